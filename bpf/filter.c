@@ -10,20 +10,24 @@ typedef unsigned long long __u64;
 
 // BPF helper return types
 #define BPF_MAP_TYPE_HASH 1
+#define BPF_MAP_TYPE_PERF_EVENT_ARRAY 4
+#define BPF_MAP_TYPE_PERCPU_ARRAY 6
+#define BPF_F_CURRENT_CPU 0xffffffffULL
 
 // BPF constants
-#define ETH_P_IP 0x0800
 #define IPPROTO_TCP 6
 #define IPPROTO_UDP 17
 
 // BPF helper function declarations
 static void *(*bpf_map_lookup_elem)(void *map, void *key) = (void *)1;
 static long (*bpf_map_update_elem)(void *map, void *key, void *value, unsigned long flags) = (void *)2;
+static long (*bpf_perf_event_output)(void *ctx, void *map, __u64 flags, void *data, __u64 size) = (void *)25;
 static long (*bpf_skb_load_bytes)(const void *skb, __u32 offset, void *to, __u32 len) = (void *)26;
 
 // Byte order conversion helpers (inline, not actual BPF helpers)
 #define bpf_htons(x) __builtin_bswap16(x)
 #define bpf_ntohs(x) __builtin_bswap16(x)
+#define bpf_ntohl(x) __builtin_bswap32(x)
 
 // Compiler directives
 #define __always_inline inline __attribute__((always_inline))
@@ -34,14 +38,6 @@ static long (*bpf_skb_load_bytes)(const void *skb, __u32 offset, void *to, __u32
 #define __uint(name, val) int (*name)[val]
 #define __type(name, val) typeof(val) *name
 
-// Ethernet header
-struct ethhdr
-{
-    unsigned char h_dest[6];
-    unsigned char h_source[6];
-    unsigned short h_proto;
-};
-
 // IP header (simplified for BPF)
 struct iphdr
 {
@@ -86,15 +82,23 @@ struct __sk_buff
     __u32 data_end;
 };
 
-// Policy key structure (must match Go struct)
+// Policy key structure (must match Go struct). Ingress entries hold the
+// source address in dest_ip and the local port in dest_port.
 struct policy_key
 {
     __u32 dest_ip;
     __u16 dest_port;
     __u8 protocol;
-    __u8 _padding;
+    __u8 direction;
 };
 
+// Policy key directions (must match the Go constants)
+#define DIR_EGRESS 0
+#define DIR_INGRESS 1
+// The all-zero key with this direction is present while some policy has
+// ingress rules; without it inbound traffic is not filtered
+#define DIR_INGRESS_RESTRICTED 2
+
 // Policy value structure (must match Go struct)
 struct policy_value
 {
@@ -112,95 +116,201 @@ struct
     __type(value, struct policy_value);
 } policy_map SEC(".maps");
 
-// Helper to parse IPv4 packet
-static __always_inline int parse_ipv4(struct __sk_buff *skb, __u32 *dest_ip,
-                                      __u8 *protocol, __u16 *dest_port)
+// Data-plane errors, counted per CPU in filter_errors (indexes must match
+// filterErrorKinds in pkg/enforcer/ebpf_errors.go)
+#define ERR_NOT_IPV4 0      // Not IPv4, passed unfiltered
+#define ERR_MALFORMED 1     // IPv4 header could not be read
+#define ERR_TRUNCATED 2     // TCP/UDP header could not be read
+#define ERR_POLICY_MISS 3   // No policy_map entry, default decision applied
+#define ERR_EVENT_DROPPED 4 // filter_events was full
+#define ERR_MAX 5
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, ERR_MAX);
+    __type(key, __u32);
+    __type(value, __u64);
+} filter_errors SEC(".maps");
+
+// Event for a malformed or truncated packet (must match Go struct)
+struct filter_event
+{
+    __u32 kind;
+    __u32 dest_ip;
+    __u16 dest_port;
+    __u8 protocol;
+    __u8 _padding;
+};
+
+// Events for malformed and truncated packets, read through perf buffers
+struct
+{
+    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+    __uint(key_size, sizeof(__u32));
+    __uint(value_size, sizeof(__u32));
+} filter_events SEC(".maps");
+
+static __always_inline void count_error(__u32 kind)
+{
+    __u64 *count = bpf_map_lookup_elem(&filter_errors, &kind);
+    if (count)
+        *count += 1; // Per-CPU value, no atomics needed
+}
+
+// Count an error and report the packet it happened on
+static __always_inline void report_error(struct __sk_buff *skb, __u32 kind,
+                                         __u32 dest_ip, __u8 protocol)
+{
+    struct filter_event event = {
+        .kind = kind,
+        .dest_ip = dest_ip,
+        .protocol = protocol,
+    };
+
+    count_error(kind);
+    if (bpf_perf_event_output(skb, &filter_events, BPF_F_CURRENT_CPU, &event, sizeof(event)) < 0)
+        count_error(ERR_EVENT_DROPPED);
+}
+
+// Addresses and ports of a parsed packet, in host byte order
+struct packet
+{
+    __u32 src_ip;
+    __u32 dest_ip;
+    __u16 src_port;
+    __u16 dest_port;
+    __u8 protocol;
+};
+
+// Helper to parse IPv4 packet. cgroup_skb programs see the packet from the
+// network header on, so there is no Ethernet header to skip. Packets that
+// cannot be parsed are counted in filter_errors.
+static __always_inline int parse_ipv4(struct __sk_buff *skb, struct packet *pkt)
 {
-    struct ethhdr eth;
     struct iphdr ip;
 
-    // Load ethernet header
-    if (bpf_skb_load_bytes(skb, 0, &eth, sizeof(eth)) < 0)
+    // Load IP header
+    if (bpf_skb_load_bytes(skb, 0, &ip, sizeof(ip)) < 0)
+    {
+        report_error(skb, ERR_MALFORMED, 0, 0);
         return -1;
+    }
 
     // Check if IPv4
-    if (eth.h_proto != bpf_htons(ETH_P_IP))
-        return -1;
-
-    // Load IP header
-    if (bpf_skb_load_bytes(skb, sizeof(eth), &ip, sizeof(ip)) < 0)
+    if ((ip.version_ihl >> 4) != 4)
+    {
+        count_error(ERR_NOT_IPV4);
         return -1;
+    }
 
-    *dest_ip = ip.daddr;
-    *protocol = ip.protocol;
+    // The Go side stores addresses in host byte order
+    pkt->src_ip = bpf_ntohl(ip.saddr);
+    pkt->dest_ip = bpf_ntohl(ip.daddr);
+    pkt->protocol = ip.protocol;
+    pkt->src_port = 0;
+    pkt->dest_port = 0;
 
     // Calculate IP header length (IHL is in 32-bit words)
     __u8 ihl = (ip.version_ihl & 0x0F) * 4;
     if (ihl < sizeof(struct iphdr))
         ihl = sizeof(struct iphdr);
 
-    // Parse port based on protocol
+    // Parse ports based on protocol
     if (ip.protocol == IPPROTO_TCP)
     {
         struct tcphdr tcp;
-        if (bpf_skb_load_bytes(skb, sizeof(eth) + ihl, &tcp, sizeof(tcp)) < 0)
+        if (bpf_skb_load_bytes(skb, ihl, &tcp, sizeof(tcp)) < 0)
+        {
+            report_error(skb, ERR_TRUNCATED, pkt->dest_ip, pkt->protocol);
             return -1;
-        *dest_port = bpf_ntohs(tcp.dest);
+        }
+        pkt->src_port = bpf_ntohs(tcp.source);
+        pkt->dest_port = bpf_ntohs(tcp.dest);
     }
     else if (ip.protocol == IPPROTO_UDP)
     {
         struct udphdr udp;
-        if (bpf_skb_load_bytes(skb, sizeof(eth) + ihl, &udp, sizeof(udp)) < 0)
+        if (bpf_skb_load_bytes(skb, ihl, &udp, sizeof(udp)) < 0)
+        {
+            report_error(skb, ERR_TRUNCATED, pkt->dest_ip, pkt->protocol);
             return -1;
-        *dest_port = bpf_ntohs(udp.dest);
-    }
-    else
-    {
-        *dest_port = 0;
+        }
+        pkt->src_port = bpf_ntohs(udp.source);
+        pkt->dest_port = bpf_ntohs(udp.dest);
     }
 
     return 0;
 }
 
+// Look up the action for a key: 1 allow, 0 block, -1 no entry
+static __always_inline int lookup_action(__u32 ip, __u16 port, __u8 protocol, __u8 direction)
+{
+    struct policy_key key = {
+        .dest_ip = ip,
+        .dest_port = port,
+        .protocol = protocol,
+        .direction = direction,
+    };
+
+    struct policy_value *value = bpf_map_lookup_elem(&policy_map, &key);
+    if (!value)
+        return -1;
+    return value->action == 1 ? 1 : 0;
+}
+
 // Main eBPF program for egress filtering
 SEC("cgroup_skb/egress")
 int filter_egress(struct __sk_buff *skb)
 {
-    __u32 dest_ip;
-    __u8 protocol;
-    __u16 dest_port;
+    struct packet pkt;
 
     // Parse packet
-    if (parse_ipv4(skb, &dest_ip, &protocol, &dest_port) < 0)
+    if (parse_ipv4(skb, &pkt) < 0)
     {
         // If not IPv4 or parse error, allow by default
         return 1;
     }
 
     // Lookup policy in map
-    struct policy_key key = {
-        .dest_ip = dest_ip,
-        .dest_port = dest_port,
-        .protocol = protocol,
-    };
+    int action = lookup_action(pkt.dest_ip, pkt.dest_port, pkt.protocol, DIR_EGRESS);
+    if (action >= 0)
+        return action;
 
-    struct policy_value *value = bpf_map_lookup_elem(&policy_map, &key);
-    if (value)
+    // Replies to inbound connections an ingress rule allows
+    if (lookup_action(pkt.dest_ip, pkt.src_port, pkt.protocol, DIR_INGRESS) == 1)
+        return 1;
+
+    // Default deny: if no policy matches, block
+    count_error(ERR_POLICY_MISS);
+    return 0;
+}
+
+// eBPF program for ingress filtering. Inbound traffic is only filtered while
+// some policy has ingress rules; then it must match one (by source address
+// and local port) or be a reply from a destination an egress rule allows.
+SEC("cgroup_skb/ingress")
+int filter_ingress(struct __sk_buff *skb)
+{
+    struct packet pkt;
+
+    if (parse_ipv4(skb, &pkt) < 0)
     {
-        // Found matching policy
-        if (value->action == 1)
-        {
-            // ALLOW
-            return 1;
-        }
-        else
-        {
-            // BLOCK
-            return 0;
-        }
+        return 1;
     }
 
-    // Default deny: if no policy matches, block
+    int action = lookup_action(pkt.src_ip, pkt.dest_port, pkt.protocol, DIR_INGRESS);
+    if (action >= 0)
+        return action;
+
+    // Replies to outbound connections an egress rule allows
+    if (lookup_action(pkt.src_ip, pkt.src_port, pkt.protocol, DIR_EGRESS) == 1)
+        return 1;
+
+    if (lookup_action(0, 0, 0, DIR_INGRESS_RESTRICTED) < 0)
+        return 1;
+
+    count_error(ERR_POLICY_MISS);
     return 0;
 }
 
@@ -208,23 +318,17 @@ int filter_egress(struct __sk_buff *skb)
 SEC("cgroup_skb/egress_permissive")
 int filter_egress_permissive(struct __sk_buff *skb)
 {
-    __u32 dest_ip;
-    __u8 protocol;
-    __u16 dest_port;
+    struct packet pkt;
 
-    if (parse_ipv4(skb, &dest_ip, &protocol, &dest_port) < 0)
+    if (parse_ipv4(skb, &pkt) < 0)
     {
         return 1;
     }
 
-    struct policy_key key = {
-        .dest_ip = dest_ip,
-        .dest_port = dest_port,
-        .protocol = protocol,
-    };
-
-    struct policy_value *value = bpf_map_lookup_elem(&policy_map, &key);
-    if (value && value->action == 0)
+    int action = lookup_action(pkt.dest_ip, pkt.dest_port, pkt.protocol, DIR_EGRESS);
+    if (action < 0)
+        count_error(ERR_POLICY_MISS);
+    if (action == 0)
     {
         // Explicitly blocked
         return 0;
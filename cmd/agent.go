@@ -0,0 +1,590 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"ztap/pkg/agent"
+	"ztap/pkg/anomaly"
+	"ztap/pkg/auth"
+	"ztap/pkg/cloud"
+	"ztap/pkg/cluster"
+	"ztap/pkg/discovery"
+	"ztap/pkg/enforcer"
+	"ztap/pkg/events"
+	"ztap/pkg/flowqueue"
+	"ztap/pkg/metrics"
+	"ztap/pkg/retention"
+	"ztap/pkg/stats"
+	"ztap/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cobra"
+)
+
+var agentCmd = &cobra.Command{
+	Use:     "agent -f policy.yaml",
+	Aliases: []string{"daemon"},
+	Short:   "Run the node agent",
+	Long: `Run the long-lived node agent. Every interval the agent reloads the
+policy file, re-resolves label selectors through service discovery (and the
+AWS inventory with --aws-region or --aws-accounts, refreshed every
+--aws-inventory-interval) and re-enforces only if the compiled rules changed.
+Unchanged policies are served from the compile cache (see
+ztap_policy_cache_hits_total).
+
+Between cycles the agent watches service discovery: endpoint changes are
+batched until they have been quiet for --debounce-quiet (at most
+--debounce-max after the first change), then only the affected policies are
+recompiled and re-enforced.
+
+If a policy's resolved endpoints shrink by more than --max-endpoint-shrink in
+one step (e.g. a discovery glitch returning a single host), its previous rules
+stay enforced and an endpoint_shrink_held event is published. The shrink is
+accepted after --shrink-grace, or immediately with
+'ztap cluster config set confirm-shrink <policy>'.
+
+Policies with a spec.nodeSelector are only enforced on nodes whose
+--node-labels it matches (use the labels given to 'ztap cluster join'), so
+each node holds just the rules that concern it.
+
+While the node (--node, the hostname by default) is cordoned ('ztap cluster
+cordon') the agent enforces no policies new to it. While it is drained
+('ztap cluster drain') it removes every rule (--mode monitor) or keeps its
+last enforced rules (--mode keep) until it is uncordoned.
+
+Temporary policies (metadata.expiresAt, or metadata.ttl counted from when this
+agent first enforced them) are removed as soon as they expire, with a
+policy_expired event. Blocked flows an expired policy would still have allowed
+are logged and published as policy_expired events with hit set. A ttl restarts
+with the agent; use expiresAt for a hard deadline.
+
+During a maintenance window ('ztap maintenance start') the policies selecting
+workloads in its scope keep their enforced rules, and flows from those
+workloads are recorded but raise no flow_blocked, policy_expired or anomaly
+events. Agents publish maintenance events when a window starts and ends.
+
+With --ebpf-stats the kernel accounts the eBPF filter program's run time,
+exported as ztap_ebpf_program_run_time_seconds and
+ztap_ebpf_program_run_count alongside ztap_policy_apply_duration_seconds.
+On Linux the filter program's error counters (packets that are not IPv4,
+malformed or truncated, policy map misses and dropped error events) are
+exported as ztap_ebpf_filter_errors, new errors are logged every 15s, and
+each malformed or truncated packet is logged with its destination.
+
+Where node-local metrics cannot be scraped, --push-url pushes them every
+--push-interval instead, as Prometheus remote_write or OTLP/HTTP
+(--push-format otlp). Failed pushes are queued and retried.
+
+The agent also follows the cluster configuration ('ztap cluster config'),
+applying changes such as log-level live, and the enforcement log: every new
+flow is scored by the anomaly detector, and blocked flows and anomalies are
+published as events. A flood of flows cannot exhaust the agent's memory: they
+wait for the detector in a bounded queue (flow_queue in config.yaml), flows
+arriving while it is full are dropped, and while it is overloaded only 1 in
+sample_rate identical verdicts is scored and published. Every flow is still
+counted in statistics; ztap_flow_events_dropped_total and
+ztap_flow_events_sampled_total count the rest. The scopes in the anomaly section of config.yaml set the
+threshold and detectors for flows to or from workloads with given labels.
+
+Pre-compile, enforcer and post-apply hooks from the hooks section of
+config.yaml run on every cycle that compiles or enforces (see 'ztap enforce').
+
+After each cycle that enforces the policy file in full, the agent records it
+with the Git commit it was read at, if it changed; see 'ztap policy diff'.
+
+The retention section of config.yaml limits the enforcement log, event journal
+and stats aggregates by age and size. The agent prunes them every interval
+(1h by default), exporting ztap_retention_pruned_records_total and
+ztap_retention_reclaimed_bytes_total.
+
+At startup the agent checks its dependencies in order: the discovery cache
+and cloud inventory, the --controller and the --anomaly-endpoint. The startup
+section of config.yaml makes each fail open (start without it) or fail closed
+(wait for it before enforcing the policy file, enforcing the last-known-good
+rule set meanwhile).
+
+To rehearse a dependency outage in staging, --simulate-failure discovery,
+controller or detector (repeatable) makes the dependency fail every check
+and call for the agent's lifetime: discovery lookups and AWS calls, the
+--controller health check, and remote anomaly detection services. The
+configured fail mode then applies as in a real outage.
+
+After each cycle that enforces the policy file in full, the compiled rules are
+saved as the last-known-good rule set (~/.ztap/last-known-good.json). On
+restart the agent enforces them again, and policies that cannot be compiled
+(e.g. while discovery is unreachable) keep their last-known-good rules. If
+policies cannot be reconciled for longer than --stale-after, the agent logs a
+warning, publishes a policy_stale event and sets ztap_policy_stale; see also
+ztap_policy_last_good_timestamp_seconds and 'ztap status'.
+
+Enforcement is attributed to the user of the 'ztap user login' session the
+agent was started with (or the local account): policy_applied events carry the
+principal, and flow_blocked events the principal that last changed the
+flow's policy.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policyFile, _ := cmd.Flags().GetString("file")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		metricsPort, _ := cmd.Flags().GetInt("metrics-port")
+		anomalyEndpoint, _ := cmd.Flags().GetString("anomaly-endpoint")
+		anomalyFallback, _ := cmd.Flags().GetString("anomaly-fallback")
+		ebpfStats, _ := cmd.Flags().GetBool("ebpf-stats")
+		debounce := agent.Debounce{}
+		debounce.Quiet, _ = cmd.Flags().GetDuration("debounce-quiet")
+		debounce.MaxDelay, _ = cmd.Flags().GetDuration("debounce-max")
+		guard := agent.ShrinkGuard{}
+		guard.MaxShrink, _ = cmd.Flags().GetFloat64("max-endpoint-shrink")
+		guard.Grace, _ = cmd.Flags().GetDuration("shrink-grace")
+		staleAfter, _ := cmd.Flags().GetDuration("stale-after")
+		simulated, _ := cmd.Flags().GetStringSlice("simulate-failure")
+
+		if interval <= 0 {
+			failf(exitValidation, "--interval must be positive")
+		}
+		if debounce.Quiet <= 0 || debounce.MaxDelay <= 0 {
+			failf(exitValidation, "--debounce-quiet and --debounce-max must be positive")
+		}
+		if guard.MaxShrink < 0 || guard.MaxShrink >= 1 || guard.Grace < 0 {
+			failf(exitValidation, "--max-endpoint-shrink must be in [0, 1) and --shrink-grace must not be negative")
+		}
+		if err := simulateFailures(simulated); err != nil {
+			fail(err)
+		}
+
+		if metricsPort > 0 {
+			go func() {
+				if err := metrics.StartServer(metricsPort); err != nil {
+					fmt.Printf("Warning: Failed to start metrics server: %v\n", err)
+				}
+			}()
+		}
+
+		pusher, err := newMetricsPusher(cmd)
+		if err != nil {
+			fail(err)
+		}
+
+		store, err := getClusterConfigStore()
+		if err != nil {
+			fail(err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		ctx = auth.WithPrincipal(ctx, currentPrincipal())
+
+		// Dependencies are waited for before discovery is first used, so a
+		// discovery cache that is still starting is not disabled
+		startup, err := agent.LoadStartupConfig(configPath())
+		if err != nil {
+			fail(err)
+		}
+		inventory, err := newInventory(cmd)
+		if err != nil {
+			fail(err)
+		}
+		lastKnownGood := loadLastKnownGood()
+		if err := agent.WaitForDependencies(ctx, startupDependencies(cmd, inventory), startup, enforceLastKnownGood(lastKnownGood)); err != nil {
+			fail(err)
+		}
+		resolver := resolverFor(inventory)
+		if pusher != nil {
+			go pusher.Run(ctx)
+		}
+		if ebpfStats {
+			stats, err := enforcer.EnableProgramStats()
+			if err != nil {
+				fail(err)
+			}
+			defer stats.Close()
+			go sampleProgramStats(ctx, ebpfStatsInterval)
+		}
+		if enforcer.IsLinux() {
+			go watchFilterErrors(ctx, ebpfStatsInterval)
+		}
+		if inventory != nil {
+			inventoryInterval, _ := cmd.Flags().GetDuration("aws-inventory-interval")
+			go inventory.Run(ctx, inventoryInterval)
+		}
+
+		a, err := newAgent(resolver, concurrency)
+		if err != nil {
+			fail(err)
+		}
+		a.SetShrinkGuard(guard)
+		a.SetStaleAfter(staleAfter)
+		if lastKnownGood != nil {
+			a.Restore(lastKnownGood.Policies, lastKnownGood.SavedAt)
+		}
+		setSelfProtection(cmd, a)
+		nodeLabels, _ := cmd.Flags().GetStringToString("node-labels")
+		a.SetNodeLabels(nodeLabels)
+		nodeID, _ := cmd.Flags().GetString("node")
+		if nodeID == "" {
+			nodeID, _ = os.Hostname()
+		}
+		a.SetNodeID(nodeID)
+		go cluster.ApplyConfig(ctx, store, a.ApplyConfig)
+		go reloadClusterConfig(ctx, store, interval)
+
+		detector, err := anomaly.NewDetector(anomalyEndpoint, anomalyFallback)
+		if err != nil {
+			fail(err)
+		}
+		if detector, err = scopeDetector(detector, anomalyEndpoint, inventory); err != nil {
+			fail(err)
+		}
+		go func() {
+			if err := statsRecorder.Run(ctx, statsFlushInterval); err != nil {
+				fmt.Printf("Warning: Failed to save statistics: %v\n", err)
+			}
+		}()
+
+		retentionConfig, err := loadSection(retention.LoadConfig)
+		if err != nil {
+			fail(err)
+		}
+		queueConfig, err := loadSection(flowqueue.LoadConfig)
+		if err != nil {
+			fail(err)
+		}
+		telemetryConfig, err := loadSection(telemetry.LoadConfig)
+		if err != nil {
+			fail(err)
+		}
+		go newTelemetryReporter(telemetryConfig).Run(ctx)
+		if retentionConfig.Enabled() {
+			go retention.NewJanitor(retentionConfig, retention.Paths{
+				EnforcementLog: getLogFilePath(),
+				EventJournal:   getEventJournalPath(),
+				Stats:          getStatsFilePath(),
+			}).Run(ctx)
+		}
+
+		// Services registered by other processes reach the agent through the
+		// journal, on a private bus so their events are not handled twice
+		attributor := newAttributor(inventory)
+		registrations := events.NewBus()
+		attributor.Record(registrations)
+		go eventJournal.Follow(ctx, registrations, time.Second)
+
+		flows := flowqueue.New(queueConfig, flowVerdict, flowHandler(anomaly.WithEvents(anomaly.WithFeedback(detector, getFeedbackStore()), events.Default()), events.Default(), a.Principal, a.CheckExpiredHit, maintenanceQuiet(a, inventory), attributor.Name))
+		go flows.Run(ctx)
+		logFile := getLogFilePath()
+		go followLog(ctx, logFile, logEndOffset(logFile), time.Second, func(entry LogEntry) {
+			recordFlow(statsRecorder, entry)
+			flows.Offer(entry)
+		})
+
+		recorder := &appliedRecorder{file: policyFile, principal: currentPrincipal()}
+		a.OnReconciled(func() {
+			recorder.record()
+			saveLastKnownGood(a, nodeID)
+		})
+		load := recorder.load
+		go a.WatchEndpoints(ctx, load, getDiscoveryBackend().Watch, interval, debounce)
+
+		fmt.Printf("Agent enforcing %s every %s (Ctrl+C to stop)\n", policyFile, interval)
+		a.Run(ctx, load, interval)
+	},
+}
+
+// ebpfStatsInterval is how often eBPF program statistics are sampled
+const ebpfStatsInterval = 15 * time.Second
+
+// sampleProgramStats exports eBPF program statistics every interval until
+// ctx is cancelled. A failure is logged once until it changes, since the
+// filter program may not be loaded yet.
+func sampleProgramStats(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last string
+	for {
+		err := enforcer.SampleProgramStats()
+		if err != nil && err.Error() != last {
+			log.Printf("Warning: %v", err)
+		}
+		last = ""
+		if err != nil {
+			last = err.Error()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// maxFilterEventLogs is how many eBPF filter error events are logged per
+// sampling interval; the counters account for the rest
+const maxFilterEventLogs = 10
+
+// watchFilterErrors exports the eBPF filter program's error counters every
+// interval and logs the errors counted since the previous sample, and each
+// malformed or truncated packet, until ctx is cancelled. Policy map misses
+// are only exported, as default deny makes them common. A failure is logged
+// once until it changes, since the filter program may not be loaded yet.
+func watchFilterErrors(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var reader *enforcer.FilterErrorReader
+	var previous enforcer.FilterErrors
+	var logged atomic.Int32
+	var last string
+	for {
+		var err error
+		if reader == nil {
+			if reader, err = enforcer.OpenFilterErrors(); err == nil {
+				defer reader.Close()
+				go func() {
+					err := reader.ReadEvents(ctx, func(event enforcer.FilterEvent) {
+						if logged.Add(1) <= maxFilterEventLogs {
+							log.Printf("Warning: eBPF filter: %s", event)
+						}
+					})
+					if err != nil {
+						log.Printf("Warning: %v", err)
+					}
+				}()
+			}
+		}
+		if reader != nil {
+			var counts enforcer.FilterErrors
+			if counts, err = reader.Counts(); err == nil {
+				delta := counts.Since(previous)
+				delete(delta, enforcer.FilterErrorPolicyMiss)
+				if previous != nil && len(delta) > 0 {
+					log.Printf("Warning: eBPF filter errors in the last %s: %s", interval, delta)
+				}
+				previous = counts
+			}
+		}
+		logged.Store(0)
+
+		if err != nil && err.Error() != last {
+			log.Printf("Warning: %v", err)
+		}
+		last = ""
+		if err != nil {
+			last = err.Error()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// newMetricsPusher builds the metrics pusher from the --push-* flags, or
+// returns nil when --push-url is not set
+func newMetricsPusher(cmd *cobra.Command) (*metrics.Pusher, error) {
+	config := metrics.PushConfig{}
+	config.URL, _ = cmd.Flags().GetString("push-url")
+	if config.URL == "" {
+		return nil, nil
+	}
+	config.Format, _ = cmd.Flags().GetString("push-format")
+	config.Interval, _ = cmd.Flags().GetDuration("push-interval")
+
+	headers, _ := cmd.Flags().GetStringArray("push-header")
+	labels, _ := cmd.Flags().GetStringArray("push-label")
+	var err error
+	if config.Headers, err = parsePairs("--push-header", headers); err != nil {
+		return nil, err
+	}
+	if config.Labels, err = parsePairs("--push-label", labels); err != nil {
+		return nil, err
+	}
+	return metrics.NewPusher(config, prometheus.DefaultGatherer)
+}
+
+// parsePairs parses repeated Name=Value flag values
+func parsePairs(flag string, values []string) (map[string]string, error) {
+	pairs := make(map[string]string, len(values))
+	for _, v := range values {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%s must be Name=Value, got %q", flag, v)
+		}
+		pairs[strings.TrimSpace(name)] = value
+	}
+	return pairs, nil
+}
+
+// recordFlow records the flows of an entry in recorder, allowed flows also
+// as rule hits for 'ztap policy prune'. Every flow is recorded, even those
+// the flow queue drops.
+func recordFlow(recorder *stats.Recorder, entry LogEntry) {
+	allowed := entry.Action == "ALLOWED"
+	recorder.RecordFlows(entry.PolicyName, allowed, entry.DestIP, entry.Port, entry.Flows())
+	if allowed && entry.PolicyName != "" {
+		recorder.RecordRuleHits(entry.PolicyName, entry.Protocol, entry.DestIP, entry.Port, entry.Flows())
+	}
+}
+
+// flowVerdict identifies flows that are identical for overload sampling
+func flowVerdict(entry LogEntry) string {
+	return strings.Join([]string{entry.Action, entry.PolicyName, entry.SourceIP, entry.DestIP, strconv.Itoa(entry.Port), entry.Protocol}, "|")
+}
+
+// flowHandler publishes blocked flows to bus attributed to the principal
+// principalOf reports for their policy, reports blocked flows an expired
+// temporary policy would have allowed to expiredHit, and scores every flow
+// with detector, which publishes anomalies. Flows from sources quiet reports
+// as under maintenance are skipped. Published flows carry the services
+// serviceOf reports held their IPs at the time. Detection failures are logged
+// when they change, not for every flow.
+func flowHandler(detector anomaly.Detector, bus *events.Bus, principalOf func(policy string) string, expiredHit func(sourceIP, destIP string, port int, protocol string) string, quiet func(sourceIP string) bool, serviceOf func(ip string, at time.Time) string) func(LogEntry) {
+	var mu sync.Mutex
+	var lastErr string
+	return func(entry LogEntry) {
+		allowed := entry.Action == "ALLOWED"
+		if quiet(entry.SourceIP) {
+			return
+		}
+
+		sourceService := serviceOf(entry.SourceIP, entry.Timestamp)
+		destService := serviceOf(entry.DestIP, entry.Timestamp)
+		if !allowed {
+			bus.Publish(events.TopicFlowBlocked, events.FlowBlocked{
+				Policy:        entry.PolicyName,
+				Principal:     principalOf(entry.PolicyName),
+				SourceIP:      entry.SourceIP,
+				SourceService: sourceService,
+				DestIP:        entry.DestIP,
+				DestService:   destService,
+				Port:          entry.Port,
+				Protocol:      entry.Protocol,
+			})
+			expiredHit(entry.SourceIP, entry.DestIP, entry.Port, entry.Protocol)
+		}
+
+		_, err := detector.Detect(anomaly.FlowRecord{
+			SourceIP:      entry.SourceIP,
+			DestIP:        entry.DestIP,
+			Port:          entry.Port,
+			Protocol:      entry.Protocol,
+			Timestamp:     entry.Timestamp,
+			SourceService: sourceService,
+			DestService:   destService,
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			lastErr = ""
+		} else if err.Error() != lastErr {
+			lastErr = err.Error()
+			log.Printf("Warning: Anomaly detection failed: %v", err)
+		}
+	}
+}
+
+// maintenanceQuiet reports whether an open maintenance window covers the
+// workload with a source IP
+func maintenanceQuiet(a *agent.Agent, inventory *cloud.Inventory) func(sourceIP string) bool {
+	labels := workloadLabels(inventory)
+	return func(sourceIP string) bool {
+		return a.Quiet(func() map[string]string { return labels(sourceIP) })
+	}
+}
+
+// workloadLabels returns the labels of the workload with an IP, identified
+// by registered services and, if given, cloud inventory
+func workloadLabels(inventory *cloud.Inventory) func(ip string) map[string]string {
+	return func(ip string) map[string]string {
+		if mem, ok := getDiscoveryBackend().(*discovery.InMemoryDiscovery); ok {
+			for _, s := range mem.ListServices() {
+				if s.IP == ip {
+					return s.Labels
+				}
+			}
+		}
+		if inventory != nil {
+			for _, r := range inventory.Resources() {
+				if r.PrivateIP == ip {
+					return r.Labels
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// scopeDetector applies the anomaly scopes of config.yaml to detector,
+// the ML detector if endpoint is set. Scopes may also enable the rule-based
+// detector.
+func scopeDetector(detector anomaly.Detector, endpoint string, inventory *cloud.Inventory) (anomaly.Detector, error) {
+	config, err := loadSection(anomaly.LoadConfig)
+	if err != nil || len(config.Scopes) == 0 {
+		return detector, err
+	}
+	named := map[string]anomaly.Detector{anomaly.DetectorRules: detector}
+	if endpoint != "" {
+		named[anomaly.DetectorRules] = anomaly.NewSimpleDetector()
+		named[anomaly.DetectorML] = detector
+	}
+	return anomaly.WithScopes(detector, named, config.Scopes, workloadLabels(inventory))
+}
+
+// reloadClusterConfig picks up changes made to the local config store by
+// other processes every interval until ctx is cancelled
+func reloadClusterConfig(ctx context.Context, store *cluster.LocalConfigStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := store.Reload(); err != nil {
+				log.Printf("Warning: Failed to reload cluster config: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func init() {
+	agentCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
+	agentCmd.Flags().Duration("interval", 30*time.Second, "Reconcile interval")
+	agentCmd.Flags().Int("concurrency", 4, "Number of policies compiled in parallel; the backend then applies the compiled set at once")
+	agentCmd.Flags().Duration("debounce-quiet", agent.DefaultDebounce.Quiet, "Re-enforce once endpoints have been stable this long after a discovery change")
+	agentCmd.Flags().Duration("debounce-max", agent.DefaultDebounce.MaxDelay, "Re-enforce at most this long after the first pending discovery change, even if endpoints keep changing")
+	agentCmd.Flags().Float64("max-endpoint-shrink", agent.DefaultShrinkGuard.MaxShrink, "Keep a policy's previous rules when its resolved endpoints shrink by more than this fraction in one step (0 disables)")
+	agentCmd.Flags().Duration("shrink-grace", agent.DefaultShrinkGuard.Grace, "Accept a held endpoint shrink once it persists this long (0 waits for 'ztap cluster config set confirm-shrink <policy>')")
+	agentCmd.Flags().Duration("stale-after", agent.DefaultStaleAfter, "Publish a policy_stale event when policies could not be reconciled for this long (0 disables)")
+	agentCmd.Flags().String("node", "", "ID of this node, followed by 'ztap cluster cordon' and 'ztap cluster drain' (default: hostname)")
+	agentCmd.Flags().StringToString("node-labels", nil, "Labels of this node; policies whose spec.nodeSelector does not match them are not enforced")
+	agentCmd.Flags().String("controller", "", "URL of the API server managing this node, kept reachable by self-protection, e.g. https://controller:8443")
+	addSelfProtectionFlags(agentCmd)
+	agentCmd.Flags().Int("metrics-port", 0, "Serve Prometheus metrics on this port (0 disables)")
+	agentCmd.Flags().Bool("ebpf-stats", false, "Export eBPF program run time and run count (Linux 5.8+, adds a small per-packet cost)")
+	agentCmd.Flags().String("push-url", "", "Push metrics to this remote_write or OTLP/HTTP endpoint, e.g. http://prometheus:9090/api/v1/write")
+	agentCmd.Flags().String("push-format", metrics.PushRemoteWrite, "Metrics push format: remote_write or otlp")
+	agentCmd.Flags().Duration("push-interval", 15*time.Second, "How often metrics are pushed")
+	agentCmd.Flags().StringArray("push-header", nil, "Header sent with every push as Name=Value, e.g. 'Authorization=Bearer <token>' (repeatable)")
+	agentCmd.Flags().StringArray("push-label", nil, "Label added to every pushed series as name=value, e.g. instance=node-1 (repeatable)")
+	agentCmd.Flags().String("aws-region", "", "Also resolve label selectors against AWS resources in this region")
+	agentCmd.Flags().String("aws-accounts", "", "Also resolve label selectors against AWS resources in the accounts and regions of this YAML file")
+	agentCmd.Flags().Duration("aws-inventory-interval", 5*time.Minute, "How often the AWS inventory is rediscovered")
+	agentCmd.Flags().String("anomaly-endpoint", "", "Anomaly detection service URL, e.g. http://localhost:5000 or grpc://localhost:50051 (default: rule-based detector)")
+	agentCmd.Flags().String("anomaly-fallback", "", "HTTP detection service used while the gRPC --anomaly-endpoint is unavailable")
+	agentCmd.Flags().StringSlice("simulate-failure", nil, "Make a dependency fail to rehearse its fail mode: discovery, controller or detector (staging only)")
+	rootCmd.AddCommand(agentCmd)
+}
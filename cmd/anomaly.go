@@ -0,0 +1,388 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"ztap/pkg/anomaly"
+	"ztap/pkg/auth"
+	"ztap/pkg/events"
+	"ztap/pkg/stats"
+
+	"github.com/spf13/cobra"
+)
+
+var anomalyCmd = &cobra.Command{
+	Use:   "anomaly",
+	Short: "Review anomaly alerts and manage the ML detector",
+}
+
+var anomalyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List recent anomaly alerts and their labels",
+	Run: func(cmd *cobra.Command, args []string) {
+		since, _ := cmd.Flags().GetString("since")
+		period, err := stats.ParseSince(since)
+		if err != nil {
+			fail(err)
+		}
+
+		alerts, err := anomalyAlerts(time.Now().Add(-period))
+		if err != nil {
+			fail(err)
+		}
+		if len(alerts) == 0 {
+			fmt.Printf("No anomalies in the last %s\n", since)
+			return
+		}
+		labels, err := getFeedbackStore().List()
+		if err != nil {
+			fail(err)
+		}
+		labelOf := make(map[string]string)
+		for _, f := range labels {
+			labelOf[f.ID] = "anomaly"
+			if f.Benign {
+				labelOf[f.ID] = "benign"
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tLAST SEEN\tCOUNT\tFLOW\tSCORE\tSCOPE\tLABEL\tREASON")
+		for _, a := range alerts {
+			label := labelOf[a.ID]
+			if label == "" {
+				label = "-"
+			}
+			scope := a.Scope
+			if scope == "" {
+				scope = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s -> %s:%d/%s\t%.0f\t%s\t%s\t%s\n", a.ID, a.LastSeen.Local().Format("2006-01-02 15:04"),
+				a.Count, formatEndpoint(a.SourceIP, a.SourceService), formatEndpoint(a.DestIP, a.DestService), a.Port, a.Protocol,
+				a.Score, scope, label, a.Reason)
+		}
+		w.Flush()
+	},
+}
+
+var anomalyAckCmd = &cobra.Command{
+	Use:   "ack <id> [--benign]",
+	Short: "Label an anomaly alert, e.g. as a false positive",
+	Long: `Label the alerts with an ID from 'ztap anomaly list'. With --benign the flow
+is a false positive: the agent stops alerting on it unless it scores higher
+than the labeled alert, and the flow is added to the training data of the ML
+model as normal traffic. Without --benign the anomaly is confirmed.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		benign, _ := cmd.Flags().GetBool("benign")
+
+		session, err := requireSession(auth.PermEnforce)
+		if err != nil {
+			fail(err)
+		}
+		alerts, err := anomalyAlerts(time.Time{})
+		if err != nil {
+			fail(err)
+		}
+		i := slices.IndexFunc(alerts, func(a anomalyAlert) bool { return a.ID == args[0] })
+		if i < 0 {
+			failf(exitValidation, "no anomaly %s in the event journal", args[0])
+		}
+		alert := alerts[i]
+
+		err = getFeedbackStore().Label(anomaly.Feedback{
+			ID:        alert.ID,
+			SourceIP:  alert.SourceIP,
+			DestIP:    alert.DestIP,
+			Port:      alert.Port,
+			Protocol:  alert.Protocol,
+			Score:     alert.Score,
+			Benign:    benign,
+			Principal: session.Username,
+			LabeledAt: time.Now().UTC(),
+		})
+		if err != nil {
+			fail(err)
+		}
+		if benign {
+			fmt.Printf("Labeled %s -> %s:%d/%s benign; it alerts again only above score %.0f\n",
+				alert.SourceIP, alert.DestIP, alert.Port, alert.Protocol, alert.Score)
+		} else {
+			fmt.Printf("Confirmed anomaly %s -> %s:%d/%s\n", alert.SourceIP, alert.DestIP, alert.Port, alert.Protocol)
+		}
+	},
+}
+
+var anomalyModelCmd = &cobra.Command{
+	Use:   "model",
+	Short: "Version, retrain and promote the detector's model",
+	Long: `Manage the model of the ML detection service (pkg/anomaly/service.py).
+
+New models are trained as candidates from the flow history in the
+enforcement log while the active model keeps scoring flows. 'evaluate' scores
+recent flows with both models (shadow evaluation) and compares how many each
+finds anomalous; 'promote' evaluates the candidate again and makes it active
+only if its anomaly rate is at most --max-increase above the active model's.
+
+  ztap anomaly model train --since 7d
+  ztap anomaly model evaluate --since 24h
+  ztap anomaly model promote --max-increase 0.02`,
+}
+
+var anomalyModelStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the active and candidate model versions",
+	Run: func(cmd *cobra.Command, args []string) {
+		detector, err := modelDetector(cmd)
+		if err != nil {
+			fail(err)
+		}
+		models, err := detector.Models(cmd.Context())
+		if err != nil {
+			fail(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MODEL\tVERSION\tTRAINED\tSAMPLES")
+		for _, m := range []struct {
+			role string
+			info *anomaly.ModelInfo
+		}{{"active", models.Active}, {"candidate", models.Candidate}} {
+			if m.info == nil {
+				fmt.Fprintf(w, "%s\t-\t-\t-\n", m.role)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\n", m.role, m.info.Version,
+				m.info.TrainedAt.Local().Format("2006-01-02 15:04"), m.info.Samples)
+		}
+		w.Flush()
+		if models.Active == nil {
+			fmt.Println("\nNo active model; the service scores flows with its rules")
+		}
+	},
+}
+
+var anomalyModelTrainCmd = &cobra.Command{
+	Use:   "train --since 7d",
+	Short: "Train a candidate model from the flow history",
+	Long: `Train a candidate model on the allowed flows of the enforcement log in the
+--since period and the flows labeled benign with 'ztap anomaly ack', taken as
+normal traffic. The active model keeps scoring flows
+until the candidate is promoted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		since, _ := cmd.Flags().GetString("since")
+
+		detector, err := modelDetector(cmd)
+		if err != nil {
+			fail(err)
+		}
+		flows, err := historyFlows(since, true)
+		if err != nil {
+			fail(err)
+		}
+		benign, err := getFeedbackStore().BenignFlows()
+		if err != nil {
+			fail(err)
+		}
+		flows = append(flows, benign...)
+
+		info, err := detector.TrainCandidate(cmd.Context(), flows)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("Trained candidate model version %d on %d flows\n", info.Version, info.Samples)
+		fmt.Println("Compare it with the active model with 'ztap anomaly model evaluate'")
+	},
+}
+
+var anomalyModelEvaluateCmd = &cobra.Command{
+	Use:   "evaluate --since 24h",
+	Short: "Compare the candidate model with the active one on recent flows",
+	Run: func(cmd *cobra.Command, args []string) {
+		since, _ := cmd.Flags().GetString("since")
+		maxIncrease, _ := cmd.Flags().GetFloat64("max-increase")
+
+		evaluation, err := evaluateCandidate(cmd, since)
+		if err != nil {
+			fail(err)
+		}
+		printEvaluation(evaluation, maxIncrease)
+	},
+}
+
+var anomalyModelPromoteCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Evaluate the candidate model and make it active",
+	Run: func(cmd *cobra.Command, args []string) {
+		since, _ := cmd.Flags().GetString("since")
+		maxIncrease, _ := cmd.Flags().GetFloat64("max-increase")
+		force, _ := cmd.Flags().GetBool("force")
+
+		evaluation, err := evaluateCandidate(cmd, since)
+		if err != nil {
+			fail(err)
+		}
+		printEvaluation(evaluation, maxIncrease)
+		if !evaluation.Acceptable(maxIncrease) && !force {
+			failf(exitFailure, "candidate not promoted; use --force to promote it anyway")
+		}
+
+		detector, _ := modelDetector(cmd)
+		info, err := detector.Promote(cmd.Context(), evaluation.CandidateVersion)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("Model version %d is now active\n", info.Version)
+	},
+}
+
+// anomalyAlert summarizes the alerts with one ID
+type anomalyAlert struct {
+	events.AnomalyDetected
+	Count    int
+	LastSeen time.Time
+}
+
+// anomalyAlerts returns the alerts journaled since since, most recent
+// first. Score is the highest score seen.
+func anomalyAlerts(since time.Time) ([]anomalyAlert, error) {
+	history, err := eventJournal.History(since, events.TopicAnomalyDetected)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*anomalyAlert)
+	for _, event := range history {
+		detected := event.Data.(events.AnomalyDetected)
+		if detected.ID == "" {
+			continue // Journaled before alerts had IDs
+		}
+		alert, ok := byID[detected.ID]
+		if !ok {
+			alert = &anomalyAlert{AnomalyDetected: detected}
+			byID[detected.ID] = alert
+		}
+		alert.Count++
+		alert.LastSeen = event.Timestamp
+		alert.Reason = detected.Reason
+		alert.Scope = detected.Scope
+		alert.Score = max(alert.Score, detected.Score)
+	}
+
+	alerts := make([]anomalyAlert, 0, len(byID))
+	for _, alert := range byID {
+		alerts = append(alerts, *alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].LastSeen.After(alerts[j].LastSeen) })
+	return alerts, nil
+}
+
+func getFeedbackStore() *anomaly.FeedbackStore {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return anomaly.NewFeedbackStore("/tmp/ztap-anomaly-feedback.json")
+	}
+	return anomaly.NewFeedbackStore(filepath.Join(homeDir, ".ztap", "anomaly-feedback.json"))
+}
+
+// modelDetector returns the client of the command's --endpoint. Model
+// management is part of the HTTP API only.
+func modelDetector(cmd *cobra.Command) (*anomaly.PythonDetector, error) {
+	endpoint, _ := cmd.Flags().GetString("endpoint")
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("--endpoint must be the http:// or https:// URL of the detection service")
+	}
+	return anomaly.NewPythonDetector(endpoint), nil
+}
+
+// evaluateCandidate runs a shadow evaluation on the flows of the --since period
+func evaluateCandidate(cmd *cobra.Command, since string) (anomaly.Evaluation, error) {
+	detector, err := modelDetector(cmd)
+	if err != nil {
+		return anomaly.Evaluation{}, err
+	}
+	flows, err := historyFlows(since, false)
+	if err != nil {
+		return anomaly.Evaluation{}, err
+	}
+	return detector.Evaluate(cmd.Context(), flows)
+}
+
+func printEvaluation(e anomaly.Evaluation, maxIncrease float64) {
+	active := "rules"
+	if e.ActiveVersion != nil {
+		active = fmt.Sprintf("version %d", *e.ActiveVersion)
+	}
+	fmt.Printf("Shadow evaluation on %d flows\n", e.Flows)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  MODEL\tANOMALIES\tRATE")
+	fmt.Fprintf(w, "  active (%s)\t%d\t%.2f%%\n", active, e.ActiveAnomalies, 100*e.ActiveRate())
+	fmt.Fprintf(w, "  candidate (version %d)\t%d\t%.2f%%\n", e.CandidateVersion, e.CandidateAnomalies, 100*e.CandidateRate())
+	w.Flush()
+	fmt.Printf("  %d flow(s) scored differently\n\n", e.Disagreements)
+
+	if e.Acceptable(maxIncrease) {
+		fmt.Printf("Candidate is acceptable (anomaly rate at most %.2f points above the active model)\n", 100*maxIncrease)
+	} else {
+		fmt.Printf("Candidate is not acceptable (anomaly rate more than %.2f points above the active model)\n", 100*maxIncrease)
+	}
+}
+
+// historyFlows returns the flows of the enforcement log in the since period,
+// or only the allowed ones if allowedOnly is set
+func historyFlows(since string, allowedOnly bool) ([]anomaly.FlowRecord, error) {
+	period, err := stats.ParseSince(since)
+	if err != nil {
+		return nil, err
+	}
+	logFile := getLogFilePath()
+	if _, err := os.Stat(logFile); err != nil {
+		return nil, fmt.Errorf("no enforcement log at %s: %w", logFile, err)
+	}
+
+	cutoff := time.Now().Add(-period)
+	var flows []anomaly.FlowRecord
+	readLogFrom(logFile, 0, func(entry LogEntry) {
+		if entry.Timestamp.Before(cutoff) || (allowedOnly && entry.Action != "ALLOWED") {
+			return
+		}
+		flows = append(flows, anomaly.FlowRecord{
+			SourceIP:  entry.SourceIP,
+			DestIP:    entry.DestIP,
+			Port:      entry.Port,
+			Protocol:  entry.Protocol,
+			Timestamp: entry.Timestamp,
+		})
+	})
+	if len(flows) == 0 {
+		return nil, fmt.Errorf("no flows in the enforcement log since %s", cutoff.Format("2006-01-02 15:04"))
+	}
+	return flows, nil
+}
+
+func init() {
+	anomalyListCmd.Flags().String("since", "24h", "Show alerts of this period (e.g. 24h, 7d)")
+	anomalyAckCmd.Flags().Bool("benign", false, "Label the alert a false positive")
+	anomalyModelCmd.PersistentFlags().String("endpoint", "http://localhost:5000", "Detection service URL")
+	anomalyModelTrainCmd.Flags().String("since", "7d", "Train on allowed flows of this period (e.g. 24h, 7d)")
+	for _, c := range []*cobra.Command{anomalyModelEvaluateCmd, anomalyModelPromoteCmd} {
+		c.Flags().String("since", "24h", "Evaluate on the flows of this period")
+		c.Flags().Float64("max-increase", 0.05, "Largest acceptable increase of the anomaly rate, as a fraction of flows")
+	}
+	anomalyModelPromoteCmd.Flags().Bool("force", false, "Promote even if the candidate's anomaly rate is not acceptable")
+
+	anomalyModelCmd.AddCommand(anomalyModelStatusCmd)
+	anomalyModelCmd.AddCommand(anomalyModelTrainCmd)
+	anomalyModelCmd.AddCommand(anomalyModelEvaluateCmd)
+	anomalyModelCmd.AddCommand(anomalyModelPromoteCmd)
+	anomalyCmd.AddCommand(anomalyListCmd)
+	anomalyCmd.AddCommand(anomalyAckCmd)
+	anomalyCmd.AddCommand(anomalyModelCmd)
+	rootCmd.AddCommand(anomalyCmd)
+}
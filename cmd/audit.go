@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"ztap/pkg/cloud"
+	"ztap/pkg/effective"
+	"ztap/pkg/enforcer"
+	"ztap/pkg/render"
+
+	"github.com/spf13/cobra"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Audit enforced state across backends",
+}
+
+var auditConsistencyCmd = &cobra.Command{
+	Use:   "consistency --accounts accounts.yaml [--labels app=web]",
+	Short: "Check that the host and its Security Groups allow the same egress",
+	Long: `Compare the egress this host enforces with what its workload's Security
+Groups allow, for workloads that run both on-prem and in the cloud. Run it on
+an on-prem host of the workload; rules are read back from the backends as
+with 'ztap export effective-rules' (the eBPF map on Linux, the ztap pf anchor
+on macOS, and the Security Group given with --security-group or those in
+--accounts).
+
+  cloud-only  a Security Group allows egress the host does not, e.g. a
+              port the host policy blocks
+  host-only   the host allows egress a Security Group does not, so the
+              cloud instances of the workload cannot reach it
+
+A rule is consistent when a single rule on the other side allows all of its
+traffic; "partial" marks traffic the other side allows only some of.
+Security Group rules to prefix lists or other groups are not compared.
+
+With --labels, only the accounts and regions where AWS instances carry the
+labels are compared, and nothing is compared unless a service registered
+on-prem carries them too. Exits non-zero if a divergence is found.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		sgID, _ := cmd.Flags().GetString("security-group")
+		region, _ := cmd.Flags().GetString("region")
+		accountsFile, _ := cmd.Flags().GetString("accounts")
+		labels, _ := cmd.Flags().GetStringToString("labels")
+
+		if sgID == "" && accountsFile == "" {
+			failf(exitValidation, "--security-group or --accounts is required")
+		}
+		ctx := cmd.Context()
+
+		var regions map[string]bool
+		if len(labels) > 0 {
+			if ips, err := getDiscoveryBackend().ResolveLabels(labels); err != nil || len(ips) == 0 {
+				printer.Printf("No on-prem workload is labeled %v; nothing to compare\n", labels)
+				return
+			}
+			var err error
+			if regions, err = workloadRegions(ctx, accountsFile, region, labels); err != nil {
+				fail(err)
+			}
+			if len(regions) == 0 {
+				printer.Printf("No AWS instance is labeled %v; nothing to compare\n", labels)
+				return
+			}
+		}
+
+		sources := effective.Sources{SecurityGroups: securityGroupSource(accountsFile, sgID, region)}
+		if enforcer.IsLinux() {
+			sources.EBPF = readPolicyMap
+		} else {
+			sources.PF = enforcer.ListPFAnchorRules
+		}
+		host, _ := os.Hostname()
+		doc := effective.Collect(ctx, host, time.Now(), sources)
+		if regions != nil {
+			var rules []cloud.AppliedRule
+			for _, r := range doc.SecurityGroups.Rules {
+				if regions[r.Account+"/"+r.Region] {
+					rules = append(rules, r)
+				}
+			}
+			doc.SecurityGroups.Rules = rules
+		}
+
+		divergences := effective.CheckConsistency(doc)
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "Kind", Key: "kind"},
+				{Header: "Account", Key: "account"},
+				{Header: "Region", Key: "region"},
+				{Header: "Security Group", Key: "securityGroup"},
+				{Header: "Traffic", Key: "traffic"},
+				{Header: "Partial", Key: "partial"},
+				{Header: "Rule ID", Key: "ruleId"},
+				{Header: "Host Rule", Key: "hostRule"},
+			},
+			Empty: "No divergences: the host and its Security Groups allow the same egress",
+		}
+		for _, d := range divergences {
+			table.AddRow(d.Kind, d.Account, d.Region, d.SecurityGroup, d.Traffic, d.Partial, d.RuleID, d.HostRule)
+		}
+		if err := printer.Table(table); err != nil {
+			fail(err)
+		}
+
+		if errs := doc.Errors(); len(errs) > 0 {
+			for _, err := range errs {
+				log.Printf("Warning: failed to read %s", err)
+			}
+			os.Exit(exitPartial)
+		}
+		if len(divergences) > 0 {
+			os.Exit(exitFailure)
+		}
+	},
+}
+
+// workloadRegions returns the "account/region" pairs (account empty without
+// accountsFile) where AWS instances carry labels
+func workloadRegions(ctx context.Context, accountsFile, region string, labels map[string]string) (map[string]bool, error) {
+	var resources []cloud.Resource
+	var err error
+	if accountsFile != "" {
+		var client *cloud.MultiClient
+		if client, err = newMultiClient(ctx, accountsFile); err != nil {
+			return nil, err
+		}
+		resources, err = client.DiscoverResources()
+	} else {
+		var client *cloud.AWSClient
+		if client, err = cloud.NewAWSClient(region); err != nil {
+			return nil, err
+		}
+		resources, err = client.DiscoverResources()
+	}
+	if err != nil && len(resources) == 0 {
+		return nil, err
+	}
+
+	regions := make(map[string]bool)
+	for _, r := range cloud.MatchResourcesByLabels(resources, labels) {
+		if accountsFile == "" {
+			regions["/"+region] = true
+			continue
+		}
+		regions[r.Labels[cloud.LabelAccount]+"/"+r.Labels[cloud.LabelRegion]] = true
+	}
+	return regions, nil
+}
+
+func init() {
+	auditConsistencyCmd.Flags().String("security-group", "", "Security Group of the workload's cloud instances")
+	auditConsistencyCmd.Flags().StringP("region", "r", "us-east-1", "AWS region of --security-group")
+	auditConsistencyCmd.Flags().String("accounts", "", "YAML file of AWS accounts whose Security Groups to compare")
+	auditConsistencyCmd.Flags().StringToString("labels", nil, "Labels of the workload; only compare where it runs both on-prem and in AWS")
+
+	auditCmd.AddCommand(auditConsistencyCmd)
+	rootCmd.AddCommand(auditCmd)
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/events"
+
+	"github.com/spf13/cobra"
+)
+
+// maxBreakGlass bounds how long enforcement can be suspended at once
+const maxBreakGlass = 24 * time.Hour
+
+var breakGlassCmd = &cobra.Command{
+	Use:   "breakglass",
+	Short: "Temporarily suspend enforcement for incident response",
+	Long: `Suspend blocking enforcement on every node for a limited time.
+
+While break-glass is active, 'ztap agent' removes all ZTAP rules from the
+backend (monitor mode) and 'ztap enforce' refuses to run. Policies keep being
+loaded and compiled, and enforcement is restored automatically when the
+window ends, or at once with 'ztap breakglass disable'. Agents pick up the
+change on their next cluster config reload.
+
+Enabling and disabling require a 'ztap user login' session with the admin
+role; the admin is recorded in the cluster config and in break_glass events.`,
+}
+
+var breakGlassEnableCmd = &cobra.Command{
+	Use:   "enable --duration 30m",
+	Short: "Suspend enforcement until the duration elapses",
+	Run: func(cmd *cobra.Command, args []string) {
+		duration, _ := cmd.Flags().GetDuration("duration")
+		reason, _ := cmd.Flags().GetString("reason")
+		if duration <= 0 || duration > maxBreakGlass {
+			failf(exitValidation, "--duration must be between 0 and %s", maxBreakGlass)
+		}
+
+		session, err := requireSession(auth.PermBreakGlass)
+		if err != nil {
+			fail(err)
+		}
+		store, err := getClusterConfigStore()
+		if err != nil {
+			fail(err)
+		}
+
+		until := time.Now().Add(duration).UTC().Truncate(time.Second)
+		if _, err := store.Set(cluster.ConfigBreakGlass, until.Format(time.RFC3339), session.Username); err != nil {
+			fail(err)
+		}
+		events.Default().Publish(events.TopicBreakGlass, events.BreakGlass{
+			Action:    events.BreakGlassEnabled,
+			Principal: session.Username,
+			Until:     until,
+			Reason:    reason,
+		})
+
+		fmt.Println("WARNING: BREAK-GLASS ENABLED")
+		fmt.Printf("WARNING: ZTAP enforcement is suspended until %s (%s)\n", until.Local().Format("2006-01-02 15:04:05"), duration)
+		fmt.Println("WARNING: Traffic is not being blocked; run 'ztap breakglass disable' as soon as possible")
+	},
+}
+
+var breakGlassDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Restore enforcement now",
+	Run: func(cmd *cobra.Command, args []string) {
+		session, err := requireSession(auth.PermBreakGlass)
+		if err != nil {
+			fail(err)
+		}
+		store, err := getClusterConfigStore()
+		if err != nil {
+			fail(err)
+		}
+
+		if _, active := activeBreakGlass(store); !active {
+			fmt.Println("Break-glass is not active")
+			return
+		}
+		if err := store.Delete(cluster.ConfigBreakGlass, session.Username); err != nil {
+			fail(err)
+		}
+		events.Default().Publish(events.TopicBreakGlass, events.BreakGlass{
+			Action:    events.BreakGlassDisabled,
+			Principal: session.Username,
+		})
+		fmt.Println("Enforcement restored")
+	},
+}
+
+var breakGlassStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether enforcement is suspended",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := getClusterConfigStore()
+		if err != nil {
+			fail(err)
+		}
+
+		entry, active := activeBreakGlass(store)
+		if !active {
+			fmt.Println("Break-glass is not active; enforcement is on")
+			return
+		}
+		until, _ := time.Parse(time.RFC3339, entry.Value)
+		fmt.Printf("BREAK-GLASS ACTIVE: enforcement suspended by %s until %s (%s left)\n",
+			entry.UpdatedBy, until.Local().Format("2006-01-02 15:04:05"), time.Until(until).Round(time.Second))
+	},
+}
+
+func init() {
+	breakGlassEnableCmd.Flags().Duration("duration", 30*time.Minute, "How long to suspend enforcement (at most 24h)")
+	breakGlassEnableCmd.Flags().String("reason", "", "Why enforcement is suspended, recorded in the break_glass event")
+
+	breakGlassCmd.AddCommand(breakGlassEnableCmd)
+	breakGlassCmd.AddCommand(breakGlassDisableCmd)
+	breakGlassCmd.AddCommand(breakGlassStatusCmd)
+	rootCmd.AddCommand(breakGlassCmd)
+}
+
+// activeBreakGlass returns the break-glass config entry if its window has
+// not ended
+func activeBreakGlass(store cluster.ConfigStore) (cluster.ConfigEntry, bool) {
+	entry, ok := store.Get(cluster.ConfigBreakGlass)
+	if !ok {
+		return entry, false
+	}
+	until, err := time.Parse(time.RFC3339, entry.Value)
+	return entry, err == nil && time.Now().Before(until)
+}
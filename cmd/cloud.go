@@ -0,0 +1,413 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"strings"
+
+	"ztap/pkg/bulk"
+	"ztap/pkg/cloud"
+	"ztap/pkg/policy"
+	"ztap/pkg/render"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var cloudCmd = &cobra.Command{
+	Use:   "cloud",
+	Short: "Manage cloud Security Group rules",
+	Long:  `Sync or export the cloud Security Group rules implied by ZTAP policies`,
+}
+
+var cloudExportCmd = &cobra.Command{
+	Use:   "export -f policy.yaml --format terraform",
+	Short: "Export Security Group rules as infrastructure-as-code",
+	Long: `Render the AWS Security Group egress and ingress rules a policy set
+implies, so ZTAP intent can be applied through existing infrastructure
+pipelines.
+
+Formats:
+  terraform        aws_security_group_rule resources (HCL)
+  cloudformation   AWS::EC2::SecurityGroupEgress and SecurityGroupIngress
+                   template (JSON); CDK apps can load it with CfnInclude
+
+podSelector peers are resolved through service discovery; peers that resolve
+to no endpoints are listed as comments and not exported.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policyFile, _ := cmd.Flags().GetString("file")
+		format, _ := cmd.Flags().GetString("format")
+		sgID, _ := cmd.Flags().GetString("security-group")
+		importRules, _ := cmd.Flags().GetBool("import")
+
+		policies, err := policy.LoadFromFile(policyFile)
+		if err != nil {
+			fail(validationErrorf("failed to load policies: %w", err))
+		}
+
+		rules, skipped := cloud.DeriveRules(policies, getDiscoveryBackend())
+
+		switch format {
+		case "terraform":
+			err = cloud.WriteTerraform(os.Stdout, rules, skipped, cloud.TerraformOptions{
+				SecurityGroupID: sgID,
+				Import:          importRules,
+			})
+		case "cloudformation":
+			err = cloud.WriteCloudFormation(os.Stdout, rules, skipped, cloud.CloudFormationOptions{
+				SecurityGroupID: sgID,
+			})
+		default:
+			err = validationErrorf("unknown format %q (use terraform or cloudformation)", format)
+		}
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
+var cloudSyncCmd = &cobra.Command{
+	Use:   "sync -f policy.yaml --security-group sg-123",
+	Short: "Sync policies to AWS Security Groups",
+	Long: `Authorize the egress and ingress rules of every policy on an AWS
+Security Group.
+
+Policies are synced by a pool of --concurrency workers. Deny-all policies are
+synced before any allow policy, and failures are reported per policy without
+stopping the others. With --accounts, every account/region that has a
+Security Group configured is synced concurrently.
+
+A line is printed as each policy completes, followed by a summary table of
+every policy (per account/region with --accounts). The command exits non-zero
+if any policy failed to sync.
+
+--dry-run prints the rules the sync would change on each Security Group,
+without applying them: rules to create (+), managed rules whose description
+no longer names the policies allowing them (~), and managed rules no policy
+implies any more (-). A sync only creates rules; with --prune it also
+updates and revokes the stale ones. --diff prints the whole egress rule set
+and the managed ingress rules of each Security Group against the policies,
+including the rules kept and those ZTAP does not manage, which are never
+changed. Markers are colored on
+a terminal unless $NO_COLOR is set.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policyFile, _ := cmd.Flags().GetString("file")
+		sgID, _ := cmd.Flags().GetString("security-group")
+		region, _ := cmd.Flags().GetString("region")
+		accountsFile, _ := cmd.Flags().GetString("accounts")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		diff, _ := cmd.Flags().GetBool("diff")
+		prune, _ := cmd.Flags().GetBool("prune")
+
+		policies, err := policy.LoadFromFile(policyFile)
+		if err != nil {
+			fail(validationErrorf("failed to load policies: %w", err))
+		}
+		if accountsFile == "" && sgID == "" {
+			failf(exitValidation, "--security-group is required without --accounts")
+		}
+
+		ctx := cmd.Context()
+		if dryRun || diff {
+			changes, err := planSync(ctx, region, sgID, accountsFile, policies)
+			printSyncPlan(changes, diff, prune)
+			if err != nil {
+				fail(err)
+			}
+			return
+		}
+
+		var reporter *bulk.Reporter
+		if accountsFile != "" {
+			reporter, err = syncAccounts(ctx, accountsFile, policies, concurrency)
+		} else {
+			var client *cloud.AWSClient
+			if client, err = cloud.NewAWSClient(region); err == nil {
+				reporter = bulk.NewReporter(os.Stdout, "synced", len(policies))
+				err = client.SyncPolicies(bulk.WithReporter(ctx, reporter), policies, sgID, concurrency)
+			}
+		}
+		if reporter != nil {
+			fmt.Println()
+			reporter.WriteSummary(os.Stdout)
+		}
+		if err != nil {
+			// Per-policy failures are listed in the summary
+			if reporter == nil || reporter.Failed() == 0 {
+				fail(err)
+			}
+			failBulk(reporter, exitEnforcement)
+		}
+		if prune {
+			pruneSync(ctx, region, sgID, accountsFile, policies)
+		}
+	},
+}
+
+// planSync plans the sync of policies to sgID in region, or to every
+// Security Group in accountsFile
+func planSync(ctx context.Context, region, sgID, accountsFile string, policies []policy.NetworkPolicy) ([]cloud.RuleChange, error) {
+	if accountsFile == "" {
+		client, err := cloud.NewAWSClient(region)
+		if err != nil {
+			return nil, err
+		}
+		return client.PlanSync(ctx, policies, sgID)
+	}
+	client, err := newMultiClient(ctx, accountsFile)
+	if err != nil {
+		return nil, err
+	}
+	return client.PlanSync(ctx, policies)
+}
+
+// pruneSync updates and revokes the managed rules a sync left stale
+func pruneSync(ctx context.Context, region, sgID, accountsFile string, policies []policy.NetworkPolicy) {
+	changes, err := planSync(ctx, region, sgID, accountsFile, policies)
+	if err != nil {
+		fail(err)
+	}
+	var stale []cloud.RuleChange
+	for _, change := range changes {
+		if change.Action == cloud.ActionUpdate || change.Action == cloud.ActionRevoke {
+			stale = append(stale, change)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+
+	if accountsFile == "" {
+		var client *cloud.AWSClient
+		if client, err = cloud.NewAWSClient(region); err == nil {
+			err = client.ApplyChanges(ctx, sgID, stale)
+		}
+	} else {
+		var client *cloud.MultiClient
+		if client, err = newMultiClient(ctx, accountsFile); err == nil {
+			err = client.ApplyChanges(ctx, stale)
+		}
+	}
+	if err != nil {
+		fail(enforcementError(fmt.Errorf("failed to prune stale rules: %w", err)))
+	}
+	printer.Printf("Pruned %d stale rules\n", len(stale))
+}
+
+// printSyncPlan prints the changes per Security Group: only the changes,
+// or with full the rules kept and unmanaged too. Without prune, updates and
+// revokes are noted as left in place by a sync.
+func printSyncPlan(changes []cloud.RuleChange, full, prune bool) {
+	if printer.Structured() {
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "Action", Key: "action"},
+				{Header: "Provider", Key: "provider"},
+				{Header: "Account", Key: "account"},
+				{Header: "Region", Key: "region"},
+				{Header: "Security Group", Key: "securityGroup"},
+				{Header: "Rule ID", Key: "ruleId"},
+				{Header: "Rule", Key: "rule"},
+				{Header: "Policies", Key: "policies"},
+				{Header: "Description", Key: "description"},
+				{Header: "Live Description", Key: "liveDescription"},
+			},
+		}
+		for _, c := range changes {
+			if full || c.Changed() {
+				table.AddRow(c.Action, c.Provider, c.Account, c.Region, c.SecurityGroup, c.RuleID, c.Rule(), c.Policies, c.Description, c.LiveDescription)
+			}
+		}
+		if err := printer.Table(table); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	counts := make(map[string]int)
+	group := ""
+	for _, c := range changes {
+		if !full && !c.Changed() {
+			continue
+		}
+		if g := syncPlanGroup(c); g != group {
+			if group != "" {
+				fmt.Println()
+			}
+			group = g
+			fmt.Println(g)
+		}
+		counts[c.Action]++
+
+		switch c.Action {
+		case cloud.ActionCreate:
+			fmt.Printf("  %s %s  (%s)\n", colorMarker("+"), c.Rule(), strings.Join(c.Policies, ", "))
+		case cloud.ActionUpdate:
+			fmt.Printf("  %s %s  (%s)\n", colorMarker("~"), c.Rule(), strings.Join(c.Policies, ", "))
+			fmt.Printf("      description %q -> %q\n", c.LiveDescription, c.Description)
+		case cloud.ActionRevoke:
+			fmt.Printf("  %s %s  %s\n", colorMarker("-"), c.Rule(), c.RuleID)
+		case cloud.ActionKeep:
+			fmt.Printf("    %s  (%s)\n", c.Rule(), strings.Join(c.Policies, ", "))
+		default:
+			fmt.Printf("    %s  %s, not managed by ZTAP\n", c.Rule(), c.RuleID)
+		}
+	}
+	if group == "" {
+		fmt.Println("No changes: every Security Group matches the policies")
+		return
+	}
+
+	fmt.Printf("\n%d to create, %d to update, %d to revoke\n",
+		counts[cloud.ActionCreate], counts[cloud.ActionUpdate], counts[cloud.ActionRevoke])
+	if !prune && counts[cloud.ActionUpdate]+counts[cloud.ActionRevoke] > 0 {
+		fmt.Println("A sync only creates rules; add --prune to also update and revoke the stale ones.")
+	}
+}
+
+// syncPlanGroup heads the changes of one Security Group, e.g.
+// "aws prod/us-east-1 sg-123"
+func syncPlanGroup(c cloud.RuleChange) string {
+	if c.Account == "" {
+		return fmt.Sprintf("%s %s %s", c.Provider, c.Region, c.SecurityGroup)
+	}
+	return fmt.Sprintf("%s %s/%s %s", c.Provider, c.Account, c.Region, c.SecurityGroup)
+}
+
+// colorMarker colors a diff marker (green +, yellow ~, red -) when stdout is
+// a terminal and $NO_COLOR is not set
+func colorMarker(marker string) string {
+	if os.Getenv("NO_COLOR") != "" || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return marker
+	}
+	color := map[string]string{"+": "32", "~": "33", "-": "31"}[marker]
+	if color == "" {
+		return marker
+	}
+	return "\x1b[" + color + "m" + marker + "\x1b[0m"
+}
+
+// syncAccounts syncs policies to every Security Group in accountsFile,
+// reporting progress per account/region and policy. The reporter is nil if
+// the accounts could not be loaded.
+func syncAccounts(ctx context.Context, accountsFile string, policies []policy.NetworkPolicy, concurrency int) (*bulk.Reporter, error) {
+	client, err := newMultiClient(ctx, accountsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := 0
+	for _, target := range client.Targets() {
+		if target.SecurityGroup != "" {
+			groups++
+		}
+	}
+	reporter := bulk.NewReporter(os.Stdout, "synced", groups*len(policies))
+	return reporter, client.SyncPolicies(bulk.WithReporter(ctx, reporter), policies, concurrency)
+}
+
+// newMultiClient returns a client for every account and region in
+// accountsFile
+func newMultiClient(ctx context.Context, accountsFile string) (*cloud.MultiClient, error) {
+	accounts, err := cloud.LoadAccounts(accountsFile)
+	if err != nil {
+		return nil, validationError(err)
+	}
+	client, err := cloud.NewMultiClient(ctx, accounts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize AWS clients: %w", err)
+	}
+	return client, nil
+}
+
+// newPolicyResolver returns a resolver over local service discovery and, when
+// the command's --aws-region or --aws-accounts flag is set, the AWS inventory,
+// so one selector covers registered hosts and cloud instances alike. The
+// inventory is returned so long-running commands can refresh it.
+func newPolicyResolver(cmd *cobra.Command) (*policy.PolicyResolver, *cloud.Inventory, error) {
+	inventory, err := newInventory(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	if inventory != nil {
+		if err := inventory.Refresh(); err != nil {
+			log.Printf("Warning: Failed to discover cloud inventory: %v", err)
+		}
+	}
+	return resolverFor(inventory), inventory, nil
+}
+
+// newInventory returns the AWS inventory of the command's --aws-region or
+// --aws-accounts flag, not yet refreshed, or nil if neither is set
+func newInventory(cmd *cobra.Command) (*cloud.Inventory, error) {
+	region, _ := cmd.Flags().GetString("aws-region")
+	accountsFile, _ := cmd.Flags().GetString("aws-accounts")
+
+	switch {
+	case accountsFile != "":
+		accounts, err := cloud.LoadAccounts(accountsFile)
+		if err != nil {
+			return nil, err
+		}
+		client, err := cloud.NewMultiClient(cmd.Context(), accounts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize AWS clients: %w", err)
+		}
+		return cloud.NewInventory(client.DiscoverResources), nil
+	case region != "":
+		client, err := cloud.NewAWSClient(region)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize AWS client: %w", err)
+		}
+		return cloud.NewInventory(client.DiscoverResources), nil
+	}
+	return nil, nil
+}
+
+// resolverFor returns a resolver over local service discovery and inventory,
+// if not nil. Local registrations take precedence over cloud inventory.
+func resolverFor(inventory *cloud.Inventory) *policy.PolicyResolver {
+	if inventory == nil {
+		return policy.NewPolicyResolver(getDiscoveryBackend())
+	}
+	return policy.NewPolicyResolver(getDiscoveryBackend(), inventory)
+}
+
+// withCloudIdentity merges the node's cloud identity labels into labels.
+// Identity labels win over user-supplied ones so a registration cannot claim
+// another account or role.
+func withCloudIdentity(labels map[string]string) (map[string]string, error) {
+	id, err := cloud.DetectIdentity(context.Background(), cloud.DefaultIdentityProviders()...)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]string, len(labels))
+	maps.Copy(merged, labels)
+	maps.Copy(merged, id.Labels())
+	return merged, nil
+}
+
+func init() {
+	cloudExportCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
+	cloudExportCmd.Flags().String("format", "terraform", "Output format (terraform, cloudformation)")
+	cloudExportCmd.Flags().String("security-group", "", "Security Group ID the rules attach to")
+	cloudExportCmd.Flags().Bool("import", false, "Emit import blocks for rules already synced (requires --security-group)")
+
+	cloudSyncCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
+	cloudSyncCmd.Flags().String("security-group", "", "Security Group ID to sync")
+	cloudSyncCmd.Flags().StringP("region", "r", "us-east-1", "AWS region")
+	cloudSyncCmd.Flags().String("accounts", "", "YAML file of AWS accounts, regions and Security Groups to sync")
+	cloudSyncCmd.Flags().Int("concurrency", 8, "Number of policies synced in parallel per Security Group")
+	cloudSyncCmd.Flags().Bool("dry-run", false, "Print the rules the sync would create, update or revoke, without applying them")
+	cloudSyncCmd.Flags().Bool("diff", false, "Print every egress rule of each Security Group against the policies, without applying them")
+	cloudSyncCmd.Flags().Bool("prune", false, "After syncing, also update the descriptions of stale managed rules and revoke those no policy implies")
+
+	cloudCmd.AddCommand(cloudExportCmd)
+	cloudCmd.AddCommand(cloudSyncCmd)
+	rootCmd.AddCommand(cloudCmd)
+}
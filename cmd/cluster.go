@@ -1,13 +1,22 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"text/tabwriter"
 	"time"
 
+	"ztap/pkg/auth"
 	"ztap/pkg/cluster"
+	"ztap/pkg/enforcer"
+	"ztap/pkg/policy"
+	"ztap/pkg/render"
 
 	"github.com/spf13/cobra"
 )
@@ -69,8 +78,19 @@ var clusterStatusCmd = &cobra.Command{
 var clusterJoinCmd = &cobra.Command{
 	Use:   "join <node-id> <node-address>",
 	Short: "Join a node to the cluster",
-	Long:  `Register a new node in the cluster. Node ID should be unique. Address format: host:port`,
-	Args:  cobra.ExactArgs(2),
+	Long: `Register a new node in the cluster. Node ID should be unique. Address format: host:port
+
+Joining provisions the node's service account, node:<node-id> (in the tenant
+given with --tenant), and prints its secret once. The agent logs in to the
+API server with it, and may then only report its own status
+(POST /nodes/<node-id>/status) and fetch the policies distributed to the
+labels given with --labels (GET /nodes/<node-id>/policies): those whose
+spec.nodeSelector matches them, and those without a nodeSelector whose
+podSelector does. It cannot read or change other nodes' policies. Joining again rotates the secret; 'ztap cluster leave'
+disables the account.
+
+  ztap cluster join web-1 10.0.1.5:9090 --labels app=web,env=prod`,
+	Args: cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		if clusterElection == nil {
 			fmt.Println("Cluster not initialized. Run with --init first.")
@@ -80,27 +100,70 @@ var clusterJoinCmd = &cobra.Command{
 		nodeID := args[0]
 		address := args[1]
 
+		backend, _ := cmd.Flags().GetString("backend")
+		nodeVersion, _ := cmd.Flags().GetString("node-version")
+		nodeOS, _ := cmd.Flags().GetString("os")
+		cloudIdentity, _ := cmd.Flags().GetBool("cloud-identity")
+		labels, _ := cmd.Flags().GetStringToString("labels")
+		policySchema, _ := cmd.Flags().GetInt("policy-schema")
+		policyFeatures, _ := cmd.Flags().GetStringSlice("policy-features")
+		if backend != enforcer.BackendEBPF && backend != enforcer.BackendPF {
+			failf(exitValidation, "invalid backend %q (must be %s or %s)", backend, enforcer.BackendEBPF, enforcer.BackendPF)
+		}
+
+		info := nodeInfo(backend, nodeVersion, nodeOS)
+		info.Labels = labels
+		info.PolicySchema, info.PolicyFeatures = policySchema, policyFeatures
+		metadata := info.Metadata()
+		if cloudIdentity {
+			var err error
+			if metadata, err = withCloudIdentity(metadata); err != nil {
+				fail(fmt.Errorf("failed to detect cloud identity: %w", err))
+			}
+		}
+
 		node := &cluster.Node{
 			ID:       nodeID,
 			Address:  address,
 			State:    cluster.StateHealthy,
 			JoinedAt: time.Now(),
 			LastSeen: time.Now(),
-			Metadata: make(map[string]string),
+			Metadata: metadata,
+		}
+
+		am, err := getAuthManager()
+		if err != nil {
+			fail(fmt.Errorf("failed to provision service account: %w", err))
+		}
+		secret, err := am.ProvisionServiceAccount(nodeID, labels, activeTenant)
+		if err != nil {
+			fail(fmt.Errorf("failed to provision service account: %w", err))
 		}
 
 		if err := clusterElection.RegisterNode(node); err != nil {
-			log.Fatalf("Failed to join node: %v", err)
+			if err := am.RevokeServiceAccount(nodeID); err != nil {
+				log.Printf("Warning: Failed to disable service account: %v", err)
+			}
+			fail(fmt.Errorf("failed to join node: %w", err))
+		}
+		// A node drained before it rejoins stays out of leadership
+		if store, err := getClusterConfigStore(); err == nil {
+			if entry, ok := store.Get(cluster.ConfigDrainPrefix + nodeID); ok {
+				cluster.FollowDrains(clusterElection)(entry)
+			}
 		}
 
 		fmt.Printf("Node %s joined the cluster at %s\n", nodeID, address)
+		fmt.Printf("Service account: %s\n", auth.ServiceAccountName(nodeID))
+		fmt.Printf("Secret: %s\n", secret)
+		fmt.Println("Store the secret on the node; it is not shown again.")
 	},
 }
 
 var clusterLeaveCmd = &cobra.Command{
 	Use:   "leave <node-id>",
 	Short: "Remove a node from the cluster",
-	Long:  `Deregister a node from the cluster.`,
+	Long:  `Deregister a node from the cluster and disable its service account.`,
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		if clusterElection == nil {
@@ -111,7 +174,12 @@ var clusterLeaveCmd = &cobra.Command{
 		nodeID := args[0]
 
 		if err := clusterElection.DeregisterNode(nodeID); err != nil {
-			log.Fatalf("Failed to remove node: %v", err)
+			fail(fmt.Errorf("failed to remove node: %w", err))
+		}
+		if am, err := getAuthManager(); err != nil {
+			log.Printf("Warning: Failed to disable service account: %v", err)
+		} else if err := am.RevokeServiceAccount(nodeID); err != nil && !errors.Is(err, auth.ErrUserNotFound) {
+			log.Printf("Warning: Failed to disable service account: %v", err)
 		}
 
 		fmt.Printf("Node %s left the cluster\n", nodeID)
@@ -128,33 +196,290 @@ var clusterListCmd = &cobra.Command{
 			return
 		}
 
-		nodes := clusterElection.GetNodes()
+		schedules := getNodeSchedules()
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "ID", Key: "id"},
+				{Header: "Address", Key: "address"},
+				{Header: "Role", Key: "role"},
+				{Header: "State", Key: "state"},
+				{Header: "Schedule", Key: "schedule"},
+				{Header: "Version", Key: "version"},
+				{Header: "Backend", Key: "backend"},
+				{Header: "Capabilities", Key: "capabilities"},
+				{Header: "Capacity", Key: "capacity"},
+				{Header: "Joined", Key: "joined"},
+				{Header: "Last seen", Key: "last_seen"},
+			},
+			Empty: "No nodes in cluster",
+		}
+		for _, node := range clusterElection.GetNodes() {
+			table.AddRow(node.ID, node.Address, node.Role, node.State, schedules[node.ID],
+				metadataOrDash(node, cluster.MetadataVersion),
+				metadataOrDash(node, cluster.MetadataBackend),
+				capabilitiesString(node), capacityString(node),
+				node.JoinedAt, node.LastSeen)
+		}
+		if err := printer.Table(table); err != nil {
+			fail(err)
+		}
+	},
+}
+
+var clusterScheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Preview which policies each node will enforce",
+	Long: `Show the policies distributed to each node based on its advertised capabilities
+and policy capacity. Policies a node cannot enforce, or whose spec.nodeSelector
+does not match the labels it joined with, are listed with the reason. So are
+policies using schema features a node running an older version does not
+understand ('ztap cluster join --policy-features'); those that can be
+rewritten for it without changing their meaning are marked as downgraded.
+Cordoned and drained nodes are marked with what they enforce instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if clusterElection == nil {
+			fmt.Println("Cluster not initialized. Run with --init first.")
+			return
+		}
 
+		file, _ := cmd.Flags().GetString("file")
+		policies, err := policy.LoadFromFile(file)
+		if err != nil {
+			fail(validationErrorf("failed to load policies: %w", err))
+		}
+
+		nodes := clusterElection.GetNodes()
 		if len(nodes) == 0 {
 			fmt.Println("No nodes in cluster")
 			return
 		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
 
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-		fmt.Fprintln(w, "ID\tAddress\tRole\tState\tJoined\tLast Seen")
-		fmt.Fprintln(w, "--\t-------\t----\t-----\t------\t---------")
+		// Capacity is counted in compiled rules: one per resolved endpoint and port
+		resolver := policy.NewPolicyResolver(getDiscoveryBackend())
+		compiled := make(map[string]*policy.CompiledPolicy, len(policies))
+		for _, p := range policies {
+			c, err := resolver.Compile(p)
+			if err != nil {
+				log.Printf("Warning: %v", err)
+				continue
+			}
+			compiled[p.Metadata.Name] = c
+		}
 
+		assignments := cluster.SchedulePolicies(nodes, policies, compiled)
+		schedules := getNodeSchedules()
 		for _, node := range nodes {
-			joined := time.Since(node.JoinedAt).Round(time.Second)
-			lastSeen := time.Since(node.LastSeen).Round(time.Millisecond)
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s ago\t%s ago\n",
-				node.ID, node.Address, node.Role, node.State, joined, lastSeen)
+			assignment := assignments[node.ID]
+			fmt.Printf("%s (%s): %d policy(ies)\n", node.ID,
+				metadataOrDash(node, cluster.MetadataBackend), len(assignment.Policies))
+			if schedule, ok := schedules[node.ID]; ok {
+				effect := "policies new to it are not enforced"
+				switch schedule.Drain {
+				case cluster.DrainMonitor:
+					effect = "no rules are enforced, traffic is only monitored"
+				case cluster.DrainKeep:
+					effect = "its last enforced rules are kept"
+				}
+				fmt.Printf("  ! %s by %s: %s\n", schedule, schedule.By, effect)
+			}
+			for _, p := range assignment.Policies {
+				if features := assignment.Downgraded[p.Metadata.Name]; len(features) > 0 {
+					fmt.Printf("  ~ %s: downgraded (%s)\n", p.Metadata.Name, strings.Join(features, ", "))
+					continue
+				}
+				fmt.Printf("  ✓ %s\n", p.Metadata.Name)
+			}
+
+			skipped := make([]string, 0, len(assignment.Skipped))
+			for name := range assignment.Skipped {
+				skipped = append(skipped, name)
+			}
+			sort.Strings(skipped)
+			for _, name := range skipped {
+				fmt.Printf("  ✗ %s: %s\n", name, assignment.Skipped[name])
+			}
+		}
+	},
+}
+
+var clusterConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage cluster-wide configuration",
+	Long: `Get and set cluster-level settings that every node applies live.
+
+Keys:
+  default-deny     Deny traffic not matched by any policy (true/false)
+  log-level        debug, info, warn, or error
+  feature.<name>   Feature flags (true/false)
+  break-glass      Time until which enforcement is suspended; use 'ztap breakglass'`,
+}
+
+var clusterConfigSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a cluster configuration value",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := getClusterConfigStore()
+		if err != nil {
+			return err
 		}
-		w.Flush()
+
+		hostname, _ := os.Hostname()
+		entry, err := store.Set(args[0], args[1], hostname)
+		if err != nil {
+			return fmt.Errorf("failed to set config: %w", err)
+		}
+
+		fmt.Printf("%s = %s (version %d)\n", entry.Key, entry.Value, entry.Version)
+		return nil
 	},
 }
 
+var clusterConfigGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Get a cluster configuration value",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := getClusterConfigStore()
+		if err != nil {
+			return err
+		}
+
+		entry, ok := store.Get(args[0])
+		if !ok {
+			return fmt.Errorf("config key %q not set", args[0])
+		}
+
+		fmt.Println(entry.Value)
+		return nil
+	},
+}
+
+var clusterConfigUnsetCmd = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Remove a cluster configuration value",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := getClusterConfigStore()
+		if err != nil {
+			return err
+		}
+
+		hostname, _ := os.Hostname()
+		if err := store.Delete(args[0], hostname); err != nil {
+			return err
+		}
+
+		fmt.Printf("%s unset\n", args[0])
+		return nil
+	},
+}
+
+var clusterConfigListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List cluster configuration values",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := getClusterConfigStore()
+		if err != nil {
+			return err
+		}
+
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "Key", Key: "key"},
+				{Header: "Value", Key: "value"},
+				{Header: "Version", Key: "version"},
+				{Header: "Updated by", Key: "updated_by"},
+				{Header: "Updated", Key: "updated_at"},
+			},
+			Empty: "No cluster configuration set",
+		}
+		for _, entry := range store.List() {
+			table.AddRow(entry.Key, entry.Value, entry.Version, entry.UpdatedBy, entry.UpdatedAt)
+		}
+		return printer.Table(table)
+	},
+}
+
+func getClusterConfigStore() (*cluster.LocalConfigStore, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	return cluster.NewLocalConfigStore(filepath.Join(homeDir, ".ztap", "cluster-config.json"))
+}
+
+// getNodeSchedules returns the cordoned and drained nodes, by ID; a missing
+// cluster config is treated as no node being cordoned
+func getNodeSchedules() map[string]cluster.NodeSchedule {
+	store, err := getClusterConfigStore()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+	return cluster.NodeSchedules(store)
+}
+
+// nodeInfo builds the advertised metadata for a node running backend
+func nodeInfo(backend, nodeVersion, nodeOS string) cluster.NodeInfo {
+	return cluster.NodeInfo{
+		Version:        nodeVersion,
+		OS:             nodeOS,
+		Backend:        backend,
+		Capabilities:   enforcer.BackendCapabilities(backend),
+		PolicyCapacity: enforcer.BackendPolicyCapacity(backend),
+		PolicySchema:   policy.SchemaVersion,
+		PolicyFeatures: policy.SupportedFeatures(),
+	}
+}
+
+func metadataOrDash(node *cluster.Node, key string) string {
+	if value := node.Metadata[key]; value != "" {
+		return value
+	}
+	return "-"
+}
+
+func capabilitiesString(node *cluster.Node) string {
+	capabilities, ok := node.Capabilities()
+	if !ok || len(capabilities) == 0 {
+		return "-"
+	}
+	return strings.Join(capabilities, ",")
+}
+
+func capacityString(node *cluster.Node) string {
+	if capacity := node.PolicyCapacity(); capacity > 0 {
+		return fmt.Sprintf("%d", capacity)
+	}
+	return "unbounded"
+}
+
 func init() {
 	// Add subcommands to cluster
 	clusterCmd.AddCommand(clusterStatusCmd)
 	clusterCmd.AddCommand(clusterJoinCmd)
 	clusterCmd.AddCommand(clusterLeaveCmd)
 	clusterCmd.AddCommand(clusterListCmd)
+	clusterCmd.AddCommand(clusterScheduleCmd)
+	clusterCmd.AddCommand(clusterConfigCmd)
+
+	clusterConfigCmd.AddCommand(clusterConfigSetCmd)
+	clusterConfigCmd.AddCommand(clusterConfigGetCmd)
+	clusterConfigCmd.AddCommand(clusterConfigUnsetCmd)
+	clusterConfigCmd.AddCommand(clusterConfigListCmd)
+
+	clusterJoinCmd.Flags().String("backend", enforcer.Backend(), "Enforcement backend of the joining node (ebpf or pf)")
+	clusterJoinCmd.Flags().String("node-version", version, "ZTAP version running on the joining node")
+	clusterJoinCmd.Flags().String("os", runtime.GOOS, "Operating system of the joining node")
+	clusterJoinCmd.Flags().StringToString("labels", nil, "Labels of the node, matched by policy nodeSelectors; its service account fetches the policies selecting them")
+	clusterJoinCmd.Flags().Int("policy-schema", policy.SchemaVersion, "Newest policy apiVersion (ztap/v<N>) the joining node understands")
+	clusterJoinCmd.Flags().StringSlice("policy-features", policy.SupportedFeatures(), "Policy schema features the joining node understands")
+	clusterJoinCmd.Flags().Bool("cloud-identity", false, "Add the node's cloud identity (account, role, VPC) from instance metadata")
+
+	clusterScheduleCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
 
 	// Add cluster command to root
 	rootCmd.AddCommand(clusterCmd)
@@ -163,8 +488,9 @@ func init() {
 	// In production, this would be replaced with etcd or Raft backend
 	hostname, _ := os.Hostname()
 	config := cluster.LeaderElectionConfig{
-		NodeID:      hostname,
-		NodeAddress: "127.0.0.1:9090", // Default; should be configurable
+		NodeID:       hostname,
+		NodeAddress:  "127.0.0.1:9090", // Default; should be configurable
+		NodeMetadata: nodeInfo(enforcer.Backend(), version, runtime.GOOS).Metadata(),
 	}
 	clusterElection = cluster.NewInMemoryElection(config)
 
@@ -178,4 +504,7 @@ func init() {
 	if err := clusterElection.Start(ctx); err != nil {
 		log.Printf("Warning: failed to start cluster election: %v", err)
 	}
+	if store, err := getClusterConfigStore(); err == nil {
+		go cluster.ApplyConfig(ctx, store, cluster.FollowDrains(clusterElection))
+	}
 }
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ztap/pkg/profile"
+	"ztap/pkg/render"
+
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:     "config",
+	Aliases: []string{"context"},
+	Short:   "Switch between the environments of config.yaml",
+	Long: `List the contexts of config.yaml and choose the one the CLI uses.
+
+A context is a named profile of an environment:
+
+  contexts:
+    prod:
+      server: https://ztap.example.com  # API server to manage
+      tenant: acme                      # default --tenant
+      region: eu-west-1                 # default --region / --aws-region
+    lab:
+      namespace: payments               # default --namespace
+      discovery:
+        backend: dns                    # memory, dns, consul or kubernetes
+        domain: lab.internal
+
+With a server, user and policy approval commands call its API with the
+session of 'ztap user login' against it, instead of the stores under
+~/.ztap. The namespace limits 'ztap logs' and 'ztap discovery list' to
+workloads labeled namespace=<namespace>, and labels services registered
+without one. Flags on the command line override the context's values, and
+--context (or $ZTAP_CONTEXT) uses another context for one command.`,
+}
+
+var getContextsCmd = &cobra.Command{
+	Use:     "get-contexts",
+	Aliases: []string{"list"},
+	Short:   "List the contexts of config.yaml",
+	Run: func(cmd *cobra.Command, args []string) {
+		contexts, err := profile.LoadContexts(configPath())
+		if err != nil {
+			fail(err)
+		}
+		current := activeContextName()
+
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "Current", Key: "current"},
+				{Header: "Name", Key: "name"},
+				{Header: "Server", Key: "server"},
+				{Header: "Tenant", Key: "tenant"},
+				{Header: "Region", Key: "region"},
+				{Header: "Namespace", Key: "namespace"},
+				{Header: "Discovery", Key: "discovery"},
+			},
+			Empty: "No contexts in " + configPath(),
+		}
+		for _, name := range profile.Names(contexts) {
+			context := contexts[name]
+			marker := ""
+			if name == current {
+				marker = "*"
+			}
+			table.AddRow(marker, name, context.Server, context.Tenant, context.Region, context.Namespace, context.Discovery.Backend)
+		}
+		if err := printer.Table(table); err != nil {
+			fail(err)
+		}
+	},
+}
+
+var useContextCmd = &cobra.Command{
+	Use:     "use-context <name>",
+	Aliases: []string{"use"},
+	Short:   "Use a context for the following commands",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		contexts, err := profile.LoadContexts(configPath())
+		if err != nil {
+			fail(err)
+		}
+		context, ok := contexts[name]
+		if !ok {
+			failf(exitValidation, "no context %q in %s", name, configPath())
+		}
+
+		if err := os.MkdirAll(filepath.Dir(getCurrentContextFile()), 0700); err != nil {
+			fail(err)
+		}
+		if err := os.WriteFile(getCurrentContextFile(), []byte(name+"\n"), 0600); err != nil {
+			fail(fmt.Errorf("failed to save current context: %w", err))
+		}
+		if context.Server != "" {
+			printer.Printf("Switched to context '%s' (%s)\n", name, context.Server)
+			return
+		}
+		printer.Printf("Switched to context '%s'\n", name)
+	},
+}
+
+var currentContextCmd = &cobra.Command{
+	Use:   "current-context",
+	Short: "Print the context in use",
+	Run: func(cmd *cobra.Command, args []string) {
+		name := activeContextName()
+		if name == "" {
+			failf(exitValidation, "no context in use")
+		}
+		printer.Printf("%s\n", name)
+	},
+}
+
+var unsetContextCmd = &cobra.Command{
+	Use:     "unset-context",
+	Aliases: []string{"clear"},
+	Short:   "Stop using a context: manage this host's stores with the defaults",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := os.Remove(getCurrentContextFile()); err != nil && !os.IsNotExist(err) {
+			fail(err)
+		}
+		printer.Printf("No context in use\n")
+	},
+}
+
+// contextFlag is --context
+var contextFlag string
+
+// activeNamespace is the namespace commands act in (--namespace, or the
+// context's); empty for every namespace
+var activeNamespace string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&contextFlag, "context", os.Getenv("ZTAP_CONTEXT"), "Context of config.yaml to use instead of the current one")
+	rootCmd.PersistentFlags().StringVar(&activeNamespace, "namespace", "", "Only show and register workloads labeled namespace=<namespace> (default: the context's)")
+
+	configCmd.AddCommand(getContextsCmd)
+	configCmd.AddCommand(useContextCmd)
+	configCmd.AddCommand(currentContextCmd)
+	configCmd.AddCommand(unsetContextCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func getCurrentContextFile() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".ztap", "context")
+}
+
+// activeContextName returns the name of the context in use: --context (or
+// $ZTAP_CONTEXT), otherwise the one chosen with 'ztap config use-context'.
+// It is empty if none is.
+func activeContextName() string {
+	if contextFlag != "" {
+		return contextFlag
+	}
+	data, err := os.ReadFile(getCurrentContextFile())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// activeContext returns the context in use, the zero context if none is
+func activeContext() profile.Context {
+	name := activeContextName()
+	if name == "" {
+		return profile.Context{}
+	}
+	contexts, err := profile.LoadContexts(configPath())
+	if err != nil {
+		fail(err)
+	}
+	context, ok := contexts[name]
+	if !ok {
+		failf(exitValidation, "context %q is not in %s; run 'ztap config use-context' or 'ztap config unset-context'", name, configPath())
+	}
+	return context
+}
+
+// applyContext sets the defaults of cmd's flags from the context in use:
+// --tenant (unless $ZTAP_TENANT is set), --namespace, and --region and
+// --aws-region. Flags given on the command line are kept. The config
+// commands themselves do not need a valid context.
+func applyContext(cmd *cobra.Command) {
+	if cmd.HasParent() && cmd.Parent() == configCmd {
+		return
+	}
+	context := activeContext()
+
+	if context.Tenant != "" && !cmd.Flags().Changed("tenant") && os.Getenv("ZTAP_TENANT") == "" {
+		activeTenant = context.Tenant
+	}
+	if context.Namespace != "" && !cmd.Flags().Changed("namespace") {
+		activeNamespace = context.Namespace
+	}
+	if context.Region != "" {
+		for _, name := range []string{"region", "aws-region"} {
+			if flag := cmd.Flags().Lookup(name); flag != nil && !flag.Changed {
+				flag.Value.Set(context.Region)
+			}
+		}
+	}
+}
+
+// inNamespace reports whether labels are in the active namespace
+func inNamespace(labels map[string]string) bool {
+	return activeNamespace == "" || labels["namespace"] == activeNamespace
+}
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"ztap/pkg/coverage"
+	"ztap/pkg/discovery"
+	"ztap/pkg/policy"
+
+	"github.com/spf13/cobra"
+)
+
+var coverageCmd = &cobra.Command{
+	Use:   "coverage -f policy.yaml",
+	Short: "Report workloads that no policy covers",
+	Long: `Cross-reference discovered workloads with the podSelectors of the policies
+in a policy file and report which workloads are covered by no policy, grouped
+by label. Workloads come from registered services, --workloads (a YAML list of
+name, ip and labels) and, with --aws-region or --aws-accounts, AWS inventory.
+
+A workload is:
+
+  enforced      selected by at least one unexpired policy
+  monitor-only  selected, but break-glass has suspended enforcement
+  uncovered     selected by no policy
+
+Use --fail-under in CI to fail when the enforced share drops below a target.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policyFile, _ := cmd.Flags().GetString("file")
+		workloadsFile, _ := cmd.Flags().GetString("workloads")
+		groupBy, _ := cmd.Flags().GetStringSlice("group-by")
+		output, _ := cmd.Flags().GetString("output")
+		showAll, _ := cmd.Flags().GetBool("all")
+		failUnder, _ := cmd.Flags().GetFloat64("fail-under")
+
+		policies, err := policy.LoadFromFile(policyFile)
+		if err != nil {
+			fail(validationErrorf("failed to load policies: %w", err))
+		}
+
+		workloads, err := collectWorkloads(cmd, workloadsFile)
+		if err != nil {
+			fail(err)
+		}
+		if len(workloads) == 0 {
+			failf(exitValidation, "no workloads found; pass --workloads or --aws-region/--aws-accounts")
+		}
+
+		opts := coverage.Options{GroupBy: groupBy}
+		if store, err := getClusterConfigStore(); err == nil {
+			_, opts.Monitor = activeBreakGlass(store)
+		}
+		report := coverage.Analyze(policies, workloads, opts)
+
+		switch output {
+		case "table":
+			printCoverage(report, showAll)
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(report)
+		default:
+			err = validationErrorf("unknown output format %q (use table or json)", output)
+		}
+		if err != nil {
+			fail(err)
+		}
+
+		if failUnder > 0 && report.Percent() < failUnder {
+			failf(exitFailure, "coverage %.1f%% is below %.1f%%", report.Percent(), failUnder)
+		}
+	},
+}
+
+// collectWorkloads collects workloads from service discovery, the
+// workloads file and cloud inventory
+func collectWorkloads(cmd *cobra.Command, workloadsFile string) ([]coverage.Workload, error) {
+	var workloads []coverage.Workload
+	if mem, ok := getDiscoveryBackend().(*discovery.InMemoryDiscovery); ok {
+		for _, s := range mem.ListServices() {
+			workloads = append(workloads, coverage.Workload{Name: s.Name, IP: s.IP, Labels: s.Labels, Source: "discovery"})
+		}
+	}
+
+	if workloadsFile != "" {
+		loaded, err := coverage.LoadWorkloads(workloadsFile)
+		if err != nil {
+			return nil, err
+		}
+		workloads = append(workloads, loaded...)
+	}
+
+	_, inventory, err := newPolicyResolver(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if inventory != nil {
+		for _, r := range inventory.Resources() {
+			name := r.Name
+			if name == "" {
+				name = r.ID
+			}
+			workloads = append(workloads, coverage.Workload{Name: name, IP: r.PrivateIP, Labels: r.Labels, Source: "aws"})
+		}
+	}
+	return workloads, nil
+}
+
+func printCoverage(report *coverage.Report, showAll bool) {
+	fmt.Println("Policy Coverage")
+	fmt.Println("===============")
+	if report.Monitor {
+		fmt.Println("Break-glass is active: selected workloads are only monitored")
+	}
+	for _, name := range report.Expired {
+		fmt.Printf("Ignoring expired policy %s\n", name)
+	}
+	fmt.Printf("\nWorkloads:    %d\n", report.Total)
+	fmt.Printf("Enforced:     %d (%.1f%%)\n", report.Enforced, report.Percent())
+	fmt.Printf("Monitor-only: %d\n", report.MonitorOnly)
+	fmt.Printf("Uncovered:    %d\n", report.Uncovered)
+
+	if len(report.Groups) > 0 {
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "GROUP\tTOTAL\tENFORCED\tMONITOR-ONLY\tUNCOVERED\tCOVERAGE")
+		for _, g := range report.Groups {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%.1f%%\n",
+				g.Key, g.Total, g.Enforced, g.MonitorOnly, g.Uncovered, g.Percent())
+		}
+		w.Flush()
+	}
+
+	title := "Uncovered workloads:"
+	if showAll {
+		title = "Workloads:"
+	}
+	fmt.Println()
+	fmt.Println(title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  NAME\tIP\tSOURCE\tSTATUS\tLABELS\tPOLICIES")
+	listed := 0
+	for _, wc := range report.Workloads {
+		if !showAll && wc.Status != coverage.StatusUncovered {
+			continue
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%s\n",
+			wc.Name, wc.IP, wc.Source, wc.Status, formatLabels(wc.Labels), strings.Join(wc.Policies, ","))
+		listed++
+	}
+	w.Flush()
+	if listed == 0 {
+		fmt.Println("  None")
+	}
+}
+
+// formatLabels renders labels as sorted key=value pairs
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func init() {
+	coverageCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
+	coverageCmd.Flags().String("workloads", "", "YAML list of workloads (name, ip, labels) to check besides registered services")
+	coverageCmd.Flags().StringSlice("group-by", []string{"app"}, "Label keys to break coverage down by")
+	coverageCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+	coverageCmd.Flags().Bool("all", false, "List every workload, not only uncovered ones")
+	coverageCmd.Flags().Float64("fail-under", 0, "Exit non-zero if less than this percentage of workloads is enforced")
+	coverageCmd.Flags().String("aws-region", "", "Also check AWS resources in this region")
+	coverageCmd.Flags().String("aws-accounts", "", "Also check AWS resources in the accounts and regions of this YAML file")
+	rootCmd.AddCommand(coverageCmd)
+}
@@ -1,11 +1,18 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
 	"os"
+	"strings"
 	"text/tabwriter"
+	"time"
 
+	"ztap/pkg/cloud"
 	"ztap/pkg/discovery"
+	"ztap/pkg/events"
+	"ztap/pkg/profile"
 
 	"github.com/spf13/cobra"
 )
@@ -25,6 +32,25 @@ var registerCmd = &cobra.Command{
 		ip := args[1]
 
 		labels, _ := cmd.Flags().GetStringToString("labels")
+		cloudIdentity, _ := cmd.Flags().GetBool("cloud-identity")
+
+		if cloudIdentity {
+			var err error
+			if labels, err = withCloudIdentity(labels); err != nil {
+				return fmt.Errorf("failed to detect cloud identity: %w", err)
+			}
+		}
+
+		if activeNamespace != "" && labels["namespace"] == "" {
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels["namespace"] = activeNamespace
+		}
+
+		if err := admitService(name); err != nil {
+			return err
+		}
 
 		disc := getDiscoveryBackend()
 		err := disc.RegisterService(name, ip, labels)
@@ -92,6 +118,14 @@ var listServicesCmd = &cobra.Command{
 		}
 
 		services := memDisc.ListServices()
+		session := loginSession()
+		visible := services[:0]
+		for _, service := range services {
+			if inNamespace(service.Labels) && (session == nil || session.Sees(service.Labels)) {
+				visible = append(visible, service)
+			}
+		}
+		services = visible
 		if len(services) == 0 {
 			fmt.Println("No services registered")
 			return nil
@@ -120,6 +154,165 @@ var listServicesCmd = &cobra.Command{
 	},
 }
 
+var localCmd = &cobra.Command{
+	Use:   "local",
+	Short: "List (and register) the services this host listens on",
+	Long: `Collect the listening TCP and UDP sockets of this host, with the process
+and binary owning each, on Linux (/proc), macOS (lsof) and Windows
+(PowerShell). Owners of other users' sockets are only shown when run as root
+(Administrator).
+
+With --register, every process, protocol and port reachable from other
+hosts is registered into discovery as <host>/<process>/<protocol>-<port>,
+labeled ztap:host, ztap:process, ztap:binary, ztap:protocol and ztap:port.
+Listeners on a wildcard address are registered with --ip (default: the
+host's first global unicast address); loopback-only listeners are skipped.
+Policies can then select what a host actually serves:
+
+  ztap discovery local --register --labels app=db
+  ztap discovery resolve --labels ztap:process=postgres,ztap:port=5432`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		register, _ := cmd.Flags().GetBool("register")
+		output, _ := cmd.Flags().GetString("output")
+		hostIP, _ := cmd.Flags().GetString("ip")
+		extra, _ := cmd.Flags().GetStringToString("labels")
+
+		listeners, err := discovery.CollectListeners(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		switch output {
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PROTO\tADDRESS\tPORT\tPID\tPROCESS\tBINARY")
+			for _, l := range listeners {
+				pid := "-"
+				if l.PID > 0 {
+					pid = fmt.Sprintf("%d", l.PID)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", l.Protocol, l.Address, l.Port, pid, valueOrDash(l.Process), valueOrDash(l.Binary))
+			}
+			w.Flush()
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(listeners); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown output format %q (use table or json)", output)
+		}
+
+		if !register {
+			return nil
+		}
+		host, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get host name: %w", err)
+		}
+		if hostIP == "" {
+			if hostIP, err = discovery.HostIP(); err != nil {
+				return fmt.Errorf("failed to find host IP (pass --ip): %w", err)
+			}
+		}
+
+		disc := getDiscoveryBackend()
+		services := discovery.LocalServices(host, hostIP, listeners)
+		for _, service := range services {
+			for key, value := range extra {
+				service.Labels[key] = value
+			}
+			if err := admitService(service.Name); err != nil {
+				return err
+			}
+			if err := disc.RegisterService(service.Name, service.IP, service.Labels); err != nil {
+				return fmt.Errorf("failed to register service %s: %w", service.Name, err)
+			}
+		}
+		if output == "table" {
+			fmt.Printf("Registered %d service(s) from %s\n", len(services), host)
+		}
+		return nil
+	},
+}
+
+var prometheusCmd = &cobra.Command{
+	Use:   "prometheus [--port port] [--labels key=value] [--file targets.json]",
+	Short: "Export registered services as Prometheus scrape targets",
+	Long: `Export the registered services as Prometheus file_sd target groups, so
+monitoring covers the same workloads segmentation does. Each service is a
+target group labeled __meta_ztap_service and __meta_ztap_label_<label>, its
+label names with characters other than letters, digits and underscores
+replaced, e.g. __meta_ztap_label_ztap_account.
+
+Targets are scraped on --port, or else on the service's ztap:port label (see
+'ztap discovery local'); services with neither are skipped with a warning,
+rather than scraped on a default port. Like 'ztap discovery list', only services in the
+active namespace and the logged-in user's scopes are exported.
+
+With --file the target groups are written atomically for a file_sd_configs
+entry; otherwise they are printed:
+
+  ztap discovery prometheus --port 9100 --file /etc/prometheus/targets/ztap.json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		port, _ := cmd.Flags().GetInt("port")
+		labels, _ := cmd.Flags().GetStringToString("labels")
+		file, _ := cmd.Flags().GetString("file")
+		if port < 0 || port > 65535 {
+			failf(exitValidation, "--port must be between 1 and 65535, or 0 to use each service's ztap:port label")
+		}
+
+		memDisc, ok := getDiscoveryBackend().(*discovery.InMemoryDiscovery)
+		if !ok {
+			failf(exitValidation, "prometheus command only works with in-memory discovery")
+		}
+		session := loginSession()
+		var services []*discovery.Service
+		for _, service := range memDisc.ListServices() {
+			if hasLabels(service.Labels, labels) && inNamespace(service.Labels) && (session == nil || session.Sees(service.Labels)) {
+				services = append(services, service)
+			}
+		}
+		groups, skipped := discovery.PrometheusTargets(services, port)
+		if len(skipped) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: Skipped %d service(s) without a ztap:port label (pass --port): %s\n",
+				len(skipped), strings.Join(skipped, ", "))
+		}
+
+		if file != "" {
+			if err := discovery.WriteTargetFile(file, groups); err != nil {
+				fail(err)
+			}
+			fmt.Fprintf(os.Stderr, "Wrote %d target group(s) to %s\n", len(groups), file)
+			return
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(groups); err != nil {
+			fail(err)
+		}
+	},
+}
+
+// hasLabels reports whether labels include every key and value of selector
+func hasLabels(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// valueOrDash returns value, or "-" if it is empty
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
 func init() {
 	rootCmd.AddCommand(discoveryCmd)
 
@@ -127,20 +320,100 @@ func init() {
 	discoveryCmd.AddCommand(deregisterCmd)
 	discoveryCmd.AddCommand(resolveCmd)
 	discoveryCmd.AddCommand(listServicesCmd)
+	discoveryCmd.AddCommand(localCmd)
+	discoveryCmd.AddCommand(prometheusCmd)
 
 	// Flags
 	registerCmd.Flags().StringToString("labels", map[string]string{}, "Service labels (key=value)")
+	registerCmd.Flags().Bool("cloud-identity", false, "Add ztap:account, ztap:role, ztap:vpc, etc. labels from cloud instance metadata")
 	resolveCmd.Flags().StringToString("labels", map[string]string{}, "Labels to resolve (key=value)")
+	localCmd.Flags().Bool("register", false, "Register the listening services into discovery")
+	localCmd.Flags().String("ip", "", "IP to register wildcard listeners with (default: the host's first global unicast address)")
+	localCmd.Flags().StringToString("labels", map[string]string{}, "Labels added to every registered service (key=value)")
+	localCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+	prometheusCmd.Flags().Int("port", 0, "Port to scrape every target on (default: the service's ztap:port label)")
+	prometheusCmd.Flags().StringToString("labels", map[string]string{}, "Only export services with these labels (key=value)")
+	prometheusCmd.Flags().StringP("file", "f", "", "Write the target groups to this file_sd file instead of printing them")
 }
 
-// getDiscoveryBackend returns the configured discovery backend
+// getDiscoveryBackend returns the discovery backend of the active context
+// (in-memory by default). With storage.redis.discovery_cache_ttl set,
+// resolutions are cached in Redis and shared with other ztap servers.
 func getDiscoveryBackend() discovery.ServiceDiscovery {
-	// TODO: Read from config.yaml to support different backends
-	// For now, use in-memory
 	if globalDiscovery == nil {
-		globalDiscovery = discovery.NewInMemoryDiscovery()
+		config := activeContext().Discovery
+		switch config.Backend {
+		case profile.DiscoveryDNS:
+			globalDiscovery = discovery.NewDNSDiscovery(config.Domain)
+		case profile.DiscoveryConsul:
+			globalDiscovery = discovery.NewConsulDiscovery(config.Address)
+		case profile.DiscoveryKubernetes:
+			globalDiscovery = discovery.NewK8sDiscovery(activeNamespace)
+		default:
+			globalDiscovery = discovery.NewInMemoryDiscovery()
+		}
+		if cache, ttl := getDiscoveryCache(); cache != nil {
+			globalDiscovery = discovery.NewCacheDiscoveryWithCache(globalDiscovery, ttl, cache)
+		}
 	}
 	return globalDiscovery
 }
 
+// getDiscoveryCache returns the shared discovery cache, or nil if none is
+// configured or Redis is unreachable
+func getDiscoveryCache() (discovery.Cache, time.Duration) {
+	config, err := getStorageConfig()
+	if err != nil || config.Redis == nil || config.Redis.DiscoveryCacheTTL <= 0 {
+		return nil, 0
+	}
+	redis, err := getRedisStore(*config.Redis)
+	if err != nil {
+		log.Printf("Warning: Shared discovery cache disabled: %v", err)
+		return nil, 0
+	}
+	return redis, config.Redis.DiscoveryCacheTTL
+}
+
 var globalDiscovery discovery.ServiceDiscovery
+
+// attributionTTL is how long cloud inventory lookups of unregistered IPs are
+// cached
+const attributionTTL = time.Minute
+
+// newAttributor returns an attributor that knows the services registered in
+// the event journal, and follows those registered by this process. IPs no
+// registration covers are looked up in inventory, if given.
+func newAttributor(inventory *cloud.Inventory) *discovery.Attributor {
+	var resolver discovery.Resolver
+	if inventory != nil {
+		resolver = func(ip string) (discovery.Attribution, bool) {
+			for _, r := range inventory.Resources() {
+				if r.PrivateIP == ip {
+					name := r.Name
+					if name == "" {
+						name = r.ID
+					}
+					return discovery.Attribution{Service: name, Labels: r.Labels}, true
+				}
+			}
+			return discovery.Attribution{}, false
+		}
+	}
+
+	attributor := discovery.NewAttributor(resolver, attributionTTL)
+	history, err := eventJournal.History(time.Time{}, events.TopicServiceChanged)
+	if err != nil {
+		log.Printf("Warning: Failed to read service history: %v", err)
+	}
+	attributor.Load(history)
+	attributor.Record(events.Default())
+	return attributor
+}
+
+// formatEndpoint formats an IP with the service that held it, if known
+func formatEndpoint(ip, service string) string {
+	if service == "" {
+		return ip
+	}
+	return ip + " (" + service + ")"
+}
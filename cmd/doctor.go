@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"ztap/pkg/enforcer"
+	"ztap/pkg/policy"
+
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor [-f policy.yaml]",
+	Short: "Check the host for problems that affect enforcement",
+	Long: `Inspect the host for conditions that keep ZTAP's rules from taking
+effect. On Linux, the capabilities each eBPF operation needs (CAP_BPF,
+CAP_PERFMON, CAP_NET_ADMIN, ...) are checked, so the agent can run as a
+non-root user with file capabilities or systemd AmbientCapabilities, and the
+cgroup layout is reported: the filter attaches to cgroup v2, through the v2
+hierarchy on hybrid hosts and a hierarchy ZTAP mounts at /run/ztap/cgroup2 on
+cgroup v1-only hosts (which needs CAP_SYS_ADMIN). The host
+firewall (iptables and nftables on Linux, pf on macOS) is
+inventoried, and with -f each compiled rule is checked against it:
+
+  blocked  the host firewall drops traffic the policy allows
+  bypass   a broader host accept decides before ZTAP's rules (pf quick
+           rules ahead of the ztap anchor)
+
+Listing firewall rules usually requires root. Exits non-zero if a conflict
+is found.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policyFile, _ := cmd.Flags().GetString("file")
+
+		fmt.Println("ZTAP Doctor")
+		fmt.Println("===========")
+		fmt.Println()
+		fmt.Printf("Enforcement backend: %s\n", enforcer.Backend())
+		printPrivileges()
+		printCgroups()
+
+		host, err := enforcer.ScanHostFirewall()
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		printHostFirewall(host)
+
+		if policyFile == "" {
+			fmt.Println("\nPolicy conflicts: (use -f to check a policy file)")
+			return
+		}
+
+		compiled, err := compilePolicyFile(cmd, policyFile)
+		if err != nil {
+			fail(err)
+		}
+		conflicts := enforcer.DetectConflicts(host, compiled)
+		fmt.Printf("\nPolicy conflicts (%s):\n", policyFile)
+		if len(conflicts) == 0 {
+			fmt.Println("  None")
+			return
+		}
+		for _, c := range conflicts {
+			fmt.Printf("  [%s] %s\n", c.Kind, c)
+		}
+		os.Exit(exitFailure)
+	},
+}
+
+func init() {
+	doctorCmd.Flags().StringP("file", "f", "", "Check the compiled rules of this policy file against the host firewall")
+	doctorCmd.Flags().String("aws-region", "", "Also resolve label selectors against AWS resources in this region")
+	doctorCmd.Flags().String("aws-accounts", "", "Also resolve label selectors against AWS resources in the accounts and regions of this YAML file")
+	rootCmd.AddCommand(doctorCmd)
+}
+
+// printPrivileges reports which privileged backend operations the process
+// can perform
+func printPrivileges() {
+	if !enforcer.IsLinux() {
+		if os.Geteuid() == 0 {
+			fmt.Println("Privileges: root")
+		} else {
+			fmt.Println("Privileges: not root (pf enforcement requires root)")
+		}
+		return
+	}
+
+	caps, err := enforcer.EffectiveCapabilities()
+	if err != nil {
+		fmt.Printf("Privileges: unknown (%v)\n", err)
+		return
+	}
+	fmt.Println("Privileges:")
+	for _, op := range enforcer.Operations {
+		missing := caps.Missing(op)
+		if len(missing) == 0 {
+			fmt.Printf("  OK       %s\n", op)
+			continue
+		}
+		names := make([]string, len(missing))
+		for i, c := range missing {
+			names[i] = c.Name
+		}
+		fmt.Printf("  MISSING  %s: needs %s\n", op, strings.Join(names, ", "))
+	}
+}
+
+// printCgroups reports the cgroup layout and how the eBPF filter attaches on
+// it
+func printCgroups() {
+	if !enforcer.IsLinux() {
+		return
+	}
+	layout, err := enforcer.DetectCgroupLayout()
+	if err != nil {
+		fmt.Printf("Cgroups: unknown (%v)\n", err)
+		return
+	}
+	fmt.Printf("Cgroups: %s\n", layout)
+	switch layout.Mode {
+	case enforcer.CgroupHybrid:
+		fmt.Printf("  The filter attaches through %s; cgroup v1 paths map to the same cgroup there\n", layout.V2Mount)
+	case enforcer.CgroupLegacy:
+		fmt.Printf("  Compatibility mode: the filter attaches to a cgroup v2 hierarchy mounted at %s, covering the whole host\n", enforcer.CompatCgroupPath)
+		if caps, err := enforcer.EffectiveCapabilities(); err == nil && len(caps.Missing(enforcer.OpMountCgroup)) > 0 {
+			fmt.Printf("  MISSING  %s: needs CAP_SYS_ADMIN (or boot with systemd.unified_cgroup_hierarchy=1)\n", enforcer.OpMountCgroup)
+		}
+	case enforcer.CgroupNone:
+		fmt.Println("  The eBPF backend needs a cgroup v2 hierarchy: mount -t cgroup2 none /sys/fs/cgroup")
+	}
+}
+
+// printHostFirewall summarizes the host firewall rules per firewall
+func printHostFirewall(host []enforcer.HostRule) {
+	counts := make(map[string]int)
+	anchored := false
+	for _, r := range host {
+		counts[r.Firewall]++
+		if r.Action == enforcer.HostAnchor {
+			anchored = true
+		}
+	}
+	if len(host) == 0 {
+		fmt.Println("Host firewall: no egress rules found")
+		return
+	}
+
+	fmt.Printf("Host firewall: %d egress rule(s)\n", len(host))
+	for _, fw := range []string{enforcer.FirewallIptables, enforcer.FirewallNftables, enforcer.FirewallPF} {
+		if counts[fw] > 0 {
+			fmt.Printf("  %s: %d\n", fw, counts[fw])
+		}
+	}
+	if counts[enforcer.FirewallPF] > 0 && !anchored {
+		fmt.Println("  Warning: the ztap anchor is not loaded in the pf ruleset")
+	}
+}
+
+// compilePolicyFile compiles every policy in file, resolving selectors like
+// 'ztap enforce' does
+func compilePolicyFile(cmd *cobra.Command, file string) ([]*policy.CompiledPolicy, error) {
+	policies, err := policy.LoadFromFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy: %w", err)
+	}
+	resolver, _, err := newPolicyResolver(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := make([]*policy.CompiledPolicy, 0, len(policies))
+	for _, p := range policies {
+		c, err := resolver.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// reportHostConflicts logs host firewall rules that change the effect of
+// freshly enforced rules. The scan is best effort: doctor reports why the
+// host firewall could not be read.
+func reportHostConflicts(compiled []*policy.CompiledPolicy) {
+	host, _ := enforcer.ScanHostFirewall()
+	for _, c := range enforcer.DetectConflicts(host, compiled) {
+		log.Printf("Warning: Host firewall conflict [%s]: %s", c.Kind, c)
+	}
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+
+	"github.com/spf13/cobra"
+)
+
+var clusterCordonCmd = &cobra.Command{
+	Use:   "cordon <node-id>",
+	Short: "Stop assigning new policies to a node",
+	Long: `Cordon a node: its agent ('ztap agent --node <node-id>') keeps enforcing,
+and updating, the policies it already enforces, but not policies new to it.
+'ztap cluster schedule' marks the node as cordoned. Undo with
+'ztap cluster uncordon'.
+
+Cordoning requires a 'ztap user login' session with the operator or admin
+role. The state is stored in the cluster config (cordon.<node-id>).`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		node := args[0]
+		store, session := nodeScheduleStore(node)
+		if _, err := store.Set(cluster.ConfigCordonPrefix+node, "true", session.Username); err != nil {
+			fail(err)
+		}
+		printer.Printf("Node %s cordoned\n", node)
+	},
+}
+
+var clusterUncordonCmd = &cobra.Command{
+	Use:   "uncordon <node-id>",
+	Short: "Resume assigning policies to a cordoned or drained node",
+	Long: `Uncordon a node cordoned with 'ztap cluster cordon' or drained with 'ztap
+cluster drain'. Its agent enforces every policy assigned to it again, and a
+drained node may become leader again.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		node := args[0]
+		store, session := nodeScheduleStore(node)
+
+		schedule := cluster.NodeSchedules(store)[node]
+		if !schedule.Cordoned && !schedule.Drained() {
+			printer.Printf("Node %s is not cordoned\n", node)
+			return
+		}
+		follow := cluster.FollowDrains(clusterElection)
+		for _, key := range []string{cluster.ConfigDrainPrefix + node, cluster.ConfigCordonPrefix + node} {
+			if _, ok := store.Get(key); !ok {
+				continue
+			}
+			if err := store.Delete(key, session.Username); err != nil {
+				fail(err)
+			}
+			follow(cluster.ConfigEntry{Key: key, Deleted: true})
+		}
+		printer.Printf("Node %s uncordoned\n", node)
+	},
+}
+
+var clusterDrainCmd = &cobra.Command{
+	Use:   "drain <node-id> [--mode monitor|keep]",
+	Short: "Prepare a node for maintenance",
+	Long: `Drain a node before host maintenance. A drained node is cordoned, cannot
+become leader (the current leader steps down), and its agent switches to a
+safe enforcement state chosen with --mode:
+
+  monitor  Remove every rule: traffic is allowed and only logged (fail-open),
+           so maintenance cannot be disrupted by enforcement
+  keep     Keep the last enforced rules and ignore policy and endpoint
+           changes until the node is uncordoned
+
+Undo with 'ztap cluster uncordon', which restores enforcement of every policy
+assigned to the node.
+
+Draining requires a 'ztap user login' session with the operator or admin role.
+The state is stored in the cluster config (drain.<node-id>).
+
+  ztap cluster drain web-1 --mode monitor`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		node := args[0]
+		mode, _ := cmd.Flags().GetString("mode")
+		if _, err := cluster.ParseDrainMode(mode); err != nil {
+			fail(err)
+		}
+
+		store, session := nodeScheduleStore(node)
+		entry, err := store.Set(cluster.ConfigDrainPrefix+node, mode, session.Username)
+		if err != nil {
+			fail(err)
+		}
+		cluster.FollowDrains(clusterElection)(entry)
+
+		printer.Printf("Node %s drained (%s)\n", node, mode)
+		if mode == string(cluster.DrainMonitor) {
+			printer.Printf("Its agent removes every rule; traffic is only monitored until it is uncordoned\n")
+		} else {
+			printer.Printf("Its agent keeps its last enforced rules until it is uncordoned\n")
+		}
+	},
+}
+
+// nodeScheduleStore checks that node is a valid node ID and the caller may
+// cordon it, and returns the cluster config store, exiting on error
+func nodeScheduleStore(node string) (*cluster.LocalConfigStore, *auth.Session) {
+	if err := auth.ValidateNode(node); err != nil {
+		fail(err)
+	}
+	session, err := requireSession(auth.PermEnforce)
+	if err != nil {
+		fail(err)
+	}
+	store, err := getClusterConfigStore()
+	if err != nil {
+		fail(err)
+	}
+	return store, session
+}
+
+func init() {
+	clusterDrainCmd.Flags().String("mode", string(cluster.DrainMonitor), "Enforcement state of the drained node: monitor (remove rules, fail-open) or keep (last enforced rules)")
+
+	clusterCmd.AddCommand(clusterCordonCmd)
+	clusterCmd.AddCommand(clusterUncordonCmd)
+	clusterCmd.AddCommand(clusterDrainCmd)
+}
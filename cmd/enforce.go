@@ -1,11 +1,22 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"time"
 
+	"ztap/pkg/agent"
+	"ztap/pkg/applied"
+	"ztap/pkg/auth"
+	"ztap/pkg/bulk"
 	"ztap/pkg/enforcer"
+	"ztap/pkg/events"
+	"ztap/pkg/hooks"
+	"ztap/pkg/metrics"
 	"ztap/pkg/policy"
+	"ztap/pkg/render"
 
 	"github.com/spf13/cobra"
 )
@@ -13,29 +24,212 @@ import (
 var enforceCmd = &cobra.Command{
 	Use:   "enforce -f policy.yaml",
 	Short: "Enforce zero-trust network policies",
+	Long: `Compile and enforce a policy file once. Label selectors are resolved
+through service discovery before enforcement. With --aws-region or
+--aws-accounts, EC2 instances and containers with matching tags are merged in,
+so app=web covers both locally registered hosts and AWS workloads.
+
+To keep enforcement in sync as policies and endpoints change, run
+'ztap agent' instead. Policies already past their metadata.expiresAt are
+skipped, but only the agent removes temporary policies once they expire.
+
+Policies are compiled by a pool of --concurrency workers, with a line printed
+as each completes, and a summary table is printed at the end. The command
+exits non-zero if any policy failed to compile or the backend failed to apply
+the set; the policies that compiled are still enforced.
+
+After enforcement, rules the host firewall overrides are logged as
+warnings; 'ztap doctor' explains them.
+
+Hooks in the hooks section of config.yaml extend enforcement: pre-compile
+hooks rewrite the policies, an enforcer hook replaces the eBPF/pf backend, and
+post-apply hooks are notified of the result.
+
+The enforced file is recorded with the Git commit and author it was read at,
+when it is in a Git checkout; 'ztap policy diff' compares it with the
+working tree or another ref.
+
+Policies are applied after the policies their metadata.dependsOn lists, e.g.
+an application's default deny after the infrastructure allow rules it relies
+on; a policy whose dependency failed to compile keeps its enforced rules, or
+is not enforced if new. --dry-run prints the resulting order, step by step,
+with the rules each policy compiles to, and enforces nothing.
+
+Nothing is enforced while 'ztap breakglass' is active.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		if store, err := getClusterConfigStore(); err == nil && !dryRun {
+			if entry, active := activeBreakGlass(store); active {
+				failf(exitEnforcement, "break-glass enabled by %s is active until %s; enforcement is suspended", entry.UpdatedBy, entry.Value)
+			}
+		}
+
 		policyFile, _ := cmd.Flags().GetString("file")
-		policies, err := policy.LoadFromFile(policyFile)
+		snapshot, err := applied.Snapshot(policyFile)
+		if err != nil {
+			fail(validationErrorf("failed to load policy: %w", err))
+		}
+		policies, err := policy.Parse([]byte(snapshot.YAML))
 		if err != nil {
-			log.Fatalf("Failed to load policy: %v", err)
+			fail(validationErrorf("failed to load policy: %w", err))
 		}
 
-		fmt.Printf("Loaded %d policy(ies) from %s\n", len(policies), policyFile)
+		if err := policy.CheckDependencies(policies); err != nil {
+			fail(err)
+		}
 
-		// Detect OS and choose enforcer
-		if enforcer.IsLinux() {
-			fmt.Println("Enforcing via eBPF (Linux)...")
-			enforcer.EnforceWithEBPF(policies)
-		} else {
-			fmt.Println("Enforcing via pf (macOS)...")
-			enforcer.EnforceWithPF(policies)
+		printer.Printf("Loaded %d policy(ies) from %s\n", len(policies), policyFile)
+		for _, p := range policies {
+			if p.Metadata.ExpiresAt != "" || p.Metadata.TTL != "" {
+				log.Printf("Warning: Policy %s is temporary; only 'ztap agent' removes it when it expires", p.Metadata.Name)
+			}
+		}
+
+		resolver, _, err := newPolicyResolver(cmd)
+		if err != nil {
+			fail(err)
+		}
+		if dryRun {
+			if err := printApplyOrder(resolver, policies); err != nil {
+				fail(err)
+			}
+			return
 		}
 
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		a, err := newAgent(resolver, concurrency)
+		if err != nil {
+			fail(err)
+		}
+		setSelfProtection(cmd, a)
+		principal := currentPrincipal()
+		reporter := bulk.NewReporter(os.Stdout, "compiled", len(policies))
+		ctx := bulk.WithReporter(auth.WithPrincipal(cmd.Context(), principal), reporter)
+		_, err = a.Reconcile(ctx, policies)
+		fmt.Println()
+		reporter.WriteSummary(os.Stdout)
+		if err != nil {
+			// Policies that failed to compile are listed in the summary;
+			// anything else, such as a backend failure, is not
+			if _, compileOnly := err.(*policy.ApplyError); !compileOnly || reporter.Failed() == 0 {
+				fail(enforcementError(err))
+			}
+			failBulk(reporter, exitFailure)
+		}
+		saveApplied(snapshot, principal)
+
 		fmt.Println("Enforcement complete.")
 	},
 }
 
 func init() {
 	enforceCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
+	addSelfProtectionFlags(enforceCmd)
+	enforceCmd.Flags().Bool("dry-run", false, "Print the order policies would be applied in and their compiled rules, without enforcing")
+	enforceCmd.Flags().Int("concurrency", 4, "Number of policies compiled in parallel; the backend then applies the compiled set at once")
+	enforceCmd.Flags().String("aws-region", "", "Also resolve label selectors against AWS resources in this region")
+	enforceCmd.Flags().String("aws-accounts", "", "Also resolve label selectors against AWS resources in the accounts and regions of this YAML file")
 	rootCmd.AddCommand(enforceCmd)
 }
+
+// printApplyOrder prints the steps policies would be applied in (see
+// policy.Levels): each step's policies depend only on earlier steps
+func printApplyOrder(resolver *policy.PolicyResolver, policies []policy.NetworkPolicy) error {
+	levels, err := policy.Levels(policies)
+	if err != nil {
+		return err
+	}
+	table := render.Table{
+		Columns: []render.Column{
+			{Header: "Step", Key: "step"},
+			{Header: "Policy", Key: "policy"},
+			{Header: "Depends On", Key: "depends_on"},
+			{Header: "Rules", Key: "rules"},
+			{Header: "Error", Key: "error"},
+		},
+		Empty: "No policies",
+	}
+	for i, level := range levels {
+		for _, p := range level {
+			rules, compileErr := 0, ""
+			if compiled, err := resolver.Compile(p); err != nil {
+				compileErr = err.Error()
+			} else {
+				rules = len(compiled.Rules)
+			}
+			table.AddRow(i+1, p.Metadata.Name, p.Metadata.DependsOn, rules, compileErr)
+		}
+	}
+	printer.Printf("Dry run: nothing is enforced\n")
+	return printer.Table(table)
+}
+
+// newAgent creates an agent resolving selectors with resolver and enforcing
+// with the local backend, extended by the hooks in the hooks section of
+// config.yaml. Its compile cache only pays off across cycles, i.e. in
+// 'ztap agent'.
+func newAgent(resolver *policy.PolicyResolver, concurrency int) (*agent.Agent, error) {
+	config, err := loadSection(hooks.LoadConfig)
+	if err != nil {
+		return nil, err
+	}
+	runner := hooks.New(config)
+
+	cache := policy.NewCompileCache(resolver)
+	cache.OnLookup(metrics.GetCollector().ObservePolicyCache)
+	cache.OnGroupChange(func(group string, added, removed []string) {
+		fmt.Printf("Endpoint group %s changed: %d members added, %d removed; re-enforcing the policies using it\n",
+			group, len(added), len(removed))
+	})
+	a := agent.New(cache, localEnforcer(runner), concurrency)
+	if runner.Mutates() {
+		a.SetMutator(runner.Mutate)
+	}
+	return a, nil
+}
+
+// localEnforcer applies compiled policies with the platform's backend, or
+// the enforcer hook if one is configured, publishes the result attributed to
+// the principal in ctx and notifies the post-apply hooks
+func localEnforcer(runner *hooks.Runner) agent.EnforceFunc {
+	return func(ctx context.Context, compiled []*policy.CompiledPolicy) error {
+		var err error
+		backend := enforcer.Backend()
+		start := time.Now()
+		switch {
+		case runner.HasEnforcer():
+			backend = hooks.Backend
+			fmt.Println("Enforcing via enforcer hook...")
+			err = runner.Enforce(ctx, compiled)
+		case enforcer.IsLinux():
+			fmt.Println("Enforcing via eBPF (Linux)...")
+			err = enforcer.EnforceWithEBPF(compiled)
+		default:
+			fmt.Println("Enforcing via pf (macOS)...")
+			var config enforcer.PFConfig
+			if config, err = enforcer.LoadPFConfig(configPath()); err == nil {
+				err = enforcer.EnforceWithPF(compiled, config)
+			}
+		}
+		metrics.GetCollector().ObservePolicyApply(backend, time.Since(start))
+		if err == nil && backend != hooks.Backend {
+			reportHostConflicts(compiled)
+		}
+
+		// The backend applies the set at once, so a failure applies to every policy
+		var errMsg string
+		if err != nil {
+			errMsg = err.Error()
+		}
+		for _, c := range compiled {
+			events.Default().Publish(events.TopicPolicyApplied, events.PolicyApplied{
+				Policy:    c.Name,
+				Backend:   backend,
+				Principal: auth.PrincipalFromContext(ctx),
+				Error:     errMsg,
+			})
+		}
+		runner.Notify(ctx, backend, compiled, err)
+		return err
+	}
+}
@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/render"
+
+	"github.com/spf13/cobra"
+)
+
+var clusterTokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage join tokens nodes enroll with",
+	Long: `Join tokens let a node enroll itself with the API server ('ztap serve
+--enrollment') instead of an operator running 'ztap cluster join' for it. A
+token is short-lived and single-use; the node presenting it receives its
+service account and a client certificate from the cluster CA.`,
+}
+
+var clusterTokenCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a join token",
+	Long: `Create a join token and print it. Only a hash is stored, so the token
+cannot be shown again. The enrolling node's service account gets the labels
+given with --labels, in the tenant given with --tenant.
+
+  ztap cluster token create --ttl 30m --labels app=web,env=prod
+  ztap cluster token create --node db-1   # only db-1 may use it`,
+	Run: func(cmd *cobra.Command, args []string) {
+		requireLocal(cmd)
+		ttl, _ := cmd.Flags().GetDuration("ttl")
+		node, _ := cmd.Flags().GetString("node")
+		labels, _ := cmd.Flags().GetStringToString("labels")
+		if node != "" {
+			if err := auth.ValidateNode(node); err != nil {
+				fail(err)
+			}
+		}
+
+		store, err := getTokenStore()
+		if err != nil {
+			fail(err)
+		}
+		presented, token, err := store.Create(cluster.TokenOptions{
+			TTL:       ttl,
+			Node:      node,
+			Tenant:    activeTenant,
+			Labels:    labels,
+			CreatedBy: currentPrincipal(),
+		})
+		if err != nil {
+			fail(err)
+		}
+
+		fmt.Printf("Join token: %s\n", presented)
+		fmt.Printf("Expires:    %s\n", token.ExpiresAt.Format(time.RFC3339))
+		fmt.Println("The token is not shown again. On the node, run:")
+		enrollNode := node
+		if enrollNode == "" {
+			enrollNode = "<node-id>"
+		}
+		fmt.Printf("  ztap cluster enroll %s --server https://<api-server> --token %s\n", enrollNode, presented)
+	},
+}
+
+var clusterTokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List join tokens",
+	Long:  `List join tokens with their state. Tokens expired or used over a week ago are dropped.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		requireLocal(cmd)
+		store, err := getTokenStore()
+		if err != nil {
+			fail(err)
+		}
+		tokens, err := store.List()
+		if err != nil {
+			fail(err)
+		}
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "ID", Key: "id"},
+				{Header: "State", Key: "state"},
+				{Header: "Node", Key: "node"},
+				{Header: "Tenant", Key: "tenant"},
+				{Header: "Labels", Key: "labels"},
+				{Header: "Created by", Key: "created_by"},
+				{Header: "Expires", Key: "expires_at"},
+			},
+			Empty: "No join tokens",
+		}
+		for _, token := range tokens {
+			state := "valid"
+			switch {
+			case token.Used():
+				state = "used by " + token.UsedBy
+			case !time.Now().Before(token.ExpiresAt):
+				state = "expired"
+			}
+			table.AddRow(token.ID, state, token.Node, token.Tenant, formatLabels(token.Labels), token.CreatedBy, token.ExpiresAt)
+		}
+		if err := printer.Table(table); err != nil {
+			fail(err)
+		}
+	},
+}
+
+var clusterTokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <token-id>",
+	Short: "Revoke a join token",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		requireLocal(cmd)
+		store, err := getTokenStore()
+		if err != nil {
+			fail(err)
+		}
+		if err := store.Revoke(args[0]); err != nil {
+			fail(err)
+		}
+		printer.Printf("Join token %s revoked\n", args[0])
+	},
+}
+
+var clusterEnrollCmd = &cobra.Command{
+	Use:   "enroll <node-id> --server URL --token TOKEN",
+	Short: "Enroll this node with a join token",
+	Long: `Enroll this node with the API server, presenting a join token from
+'ztap cluster token create'. A private key is generated locally and only a
+certificate request is sent. The node's identity is written to --dir:
+
+  node.key     Private key (mode 0600)
+  node.crt     Client certificate issued by the cluster CA
+  ca.crt       Cluster CA certificate
+  node.secret  Secret of the node's service account (mode 0600)
+
+Over TLS, the API server requires node.crt on every request made with the
+node's service account.
+
+  ztap cluster enroll web-1 --server https://controller:8443 --token 1a2b3c4d.5e6f...`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		node := args[0]
+		server, _ := cmd.Flags().GetString("server")
+		token, _ := cmd.Flags().GetString("token")
+		serverCA, _ := cmd.Flags().GetString("server-ca")
+		dir, _ := cmd.Flags().GetString("dir")
+		if server == "" {
+			server = remoteServer()
+		}
+		if server == "" || token == "" {
+			failf(exitValidation, "--server and --token are required")
+		}
+		if err := auth.ValidateNode(node); err != nil {
+			fail(err)
+		}
+		if dir == "" {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				fail(fmt.Errorf("failed to get home directory: %w", err))
+			}
+			dir = filepath.Join(homeDir, ".ztap", "node")
+		}
+
+		client := &http.Client{Timeout: 30 * time.Second}
+		if serverCA != "" {
+			pem, err := os.ReadFile(serverCA)
+			if err != nil {
+				fail(err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				failf(exitValidation, "no certificates in %s", serverCA)
+			}
+			client.Transport = &http.Transport{TLSClientConfig: &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}}
+		}
+
+		enrollment, err := cluster.Enroll(cmd.Context(), client, server, token, node)
+		if err != nil {
+			fail(err)
+		}
+		if err := enrollment.Save(dir); err != nil {
+			fail(err)
+		}
+
+		fmt.Printf("Node %s enrolled as %s\n", node, enrollment.ServiceAccount)
+		if len(enrollment.Labels) > 0 {
+			fmt.Printf("Labels: %s\n", formatLabels(enrollment.Labels))
+		}
+		fmt.Printf("Identity written to %s (certificate valid until %s)\n", dir, enrollment.ExpiresAt.Format(time.RFC3339))
+	},
+}
+
+// getTokenStore returns the join token store under ~/.ztap
+func getTokenStore() (*cluster.TokenStore, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return cluster.NewTokenStore(filepath.Join(homeDir, ".ztap", "join-tokens.json")), nil
+}
+
+// getClusterCA loads the cluster CA under ~/.ztap/ca, creating it on first use
+func getClusterCA() (*cluster.CA, error) {
+	dir, err := getClusterCADir()
+	if err != nil {
+		return nil, err
+	}
+	return cluster.LoadOrCreateCA(dir)
+}
+
+// getClusterCADir returns the directory of the cluster CA, ~/.ztap/ca
+func getClusterCADir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".ztap", "ca"), nil
+}
+
+func init() {
+	clusterTokenCreateCmd.Flags().Duration("ttl", time.Hour, "How long the token can be used")
+	clusterTokenCreateCmd.Flags().String("node", "", "Only let this node ID enroll with the token")
+	clusterTokenCreateCmd.Flags().StringToString("labels", nil, "Labels of the enrolling node's service account, matched by policy nodeSelectors")
+
+	clusterEnrollCmd.Flags().String("server", "", "URL of the API server, e.g. https://controller:8443 (default: $ZTAP_SERVER or the current context's)")
+	clusterEnrollCmd.Flags().String("token", "", "Join token from 'ztap cluster token create'")
+	clusterEnrollCmd.Flags().String("server-ca", "", "CA certificate to verify the API server with (default: system roots)")
+	clusterEnrollCmd.Flags().String("dir", "", "Directory the node identity is written to (default ~/.ztap/node)")
+
+	clusterTokenCmd.AddCommand(clusterTokenCreateCmd)
+	clusterTokenCmd.AddCommand(clusterTokenListCmd)
+	clusterTokenCmd.AddCommand(clusterTokenRevokeCmd)
+	clusterCmd.AddCommand(clusterTokenCmd)
+	clusterCmd.AddCommand(clusterEnrollCmd)
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/bulk"
+	"ztap/pkg/cluster"
+	"ztap/pkg/compliance"
+	"ztap/pkg/enforcer"
+	"ztap/pkg/logging"
+	"ztap/pkg/policy"
+	"ztap/pkg/quota"
+	"ztap/pkg/upgrade"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of ztap commands, so scripts can tell failures apart. They are
+// documented in the README (Exit Codes); keep both in step.
+const (
+	// exitFailure covers failures not classified below, and checks that
+	// ran but did not pass, such as failing policy tests
+	exitFailure = 1
+	// exitValidation: invalid arguments, flags or input files, such as a
+	// policy that does not validate
+	exitValidation = 2
+	// exitAuth: not logged in, the session expired, or the role lacks the
+	// permission or tenant
+	exitAuth = 3
+	// exitEnforcement: a backend (eBPF, pf, an enforcer hook or a cloud
+	// provider) failed to apply policies, or enforcement is suspended
+	exitEnforcement = 4
+	// exitPartial: some items of a bulk operation failed and others
+	// succeeded
+	exitPartial = 5
+)
+
+// Error kinds, reported as "kind" in JSON error output
+var errorKinds = map[int]string{
+	exitFailure:     "error",
+	exitValidation:  "validation",
+	exitAuth:        "auth",
+	exitEnforcement: "enforcement",
+	exitPartial:     "partial",
+}
+
+// exitError classifies err with an exit code
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// validationError, authError and enforcementError classify err for fail;
+// errors of other packages are mostly classified already by exitCode
+func validationError(err error) error  { return &exitError{exitValidation, err} }
+func authError(err error) error        { return &exitError{exitAuth, err} }
+func enforcementError(err error) error { return &exitError{exitEnforcement, err} }
+
+// validationErrorf is validationError with a message formatted by
+// fmt.Errorf
+func validationErrorf(format string, args ...any) error {
+	return validationError(fmt.Errorf(format, args...))
+}
+
+// exitCode returns the exit code err is classified with, by fail's callers
+// or by the error types and sentinels of the packages commands use
+func exitCode(err error) int {
+	var classified *exitError
+	if errors.As(err, &classified) {
+		return classified.code
+	}
+
+	var (
+		invalidPolicy policy.ValidationError
+		incompatible  *policy.IncompatibleError
+		collision     *policy.CollisionError
+		cycle         *policy.DependencyCycleError
+		missing       *policy.MissingDependencyError
+		privileges    *enforcer.PrivilegeError
+		cgroup        *enforcer.CgroupError
+	)
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials), errors.Is(err, auth.ErrSessionExpired),
+		errors.Is(err, auth.ErrSessionNotFound), errors.Is(err, auth.ErrPermissionDenied),
+		errors.Is(err, auth.ErrTenantDenied), errors.Is(err, auth.ErrUserDisabled),
+		errors.Is(err, cluster.ErrTokenInvalid), errors.Is(err, cluster.ErrTokenExpired),
+		errors.Is(err, cluster.ErrTokenUsed):
+		return exitAuth
+	case errors.As(err, &invalidPolicy), errors.As(err, &incompatible), errors.As(err, &collision),
+		errors.As(err, &cycle), errors.As(err, &missing),
+		errors.Is(err, auth.ErrInvalidNode), errors.Is(err, auth.ErrInvalidTenant),
+		errors.Is(err, compliance.ErrInvalidSignature), errors.Is(err, compliance.ErrUntrustedKey),
+		errors.Is(err, upgrade.ErrInvalidSignature), errors.Is(err, quota.ErrExceeded):
+		return exitValidation
+	case errors.As(err, &privileges), errors.As(err, &cgroup):
+		return exitEnforcement
+	}
+	return exitFailure
+}
+
+// jsonErrors reports whether errors are printed as JSON: --output json on
+// the command being run
+var jsonErrors bool
+
+// setErrorFormat sets the format of errors from cmd's --output flag. Commands
+// with their own --output format flag print errors as JSON with -o json too.
+func setErrorFormat(cmd *cobra.Command) {
+	output, _ := cmd.Flags().GetString("output")
+	jsonErrors = output == "json"
+}
+
+// fail prints err, with secrets redacted, and exits with its exit code (see
+// exitCode). Errors are printed to stderr as "Error: <message>", or with
+// --output json as
+//
+//	{"error": {"kind": "validation", "exit_code": 2, "message": "..."}}
+func fail(err error) {
+	code := exitCode(err)
+	message := logging.Scrub(err.Error())
+	if jsonErrors {
+		type jsonError struct {
+			Kind     string `json:"kind"`
+			ExitCode int    `json:"exit_code"`
+			Message  string `json:"message"`
+		}
+		data, _ := json.Marshal(map[string]jsonError{
+			"error": {Kind: errorKinds[code], ExitCode: code, Message: message},
+		})
+		fmt.Fprintln(os.Stderr, string(data))
+	} else {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", message)
+	}
+	recordTelemetryError(errorKinds[code])
+	os.Exit(code)
+}
+
+// failBulk exits after a bulk operation whose failed items reporter listed,
+// with exitPartial if some items succeeded and noneSucceeded otherwise
+func failBulk(reporter *bulk.Reporter, noneSucceeded int) {
+	failed, total := reporter.Failed(), len(reporter.Results())
+	code := exitPartial
+	if failed == total {
+		code = noneSucceeded
+	}
+	failf(code, "%d of %d item(s) failed", failed, total)
+}
+
+// failf is fail with a message formatted by fmt.Errorf, classified with code
+func failf(code int, format string, args ...any) {
+	fail(&exitError{code, fmt.Errorf(format, args...)})
+}
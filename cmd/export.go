@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"ztap/pkg/cloud"
+	"ztap/pkg/effective"
+	"ztap/pkg/enforcer"
+
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export enforced state for audits",
+}
+
+var exportEffectiveRulesCmd = &cobra.Command{
+	Use:   "effective-rules --output json",
+	Short: "Export the rule set currently enforced across backends",
+	Long: `Read back the rules currently enforced on this host and in AWS, and write
+them as one JSON document for audits and for diffing with external tools.
+
+  ebpf             entries of the pinned eBPF policy map (Linux); the
+                   filter matches each entry's exact address
+  pf               rules loaded in the ztap pf anchor (macOS)
+  securityGroups   every rule of the Security Group given with
+                   --security-group, or of each Security Group in --accounts,
+                   with its AWS rule ID
+
+Rules are read from the backends, not compiled from policy files, so the
+document shows what is enforced even if it has drifted from the policies.
+Reading the eBPF map and pf rules usually requires root. A backend that cannot
+be read is reported with an error in its section, and the command exits
+non-zero after writing the document.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		sgID, _ := cmd.Flags().GetString("security-group")
+		region, _ := cmd.Flags().GetString("region")
+		accountsFile, _ := cmd.Flags().GetString("accounts")
+
+		if output != "json" {
+			failf(exitValidation, "unknown output format %q (use json)", output)
+		}
+
+		var sources effective.Sources
+		if enforcer.IsLinux() {
+			sources.EBPF = readPolicyMap
+		} else {
+			sources.PF = enforcer.ListPFAnchorRules
+		}
+		sources.SecurityGroups = securityGroupSource(accountsFile, sgID, region)
+
+		host, _ := os.Hostname()
+		doc := effective.Collect(cmd.Context(), host, time.Now(), sources)
+
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(doc); err != nil {
+			fail(err)
+		}
+		if errs := doc.Errors(); len(errs) > 0 {
+			for _, err := range errs {
+				log.Printf("Warning: failed to read %s", err)
+			}
+			os.Exit(exitPartial)
+		}
+	},
+}
+
+// securityGroupSource reads the rules of every Security Group in
+// accountsFile, or of sgID in region; it is nil if neither is given
+func securityGroupSource(accountsFile, sgID, region string) func(ctx context.Context) ([]cloud.AppliedRule, error) {
+	switch {
+	case accountsFile != "":
+		return func(ctx context.Context) ([]cloud.AppliedRule, error) {
+			client, err := newMultiClient(ctx, accountsFile)
+			if err != nil {
+				return nil, err
+			}
+			return client.ListRules(ctx)
+		}
+	case sgID != "":
+		return func(ctx context.Context) ([]cloud.AppliedRule, error) {
+			client, err := cloud.NewAWSClient(region)
+			if err != nil {
+				return nil, err
+			}
+			return client.ListRules(ctx, sgID)
+		}
+	}
+	return nil
+}
+
+// readPolicyMap returns the entries of the eBPF policy map pinned by a
+// running agent
+func readPolicyMap() ([]enforcer.PolicyEntry, error) {
+	reader, err := enforcer.OpenPinnedPolicyMap()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return reader.Entries()
+}
+
+func init() {
+	exportEffectiveRulesCmd.Flags().StringP("output", "o", "json", "Output format (json)")
+	exportEffectiveRulesCmd.Flags().String("security-group", "", "Also export the rules of this Security Group")
+	exportEffectiveRulesCmd.Flags().StringP("region", "r", "us-east-1", "AWS region of --security-group")
+	exportEffectiveRulesCmd.Flags().String("accounts", "", "Also export the rules of every Security Group in this YAML file of AWS accounts")
+
+	exportCmd.AddCommand(exportEffectiveRulesCmd)
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"ztap/pkg/graph"
+	"ztap/pkg/policy"
+	"ztap/pkg/stats"
+
+	"github.com/spf13/cobra"
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph [--since 24h]",
+	Short: "Export the service dependency graph seen in flow logs",
+	Long: `Build a service-to-service communication graph from the enforcement log and
+export it as DOT (Graphviz), GraphML (Gephi, yEd) or JSON.
+
+Flow IPs are identified with registered services, --workloads (a YAML list of
+name, ip and labels) and, with --aws-region or --aws-accounts, AWS inventory.
+Workloads sharing the --group-by label form one node; IPs no workload is
+known for are nodes of their own. Each edge carries its port, protocol,
+allowed and blocked flow counts, and the policies the flows were logged
+under.
+
+With -f, edges are annotated with whether the policy file allows their flows:
+covered (every flow), partial (some flows) or uncovered. Uncovered
+edges between known services are candidates for new allow rules.
+
+  ztap graph --since 24h -f policy.yaml | dot -Tsvg > graph.svg`,
+	Run: func(cmd *cobra.Command, args []string) {
+		since, _ := cmd.Flags().GetString("since")
+		format, _ := cmd.Flags().GetString("format")
+		policyFile, _ := cmd.Flags().GetString("file")
+		workloadsFile, _ := cmd.Flags().GetString("workloads")
+		groupBy, _ := cmd.Flags().GetString("group-by")
+		outputFile, _ := cmd.Flags().GetString("output")
+
+		period, err := stats.ParseSince(since)
+		if err != nil {
+			fail(err)
+		}
+
+		workloads, err := collectWorkloads(cmd, workloadsFile)
+		if err != nil {
+			fail(err)
+		}
+		endpoints := make(map[string]graph.Endpoint, len(workloads))
+		for _, w := range workloads {
+			if _, ok := endpoints[w.IP]; !ok && w.IP != "" {
+				endpoints[w.IP] = graph.Endpoint{Name: w.Name, Labels: w.Labels}
+			}
+		}
+		builder := graph.NewBuilder(func(ip string) (graph.Endpoint, bool) {
+			ep, ok := endpoints[ip]
+			return ep, ok
+		}, groupBy)
+
+		if policyFile != "" {
+			rules, err := graphRules(cmd, policyFile)
+			if err != nil {
+				fail(err)
+			}
+			builder.SetRules(rules)
+		}
+
+		logFile := getLogFilePath()
+		if _, err := os.Stat(logFile); err != nil {
+			failf(exitFailure, "no enforcement log at %s: %w", logFile, err)
+		}
+		cutoff := time.Now().Add(-period)
+		flows := 0
+		readLogFrom(logFile, 0, func(entry LogEntry) {
+			if entry.Timestamp.Before(cutoff) || entry.SourceIP == "" || entry.DestIP == "" {
+				return
+			}
+			builder.Add(graph.Flow{
+				Timestamp: entry.Timestamp,
+				SourceIP:  entry.SourceIP,
+				DestIP:    entry.DestIP,
+				Port:      entry.Port,
+				Protocol:  strings.ToUpper(entry.Protocol),
+				Action:    entry.Action,
+				Policy:    entry.PolicyName,
+				Count:     entry.Count,
+				LastSeen:  entry.LastSeen,
+			})
+			flows += entry.Flows()
+		})
+
+		var out io.Writer = os.Stdout
+		if outputFile != "" {
+			file, err := os.Create(outputFile)
+			if err != nil {
+				fail(err)
+			}
+			defer file.Close()
+			out = file
+		}
+
+		g := builder.Graph()
+		if err := g.Write(out, format); err != nil {
+			fail(err)
+		}
+		fmt.Fprintf(os.Stderr, "%d flows since %s: %d nodes, %d edges\n",
+			flows, cutoff.Format("2006-01-02 15:04"), len(g.Nodes), len(g.Edges))
+	},
+}
+
+// graphRules compiles a policy file into the rules edges are checked against
+func graphRules(cmd *cobra.Command, policyFile string) (*graph.Rules, error) {
+	compiled, err := compilePolicyFile(cmd, policyFile)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := policy.LoadFromFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+	return graph.NewRules(policies, compiled)
+}
+
+func init() {
+	graphCmd.Flags().String("since", "24h", "Look-back period (e.g. 24h, 7d, 2w)")
+	graphCmd.Flags().String("format", "dot", "Export format (dot, graphml, json)")
+	graphCmd.Flags().StringP("file", "f", "", "Annotate edges with whether this policy file allows them")
+	graphCmd.Flags().String("workloads", "", "YAML list of workloads (name, ip, labels) used to identify flow IPs")
+	graphCmd.Flags().String("group-by", "app", "Label whose value groups workloads into one node (empty: one node per workload)")
+	graphCmd.Flags().StringP("output", "o", "", "Write the graph to this file instead of stdout")
+	graphCmd.Flags().String("aws-region", "", "Also identify flow IPs with AWS resources in this region")
+	graphCmd.Flags().String("aws-accounts", "", "Also identify flow IPs with AWS resources in the accounts and regions of this YAML file")
+	rootCmd.AddCommand(graphCmd)
+}
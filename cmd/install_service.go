@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"ztap/pkg/enforcer"
+	"ztap/pkg/service"
+
+	"github.com/spf13/cobra"
+)
+
+var installServiceCmd = &cobra.Command{
+	Use:   "install-service",
+	Short: "Install 'ztap agent' as a systemd service",
+	Long: `Write a systemd unit that runs 'ztap agent' at boot.
+
+With --user the agent runs as that (existing) user with only the capabilities
+eBPF enforcement needs, granted through AmbientCapabilities.
+
+--hardened also sandboxes the agent:
+  - systemd restricts it to a read-only system, its state directory and
+    /sys/fs/bpf, and to the syscalls the agent makes (SystemCallFilter)
+  - /etc/apparmor.d/ztap-agent confines its files, network and capabilities
+  - /etc/ztap/selinux/ztap.{te,fc} is an equivalent SELinux policy module
+  - /etc/ztap/seccomp.json is the same syscall allowlist for containers
+
+A hardened agent cannot run other programs, so it does not check host
+firewall conflicts after enforcing; use 'ztap doctor' instead.
+
+Use --dry-run to print the files instead of writing them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := service.DefaultConfig
+		config.Binary, _ = cmd.Flags().GetString("binary")
+		config.PolicyFile, _ = cmd.Flags().GetString("policy")
+		config.StateDir, _ = cmd.Flags().GetString("state-dir")
+		config.User, _ = cmd.Flags().GetString("user")
+		config.Args, _ = cmd.Flags().GetStringArray("agent-arg")
+		config.Hardened, _ = cmd.Flags().GetBool("hardened")
+		root, _ := cmd.Flags().GetString("root")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		if config.User != "" {
+			config.Capabilities = agentCapabilities(config.Args)
+		}
+
+		files, err := service.Files(config)
+		if err != nil {
+			fail(err)
+		}
+
+		for _, f := range files {
+			if dryRun {
+				fmt.Printf("==> %s <==\n%s\n", f.Path, f.Data)
+				continue
+			}
+			path := filepath.Join(root, f.Path)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				fail(err)
+			}
+			if err := os.WriteFile(path, f.Data, os.FileMode(f.Mode)); err != nil {
+				fail(err)
+			}
+			fmt.Printf("Wrote %s\n", path)
+		}
+		if dryRun {
+			return
+		}
+
+		fmt.Println("\nNext steps:")
+		if config.User != "" {
+			fmt.Printf("  useradd --system --home-dir %s --shell /usr/sbin/nologin %s   # if the user does not exist\n", config.StateDir, config.User)
+		}
+		if config.Hardened {
+			fmt.Printf("  apparmor_parser -r /etc/apparmor.d/%s                   # AppArmor hosts\n", service.Name)
+			fmt.Println("  cd /etc/ztap/selinux && make -f /usr/share/selinux/devel/Makefile ztap.pp && semodule -i ztap.pp && restorecon -R /etc/ztap " + config.StateDir + " " + config.Binary + "   # SELinux hosts")
+		}
+		fmt.Printf("  systemctl daemon-reload && systemctl enable --now %s\n", service.Name)
+	},
+}
+
+// agentCapabilities returns the capabilities a non-root agent started with
+// args needs
+func agentCapabilities(args []string) []string {
+	ops := []string{enforcer.OpMemlock, enforcer.OpLoadEBPF, enforcer.OpAttachCgroup}
+	for _, arg := range args {
+		if arg == "--ebpf-stats" {
+			ops = append(ops, enforcer.OpProgramStats)
+		}
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, op := range ops {
+		for _, c := range enforcer.RequiredCapabilities(op) {
+			if !seen[c.Name] {
+				seen[c.Name] = true
+				names = append(names, c.Name)
+			}
+		}
+	}
+	return names
+}
+
+func init() {
+	installServiceCmd.Flags().String("binary", service.DefaultConfig.Binary, "Absolute path of the ztap binary the service runs")
+	installServiceCmd.Flags().String("policy", service.DefaultConfig.PolicyFile, "Policy file the agent enforces")
+	installServiceCmd.Flags().String("state-dir", service.DefaultConfig.StateDir, "Home directory of the service; state is kept in <state-dir>/.ztap")
+	installServiceCmd.Flags().String("user", "", "Run the agent as this non-root user with only the capabilities it needs")
+	installServiceCmd.Flags().StringArray("agent-arg", nil, "Extra 'ztap agent' argument, e.g. --agent-arg=--metrics-port --agent-arg=9090 (repeatable)")
+	installServiceCmd.Flags().Bool("hardened", false, "Sandbox the agent with systemd, seccomp, AppArmor and SELinux")
+	installServiceCmd.Flags().String("root", "/", "Install under this directory instead of / (for packaging)")
+	installServiceCmd.Flags().Bool("dry-run", false, "Print the generated files instead of writing them")
+	rootCmd.AddCommand(installServiceCmd)
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ztap/pkg/agent"
+	"ztap/pkg/applied"
+	"ztap/pkg/hooks"
+)
+
+func getLastKnownGoodPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/ztap-last-known-good.json"
+	}
+	return filepath.Join(homeDir, ".ztap", "last-known-good.json")
+}
+
+// loadLastKnownGood returns the saved last-known-good rule set, or nil if
+// none was saved or it cannot be read
+func loadLastKnownGood() *applied.RuleSet {
+	set, err := applied.LoadRuleSet(getLastKnownGoodPath())
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return nil
+	}
+	return set
+}
+
+// enforceLastKnownGood returns a function enforcing set with the platform's
+// backend or enforcer hook, for agent.WaitForDependencies, or nil if set is
+// nil
+func enforceLastKnownGood(set *applied.RuleSet) func(context.Context) error {
+	if set == nil {
+		return nil
+	}
+	return func(ctx context.Context) error {
+		fmt.Printf("Enforcing the last-known-good rule set: %s\n", describeRuleSet(set))
+		config, err := loadSection(hooks.LoadConfig)
+		if err != nil {
+			return err
+		}
+		return localEnforcer(hooks.New(config))(ctx, set.Policies)
+	}
+}
+
+// saveLastKnownGood saves the rule set a enforces as last known good
+func saveLastKnownGood(a *agent.Agent, node string) {
+	set := applied.RuleSet{Policies: a.Applied(), SavedAt: time.Now().UTC(), SavedBy: node}
+	if err := applied.SaveRuleSet(getLastKnownGoodPath(), set); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// describeRuleSet summarizes a saved rule set and its age
+func describeRuleSet(set *applied.RuleSet) string {
+	return fmt.Sprintf("%d policies, saved %s (%s ago)", len(set.Policies),
+		set.SavedAt.Local().Format("2006-01-02 15:04:05"), set.Age(time.Now()).Round(time.Second))
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"ztap/pkg/anomaly"
+	"ztap/pkg/enforcer"
+	"ztap/pkg/events"
+	"ztap/pkg/loadtest"
+	"ztap/pkg/metrics"
+	"ztap/pkg/stats"
+
+	"github.com/spf13/cobra"
+)
+
+var loadtestCmd = &cobra.Command{
+	Use:   "loadtest",
+	Short: "Measure flow pipeline throughput with synthetic flows",
+	Long: `Generate synthetic flows and push each one through the agent's flow
+pipeline: JSON logging, statistics, blocked-flow events and metrics, and
+anomaly detection. With --ebpf-lookup every flow is also looked up in the
+policy map pinned by a running eBPF agent. Reports throughput and latency
+percentiles per flow.
+
+The run uses a private event bus and statistics recorder, so synthetic flows
+never reach 'ztap serve', 'ztap report' or the enforcement log. Use
+--log-file to keep the logged entries.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := loadtest.Config{}
+		config.Flows, _ = cmd.Flags().GetInt("flows")
+		config.Rate, _ = cmd.Flags().GetFloat64("rate")
+		config.Workers, _ = cmd.Flags().GetInt("workers")
+		config.BlockedRatio, _ = cmd.Flags().GetFloat64("blocked")
+		config.Seed, _ = cmd.Flags().GetInt64("seed")
+		anomalyEndpoint, _ := cmd.Flags().GetString("anomaly-endpoint")
+		anomalyFallback, _ := cmd.Flags().GetString("anomaly-fallback")
+		logFile, _ := cmd.Flags().GetString("log-file")
+		ebpfLookup, _ := cmd.Flags().GetBool("ebpf-lookup")
+
+		if err := config.Validate(); err != nil {
+			fail(err)
+		}
+
+		var logOut io.Writer = io.Discard
+		if logFile != "" {
+			file, err := os.Create(logFile)
+			if err != nil {
+				fail(err)
+			}
+			defer file.Close()
+			buffered := bufio.NewWriter(file)
+			defer buffered.Flush()
+			logOut = buffered
+		}
+
+		var lookup *enforcer.PolicyMapReader
+		if ebpfLookup {
+			reader, err := enforcer.OpenPinnedPolicyMap()
+			if err != nil {
+				fail(err)
+			}
+			defer reader.Close()
+			lookup = reader
+		}
+
+		detector, err := anomaly.NewDetector(anomalyEndpoint, anomalyFallback)
+		if err != nil {
+			fail(err)
+		}
+		handle := loadtestPipeline(detector, logOut, lookup)
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		rate := "unlimited"
+		if config.Rate > 0 {
+			rate = fmt.Sprintf("%.0f flows/s", config.Rate)
+		}
+		fmt.Printf("Sending %d synthetic flows (%s)...\n", config.Flows, rate)
+
+		report, err := loadtest.Run(ctx, config, handle)
+		if err != nil && report == nil {
+			fail(err)
+		}
+		if err != nil {
+			fmt.Println("Interrupted; partial results:")
+		}
+		printLoadtestReport(report, config.Rate)
+	},
+}
+
+// loadtestPipeline returns the per-flow pipeline: log the entry, run the
+// agent's flow handler against a private bus and recorder, and optionally
+// look the flow up in the pinned eBPF policy map
+func loadtestPipeline(detector anomaly.Detector, logOut io.Writer, lookup *enforcer.PolicyMapReader) func(loadtest.Flow) error {
+	bus := events.NewBus()
+	metrics.RecordEvents(bus)
+	recorder := stats.NewRecorder("")
+	handler := flowHandler(anomaly.WithEvents(detector, bus), bus,
+		func(string) string { return "" },
+		func(string, string, int, string) string { return "" },
+		func(string) bool { return false },
+		func(string, time.Time) string { return "" })
+
+	var logMu sync.Mutex
+	encoder := json.NewEncoder(logOut)
+
+	return func(flow loadtest.Flow) error {
+		action := "ALLOWED"
+		if !flow.Allowed {
+			action = "BLOCKED"
+		}
+		entry := LogEntry{
+			Timestamp:  flow.Timestamp,
+			PolicyName: flow.Policy,
+			Action:     action,
+			SourceIP:   flow.SourceIP,
+			DestIP:     flow.DestIP,
+			Port:       flow.Port,
+			Protocol:   flow.Protocol,
+		}
+
+		logMu.Lock()
+		err := encoder.Encode(entry)
+		logMu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		recordFlow(recorder, entry)
+		handler(entry)
+
+		if lookup != nil {
+			if _, err := lookup.Allowed(flow.DestIP, flow.Port, flow.Protocol); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func printLoadtestReport(report *loadtest.Report, target float64) {
+	fmt.Printf("\nFlows:       %d (%d errors)\n", report.Flows, report.Errors)
+	fmt.Printf("Duration:    %s\n", report.Duration.Round(time.Millisecond))
+	fmt.Printf("Throughput:  %.0f flows/s", report.Throughput())
+	if target > 0 {
+		fmt.Printf(" (target %.0f)", target)
+	}
+	fmt.Println()
+	fmt.Println("Latency per flow:")
+	fmt.Printf("  p50  %s\n", report.P50)
+	fmt.Printf("  p90  %s\n", report.P90)
+	fmt.Printf("  p99  %s\n", report.P99)
+	fmt.Printf("  max  %s\n", report.Max)
+	if target > 0 && report.Throughput() < target*0.95 {
+		fmt.Println("\nWarning: the pipeline did not keep up with the target rate; add --workers or lower --rate")
+	}
+}
+
+func init() {
+	loadtestCmd.Flags().Int("flows", 100000, "Number of synthetic flows to send")
+	loadtestCmd.Flags().Float64("rate", 5000, "Flows per second (0 sends as fast as possible)")
+	loadtestCmd.Flags().Int("workers", 0, "Concurrent pipeline workers (default: number of CPUs)")
+	loadtestCmd.Flags().Float64("blocked", 0.1, "Fraction of flows that are blocked")
+	loadtestCmd.Flags().Int64("seed", 1, "Seed for the flow generator")
+	loadtestCmd.Flags().String("anomaly-endpoint", "", "Anomaly detection service URL, http:// or grpc:// (default: rule-based detector)")
+	loadtestCmd.Flags().String("anomaly-fallback", "", "HTTP detection service used while the gRPC --anomaly-endpoint is unavailable")
+	loadtestCmd.Flags().String("log-file", "", "Write the logged flow entries here instead of discarding them")
+	loadtestCmd.Flags().Bool("ebpf-lookup", false, "Also look every flow up in the eBPF policy map pinned by a running agent (Linux)")
+	rootCmd.AddCommand(loadtestCmd)
+}
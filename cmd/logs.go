@@ -1,18 +1,28 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"ztap/pkg/auth"
+	"ztap/pkg/discovery"
+	"ztap/pkg/enforcer"
+	"ztap/pkg/flowlog"
+
 	"github.com/spf13/cobra"
 )
 
-// LogEntry represents a single enforcement log entry
+// LogEntry represents a single enforcement log entry. An aggregated entry
+// stands for Count identical flows, the first seen at Timestamp and the last
+// at LastSeen (see flowlog).
 type LogEntry struct {
 	Timestamp  time.Time         `json:"timestamp"`
 	PolicyName string            `json:"policy_name"`
@@ -22,27 +32,41 @@ type LogEntry struct {
 	Port       int               `json:"port"`
 	Protocol   string            `json:"protocol"`
 	Labels     map[string]string `json:"labels"`
+	Count      int               `json:"count,omitempty"`
+	Bytes      int64             `json:"bytes,omitempty"`
+	LastSeen   time.Time         `json:"last_seen,omitzero"`
+}
+
+// Flows returns the number of flows the entry stands for
+func (e LogEntry) Flows() int {
+	return max(1, e.Count)
 }
 
 var logsCmd = &cobra.Command{
 	Use:   "logs [--policy policy-name]",
 	Short: "View enforcement logs",
-	Long:  `Display logs of policy enforcement actions (allowed/blocked flows)`,
+	Long: `Display logs of policy enforcement actions (allowed/blocked flows)
+
+Users limited by scopes ('ztap user scope') only see flows whose labels, or
+the labels of the service holding either IP at the time, are in a scope.
+With --namespace (or a context's namespace), only flows whose labels or
+services carry namespace=<namespace> are shown.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		policyFilter, _ := cmd.Flags().GetString("policy")
 		follow, _ := cmd.Flags().GetBool("follow")
 		tail, _ := cmd.Flags().GetInt("tail")
 
 		logFile := getLogFilePath()
+		session := loginSession()
 
 		if follow {
 			fmt.Println("Following logs (Ctrl+C to stop)...")
-			tailLogs(logFile, policyFilter, -1)
+			tailLogs(logFile, policyFilter, -1, session)
 		} else {
 			if tail > 0 {
-				tailLogs(logFile, policyFilter, tail)
+				tailLogs(logFile, policyFilter, tail, session)
 			} else {
-				displayLogs(logFile, policyFilter)
+				displayLogs(logFile, policyFilter, session)
 			}
 		}
 	},
@@ -63,19 +87,19 @@ func getLogFilePath() string {
 	return filepath.Join(homeDir, ".ztap", "enforcement.log")
 }
 
-func displayLogs(logFile, policyFilter string) {
+func displayLogs(logFile, policyFilter string, session *auth.Session) {
 	file, err := os.Open(logFile)
 	if err != nil {
 		if os.IsNotExist(err) {
 			fmt.Println("No logs found. Run 'ztap enforce' to generate logs.")
 			return
 		}
-		fmt.Printf("Error: Failed to open log file: %v\n", err)
-		return
+		fail(fmt.Errorf("failed to open log file: %w", err))
 	}
 	defer file.Close()
 
 	decoder := json.NewDecoder(file)
+	attributor := newAttributor(nil)
 	count := 0
 
 	for {
@@ -90,8 +114,11 @@ func displayLogs(logFile, policyFilter string) {
 		if policyFilter != "" && entry.PolicyName != policyFilter {
 			continue
 		}
+		if !entryVisible(session, entry, attributor) {
+			continue
+		}
 
-		printLogEntry(entry)
+		printLogEntry(entry, attributor)
 		count++
 	}
 
@@ -104,7 +131,7 @@ func displayLogs(logFile, policyFilter string) {
 	}
 }
 
-func tailLogs(logFile, policyFilter string, n int) {
+func tailLogs(logFile, policyFilter string, n int, session *auth.Session) {
 	// For simplicity, this is a basic implementation
 	// In production, use a proper tail implementation or library
 	file, err := os.Open(logFile)
@@ -113,13 +140,13 @@ func tailLogs(logFile, policyFilter string, n int) {
 			fmt.Println("No logs found. Run 'ztap enforce' to generate logs.")
 			return
 		}
-		fmt.Printf("Error: Failed to open log file: %v\n", err)
-		return
+		fail(fmt.Errorf("failed to open log file: %w", err))
 	}
 	defer file.Close()
 
 	var entries []LogEntry
 	decoder := json.NewDecoder(file)
+	attributor := newAttributor(nil)
 
 	for {
 		var entry LogEntry
@@ -130,7 +157,7 @@ func tailLogs(logFile, policyFilter string, n int) {
 			continue
 		}
 
-		if policyFilter == "" || entry.PolicyName == policyFilter {
+		if (policyFilter == "" || entry.PolicyName == policyFilter) && entryVisible(session, entry, attributor) {
 			entries = append(entries, entry)
 		}
 	}
@@ -142,11 +169,31 @@ func tailLogs(logFile, policyFilter string, n int) {
 	}
 
 	for i := start; i < len(entries); i++ {
-		printLogEntry(entries[i])
+		printLogEntry(entries[i], attributor)
+	}
+}
+
+// entryVisible reports whether entry is shown to session (nil without a
+// login): if its labels or those of the service holding either IP at the
+// time are in one of the session's scopes and in the active namespace
+func entryVisible(session *auth.Session, entry LogEntry, attributor *discovery.Attributor) bool {
+	shown := func(labels map[string]string) bool {
+		return inNamespace(labels) && (session == nil || session.Sees(labels))
+	}
+	if shown(entry.Labels) {
+		return true
+	}
+	for _, ip := range []string{entry.SourceIP, entry.DestIP} {
+		if a, ok := attributor.Attribute(ip, entry.Timestamp); ok && shown(a.Labels) {
+			return true
+		}
 	}
+	return false
 }
 
-func printLogEntry(entry LogEntry) {
+// printLogEntry prints entry, naming the services that held its IPs at the
+// time
+func printLogEntry(entry LogEntry, attributor *discovery.Attributor) {
 	actionColor := ""
 	if entry.Action == "ALLOWED" {
 		actionColor = "[ALLOWED]"
@@ -163,20 +210,80 @@ func printLogEntry(entry LogEntry) {
 		labels = " (" + strings.Join(parts, ", ") + ")"
 	}
 
-	fmt.Printf("[%s] %s Policy: %s | %s:%d -> %s:%d%s\n",
+	// Aggregated flows: x42 until 15:04:55
+	count := ""
+	if entry.Count > 1 {
+		count = fmt.Sprintf(" x%d until %s", entry.Count, entry.LastSeen.Format("15:04:05"))
+	}
+
+	fmt.Printf("[%s] %s Policy: %s | %s:%d -> %s:%d%s%s\n",
 		entry.Timestamp.Format("2006-01-02 15:04:05"),
 		actionColor,
 		entry.PolicyName,
-		entry.SourceIP,
+		attributor.FormatIP(entry.SourceIP, entry.Timestamp),
 		entry.Port,
-		entry.DestIP,
+		attributor.FormatIP(entry.DestIP, entry.Timestamp),
 		entry.Port,
+		count,
 		labels,
 	)
 }
 
-// LogEnforcement writes an enforcement action to the log file
-func LogEnforcement(policyName, action, sourceIP, destIP, protocol string, port int, labels map[string]string) error {
+var (
+	flowLogger     *flowlog.Aggregator
+	flowLoggerErr  error
+	flowLoggerOnce sync.Once
+	flowLogMu      sync.Mutex
+)
+
+// getFlowLogger returns the aggregator of this process's enforcement log
+// writes, over the flow_log window of config.yaml for the platform's backend
+func getFlowLogger() (*flowlog.Aggregator, error) {
+	flowLoggerOnce.Do(func() {
+		var config flowlog.Config
+		config, flowLoggerErr = loadSection(flowlog.LoadConfig)
+		flowLogger = flowlog.NewAggregator(config.WindowFor(enforcer.Backend()), writeLogRecord)
+	})
+	return flowLogger, flowLoggerErr
+}
+
+// LogEnforcement records an enforcement action of a flow of size bytes in
+// the log file. Identical flows within the flow_log window of config.yaml
+// are written as one entry when the window closes; call FlushEnforcementLog
+// before exiting. Flows are counted and published by 'ztap agent', which
+// follows the log, so they are counted once whichever process logs them.
+func LogEnforcement(policyName, action, sourceIP, destIP, protocol string, port int, bytes int64, labels map[string]string) error {
+	logger, err := getFlowLogger()
+	if err != nil {
+		return err
+	}
+	return logger.Add(flowlog.Flow{
+		Timestamp: time.Now(),
+		Policy:    policyName,
+		Action:    action,
+		SourceIP:  sourceIP,
+		DestIP:    destIP,
+		Port:      port,
+		Protocol:  protocol,
+		Bytes:     bytes,
+		Labels:    labels,
+	})
+}
+
+// FlushEnforcementLog writes the flows LogEnforcement is still aggregating
+func FlushEnforcementLog() error {
+	logger, err := getFlowLogger()
+	if err != nil {
+		return err
+	}
+	return logger.Flush()
+}
+
+// writeLogRecord appends a flow record to the log file
+func writeLogRecord(r flowlog.Record) error {
+	flowLogMu.Lock()
+	defer flowLogMu.Unlock()
+
 	logFile := getLogFilePath()
 
 	// Ensure directory exists
@@ -186,14 +293,19 @@ func LogEnforcement(policyName, action, sourceIP, destIP, protocol string, port
 	}
 
 	entry := LogEntry{
-		Timestamp:  time.Now(),
-		PolicyName: policyName,
-		Action:     action,
-		SourceIP:   sourceIP,
-		DestIP:     destIP,
-		Port:       port,
-		Protocol:   protocol,
-		Labels:     labels,
+		Timestamp:  r.FirstSeen,
+		PolicyName: r.Policy,
+		Action:     r.Action,
+		SourceIP:   r.SourceIP,
+		DestIP:     r.DestIP,
+		Port:       r.Port,
+		Protocol:   r.Protocol,
+		Labels:     r.Labels,
+		Bytes:      r.Bytes,
+	}
+	if r.Count > 1 {
+		entry.Count = r.Count
+		entry.LastSeen = r.LastSeen
 	}
 
 	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
@@ -205,3 +317,81 @@ func LogEnforcement(policyName, action, sourceIP, destIP, protocol string, port
 	encoder := json.NewEncoder(file)
 	return encoder.Encode(entry)
 }
+
+// followLog calls handle for every complete entry appended to logFile after
+// offset, polling every interval until ctx is cancelled. Entries are read by
+// whichever process runs the follower, so flows logged by any process reach
+// its event bus exactly once. A truncated file is read from the start, as is
+// a rotated one or one rewritten by retention, past the entries already
+// handled.
+func followLog(ctx context.Context, logFile string, offset int64, interval time.Duration, handle func(LogEntry)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	followed, _ := os.Stat(logFile)
+	var last time.Time
+	for {
+		var skip time.Time
+		if info, err := os.Stat(logFile); err == nil {
+			if followed != nil && !os.SameFile(followed, info) {
+				offset, skip = 0, last
+			}
+			followed = info
+		}
+		offset = readLogFrom(logFile, offset, func(entry LogEntry) {
+			if !skip.IsZero() && !entry.Timestamp.After(skip) {
+				return
+			}
+			last = entry.Timestamp
+			handle(entry)
+		})
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readLogFrom calls handle for the complete entries after offset and returns
+// the offset to resume from
+func readLogFrom(logFile string, offset int64, handle func(LogEntry)) int64 {
+	file, err := os.Open(logFile)
+	if err != nil {
+		return offset
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil && info.Size() < offset {
+		offset = 0
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return offset
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// A partial line is still being written; retry it next poll
+			return offset
+		}
+		offset += int64(len(line))
+
+		var entry LogEntry
+		if json.Unmarshal(line, &entry) == nil {
+			handle(entry)
+		}
+	}
+}
+
+// logEndOffset returns the current size of logFile, so a follower starting
+// there skips history
+func logEndOffset(logFile string) int64 {
+	info, err := os.Stat(logFile)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/events"
+	"ztap/pkg/render"
+
+	"github.com/spf13/cobra"
+)
+
+// maxMaintenance bounds how long drift remediation and alerts can be paused
+// by one window
+const maxMaintenance = 7 * 24 * time.Hour
+
+var maintenanceCmd = &cobra.Command{
+	Use:   "maintenance",
+	Short: "Pause drift remediation and alerts during planned changes",
+	Long: `Schedule maintenance windows during which agents stop remediating drift and
+alerting, so planned changes do not cause noise.
+
+While a window is open, 'ztap agent' keeps the enforced rules of policies
+selecting workloads in its scope (policies new in scope are enforced once it
+ends), and records flows from those workloads without raising flow_blocked,
+policy_expired or anomaly events. Break-glass and policy expiry still apply.
+A window without --labels covers every workload; with --labels, workloads
+with all of the given labels.
+
+Windows are stored in the cluster config (maintenance.<name>). Scheduling and
+overriding them require a 'ztap user login' session with the operator or
+admin role; they are recorded in maintenance events, as are the start and
+end of each window seen by an agent.`,
+}
+
+var maintenanceStartCmd = &cobra.Command{
+	Use:   "start <name> --duration 2h",
+	Short: "Open or schedule a maintenance window",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		duration, _ := cmd.Flags().GetDuration("duration")
+		at, _ := cmd.Flags().GetString("at")
+		labels, _ := cmd.Flags().GetStringToString("labels")
+		reason, _ := cmd.Flags().GetString("reason")
+		if duration <= 0 || duration > maxMaintenance {
+			failf(exitValidation, "--duration must be between 0 and %s", maxMaintenance)
+		}
+
+		start := time.Now()
+		if at != "" {
+			var err error
+			if start, err = time.Parse(time.RFC3339, at); err != nil {
+				failf(exitValidation, "--at must be an RFC 3339 time, e.g. 2025-10-31T22:00:00Z")
+			}
+		}
+		start = start.UTC().Truncate(time.Second)
+		window := cluster.MaintenanceWindow{Name: args[0], Start: start, End: start.Add(duration), Selector: labels}
+
+		session, err := requireSession(auth.PermEnforce)
+		if err != nil {
+			fail(err)
+		}
+		store, err := getClusterConfigStore()
+		if err != nil {
+			fail(err)
+		}
+
+		key := cluster.ConfigMaintenancePrefix + window.Name
+		previous, open := openMaintenance(store, key)
+		if _, err := store.Set(key, window.Value(), session.Username); err != nil {
+			fail(err)
+		}
+		if open {
+			previous.Name = window.Name
+			publishMaintenance(events.MaintenanceOverridden, previous, session.Username, reason)
+		}
+		publishMaintenance(events.MaintenanceScheduled, window, session.Username, reason)
+
+		scope := window.Scope()
+		if scope == "" {
+			scope = "all workloads"
+		}
+		printer.Printf("Maintenance window %s for %s: %s to %s\n", window.Name, scope,
+			render.Cell(window.Start), render.Cell(window.End))
+		printer.Printf("Drift remediation and alerts are paused in scope while the window is open\n")
+	},
+}
+
+var maintenanceEndCmd = &cobra.Command{
+	Use:   "end <name>",
+	Short: "Close or cancel a maintenance window now",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		reason, _ := cmd.Flags().GetString("reason")
+
+		session, err := requireSession(auth.PermEnforce)
+		if err != nil {
+			fail(err)
+		}
+		store, err := getClusterConfigStore()
+		if err != nil {
+			fail(err)
+		}
+
+		key := cluster.ConfigMaintenancePrefix + args[0]
+		window, open := openMaintenance(store, key)
+		if err := store.Delete(key, session.Username); err != nil {
+			fail(err)
+		}
+		if open {
+			window.Name = args[0]
+			publishMaintenance(events.MaintenanceOverridden, window, session.Username, reason)
+			printer.Printf("Maintenance window %s ended early; drift remediation and alerts resume\n", args[0])
+			return
+		}
+		printer.Printf("Maintenance window %s removed\n", args[0])
+	},
+}
+
+var maintenanceListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List maintenance windows",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := getClusterConfigStore()
+		if err != nil {
+			fail(err)
+		}
+
+		now := time.Now()
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "Name", Key: "name"},
+				{Header: "Scope", Key: "scope"},
+				{Header: "Start", Key: "start"},
+				{Header: "End", Key: "end"},
+				{Header: "Status", Key: "status"},
+				{Header: "By", Key: "by"},
+			},
+			Empty: "No maintenance windows",
+		}
+		for _, window := range cluster.MaintenanceWindows(store) {
+			status := "scheduled"
+			switch {
+			case window.Active(now):
+				status = "open"
+			case !now.Before(window.End):
+				status = "ended"
+			}
+			scope := window.Scope()
+			if scope == "" {
+				scope = "*"
+			}
+			table.AddRow(window.Name, scope, window.Start, window.End, status, window.By)
+		}
+		if err := printer.Table(table); err != nil {
+			fail(err)
+		}
+	},
+}
+
+// openMaintenance returns the window stored under key if it is open now
+func openMaintenance(store cluster.ConfigStore, key string) (cluster.MaintenanceWindow, bool) {
+	entry, ok := store.Get(key)
+	if !ok {
+		return cluster.MaintenanceWindow{}, false
+	}
+	window, err := cluster.ParseMaintenanceWindow("", entry.Value)
+	return window, err == nil && window.Active(time.Now())
+}
+
+func publishMaintenance(action string, window cluster.MaintenanceWindow, principal, reason string) {
+	events.Default().Publish(events.TopicMaintenance, events.Maintenance{
+		Name:      window.Name,
+		Action:    action,
+		Principal: principal,
+		Start:     window.Start,
+		End:       window.End,
+		Selector:  window.Selector,
+		Reason:    reason,
+	})
+}
+
+func init() {
+	maintenanceStartCmd.Flags().Duration("duration", time.Hour, "How long the window stays open (at most 7 days)")
+	maintenanceStartCmd.Flags().String("at", "", "When the window opens, RFC 3339 (default: now)")
+	maintenanceStartCmd.Flags().StringToString("labels", nil, "Only cover workloads with these labels (default: all workloads)")
+	maintenanceStartCmd.Flags().String("reason", "", "Why the window is needed, recorded in the maintenance event")
+	maintenanceEndCmd.Flags().String("reason", "", "Why the window is ended early, recorded in the maintenance event")
+
+	maintenanceCmd.AddCommand(maintenanceStartCmd)
+	maintenanceCmd.AddCommand(maintenanceEndCmd)
+	maintenanceCmd.AddCommand(maintenanceListCmd)
+	rootCmd.AddCommand(maintenanceCmd)
+}
@@ -20,7 +20,7 @@ var metricsCmd = &cobra.Command{
 		fmt.Println("Press Ctrl+C to stop")
 
 		if err := metrics.StartServer(port); err != nil {
-			fmt.Printf("Error: Failed to start metrics server: %v\n", err)
+			fail(fmt.Errorf("failed to start metrics server: %w", err))
 		}
 	},
 }
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"ztap/pkg/approval"
+	"ztap/pkg/auth"
+	"ztap/pkg/logging"
+	"ztap/pkg/render"
+	"ztap/pkg/storage"
+
+	"github.com/spf13/cobra"
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Review policy changes, prune unused rules and test policies",
+	Long: `List, approve and reject high-risk policy changes, and prune egress rules
+that no traffic uses.
+
+Policies stored through the API that match a rule in the approval section of
+config.yaml are held as pending changes. A pending change is stored only once
+an admin other than its author approves it. Approving and rejecting require a 'ztap user login' session with the
+admin role. Against an API server (see 'ztap config'), changes are reviewed
+through its API.
+
+'ztap policy prune' reports egress rules that matched no traffic for a period,
+'ztap policy test' checks a policy file against the verdicts its test file
+expects, 'ztap policy diff' shows what re-enforcing it would change and
+'ztap policy search' finds the rules covering a destination.`,
+}
+
+var policyPendingCmd = &cobra.Command{
+	Use:   "pending",
+	Short: "List policy changes awaiting approval",
+	Run: func(cmd *cobra.Command, args []string) {
+		var changes []storage.PendingChange
+		if remote := getRemoteClient(); remote != nil {
+			var err error
+			if changes, err = remote.ListApprovals(cmd.Context()); err != nil {
+				fail(err)
+			}
+		} else {
+			gate, err := getPolicyGate()
+			if err != nil {
+				fail(err)
+			}
+			if changes, err = gate.Pending(cmd.Context()); err != nil {
+				fail(err)
+			}
+		}
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "ID", Key: "id"},
+				{Header: "Policy", Key: "policy"},
+				{Header: "Requested by", Key: "requested_by"},
+				{Header: "Requested", Key: "requested_at"},
+				{Header: "Reasons", Key: "reasons"},
+			},
+			Empty: "No pending changes",
+		}
+		for _, change := range changes {
+			table.AddRow(change.ID, change.Policy, change.RequestedBy, change.RequestedAt, strings.Join(change.Reasons, "; "))
+		}
+		if err := printer.Table(table); err != nil {
+			fail(err)
+		}
+	},
+}
+
+var policyApproveCmd = &cobra.Command{
+	Use:   "approve <id>",
+	Short: "Approve a pending policy change",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var record storage.PolicyRecord
+		if remote := getRemoteClient(); remote != nil {
+			var err error
+			if record, err = remote.ApproveChange(cmd.Context(), args[0]); err != nil {
+				fail(err)
+			}
+		} else {
+			session, err := requireSession(auth.PermApprove)
+			if err != nil {
+				fail(err)
+			}
+			ctx, err := sessionContext(cmd.Context(), session)
+			if err != nil {
+				fail(err)
+			}
+			gate, err := getPolicyGate()
+			if err != nil {
+				fail(err)
+			}
+			if record, err = gate.Approve(ctx, args[0], session.Username); err != nil {
+				fail(err)
+			}
+		}
+		fmt.Printf("Approved change %s: policy '%s' stored as version %d\n", args[0], record.Name, record.Version)
+	},
+}
+
+var policyRejectCmd = &cobra.Command{
+	Use:   "reject <id>",
+	Short: "Reject a pending policy change",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var change storage.PendingChange
+		if remote := getRemoteClient(); remote != nil {
+			var err error
+			if change, err = remote.RejectChange(cmd.Context(), args[0]); err != nil {
+				fail(err)
+			}
+		} else {
+			session, err := requireSession(auth.PermApprove)
+			if err != nil {
+				fail(err)
+			}
+			ctx, err := sessionContext(cmd.Context(), session)
+			if err != nil {
+				fail(err)
+			}
+			gate, err := getPolicyGate()
+			if err != nil {
+				fail(err)
+			}
+			if change, err = gate.Reject(ctx, args[0], session.Username); err != nil {
+				fail(err)
+			}
+		}
+		fmt.Printf("Rejected change %s to policy '%s' requested by %s\n", change.ID, change.Policy, change.RequestedBy)
+	},
+}
+
+func init() {
+	policyCmd.AddCommand(policyPendingCmd)
+	policyCmd.AddCommand(policyApproveCmd)
+	policyCmd.AddCommand(policyRejectCmd)
+	rootCmd.AddCommand(policyCmd)
+}
+
+// getPolicyGate returns the approval gate over the configured stores. It
+// works without approval rules too, so changes held earlier can still be
+// reviewed.
+func getPolicyGate() (*approval.Gate, error) {
+	policies, err := getPolicyStore()
+	if err != nil {
+		return nil, err
+	}
+	gate, _, err := getApprovalGate(policies)
+	return gate, err
+}
+
+// sessionContext scopes ctx to the tenant session acts in: --tenant, which
+// a tenant's users may only set to their own tenant
+func sessionContext(ctx context.Context, session *auth.Session) (context.Context, error) {
+	tenant, err := session.ResolveTenant(activeTenant)
+	if err != nil {
+		return nil, err
+	}
+	return auth.WithTenant(ctx, tenant), nil
+}
+
+// requireSession returns the 'ztap user login' session, which must hold perm
+func requireSession(perm auth.Permission) (*auth.Session, error) {
+	token, err := os.ReadFile(getTokenFile())
+	if err != nil {
+		return nil, authError(errors.New("not logged in; run 'ztap user login' first"))
+	}
+	logging.Register(strings.TrimSpace(string(token)))
+	am, err := getAuthManager()
+	if err != nil {
+		return nil, err
+	}
+	if err := am.HasPermission(strings.TrimSpace(string(token)), perm); err != nil {
+		return nil, err
+	}
+	return am.ValidateSession(strings.TrimSpace(string(token)))
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ztap/pkg/applied"
+	"ztap/pkg/policy"
+
+	"github.com/spf13/cobra"
+)
+
+var policyDiffCmd = &cobra.Command{
+	Use:   "diff [-f policy.yaml] [--ref main]",
+	Short: "Show what re-enforcing a policy file would change",
+	Long: `Compare the policies last enforced on this host with the working tree copy
+of their file, or with the file at another Git ref.
+
+'ztap enforce' and 'ztap agent' record the enforced file in
+~/.ztap/applied.json, with the commit SHA and author it was read at when the
+file is in a Git checkout ("+dirty" when it had uncommitted changes). The
+diff lists policies added, removed and changed, with the podSelector,
+expiry and egress rules (one per peer and port) that differ.
+
+  ztap policy diff                 # applied vs. working tree
+  ztap policy diff --ref origin/main
+  ztap policy diff --exit-code     # exit 1 when re-enforcing changes something`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("file")
+		ref, _ := cmd.Flags().GetString("ref")
+		exitCode, _ := cmd.Flags().GetBool("exit-code")
+
+		state, err := applied.Load(getAppliedStatePath())
+		if err != nil {
+			fail(err)
+		}
+		if state == nil {
+			failf(exitFailure, "no policies applied on this host yet; run 'ztap enforce' or 'ztap agent'")
+		}
+		if file == "" {
+			file = state.File
+		} else if abs, _ := filepath.Abs(file); abs != state.File {
+			log.Printf("Warning: The applied policies were read from %s", state.File)
+		}
+
+		var target, content string
+		if ref != "" {
+			var commit string
+			if content, commit, err = applied.Show(file, ref); err != nil {
+				fail(err)
+			}
+			target = fmt.Sprintf("%s at %s (%s)", file, ref, applied.Revision{Commit: commit}.Short())
+		} else {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				fail(err)
+			}
+			content, target = string(data), file+" (working tree)"
+		}
+
+		before, err := policy.Parse([]byte(state.YAML))
+		if err != nil {
+			fail(fmt.Errorf("failed to parse applied policies: %w", err))
+		}
+		after, err := policy.Parse([]byte(content))
+		if err != nil {
+			fail(validationErrorf("failed to parse %s: %w", target, err))
+		}
+
+		fmt.Printf("Applied: %s\n", describeApplied(state))
+		fmt.Printf("Compared with: %s\n\n", target)
+
+		changes := policy.DiffPolicies(before, after)
+		if len(changes) == 0 {
+			fmt.Println("No changes; re-enforcing would leave the policies as they are")
+			return
+		}
+		counts := map[string]int{}
+		for _, change := range changes {
+			counts[change.Kind]++
+			fmt.Printf("%s %s\n", diffMarker(change.Kind), change.Policy)
+			for _, field := range change.Fields {
+				fmt.Printf("    ~ %s\n", field)
+			}
+			for _, rule := range change.Removed {
+				fmt.Printf("    - %s\n", rule)
+			}
+			for _, rule := range change.Added {
+				fmt.Printf("    + %s\n", rule)
+			}
+		}
+		fmt.Printf("\n%d added, %d changed, %d removed\n",
+			counts[policy.ChangeAdded], counts[policy.ChangeChanged], counts[policy.ChangeRemoved])
+		if exitCode {
+			os.Exit(exitFailure)
+		}
+	},
+}
+
+func init() {
+	policyDiffCmd.Flags().StringP("file", "f", "", "Policy file to compare with (default: the file last applied)")
+	policyDiffCmd.Flags().String("ref", "", "Compare with the file at this Git commit, branch or tag instead of the working tree")
+	policyDiffCmd.Flags().Bool("exit-code", false, "Exit with status 1 when there are changes")
+	policyCmd.AddCommand(policyDiffCmd)
+}
+
+func getAppliedStatePath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/ztap-applied.json"
+	}
+	return filepath.Join(homeDir, ".ztap", "applied.json")
+}
+
+// diffMarker prefixes a policy in the diff by its change kind
+func diffMarker(kind string) string {
+	switch kind {
+	case policy.ChangeAdded:
+		return "+"
+	case policy.ChangeRemoved:
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+// describeApplied summarizes where and when the applied policies came from
+func describeApplied(state *applied.State) string {
+	source := state.File
+	if state.Revision != nil {
+		source += fmt.Sprintf(" at %s by %s", state.Revision.Short(), state.Revision.Author)
+	}
+	when := state.AppliedAt.Local().Format("2006-01-02 15:04:05")
+	if state.AppliedBy != "" {
+		return fmt.Sprintf("%s, enforced %s by %s", source, when, state.AppliedBy)
+	}
+	return fmt.Sprintf("%s, enforced %s", source, when)
+}
+
+// saveApplied records snapshot as the applied state, enforced now by
+// principal
+func saveApplied(snapshot applied.State, principal string) {
+	snapshot.AppliedAt = time.Now().UTC()
+	snapshot.AppliedBy = principal
+	if err := applied.Save(getAppliedStatePath(), snapshot); err != nil {
+		log.Printf("Warning: Failed to record applied policies: %v", err)
+	}
+}
+
+// appliedRecorder loads a policy file for the agent and records the last
+// loaded snapshot as applied after each successful cycle, when it differs
+// from the one recorded before
+type appliedRecorder struct {
+	file      string
+	principal string
+
+	mu     sync.Mutex
+	loaded applied.State
+	saved  applied.State
+}
+
+// load snapshots the policy file and parses the policies in it
+func (r *appliedRecorder) load() ([]policy.NetworkPolicy, error) {
+	snapshot, err := applied.Snapshot(r.file)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := policy.Parse([]byte(snapshot.YAML))
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.loaded = snapshot
+	r.mu.Unlock()
+	return policies, nil
+}
+
+// record saves the last loaded snapshot unless it is already recorded
+func (r *appliedRecorder) record() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.loaded.Same(r.saved) {
+		return
+	}
+	saveApplied(r.loaded, r.principal)
+	r.saved = r.loaded
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"ztap/pkg/policy"
+	"ztap/pkg/render"
+
+	"github.com/spf13/cobra"
+)
+
+var policySearchCmd = &cobra.Command{
+	Use:   "search --dest IP [--port N] [-f policy.yaml]",
+	Short: "Find the policies whose rules cover a destination",
+	Long: `Compile the policies of a policy file and list the rules covering traffic to
+a destination IP, optionally on one port and protocol: egress rules whose
+ipBlock contains it or whose podSelector or endpoint group resolves to it,
+and ingress rules of policies whose podSelector selects it. Selectors are
+resolved through service discovery and, with --aws-region or --aws-accounts,
+AWS inventory.
+
+Against an API server (see 'ztap config'), the policies stored on the server
+are searched instead, with the server's service discovery.
+
+  ztap policy search --dest 10.0.2.5 --port 5432
+  ztap policy search --dest 10.0.2.5 -o json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("file")
+		query := policy.SearchQuery{}
+		query.Dest, _ = cmd.Flags().GetString("dest")
+		query.Port, _ = cmd.Flags().GetInt("port")
+		query.Protocol, _ = cmd.Flags().GetString("protocol")
+		if query.Dest == "" {
+			failf(exitValidation, "--dest is required")
+		}
+		if err := query.Validate(); err != nil {
+			fail(validationError(err))
+		}
+
+		var result policy.SearchResult
+		if remote := getRemoteClient(); remote != nil {
+			var err error
+			if result, err = remote.SearchPolicies(cmd.Context(), query); err != nil {
+				fail(err)
+			}
+		} else {
+			policies, err := policy.LoadFromFile(file)
+			if err != nil {
+				fail(validationErrorf("failed to load policies: %w", err))
+			}
+			resolver, _, err := newPolicyResolver(cmd)
+			if err != nil {
+				fail(err)
+			}
+			result = policy.NewRuleIndex(resolver, policies).Search(query)
+		}
+
+		if printer.Structured() {
+			if err := printer.Value(result); err != nil {
+				fail(err)
+			}
+			return
+		}
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "Policy", Key: "policy"},
+				{Header: "Direction", Key: "direction"},
+				{Header: "Rule", Key: "rule"},
+				{Header: "Matched through", Key: "source"},
+			},
+			Empty: "No rules cover " + query.Dest,
+		}
+		for _, match := range result.Matches {
+			direction, arrow := policy.DirectionEgress, "->"
+			if match.Ingress() {
+				direction, arrow = policy.DirectionIngress, "<-"
+			}
+			table.AddRow(match.Policy, direction, fmt.Sprintf("%s/%s %s %s", match.Protocol, match.PortRange(), arrow, match.CIDR), match.Source)
+		}
+		if err := printer.Table(table); err != nil {
+			fail(err)
+		}
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+	},
+}
+
+func init() {
+	policySearchCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
+	policySearchCmd.Flags().String("dest", "", "Destination IP to find the rules of")
+	policySearchCmd.Flags().Int("port", 0, "Only rules for this port (default: any)")
+	policySearchCmd.Flags().String("protocol", "", "Only rules for this protocol, e.g. TCP (default: any)")
+	policySearchCmd.Flags().String("aws-region", "", "Also resolve label selectors against AWS resources in this region")
+	policySearchCmd.Flags().String("aws-accounts", "", "Also resolve label selectors against AWS resources in the accounts and regions of this YAML file")
+	policyCmd.AddCommand(policySearchCmd)
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"ztap/pkg/policy"
+	"ztap/pkg/policytest"
+
+	"github.com/spf13/cobra"
+)
+
+var policyTestCmd = &cobra.Command{
+	Use:   "test -f policy.yaml [test files...]",
+	Short: "Check a policy file against the verdicts its tests expect",
+	Long: `Evaluate the assertions of policy test files against a policy file and
+report which pass. Without test files, the one alongside the policy file is
+used: web-to-db_test.yaml for web-to-db.yaml.
+
+A test file lists flows and the verdict they should get:
+
+  tests:
+    - name: web reaches the database
+      from: {labels: {app: web}}
+      to: {labels: {app: db}}
+      port: 5432
+      expect: allow
+    - name: web cannot reach the internet
+      from: {labels: {app: web}}
+      to: {ip: 203.0.113.10}
+      port: 443
+      protocol: TCP # the default
+      expect: deny
+
+Flows are evaluated as enforcement would, without touching the backend: a
+source no policy selects is not enforced and may reach anything; a selected
+source reaches only what an egress rule of a selecting policy allows on the
+port. Destination labels are matched against podSelectors directly, and
+destination IPs against ipBlocks.
+
+Exits with status 1 when a test fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policyFile, _ := cmd.Flags().GetString("file")
+		verbose, _ := cmd.Flags().GetBool("verbose")
+
+		policies, err := policy.LoadFromFile(policyFile)
+		if err != nil {
+			fail(validationErrorf("failed to load policies: %w", err))
+		}
+		for i := range policies {
+			if err := policies[i].Validate(); err != nil {
+				fail(err)
+			}
+		}
+
+		testFiles := args
+		if len(testFiles) == 0 {
+			testFiles = []string{policytest.DefaultPath(policyFile)}
+		}
+
+		passed, failed := 0, 0
+		for _, path := range testFiles {
+			cases, err := policytest.Load(path)
+			if err != nil {
+				fail(err)
+			}
+			for _, result := range policytest.Run(policies, path, cases) {
+				if result.Passed {
+					passed++
+					if verbose {
+						fmt.Printf("PASS  %s (%s)\n", result.Case.Name, result.Verdict.Reason)
+					}
+					continue
+				}
+				failed++
+				got := policytest.ExpectDeny
+				if result.Verdict.Allowed {
+					got = policytest.ExpectAllow
+				}
+				fmt.Printf("FAIL  %s: %s\n", result.Case.Name, path)
+				fmt.Printf("      %s: expected %s, got %s (%s)\n", result.Case, result.Case.Expect, got, result.Verdict.Reason)
+			}
+		}
+
+		fmt.Printf("\n%d passed, %d failed\n", passed, failed)
+		if failed > 0 {
+			os.Exit(exitFailure)
+		}
+	},
+}
+
+func init() {
+	policyTestCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
+	policyTestCmd.Flags().BoolP("verbose", "v", false, "Also list passing tests with the rule deciding them")
+	policyCmd.AddCommand(policyTestCmd)
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"ztap/pkg/policy"
+	"ztap/pkg/prune"
+	"ztap/pkg/stats"
+
+	"github.com/spf13/cobra"
+)
+
+var policyPruneCmd = &cobra.Command{
+	Use:   "prune -f policy.yaml --unused-for 30d",
+	Short: "Report egress rules that matched no traffic",
+	Long: `Report the egress rules of a policy file that have not matched any allowed
+traffic for a period, from the rule hits the agent records in the stats file.
+
+Each port of an egress rule is checked on its own. A hit matches a rule of the
+policy it was logged under with the same protocol and port whose ipBlock
+contains the destination, or whose podSelector resolves to it. Selectors are
+resolved as they are now, so a hit to an IP no selector resolves to any more
+counts for every selector rule of the policy with that protocol and port.
+
+With -o, the policies are written without the unused rules, as a minimal
+policy file ready for review ("-" writes to stdout). A policy whose rules are
+all unused is kept without egress rules, so it allows nothing. Writing is
+refused when the recorded stats cover less than --unused-for, unless --force
+is given.
+
+  ztap policy prune -f policy.yaml --unused-for 30d -o policy.pruned.yaml`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policyFile, _ := cmd.Flags().GetString("file")
+		unusedFor, _ := cmd.Flags().GetString("unused-for")
+		outputFile, _ := cmd.Flags().GetString("output")
+		force, _ := cmd.Flags().GetBool("force")
+
+		period, err := stats.ParseSince(unusedFor)
+		if err != nil {
+			fail(err)
+		}
+
+		policies, err := policy.LoadFromFile(policyFile)
+		if err != nil {
+			fail(validationErrorf("failed to load policies: %w", err))
+		}
+
+		buckets, err := stats.Load(getStatsFilePath())
+		if err != nil {
+			fail(err)
+		}
+		since := time.Now().Add(-period)
+		complete := len(buckets) > 0 && !buckets[0].Hour.After(since)
+
+		resolver, _, err := newPolicyResolver(cmd)
+		if err != nil {
+			fail(err)
+		}
+		rules := prune.Analyze(policies, stats.RuleHits(buckets, since), resolver)
+
+		// Keep stdout for the policy file when it is written there
+		var report io.Writer = os.Stdout
+		if outputFile == "-" {
+			report = os.Stderr
+		}
+		printUnusedRules(report, rules, since)
+		if !complete {
+			fmt.Fprintf(report, "\nWarning: stats only cover %s; rules may be used less often than that\n", statsCoverage(buckets))
+		}
+
+		if outputFile == "" {
+			return
+		}
+		if !complete && !force {
+			failf(exitFailure, "stats cover less than --unused-for; use --force to write the pruned policies anyway")
+		}
+
+		pruned, emptied := prune.Prune(policies, rules)
+		for _, name := range emptied {
+			fmt.Fprintf(report, "Warning: all egress rules of policy %s are unused; it will allow nothing\n", name)
+		}
+		data, err := policy.Marshal(pruned)
+		if err != nil {
+			fail(err)
+		}
+		if outputFile == "-" {
+			os.Stdout.Write(data)
+			return
+		}
+		if err := os.WriteFile(outputFile, data, 0644); err != nil {
+			fail(err)
+		}
+		fmt.Fprintf(report, "Wrote pruned policies to %s\n", outputFile)
+	},
+}
+
+func printUnusedRules(w io.Writer, rules []prune.Rule, since time.Time) {
+	fmt.Fprintf(w, "Egress rules unused since %s:\n", since.Format("2006-01-02 15:04"))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  POLICY\tEGRESS\tTO\tPROTOCOL\tPORT")
+	unused := 0
+	for _, r := range rules {
+		if !r.Unused() {
+			continue
+		}
+		fmt.Fprintf(tw, "  %s\t%d\t%s\t%s\t%s\n", r.Policy, r.Egress, r.Target, r.Protocol, policy.PortRange(r.Port, r.EndPort))
+		unused++
+	}
+	tw.Flush()
+	if unused == 0 {
+		fmt.Fprintln(w, "  None")
+	}
+	fmt.Fprintf(w, "\n%d of %d rules unused\n", unused, len(rules))
+}
+
+// statsCoverage describes how far back the recorded stats go
+func statsCoverage(buckets []*stats.Bucket) string {
+	if len(buckets) == 0 {
+		return "no time (no stats recorded)"
+	}
+	return fmt.Sprintf("the time since %s", buckets[0].Hour.Local().Format("2006-01-02 15:04"))
+}
+
+func init() {
+	policyPruneCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
+	policyPruneCmd.Flags().String("unused-for", "30d", "Report rules with no hits in this period (e.g. 7d, 30d, 2w)")
+	policyPruneCmd.Flags().StringP("output", "o", "", "Write the policies without unused rules to this file (- for stdout)")
+	policyPruneCmd.Flags().Bool("force", false, "Write pruned policies even if stats cover less than --unused-for")
+	policyPruneCmd.Flags().String("aws-region", "", "Also resolve selectors to AWS resources in this region")
+	policyPruneCmd.Flags().String("aws-accounts", "", "Also resolve selectors to AWS resources in the accounts and regions of this YAML file")
+	policyCmd.AddCommand(policyPruneCmd)
+}
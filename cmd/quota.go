@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"ztap/pkg/events"
+	"ztap/pkg/quota"
+
+	"github.com/spf13/cobra"
+)
+
+var quotaCmd = &cobra.Command{
+	Use:   "quota",
+	Short: "Show quota usage of the tenant",
+	Long: `Show what the tenant selected with --tenant uses of its quotas.
+
+Quotas are set in the quotas section of ~/.ztap/config.yaml (or
+$ZTAP_CONFIG): default limits for every tenant, overridden per tenant.
+
+  quotas:
+    default:
+      max_policies: 50
+      max_rules_per_policy: 200
+      max_services: 500
+    tenants:
+      acme:
+        max_policies: 10
+
+A policy over the limits is refused when stored through the API server or
+approved, and a service over max_services when registered. A policy's rules
+are its egress and ingress peer and port pairs. Services count those
+registered and not deregistered in the event journal.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadSection(quota.LoadConfig)
+		if err != nil {
+			fail(err)
+		}
+		policies, err := openPolicyStore()
+		if err != nil {
+			fail(err)
+		}
+		history, err := eventJournal.History(time.Time{}, events.TopicServiceChanged)
+		if err != nil {
+			fail(fmt.Errorf("failed to read event journal: %w", err))
+		}
+
+		usage, err := quota.NewStore(policies, config).Usage(cmd.Context(), history)
+		if err != nil {
+			fail(err)
+		}
+		limits := config.For(activeTenant)
+
+		if activeTenant != "" {
+			fmt.Printf("Tenant: %s\n\n", activeTenant)
+		}
+		largest := ""
+		if usage.LargestPolicy != "" {
+			largest = "largest: " + usage.LargestPolicy
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RESOURCE\tUSED\tLIMIT\t")
+		fmt.Fprintf(w, "%s\t%d\t%s\t\n", quota.ResourcePolicies, usage.Policies, formatLimit(limits.MaxPolicies))
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", quota.ResourceRules, usage.Rules, formatLimit(limits.MaxRulesPerPolicy), largest)
+		fmt.Fprintf(w, "%s\t%d\t%s\t\n", quota.ResourceServices, usage.Services, formatLimit(limits.MaxServices))
+		w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(quotaCmd)
+}
+
+// formatLimit formats a quota limit, 0 being unlimited
+func formatLimit(limit int) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(limit)
+}
+
+// admitService checks that the tenant may register the service name
+func admitService(name string) error {
+	config, err := loadSection(quota.LoadConfig)
+	if err != nil || !config.Enabled() {
+		return err
+	}
+	history, err := eventJournal.History(time.Time{}, events.TopicServiceChanged)
+	if err != nil {
+		return fmt.Errorf("failed to read event journal: %w", err)
+	}
+	return config.AdmitService(history, activeTenant, name)
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ztap/pkg/client"
+
+	"github.com/spf13/cobra"
+)
+
+// serverFlag is --server
+var serverFlag string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&serverFlag, "server", os.Getenv("ZTAP_SERVER"), "URL of a ZTAP API server to manage instead of this host's stores (overrides the context's)")
+}
+
+// remoteServer returns the URL of the API server the CLI manages: --server
+// (or $ZTAP_SERVER), otherwise the server of the active context. It is
+// empty when the CLI manages this host's stores.
+func remoteServer() string {
+	if serverFlag != "" {
+		return serverFlag
+	}
+	return activeContext().Server
+}
+
+// getRemoteClient returns a client for the API server the CLI manages, with
+// the session of 'ztap user login' against it, or nil when it manages this
+// host's stores
+func getRemoteClient() *client.Client {
+	server := remoteServer()
+	if server == "" {
+		return nil
+	}
+	if u, err := url.Parse(server); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		failf(exitValidation, "invalid API server URL %q: use e.g. https://ztap.example.com", server)
+	}
+	token, _ := os.ReadFile(getRemoteTokenFile(server))
+	return client.New(server, strings.TrimSpace(string(token)), activeTenant)
+}
+
+// getRemoteTokenFile returns where the session token for server is kept,
+// one file per server so switching contexts keeps each login
+func getRemoteTokenFile(server string) string {
+	name := server
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		name = u.Host
+	}
+	name = strings.NewReplacer(":", "_", "/", "_").Replace(name)
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".ztap", "sessions", name+".token")
+}
+
+// requireLocal fails commands that only manage this host's stores while the
+// CLI points at an API server, rather than silently changing local state
+func requireLocal(cmd *cobra.Command) {
+	if server := remoteServer(); server != "" {
+		failf(exitValidation, "'%s' is not available against the API server %s; run it on the server host, or without a context", cmd.CommandPath(), server)
+	}
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"ztap/pkg/policy"
+	"ztap/pkg/replay"
+
+	"github.com/spf13/cobra"
+)
+
+var replayCmd = &cobra.Command{
+	Use:   "replay --pcap capture.pcap -f policy.yaml",
+	Short: "Report which packets of a capture a policy set would block",
+	Long: `Read a packet capture (pcap or pcapng, e.g. from tcpdump or Wireshark),
+evaluate each flow in it against a policy file and report which flows and
+packets would be allowed or blocked, without touching the backend.
+
+Packets are grouped into connections, and connections into flows from one
+source to one destination port. Replies count with the connection they
+belong to, as enforcement keeps state. Flows are evaluated like 'ztap policy
+test': a source no policy selects is not enforced; a selected source reaches
+only what an egress rule of a selecting policy allows.
+
+Sources and destinations are matched by IP. Their labels, for podSelectors,
+come from registered services, --workloads (a YAML list of name, ip and
+labels) and, with --aws-region or --aws-accounts, AWS inventory. ICMP is
+evaluated with port 0, as the eBPF backend sees it. Packets other than TCP,
+UDP and ICMP over IPv4 or IPv6 are skipped.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policyFile, _ := cmd.Flags().GetString("file")
+		pcapFile, _ := cmd.Flags().GetString("pcap")
+		workloadsFile, _ := cmd.Flags().GetString("workloads")
+		output, _ := cmd.Flags().GetString("output")
+		blockedOnly, _ := cmd.Flags().GetBool("blocked")
+		if pcapFile == "" {
+			failf(exitValidation, "--pcap is required")
+		}
+
+		policies, err := policy.LoadFromFile(policyFile)
+		if err != nil {
+			fail(validationErrorf("failed to load policies: %w", err))
+		}
+		for i := range policies {
+			if err := policies[i].Validate(); err != nil {
+				fail(err)
+			}
+		}
+
+		workloads, err := collectWorkloads(cmd, workloadsFile)
+		if err != nil {
+			fail(err)
+		}
+		labels := make(map[string]map[string]string)
+		for _, w := range workloads {
+			if _, ok := labels[w.IP]; w.IP != "" && !ok {
+				labels[w.IP] = w.Labels
+			}
+		}
+
+		f, err := os.Open(pcapFile)
+		if err != nil {
+			fail(err)
+		}
+		defer f.Close()
+		reader, err := replay.NewReader(f)
+		if err != nil {
+			fail(validationErrorf("%s: %w", pcapFile, err))
+		}
+		report, err := replay.Replay(policies, reader, labels)
+		if err != nil {
+			fail(validationErrorf("%s: %w", pcapFile, err))
+		}
+
+		switch output {
+		case "table":
+			printReplay(report, blockedOnly)
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(report)
+		default:
+			err = fmt.Errorf("unknown output format %q (use table or json)", output)
+		}
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
+func printReplay(report *replay.Report, blockedOnly bool) {
+	blocked := report.Blocked()
+	fmt.Printf("Flows:   %d (%d packets, %d skipped)\n", len(report.Flows), report.AllowedPackets+report.BlockedPackets, report.Skipped)
+	fmt.Printf("Allowed: %d flows, %d packets\n", len(report.Flows)-blocked, report.AllowedPackets)
+	fmt.Printf("Blocked: %d flows, %d packets\n", blocked, report.BlockedPackets)
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERDICT\tSOURCE\tDESTINATION\tPORT\tCONNECTIONS\tPACKETS\tREASON")
+	listed := 0
+	for _, flow := range report.Flows {
+		if blockedOnly && flow.Allowed {
+			continue
+		}
+		verdict := "BLOCK"
+		if flow.Allowed {
+			verdict = "ALLOW"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s/%d\t%d\t%d\t%s\n",
+			verdict, flow.Src, flow.Dst, flow.Protocol, flow.Port, flow.Connections, flow.Packets, flow.Reason)
+		listed++
+	}
+	w.Flush()
+	if listed == 0 {
+		fmt.Println("  None")
+	}
+}
+
+func init() {
+	replayCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
+	replayCmd.Flags().String("pcap", "", "Packet capture to replay (pcap or pcapng)")
+	replayCmd.Flags().String("workloads", "", "YAML list of workloads (name, ip, labels) labelling the capture's addresses")
+	replayCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+	replayCmd.Flags().Bool("blocked", false, "List only blocked flows")
+	replayCmd.Flags().String("aws-region", "", "Also label addresses from AWS resources in this region")
+	replayCmd.Flags().String("aws-accounts", "", "Also label addresses from AWS resources in the accounts and regions of this YAML file")
+	rootCmd.AddCommand(replayCmd)
+}
@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ztap/pkg/compliance"
+	"ztap/pkg/policy"
+	"ztap/pkg/stats"
+
+	"github.com/spf13/cobra"
+)
+
+// statsFlushInterval is how often long-running commands persist statistics
+const statsFlushInterval = time.Minute
+
+// statsRecorder aggregates enforcement statistics for this process; they are
+// flushed after every command and periodically by long-running ones
+var statsRecorder = stats.NewRecorder(getStatsFilePath())
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Summarize historical enforcement statistics",
+	Long: `Summarize hourly enforcement statistics: flows allowed and blocked per
+policy, the most-blocked destinations, daily policy hit trends, and anomaly
+counts. Periods accept h, d, and w units (e.g. 24h, 7d, 2w).`,
+	Run: func(cmd *cobra.Command, args []string) {
+		since, _ := cmd.Flags().GetString("since")
+		output, _ := cmd.Flags().GetString("output")
+		top, _ := cmd.Flags().GetInt("top")
+
+		period, err := stats.ParseSince(since)
+		if err != nil {
+			fail(err)
+		}
+
+		// Include statistics recorded by this process that are not yet flushed
+		if err := statsRecorder.Flush(); err != nil {
+			fmt.Printf("Warning: Failed to flush statistics: %v\n", err)
+		}
+
+		buckets, err := stats.Load(getStatsFilePath())
+		if err != nil {
+			fail(err)
+		}
+
+		now := time.Now()
+		report := stats.BuildReport(buckets, now.Add(-period), now, top)
+		report.Attribute(newAttributor(nil).Name)
+
+		switch output {
+		case "table":
+			err = report.WriteTable(os.Stdout)
+		case "json":
+			err = report.WriteJSON(os.Stdout)
+		case "html":
+			err = report.WriteHTML(os.Stdout)
+		default:
+			err = validationErrorf("unknown output format %q (use table, json, or html)", output)
+		}
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
+var complianceCmd = &cobra.Command{
+	Use:   "compliance -f policy.yaml",
+	Short: "Generate a signed compliance report",
+	Long: `Map enforced policies, cluster configuration, and enforcement audit records
+onto PCI DSS / SOC 2 style control statements (segmentation, default deny,
+change history retention, change attribution).
+
+Reports are signed with an Ed25519 key kept at ~/.ztap/report-signing.key and
+generated on first use. Use HTML for a print-ready (PDF) artifact and JSON for
+a machine-verifiable one.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policyFile, _ := cmd.Flags().GetString("file")
+		output, _ := cmd.Flags().GetString("output")
+		retention, _ := cmd.Flags().GetString("retention")
+
+		period, err := stats.ParseSince(retention)
+		if err != nil {
+			fail(err)
+		}
+
+		policies, err := policy.LoadFromFile(policyFile)
+		if err != nil {
+			fail(validationErrorf("failed to load policies: %w", err))
+		}
+
+		store, err := getClusterConfigStore()
+		if err != nil {
+			fail(err)
+		}
+
+		records, oldest, err := readAuditRecords(getLogFilePath())
+		if err != nil {
+			fail(err)
+		}
+
+		report := compliance.Generate(compliance.Evidence{
+			Policies:     policies,
+			Config:       store.List(),
+			AuditRecords: records,
+			OldestAudit:  oldest,
+			Retention:    period,
+		}, version, time.Now())
+
+		key, err := compliance.LoadOrCreateSigningKey(getSigningKeyPath())
+		if err != nil {
+			fail(err)
+		}
+		if err := report.Sign(key); err != nil {
+			fail(fmt.Errorf("failed to sign report: %w", err))
+		}
+
+		switch output {
+		case "table":
+			err = report.WriteTable(os.Stdout)
+		case "json":
+			err = report.WriteJSON(os.Stdout)
+		case "html":
+			err = report.WriteHTML(os.Stdout)
+		default:
+			err = validationErrorf("unknown output format %q (use table, json, or html)", output)
+		}
+		if err != nil {
+			fail(err)
+		}
+	},
+}
+
+var complianceVerifyCmd = &cobra.Command{
+	Use:   "verify <report.json>",
+	Short: "Verify the signature of a JSON compliance report",
+	Long: `Verify a JSON compliance report against the issuer's public key.
+
+Pass the key the issuer published with --public-key. Without it, the report
+must be signed by this host's key (~/.ztap/report-signing.key); the key
+embedded in the report is never trusted on its own.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		publicKey, _ := cmd.Flags().GetString("public-key")
+
+		report, err := compliance.LoadReport(args[0])
+		if err != nil {
+			fail(err)
+		}
+
+		trusted, err := trustedReportKey(publicKey)
+		if err != nil {
+			fail(err)
+		}
+		if err := report.Verify(trusted); err != nil {
+			fail(err)
+		}
+		fmt.Printf("Signature valid (Ed25519 key %s)\n", report.PublicKey)
+	},
+}
+
+func init() {
+	complianceCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
+	complianceCmd.Flags().StringP("output", "o", "table", "Output format (table, json, html)")
+	complianceCmd.Flags().String("retention", "365d", "Audit history the change retention control requires")
+	complianceVerifyCmd.Flags().String("public-key", "", "Issuer's base64 Ed25519 public key (default: this host's signing key)")
+	complianceCmd.AddCommand(complianceVerifyCmd)
+	reportCmd.AddCommand(complianceCmd)
+
+	reportCmd.Flags().String("since", "7d", "Report period (e.g. 24h, 7d, 2w)")
+	reportCmd.Flags().StringP("output", "o", "table", "Output format (table, json, html)")
+	reportCmd.Flags().Int("top", 10, "Number of top blocked destinations to show")
+	rootCmd.AddCommand(reportCmd)
+}
+
+// trustedReportKey returns the key reports are verified against: publicKey
+// if given, else the public half of the local signing key
+func trustedReportKey(publicKey string) (ed25519.PublicKey, error) {
+	if publicKey != "" {
+		return compliance.ParsePublicKey(publicKey)
+	}
+
+	key, err := compliance.LoadSigningKey(getSigningKeyPath())
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: pass the issuer's key with --public-key", compliance.ErrNoTrustedKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key.Public().(ed25519.PublicKey), nil
+}
+
+// readAuditRecords counts enforcement log entries and returns the oldest timestamp
+func readAuditRecords(logFile string) (int, time.Time, error) {
+	file, err := os.Open(logFile)
+	if os.IsNotExist(err) {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer file.Close()
+
+	count := 0
+	var oldest time.Time
+	decoder := json.NewDecoder(file)
+	for {
+		var entry LogEntry
+		if err := decoder.Decode(&entry); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return count, oldest, fmt.Errorf("failed to parse log file: %w", err)
+		}
+		count++
+		if oldest.IsZero() || entry.Timestamp.Before(oldest) {
+			oldest = entry.Timestamp
+		}
+	}
+	return count, oldest, nil
+}
+
+func getSigningKeyPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/ztap-report-signing.key"
+	}
+	return filepath.Join(homeDir, ".ztap", "report-signing.key")
+}
+
+func getStatsFilePath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/ztap-stats.json"
+	}
+	return filepath.Join(homeDir, ".ztap", "stats.json")
+}
@@ -1,20 +1,115 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/events"
+	"ztap/pkg/logging"
+	"ztap/pkg/metrics"
+	"ztap/pkg/render"
 
 	"github.com/spf13/cobra"
 )
 
+// version is the ZTAP build version, set at build time with
+// -ldflags "-X ztap/cmd.version=v1.2.3"
+var version = "dev"
+
 var rootCmd = &cobra.Command{
-	Use:   "ztap",
-	Short: "Zero Trust Access Platform - Microsegmentation for hybrid environments",
+	Use:     "ztap",
+	Version: version,
+	Short:   "Zero Trust Access Platform - Microsegmentation for hybrid environments",
 	Long: `ZTAP enforces zero-trust network policies across on-premises and cloud workloads.
 It uses eBPF on Linux and pf on macOS to enforce fine-grained traffic rules.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Arguments and flags parsed: usage is no longer shown on errors
+		commandStarted, cmd.SilenceUsage = true, true
+		setErrorFormat(cmd)
+		setPrinter(cmd)
+		applyContext(cmd)
+		migrateState(cmd)
+		if err := auth.ValidateTenant(activeTenant); err != nil {
+			fail(validationErrorf("--tenant: %w", err))
+		}
+		// Events and stored records of this process belong to the tenant
+		events.Default().SetTenant(activeTenant)
+		cmd.SetContext(auth.WithTenant(cmd.Context(), activeTenant))
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		if err := statsRecorder.Flush(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to save statistics: %v\n", err)
+		}
+	},
+}
+
+// activeTenant is the tenant this process acts in (--tenant, or
+// $ZTAP_TENANT); empty for single-tenant installs
+var activeTenant string
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&activeTenant, "tenant", os.Getenv("ZTAP_TENANT"), "Tenant (organization) to act in when the server is shared by several")
+	rootCmd.PersistentFlags().StringP("output", "o", "text", "Output format: text (table), json or yaml; errors are printed as JSON with json")
+	rootCmd.PersistentFlags().BoolVarP(&quietOutput, "quiet", "q", false, "Print only the first column of lists (e.g. IDs) and no status messages")
+	rootCmd.SilenceErrors = true
+
+	// Log lines are scrubbed of secrets before they are written
+	logging.Install()
+
+	// Metrics are derived from events so producers stay decoupled from exporters
+	metrics.RecordEvents(events.Default())
+	statsRecorder.RecordEvents(events.Default())
+	// Bridges events to 'ztap serve', which runs in a separate process
+	eventJournal.Record(events.Default())
+}
+
+// quietOutput is --quiet
+var quietOutput bool
+
+// printer renders command output per --output and --quiet (see pkg/render).
+// Commands with their own --output flag, such as export, print tables.
+var printer = render.New(os.Stdout, render.FormatTable, false)
+
+// setPrinter sets printer from cmd's flags
+func setPrinter(cmd *cobra.Command) {
+	format := render.FormatTable
+	if flag := cmd.Flags().Lookup("output"); flag == cmd.Root().PersistentFlags().Lookup("output") {
+		var err error
+		if format, err = render.ParseFormat(flag.Value.String()); err != nil {
+			fail(validationErrorf("--output: %w", err))
+		}
+	}
+	printer = render.New(os.Stdout, format, quietOutput)
 }
 
+// eventJournal is shared by every ztap process on the host
+var eventJournal = events.NewJournal(getEventJournalPath())
+
+func getEventJournalPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/ztap-events.jsonl"
+	}
+	return filepath.Join(homeDir, ".ztap", "events.jsonl")
+}
+
+// commandStarted is set once cobra has parsed a command's arguments and
+// flags and runs it
+var commandStarted bool
+
+// Execute runs the command line and exits with the exit code of its error,
+// if any (see exit.go). Errors from RunE commands are classified like those
+// passed to fail; errors parsing arguments and flags are validation errors.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+	cmd, err := rootCmd.ExecuteC()
+	if err == nil {
+		return
+	}
+	setErrorFormat(cmd)
+	if !commandStarted {
+		err = validationError(err)
 	}
+	fail(err)
 }
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"ztap/pkg/cluster"
+	"ztap/pkg/events"
+
+	"github.com/spf13/cobra"
+)
+
+// rotatableSecrets are the secrets 'ztap security rotate' rotates, in order
+var rotatableSecrets = []string{"ca", "join-tokens", "sync-key"}
+
+var securityCmd = &cobra.Command{
+	Use:   "security",
+	Short: "Manage the secrets ZTAP keeps",
+}
+
+var securityRotateCmd = &cobra.Command{
+	Use:   "rotate [--only ca,join-tokens,sync-key] [--overlap 24h]",
+	Short: "Rotate the cluster CA, join tokens and sync key",
+	Long: `Rotate the secrets this host keeps under ~/.ztap, with an overlap period so
+nothing breaks mid-rotation:
+
+  ca           Replace the cluster CA. Node certificates issued by the
+               previous one are still accepted for --overlap; enroll the
+               nodes again before it ends, and restart 'ztap serve' to load
+               the new CA.
+  join-tokens  Reissue outstanding join tokens with new secrets. The previous
+               tokens still work for --overlap (or until they expire).
+  sync-key     Add a new key sealing policy sync payloads. The previous key
+               still opens updates for --overlap; export the keyring to the
+               nodes with 'ztap cluster sync-key export'.
+
+Secrets not created yet are skipped. Each rotation is recorded in a
+secret_rotated event with the principal, in the event journal.
+
+  ztap security rotate
+  ztap security rotate --only join-tokens --overlap 1h`,
+	Run: func(cmd *cobra.Command, args []string) {
+		requireLocal(cmd)
+		overlap, _ := cmd.Flags().GetDuration("overlap")
+		only, _ := cmd.Flags().GetStringSlice("only")
+		if overlap < 0 {
+			failf(exitValidation, "--overlap must not be negative")
+		}
+		for _, secret := range only {
+			if !slices.Contains(rotatableSecrets, secret) {
+				failf(exitValidation, "unknown secret %q (use %s)", secret, strings.Join(rotatableSecrets, ", "))
+			}
+		}
+
+		now := time.Now()
+		rotate := map[string]func(time.Time, time.Duration) (*events.SecretRotated, error){
+			"ca":          rotateClusterCA,
+			"join-tokens": rotateJoinTokens,
+			"sync-key":    rotateSyncKey,
+		}
+		for _, secret := range rotatableSecrets {
+			if len(only) > 0 && !slices.Contains(only, secret) {
+				continue
+			}
+			rotated, err := rotate[secret](now, overlap)
+			if err != nil {
+				fail(fmt.Errorf("failed to rotate %s: %w", secret, err))
+			}
+			if rotated != nil {
+				recordRotation(*rotated, now, overlap)
+			}
+		}
+	},
+}
+
+// recordRotation publishes a secret_rotated event for the journal
+func recordRotation(rotated events.SecretRotated, now time.Time, overlap time.Duration) {
+	rotated.Principal = currentPrincipal()
+	rotated.OverlapUntil = now.Add(overlap).UTC()
+	events.Default().Publish(events.TopicSecretRotated, rotated)
+}
+
+// rotateClusterCA rotates the cluster CA, if it exists
+func rotateClusterCA(now time.Time, overlap time.Duration) (*events.SecretRotated, error) {
+	dir, err := getClusterCADir()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ca.crt")); errors.Is(err, fs.ErrNotExist) {
+		fmt.Println("Cluster CA: not created yet, skipped")
+		return nil, nil
+	}
+	ca, previous, dropped, err := cluster.RotateCA(dir, now, overlap)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Cluster CA: %s replaces %s, whose node certificates are accepted until %s\n",
+		ca.Fingerprint(), previous, now.Add(overlap).Format(time.RFC3339))
+	fmt.Println("  Enroll the nodes again before then, and restart 'ztap serve' to load the new CA")
+	if len(dropped) > 0 {
+		fmt.Printf("  Dropped retired certificates: %s\n", strings.Join(dropped, ", "))
+	}
+	return &events.SecretRotated{Kind: events.SecretClusterCA, KeyID: ca.Fingerprint(), PreviousID: previous, Dropped: dropped}, nil
+}
+
+// rotateJoinTokens reissues the outstanding join tokens
+func rotateJoinTokens(now time.Time, overlap time.Duration) (*events.SecretRotated, error) {
+	store, err := getTokenStore()
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := store.Rotate(overlap)
+	if err != nil {
+		return nil, err
+	}
+	if len(rotated) == 0 {
+		fmt.Println("Join tokens: none outstanding, skipped")
+		return nil, nil
+	}
+	fmt.Printf("Join tokens: %d reissued; the previous ones work until %s at the latest\n", len(rotated), now.Add(overlap).Format(time.RFC3339))
+	for _, token := range rotated {
+		fmt.Printf("  %s -> %s (expires %s)\n", token.Previous, token.Presented, token.Token.ExpiresAt.Format(time.RFC3339))
+	}
+	fmt.Println("  The new tokens are not shown again")
+	return &events.SecretRotated{Kind: events.SecretJoinTokens, Reissued: len(rotated)}, nil
+}
+
+// rotateSyncKey rotates the sync keyring, if it exists
+func rotateSyncKey(now time.Time, overlap time.Duration) (*events.SecretRotated, error) {
+	path, err := getSyncKeyringPath()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := cluster.LoadSyncKeyring(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Println("Sync key: not created yet, skipped")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	previous := keyring.Primary().ID
+	key, dropped, err := keyring.Rotate(now, overlap)
+	if err != nil {
+		return nil, err
+	}
+	if err := keyring.Save(path); err != nil {
+		return nil, err
+	}
+	fmt.Printf("Sync key: %s replaces %s, which opens updates until %s\n", key.ID, previous, now.Add(overlap).Format(time.RFC3339))
+	if len(dropped) > 0 {
+		fmt.Printf("  Dropped retired keys: %s\n", strings.Join(dropped, ", "))
+	}
+	fmt.Println("  Export the keyring to the nodes with 'ztap cluster sync-key export'")
+	return &events.SecretRotated{Kind: events.SecretSyncKey, KeyID: key.ID, PreviousID: previous, Dropped: dropped}, nil
+}
+
+func init() {
+	securityRotateCmd.Flags().Duration("overlap", cluster.DefaultSyncKeyOverlap, "How long the previous secrets keep working")
+	securityRotateCmd.Flags().StringSlice("only", nil, "Rotate only these secrets (ca, join-tokens, sync-key)")
+
+	securityCmd.AddCommand(securityRotateCmd)
+	rootCmd.AddCommand(securityCmd)
+}
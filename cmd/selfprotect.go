@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"log"
+
+	"ztap/pkg/agent"
+
+	"github.com/spf13/cobra"
+)
+
+// setSelfProtection gives a the built-in policy keeping ZTAP's own
+// connections open, unless --self-protection=false: the --controller, the
+// metrics push and anomaly detection endpoints, the shared storage and
+// discovery cache of config.yaml, --protect addresses and, under ingress
+// default deny, the --metrics-port. Endpoints that cannot be resolved are
+// logged and left unprotected.
+func setSelfProtection(cmd *cobra.Command, a *agent.Agent) {
+	if enabled, _ := cmd.Flags().GetBool("self-protection"); !enabled {
+		return
+	}
+
+	var endpoints []agent.ControlEndpoint
+	add := func(name, address string) {
+		if address != "" {
+			endpoints = append(endpoints, agent.ControlEndpoint{Name: name, Address: address})
+		}
+	}
+	controller, _ := cmd.Flags().GetString("controller")
+	add("controller", controller)
+	pushURL, _ := cmd.Flags().GetString("push-url")
+	add("metrics push", pushURL)
+	anomalyEndpoint, _ := cmd.Flags().GetString("anomaly-endpoint")
+	add("anomaly detection", anomalyEndpoint)
+	anomalyFallback, _ := cmd.Flags().GetString("anomaly-fallback")
+	add("anomaly detection fallback", anomalyFallback)
+	if config, err := getStorageConfig(); err == nil {
+		add("postgres storage", config.Postgres.DSN)
+		if config.Redis != nil {
+			add("redis storage and discovery cache", config.Redis.Addr)
+		}
+	}
+	extra, _ := cmd.Flags().GetStringArray("protect")
+	for _, address := range extra {
+		add("--protect", address)
+	}
+
+	var ports []agent.ControlPort
+	if metricsPort, _ := cmd.Flags().GetInt("metrics-port"); metricsPort > 0 {
+		ports = append(ports, agent.ControlPort{Name: "metrics", Port: metricsPort})
+	}
+
+	protection, errs := agent.NewSelfProtection(cmd.Context(), endpoints, ports)
+	for _, err := range errs {
+		log.Printf("Warning: %v; the connection is not protected from default deny", err)
+	}
+	a.SetSelfProtection(protection)
+}
+
+// addSelfProtectionFlags adds the flags of setSelfProtection to cmd
+func addSelfProtectionFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("self-protection", true, "Always allow ZTAP's own control connections (controller, metrics, storage, discovery cache) alongside user policies")
+	cmd.Flags().StringArray("protect", nil, "Another address (host:port or URL) to keep reachable under default deny (repeatable)")
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ztap/pkg/admission"
+	"ztap/pkg/api"
+	"ztap/pkg/events"
+	"ztap/pkg/notify"
+	"ztap/pkg/scim"
+	"ztap/pkg/telemetry"
+
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Start the ZTAP API server",
+	Long: `Start the HTTP API server.
+
+Endpoints:
+  POST /login    Exchange {"username","password"} for a session token
+  POST /logout   End the session of the request
+  GET  /events   Server-Sent Events stream of enforcement, discovery,
+                 cluster and anomaly events
+  GET  /policies                List stored policies
+  GET|PUT|DELETE /policies/NAME Read, store (YAML body) or delete a policy
+  GET  /approvals               List policy changes awaiting approval
+  POST /approvals/ID/approve    Store a pending change (a second admin)
+  POST /approvals/ID/reject     Discard a pending change
+  GET|POST /users               List or create (JSON body) users of a tenant
+  POST /enroll                  Enroll a node with a join token (--enrollment)
+  POST /admission               Submit a policy from CI or a ticketing system
+  GET  /stats/heatmap           Blocks per policy or anomaly scores per service
+                                over time (?metric=anomalies&since=7d&step=1h)
+  /scim/v2/Users, /scim/v2/Groups
+                                SCIM 2.0 user provisioning (scim in config.yaml)
+
+Users, sessions and policies are kept under ~/.ztap by default. Set
+storage.backend to postgres in ~/.ztap/config.yaml (or $ZTAP_CONFIG) so
+several API server replicas share them, and storage.redis to keep sessions
+in Redis.
+
+Add rules to the approval section of config.yaml to require a second admin
+for high-risk changes: a PUT matching a rule is answered with 202 and held
+until approved here or with 'ztap policy approve'.
+
+External systems submit policies with POST /admission ({"yaml", "source",
+"reference", "dry_run"}): a submission is validated, linted and, when the
+admission section of config.yaml sets opa.url, checked by an Open Policy
+Agent decision before it is stored or held for approval like a PUT. The
+response reports the outcome (rejected, checked, stored or pending) with the
+lint findings and OPA denials.
+
+An identity provider provisions users through SCIM 2.0 when the scim
+section of config.yaml names a file holding its bearer token: it creates,
+updates and deactivates users of scim.tenant, and its groups listed in
+scim.groups set their roles.
+
+Several organizations can share the server as tenants ('ztap user create
+--tenant acme'). Requests by a tenant's users act in their tenant only: its
+policies, approvals, users and the events published by processes started
+with --tenant acme. Users without a tenant choose one with the X-ZTAP-Tenant
+header or ?tenant=; without one they act on records outside any tenant and
+receive every event. The quotas section of config.yaml limits the policies
+each tenant may store ('ztap quota').
+
+Users limited by scopes ('ztap user scope') only see the policies selecting
+workloads in their scopes, and the events about those workloads.
+
+With --enrollment, nodes enroll themselves with join tokens from 'ztap
+cluster token create' ('ztap cluster enroll'). Each receives its service
+account and a client certificate from the cluster CA in ~/.ztap/ca, created
+on first use. Serve TLS with --tls-cert and --tls-key so node service
+accounts must present their certificate on every request.
+
+Events published by other ztap processes on this host (ztap agent, enforce,
+discovery and cluster commands) are bridged through ~/.ztap/events.jsonl.
+
+The notifications section of config.yaml sends events to commands or URLs.
+Repeats of an alert (same topic, policy and destination) within
+notifications.dedup.window are sent once, followed by their count when the
+window ends; channels with digest: hourly or daily receive one summary per
+period instead.
+
+Authenticate /events with "Authorization: Bearer <token>" or ?token=<token>.
+Filter with ?topics=flow_blocked,anomaly_detected; only topics your role is
+permitted to view are streamed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		port, _ := cmd.Flags().GetInt("port")
+		tlsCert, _ := cmd.Flags().GetString("tls-cert")
+		tlsKey, _ := cmd.Flags().GetString("tls-key")
+		enrollment, _ := cmd.Flags().GetBool("enrollment")
+		if (tlsCert == "") != (tlsKey == "") {
+			failf(exitValidation, "--tls-cert and --tls-key must be given together")
+		}
+
+		am, err := getAuthManager()
+		if err != nil {
+			fail(err)
+		}
+		policies, err := getPolicyStore()
+		if err != nil {
+			fail(err)
+		}
+
+		gate, rules, err := getApprovalGate(policies)
+		if err != nil {
+			fail(err)
+		}
+		admissionConfig, err := loadSection(admission.LoadConfig)
+		if err != nil {
+			fail(err)
+		}
+		scimConfig, err := loadSection(scim.LoadConfig)
+		if err != nil {
+			fail(err)
+		}
+		notifyConfig, err := loadSection(notify.LoadConfig)
+		if err != nil {
+			fail(err)
+		}
+		telemetryConfig, err := loadSection(telemetry.LoadConfig)
+		if err != nil {
+			fail(err)
+		}
+		var scimToken string
+		if scimConfig.Enabled() {
+			if scimToken, err = scimConfig.LoadToken(); err != nil {
+				fail(err)
+			}
+		}
+
+		scheme := "http"
+		if tlsCert != "" {
+			scheme = "https"
+		}
+		fmt.Printf("Starting ZTAP API server on port %d\n", port)
+		fmt.Printf("Stream events at: %s://localhost:%d/events\n", scheme, port)
+		fmt.Println("Press Ctrl+C to stop")
+
+		go func() {
+			if err := statsRecorder.Run(context.Background(), statsFlushInterval); err != nil {
+				fmt.Printf("Warning: Failed to save statistics: %v\n", err)
+			}
+		}()
+
+		go eventJournal.Follow(context.Background(), events.Default(), time.Second)
+		if notifyConfig.Enabled() {
+			go notify.New(notifyConfig).Run(context.Background(), events.Default())
+		}
+		go newTelemetryReporter(telemetryConfig).Run(context.Background())
+
+		server := api.NewServer(am, events.Default(), policies)
+		server.EnableStats(getStatsFilePath())
+		server.EnableSearch(resolverFor(nil))
+		attributor := newAttributor(nil)
+		server.SetWorkloadLabels(func(ip string, at time.Time) map[string]string {
+			a, _ := attributor.Attribute(ip, at)
+			return a.Labels
+		})
+		if rules.Enabled() {
+			server.RequireApproval(gate)
+		}
+		server.SetAdmission(admission.NewChecker(admissionConfig))
+		if scimToken != "" {
+			server.EnableSCIM(scimConfig, scimToken)
+			fmt.Printf("SCIM provisioning at: %s://localhost:%d/scim/v2\n", scheme, port)
+		}
+		if enrollment {
+			ca, err := getClusterCA()
+			if err != nil {
+				fail(err)
+			}
+			tokens, err := getTokenStore()
+			if err != nil {
+				fail(err)
+			}
+			server.EnableEnrollment(tokens, ca)
+			if tlsCert == "" {
+				fmt.Println("Warning: Enrollment without --tls-cert sends join tokens and node secrets in plain text")
+			}
+		}
+
+		addr := fmt.Sprintf(":%d", port)
+		if tlsCert != "" {
+			err = server.ListenAndServeTLS(addr, tlsCert, tlsKey)
+		} else {
+			err = server.ListenAndServe(addr)
+		}
+		if err != nil {
+			fail(fmt.Errorf("failed to start API server: %w", err))
+		}
+	},
+}
+
+func init() {
+	serveCmd.Flags().IntP("port", "p", 8080, "Port for API server")
+	serveCmd.Flags().String("tls-cert", "", "Serve HTTPS with this certificate (PEM)")
+	serveCmd.Flags().String("tls-key", "", "Private key of --tls-cert (PEM)")
+	serveCmd.Flags().Bool("enrollment", false, "Let nodes enroll with join tokens (POST /enroll)")
+	rootCmd.AddCommand(serveCmd)
+}
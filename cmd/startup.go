@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"ztap/pkg/agent"
+	"ztap/pkg/anomaly"
+	"ztap/pkg/cloud"
+	"ztap/pkg/faults"
+	"ztap/pkg/storage"
+
+	"github.com/spf13/cobra"
+)
+
+// dependencyTimeout bounds each startup dependency check
+const dependencyTimeout = 5 * time.Second
+
+// failurePoints are the fault points --simulate-failure fails, by
+// dependency
+var failurePoints = map[string][]faults.Point{
+	agent.DependencyDiscovery:  {faults.DiscoveryResolve, faults.AWSCall},
+	agent.DependencyController: {faults.Controller},
+	agent.DependencyDetector:   {faults.Detect},
+}
+
+// simulateFailures makes every check and call of the named dependencies
+// fail, so operators can rehearse their fail modes
+func simulateFailures(names []string) error {
+	var spec []string
+	for _, name := range names {
+		points, ok := failurePoints[name]
+		if !ok {
+			return validationError(fmt.Errorf("unknown component %q for --simulate-failure (use %s, %s or %s)",
+				name, agent.DependencyDiscovery, agent.DependencyController, agent.DependencyDetector))
+		}
+		for _, point := range points {
+			spec = append(spec, string(point)+":fail=100%")
+		}
+	}
+	if len(spec) == 0 {
+		return nil
+	}
+	if err := faults.Set(strings.Join(spec, ",")); err != nil {
+		return err
+	}
+	log.Printf("Warning: simulating the failure of %s; do not use --simulate-failure in production", strings.Join(names, ", "))
+	return nil
+}
+
+// startupDependencies returns the services the agent needs, in the order
+// they are waited for: the discovery cache of config.yaml and inventory (if
+// not nil), the --controller and the --anomaly-endpoint. Services that are
+// not configured are left out, unless their failure is simulated.
+func startupDependencies(cmd *cobra.Command, inventory *cloud.Inventory) []agent.Dependency {
+	var deps []agent.Dependency
+	add := func(name string, check func(context.Context) error) {
+		point := failurePoints[name][0]
+		if check == nil {
+			if !faults.Configured(point) {
+				return
+			}
+			check = func(context.Context) error { return nil }
+		}
+		deps = append(deps, agent.Dependency{Name: name, Check: func(ctx context.Context) error {
+			if err := faults.Inject(point); err != nil {
+				return err
+			}
+			return check(ctx)
+		}})
+	}
+
+	var checks []func(context.Context) error
+	if config, err := getStorageConfig(); err == nil && config.Redis != nil && config.Redis.DiscoveryCacheTTL > 0 {
+		redis := *config.Redis
+		checks = append(checks, func(context.Context) error {
+			store, err := storage.OpenRedis(redis)
+			if err != nil {
+				return err
+			}
+			return store.Close()
+		})
+	}
+	if inventory != nil {
+		checks = append(checks, func(context.Context) error {
+			return inventory.Refresh()
+		})
+	}
+	var discovery func(context.Context) error
+	if len(checks) > 0 {
+		discovery = func(ctx context.Context) error {
+			var errs []error
+			for _, check := range checks {
+				errs = append(errs, check(ctx))
+			}
+			return errors.Join(errs...)
+		}
+	}
+	add(agent.DependencyDiscovery, discovery)
+
+	var controllerCheck func(context.Context) error
+	if controller, _ := cmd.Flags().GetString("controller"); controller != "" {
+		healthz := strings.TrimSuffix(controller, "/") + "/healthz"
+		controllerCheck = func(ctx context.Context) error {
+			return checkHTTP(ctx, healthz)
+		}
+	}
+	add(agent.DependencyController, controllerCheck)
+
+	var detectorCheck func(context.Context) error
+	if endpoint, _ := cmd.Flags().GetString("anomaly-endpoint"); endpoint != "" {
+		fallback, _ := cmd.Flags().GetString("anomaly-fallback")
+		detectorCheck = func(ctx context.Context) error {
+			err := checkDetector(ctx, endpoint)
+			if err != nil && fallback != "" && checkDetector(ctx, fallback) == nil {
+				return nil
+			}
+			return err
+		}
+	}
+	add(agent.DependencyDetector, detectorCheck)
+	return deps
+}
+
+// checkDetector checks the health of the anomaly detection service at
+// endpoint
+func checkDetector(ctx context.Context, endpoint string) error {
+	if !strings.HasPrefix(endpoint, "grpc://") && !strings.HasPrefix(endpoint, "grpcs://") {
+		return checkHTTP(ctx, strings.TrimSuffix(endpoint, "/")+"/health")
+	}
+	d, err := anomaly.NewGRPCDetector(endpoint)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+	defer cancel()
+	_, err = d.Health(ctx)
+	return err
+}
+
+// checkHTTP requires url to answer GET with 200 OK
+func checkHTTP(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, dependencyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ztap/pkg/migrate"
+
+	"github.com/spf13/cobra"
+)
+
+// stateFormats are the versioned files under ~/.ztap. A change to one of
+// their formats that older builds cannot read adds a migration here, so
+// existing files are migrated at startup and older builds refuse them.
+var stateFormats = []migrate.Format{
+	{File: "config.yaml"},
+	{File: "users.json"},
+	{File: "policies.json"},
+	{File: "pending.json"},
+	{File: "applied.json"},
+	{File: "last-known-good.json"},
+	{File: "stats.json"},
+	{File: "events.jsonl"},
+	{File: "cluster-config.json"},
+	{File: "join-tokens.json"},
+	{File: "sync-keys.json"},
+	{File: "anomaly-feedback.json"},
+	{File: "telemetry.json"},
+}
+
+// migrateState migrates the files under ~/.ztap to the formats of this
+// build before cmd runs, and fails if any is newer. 'ztap upgrade' and help
+// still run on newer state, so an older binary can be replaced.
+func migrateState(cmd *cobra.Command) {
+	dir, err := getStateDir()
+	if err != nil {
+		return
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return
+	}
+	switch cmd.Name() {
+	case "upgrade", "help", "completion":
+		return
+	}
+
+	migrated, err := migrate.Run(dir, stateFormats, version, time.Now())
+	for _, m := range migrated {
+		fmt.Fprintf(os.Stderr, "Migrated %s from format version %d to %d (backup: %s)\n", m.File, m.From, m.To, m.Backup)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+// getStateDir returns ~/.ztap
+func getStateDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".ztap"), nil
+}
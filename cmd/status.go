@@ -1,12 +1,16 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
 	"text/tabwriter"
+	"time"
 
+	"ztap/pkg/agent"
+	"ztap/pkg/applied"
 	"ztap/pkg/cloud"
 
 	"github.com/spf13/cobra"
@@ -19,6 +23,8 @@ var statusCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		region, _ := cmd.Flags().GetString("region")
 		showAWS, _ := cmd.Flags().GetBool("aws")
+		accountsFile, _ := cmd.Flags().GetString("accounts")
+		staleAfter, _ := cmd.Flags().GetDuration("stale-after")
 
 		fmt.Println("ZTAP Status Report")
 		fmt.Println("==================")
@@ -33,43 +39,44 @@ var statusCmd = &cobra.Command{
 		fmt.Printf("  Hostname: %s\n", hostname)
 		fmt.Println()
 
+		if state, err := applied.Load(getAppliedStatePath()); err != nil {
+			log.Printf("Warning: %v", err)
+		} else if state != nil {
+			fmt.Println("Applied Policies:")
+			fmt.Printf("  %s\n", describeApplied(state))
+			fmt.Println()
+		}
+		if set := loadLastKnownGood(); set != nil {
+			fmt.Println("Last-Known-Good Rule Set:")
+			fmt.Printf("  %s\n", describeRuleSet(set))
+			if staleAfter > 0 && set.Age(time.Now()) > staleAfter {
+				fmt.Printf("  Stale: not refreshed for more than %s; check that the agent is running and can reconcile\n", staleAfter)
+			}
+			fmt.Println()
+		}
+
 		// Show AWS resources if requested
 		if showAWS {
-			fmt.Printf("AWS Resources (Region: %s):\n", region)
-
-			client, err := cloud.NewAWSClient(region)
-			if err != nil {
-				log.Printf("Warning: Failed to initialize AWS client: %v", err)
-				log.Println("  Make sure AWS credentials are configured (aws configure)")
-				return
+			var resources []cloud.Resource
+			var err error
+			if accountsFile != "" {
+				resources, err = discoverAccounts(cmd.Context(), accountsFile)
+			} else {
+				fmt.Printf("AWS Resources (Region: %s):\n", region)
+				resources, err = discoverRegion(region)
 			}
-
-			resources, err := client.DiscoverResources()
 			if err != nil {
-				log.Printf("Warning: Failed to discover AWS resources: %v", err)
-				return
+				log.Printf("Warning: %v", err)
+				log.Println("  Make sure AWS credentials are configured (aws configure)")
+				if len(resources) == 0 {
+					return
+				}
 			}
 
 			if len(resources) == 0 {
 				fmt.Println("  No resources found")
 			} else {
-				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-				fmt.Fprintln(w, "  ID\tName\tType\tPrivate IP\tPublic IP\tLabels")
-				fmt.Fprintln(w, "  --\t----\t----\t----------\t---------\t------")
-
-				for _, r := range resources {
-					labels := ""
-					for k, v := range r.Labels {
-						if k == "Name" {
-							continue
-						}
-						labels += fmt.Sprintf("%s=%s ", k, v)
-					}
-					fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%s\n",
-						r.ID, r.Name, r.Type, r.PrivateIP, r.PublicIP, labels)
-				}
-				w.Flush()
-				fmt.Printf("\nTotal: %d resource(s)\n", len(resources))
+				printResources(resources, accountsFile != "")
 			}
 		} else {
 			fmt.Println("Cloud Resources: (use --aws to discover AWS resources)")
@@ -80,5 +87,73 @@ var statusCmd = &cobra.Command{
 func init() {
 	statusCmd.Flags().BoolP("aws", "a", false, "Discover AWS resources")
 	statusCmd.Flags().StringP("region", "r", "us-east-1", "AWS region")
+	statusCmd.Flags().Duration("stale-after", agent.DefaultStaleAfter, "Flag the last-known-good rule set as stale when older than this (0 disables)")
+	statusCmd.Flags().String("accounts", "", "YAML file of AWS accounts and regions to discover (overrides --region)")
 	rootCmd.AddCommand(statusCmd)
 }
+
+// discoverRegion discovers resources in one region with default credentials.
+// Instances are returned with the error if only container discovery failed.
+func discoverRegion(region string) ([]cloud.Resource, error) {
+	client, err := cloud.NewAWSClient(region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize AWS client: %w", err)
+	}
+
+	resources, err := client.DiscoverResources()
+	if err != nil {
+		return resources, fmt.Errorf("failed to discover AWS resources: %w", err)
+	}
+	return resources, nil
+}
+
+// discoverAccounts discovers resources across the accounts and regions in
+// accountsFile. Resources from reachable targets are returned with any error.
+func discoverAccounts(ctx context.Context, accountsFile string) ([]cloud.Resource, error) {
+	accounts, err := cloud.LoadAccounts(accountsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := cloud.NewMultiClient(ctx, accounts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize AWS clients: %w", err)
+	}
+
+	fmt.Printf("AWS Resources (%d account/region target(s)):\n", len(client.Targets()))
+	resources, err := client.DiscoverResources()
+	if err != nil {
+		return resources, fmt.Errorf("failed to discover some AWS resources: %w", err)
+	}
+	return resources, nil
+}
+
+func printResources(resources []cloud.Resource, multiAccount bool) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if multiAccount {
+		fmt.Fprintln(w, "  Account\tRegion\tID\tName\tType\tPrivate IP\tPublic IP\tLabels")
+		fmt.Fprintln(w, "  -------\t------\t--\t----\t----\t----------\t---------\t------")
+	} else {
+		fmt.Fprintln(w, "  ID\tName\tType\tPrivate IP\tPublic IP\tLabels")
+		fmt.Fprintln(w, "  --\t----\t----\t----------\t---------\t------")
+	}
+
+	for _, r := range resources {
+		labels := ""
+		for k, v := range r.Labels {
+			if k == "Name" || k == cloud.LabelAccount || k == cloud.LabelRegion {
+				continue
+			}
+			labels += fmt.Sprintf("%s=%s ", k, v)
+		}
+		if multiAccount {
+			fmt.Fprintf(w, "  %s\t%s\t", r.Labels[cloud.LabelAccount], r.Labels[cloud.LabelRegion])
+		} else {
+			fmt.Fprint(w, "  ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.ID, r.Name, r.Type, r.PrivateIP, r.PublicIP, labels)
+	}
+	w.Flush()
+	fmt.Printf("\nTotal: %d resource(s)\n", len(resources))
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"ztap/pkg/approval"
+	"ztap/pkg/configfile"
+	"ztap/pkg/quota"
+	"ztap/pkg/storage"
+)
+
+var (
+	configOnce sync.Once
+	configFile *configfile.File
+	configErr  error
+
+	postgresOnce  sync.Once
+	postgresStore *storage.PostgresStore
+	postgresErr   error
+
+	redisOnce  sync.Once
+	redisStore *storage.RedisStore
+	redisErr   error
+)
+
+// configPath returns the config.yaml to read: $ZTAP_CONFIG, or
+// ~/.ztap/config.yaml
+func configPath() string {
+	if path := os.Getenv("ZTAP_CONFIG"); path != "" {
+		return path
+	}
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".ztap", "config.yaml")
+}
+
+// getConfigFile reads config.yaml once per process
+func getConfigFile() (*configfile.File, error) {
+	configOnce.Do(func() {
+		configFile, configErr = configfile.Load(configPath())
+	})
+	return configFile, configErr
+}
+
+// loadSection returns a section of config.yaml read with load, the
+// LoadConfig of the package the section configures
+func loadSection[T any](load func(*configfile.File) (T, error)) (T, error) {
+	file, err := getConfigFile()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return load(file)
+}
+
+// getStorageConfig reads the storage section of config.yaml
+func getStorageConfig() (storage.Config, error) {
+	return loadSection(storage.LoadConfig)
+}
+
+// getPostgresStore opens the configured postgres backend once per process,
+// so users, sessions and policies share one connection pool
+func getPostgresStore(config storage.PostgresConfig) (*storage.PostgresStore, error) {
+	postgresOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		postgresStore, postgresErr = storage.OpenPostgres(ctx, config)
+	})
+	return postgresStore, postgresErr
+}
+
+// getPolicyStore returns the configured policy store, enforcing the quotas
+// section of config.yaml when it sets any limit
+func getPolicyStore() (storage.PolicyStore, error) {
+	policies, err := openPolicyStore()
+	if err != nil {
+		return nil, err
+	}
+	quotas, err := loadSection(quota.LoadConfig)
+	if err != nil {
+		return nil, err
+	}
+	if quotas.Enabled() {
+		return quota.NewStore(policies, quotas), nil
+	}
+	return policies, nil
+}
+
+// openPolicyStore opens the configured policy store
+func openPolicyStore() (storage.PolicyStore, error) {
+	config, err := getStorageConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Backend == storage.BackendPostgres {
+		return getPostgresStore(config.Postgres)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return storage.NewFilePolicyStore(filepath.Join(homeDir, ".ztap", "policies.json")), nil
+}
+
+// getPendingStore returns the configured store for policy changes awaiting
+// approval
+func getPendingStore() (storage.PendingStore, error) {
+	config, err := getStorageConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config.Backend == storage.BackendPostgres {
+		return getPostgresStore(config.Postgres)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return storage.NewFilePendingStore(filepath.Join(homeDir, ".ztap", "pending.json")), nil
+}
+
+// getApprovalGate returns an approval gate writing to policies, with the
+// rules in the approval section of config.yaml
+func getApprovalGate(policies storage.PolicyStore) (*approval.Gate, approval.Config, error) {
+	config, err := loadSection(approval.LoadConfig)
+	if err != nil {
+		return nil, config, err
+	}
+	pending, err := getPendingStore()
+	if err != nil {
+		return nil, config, err
+	}
+	return approval.NewGate(config, policies, pending), config, nil
+}
+
+// getRedisStore connects to the configured Redis once per process
+func getRedisStore(config storage.RedisConfig) (*storage.RedisStore, error) {
+	redisOnce.Do(func() {
+		redisStore, redisErr = storage.OpenRedis(config)
+	})
+	return redisStore, redisErr
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ztap/pkg/cluster"
+	"ztap/pkg/render"
+
+	"github.com/spf13/cobra"
+)
+
+var clusterSyncKeyCmd = &cobra.Command{
+	Use:   "sync-key",
+	Short: "Manage the key sealing policy sync payloads",
+	Long: `The sync keyring (~/.ztap/sync-keys.json) seals the policy updates the
+leader distributes: each update's YAML is encrypted with a fresh data key,
+wrapped with the cluster's sync key, and signed. Agents given the keyring
+verify and decrypt every update before applying it, and reject unsealed ones.
+
+Give agents a verify-only copy ('ztap cluster sync-key export'), so a
+compromised node cannot sign updates of its own.`,
+}
+
+var clusterSyncKeyRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Create the sync keyring, or rotate its key",
+	Long: `Create the sync keyring on first use; afterwards, add a new key that
+seals all further updates. The previous key still opens updates for
+--overlap, so nodes can pick up the new keyring at different times; keys
+retired longer ago are dropped. Rotations are recorded in secret_rotated
+events, as with 'ztap security rotate'.
+
+  ztap cluster sync-key rotate
+  ztap cluster sync-key rotate --overlap 1h`,
+	Run: func(cmd *cobra.Command, args []string) {
+		requireLocal(cmd)
+		overlap, _ := cmd.Flags().GetDuration("overlap")
+		if overlap < 0 {
+			failf(exitValidation, "--overlap must not be negative")
+		}
+		path, err := getSyncKeyringPath()
+		if err != nil {
+			fail(err)
+		}
+
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			keyring, err := cluster.NewSyncKeyring(time.Now())
+			if err != nil {
+				fail(err)
+			}
+			if err := keyring.Save(path); err != nil {
+				fail(err)
+			}
+			fmt.Printf("Sync keyring created with key %s\n", keyring.Primary().ID)
+			return
+		}
+		now := time.Now()
+		rotated, err := rotateSyncKey(now, overlap)
+		if err != nil {
+			fail(err)
+		}
+		if rotated != nil {
+			recordRotation(*rotated, now, overlap)
+		}
+	},
+}
+
+var clusterSyncKeyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List sync keys",
+	Run: func(cmd *cobra.Command, args []string) {
+		requireLocal(cmd)
+		path, err := getSyncKeyringPath()
+		if err != nil {
+			fail(err)
+		}
+		keyring, err := cluster.LoadSyncKeyring(path)
+		if err != nil {
+			fail(err)
+		}
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "ID", Key: "id"},
+				{Header: "State", Key: "state"},
+				{Header: "Created", Key: "created_at"},
+				{Header: "Retired", Key: "retired_at"},
+			},
+			Empty: "No sync keys",
+		}
+		primary := keyring.Primary().ID
+		for _, key := range keyring.Keys {
+			state := "retired"
+			if key.ID == primary {
+				state = "primary"
+			}
+			if key.SigningKey == nil {
+				state += ", verify-only"
+			}
+			retired := ""
+			if !key.RetiredAt.IsZero() {
+				retired = key.RetiredAt.Format(time.RFC3339)
+			}
+			table.AddRow(key.ID, state, key.CreatedAt, retired)
+		}
+		if err := printer.Table(table); err != nil {
+			fail(err)
+		}
+	},
+}
+
+var clusterSyncKeyExportCmd = &cobra.Command{
+	Use:   "export -o FILE",
+	Short: "Export the sync keyring for agents",
+	Long: `Write a copy of the sync keyring without its signing keys, for agents
+that open policy updates but must not be able to sign them. Copy it to each
+node after every rotation.
+
+  ztap cluster sync-key export -o sync-keys.json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		requireLocal(cmd)
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			failf(exitValidation, "--output is required")
+		}
+		path, err := getSyncKeyringPath()
+		if err != nil {
+			fail(err)
+		}
+		keyring, err := cluster.LoadSyncKeyring(path)
+		if err != nil {
+			fail(err)
+		}
+		if err := keyring.VerifyOnly().Save(output); err != nil {
+			fail(err)
+		}
+		fmt.Printf("Verify-only keyring with %d keys written to %s\n", len(keyring.Keys), output)
+	},
+}
+
+// getSyncKeyringPath returns the path of the sync keyring under ~/.ztap
+func getSyncKeyringPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".ztap", "sync-keys.json"), nil
+}
+
+func init() {
+	clusterSyncKeyRotateCmd.Flags().Duration("overlap", cluster.DefaultSyncKeyOverlap, "How long the previous key still opens updates")
+	clusterSyncKeyExportCmd.Flags().StringP("output", "o", "", "File the verify-only keyring is written to")
+
+	clusterSyncKeyCmd.AddCommand(clusterSyncKeyRotateCmd)
+	clusterSyncKeyCmd.AddCommand(clusterSyncKeyListCmd)
+	clusterSyncKeyCmd.AddCommand(clusterSyncKeyExportCmd)
+	clusterCmd.AddCommand(clusterSyncKeyCmd)
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"ztap/pkg/applied"
+	"ztap/pkg/discovery"
+	"ztap/pkg/enforcer"
+	"ztap/pkg/storage"
+	"ztap/pkg/telemetry"
+
+	"github.com/spf13/cobra"
+)
+
+var telemetryCmd = &cobra.Command{
+	Use:   "telemetry",
+	Short: "Inspect the anonymized usage statistics ZTAP reports when opted in",
+	Long: `Telemetry is off unless enabled in the telemetry section of config.yaml.
+Once enabled, 'ztap agent' and 'ztap serve' send a report to the configured
+endpoint every interval (24h by default): the version, platform, backend
+types, the number of policies, rules and services, and the number of failed
+commands by class (validation, auth, ...). Reports carry a random install ID
+and never names, labels, addresses or policy content.
+
+ZTAP_TELEMETRY=off (or DO_NOT_TRACK=1) turns telemetry off whatever
+config.yaml says: nothing is recorded or sent.`,
+}
+
+var telemetryShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print exactly the report that would be sent",
+	Long: `Print the report telemetry would send now, as JSON, without sending it.
+The install ID is empty until the first report is sent.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := loadSection(telemetry.LoadConfig)
+		if err != nil {
+			fail(err)
+		}
+		report, err := newTelemetryReporter(config).Preview()
+		if err != nil {
+			fail(err)
+		}
+
+		switch {
+		case telemetry.Off():
+			printer.Printf("Telemetry is off (%s or DO_NOT_TRACK); nothing is sent\n", telemetry.OffEnv)
+		case !config.Enabled:
+			printer.Printf("Telemetry is disabled; nothing is sent unless telemetry.enabled is set in config.yaml\n")
+		default:
+			printer.Printf("Telemetry is enabled; this report would be sent to %s:\n", config.Endpoint)
+		}
+		if printer.Structured() {
+			if err := printer.Value(report); err != nil {
+				fail(err)
+			}
+			return
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			fail(err)
+		}
+		fmt.Println(string(data))
+	},
+}
+
+// newTelemetryReporter returns the reporter of this host's usage
+func newTelemetryReporter(config telemetry.Config) *telemetry.Reporter {
+	return telemetry.NewReporter(config, telemetry.NewStore(getTelemetryPath()), version, collectTelemetry)
+}
+
+// collectTelemetry fills in the backends and counts of a report
+func collectTelemetry(report *telemetry.Report) {
+	report.Backends["enforcement"] = "pf"
+	if enforcer.IsLinux() {
+		report.Backends["enforcement"] = "ebpf"
+	}
+	if config, err := getStorageConfig(); err == nil {
+		report.Backends["storage"] = storage.BackendFile
+		if config.Backend != "" {
+			report.Backends["storage"] = config.Backend
+		}
+		report.Backends["sessions"] = report.Backends["storage"]
+		if config.Redis != nil {
+			report.Backends["sessions"] = "redis"
+		}
+	}
+	report.Backends["discovery"] = "memory"
+	if backend := activeContext().Discovery.Backend; backend != "" {
+		report.Backends["discovery"] = backend
+	}
+
+	if set, err := applied.LoadRuleSet(getLastKnownGoodPath()); err == nil && set != nil {
+		report.Policies = len(set.Policies)
+		for _, p := range set.Policies {
+			report.Rules += len(p.Rules)
+		}
+	}
+	if mem, ok := getDiscoveryBackend().(*discovery.InMemoryDiscovery); ok {
+		report.Services = len(mem.ListServices())
+	}
+}
+
+// recordTelemetryError counts a failed command of class if telemetry is
+// active
+func recordTelemetryError(class string) {
+	config, err := loadSection(telemetry.LoadConfig)
+	if err != nil || !config.Active() {
+		return
+	}
+	telemetry.NewStore(getTelemetryPath()).RecordError(class)
+}
+
+// getTelemetryPath returns the path of the telemetry state under ~/.ztap
+func getTelemetryPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "/tmp/ztap-telemetry.json"
+	}
+	return filepath.Join(homeDir, ".ztap", "telemetry.json")
+}
+
+func init() {
+	telemetryCmd.AddCommand(telemetryShowCmd)
+	rootCmd.AddCommand(telemetryCmd)
+}
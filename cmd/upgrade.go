@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"ztap/pkg/service"
+	"ztap/pkg/upgrade"
+
+	"github.com/spf13/cobra"
+)
+
+// Release endpoint and the base64 Ed25519 key releases are signed with, set
+// at build time with -ldflags "-X ztap/cmd.releaseEndpoint=... -X ztap/cmd.releaseKey=..."
+var (
+	releaseEndpoint = ""
+	releaseKey      = ""
+)
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Upgrade ztap to the latest signed release",
+	Long: `Check the release endpoint for a newer version, download the binary for
+this platform, verify its detached Ed25519 signature, which covers the
+release version and the binary, against the trusted release key and
+atomically replace the running binary. The replaced binary is kept next to
+it with a .previous suffix. A release older than the running version is
+refused unless --force is given.
+
+With --restart the systemd service ('ztap install-service') is restarted
+afterwards. eBPF enforcement state is pinned under /sys/fs/bpf/ztap, so
+rules keep being enforced while the agent restarts and the new agent picks
+them up in place, swapping its filter program onto the pinned cgroup links
+atomically.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		endpoint, _ := cmd.Flags().GetString("endpoint")
+		keyFlag, _ := cmd.Flags().GetString("public-key")
+		checkOnly, _ := cmd.Flags().GetBool("check")
+		force, _ := cmd.Flags().GetBool("force")
+		restart, _ := cmd.Flags().GetBool("restart")
+
+		if endpoint == "" {
+			failf(exitValidation, "no release endpoint; pass --endpoint")
+		}
+		key, err := base64.StdEncoding.DecodeString(keyFlag)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			failf(exitValidation, "no valid trusted release key; pass --public-key with a base64 Ed25519 public key")
+		}
+
+		client := upgrade.NewClient(endpoint, ed25519.PublicKey(key))
+		release, err := client.Latest(cmd.Context())
+		if err != nil {
+			fail(err)
+		}
+
+		fmt.Printf("Current version: %s\n", version)
+		fmt.Printf("Latest release:  %s\n", release.Version)
+		if !upgrade.Newer(release.Version, version) && !force {
+			if upgrade.Newer(version, release.Version) && !checkOnly {
+				failf(exitValidation, "release %s is older than the running version %s; pass --force to downgrade", release.Version, version)
+			}
+			fmt.Println("Already up to date.")
+			return
+		}
+		if checkOnly {
+			fmt.Println("An upgrade is available; run 'ztap upgrade' to install it.")
+			return
+		}
+
+		asset, err := release.Asset(runtime.GOOS, runtime.GOARCH)
+		if err != nil {
+			fail(err)
+		}
+		binary, err := client.Download(cmd.Context(), release.Version, asset)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Println("Signature verified.")
+
+		path, err := os.Executable()
+		if err == nil {
+			path, err = filepath.EvalSymlinks(path)
+		}
+		if err != nil {
+			fail(fmt.Errorf("failed to locate the running binary: %w", err))
+		}
+		if err := upgrade.Install(path, binary); err != nil {
+			fail(err)
+		}
+		fmt.Printf("Upgraded %s to %s (previous binary kept as %s.previous)\n", path, release.Version, path)
+
+		if restart {
+			out, err := exec.Command("systemctl", "restart", service.Name).CombinedOutput()
+			if err != nil {
+				fail(fmt.Errorf("failed to restart %s: %w\n%s", service.Name, err, out))
+			}
+			fmt.Printf("Restarted %s\n", service.Name)
+		}
+	},
+}
+
+func init() {
+	upgradeCmd.Flags().String("endpoint", releaseEndpoint, "URL of the release manifest")
+	upgradeCmd.Flags().String("public-key", releaseKey, "Base64 Ed25519 public key releases must be signed with")
+	upgradeCmd.Flags().Bool("check", false, "Only report whether a newer release is available")
+	upgradeCmd.Flags().Bool("force", false, "Install the latest release even if it is not newer than this version, or is older")
+	upgradeCmd.Flags().Bool("restart", false, "Restart the "+service.Name+" systemd service after upgrading")
+	rootCmd.AddCommand(upgradeCmd)
+}
@@ -1,13 +1,18 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strings"
 	"syscall"
-	"text/tabwriter"
 
+	"ztap/pkg/api"
 	"ztap/pkg/auth"
+	"ztap/pkg/render"
+	"ztap/pkg/storage"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
@@ -16,7 +21,17 @@ import (
 var userCmd = &cobra.Command{
 	Use:   "user",
 	Short: "Manage users and authentication",
-	Long:  `Create, list, and manage users for ZTAP authentication`,
+	Long: `Create, list, and manage users for ZTAP authentication.
+
+With --tenant, users are created in and listed from a tenant (organization).
+A tenant's users only see and change their tenant's policies, approvals and
+events; its tenant-admin manages them through the API. Users without a tenant
+operate the deployment and may act in any tenant.
+
+With --scope, a user only sees workloads carrying a set of labels, e.g.
+--scope namespace=payments: 'ztap logs', 'ztap discovery list' and the API's
+policies and event stream leave out everything else. Policies are shown if
+their podSelector falls within a scope. Repeat --scope to allow several.`,
 }
 
 var createUserCmd = &cobra.Command{
@@ -26,51 +41,68 @@ var createUserCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		username := args[0]
 		role, _ := cmd.Flags().GetString("role")
-
-		// Get auth manager
-		am, err := getAuthManager()
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
-		}
+		scopes := scopeFlags(cmd)
+		remote := getRemoteClient()
 
 		// Prompt for password
 		fmt.Print("Enter password: ")
 		passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
 		fmt.Println()
 		if err != nil {
-			fmt.Printf("Error reading password: %v\n", err)
-			os.Exit(1)
+			fail(fmt.Errorf("failed to read password: %w", err))
 		}
 
 		fmt.Print("Confirm password: ")
 		confirmBytes, err := term.ReadPassword(int(syscall.Stdin))
 		fmt.Println()
 		if err != nil {
-			fmt.Printf("Error reading password: %v\n", err)
-			os.Exit(1)
+			fail(fmt.Errorf("failed to read password: %w", err))
 		}
 
 		password := string(passwordBytes)
 		confirm := string(confirmBytes)
 
 		if password != confirm {
-			fmt.Println("Error: Passwords do not match")
-			os.Exit(1)
+			failf(exitValidation, "passwords do not match")
 		}
 
 		if len(password) < 8 {
-			fmt.Println("Error: Password must be at least 8 characters")
-			os.Exit(1)
+			failf(exitValidation, "password must be at least 8 characters")
 		}
 
 		// Create user
-		if err := am.CreateUser(username, password, auth.Role(role)); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+		tenant := activeTenant
+		if remote != nil {
+			user, err := remote.CreateUser(cmd.Context(), api.CreateUserRequest{
+				Username: username,
+				Password: password,
+				Role:     auth.Role(role),
+				Scopes:   scopes,
+			})
+			if err != nil {
+				fail(err)
+			}
+			tenant = user.Tenant
+		} else {
+			am, err := getAuthManager()
+			if err != nil {
+				fail(err)
+			}
+			if err := am.CreateTenantUser(username, password, auth.Role(role), activeTenant); err != nil {
+				fail(err)
+			}
+			if len(scopes) > 0 {
+				if err := am.SetScopes(username, scopes); err != nil {
+					fail(err)
+				}
+			}
 		}
 
-		fmt.Printf("User '%s' created successfully with role '%s'\n", username, role)
+		if tenant != "" {
+			printer.Printf("User '%s' created successfully in tenant '%s' with role '%s'\n", username, tenant, role)
+			return
+		}
+		printer.Printf("User '%s' created successfully with role '%s'\n", username, role)
 	},
 }
 
@@ -78,42 +110,45 @@ var listUsersCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all users",
 	Run: func(cmd *cobra.Command, args []string) {
-		am, err := getAuthManager()
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+		var users []*auth.User
+		if remote := getRemoteClient(); remote != nil {
+			listed, err := remote.ListUsers(cmd.Context())
+			if err != nil {
+				fail(err)
+			}
+			for i := range listed {
+				users = append(users, &listed[i])
+			}
+		} else {
+			am, err := getAuthManager()
+			if err != nil {
+				fail(err)
+			}
+			users = am.ListTenantUsers(activeTenant)
 		}
 
-		users := am.ListUsers()
-		if len(users) == 0 {
-			fmt.Println("No users found")
-			return
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "Username", Key: "username"},
+				{Header: "Role", Key: "role"},
+				{Header: "Tenant", Key: "tenant"},
+				{Header: "Scopes", Key: "scopes"},
+				{Header: "Enabled", Key: "enabled"},
+				{Header: "Created", Key: "created"},
+				{Header: "Last login", Key: "last_login"},
+			},
+			Empty: "No users found",
 		}
-
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-		fmt.Fprintln(w, "USERNAME\tROLE\tENABLED\tCREATED\tLAST LOGIN")
-		fmt.Fprintln(w, "--------\t----\t-------\t-------\t----------")
-
 		for _, user := range users {
-			lastLogin := "Never"
-			if !user.LastLogin.IsZero() {
-				lastLogin = user.LastLogin.Format("2006-01-02 15:04")
-			}
-
-			enabled := "Yes"
-			if !user.Enabled {
-				enabled = "No"
+			scopes := make([]string, len(user.Scopes))
+			for i, scope := range user.Scopes {
+				scopes[i] = scope.String()
 			}
-
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
-				user.Username,
-				user.Role,
-				enabled,
-				user.CreatedAt.Format("2006-01-02"),
-				lastLogin,
-			)
+			table.AddRow(user.Username, string(user.Role), user.Tenant, strings.Join(scopes, " | "), user.Enabled, user.CreatedAt, user.LastLogin)
+		}
+		if err := printer.Table(table); err != nil {
+			fail(err)
 		}
-		w.Flush()
 	},
 }
 
@@ -122,12 +157,12 @@ var changePasswordCmd = &cobra.Command{
 	Short: "Change user password",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		requireLocal(cmd)
 		username := args[0]
 
 		am, err := getAuthManager()
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+			fail(err)
 		}
 
 		// Prompt for old password
@@ -135,8 +170,7 @@ var changePasswordCmd = &cobra.Command{
 		oldPasswordBytes, err := term.ReadPassword(int(syscall.Stdin))
 		fmt.Println()
 		if err != nil {
-			fmt.Printf("Error reading password: %v\n", err)
-			os.Exit(1)
+			fail(fmt.Errorf("failed to read password: %w", err))
 		}
 
 		// Prompt for new password
@@ -144,38 +178,33 @@ var changePasswordCmd = &cobra.Command{
 		newPasswordBytes, err := term.ReadPassword(int(syscall.Stdin))
 		fmt.Println()
 		if err != nil {
-			fmt.Printf("Error reading password: %v\n", err)
-			os.Exit(1)
+			fail(fmt.Errorf("failed to read password: %w", err))
 		}
 
 		fmt.Print("Confirm new password: ")
 		confirmBytes, err := term.ReadPassword(int(syscall.Stdin))
 		fmt.Println()
 		if err != nil {
-			fmt.Printf("Error reading password: %v\n", err)
-			os.Exit(1)
+			fail(fmt.Errorf("failed to read password: %w", err))
 		}
 
 		newPassword := string(newPasswordBytes)
 		confirm := string(confirmBytes)
 
 		if newPassword != confirm {
-			fmt.Println("Error: Passwords do not match")
-			os.Exit(1)
+			failf(exitValidation, "passwords do not match")
 		}
 
 		if len(newPassword) < 8 {
-			fmt.Println("Error: Password must be at least 8 characters")
-			os.Exit(1)
+			failf(exitValidation, "password must be at least 8 characters")
 		}
 
 		// Change password
 		if err := am.ChangePassword(username, string(oldPasswordBytes), newPassword); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+			fail(err)
 		}
 
-		fmt.Printf("Password changed successfully for user '%s'\n", username)
+		printer.Printf("Password changed successfully for user '%s'\n", username)
 	},
 }
 
@@ -184,20 +213,19 @@ var disableUserCmd = &cobra.Command{
 	Short: "Disable a user account",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		requireLocal(cmd)
 		username := args[0]
 
 		am, err := getAuthManager()
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+			fail(err)
 		}
 
 		if err := am.DisableUser(username); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+			fail(err)
 		}
 
-		fmt.Printf("User '%s' disabled\n", username)
+		printer.Printf("User '%s' disabled\n", username)
 	},
 }
 
@@ -206,26 +234,71 @@ var enableUserCmd = &cobra.Command{
 	Short: "Enable a user account",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		requireLocal(cmd)
 		username := args[0]
 
 		am, err := getAuthManager()
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+			fail(err)
 		}
 
 		if err := am.EnableUser(username); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+			fail(err)
 		}
 
-		fmt.Printf("User '%s' enabled\n", username)
+		printer.Printf("User '%s' enabled\n", username)
 	},
 }
 
+var scopeUserCmd = &cobra.Command{
+	Use:   "scope <username>",
+	Short: "Set the label scopes limiting what a user sees",
+	Long: `Replace the scopes of a user with the given --scope flags, or lift the
+limit without any. The change also applies to the user's open sessions.`,
+	Example: `  ztap user scope pat --scope namespace=payments --scope namespace=billing
+  ztap user scope pat`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		requireLocal(cmd)
+		username := args[0]
+		scopes := scopeFlags(cmd)
+
+		am, err := getAuthManager()
+		if err != nil {
+			fail(err)
+		}
+
+		if err := am.SetScopes(username, scopes); err != nil {
+			fail(err)
+		}
+
+		if len(scopes) == 0 {
+			printer.Printf("User '%s' is no longer scoped\n", username)
+			return
+		}
+		printer.Printf("User '%s' scoped to %d label set(s)\n", username, len(scopes))
+	},
+}
+
+// scopeFlags parses the --scope flags of cmd
+func scopeFlags(cmd *cobra.Command) []auth.Scope {
+	values, _ := cmd.Flags().GetStringArray("scope")
+	scopes := make([]auth.Scope, 0, len(values))
+	for _, value := range values {
+		scope, err := auth.ParseScope(value)
+		if err != nil {
+			failf(exitValidation, "%v", err)
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
 var loginCmd = &cobra.Command{
 	Use:   "login",
 	Short: "Authenticate and create a session",
+	Long: `Authenticate and create a session. Against an API server (see 'ztap
+config'), the session is created by the server and kept per server.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		fmt.Print("Username: ")
 		var username string
@@ -235,31 +308,43 @@ var loginCmd = &cobra.Command{
 		passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
 		fmt.Println()
 		if err != nil {
-			fmt.Printf("Error reading password: %v\n", err)
-			os.Exit(1)
-		}
-
-		am, err := getAuthManager()
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+			fail(fmt.Errorf("failed to read password: %w", err))
 		}
 
-		session, err := am.Authenticate(username, string(passwordBytes))
-		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+		var session *auth.Session
+		tokenFile := getTokenFile()
+		if remote := getRemoteClient(); remote != nil {
+			session, err = remote.Authenticate(cmd.Context(), username, string(passwordBytes))
+			if err != nil {
+				fail(err)
+			}
+			tokenFile = getRemoteTokenFile(remote.Server())
+			if err := os.MkdirAll(filepath.Dir(tokenFile), 0700); err != nil {
+				fail(err)
+			}
+		} else {
+			am, err := getAuthManager()
+			if err != nil {
+				fail(err)
+			}
+			if session, err = am.Authenticate(username, string(passwordBytes)); err != nil {
+				fail(err)
+			}
 		}
 
 		// Save token to file
-		tokenFile := getTokenFile()
 		if err := os.WriteFile(tokenFile, []byte(session.Token), 0600); err != nil {
-			fmt.Printf("Error saving token: %v\n", err)
-			os.Exit(1)
+			fail(fmt.Errorf("failed to save token: %w", err))
 		}
 
-		fmt.Println("Login successful")
-		fmt.Printf("Session expires: %s\n", session.ExpiresAt.Format("2006-01-02 15:04:05"))
+		printer.Printf("Login successful\n")
+		if session.Tenant != "" {
+			printer.Printf("Tenant: %s\n", session.Tenant)
+		}
+		for _, scope := range session.Scopes {
+			printer.Printf("Scope: %s\n", scope)
+		}
+		printer.Printf("Session expires: %s\n", render.Cell(session.ExpiresAt))
 	},
 }
 
@@ -267,38 +352,54 @@ var logoutCmd = &cobra.Command{
 	Use:   "logout",
 	Short: "Logout and invalidate session",
 	Run: func(cmd *cobra.Command, args []string) {
+		if remote := getRemoteClient(); remote != nil {
+			tokenFile := getRemoteTokenFile(remote.Server())
+			if _, err := os.Stat(tokenFile); err != nil {
+				printer.Printf("Not logged in to %s\n", remote.Server())
+				return
+			}
+			// An expired session needs no logout; forget it either way
+			if err := remote.Logout(cmd.Context()); err != nil && !errors.Is(err, auth.ErrSessionNotFound) {
+				fail(err)
+			}
+			os.Remove(tokenFile)
+			printer.Printf("Logged out of %s\n", remote.Server())
+			return
+		}
+
 		tokenFile := getTokenFile()
 		tokenBytes, err := os.ReadFile(tokenFile)
 		if err != nil {
-			fmt.Println("Not logged in")
+			printer.Printf("Not logged in\n")
 			return
 		}
 
 		am, err := getAuthManager()
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+			fail(err)
 		}
 
 		if err := am.Logout(string(tokenBytes)); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			os.Exit(1)
+			fail(err)
 		}
 
 		// Remove token file
 		os.Remove(tokenFile)
-		fmt.Println("Logged out successfully")
+		printer.Printf("Logged out successfully\n")
 	},
 }
 
 func init() {
-	createUserCmd.Flags().StringP("role", "r", "operator", "User role (admin, operator, viewer)")
+	createUserCmd.Flags().StringP("role", "r", "operator", "User role (admin, operator, viewer, or tenant-admin with --tenant)")
+	createUserCmd.Flags().StringArray("scope", nil, "Only show workloads with these labels, e.g. namespace=payments (repeatable)")
+	scopeUserCmd.Flags().StringArray("scope", nil, "Only show workloads with these labels, e.g. namespace=payments (repeatable)")
 
 	userCmd.AddCommand(createUserCmd)
 	userCmd.AddCommand(listUsersCmd)
 	userCmd.AddCommand(changePasswordCmd)
 	userCmd.AddCommand(disableUserCmd)
 	userCmd.AddCommand(enableUserCmd)
+	userCmd.AddCommand(scopeUserCmd)
 	userCmd.AddCommand(loginCmd)
 	userCmd.AddCommand(logoutCmd)
 
@@ -306,13 +407,32 @@ func init() {
 }
 
 func getAuthManager() (*auth.AuthManager, error) {
-	homeDir, err := os.UserHomeDir()
+	config, err := getStorageConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
+		return nil, err
 	}
 
-	dbPath := filepath.Join(homeDir, ".ztap", "users.json")
-	return auth.NewAuthManager(dbPath)
+	var store auth.Store
+	if config.Backend == storage.BackendPostgres {
+		if store, err = getPostgresStore(config.Postgres); err != nil {
+			return nil, err
+		}
+	} else {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get home directory: %w", err)
+		}
+		store = auth.NewFileStore(filepath.Join(homeDir, ".ztap", "users.json"))
+	}
+
+	if config.Redis != nil {
+		redis, err := getRedisStore(*config.Redis)
+		if err != nil {
+			return nil, err
+		}
+		store = auth.SplitStore(store, redis)
+	}
+	return auth.NewAuthManagerWithStore(store)
 }
 
 func getTokenFile() string {
@@ -335,3 +455,35 @@ func CheckAuth(perm auth.Permission) error {
 
 	return am.HasPermission(string(tokenBytes), perm)
 }
+
+// currentPrincipal returns who enforcement started by this process is
+// attributed to: the user of a valid 'ztap user login' session, otherwise the
+// local account as "local:<name>"
+func currentPrincipal() string {
+	if session := loginSession(); session != nil {
+		return session.Username
+	}
+
+	if u, err := user.Current(); err == nil {
+		return "local:" + u.Username
+	}
+	return "local"
+}
+
+// loginSession returns the session of 'ztap user login' if it is still
+// valid, otherwise nil
+func loginSession() *auth.Session {
+	token, err := os.ReadFile(getTokenFile())
+	if err != nil {
+		return nil
+	}
+	am, err := getAuthManager()
+	if err != nil {
+		return nil
+	}
+	session, err := am.ValidateSession(strings.TrimSpace(string(token)))
+	if err != nil {
+		return nil
+	}
+	return session
+}
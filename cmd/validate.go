@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"ztap/pkg/policy"
+	"ztap/pkg/policylint"
+	"ztap/pkg/render"
+
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate -f policy.yaml [-f more.yaml...] [--lint]",
+	Short: "Validate policy files without enforcing them",
+	Long: `Check that every policy of the policy files is valid, as 'ztap enforce' would,
+without touching the backend. Policies of different files sharing a name must
+be identical, unless the later one has the annotation ztap.io/override: "true"
+to replace the earlier one; otherwise both files are reported. Every
+metadata.dependsOn entry must name a policy of the files, without cycles.
+
+With --lint, valid policies are also checked against best practices:
+
+  open-destination  an egress rule allows 0.0.0.0/0 or ::/0
+  broad-selector    a podSelector has a single common label (env, tier, ...),
+                    or one most known workloads share
+  missing-owner     no owner annotation (metadata.annotations.owner)
+  port-range        an egress rule allows more ports of a protocol than maxPorts
+  unused-selector   a podSelector matches no known workload
+
+Known workloads are registered services and those of --workloads; without
+any, broad-selector only checks label keys and unused-selector is skipped.
+
+Rules report warnings by default. --lint-config sets severities (error,
+warning, info or off) and suppressions:
+
+  rules:
+    open-destination: error
+  maxPorts: 100
+  suppress:
+    - rule: open-destination
+      policy: allow-egress-proxy
+
+A policy can also suppress rules itself with the lint.ztap.io/ignore
+annotation, e.g. "open-destination,missing-owner".
+
+Exits with status 2 when a policy is invalid or a finding is an error, or a
+warning with --strict.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		policyFiles, _ := cmd.Flags().GetStringSlice("file")
+		lint, _ := cmd.Flags().GetBool("lint")
+		configFile, _ := cmd.Flags().GetString("lint-config")
+		workloadsFile, _ := cmd.Flags().GetString("workloads")
+		strict, _ := cmd.Flags().GetBool("strict")
+
+		policies, err := policy.LoadFromFiles(policyFiles...)
+		if err != nil {
+			fail(validationErrorf("failed to load policies: %w", err))
+		}
+		for i := range policies {
+			if err := policies[i].Validate(); err != nil {
+				fail(err)
+			}
+		}
+		if err := policy.CheckDependencies(policies); err != nil {
+			fail(err)
+		}
+		if !lint {
+			printer.Printf("%d policies valid\n", len(policies))
+			return
+		}
+
+		config := policylint.DefaultConfig()
+		if configFile != "" {
+			if config, err = policylint.LoadConfig(configFile); err != nil {
+				fail(validationError(err))
+			}
+		}
+		workloads, err := collectWorkloads(cmd, workloadsFile)
+		if err != nil {
+			fail(err)
+		}
+		labels := make([]map[string]string, len(workloads))
+		for i, w := range workloads {
+			labels[i] = w.Labels
+		}
+
+		findings := policylint.Lint(policies, labels, config)
+		table := render.Table{
+			Columns: []render.Column{
+				{Header: "Policy", Key: "policy"},
+				{Header: "Severity", Key: "severity"},
+				{Header: "Rule", Key: "rule"},
+				{Header: "Field", Key: "field"},
+				{Header: "Message", Key: "message"},
+			},
+			Empty: "No lint findings",
+		}
+		failing := 0
+		for _, f := range findings {
+			table.AddRow(f.Policy, string(f.Severity), f.Rule, f.Field, f.Message)
+			if f.Severity == policylint.SeverityError || (strict && f.Severity == policylint.SeverityWarning) {
+				failing++
+			}
+		}
+		if err := printer.Table(table); err != nil {
+			fail(err)
+		}
+		if failing > 0 {
+			failf(exitValidation, "%d lint finding(s) must be fixed or suppressed", failing)
+		}
+	},
+}
+
+func init() {
+	validateCmd.Flags().StringSliceP("file", "f", []string{"policy.yaml"}, "Path to policy YAML file; repeat to validate several files together")
+	validateCmd.Flags().Bool("lint", false, "Also check policies against best-practice rules")
+	validateCmd.Flags().String("lint-config", "", "YAML file setting lint rule severities, thresholds and suppressions")
+	validateCmd.Flags().String("workloads", "", "YAML list of workloads (name, ip, labels) to lint selectors against besides registered services")
+	validateCmd.Flags().Bool("strict", false, "Exit non-zero on lint warnings too")
+	rootCmd.AddCommand(validateCmd)
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"ztap/pkg/discovery"
+	"ztap/pkg/enforcer"
+	"ztap/pkg/policy"
+	"ztap/pkg/render"
+	"ztap/pkg/whatif"
+
+	"github.com/spf13/cobra"
+)
+
+var whatifCmd = &cobra.Command{
+	Use:   "whatif -f policy.yaml [--scale selector:count] [--remove service]",
+	Short: "Simulate discovery changes and report their effect on rule counts",
+	Long: `Compile the policies of a policy file against service discovery as it is
+and as it would be after hypothetical changes, and report how many rules each
+policy compiles to, the use of the eBPF policy map and the number of Security
+Group rules 'ztap cloud sync' would keep, against their limits.
+
+--scale makes a label selector resolve to a number of IPs, adding
+hypothetical workloads with the selector's labels (IPs from 198.18.0.0/15)
+or removing the last matching ones. --remove drops a registered service by
+name or IP. Both can be repeated:
+
+  ztap discovery whatif -f policy.yaml --scale app=db:50
+  ztap discovery whatif -f policy.yaml --scale app=web,tier=edge:200 --remove legacy-api
+  ztap discovery whatif -f policy.yaml --scale app=db:500 --exit-code   # exit 1 over a limit
+
+Security Groups allow 60 inbound and 60 outbound rules by default, counted
+separately for IPv4 and IPv6; pass a raised quota with --sg-rule-limit.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, _ := cmd.Flags().GetString("file")
+		scales, _ := cmd.Flags().GetStringArray("scale")
+		removals, _ := cmd.Flags().GetStringArray("remove")
+		sgRuleLimit, _ := cmd.Flags().GetInt("sg-rule-limit")
+		exitCode, _ := cmd.Flags().GetBool("exit-code")
+
+		policies, err := policy.LoadFromFile(file)
+		if err != nil {
+			fail(validationErrorf("failed to load policies: %w", err))
+		}
+		var scenario whatif.Scenario
+		for _, s := range scales {
+			scale, err := whatif.ParseScale(s)
+			if err != nil {
+				fail(validationError(err))
+			}
+			scenario.Scale = append(scenario.Scale, scale)
+		}
+		for _, service := range removals {
+			ip, err := serviceIP(service)
+			if err != nil {
+				fail(validationError(err))
+			}
+			scenario.Remove = append(scenario.Remove, ip)
+		}
+		if len(scenario.Scale) == 0 && len(scenario.Remove) == 0 {
+			failf(exitValidation, "nothing to simulate; pass --scale or --remove")
+		}
+
+		resolver, _, err := newPolicyResolver(cmd)
+		if err != nil {
+			fail(err)
+		}
+		report, err := whatif.Analyze(policies, resolver, scenario, whatif.Limits{
+			MapCapacity:            enforcer.BackendPolicyCapacity(enforcer.BackendEBPF),
+			SecurityGroupRuleLimit: sgRuleLimit,
+		})
+		if err != nil {
+			fail(validationError(err))
+		}
+
+		if printer.Structured() {
+			err = printer.Value(report)
+		} else {
+			err = printWhatif(report)
+		}
+		if err != nil {
+			fail(err)
+		}
+		if exitCode && (report.OverMapCapacity() || report.OverSecurityGroupLimit()) {
+			os.Exit(exitFailure)
+		}
+	},
+}
+
+func init() {
+	whatifCmd.Flags().StringP("file", "f", "policy.yaml", "Path to policy YAML file")
+	whatifCmd.Flags().StringArray("scale", nil, "Make a selector resolve to a number of IPs, e.g. app=db:50 (repeatable)")
+	whatifCmd.Flags().StringArray("remove", nil, "Remove a registered service, by name or IP (repeatable)")
+	whatifCmd.Flags().Int("sg-rule-limit", whatif.DefaultSecurityGroupRuleLimit, "Security Group rules allowed per direction and address family")
+	whatifCmd.Flags().Bool("exit-code", false, "Exit with status 1 when a limit would be exceeded")
+	whatifCmd.Flags().String("aws-region", "", "Also resolve label selectors against AWS resources in this region")
+	whatifCmd.Flags().String("aws-accounts", "", "Also resolve label selectors against AWS resources in the accounts and regions of this YAML file")
+	discoveryCmd.AddCommand(whatifCmd)
+}
+
+// serviceIP returns the IP of a registered service given by name, or the
+// IP given
+func serviceIP(service string) (string, error) {
+	if net.ParseIP(service) != nil {
+		return service, nil
+	}
+	if mem, ok := getDiscoveryBackend().(*discovery.InMemoryDiscovery); ok {
+		for _, s := range mem.ListServices() {
+			if s.Name == service {
+				return s.IP, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unknown service %q; pass its IP instead", service)
+}
+
+// printWhatif prints the rule count changes of report and the limits they
+// exceed
+func printWhatif(report *whatif.Report) error {
+	table := render.Table{
+		Columns: []render.Column{
+			{Header: "Policy", Key: "policy"},
+			{Header: "Rules before", Key: "before"},
+			{Header: "Rules after", Key: "after"},
+			{Header: "Change", Key: "change"},
+			{Header: "Error", Key: "error"},
+		},
+		Empty: "No policy's rules change",
+	}
+	for _, change := range report.Policies {
+		table.AddRow(change.Policy, change.Rules.Before, change.Rules.After, fmt.Sprintf("%+d", change.Rules.Delta()), change.Error)
+	}
+	if err := printer.Table(table); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nCompiled rules: %s\n", formatChange(report.Rules))
+	if report.MapCapacity > 0 {
+		fmt.Printf("eBPF policy map: %s of %d entries (%.1f%%)\n", formatChange(report.MapEntries), report.MapCapacity,
+			100*float64(report.MapEntries.After)/float64(report.MapCapacity))
+	}
+	fmt.Printf("Security Group rules: egress %s, ingress %s (limit %d per direction)\n",
+		formatChange(report.SecurityGroupEgress), formatChange(report.SecurityGroupIngress), report.SecurityGroupRuleLimit)
+
+	if report.OverMapCapacity() {
+		fmt.Fprintf(os.Stderr, "Warning: %d entries would not fit in the eBPF policy map of %d entries\n",
+			report.MapEntries.After, report.MapCapacity)
+	}
+	if report.OverSecurityGroupLimit() {
+		fmt.Fprintf(os.Stderr, "Warning: The Security Group rules would exceed the limit of %d per direction\n",
+			report.SecurityGroupRuleLimit)
+	}
+	return nil
+}
+
+// formatChange formats a count before and after, e.g. "12 -> 60 (+48)"
+func formatChange(c whatif.Change) string {
+	if c.Delta() == 0 {
+		return fmt.Sprintf("%d (unchanged)", c.After)
+	}
+	return fmt.Sprintf("%d -> %d (%+d)", c.Before, c.After, c.Delta())
+}
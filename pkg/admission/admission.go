@@ -0,0 +1,265 @@
+// Package admission checks policies submitted by external systems, such as
+// CI pipelines and ticketing workflows, before they are staged: a submission
+// must validate, pass the lint rules and, when configured, be allowed by an
+// Open Policy Agent decision.
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"ztap/pkg/configfile"
+	"ztap/pkg/policy"
+	"ztap/pkg/policylint"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultTimeout bounds an OPA query when the config sets no timeout
+const DefaultTimeout = 5 * time.Second
+
+// OPAConfig points at an OPA decision, queried with the Data API
+type OPAConfig struct {
+	// URL of the decision, e.g. http://opa:8181/v1/data/ztap/admission
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Config is the admission section of config.yaml:
+//
+//	admission:
+//	  strict: true              # lint warnings reject too
+//	  lint:                     # as 'ztap validate --lint-config'
+//	    rules:
+//	      open-destination: error
+//	  opa:
+//	    url: http://opa:8181/v1/data/ztap/admission
+//	    timeout: 5s
+type Config struct {
+	Lint   policylint.Config `yaml:"lint"`
+	Strict bool              `yaml:"strict"`
+	OPA    OPAConfig         `yaml:"opa"`
+}
+
+// DefaultConfig lints with the default rules and queries no OPA
+func DefaultConfig() Config {
+	return Config{Lint: policylint.DefaultConfig()}
+}
+
+// Validate checks the lint rules and OPA settings
+func (c Config) Validate() error {
+	if err := c.Lint.Validate(); err != nil {
+		return fmt.Errorf("admission.lint: %w", err)
+	}
+	if c.OPA.URL != "" {
+		if u, err := url.Parse(c.OPA.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("admission.opa.url: %q is not an http(s) URL", c.OPA.URL)
+		}
+	}
+	if c.OPA.Timeout < 0 {
+		return errors.New("admission.opa.timeout must not be negative")
+	}
+	return nil
+}
+
+// LoadConfig reads the admission section of file. A missing file or section
+// gives DefaultConfig.
+func LoadConfig(file *configfile.File) (Config, error) {
+	c := DefaultConfig()
+	if err := file.Section("admission", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Submission is a policy document submitted for admission
+type Submission struct {
+	Name      string `json:"name"`
+	YAML      string `json:"yaml"`
+	Source    string `json:"source,omitempty"`    // Submitting system, e.g. ci
+	Reference string `json:"reference,omitempty"` // e.g. a commit or ticket
+	User      string `json:"user"`
+	Tenant    string `json:"tenant,omitempty"`
+}
+
+// Result is the outcome of the checks of a submission
+type Result struct {
+	Allowed  bool                 `json:"allowed"`
+	Errors   []string             `json:"errors,omitempty"` // Why the document is invalid
+	Findings []policylint.Finding `json:"findings,omitempty"`
+	Denials  []string             `json:"denials,omitempty"` // Messages of the OPA decision
+}
+
+// Checker checks submissions
+type Checker struct {
+	config Config
+	http   *http.Client
+}
+
+// NewChecker returns a checker applying config
+func NewChecker(config Config) *Checker {
+	if config.OPA.Timeout == 0 {
+		config.OPA.Timeout = DefaultTimeout
+	}
+	return &Checker{config: config, http: &http.Client{Timeout: config.OPA.Timeout}}
+}
+
+// Check validates the submission, lints its policies and asks OPA, in
+// that order, stopping at the first step that rejects it. Lint errors
+// reject it, and warnings too with Config.Strict. It returns an error only
+// when OPA cannot be queried.
+func (c *Checker) Check(ctx context.Context, sub Submission) (Result, error) {
+	policies, err := policy.Parse([]byte(sub.YAML))
+	if err != nil {
+		return Result{Errors: []string{fmt.Sprintf("invalid policy YAML: %v", err)}}, nil
+	}
+	if len(policies) == 0 {
+		return Result{Errors: []string{"no policies in the document"}}, nil
+	}
+	var result Result
+	for i := range policies {
+		if err := policies[i].Validate(); err != nil {
+			result.Errors = append(result.Errors, err.Error())
+		}
+	}
+	if len(result.Errors) == 0 {
+		if err := policy.CheckDependencies(policies); err != nil {
+			result.Errors = append(result.Errors, err.Error())
+		}
+	}
+	if len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	result.Findings = policylint.Lint(policies, nil, c.config.Lint)
+	for _, f := range result.Findings {
+		if f.Severity == policylint.SeverityError || (c.config.Strict && f.Severity == policylint.SeverityWarning) {
+			return result, nil
+		}
+	}
+
+	if c.config.OPA.URL != "" {
+		denials, err := c.queryOPA(ctx, sub, policies)
+		if err != nil {
+			return result, err
+		}
+		if len(denials) > 0 {
+			result.Denials = denials
+			return result, nil
+		}
+	}
+	result.Allowed = true
+	return result, nil
+}
+
+// opaInput is the input document of the OPA query. Policies keep the
+// field names of their YAML.
+type opaInput struct {
+	Submission
+	Policies []any `json:"policies"`
+}
+
+// queryOPA returns why the OPA decision denies the submission, or nil if it
+// allows it. The decision may be a boolean (allow), a list of deny
+// messages, or an object with allow and deny fields.
+func (c *Checker) queryOPA(ctx context.Context, sub Submission, policies []policy.NetworkPolicy) ([]string, error) {
+	input := opaInput{Submission: sub}
+	for _, p := range policies {
+		doc, err := yamlDocument(p)
+		if err != nil {
+			return nil, err
+		}
+		input.Policies = append(input.Policies, doc)
+	}
+	body, err := json.Marshal(map[string]any{"input": input})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.OPA.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query OPA: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("OPA answered %s", resp.Status)
+	}
+
+	var decision struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("invalid OPA response: %w", err)
+	}
+	if len(decision.Result) == 0 {
+		return nil, errors.New("OPA returned no decision; check admission.opa.url")
+	}
+	return parseDecision(decision.Result)
+}
+
+// parseDecision returns the denials of an OPA decision
+func parseDecision(result json.RawMessage) ([]string, error) {
+	var allow bool
+	if err := json.Unmarshal(result, &allow); err == nil {
+		if allow {
+			return nil, nil
+		}
+		return []string{"denied by OPA"}, nil
+	}
+	var deny []string
+	if err := json.Unmarshal(result, &deny); err == nil {
+		return deny, nil
+	}
+	var object struct {
+		Allow *bool    `json:"allow"`
+		Deny  []string `json:"deny"`
+	}
+	if err := json.Unmarshal(result, &object); err != nil {
+		return nil, fmt.Errorf("unexpected OPA decision %s", result)
+	}
+	if len(object.Deny) == 0 && object.Allow != nil && !*object.Allow {
+		return []string{"denied by OPA"}, nil
+	}
+	return object.Deny, nil
+}
+
+// yamlDocument returns v as it encodes to YAML, with maps JSON can encode
+func yamlDocument(v any) (any, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return jsonValue(doc), nil
+}
+
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []any:
+		for i := range v {
+			v[i] = jsonValue(v[i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,138 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ztap/pkg/configfile"
+	"ztap/pkg/policylint"
+)
+
+const testPolicy = `apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+  annotations:
+    owner: payments
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/24
+      ports:
+        - protocol: TCP
+          port: 5432
+`
+
+func TestCheck(t *testing.T) {
+	strict := DefaultConfig()
+	strict.Strict = true
+	open := strings.Replace(testPolicy, "10.0.0.0/24", "0.0.0.0/0", 1)
+
+	tests := []struct {
+		name     string
+		config   Config
+		yaml     string
+		allowed  bool
+		errors   bool
+		findings int
+	}{
+		{"valid", DefaultConfig(), testPolicy, true, false, 0},
+		{"invalid yaml", DefaultConfig(), "kind: [\n", false, true, 0},
+		{"invalid policy", DefaultConfig(), strings.Replace(testPolicy, "5432", "70000", 1), false, true, 0},
+		{"lint warning", DefaultConfig(), open, true, false, 1},
+		{"lint warning when strict", strict, open, false, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewChecker(tt.config).Check(context.Background(), Submission{Name: "web", YAML: tt.yaml})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Allowed != tt.allowed || (len(result.Errors) > 0) != tt.errors || len(result.Findings) != tt.findings {
+				t.Errorf("Unexpected result %+v", result)
+			}
+		})
+	}
+}
+
+func TestCheckOPA(t *testing.T) {
+	var decision string
+	var input map[string]any
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Input map[string]any `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		input = body.Input
+		w.Write([]byte(decision))
+	}))
+	defer opa.Close()
+
+	config := DefaultConfig()
+	config.OPA.URL = opa.URL + "/v1/data/ztap/admission/deny"
+	checker := NewChecker(config)
+	sub := Submission{Name: "web", YAML: testPolicy, Source: "ci", User: "robot"}
+
+	tests := []struct {
+		decision string
+		denials  []string
+		err      bool
+	}{
+		{`{"result": []}`, nil, false},
+		{`{"result": ["port 5432 needs a ticket"]}`, []string{"port 5432 needs a ticket"}, false},
+		{`{"result": true}`, nil, false},
+		{`{"result": false}`, []string{"denied by OPA"}, false},
+		{`{"result": {"allow": false, "deny": ["no"]}}`, []string{"no"}, false},
+		{`{}`, nil, true},
+	}
+	for _, tt := range tests {
+		decision = tt.decision
+		result, err := checker.Check(context.Background(), sub)
+		if (err != nil) != tt.err {
+			t.Fatalf("%s: unexpected error %v", tt.decision, err)
+		}
+		if err == nil && (result.Allowed != (len(tt.denials) == 0) || strings.Join(result.Denials, ";") != strings.Join(tt.denials, ";")) {
+			t.Errorf("%s: unexpected result %+v", tt.decision, result)
+		}
+	}
+
+	policies, _ := input["policies"].([]any)
+	if input["source"] != "ci" || input["user"] != "robot" || len(policies) != 1 {
+		t.Fatalf("Unexpected OPA input %+v", input)
+	}
+	metadata, _ := policies[0].(map[string]any)["metadata"].(map[string]any)
+	if metadata["name"] != "web-to-db" {
+		t.Errorf("Expected policies with their YAML field names, got %+v", policies[0])
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if config, err := configfile.Read(path, LoadConfig); err != nil || config.Lint.MaxPorts != 100 {
+		t.Fatalf("Expected defaults for a missing file, got %+v, %v", config, err)
+	}
+
+	os.WriteFile(path, []byte("admission:\n  strict: true\n  lint:\n    rules:\n      open-destination: error\n  opa:\n    url: http://opa:8181/v1/data/ztap\n"), 0600)
+	config, err := configfile.Read(path, LoadConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Strict || config.Lint.Rules[policylint.RuleOpenDestination] != policylint.SeverityError || config.Lint.OwnerAnnotation != "owner" || config.OPA.URL == "" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	os.WriteFile(path, []byte("admission:\n  opa:\n    url: opa:8181\n"), 0600)
+	if _, err := configfile.Read(path, LoadConfig); err == nil {
+		t.Error("Expected an error for an OPA URL without a scheme")
+	}
+}
@@ -0,0 +1,485 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/clock"
+	"ztap/pkg/cluster"
+	"ztap/pkg/faults"
+	"ztap/pkg/policy"
+)
+
+// EnforceFunc applies a complete set of compiled policies to the local
+// enforcement backend, replacing whatever was applied before. ctx carries the
+// principal that initiated the enforcement (see auth.PrincipalFromContext).
+type EnforceFunc func(ctx context.Context, policies []*policy.CompiledPolicy) error
+
+// LoadFunc returns the desired policy set
+type LoadFunc func() ([]policy.NetworkPolicy, error)
+
+// MutateFunc rewrites the desired policy set before it is compiled
+type MutateFunc func(ctx context.Context, policies []policy.NetworkPolicy) ([]policy.NetworkPolicy, error)
+
+// Agent keeps local enforcement in sync with the desired policy set. It owns
+// the compile cache, so a steady-state cycle re-resolves selectors but skips
+// recompiling unchanged policies, and skips the backend entirely when no
+// compiled policy changed since the last successful enforcement.
+type Agent struct {
+	cache       *policy.CompileCache
+	enforce     EnforceFunc
+	mutate      MutateFunc
+	reconciled  func()            // Called after each successful cycle of Run
+	nodeLabels  map[string]string // Matched against policy nodeSelectors
+	nodeID      string            // Followed by the cordon and drain config keys
+	concurrency int
+	protection  *SelfProtection // Enforced alongside user policies
+
+	mu         sync.Mutex
+	applied    map[string]*policy.CompiledPolicy // Last enforced, keyed by policy name
+	order      []string                          // Names of the applied policies, in apply order
+	principals map[string]string                 // Who last changed each applied policy
+	restored   bool                              // applied was restored, not enforced by this agent
+	lastGood   time.Time                         // When applied was last reconciled without errors
+	staleAfter time.Duration
+	stale      bool // Alerted that applied is stale
+	guard      ShrinkGuard
+	held       map[string]time.Time // Policies held back by the shrink guard, since when
+	confirmed  map[string]bool      // Held policies an operator confirmed
+	ttlStart   map[string]time.Time // When policies with a ttl were first seen
+	expired    map[string]*expiredPolicy
+	nextExpiry time.Time // Earliest upcoming policy expiry, zero if none
+	breakGlass breakGlass
+	monitoring bool            // Rules removed from the backend for break-glass
+	drained    bool            // Rules removed from the backend for a monitor-mode drain
+	announced  map[string]bool // Maintenance windows seen to start
+	nextWindow time.Time       // Next maintenance window start or end, zero if none
+	now        func() time.Time
+	clock      clock.Clock // Schedules reconcile cycles and expiries
+	wake       chan struct{}
+
+	configMu  sync.RWMutex
+	config    map[string]string                    // Live cluster configuration
+	windows   map[string]cluster.MaintenanceWindow // Maintenance windows, by name
+	schedules map[string]cluster.NodeSchedule      // Cordoned and drained nodes, by ID
+
+	fence    cluster.Fence // Highest leadership epoch accepted from policy sync
+	syncMu   sync.Mutex
+	synced   map[string][]policy.NetworkPolicy // Policies received from the leader, by update name
+	versions map[string]int64                  // Last applied version, by update name; kept once removed
+	withheld map[string]string                 // Updates not understood, by update name: the reason
+	sealer   cluster.PayloadSealer             // Opens sealed updates; nil accepts them in the clear
+}
+
+// logLevels orders the cluster log-level values
+var logLevels = map[string]int{"debug": 0, "info": 1, "warn": 2, "error": 3}
+
+// logPrefixes match the prefixes used by the CLI's own messages
+var logPrefixes = map[string]string{"debug": "Debug: ", "info": "", "warn": "Warning: ", "error": "Error: "}
+
+// New creates an agent compiling through cache and enforcing with enforce,
+// compiling up to concurrency policies in parallel
+func New(cache *policy.CompileCache, enforce EnforceFunc, concurrency int) *Agent {
+	return &Agent{
+		cache:       cache,
+		enforce:     enforce,
+		concurrency: concurrency,
+		guard:       DefaultShrinkGuard,
+		held:        make(map[string]time.Time),
+		confirmed:   make(map[string]bool),
+		ttlStart:    make(map[string]time.Time),
+		expired:     make(map[string]*expiredPolicy),
+		announced:   make(map[string]bool),
+		now:         time.Now,
+		clock:       clock.Real,
+		wake:        make(chan struct{}, 1),
+		config:      make(map[string]string),
+		windows:     make(map[string]cluster.MaintenanceWindow),
+		schedules:   make(map[string]cluster.NodeSchedule),
+		synced:      make(map[string][]policy.NetworkPolicy),
+		versions:    make(map[string]int64),
+		withheld:    make(map[string]string),
+	}
+}
+
+// SetClock replaces the clock that schedules reconcile cycles and decides
+// expiries, e.g. with a clock.Fake in tests. Call it before Run.
+func (a *Agent) SetClock(clk clock.Clock) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clock = clock.OrReal(clk)
+	a.now = a.clock.Now
+}
+
+// SetMutator makes Reconcile pass the policy set through mutate before
+// compiling it. Call it before Run.
+func (a *Agent) SetMutator(mutate MutateFunc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.mutate = mutate
+}
+
+// SetNodeLabels sets the labels of the node the agent runs on. Policies with
+// a nodeSelector that does not match them are not enforced, like policies the
+// scheduler does not assign to the node. Call it before Run.
+func (a *Agent) SetNodeLabels(labels map[string]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nodeLabels = labels
+}
+
+// selectingNode returns the policies distributed to this node (requires
+// holding mu)
+func (a *Agent) selectingNode(policies []policy.NetworkPolicy) []policy.NetworkPolicy {
+	selected := make([]policy.NetworkPolicy, 0, len(policies))
+	for _, p := range policies {
+		if p.SelectsNode(a.nodeLabels) {
+			selected = append(selected, p)
+		}
+	}
+	return selected
+}
+
+// withMutator returns load followed by the mutator, if one is set
+func (a *Agent) withMutator(ctx context.Context, load LoadFunc) LoadFunc {
+	a.mu.Lock()
+	mutate := a.mutate
+	a.mu.Unlock()
+	if mutate == nil {
+		return load
+	}
+	return func() ([]policy.NetworkPolicy, error) {
+		policies, err := load()
+		if err != nil {
+			return nil, err
+		}
+		return mutate(ctx, policies)
+	}
+}
+
+// ApplyConfig applies a cluster configuration change; pass it to
+// cluster.ApplyConfig to follow the cluster configuration live
+func (a *Agent) ApplyConfig(entry cluster.ConfigEntry) {
+	a.configMu.Lock()
+	if entry.Deleted {
+		delete(a.config, entry.Key)
+	} else {
+		a.config[entry.Key] = entry.Value
+	}
+	a.configMu.Unlock()
+
+	if entry.Key == cluster.ConfigConfirmShrink && !entry.Deleted {
+		a.ConfirmShrink(strings.Split(entry.Value, ",")...)
+	}
+	if entry.Key == cluster.ConfigBreakGlass {
+		a.applyBreakGlass(entry)
+	}
+	if strings.HasPrefix(entry.Key, cluster.ConfigMaintenancePrefix) {
+		a.applyMaintenance(entry)
+	}
+	if strings.HasPrefix(entry.Key, cluster.ConfigCordonPrefix) || strings.HasPrefix(entry.Key, cluster.ConfigDrainPrefix) {
+		a.applyNodeSchedule(entry)
+	}
+
+	if entry.Deleted {
+		a.logf("info", "Cluster config %s unset (version %d)", entry.Key, entry.Version)
+	} else {
+		a.logf("info", "Cluster config %s=%s (version %d)", entry.Key, entry.Value, entry.Version)
+	}
+}
+
+// Config returns the live value of a cluster configuration key
+func (a *Agent) Config(key string) (string, bool) {
+	a.configMu.RLock()
+	defer a.configMu.RUnlock()
+	value, ok := a.config[key]
+	return value, ok
+}
+
+// Feature reports whether the feature flag name is enabled cluster-wide
+func (a *Agent) Feature(name string) bool {
+	value, _ := a.Config(cluster.ConfigFeaturePrefix + name)
+	enabled, _ := strconv.ParseBool(value)
+	return enabled
+}
+
+// logf logs a message at level unless the cluster log-level is higher
+// (default info)
+func (a *Agent) logf(level, format string, args ...any) {
+	threshold, ok := a.Config(cluster.ConfigLogLevel)
+	if !ok {
+		threshold = "info"
+	}
+	if logLevels[level] < logLevels[threshold] {
+		return
+	}
+	log.Printf(logPrefixes[level]+format, args...)
+}
+
+// Reconcile compiles policies and enforces the result if it differs from
+// what was last enforced. A policy that fails to compile keeps its previously
+// enforced rules rather than being dropped, so a discovery outage cannot
+// remove allow rules; the compile error is still returned. Likewise a policy
+// whose endpoints shrink past the ShrinkGuard keeps its previous rules. If
+// the mutator fails, nothing is compiled and enforcement is left as it was.
+// Temporary policies past their expiresAt or ttl are removed. Policies
+// selecting workloads an open maintenance window covers keep their enforced
+// rules, or stay unenforced if new, until the window ends. Policies are
+// enforced in dependency order (see policy.Levels); a policy whose dependency
+// is not enforced keeps its enforced rules, or stays unenforced if new, and a
+// dependency cycle leaves enforcement as it was. A cordoned node
+// does not enforce policies new to it, and a drained node either keeps its
+// enforced rules or enforces nothing, depending on the drain mode. While
+// break-glass is active nothing is enforced. The self-protection policy, if
+// set, is enforced ahead of any other policy.
+func (a *Agent) Reconcile(ctx context.Context, policies []policy.NetworkPolicy) ([]*policy.CompiledPolicy, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.mutate != nil {
+		mutated, err := a.mutate(ctx, policies)
+		if err != nil {
+			return nil, err
+		}
+		policies = mutated
+	}
+	policies = a.selectingNode(policies)
+
+	a.forgetRemoved(policies)
+	policies, a.nextExpiry = a.dropExpired(policies)
+	a.nextWindow = a.updateMaintenance()
+	windows := a.activeWindows()
+	policies, err := policy.Order(policies)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, compileErr := a.cache.CompileAll(ctx, policies, a.concurrency)
+
+	present := make(map[string]bool, len(policies))
+	for _, p := range policies {
+		present[p.Metadata.Name] = true
+	}
+	desired := make([]*policy.CompiledPolicy, 0, len(compiled))
+	enforced := make(map[string]bool, len(compiled))
+	unmet := make(map[string]error)
+	for i, c := range compiled {
+		name := policies[i].Metadata.Name
+		switch dep := unmetDependency(policies[i], present, enforced); {
+		case paused(windows, policies[i]):
+			c = a.applied[name]
+		case c == nil:
+			c = a.applied[name]
+		case dep != "":
+			c = a.applied[name]
+			unmet[name] = fmt.Errorf("dependency %s is not enforced", dep)
+			a.logf("warn", "Policy %s waits for its dependency %s; keeping its enforced rules, if any", name, dep)
+		default:
+			c = a.guardShrink(c)
+		}
+		if c != nil {
+			desired = append(desired, c)
+			enforced[name] = true
+		}
+	}
+	compileErr = withFailures(compileErr, unmet)
+	if schedule := a.schedule(a.nodeID); schedule.Cordoned || schedule.Drained() {
+		desired = a.cordoned(schedule, desired)
+	}
+	desired = a.protection.protect(desired)
+
+	return a.apply(ctx, desired, compileErr)
+}
+
+// apply enforces desired unless it is exactly what was last enforced, or
+// suspends enforcement while break-glass is active or the node is drained in
+// monitor mode (requires holding mu).
+// Policies that changed are attributed to the principal in ctx.
+func (a *Agent) apply(ctx context.Context, desired []*policy.CompiledPolicy, compileErr error) ([]*policy.CompiledPolicy, error) {
+	if a.suspended() {
+		if err := a.suspend(ctx); err != nil {
+			return desired, errors.Join(compileErr, err)
+		}
+		return desired, compileErr
+	}
+	switch schedule := a.schedule(a.nodeID); {
+	case schedule.Drain == cluster.DrainMonitor:
+		if err := a.drain(ctx, schedule); err != nil {
+			return desired, errors.Join(compileErr, err)
+		}
+		return desired, compileErr
+	case schedule.Drain == cluster.DrainKeep && a.applied == nil:
+		// Nothing was enforced by this agent yet, so the backend keeps
+		// whatever rules it holds
+		return desired, compileErr
+	}
+	if a.applied != nil && !a.restored && !a.monitoring && !a.drained && unchanged(a.applied, desired) {
+		return desired, compileErr
+	}
+
+	if err := faults.Inject(faults.Enforce); err != nil {
+		return desired, errors.Join(compileErr, err)
+	}
+	if err := a.enforce(ctx, desired); err != nil {
+		return desired, errors.Join(compileErr, err)
+	}
+	if a.monitoring {
+		a.resumed()
+	}
+	if a.drained {
+		a.drained = false
+		a.logf("info", "Node no longer drained; enforcement restored")
+	}
+
+	principal := auth.PrincipalFromContext(ctx)
+	applied := make(map[string]*policy.CompiledPolicy, len(desired))
+	principals := make(map[string]string, len(desired))
+	for _, c := range desired {
+		applied[c.Name] = c
+		principals[c.Name] = principal
+		if previous, ok := a.applied[c.Name]; ok && previous.Hash == c.Hash {
+			principals[c.Name] = a.principals[c.Name]
+		}
+	}
+	order := make([]string, len(desired))
+	for i, c := range desired {
+		order[i] = c.Name
+	}
+	a.applied, a.principals, a.order = applied, principals, order
+	a.restored = false
+	return desired, compileErr
+}
+
+// unmetDependency returns the first dependency of p among present that is not
+// enforced, or ""
+func unmetDependency(p policy.NetworkPolicy, present, enforced map[string]bool) string {
+	for _, dep := range p.Metadata.DependsOn {
+		if present[dep] && !enforced[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+// withFailures adds per-policy failures to err, an *policy.ApplyError or nil
+func withFailures(err error, failures map[string]error) error {
+	if len(failures) == 0 {
+		return err
+	}
+	if err == nil {
+		return &policy.ApplyError{Failures: failures}
+	}
+	applyErr, ok := err.(*policy.ApplyError)
+	if !ok {
+		return errors.Join(err, &policy.ApplyError{Failures: failures})
+	}
+	for name, failure := range failures {
+		applyErr.Failures[name] = failure
+	}
+	return applyErr
+}
+
+// Principal returns the principal whose enforcement last changed the named
+// policy, or an empty string if the policy is not applied or the change was
+// not attributed
+func (a *Agent) Principal(name string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.principals[name]
+}
+
+// unchanged reports whether desired is exactly the applied set
+func unchanged(applied map[string]*policy.CompiledPolicy, desired []*policy.CompiledPolicy) bool {
+	if len(applied) != len(desired) {
+		return false
+	}
+	for _, c := range desired {
+		if previous, exists := applied[c.Name]; !exists || previous.Hash != c.Hash {
+			return false
+		}
+	}
+	return true
+}
+
+// Run reconciles the policies returned by load immediately and then every
+// interval until ctx is cancelled, and also as soon as a temporary policy or
+// break-glass expires, a maintenance window starts or ends, or Wake is called. Failures are logged and retried on the next cycle so a transient
+// error never stops enforcement; if they persist past the staleness
+// threshold (see SetStaleAfter), a policy_stale event is published.
+func (a *Agent) Run(ctx context.Context, load LoadFunc, interval time.Duration) {
+	a.mu.Lock()
+	clk := a.clock
+	a.mu.Unlock()
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		a.cycle(ctx, load)
+
+		var expiry <-chan time.Time
+		var timer clock.Timer
+		if d, ok := a.untilNextExpiry(); ok {
+			timer = clk.NewTimer(d)
+			expiry = timer.C()
+		}
+
+		select {
+		case <-ticker.C():
+		case <-expiry:
+		case <-a.wake:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (a *Agent) cycle(ctx context.Context, load LoadFunc) {
+	policies, err := load()
+	if err != nil {
+		a.logf("warn", "Failed to load policies: %v", err)
+		a.mu.Lock()
+		a.checkStale(err)
+		a.mu.Unlock()
+		return
+	}
+	compiled, err := a.Reconcile(ctx, policies)
+	if err != nil {
+		a.logf("warn", "Reconcile failed: %v", err)
+		a.mu.Lock()
+		a.checkStale(err)
+		a.mu.Unlock()
+		return
+	}
+	a.logf("debug", "Reconciled %d policies", len(compiled))
+
+	a.mu.Lock()
+	a.reconciledFresh()
+	reconciled, suspended := a.reconciled, a.suspended()
+	schedule := a.schedule(a.nodeID)
+	a.mu.Unlock()
+	if reconciled != nil && !suspended && !schedule.Cordoned && !schedule.Drained() {
+		reconciled()
+	}
+}
+
+// OnReconciled registers a callback invoked after every cycle of Run that
+// enforced the loaded policies in full, i.e. without errors, outside
+// break-glass and while the node is not cordoned (e.g. to record what is
+// applied)
+func (a *Agent) OnReconciled(fn func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.reconciled = fn
+}
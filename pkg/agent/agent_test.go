@@ -0,0 +1,340 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/policy"
+)
+
+const agentTestPolicies = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-dns
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.53/32
+      ports:
+        - protocol: UDP
+          port: 53
+`
+
+// stubDiscovery resolves app=<name> selectors from a map
+type stubDiscovery struct {
+	apps map[string][]string
+}
+
+func (s *stubDiscovery) ResolveLabels(labels map[string]string) ([]string, error) {
+	ips, ok := s.apps[labels["app"]]
+	if !ok {
+		return nil, fmt.Errorf("no services match %v", labels)
+	}
+	return ips, nil
+}
+
+// recorder is an EnforceFunc that records every enforced set
+type recorder struct {
+	mu         sync.Mutex
+	calls      [][]*policy.CompiledPolicy
+	principals []string
+	err        error
+}
+
+func (r *recorder) enforce(ctx context.Context, policies []*policy.CompiledPolicy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, policies)
+	r.principals = append(r.principals, auth.PrincipalFromContext(ctx))
+	return r.err
+}
+
+// count returns the number of enforced sets, safe to call while the agent runs
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.calls)
+}
+
+func newTestAgent(t *testing.T, disc *stubDiscovery) (*Agent, *recorder, []policy.NetworkPolicy) {
+	t.Helper()
+	policies, err := policy.Parse([]byte(agentTestPolicies))
+	if err != nil {
+		t.Fatalf("Failed to parse policies: %v", err)
+	}
+	rec := &recorder{}
+	return New(policy.NewCompileCache(policy.NewPolicyResolver(disc)), rec.enforce, 2), rec, policies
+}
+
+func TestReconcileSkipsUnchanged(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	compiled, err := a.Reconcile(ctx, policies)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(compiled) != 2 || len(rec.calls) != 1 {
+		t.Fatalf("Expected 2 policies enforced once, got %d policies, %d calls", len(compiled), len(rec.calls))
+	}
+
+	// Nothing changed: served from cache and not re-enforced
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(rec.calls) != 1 {
+		t.Errorf("Expected unchanged policies not to be re-enforced, got %d calls", len(rec.calls))
+	}
+	if stats := a.cache.Stats(); stats.Hits != 2 {
+		t.Errorf("Expected 2 cache hits, got %+v", stats)
+	}
+
+	// Endpoint churn re-enforces
+	disc.apps["db"] = []string{"10.0.2.1", "10.0.2.2"}
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(rec.calls) != 2 {
+		t.Fatalf("Expected endpoint change to re-enforce, got %d calls", len(rec.calls))
+	}
+	if rules := rec.calls[1][0].Rules; len(rules) != 2 {
+		t.Errorf("Expected 2 resolved rules, got %v", rules)
+	}
+
+	// Removing a policy re-enforces
+	if _, err := a.Reconcile(ctx, policies[1:]); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(rec.calls) != 3 || len(rec.calls[2]) != 1 {
+		t.Errorf("Expected removal to re-enforce one policy, got %v", rec.calls)
+	}
+}
+
+func TestReconcileKeepsPreviousOnCompileError(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	// Discovery loses the selector: the previous rules stay enforced
+	delete(disc.apps, "db")
+	compiled, err := a.Reconcile(ctx, policies)
+	if err == nil {
+		t.Fatal("Expected compile error")
+	}
+	if len(compiled) != 2 || len(compiled[0].Rules) != 1 {
+		t.Errorf("Expected previous rules to be kept, got %v", compiled)
+	}
+	if len(rec.calls) != 1 {
+		t.Errorf("Expected no re-enforcement, got %d calls", len(rec.calls))
+	}
+}
+
+func TestReconcileNodeSelector(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	policies[0].Spec.NodeSelector = map[string]string{"role": "app"}
+	ctx := context.Background()
+
+	// An unlabeled node only enforces policies without a nodeSelector
+	compiled, err := a.Reconcile(ctx, policies)
+	if err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(compiled) != 1 || compiled[0].Name != "web-to-dns" {
+		t.Errorf("Expected web-to-dns only, got %v", compiled)
+	}
+
+	a.SetNodeLabels(map[string]string{"role": "app", "zone": "a"})
+	if compiled, err = a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(compiled) != 2 || len(rec.calls) != 2 {
+		t.Errorf("Expected both policies enforced once labeled, got %v (%d calls)", compiled, len(rec.calls))
+	}
+}
+
+func TestReconcileRetriesFailedEnforcement(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	rec.err = errors.New("backend unavailable")
+	if _, err := a.Reconcile(ctx, policies); !errors.Is(err, rec.err) {
+		t.Fatalf("Expected enforcement error, got %v", err)
+	}
+
+	rec.err = nil
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if len(rec.calls) != 2 {
+		t.Errorf("Expected failed enforcement to be retried, got %d calls", len(rec.calls))
+	}
+}
+
+func TestRun(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+
+	reconciled := 0
+	a.OnReconciled(func() { reconciled++ })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	loads := 0
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx, func() ([]policy.NetworkPolicy, error) {
+			loads++
+			if loads == 3 {
+				cancel()
+			}
+			return policies, nil
+		}, time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop after cancel")
+	}
+	if loads < 3 || len(rec.calls) != 1 {
+		t.Errorf("Expected at least 3 cycles and 1 enforcement, got %d loads, %d calls", loads, len(rec.calls))
+	}
+	// The last cycle fails to compile once ctx is cancelled
+	if reconciled != loads-1 {
+		t.Errorf("Expected the successful cycles reported reconciled, got %d of %d", reconciled, loads)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	a, _, _ := newTestAgent(t, &stubDiscovery{})
+
+	a.ApplyConfig(cluster.ConfigEntry{Key: cluster.ConfigLogLevel, Value: "debug", Version: 1})
+	a.ApplyConfig(cluster.ConfigEntry{Key: "feature.ebpf-batch", Value: "true", Version: 2})
+	if level, _ := a.Config(cluster.ConfigLogLevel); level != "debug" {
+		t.Errorf("Expected log-level debug, got %q", level)
+	}
+	if !a.Feature("ebpf-batch") {
+		t.Error("Expected feature.ebpf-batch enabled")
+	}
+
+	a.ApplyConfig(cluster.ConfigEntry{Key: "feature.ebpf-batch", Version: 3, Deleted: true})
+	if a.Feature("ebpf-batch") {
+		t.Error("Expected feature.ebpf-batch disabled after unset")
+	}
+}
+
+func TestApplyConfigLive(t *testing.T) {
+	a, _, _ := newTestAgent(t, &stubDiscovery{})
+	store, _ := cluster.NewLocalConfigStore("")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cluster.ApplyConfig(ctx, store, a.ApplyConfig)
+	}()
+
+	store.Set(cluster.ConfigLogLevel, "error", "node-2")
+	deadline := time.After(time.Second)
+	for {
+		if level, _ := a.Config(cluster.ConfigLogLevel); level == "error" {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("cluster config not applied live")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	<-done
+}
+
+func TestReconcileAttributesPrincipal(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+
+	if _, err := a.Reconcile(auth.WithPrincipal(context.Background(), "alice"), policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if rec.principals[0] != "alice" || a.Principal("web-to-db") != "alice" || a.Principal("web-to-dns") != "alice" {
+		t.Fatalf("Expected alice to be recorded, got %v, %q", rec.principals, a.Principal("web-to-db"))
+	}
+
+	// Only the policy bob's enforcement changed is attributed to bob
+	disc.apps["db"] = []string{"10.0.2.1", "10.0.2.2"}
+	if _, err := a.Reconcile(auth.WithPrincipal(context.Background(), "bob"), policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if rec.principals[1] != "bob" || a.Principal("web-to-db") != "bob" {
+		t.Errorf("Expected bob for the changed policy, got %v, %q", rec.principals, a.Principal("web-to-db"))
+	}
+	if got := a.Principal("web-to-dns"); got != "alice" {
+		t.Errorf("Expected unchanged policy to stay attributed to alice, got %q", got)
+	}
+	if got := a.Principal("missing"); got != "" {
+		t.Errorf("Expected no principal for an unknown policy, got %q", got)
+	}
+}
+
+func TestReconcileDependencies(t *testing.T) {
+	ctx := context.Background()
+	disc := &stubDiscovery{apps: map[string][]string{"web": {"10.0.1.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	// web-to-dns waits for web-to-db, which cannot resolve its database
+	policies[1].Metadata.DependsOn = []string{"web-to-db"}
+
+	_, err := a.Reconcile(ctx, policies)
+	var applyErr *policy.ApplyError
+	if !errors.As(err, &applyErr) || applyErr.Failures["web-to-dns"] == nil {
+		t.Fatalf("Expected web-to-dns to report its unmet dependency, got %v", err)
+	}
+	if len(rec.calls) != 1 || len(rec.calls[0]) != 0 {
+		t.Fatalf("Expected nothing enforced, got %v", rec.calls)
+	}
+
+	disc.apps["db"] = []string{"10.0.2.1"}
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatal(err)
+	}
+	enforced := rec.calls[len(rec.calls)-1]
+	if len(enforced) != 2 || enforced[0].Name != "web-to-db" || enforced[1].Name != "web-to-dns" {
+		t.Errorf("Expected web-to-db enforced ahead of web-to-dns, got %v", enforced)
+	}
+}
@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/events"
+	"ztap/pkg/metrics"
+)
+
+// breakGlass is the window set by the break-glass cluster config key
+type breakGlass struct {
+	until time.Time
+	by    string // Who enabled it
+}
+
+// applyBreakGlass follows a change to the break-glass cluster config key and
+// reconciles right away, so enforcement is suspended or restored without
+// waiting for the next cycle
+func (a *Agent) applyBreakGlass(entry cluster.ConfigEntry) {
+	a.mu.Lock()
+	if entry.Deleted {
+		a.breakGlass = breakGlass{}
+	} else if until, err := time.Parse(time.RFC3339, entry.Value); err != nil {
+		a.logf("warn", "Ignoring invalid %s value %q: %v", cluster.ConfigBreakGlass, entry.Value, err)
+	} else {
+		a.breakGlass = breakGlass{until: until, by: entry.UpdatedBy}
+	}
+	a.mu.Unlock()
+	a.Wake()
+}
+
+// suspended reports whether break-glass suspends enforcement now (requires
+// holding mu)
+func (a *Agent) suspended() bool {
+	return a.now().Before(a.breakGlass.until)
+}
+
+// suspend removes every rule from the backend, attributed to whoever enabled
+// break-glass. The last enforced set is kept, so compile failures still fall
+// back to it and it is enforced again once break-glass ends (requires
+// holding mu).
+func (a *Agent) suspend(ctx context.Context) error {
+	if a.monitoring {
+		return nil
+	}
+	if err := a.enforce(auth.WithPrincipal(ctx, a.breakGlass.by), nil); err != nil {
+		return err
+	}
+	a.monitoring = true
+	metrics.GetCollector().SetBreakGlass(true)
+	a.logf("error", "BREAK-GLASS: enforcement suspended by %s until %s; ZTAP is not blocking any traffic",
+		a.breakGlass.by, a.breakGlass.until.Format(time.RFC3339))
+	return nil
+}
+
+// resumed records that desired was enforced again after break-glass
+// (requires holding mu)
+func (a *Agent) resumed() {
+	a.monitoring = false
+	metrics.GetCollector().SetBreakGlass(false)
+	a.logf("warn", "BREAK-GLASS: enforcement restored")
+
+	// A window that ran out, rather than one disabled by an admin, is only
+	// visible here
+	if !a.breakGlass.until.IsZero() {
+		events.Default().Publish(events.TopicBreakGlass, events.BreakGlass{
+			Action:    events.BreakGlassExpired,
+			Principal: a.breakGlass.by,
+			Until:     a.breakGlass.until,
+		})
+	}
+}
+
+// Wake makes Run reconcile now instead of at the next interval
+func (a *Agent) Wake() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ztap/pkg/cluster"
+	"ztap/pkg/events"
+	"ztap/pkg/policy"
+)
+
+func breakGlassEntry(until time.Time) cluster.ConfigEntry {
+	return cluster.ConfigEntry{Key: cluster.ConfigBreakGlass, Value: until.Format(time.RFC3339Nano), UpdatedBy: "alice", Version: 1}
+}
+
+func TestBreakGlassSuspendsEnforcement(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	now := time.Date(2025, 10, 31, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	restored := make(chan events.Event, 4)
+	stop := events.Default().SubscribeFunc(func(e events.Event) { restored <- e }, events.TopicBreakGlass)
+	defer stop()
+
+	a.Reconcile(ctx, policies)
+	a.ApplyConfig(breakGlassEntry(now.Add(30 * time.Minute)))
+
+	// Every rule is removed on behalf of the admin, once
+	a.Reconcile(ctx, policies)
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 2 || len(rec.calls[1]) != 0 || rec.principals[1] != "alice" {
+		t.Fatalf("Expected one empty enforcement by alice, got %d calls", len(rec.calls))
+	}
+	if d, ok := a.untilNextExpiry(); !ok || d != 30*time.Minute {
+		t.Errorf("Expected wake-up when break-glass ends in 30m, got %v, %v", d, ok)
+	}
+
+	// Enforcement is restored when the window ends
+	now = now.Add(30 * time.Minute)
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 3 || len(rec.calls[2]) != 2 {
+		t.Fatalf("Expected both policies enforced again, got %d calls", len(rec.calls))
+	}
+	select {
+	case e := <-restored:
+		data := e.Data.(events.BreakGlass)
+		if data.Action != events.BreakGlassExpired || data.Principal != "alice" {
+			t.Errorf("Unexpected break_glass event %+v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected break_glass event")
+	}
+}
+
+func TestBreakGlassDisable(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	a.ApplyConfig(breakGlassEntry(time.Now().Add(time.Hour)))
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 1 || len(rec.calls[0]) != 0 {
+		t.Fatalf("Expected nothing enforced during break-glass, got %d calls", len(rec.calls))
+	}
+
+	a.ApplyConfig(cluster.ConfigEntry{Key: cluster.ConfigBreakGlass, Version: 2, Deleted: true})
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 2 || len(rec.calls[1]) != 2 {
+		t.Errorf("Expected enforcement restored when disabled, got %d calls", len(rec.calls))
+	}
+}
+
+func TestRunWakesOnBreakGlass(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx, func() ([]policy.NetworkPolicy, error) { return policies, nil }, time.Hour)
+	waitCalls(t, rec, 1, 5*time.Second)
+
+	// Suspended right away, and restored when the window ends, long before
+	// the interval
+	a.ApplyConfig(breakGlassEntry(time.Now().Add(300 * time.Millisecond)))
+	waitCalls(t, rec, 3, 5*time.Second)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.calls[1]) != 0 || len(rec.calls[2]) != 2 {
+		t.Errorf("Expected suspend then restore, got %d then %d policies", len(rec.calls[1]), len(rec.calls[2]))
+	}
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"sort"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/policy"
+)
+
+// SetNodeID sets the ID of the node the agent runs on, so it follows the
+// node being cordoned or drained ('ztap cluster cordon', 'ztap cluster
+// drain'). Call it before Run.
+func (a *Agent) SetNodeID(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nodeID = id
+}
+
+// applyNodeSchedule follows a change to a cordon or drain cluster config key
+// and reconciles right away if it concerns this node
+func (a *Agent) applyNodeSchedule(entry cluster.ConfigEntry) {
+	a.mu.Lock()
+	nodeID := a.nodeID
+	a.mu.Unlock()
+
+	a.configMu.Lock()
+	before := a.schedules[nodeID]
+	node, ok := cluster.ApplyNodeSchedule(entry, a.schedules)
+	after := a.schedules[nodeID]
+	a.configMu.Unlock()
+	if !ok {
+		a.logf("warn", "Ignoring invalid %s value %q", entry.Key, entry.Value)
+		return
+	}
+	if nodeID == "" || node != nodeID || before == after {
+		return
+	}
+
+	switch {
+	case after.Drained():
+		a.logf("warn", "Node drained by %s (%s); policy changes are not enforced until it is uncordoned", after.By, after.Drain)
+	case after.Cordoned:
+		a.logf("warn", "Node cordoned by %s; policies new to this node are not enforced until it is uncordoned", after.By)
+	default:
+		a.logf("info", "Node uncordoned; enforcing every policy assigned to it")
+	}
+	a.Wake()
+}
+
+// schedule returns this node's cordon and drain state
+func (a *Agent) schedule(nodeID string) cluster.NodeSchedule {
+	a.configMu.RLock()
+	defer a.configMu.RUnlock()
+	return a.schedules[nodeID]
+}
+
+// cordoned filters compiled down to what a cordoned or drained node enforces
+// (requires holding mu): a cordoned node only updates the policies it already
+// enforces, and a node drained in keep mode keeps exactly its last enforced
+// rules
+func (a *Agent) cordoned(schedule cluster.NodeSchedule, desired []*policy.CompiledPolicy) []*policy.CompiledPolicy {
+	if schedule.Drain == cluster.DrainKeep {
+		kept := make([]*policy.CompiledPolicy, 0, len(a.applied))
+		for _, c := range a.applied {
+			kept = append(kept, c)
+		}
+		sort.Slice(kept, func(i, j int) bool { return kept[i].Name < kept[j].Name })
+		return kept
+	}
+
+	assigned := desired[:0:0]
+	for _, c := range desired {
+		if _, ok := a.applied[c.Name]; ok || a.applied == nil {
+			assigned = append(assigned, c)
+		}
+	}
+	return assigned
+}
+
+// drain removes every rule from the backend for a node drained in monitor
+// mode, attributed to whoever drained it. Like break-glass, the last enforced
+// set is kept and enforced again once the node is uncordoned (requires
+// holding mu).
+func (a *Agent) drain(ctx context.Context, schedule cluster.NodeSchedule) error {
+	if a.drained {
+		return nil
+	}
+	if err := a.enforce(auth.WithPrincipal(ctx, schedule.By), nil); err != nil {
+		return err
+	}
+	a.drained = true
+	a.logf("warn", "Node drained by %s; enforcement removed, traffic is only monitored", schedule.By)
+	return nil
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"ztap/pkg/cluster"
+)
+
+func scheduleEntry(key, value string, deleted bool) cluster.ConfigEntry {
+	return cluster.ConfigEntry{Key: key, Value: value, UpdatedBy: "carol", Version: 1, Deleted: deleted}
+}
+
+func TestCordonSkipsNewPolicies(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	a.SetNodeID("web-1")
+	ctx := context.Background()
+
+	a.Reconcile(ctx, policies[:1])
+	a.ApplyConfig(scheduleEntry(cluster.ConfigCordonPrefix+"db-1", "true", false))
+	a.ApplyConfig(scheduleEntry(cluster.ConfigCordonPrefix+"web-1", "true", false))
+
+	// The new policy is not enforced, changes to the enforced one are
+	disc.apps["db"] = []string{"10.0.2.1", "10.0.2.2"}
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 2 || len(rec.calls[1]) != 1 || len(rec.calls[1][0].Rules) != 2 {
+		t.Fatalf("Expected only web-to-db updated while cordoned, got %v", rec.calls)
+	}
+
+	a.ApplyConfig(scheduleEntry(cluster.ConfigCordonPrefix+"web-1", "", true))
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 3 || len(rec.calls[2]) != 2 {
+		t.Fatalf("Expected both policies enforced once uncordoned, got %v", rec.calls)
+	}
+}
+
+func TestDrainMonitorRemovesRules(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	a.SetNodeID("web-1")
+	ctx := context.Background()
+
+	a.Reconcile(ctx, policies)
+	a.ApplyConfig(scheduleEntry(cluster.ConfigDrainPrefix+"web-1", "monitor", false))
+	a.Reconcile(ctx, policies)
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 2 || len(rec.calls[1]) != 0 || rec.principals[1] != "carol" {
+		t.Fatalf("Expected one empty enforcement by carol, got %d calls", len(rec.calls))
+	}
+
+	a.ApplyConfig(scheduleEntry(cluster.ConfigDrainPrefix+"web-1", "", true))
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 3 || len(rec.calls[2]) != 2 {
+		t.Fatalf("Expected both policies enforced again once undrained, got %d calls", len(rec.calls))
+	}
+}
+
+func TestDrainKeepFreezesRules(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	a.SetNodeID("web-1")
+	ctx := context.Background()
+
+	// Started while drained: the backend is left as it is
+	a.ApplyConfig(scheduleEntry(cluster.ConfigDrainPrefix+"web-1", "keep", false))
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 0 {
+		t.Fatalf("Expected nothing enforced by an agent started on a drained node, got %v", rec.calls)
+	}
+
+	a.ApplyConfig(scheduleEntry(cluster.ConfigDrainPrefix+"web-1", "", true))
+	a.Reconcile(ctx, policies)
+	a.ApplyConfig(scheduleEntry(cluster.ConfigDrainPrefix+"web-1", "keep", false))
+
+	// Endpoint changes and removals are ignored
+	disc.apps["db"] = []string{"10.0.2.1", "10.0.2.2"}
+	a.Reconcile(ctx, policies[1:])
+	if len(rec.calls) != 1 {
+		t.Fatalf("Expected the last enforced rules kept while drained, got %d calls", len(rec.calls))
+	}
+}
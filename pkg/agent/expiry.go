@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"ztap/pkg/events"
+	"ztap/pkg/policy"
+)
+
+// expiredHitAlertInterval limits how often hits on one expired policy are
+// logged; every hit is still published
+const expiredHitAlertInterval = time.Minute
+
+// expiredPolicy is a temporary policy removed at expiry
+type expiredPolicy struct {
+	at        time.Time
+	compiled  *policy.CompiledPolicy // Last enforced version, nil if never enforced
+	principal string
+	alerted   time.Time // Last logged hit
+}
+
+// dropExpired returns the policies that have not expired. Policies expiring
+// now are recorded, logged and published once; a policy whose expiry is
+// extended is enforced again. It also returns the earliest upcoming expiry,
+// zero if none (requires holding mu).
+func (a *Agent) dropExpired(policies []policy.NetworkPolicy) ([]policy.NetworkPolicy, time.Time) {
+	now := a.now()
+	var next time.Time
+	active := make([]policy.NetworkPolicy, 0, len(policies))
+	for i := range policies {
+		p := &policies[i]
+		name := p.Metadata.Name
+
+		if p.Metadata.TTL != "" {
+			if _, started := a.ttlStart[name]; !started {
+				a.ttlStart[name] = now
+			}
+		}
+		expiresAt, temporary := p.Expiry(a.ttlStart[name])
+		if !temporary || now.Before(expiresAt) {
+			delete(a.expired, name)
+			if temporary && (next.IsZero() || expiresAt.Before(next)) {
+				next = expiresAt
+			}
+			active = append(active, *p)
+			continue
+		}
+
+		if _, done := a.expired[name]; !done {
+			a.expire(name, expiresAt)
+		}
+	}
+	return active, next
+}
+
+// expire records that the named policy expired at, before it is dropped
+// from the enforced set (requires holding mu)
+func (a *Agent) expire(name string, at time.Time) {
+	e := &expiredPolicy{at: at, compiled: a.applied[name], principal: a.principals[name]}
+	a.expired[name] = e
+
+	a.logf("warn", "Policy %s expired at %s; removing its rules", name, at.Format(time.RFC3339))
+	events.Default().Publish(events.TopicPolicyExpired, events.PolicyExpired{
+		Policy:    name,
+		ExpiredAt: at,
+		Principal: e.principal,
+	})
+}
+
+// isExpired reports whether p has expired, without recording anything. A
+// policy with a ttl not yet seen by Reconcile has not started (requires
+// holding mu).
+func (a *Agent) isExpired(p policy.NetworkPolicy) bool {
+	name := p.Metadata.Name
+	if _, expired := a.expired[name]; expired {
+		return true
+	}
+	start, started := a.ttlStart[name]
+	if p.Metadata.TTL != "" && !started {
+		return false
+	}
+	expiresAt, temporary := p.Expiry(start)
+	return temporary && !a.now().Before(expiresAt)
+}
+
+// forgetRemoved drops expiry state of policies no longer in the desired set,
+// so a policy re-added later starts a new ttl (requires holding mu)
+func (a *Agent) forgetRemoved(policies []policy.NetworkPolicy) {
+	present := make(map[string]bool, len(policies))
+	for _, p := range policies {
+		present[p.Metadata.Name] = true
+	}
+	for name := range a.ttlStart {
+		if !present[name] {
+			delete(a.ttlStart, name)
+		}
+	}
+	for name := range a.expired {
+		if !present[name] {
+			delete(a.expired, name)
+		}
+	}
+}
+
+// untilNextExpiry returns how long until the next temporary policy or
+// break-glass expires, or a maintenance window starts or ends
+func (a *Agent) untilNextExpiry() (time.Duration, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	next := a.nextExpiry
+	if a.monitoring && (next.IsZero() || a.breakGlass.until.Before(next)) {
+		next = a.breakGlass.until
+	}
+	if !a.nextWindow.IsZero() && (next.IsZero() || a.nextWindow.Before(next)) {
+		next = a.nextWindow
+	}
+	if next.IsZero() {
+		return 0, false
+	}
+	return next.Sub(a.now()), true
+}
+
+// CheckExpiredHit reports a blocked flow that an expired temporary policy
+// would have allowed: a sign the exception is still needed, or that
+// something still depends on it. It returns the expired policy's name, or an
+// empty string if no expired policy matches.
+func (a *Agent) CheckExpiredHit(sourceIP, destIP string, port int, protocol string) string {
+	ip := net.ParseIP(destIP)
+	if ip == nil {
+		return ""
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for name, e := range a.expired {
+		if e.compiled == nil || !allows(e.compiled.Rules, ip, port, protocol) {
+			continue
+		}
+
+		now := a.now()
+		if now.Sub(e.alerted) >= expiredHitAlertInterval {
+			e.alerted = now
+			a.logf("warn", "Blocked flow %s -> %s:%d/%s would have been allowed by policy %s, which expired at %s",
+				sourceIP, destIP, port, protocol, name, e.at.Format(time.RFC3339))
+		}
+		events.Default().Publish(events.TopicPolicyExpired, events.PolicyExpired{
+			Policy:    name,
+			ExpiredAt: e.at,
+			Principal: e.principal,
+			Hit:       true,
+			SourceIP:  sourceIP,
+			DestIP:    destIP,
+			Port:      port,
+			Protocol:  protocol,
+		})
+		return name
+	}
+	return ""
+}
+
+// allows reports whether any rule allows the destination
+func allows(rules []policy.Rule, ip net.IP, port int, protocol string) bool {
+	for _, rule := range rules {
+		if !rule.CoversPort(port) || !strings.EqualFold(rule.Protocol, protocol) {
+			continue
+		}
+		if _, cidr, err := net.ParseCIDR(rule.CIDR); err == nil && cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
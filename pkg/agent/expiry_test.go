@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ztap/pkg/clock"
+	"ztap/pkg/events"
+	"ztap/pkg/policy"
+)
+
+// expiring returns policies with web-to-dns made temporary
+func expiring(policies []policy.NetworkPolicy, expiresAt, ttl string) []policy.NetworkPolicy {
+	out := append([]policy.NetworkPolicy(nil), policies...)
+	out[1].Metadata.ExpiresAt = expiresAt
+	out[1].Metadata.TTL = ttl
+	return out
+}
+
+func TestReconcileRemovesExpiredPolicy(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	now := time.Date(2025, 10, 31, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	expired := make(chan events.Event, 4)
+	stop := events.Default().SubscribeFunc(func(e events.Event) { expired <- e }, events.TopicPolicyExpired)
+	defer stop()
+
+	policies = expiring(policies, "2025-10-31T16:00:00Z", "")
+	if compiled, _ := a.Reconcile(ctx, policies); len(compiled) != 2 {
+		t.Fatalf("Expected both policies before expiry, got %d", len(compiled))
+	}
+	if d, ok := a.untilNextExpiry(); !ok || d != 4*time.Hour {
+		t.Errorf("Expected next expiry in 4h, got %v, %v", d, ok)
+	}
+
+	now = now.Add(4 * time.Hour)
+	compiled, _ := a.Reconcile(ctx, policies)
+	if len(compiled) != 1 || compiled[0].Name != "web-to-db" || len(rec.calls) != 2 {
+		t.Fatalf("Expected web-to-dns removed at expiry, got %d policies, %d calls", len(compiled), len(rec.calls))
+	}
+	select {
+	case e := <-expired:
+		data := e.Data.(events.PolicyExpired)
+		if data.Policy != "web-to-dns" || data.Hit || !data.ExpiredAt.Equal(now) {
+			t.Errorf("Unexpected expiry event %+v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected policy_expired event")
+	}
+
+	// Expiry is reported once, and endpoint refreshes do not bring it back
+	a.Reconcile(ctx, policies)
+	if compiled, _ := a.ReconcileSubset(ctx, policies); len(compiled) != 1 {
+		t.Errorf("Expected expired policy to stay removed, got %d policies", len(compiled))
+	}
+	if len(expired) != 0 || len(rec.calls) != 2 {
+		t.Errorf("Expected no further events or enforcement, got %d events, %d calls", len(expired), len(rec.calls))
+	}
+
+	// Extending the expiry enforces the policy again
+	policies = expiring(policies, "2025-10-31T20:00:00Z", "")
+	if compiled, _ := a.Reconcile(ctx, policies); len(compiled) != 2 || len(rec.calls) != 3 {
+		t.Errorf("Expected extended policy to be enforced, got %d policies, %d calls", len(compiled), len(rec.calls))
+	}
+}
+
+func TestReconcileTTL(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, _, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	now := time.Unix(1000, 0)
+	a.now = func() time.Time { return now }
+	temporary := expiring(policies, "", "4h")
+
+	a.Reconcile(ctx, temporary)
+	now = now.Add(3 * time.Hour)
+	if compiled, _ := a.Reconcile(ctx, temporary); len(compiled) != 2 {
+		t.Fatalf("Expected policy active within its ttl, got %d policies", len(compiled))
+	}
+	now = now.Add(time.Hour)
+	if compiled, _ := a.Reconcile(ctx, temporary); len(compiled) != 1 {
+		t.Fatalf("Expected policy removed after its ttl, got %d policies", len(compiled))
+	}
+
+	// Removing and re-adding the policy starts a new ttl
+	a.Reconcile(ctx, policies[:1])
+	if compiled, _ := a.Reconcile(ctx, temporary); len(compiled) != 2 {
+		t.Errorf("Expected re-added policy to start a new ttl, got %d policies", len(compiled))
+	}
+}
+
+func TestCheckExpiredHit(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, _, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	now := time.Unix(1000, 0)
+	a.now = func() time.Time { return now }
+	policies = expiring(policies, "", "1h")
+	a.Reconcile(ctx, policies)
+
+	if name := a.CheckExpiredHit("10.0.1.1", "10.0.0.53", 53, "UDP"); name != "" {
+		t.Errorf("Expected no hit while the policy is active, got %s", name)
+	}
+
+	now = now.Add(time.Hour)
+	a.Reconcile(ctx, policies)
+
+	hits := make(chan events.Event, 4)
+	stop := events.Default().SubscribeFunc(func(e events.Event) { hits <- e }, events.TopicPolicyExpired)
+	defer stop()
+
+	if name := a.CheckExpiredHit("10.0.1.1", "10.0.0.53", 53, "udp"); name != "web-to-dns" {
+		t.Fatalf("Expected hit on web-to-dns, got %q", name)
+	}
+	select {
+	case e := <-hits:
+		data := e.Data.(events.PolicyExpired)
+		if !data.Hit || data.SourceIP != "10.0.1.1" || data.Port != 53 {
+			t.Errorf("Unexpected hit event %+v", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected hit event")
+	}
+
+	for _, flow := range []struct {
+		dest     string
+		port     int
+		protocol string
+	}{
+		{"10.0.0.54", 53, "UDP"},
+		{"10.0.0.53", 54, "UDP"},
+		{"10.0.0.53", 53, "TCP"},
+	} {
+		if name := a.CheckExpiredHit("10.0.1.1", flow.dest, flow.port, flow.protocol); name != "" {
+			t.Errorf("Expected no hit for %+v, got %s", flow, name)
+		}
+	}
+}
+
+func TestRunWakesAtExpiry(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	policies = expiring(policies, time.Now().Add(200*time.Millisecond).Format(time.RFC3339Nano), "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx, func() ([]policy.NetworkPolicy, error) { return policies, nil }, time.Hour)
+
+	// The second enforcement happens at expiry, long before the interval
+	waitCalls(t, rec, 2, 5*time.Second)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.calls[1]) != 1 {
+		t.Errorf("Expected expired policy removed, got %d policies", len(rec.calls[1]))
+	}
+}
+
+func TestRunSchedulesOnClock(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	clk := clock.NewFake(time.Unix(1000, 0))
+	a.SetClock(clk)
+	policies = expiring(policies, "", "90m")
+
+	loads := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx, func() ([]policy.NetworkPolicy, error) {
+			loads <- struct{}{}
+			return policies, nil
+		}, time.Hour)
+		close(done)
+	}()
+	waitLoad := func(what string) {
+		t.Helper()
+		select {
+		case <-loads:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a cycle %s", what)
+		}
+	}
+
+	waitLoad("at start")
+	clk.BlockUntil(2) // Interval ticker and expiry timer
+
+	clk.Advance(time.Hour)
+	waitLoad("after the interval")
+	if rec.count() != 1 {
+		t.Errorf("Expected unchanged policies not to be re-enforced, got %d calls", rec.count())
+	}
+
+	// The expiry timer wakes the agent 30 minutes before the next tick
+	clk.BlockUntil(2)
+	clk.Advance(30 * time.Minute)
+	waitLoad("at the expiry")
+	cancel()
+	<-done
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.calls) != 2 || len(rec.calls[1]) != 1 {
+		t.Errorf("Expected the expired policy to be removed, got %v", rec.calls)
+	}
+}
@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"time"
+
+	"ztap/pkg/events"
+	"ztap/pkg/metrics"
+	"ztap/pkg/policy"
+)
+
+// DefaultStaleAfter is how long the agent may enforce the same rule set
+// without reconciling before it alerts
+const DefaultStaleAfter = time.Hour
+
+// Restore records policies as enforced, e.g. the last-known-good rule set
+// saved at savedAt before a restart: until they compile again, policies keep
+// these rules, and the first cycle enforces them again even if nothing
+// changed. Call it before Run.
+func (a *Agent) Restore(policies []*policy.CompiledPolicy, savedAt time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.applied = make(map[string]*policy.CompiledPolicy, len(policies))
+	a.principals = make(map[string]string, len(policies))
+	a.order = make([]string, len(policies))
+	for i, c := range policies {
+		a.applied[c.Name] = c
+		a.order[i] = c.Name
+	}
+	a.restored = true
+	a.lastGood = savedAt
+}
+
+// Applied returns the enforced rule set, in apply order
+func (a *Agent) Applied() []*policy.CompiledPolicy {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	applied := make([]*policy.CompiledPolicy, len(a.order))
+	for i, name := range a.order {
+		applied[i] = a.applied[name]
+	}
+	return applied
+}
+
+// SetStaleAfter sets how long the agent may go without reconciling before
+// alerting that it enforces stale policy (0 disables the alert). Call it
+// before Run.
+func (a *Agent) SetStaleAfter(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.staleAfter = d
+}
+
+// LastGood returns when the enforced rule set was last compiled and enforced
+// in full, zero if it never was
+func (a *Agent) LastGood() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastGood
+}
+
+// reconciledFresh records a cycle that reconciled without errors, ending a
+// stale alert (requires holding mu)
+func (a *Agent) reconciledFresh() {
+	a.lastGood = a.now()
+	metrics.GetCollector().SetPolicyLastGood(a.lastGood)
+	if !a.stale {
+		return
+	}
+	a.stale = false
+	metrics.GetCollector().SetPolicyStale(false)
+	a.logf("info", "Policies reconciled again; no longer enforcing stale policy")
+	events.Default().Publish(events.TopicPolicyStale, events.PolicyStale{
+		Node:      a.nodeID,
+		LastGood:  a.lastGood,
+		Recovered: true,
+	})
+}
+
+// checkStale alerts once the enforced rule set has not been reconciled for
+// longer than the staleness threshold because of err (requires holding mu)
+func (a *Agent) checkStale(err error) {
+	if a.stale || a.staleAfter <= 0 || a.lastGood.IsZero() {
+		return
+	}
+	age := a.now().Sub(a.lastGood)
+	if age <= a.staleAfter {
+		return
+	}
+	a.stale = true
+	metrics.GetCollector().SetPolicyStale(true)
+	a.logf("warn", "Enforcing stale policy: rules last reconciled %s ago (%s): %v", age.Round(time.Second), a.lastGood.Format(time.RFC3339), err)
+	events.Default().Publish(events.TopicPolicyStale, events.PolicyStale{
+		Node:     a.nodeID,
+		LastGood: a.lastGood,
+		Error:    err.Error(),
+	})
+}
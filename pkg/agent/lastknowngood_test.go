@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ztap/pkg/clock"
+	"ztap/pkg/events"
+	"ztap/pkg/policy"
+)
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	disc := &stubDiscovery{apps: map[string][]string{"web": {"10.0.1.1"}, "db": {"10.0.2.1"}}}
+	previous, _, policies := newTestAgent(t, disc)
+	if _, err := previous.Reconcile(ctx, policies); err != nil {
+		t.Fatal(err)
+	}
+	lastKnownGood := previous.Applied()
+	hashes := make(map[string]string)
+	for _, c := range lastKnownGood {
+		hashes[c.Name] = c.Hash
+	}
+
+	// Restarted while discovery no longer resolves the database
+	delete(disc.apps, "db")
+	a, rec, _ := newTestAgent(t, disc)
+	a.Restore(lastKnownGood, time.Now().Add(-time.Minute))
+	if _, err := a.Reconcile(ctx, policies); err == nil {
+		t.Fatal("Expected the compile error to be returned")
+	}
+	if len(rec.calls) != 1 || len(rec.calls[0]) != 2 {
+		t.Fatalf("Expected the restored rules enforced again, got %v", rec.calls)
+	}
+	for _, c := range rec.calls[0] {
+		if c.Hash != hashes[c.Name] {
+			t.Errorf("Expected %s to keep its last-known-good rules, got %+v", c.Name, c)
+		}
+	}
+}
+
+func TestStalePolicy(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"web": {"10.0.1.1"}, "db": {"10.0.2.1"}}}
+	a, _, policies := newTestAgent(t, disc)
+	clk := clock.NewFake(time.Unix(1000, 0))
+	a.SetClock(clk)
+	a.SetStaleAfter(time.Hour)
+
+	seen := make(chan events.PolicyStale, 2)
+	stop := events.Default().SubscribeFunc(func(e events.Event) { seen <- e.Data.(events.PolicyStale) }, events.TopicPolicyStale)
+	defer stop()
+
+	ctx := context.Background()
+	load := func() ([]policy.NetworkPolicy, error) { return policies, nil }
+	fail := func() ([]policy.NetworkPolicy, error) { return nil, errors.New("registry unreachable") }
+	a.cycle(ctx, load)
+
+	clk.Advance(30 * time.Minute)
+	a.cycle(ctx, fail)
+	clk.Advance(time.Hour)
+	a.cycle(ctx, fail)
+	a.cycle(ctx, fail)
+	a.cycle(ctx, load)
+
+	for _, recovered := range []bool{false, true} {
+		select {
+		case e := <-seen:
+			if e.Recovered != recovered {
+				t.Errorf("Expected recovered=%v, got %+v", recovered, e)
+			}
+			if !recovered && !e.LastGood.Equal(time.Unix(1000, 0)) {
+				t.Errorf("Expected the alert to carry the last reconcile, got %s", e.LastGood)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a policy_stale event with recovered=%v", recovered)
+		}
+	}
+	select {
+	case e := <-seen:
+		t.Errorf("Expected one alert per stale period, got %+v", e)
+	default:
+	}
+}
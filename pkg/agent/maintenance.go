@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"strings"
+	"time"
+
+	"ztap/pkg/cluster"
+	"ztap/pkg/events"
+	"ztap/pkg/policy"
+)
+
+// applyMaintenance follows a change to a maintenance window cluster config
+// key and reconciles right away, so policies in scope are frozen or
+// released without waiting for the next cycle
+func (a *Agent) applyMaintenance(entry cluster.ConfigEntry) {
+	name := strings.TrimPrefix(entry.Key, cluster.ConfigMaintenancePrefix)
+	a.configMu.Lock()
+	if entry.Deleted {
+		delete(a.windows, name)
+	} else if w, err := cluster.ParseMaintenanceWindow(name, entry.Value); err != nil {
+		a.configMu.Unlock()
+		a.logf("warn", "Ignoring invalid %s value %q: %v", entry.Key, entry.Value, err)
+		return
+	} else {
+		w.By = entry.UpdatedBy
+		a.windows[name] = w
+	}
+	a.configMu.Unlock()
+	a.Wake()
+}
+
+// activeWindows returns the maintenance windows open now
+func (a *Agent) activeWindows() []cluster.MaintenanceWindow {
+	now := a.now()
+	a.configMu.RLock()
+	defer a.configMu.RUnlock()
+	var active []cluster.MaintenanceWindow
+	for _, w := range a.windows {
+		if w.Active(now) {
+			active = append(active, w)
+		}
+	}
+	return active
+}
+
+// Quiet reports whether an open maintenance window suppresses alerts about
+// a workload. labels returns the workload's labels; it is only called while
+// a scoped window is open.
+func (a *Agent) Quiet(labels func() map[string]string) bool {
+	active := a.activeWindows()
+	if len(active) == 0 {
+		return false
+	}
+	for _, w := range active {
+		if len(w.Selector) == 0 {
+			return true
+		}
+	}
+	workload := labels()
+	for _, w := range active {
+		if w.Covers(workload) {
+			return true
+		}
+	}
+	return false
+}
+
+// paused reports whether an open maintenance window covers the workloads p
+// selects, so its enforced rules are left as they are
+func paused(active []cluster.MaintenanceWindow, p policy.NetworkPolicy) bool {
+	for _, w := range active {
+		if w.Covers(p.Spec.PodSelector.MatchLabels) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateMaintenance logs and publishes the maintenance windows that started
+// or ended since the last reconcile, and returns the next window boundary,
+// zero if none (requires holding mu)
+func (a *Agent) updateMaintenance() time.Time {
+	now := a.now()
+	a.configMu.RLock()
+	windows := make(map[string]cluster.MaintenanceWindow, len(a.windows))
+	for name, w := range a.windows {
+		windows[name] = w
+	}
+	a.configMu.RUnlock()
+
+	// Overridden windows are recorded by whoever removed them
+	for name := range a.announced {
+		if _, ok := windows[name]; !ok {
+			delete(a.announced, name)
+			a.logf("warn", "Maintenance window %s was removed; resuming drift remediation and alerts", name)
+		}
+	}
+
+	var next time.Time
+	for name, w := range windows {
+		active := w.Active(now)
+		switch {
+		case active && !a.announced[name]:
+			a.announced[name] = true
+			a.logf("warn", "Maintenance window %s (%s) started; pausing drift remediation and alerts until %s",
+				name, describeScope(w), w.End.Format(time.RFC3339))
+			publishMaintenance(events.MaintenanceStarted, w)
+		case !active && a.announced[name]:
+			delete(a.announced, name)
+			a.logf("info", "Maintenance window %s ended; resuming drift remediation and alerts", name)
+			publishMaintenance(events.MaintenanceEnded, w)
+		}
+
+		boundary := w.End
+		if now.Before(w.Start) {
+			boundary = w.Start
+		}
+		if now.Before(boundary) && (next.IsZero() || boundary.Before(next)) {
+			next = boundary
+		}
+	}
+	return next
+}
+
+func describeScope(w cluster.MaintenanceWindow) string {
+	if scope := w.Scope(); scope != "" {
+		return scope
+	}
+	return "all workloads"
+}
+
+func publishMaintenance(action string, w cluster.MaintenanceWindow) {
+	events.Default().Publish(events.TopicMaintenance, events.Maintenance{
+		Name:      w.Name,
+		Action:    action,
+		Principal: w.By,
+		Start:     w.Start,
+		End:       w.End,
+		Selector:  w.Selector,
+	})
+}
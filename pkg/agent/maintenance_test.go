@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ztap/pkg/cluster"
+	"ztap/pkg/events"
+)
+
+func maintenanceEntry(name string, start, end time.Time, selector string) cluster.ConfigEntry {
+	w := cluster.MaintenanceWindow{Start: start, End: end}
+	value := w.Value()
+	if selector != "" {
+		value += " " + selector
+	}
+	return cluster.ConfigEntry{Key: cluster.ConfigMaintenancePrefix + name, Value: value, UpdatedBy: "bob", Version: 1}
+}
+
+func TestMaintenanceFreezesPoliciesInScope(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	now := time.Date(2025, 10, 31, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	seen := make(chan events.Event, 4)
+	stop := events.Default().SubscribeFunc(func(e events.Event) { seen <- e }, events.TopicMaintenance)
+	defer stop()
+
+	a.Reconcile(ctx, policies)
+	a.ApplyConfig(maintenanceEntry("db-move", now.Add(time.Hour), now.Add(2*time.Hour), "app=web"))
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if d, ok := a.untilNextExpiry(); !ok || d != time.Hour {
+		t.Errorf("Expected wake-up when the window starts in 1h, got %v, %v", d, ok)
+	}
+
+	// Endpoint changes are not enforced while the window is open
+	now = now.Add(time.Hour)
+	disc.apps["db"] = []string{"10.0.2.1", "10.0.2.2"}
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 1 {
+		t.Fatalf("Expected no re-enforcement during maintenance, got %d calls", len(rec.calls))
+	}
+	if !a.Quiet(func() map[string]string { return map[string]string{"app": "web"} }) {
+		t.Error("Expected alerts about app=web to be suppressed")
+	}
+	if a.Quiet(func() map[string]string { return map[string]string{"app": "db"} }) {
+		t.Error("Expected alerts about app=db to continue")
+	}
+
+	// Drift is remediated once the window ends
+	now = now.Add(time.Hour)
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 2 || len(rec.calls[1][0].Rules) != 2 {
+		t.Fatalf("Expected endpoint change enforced after maintenance, got %d calls", len(rec.calls))
+	}
+
+	for _, action := range []string{events.MaintenanceStarted, events.MaintenanceEnded} {
+		select {
+		case e := <-seen:
+			data := e.Data.(events.Maintenance)
+			if data.Action != action || data.Name != "db-move" || data.Principal != "bob" || data.Selector["app"] != "web" {
+				t.Errorf("Expected %s event, got %+v", action, data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected maintenance %s event", action)
+		}
+	}
+}
+
+func TestMaintenanceGlobalWindow(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	a.ApplyConfig(maintenanceEntry("freeze", time.Now().Add(-time.Minute), time.Now().Add(time.Hour), ""))
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 1 || len(rec.calls[0]) != 0 {
+		t.Fatalf("Expected new policies held back during maintenance, got %v", rec.calls)
+	}
+	if !a.Quiet(func() map[string]string { t.Fatal("labels needed for a global window"); return nil }) {
+		t.Error("Expected a global window to suppress every alert")
+	}
+
+	// Removing the window releases the policies at once
+	a.ApplyConfig(cluster.ConfigEntry{Key: cluster.ConfigMaintenancePrefix + "freeze", Version: 2, Deleted: true})
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 2 || len(rec.calls[1]) != 2 {
+		t.Errorf("Expected both policies enforced after the window was removed, got %d calls", len(rec.calls))
+	}
+	if a.Quiet(func() map[string]string { return nil }) {
+		t.Error("Expected alerts to resume")
+	}
+}
+
+func TestMaintenanceFreezesEndpointChanges(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	a.Reconcile(ctx, policies)
+	a.ApplyConfig(maintenanceEntry("db-move", time.Now().Add(-time.Minute), time.Now().Add(time.Hour), "app=web"))
+
+	disc.apps["db"] = []string{"10.0.2.1", "10.0.2.2"}
+	a.ReconcileSubset(ctx, policies[:1])
+	if len(rec.calls) != 1 {
+		t.Errorf("Expected endpoint change not to be enforced during maintenance, got %d calls", len(rec.calls))
+	}
+}
@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"ztap/pkg/policy"
+)
+
+// ControlEndpoint is a connection ZTAP's own management plane depends on,
+// such as the controller, a metrics push endpoint or the discovery cache
+type ControlEndpoint struct {
+	Name    string // What the connection is for, e.g. "controller"
+	Address string // URL, DSN or host:port
+}
+
+// ControlPort is a local port ZTAP serves its management plane on, such as
+// the metrics port
+type ControlPort struct {
+	Name string
+	Port int
+}
+
+// SelfProtection is the built-in policy keeping ZTAP's management plane
+// reachable under default deny. The agent enforces it alongside any user
+// policy; it is never enforced alone, as that would deny everything else.
+type SelfProtection struct {
+	egress  []policy.Rule
+	ingress []policy.Rule
+}
+
+// NewSelfProtection resolves endpoints to egress rules and ports to ingress
+// rules. Hostnames are resolved once, now: endpoints that fail to resolve
+// or have no port are returned as errors, and the others are protected.
+func NewSelfProtection(ctx context.Context, endpoints []ControlEndpoint, ports []ControlPort) (*SelfProtection, []error) {
+	var errs []error
+	sp := &SelfProtection{}
+	seen := make(map[policy.Rule]bool)
+	add := func(rules *[]policy.Rule, r policy.Rule) {
+		if !seen[r] {
+			seen[r] = true
+			*rules = append(*rules, r)
+		}
+	}
+
+	for _, endpoint := range endpoints {
+		host, port, err := splitAddress(endpoint.Address)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("self-protection: %s: %w", endpoint.Name, err))
+			continue
+		}
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("self-protection: %s: %w", endpoint.Name, err))
+			continue
+		}
+		for _, ip := range ips {
+			add(&sp.egress, policy.Rule{Policy: policy.SelfProtectionPolicy, CIDR: hostCIDR(ip), Protocol: "TCP", Port: port, Direction: policy.DirectionEgress})
+		}
+	}
+	for _, p := range ports {
+		for _, cidr := range []string{"0.0.0.0/0", "::/0"} {
+			add(&sp.ingress, policy.Rule{Policy: policy.SelfProtectionPolicy, CIDR: cidr, Protocol: "TCP", Port: p.Port, Direction: policy.DirectionIngress})
+		}
+	}
+	return sp, errs
+}
+
+// Rules returns the protected connections as rules
+func (sp *SelfProtection) Rules() []policy.Rule {
+	return append(append([]policy.Rule(nil), sp.egress...), sp.ingress...)
+}
+
+// protect returns desired preceded by the self-protection policy. Ingress
+// rules are only added when a desired policy already restricts ingress, as
+// the first ingress rule turns on ingress default deny.
+func (sp *SelfProtection) protect(desired []*policy.CompiledPolicy) []*policy.CompiledPolicy {
+	if sp == nil || len(desired) == 0 {
+		return desired
+	}
+	rules := append([]policy.Rule(nil), sp.egress...)
+	for _, c := range desired {
+		if restrictsIngress(c) {
+			rules = append(rules, sp.ingress...)
+			break
+		}
+	}
+	if len(rules) == 0 {
+		return desired
+	}
+
+	data, _ := json.Marshal(rules)
+	sum := sha256.Sum256(data)
+	protection := &policy.CompiledPolicy{Name: policy.SelfProtectionPolicy, Hash: hex.EncodeToString(sum[:]), Rules: rules}
+	return append([]*policy.CompiledPolicy{protection}, desired...)
+}
+
+func restrictsIngress(c *policy.CompiledPolicy) bool {
+	for _, r := range c.Rules {
+		if r.Ingress() {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSelfProtection sets the built-in policy enforced alongside user
+// policies (nil disables it). Call it before Run.
+func (a *Agent) SetSelfProtection(sp *SelfProtection) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.protection = sp
+}
+
+// splitAddress returns the host and port of a URL (e.g.
+// https://controller:8443, postgres://db/ztap), a DSN or host:port. URLs
+// without a port use their scheme's default.
+func splitAddress(address string) (string, int, error) {
+	host, port := address, ""
+	if u, err := url.Parse(address); err == nil && u.Host != "" {
+		host, port = u.Hostname(), u.Port()
+		if port == "" {
+			port = defaultPorts[strings.ToLower(u.Scheme)]
+		}
+	} else if h, p, err := net.SplitHostPort(address); err == nil {
+		host, port = h, p
+	}
+	number, err := strconv.Atoi(port)
+	if err != nil || number < 1 || number > 65535 {
+		return "", 0, fmt.Errorf("no port in %q", address)
+	}
+	return host, number, nil
+}
+
+// defaultPorts are the ports of URL schemes used by control endpoints
+var defaultPorts = map[string]string{
+	"http":       "80",
+	"https":      "443",
+	"grpc":       "50051",
+	"postgres":   "5432",
+	"postgresql": "5432",
+	"redis":      "6379",
+	"rediss":     "6379",
+}
+
+// hostCIDR returns the single-host CIDR of ip
+func hostCIDR(ip string) string {
+	if strings.Contains(ip, ":") {
+		return ip + "/128"
+	}
+	return ip + "/32"
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"ztap/pkg/policy"
+)
+
+func TestSelfProtection(t *testing.T) {
+	ctx := context.Background()
+	sp, errs := NewSelfProtection(ctx, []ControlEndpoint{
+		{Name: "controller", Address: "https://127.0.0.1:8443"},
+		{Name: "discovery cache", Address: "127.0.0.2:6379"},
+		{Name: "metrics push", Address: "http://[::1]/api/v1/write"},
+		{Name: "broken", Address: "127.0.0.3"},
+	}, []ControlPort{{Name: "metrics", Port: 9090}})
+	if len(errs) != 1 {
+		t.Errorf("Expected the endpoint without a port to fail, got %v", errs)
+	}
+
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	a.SetSelfProtection(sp)
+
+	// Nothing to protect against without user policies
+	if _, err := a.Reconcile(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.calls[0]) != 0 {
+		t.Fatalf("Expected self-protection not to be enforced alone, got %v", rec.calls[0])
+	}
+
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatal(err)
+	}
+	enforced := rec.calls[len(rec.calls)-1]
+	if enforced[0].Name != policy.SelfProtectionPolicy {
+		t.Fatalf("Expected self-protection first, got %s", enforced[0].Name)
+	}
+	want := map[string]int{"127.0.0.1/32": 8443, "127.0.0.2/32": 6379, "::1/128": 80}
+	for _, r := range enforced[0].Rules {
+		if r.Ingress() {
+			t.Errorf("Expected no ingress rule while no policy restricts ingress, got %+v", r)
+			continue
+		}
+		if want[r.CIDR] != r.Port {
+			t.Errorf("Unexpected rule %+v", r)
+		}
+		delete(want, r.CIDR)
+	}
+	if len(want) != 0 {
+		t.Errorf("Expected rules for %v", want)
+	}
+}
+
+func TestSelfProtectionIngress(t *testing.T) {
+	sp, _ := NewSelfProtection(context.Background(), nil, []ControlPort{{Name: "metrics", Port: 9090}})
+	restricted := &policy.CompiledPolicy{Name: "ssh-in", Rules: []policy.Rule{{CIDR: "10.0.0.0/8", Protocol: "TCP", Port: 22, Direction: policy.DirectionIngress}}}
+
+	protected := sp.protect([]*policy.CompiledPolicy{restricted})
+	if len(protected) != 2 || len(protected[0].Rules) != 2 || protected[0].Rules[0].Port != 9090 {
+		t.Errorf("Expected the metrics port allowed in under ingress default deny, got %+v", protected)
+	}
+}
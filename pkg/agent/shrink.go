@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"ztap/pkg/cluster"
+	"ztap/pkg/events"
+	"ztap/pkg/policy"
+)
+
+// ShrinkGuard protects against discovery glitches collapsing a policy's
+// rules. When a policy's resolved endpoints shrink by more than MaxShrink (a
+// fraction of the enforced count) in one step, its previous rules stay
+// enforced until the shrink persists for Grace or an operator confirms it.
+// A MaxShrink of zero disables the check; a Grace of zero waits for
+// confirmation.
+type ShrinkGuard struct {
+	MaxShrink float64
+	Grace     time.Duration
+}
+
+// DefaultShrinkGuard holds back a policy losing more than half its endpoints
+// for five minutes
+var DefaultShrinkGuard = ShrinkGuard{MaxShrink: 0.5, Grace: 5 * time.Minute}
+
+// SetShrinkGuard replaces the endpoint shrink check
+func (a *Agent) SetShrinkGuard(guard ShrinkGuard) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.guard = guard
+}
+
+// ConfirmShrink accepts the held endpoint shrink of the named policies on the
+// next reconcile. Policies not currently held are ignored, so a confirmation
+// never pre-approves a later shrink.
+func (a *Agent) ConfirmShrink(names ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if _, held := a.held[name]; held {
+			a.confirmed[name] = true
+			a.logf("info", "Endpoint shrink of policy %s confirmed", name)
+		}
+	}
+}
+
+// guardShrink returns the compiled policy to enforce for c: c itself, or the
+// previously enforced version while a large endpoint shrink is held back
+// (requires holding mu)
+func (a *Agent) guardShrink(c *policy.CompiledPolicy) *policy.CompiledPolicy {
+	previous := a.applied[c.Name]
+	if previous == nil || a.guard.MaxShrink <= 0 ||
+		float64(c.Endpoints) >= float64(previous.Endpoints)*(1-a.guard.MaxShrink) {
+		a.release(c.Name)
+		return c
+	}
+
+	now := a.now()
+	since, held := a.held[c.Name]
+	switch {
+	case a.confirmed[c.Name]:
+		a.logf("info", "Accepting confirmed endpoint shrink of policy %s (%d -> %d)", c.Name, previous.Endpoints, c.Endpoints)
+	case held && a.guard.Grace > 0 && now.Sub(since) >= a.guard.Grace:
+		a.logf("warn", "Accepting endpoint shrink of policy %s (%d -> %d) after %s", c.Name, previous.Endpoints, c.Endpoints, a.guard.Grace)
+	default:
+		if !held {
+			a.held[c.Name] = now
+			a.alertShrink(c, previous, now)
+		}
+		return previous
+	}
+
+	a.release(c.Name)
+	return c
+}
+
+// alertShrink reports a newly held policy
+func (a *Agent) alertShrink(c, previous *policy.CompiledPolicy, now time.Time) {
+	var until time.Time
+	release := "confirmed"
+	if a.guard.Grace > 0 {
+		until = now.Add(a.guard.Grace)
+		release = fmt.Sprintf("confirmed or %s elapses", a.guard.Grace)
+	}
+	a.logf("warn", "Policy %s resolved %d endpoint(s), down from %d; keeping previous rules until %s (ztap cluster config set %s %s)",
+		c.Name, c.Endpoints, previous.Endpoints, release, cluster.ConfigConfirmShrink, c.Name)
+	events.Default().Publish(events.TopicShrinkHeld, events.ShrinkHeld{
+		Policy:    c.Name,
+		Previous:  previous.Endpoints,
+		Resolved:  c.Endpoints,
+		HeldUntil: until,
+	})
+}
+
+// release clears the held state of a policy (requires holding mu)
+func (a *Agent) release(name string) {
+	delete(a.held, name)
+	delete(a.confirmed, name)
+}
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ztap/pkg/cluster"
+	"ztap/pkg/events"
+)
+
+// dbIPs returns n distinct endpoints for app=db
+func dbIPs(n int) []string {
+	ips := make([]string, n)
+	for i := range ips {
+		ips[i] = "10.0.2." + string(rune('1'+i))
+	}
+	return ips
+}
+
+func TestShrinkGuardHoldsLargeShrink(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": dbIPs(4)}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	now := time.Unix(1000, 0)
+	a.now = func() time.Time { return now }
+	a.SetShrinkGuard(ShrinkGuard{MaxShrink: 0.5, Grace: time.Minute})
+
+	alerts := make(chan events.Event, 4)
+	stop := events.Default().SubscribeFunc(func(e events.Event) { alerts <- e }, events.TopicShrinkHeld)
+	defer stop()
+
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	// Losing up to half is within the threshold
+	disc.apps["db"] = dbIPs(3)
+	compiled, _ := a.Reconcile(ctx, policies)
+	if compiled[0].Endpoints != 3 || len(rec.calls) != 2 {
+		t.Fatalf("Expected shrink to 3 endpoints to be enforced, got %d endpoints, %d calls", compiled[0].Endpoints, len(rec.calls))
+	}
+
+	// Collapsing to one endpoint is held
+	disc.apps["db"] = dbIPs(1)
+	compiled, _ = a.Reconcile(ctx, policies)
+	if compiled[0].Endpoints != 3 || len(rec.calls) != 2 {
+		t.Fatalf("Expected previous rules to be held, got %d endpoints, %d calls", compiled[0].Endpoints, len(rec.calls))
+	}
+	select {
+	case e := <-alerts:
+		held := e.Data.(events.ShrinkHeld)
+		if held.Policy != "web-to-db" || held.Previous != 3 || held.Resolved != 1 || !held.HeldUntil.Equal(now.Add(time.Minute)) {
+			t.Errorf("Unexpected alert %+v", held)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected shrink alert")
+	}
+
+	// Still held within the grace period, without alerting again
+	now = now.Add(30 * time.Second)
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 2 || len(alerts) != 0 {
+		t.Errorf("Expected shrink to stay held quietly, got %d calls, %d alerts", len(rec.calls), len(alerts))
+	}
+
+	// Accepted once the shrink persists for the grace period
+	now = now.Add(30 * time.Second)
+	compiled, _ = a.Reconcile(ctx, policies)
+	if compiled[0].Endpoints != 1 || len(rec.calls) != 3 {
+		t.Errorf("Expected shrink to be accepted after grace, got %d endpoints, %d calls", compiled[0].Endpoints, len(rec.calls))
+	}
+}
+
+func TestShrinkGuardConfirmation(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": dbIPs(4)}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+	a.SetShrinkGuard(ShrinkGuard{MaxShrink: 0.25})
+
+	// Confirming a policy that is not held does nothing
+	a.ConfirmShrink("web-to-db")
+
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	disc.apps["db"] = dbIPs(1)
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 1 {
+		t.Fatalf("Expected shrink to be held, got %d calls", len(rec.calls))
+	}
+
+	// Without a grace period only confirmation releases it, here through
+	// the cluster configuration
+	a.ApplyConfig(cluster.ConfigEntry{Key: cluster.ConfigConfirmShrink, Value: "other, web-to-db", Version: 1})
+	compiled, _ := a.Reconcile(ctx, policies)
+	if compiled[0].Endpoints != 1 || len(rec.calls) != 2 {
+		t.Errorf("Expected confirmed shrink to be enforced, got %d endpoints, %d calls", compiled[0].Endpoints, len(rec.calls))
+	}
+}
+
+func TestShrinkGuardDisabled(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": dbIPs(4)}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+	a.SetShrinkGuard(ShrinkGuard{})
+
+	a.Reconcile(ctx, policies)
+	disc.apps["db"] = dbIPs(1)
+	a.Reconcile(ctx, policies)
+	if len(rec.calls) != 2 {
+		t.Errorf("Expected shrink to be enforced with the guard disabled, got %d calls", len(rec.calls))
+	}
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FailMode decides how the agent starts while a dependency is unavailable
+type FailMode string
+
+const (
+	// FailOpen starts without the dependency, degraded until it recovers
+	FailOpen FailMode = "open"
+	// FailClosed holds enforcement of the loaded policies until the
+	// dependency is available, enforcing the last-known-good rule set
+	// meanwhile
+	FailClosed FailMode = "closed"
+)
+
+// Dependencies of the agent, in the order they are checked at startup
+const (
+	DependencyDiscovery  = "discovery"  // Discovery cache and cloud inventory
+	DependencyController = "controller" // API server managing the node
+	DependencyDetector   = "detector"   // Anomaly detection service
+)
+
+// Dependency is a service the agent needs at startup
+type Dependency struct {
+	Name  string // One of the Dependency* names
+	Check func(ctx context.Context) error
+}
+
+// StartupConfig is the startup section of config.yaml
+type StartupConfig struct {
+	Timeout    time.Duration `yaml:"timeout"` // Give up on fail-closed dependencies after this long (0 waits forever)
+	Retry      time.Duration `yaml:"retry"`   // How often unavailable dependencies are checked again
+	Discovery  FailMode      `yaml:"discovery"`
+	Controller FailMode      `yaml:"controller"`
+	Detector   FailMode      `yaml:"detector"`
+}
+
+// DefaultStartupConfig waits for discovery, as policies compiled without it
+// lose their allow rules, and starts without the controller and detector
+var DefaultStartupConfig = StartupConfig{
+	Retry:      5 * time.Second,
+	Discovery:  FailClosed,
+	Controller: FailOpen,
+	Detector:   FailOpen,
+}
+
+// Mode returns the fail mode of the named dependency
+func (c StartupConfig) Mode(name string) FailMode {
+	switch name {
+	case DependencyDiscovery:
+		return c.Discovery
+	case DependencyController:
+		return c.Controller
+	case DependencyDetector:
+		return c.Detector
+	}
+	return FailOpen
+}
+
+// Validate checks the configuration
+func (c StartupConfig) Validate() error {
+	if c.Timeout < 0 || c.Retry <= 0 {
+		return fmt.Errorf("startup.timeout must not be negative and startup.retry must be positive")
+	}
+	for _, name := range []string{DependencyDiscovery, DependencyController, DependencyDetector} {
+		if mode := c.Mode(name); mode != FailOpen && mode != FailClosed {
+			return fmt.Errorf("startup.%s must be open or closed, got %q", name, mode)
+		}
+	}
+	return nil
+}
+
+// startupFile is the part of config.yaml read by LoadStartupConfig
+type startupFile struct {
+	Startup StartupConfig `yaml:"startup"`
+}
+
+// LoadStartupConfig reads the startup section of the config.yaml at path.
+// Unset fields keep their DefaultStartupConfig value.
+func LoadStartupConfig(path string) (StartupConfig, error) {
+	file := startupFile{Startup: DefaultStartupConfig}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return file.Startup, nil
+	}
+	if err != nil {
+		return StartupConfig{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return StartupConfig{}, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if err := file.Startup.Validate(); err != nil {
+		return StartupConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return file.Startup, nil
+}
+
+// WaitForDependencies checks deps in order before the agent starts
+// enforcing. An unavailable fail-open dependency is logged and skipped.
+// While a fail-closed dependency is unavailable, fallback (if not nil) is
+// called once to enforce the last-known-good rule set, and the dependency is
+// checked again every config.Retry. An error is returned if it is still
+// unavailable after config.Timeout or ctx is cancelled.
+func WaitForDependencies(ctx context.Context, deps []Dependency, config StartupConfig, fallback func(context.Context) error) error {
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	for _, dep := range deps {
+		err := dep.Check(ctx)
+		if err == nil {
+			continue
+		}
+		if config.Mode(dep.Name) == FailOpen {
+			log.Printf("Warning: %s unavailable, starting without it: %v", dep.Name, err)
+			continue
+		}
+
+		log.Printf("Warning: %s unavailable, waiting for it before enforcing policies: %v", dep.Name, err)
+		if fallback != nil {
+			if ferr := fallback(ctx); ferr != nil {
+				log.Printf("Warning: Failed to enforce the last-known-good rule set: %v", ferr)
+			}
+			fallback = nil
+		}
+		for err != nil {
+			select {
+			case <-time.After(config.Retry):
+			case <-ctx.Done():
+				return fmt.Errorf("%s unavailable: %w", dep.Name, err)
+			}
+			err = dep.Check(ctx)
+		}
+		log.Printf("%s available", dep.Name)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWaitForDependencies(t *testing.T) {
+	var order []string
+	down := 2
+	deps := []Dependency{
+		{Name: DependencyDiscovery, Check: func(context.Context) error {
+			order = append(order, DependencyDiscovery)
+			if down > 0 {
+				down--
+				return errors.New("connection refused")
+			}
+			return nil
+		}},
+		{Name: DependencyDetector, Check: func(context.Context) error {
+			order = append(order, DependencyDetector)
+			return errors.New("connection refused")
+		}},
+	}
+	fallbacks := 0
+	fallback := func(context.Context) error {
+		fallbacks++
+		return nil
+	}
+
+	config := DefaultStartupConfig
+	config.Retry = time.Millisecond
+	if err := WaitForDependencies(context.Background(), deps, config, fallback); err != nil {
+		t.Fatal(err)
+	}
+	// Discovery fails closed and is retried until available; the detector
+	// fails open and is checked once
+	want := []string{DependencyDiscovery, DependencyDiscovery, DependencyDiscovery, DependencyDetector}
+	if len(order) != len(want) {
+		t.Fatalf("Expected checks %v, got %v", want, order)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("Expected checks %v, got %v", want, order)
+		}
+	}
+	if fallbacks != 1 {
+		t.Errorf("Expected the last-known-good rule set enforced once, got %d", fallbacks)
+	}
+}
+
+func TestWaitForDependenciesTimeout(t *testing.T) {
+	config := DefaultStartupConfig
+	config.Retry = time.Millisecond
+	config.Timeout = 20 * time.Millisecond
+	config.Controller = FailClosed
+
+	deps := []Dependency{{Name: DependencyController, Check: func(context.Context) error {
+		return errors.New("no route to host")
+	}}}
+	if err := WaitForDependencies(context.Background(), deps, config, nil); err == nil {
+		t.Error("Expected an error once the timeout passed")
+	}
+}
+
+func TestLoadStartupConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("startup:\n  timeout: 2m\n  detector: closed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadStartupConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Timeout != 2*time.Minute || config.Detector != FailClosed || config.Discovery != FailClosed || config.Retry != DefaultStartupConfig.Retry {
+		t.Errorf("Expected set fields over defaults, got %+v", config)
+	}
+
+	if err := os.WriteFile(path, []byte("startup:\n  controller: ignore\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadStartupConfig(path); err == nil {
+		t.Error("Expected an invalid fail mode to be rejected")
+	}
+}
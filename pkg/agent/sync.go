@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/policy"
+)
+
+// SetSealer makes HandleUpdate open every update with sealer, rejecting
+// updates that are unsealed or whose signature does not verify. Without one,
+// sealed updates are rejected. Call it before Follow.
+func (a *Agent) SetSealer(sealer cluster.PayloadSealer) {
+	a.syncMu.Lock()
+	defer a.syncMu.Unlock()
+	a.sealer = sealer
+}
+
+// HandleUpdate applies a policy update received from the cluster leader. A
+// sealed update is opened first (see SetSealer); its fencing token is then
+// checked: an update from a deposed leader (an older epoch, or a different
+// leader claiming the current epoch) is rejected with cluster.ErrStaleEpoch
+// and nothing is enforced. Updates at or below the last applied version of
+// the same policy are ignored, also once the policy is removed, so a replayed
+// update cannot restore it; an empty YAML removes the policy. An
+// update defining a policy another update already defines with different
+// content is rejected (see policy.Merge). An update declaring a schema
+// version or features this build does not understand is withheld: the
+// previously synced version, if any, stays enforced and Withheld reports
+// why. The full synced set is then reconciled on behalf of the update's
+// principal, if it has one.
+func (a *Agent) HandleUpdate(ctx context.Context, update cluster.PolicyUpdate) error {
+	a.syncMu.Lock()
+	sealer := a.sealer
+	a.syncMu.Unlock()
+	switch {
+	case sealer != nil:
+		opened, err := sealer.Open(update)
+		if err != nil {
+			return fmt.Errorf("rejected update for %s from %s: %w", update.PolicyName, update.Source, err)
+		}
+		update = opened
+	case update.Envelope != nil:
+		return fmt.Errorf("rejected update for %s from %s: sealed, but no sync keyring is configured", update.PolicyName, update.Source)
+	}
+
+	if err := a.fence.Admit(update.Token); err != nil {
+		return fmt.Errorf("rejected update for %s from %s: %w", update.PolicyName, update.Source, err)
+	}
+
+	a.syncMu.Lock()
+	if applied, ok := a.versions[update.PolicyName]; ok && update.Version <= applied {
+		a.syncMu.Unlock()
+		return nil
+	}
+	if len(update.YAML) > 0 {
+		err := policy.LocalPeer().Accepts(update.PolicyName, update.Schema, update.Features)
+		var incompatible *policy.IncompatibleError
+		if errors.As(err, &incompatible) {
+			a.withheld[update.PolicyName] = incompatible.Reason()
+			a.syncMu.Unlock()
+			return fmt.Errorf("withheld update for %s from %s: %w", update.PolicyName, update.Source, err)
+		}
+	}
+	delete(a.withheld, update.PolicyName)
+
+	if len(update.YAML) == 0 {
+		delete(a.synced, update.PolicyName)
+		a.versions[update.PolicyName] = update.Version
+	} else {
+		policies, err := policy.Parse(update.YAML)
+		if err != nil {
+			a.syncMu.Unlock()
+			return fmt.Errorf("invalid update for %s: %w", update.PolicyName, err)
+		}
+		previous, existed := a.synced[update.PolicyName]
+		a.synced[update.PolicyName] = policies
+		if _, err := a.mergeSynced(); err != nil {
+			if existed {
+				a.synced[update.PolicyName] = previous
+			} else {
+				delete(a.synced, update.PolicyName)
+			}
+			a.syncMu.Unlock()
+			return fmt.Errorf("rejected update for %s from %s: %w", update.PolicyName, update.Source, err)
+		}
+		a.versions[update.PolicyName] = update.Version
+	}
+	desired, _ := a.mergeSynced()
+	a.syncMu.Unlock()
+
+	if update.Principal != "" {
+		ctx = auth.WithPrincipal(ctx, update.Principal)
+	}
+	_, err := a.Reconcile(ctx, desired)
+	return err
+}
+
+// Withheld returns the synced policies whose latest update this agent does
+// not understand, with the reason, by policy name
+func (a *Agent) Withheld() map[string]string {
+	a.syncMu.Lock()
+	defer a.syncMu.Unlock()
+	withheld := make(map[string]string, len(a.withheld))
+	for name, reason := range a.withheld {
+		withheld[name] = reason
+	}
+	return withheld
+}
+
+// mergeSynced merges the synced set in name order (requires syncMu). A
+// policy named like one of another update is rejected unless identical or
+// annotated with policy.OverrideAnnotation.
+func (a *Agent) mergeSynced() ([]policy.NetworkPolicy, error) {
+	names := make([]string, 0, len(a.synced))
+	for name := range a.synced {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sources := make([]policy.Source, len(names))
+	for i, name := range names {
+		sources[i] = policy.Source{Name: "update " + name, Policies: a.synced[name]}
+	}
+	return policy.Merge(sources...)
+}
+
+// Follow applies policy updates from sync until ctx is cancelled. It is the
+// cluster counterpart of Run: an agent takes its policies from either a file
+// or the leader, not both. Rejected and failed updates are logged.
+func (a *Agent) Follow(ctx context.Context, sync cluster.PolicySync) {
+	for update := range sync.SubscribePolicies(ctx) {
+		if err := a.HandleUpdate(ctx, update); err != nil {
+			a.logf("warn", "%v", err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/policy"
+)
+
+const dnsPolicy = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-dns
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.53/32
+      ports:
+        - protocol: UDP
+          port: 53
+`
+
+const denyPolicy = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-dns
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+`
+
+func TestHandleUpdateRejectsStaleLeader(t *testing.T) {
+	a, rec, _ := newTestAgent(t, &stubDiscovery{})
+	ctx := context.Background()
+
+	oldLeader := cluster.FencingToken{Epoch: 1, LeaderID: "node-a"}
+	newLeader := cluster.FencingToken{Epoch: 2, LeaderID: "node-b"}
+
+	if err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(dnsPolicy), Version: 1, Token: oldLeader, Source: "node-a"}); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	// A partition elects node-b, which re-sends the policy
+	if err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(dnsPolicy), Version: 2, Token: newLeader, Source: "node-b"}); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+
+	// node-a still believes it leads and pushes a deny-all
+	err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(denyPolicy), Version: 3, Token: oldLeader, Source: "node-a"})
+	if !errors.Is(err, cluster.ErrStaleEpoch) {
+		t.Fatalf("Expected ErrStaleEpoch, got %v", err)
+	}
+
+	// A different leader claiming the current epoch is split brain
+	err = a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(denyPolicy), Version: 3, Token: cluster.FencingToken{Epoch: 2, LeaderID: "node-c"}})
+	if !errors.Is(err, cluster.ErrStaleEpoch) {
+		t.Fatalf("Expected ErrStaleEpoch for conflicting leader, got %v", err)
+	}
+
+	if len(rec.calls) != 1 {
+		t.Fatalf("Expected only the first update to be enforced, got %d calls", len(rec.calls))
+	}
+	if rules := rec.calls[0][0].Rules; len(rules) != 1 || rules[0].Port != 53 {
+		t.Errorf("Expected the allow rule to stay enforced, got %v", rules)
+	}
+}
+
+func TestHandleUpdateVersions(t *testing.T) {
+	a, rec, _ := newTestAgent(t, &stubDiscovery{})
+	ctx := context.Background()
+	token := cluster.FencingToken{Epoch: 1, LeaderID: "node-a"}
+
+	if err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(dnsPolicy), Version: 2, Token: token}); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	// Reordered delivery of an older version is ignored
+	if err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(denyPolicy), Version: 1, Token: token}); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	if len(rec.calls) != 1 {
+		t.Fatalf("Expected older version to be ignored, got %d calls", len(rec.calls))
+	}
+
+	// An empty YAML removes the policy
+	if err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "dns", Version: 3, Token: token}); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	if len(rec.calls) != 2 || len(rec.calls[1]) != 0 {
+		t.Errorf("Expected removal to enforce an empty set, got %v", rec.calls)
+	}
+
+	// A replayed update cannot bring the removed policy back
+	for _, version := range []int64{2, 3} {
+		if err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(dnsPolicy), Version: version, Token: token}); err != nil {
+			t.Fatalf("HandleUpdate failed: %v", err)
+		}
+	}
+	if len(rec.calls) != 2 {
+		t.Errorf("Expected replays of the removed policy ignored, got %v", rec.calls)
+	}
+	if err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(dnsPolicy), Version: 4, Token: token}); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	if len(rec.calls) != 3 || len(rec.calls[2]) != 1 {
+		t.Errorf("Expected a newer version to restore the policy, got %v", rec.calls)
+	}
+}
+
+// channelSync is a PolicySync delivering updates from a channel
+type channelSync struct {
+	updates chan cluster.PolicyUpdate
+}
+
+func (s *channelSync) SyncPolicy(ctx context.Context, policyName string, policyYAML []byte) error {
+	return nil
+}
+
+func (s *channelSync) GetPolicyVersion(policyName string) (int64, error) { return 0, nil }
+
+func (s *channelSync) SubscribePolicies(ctx context.Context) <-chan cluster.PolicyUpdate {
+	return s.updates
+}
+
+func TestFollow(t *testing.T) {
+	a, rec, _ := newTestAgent(t, &stubDiscovery{})
+	sync := &channelSync{updates: make(chan cluster.PolicyUpdate, 2)}
+
+	sync.updates <- cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(dnsPolicy), Version: 1, Token: cluster.FencingToken{Epoch: 2, LeaderID: "node-b"}}
+	sync.updates <- cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(denyPolicy), Version: 2, Token: cluster.FencingToken{Epoch: 1, LeaderID: "node-a"}}
+	close(sync.updates)
+
+	done := make(chan struct{})
+	go func() {
+		a.Follow(context.Background(), sync)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Follow did not return after the updates channel closed")
+	}
+
+	if len(rec.calls) != 1 || len(rec.calls[0][0].Rules) != 1 {
+		t.Errorf("Expected only the current leader's update to be enforced, got %v", rec.calls)
+	}
+}
+
+func TestHandleUpdateAttributesPrincipal(t *testing.T) {
+	a, rec, _ := newTestAgent(t, &stubDiscovery{})
+	update := cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(dnsPolicy), Version: 1, Token: cluster.FencingToken{Epoch: 1, LeaderID: "node-a"}, Principal: "alice"}
+
+	if err := a.HandleUpdate(auth.WithPrincipal(context.Background(), "system"), update); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	if rec.principals[0] != "alice" || a.Principal("web-to-dns") != "alice" {
+		t.Errorf("Expected the update's principal, got %v", rec.principals)
+	}
+}
+
+func TestHandleUpdateWithholdsUnknownFeatures(t *testing.T) {
+	a, rec, _ := newTestAgent(t, &stubDiscovery{})
+	ctx := context.Background()
+	token := cluster.FencingToken{Epoch: 1, LeaderID: "node-a"}
+
+	if err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(dnsPolicy), Version: 1, Token: token, Schema: 1}); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	// A newer leader sends a version using a feature this build lacks
+	update := cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(denyPolicy), Version: 2, Token: token, Schema: 1, Features: []string{"rate-limits"}}
+	if err := a.HandleUpdate(ctx, update); err == nil {
+		t.Fatal("Expected the update to be withheld")
+	}
+	if len(rec.calls) != 1 || a.Withheld()["dns"] != "requires unsupported features: rate-limits" {
+		t.Errorf("Expected the previous version kept and the update reported, got %d calls, %v", len(rec.calls), a.Withheld())
+	}
+
+	update.Version, update.Schema, update.Features = 3, 1, nil
+	if err := a.HandleUpdate(ctx, update); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	if len(rec.calls) != 2 || len(a.Withheld()) != 0 {
+		t.Errorf("Expected a compatible update enforced, got %d calls, %v", len(rec.calls), a.Withheld())
+	}
+}
+
+func TestHandleUpdateNameCollision(t *testing.T) {
+	a, rec, _ := newTestAgent(t, &stubDiscovery{})
+	ctx := context.Background()
+	token := cluster.FencingToken{Epoch: 1, LeaderID: "node-a"}
+
+	if err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(dnsPolicy), Version: 1, Token: token}); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	// Another document defines web-to-dns differently
+	var collision *policy.CollisionError
+	err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "legacy", YAML: []byte(denyPolicy), Version: 1, Token: token})
+	if !errors.As(err, &collision) || collision.First != "update dns" || collision.Second != "update legacy" {
+		t.Fatalf("Expected a collision naming both updates, got %v", err)
+	}
+	if len(rec.calls) != 1 {
+		t.Fatalf("Expected the colliding update not to be enforced, got %d calls", len(rec.calls))
+	}
+
+	// Unless it says it replaces it
+	override := strings.Replace(denyPolicy, "  name: web-to-dns\n", "  name: web-to-dns\n  annotations:\n    ztap.io/override: \"true\"\n", 1)
+	if err := a.HandleUpdate(ctx, cluster.PolicyUpdate{PolicyName: "legacy", YAML: []byte(override), Version: 1, Token: token}); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	if len(rec.calls) != 2 || len(rec.calls[1][0].Rules) != 0 {
+		t.Errorf("Expected the override to be enforced, got %v", rec.calls)
+	}
+}
+
+func TestHandleUpdateSealed(t *testing.T) {
+	a, rec, _ := newTestAgent(t, &stubDiscovery{})
+	ctx := context.Background()
+	token := cluster.FencingToken{Epoch: 1, LeaderID: "node-a"}
+
+	leader, err := cluster.NewSyncKeyring(time.Now())
+	if err != nil {
+		t.Fatalf("NewSyncKeyring failed: %v", err)
+	}
+	sealed, err := leader.Seal(cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(dnsPolicy), Version: 1, Token: token, Source: "node-a"})
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	// Without a keyring a sealed update is not mistaken for a removal
+	if err := a.HandleUpdate(ctx, sealed); err == nil || !strings.Contains(err.Error(), "no sync keyring") {
+		t.Fatalf("Expected a sealed update rejected without a keyring, got %v", err)
+	}
+
+	a.SetSealer(leader.VerifyOnly())
+	unsealed := cluster.PolicyUpdate{PolicyName: "dns", YAML: []byte(denyPolicy), Version: 2, Token: token, Source: "node-a"}
+	if err := a.HandleUpdate(ctx, unsealed); !errors.Is(err, cluster.ErrUnsealedUpdate) {
+		t.Fatalf("Expected ErrUnsealedUpdate, got %v", err)
+	}
+	if err := a.HandleUpdate(ctx, sealed); err != nil {
+		t.Fatalf("HandleUpdate failed: %v", err)
+	}
+	if len(rec.calls) != 1 || len(rec.calls[0][0].Rules) != 1 {
+		t.Fatalf("Expected the sealed policy enforced, got %v", rec.calls)
+	}
+}
@@ -0,0 +1,267 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"ztap/pkg/policy"
+)
+
+// WatchFunc subscribes to the endpoints matching a label selector, e.g. the
+// Watch method of a discovery backend. The channel receives the full set of
+// matching IPs on every change and is closed when ctx is cancelled.
+type WatchFunc func(ctx context.Context, labels map[string]string) (<-chan []string, error)
+
+// Debounce bounds how endpoint churn is batched into re-enforcement. After a
+// change the agent waits until no further change arrives for Quiet, but never
+// longer than MaxDelay after the first pending change.
+type Debounce struct {
+	Quiet    time.Duration
+	MaxDelay time.Duration
+}
+
+// DefaultDebounce suits autoscaling groups adding or removing several
+// instances within seconds
+var DefaultDebounce = Debounce{Quiet: 2 * time.Second, MaxDelay: 30 * time.Second}
+
+// selectorWatch is the subscription for one distinct selector
+type selectorWatch struct {
+	cancel   context.CancelFunc
+	policies map[string]bool // Policies using the selector
+}
+
+// selectorChange reports that the endpoints of a selector changed
+type selectorChange struct {
+	key string
+	ips []string
+}
+
+// WatchEndpoints re-enforces policies when the endpoints behind their label
+// selectors (or those of their endpoint groups) change, instead of waiting
+// for the next Run cycle. Changes are debounced and batched per policy; only
+// the affected policies are recompiled. Subscriptions follow the selectors of
+// the policies returned by load, reloaded every interval and passed through
+// the mutator like in Reconcile. It returns when ctx is cancelled.
+func (a *Agent) WatchEndpoints(ctx context.Context, load LoadFunc, watch WatchFunc, interval time.Duration, debounce Debounce) {
+	load = a.withMutator(ctx, load)
+	changes := make(chan selectorChange)
+	watches := make(map[string]*selectorWatch)
+	defer func() {
+		for _, w := range watches {
+			w.cancel()
+		}
+	}()
+
+	resubscribe := func() {
+		policies, err := load()
+		if err != nil {
+			a.logf("warn", "Failed to load policies: %v", err)
+			return
+		}
+		a.subscribe(ctx, policies, watch, watches, changes)
+	}
+	resubscribe()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pending := make(map[string]bool)
+	var quiet, deadline <-chan time.Time
+	for {
+		select {
+		case change := <-changes:
+			w, ok := watches[change.key]
+			if !ok {
+				continue
+			}
+			a.logf("debug", "Endpoints for %s changed (%d IPs)", change.key, len(change.ips))
+			for name := range w.policies {
+				pending[name] = true
+			}
+			quiet = time.After(debounce.Quiet)
+			if deadline == nil {
+				deadline = time.After(debounce.MaxDelay)
+			}
+			continue
+		case <-quiet:
+		case <-deadline:
+		case <-ticker.C:
+			resubscribe()
+			continue
+		case <-ctx.Done():
+			return
+		}
+
+		// Quiet period elapsed or maximum delay reached
+		quiet, deadline = nil, nil
+		names := pending
+		pending = make(map[string]bool)
+		a.refresh(ctx, load, names)
+	}
+}
+
+// subscribe starts a watch for every selector of policies not yet watched,
+// including ingress sources and the selectors of the endpoint groups they
+// reference, stops watches no policy uses any more and updates which policies use each
+func (a *Agent) subscribe(ctx context.Context, policies []policy.NetworkPolicy, watch WatchFunc, watches map[string]*selectorWatch, changes chan<- selectorChange) {
+	used := make(map[string]map[string]bool)
+	selectors := make(map[string]map[string]string)
+	for _, p := range policies {
+		var peers []map[string]string
+		for _, egress := range p.Spec.Egress {
+			peers = append(peers, egress.To.PodSelector.MatchLabels)
+			if g, ok := p.Groups[egress.To.Group]; ok {
+				peers = append(peers, g.Selectors...)
+			}
+		}
+		for _, ingress := range p.Spec.Ingress {
+			peers = append(peers, ingress.From.PodSelector.MatchLabels)
+		}
+		for _, labels := range peers {
+			if len(labels) == 0 {
+				continue
+			}
+			key := selectorKey(labels)
+			if used[key] == nil {
+				used[key] = make(map[string]bool)
+				selectors[key] = labels
+			}
+			used[key][p.Metadata.Name] = true
+		}
+	}
+
+	for key, w := range watches {
+		if _, ok := used[key]; !ok {
+			w.cancel()
+			delete(watches, key)
+		}
+	}
+
+	for key, names := range used {
+		if w, ok := watches[key]; ok {
+			w.policies = names
+			continue
+		}
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		updates, err := watch(watchCtx, selectors[key])
+		if err != nil {
+			// Backends without watch support are still picked up by Run;
+			// logged at debug level since this repeats every interval
+			cancel()
+			a.logf("debug", "Failed to watch endpoints for %s: %v", key, err)
+			continue
+		}
+		watches[key] = &selectorWatch{cancel: cancel, policies: names}
+		go forwardChanges(watchCtx, key, updates, changes)
+	}
+}
+
+// forwardChanges relays a selector's updates, skipping the initial state
+// since the policies using it were compiled against it already
+func forwardChanges(ctx context.Context, key string, updates <-chan []string, changes chan<- selectorChange) {
+	initial := true
+	for ips := range updates {
+		if initial {
+			initial = false
+			continue
+		}
+		select {
+		case changes <- selectorChange{key: key, ips: ips}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refresh recompiles the named policies and enforces the result, keeping
+// every other policy as last enforced
+func (a *Agent) refresh(ctx context.Context, load LoadFunc, names map[string]bool) {
+	policies, err := load()
+	if err != nil {
+		a.logf("warn", "Failed to load policies: %v", err)
+		return
+	}
+
+	var changed []policy.NetworkPolicy
+	for _, p := range policies {
+		if names[p.Metadata.Name] {
+			changed = append(changed, p)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	if _, err := a.ReconcileSubset(ctx, changed); err != nil {
+		a.logf("warn", "Re-enforcement after endpoint change failed: %v", err)
+	}
+}
+
+// ReconcileSubset recompiles only policies and enforces them together with
+// the rest of the last enforced set. Before anything has been enforced there
+// is no rest to keep, so nothing is done until the first Reconcile. Policies
+// an open maintenance window covers keep their enforced rules.
+func (a *Agent) ReconcileSubset(ctx context.Context, policies []policy.NetworkPolicy) ([]*policy.CompiledPolicy, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.applied == nil {
+		return nil, nil
+	}
+	// Expired policies stay removed until Reconcile sees them extended
+	var active []policy.NetworkPolicy
+	for _, p := range a.selectingNode(policies) {
+		if !a.isExpired(p) {
+			active = append(active, p)
+		}
+	}
+	policies = active
+
+	compiled, compileErr := a.cache.CompileAll(ctx, policies, a.concurrency)
+
+	merged := make(map[string]*policy.CompiledPolicy, len(a.applied))
+	for name, c := range a.applied {
+		merged[name] = c
+	}
+	windows := a.activeWindows()
+	for i, c := range compiled {
+		if c == nil || paused(windows, policies[i]) {
+			continue
+		}
+		c = a.guardShrink(c)
+		if previous := a.applied[c.Name]; previous != nil && previous.Hash != c.Hash {
+			added, removed := policy.DiffRules(previous.Rules, c.Rules)
+			a.logf("info", "Endpoints changed for policy %s: %d rule(s) added, %d removed", c.Name, len(added), len(removed))
+		}
+		merged[c.Name] = c
+	}
+
+	// Keep the apply order of the enforced set; policies new to it follow
+	var added []string
+	for name := range merged {
+		if _, ok := a.applied[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	desired := make([]*policy.CompiledPolicy, 0, len(merged))
+	for _, name := range append(append([]string(nil), a.order...), added...) {
+		desired = append(desired, merged[name])
+	}
+
+	return a.apply(ctx, desired, compileErr)
+}
+
+// selectorKey is a canonical string for a label selector
+func selectorKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"ztap/pkg/policy"
+)
+
+// fakeWatch is a WatchFunc whose updates are sent by the test
+type fakeWatch struct {
+	mu    sync.Mutex
+	chans map[string]chan []string
+}
+
+func (f *fakeWatch) watch(ctx context.Context, labels map[string]string) (<-chan []string, error) {
+	ch := make(chan []string, 1)
+	ch <- nil // Initial state
+	f.mu.Lock()
+	f.chans[selectorKey(labels)] = ch
+	f.mu.Unlock()
+	return ch, nil
+}
+
+// channel waits for the subscription to key
+func (f *fakeWatch) channel(t *testing.T, key string) chan []string {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		f.mu.Lock()
+		ch, ok := f.chans[key]
+		f.mu.Unlock()
+		if ok {
+			return ch
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("No watch on %s", key)
+	return nil
+}
+
+// waitCalls waits until rec has seen n enforcements
+func waitCalls(t *testing.T, rec *recorder, n int, within time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for time.Now().Before(deadline) {
+		if rec.count() >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d enforcements within %s, got %d", n, within, rec.count())
+}
+
+func startWatch(t *testing.T, a *Agent, policies []policy.NetworkPolicy, debounce Debounce) *fakeWatch {
+	t.Helper()
+	fw := &fakeWatch{chans: make(map[string]chan []string)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.WatchEndpoints(ctx, func() ([]policy.NetworkPolicy, error) { return policies, nil }, fw.watch, time.Hour, debounce)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return fw
+}
+
+func TestWatchEndpointsDebounces(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	if _, err := a.Reconcile(context.Background(), policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	fw := startWatch(t, a, policies, Debounce{Quiet: 50 * time.Millisecond, MaxDelay: time.Second})
+	ch := fw.channel(t, "app=db")
+
+	// A burst of scale-out events is enforced once
+	disc.apps["db"] = []string{"10.0.2.1", "10.0.2.2", "10.0.2.3"}
+	for i := 0; i < 3; i++ {
+		ch <- disc.apps["db"]
+	}
+	waitCalls(t, rec, 2, time.Second)
+	time.Sleep(100 * time.Millisecond)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.calls) != 2 {
+		t.Fatalf("Expected the burst to be enforced once, got %d calls", len(rec.calls))
+	}
+	var rules int
+	for _, c := range rec.calls[1] {
+		rules += len(c.Rules)
+	}
+	if len(rec.calls[1]) != 2 || rules != 4 {
+		t.Errorf("Expected both policies with 4 rules, got %d policies, %d rules", len(rec.calls[1]), rules)
+	}
+}
+
+func TestWatchEndpointsMaxDelay(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	if _, err := a.Reconcile(context.Background(), policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	fw := startWatch(t, a, policies, Debounce{Quiet: 200 * time.Millisecond, MaxDelay: 100 * time.Millisecond})
+	ch := fw.channel(t, "app=db")
+
+	// Continuous churn never goes quiet, but is still enforced by MaxDelay
+	disc.apps["db"] = []string{"10.0.2.1", "10.0.2.2"}
+	stop := time.After(400 * time.Millisecond)
+	for churning := true; churning; {
+		select {
+		case ch <- disc.apps["db"]:
+			time.Sleep(20 * time.Millisecond)
+		case <-stop:
+			churning = false
+		}
+		if rec.count() >= 2 {
+			break
+		}
+	}
+	waitCalls(t, rec, 2, 50*time.Millisecond)
+}
+
+func TestWatchEndpointsGroupSelectors(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"cache": {"10.0.3.1"}}}
+	policies, err := policy.Parse([]byte(`apiVersion: ztap/v1
+kind: EndpointGroup
+metadata:
+  name: backends
+spec:
+  cidrs: [10.9.0.0/16]
+  selectors:
+    - matchLabels:
+        app: cache
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-backends
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        group: backends
+      ports:
+        - protocol: TCP
+          port: 6379
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse policies: %v", err)
+	}
+	rec := &recorder{}
+	a := New(policy.NewCompileCache(policy.NewPolicyResolver(disc)), rec.enforce, 2)
+	if _, err := a.Reconcile(context.Background(), policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	// The group's selector is watched like a podSelector
+	fw := startWatch(t, a, policies, Debounce{Quiet: 10 * time.Millisecond, MaxDelay: time.Second})
+	ch := fw.channel(t, "app=cache")
+	disc.apps["cache"] = []string{"10.0.3.1", "10.0.3.2"}
+	ch <- disc.apps["cache"]
+	waitCalls(t, rec, 2, time.Second)
+}
+
+func TestReconcileSubset(t *testing.T) {
+	disc := &stubDiscovery{apps: map[string][]string{"db": {"10.0.2.1"}}}
+	a, rec, policies := newTestAgent(t, disc)
+	ctx := context.Background()
+
+	// Nothing to keep before the first full reconcile
+	if compiled, err := a.ReconcileSubset(ctx, policies[:1]); err != nil || compiled != nil || len(rec.calls) != 0 {
+		t.Fatalf("Expected no enforcement before Reconcile, got %v, %v", compiled, err)
+	}
+
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	disc.apps["db"] = []string{"10.0.2.1", "10.0.2.2"}
+	compiled, err := a.ReconcileSubset(ctx, policies[:1])
+	if err != nil {
+		t.Fatalf("ReconcileSubset failed: %v", err)
+	}
+	if len(compiled) != 2 || len(rec.calls) != 2 {
+		t.Fatalf("Expected both policies enforced, got %d policies, %d calls", len(compiled), len(rec.calls))
+	}
+
+	// An unchanged subset is not re-enforced
+	if _, err := a.ReconcileSubset(ctx, policies[:1]); err != nil || len(rec.calls) != 2 {
+		t.Errorf("Expected no re-enforcement, got %d calls, %v", len(rec.calls), err)
+	}
+}
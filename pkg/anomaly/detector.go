@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"ztap/pkg/events"
+	"ztap/pkg/faults"
 )
 
 // FlowRecord represents a network flow for anomaly detection
@@ -18,13 +21,17 @@ type FlowRecord struct {
 	Timestamp time.Time `json:"timestamp"`
 	SourceGeo string    `json:"source_geo,omitempty"`
 	DestGeo   string    `json:"dest_geo,omitempty"`
+	// Services that held the IPs, see discovery.Attributor
+	SourceService string `json:"source_service,omitempty"`
+	DestService   string `json:"dest_service,omitempty"`
 }
 
 // AnomalyScore represents the detection result
 type AnomalyScore struct {
-	Score     float64 `json:"score"`      // 0-100
-	IsAnomaly bool    `json:"is_anomaly"` // True if score > threshold
-	Reason    string  `json:"reason"`     // Human-readable explanation
+	Score     float64 `json:"score"`           // 0-100
+	IsAnomaly bool    `json:"is_anomaly"`      // True if score > threshold
+	Reason    string  `json:"reason"`          // Human-readable explanation
+	Scope     string  `json:"scope,omitempty"` // Name of the Scope that scored the flow
 }
 
 // Detector interface for anomaly detection
@@ -51,6 +58,9 @@ func NewPythonDetector(endpoint string) *PythonDetector {
 
 // Detect sends a flow to the Python service for anomaly detection
 func (d *PythonDetector) Detect(flow FlowRecord) (*AnomalyScore, error) {
+	if err := faults.Inject(faults.Detect); err != nil {
+		return nil, err
+	}
 	data, err := json.Marshal(flow)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal flow: %w", err)
@@ -165,3 +175,39 @@ func (d *SimpleDetector) Detect(flow FlowRecord) (*AnomalyScore, error) {
 func (d *SimpleDetector) Train(flows []FlowRecord) error {
 	return nil
 }
+
+// eventDetector publishes anomalous detections to an event bus
+type eventDetector struct {
+	Detector
+	bus *events.Bus
+}
+
+// WithEvents wraps a detector so every anomalous flow is published to bus as
+// an AnomalyDetected event
+func WithEvents(d Detector, bus *events.Bus) Detector {
+	return &eventDetector{Detector: d, bus: bus}
+}
+
+// Detect runs the wrapped detector and publishes anomalies
+func (d *eventDetector) Detect(flow FlowRecord) (*AnomalyScore, error) {
+	score, err := d.Detector.Detect(flow)
+	if err != nil {
+		return nil, err
+	}
+
+	if score.IsAnomaly {
+		d.bus.Publish(events.TopicAnomalyDetected, events.AnomalyDetected{
+			ID:            AlertID(flow),
+			SourceIP:      flow.SourceIP,
+			SourceService: flow.SourceService,
+			DestIP:        flow.DestIP,
+			DestService:   flow.DestService,
+			Port:          flow.Port,
+			Protocol:      flow.Protocol,
+			Score:         score.Score,
+			Reason:        score.Reason,
+			Scope:         score.Scope,
+		})
+	}
+	return score, nil
+}
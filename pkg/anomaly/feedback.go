@@ -0,0 +1,170 @@
+package anomaly
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertID identifies the alerts raised for a flow. Every alert on the same
+// source, destination, port and protocol gets the same ID, so a label given
+// to one applies to the repeats.
+func AlertID(flow FlowRecord) string {
+	key := fmt.Sprintf("%s|%s|%d|%s", flow.SourceIP, flow.DestIP, flow.Port, strings.ToUpper(flow.Protocol))
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Feedback is an operator's label for an alert
+type Feedback struct {
+	ID        string    `json:"id"`
+	SourceIP  string    `json:"source_ip"`
+	DestIP    string    `json:"dest_ip"`
+	Port      int       `json:"port"`
+	Protocol  string    `json:"protocol"`
+	Score     float64   `json:"score"`  // Score of the labeled alert
+	Benign    bool      `json:"benign"` // False confirms the anomaly
+	Principal string    `json:"principal"`
+	LabeledAt time.Time `json:"labeled_at"`
+}
+
+// Flow returns the labeled flow
+func (f Feedback) Flow() FlowRecord {
+	return FlowRecord{SourceIP: f.SourceIP, DestIP: f.DestIP, Port: f.Port, Protocol: f.Protocol, Timestamp: f.LabeledAt}
+}
+
+// FeedbackStore keeps alert labels in a JSON file, re-read on every access
+// so labels given by 'ztap anomaly ack' reach a running agent
+type FeedbackStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFeedbackStore creates a label store backed by path
+func NewFeedbackStore(path string) *FeedbackStore {
+	return &FeedbackStore{path: path}
+}
+
+// Label records f, replacing an earlier label of the same alert
+func (s *FeedbackStore) Label(f Feedback) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	labels, err := s.read()
+	if err != nil {
+		return err
+	}
+	labels[f.ID] = f
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create feedback directory: %w", err)
+	}
+	data, err := json.MarshalIndent(labels, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+// List returns every label, oldest first
+func (s *FeedbackStore) List() ([]Feedback, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	labels, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Feedback, 0, len(labels))
+	for _, f := range labels {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LabeledAt.Before(list[j].LabeledAt) })
+	return list, nil
+}
+
+// BenignFlows returns the flows labeled benign, as training samples of
+// normal traffic
+func (s *FeedbackStore) BenignFlows() ([]FlowRecord, error) {
+	list, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	var flows []FlowRecord
+	for _, f := range list {
+		if f.Benign {
+			flows = append(flows, f.Flow())
+		}
+	}
+	return flows, nil
+}
+
+// read loads the labels by alert ID (requires mu)
+func (s *FeedbackStore) read() (map[string]Feedback, error) {
+	labels := make(map[string]Feedback)
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return labels, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	return labels, nil
+}
+
+// feedbackDetector applies operator labels to a detector's results
+type feedbackDetector struct {
+	Detector
+	store *FeedbackStore
+}
+
+// WithFeedback wraps a detector so alerts labeled benign stop repeating: a
+// flow labeled benign is only anomalous again if it scores higher than the
+// labeled alert, which raises the detector's threshold for that flow alone.
+// Benign flows are also added to every Train call as normal traffic, so
+// ML models learn them.
+func WithFeedback(d Detector, store *FeedbackStore) Detector {
+	return &feedbackDetector{Detector: d, store: store}
+}
+
+// Detect runs the wrapped detector and clears anomalies labeled benign
+func (d *feedbackDetector) Detect(flow FlowRecord) (*AnomalyScore, error) {
+	score, err := d.Detector.Detect(flow)
+	if err != nil || !score.IsAnomaly {
+		return score, err
+	}
+
+	// Labels are only read for anomalies, which are rare
+	d.store.mu.Lock()
+	labels, err := d.store.read()
+	d.store.mu.Unlock()
+	if err != nil {
+		log.Printf("Warning: Failed to read anomaly feedback: %v", err)
+		return score, nil
+	}
+	if label, ok := labels[AlertID(flow)]; ok && label.Benign && score.Score <= label.Score {
+		score.IsAnomaly = false
+		score.Reason = "labeled benign: " + score.Reason
+	}
+	return score, nil
+}
+
+// Train trains the wrapped detector on flows and the flows labeled benign
+func (d *feedbackDetector) Train(flows []FlowRecord) error {
+	benign, err := d.store.BenignFlows()
+	if err != nil {
+		return err
+	}
+	return d.Detector.Train(append(flows, benign...))
+}
@@ -0,0 +1,83 @@
+package anomaly
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordingDetector scores flows to port 22 at 60 and records training data
+type recordingDetector struct {
+	trained []FlowRecord
+}
+
+func (d *recordingDetector) Detect(flow FlowRecord) (*AnomalyScore, error) {
+	if flow.Port == 22 {
+		return &AnomalyScore{Score: 60, IsAnomaly: true, Reason: "ssh"}, nil
+	}
+	return &AnomalyScore{Score: 10, Reason: "normal traffic"}, nil
+}
+
+func (d *recordingDetector) Train(flows []FlowRecord) error {
+	d.trained = flows
+	return nil
+}
+
+func TestWithFeedback(t *testing.T) {
+	store := NewFeedbackStore(filepath.Join(t.TempDir(), "feedback.json"))
+	inner := &recordingDetector{}
+	d := WithFeedback(inner, store)
+
+	bastion := FlowRecord{SourceIP: "10.0.0.5", DestIP: "10.0.1.1", Port: 22, Protocol: "tcp"}
+	if score, _ := d.Detect(bastion); !score.IsAnomaly {
+		t.Fatal("Expected an anomaly before any feedback")
+	}
+
+	err := store.Label(Feedback{
+		ID: AlertID(bastion), SourceIP: bastion.SourceIP, DestIP: bastion.DestIP, Port: 22, Protocol: "TCP",
+		Score: 60, Benign: true, Principal: "alice", LabeledAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Label failed: %v", err)
+	}
+
+	score, _ := d.Detect(bastion)
+	if score.IsAnomaly || score.Reason != "labeled benign: ssh" {
+		t.Errorf("Expected the labeled flow to be benign, got %+v", score)
+	}
+	other := bastion
+	other.SourceIP = "10.0.0.6"
+	if score, _ := d.Detect(other); !score.IsAnomaly {
+		t.Error("Expected other flows to port 22 still anomalous")
+	}
+
+	if err := d.Train([]FlowRecord{{Port: 443}}); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	if len(inner.trained) != 2 || inner.trained[1].SourceIP != "10.0.0.5" {
+		t.Errorf("Expected the benign flow added to training data, got %+v", inner.trained)
+	}
+}
+
+func TestWithFeedbackHigherScore(t *testing.T) {
+	store := NewFeedbackStore(filepath.Join(t.TempDir(), "feedback.json"))
+	flow := FlowRecord{SourceIP: "10.0.0.5", DestIP: "10.0.1.1", Port: 22, Protocol: "TCP"}
+	store.Label(Feedback{ID: AlertID(flow), Score: 40, Benign: true})
+
+	// Scoring higher than the labeled alert raises it again
+	if score, _ := WithFeedback(&recordingDetector{}, store).Detect(flow); !score.IsAnomaly {
+		t.Error("Expected a higher score than the labeled alert to be anomalous")
+	}
+}
+
+func TestAlertID(t *testing.T) {
+	a := FlowRecord{SourceIP: "10.0.0.5", DestIP: "10.0.1.1", Port: 22, Protocol: "tcp", Bytes: 10}
+	b := FlowRecord{SourceIP: "10.0.0.5", DestIP: "10.0.1.1", Port: 22, Protocol: "TCP", Bytes: 99}
+	if AlertID(a) != AlertID(b) || len(AlertID(a)) != 12 {
+		t.Errorf("Expected the same 12 character ID, got %s and %s", AlertID(a), AlertID(b))
+	}
+	b.Port = 2222
+	if AlertID(a) == AlertID(b) {
+		t.Error("Expected different flows to get different IDs")
+	}
+}
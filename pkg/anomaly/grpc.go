@@ -0,0 +1,342 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ztap/pkg/faults"
+)
+
+// grpcService is the full name of the Detection service in detection.proto
+const grpcService = "ztap.anomaly.v1.Detection"
+
+// maxDetectBatch bounds the flows sent in one DetectRequest
+const maxDetectBatch = 256
+
+// gRPC status codes returned by detection services that ZTAP tells apart
+const (
+	CodeDeadlineExceeded = 4
+	CodeUnimplemented    = 12
+	CodeUnavailable      = 14
+)
+
+// StatusError is a non-OK gRPC status returned by a detection service
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("detection service returned gRPC status %d: %s", e.Code, e.Message)
+}
+
+// Health is the state reported by a detection service
+type Health struct {
+	Serving      bool
+	ModelTrained bool
+}
+
+// GRPCDetector calls a detection service implementing the Detection service
+// of detection.proto. It speaks the gRPC wire protocol over net/http's HTTP/2
+// support, so no gRPC runtime is needed. The caller's context deadline is
+// sent as grpc-timeout, so the service can give up when ZTAP does.
+type GRPCDetector struct {
+	base    string // http(s)://host:port that calls are POSTed under
+	client  *http.Client
+	timeout time.Duration // For Detect and Train, which take no context
+}
+
+// NewGRPCDetector creates a client for the detection service at endpoint,
+// grpc://host:port for plaintext HTTP/2 or grpcs://host:port for TLS
+func NewGRPCDetector(endpoint string) (*GRPCDetector, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid detection endpoint %q: %w", endpoint, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid detection endpoint %q: missing host", endpoint)
+	}
+
+	var protocols http.Protocols
+	transport := &http.Transport{}
+	switch u.Scheme {
+	case "grpc":
+		protocols.SetUnencryptedHTTP2(true)
+		u.Scheme = "http"
+	case "grpcs":
+		protocols.SetHTTP2(true)
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		u.Scheme = "https"
+	default:
+		return nil, fmt.Errorf("invalid detection endpoint %q: scheme must be grpc or grpcs", endpoint)
+	}
+	transport.Protocols = &protocols
+
+	return &GRPCDetector{
+		base:    u.Scheme + "://" + u.Host,
+		client:  &http.Client{Transport: transport},
+		timeout: 5 * time.Second,
+	}, nil
+}
+
+// Detect scores one flow
+func (d *GRPCDetector) Detect(flow FlowRecord) (*AnomalyScore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	scores, err := d.DetectBatch(ctx, []FlowRecord{flow})
+	if err != nil {
+		return nil, err
+	}
+	return scores[0], nil
+}
+
+// DetectBatch scores flows in one Detect stream, sending them in batches of
+// up to maxDetectBatch. Scores are returned in the order of flows.
+func (d *GRPCDetector) DetectBatch(ctx context.Context, flows []FlowRecord) ([]*AnomalyScore, error) {
+	if err := faults.Inject(faults.Detect); err != nil {
+		return nil, err
+	}
+	var body []byte
+	for batch := range slices.Chunk(flows, maxDetectBatch) {
+		body = appendGRPCFrame(body, encodeFlows(batch))
+	}
+
+	messages, err := d.call(ctx, "Detect", body)
+	if err != nil {
+		return nil, err
+	}
+	var scores []*AnomalyScore
+	for _, m := range messages {
+		batch, err := decodeDetectResponse(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		scores = append(scores, batch...)
+	}
+	if len(scores) != len(flows) {
+		return nil, fmt.Errorf("detection service returned %d scores for %d flows", len(scores), len(flows))
+	}
+	return scores, nil
+}
+
+// Train sends training data to the detection service
+func (d *GRPCDetector) Train(flows []FlowRecord) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	_, err := d.TrainContext(ctx, flows)
+	return err
+}
+
+// TrainContext sends training data to the detection service and returns
+// the number of samples the model was fitted to
+func (d *GRPCDetector) TrainContext(ctx context.Context, flows []FlowRecord) (int, error) {
+	messages, err := d.call(ctx, "Train", appendGRPCFrame(nil, encodeFlows(flows)))
+	if err != nil {
+		return 0, err
+	}
+	if len(messages) != 1 {
+		return 0, fmt.Errorf("detection service returned %d responses to Train", len(messages))
+	}
+	return decodeTrainResponse(messages[0])
+}
+
+// Health checks the detection service
+func (d *GRPCDetector) Health(ctx context.Context) (Health, error) {
+	messages, err := d.call(ctx, "Health", appendGRPCFrame(nil, nil))
+	if err != nil {
+		return Health{}, err
+	}
+	if len(messages) != 1 {
+		return Health{}, fmt.Errorf("detection service returned %d responses to Health", len(messages))
+	}
+	return decodeHealthResponse(messages[0])
+}
+
+// call invokes method with the framed request messages in body and returns
+// the response messages
+func (d *GRPCDetector) call(ctx context.Context, method string, body []byte) ([][]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.base+"/"+grpcService+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("TE", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", grpcTimeout(time.Until(deadline)))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, &StatusError{Code: CodeDeadlineExceeded, Message: err.Error()}
+		}
+		return nil, &StatusError{Code: CodeUnavailable, Message: err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("detection service returned HTTP status %d", resp.StatusCode)
+	}
+
+	// A failing call may answer with headers only
+	if err := grpcStatus(resp.Header); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &StatusError{Code: CodeUnavailable, Message: err.Error()}
+	}
+	if err := grpcStatus(resp.Trailer); err != nil {
+		return nil, err
+	}
+	if resp.Header.Get("Grpc-Status") == "" && resp.Trailer.Get("Grpc-Status") == "" {
+		return nil, fmt.Errorf("detection service sent no gRPC status")
+	}
+	return splitGRPCFrames(data)
+}
+
+// grpcStatus returns the error for the status in h, or nil if it is OK or
+// not set
+func grpcStatus(h http.Header) error {
+	status := h.Get("Grpc-Status")
+	if status == "" || status == "0" {
+		return nil
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return fmt.Errorf("detection service returned invalid gRPC status %q", status)
+	}
+	message, err := url.PathUnescape(h.Get("Grpc-Message"))
+	if err != nil {
+		message = h.Get("Grpc-Message")
+	}
+	return &StatusError{Code: code, Message: message}
+}
+
+// grpcTimeout formats d as a grpc-timeout header: at most 8 digits and a unit
+func grpcTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "1n"
+	}
+	units := []struct {
+		suffix string
+		size   time.Duration
+	}{
+		{"n", time.Nanosecond},
+		{"u", time.Microsecond},
+		{"m", time.Millisecond},
+		{"S", time.Second},
+		{"M", time.Minute},
+		{"H", time.Hour},
+	}
+	for _, u := range units {
+		// Round up, as gRPC implementations do
+		if n := (d + u.size - 1) / u.size; n < 1e8 {
+			return strconv.FormatInt(int64(n), 10) + u.suffix
+		}
+	}
+	return "99999999H"
+}
+
+// appendGRPCFrame appends a length-prefixed, uncompressed message
+func appendGRPCFrame(b, message []byte) []byte {
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(message)))
+	return append(b, message...)
+}
+
+func splitGRPCFrames(data []byte) ([][]byte, error) {
+	var messages [][]byte
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, fmt.Errorf("truncated gRPC message")
+		}
+		if data[0] != 0 {
+			return nil, fmt.Errorf("compressed gRPC messages are not supported")
+		}
+		n := binary.BigEndian.Uint32(data[1:5])
+		if uint32(len(data)-5) < n {
+			return nil, fmt.Errorf("truncated gRPC message")
+		}
+		messages = append(messages, data[5:5+n])
+		data = data[5+n:]
+	}
+	return messages, nil
+}
+
+// fallbackDetector uses a second detector while the first cannot be reached
+type fallbackDetector struct {
+	primary, fallback Detector
+}
+
+// WithFallback returns a detector that uses primary and falls back to
+// fallback for calls primary fails with CodeUnavailable or CodeUnimplemented,
+// e.g. a gRPC service with the HTTP service of the same model
+func WithFallback(primary, fallback Detector) Detector {
+	return &fallbackDetector{primary: primary, fallback: fallback}
+}
+
+// Detect scores flow with the primary detector, or the fallback
+func (d *fallbackDetector) Detect(flow FlowRecord) (*AnomalyScore, error) {
+	score, err := d.primary.Detect(flow)
+	if !unreachable(err) {
+		return score, err
+	}
+	log.Printf("Warning: %v; using fallback detector", err)
+	return d.fallback.Detect(flow)
+}
+
+// Train trains the primary detector, or the fallback
+func (d *fallbackDetector) Train(flows []FlowRecord) error {
+	err := d.primary.Train(flows)
+	if !unreachable(err) {
+		return err
+	}
+	log.Printf("Warning: %v; using fallback detector", err)
+	return d.fallback.Train(flows)
+}
+
+// unreachable reports whether err means the service could not handle the
+// call at all, rather than rejected it
+func unreachable(err error) bool {
+	var status *StatusError
+	if !errors.As(err, &status) {
+		return false
+	}
+	return status.Code == CodeUnavailable || status.Code == CodeUnimplemented
+}
+
+// NewDetector returns the detector for endpoint: a GRPCDetector for grpc://
+// and grpcs:// URLs, a PythonDetector for http:// and https:// URLs, and the
+// rule-based detector if endpoint is empty. A gRPC detector falls back to the
+// HTTP service at fallback, if given, while it is unavailable.
+func NewDetector(endpoint, fallback string) (Detector, error) {
+	switch {
+	case endpoint == "":
+		return NewSimpleDetector(), nil
+	case strings.HasPrefix(endpoint, "grpc://"), strings.HasPrefix(endpoint, "grpcs://"):
+		d, err := NewGRPCDetector(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		if fallback != "" {
+			return WithFallback(d, NewPythonDetector(fallback)), nil
+		}
+		return d, nil
+	case fallback != "":
+		return nil, fmt.Errorf("a fallback detector requires a grpc:// or grpcs:// endpoint")
+	default:
+		return NewPythonDetector(endpoint), nil
+	}
+}
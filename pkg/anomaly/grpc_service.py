@@ -0,0 +1,66 @@
+#!/usr/bin/env python3
+"""
+ZTAP Anomaly Detection gRPC Service
+Serves the Detection service of detection.proto with the model of service.py
+
+Generate the stubs first:
+    python3 -m grpc_tools.protoc -I. --python_out=. --grpc_python_out=. detection.proto
+"""
+
+from concurrent import futures
+
+import grpc
+
+import detection_pb2
+import detection_pb2_grpc
+import service
+
+
+def flow_dict(flow):
+    """Convert a Flow message to the flow records service.py expects"""
+    record = {
+        'source_ip': flow.source_ip,
+        'dest_ip': flow.dest_ip,
+        'port': flow.port,
+        'protocol': flow.protocol,
+        'bytes': flow.bytes,
+        'source_geo': flow.source_geo,
+        'dest_geo': flow.dest_geo,
+    }
+    if flow.HasField('timestamp'):
+        record['timestamp'] = flow.timestamp.ToDatetime().isoformat()
+    return record
+
+
+class Detection(detection_pb2_grpc.DetectionServicer):
+    def Detect(self, request_iterator, context):
+        for request in request_iterator:
+            scores = [service.score_flow(flow_dict(flow)) for flow in request.flows]
+            yield detection_pb2.DetectResponse(
+                scores=[detection_pb2.Score(**score) for score in scores]
+            )
+
+    def Train(self, request, context):
+        try:
+            info = service.train_model([flow_dict(flow) for flow in request.flows])
+        except ValueError as e:
+            context.abort(grpc.StatusCode.INVALID_ARGUMENT, str(e))
+        return detection_pb2.TrainResponse(samples=info['samples'])
+
+    def Health(self, request, context):
+        return detection_pb2.HealthResponse(
+            serving=True, model_trained=service.model is not None
+        )
+
+
+def serve(port=50051):
+    server = grpc.server(futures.ThreadPoolExecutor(max_workers=8))
+    detection_pb2_grpc.add_DetectionServicer_to_server(Detection(), server)
+    server.add_insecure_port(f'[::]:{port}')
+    server.start()
+    print(f"Starting ZTAP Anomaly Detection gRPC Service on port {port}")
+    server.wait_for_termination()
+
+
+if __name__ == '__main__':
+    serve()
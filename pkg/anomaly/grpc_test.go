@@ -0,0 +1,236 @@
+package anomaly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fakeDetection serves the Detection service over plaintext HTTP/2, scoring
+// flows to port 22 as anomalous
+type fakeDetection struct {
+	mu       sync.Mutex
+	timeouts []string
+	trained  []FlowRecord
+	batches  int
+}
+
+func (f *fakeDetection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.timeouts = append(f.timeouts, r.Header.Get("Grpc-Timeout"))
+
+	body, _ := io.ReadAll(r.Body)
+	requests, err := splitGRPCFrames(body)
+	if err != nil || r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc+proto" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc+proto")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	var out []byte
+	switch r.URL.Path {
+	case "/ztap.anomaly.v1.Detection/Detect":
+		for _, req := range requests {
+			f.batches++
+			var resp []byte
+			for _, flow := range decodeTestFlows(req) {
+				var score []byte
+				if flow.Port == 22 {
+					score = protowire.AppendTag(score, 1, protowire.Fixed64Type)
+					score = protowire.AppendFixed64(score, math.Float64bits(80))
+					score = appendVarint(score, 2, 1)
+				}
+				score = appendString(score, 3, "port "+flow.Protocol)
+				resp = appendMessage(resp, 1, score)
+			}
+			out = appendGRPCFrame(out, resp)
+		}
+	case "/ztap.anomaly.v1.Detection/Train":
+		f.trained = decodeTestFlows(requests[0])
+		if len(f.trained) < 2 {
+			w.Header().Set("Grpc-Status", "3")
+			w.Header().Set("Grpc-Message", "need%20two%20samples")
+			return
+		}
+		out = appendGRPCFrame(out, appendVarint(nil, 1, uint64(len(f.trained))))
+	case "/ztap.anomaly.v1.Detection/Health":
+		out = appendGRPCFrame(out, appendVarint(appendVarint(nil, 1, 1), 2, 1))
+	default:
+		w.Header().Set("Grpc-Status", "12")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Write(out)
+	w.Header().Set("Grpc-Status", "0")
+}
+
+func decodeTestFlows(b []byte) []FlowRecord {
+	var flows []FlowRecord
+	consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		var flow FlowRecord
+		consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+			switch num {
+			case 1:
+				flow.SourceIP = string(v)
+			case 3:
+				flow.Port = int(n)
+			case 4:
+				flow.Protocol = string(v)
+			case 6:
+				var seconds int64
+				consumeFields(v, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+					if num == 1 {
+						seconds = int64(n)
+					}
+					return nil
+				})
+				flow.Timestamp = time.Unix(seconds, 0).UTC()
+			}
+			return nil
+		})
+		flows = append(flows, flow)
+		return nil
+	})
+	return flows
+}
+
+func startFakeDetection(t *testing.T) (*fakeDetection, string) {
+	t.Helper()
+	fake := &fakeDetection{}
+	srv := httptest.NewUnstartedServer(fake)
+	srv.Config.Protocols = &http.Protocols{}
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return fake, "grpc://" + strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestGRPCDetectBatch(t *testing.T) {
+	fake, endpoint := startFakeDetection(t)
+	d, err := NewGRPCDetector(endpoint)
+	if err != nil {
+		t.Fatalf("NewGRPCDetector failed: %v", err)
+	}
+
+	flows := make([]FlowRecord, maxDetectBatch+1)
+	for i := range flows {
+		flows[i] = FlowRecord{SourceIP: "10.0.0.1", Port: 443, Protocol: "TCP"}
+	}
+	flows[maxDetectBatch].Port = 22
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	scores, err := d.DetectBatch(ctx, flows)
+	if err != nil {
+		t.Fatalf("DetectBatch failed: %v", err)
+	}
+	if len(scores) != len(flows) || fake.batches != 2 {
+		t.Fatalf("Expected %d scores from 2 batches, got %d from %d", len(flows), len(scores), fake.batches)
+	}
+	if scores[0].IsAnomaly || scores[0].Reason != "port TCP" {
+		t.Errorf("Expected first flow normal, got %+v", scores[0])
+	}
+	if last := scores[maxDetectBatch]; !last.IsAnomaly || last.Score != 80 {
+		t.Errorf("Expected last flow anomalous, got %+v", last)
+	}
+	if timeout := fake.timeouts[0]; !strings.HasSuffix(timeout, "m") && !strings.HasSuffix(timeout, "u") {
+		t.Errorf("Expected the deadline propagated as grpc-timeout, got %q", timeout)
+	}
+}
+
+func TestGRPCTrainAndHealth(t *testing.T) {
+	fake, endpoint := startFakeDetection(t)
+	d, err := NewGRPCDetector(endpoint)
+	if err != nil {
+		t.Fatalf("NewGRPCDetector failed: %v", err)
+	}
+	ctx := context.Background()
+
+	at := time.Date(2025, 10, 9, 10, 0, 0, 0, time.UTC)
+	samples, err := d.TrainContext(ctx, []FlowRecord{{SourceIP: "10.0.0.1", Timestamp: at}, {SourceIP: "10.0.0.2"}})
+	if err != nil || samples != 2 {
+		t.Fatalf("Expected 2 samples trained, got %d, %v", samples, err)
+	}
+	if !fake.trained[0].Timestamp.Equal(at) {
+		t.Errorf("Expected timestamp %v, got %v", at, fake.trained[0].Timestamp)
+	}
+
+	var status *StatusError
+	err = d.Train([]FlowRecord{{SourceIP: "10.0.0.1"}})
+	if !errors.As(err, &status) || status.Code != 3 || status.Message != "need two samples" {
+		t.Errorf("Expected INVALID_ARGUMENT status, got %v", err)
+	}
+
+	health, err := d.Health(ctx)
+	if err != nil || !health.Serving || !health.ModelTrained {
+		t.Errorf("Expected a healthy, trained service, got %+v, %v", health, err)
+	}
+}
+
+func TestWithFallback(t *testing.T) {
+	// Nothing listens on this port
+	d, err := NewDetector("grpc://127.0.0.1:1", "")
+	if err != nil {
+		t.Fatalf("NewDetector failed: %v", err)
+	}
+
+	var called bool
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = r.URL.Path == "/detect"
+		w.Write([]byte(`{"score": 10, "is_anomaly": false, "reason": "http"}`))
+	}))
+	defer fallback.Close()
+
+	score, err := WithFallback(d, NewPythonDetector(fallback.URL)).Detect(FlowRecord{Port: 443})
+	if err != nil || !called || score.Reason != "http" {
+		t.Errorf("Expected the HTTP detector to score the flow, got %+v, %v", score, err)
+	}
+}
+
+func TestNewDetector(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint, fallback string
+		want               string
+	}{
+		{"", "", "*anomaly.SimpleDetector"},
+		{"http://localhost:5000", "", "*anomaly.PythonDetector"},
+		{"grpc://localhost:50051", "", "*anomaly.GRPCDetector"},
+		{"grpcs://detect.example.com:443", "http://localhost:5000", "*anomaly.fallbackDetector"},
+		{"http://localhost:5000", "http://localhost:5001", "error"},
+		{"grpc://", "", "error"},
+	} {
+		d, err := NewDetector(tc.endpoint, tc.fallback)
+		got := "error"
+		if err == nil {
+			got = fmt.Sprintf("%T", d)
+		}
+		if got != tc.want {
+			t.Errorf("NewDetector(%q, %q) = %s, expected %s", tc.endpoint, tc.fallback, got, tc.want)
+		}
+	}
+}
+
+func TestGRPCTimeout(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		1500 * time.Microsecond: "1500000n",
+		5 * time.Second:         "5000000u",
+		3 * time.Minute:         "180000m",
+		0:                       "1n",
+	} {
+		if got := grpcTimeout(d); got != want {
+			t.Errorf("grpcTimeout(%v) = %s, expected %s", d, got, want)
+		}
+	}
+}
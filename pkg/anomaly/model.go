@@ -0,0 +1,136 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ModelInfo describes a model version of the ML detection service
+type ModelInfo struct {
+	Version   int       `json:"version"`
+	TrainedAt time.Time `json:"trained_at"`
+	Samples   int       `json:"samples"`
+}
+
+// Models are the active model of a detection service, which scores flows,
+// and the candidate awaiting promotion. Either is nil if there is none; the
+// service scores flows with its rules while no model is active.
+type Models struct {
+	Active    *ModelInfo `json:"active"`
+	Candidate *ModelInfo `json:"candidate"`
+}
+
+// Evaluation compares the candidate model with the active one on the same
+// flows (shadow evaluation)
+type Evaluation struct {
+	Flows              int  `json:"flows"`
+	ActiveVersion      *int `json:"active_version"` // Nil when scored by the rules
+	CandidateVersion   int  `json:"candidate_version"`
+	ActiveAnomalies    int  `json:"active_anomalies"`
+	CandidateAnomalies int  `json:"candidate_anomalies"`
+	Disagreements      int  `json:"disagreements"` // Flows only one model found anomalous
+}
+
+// ActiveRate is the fraction of flows the active model found anomalous
+func (e Evaluation) ActiveRate() float64 {
+	return rate(e.ActiveAnomalies, e.Flows)
+}
+
+// CandidateRate is the fraction of flows the candidate found anomalous
+func (e Evaluation) CandidateRate() float64 {
+	return rate(e.CandidateAnomalies, e.Flows)
+}
+
+// Acceptable reports whether the candidate's anomaly rate exceeds the
+// active model's by at most maxIncrease (a fraction of flows), so promoting
+// it does not flood operators with new alerts
+func (e Evaluation) Acceptable(maxIncrease float64) bool {
+	return e.Flows > 0 && e.CandidateRate()-e.ActiveRate() <= maxIncrease
+}
+
+func rate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// Models returns the model versions of the detection service
+func (d *PythonDetector) Models(ctx context.Context) (Models, error) {
+	var models Models
+	err := d.call(ctx, http.MethodGet, "/model", nil, &models)
+	return models, err
+}
+
+// TrainCandidate trains a candidate model on flows, leaving the active model
+// in use until the candidate is promoted
+func (d *PythonDetector) TrainCandidate(ctx context.Context, flows []FlowRecord) (ModelInfo, error) {
+	var info ModelInfo
+	err := d.call(ctx, http.MethodPost, "/train", map[string]any{"flows": flows, "candidate": true}, &info)
+	return info, err
+}
+
+// Evaluate scores flows with both the candidate and the active model,
+// without affecting detection
+func (d *PythonDetector) Evaluate(ctx context.Context, flows []FlowRecord) (Evaluation, error) {
+	var evaluation Evaluation
+	err := d.call(ctx, http.MethodPost, "/evaluate", flows, &evaluation)
+	return evaluation, err
+}
+
+// Promote makes candidate version the active model. It fails if the service's
+// candidate is another version, e.g. one trained after the evaluation.
+func (d *PythonDetector) Promote(ctx context.Context, version int) (ModelInfo, error) {
+	var result struct {
+		Active ModelInfo `json:"active"`
+	}
+	err := d.call(ctx, http.MethodPost, "/promote", map[string]int{"version": version}, &result)
+	return result.Active, err
+}
+
+// call sends in as JSON to path and decodes the response into out. Error
+// responses carry their message in an "error" field.
+func (d *PythonDetector) call(ctx context.Context, method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, d.endpoint+path, &body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Training can take longer than the client's detection timeout
+	client := *d.client
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call detection service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		if failure.Error != "" {
+			return fmt.Errorf("detection service returned status %d: %s", resp.StatusCode, strings.TrimSpace(failure.Error))
+		}
+		return fmt.Errorf("detection service returned status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package anomaly
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestModelManagement(t *testing.T) {
+	var trained map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /model":
+			w.Write([]byte(`{"active": {"version": 1, "trained_at": "2025-10-09T10:00:00+00:00", "samples": 20}, "candidate": null}`))
+		case "POST /train":
+			json.NewDecoder(r.Body).Decode(&trained)
+			w.Write([]byte(`{"status": "trained", "samples": 2, "features": 6, "version": 2, "candidate": true}`))
+		case "POST /evaluate":
+			w.Write([]byte(`{"flows": 200, "active_version": 1, "candidate_version": 2, "active_anomalies": 10, "candidate_anomalies": 16, "disagreements": 8}`))
+		case "POST /promote":
+			var req map[string]int
+			json.NewDecoder(r.Body).Decode(&req)
+			if req["version"] != 2 {
+				w.WriteHeader(http.StatusConflict)
+				w.Write([]byte(`{"error": "Candidate is version 2"}`))
+				return
+			}
+			w.Write([]byte(`{"active": {"version": 2, "samples": 2}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewPythonDetector(srv.URL)
+	ctx := context.Background()
+
+	models, err := d.Models(ctx)
+	if err != nil || models.Active == nil || models.Active.Version != 1 || models.Candidate != nil {
+		t.Fatalf("Expected active version 1 and no candidate, got %+v, %v", models, err)
+	}
+
+	info, err := d.TrainCandidate(ctx, []FlowRecord{{SourceIP: "10.0.0.1"}, {SourceIP: "10.0.0.2"}})
+	if err != nil || info.Version != 2 {
+		t.Fatalf("Expected candidate version 2, got %+v, %v", info, err)
+	}
+	if trained["candidate"] != true || len(trained["flows"].([]any)) != 2 {
+		t.Errorf("Expected flows trained as a candidate, got %v", trained)
+	}
+
+	evaluation, err := d.Evaluate(ctx, []FlowRecord{{SourceIP: "10.0.0.1"}})
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	if evaluation.ActiveRate() != 0.05 || evaluation.CandidateRate() != 0.08 {
+		t.Errorf("Unexpected rates %v and %v", evaluation.ActiveRate(), evaluation.CandidateRate())
+	}
+	if !evaluation.Acceptable(0.05) || evaluation.Acceptable(0.02) {
+		t.Errorf("Expected a 3 point increase to be acceptable only within 5 points")
+	}
+
+	if _, err := d.Promote(ctx, 3); err == nil || !strings.Contains(err.Error(), "Candidate is version 2") {
+		t.Errorf("Expected promotion of another version refused, got %v", err)
+	}
+	if info, err := d.Promote(ctx, 2); err != nil || info.Version != 2 {
+		t.Errorf("Expected version 2 promoted, got %+v, %v", info, err)
+	}
+}
+
+func TestEvaluationWithoutFlows(t *testing.T) {
+	if (Evaluation{}).Acceptable(1) {
+		t.Error("Expected an evaluation of no flows not to be acceptable")
+	}
+}
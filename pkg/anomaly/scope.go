@@ -0,0 +1,181 @@
+package anomaly
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"ztap/pkg/configfile"
+)
+
+// Detectors a scope can enable
+const (
+	DetectorRules = "rules" // SimpleDetector
+	DetectorML    = "ml"    // The detection service of --anomaly-endpoint
+)
+
+// Scope sets how flows to or from the workloads matching Selector are
+// scored, e.g. a lower threshold for tier=pci
+type Scope struct {
+	Name     string            `yaml:"name"`
+	Selector map[string]string `yaml:"selector"`
+	// Threshold a flow must score above to be anomalous; 0 keeps the
+	// detectors' own decision
+	Threshold float64 `yaml:"threshold"`
+	// Detectors scoring the flows, DetectorRules and/or DetectorML; empty
+	// keeps the agent's detector. The highest score counts.
+	Detectors []string `yaml:"detectors"`
+}
+
+// matches reports whether the scope selects a workload with labels
+func (s Scope) matches(labels map[string]string) bool {
+	if len(labels) == 0 {
+		return false
+	}
+	for key, value := range s.Selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Config is the anomaly section of config.yaml
+type Config struct {
+	// Scopes are matched in order; the first matching either end of a flow
+	// applies
+	Scopes []Scope `yaml:"scopes"`
+}
+
+// Validate checks the scopes
+func (c Config) Validate() error {
+	names := make(map[string]bool)
+	for i, scope := range c.Scopes {
+		if scope.Name == "" {
+			return fmt.Errorf("anomaly.scopes[%d]: name is required", i)
+		}
+		if names[scope.Name] {
+			return fmt.Errorf("anomaly.scopes[%d]: duplicate name %q", i, scope.Name)
+		}
+		names[scope.Name] = true
+		if len(scope.Selector) == 0 {
+			return fmt.Errorf("anomaly.scopes[%d]: selector is required", i)
+		}
+		if scope.Threshold < 0 || scope.Threshold > 100 {
+			return fmt.Errorf("anomaly.scopes[%d]: threshold must be between 0 and 100", i)
+		}
+		for _, name := range scope.Detectors {
+			if name != DetectorRules && name != DetectorML {
+				return fmt.Errorf("anomaly.scopes[%d]: unknown detector %q (use %s or %s)", i, name, DetectorRules, DetectorML)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads the anomaly section of file. A missing file or section
+// configures no scopes.
+func LoadConfig(file *configfile.File) (Config, error) {
+	var c Config
+	if err := file.Section("anomaly", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// scopedDetector scores flows by the scope of their workloads
+type scopedDetector struct {
+	Detector
+	named  map[string]Detector
+	scopes []Scope
+	labels func(ip string) map[string]string
+}
+
+// WithScopes wraps d so flows whose source or destination workload matches
+// a scope are scored with the scope's detectors, from named, and threshold.
+// labels returns the labels of the workload with an IP, nil if unknown.
+// Scores of scoped flows carry the scope's name. Other flows are scored by d.
+func WithScopes(d Detector, named map[string]Detector, scopes []Scope, labels func(ip string) map[string]string) (Detector, error) {
+	for _, scope := range scopes {
+		for _, name := range scope.Detectors {
+			if named[name] == nil {
+				return nil, fmt.Errorf("anomaly scope %s enables detector %q, which is not configured", scope.Name, name)
+			}
+		}
+	}
+	return &scopedDetector{Detector: d, named: named, scopes: scopes, labels: labels}, nil
+}
+
+// Detect scores flow according to its scope
+func (d *scopedDetector) Detect(flow FlowRecord) (*AnomalyScore, error) {
+	scope := d.scopeOf(flow)
+	if scope == nil {
+		return d.Detector.Detect(flow)
+	}
+
+	detectors := []Detector{d.Detector}
+	if len(scope.Detectors) > 0 {
+		detectors = detectors[:0]
+		for _, name := range scope.Detectors {
+			detectors = append(detectors, d.named[name])
+		}
+	}
+
+	var best *AnomalyScore
+	anomalous := false
+	for _, detector := range detectors {
+		score, err := detector.Detect(flow)
+		if err != nil {
+			return nil, err
+		}
+		anomalous = anomalous || score.IsAnomaly
+		if best == nil || score.Score > best.Score {
+			best = score
+		}
+	}
+
+	result := *best
+	result.IsAnomaly = anomalous
+	if scope.Threshold > 0 {
+		result.IsAnomaly = result.Score > scope.Threshold
+	}
+	result.Scope = scope.Name
+	return &result, nil
+}
+
+// Train trains the default and every named detector
+func (d *scopedDetector) Train(flows []FlowRecord) error {
+	if err := d.Detector.Train(flows); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(d.named))
+	for name := range d.named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var failed []string
+	for _, name := range names {
+		if d.named[name] == d.Detector {
+			continue
+		}
+		if err := d.named[name].Train(flows); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to train detectors: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// scopeOf returns the first scope matching the source or destination
+// workload of flow, or nil
+func (d *scopedDetector) scopeOf(flow FlowRecord) *Scope {
+	source, dest := d.labels(flow.SourceIP), d.labels(flow.DestIP)
+	for i := range d.scopes {
+		if d.scopes[i].matches(source) || d.scopes[i].matches(dest) {
+			return &d.scopes[i]
+		}
+	}
+	return nil
+}
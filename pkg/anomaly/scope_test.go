@@ -0,0 +1,110 @@
+package anomaly
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ztap/pkg/configfile"
+)
+
+// fixedDetector gives every flow the same score
+type fixedDetector struct {
+	score   AnomalyScore
+	trained int
+}
+
+func (d *fixedDetector) Detect(flow FlowRecord) (*AnomalyScore, error) {
+	score := d.score
+	return &score, nil
+}
+
+func (d *fixedDetector) Train(flows []FlowRecord) error {
+	d.trained++
+	return nil
+}
+
+func TestWithScopes(t *testing.T) {
+	rules := &fixedDetector{score: AnomalyScore{Score: 30, Reason: "suspicious port 22"}}
+	ml := &fixedDetector{score: AnomalyScore{Score: 45, Reason: "isolation forest"}}
+	labels := map[string]map[string]string{
+		"10.0.0.1": {"app": "web"},
+		"10.0.9.9": {"app": "payments", "tier": "pci"},
+	}
+
+	d, err := WithScopes(ml, map[string]Detector{DetectorRules: rules, DetectorML: ml}, []Scope{
+		{Name: "pci", Selector: map[string]string{"tier": "pci"}, Threshold: 25, Detectors: []string{DetectorRules, DetectorML}},
+		{Name: "web", Selector: map[string]string{"app": "web"}, Detectors: []string{DetectorRules}},
+	}, func(ip string) map[string]string { return labels[ip] })
+	if err != nil {
+		t.Fatalf("WithScopes failed: %v", err)
+	}
+
+	// The destination is in the pci scope, which comes first: the highest
+	// score counts against the lower threshold
+	score, _ := d.Detect(FlowRecord{SourceIP: "10.0.0.1", DestIP: "10.0.9.9", Port: 22})
+	if !score.IsAnomaly || score.Score != 45 || score.Scope != "pci" {
+		t.Errorf("Expected an anomaly in scope pci, got %+v", score)
+	}
+
+	// The web scope only runs the rules and keeps their decision
+	score, _ = d.Detect(FlowRecord{SourceIP: "10.0.0.1", DestIP: "10.0.0.2", Port: 22})
+	if score.IsAnomaly || score.Reason != "suspicious port 22" || score.Scope != "web" {
+		t.Errorf("Expected a normal flow in scope web, got %+v", score)
+	}
+
+	// Unknown workloads are scored by the default detector without a scope
+	score, _ = d.Detect(FlowRecord{SourceIP: "10.0.5.5", DestIP: "10.0.5.6"})
+	if score.Reason != "isolation forest" || score.Scope != "" {
+		t.Errorf("Expected an unscoped ML score, got %+v", score)
+	}
+
+	if err := d.Train(nil); err != nil || rules.trained != 1 || ml.trained != 1 {
+		t.Errorf("Expected each detector trained once, got rules %d, ml %d, %v", rules.trained, ml.trained, err)
+	}
+}
+
+func TestWithScopesMissingDetector(t *testing.T) {
+	_, err := WithScopes(NewSimpleDetector(), map[string]Detector{DetectorRules: NewSimpleDetector()}, []Scope{
+		{Name: "pci", Selector: map[string]string{"tier": "pci"}, Detectors: []string{DetectorML}},
+	}, func(string) map[string]string { return nil })
+	if err == nil || !strings.Contains(err.Error(), `"ml"`) {
+		t.Errorf("Expected an error for the unconfigured ML detector, got %v", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+anomaly:
+  scopes:
+    - name: pci
+      selector:
+        tier: pci
+      threshold: 30
+      detectors: [rules, ml]
+`), 0600)
+	config, err := configfile.Read(path, LoadConfig)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if len(config.Scopes) != 1 || config.Scopes[0].Threshold != 30 || len(config.Scopes[0].Detectors) != 2 {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	for _, invalid := range []string{
+		"anomaly:\n  scopes:\n    - name: pci\n",
+		"anomaly:\n  scopes:\n    - name: pci\n      selector: {tier: pci}\n      threshold: 150\n",
+		"anomaly:\n  scopes:\n    - name: pci\n      selector: {tier: pci}\n      detectors: [neural]\n",
+	} {
+		os.WriteFile(path, []byte(invalid), 0600)
+		if _, err := configfile.Read(path, LoadConfig); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+
+	if config, err := configfile.Read(filepath.Join(t.TempDir(), "missing.yaml"), LoadConfig); err != nil || len(config.Scopes) != 0 {
+		t.Errorf("Expected no scopes without a config file, got %+v, %v", config, err)
+	}
+}
@@ -8,7 +8,7 @@ from flask import Flask, request, jsonify
 from sklearn.ensemble import IsolationForest
 import numpy as np
 import json
-from datetime import datetime
+from datetime import datetime, timezone
 
 app = Flask(__name__)
 
@@ -16,6 +16,13 @@ app = Flask(__name__)
 model = None
 training_data = []
 
+# Version and training details of the active model, and of a candidate model
+# trained for shadow evaluation before it is promoted
+model_info = None
+candidate = None
+candidate_info = None
+next_version = 1
+
 def extract_features(flow):
     """Extract numeric features from flow record"""
     # Convert IP addresses to numeric (simple hash for demo)
@@ -42,13 +49,15 @@ def extract_features(flow):
 @app.route('/health', methods=['GET'])
 def health():
     """Health check endpoint"""
-    return jsonify({'status': 'healthy', 'model_trained': model is not None})
+    return jsonify({
+        'status': 'healthy',
+        'model_trained': model is not None,
+        'model_version': model_info['version'] if model_info else None
+    })
 
 @app.route('/train', methods=['POST'])
 def train():
     """Train the Isolation Forest model on normal traffic"""
-    global model, training_data
-    
     data = request.json
     if not data:
         return jsonify({'error': 'Expected JSON data'}), 400
@@ -59,50 +68,137 @@ def train():
     if not flows or not isinstance(flows, list):
         return jsonify({'error': 'Expected list of flows'}), 400
     
+    # {'candidate': true} trains a candidate, leaving the active model in use
+    as_candidate = isinstance(data, dict) and bool(data.get('candidate'))
+    try:
+        info = train_model(flows, as_candidate)
+    except ValueError as e:
+        return jsonify({'error': str(e)}), 400
+    
+    return jsonify({
+        'status': 'trained',
+        'samples': info['samples'],
+        'features': info['features'],
+        'version': info['version'],
+        'trained_at': info['trained_at'],
+        'candidate': as_candidate
+    })
+
+def train_model(flows, as_candidate=False):
+    """Fit a new model version to flows, returning its details. The model
+    becomes active unless as_candidate is set."""
+    global model, training_data, model_info, candidate, candidate_info, next_version
+    
     # Require minimum samples for training
     if len(flows) < 2:
-        return jsonify({'error': 'Insufficient training data (minimum 2 samples required)'}), 400
+        raise ValueError('Insufficient training data (minimum 2 samples required)')
     
     # Extract features
     features = [extract_features(flow) for flow in flows]
     X = np.array(features)
     
     # Train model
-    model = IsolationForest(
+    trained = IsolationForest(
         contamination=0.1,  # Expect 10% anomalies
         random_state=42,
         n_estimators=100
     )
-    model.fit(X)
-    
-    training_data = flows
+    trained.fit(X)
     
-    return jsonify({
-        'status': 'trained',
+    info = {
+        'version': next_version,
+        'trained_at': datetime.now(timezone.utc).isoformat(),
         'samples': len(flows),
         'features': X.shape[1]
+    }
+    next_version += 1
+    
+    if as_candidate:
+        candidate, candidate_info = trained, info
+    else:
+        model, model_info = trained, info
+        training_data = flows
+    return info
+
+@app.route('/model', methods=['GET'])
+def model_status():
+    """Versions of the active and candidate models"""
+    return jsonify({'active': model_info, 'candidate': candidate_info})
+
+@app.route('/evaluate', methods=['POST'])
+def evaluate():
+    """Score flows with both the active and the candidate model (shadow
+    evaluation), without affecting detection"""
+    if candidate is None:
+        return jsonify({'error': 'No candidate model. Train one with {"candidate": true} first.'}), 409
+    
+    data = request.json
+    flows = data.get('flows', data) if isinstance(data, dict) else data
+    if not flows or not isinstance(flows, list):
+        return jsonify({'error': 'Expected list of flows'}), 400
+    
+    active_anomalies = candidate_anomalies = disagreements = 0
+    for flow in flows:
+        active = score_with(model, flow)['is_anomaly']
+        shadow = score_with(candidate, flow)['is_anomaly']
+        active_anomalies += active
+        candidate_anomalies += shadow
+        disagreements += active != shadow
+    
+    return jsonify({
+        'flows': len(flows),
+        'active_version': model_info['version'] if model_info else None,
+        'candidate_version': candidate_info['version'],
+        'active_anomalies': int(active_anomalies),
+        'candidate_anomalies': int(candidate_anomalies),
+        'disagreements': int(disagreements)
     })
 
+@app.route('/promote', methods=['POST'])
+def promote():
+    """Make the candidate model active. {'version': n} refuses to promote a
+    candidate other than the one evaluated."""
+    global model, model_info, candidate, candidate_info, training_data
+    
+    if candidate is None:
+        return jsonify({'error': 'No candidate model to promote'}), 409
+    
+    data = request.get_json(silent=True) or {}
+    version = data.get('version')
+    if version is not None and version != candidate_info['version']:
+        return jsonify({'error': f"Candidate is version {candidate_info['version']}, not {version}"}), 409
+    
+    model, model_info = candidate, candidate_info
+    candidate = candidate_info = None
+    training_data = []
+    return jsonify({'active': model_info})
+
 @app.route('/detect', methods=['POST'])
 def detect():
     """Detect if a flow is anomalous"""
-    global model
-    
-    if model is None:
-        # Use simple heuristic if not trained
-        return simple_detect(request.json)
-    
     flow = request.json
-    if not flow:
+    if model is not None and not flow:
         return jsonify({'error': 'Expected flow object'}), 400
     
+    return jsonify(score_flow(flow))
+
+def score_flow(flow):
+    """Score a flow with the model, or the rules if it is not trained"""
+    return score_with(model, flow)
+
+def score_with(m, flow):
+    """Score a flow with model m, or the rules if m is None"""
+    if m is None:
+        # Use simple heuristic if not trained
+        return simple_score(flow)
+    
     # Extract features
     features = extract_features(flow)
     X = np.array([features])
     
     # Predict (-1 = anomaly, 1 = normal)
-    prediction = model.predict(X)[0]
-    anomaly_score = model.decision_function(X)[0]
+    prediction = m.predict(X)[0]
+    anomaly_score = m.decision_function(X)[0]
     
     # Convert to 0-100 scale (lower anomaly_score = more anomalous)
     # Typical range is [-0.5, 0.5], normalize to [0, 100]
@@ -115,11 +211,11 @@ def detect():
     else:
         reason += "flow matches normal patterns"
     
-    return jsonify({
+    return {
         'score': float(score),
         'is_anomaly': bool(is_anomaly),
         'reason': reason
-    })
+    }
 
 @app.route('/predict', methods=['POST'])
 def predict():
@@ -202,6 +298,11 @@ def batch_predict():
 
 def simple_detect(flow):
     """Simple rule-based detection (fallback when model not trained)"""
+    return jsonify(simple_score(flow))
+
+
+def simple_score(flow):
+    """Score a flow with the rules of simple_detect"""
     score = 0.0
     reasons = []
     
@@ -225,11 +326,11 @@ def simple_detect(flow):
     
     reason = "rule-based detection: " + (", ".join(reasons) if reasons else "normal traffic")
     
-    return jsonify({
+    return {
         'score': float(score),
         'is_anomaly': score > 50,
         'reason': reason
-    })
+    }
 
 if __name__ == '__main__':
     print("Starting ZTAP Anomaly Detection Service")
@@ -238,6 +339,9 @@ if __name__ == '__main__':
     print("  POST /predict       - Predict single flow (requires trained model)")
     print("  POST /batch_predict - Predict multiple flows (requires trained model)")
     print("  POST /detect        - Detect anomalous flows (with fallback)")
+    print("  GET  /model         - Active and candidate model versions")
+    print("  POST /evaluate      - Compare the candidate model with the active one")
+    print("  POST /promote       - Make the candidate model active")
     print("  GET  /health        - Health check")
     app.run(host='0.0.0.0', port=5000, debug=False)
     app.run(host='0.0.0.0', port=5000, debug=True)
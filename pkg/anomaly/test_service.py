@@ -20,6 +20,9 @@ class TestAnomalyDetectionService(unittest.TestCase):
         # Reset global state in the service module
         service.model = None
         service.training_data = []
+        service.model_info = None
+        service.candidate = None
+        service.candidate_info = None
     
     def test_health_endpoint(self):
         """Test health check endpoint"""
@@ -309,6 +312,71 @@ class TestAnomalyDetectionService(unittest.TestCase):
         
         self.assertEqual(response.status_code, 400)
 
+    def _flows(self, count, port=443):
+        return [
+            {
+                'source_ip': f'192.168.1.{i}',
+                'dest_ip': '10.0.0.1',
+                'protocol': 'TCP',
+                'port': port,
+                'bytes': 1000 + i,
+                'timestamp': '2025-10-09T10:00:00'
+            }
+            for i in range(count)
+        ]
+    
+    def test_candidate_model_workflow(self):
+        """Test training, evaluating and promoting a candidate model"""
+        response = self.client.post('/train',
+                                    data=json.dumps({'flows': self._flows(20)}),
+                                    content_type='application/json')
+        active = json.loads(response.data)['version']
+        
+        response = self.client.post('/train',
+                                    data=json.dumps({'flows': self._flows(30), 'candidate': True}),
+                                    content_type='application/json')
+        self.assertEqual(response.status_code, 200)
+        data = json.loads(response.data)
+        self.assertTrue(data['candidate'])
+        candidate = data['version']
+        self.assertGreater(candidate, active)
+        
+        # The candidate does not replace the active model
+        data = json.loads(self.client.get('/model').data)
+        self.assertEqual(data['active']['version'], active)
+        self.assertEqual(data['candidate']['version'], candidate)
+        
+        response = self.client.post('/evaluate',
+                                    data=json.dumps(self._flows(10)),
+                                    content_type='application/json')
+        self.assertEqual(response.status_code, 200)
+        data = json.loads(response.data)
+        self.assertEqual(data['flows'], 10)
+        self.assertEqual(data['active_version'], active)
+        self.assertEqual(data['candidate_version'], candidate)
+        self.assertIn('disagreements', data)
+        
+        # Promoting a version other than the candidate is refused
+        response = self.client.post('/promote',
+                                    data=json.dumps({'version': active}),
+                                    content_type='application/json')
+        self.assertEqual(response.status_code, 409)
+        
+        response = self.client.post('/promote',
+                                    data=json.dumps({'version': candidate}),
+                                    content_type='application/json')
+        self.assertEqual(response.status_code, 200)
+        data = json.loads(self.client.get('/model').data)
+        self.assertEqual(data['active']['version'], candidate)
+        self.assertIsNone(data['candidate'])
+    
+    def test_evaluate_without_candidate(self):
+        """Test shadow evaluation without a candidate model"""
+        response = self.client.post('/evaluate',
+                                    data=json.dumps(self._flows(3)),
+                                    content_type='application/json')
+        self.assertEqual(response.status_code, 409)
+
 
 if __name__ == '__main__':
     unittest.main()
@@ -0,0 +1,157 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encoding of the detection.proto messages, written by hand so the
+// client needs no generated code
+
+func appendFlow(b []byte, f FlowRecord) []byte {
+	b = appendString(b, 1, f.SourceIP)
+	b = appendString(b, 2, f.DestIP)
+	b = appendVarint(b, 3, uint64(int32(f.Port)))
+	b = appendString(b, 4, f.Protocol)
+	b = appendVarint(b, 5, uint64(f.Bytes))
+	if !f.Timestamp.IsZero() {
+		var ts []byte
+		ts = appendVarint(ts, 1, uint64(f.Timestamp.Unix()))
+		ts = appendVarint(ts, 2, uint64(f.Timestamp.Nanosecond()))
+		b = appendMessage(b, 6, ts)
+	}
+	b = appendString(b, 7, f.SourceGeo)
+	b = appendString(b, 8, f.DestGeo)
+	return b
+}
+
+// encodeFlows encodes a DetectRequest or TrainRequest, which both hold
+// flows in field 1
+func encodeFlows(flows []FlowRecord) []byte {
+	var b []byte
+	for _, f := range flows {
+		b = appendMessage(b, 1, appendFlow(nil, f))
+	}
+	return b
+}
+
+func decodeDetectResponse(b []byte) ([]*AnomalyScore, error) {
+	var scores []*AnomalyScore
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		score, err := decodeScore(v)
+		if err != nil {
+			return err
+		}
+		scores = append(scores, score)
+		return nil
+	})
+	return scores, err
+}
+
+func decodeScore(b []byte) (*AnomalyScore, error) {
+	score := &AnomalyScore{}
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			score.Score = math.Float64frombits(n)
+		case num == 2 && typ == protowire.VarintType:
+			score.IsAnomaly = n != 0
+		case num == 3 && typ == protowire.BytesType:
+			score.Reason = string(v)
+		}
+		return nil
+	})
+	return score, err
+}
+
+func decodeTrainResponse(b []byte) (int, error) {
+	samples := 0
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		if num == 1 && typ == protowire.VarintType {
+			samples = int(int32(n))
+		}
+		return nil
+	})
+	return samples, err
+}
+
+func decodeHealthResponse(b []byte) (Health, error) {
+	var health Health
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		if typ != protowire.VarintType {
+			return nil
+		}
+		switch num {
+		case 1:
+			health.Serving = n != 0
+		case 2:
+			health.ModelTrained = n != 0
+		}
+		return nil
+	})
+	return health, err
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// consumeFields calls fn with every field of a message: v holds the content
+// of length-delimited fields and n the value of varint and fixed fields.
+// Unknown fields are for fn to skip.
+func consumeFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return fmt.Errorf("invalid protobuf message: %w", protowire.ParseError(l))
+		}
+		b = b[l:]
+
+		var v []byte
+		var n uint64
+		switch typ {
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			n, l = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var n32 uint32
+			n32, l = protowire.ConsumeFixed32(b)
+			n = uint64(n32)
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(b)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if l < 0 {
+			return fmt.Errorf("invalid protobuf message: %w", protowire.ParseError(l))
+		}
+		b = b[l:]
+		if err := fn(num, typ, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
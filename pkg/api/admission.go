@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"ztap/pkg/admission"
+	"ztap/pkg/auth"
+	"ztap/pkg/policy"
+	"ztap/pkg/storage"
+)
+
+// Admission statuses
+const (
+	AdmissionRejected = "rejected" // A check failed; nothing was stored
+	AdmissionChecked  = "checked"  // The checks passed on a dry run
+	AdmissionStored   = "stored"
+	AdmissionPending  = "pending" // Held for approval
+)
+
+// AdmissionRequest is the body of POST /admission
+type AdmissionRequest struct {
+	// Name stores the document, as PUT /policies/{name}; by default the
+	// name of its first policy
+	Name      string `json:"name,omitempty"`
+	YAML      string `json:"yaml"`
+	Source    string `json:"source,omitempty"`    // Submitting system, e.g. ci or jira
+	Reference string `json:"reference,omitempty"` // e.g. a commit or ticket
+	DryRun    bool   `json:"dry_run,omitempty"`   // Only run the checks
+}
+
+// AdmissionResponse is the outcome of POST /admission
+type AdmissionResponse struct {
+	Name    string                 `json:"name"`
+	Status  string                 `json:"status"`
+	Result  admission.Result       `json:"result"`
+	Record  *storage.PolicyRecord  `json:"record,omitempty"`
+	Pending *storage.PendingChange `json:"pending,omitempty"`
+}
+
+// SetAdmission replaces the checks of POST /admission, by default those of
+// admission.DefaultConfig
+func (s *Server) SetAdmission(checker *admission.Checker) {
+	s.admission = checker
+}
+
+// handleAdmission serves POST /admission, through which external systems
+// submit policy documents. A submission is validated, linted and checked by
+// OPA (see admission.Checker), then stored as PUT /policies/{name} would,
+// subject to quotas and approval. Submissions failing a check are answered
+// with 200 and status rejected, so callers read one response for every
+// outcome; a 409 redefines a policy of another document, and a 403 one
+// outside the scopes of the user (see writeAllowed).
+func (s *Server) handleAdmission(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, session, ok := s.authorizeTenant(w, r, auth.PermEnforce)
+	if !ok {
+		return
+	}
+	var req AdmissionRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxPolicySize+1)).Decode(&req); err != nil || req.YAML == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Name == "" {
+		if policies, err := policy.Parse([]byte(req.YAML)); err == nil && len(policies) > 0 {
+			req.Name = policies[0].Metadata.Name
+		}
+	}
+	if strings.Contains(req.Name, "/") {
+		writeError(w, http.StatusBadRequest, "invalid name")
+		return
+	}
+
+	result, err := s.admission.Check(ctx, admission.Submission{
+		Name:      req.Name,
+		YAML:      req.YAML,
+		Source:    req.Source,
+		Reference: req.Reference,
+		User:      session.Username,
+		Tenant:    auth.TenantFromContext(ctx),
+	})
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	resp := AdmissionResponse{Name: req.Name, Status: AdmissionRejected, Result: result}
+	if result.Allowed && req.Name == "" {
+		resp.Result.Allowed = false
+		resp.Result.Errors = []string{"name is required"}
+	}
+	if !resp.Result.Allowed || req.DryRun {
+		if resp.Result.Allowed {
+			resp.Status = AdmissionChecked
+		}
+		logAdmission(session, req, resp.Status)
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	if !s.checkWriteAllowed(ctx, w, session, req.Name, req.YAML) {
+		return
+	}
+	if err := s.checkCollisions(ctx, req.Name, []byte(req.YAML)); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	record, pending, err := s.stagePolicy(ctx, req.Name, req.YAML, session.Username)
+	switch {
+	case err != nil:
+		writePolicyError(w, err)
+		return
+	case pending != nil:
+		resp.Status, resp.Pending = AdmissionPending, pending
+	default:
+		resp.Status, resp.Record = AdmissionStored, &record
+	}
+	logAdmission(session, req, resp.Status)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// logAdmission records who submitted what from where
+func logAdmission(session *auth.Session, req AdmissionRequest, status string) {
+	source := req.Source
+	if source == "" {
+		source = "unknown source"
+	}
+	if req.Reference != "" {
+		source += " " + req.Reference
+	}
+	log.Printf("Admission of policy %q by %s (%s): %s", req.Name, session.Username, source, status)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ztap/pkg/approval"
+	"ztap/pkg/auth"
+	"ztap/pkg/storage"
+)
+
+func submit(t *testing.T, s *Server, token string, req AdmissionRequest) (int, AdmissionResponse) {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/admission", bytes.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, r)
+	var resp AdmissionResponse
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestAdmission(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	operator := login(t, am, "olivia", auth.RoleOperator)
+	viewer := login(t, am, "val", auth.RoleViewer)
+
+	if code, _ := submit(t, s, viewer, AdmissionRequest{YAML: testPolicyYAML}); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a viewer, got %d", code)
+	}
+
+	code, resp := submit(t, s, operator, AdmissionRequest{YAML: "kind: [\n", Source: "ci"})
+	if code != http.StatusOK || resp.Status != AdmissionRejected || len(resp.Result.Errors) == 0 {
+		t.Errorf("Expected invalid YAML rejected, got %d %+v", code, resp)
+	}
+
+	code, resp = submit(t, s, operator, AdmissionRequest{YAML: testPolicyYAML, DryRun: true})
+	if code != http.StatusOK || resp.Status != AdmissionChecked || resp.Name != "web-to-db" || resp.Record != nil {
+		t.Errorf("Expected a dry run checked only, got %d %+v", code, resp)
+	}
+	if _, err := s.policies.GetPolicy(t.Context(), "web-to-db"); err == nil {
+		t.Error("Expected nothing stored on a dry run")
+	}
+
+	code, resp = submit(t, s, operator, AdmissionRequest{YAML: testPolicyYAML, Source: "ci", Reference: "abc123"})
+	if code != http.StatusOK || resp.Status != AdmissionStored || resp.Record == nil || resp.Record.UpdatedBy != "olivia" {
+		t.Fatalf("Expected the policy stored, got %d %+v", code, resp)
+	}
+	if !resp.Result.Allowed || len(resp.Result.Findings) == 0 {
+		t.Errorf("Expected lint warnings reported but allowed, got %+v", resp.Result)
+	}
+
+	renamed := strings.Replace(testPolicyYAML, "10.0.0.0/24", "10.0.1.0/24", 1)
+	if code, _ := submit(t, s, operator, AdmissionRequest{Name: "other", YAML: renamed}); code != http.StatusConflict {
+		t.Errorf("Expected 409 redefining web-to-db in another document, got %d", code)
+	}
+}
+
+func TestAdmissionApproval(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	pending := storage.NewFilePendingStore(filepath.Join(t.TempDir(), "pending.json"))
+	s.RequireApproval(approval.NewGate(approval.Config{Rules: []approval.Rule{{Name: "postgres", Port: 5432}}}, s.policies, pending))
+	operator := login(t, am, "olivia", auth.RoleOperator)
+
+	code, resp := submit(t, s, operator, AdmissionRequest{YAML: testPolicyYAML, Source: "jira", Reference: "SEC-42"})
+	if code != http.StatusOK || resp.Status != AdmissionPending || resp.Pending == nil || resp.Pending.RequestedBy != "olivia" {
+		t.Fatalf("Expected the change held for approval, got %d %+v", code, resp)
+	}
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"ztap/pkg/approval"
+	"ztap/pkg/auth"
+	"ztap/pkg/quota"
+	"ztap/pkg/storage"
+)
+
+// handleApprovals serves GET /approvals, listing policy changes awaiting
+// approval, and for users limited by scopes only those they may see (see
+// changeVisible)
+func (s *Server) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, session, ok := s.authorizeTenant(w, r, auth.PermViewPolicies)
+	if !ok {
+		return
+	}
+	if s.gate == nil {
+		writeError(w, http.StatusNotFound, "policy approval is not enabled")
+		return
+	}
+
+	changes, err := s.gate.Pending(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	visible := []storage.PendingChange{}
+	for _, change := range changes {
+		if changeVisible(session, change) {
+			visible = append(visible, change)
+		}
+	}
+	writeJSON(w, http.StatusOK, visible)
+}
+
+// handleApproval serves POST /approvals/{id}/approve, which stores the
+// pending change, and POST /approvals/{id}/reject, which discards it. The
+// author of a change cannot approve it, and changes hidden from a scoped user
+// are not found.
+func (s *Server) handleApproval(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/approvals/"), "/")
+	if id == "" || (action != "approve" && action != "reject") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, session, ok := s.authorizeTenant(w, r, auth.PermApprove)
+	if !ok {
+		return
+	}
+	if s.gate == nil {
+		writeError(w, http.StatusNotFound, "policy approval is not enabled")
+		return
+	}
+
+	if session.Scoped() {
+		changes, err := s.gate.Pending(ctx)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		i := slices.IndexFunc(changes, func(c storage.PendingChange) bool { return c.ID == id })
+		if i < 0 || !changeVisible(session, changes[i]) {
+			writeApprovalError(w, storage.ErrChangeNotFound)
+			return
+		}
+	}
+
+	if action == "reject" {
+		change, err := s.gate.Reject(ctx, id, session.Username)
+		if err != nil {
+			writeApprovalError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, change)
+		return
+	}
+	record, err := s.gate.Approve(ctx, id, session.Username)
+	if err != nil {
+		writeApprovalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, record)
+}
+
+func writeApprovalError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrChangeNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, approval.ErrSelfApproval), errors.Is(err, quota.ErrExceeded):
+		writeError(w, http.StatusForbidden, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ztap/pkg/approval"
+	"ztap/pkg/auth"
+	"ztap/pkg/storage"
+)
+
+func TestApprovalWorkflow(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	pending := storage.NewFilePendingStore(filepath.Join(t.TempDir(), "pending.json"))
+	s.RequireApproval(approval.NewGate(approval.Config{Rules: []approval.Rule{{Name: "postgres", Port: 5432}}}, s.policies, pending))
+
+	alice := login(t, am, "alice", auth.RoleAdmin)
+	bob := login(t, am, "bob", auth.RoleAdmin)
+	operator := login(t, am, "olivia", auth.RoleOperator)
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/web-to-db", alice, testPolicyYAML))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var change storage.PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&change); err != nil || change.ID == "" {
+		t.Fatalf("Unexpected pending change %+v, %v", change, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/policies/web-to-db", alice, ""))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected held policy not stored, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/approvals", operator, ""))
+	var changes []storage.PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&changes); err != nil || len(changes) != 1 || changes[0].ID != change.ID {
+		t.Errorf("Unexpected pending list %+v, %v", changes, err)
+	}
+
+	approve := "/approvals/" + change.ID + "/approve"
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"operator", operator, http.StatusForbidden},
+		{"author", alice, http.StatusForbidden},
+		{"second admin", bob, http.StatusOK},
+		{"already approved", bob, http.StatusNotFound},
+	} {
+		rec = httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, policyRequest(http.MethodPost, approve, tc.token, ""))
+		if rec.Code != tc.want {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.want, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/policies/web-to-db", alice, ""))
+	var record storage.PolicyRecord
+	if err := json.NewDecoder(rec.Body).Decode(&record); err != nil || record.UpdatedBy != "alice" {
+		t.Errorf("Expected approved policy stored for alice, got %+v, %v", record, err)
+	}
+
+	// Low-risk changes are stored directly
+	rec = httptest.NewRecorder()
+	body := strings.Replace(testPolicyYAML, "5432", "443", 1)
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/web-to-db", operator, body))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestApprovalReject(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	pending := storage.NewFilePendingStore(filepath.Join(t.TempDir(), "pending.json"))
+	s.RequireApproval(approval.NewGate(approval.Config{Rules: []approval.Rule{{Port: 5432}}}, s.policies, pending))
+	alice := login(t, am, "alice", auth.RoleAdmin)
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/web-to-db", alice, testPolicyYAML))
+	var change storage.PendingChange
+	json.NewDecoder(rec.Body).Decode(&change)
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPost, "/approvals/"+change.ID+"/reject", alice, ""))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if changes, _ := pending.ListPending(t.Context()); len(changes) != 0 {
+		t.Errorf("Expected no pending changes, got %+v", changes)
+	}
+}
+
+func TestApprovalsNotEnabled(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	admin := login(t, am, "alice", auth.RoleAdmin)
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/approvals", admin, ""))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+)
+
+// maxEnrollBody bounds the body of POST /enroll
+const maxEnrollBody = 64 << 10
+
+// EnableEnrollment lets nodes enroll with POST /enroll, presenting a join
+// token from tokens. An enrolled node receives its service account and a
+// client certificate issued by ca. Over TLS, node service accounts must
+// then present their node's certificate on every request.
+func (s *Server) EnableEnrollment(tokens *cluster.TokenStore, ca *cluster.CA) {
+	s.tokens, s.ca = tokens, ca
+}
+
+// handleEnroll serves POST /enroll. The join token is the only credential:
+// it is redeemed once the request is known to be valid, so a malformed
+// request does not use it up, and released again if enrollment then fails.
+func (s *Server) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	if s.tokens == nil {
+		writeError(w, http.StatusNotFound, "enrollment is not enabled")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req cluster.EnrollRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEnrollBody)).Decode(&req); err != nil || req.Token == "" {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := auth.ValidateNode(req.Node); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	csr, err := cluster.ParseNodeCSR([]byte(req.CSR), req.Node)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	token, err := s.tokens.Redeem(req.Token, req.Node)
+	if errors.Is(err, cluster.ErrTokenInvalid) || errors.Is(err, cluster.ErrTokenExpired) || errors.Is(err, cluster.ErrTokenUsed) {
+		log.Printf("Rejected enrollment of node %s from %s: %v", req.Node, r.RemoteAddr, err)
+		writeError(w, http.StatusUnauthorized, "invalid, expired or used join token")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	enrolled := false
+	defer func() {
+		if !enrolled {
+			if err := s.tokens.Release(token.ID, req.Node); err != nil {
+				log.Printf("Failed to release join token %s: %v", token.ID, err)
+			}
+		}
+	}()
+
+	secret, err := s.auth.ProvisionServiceAccount(req.Node, token.Labels, token.Tenant)
+	if errors.Is(err, auth.ErrUserExists) {
+		writeError(w, http.StatusConflict, req.Node+" names an existing user")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cert, expiresAt, err := s.ca.IssueNodeCertificate(csr, cluster.NodeCertTTL)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	enrolled = true
+
+	s.nodesMu.Lock()
+	s.nodes[token.Tenant+"/"+req.Node] = NodeStatus{
+		Node:       req.Node,
+		Tenant:     token.Tenant,
+		State:      "enrolled",
+		ReportedAt: time.Now().UTC(),
+	}
+	s.nodesMu.Unlock()
+	log.Printf("Node %s enrolled with join token %s", req.Node, token.ID)
+
+	writeJSON(w, http.StatusOK, cluster.EnrollResponse{
+		Node:           req.Node,
+		Tenant:         token.Tenant,
+		Labels:         token.Labels,
+		ServiceAccount: auth.ServiceAccountName(req.Node),
+		Secret:         secret,
+		Certificate:    string(cert),
+		CA:             string(s.ca.CertPEM()),
+		ExpiresAt:      expiresAt,
+	})
+}
+
+// nodeCertificateValid reports whether a request made with a node service
+// account's session may proceed: over TLS with enrollment enabled, it must
+// present a certificate issued to the session's node
+func (s *Server) nodeCertificateValid(r *http.Request, session *auth.Session) bool {
+	if s.ca == nil || r.TLS == nil || session.Node == "" {
+		return true
+	}
+	return len(r.TLS.VerifiedChains) > 0 && r.TLS.VerifiedChains[0][0].Subject.CommonName == session.Node
+}
+
+// ListenAndServeTLS serves the API over HTTPS on addr. With enrollment
+// enabled, clients may present certificates issued by the cluster CA.
+func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
+	log.Printf("Starting API server on %s (TLS)", addr)
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.ca != nil {
+		config.ClientCAs = s.ca.Pool()
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		TLSConfig:         config,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/events"
+	"ztap/pkg/storage"
+)
+
+func TestEnrollment(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	dir := t.TempDir()
+	ca, err := cluster.LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := cluster.NewTokenStore(filepath.Join(dir, "join-tokens.json"))
+	s.EnableEnrollment(tokens, ca)
+
+	srv := httptest.NewUnstartedServer(s.Handler())
+	srv.TLS = &tls.Config{ClientCAs: ca.Pool(), ClientAuth: tls.VerifyClientCertIfGiven}
+	srv.StartTLS()
+	defer srv.Close()
+	ctx := context.Background()
+
+	token, _, err := tokens.Create(cluster.TokenOptions{TTL: time.Hour, Labels: map[string]string{"app": "web"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cluster.Enroll(ctx, srv.Client(), srv.URL, "bogus.token", "web-1"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected a bogus token to be rejected with 401, got %v", err)
+	}
+	enrollment, err := cluster.Enroll(ctx, srv.Client(), srv.URL, token, "web-1")
+	if err != nil {
+		t.Fatalf("Enroll failed: %v", err)
+	}
+	if enrollment.ServiceAccount != "node:web-1" || enrollment.Labels["app"] != "web" || len(enrollment.Key) == 0 {
+		t.Errorf("Unexpected enrollment %+v", enrollment.EnrollResponse)
+	}
+	if _, err := cluster.Enroll(ctx, srv.Client(), srv.URL, token, "web-2"); err == nil {
+		t.Error("Expected a used token to be rejected")
+	}
+	if err := enrollment.Save(filepath.Join(dir, "node")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// The node logs in with its secret, and must present its certificate
+	session, err := am.Authenticate(enrollment.ServiceAccount, enrollment.Secret)
+	if err != nil {
+		t.Fatalf("Failed to authenticate the enrolled node: %v", err)
+	}
+	get := func(client *http.Client) int {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/nodes/web-1/policies", nil)
+		req.Header.Set("Authorization", "Bearer "+session.Token)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get(srv.Client()); code != http.StatusForbidden {
+		t.Errorf("Expected 403 without the node certificate, got %d", code)
+	}
+
+	cert, err := tls.X509KeyPair([]byte(enrollment.Certificate), enrollment.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := srv.Client()
+	transport := client.Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	client.Transport = transport
+	if code := get(client); code != http.StatusOK {
+		t.Errorf("Expected 200 with the node certificate, got %d", code)
+	}
+
+	// Another node's certificate does not match the session
+	other, _, err := tokens.Create(cluster.TokenOptions{TTL: time.Hour, Node: "db-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := cluster.Enroll(ctx, srv.Client(), srv.URL, other, "db-1")
+	if err != nil {
+		t.Fatalf("Enroll failed: %v", err)
+	}
+	dbCert, _ := tls.X509KeyPair([]byte(db.Certificate), db.Key)
+	transport.TLSClientConfig.Certificates = []tls.Certificate{dbCert}
+	transport.CloseIdleConnections()
+	if code := get(client); code != http.StatusForbidden {
+		t.Errorf("Expected 403 with another node's certificate, got %d", code)
+	}
+
+	// Enrolled nodes are listed before they report
+	admin := login(t, am, "ada", auth.RoleAdmin)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/nodes", admin, ""))
+	if !strings.Contains(rec.Body.String(), `"state":"enrolled"`) {
+		t.Errorf("Expected enrolled nodes in GET /nodes, got %s", rec.Body.String())
+	}
+}
+
+func TestEnrollmentDisabled(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/enroll", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without enrollment, got %d", rec.Code)
+	}
+}
+
+func TestEnrollmentFailureReleasesToken(t *testing.T) {
+	dir := t.TempDir()
+	// A user from before node service accounts were reserved holds db-1's name
+	users := `{"node:db-1":{"username":"node:db-1","password_hash":"x","role":"viewer","enabled":true}}`
+	if err := os.WriteFile(filepath.Join(dir, "users.json"), []byte(users), 0600); err != nil {
+		t.Fatal(err)
+	}
+	am, err := auth.NewAuthManager(filepath.Join(dir, "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(am, events.NewBus(), storage.NewFilePolicyStore(filepath.Join(dir, "policies.json")))
+	ca, err := cluster.LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := cluster.NewTokenStore(filepath.Join(dir, "join-tokens.json"))
+	s.EnableEnrollment(tokens, ca)
+	srv := httptest.NewTLSServer(s.Handler())
+	defer srv.Close()
+
+	token, created, err := tokens.Create(cluster.TokenOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := cluster.Enroll(ctx, srv.Client(), srv.URL, token, "db-1"); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Fatalf("Expected 409 enrolling over an existing user, got %v", err)
+	}
+	list, _ := tokens.List()
+	if len(list) != 1 || list[0].ID != created.ID || list[0].Used() {
+		t.Errorf("Expected the token released after the failed enrollment, got %+v", list)
+	}
+	if _, err := cluster.Enroll(ctx, srv.Client(), srv.URL, token, "db-2"); err != nil {
+		t.Errorf("Expected the released token to enroll another node: %v", err)
+	}
+}
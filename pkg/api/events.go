@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/events"
+)
+
+// topicPermissions maps each event topic to the permission needed to receive it
+var topicPermissions = map[events.Topic]auth.Permission{
+	events.TopicServiceChanged:  auth.PermViewStatus,
+	events.TopicPolicyApplied:   auth.PermViewPolicies,
+	events.TopicLeaderChanged:   auth.PermViewStatus,
+	events.TopicFlowBlocked:     auth.PermViewLogs,
+	events.TopicAnomalyDetected: auth.PermViewMetrics,
+	events.TopicShrinkHeld:      auth.PermViewPolicies,
+	events.TopicPolicyExpired:   auth.PermViewPolicies,
+	events.TopicPolicyApproval:  auth.PermViewPolicies,
+	events.TopicBreakGlass:      auth.PermViewStatus,
+	events.TopicMaintenance:     auth.PermViewStatus,
+	events.TopicPolicyStale:     auth.PermViewStatus,
+	events.TopicSecretRotated:   auth.PermViewStatus,
+}
+
+// handleEvents streams events as Server-Sent Events. Clients choose topics
+// with ?topics=a,b; by default every topic the caller may view is streamed.
+// Requesting a topic without permission is rejected with 403. Users of a
+// tenant only receive their tenant's events; users without a tenant receive
+// every event, or one tenant's with ?tenant=. Users limited by scopes only
+// receive the events of their workloads and policies (see eventVisible).
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	token, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	session, err := s.auth.ValidateSession(token)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	tenant, ok := s.tenant(w, r, session)
+	if !ok {
+		return
+	}
+
+	topics, err := s.allowedTopics(token, r.URL.Query().Get("topics"))
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if len(topics) == 0 {
+		writeError(w, http.StatusForbidden, "no viewable event topics")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	// The policies a scoped session sees, refreshed with every heartbeat
+	ctx := auth.WithTenant(r.Context(), tenant)
+	var visible map[string]bool
+	if session.Scoped() {
+		if visible, err = s.visiblePolicies(ctx, session); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	stream := s.bus.Subscribe(r.Context(), topics...)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-stream:
+			if !ok {
+				return
+			}
+			if tenant != "" && event.Tenant != tenant {
+				continue
+			}
+			if !s.eventVisible(session, event, visible) {
+				continue
+			}
+			// Sessions can expire or be revoked while streaming
+			if _, err := s.auth.ValidateSession(token); err != nil {
+				return
+			}
+			if err := writeSSE(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			// Also checked here so an idle stream ends once its session does
+			if _, err := s.auth.ValidateSession(token); err != nil {
+				return
+			}
+			if session.Scoped() {
+				if policies, err := s.visiblePolicies(ctx, session); err == nil {
+					visible = policies
+				}
+			}
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// allowedTopics resolves the requested topic list against the caller's permissions
+func (s *Server) allowedTopics(token, requested string) ([]events.Topic, error) {
+	if requested == "" {
+		var topics []events.Topic
+		for _, topic := range events.Topics {
+			if s.auth.HasPermission(token, topicPermissions[topic]) == nil {
+				topics = append(topics, topic)
+			}
+		}
+		return topics, nil
+	}
+
+	var topics []events.Topic
+	for _, name := range strings.Split(requested, ",") {
+		topic := events.Topic(strings.TrimSpace(name))
+		perm, known := topicPermissions[topic]
+		if !known {
+			return nil, fmt.Errorf("unknown topic %q", topic)
+		}
+		if err := s.auth.HasPermission(token, perm); err != nil {
+			return nil, fmt.Errorf("permission %s required for topic %s", perm, topic)
+		}
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
+
+// writeSSE writes one event in text/event-stream format
+func writeSSE(w http.ResponseWriter, event events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Topic, data)
+	return err
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/events"
+)
+
+// readSSE reads the next event from an SSE stream, skipping comments
+func readSSE(t *testing.T, r *bufio.Reader) (topic string, data string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			topic = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && topic != "":
+			return topic, data
+		}
+	}
+}
+
+// openStream opens an SSE stream that is closed when the test ends
+func openStream(t *testing.T, srv *httptest.Server, query string) *http.Response {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events"+query, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestEventsStream(t *testing.T) {
+	s, am, bus := newTestServer(t)
+	token := login(t, am, "viewer", auth.RoleViewer)
+
+	// Registered before the stream so the stream is cancelled first
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+
+	resp := openStream(t, srv, "?token="+token+"&topics=flow_blocked")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %s", ct)
+	}
+
+	// Headers are flushed after subscribing, so published events are delivered
+	bus.Publish(events.TopicPolicyApplied, events.PolicyApplied{Policy: "ignored"})
+	bus.Publish(events.TopicFlowBlocked, events.FlowBlocked{SourceIP: "10.0.0.1", DestIP: "10.0.0.2", Port: 22, Protocol: "TCP"})
+
+	topic, data := readSSE(t, bufio.NewReader(resp.Body))
+	if topic != string(events.TopicFlowBlocked) {
+		t.Fatalf("Expected flow_blocked, got %s", topic)
+	}
+
+	var event struct {
+		Topic string             `json:"topic"`
+		Data  events.FlowBlocked `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		t.Fatalf("Failed to decode event: %v", err)
+	}
+	if event.Data.DestIP != "10.0.0.2" || event.Data.Port != 22 {
+		t.Errorf("Unexpected payload: %+v", event.Data)
+	}
+}
+
+func TestEventsAuthorization(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	token := login(t, am, "viewer", auth.RoleViewer)
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"invalid token", "?token=bogus", http.StatusUnauthorized},
+		{"unknown topic", "?token=" + token + "&topics=nope", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events"+tt.query, nil))
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAllowedTopics(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	token := login(t, am, "viewer", auth.RoleViewer)
+
+	topics, err := s.allowedTopics(token, "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(topics) != len(events.Topics) {
+		t.Errorf("Expected viewer to see all %d topics, got %v", len(events.Topics), topics)
+	}
+
+	topics, err = s.allowedTopics(token, "flow_blocked, anomaly_detected")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(topics) != 2 || topics[1] != events.TopicAnomalyDetected {
+		t.Errorf("Unexpected topics: %v", topics)
+	}
+
+	if _, err := s.allowedTopics("bogus", "flow_blocked"); err == nil {
+		t.Error("Expected error for session without permission")
+	}
+}
+
+func TestEventsHeartbeat(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	s.heartbeat = 10 * time.Millisecond
+	token := login(t, am, "viewer", auth.RoleViewer)
+
+	// Registered before the stream so the stream is cancelled first
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+
+	resp := openStream(t, srv, "?token="+token)
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+	if !strings.HasPrefix(line, ":") {
+		t.Errorf("Expected keep-alive comment, got %q", line)
+	}
+}
+
+func TestEventsHeartbeatEndsRevokedSession(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	s.heartbeat = 10 * time.Millisecond
+	token := login(t, am, "viewer", auth.RoleViewer)
+
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+
+	resp := openStream(t, srv, "?token="+token)
+	reader := bufio.NewReader(resp.Body)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+
+	// No events are published: the heartbeat alone must end the stream
+	if err := am.Logout(token); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, reader)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected stream to end cleanly, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stream stayed open after the session was revoked")
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/stats"
+)
+
+// Heatmap defaults: the last day in 5-minute columns, the 20 busiest rows
+const (
+	defaultHeatmapSince   = 24 * time.Hour
+	defaultHeatmapColumns = 288
+	maxHeatmapColumns     = 2000
+	defaultHeatmapTop     = 20
+)
+
+// EnableStats serves GET /stats/heatmap from the statistics file at path,
+// written by the stats.Recorder of the ztap processes of the host
+func (s *Server) EnableStats(path string) {
+	s.statsPath = path
+}
+
+// handleHeatmap serves GET /stats/heatmap: blocked flows per policy, or
+// anomaly scores per service, in time columns for dashboard charts (see
+// stats.BuildHeatmap). Statistics are not kept by tenant: sessions acting in
+// a tenant or limited by scopes only see the blocks of the policies they
+// may see, and no anomalies.
+func (s *Server) handleHeatmap(w http.ResponseWriter, r *http.Request) {
+	if s.statsPath == "" {
+		writeError(w, http.StatusNotFound, "statistics are not enabled")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, session, ok := s.authorizeTenant(w, r, auth.PermViewMetrics)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = stats.MetricBlocks
+	}
+	until := time.Now()
+	if v := query.Get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "until must be an RFC 3339 time")
+			return
+		}
+		until = t
+	}
+	opts := stats.HeatmapOptions{Until: until, Since: until.Add(-defaultHeatmapSince), MaxColumns: defaultHeatmapColumns, Top: defaultHeatmapTop}
+	if v := query.Get("since"); v != "" {
+		period, err := stats.ParseSince(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts.Since = until.Add(-period)
+	}
+	if v := query.Get("step"); v != "" {
+		step, err := time.ParseDuration(v)
+		if err != nil || step <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid step "+strconv.Quote(v))
+			return
+		}
+		opts.Step = step
+	}
+	for name, field := range map[string]*int{"columns": &opts.MaxColumns, "top": &opts.Top} {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, "invalid "+name+" "+strconv.Quote(v))
+				return
+			}
+			*field = n
+		}
+	}
+	if opts.MaxColumns == 0 || opts.MaxColumns > maxHeatmapColumns {
+		opts.MaxColumns = maxHeatmapColumns
+	}
+
+	if auth.TenantFromContext(ctx) != "" || session.Scoped() {
+		if metric == stats.MetricAnomalies {
+			writeError(w, http.StatusForbidden, "anomaly heatmaps are not available in a tenant or to scoped users")
+			return
+		}
+		visible, err := s.visiblePolicies(ctx, session)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		opts.Include = func(name string) bool { return visible[name] }
+	}
+
+	buckets, err := stats.Load(s.statsPath)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	heatmap, err := stats.BuildHeatmap(buckets, metric, opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, heatmap)
+}
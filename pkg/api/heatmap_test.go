@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/stats"
+)
+
+func getHeatmap(t *testing.T, s *Server, token, query string) (int, stats.Heatmap) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/stats/heatmap"+query, token, ""))
+	var heatmap stats.Heatmap
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&heatmap); err != nil {
+			t.Fatalf("Failed to decode heatmap: %v", err)
+		}
+	}
+	return rec.Code, heatmap
+}
+
+func TestHeatmap(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	admin := login(t, am, "alice", auth.RoleAdmin)
+	if code, _ := getHeatmap(t, s, admin, ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 without statistics, got %d", code)
+	}
+
+	path := filepath.Join(t.TempDir(), "stats.json")
+	recorder := stats.NewRecorder(path)
+	recorder.RecordFlows("web-to-db", false, "10.0.0.3", 5432, 5)
+	recorder.RecordFlow("deny-ssh", false, "10.0.0.4", 22)
+	recorder.RecordAnomalyScore("web", 0.8)
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	s.EnableStats(path)
+
+	code, heatmap := getHeatmap(t, s, admin, "")
+	if code != http.StatusOK || heatmap.Step != 300 || len(heatmap.Columns) < 288 {
+		t.Fatalf("Expected a day in 5-minute columns, got %d, %d columns of %ds", code, len(heatmap.Columns), heatmap.Step)
+	}
+	if len(heatmap.Rows) != 2 || heatmap.Rows[0].Name != "web-to-db" || heatmap.Rows[0].Total != 5 {
+		t.Errorf("Unexpected rows %+v", heatmap.Rows)
+	}
+
+	code, heatmap = getHeatmap(t, s, admin, "?metric=anomalies&since=7d&top=1")
+	if code != http.StatusOK || heatmap.Step != 3600 || len(heatmap.Rows) != 1 || heatmap.Rows[0].Total != 0.8 {
+		t.Errorf("Expected a week of hourly anomaly columns, got %d %+v", code, heatmap)
+	}
+
+	for _, query := range []string{"?metric=latency", "?since=soon", "?step=-5m", "?top=x", "?until=yesterday"} {
+		if code, _ := getHeatmap(t, s, admin, query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, code)
+		}
+	}
+
+	// Tenant users only see the blocks of their policies
+	ctx := auth.WithTenant(t.Context(), "acme")
+	if _, err := s.policies.PutPolicy(ctx, "web-to-db", testPolicyYAML, "alice"); err != nil {
+		t.Fatalf("PutPolicy failed: %v", err)
+	}
+	tenant := loginTenant(t, am, "tom", auth.RoleViewer, "acme")
+	code, heatmap = getHeatmap(t, s, tenant, "")
+	if code != http.StatusOK || len(heatmap.Rows) != 1 || heatmap.Rows[0].Name != "web-to-db" {
+		t.Errorf("Expected only web-to-db in the tenant, got %d %+v", code, heatmap.Rows)
+	}
+	if code, _ := getHeatmap(t, s, tenant, "?metric=anomalies"); code != http.StatusForbidden {
+		t.Errorf("Expected 403 for anomalies in a tenant, got %d", code)
+	}
+}
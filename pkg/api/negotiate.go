@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ztap/pkg/policy"
+	"ztap/pkg/storage"
+)
+
+// Headers (or ?schema= and ?features=) through which an agent fetching its
+// policies advertises the policy apiVersion and schema features it
+// understands
+const (
+	policySchemaHeader   = "X-ZTAP-Policy-Schema"
+	policyFeaturesHeader = "X-ZTAP-Policy-Features"
+	// withheldHeader lists the policies withheld from the response
+	withheldHeader = "X-ZTAP-Policies-Withheld"
+)
+
+// policyPeer returns what the client of GET /nodes/{id}/policies advertises
+// it understands, matched against the node's labels. ok is false if it
+// advertises nothing, e.g. an agent predating negotiation, which receives
+// policies as they are stored.
+func policyPeer(r *http.Request, labels map[string]string) (peer policy.Peer, ok bool, err error) {
+	schema := r.Header.Get(policySchemaHeader)
+	if schema == "" {
+		schema = r.URL.Query().Get("schema")
+	}
+	if schema == "" {
+		return policy.Peer{}, false, nil
+	}
+	if peer.Schema, err = strconv.Atoi(schema); err != nil || peer.Schema < 1 {
+		return policy.Peer{}, false, fmt.Errorf("policy schema must be a positive integer")
+	}
+
+	features := r.Header.Get(policyFeaturesHeader)
+	if features == "" {
+		features = r.URL.Query().Get("features")
+	}
+	for _, feature := range strings.Split(features, ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			peer.Features = append(peer.Features, feature)
+		}
+	}
+	peer.Labels = labels
+	return peer, true, nil
+}
+
+// negotiate rewrites records for peer (see policy.Downgrade). A record with a
+// policy the peer cannot understand is withheld as a whole, with the reason,
+// by record name. Policies whose nodeSelector does not match the peer are
+// left out for a peer that does not understand nodeSelectors, as it would
+// enforce them.
+func negotiate(records []storage.PolicyRecord, peer policy.Peer) ([]storage.PolicyRecord, map[string]string) {
+	negotiated := make([]storage.PolicyRecord, 0, len(records))
+	withheld := make(map[string]string)
+
+	for _, record := range records {
+		policies, err := policy.Parse([]byte(record.YAML))
+		if err != nil {
+			withheld[record.Name] = err.Error()
+			continue
+		}
+
+		var rewritten []policy.NetworkPolicy
+		changed := false
+		for _, p := range policies {
+			if len(p.Spec.NodeSelector) > 0 && !peer.Supports(policy.FeatureNodeSelector) && !p.SelectsNode(peer.Labels) {
+				changed = true
+				continue
+			}
+			downgraded, features, err := policy.Downgrade(p, peer)
+			var incompatible *policy.IncompatibleError
+			if errors.As(err, &incompatible) {
+				withheld[record.Name] = incompatible.Error()
+				break
+			}
+			changed = changed || len(features) > 0
+			rewritten = append(rewritten, downgraded)
+		}
+		if _, ok := withheld[record.Name]; ok {
+			continue
+		}
+
+		if changed {
+			data, err := policy.Marshal(rewritten)
+			if err != nil {
+				withheld[record.Name] = err.Error()
+				continue
+			}
+			record.YAML = string(data)
+		}
+		negotiated = append(negotiated, record)
+	}
+	return negotiated, withheld
+}
+
+// withheldNames returns the names of withheld records, sorted
+func withheldNames(withheld map[string]string) string {
+	names := make([]string, 0, len(withheld))
+	for name := range withheld {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
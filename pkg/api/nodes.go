@@ -0,0 +1,227 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/policy"
+	"ztap/pkg/storage"
+)
+
+// NodeStatus is what an agent reports with POST /nodes/{id}/status
+type NodeStatus struct {
+	Node       string    `json:"node"`
+	Tenant     string    `json:"tenant,omitempty"`
+	State      string    `json:"state"` // e.g. healthy or degraded
+	Version    string    `json:"version,omitempty"`
+	Policies   int       `json:"policies"`        // Policies enforced
+	Error      string    `json:"error,omitempty"` // Last enforcement error
+	ReportedAt time.Time `json:"reported_at"`
+	// Withheld are the policies the node does not understand, with the
+	// reason: reported by the node, and found when it fetched its policies
+	Withheld map[string]string `json:"withheld,omitempty"`
+}
+
+// handleNodes serves GET /nodes, listing the last status reported by each
+// node of the request's tenant (every node for admins without a tenant and
+// no ?tenant=), with the policies withheld from it
+func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, _, ok := s.authorizeTenant(w, r, auth.PermViewStatus)
+	if !ok {
+		return
+	}
+	tenant := auth.TenantFromContext(ctx)
+
+	s.nodesMu.Lock()
+	statuses := make([]NodeStatus, 0, len(s.nodes))
+	for key, status := range s.nodes {
+		if tenant == "" || status.Tenant == tenant {
+			status.Withheld = mergeWithheld(status.Withheld, s.withheld[key])
+			statuses = append(statuses, status)
+		}
+	}
+	// Nodes withholding policies are listed even before their first report
+	for key, withheld := range s.withheld {
+		nodeTenant, node, _ := strings.Cut(key, "/")
+		if _, ok := s.nodes[key]; ok || len(withheld) == 0 || (tenant != "" && nodeTenant != tenant) {
+			continue
+		}
+		statuses = append(statuses, NodeStatus{Node: node, Tenant: nodeTenant, State: "unreported", Withheld: withheld})
+	}
+	s.nodesMu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Node < statuses[j].Node })
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleNode serves POST /nodes/{id}/status, through which a node's service
+// account reports its status, and GET /nodes/{id}/policies, returning the
+// policies distributed to the node. A service account
+// can only act for its own node; the labels are those it was provisioned
+// with, so a node cannot widen what it reads.
+func (s *Server) handleNode(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/nodes/"), "/")
+	if id == "" || (action != "status" && action != "policies") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch {
+	case action == "status" && r.Method == http.MethodPost:
+		session, ok := s.authorize(w, r, auth.PermReportStatus)
+		if !ok {
+			return
+		}
+		if session.Node != id {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("%s cannot act for node %s", session.Username, id))
+			return
+		}
+		var status NodeStatus
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil || status.State == "" {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		status.Node, status.Tenant, status.ReportedAt = id, session.Tenant, time.Now().UTC()
+
+		s.nodesMu.Lock()
+		s.nodes[session.Tenant+"/"+id] = status
+		s.nodesMu.Unlock()
+		writeJSON(w, http.StatusOK, status)
+
+	case action == "policies" && r.Method == http.MethodGet:
+		s.handleNodePolicies(w, r, id)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleNodePolicies serves GET /nodes/{id}/policies. Node service accounts
+// need PermFetchNodePolicies and read their own node's policies; users need
+// PermViewPolicies and access to the tenant of the node's account, and see
+// only the policies within their scopes (see documentVisible). A client
+// advertising the policy schema it understands receives the policies
+// negotiated for it; those withheld are named in the X-ZTAP-Policies-Withheld
+// header and, for the node's own fetches, listed in its GET /nodes status.
+func (s *Server) handleNodePolicies(w http.ResponseWriter, r *http.Request, id string) {
+	token, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	session, err := s.auth.ValidateSession(token)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	var tenant string
+	var labels map[string]string
+	if session.Node != "" {
+		if err := s.auth.HasPermission(token, auth.PermFetchNodePolicies); err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if session.Node != id {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("%s cannot act for node %s", session.Username, id))
+			return
+		}
+		tenant, labels = session.Tenant, session.Labels
+	} else {
+		if err := s.auth.HasPermission(token, auth.PermViewPolicies); err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		account, err := s.auth.ServiceAccount(id)
+		if errors.Is(err, auth.ErrUserNotFound) {
+			writeError(w, http.StatusNotFound, "node "+id+" has no service account")
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if tenant, err = session.ResolveTenant(account.Tenant); err != nil {
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		labels = account.Labels
+	}
+
+	records, err := s.policies.ListPolicies(auth.WithTenant(r.Context(), tenant))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	selected := []storage.PolicyRecord{}
+	for _, record := range records {
+		if selectsNode(record, labels) && documentVisible(session, record) {
+			selected = append(selected, record)
+		}
+	}
+
+	peer, negotiated, err := policyPeer(r, labels)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if negotiated {
+		var withheld map[string]string
+		selected, withheld = negotiate(selected, peer)
+		if session.Node != "" {
+			s.nodesMu.Lock()
+			s.withheld[tenant+"/"+id] = withheld
+			s.nodesMu.Unlock()
+		}
+		if len(withheld) > 0 {
+			w.Header().Set(withheldHeader, withheldNames(withheld))
+		}
+	}
+	writeJSON(w, http.StatusOK, selected)
+}
+
+// selectsNode reports whether a policy in record is distributed to a node
+// with labels: a policy with a nodeSelector when it matches the labels, one
+// without when its podSelector does. Nodes without labels are selected by
+// nothing, as every selector has a label.
+func selectsNode(record storage.PolicyRecord, labels map[string]string) bool {
+	if len(labels) == 0 {
+		return false
+	}
+	policies, err := policy.Parse([]byte(record.YAML))
+	if err != nil {
+		return false
+	}
+	for i := range policies {
+		p := &policies[i]
+		if len(p.Spec.NodeSelector) > 0 && p.SelectsNode(labels) || len(p.Spec.NodeSelector) == 0 && p.Selects(labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeWithheld returns the policies withheld from a node as it reported them
+// and as found when it last fetched its policies
+func mergeWithheld(reported, fetched map[string]string) map[string]string {
+	if len(fetched) == 0 {
+		return reported
+	}
+	merged := make(map[string]string, len(reported)+len(fetched))
+	for name, reason := range reported {
+		merged[name] = reason
+	}
+	for name, reason := range fetched {
+		merged[name] = reason
+	}
+	return merged
+}
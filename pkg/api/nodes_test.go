@@ -0,0 +1,182 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/storage"
+)
+
+// loginNode provisions the service account of node and returns its token
+func loginNode(t *testing.T, am *auth.AuthManager, node string, labels map[string]string) string {
+	t.Helper()
+	secret, err := am.ProvisionServiceAccount(node, labels, "")
+	if err != nil {
+		t.Fatalf("Failed to provision service account: %v", err)
+	}
+	session, err := am.Authenticate(auth.ServiceAccountName(node), secret)
+	if err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	return session.Token
+}
+
+func TestNodeServiceAccounts(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	operator := login(t, am, "olivia", auth.RoleOperator)
+	web := loginNode(t, am, "web-1", map[string]string{"app": "web"})
+	loginNode(t, am, "db-1", map[string]string{"app": "db"})
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, r)
+		return rec
+	}
+	if rec := serve(policyRequest(http.MethodPut, "/policies/web-to-db", operator, testPolicyYAML)); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	dbPolicy := strings.NewReplacer("web-to-db", "db-egress", "app: web", "app: db").Replace(testPolicyYAML)
+	if rec := serve(policyRequest(http.MethodPut, "/policies/db-egress", operator, dbPolicy)); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A node fetches the policies selecting its own labels only
+	rec := serve(policyRequest(http.MethodGet, "/nodes/web-1/policies", web, ""))
+	var records []storage.PolicyRecord
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil {
+		t.Fatalf("Failed to decode policies: %v (%d)", err, rec.Code)
+	}
+	if len(records) != 1 || records[0].Name != "web-to-db" {
+		t.Errorf("Expected web-to-db only, got %+v", records)
+	}
+
+	// ...and cannot read other nodes' assignments or the policy store, nor
+	// change policies
+	for _, r := range []*http.Request{
+		policyRequest(http.MethodGet, "/nodes/db-1/policies", web, ""),
+		policyRequest(http.MethodPost, "/nodes/db-1/status", web, `{"state":"healthy"}`),
+		policyRequest(http.MethodGet, "/policies", web, ""),
+		policyRequest(http.MethodGet, "/policies/db-egress", web, ""),
+		policyRequest(http.MethodPut, "/policies/db-egress", web, testPolicyYAML),
+	} {
+		if rec := serve(r); rec.Code != http.StatusForbidden {
+			t.Errorf("%s %s: expected 403, got %d", r.Method, r.URL.Path, rec.Code)
+		}
+	}
+
+	// Users with view_policies can look up any node's assignments
+	rec = serve(policyRequest(http.MethodGet, "/nodes/db-1/policies", operator, ""))
+	records = nil
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil || len(records) != 1 || records[0].Name != "db-egress" {
+		t.Errorf("Expected db-egress for db-1, got %+v (%v)", records, err)
+	}
+	if rec := serve(policyRequest(http.MethodGet, "/nodes/unknown/policies", operator, "")); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a node without account, got %d", rec.Code)
+	}
+
+	// Status reports are listed for users with view_status
+	if rec := serve(policyRequest(http.MethodPost, "/nodes/web-1/status", web, `{"state":"healthy","policies":1}`)); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := serve(policyRequest(http.MethodPost, "/nodes/web-1/status", operator, `{"state":"healthy"}`)); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected users not to report node status, got %d", rec.Code)
+	}
+	rec = serve(policyRequest(http.MethodGet, "/nodes", operator, ""))
+	var statuses []NodeStatus
+	if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil {
+		t.Fatalf("Failed to decode statuses: %v (%d)", err, rec.Code)
+	}
+	if len(statuses) != 1 || statuses[0].Node != "web-1" || statuses[0].Policies != 1 {
+		t.Errorf("Unexpected statuses %+v", statuses)
+	}
+}
+
+func TestNodePoliciesNodeSelector(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	operator := login(t, am, "olivia", auth.RoleOperator)
+	web := loginNode(t, am, "web-1", map[string]string{"app": "web"})
+	edge := loginNode(t, am, "edge-1", map[string]string{"role": "edge"})
+
+	// web-to-db selects app=web workloads, but is only distributed to edge nodes
+	edgePolicy := strings.Replace(testPolicyYAML, "  egress:", "  nodeSelector:\n    role: edge\n  egress:", 1)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/web-to-db", operator, edgePolicy))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for _, tt := range []struct {
+		node, token string
+		want        int
+	}{
+		{"web-1", web, 0},
+		{"edge-1", edge, 1},
+	} {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/nodes/"+tt.node+"/policies", tt.token, ""))
+		var records []storage.PolicyRecord
+		if err := json.NewDecoder(rec.Body).Decode(&records); err != nil || len(records) != tt.want {
+			t.Errorf("%s: expected %d policies, got %+v (%v)", tt.node, tt.want, records, err)
+		}
+	}
+}
+
+func TestNodePoliciesVersionSkew(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	operator := login(t, am, "olivia", auth.RoleOperator)
+	edge := loginNode(t, am, "edge-1", map[string]string{"app": "web", "role": "edge"})
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, r)
+		return rec
+	}
+	edgePolicy := strings.Replace(testPolicyYAML, "  egress:", "  nodeSelector:\n    role: edge\n  egress:", 1)
+	expiring := strings.NewReplacer("web-to-db", "temp-access", "metadata:\n", "metadata:\n  expiresAt: \"2099-01-01T00:00:00Z\"\n").Replace(testPolicyYAML)
+	for name, yaml := range map[string]string{"web-to-db": edgePolicy, "temp-access": expiring} {
+		if rec := serve(policyRequest(http.MethodPut, "/policies/"+name, operator, yaml)); rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	// An agent predating negotiation receives policies as they are stored
+	rec := serve(policyRequest(http.MethodGet, "/nodes/edge-1/policies", edge, ""))
+	var records []storage.PolicyRecord
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil || len(records) != 2 {
+		t.Fatalf("Expected 2 policies, got %+v (%v)", records, err)
+	}
+
+	// An agent without expiry or nodeSelector support gets web-to-db without
+	// its nodeSelector, and temp-access is withheld
+	r := policyRequest(http.MethodGet, "/nodes/edge-1/policies", edge, "")
+	r.Header.Set(policySchemaHeader, "1")
+	r.Header.Set(policyFeaturesHeader, "endpoint-groups")
+	rec = serve(r)
+	records = nil
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil || len(records) != 1 || records[0].Name != "web-to-db" {
+		t.Fatalf("Expected web-to-db only, got %+v (%v)", records, err)
+	}
+	if strings.Contains(records[0].YAML, "nodeSelector") {
+		t.Errorf("Expected nodeSelector to be dropped, got:\n%s", records[0].YAML)
+	}
+	if got := rec.Header().Get(withheldHeader); got != "temp-access" {
+		t.Errorf("Expected temp-access to be withheld, got %q", got)
+	}
+
+	rec = serve(policyRequest(http.MethodGet, "/nodes", operator, ""))
+	var statuses []NodeStatus
+	if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil {
+		t.Fatalf("Failed to decode statuses: %v (%d)", err, rec.Code)
+	}
+	if len(statuses) != 1 || !strings.Contains(statuses[0].Withheld["temp-access"], "expiry") {
+		t.Errorf("Expected temp-access withheld from edge-1, got %+v", statuses)
+	}
+
+	if rec := serve(policyRequest(http.MethodGet, "/nodes/edge-1/policies?schema=v1", edge, "")); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid schema, got %d", rec.Code)
+	}
+}
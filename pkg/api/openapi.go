@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPIVersion is the version of the API in its OpenAPI document; bump it
+// when a change breaks existing clients
+const openAPIVersion = "1.0.0"
+
+// OpenAPI returns the OpenAPI 3 document describing Routes, as indented
+// JSON. Schemas are derived from the Go types of the request and response
+// bodies, following their json tags; each Go struct becomes a component
+// schema recording its Go type in x-go-type.
+func OpenAPI() ([]byte, error) {
+	schemas := make(map[string]any)
+	paths := make(map[string]map[string]any)
+	for _, route := range Routes {
+		item, ok := paths[route.Path]
+		if !ok {
+			item = make(map[string]any)
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation(route, schemas)
+	}
+
+	schemaOf(reflect.TypeOf(ErrorResponse{}), schemas)
+	doc := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "ZTAP API",
+			"version":     openAPIVersion,
+			"description": "API of the ZTAP server ('ztap serve'): sessions, policies, approvals, users, nodes and the live event stream.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// handleOpenAPI serves GET /openapi.json
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	doc, err := OpenAPI()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
+
+// PathParams returns the names of the parameters in a path template, in
+// order, e.g. [id] for /approvals/{id}/approve
+func PathParams(path string) []string {
+	var params []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, strings.Trim(segment, "{}"))
+		}
+	}
+	return params
+}
+
+// operation returns the OpenAPI operation of route, adding the schemas of
+// its bodies to schemas
+func operation(route Route, schemas map[string]any) map[string]any {
+	op := map[string]any{
+		"operationId": route.Operation,
+		"summary":     route.Summary,
+	}
+
+	var params []any
+	for _, name := range PathParams(route.Path) {
+		params = append(params, map[string]any{
+			"name": name, "in": "path", "required": true,
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+	if route.Tenant {
+		params = append(params,
+			map[string]any{
+				"name": tenantHeader, "in": "header",
+				"description": "Tenant to act in, for users without a tenant of their own",
+				"schema":      map[string]any{"type": "string"},
+			},
+			map[string]any{
+				"name": "tenant", "in": "query",
+				"description": "Tenant to act in, like the " + tenantHeader + " header",
+				"schema":      map[string]any{"type": "string"},
+			})
+	}
+	for _, p := range route.Query {
+		params = append(params, map[string]any{
+			"name": p.Name, "in": "query", "description": p.Description,
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if route.Body != nil {
+		contentType, schema := "application/json", schemaOf(reflect.TypeOf(route.Body), schemas)
+		if route.BodyType != "" {
+			contentType = route.BodyType
+		}
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{contentType: map[string]any{"schema": schema}},
+		}
+	}
+
+	responses := make(map[string]any)
+	for _, resp := range route.Responses {
+		r := map[string]any{"description": resp.Description}
+		switch {
+		case route.Stream:
+			r["content"] = map[string]any{"text/event-stream": map[string]any{"schema": map[string]any{"type": "string"}}}
+		case resp.Body != nil:
+			r["content"] = map[string]any{"application/json": map[string]any{"schema": schemaOf(reflect.TypeOf(resp.Body), schemas)}}
+		}
+		responses[strconv.Itoa(resp.Status)] = r
+	}
+	errorStatuses := route.Errors
+	if route.Permission != "" || route.Authenticated {
+		op["security"] = []any{map[string]any{"bearer": []any{}}}
+		errorStatuses = append([]int{http.StatusUnauthorized, http.StatusForbidden}, errorStatuses...)
+	}
+	if route.Permission != "" {
+		op["x-ztap-permission"] = string(route.Permission)
+	}
+	for _, status := range errorStatuses {
+		responses[strconv.Itoa(status)] = map[string]any{
+			"description": http.StatusText(status),
+			"content": map[string]any{"application/json": map[string]any{
+				"schema": map[string]any{"$ref": "#/components/schemas/ErrorResponse"},
+			}},
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the JSON schema of t: a reference for named structs,
+// whose schemas are added to schemas, and an inline schema otherwise
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := schemaOf(t.Elem(), schemas)
+		if _, ref := schema["$ref"]; !ref {
+			schema["nullable"] = true
+		}
+		return schema
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = nil // Guards recursive types
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// structSchema returns the object schema of the JSON fields of struct t.
+// Fields without omitempty are required.
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = schemaOf(f.Type, schemas)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	if t.Name() != "" {
+		schema["x-go-type"] = t.String()
+		schema["x-go-package"] = t.PkgPath()
+	}
+	return schema
+}
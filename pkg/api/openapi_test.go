@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoutesAreServed(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	for _, route := range Routes {
+		path := route.Path
+		for _, param := range PathParams(route.Path) {
+			path = strings.Replace(path, "{"+param+"}", "x", 1)
+		}
+		req := httptest.NewRequest(route.Method, path, nil)
+		if _, pattern := s.mux.Handler(req); pattern == "" {
+			t.Errorf("%s %s is described but not served", route.Method, route.Path)
+			continue
+		}
+		if route.Stream {
+			continue
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		if rec.Code == http.StatusMethodNotAllowed {
+			t.Errorf("%s %s is described but not allowed", route.Method, route.Path)
+		}
+	}
+}
+
+func TestOpenAPIEndpoint(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	var doc struct {
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("Failed to decode document: %v", err)
+	}
+	put := doc.Paths["/policies/{name}"]["put"]
+	if put["operationId"] != "putPolicy" || put["x-ztap-permission"] != "enforce" {
+		t.Errorf("Unexpected PUT /policies/{name} operation %+v", put)
+	}
+	if _, ok := put["responses"].(map[string]any)["202"]; !ok {
+		t.Error("Expected the 202 of a change awaiting approval documented")
+	}
+	record := doc.Components.Schemas["PolicyRecord"]
+	if record["x-go-type"] != "storage.PolicyRecord" || record["properties"] == nil {
+		t.Errorf("Unexpected PolicyRecord schema %+v", record)
+	}
+	if doc.Components.Schemas["ErrorResponse"]["properties"] == nil {
+		t.Error("Expected the ErrorResponse schema")
+	}
+}
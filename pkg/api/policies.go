@@ -0,0 +1,208 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/policy"
+	"ztap/pkg/quota"
+	"ztap/pkg/storage"
+)
+
+// maxPolicySize bounds PUT /policies/{name} bodies
+const maxPolicySize = 1 << 20
+
+// handlePolicies serves GET /policies, listing the policies stored for the
+// request's tenant, and for users limited by scopes only those selecting
+// their workloads. Every policy endpoint acts in one tenant (see tenant).
+func (s *Server) handlePolicies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, session, ok := s.authorizeTenant(w, r, auth.PermViewPolicies)
+	if !ok {
+		return
+	}
+
+	records, err := s.policies.ListPolicies(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	visible := []storage.PolicyRecord{}
+	for _, record := range records {
+		if documentVisible(session, record) {
+			visible = append(visible, record)
+		}
+	}
+	writeJSON(w, http.StatusOK, visible)
+}
+
+// handlePolicy serves GET, PUT and DELETE /policies/{name}. GET answers 404
+// for policies hidden from the user by its scopes. PUT takes the
+// policy YAML as its body and rejects documents that do not validate, and
+// with 409 those redefining a policy of another stored document. With
+// approval required, a high-risk PUT is held and answered with 202 and the
+// pending change. A PUT over the tenant's quotas is answered with 403, as
+// are PUTs and DELETEs by scoped users of documents, stored or new, that
+// select workloads outside their scopes.
+func (s *Server) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/policies/")
+	if name == "" || strings.Contains(name, "/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ctx, session, ok := s.authorizeTenant(w, r, auth.PermViewPolicies)
+		if !ok {
+			return
+		}
+		record, err := s.policies.GetPolicy(ctx, name)
+		if err == nil && !documentVisible(session, record) {
+			err = storage.ErrPolicyNotFound
+		}
+		if err != nil {
+			writePolicyError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, record)
+
+	case http.MethodPut:
+		ctx, session, ok := s.authorizeTenant(w, r, auth.PermEnforce)
+		if !ok {
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxPolicySize+1))
+		if err != nil || len(data) > maxPolicySize {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if err := validatePolicyYAML(data); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !s.checkWriteAllowed(ctx, w, session, name, string(data)) {
+			return
+		}
+		if err := s.checkCollisions(ctx, name, data); err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		record, pending, err := s.stagePolicy(ctx, name, string(data), session.Username)
+		switch {
+		case err != nil:
+			writePolicyError(w, err)
+		case pending != nil:
+			writeJSON(w, http.StatusAccepted, pending)
+		default:
+			writeJSON(w, http.StatusOK, record)
+		}
+
+	case http.MethodDelete:
+		ctx, session, ok := s.authorizeTenant(w, r, auth.PermEnforce)
+		if !ok || !s.checkWriteAllowed(ctx, w, session, name, "") {
+			return
+		}
+		if err := s.policies.DeletePolicy(ctx, name); err != nil {
+			writePolicyError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// checkWriteAllowed answers with 403 and returns false if session may not
+// write the policy document name (see writeAllowed)
+func (s *Server) checkWriteAllowed(ctx context.Context, w http.ResponseWriter, session *auth.Session, name, document string) bool {
+	allowed, err := s.writeAllowed(ctx, session, name, document)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if !allowed {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("policy %s selects workloads outside the scopes of %s", name, session.Username))
+		return false
+	}
+	return true
+}
+
+// validatePolicyYAML checks that data holds at least one valid policy
+func validatePolicyYAML(data []byte) error {
+	policies, err := policy.Parse(data)
+	if err != nil {
+		return fmt.Errorf("invalid policy YAML: %v", err)
+	}
+	if len(policies) == 0 {
+		return errors.New("no policies in request body")
+	}
+	for i := range policies {
+		if err := policies[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCollisions checks that the policies of data, to be stored as name,
+// do not redefine those of other stored documents (see policy.Merge)
+func (s *Server) checkCollisions(ctx context.Context, name string, data []byte) error {
+	records, err := s.policies.ListPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	var sources []policy.Source
+	for _, record := range records {
+		if record.Name == name {
+			continue
+		}
+		// Stored documents were validated when put
+		policies, _ := policy.Parse([]byte(record.YAML))
+		sources = append(sources, policy.Source{Name: "stored policy " + record.Name, Policies: policies})
+	}
+	policies, _ := policy.Parse(data)
+	_, err = policy.Merge(append(sources, policy.Source{Name: "stored policy " + name, Policies: policies})...)
+	return err
+}
+
+// stagePolicy stores a checked policy document as name on behalf of
+// principal, or holds it for approval when approval is required
+func (s *Server) stagePolicy(ctx context.Context, name, document, principal string) (storage.PolicyRecord, *storage.PendingChange, error) {
+	if s.gate == nil {
+		record, err := s.policies.PutPolicy(ctx, name, document, principal)
+		return record, nil, err
+	}
+	// Check quotas before a change is held rather than when approved
+	if store, ok := s.policies.(admitter); ok {
+		if err := store.Admit(ctx, name, document); err != nil {
+			return storage.PolicyRecord{}, nil, err
+		}
+	}
+	return s.gate.Submit(ctx, name, document, principal)
+}
+
+// admitter is implemented by policy stores enforcing quotas (quota.Store)
+type admitter interface {
+	Admit(ctx context.Context, name, document string) error
+}
+
+func writePolicyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrPolicyNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, quota.ErrExceeded):
+		writeError(w, http.StatusForbidden, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/storage"
+)
+
+const testPolicyYAML = `apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/24
+      ports:
+        - protocol: TCP
+          port: 5432
+`
+
+func policyRequest(method, path, token, body string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestPolicyEndpoints(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	operator := login(t, am, "olivia", auth.RoleOperator)
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/web-to-db", operator, testPolicyYAML))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var record storage.PolicyRecord
+	if err := json.NewDecoder(rec.Body).Decode(&record); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if record.Version != 1 || record.UpdatedBy != "olivia" {
+		t.Errorf("Expected version 1 by olivia, got %+v", record)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/policies", operator, ""))
+	var records []storage.PolicyRecord
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil || len(records) != 1 || records[0].YAML != testPolicyYAML {
+		t.Errorf("Unexpected policy list %+v, %v", records, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodDelete, "/policies/web-to-db", operator, ""))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/policies/web-to-db", operator, ""))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+}
+
+func TestPolicyEndpointsRejectInvalid(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	operator := login(t, am, "olivia", auth.RoleOperator)
+	viewer := login(t, am, "victor", auth.RoleViewer)
+
+	for _, tc := range []struct {
+		name  string
+		req   *http.Request
+		wants int
+	}{
+		{"unauthenticated", httptest.NewRequest(http.MethodGet, "/policies", nil), http.StatusUnauthorized},
+		{"viewer cannot write", policyRequest(http.MethodPut, "/policies/web", viewer, testPolicyYAML), http.StatusForbidden},
+		{"invalid yaml", policyRequest(http.MethodPut, "/policies/web", operator, "kind: [\n"), http.StatusBadRequest},
+		{"invalid policy", policyRequest(http.MethodPut, "/policies/web", operator, "kind: NetworkPolicy\n"), http.StatusBadRequest},
+		{"bad method", policyRequest(http.MethodPost, "/policies/web", operator, testPolicyYAML), http.StatusMethodNotAllowed},
+	} {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, tc.req)
+		if rec.Code != tc.wants {
+			t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.wants, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestPolicyNameCollision(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	operator := login(t, am, "olivia", auth.RoleOperator)
+
+	put := func(name, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/"+name, operator, body))
+		return rec
+	}
+	if rec := put("web-to-db", testPolicyYAML); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// The same content under another document is harmless
+	if rec := put("copy", testPolicyYAML); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for an identical policy, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	changed := strings.Replace(testPolicyYAML, "5432", "5433", 1)
+	rec := put("legacy", changed)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "both stored policy copy and stored policy legacy") {
+		t.Fatalf("Expected 409 naming both documents, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// Updating the document itself is not a collision
+	if rec := put("web-to-db", strings.Replace(testPolicyYAML, "5432", "5433", 1)); rec.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 while copy still holds the old content, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	override := strings.Replace(changed, "  name: web-to-db\n", "  name: web-to-db\n  annotations:\n    ztap.io/override: \"true\"\n", 1)
+	if rec := put("legacy", override); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for an override, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
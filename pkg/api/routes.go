@@ -0,0 +1,214 @@
+package api
+
+import (
+	"net/http"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/policy"
+	"ztap/pkg/stats"
+	"ztap/pkg/storage"
+)
+
+// Route describes an endpoint served by the API. Routes is the source of
+// the OpenAPI document (see OpenAPI) and of the generated clients, so every
+// endpoint added to NewServer is described here too.
+type Route struct {
+	Method    string
+	Path      string // OpenAPI path template, e.g. /policies/{name}
+	Operation string // operationId, and the name of the client method
+	Summary   string
+	// Permission is what the session needs; Authenticated endpoints without
+	// one check permissions of their own
+	Permission    auth.Permission
+	Authenticated bool
+	// Tenant endpoints act in the tenant named by the X-ZTAP-Tenant header
+	// or ?tenant= (see Server.tenant)
+	Tenant bool
+	Query  []Param
+	// Body is the zero value of the JSON request body, nil for none; a
+	// string body is sent as is with BodyType
+	Body      any
+	BodyType  string
+	Responses []Response // The first is the usual success
+	Errors    []int
+	Stream    bool // Server-Sent Events rather than a JSON response
+}
+
+// Param is an optional query parameter of a Route
+type Param struct {
+	Name        string
+	Description string
+}
+
+// Response is a successful response of a Route
+type Response struct {
+	Status      int
+	Description string
+	Body        any // Zero value of the JSON body, nil for none
+}
+
+// Routes are the endpoints served by NewServer, in the order of the
+// OpenAPI document
+var Routes = []Route{
+	{
+		Method: http.MethodGet, Path: "/healthz", Operation: "health",
+		Summary:   "Report that the server is up",
+		Responses: []Response{{http.StatusOK, "The server is up", map[string]string{}}},
+	},
+	{
+		Method: http.MethodPost, Path: "/login", Operation: "login",
+		Summary:   "Exchange a username and password for a session token",
+		Body:      LoginRequest{},
+		Responses: []Response{{http.StatusOK, "The session", LoginResponse{}}},
+		Errors:    []int{http.StatusBadRequest, http.StatusUnauthorized},
+	},
+	{
+		Method: http.MethodPost, Path: "/logout", Operation: "logout",
+		Summary:       "End the request's session",
+		Authenticated: true,
+		Responses:     []Response{{http.StatusNoContent, "The session ended", nil}},
+	},
+	{
+		Method: http.MethodGet, Path: "/events", Operation: "streamEvents",
+		Summary:       "Stream events as Server-Sent Events",
+		Authenticated: true, Tenant: true,
+		Query:     []Param{{"topics", "Comma-separated topics to stream; by default every topic the caller may view"}},
+		Responses: []Response{{http.StatusOK, "An event stream", nil}},
+		Stream:    true,
+	},
+	{
+		Method: http.MethodGet, Path: "/policies", Operation: "listPolicies",
+		Summary:    "List the stored policies",
+		Permission: auth.PermViewPolicies, Tenant: true,
+		Responses: []Response{{http.StatusOK, "The policies the caller sees", []storage.PolicyRecord{}}},
+	},
+	{
+		Method: http.MethodGet, Path: "/policies/{name}", Operation: "getPolicy",
+		Summary:    "Get a stored policy",
+		Permission: auth.PermViewPolicies, Tenant: true,
+		Responses: []Response{{http.StatusOK, "The policy", storage.PolicyRecord{}}},
+		Errors:    []int{http.StatusNotFound},
+	},
+	{
+		Method: http.MethodPut, Path: "/policies/{name}", Operation: "putPolicy",
+		Summary:    "Create or replace a policy document",
+		Permission: auth.PermEnforce, Tenant: true,
+		Body: "", BodyType: "application/yaml",
+		Responses: []Response{
+			{http.StatusOK, "The stored policy", storage.PolicyRecord{}},
+			{http.StatusAccepted, "The change awaits approval", storage.PendingChange{}},
+		},
+		Errors: []int{http.StatusBadRequest, http.StatusConflict},
+	},
+	{
+		Method: http.MethodDelete, Path: "/policies/{name}", Operation: "deletePolicy",
+		Summary:    "Delete a policy document",
+		Permission: auth.PermEnforce, Tenant: true,
+		Responses: []Response{{http.StatusNoContent, "The policy was deleted", nil}},
+		Errors:    []int{http.StatusNotFound},
+	},
+	{
+		Method: http.MethodGet, Path: "/approvals", Operation: "listApprovals",
+		Summary:    "List the policy changes awaiting approval",
+		Permission: auth.PermViewPolicies, Tenant: true,
+		Responses: []Response{{http.StatusOK, "The pending changes", []storage.PendingChange{}}},
+		Errors:    []int{http.StatusNotFound},
+	},
+	{
+		Method: http.MethodPost, Path: "/approvals/{id}/approve", Operation: "approveChange",
+		Summary:    "Approve a pending policy change, storing it",
+		Permission: auth.PermApprove, Tenant: true,
+		Responses: []Response{{http.StatusOK, "The stored policy", storage.PolicyRecord{}}},
+		Errors:    []int{http.StatusNotFound},
+	},
+	{
+		Method: http.MethodPost, Path: "/approvals/{id}/reject", Operation: "rejectChange",
+		Summary:    "Reject a pending policy change, discarding it",
+		Permission: auth.PermApprove, Tenant: true,
+		Responses: []Response{{http.StatusOK, "The discarded change", storage.PendingChange{}}},
+		Errors:    []int{http.StatusNotFound},
+	},
+	{
+		Method: http.MethodGet, Path: "/users", Operation: "listUsers",
+		Summary:    "List the users of the tenant",
+		Permission: auth.PermManageUsers, Tenant: true,
+		Responses: []Response{{http.StatusOK, "The users", []auth.User{}}},
+	},
+	{
+		Method: http.MethodPost, Path: "/users", Operation: "createUser",
+		Summary:    "Create a user in the tenant",
+		Permission: auth.PermManageUsers, Tenant: true,
+		Body:      CreateUserRequest{},
+		Responses: []Response{{http.StatusCreated, "The user", auth.User{}}},
+		Errors:    []int{http.StatusBadRequest, http.StatusConflict},
+	},
+	{
+		Method: http.MethodGet, Path: "/nodes", Operation: "listNodes",
+		Summary:    "List the last status reported by each node",
+		Permission: auth.PermViewStatus, Tenant: true,
+		Responses: []Response{{http.StatusOK, "The node statuses", []NodeStatus{}}},
+	},
+	{
+		Method: http.MethodPost, Path: "/nodes/{id}/status", Operation: "reportNodeStatus",
+		Summary:    "Report the status of the caller's node",
+		Permission: auth.PermReportStatus,
+		Body:       NodeStatus{},
+		Responses:  []Response{{http.StatusOK, "The recorded status", NodeStatus{}}},
+		Errors:     []int{http.StatusBadRequest},
+	},
+	{
+		Method: http.MethodGet, Path: "/nodes/{id}/policies", Operation: "getNodePolicies",
+		Summary:       "Get the policies distributed to a node",
+		Authenticated: true,
+		Query: []Param{
+			{"schema", "Policy schema the node understands, like the X-ZTAP-Policy-Schema header"},
+			{"features", "Comma-separated policy features the node understands, like the X-ZTAP-Policy-Features header"},
+		},
+		Responses: []Response{{http.StatusOK, "The node's policies", []storage.PolicyRecord{}}},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	{
+		Method: http.MethodPost, Path: "/enroll", Operation: "enroll",
+		Summary:   "Enroll a node with a join token",
+		Body:      cluster.EnrollRequest{},
+		Responses: []Response{{http.StatusOK, "The node's identity", cluster.EnrollResponse{}}},
+		Errors:    []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound, http.StatusConflict},
+	},
+	{
+		Method: http.MethodPost, Path: "/admission", Operation: "submitPolicy",
+		Summary:    "Submit a policy document for validation, lint and OPA checks, then storage",
+		Permission: auth.PermEnforce, Tenant: true,
+		Body:      AdmissionRequest{},
+		Responses: []Response{{http.StatusOK, "The outcome: rejected, checked (dry run), stored or pending approval", AdmissionResponse{}}},
+		Errors:    []int{http.StatusBadRequest, http.StatusConflict, http.StatusServiceUnavailable},
+	},
+	{
+		Method: http.MethodGet, Path: "/stats/heatmap", Operation: "getHeatmap",
+		Summary:    "Chart blocked flows per policy or anomaly scores per service over time",
+		Permission: auth.PermViewMetrics, Tenant: true,
+		Query: []Param{
+			{"metric", "blocks (the default) or anomalies"},
+			{"since", "Period before until to chart, e.g. 6h or 7d; 24h by default"},
+			{"until", "End of the chart (RFC 3339); now by default"},
+			{"step", "Finest column width wanted, e.g. 15m; wider for long periods"},
+			{"columns", "Most columns to return; 288 by default"},
+			{"top", "Rows with the highest totals to return, 0 for all; 20 by default"},
+		},
+		Responses: []Response{{http.StatusOK, "The heatmap", stats.Heatmap{}}},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	{
+		Method: http.MethodPost, Path: "/search", Operation: "searchPolicies",
+		Summary:    "Find the compiled rules of the stored policies covering a destination",
+		Permission: auth.PermViewPolicies, Tenant: true,
+		Body:      policy.SearchQuery{},
+		Responses: []Response{{http.StatusOK, "The matching rules, and the selectors that failed to resolve", policy.SearchResult{}}},
+		Errors:    []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	{
+		Method: http.MethodGet, Path: "/openapi.json", Operation: "openAPI",
+		Summary:   "Get this OpenAPI document",
+		Responses: []Response{{http.StatusOK, "The OpenAPI document", map[string]any{}}},
+	},
+}
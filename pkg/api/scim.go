@@ -0,0 +1,644 @@
+package api
+
+import (
+	"cmp"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/scim"
+)
+
+// scimMaxResults bounds a page of SCIM resources
+const scimMaxResults = 200
+
+// EnableSCIM serves the SCIM 2.0 provisioning endpoints under /scim/v2 for
+// an identity provider authenticating with token. They follow RFC 7644
+// rather than Routes, so they are not in the OpenAPI document.
+func (s *Server) EnableSCIM(config scim.Config, token string) {
+	s.scim, s.scimToken = config, token
+}
+
+// handleSCIM serves /scim/v2/Users, /scim/v2/Groups and
+// /scim/v2/ServiceProviderConfig. Users are those of the configured tenant,
+// other than node service accounts; their id is their username. Groups are
+// the identity provider groups mapped to roles: membership sets the user's
+// role (see scim.Config.Role). Deleting a user disables it, which ends its
+// sessions, and keeps its record.
+func (s *Server) handleSCIM(w http.ResponseWriter, r *http.Request) {
+	if s.scimToken == "" {
+		writeSCIMError(w, http.StatusNotFound, "", "SCIM provisioning is not enabled")
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !scim.TokenMatches(token, s.scimToken) {
+		writeSCIMError(w, http.StatusUnauthorized, "", "invalid bearer token")
+		return
+	}
+
+	resource, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/scim/v2/"), "/")
+	if strings.Contains(id, "/") {
+		writeSCIMError(w, http.StatusNotFound, "", "not found")
+		return
+	}
+	if id != "" {
+		var err error
+		if id, err = url.PathUnescape(id); err != nil {
+			writeSCIMError(w, http.StatusNotFound, "", "not found")
+			return
+		}
+	}
+
+	switch {
+	case resource == "ServiceProviderConfig" && id == "" && r.Method == http.MethodGet:
+		s.scimServiceProviderConfig(w)
+	case resource == "Users" && id == "":
+		switch r.Method {
+		case http.MethodGet:
+			s.scimListUsers(w, r)
+		case http.MethodPost:
+			s.scimCreateUser(w, r)
+		default:
+			writeSCIMError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		}
+	case resource == "Users":
+		s.scimUser(w, r, id)
+	case resource == "Groups" && id == "":
+		switch r.Method {
+		case http.MethodGet:
+			s.scimListGroups(w, r)
+		case http.MethodPost:
+			s.scimCreateGroup(w, r)
+		default:
+			writeSCIMError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		}
+	case resource == "Groups":
+		s.scimGroup(w, r, id)
+	default:
+		writeSCIMError(w, http.StatusNotFound, "", "not found")
+	}
+}
+
+func (s *Server) scimServiceProviderConfig(w http.ResponseWriter) {
+	supported := func(ok bool) map[string]any { return map[string]any{"supported": ok} }
+	writeSCIM(w, http.StatusOK, map[string]any{
+		"schemas":        []string{scim.SchemaServiceProviderConfig},
+		"patch":          supported(true),
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": scimMaxResults},
+		"changePassword": supported(true),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]any{{
+			"type": "oauthbearertoken", "name": "Bearer token",
+			"description": "The token in scim.tokenFile of the server's config.yaml",
+		}},
+	})
+}
+
+// scimUsers returns the users SCIM manages, by username: the ones it
+// created. Local users, such as the built-in admin, are not found through it.
+func (s *Server) scimUsers() map[string]*auth.User {
+	users := make(map[string]*auth.User)
+	for _, user := range s.auth.ListTenantUsers(s.scim.Tenant) {
+		if user.Tenant == s.scim.Tenant && user.Provisioned && user.Role != auth.RoleNode {
+			users[user.Username] = user
+		}
+	}
+	return users
+}
+
+func (s *Server) scimListUsers(w http.ResponseWriter, r *http.Request) {
+	filter, err := scim.ParseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	var resources []any
+	for _, user := range sortedUsers(s.scimUsers()) {
+		if filter.Matches(map[string]string{"userName": user.Username, "externalId": user.ExternalID, "id": user.Username}) {
+			resources = append(resources, scimUser(user))
+		}
+	}
+	writeSCIMList(w, r, resources)
+}
+
+func (s *Server) scimCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req scim.User
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserName == "" {
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", "userName is required")
+		return
+	}
+	explicit, err := s.scimRole(req.Roles)
+	if err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	password := req.Password
+	if password == "" {
+		// Until the provider sets a password, the user cannot log in
+		if password, err = randomPassword(); err != nil {
+			writeSCIMError(w, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+	}
+
+	role := s.scim.Role(nil, explicit)
+	if err := s.auth.CreateTenantUser(req.UserName, password, role, s.scim.Tenant); err != nil {
+		if errors.Is(err, auth.ErrUserExists) {
+			writeSCIMError(w, http.StatusConflict, "uniqueness", err.Error())
+		} else {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		}
+		return
+	}
+	err = s.auth.UpdateUser(req.UserName, func(user *auth.User) error {
+		user.ExternalID, user.Provisioned = req.ExternalID, true
+		if req.Active != nil {
+			user.Enabled = *req.Active
+		}
+		return nil
+	})
+	if err != nil {
+		writeSCIMError(w, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	log.Printf("SCIM: created user %s (%s)", req.UserName, role)
+	s.writeSCIMUser(w, http.StatusCreated, req.UserName)
+}
+
+// scimUser serves GET, PUT, PATCH and DELETE /scim/v2/Users/{id}
+func (s *Server) scimUser(w http.ResponseWriter, r *http.Request, id string) {
+	user, ok := s.scimUsers()[id]
+	if !ok {
+		writeSCIMError(w, http.StatusNotFound, "", "user "+id+" not found")
+		return
+	}
+
+	var update func(user *auth.User) error
+	switch r.Method {
+	case http.MethodGet:
+		writeSCIM(w, http.StatusOK, scimUser(user))
+		return
+
+	case http.MethodDelete:
+		update = func(user *auth.User) error {
+			user.Enabled, user.Groups = false, nil
+			return nil
+		}
+
+	case http.MethodPut:
+		var req scim.User
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "invalid request body")
+			return
+		}
+		if req.UserName != "" && req.UserName != id {
+			writeSCIMError(w, http.StatusBadRequest, "mutability", "userName cannot change")
+			return
+		}
+		explicit, err := s.scimRole(req.Roles)
+		if err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+		update = func(user *auth.User) error {
+			user.ExternalID = req.ExternalID
+			if req.Active != nil {
+				user.Enabled = *req.Active
+			}
+			if req.Password != "" {
+				user.PasswordHash = auth.HashPassword(req.Password)
+			}
+			user.Role = s.scim.Role(user.Groups, cmp.Or(explicit, user.Role))
+			return nil
+		}
+
+	case http.MethodPatch:
+		var req scim.PatchOp
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Operations) == 0 {
+			writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "invalid PatchOp")
+			return
+		}
+		update = func(user *auth.User) error {
+			for _, op := range req.Operations {
+				if err := s.patchUser(user, op); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+	default:
+		writeSCIMError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+
+	if err := s.auth.UpdateUser(id, update); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	if r.Method == http.MethodDelete {
+		log.Printf("SCIM: deactivated user %s", id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.writeSCIMUser(w, http.StatusOK, id)
+}
+
+// patchUser applies a PATCH operation to user. Operations without a path
+// set the attributes of their value, as providers send them.
+func (s *Server) patchUser(user *auth.User, op scim.PatchOperation) error {
+	operation := strings.ToLower(op.Op)
+	if operation != "add" && operation != "replace" && operation != "remove" {
+		return fmt.Errorf("unsupported operation %q", op.Op)
+	}
+	values := map[string]any{op.Path: op.Value}
+	if op.Path == "" {
+		object, ok := op.Value.(map[string]any)
+		if !ok {
+			return errors.New("an operation without a path needs an object value")
+		}
+		values = object
+	}
+
+	for path, value := range values {
+		if operation == "remove" {
+			value = nil
+		}
+		switch strings.ToLower(path) {
+		case "active":
+			active, err := scimBool(value)
+			if err != nil {
+				return err
+			}
+			user.Enabled = active
+		case "externalid":
+			user.ExternalID, _ = value.(string)
+		case "password":
+			password, _ := value.(string)
+			if password == "" {
+				return errors.New("password cannot be removed")
+			}
+			user.PasswordHash = auth.HashPassword(password)
+		case "roles":
+			var roles []scim.Value
+			data, _ := json.Marshal(value)
+			if value != nil {
+				if err := json.Unmarshal(data, &roles); err != nil {
+					return errors.New("roles must be a list of values")
+				}
+			}
+			explicit, err := s.scimRole(roles)
+			if err != nil {
+				return err
+			}
+			user.Role = s.scim.Role(user.Groups, cmp.Or(explicit, s.scim.Role(nil, "")))
+		case "username":
+			if value != user.Username {
+				return errors.New("userName cannot change")
+			}
+		default:
+			return fmt.Errorf("attribute %q cannot be patched", path)
+		}
+	}
+	return nil
+}
+
+func (s *Server) writeSCIMUser(w http.ResponseWriter, status int, username string) {
+	user, ok := s.scimUsers()[username]
+	if !ok {
+		writeSCIMError(w, http.StatusNotFound, "", "user "+username+" not found")
+		return
+	}
+	writeSCIM(w, status, scimUser(user))
+}
+
+func (s *Server) scimListGroups(w http.ResponseWriter, r *http.Request) {
+	filter, err := scim.ParseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidFilter", err.Error())
+		return
+	}
+	users := s.scimUsers()
+	var resources []any
+	for _, name := range s.scim.GroupNames() {
+		if filter.Matches(map[string]string{"displayName": name, "id": name}) {
+			resources = append(resources, scimGroup(name, users))
+		}
+	}
+	writeSCIMList(w, r, resources)
+}
+
+// scimCreateGroup serves POST /scim/v2/Groups. Only mapped groups exist;
+// pushing one sets its members.
+func (s *Server) scimCreateGroup(w http.ResponseWriter, r *http.Request) {
+	var req scim.Group
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DisplayName == "" {
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", "displayName is required")
+		return
+	}
+	if _, ok := s.scim.Groups[req.DisplayName]; !ok {
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", fmt.Sprintf("group %s is not mapped to a role in scim.groups", req.DisplayName))
+		return
+	}
+	if err := s.setMembers(req.DisplayName, req.Members); err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	writeSCIM(w, http.StatusCreated, scimGroup(req.DisplayName, s.scimUsers()))
+}
+
+// scimGroup serves GET, PUT, PATCH and DELETE /scim/v2/Groups/{id}
+func (s *Server) scimGroup(w http.ResponseWriter, r *http.Request, id string) {
+	if _, ok := s.scim.Groups[id]; !ok {
+		writeSCIMError(w, http.StatusNotFound, "", "group "+id+" not found")
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		writeSCIM(w, http.StatusOK, scimGroup(id, s.scimUsers()))
+		return
+
+	case http.MethodDelete:
+		if err := s.setMembers(id, nil); err != nil {
+			writeSCIMError(w, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+
+	case http.MethodPut:
+		var req scim.Group
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "invalid request body")
+			return
+		}
+		err = s.setMembers(id, req.Members)
+
+	case http.MethodPatch:
+		var req scim.PatchOp
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Operations) == 0 {
+			writeSCIMError(w, http.StatusBadRequest, "invalidSyntax", "invalid PatchOp")
+			return
+		}
+		for _, op := range req.Operations {
+			if err = s.patchGroup(id, op); err != nil {
+				break
+			}
+		}
+
+	default:
+		writeSCIMError(w, http.StatusMethodNotAllowed, "", "method not allowed")
+		return
+	}
+	if err != nil {
+		writeSCIMError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	writeSCIM(w, http.StatusOK, scimGroup(id, s.scimUsers()))
+}
+
+// patchGroup applies a PATCH operation to the members of group: add and
+// remove members by value, remove "members[value eq \"alice\"]", or replace
+// them all
+func (s *Server) patchGroup(group string, op scim.PatchOperation) error {
+	path := op.Path
+	var members []scim.Value
+	if path == "" {
+		// e.g. {"op": "replace", "value": {"displayName": "...", "members": [...]}}
+		object, ok := op.Value.(map[string]any)
+		if !ok {
+			return errors.New("an operation without a path needs an object value")
+		}
+		value, ok := object["members"]
+		if !ok {
+			return nil // Only the unchangeable displayName
+		}
+		path, op.Value = "members", value
+	}
+	if filter, ok := strings.CutPrefix(path, "members["); ok {
+		f, err := scim.ParseFilter(strings.TrimSuffix(filter, "]"))
+		if err != nil || f == nil || !strings.EqualFold(f.Attribute, "value") {
+			return fmt.Errorf("unsupported path %q", op.Path)
+		}
+		path, members = "members", []scim.Value{{Value: f.Value}}
+	} else if op.Value != nil {
+		data, _ := json.Marshal(op.Value)
+		if err := json.Unmarshal(data, &members); err != nil {
+			return errors.New("members must be a list of values")
+		}
+	}
+	if !strings.EqualFold(path, "members") {
+		if strings.EqualFold(path, "displayName") {
+			return nil
+		}
+		return fmt.Errorf("attribute %q cannot be patched", op.Path)
+	}
+
+	switch strings.ToLower(op.Op) {
+	case "add":
+		for _, m := range members {
+			if err := s.setMembership(group, m.Value, true); err != nil {
+				return err
+			}
+		}
+	case "remove":
+		if members == nil {
+			return s.setMembers(group, nil)
+		}
+		for _, m := range members {
+			if err := s.setMembership(group, m.Value, false); err != nil {
+				return err
+			}
+		}
+	case "replace":
+		return s.setMembers(group, members)
+	default:
+		return fmt.Errorf("unsupported operation %q", op.Op)
+	}
+	return nil
+}
+
+// setMembers makes members the only members of group
+func (s *Server) setMembers(group string, members []scim.Value) error {
+	wanted := make(map[string]bool)
+	users := s.scimUsers()
+	for _, m := range members {
+		if _, ok := users[m.Value]; !ok {
+			return fmt.Errorf("user %s not found", m.Value)
+		}
+		wanted[m.Value] = true
+	}
+	for _, user := range sortedUsers(users) {
+		if wanted[user.Username] != slices.Contains(user.Groups, group) {
+			if err := s.setMembership(group, user.Username, wanted[user.Username]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setMembership adds a user to group or removes it, and updates its role
+func (s *Server) setMembership(group, username string, member bool) error {
+	if _, ok := s.scimUsers()[username]; !ok {
+		return fmt.Errorf("user %s not found", username)
+	}
+	return s.auth.UpdateUser(username, func(user *auth.User) error {
+		groups := slices.DeleteFunc(slices.Clone(user.Groups), func(g string) bool { return g == group })
+		if member {
+			groups = append(groups, group)
+			sort.Strings(groups)
+		}
+		user.Groups = groups
+		if len(groups) == 0 {
+			user.Groups = nil
+		}
+		role := s.scim.Role(user.Groups, "")
+		if role != user.Role {
+			log.Printf("SCIM: user %s is now %s (groups %v)", username, role, user.Groups)
+		}
+		user.Role = role
+		return nil
+	})
+}
+
+func scimUser(user *auth.User) scim.User {
+	active := user.Enabled
+	created := user.CreatedAt
+	resource := scim.User{
+		Schemas:    []string{scim.SchemaUser},
+		ID:         user.Username,
+		ExternalID: user.ExternalID,
+		UserName:   user.Username,
+		Active:     &active,
+		Roles:      []scim.Value{{Value: string(user.Role)}},
+		Meta:       &scim.Meta{ResourceType: "User", Created: &created, Location: "/scim/v2/Users/" + url.PathEscape(user.Username)},
+	}
+	for _, group := range user.Groups {
+		resource.Groups = append(resource.Groups, scim.Value{Value: group, Display: group})
+	}
+	return resource
+}
+
+func scimGroup(name string, users map[string]*auth.User) scim.Group {
+	group := scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          name,
+		DisplayName: name,
+		Meta:        &scim.Meta{ResourceType: "Group", Location: "/scim/v2/Groups/" + url.PathEscape(name)},
+	}
+	for _, user := range sortedUsers(users) {
+		if slices.Contains(user.Groups, name) {
+			group.Members = append(group.Members, scim.Value{Value: user.Username, Display: user.Username})
+		}
+	}
+	return group
+}
+
+// scimRole returns the role set by a roles attribute: the primary or
+// first value, or "" for none. It must be a role SCIM can provision, the
+// same ones the config may map groups to.
+func (s *Server) scimRole(roles []scim.Value) (auth.Role, error) {
+	if len(roles) == 0 {
+		return "", nil
+	}
+	value := roles[0]
+	for _, r := range roles {
+		if r.Primary {
+			value = r
+			break
+		}
+	}
+	role := auth.Role(value.Value)
+	if !validRole(role) {
+		return "", fmt.Errorf("unknown role %q", role)
+	}
+	if err := s.scim.Provisionable(role); err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+// scimBool reads a boolean, which some providers send as a string
+func scimBool(value any) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		return strconv.ParseBool(strings.ToLower(v))
+	}
+	return false, errors.New("active must be a boolean")
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func sortedUsers(users map[string]*auth.User) []*auth.User {
+	sorted := make([]*auth.User, 0, len(users))
+	for _, user := range users {
+		sorted = append(sorted, user)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Username < sorted[j].Username })
+	return sorted
+}
+
+// writeSCIMList writes the page of resources selected by ?startIndex= (from
+// 1) and ?count=
+func writeSCIMList(w http.ResponseWriter, r *http.Request, resources []any) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if start < 1 {
+		start = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count > scimMaxResults {
+		count = scimMaxResults
+	}
+	if count < 0 {
+		count = 0
+	}
+	page := []any{}
+	if start <= len(resources) {
+		page = resources[start-1 : min(len(resources), start-1+count)]
+	}
+	writeSCIM(w, http.StatusOK, scim.ListResponse{
+		Schemas:      []string{scim.SchemaListResponse},
+		TotalResults: len(resources),
+		StartIndex:   start,
+		ItemsPerPage: len(page),
+		Resources:    page,
+	})
+}
+
+func writeSCIM(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeSCIMError(w http.ResponseWriter, status int, scimType, detail string) {
+	writeSCIM(w, status, scim.Error{
+		Schemas:  []string{scim.SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/scim"
+)
+
+const testSCIMToken = "scim-token-0123456789"
+
+func scimRequest(t *testing.T, s *Server, method, path, body string, out any) int {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testSCIMToken)
+	r.Header.Set("Content-Type", scim.ContentType)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, r)
+	if out != nil && rec.Code < 300 {
+		if err := json.NewDecoder(rec.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: failed to decode response: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestSCIMUserLifecycle(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	if code := scimRequest(t, s, http.MethodGet, "/scim/v2/Users", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 before SCIM is enabled, got %d", code)
+	}
+	s.EnableSCIM(scim.Config{
+		Tenant:      "acme",
+		DefaultRole: auth.RoleViewer,
+		Groups:      map[string]auth.Role{"ztap-admins": auth.RoleTenantAdmin, "ztap-ops": auth.RoleOperator},
+	}, testSCIMToken)
+
+	r := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+	r.Header.Set("Authorization", "Bearer wrong")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, r)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong token, got %d", rec.Code)
+	}
+
+	var user scim.User
+	code := scimRequest(t, s, http.MethodPost, "/scim/v2/Users",
+		`{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"alice","externalId":"00u1","active":true,"password":"password123"}`, &user)
+	if code != http.StatusCreated || user.ID != "alice" || user.ExternalID != "00u1" || user.Roles[0].Value != string(auth.RoleViewer) {
+		t.Fatalf("Expected alice created as a viewer, got %d %+v", code, user)
+	}
+	if code := scimRequest(t, s, http.MethodPost, "/scim/v2/Users", `{"userName":"alice"}`, nil); code != http.StatusConflict {
+		t.Errorf("Expected 409 creating alice again, got %d", code)
+	}
+
+	var list scim.ListResponse
+	scimRequest(t, s, http.MethodGet, `/scim/v2/Users?filter=externalId+eq+%2200u1%22`, "", &list)
+	if list.TotalResults != 1 {
+		t.Errorf("Expected alice found by externalId, got %+v", list)
+	}
+	scimRequest(t, s, http.MethodGet, "/scim/v2/Users", "", &list)
+	if list.TotalResults != 1 {
+		t.Errorf("Expected only users of the tenant, got %+v", list)
+	}
+
+	var group scim.Group
+	code = scimRequest(t, s, http.MethodPatch, "/scim/v2/Groups/ztap-admins",
+		`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"Add","path":"members","value":[{"value":"alice"}]}]}`, &group)
+	if code != http.StatusOK || len(group.Members) != 1 {
+		t.Fatalf("Expected alice added to ztap-admins, got %d %+v", code, group)
+	}
+	scimRequest(t, s, http.MethodPatch, "/scim/v2/Groups/ztap-ops",
+		`{"Operations":[{"op":"add","path":"members","value":[{"value":"alice"}]}]}`, nil)
+	scimRequest(t, s, http.MethodGet, "/scim/v2/Users/alice", "", &user)
+	if user.Roles[0].Value != string(auth.RoleTenantAdmin) || len(user.Groups) != 2 {
+		t.Errorf("Expected the most privileged role of alice's groups, got %+v", user)
+	}
+
+	scimRequest(t, s, http.MethodPatch, "/scim/v2/Groups/ztap-admins",
+		`{"Operations":[{"op":"remove","path":"members[value eq \"alice\"]"}]}`, nil)
+	scimRequest(t, s, http.MethodGet, "/scim/v2/Users/alice", "", &user)
+	if user.Roles[0].Value != string(auth.RoleOperator) {
+		t.Errorf("Expected alice back to operator, got %+v", user.Roles)
+	}
+
+	session, err := am.Authenticate("alice", "password123")
+	if err != nil {
+		t.Fatalf("Expected the provisioned password to work: %v", err)
+	}
+	code = scimRequest(t, s, http.MethodPatch, "/scim/v2/Users/alice",
+		`{"Operations":[{"op":"Replace","path":"active","value":"False"}]}`, &user)
+	if code != http.StatusOK || *user.Active {
+		t.Fatalf("Expected alice deactivated, got %d %+v", code, user)
+	}
+	if _, err := am.ValidateSession(session.Token); err == nil {
+		t.Error("Expected deactivation to end alice's session")
+	}
+
+	if code := scimRequest(t, s, http.MethodDelete, "/scim/v2/Users/alice", "", nil); code != http.StatusNoContent {
+		t.Errorf("Expected 204 deleting alice, got %d", code)
+	}
+	var deleted scim.User
+	scimRequest(t, s, http.MethodGet, "/scim/v2/Users/alice", "", &deleted)
+	if *deleted.Active || len(deleted.Groups) != 0 {
+		t.Errorf("Expected alice kept disabled without groups, got %+v", deleted)
+	}
+
+	if code := scimRequest(t, s, http.MethodGet, "/scim/v2/Groups/unmapped", "", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unmapped group, got %d", code)
+	}
+	if code := scimRequest(t, s, http.MethodPut, "/scim/v2/Users/alice", `{"userName":"bob"}`, nil); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 renaming alice, got %d", code)
+	}
+}
+
+func TestSCIMDemotionEndsAdminRights(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	s.EnableSCIM(scim.Config{
+		Tenant:      "acme",
+		DefaultRole: auth.RoleViewer,
+		Groups:      map[string]auth.Role{"ztap-admins": auth.RoleTenantAdmin},
+	}, testSCIMToken)
+	scimRequest(t, s, http.MethodPost, "/scim/v2/Users", `{"userName":"alice","active":true,"password":"password123"}`, nil)
+	scimRequest(t, s, http.MethodPatch, "/scim/v2/Groups/ztap-admins",
+		`{"Operations":[{"op":"add","path":"members","value":[{"value":"alice"}]}]}`, nil)
+
+	session, err := am.Authenticate("alice", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := am.HasPermission(session.Token, auth.PermManageUsers); err != nil {
+		t.Fatalf("Expected alice to manage users as tenant admin, got %v", err)
+	}
+
+	// Removed from the admin group, alice's open session is a viewer's
+	scimRequest(t, s, http.MethodPatch, "/scim/v2/Groups/ztap-admins",
+		`{"Operations":[{"op":"remove","path":"members[value eq \"alice\"]"}]}`, nil)
+	if err := am.HasPermission(session.Token, auth.PermManageUsers); !errors.Is(err, auth.ErrPermissionDenied) {
+		t.Errorf("Expected the demoted session denied, got %v", err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.Header.Set("Authorization", "Bearer "+session.Token)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, r)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 listing users after the demotion, got %d", rec.Code)
+	}
+}
+
+func TestSCIMManagesOnlyProvisionedUsers(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	s.EnableSCIM(scim.Config{DefaultRole: auth.RoleViewer}, testSCIMToken)
+	if err := am.CreateUser("local-ops", "password123", auth.RoleOperator); err != nil {
+		t.Fatal(err)
+	}
+
+	var list scim.ListResponse
+	scimRequest(t, s, http.MethodGet, "/scim/v2/Users", "", &list)
+	if list.TotalResults != 0 {
+		t.Errorf("Expected local users hidden from SCIM, got %+v", list)
+	}
+	for _, name := range []string{"admin", "local-ops"} {
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			body := `{"userName":"` + name + `","active":false,"roles":[{"value":"viewer"}]}`
+			if code := scimRequest(t, s, method, "/scim/v2/Users/"+name, body, nil); code != http.StatusNotFound {
+				t.Errorf("Expected 404 for %s %s, got %d", method, name, code)
+			}
+		}
+	}
+	scimRequest(t, s, http.MethodPatch, "/scim/v2/Groups/ztap-admins",
+		`{"Operations":[{"op":"add","path":"members","value":[{"value":"local-ops"}]}]}`, nil)
+	for _, user := range am.ListUsers() {
+		if user.Username == "local-ops" && (!user.Enabled || user.Role != auth.RoleOperator || len(user.Groups) != 0) {
+			t.Errorf("Expected local-ops untouched, got %+v", user)
+		}
+	}
+}
+
+func TestSCIMExplicitRoles(t *testing.T) {
+	s, _, _ := newTestServer(t)
+	s.EnableSCIM(scim.Config{Tenant: "acme"}, testSCIMToken)
+
+	for _, role := range []string{"admin", "node"} {
+		body := `{"userName":"alice","roles":[{"value":"` + role + `"}]}`
+		if code := scimRequest(t, s, http.MethodPost, "/scim/v2/Users", body, nil); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 provisioning %s in a tenant, got %d", role, code)
+		}
+	}
+
+	var user scim.User
+	scimRequest(t, s, http.MethodPost, "/scim/v2/Users",
+		`{"userName":"alice","roles":[{"value":"viewer"},{"value":"operator","primary":true}]}`, &user)
+	if user.Roles[0].Value != string(auth.RoleOperator) {
+		t.Errorf("Expected the primary role, got %+v", user.Roles)
+	}
+	code := scimRequest(t, s, http.MethodPatch, "/scim/v2/Users/alice",
+		`{"Operations":[{"op":"replace","path":"roles","value":[{"value":"admin"}]}]}`, nil)
+	if code != http.StatusBadRequest {
+		t.Errorf("Expected 400 patching alice to admin, got %d", code)
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/events"
+	"ztap/pkg/policy"
+	"ztap/pkg/storage"
+)
+
+// SetWorkloadLabels sets how the labels of the workload that held an IP at a
+// time are found, so users limited by scopes (see auth.Session.Sees) receive
+// the flows of their workloads. Without it scoped users receive no flows.
+func (s *Server) SetWorkloadLabels(labels func(ip string, at time.Time) map[string]string) {
+	s.workloadLabels = labels
+}
+
+// flowVisible reports whether session may see a flow: scoped sessions only
+// see flows from or to a workload in one of their scopes
+func (s *Server) flowVisible(session *auth.Session, sourceIP, destIP string, at time.Time) bool {
+	if !session.Scoped() {
+		return true
+	}
+	if s.workloadLabels == nil {
+		return false
+	}
+	return session.Sees(s.workloadLabels(sourceIP, at)) || session.Sees(s.workloadLabels(destIP, at))
+}
+
+// documentVisible reports whether session may see a stored policy document:
+// scoped sessions only see documents whose policies all select workloads
+// within their scopes
+func documentVisible(session *auth.Session, record storage.PolicyRecord) bool {
+	if !session.Scoped() {
+		return true
+	}
+	policies, err := policy.Parse([]byte(record.YAML))
+	if err != nil || len(policies) == 0 {
+		return false
+	}
+	for _, p := range policies {
+		if !session.Sees(p.Spec.PodSelector.MatchLabels) {
+			return false
+		}
+	}
+	return true
+}
+
+// changeVisible reports whether session may see a pending change, as it
+// would the document the change stores
+func changeVisible(session *auth.Session, change storage.PendingChange) bool {
+	return documentVisible(session, storage.PolicyRecord{Name: change.Policy, YAML: change.YAML})
+}
+
+// writeAllowed reports whether session may store document as the policy
+// document name, or delete it if document is "". Scoped sessions may only
+// write documents they see, both the stored one and its replacement.
+func (s *Server) writeAllowed(ctx context.Context, session *auth.Session, name, document string) (bool, error) {
+	if !session.Scoped() {
+		return true, nil
+	}
+	if document != "" && !documentVisible(session, storage.PolicyRecord{Name: name, YAML: document}) {
+		return false, nil
+	}
+	record, err := s.policies.GetPolicy(ctx, name)
+	if errors.Is(err, storage.ErrPolicyNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return documentVisible(session, record), nil
+}
+
+// visiblePolicies returns the names of the stored policies of the context's
+// tenant that a scoped session may see
+func (s *Server) visiblePolicies(ctx context.Context, session *auth.Session) (map[string]bool, error) {
+	records, err := s.policies.ListPolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	visible := make(map[string]bool)
+	for _, record := range records {
+		policies, err := policy.Parse([]byte(record.YAML))
+		if err != nil {
+			continue
+		}
+		for _, p := range policies {
+			if session.Sees(p.Spec.PodSelector.MatchLabels) {
+				visible[p.Metadata.Name] = true
+			}
+		}
+	}
+	return visible, nil
+}
+
+// eventVisible reports whether session may see event. Scoped sessions see
+// the services, flows and maintenance windows of their workloads, events of
+// the policies in visible, and cluster-wide status such as leader changes.
+func (s *Server) eventVisible(session *auth.Session, event events.Event, visible map[string]bool) bool {
+	if !session.Scoped() {
+		return true
+	}
+	switch data := event.Data.(type) {
+	case events.ServiceChanged:
+		return session.Sees(data.Labels)
+	case events.FlowBlocked:
+		return s.flowVisible(session, data.SourceIP, data.DestIP, event.Timestamp)
+	case events.AnomalyDetected:
+		return s.flowVisible(session, data.SourceIP, data.DestIP, event.Timestamp)
+	case events.PolicyExpired:
+		if data.Hit {
+			return s.flowVisible(session, data.SourceIP, data.DestIP, event.Timestamp)
+		}
+		return visible[data.Policy]
+	case events.PolicyApplied:
+		return visible[data.Policy]
+	case events.ShrinkHeld:
+		return visible[data.Policy]
+	case events.PolicyApproval:
+		return visible[data.Policy]
+	case events.Maintenance:
+		return len(data.Selector) == 0 || session.Sees(data.Selector)
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/approval"
+	"ztap/pkg/auth"
+	"ztap/pkg/events"
+	"ztap/pkg/storage"
+)
+
+// loginScoped logs in a new viewer limited to scopes
+func loginScoped(t *testing.T, am *auth.AuthManager, username string, scopes ...auth.Scope) string {
+	t.Helper()
+	return loginScopedAs(t, am, username, auth.RoleViewer, scopes...)
+}
+
+// loginScopedAs logs in a new user of role limited to scopes
+func loginScopedAs(t *testing.T, am *auth.AuthManager, username string, role auth.Role, scopes ...auth.Scope) string {
+	t.Helper()
+	if err := am.CreateUser(username, "password123", role); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.SetScopes(username, scopes); err != nil {
+		t.Fatal(err)
+	}
+	session, err := am.Authenticate(username, "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session.Token
+}
+
+func TestScopedPolicies(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	operator := login(t, am, "olivia", auth.RoleOperator)
+	payments := loginScoped(t, am, "pat", auth.Scope{"namespace": "payments"})
+
+	paymentsPolicy := strings.Replace(strings.Replace(testPolicyYAML, "web-to-db", "payments-web", 1), "app: web", "app: web\n      namespace: payments", 1)
+	for name, yaml := range map[string]string{"web-to-db": testPolicyYAML, "payments-web": paymentsPolicy} {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/"+name, operator, yaml))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/policies", payments, ""))
+	var records []storage.PolicyRecord
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil || len(records) != 1 || records[0].Name != "payments-web" {
+		t.Errorf("Expected only the payments policy listed, got %+v, %v", records, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/policies/web-to-db", payments, ""))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a policy outside the scope, got %d", rec.Code)
+	}
+
+	// Narrowing the scopes applies to the open session
+	if err := am.SetScopes("pat", []auth.Scope{{"namespace": "billing"}}); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/policies/payments-web", payments, ""))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 once the scope is narrowed, got %d", rec.Code)
+	}
+}
+
+func TestScopedEvents(t *testing.T) {
+	s, am, bus := newTestServer(t)
+	token := loginScoped(t, am, "pat", auth.Scope{"namespace": "payments"})
+	s.SetWorkloadLabels(func(ip string, at time.Time) map[string]string {
+		if ip == "10.0.5.1" {
+			return map[string]string{"app": "api", "namespace": "payments"}
+		}
+		return nil
+	})
+
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+	resp := openStream(t, srv, "?token="+token+"&topics=flow_blocked,service_changed")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	bus.Publish(events.TopicServiceChanged, events.ServiceChanged{Name: "web", Labels: map[string]string{"app": "web"}})
+	bus.Publish(events.TopicFlowBlocked, events.FlowBlocked{SourceIP: "10.0.0.1", DestIP: "10.0.0.2", Port: 22})
+	bus.Publish(events.TopicFlowBlocked, events.FlowBlocked{SourceIP: "10.0.0.1", DestIP: "10.0.5.1", Port: 443})
+
+	topic, data := readSSE(t, bufio.NewReader(resp.Body))
+	if topic != string(events.TopicFlowBlocked) || !strings.Contains(data, "10.0.5.1") {
+		t.Errorf("Expected only the flow to the payments workload, got %s %s", topic, data)
+	}
+}
+
+func TestScopedWrites(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	operator := login(t, am, "olivia", auth.RoleOperator)
+	payments := loginScopedAs(t, am, "pat", auth.RoleOperator, auth.Scope{"namespace": "payments"})
+	loginNode(t, am, "web-1", map[string]string{"app": "web"})
+
+	paymentsPolicy := strings.Replace(strings.Replace(testPolicyYAML, "web-to-db", "payments-web", 1), "app: web", "app: web\n      namespace: payments", 1)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/web-to-db", operator, testPolicyYAML))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	for _, tt := range []struct {
+		name, method, path, yaml string
+	}{
+		{"replacing a hidden policy", http.MethodPut, "/policies/web-to-db", paymentsPolicy},
+		{"deleting a hidden policy", http.MethodDelete, "/policies/web-to-db", ""},
+		{"storing a policy outside the scope", http.MethodPut, "/policies/other", testPolicyYAML},
+	} {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, policyRequest(tt.method, tt.path, payments, tt.yaml))
+		if rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "10.0.0.0/24") {
+			t.Errorf("%s: expected 403 without the stored document, got %d: %s", tt.name, rec.Code, rec.Body.String())
+		}
+	}
+	if code, _ := submit(t, s, payments, AdmissionRequest{YAML: testPolicyYAML}); code != http.StatusForbidden {
+		t.Errorf("Expected 403 admitting a policy outside the scope, got %d", code)
+	}
+	if record, err := s.policies.GetPolicy(context.Background(), "web-to-db"); err != nil || record.YAML != testPolicyYAML {
+		t.Errorf("Expected web-to-db unchanged, got %+v, %v", record, err)
+	}
+
+	// Node policies are filtered like GET /policies
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/nodes/web-1/policies", payments, ""))
+	var records []storage.PolicyRecord
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil || len(records) != 0 {
+		t.Errorf("Expected no node policies outside the scope, got %+v, %v", records, err)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/payments-web", payments, paymentsPolicy))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 within the scope, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestScopedApprovals(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	pending := storage.NewFilePendingStore(filepath.Join(t.TempDir(), "pending.json"))
+	s.RequireApproval(approval.NewGate(approval.Config{Rules: []approval.Rule{{Port: 5432}}}, s.policies, pending))
+	admin := login(t, am, "alice", auth.RoleAdmin)
+	payments := loginScopedAs(t, am, "pat", auth.RoleAdmin, auth.Scope{"namespace": "payments"})
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/web-to-db", admin, testPolicyYAML))
+	var change storage.PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&change); err != nil || change.ID == "" {
+		t.Fatalf("Expected a pending change, got %d %+v", rec.Code, change)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/approvals", payments, ""))
+	var changes []storage.PendingChange
+	if err := json.NewDecoder(rec.Body).Decode(&changes); err != nil || len(changes) != 0 {
+		t.Errorf("Expected no pending changes outside the scope, got %+v, %v", changes, err)
+	}
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPost, "/approvals/"+change.ID+"/approve", payments, ""))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 approving a change outside the scope, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/policy"
+)
+
+// EnableSearch serves POST /search, resolving the label selectors and
+// endpoint groups of the stored policies with resolver
+func (s *Server) EnableSearch(resolver *policy.PolicyResolver) {
+	s.resolver = resolver
+}
+
+// handleSearch serves POST /search: the compiled rules of the request
+// tenant's stored policies that cover a destination IP, and optionally a
+// port and protocol (see policy.RuleIndex). Users limited by scopes only
+// search the documents they may see.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if s.resolver == nil {
+		writeError(w, http.StatusNotFound, "policy search is not enabled")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, session, ok := s.authorizeTenant(w, r, auth.PermViewPolicies)
+	if !ok {
+		return
+	}
+
+	var query policy.SearchQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := query.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	records, err := s.policies.ListPolicies(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var policies []policy.NetworkPolicy
+	for _, record := range records {
+		if !documentVisible(session, record) {
+			continue
+		}
+		parsed, err := policy.Parse([]byte(record.YAML))
+		if err != nil {
+			continue
+		}
+		policies = append(policies, parsed...)
+	}
+	writeJSON(w, http.StatusOK, policy.NewRuleIndex(s.resolver, policies).Search(query))
+}
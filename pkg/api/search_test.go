@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/discovery"
+	"ztap/pkg/policy"
+)
+
+func searchPolicies(t *testing.T, s *Server, token, body string) (int, policy.SearchResult) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPost, "/search", token, body))
+	var result policy.SearchResult
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode search result: %v", err)
+		}
+	}
+	return rec.Code, result
+}
+
+func TestSearch(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	viewer := login(t, am, "victor", auth.RoleViewer)
+	if code, _ := searchPolicies(t, s, viewer, `{"dest": "10.0.0.5"}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 without search, got %d", code)
+	}
+
+	backend := discovery.NewInMemoryDiscovery()
+	backend.RegisterService("db", "10.0.2.5", map[string]string{"tier": "db"})
+	s.EnableSearch(policy.NewPolicyResolver(backend))
+	if _, err := s.policies.PutPolicy(t.Context(), "web-to-db", testPolicyYAML, "alice"); err != nil {
+		t.Fatalf("PutPolicy failed: %v", err)
+	}
+	if _, err := s.policies.PutPolicy(t.Context(), "api-to-db", `apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: api-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: api
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            tier: db
+      ports:
+        - protocol: TCP
+          port: 5432
+`, "alice"); err != nil {
+		t.Fatalf("PutPolicy failed: %v", err)
+	}
+
+	code, result := searchPolicies(t, s, viewer, `{"dest": "10.0.0.5", "port": 5432}`)
+	if code != http.StatusOK || len(result.Matches) != 1 || result.Matches[0].Policy != "web-to-db" {
+		t.Errorf("Expected web-to-db through its ipBlock, got %d %+v", code, result)
+	}
+	code, result = searchPolicies(t, s, viewer, `{"dest": "10.0.2.5", "port": 5432}`)
+	if code != http.StatusOK || len(result.Matches) != 1 || result.Matches[0].Source != "spec.egress[0].to.podSelector tier=db" {
+		t.Errorf("Expected api-to-db through label resolution, got %d %+v", code, result)
+	}
+	if code, result := searchPolicies(t, s, viewer, `{"dest": "10.0.2.5", "port": 443}`); code != http.StatusOK || len(result.Matches) != 0 {
+		t.Errorf("Expected no matches on another port, got %d %+v", code, result)
+	}
+
+	for _, body := range []string{`{"dest": "db"}`, `{"dest": "10.0.2.5", "port": -1}`, `[`} {
+		if code, _ := searchPolicies(t, s, viewer, body); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, code)
+		}
+	}
+
+	// Scoped users only search the policies selecting their workloads
+	scoped := loginScoped(t, am, "sam", auth.Scope{"app": "web"})
+	if code, result := searchPolicies(t, s, scoped, `{"dest": "10.0.2.5"}`); code != http.StatusOK || len(result.Matches) != 0 {
+		t.Errorf("Expected api-to-db hidden from a scoped user, got %d %+v", code, result)
+	}
+}
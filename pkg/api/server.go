@@ -0,0 +1,277 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ztap/pkg/admission"
+	"ztap/pkg/approval"
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/events"
+	"ztap/pkg/logging"
+	"ztap/pkg/metrics"
+	"ztap/pkg/policy"
+	"ztap/pkg/scim"
+	"ztap/pkg/storage"
+)
+
+// Server is the ZTAP HTTP API
+type Server struct {
+	auth     *auth.AuthManager
+	bus      *events.Bus
+	policies storage.PolicyStore
+	gate     *approval.Gate // Nil unless RequireApproval was called
+	// admission checks POST /admission submissions (see SetAdmission)
+	admission *admission.Checker
+	mux       *http.ServeMux
+
+	// tokens and ca enroll nodes; nil unless EnableEnrollment was called
+	tokens *cluster.TokenStore
+	ca     *cluster.CA
+
+	nodesMu  sync.Mutex
+	nodes    map[string]NodeStatus        // Last status reported, by tenant and node
+	withheld map[string]map[string]string // Policies withheld at the last fetch, by tenant and node
+
+	// workloadLabels finds the labels of the workload behind a flow's IPs
+	// for scoped sessions; nil unless SetWorkloadLabels was called
+	workloadLabels func(ip string, at time.Time) map[string]string
+
+	// scim and scimToken serve SCIM provisioning; the token is empty unless
+	// EnableSCIM was called
+	scim      scim.Config
+	scimToken string
+
+	// statsPath is the statistics file of GET /stats/heatmap; empty unless
+	// EnableStats was called
+	statsPath string
+
+	// resolver resolves the selectors of POST /search; nil unless
+	// EnableSearch was called
+	resolver *policy.PolicyResolver
+
+	// heartbeat is the interval between SSE keep-alive comments
+	heartbeat time.Duration
+}
+
+// NewServer creates an API server authenticating against am, streaming
+// events from bus and serving the policies kept in policies
+func NewServer(am *auth.AuthManager, bus *events.Bus, policies storage.PolicyStore) *Server {
+	s := &Server{
+		auth:      am,
+		bus:       bus,
+		policies:  policies,
+		mux:       http.NewServeMux(),
+		nodes:     make(map[string]NodeStatus),
+		withheld:  make(map[string]map[string]string),
+		heartbeat: 15 * time.Second,
+		admission: admission.NewChecker(admission.DefaultConfig()),
+	}
+
+	s.mux.HandleFunc("/healthz", s.handleHealth)
+	s.mux.HandleFunc("/openapi.json", s.handleOpenAPI)
+	s.mux.HandleFunc("/login", s.handleLogin)
+	s.mux.HandleFunc("/logout", s.handleLogout)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	s.mux.HandleFunc("/policies", s.handlePolicies)
+	s.mux.HandleFunc("/policies/", s.handlePolicy)
+	s.mux.HandleFunc("/approvals", s.handleApprovals)
+	s.mux.HandleFunc("/approvals/", s.handleApproval)
+	s.mux.HandleFunc("/users", s.handleUsers)
+	s.mux.HandleFunc("/nodes", s.handleNodes)
+	s.mux.HandleFunc("/nodes/", s.handleNode)
+	s.mux.HandleFunc("/enroll", s.handleEnroll)
+	s.mux.HandleFunc("/admission", s.handleAdmission)
+	s.mux.HandleFunc("/scim/v2/", s.handleSCIM)
+	s.mux.HandleFunc("/stats/heatmap", s.handleHeatmap)
+	s.mux.HandleFunc("/search", s.handleSearch)
+
+	return s
+}
+
+// RequireApproval routes policy writes through gate, so high-risk changes
+// wait for a second admin. The gate must write to the server's policy store.
+func (s *Server) RequireApproval(gate *approval.Gate) {
+	s.gate = gate
+}
+
+// Handler returns the HTTP handler serving the API
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// ListenAndServe serves the API on addr
+func (s *Server) ListenAndServe(addr string) error {
+	log.Printf("Starting API server on %s", addr)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// LoginRequest is the body of POST /login
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LoginResponse is returned by a successful POST /login
+type LoginResponse struct {
+	Token     string       `json:"token"`
+	Role      auth.Role    `json:"role"`
+	Tenant    string       `json:"tenant,omitempty"`
+	Scopes    []auth.Scope `json:"scopes,omitempty"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	session, err := s.auth.Authenticate(req.Username, req.Password)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, LoginResponse{
+		Token:     session.Token,
+		Role:      session.Role,
+		Tenant:    session.Tenant,
+		Scopes:    session.Scopes,
+		ExpiresAt: session.ExpiresAt,
+	})
+}
+
+// handleLogout serves POST /logout, ending the request's session
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	token, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if err := s.auth.Logout(token); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticate returns the session token from the Authorization header, or
+// the token query parameter for clients such as EventSource that cannot set
+// headers. It writes a 401 and returns false if the session is invalid, and
+// a 403 for a node session without its certificate (see EnableEnrollment).
+// Authenticated requests are counted by user.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+
+	session, err := s.auth.ValidateSession(token)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return "", false
+	}
+	if !s.nodeCertificateValid(r, session) {
+		writeError(w, http.StatusForbidden, session.Username+" must present the certificate of node "+session.Node)
+		return "", false
+	}
+	metrics.GetCollector().IncAPIRequest(session.Username)
+	return token, true
+}
+
+// authorize authenticates the request and checks that its session holds
+// perm, writing a 401 or 403 and returning false otherwise
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, perm auth.Permission) (*auth.Session, bool) {
+	token, ok := s.authenticate(w, r)
+	if !ok {
+		return nil, false
+	}
+	if err := s.auth.HasPermission(token, perm); err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return nil, false
+	}
+	session, err := s.auth.ValidateSession(token)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "authentication required")
+		return nil, false
+	}
+	return session, true
+}
+
+// tenantHeader names the tenant a request acts in, like ?tenant=
+const tenantHeader = "X-ZTAP-Tenant"
+
+// tenant returns the tenant the session acts in for the request: the user's
+// own, or for users without a tenant the one named by the X-ZTAP-Tenant
+// header or ?tenant= ("" if none). It writes a 400 or 403 and returns false
+// for an invalid tenant or one the session may not act in.
+func (s *Server) tenant(w http.ResponseWriter, r *http.Request, session *auth.Session) (string, bool) {
+	requested := r.Header.Get(tenantHeader)
+	if requested == "" {
+		requested = r.URL.Query().Get("tenant")
+	}
+	if err := auth.ValidateTenant(requested); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	tenant, err := session.ResolveTenant(requested)
+	if err != nil {
+		writeError(w, http.StatusForbidden, err.Error())
+		return "", false
+	}
+	return tenant, true
+}
+
+// authorizeTenant authorizes the request like authorize and returns a
+// context scoping stores to the tenant it acts in
+func (s *Server) authorizeTenant(w http.ResponseWriter, r *http.Request, perm auth.Permission) (context.Context, *auth.Session, bool) {
+	session, ok := s.authorize(w, r, perm)
+	if !ok {
+		return nil, nil, false
+	}
+	tenant, ok := s.tenant(w, r, session)
+	if !ok {
+		return nil, nil, false
+	}
+	return auth.WithTenant(r.Context(), tenant), session, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Error: logging.Scrub(message)})
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/events"
+	"ztap/pkg/storage"
+)
+
+func newTestServer(t *testing.T) (*Server, *auth.AuthManager, *events.Bus) {
+	t.Helper()
+	am, err := auth.NewAuthManager(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatalf("Failed to create auth manager: %v", err)
+	}
+	bus := events.NewBus()
+	return NewServer(am, bus, storage.NewFilePolicyStore(filepath.Join(t.TempDir(), "policies.json"))), am, bus
+}
+
+func login(t *testing.T, am *auth.AuthManager, username string, role auth.Role) string {
+	t.Helper()
+	if err := am.CreateUser(username, "password123", role); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	session, err := am.Authenticate(username, "password123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	return session.Token
+}
+
+func TestLogin(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	if err := am.CreateUser("alice", "password123", auth.RoleViewer); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	body, _ := json.Marshal(LoginRequest{Username: "alice", Password: "password123"})
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp LoginResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Role != auth.RoleViewer {
+		t.Errorf("Expected role viewer, got %s", resp.Role)
+	}
+	if _, err := am.ValidateSession(resp.Token); err != nil {
+		t.Errorf("Returned token is not a valid session: %v", err)
+	}
+}
+
+func TestLoginRejectsBadCredentials(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	am.CreateUser("alice", "password123", auth.RoleViewer)
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{"wrong password", http.MethodPost, `{"username":"alice","password":"nope"}`, http.StatusUnauthorized},
+		{"malformed body", http.MethodPost, `{`, http.StatusBadRequest},
+		{"wrong method", http.MethodGet, ``, http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/login", bytes.NewBufferString(tt.body))
+			s.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
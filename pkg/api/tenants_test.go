@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/events"
+	"ztap/pkg/quota"
+	"ztap/pkg/storage"
+)
+
+// loginTenant creates a user of tenant and returns its session token
+func loginTenant(t *testing.T, am *auth.AuthManager, username string, role auth.Role, tenant string) string {
+	t.Helper()
+	if err := am.CreateTenantUser(username, "password123", role, tenant); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	session, err := am.Authenticate(username, "password123")
+	if err != nil {
+		t.Fatalf("Failed to authenticate: %v", err)
+	}
+	return session.Token
+}
+
+func listPolicies(t *testing.T, s *Server, token, tenant string) []storage.PolicyRecord {
+	t.Helper()
+	r := policyRequest(http.MethodGet, "/policies", token, "")
+	r.Header.Set(tenantHeader, tenant)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, r)
+	var records []storage.PolicyRecord
+	if err := json.NewDecoder(rec.Body).Decode(&records); err != nil {
+		t.Fatalf("Failed to decode policies: %v (%d)", err, rec.Code)
+	}
+	return records
+}
+
+func TestTenantIsolation(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	provider := login(t, am, "root", auth.RoleAdmin)
+	acme := loginTenant(t, am, "ann", auth.RoleTenantAdmin, "acme")
+	globex := loginTenant(t, am, "gus", auth.RoleOperator, "globex")
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/web-to-db", acme, testPolicyYAML))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if got := listPolicies(t, s, acme, ""); len(got) != 1 {
+		t.Errorf("Expected acme to see its policy, got %+v", got)
+	}
+	if got := listPolicies(t, s, globex, ""); len(got) != 0 {
+		t.Errorf("Expected globex to see no policies, got %+v", got)
+	}
+	if got := listPolicies(t, s, provider, ""); len(got) != 0 {
+		t.Errorf("Expected no policies without a tenant, got %+v", got)
+	}
+	if got := listPolicies(t, s, provider, "acme"); len(got) != 1 {
+		t.Errorf("Expected the provider to see acme's policy with its tenant, got %+v", got)
+	}
+
+	// A tenant's users cannot act in another tenant
+	r := policyRequest(http.MethodGet, "/policies/web-to-db", globex, "")
+	r.Header.Set(tenantHeader, "acme")
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, r)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for another tenant, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/policies?tenant=../etc", provider, ""))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid tenant, got %d", rec.Code)
+	}
+}
+
+func TestTenantUsers(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	acme := loginTenant(t, am, "ann", auth.RoleTenantAdmin, "acme")
+	loginTenant(t, am, "gus", auth.RoleViewer, "globex")
+
+	create := func(body string) int {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, policyRequest(http.MethodPost, "/users", acme, body))
+		return rec.Code
+	}
+	if code := create(`{"username":"bob","password":"password123","role":"operator"}`); code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", code)
+	}
+	if code := create(`{"username":"eve","password":"password123","role":"admin"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected a tenant admin not to create admins, got %d", code)
+	}
+	if code := create(`{"username":"gus","password":"password123","role":"viewer"}`); code != http.StatusConflict {
+		t.Errorf("Expected usernames unique across tenants, got %d", code)
+	}
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, policyRequest(http.MethodGet, "/users", acme, ""))
+	var users []auth.User
+	json.NewDecoder(rec.Body).Decode(&users)
+	if len(users) != 2 || users[0].Username != "ann" || users[1].Username != "bob" || users[1].Tenant != "acme" {
+		t.Errorf("Expected only acme's users, got %+v", users)
+	}
+}
+
+func TestTenantEvents(t *testing.T) {
+	s, am, bus := newTestServer(t)
+	s.heartbeat = time.Hour
+	token := loginTenant(t, am, "ann", auth.RoleViewer, "acme")
+
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events?topics=flow_blocked&token="+token, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bus.PublishFor("globex", events.TopicFlowBlocked, events.FlowBlocked{Port: 22})
+	bus.PublishFor("acme", events.TopicFlowBlocked, events.FlowBlocked{Port: 443})
+
+	buf := make([]byte, 4096)
+	var body strings.Builder
+	for !strings.Contains(body.String(), "\n\n") {
+		n, err := resp.Body.Read(buf)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		body.Write(buf[:n])
+	}
+	if !strings.Contains(body.String(), `"port":443`) || strings.Contains(body.String(), `"port":22`) {
+		t.Errorf("Expected only acme's event, got %q", body.String())
+	}
+}
+
+func TestTenantQuota(t *testing.T) {
+	s, am, _ := newTestServer(t)
+	s.policies = quota.NewStore(s.policies, quota.Config{Tenants: map[string]quota.Limits{"acme": {MaxPolicies: 1}}})
+	acme := loginTenant(t, am, "ann", auth.RoleOperator, "acme")
+
+	put := func(name string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, policyRequest(http.MethodPut, "/policies/"+name, acme, testPolicyYAML))
+		return rec
+	}
+	if rec := put("web-to-db"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := put("web-to-cache")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "quota exceeded") {
+		t.Errorf("Expected 403 over the quota, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"ztap/pkg/auth"
+)
+
+// minPasswordLength matches 'ztap user create'
+const minPasswordLength = 8
+
+// CreateUserRequest is the body of POST /users
+type CreateUserRequest struct {
+	Username string       `json:"username"`
+	Password string       `json:"password"`
+	Role     auth.Role    `json:"role"`
+	Scopes   []auth.Scope `json:"scopes,omitempty"` // Limit what the user sees (see auth.Session.Sees)
+}
+
+// handleUsers serves GET /users, listing the users of the request's tenant
+// (every user for admins without a tenant and no ?tenant=), and POST /users,
+// creating a user in it, optionally limited to scopes. Tenant admins thereby manage their own tenant only.
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ctx, _, ok := s.authorizeTenant(w, r, auth.PermManageUsers)
+		if !ok {
+			return
+		}
+		users := s.auth.ListTenantUsers(auth.TenantFromContext(ctx))
+		sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+		writeJSON(w, http.StatusOK, users)
+
+	case http.MethodPost:
+		ctx, _, ok := s.authorizeTenant(w, r, auth.PermManageUsers)
+		if !ok {
+			return
+		}
+		var req CreateUserRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		if len(req.Password) < minPasswordLength {
+			writeError(w, http.StatusBadRequest, "password must be at least 8 characters")
+			return
+		}
+		if !validRole(req.Role) {
+			writeError(w, http.StatusBadRequest, "unknown role "+string(req.Role))
+			return
+		}
+
+		tenant := auth.TenantFromContext(ctx)
+		err := s.auth.CreateTenantUser(req.Username, req.Password, req.Role, tenant)
+		if err == nil && len(req.Scopes) > 0 {
+			err = s.auth.SetScopes(req.Username, req.Scopes)
+		}
+		switch {
+		case errors.Is(err, auth.ErrUserExists):
+			writeError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeJSON(w, http.StatusCreated, auth.User{Username: req.Username, Role: req.Role, Tenant: tenant, Scopes: req.Scopes, Enabled: true})
+		}
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// validRole reports whether role is one of the built-in roles
+func validRole(role auth.Role) bool {
+	switch role {
+	case auth.RoleAdmin, auth.RoleOperator, auth.RoleViewer, auth.RoleTenantAdmin:
+		return true
+	}
+	return false
+}
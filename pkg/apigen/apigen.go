@@ -0,0 +1,202 @@
+// Package apigen generates the clients of the ZTAP API from its route
+// definitions (api.Routes) and OpenAPI document: the methods of the Go
+// client (pkg/client) and a TypeScript client. The generated files are
+// checked in; regenerate them, with docs/openapi.json, by running
+//
+//	go generate ./pkg/client
+package apigen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"ztap/pkg/api"
+)
+
+// header starts every generated file
+const header = "Code generated by ztap/pkg/apigen from the API routes; DO NOT EDIT."
+
+// GoClient returns the source of the generated methods of client.Client:
+// one per route, named after its operation, taking its path parameters and
+// body. Event streams and optional query parameters are left to hand-written
+// code.
+func GoClient() ([]byte, error) {
+	imports := map[string]bool{"context": true, "net/http": true}
+	var body bytes.Buffer
+
+	for _, route := range api.Routes {
+		if route.Stream {
+			continue
+		}
+		name := exported(route.Operation)
+
+		params := []string{"ctx context.Context"}
+		path := goPath(route.Path)
+		if strings.Contains(path, "url.PathEscape") {
+			imports["net/url"] = true
+		}
+		for _, p := range api.PathParams(route.Path) {
+			params = append(params, p+" string")
+		}
+		request := "nil"
+		switch b := route.Body.(type) {
+		case nil:
+		case string:
+			params = append(params, "body []byte")
+			request = fmt.Sprintf("rawBody{contentType: %q, data: body}", route.BodyType)
+		default:
+			params = append(params, "body "+goType(reflect.TypeOf(b), imports))
+			request = "body"
+		}
+
+		// The first response with a body is returned by value, others by
+		// pointer, set only when the server answers with their status
+		var results, vars, outs, returns []string
+		for i, resp := range route.Responses {
+			if resp.Body == nil {
+				continue
+			}
+			typ := goType(reflect.TypeOf(resp.Body), imports)
+			v := "out"
+			if len(vars) > 0 {
+				v = fmt.Sprintf("out%d", resp.Status)
+				returns = append(returns, "&"+v)
+				typ = "*" + typ
+			} else {
+				returns = append(returns, v)
+			}
+			results = append(results, typ)
+			vars = append(vars, fmt.Sprintf("var %s %s", v, strings.TrimPrefix(typ, "*")))
+			outs = append(outs, fmt.Sprintf("%s: &%s", statusConst(route.Responses[i].Status), v))
+		}
+		results = append(results, "error")
+
+		fmt.Fprintf(&body, "\n// %s calls %s %s to %s\n", name, route.Method, route.Path, lowerFirst(route.Summary))
+		fmt.Fprintf(&body, "func (c *Client) %s(%s) ", name, strings.Join(params, ", "))
+		if len(results) == 1 {
+			body.WriteString("error {\n")
+		} else {
+			fmt.Fprintf(&body, "(%s) {\n", strings.Join(results, ", "))
+		}
+		for _, v := range vars {
+			body.WriteString(v + "\n")
+		}
+		responses := "nil"
+		if len(outs) > 0 {
+			responses = "map[int]any{" + strings.Join(outs, ", ") + "}"
+		}
+		call := fmt.Sprintf("c.do(ctx, %s, %s, %s, %s)", methodConst(route.Method), path, request, responses)
+		switch len(returns) {
+		case 0:
+			fmt.Fprintf(&body, "_, err := %s\nreturn err\n", call)
+		case 1:
+			fmt.Fprintf(&body, "_, err := %s\nreturn out, err\n", call)
+		default:
+			fmt.Fprintf(&body, "status, err := %s\n", call)
+			nils := make([]string, len(returns))
+			nils[0] = "out"
+			for i := 1; i < len(returns); i++ {
+				nils[i] = "nil"
+			}
+			fmt.Fprintf(&body, "if err != nil {\nreturn %s, err\n}\n", strings.Join(nils, ", "))
+			i := 0
+			for _, resp := range route.Responses {
+				if resp.Body == nil {
+					continue
+				}
+				if i++; i == 1 {
+					continue
+				}
+				set := append([]string(nil), nils...)
+				set[i-1] = returns[i-1]
+				fmt.Fprintf(&body, "if status == %s {\nreturn %s, nil\n}\n", statusConst(resp.Status), strings.Join(set, ", "))
+			}
+			fmt.Fprintf(&body, "return %s, nil\n", strings.Join(nils, ", "))
+		}
+		body.WriteString("}\n")
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// %s\n\npackage client\n\nimport (\n", header)
+	paths := make([]string, 0, len(imports))
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	local := false
+	for _, path := range paths {
+		if strings.HasPrefix(path, "ztap/") && !local {
+			src.WriteString("\n")
+			local = true
+		}
+		fmt.Fprintf(&src, "%q\n", path)
+	}
+	src.WriteString(")\n")
+	src.Write(body.Bytes())
+	return format.Source(src.Bytes())
+}
+
+// goPath returns the Go expression of a path template, escaping its
+// parameters, e.g. "/policies/"+url.PathEscape(name)
+func goPath(template string) string {
+	var parts []string
+	literal := ""
+	for _, segment := range strings.Split(strings.TrimPrefix(template, "/"), "/") {
+		literal += "/"
+		if strings.HasPrefix(segment, "{") {
+			parts = append(parts, fmt.Sprintf("%q", literal), "url.PathEscape("+strings.Trim(segment, "{}")+")")
+			literal = ""
+			continue
+		}
+		literal += segment
+	}
+	if literal != "" {
+		parts = append(parts, fmt.Sprintf("%q", literal))
+	}
+	return strings.Join(parts, "+")
+}
+
+// goType returns the Go expression of t, adding the packages it needs to
+// imports
+func goType(t reflect.Type, imports map[string]bool) string {
+	if t.PkgPath() != "" && t.Name() != "" {
+		imports[t.PkgPath()] = true
+		return t.String()
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return "*" + goType(t.Elem(), imports)
+	case reflect.Slice:
+		return "[]" + goType(t.Elem(), imports)
+	case reflect.Map:
+		return "map[" + goType(t.Key(), imports) + "]" + goType(t.Elem(), imports)
+	case reflect.Interface:
+		return "any"
+	}
+	return t.String()
+}
+
+func methodConst(method string) string {
+	return "http.Method" + method[:1] + strings.ToLower(method[1:])
+}
+
+// statusConst returns the net/http constant of a status, e.g.
+// http.StatusAccepted
+func statusConst(status int) string {
+	name := strings.NewReplacer(" ", "", "-", "").Replace(http.StatusText(status))
+	return "http.Status" + name
+}
+
+func exported(s string) string {
+	return string(unicode.ToUpper(rune(s[0]))) + s[1:]
+}
+
+func lowerFirst(s string) string {
+	return string(unicode.ToLower(rune(s[0]))) + s[1:]
+}
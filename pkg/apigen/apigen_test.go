@@ -0,0 +1,74 @@
+package apigen
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ztap/pkg/api"
+)
+
+var updateGenerated = flag.Bool("update", false, "rewrite the generated files")
+
+// TestGenerated checks that the checked-in OpenAPI document and clients are
+// up to date with api.Routes. Run with -update (or 'go generate
+// ./pkg/client') to rewrite them.
+func TestGenerated(t *testing.T) {
+	openapi, err := api.OpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	goClient, err := GoClient()
+	if err != nil {
+		t.Fatalf("Generated Go client does not compile: %v", err)
+	}
+	tsClient, err := TypeScriptClient(openapi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join("..", "..")
+	for path, got := range map[string][]byte{
+		filepath.Join(root, "docs", "openapi.json"):             openapi,
+		filepath.Join(root, "pkg", "client", "generated.go"):    goClient,
+		filepath.Join(root, "clients", "typescript", "ztap.ts"): tsClient,
+	} {
+		if *updateGenerated {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read generated file (run with -update to create it): %v", err)
+		}
+		if string(got) != string(want) {
+			t.Errorf("%s is out of date with api.Routes; run 'go generate ./pkg/client'", path)
+		}
+	}
+}
+
+func TestGoClient(t *testing.T) {
+	src, err := GoClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"func (c *Client) PutPolicy(ctx context.Context, name string, body []byte) (storage.PolicyRecord, *storage.PendingChange, error)",
+		`"/approvals/"+url.PathEscape(id)+"/approve"`,
+		"func (c *Client) Logout(ctx context.Context) error",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("Expected the Go client to contain %s", want)
+		}
+	}
+	if strings.Contains(string(src), "StreamEvents") {
+		t.Error("Expected no method for the event stream")
+	}
+}
@@ -0,0 +1,297 @@
+package apigen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// document is the part of an OpenAPI document the TypeScript client is
+// generated from
+type document struct {
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components struct {
+		Schemas map[string]schema `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	OperationID string      `json:"operationId"`
+	Summary     string      `json:"summary"`
+	Parameters  []parameter `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema schema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]struct {
+			Schema schema `json:"schema"`
+		} `json:"content"`
+	} `json:"responses"`
+}
+
+type parameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description"`
+}
+
+type schema struct {
+	Ref                  string            `json:"$ref"`
+	Type                 string            `json:"type"`
+	Format               string            `json:"format"`
+	Nullable             bool              `json:"nullable"`
+	Items                *schema           `json:"items"`
+	Properties           map[string]schema `json:"properties"`
+	AdditionalProperties *schema           `json:"additionalProperties"`
+	Required             []string          `json:"required"`
+	GoType               string            `json:"x-go-type"`
+}
+
+// tsClient is the hand-written part of the TypeScript client: errors and
+// the request helper of the generated methods
+const tsClient = `/** An error response of the API server */
+export class ApiError extends Error {
+  constructor(public readonly status: number, message: string) {
+    super(message);
+  }
+}
+
+export interface ClientOptions {
+  /** URL of the API server, e.g. https://ztap.example.com */
+  server: string;
+  /** Session token from login */
+  token?: string;
+  /** Tenant to act in, for users without a tenant of their own */
+  tenant?: string;
+  fetch?: typeof fetch;
+}
+
+/** Calls the ZTAP API server ('ztap serve') */
+export class Client {
+  private readonly server: string;
+
+  constructor(private readonly options: ClientOptions) {
+    this.server = options.server.replace(/\/$/, "");
+  }
+
+  /** Authenticates later requests with token */
+  setToken(token: string | undefined): void {
+    this.options.token = token;
+  }
+
+  private url(path: string, query?: Record<string, string | undefined>): string {
+    const params = new URLSearchParams();
+    for (const [key, value] of Object.entries(query ?? {})) {
+      if (value !== undefined) {
+        params.set(key, value);
+      }
+    }
+    const search = params.toString();
+    return this.server + path + (search ? "?" + search : "");
+  }
+
+  private async request<T>(method: string, path: string, query?: Record<string, string | undefined>, body?: unknown, contentType = "application/json"): Promise<T> {
+    const headers: Record<string, string> = {};
+    if (this.options.token) {
+      headers["Authorization"] = "Bearer " + this.options.token;
+    }
+    if (this.options.tenant) {
+      headers["X-ZTAP-Tenant"] = this.options.tenant;
+    }
+    let payload: string | undefined;
+    if (body !== undefined) {
+      headers["Content-Type"] = contentType;
+      payload = typeof body === "string" ? body : JSON.stringify(body);
+    }
+    const response = await (this.options.fetch ?? fetch)(this.url(path, query), { method, headers, body: payload });
+    if (!response.ok) {
+      const failure = await response.json().catch(() => ({}));
+      throw new ApiError(response.status, failure.error || method + " " + path + " failed");
+    }
+    if (response.status === 204) {
+      return undefined as T;
+    }
+    return (await response.json()) as T;
+  }
+`
+
+// TypeScriptClient returns the source of a TypeScript client of the API
+// described by the OpenAPI document: an interface per component schema and
+// a Client class with a method per operation. Event streams get a method
+// returning their URL, for an EventSource.
+func TypeScriptClient(openapi []byte) ([]byte, error) {
+	var doc document
+	if err := json.Unmarshal(openapi, &doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// %s\n", header)
+
+	names := make([]string, 0, len(doc.Components.Schemas))
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := doc.Components.Schemas[name]
+		b.WriteString("\n")
+		if s.GoType != "" {
+			fmt.Fprintf(&b, "/** %s */\n", s.GoType)
+		}
+		fmt.Fprintf(&b, "export interface %s %s\n", name, tsObject(s, ""))
+	}
+
+	b.WriteString("\n" + tsClient)
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		methods := make([]string, 0, len(doc.Paths[path]))
+		for method := range doc.Paths[path] {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			tsMethod(&b, path, strings.ToUpper(method), doc.Paths[path][method])
+		}
+	}
+	b.WriteString("}\n")
+	return b.Bytes(), nil
+}
+
+// tsMethod writes the Client method of an operation
+func tsMethod(b *bytes.Buffer, path, method string, op operation) {
+	var params, query []string
+	pathExpr := path
+	for _, p := range op.Parameters {
+		switch p.In {
+		case "path":
+			params = append(params, p.Name+": string")
+			pathExpr = strings.ReplaceAll(pathExpr, "{"+p.Name+"}", "${encodeURIComponent("+p.Name+")}")
+		case "query":
+			if p.Name != "tenant" {
+				query = append(query, p.Name)
+			}
+		}
+	}
+
+	body, contentType := "undefined", ""
+	if op.RequestBody != nil {
+		for ct, content := range op.RequestBody.Content {
+			params = append(params, "body: "+tsType(content.Schema, ""))
+			body, contentType = "body", ct
+		}
+	}
+	queryExpr := "undefined"
+	if len(query) > 0 {
+		fields := make([]string, len(query))
+		for i, q := range query {
+			fields[i] = q + "?: string"
+		}
+		params = append(params, "query: { "+strings.Join(fields, "; ")+" } = {}")
+		queryExpr = "query"
+	}
+
+	statuses := make([]string, 0, len(op.Responses))
+	for status := range op.Responses {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	var results []string
+	stream := false
+	for _, status := range statuses {
+		if status[0] != '2' {
+			continue
+		}
+		for ct, content := range op.Responses[status].Content {
+			if ct == "text/event-stream" {
+				stream = true
+				continue
+			}
+			results = append(results, tsType(content.Schema, ""))
+		}
+	}
+
+	fmt.Fprintf(b, "\n  /** %s %s: %s */\n", method, path, op.Summary)
+	if stream {
+		fmt.Fprintf(b, "  %sURL(%s): string {\n", op.OperationID, strings.Join(params, ", "))
+		fmt.Fprintf(b, "    return this.url(`%s`, { ...%s, token: this.options.token, tenant: this.options.tenant });\n  }\n", pathExpr, queryExpr)
+		return
+	}
+	result := "void"
+	if len(results) > 0 {
+		result = strings.Join(results, " | ")
+	}
+	fmt.Fprintf(b, "  %s(%s): Promise<%s> {\n", op.OperationID, strings.Join(params, ", "), result)
+	args := []string{fmt.Sprintf("%q", method), "`" + pathExpr + "`", queryExpr, body}
+	if contentType != "" && contentType != "application/json" {
+		args = append(args, fmt.Sprintf("%q", contentType))
+	}
+	for len(args) > 2 && args[len(args)-1] == "undefined" {
+		args = args[:len(args)-1]
+	}
+	fmt.Fprintf(b, "    return this.request(%s);\n  }\n", strings.Join(args, ", "))
+}
+
+// tsType returns the TypeScript type of a schema, indenting nested objects
+// by indent
+func tsType(s schema, indent string) string {
+	var t string
+	switch {
+	case s.Ref != "":
+		t = s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+	case s.Type == "string":
+		t = "string"
+	case s.Type == "integer", s.Type == "number":
+		t = "number"
+	case s.Type == "boolean":
+		t = "boolean"
+	case s.Type == "array":
+		t = tsType(*s.Items, indent) + "[]"
+	case s.Type == "object" && s.Properties != nil:
+		t = tsObject(s, indent)
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		t = "Record<string, " + tsType(*s.AdditionalProperties, indent) + ">"
+	case s.Type == "object":
+		t = "Record<string, unknown>"
+	default:
+		t = "unknown"
+	}
+	if s.Nullable {
+		t += " | null"
+	}
+	return t
+}
+
+// tsObject returns the TypeScript object type of an object schema; fields
+// that are not required are optional
+func tsObject(s schema, indent string) string {
+	required := make(map[string]bool)
+	for _, name := range s.Required {
+		required[name] = true
+	}
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, name := range names {
+		optional := "?"
+		if required[name] {
+			optional = ""
+		}
+		fmt.Fprintf(&b, "%s  %s%s: %s;\n", indent, name, optional, tsType(s.Properties[name], indent+"  "))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
@@ -0,0 +1,97 @@
+// Package applied records the policy file last enforced on a host, with the
+// Git commit and author it came from when the file is in a Git checkout, so
+// operators can tell which revision is enforced and diff it against the
+// working tree or another ref ('ztap policy diff').
+package applied
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State is the policy file as it was last enforced
+type State struct {
+	File      string    `json:"file"` // Absolute path of the policy file
+	Revision  *Revision `json:"revision,omitempty"`
+	YAML      string    `json:"yaml"`
+	AppliedAt time.Time `json:"applied_at"`
+	AppliedBy string    `json:"applied_by,omitempty"` // Principal of the enforcement
+}
+
+// Snapshot reads the policy file and the revision it is at. Files outside a
+// Git checkout, or when git is not installed, have no revision.
+func Snapshot(file string) (State, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return State{}, err
+	}
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return State{}, err
+	}
+	revision, _ := DetectRevision(abs)
+	return State{File: abs, Revision: revision, YAML: string(data)}, nil
+}
+
+// Same reports whether two snapshots hold the same content at the same
+// revision
+func (s State) Same(other State) bool {
+	if s.File != other.File || s.YAML != other.YAML || (s.Revision == nil) != (other.Revision == nil) {
+		return false
+	}
+	return s.Revision == nil || *s.Revision == *other.Revision
+}
+
+// Load returns the state saved at path, or nil if nothing was recorded
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid applied state %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// Save writes state to a temporary file and renames it over path
+func Save(path string, state State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(path, data); err != nil {
+		return fmt.Errorf("failed to save applied state: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes data to a temporary file and renames it over path, so
+// readers never see a partial file
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,125 @@
+package applied
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/policy"
+)
+
+// gitRepo creates a repository with policy.yaml committed, skipping the test
+// without git
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=Alice", "-c", "user.email=alice@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	os.MkdirAll(filepath.Join(dir, "policies"), 0700)
+	os.WriteFile(filepath.Join(dir, "policies", "policy.yaml"), []byte("v1\n"), 0600)
+	git("add", ".")
+	git("commit", "-q", "-m", "v1")
+	git("tag", "v1")
+	os.WriteFile(filepath.Join(dir, "policies", "policy.yaml"), []byte("v2\n"), 0600)
+	git("commit", "-q", "-am", "v2")
+	return dir
+}
+
+func TestSnapshotRevision(t *testing.T) {
+	dir := gitRepo(t)
+	file := filepath.Join(dir, "policies", "policy.yaml")
+
+	snapshot, err := Snapshot(file)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	r := snapshot.Revision
+	if r == nil || len(r.Commit) != 40 || r.Author != "Alice <alice@example.com>" || r.Path != "policies/policy.yaml" || r.Dirty {
+		t.Fatalf("Unexpected revision %+v", r)
+	}
+	if snapshot.YAML != "v2\n" {
+		t.Errorf("Unexpected content %q", snapshot.YAML)
+	}
+
+	os.WriteFile(file, []byte("v3\n"), 0600)
+	dirty, _ := Snapshot(file)
+	if dirty.Revision == nil || !dirty.Revision.Dirty || !strings.HasSuffix(dirty.Revision.Short(), "+dirty") || dirty.Same(snapshot) {
+		t.Errorf("Expected a dirty revision, got %+v", dirty.Revision)
+	}
+
+	content, commit, err := Show(file, "v1")
+	if err != nil || content != "v1" || len(commit) != 40 || commit == r.Commit {
+		t.Errorf("Unexpected content at v1: %q, %s, %v", content, commit, err)
+	}
+	if _, _, err := Show(file, "no-such-ref"); err == nil {
+		t.Error("Expected an error for an unknown ref")
+	}
+}
+
+func TestSnapshotOutsideGit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	os.WriteFile(file, []byte("v1\n"), 0600)
+	snapshot, err := Snapshot(file)
+	if err != nil || snapshot.Revision != nil || snapshot.File != file {
+		t.Errorf("Expected a snapshot without revision, got %+v, %v", snapshot, err)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ztap", "applied.json")
+	if state, err := Load(path); err != nil || state != nil {
+		t.Fatalf("Expected no state, got %+v, %v", state, err)
+	}
+
+	state := State{
+		File:      "/etc/ztap/policy.yaml",
+		Revision:  &Revision{Commit: "0123456789abcdef", Author: "Alice <alice@example.com>", Path: "policy.yaml"},
+		YAML:      "v1\n",
+		AppliedAt: time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC),
+		AppliedBy: "alice",
+	}
+	if err := Save(path, state); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil || loaded == nil || !loaded.Same(state) || loaded.AppliedBy != "alice" || loaded.Revision.Short() != "0123456" {
+		t.Errorf("Unexpected state %+v, %v", loaded, err)
+	}
+}
+
+func TestRuleSetRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "last-known-good.json")
+	if set, err := LoadRuleSet(path); err != nil || set != nil {
+		t.Fatalf("Expected no rule set, got %v, %v", set, err)
+	}
+
+	saved := RuleSet{
+		Policies: []*policy.CompiledPolicy{{Name: "web-to-db", Hash: "abc", Rules: []policy.Rule{{Policy: "web-to-db", CIDR: "10.0.2.1/32", Protocol: "TCP", Port: 5432}}}},
+		SavedAt:  time.Now().Add(-2 * time.Hour).UTC(),
+	}
+	if err := SaveRuleSet(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	set, err := LoadRuleSet(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Policies) != 1 || set.Policies[0].Rules[0].Port != 5432 || !set.SavedAt.Equal(saved.SavedAt) {
+		t.Errorf("Unexpected rule set %+v", set)
+	}
+	if age := set.Age(time.Now()); age < 2*time.Hour {
+		t.Errorf("Expected an age of at least 2h, got %s", age)
+	}
+}
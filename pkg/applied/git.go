@@ -0,0 +1,89 @@
+package applied
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotInGit is returned for a file outside a Git checkout
+var ErrNotInGit = errors.New("not in a git checkout")
+
+// Revision is the Git commit a policy file was read at
+type Revision struct {
+	Commit string `json:"commit"`
+	Author string `json:"author"` // Of the commit, as "Name <email>"
+	Path   string `json:"path"`   // Of the file, relative to the repository root
+	// Dirty is set when the file differed from the commit
+	Dirty bool `json:"dirty,omitempty"`
+}
+
+// Short returns the abbreviated commit, marked when the file was modified
+func (r Revision) Short() string {
+	commit := r.Commit
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	if r.Dirty {
+		commit += "+dirty"
+	}
+	return commit
+}
+
+// runGit runs git in dir and returns its trimmed output
+func runGit(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// DetectRevision returns the HEAD commit of the checkout holding file and
+// whether file has uncommitted changes. Untracked files are ErrNotInGit.
+func DetectRevision(file string) (*Revision, error) {
+	dir, base := filepath.Split(file)
+	if dir == "" {
+		dir = "."
+	}
+	path, err := runGit(dir, "ls-files", "--full-name", "--error-unmatch", "--", base)
+	if err != nil {
+		return nil, ErrNotInGit
+	}
+	commit, err := runGit(dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	author, err := runGit(dir, "log", "-1", "--format=%an <%ae>", commit)
+	if err != nil {
+		return nil, err
+	}
+	status, err := runGit(dir, "status", "--porcelain", "--", base)
+	if err != nil {
+		return nil, err
+	}
+	return &Revision{Commit: commit, Author: author, Path: path, Dirty: status != ""}, nil
+}
+
+// Show returns the content of file at ref (a commit, branch or tag of the
+// checkout holding file) and the commit ref resolves to
+func Show(file, ref string) (content, commit string, err error) {
+	dir, base := filepath.Split(file)
+	if dir == "" {
+		dir = "."
+	}
+	if commit, err = runGit(dir, "rev-parse", "--verify", ref+"^{commit}"); err != nil {
+		return "", "", fmt.Errorf("unknown ref %s: %w", ref, err)
+	}
+	if content, err = runGit(dir, "show", commit+":./"+base); err != nil {
+		return "", "", fmt.Errorf("%s does not exist at %s", base, ref)
+	}
+	return content, commit, nil
+}
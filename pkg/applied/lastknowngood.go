@@ -0,0 +1,52 @@
+package applied
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"ztap/pkg/policy"
+)
+
+// RuleSet is the last rule set an agent compiled and enforced in full. The
+// agent re-enforces it on restart while the dependencies needed to compile
+// its policies are unavailable.
+type RuleSet struct {
+	Policies []*policy.CompiledPolicy `json:"policies"`
+	SavedAt  time.Time                `json:"saved_at"`
+	SavedBy  string                   `json:"saved_by,omitempty"` // Node that enforced it
+}
+
+// Age returns how long ago the rule set was saved
+func (r RuleSet) Age(now time.Time) time.Duration {
+	return now.Sub(r.SavedAt)
+}
+
+// LoadRuleSet returns the rule set saved at path, or nil if none was saved
+func LoadRuleSet(path string) (*RuleSet, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var set RuleSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid last-known-good rule set %s: %w", path, err)
+	}
+	return &set, nil
+}
+
+// SaveRuleSet writes set to a temporary file and renames it over path
+func SaveRuleSet(path string, set RuleSet) error {
+	data, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(path, data); err != nil {
+		return fmt.Errorf("failed to save last-known-good rule set: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+// Package approval implements the two-person rule for high-risk policy
+// changes: a policy matching one of the configured risk rules is held as a
+// pending change until an admin other than its author approves it.
+package approval
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/configfile"
+	"ztap/pkg/events"
+	"ztap/pkg/policy"
+	"ztap/pkg/storage"
+)
+
+// ErrSelfApproval is returned when the author of a change tries to approve it
+var ErrSelfApproval = errors.New("a change must be approved by someone other than its author")
+
+// Rule is a risk criterion. A policy matches when one of its egress rules
+// matches every field set: CIDR when the egress ipBlock covers all of it
+// (0.0.0.0/0 matches only egress to anywhere), Port and Protocol when the
+// egress allows that port, on its own or within a port range.
+type Rule struct {
+	Name     string `yaml:"name"`
+	CIDR     string `yaml:"cidr"`
+	Port     int    `yaml:"port"`
+	Protocol string `yaml:"protocol"`
+}
+
+// String names the rule in pending change reasons
+func (r Rule) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	var parts []string
+	if r.CIDR != "" {
+		parts = append(parts, "destination "+r.CIDR)
+	}
+	if r.Port != 0 {
+		parts = append(parts, fmt.Sprintf("port %d", r.Port))
+	}
+	if r.Protocol != "" {
+		parts = append(parts, strings.ToUpper(r.Protocol))
+	}
+	return strings.Join(parts, " ")
+}
+
+// matches reports whether egress to cidr (empty for a pod selector) on the
+// ports of port matches the rule
+func (r Rule) matches(cidr string, port policy.PolicyPort) bool {
+	if r.Port != 0 && !port.CoversPort(r.Port) {
+		return false
+	}
+	if r.Protocol != "" && !strings.EqualFold(r.Protocol, port.Protocol) {
+		return false
+	}
+	if r.CIDR == "" {
+		return true
+	}
+
+	_, allowed, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	_, risky, _ := net.ParseCIDR(r.CIDR)
+	allowedOnes, allowedBits := allowed.Mask.Size()
+	riskyOnes, riskyBits := risky.Mask.Size()
+	return allowedBits == riskyBits && allowedOnes <= riskyOnes && allowed.Contains(risky.IP)
+}
+
+// Config is the approval section of config.yaml. Approval is required only
+// when Rules is not empty.
+type Config struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Enabled reports whether any change can require approval
+func (c Config) Enabled() bool {
+	return len(c.Rules) > 0
+}
+
+// Validate checks the rules
+func (c Config) Validate() error {
+	for i, rule := range c.Rules {
+		if rule.CIDR == "" && rule.Port == 0 {
+			return fmt.Errorf("approval.rules[%d]: cidr or port is required", i)
+		}
+		if rule.CIDR != "" {
+			if _, _, err := net.ParseCIDR(rule.CIDR); err != nil {
+				return fmt.Errorf("approval.rules[%d]: invalid cidr %q", i, rule.CIDR)
+			}
+		}
+		if rule.Port < 0 || rule.Port > 65535 {
+			return fmt.Errorf("approval.rules[%d]: port %d out of range", i, rule.Port)
+		}
+	}
+	return nil
+}
+
+// Risks returns why policies need approval, one reason per matching rule
+// and policy, or nil if they do not
+func (c Config) Risks(policies []policy.NetworkPolicy) []string {
+	var reasons []string
+	for _, p := range policies {
+		for _, rule := range c.Rules {
+			if matchesPolicy(rule, &p) {
+				reasons = append(reasons, fmt.Sprintf("policy %s matches %s", p.Metadata.Name, rule))
+			}
+		}
+	}
+	return reasons
+}
+
+func matchesPolicy(rule Rule, p *policy.NetworkPolicy) bool {
+	for _, egress := range p.Spec.Egress {
+		for _, port := range egress.Ports {
+			if rule.matches(egress.To.IPBlock.CIDR, port) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LoadConfig reads the approval section of file. A missing file or section
+// requires no approvals.
+func LoadConfig(file *configfile.File) (Config, error) {
+	var c Config
+	if err := file.Section("approval", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Gate stores policy changes, holding those that match a risk rule until
+// they are approved. Each step is published on the default event bus.
+type Gate struct {
+	config   Config
+	policies storage.PolicyStore
+	pending  storage.PendingStore
+	now      func() time.Time
+}
+
+// NewGate creates a gate writing approved changes to policies and holding
+// risky ones in pending
+func NewGate(config Config, policies storage.PolicyStore, pending storage.PendingStore) *Gate {
+	return &Gate{config: config, policies: policies, pending: pending, now: time.Now}
+}
+
+// Submit stores the policy YAML as name on behalf of principal, unless it
+// matches a risk rule: then it is held and returned as a pending change, and
+// the stored policy is left as it was.
+func (g *Gate) Submit(ctx context.Context, name, document, principal string) (storage.PolicyRecord, *storage.PendingChange, error) {
+	policies, err := policy.Parse([]byte(document))
+	if err != nil {
+		return storage.PolicyRecord{}, nil, fmt.Errorf("invalid policy YAML: %v", err)
+	}
+
+	reasons := g.config.Risks(policies)
+	if len(reasons) == 0 {
+		record, err := g.policies.PutPolicy(ctx, name, document, principal)
+		return record, nil, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return storage.PolicyRecord{}, nil, err
+	}
+	change := storage.PendingChange{
+		ID:          id,
+		Policy:      name,
+		YAML:        document,
+		Reasons:     reasons,
+		RequestedBy: principal,
+		RequestedAt: g.now().UTC(),
+	}
+	if err := g.pending.AddPending(ctx, change); err != nil {
+		return storage.PolicyRecord{}, nil, err
+	}
+	publish(ctx, change, events.ApprovalRequested, principal)
+	return storage.PolicyRecord{}, &change, nil
+}
+
+// Pending returns the changes awaiting approval, oldest first
+func (g *Gate) Pending(ctx context.Context) ([]storage.PendingChange, error) {
+	return g.pending.ListPending(ctx)
+}
+
+// Approve stores the pending change with id on behalf of approver, who must
+// not be its author. The stored policy is attributed to the author.
+func (g *Gate) Approve(ctx context.Context, id, approver string) (storage.PolicyRecord, error) {
+	change, err := g.pending.GetPending(ctx, id)
+	if err != nil {
+		return storage.PolicyRecord{}, err
+	}
+	if change.RequestedBy == approver {
+		return storage.PolicyRecord{}, ErrSelfApproval
+	}
+
+	// Claim the change so a concurrent approval cannot store it twice
+	if change, err = g.pending.TakePending(ctx, id); err != nil {
+		return storage.PolicyRecord{}, err
+	}
+	record, err := g.policies.PutPolicy(ctx, change.Policy, change.YAML, change.RequestedBy)
+	if err != nil {
+		if restoreErr := g.pending.AddPending(ctx, change); restoreErr != nil {
+			err = errors.Join(err, fmt.Errorf("change %s was lost: %w", id, restoreErr))
+		}
+		return storage.PolicyRecord{}, err
+	}
+	publish(ctx, change, events.ApprovalApproved, approver)
+	return record, nil
+}
+
+// Reject discards the pending change with id on behalf of principal
+func (g *Gate) Reject(ctx context.Context, id, principal string) (storage.PendingChange, error) {
+	change, err := g.pending.TakePending(ctx, id)
+	if err != nil {
+		return storage.PendingChange{}, err
+	}
+	publish(ctx, change, events.ApprovalRejected, principal)
+	return change, nil
+}
+
+// publish publishes a step of the workflow as an event of the context's
+// tenant
+func publish(ctx context.Context, change storage.PendingChange, action, principal string) {
+	events.Default().PublishFor(auth.TenantFromContext(ctx), events.TopicPolicyApproval, events.PolicyApproval{
+		ID:          change.ID,
+		Policy:      change.Policy,
+		Action:      action,
+		Principal:   principal,
+		RequestedBy: change.RequestedBy,
+		Reasons:     change.Reasons,
+	})
+}
+
+// newID returns a random pending change ID
+func newID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,207 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ztap/pkg/configfile"
+	"ztap/pkg/events"
+	"ztap/pkg/policy"
+	"ztap/pkg/storage"
+)
+
+const egressPolicy = `apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: %s
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: %s
+      ports:
+        - protocol: TCP
+          port: %d
+`
+
+func policyYAML(name, cidr string, port int) string {
+	return fmt.Sprintf(egressPolicy, name, cidr, port)
+}
+
+func TestRisks(t *testing.T) {
+	config := Config{Rules: []Rule{
+		{Name: "internet", CIDR: "0.0.0.0/0"},
+		{CIDR: "10.1.0.0/16", Port: 22, Protocol: "tcp"},
+	}}
+
+	tests := []struct {
+		name  string
+		cidr  string
+		port  int
+		risks int
+	}{
+		{"anywhere", "0.0.0.0/0", 443, 1},
+		{"subnet", "10.0.0.0/8", 443, 0},
+		{"ssh covering subnet", "10.0.0.0/8", 22, 1},
+		{"ssh inside subnet", "10.1.2.0/24", 22, 0},
+		{"ssh elsewhere", "192.168.0.0/16", 22, 0},
+		{"ipv6", "::/0", 22, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policies, err := policy.Parse([]byte(policyYAML("p", tt.cidr, tt.port)))
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if risks := config.Risks(policies); len(risks) != tt.risks {
+				t.Errorf("Risks() = %v, want %d", risks, tt.risks)
+			}
+		})
+	}
+
+	policies, _ := policy.Parse([]byte(policyYAML("p", "0.0.0.0/0", 22)))
+	risks := config.Risks(policies)
+	if len(risks) != 2 || risks[0] != "policy p matches internet" || risks[1] != "policy p matches destination 10.1.0.0/16 port 22 TCP" {
+		t.Errorf("Unexpected reasons %v", risks)
+	}
+
+	// A port range covering the risky port matches it
+	policies, _ = policy.Parse([]byte(strings.Replace(policyYAML("p", "10.0.0.0/8", 20), "port: 20", "port: 20-30", 1)))
+	if risks := config.Risks(policies); len(risks) != 1 {
+		t.Errorf("Expected the range to match the ssh rule, got %v", risks)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		rule  Rule
+		valid bool
+	}{
+		{Rule{CIDR: "0.0.0.0/0"}, true},
+		{Rule{Port: 22}, true},
+		{Rule{Protocol: "TCP"}, false},
+		{Rule{CIDR: "anywhere"}, false},
+		{Rule{Port: 70000}, false},
+	}
+	for _, tt := range tests {
+		if err := (Config{Rules: []Rule{tt.rule}}).Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, want valid=%v", tt.rule, err, tt.valid)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	if config, err := configfile.Read(filepath.Join(dir, "missing.yaml"), LoadConfig); err != nil || config.Enabled() {
+		t.Errorf("Expected approval disabled without a config file, got %+v, %v", config, err)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("approval:\n  rules:\n    - name: ssh\n      port: 22\n"), 0600)
+	config, err := configfile.Read(path, LoadConfig)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if !config.Enabled() || config.Rules[0].Port != 22 {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	os.WriteFile(path, []byte("approval:\n  rules:\n    - name: empty\n"), 0600)
+	if _, err := configfile.Read(path, LoadConfig); err == nil {
+		t.Error("Expected error for a rule without cidr or port")
+	}
+}
+
+func newGate(t *testing.T) (*Gate, storage.PolicyStore) {
+	t.Helper()
+	dir := t.TempDir()
+	policies := storage.NewFilePolicyStore(filepath.Join(dir, "policies.json"))
+	pending := storage.NewFilePendingStore(filepath.Join(dir, "pending.json"))
+	return NewGate(Config{Rules: []Rule{{Name: "ssh", Port: 22}}}, policies, pending), policies
+}
+
+func TestSubmitStoresLowRiskChanges(t *testing.T) {
+	gate, policies := newGate(t)
+	ctx := context.Background()
+
+	record, change, err := gate.Submit(ctx, "web", policyYAML("web", "10.0.0.0/8", 443), "alice")
+	if err != nil || change != nil {
+		t.Fatalf("Submit = %+v, %v", change, err)
+	}
+	if record.Version != 1 || record.UpdatedBy != "alice" {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if _, err := policies.GetPolicy(ctx, "web"); err != nil {
+		t.Errorf("Expected policy stored, got %v", err)
+	}
+}
+
+func TestApproveRequiresSecondAdmin(t *testing.T) {
+	gate, policies := newGate(t)
+	ctx := context.Background()
+
+	subCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sub := events.Default().Subscribe(subCtx, events.TopicPolicyApproval)
+
+	_, change, err := gate.Submit(ctx, "ssh", policyYAML("ssh", "10.0.0.0/24", 22), "alice")
+	if err != nil || change == nil {
+		t.Fatalf("Expected a pending change, got %+v, %v", change, err)
+	}
+	if len(change.Reasons) != 1 || change.RequestedBy != "alice" {
+		t.Errorf("Unexpected pending change %+v", change)
+	}
+	if _, err := policies.GetPolicy(ctx, "ssh"); !errors.Is(err, storage.ErrPolicyNotFound) {
+		t.Errorf("Expected policy held back, got %v", err)
+	}
+
+	if _, err := gate.Approve(ctx, change.ID, "alice"); !errors.Is(err, ErrSelfApproval) {
+		t.Errorf("Expected ErrSelfApproval, got %v", err)
+	}
+	record, err := gate.Approve(ctx, change.ID, "bob")
+	if err != nil {
+		t.Fatalf("Approve failed: %v", err)
+	}
+	if record.Name != "ssh" || record.UpdatedBy != "alice" {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if _, err := gate.Approve(ctx, change.ID, "carol"); !errors.Is(err, storage.ErrChangeNotFound) {
+		t.Errorf("Expected ErrChangeNotFound on second approval, got %v", err)
+	}
+
+	for _, want := range []string{events.ApprovalRequested, events.ApprovalApproved} {
+		event := <-sub
+		data := event.Data.(events.PolicyApproval)
+		if data.Action != want || data.ID != change.ID {
+			t.Errorf("Expected %s event for %s, got %+v", want, change.ID, data)
+		}
+	}
+}
+
+func TestReject(t *testing.T) {
+	gate, policies := newGate(t)
+	ctx := context.Background()
+
+	_, change, err := gate.Submit(ctx, "ssh", policyYAML("ssh", "10.0.0.0/24", 22), "alice")
+	if err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+	if _, err := gate.Reject(ctx, change.ID, "bob"); err != nil {
+		t.Fatalf("Reject failed: %v", err)
+	}
+	if pending, _ := gate.Pending(ctx); len(pending) != 0 {
+		t.Errorf("Expected no pending changes, got %+v", pending)
+	}
+	if _, err := policies.GetPolicy(ctx, "ssh"); !errors.Is(err, storage.ErrPolicyNotFound) {
+		t.Errorf("Expected rejected policy not stored, got %v", err)
+	}
+}
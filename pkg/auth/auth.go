@@ -1,17 +1,20 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/json"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
+	"regexp"
 	"sync"
 	"time"
+
+	"ztap/pkg/clock"
+	"ztap/pkg/logging"
 )
 
 // Role represents a user role
@@ -21,6 +24,12 @@ const (
 	RoleAdmin    Role = "admin"
 	RoleOperator Role = "operator"
 	RoleViewer   Role = "viewer"
+	// RoleTenantAdmin manages the users, policies and approvals of its own
+	// tenant only
+	RoleTenantAdmin Role = "tenant-admin"
+	// RoleNode is held by node service accounts only (see
+	// ProvisionServiceAccount)
+	RoleNode Role = "node"
 )
 
 // Permission represents an action permission
@@ -33,6 +42,12 @@ const (
 	PermViewStatus   Permission = "view_status"
 	PermManageUsers  Permission = "manage_users"
 	PermViewMetrics  Permission = "view_metrics"
+	PermApprove      Permission = "approve"     // Approve or reject high-risk policy changes
+	PermBreakGlass   Permission = "break_glass" // Suspend enforcement for incident response
+	// PermReportStatus and PermFetchNodePolicies let a node service account
+	// act for its own node only
+	PermReportStatus      Permission = "report_status"
+	PermFetchNodePolicies Permission = "fetch_node_policies"
 )
 
 // User represents an authenticated user
@@ -43,23 +58,61 @@ type User struct {
 	CreatedAt    time.Time `json:"created_at"`
 	LastLogin    time.Time `json:"last_login,omitempty"`
 	Enabled      bool      `json:"enabled"`
+	// Tenant is the organization the user belongs to. Users without one
+	// operate the deployment and may act in any tenant.
+	Tenant string `json:"tenant,omitempty"`
+	// Node and Labels are set on node service accounts: the node the
+	// account acts for and the labels its policies are selected by
+	Node   string            `json:"node,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Scopes limit what the user sees to the flows, services and policies of
+	// workloads matching one of them (see Session.Sees); none sees everything
+	Scopes []Scope `json:"scopes,omitempty"`
+	// ExternalID and Groups are set on users provisioned by an identity
+	// provider: its ID for the user and the groups it reports the user in.
+	// Provisioned marks users created through SCIM, the only ones it manages.
+	ExternalID  string   `json:"external_id,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	Provisioned bool     `json:"provisioned,omitempty"`
 }
 
 // Session represents an active user session
 type Session struct {
-	Token     string    `json:"token"`
-	Username  string    `json:"username"`
-	Role      Role      `json:"role"`
-	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at"`
+	Token    string `json:"token,omitempty"` // Not persisted, see sessionKey
+	Username string `json:"username"`
+	Role     Role   `json:"role"`
+	Tenant   string `json:"tenant,omitempty"` // The user's tenant
+	// Node and Labels are copied from a node service account
+	Node      string            `json:"node,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Scopes    []Scope           `json:"scopes,omitempty"` // The user's, kept current by ValidateSession
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
 }
 
-// AuthManager manages authentication and authorization
+// ResolveTenant returns the tenant a request for tenant acts in. Users of a
+// tenant always act in their own, and requesting another is denied; users
+// without a tenant act in the one requested, "" meaning all or none.
+func (s *Session) ResolveTenant(tenant string) (string, error) {
+	if s.Tenant == "" {
+		return tenant, nil
+	}
+	if tenant != "" && tenant != s.Tenant {
+		return "", ErrTenantDenied
+	}
+	return s.Tenant, nil
+}
+
+// AuthManager manages authentication and authorization. Users and sessions
+// are persisted in a Store, so a token from 'ztap user login' is valid in
+// later CLI invocations and in 'ztap serve', and API server replicas sharing
+// a store share logins.
 type AuthManager struct {
 	users    map[string]*User
-	sessions map[string]*Session
+	sessions map[string]*Session // Keyed by sessionKey
 	mu       sync.RWMutex
-	dbPath   string
+	store    Store
+	clock    clock.Clock
 }
 
 // Role permissions mapping
@@ -71,6 +124,8 @@ var rolePermissions = map[Role][]Permission{
 		PermViewStatus,
 		PermManageUsers,
 		PermViewMetrics,
+		PermApprove,
+		PermBreakGlass,
 	},
 	RoleOperator: {
 		PermEnforce,
@@ -85,6 +140,19 @@ var rolePermissions = map[Role][]Permission{
 		PermViewStatus,
 		PermViewMetrics,
 	},
+	RoleTenantAdmin: {
+		PermEnforce,
+		PermViewPolicies,
+		PermViewLogs,
+		PermViewStatus,
+		PermManageUsers,
+		PermViewMetrics,
+		PermApprove,
+	},
+	RoleNode: {
+		PermReportStatus,
+		PermFetchNodePolicies,
+	},
 }
 
 var (
@@ -95,43 +163,71 @@ var (
 	ErrSessionNotFound    = errors.New("session not found")
 	ErrPermissionDenied   = errors.New("permission denied")
 	ErrUserExists         = errors.New("user already exists")
+	ErrTenantDenied       = errors.New("access to tenant denied")
+	ErrInvalidTenant      = errors.New("invalid tenant name (use lowercase letters, digits and dashes)")
 )
 
-// NewAuthManager creates a new authentication manager
+// tenantPattern matches tenant names, which are used in file paths and DNS
+// style identifiers
+var tenantPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// ValidateTenant checks a tenant name; "" (no tenant) is valid
+func ValidateTenant(tenant string) error {
+	if tenant != "" && !tenantPattern.MatchString(tenant) {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+// NewAuthManager creates a new authentication manager storing users in the
+// JSON file dbPath
 func NewAuthManager(dbPath string) (*AuthManager, error) {
+	return NewAuthManagerWithStore(NewFileStore(dbPath))
+}
+
+// NewAuthManagerWithStore creates a new authentication manager persisting
+// users and sessions in store
+func NewAuthManagerWithStore(store Store) (*AuthManager, error) {
 	am := &AuthManager{
-		users:    make(map[string]*User),
 		sessions: make(map[string]*Session),
-		dbPath:   dbPath,
-	}
-
-	// Load existing users from disk
-	if err := am.loadUsers(); err != nil {
-		// If file doesn't exist, create default admin user
-		if os.IsNotExist(err) {
-			if err := am.createDefaultAdmin(); err != nil {
-				return nil, fmt.Errorf("failed to create default admin: %w", err)
-			}
-		} else {
-			return nil, fmt.Errorf("failed to load users: %w", err)
+		store:    store,
+		clock:    clock.Real,
+	}
+
+	users, err := store.Users()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load users: %w", err)
+	}
+	am.users = users
+
+	// A new store starts with the default admin user
+	if len(users) == 0 {
+		if err := am.createDefaultAdmin(); err != nil {
+			return nil, fmt.Errorf("failed to create default admin: %w", err)
 		}
 	}
 
 	return am, nil
 }
 
+// SetClock replaces the clock sessions are created and expired by, e.g.
+// with a clock.Fake in tests
+func (am *AuthManager) SetClock(clk clock.Clock) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.clock = clock.OrReal(clk)
+}
+
 // createDefaultAdmin creates a default admin user
 func (am *AuthManager) createDefaultAdmin() error {
 	defaultPassword := "ztap-admin-change-me"
 
-	log.Printf("WARNING: Creating default admin user with password: %s", defaultPassword)
+	// The default password is documented; logs must not repeat it
+	logging.Register(defaultPassword)
+	log.Println("WARNING: Creating default admin user 'admin' with the default password (see the README)")
 	log.Println("WARNING: Please change the password immediately using 'ztap user change-password'")
 
-	if err := am.CreateUser("admin", defaultPassword, RoleAdmin); err != nil {
-		return err
-	}
-
-	return am.saveUsers()
+	return am.CreateUser("admin", defaultPassword, RoleAdmin)
 }
 
 // HashPassword creates a hash of the password
@@ -140,8 +236,28 @@ func HashPassword(password string) string {
 	return base64.StdEncoding.EncodeToString(hash[:])
 }
 
-// CreateUser creates a new user
+// CreateUser creates a new user without a tenant
 func (am *AuthManager) CreateUser(username, password string, role Role) error {
+	return am.CreateTenantUser(username, password, role, "")
+}
+
+// CreateTenantUser creates a new user in tenant. Usernames are unique across
+// tenants. A tenant's users cannot hold the admin role, and the tenant-admin
+// role requires a tenant.
+func (am *AuthManager) CreateTenantUser(username, password string, role Role, tenant string) error {
+	if err := ValidateTenant(tenant); err != nil {
+		return err
+	}
+	if tenant != "" && role == RoleAdmin {
+		return fmt.Errorf("users of tenant %s cannot be %s; use %s", tenant, RoleAdmin, RoleTenantAdmin)
+	}
+	if tenant == "" && role == RoleTenantAdmin {
+		return fmt.Errorf("role %s requires a tenant", RoleTenantAdmin)
+	}
+	if role == RoleNode || isServiceAccountName(username) {
+		return fmt.Errorf("role %s and usernames starting with %q are reserved for node service accounts, created by 'ztap cluster join'", RoleNode, serviceAccountPrefix)
+	}
+
 	am.mu.Lock()
 	defer am.mu.Unlock()
 
@@ -153,12 +269,16 @@ func (am *AuthManager) CreateUser(username, password string, role Role) error {
 		Username:     username,
 		PasswordHash: HashPassword(password),
 		Role:         role,
-		CreatedAt:    time.Now(),
+		CreatedAt:    am.clock.Now(),
 		Enabled:      true,
+		Tenant:       tenant,
 	}
 
+	if err := am.store.PutUser(user); err != nil {
+		return err
+	}
 	am.users[username] = user
-	return am.saveUsers()
+	return nil
 }
 
 // Authenticate validates credentials and creates a session
@@ -166,6 +286,11 @@ func (am *AuthManager) Authenticate(username, password string) (*Session, error)
 	am.mu.Lock()
 	defer am.mu.Unlock()
 
+	// Pick up users created or changed through other managers of the store
+	if users, err := am.store.Users(); err == nil {
+		am.users = users
+	}
+
 	user, exists := am.users[username]
 	if !exists {
 		return nil, ErrUserNotFound
@@ -181,7 +306,8 @@ func (am *AuthManager) Authenticate(username, password string) (*Session, error)
 	}
 
 	// Update last login
-	user.LastLogin = time.Now()
+	now := am.clock.Now()
+	user.LastLogin = now
 
 	// Create session
 	token, err := generateToken()
@@ -193,13 +319,20 @@ func (am *AuthManager) Authenticate(username, password string) (*Session, error)
 		Token:     token,
 		Username:  username,
 		Role:      user.Role,
-		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+		Tenant:    user.Tenant,
+		Node:      user.Node,
+		Labels:    user.Labels,
+		Scopes:    user.Scopes,
+		CreatedAt: now,
+		ExpiresAt: now.Add(24 * time.Hour),
 	}
 
-	am.sessions[token] = session
+	am.sessions[sessionKey(token)] = session
 
-	if err := am.saveUsers(); err != nil {
+	if err := am.store.PutUser(user); err != nil {
+		return nil, err
+	}
+	if err := am.store.PutSession(sessionKey(token), session); err != nil {
 		return nil, err
 	}
 
@@ -211,14 +344,34 @@ func (am *AuthManager) ValidateSession(token string) (*Session, error) {
 	am.mu.RLock()
 	defer am.mu.RUnlock()
 
-	session, exists := am.sessions[token]
+	key := sessionKey(token)
+	session, exists := am.sessions[key]
 	if !exists {
-		return nil, ErrSessionNotFound
+		// Created by another process since this manager was loaded
+		stored, err := am.store.Session(key)
+		if err != nil {
+			return nil, ErrSessionNotFound
+		}
+		session = stored
 	}
 
-	if time.Now().After(session.ExpiresAt) {
+	if am.clock.Now().After(session.ExpiresAt) {
 		return nil, ErrSessionExpired
 	}
+	if session.Node != "" && !am.serviceAccountValid(session) {
+		return nil, ErrSessionNotFound
+	}
+	if user, ok := am.users[session.Username]; ok {
+		// Disabling a user ends its sessions
+		if !user.Enabled {
+			return nil, ErrUserDisabled
+		}
+		// Sessions follow the user's current role and scopes, so demoting
+		// or narrowing a user takes effect at once
+		current := *session
+		current.Role, current.Scopes = user.Role, user.Scopes
+		session = &current
+	}
 
 	return session, nil
 }
@@ -249,8 +402,26 @@ func (am *AuthManager) Logout(token string) error {
 	am.mu.Lock()
 	defer am.mu.Unlock()
 
-	delete(am.sessions, token)
-	return nil
+	delete(am.sessions, sessionKey(token))
+	return am.store.DeleteSession(sessionKey(token))
+}
+
+// SetScopes replaces the scopes limiting what a user sees (none lifts the
+// limit), in the user's open sessions too
+func (am *AuthManager) SetScopes(username string, scopes []Scope) error {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	user, exists := am.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+
+	user.Scopes = scopes
+	if err := am.store.PutUser(user); err != nil {
+		return err
+	}
+	return am.refreshSessions(user)
 }
 
 // ChangePassword changes a user's password
@@ -269,7 +440,7 @@ func (am *AuthManager) ChangePassword(username, oldPassword, newPassword string)
 	}
 
 	user.PasswordHash = HashPassword(newPassword)
-	return am.saveUsers()
+	return am.store.PutUser(user)
 }
 
 // DisableUser disables a user account
@@ -283,7 +454,7 @@ func (am *AuthManager) DisableUser(username string) error {
 	}
 
 	user.Enabled = false
-	return am.saveUsers()
+	return am.store.PutUser(user)
 }
 
 // EnableUser enables a user account
@@ -297,16 +468,90 @@ func (am *AuthManager) EnableUser(username string) error {
 	}
 
 	user.Enabled = true
-	return am.saveUsers()
+	return am.store.PutUser(user)
+}
+
+// SetPassword replaces a user's password without the old one, for
+// administrators and provisioning
+func (am *AuthManager) SetPassword(username, password string) error {
+	return am.UpdateUser(username, func(user *User) error {
+		user.PasswordHash = HashPassword(password)
+		return nil
+	})
+}
+
+// UpdateUser applies update to a copy of a user and stores it, unless update
+// fails or breaks the role rules of CreateTenantUser. The username, tenant
+// and node of the user cannot change.
+func (am *AuthManager) UpdateUser(username string, update func(user *User) error) error {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	user, exists := am.users[username]
+	if !exists {
+		return ErrUserNotFound
+	}
+	updated := *user
+	if err := update(&updated); err != nil {
+		return err
+	}
+	updated.Username, updated.Tenant, updated.Node = user.Username, user.Tenant, user.Node
+	if updated.Role != user.Role {
+		if updated.Tenant != "" && updated.Role == RoleAdmin {
+			return fmt.Errorf("users of tenant %s cannot be %s; use %s", updated.Tenant, RoleAdmin, RoleTenantAdmin)
+		}
+		if updated.Tenant == "" && updated.Role == RoleTenantAdmin {
+			return fmt.Errorf("role %s requires a tenant", RoleTenantAdmin)
+		}
+		if updated.Role == RoleNode || user.Role == RoleNode {
+			return fmt.Errorf("the role of node service accounts cannot change")
+		}
+	}
+
+	if err := am.store.PutUser(&updated); err != nil {
+		return err
+	}
+	am.users[username] = &updated
+	if updated.Role != user.Role {
+		return am.refreshSessions(&updated)
+	}
+	return nil
+}
+
+// refreshSessions copies the role and scopes of user into the sessions this
+// manager holds for it, and stores them, so a demotion reaches servers that
+// read the sessions from the store too. ValidateSession applies the user's
+// current role and scopes to any other session. Requires holding mu.
+func (am *AuthManager) refreshSessions(user *User) error {
+	for key, session := range am.sessions {
+		if session.Username != user.Username {
+			continue
+		}
+		refreshed := *session
+		refreshed.Role, refreshed.Scopes = user.Role, user.Scopes
+		am.sessions[key] = &refreshed
+		if err := am.store.PutSession(key, &refreshed); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // ListUsers returns all users
 func (am *AuthManager) ListUsers() []*User {
+	return am.ListTenantUsers("")
+}
+
+// ListTenantUsers returns the users of tenant, or all users if tenant is ""
+func (am *AuthManager) ListTenantUsers(tenant string) []*User {
 	am.mu.RLock()
 	defer am.mu.RUnlock()
 
 	users := make([]*User, 0, len(am.users))
 	for _, user := range am.users {
+		if tenant != "" && user.Tenant != tenant {
+			continue
+		}
 		// Don't expose password hash
 		userCopy := *user
 		userCopy.PasswordHash = ""
@@ -324,30 +569,43 @@ func generateToken() (string, error) {
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
-// loadUsers loads users from disk
-func (am *AuthManager) loadUsers() error {
-	data, err := os.ReadFile(am.dbPath)
-	if err != nil {
-		return err
-	}
+// sessionKey is how sessions are stored and looked up. Only a hash of the
+// token is kept, so a store cannot be used to impersonate a user.
+func sessionKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
 
-	return json.Unmarshal(data, &am.users)
+// principalKey is the context key for WithPrincipal
+type principalKey struct{}
+
+// WithPrincipal returns a context recording principal as the user on whose
+// behalf work done with it is performed, so enforcement can be attributed
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
 }
 
-// saveUsers saves users to disk
-func (am *AuthManager) saveUsers() error {
-	// Ensure directory exists
-	dir := filepath.Dir(am.dbPath)
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return err
-	}
+// PrincipalFromContext returns the principal recorded with WithPrincipal, or
+// an empty string
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
 
-	data, err := json.MarshalIndent(am.users, "", "  ")
-	if err != nil {
-		return err
-	}
+// tenantKey is the context key for WithTenant
+type tenantKey struct{}
+
+// WithTenant returns a context scoping the stores it is passed to to tenant,
+// so each tenant only reads and writes its own records
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
 
-	return os.WriteFile(am.dbPath, data, 0600)
+// TenantFromContext returns the tenant recorded with WithTenant, or "" for
+// records without a tenant
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
 }
 
 // CleanupExpiredSessions removes expired sessions
@@ -355,7 +613,7 @@ func (am *AuthManager) CleanupExpiredSessions() {
 	am.mu.Lock()
 	defer am.mu.Unlock()
 
-	now := time.Now()
+	now := am.clock.Now()
 	for token, session := range am.sessions {
 		if now.After(session.ExpiresAt) {
 			delete(am.sessions, token)
@@ -1,10 +1,16 @@
 package auth
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"ztap/pkg/clock"
 )
 
 func TestCreateUser(t *testing.T) {
@@ -103,7 +109,7 @@ func TestValidateSession(t *testing.T) {
 	}
 
 	manager.mu.Lock()
-	manager.sessions[session.Token].ExpiresAt = time.Now().Add(-1 * time.Hour)
+	manager.sessions[sessionKey(session.Token)].ExpiresAt = time.Now().Add(-1 * time.Hour)
 	manager.mu.Unlock()
 
 	_, err = manager.ValidateSession(session.Token)
@@ -112,6 +118,133 @@ func TestValidateSession(t *testing.T) {
 	}
 }
 
+func TestSessionExpiryClock(t *testing.T) {
+	manager, _ := NewAuthManager(filepath.Join(t.TempDir(), "users.json"))
+	clk := clock.NewFake(time.Now())
+	manager.SetClock(clk)
+
+	manager.CreateUser("testuser", "password", RoleOperator)
+	session, err := manager.Authenticate("testuser", "password")
+	if err != nil {
+		t.Fatalf("Authentication failed: %v", err)
+	}
+	if !session.ExpiresAt.Equal(clk.Now().Add(24 * time.Hour)) {
+		t.Errorf("Expected session to expire 24h after login, got %v", session.ExpiresAt)
+	}
+
+	clk.Advance(23 * time.Hour)
+	if _, err := manager.ValidateSession(session.Token); err != nil {
+		t.Errorf("Expected session to be valid after 23h: %v", err)
+	}
+
+	clk.Advance(2 * time.Hour)
+	if _, err := manager.ValidateSession(session.Token); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Expected ErrSessionExpired after 25h, got %v", err)
+	}
+	manager.CleanupExpiredSessions()
+	if len(manager.sessions) != 0 {
+		t.Errorf("Expected expired session to be cleaned up, got %d", len(manager.sessions))
+	}
+}
+
+func TestSessionPersistence(t *testing.T) {
+	tmpDir := t.TempDir()
+	dbPath := filepath.Join(tmpDir, "users.json")
+	manager, _ := NewAuthManager(dbPath)
+
+	manager.CreateUser("testuser", "password", RoleOperator)
+	session, _ := manager.Authenticate("testuser", "password")
+
+	// Another process, e.g. a later CLI invocation
+	other, err := NewAuthManager(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to load manager: %v", err)
+	}
+	validated, err := other.ValidateSession(session.Token)
+	if err != nil || validated.Username != "testuser" {
+		t.Fatalf("Expected persisted session for testuser, got %+v, %v", validated, err)
+	}
+
+	// Sessions created after loading are found too
+	later, _ := manager.Authenticate("testuser", "password")
+	if _, err := other.ValidateSession(later.Token); err != nil {
+		t.Errorf("Expected session from another manager to validate: %v", err)
+	}
+
+	// Only token hashes are stored
+	data, _ := os.ReadFile(filepath.Join(tmpDir, "sessions.json"))
+	if strings.Contains(string(data), session.Token) {
+		t.Error("Expected sessions.json not to contain raw tokens")
+	}
+
+	if err := other.Logout(session.Token); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	reloaded, _ := NewAuthManager(dbPath)
+	if _, err := reloaded.ValidateSession(session.Token); err == nil {
+		t.Error("Expected logged out session to be removed")
+	}
+	if _, err := reloaded.ValidateSession(later.Token); err != nil {
+		t.Errorf("Expected other sessions to survive logout: %v", err)
+	}
+}
+
+func TestPrincipalContext(t *testing.T) {
+	if got := PrincipalFromContext(context.Background()); got != "" {
+		t.Errorf("Expected no principal, got %q", got)
+	}
+	ctx := WithPrincipal(context.Background(), "alice")
+	if got := PrincipalFromContext(ctx); got != "alice" {
+		t.Errorf("Expected alice, got %q", got)
+	}
+}
+
+func TestTenantUsers(t *testing.T) {
+	manager, _ := NewAuthManager(filepath.Join(t.TempDir(), "users.json"))
+
+	if err := manager.CreateTenantUser("ann", "password123", RoleTenantAdmin, "acme"); err != nil {
+		t.Fatalf("CreateTenantUser failed: %v", err)
+	}
+	if err := manager.CreateTenantUser("eve", "password123", RoleAdmin, "acme"); err == nil {
+		t.Error("Expected a tenant's users not to be admins")
+	}
+	if err := manager.CreateUser("tom", "password123", RoleTenantAdmin); err == nil {
+		t.Error("Expected tenant-admin to require a tenant")
+	}
+	if err := manager.CreateTenantUser("max", "password123", RoleViewer, "Acme Corp"); err != ErrInvalidTenant {
+		t.Errorf("Expected ErrInvalidTenant, got %v", err)
+	}
+
+	session, err := manager.Authenticate("ann", "password123")
+	if err != nil || session.Tenant != "acme" {
+		t.Fatalf("Expected a session in acme, got %+v, %v", session, err)
+	}
+	if err := manager.HasPermission(session.Token, PermManageUsers); err != nil {
+		t.Errorf("Expected tenant-admin to manage users: %v", err)
+	}
+	if err := manager.HasPermission(session.Token, PermBreakGlass); err != ErrPermissionDenied {
+		t.Errorf("Expected tenant-admin not to break glass, got %v", err)
+	}
+
+	if tenant, err := session.ResolveTenant(""); err != nil || tenant != "acme" {
+		t.Errorf("Expected acme by default, got %q, %v", tenant, err)
+	}
+	if _, err := session.ResolveTenant("globex"); err != ErrTenantDenied {
+		t.Errorf("Expected ErrTenantDenied, got %v", err)
+	}
+	provider := &Session{Username: "admin", Role: RoleAdmin}
+	if tenant, err := provider.ResolveTenant("globex"); err != nil || tenant != "globex" {
+		t.Errorf("Expected users without a tenant to act in any, got %q, %v", tenant, err)
+	}
+
+	if users := manager.ListTenantUsers("acme"); len(users) != 1 || users[0].Username != "ann" {
+		t.Errorf("Expected only ann in acme, got %+v", users)
+	}
+	if got := TenantFromContext(WithTenant(context.Background(), "acme")); got != "acme" {
+		t.Errorf("Expected acme from context, got %q", got)
+	}
+}
+
 func TestHasPermission(t *testing.T) {
 	tmpDir := t.TempDir()
 	manager, _ := NewAuthManager(filepath.Join(tmpDir, "users.json"))
@@ -134,6 +267,10 @@ func TestHasPermission(t *testing.T) {
 		{adminSession.Token, PermManageUsers, true},
 		{operatorSession.Token, PermEnforce, true},
 		{operatorSession.Token, PermManageUsers, false},
+		{adminSession.Token, PermApprove, true},
+		{operatorSession.Token, PermApprove, false},
+		{adminSession.Token, PermBreakGlass, true},
+		{operatorSession.Token, PermBreakGlass, false},
 		{viewerSession.Token, PermViewLogs, true},
 		{viewerSession.Token, PermEnforce, false},
 	}
@@ -230,3 +367,128 @@ func TestDefaultAdmin(t *testing.T) {
 		t.Errorf("Admin authentication failed: %v", err)
 	}
 }
+
+func TestScopes(t *testing.T) {
+	manager, _ := NewAuthManager(filepath.Join(t.TempDir(), "users.json"))
+	if err := manager.CreateUser("pat", "password123", RoleViewer); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+
+	scope, err := ParseScope("namespace=payments, env=prod")
+	if err != nil || scope.String() != "env=prod,namespace=payments" {
+		t.Fatalf("Expected a parsed scope, got %v, %v", scope, err)
+	}
+	if _, err := ParseScope("payments"); err == nil {
+		t.Error("Expected a scope without key=value pairs to be rejected")
+	}
+	if err := manager.SetScopes("pat", []Scope{scope}); err != nil {
+		t.Fatalf("SetScopes failed: %v", err)
+	}
+
+	session, err := manager.Authenticate("pat", "password123")
+	if err != nil || !session.Scoped() {
+		t.Fatalf("Expected a scoped session, got %+v, %v", session, err)
+	}
+	if !session.Sees(map[string]string{"app": "api", "namespace": "payments", "env": "prod"}) {
+		t.Error("Expected labels within the scope to be seen")
+	}
+	if session.Sees(map[string]string{"namespace": "payments"}) {
+		t.Error("Expected labels matching part of the scope not to be seen")
+	}
+	if !(&Session{}).Sees(nil) {
+		t.Error("Expected sessions without scopes to see everything")
+	}
+}
+
+func TestDisableEndsSessions(t *testing.T) {
+	manager, _ := NewAuthManager(filepath.Join(t.TempDir(), "users.json"))
+	manager.CreateUser("testuser", "password", RoleOperator)
+	session, err := manager.Authenticate("testuser", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager.DisableUser("testuser")
+	if _, err := manager.ValidateSession(session.Token); !errors.Is(err, ErrUserDisabled) {
+		t.Errorf("Expected ErrUserDisabled for a disabled user's session, got %v", err)
+	}
+}
+
+func TestSetScopesNarrowsSessions(t *testing.T) {
+	manager, _ := NewAuthManager(filepath.Join(t.TempDir(), "users.json"))
+	manager.CreateUser("pat", "password", RoleViewer)
+	session, err := manager.Authenticate("pat", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	billing := map[string]string{"namespace": "billing"}
+	manager.SetScopes("pat", []Scope{{"namespace": "payments"}})
+	current, err := manager.ValidateSession(session.Token)
+	if err != nil {
+		t.Fatalf("ValidateSession failed: %v", err)
+	}
+	if current.Sees(billing) {
+		t.Error("Expected the open session narrowed to the new scopes")
+	}
+	if !current.Sees(map[string]string{"namespace": "payments"}) {
+		t.Error("Expected the open session to see its new scope")
+	}
+}
+
+func TestRoleChangeRefreshesStoredSessions(t *testing.T) {
+	manager, _ := NewAuthManager(filepath.Join(t.TempDir(), "users.json"))
+	manager.CreateUser("pat", "password", RoleAdmin)
+	session, err := manager.Authenticate("pat", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager.UpdateUser("pat", func(user *User) error {
+		user.Role = RoleViewer
+		return nil
+	})
+	stored, err := manager.store.Session(sessionKey(session.Token))
+	if err != nil || stored.Role != RoleViewer {
+		t.Errorf("Expected the stored session demoted, got %+v, %v", stored, err)
+	}
+	if err := manager.HasPermission(session.Token, PermManageUsers); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected the demoted session denied, got %v", err)
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	manager, _ := NewAuthManager(filepath.Join(t.TempDir(), "users.json"))
+	manager.CreateTenantUser("pat", "password", RoleViewer, "acme")
+
+	err := manager.UpdateUser("pat", func(user *User) error {
+		user.Role, user.ExternalID, user.Groups = RoleTenantAdmin, "00u1", []string{"ztap-admins"}
+		user.Tenant = "other"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateUser failed: %v", err)
+	}
+	users := manager.ListUsers()
+	if len(users) != 2 {
+		t.Fatalf("Expected admin and pat, got %d users", len(users))
+	}
+	for _, user := range users {
+		if user.Username == "pat" && (user.Role != RoleTenantAdmin || user.Tenant != "acme" || user.ExternalID != "00u1" || len(user.Groups) != 1) {
+			t.Errorf("Unexpected updated user %+v", user)
+		}
+	}
+
+	if err := manager.UpdateUser("pat", func(user *User) error { user.Role = RoleAdmin; return nil }); err == nil {
+		t.Error("Expected a tenant user refused the admin role")
+	}
+	if err := manager.SetPassword("pat", "newpassword"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Authenticate("pat", "newpassword"); err != nil {
+		t.Errorf("Expected the new password to work, got %v", err)
+	}
+	if err := manager.UpdateUser("nobody", func(*User) error { return nil }); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
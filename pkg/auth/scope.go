@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Scope is a set of labels, e.g. {"namespace": "payments"}: a workload is in
+// the scope if it carries every one of them
+type Scope map[string]string
+
+// ParseScope parses a scope written as comma-separated key=value pairs, e.g.
+// "namespace=payments,env=prod"
+func ParseScope(s string) (Scope, error) {
+	scope := make(Scope)
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid scope %q: use key=value pairs separated by commas", s)
+		}
+		scope[key] = value
+	}
+	return scope, nil
+}
+
+// String formats the scope as sorted key=value pairs, as ParseScope reads
+func (s Scope) String() string {
+	pairs := make([]string, 0, len(s))
+	for k, v := range s {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// matches reports whether labels carry every label of the scope
+func (s Scope) matches(labels map[string]string) bool {
+	for k, v := range s {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Scoped reports whether scopes limit what the session sees
+func (s *Session) Scoped() bool {
+	return len(s.Scopes) > 0
+}
+
+// Sees reports whether the session may see a workload or service with
+// labels, or a policy selecting workloads by them: always without scopes,
+// otherwise if labels match one of the session's scopes. A policy selecting
+// app=web only is thus hidden from a session scoped to namespace=payments,
+// as it may select workloads outside the scope.
+func (s *Session) Sees(labels map[string]string) bool {
+	if !s.Scoped() {
+		return true
+	}
+	for _, scope := range s.Scopes {
+		if scope.matches(labels) {
+			return true
+		}
+	}
+	return false
+}
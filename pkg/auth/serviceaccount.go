@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// serviceAccountPrefix starts the usernames of node service accounts
+const serviceAccountPrefix = "node:"
+
+// ErrInvalidNode is returned for a node ID that cannot name a service account
+var ErrInvalidNode = errors.New("invalid node ID (use letters, digits, dots and dashes)")
+
+// nodePattern matches node IDs, which are usually host names
+var nodePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]{0,251}[A-Za-z0-9])?$`)
+
+// ValidateNode checks a node ID
+func ValidateNode(node string) error {
+	if !nodePattern.MatchString(node) {
+		return ErrInvalidNode
+	}
+	return nil
+}
+
+// ServiceAccountName returns the username of a node's service account
+func ServiceAccountName(node string) string {
+	return serviceAccountPrefix + node
+}
+
+// ProvisionServiceAccount creates the service account of node in tenant and
+// returns its secret, which the node logs in with like a password. The
+// account holds RoleNode: it may report the node's status and fetch the
+// policies selecting labels, nothing else. Provisioning an existing account
+// again (a node rejoining) replaces its labels and secret and invalidates
+// its sessions.
+func (am *AuthManager) ProvisionServiceAccount(node string, labels map[string]string, tenant string) (string, error) {
+	if err := ValidateNode(node); err != nil {
+		return "", err
+	}
+	if err := ValidateTenant(tenant); err != nil {
+		return "", err
+	}
+	secret, err := generateToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	username := ServiceAccountName(node)
+	if existing, exists := am.users[username]; exists && existing.Role != RoleNode {
+		return "", ErrUserExists
+	}
+
+	copied := make(map[string]string, len(labels))
+	for key, value := range labels {
+		copied[key] = value
+	}
+	user := &User{
+		Username:     username,
+		PasswordHash: HashPassword(secret),
+		Role:         RoleNode,
+		CreatedAt:    am.clock.Now(),
+		Enabled:      true,
+		Tenant:       tenant,
+		Node:         node,
+		Labels:       copied,
+	}
+	if err := am.store.PutUser(user); err != nil {
+		return "", err
+	}
+	am.users[username] = user
+	return secret, nil
+}
+
+// RevokeServiceAccount disables the service account of node, ending its
+// sessions
+func (am *AuthManager) RevokeServiceAccount(node string) error {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	user, exists := am.users[ServiceAccountName(node)]
+	if !exists || user.Role != RoleNode {
+		return ErrUserNotFound
+	}
+	user.Enabled = false
+	return am.store.PutUser(user)
+}
+
+// ServiceAccount returns the service account of node, without its password
+// hash
+func (am *AuthManager) ServiceAccount(node string) (*User, error) {
+	users, err := am.store.Users()
+	if err != nil {
+		return nil, err
+	}
+	user, exists := users[ServiceAccountName(node)]
+	if !exists || user.Role != RoleNode {
+		return nil, ErrUserNotFound
+	}
+	userCopy := *user
+	userCopy.PasswordHash = ""
+	return &userCopy, nil
+}
+
+// serviceAccountValid reports whether the service account a node session
+// was created for is still enabled and was not provisioned again since. The
+// store is read again, as accounts are revoked by other ztap processes.
+func (am *AuthManager) serviceAccountValid(session *Session) bool {
+	users, err := am.store.Users()
+	if err != nil {
+		return false
+	}
+	user, exists := users[session.Username]
+	return exists && user.Enabled && user.Role == RoleNode && user.Node == session.Node &&
+		!session.CreatedAt.Before(user.CreatedAt)
+}
+
+// isServiceAccountName reports whether username is reserved for service
+// accounts
+func isServiceAccountName(username string) bool {
+	return strings.HasPrefix(username, serviceAccountPrefix)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ztap/pkg/clock"
+)
+
+func TestServiceAccount(t *testing.T) {
+	manager, err := NewAuthManager(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatalf("Failed to create auth manager: %v", err)
+	}
+	clk := clock.NewFake(time.Now())
+	manager.SetClock(clk)
+
+	secret, err := manager.ProvisionServiceAccount("web-1.example.com", map[string]string{"app": "web"}, "acme")
+	if err != nil {
+		t.Fatalf("ProvisionServiceAccount failed: %v", err)
+	}
+	session, err := manager.Authenticate("node:web-1.example.com", secret)
+	if err != nil {
+		t.Fatalf("Failed to log in with the secret: %v", err)
+	}
+	if session.Role != RoleNode || session.Node != "web-1.example.com" || session.Tenant != "acme" || session.Labels["app"] != "web" {
+		t.Errorf("Unexpected session %+v", session)
+	}
+
+	// Only the node permissions are granted
+	if err := manager.HasPermission(session.Token, PermFetchNodePolicies); err != nil {
+		t.Errorf("Expected %s, got %v", PermFetchNodePolicies, err)
+	}
+	for _, perm := range []Permission{PermViewPolicies, PermEnforce, PermManageUsers} {
+		if err := manager.HasPermission(session.Token, perm); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("Expected %s to be denied, got %v", perm, err)
+		}
+	}
+
+	// Rejoining rotates the secret and ends earlier sessions
+	clk.Advance(time.Second)
+	rotated, err := manager.ProvisionServiceAccount("web-1.example.com", map[string]string{"app": "web"}, "acme")
+	if err != nil {
+		t.Fatalf("ProvisionServiceAccount failed: %v", err)
+	}
+	if _, err := manager.ValidateSession(session.Token); err == nil {
+		t.Error("Expected the session of the previous secret to end")
+	}
+	if _, err := manager.Authenticate("node:web-1.example.com", secret); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected the previous secret to be rejected, got %v", err)
+	}
+	session, err = manager.Authenticate("node:web-1.example.com", rotated)
+	if err != nil {
+		t.Fatalf("Failed to log in with the rotated secret: %v", err)
+	}
+
+	// Leaving disables the account
+	if err := manager.RevokeServiceAccount("web-1.example.com"); err != nil {
+		t.Fatalf("RevokeServiceAccount failed: %v", err)
+	}
+	if _, err := manager.ValidateSession(session.Token); err == nil {
+		t.Error("Expected the session to end once the account is disabled")
+	}
+}
+
+func TestServiceAccountReserved(t *testing.T) {
+	manager, err := NewAuthManager(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatalf("Failed to create auth manager: %v", err)
+	}
+
+	if err := manager.CreateUser("bob", "password123", RoleNode); err == nil {
+		t.Error("Expected the node role to be refused for users")
+	}
+	if err := manager.CreateUser("node:web-1", "password123", RoleAdmin); err == nil {
+		t.Error("Expected service account names to be refused for users")
+	}
+	if _, err := manager.ProvisionServiceAccount("web 1", nil, ""); !errors.Is(err, ErrInvalidNode) {
+		t.Errorf("Expected ErrInvalidNode, got %v", err)
+	}
+	if err := manager.RevokeServiceAccount("admin"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
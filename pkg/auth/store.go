@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Store persists users and sessions
+type Store interface {
+	UserStore
+	SessionStore
+}
+
+// UserStore persists users
+type UserStore interface {
+	// Users returns every user, keyed by username
+	Users() (map[string]*User, error)
+	// PutUser creates or replaces a user
+	PutUser(user *User) error
+}
+
+// SessionStore persists sessions. Sessions are keyed by sessionKey, a hash of
+// their token; stored sessions carry no token.
+type SessionStore interface {
+	// Session returns the session stored under key, or ErrSessionNotFound
+	Session(key string) (*Session, error)
+	// PutSession stores a session under key
+	PutSession(key string, session *Session) error
+	// DeleteSession removes the session stored under key, if any
+	DeleteSession(key string) error
+}
+
+// splitStore keeps users and sessions in different stores
+type splitStore struct {
+	UserStore
+	SessionStore
+}
+
+// SplitStore returns a Store keeping users in users and sessions in sessions,
+// e.g. users in a database and sessions in a cache with native expiry
+func SplitStore(users UserStore, sessions SessionStore) Store {
+	return splitStore{UserStore: users, SessionStore: sessions}
+}
+
+// FileStore keeps users in a JSON file and sessions in sessions.json next to
+// it. Every write re-reads the file first, so changes made by other ztap
+// processes on the host are kept.
+type FileStore struct {
+	mu           sync.Mutex
+	usersPath    string
+	sessionsPath string
+}
+
+// NewFileStore creates a store keeping users in usersPath
+func NewFileStore(usersPath string) *FileStore {
+	return &FileStore{
+		usersPath:    usersPath,
+		sessionsPath: filepath.Join(filepath.Dir(usersPath), "sessions.json"),
+	}
+}
+
+// Users returns every user; a missing file has none
+func (s *FileStore) Users() (map[string]*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readUsers()
+}
+
+// PutUser creates or replaces a user
+func (s *FileStore) PutUser(user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := s.readUsers()
+	if err != nil {
+		return err
+	}
+	stored := *user
+	users[user.Username] = &stored
+	return writeJSON(s.usersPath, users)
+}
+
+// Session returns the session stored under key
+func (s *FileStore) Session(key string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions, err := s.readSessions()
+	if err != nil {
+		return nil, err
+	}
+	session, exists := sessions[key]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// PutSession stores a session under key. Expired sessions are dropped.
+func (s *FileStore) PutSession(key string, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions, err := s.readSessions()
+	if err != nil {
+		return err
+	}
+	stored := *session
+	stored.Token = ""
+	sessions[key] = &stored
+	return writeJSON(s.sessionsPath, sessions)
+}
+
+// DeleteSession removes the session stored under key. Expired sessions are
+// dropped.
+func (s *FileStore) DeleteSession(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions, err := s.readSessions()
+	if err != nil {
+		return err
+	}
+	delete(sessions, key)
+	return writeJSON(s.sessionsPath, sessions)
+}
+
+// readUsers reads the users file (requires mu)
+func (s *FileStore) readUsers() (map[string]*User, error) {
+	users := make(map[string]*User)
+	if err := readJSON(s.usersPath, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// readSessions reads the unexpired sessions (requires mu)
+func (s *FileStore) readSessions() (map[string]*Session, error) {
+	sessions := make(map[string]*Session)
+	if err := readJSON(s.sessionsPath, &sessions); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for key, session := range sessions {
+		if now.After(session.ExpiresAt) {
+			delete(sessions, key)
+		}
+	}
+	return sessions, nil
+}
+
+// readJSON decodes path into v, leaving v unchanged if path does not exist
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSON writes v to path, readable only by its owner
+func writeJSON(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
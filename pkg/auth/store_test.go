@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStoreKeepsConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	a, b := NewFileStore(path), NewFileStore(path)
+
+	if err := a.PutUser(&User{Username: "alice", Role: RoleViewer, Enabled: true}); err != nil {
+		t.Fatalf("PutUser failed: %v", err)
+	}
+	if err := b.PutUser(&User{Username: "bob", Role: RoleOperator, Enabled: true}); err != nil {
+		t.Fatalf("PutUser failed: %v", err)
+	}
+
+	users, err := a.Users()
+	if err != nil {
+		t.Fatalf("Users failed: %v", err)
+	}
+	if len(users) != 2 || users["alice"] == nil || users["bob"].Role != RoleOperator {
+		t.Errorf("Expected both users, got %v", users)
+	}
+}
+
+func TestFileStoreSessions(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileStore(filepath.Join(dir, "users.json"))
+
+	if _, err := store.Session("missing"); err != ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+
+	live := &Session{Token: "secret-token", Username: "alice", ExpiresAt: time.Now().Add(time.Hour)}
+	expired := &Session{Username: "bob", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := store.PutSession("expired", expired); err != nil {
+		t.Fatalf("PutSession failed: %v", err)
+	}
+	if err := store.PutSession("live", live); err != nil {
+		t.Fatalf("PutSession failed: %v", err)
+	}
+
+	session, err := store.Session("live")
+	if err != nil || session.Username != "alice" || session.Token != "" {
+		t.Errorf("Expected tokenless session for alice, got %+v, %v", session, err)
+	}
+	if _, err := store.Session("expired"); err != ErrSessionNotFound {
+		t.Errorf("Expected expired session to be dropped, got %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "sessions.json"))
+	if err != nil {
+		t.Fatalf("Failed to read sessions file: %v", err)
+	}
+	if strings.Contains(string(data), "secret-token") {
+		t.Error("Session token was persisted")
+	}
+
+	if err := store.DeleteSession("live"); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	if _, err := store.Session("live"); err != ErrSessionNotFound {
+		t.Errorf("Expected deleted session to be gone, got %v", err)
+	}
+}
@@ -0,0 +1,198 @@
+// Package bulk runs an operation over many items, such as policies or cloud
+// targets, with a bounded pool of workers, and reports each item's outcome as
+// it completes.
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Result is the outcome of one item
+type Result struct {
+	Item     string
+	Err      error // nil on success
+	Duration time.Duration
+}
+
+// Run calls fn for each index of items using up to concurrency workers and
+// returns the results in input order. Items not started before ctx is done
+// fail with the context error. Each result is reported to the Reporter in
+// ctx, if any, named after the scopes of ctx (see WithScope).
+func Run(ctx context.Context, items []string, concurrency int, fn func(i int) error) []Result {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	reporter := reporterFrom(ctx)
+	scope := scopeFrom(ctx)
+
+	results := make([]Result, len(items))
+	finish := func(i int, start time.Time, err error) {
+		results[i] = Result{Item: items[i], Err: err, Duration: time.Since(start)}
+		if reporter != nil {
+			reported := results[i]
+			reported.Item = scope + reported.Item
+			reporter.Report(reported)
+		}
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	for w := 0; w < concurrency && w < len(items); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				start := time.Now()
+				finish(i, start, fn(i))
+			}
+		}()
+	}
+
+	for i := range items {
+		if err := ctx.Err(); err != nil {
+			finish(i, time.Now(), err)
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// Failures returns the failed results by item
+func Failures(results []Result) map[string]error {
+	failures := make(map[string]error)
+	for _, r := range results {
+		if r.Err != nil {
+			failures[r.Item] = r.Err
+		}
+	}
+	return failures
+}
+
+// scopeKey is the context key for WithScope
+type scopeKey struct{}
+
+// WithScope returns a context under which results reported by Run are named
+// "<scope>: <item>", e.g. to tell apart the same policy synced to several
+// accounts. Scopes nest.
+func WithScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scopeFrom(ctx)+scope+": ")
+}
+
+func scopeFrom(ctx context.Context) string {
+	scope, _ := ctx.Value(scopeKey{}).(string)
+	return scope
+}
+
+// reporterKey is the context key for WithReporter
+type reporterKey struct{}
+
+// WithReporter returns a context under which Run reports every result to
+// reporter, however deep in the call stack it runs
+func WithReporter(ctx context.Context, reporter *Reporter) context.Context {
+	return context.WithValue(ctx, reporterKey{}, reporter)
+}
+
+func reporterFrom(ctx context.Context) *Reporter {
+	reporter, _ := ctx.Value(reporterKey{}).(*Reporter)
+	return reporter
+}
+
+// Reporter collects results and prints a progress line for each, e.g.
+// "[3/10] ✓ allow-https (120ms)". It is safe for concurrent use.
+type Reporter struct {
+	mu      sync.Mutex
+	out     io.Writer
+	verb    string
+	total   int
+	results []Result
+}
+
+// NewReporter returns a reporter printing progress to out (nil for none)
+// for an operation over total items (0 if unknown). verb describes the
+// operation in the summary, e.g. "synced".
+func NewReporter(out io.Writer, verb string, total int) *Reporter {
+	return &Reporter{out: out, verb: verb, total: total}
+}
+
+// Report records a result and prints its progress line
+func (r *Reporter) Report(result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, result)
+	if r.out == nil {
+		return
+	}
+
+	progress := fmt.Sprintf("[%d/%d]", len(r.results), r.total)
+	if r.total < len(r.results) {
+		progress = fmt.Sprintf("[%d]", len(r.results))
+	}
+	if result.Err != nil {
+		fmt.Fprintf(r.out, "%s ✗ %s: %s\n", progress, result.Item, oneLine(result.Err))
+		return
+	}
+	fmt.Fprintf(r.out, "%s ✓ %s (%s)\n", progress, result.Item, result.Duration.Round(time.Millisecond))
+}
+
+// Results returns the results reported so far, sorted by item
+func (r *Reporter) Results() []Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	results := append([]Result(nil), r.results...)
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Item < results[j].Item })
+	return results
+}
+
+// Failed returns the number of failed items
+func (r *Reporter) Failed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	failed := 0
+	for _, result := range r.results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// WriteSummary writes a table of every item's outcome followed by the
+// totals, e.g. "8 synced, 2 failed"
+func (r *Reporter) WriteSummary(w io.Writer) {
+	results := r.Results()
+	if len(results) == 0 {
+		fmt.Fprintf(w, "Nothing %s\n", r.verb)
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ITEM\tRESULT\tDURATION\tERROR")
+	failed := 0
+	for _, result := range results {
+		outcome, errMsg := "ok", "-"
+		if result.Err != nil {
+			failed++
+			outcome, errMsg = "failed", oneLine(result.Err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Item, outcome, result.Duration.Round(time.Millisecond), errMsg)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d %s, %d failed\n", len(results)-failed, r.verb, failed)
+}
+
+// oneLine keeps progress lines and summary rows on one line for joined
+// errors
+func oneLine(err error) string {
+	return strings.ReplaceAll(err.Error(), "\n", "; ")
+}
@@ -0,0 +1,100 @@
+package bulk
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	items := []string{"a", "b", "c", "d", "e"}
+
+	var running, peak int32
+	results := Run(context.Background(), items, 2, func(i int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		if items[i] == "c" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent items, got %d", peak)
+	}
+	for i, r := range results {
+		if r.Item != items[i] {
+			t.Errorf("Expected results in input order, got %s at %d", r.Item, i)
+		}
+	}
+	failures := Failures(results)
+	if len(failures) != 1 || failures["c"] == nil {
+		t.Errorf("Expected c to fail, got %v", failures)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var called int32
+	results := Run(ctx, []string{"a", "b"}, 2, func(i int) error {
+		atomic.AddInt32(&called, 1)
+		return nil
+	})
+	if called != 0 {
+		t.Errorf("Expected no item to run after cancel, got %d", called)
+	}
+	for _, r := range results {
+		if !errors.Is(r.Err, context.Canceled) {
+			t.Errorf("Expected %s to fail with context.Canceled, got %v", r.Item, r.Err)
+		}
+	}
+}
+
+func TestReporter(t *testing.T) {
+	var progress bytes.Buffer
+	reporter := NewReporter(&progress, "synced", 4)
+	ctx := WithReporter(context.Background(), reporter)
+
+	for _, scope := range []string{"prod/us-east-1", "dev/eu-west-1"} {
+		Run(WithScope(ctx, scope), []string{"allow-https", "deny-all"}, 1, func(i int) error {
+			if scope == "dev/eu-west-1" && i == 1 {
+				return errors.New("UnauthorizedOperation\nretry later")
+			}
+			return nil
+		})
+	}
+
+	lines := strings.Split(strings.TrimSpace(progress.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "[1/4] ✓ prod/us-east-1: allow-https") {
+		t.Errorf("Unexpected progress:\n%s", progress.String())
+	}
+	if !strings.Contains(progress.String(), "[4/4] ✗ dev/eu-west-1: deny-all: UnauthorizedOperation") {
+		t.Errorf("Expected the failure in progress, got:\n%s", progress.String())
+	}
+	if reporter.Failed() != 1 {
+		t.Errorf("Expected 1 failure, got %d", reporter.Failed())
+	}
+
+	var summary bytes.Buffer
+	reporter.WriteSummary(&summary)
+	if !strings.Contains(summary.String(), "3 synced, 1 failed") {
+		t.Errorf("Expected totals in summary, got:\n%s", summary.String())
+	}
+	if !strings.Contains(summary.String(), "UnauthorizedOperation; retry later") {
+		t.Errorf("Expected one line per item in summary, got:\n%s", summary.String())
+	}
+	if results := reporter.Results(); results[0].Item != "dev/eu-west-1: allow-https" {
+		t.Errorf("Expected results sorted by item, got %+v", results)
+	}
+}
@@ -0,0 +1,155 @@
+// Package client calls the ZTAP API server ('ztap serve') on behalf of a
+// user, so the CLI can manage a remote deployment. Its methods, one per API
+// operation, are generated from api.Routes into generated.go.
+package client
+
+//go:generate go test ../apigen -run TestGenerated -update
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"ztap/pkg/api"
+	"ztap/pkg/auth"
+)
+
+// Error is an error response of the API server. It unwraps to the auth
+// error matching its status, so callers can tell authentication failures
+// apart.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Unwrap returns auth.ErrSessionNotFound for 401 and auth.ErrPermissionDenied
+// for 403 responses
+func (e *Error) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return auth.ErrSessionNotFound
+	case http.StatusForbidden:
+		return auth.ErrPermissionDenied
+	}
+	return nil
+}
+
+// Client calls the API server at a URL such as https://ztap.example.com
+type Client struct {
+	server string
+	token  string
+	tenant string
+	http   *http.Client
+}
+
+// New returns a client for the API server at server, authenticating with
+// the session token (empty before logging in) and acting in tenant (see
+// the X-ZTAP-Tenant header; empty for the session's own)
+func New(server, token, tenant string) *Client {
+	return &Client{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		tenant: tenant,
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Server returns the URL of the API server
+func (c *Client) Server() string {
+	return c.server
+}
+
+// Authenticate logs in with a username and password, and authenticates
+// the client's later requests with the session
+func (c *Client) Authenticate(ctx context.Context, username, password string) (*auth.Session, error) {
+	resp, err := c.Login(ctx, api.LoginRequest{Username: username, Password: password})
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		return nil, auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.token = resp.Token
+	return &auth.Session{
+		Token:     resp.Token,
+		Username:  username,
+		Role:      resp.Role,
+		Tenant:    resp.Tenant,
+		Scopes:    resp.Scopes,
+		ExpiresAt: resp.ExpiresAt,
+	}, nil
+}
+
+// rawBody is a request body sent as is rather than encoded as JSON
+type rawBody struct {
+	contentType string
+	data        []byte
+}
+
+// do sends a request with body (if not nil) encoded as JSON, or as is for
+// a rawBody, and decodes the response into the value in outs for its
+// status, if any. It returns the status; responses other than 2xx are
+// returned as *Error.
+func (c *Client) do(ctx context.Context, method, path string, body any, outs map[int]any) (int, error) {
+	var reader io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case rawBody:
+		contentType = b.contentType
+		reader = bytes.NewReader(b.data)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-ZTAP-Tenant", c.tenant)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reach %s: %w", c.server, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var failure api.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&failure)
+		if failure.Error == "" {
+			failure.Error = method + " " + path + " failed"
+		}
+		return resp.StatusCode, &Error{StatusCode: resp.StatusCode, Message: failure.Error}
+	}
+	out := outs[resp.StatusCode]
+	if out == nil {
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("invalid response to %s %s: %w", method, path, err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"ztap/pkg/api"
+	"ztap/pkg/auth"
+	"ztap/pkg/events"
+	"ztap/pkg/storage"
+)
+
+func newTestServer(t *testing.T) (*httptest.Server, *auth.AuthManager) {
+	t.Helper()
+	am, err := auth.NewAuthManager(filepath.Join(t.TempDir(), "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := am.CreateUser("alice", "password123", auth.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	server := api.NewServer(am, events.NewBus(), storage.NewFilePolicyStore(filepath.Join(t.TempDir(), "policies.json")))
+	srv := httptest.NewServer(server.Handler())
+	t.Cleanup(srv.Close)
+	return srv, am
+}
+
+const testPolicyYAML = `apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/24
+      ports:
+        - protocol: TCP
+          port: 5432
+`
+
+func TestClient(t *testing.T) {
+	srv, am := newTestServer(t)
+	ctx := context.Background()
+	c := New(srv.URL+"/", "", "")
+
+	if _, err := c.Authenticate(ctx, "alice", "wrong"); !errors.Is(err, auth.ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+	}
+	session, err := c.Authenticate(ctx, "alice", "password123")
+	if err != nil || session.Role != auth.RoleAdmin {
+		t.Fatalf("Expected an admin session, got %+v, %v", session, err)
+	}
+
+	scopes := []auth.Scope{{"namespace": "payments"}}
+	if _, err := c.CreateUser(ctx, api.CreateUserRequest{Username: "pat", Password: "password123", Role: auth.RoleViewer, Scopes: scopes}); err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	users, err := c.ListUsers(ctx)
+	if err != nil || !hasScopedUser(users, "pat") {
+		t.Errorf("Expected the scoped pat listed, got %+v, %v", users, err)
+	}
+
+	record, pending, err := c.PutPolicy(ctx, "web", []byte(testPolicyYAML))
+	if err != nil || pending != nil || record.Version != 1 {
+		t.Errorf("Expected the policy stored at version 1, got %+v, %+v, %v", record, pending, err)
+	}
+	if got, err := c.GetPolicy(ctx, "web"); err != nil || got.YAML != testPolicyYAML {
+		t.Errorf("Expected the stored policy back, got %+v, %v", got, err)
+	}
+
+	var apiErr *Error
+	if _, err := c.ListApprovals(ctx); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 without approval enabled, got %v", err)
+	}
+
+	if err := c.Logout(ctx); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if _, err := am.ValidateSession(session.Token); err == nil {
+		t.Error("Expected the session to end at logout")
+	}
+	if _, err := c.ListUsers(ctx); !errors.Is(err, auth.ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound after logout, got %v", err)
+	}
+}
+
+func hasScopedUser(users []auth.User, username string) bool {
+	for _, user := range users {
+		if user.Username == username {
+			return len(user.Scopes) > 0
+		}
+	}
+	return false
+}
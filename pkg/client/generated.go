@@ -0,0 +1,161 @@
+// Code generated by ztap/pkg/apigen from the API routes; DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"ztap/pkg/api"
+	"ztap/pkg/auth"
+	"ztap/pkg/cluster"
+	"ztap/pkg/policy"
+	"ztap/pkg/stats"
+	"ztap/pkg/storage"
+)
+
+// Health calls GET /healthz to report that the server is up
+func (c *Client) Health(ctx context.Context) (map[string]string, error) {
+	var out map[string]string
+	_, err := c.do(ctx, http.MethodGet, "/healthz", nil, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// Login calls POST /login to exchange a username and password for a session token
+func (c *Client) Login(ctx context.Context, body api.LoginRequest) (api.LoginResponse, error) {
+	var out api.LoginResponse
+	_, err := c.do(ctx, http.MethodPost, "/login", body, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// Logout calls POST /logout to end the request's session
+func (c *Client) Logout(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodPost, "/logout", nil, nil)
+	return err
+}
+
+// ListPolicies calls GET /policies to list the stored policies
+func (c *Client) ListPolicies(ctx context.Context) ([]storage.PolicyRecord, error) {
+	var out []storage.PolicyRecord
+	_, err := c.do(ctx, http.MethodGet, "/policies", nil, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// GetPolicy calls GET /policies/{name} to get a stored policy
+func (c *Client) GetPolicy(ctx context.Context, name string) (storage.PolicyRecord, error) {
+	var out storage.PolicyRecord
+	_, err := c.do(ctx, http.MethodGet, "/policies/"+url.PathEscape(name), nil, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// PutPolicy calls PUT /policies/{name} to create or replace a policy document
+func (c *Client) PutPolicy(ctx context.Context, name string, body []byte) (storage.PolicyRecord, *storage.PendingChange, error) {
+	var out storage.PolicyRecord
+	var out202 storage.PendingChange
+	status, err := c.do(ctx, http.MethodPut, "/policies/"+url.PathEscape(name), rawBody{contentType: "application/yaml", data: body}, map[int]any{http.StatusOK: &out, http.StatusAccepted: &out202})
+	if err != nil {
+		return out, nil, err
+	}
+	if status == http.StatusAccepted {
+		return out, &out202, nil
+	}
+	return out, nil, nil
+}
+
+// DeletePolicy calls DELETE /policies/{name} to delete a policy document
+func (c *Client) DeletePolicy(ctx context.Context, name string) error {
+	_, err := c.do(ctx, http.MethodDelete, "/policies/"+url.PathEscape(name), nil, nil)
+	return err
+}
+
+// ListApprovals calls GET /approvals to list the policy changes awaiting approval
+func (c *Client) ListApprovals(ctx context.Context) ([]storage.PendingChange, error) {
+	var out []storage.PendingChange
+	_, err := c.do(ctx, http.MethodGet, "/approvals", nil, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// ApproveChange calls POST /approvals/{id}/approve to approve a pending policy change, storing it
+func (c *Client) ApproveChange(ctx context.Context, id string) (storage.PolicyRecord, error) {
+	var out storage.PolicyRecord
+	_, err := c.do(ctx, http.MethodPost, "/approvals/"+url.PathEscape(id)+"/approve", nil, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// RejectChange calls POST /approvals/{id}/reject to reject a pending policy change, discarding it
+func (c *Client) RejectChange(ctx context.Context, id string) (storage.PendingChange, error) {
+	var out storage.PendingChange
+	_, err := c.do(ctx, http.MethodPost, "/approvals/"+url.PathEscape(id)+"/reject", nil, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// ListUsers calls GET /users to list the users of the tenant
+func (c *Client) ListUsers(ctx context.Context) ([]auth.User, error) {
+	var out []auth.User
+	_, err := c.do(ctx, http.MethodGet, "/users", nil, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// CreateUser calls POST /users to create a user in the tenant
+func (c *Client) CreateUser(ctx context.Context, body api.CreateUserRequest) (auth.User, error) {
+	var out auth.User
+	_, err := c.do(ctx, http.MethodPost, "/users", body, map[int]any{http.StatusCreated: &out})
+	return out, err
+}
+
+// ListNodes calls GET /nodes to list the last status reported by each node
+func (c *Client) ListNodes(ctx context.Context) ([]api.NodeStatus, error) {
+	var out []api.NodeStatus
+	_, err := c.do(ctx, http.MethodGet, "/nodes", nil, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// ReportNodeStatus calls POST /nodes/{id}/status to report the status of the caller's node
+func (c *Client) ReportNodeStatus(ctx context.Context, id string, body api.NodeStatus) (api.NodeStatus, error) {
+	var out api.NodeStatus
+	_, err := c.do(ctx, http.MethodPost, "/nodes/"+url.PathEscape(id)+"/status", body, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// GetNodePolicies calls GET /nodes/{id}/policies to get the policies distributed to a node
+func (c *Client) GetNodePolicies(ctx context.Context, id string) ([]storage.PolicyRecord, error) {
+	var out []storage.PolicyRecord
+	_, err := c.do(ctx, http.MethodGet, "/nodes/"+url.PathEscape(id)+"/policies", nil, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// Enroll calls POST /enroll to enroll a node with a join token
+func (c *Client) Enroll(ctx context.Context, body cluster.EnrollRequest) (cluster.EnrollResponse, error) {
+	var out cluster.EnrollResponse
+	_, err := c.do(ctx, http.MethodPost, "/enroll", body, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// SubmitPolicy calls POST /admission to submit a policy document for validation, lint and OPA checks, then storage
+func (c *Client) SubmitPolicy(ctx context.Context, body api.AdmissionRequest) (api.AdmissionResponse, error) {
+	var out api.AdmissionResponse
+	_, err := c.do(ctx, http.MethodPost, "/admission", body, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// GetHeatmap calls GET /stats/heatmap to chart blocked flows per policy or anomaly scores per service over time
+func (c *Client) GetHeatmap(ctx context.Context) (stats.Heatmap, error) {
+	var out stats.Heatmap
+	_, err := c.do(ctx, http.MethodGet, "/stats/heatmap", nil, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// SearchPolicies calls POST /search to find the compiled rules of the stored policies covering a destination
+func (c *Client) SearchPolicies(ctx context.Context, body policy.SearchQuery) (policy.SearchResult, error) {
+	var out policy.SearchResult
+	_, err := c.do(ctx, http.MethodPost, "/search", body, map[int]any{http.StatusOK: &out})
+	return out, err
+}
+
+// OpenAPI calls GET /openapi.json to get this OpenAPI document
+func (c *Client) OpenAPI(ctx context.Context) (map[string]any, error) {
+	var out map[string]any
+	_, err := c.do(ctx, http.MethodGet, "/openapi.json", nil, map[int]any{http.StatusOK: &out})
+	return out, err
+}
@@ -0,0 +1,125 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func TestFakeNow(t *testing.T) {
+	f := NewFake(epoch)
+	if !f.Now().Equal(epoch) {
+		t.Fatalf("Expected %v, got %v", epoch, f.Now())
+	}
+	f.Advance(90 * time.Second)
+	if got := f.Since(epoch); got != 90*time.Second {
+		t.Errorf("Expected 90s since epoch, got %v", got)
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	f := NewFake(epoch)
+	timer := f.NewTimer(time.Minute)
+
+	f.Advance(59 * time.Second)
+	select {
+	case <-timer.C():
+		t.Fatal("Timer fired early")
+	default:
+	}
+
+	f.Advance(time.Second)
+	select {
+	case fired := <-timer.C():
+		if !fired.Equal(epoch.Add(time.Minute)) {
+			t.Errorf("Expected timer to fire at its deadline, got %v", fired)
+		}
+	default:
+		t.Fatal("Timer did not fire")
+	}
+	if timer.Stop() {
+		t.Error("Expected Stop on a fired timer to report false")
+	}
+
+	stopped := f.NewTimer(time.Second)
+	if !stopped.Stop() {
+		t.Error("Expected Stop on a pending timer to report true")
+	}
+	f.Advance(time.Hour)
+	select {
+	case <-stopped.C():
+		t.Error("Stopped timer fired")
+	default:
+	}
+	if f.Waiters() != 0 {
+		t.Errorf("Expected no active waiters, got %d", f.Waiters())
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(epoch)
+	ticker := f.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	var ticks []time.Time
+	for i := 0; i < 3; i++ {
+		f.Advance(10 * time.Second)
+		ticks = append(ticks, <-ticker.C())
+	}
+	if !ticks[2].Equal(epoch.Add(30 * time.Second)) {
+		t.Errorf("Unexpected ticks %v", ticks)
+	}
+
+	// Like time.Ticker, ticks nobody received are dropped
+	f.Advance(time.Minute)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Error("Expected missed ticks to be dropped")
+	default:
+	}
+}
+
+func TestFakeFiresInOrder(t *testing.T) {
+	f := NewFake(epoch)
+	late := f.NewTimer(2 * time.Second)
+	early := f.NewTimer(time.Second)
+
+	f.Advance(5 * time.Second)
+	a, b := <-early.C(), <-late.C()
+	if !a.Before(b) {
+		t.Errorf("Expected early timer to fire first, got %v and %v", a, b)
+	}
+	if !f.Now().Equal(epoch.Add(5 * time.Second)) {
+		t.Errorf("Expected clock at +5s, got %v", f.Now())
+	}
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	f := NewFake(epoch)
+	done := make(chan struct{})
+	go func() {
+		timer := f.NewTimer(time.Second)
+		<-timer.C()
+		close(done)
+	}()
+
+	f.BlockUntil(1)
+	f.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Timer goroutine did not wake")
+	}
+}
+
+func TestOrReal(t *testing.T) {
+	if OrReal(nil) != Real {
+		t.Error("Expected nil to default to the real clock")
+	}
+	f := NewFake(epoch)
+	if OrReal(f) != f {
+		t.Error("Expected explicit clock to be kept")
+	}
+}
@@ -0,0 +1,176 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves when told to. Timers and tickers fire
+// synchronously from Advance and Set, in deadline order, so a test can step
+// through timeouts without sleeping. Like their time counterparts, fake
+// channels have a buffer of one and a ticker drops ticks nobody received.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+	changed chan struct{} // Closed and replaced whenever a waiter is added
+}
+
+type fakeWaiter struct {
+	clock    *Fake
+	deadline time.Time
+	period   time.Duration // Zero for timers
+	ch       chan time.Time
+	stopped  bool
+}
+
+// NewFake returns a fake clock reading now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now, changed: make(chan struct{})}
+}
+
+// Now returns the fake time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since returns the fake time elapsed since t
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// NewTicker returns a ticker firing every d of fake time
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(d, d)}
+}
+
+// NewTimer returns a timer firing once after d of fake time
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return fakeTimer{f.add(d, 0)}
+}
+
+func (f *Fake) add(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{
+		clock:    f,
+		deadline: f.now.Add(d),
+		period:   period,
+		ch:       make(chan time.Time, 1),
+	}
+	if period == 0 && d <= 0 {
+		w.ch <- f.now
+		w.stopped = true
+	} else {
+		f.waiters = append(f.waiters, w)
+	}
+	close(f.changed)
+	f.changed = make(chan struct{})
+	return w
+}
+
+// Advance moves the clock forward by d, firing due timers and tickers
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t, firing due timers and tickers. Moving the clock
+// backwards fires nothing.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for {
+		w := f.next(t)
+		if w == nil {
+			break
+		}
+		f.now = w.deadline
+		select {
+		case w.ch <- w.deadline:
+		default:
+		}
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			w.stopped = true
+		}
+	}
+	f.now = t
+	f.prune()
+}
+
+// next returns the active waiter with the earliest deadline not after t
+// (requires holding mu)
+func (f *Fake) next(t time.Time) *fakeWaiter {
+	var next *fakeWaiter
+	for _, w := range f.waiters {
+		if w.stopped || w.deadline.After(t) {
+			continue
+		}
+		if next == nil || w.deadline.Before(next.deadline) {
+			next = w
+		}
+	}
+	return next
+}
+
+// prune drops stopped waiters (requires holding mu)
+func (f *Fake) prune() {
+	active := f.waiters[:0]
+	for _, w := range f.waiters {
+		if !w.stopped {
+			active = append(active, w)
+		}
+	}
+	f.waiters = active
+}
+
+// Waiters returns the number of active timers and tickers
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prune()
+	return len(f.waiters)
+}
+
+// BlockUntil blocks until at least n timers and tickers are active, so a
+// test can advance the clock only once the code under test is waiting on it
+func (f *Fake) BlockUntil(n int) {
+	for {
+		f.mu.Lock()
+		f.prune()
+		active := len(f.waiters)
+		changed := f.changed
+		f.mu.Unlock()
+		if active >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.ch }
+
+// stop stops the waiter and reports whether it was still active
+func (w *fakeWaiter) stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	active := !w.stopped
+	w.stopped = true
+	return active
+}
+
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) Stop() { t.stop() }
+
+type fakeTimer struct{ *fakeWaiter }
+
+func (t fakeTimer) Stop() bool { return t.stop() }
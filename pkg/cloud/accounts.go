@@ -0,0 +1,251 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"ztap/pkg/bulk"
+	"ztap/pkg/cluster"
+	"ztap/pkg/policy"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"gopkg.in/yaml.v2"
+)
+
+// Labels attached to every resource discovered through a MultiClient
+const (
+	LabelAccount = "ztap:account"
+	LabelRegion  = "ztap:region"
+)
+
+// defaultAccountRateLimit is the EC2 calls per second allowed per account
+// when none is configured, well under the default EC2 API request quotas
+const defaultAccountRateLimit = 10
+
+// AccountConfig describes an AWS account and the regions ZTAP manages in it
+type AccountConfig struct {
+	Name           string            `yaml:"name"`
+	RoleARN        string            `yaml:"roleArn,omitempty"`        // Role to assume; empty uses default credentials
+	ExternalID     string            `yaml:"externalId,omitempty"`     // Required by some cross-account trust policies
+	Regions        []string          `yaml:"regions"`                  // Regions to discover and sync
+	SecurityGroups map[string]string `yaml:"securityGroups,omitempty"` // Region -> Security Group synced with policies
+	RateLimit      float64           `yaml:"rateLimit,omitempty"`      // EC2 calls per second shared by all regions
+}
+
+// accountsFile is the on-disk format of LoadAccounts
+type accountsFile struct {
+	Accounts []AccountConfig `yaml:"accounts"`
+}
+
+// LoadAccounts reads account configuration from a YAML file
+func LoadAccounts(path string) ([]AccountConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accounts file: %w", err)
+	}
+
+	var file accountsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse accounts file %s: %w", path, err)
+	}
+	if len(file.Accounts) == 0 {
+		return nil, fmt.Errorf("no accounts defined in %s", path)
+	}
+
+	seen := make(map[string]bool)
+	for _, account := range file.Accounts {
+		if account.Name == "" {
+			return nil, fmt.Errorf("account without name in %s", path)
+		}
+		if seen[account.Name] {
+			return nil, fmt.Errorf("duplicate account %s in %s", account.Name, path)
+		}
+		seen[account.Name] = true
+		if len(account.Regions) == 0 {
+			return nil, fmt.Errorf("account %s has no regions", account.Name)
+		}
+		for region := range account.SecurityGroups {
+			if !slices.Contains(account.Regions, region) {
+				return nil, fmt.Errorf("account %s: security group for unmanaged region %s", account.Name, region)
+			}
+		}
+	}
+	return file.Accounts, nil
+}
+
+// Target is one account/region pair managed by a MultiClient
+type Target struct {
+	Account       string
+	Region        string
+	SecurityGroup string // Empty if policies are not synced here
+	client        *AWSClient
+}
+
+// MultiClient fans discovery and sync out across accounts and regions.
+// Regions of the same account share one rate limiter.
+type MultiClient struct {
+	targets []*Target
+}
+
+// NewMultiClient creates clients for every account/region, assuming each
+// account's role (if any) from the default credentials
+func NewMultiClient(ctx context.Context, accounts []AccountConfig) (*MultiClient, error) {
+	base, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	m := &MultiClient{}
+	for _, account := range accounts {
+		cfg := base.Copy()
+		if account.RoleARN != "" {
+			provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(base), account.RoleARN,
+				func(o *stscreds.AssumeRoleOptions) {
+					o.RoleSessionName = "ztap-" + account.Name
+					if account.ExternalID != "" {
+						o.ExternalID = aws.String(account.ExternalID)
+					}
+				})
+			cfg.Credentials = aws.NewCredentialsCache(provider)
+		}
+
+		for _, region := range account.Regions {
+			client := ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+				o.Region = region
+			})
+			m.targets = append(m.targets, &Target{
+				Account:       account.Name,
+				Region:        region,
+				SecurityGroup: account.SecurityGroups[region],
+				client:        &AWSClient{ec2API: &faultyEC2{api: client}, region: region},
+			})
+		}
+	}
+
+	m.rateLimit(accounts)
+	return m, nil
+}
+
+// rateLimit wraps every target's API in its account's shared limiter
+func (m *MultiClient) rateLimit(accounts []AccountConfig) {
+	limiters := make(map[string]*rateLimiter, len(accounts))
+	for _, account := range accounts {
+		rate := account.RateLimit
+		if rate <= 0 {
+			rate = defaultAccountRateLimit
+		}
+		limiters[account.Name] = newRateLimiter(rate, int(rate)+1)
+	}
+
+	for _, t := range m.targets {
+		t.client.ec2API = &rateLimitedEC2{api: t.client.ec2API, limiter: limiters[t.Account]}
+	}
+}
+
+// Targets returns the account/region pairs managed by the client
+func (m *MultiClient) Targets() []Target {
+	targets := make([]Target, len(m.targets))
+	for i, t := range m.targets {
+		targets[i] = *t
+	}
+	return targets
+}
+
+// DiscoverResources discovers instances in every account and region
+// concurrently, labelling each with its account and region. Resources from
+// reachable targets are returned alongside an error naming the failed ones.
+func (m *MultiClient) DiscoverResources() ([]Resource, error) {
+	var mu sync.Mutex
+	var resources []Resource
+
+	err := m.fanOut(context.Background(), m.targets, func(ctx context.Context, t *Target) error {
+		// Keep instances found before container discovery failed
+		found, err := t.client.DiscoverResources()
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, r := range found {
+			r.Labels[LabelAccount] = t.Account
+			r.Labels[LabelRegion] = t.Region
+			resources = append(resources, r)
+		}
+		return err
+	})
+	return resources, err
+}
+
+// SyncPolicies syncs policies to the Security Group of every target that has
+// one configured, using up to concurrency workers per target
+func (m *MultiClient) SyncPolicies(ctx context.Context, policies []policy.NetworkPolicy, concurrency int) error {
+	return m.fanOut(ctx, m.syncTargets(), func(ctx context.Context, t *Target) error {
+		return t.client.SyncPolicies(ctx, policies, t.SecurityGroup, concurrency)
+	})
+}
+
+// SyncPoliciesFenced is SyncPolicies guarded by a fencing token on every
+// target's Security Group
+func (m *MultiClient) SyncPoliciesFenced(ctx context.Context, token cluster.FencingToken, policies []policy.NetworkPolicy, concurrency int) error {
+	return m.fanOut(ctx, m.syncTargets(), func(ctx context.Context, t *Target) error {
+		return t.client.SyncPoliciesFenced(ctx, token, policies, t.SecurityGroup, concurrency)
+	})
+}
+
+// ListRules lists the rules of the Security Group of every target that has
+// one configured. Rules from reachable targets are returned alongside an
+// error naming the failed ones.
+func (m *MultiClient) ListRules(ctx context.Context) ([]AppliedRule, error) {
+	var mu sync.Mutex
+	var rules []AppliedRule
+
+	err := m.fanOut(ctx, m.syncTargets(), func(ctx context.Context, t *Target) error {
+		found, err := t.client.ListRules(ctx, t.SecurityGroup)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, r := range found {
+			r.Account = t.Account
+			rules = append(rules, r)
+		}
+		return err
+	})
+	return rules, err
+}
+
+func (m *MultiClient) syncTargets() []*Target {
+	var targets []*Target
+	for _, t := range m.targets {
+		if t.SecurityGroup != "" {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// fanOut runs fn for every target concurrently and joins the failures,
+// each prefixed with its account and region. Per-item progress is reported
+// under the same prefix (see bulk.WithScope).
+func (m *MultiClient) fanOut(ctx context.Context, targets []*Target, fn func(ctx context.Context, t *Target) error) error {
+	errs := make([]error, len(targets))
+
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t *Target) {
+			defer wg.Done()
+			if err := fn(bulk.WithScope(ctx, t.Account+"/"+t.Region), t); err != nil {
+				errs[i] = fmt.Errorf("%s/%s: %w", t.Account, t.Region, err)
+			}
+		}(i, t)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
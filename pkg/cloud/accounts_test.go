@@ -0,0 +1,130 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ztap/pkg/policy"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func instanceOutput(id string) *ec2.DescribeInstancesOutput {
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{{
+			Instances: []types.Instance{{
+				InstanceId: aws.String(id),
+				State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+			}},
+		}},
+	}
+}
+
+func TestLoadAccounts(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "accounts.yaml")
+	os.WriteFile(valid, []byte(`
+accounts:
+  - name: prod
+    roleArn: arn:aws:iam::111111111111:role/ztap
+    regions: [us-east-1, eu-west-1]
+    securityGroups:
+      us-east-1: sg-111
+    rateLimit: 5
+  - name: dev
+    regions: [us-west-2]
+`), 0644)
+
+	accounts, err := LoadAccounts(valid)
+	if err != nil {
+		t.Fatalf("LoadAccounts failed: %v", err)
+	}
+	if len(accounts) != 2 || accounts[0].RoleARN == "" || accounts[0].SecurityGroups["us-east-1"] != "sg-111" {
+		t.Errorf("Unexpected accounts: %+v", accounts)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"empty", "accounts: []", "no accounts"},
+		{"no name", "accounts:\n  - regions: [us-east-1]", "without name"},
+		{"no regions", "accounts:\n  - name: prod", "no regions"},
+		{"duplicate", "accounts:\n  - name: a\n    regions: [us-east-1]\n  - name: a\n    regions: [us-east-1]", "duplicate"},
+		{"unmanaged region", "accounts:\n  - name: a\n    regions: [us-east-1]\n    securityGroups:\n      eu-west-1: sg-1", "unmanaged region"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".yaml")
+			os.WriteFile(path, []byte(tt.content), 0644)
+			if _, err := LoadAccounts(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMultiClientDiscoverResources(t *testing.T) {
+	m := &MultiClient{targets: []*Target{
+		{Account: "prod", Region: "us-east-1", client: &AWSClient{ec2API: &mockEC2Client{describeInstancesOutput: instanceOutput("i-1")}}},
+		{Account: "prod", Region: "eu-west-1", client: &AWSClient{ec2API: &mockEC2Client{describeInstancesOutput: instanceOutput("i-2")}}},
+		{Account: "dev", Region: "us-west-2", client: &AWSClient{ec2API: &mockEC2Client{describeInstancesErr: errors.New("access denied")}}},
+	}}
+	m.rateLimit([]AccountConfig{{Name: "prod"}, {Name: "dev"}})
+
+	resources, err := m.DiscoverResources()
+	if err == nil || !strings.Contains(err.Error(), "dev/us-west-2") {
+		t.Errorf("Expected error naming dev/us-west-2, got %v", err)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("Expected resources from reachable targets, got %d", len(resources))
+	}
+
+	regions := make(map[string]string)
+	for _, r := range resources {
+		if r.Labels[LabelAccount] != "prod" {
+			t.Errorf("Expected account label prod on %s, got %q", r.ID, r.Labels[LabelAccount])
+		}
+		regions[r.ID] = r.Labels[LabelRegion]
+	}
+	if regions["i-1"] != "us-east-1" || regions["i-2"] != "eu-west-1" {
+		t.Errorf("Unexpected region labels: %v", regions)
+	}
+}
+
+func TestMultiClientSyncPolicies(t *testing.T) {
+	synced := &mockEC2Client{}
+	unmanaged := &mockEC2Client{}
+	m := &MultiClient{targets: []*Target{
+		{Account: "prod", Region: "us-east-1", SecurityGroup: "sg-111", client: &AWSClient{ec2API: synced}},
+		{Account: "prod", Region: "eu-west-1", client: &AWSClient{ec2API: unmanaged}},
+	}}
+
+	policies, err := policy.Parse([]byte(exportPolicies))
+	if err != nil {
+		t.Fatalf("Failed to parse policies: %v", err)
+	}
+
+	if err := m.SyncPolicies(context.Background(), policies, 2); err != nil {
+		t.Fatalf("SyncPolicies failed: %v", err)
+	}
+	if len(synced.authorizeInputs) == 0 {
+		t.Error("Expected rules synced to the configured Security Group")
+	}
+	for _, input := range synced.authorizeInputs {
+		if aws.ToString(input.GroupId) != "sg-111" {
+			t.Errorf("Synced to unexpected group %s", aws.ToString(input.GroupId))
+		}
+	}
+	if len(unmanaged.authorizeInputs) != 0 {
+		t.Error("Expected no sync to a region without a Security Group")
+	}
+}
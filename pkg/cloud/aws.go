@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 
+	"ztap/pkg/cluster"
 	"ztap/pkg/policy"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -21,9 +23,22 @@ type ec2API interface {
 	DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
 	AuthorizeSecurityGroupEgress(ctx context.Context, params *ec2.AuthorizeSecurityGroupEgressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupEgressOutput, error)
 	DescribeSecurityGroups(ctx context.Context, params *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error)
+	DescribeSecurityGroupRules(ctx context.Context, params *ec2.DescribeSecurityGroupRulesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupRulesOutput, error)
 	RevokeSecurityGroupEgress(ctx context.Context, params *ec2.RevokeSecurityGroupEgressInput, optFns ...func(*ec2.Options)) (*ec2.RevokeSecurityGroupEgressOutput, error)
+	UpdateSecurityGroupRuleDescriptionsEgress(ctx context.Context, params *ec2.UpdateSecurityGroupRuleDescriptionsEgressInput, optFns ...func(*ec2.Options)) (*ec2.UpdateSecurityGroupRuleDescriptionsEgressOutput, error)
+	AuthorizeSecurityGroupIngress(ctx context.Context, params *ec2.AuthorizeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupIngressOutput, error)
+	RevokeSecurityGroupIngress(ctx context.Context, params *ec2.RevokeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.RevokeSecurityGroupIngressOutput, error)
+	UpdateSecurityGroupRuleDescriptionsIngress(ctx context.Context, params *ec2.UpdateSecurityGroupRuleDescriptionsIngressInput, optFns ...func(*ec2.Options)) (*ec2.UpdateSecurityGroupRuleDescriptionsIngressOutput, error)
+	CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error)
+	DescribeNetworkInterfaces(ctx context.Context, params *ec2.DescribeNetworkInterfacesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeNetworkInterfacesOutput, error)
 }
 
+// Security Group tags recording the leadership term of the last fenced sync
+const (
+	fencingEpochTag  = "ztap:fencing-epoch"
+	fencingLeaderTag = "ztap:fencing-leader"
+)
+
 // AWSClient manages AWS Security Group synchronization
 type AWSClient struct {
 	ec2API ec2API
@@ -48,12 +63,15 @@ func NewAWSClient(region string) (*AWSClient, error) {
 	}
 
 	return &AWSClient{
-		ec2API: ec2.NewFromConfig(cfg),
+		ec2API: &faultyEC2{api: ec2.NewFromConfig(cfg)},
 		region: region,
 	}, nil
 }
 
-// DiscoverResources finds all EC2 instances and their metadata
+// DiscoverResources finds all EC2 instances and containerized workloads
+// (see DiscoverContainers) and their metadata. If only container discovery
+// fails, the instances are still returned with an error wrapping
+// ErrContainerDiscovery.
 func (c *AWSClient) DiscoverResources() ([]Resource, error) {
 	input := &ec2.DescribeInstancesInput{}
 	result, err := c.ec2API.DescribeInstances(context.TODO(), input)
@@ -94,70 +112,184 @@ func (c *AWSClient) DiscoverResources() ([]Resource, error) {
 		}
 	}
 
-	return resources, nil
+	containers, err := c.DiscoverContainers()
+	if err != nil {
+		return resources, fmt.Errorf("%w: %v", ErrContainerDiscovery, err)
+	}
+	return append(resources, containers...), nil
 }
 
 // SyncPolicy converts ZTAP policy to AWS Security Group rules
 func (c *AWSClient) SyncPolicy(p policy.NetworkPolicy, sgID string) error {
+	return c.syncPolicy(p, sgID, nil)
+}
+
+// syncPolicy authorizes the rules p implies (see DeriveRules), describing
+// each with descriptions[rule name] when set, e.g. to name every policy
+// sharing the rule, and with its own Description otherwise
+func (c *AWSClient) syncPolicy(p policy.NetworkPolicy, sgID string, descriptions map[string]string) error {
 	log.Printf("Syncing policy '%s' to Security Group %s", p.Metadata.Name, sgID)
 
-	// For each egress rule in policy
-	for _, egress := range p.Spec.Egress {
-		// Convert to AWS Security Group rule
-		if egress.To.IPBlock.CIDR != "" {
-			for _, port := range egress.Ports {
-				err := c.authorizeEgress(sgID, egress.To.IPBlock.CIDR, port.Protocol, port.Port)
-				if err != nil {
-					return fmt.Errorf("failed to authorize egress: %w", err)
-				}
-			}
+	// Label-based destinations need IPs resolved from the inventory, which
+	// the Security Group sync does not have
+	rules, skipped := DeriveRules([]policy.NetworkPolicy{p}, nil)
+	for _, s := range skipped {
+		log.Printf("Note: skipping %s of policy '%s'", s.Reason, s.Policy)
+	}
+
+	for _, rule := range rules {
+		description, ok := descriptions[rule.Name()]
+		if !ok {
+			description = rule.Description()
 		}
+		if err := c.authorize(sgID, rule, description); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-		// Handle label-based rules (resolve labels to IPs first)
-		if len(egress.To.PodSelector.MatchLabels) > 0 {
-			log.Printf("Note: Label-based rules require IP resolution from inventory")
-			// In production: query discovered resources, match labels, extract IPs
-			// For now: log as warning
+// SyncPolicies syncs a set of policies to a Security Group using up to
+// concurrency parallel workers. Failures are aggregated per policy. Rules
+// shared by several policies are described with all of their names.
+func (c *AWSClient) SyncPolicies(ctx context.Context, policies []policy.NetworkPolicy, sgID string, concurrency int) error {
+	rules, _ := DeriveRules(policies, nil)
+	descriptions := make(map[string]string, len(rules))
+	for _, rule := range rules {
+		descriptions[rule.Name()] = rule.Description()
+	}
+	return policy.ApplyAll(ctx, policies, concurrency, func(p policy.NetworkPolicy) error {
+		return c.syncPolicy(p, sgID, descriptions)
+	})
+}
+
+// SyncPoliciesFenced is SyncPolicies guarded by a fencing token. The Security
+// Group is tagged with the token's epoch, and the sync is rejected with
+// cluster.ErrStaleEpoch if a newer leader has already claimed the group.
+// EC2 tags have no compare-and-swap, so this narrows rather than eliminates
+// the window in which two leaders can both write.
+func (c *AWSClient) SyncPoliciesFenced(ctx context.Context, token cluster.FencingToken, policies []policy.NetworkPolicy, sgID string, concurrency int) error {
+	if err := c.fenceSecurityGroup(ctx, token, sgID); err != nil {
+		return err
+	}
+	return c.SyncPolicies(ctx, policies, sgID, concurrency)
+}
+
+// fenceSecurityGroup checks the Security Group's recorded epoch against token
+// and records token as the latest writer
+func (c *AWSClient) fenceSecurityGroup(ctx context.Context, token cluster.FencingToken, sgID string) error {
+	result, err := c.ec2API.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		GroupIds: []string{sgID},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe security group: %w", err)
+	}
+	if len(result.SecurityGroups) == 0 {
+		return fmt.Errorf("security group %s not found", sgID)
+	}
+
+	var fence cluster.Fence
+	var recorded cluster.FencingToken
+	for _, tag := range result.SecurityGroups[0].Tags {
+		switch aws.ToString(tag.Key) {
+		case fencingEpochTag:
+			recorded.Epoch, _ = strconv.ParseInt(aws.ToString(tag.Value), 10, 64)
+		case fencingLeaderTag:
+			recorded.LeaderID = aws.ToString(tag.Value)
 		}
 	}
+	if err := fence.Admit(recorded); err != nil {
+		return err
+	}
+	if err := fence.Admit(token); err != nil {
+		return fmt.Errorf("security group %s: %w", sgID, err)
+	}
 
+	_, err = c.ec2API.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{sgID},
+		Tags: []types.Tag{
+			{Key: aws.String(fencingEpochTag), Value: aws.String(strconv.FormatInt(token.Epoch, 10))},
+			{Key: aws.String(fencingLeaderTag), Value: aws.String(token.LeaderID)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to tag security group with fencing token: %w", err)
+	}
 	return nil
 }
 
-// authorizeEgress adds an egress rule to the Security Group
-func (c *AWSClient) authorizeEgress(sgID, cidr, protocol string, port int) error {
-	// Convert protocol to lowercase (AWS uses lowercase)
-	proto := strings.ToLower(protocol)
+// authorize adds an egress or ingress rule to the Security Group
+func (c *AWSClient) authorize(sgID string, rule SecurityGroupRule, description string) error {
+	if rule.Ingress {
+		if err := c.authorizeIngress(sgID, rule, description); err != nil {
+			return fmt.Errorf("failed to authorize ingress: %w", err)
+		}
+		return nil
+	}
+	if err := c.authorizeEgress(sgID, rule, description); err != nil {
+		return fmt.Errorf("failed to authorize egress: %w", err)
+	}
+	return nil
+}
+
+// ipPermission returns the permission granting rule, described with
+// description
+func ipPermission(rule SecurityGroupRule, description string) types.IpPermission {
+	permission := types.IpPermission{
+		IpProtocol: aws.String(rule.Protocol),
+		FromPort:   aws.Int32(int32(rule.Port)),
+		ToPort:     aws.Int32(int32(rule.LastPort())),
+	}
+	if rule.IPv6() {
+		permission.Ipv6Ranges = []types.Ipv6Range{{CidrIpv6: aws.String(rule.CIDR), Description: aws.String(description)}}
+	} else {
+		permission.IpRanges = []types.IpRange{{CidrIp: aws.String(rule.CIDR), Description: aws.String(description)}}
+	}
+	return permission
+}
 
+// authorizeEgress adds an egress rule to the Security Group
+func (c *AWSClient) authorizeEgress(sgID string, rule SecurityGroupRule, description string) error {
 	// Note: AWS Security Groups are stateful, so egress rules automatically allow responses
 	input := &ec2.AuthorizeSecurityGroupEgressInput{
-		GroupId: aws.String(sgID),
-		IpPermissions: []types.IpPermission{
-			{
-				IpProtocol: aws.String(proto),
-				FromPort:   aws.Int32(int32(port)),
-				ToPort:     aws.Int32(int32(port)),
-				IpRanges: []types.IpRange{
-					{
-						CidrIp:      aws.String(cidr),
-						Description: aws.String("Managed by ZTAP"),
-					},
-				},
-			},
-		},
+		GroupId:       aws.String(sgID),
+		IpPermissions: []types.IpPermission{ipPermission(rule, description)},
 	}
 
 	_, err := c.ec2API.AuthorizeSecurityGroupEgress(context.TODO(), input)
 	if err != nil {
 		// Ignore "duplicate rule" errors
 		if strings.Contains(err.Error(), "already exists") {
-			log.Printf("Rule already exists: %s:%d -> %s", protocol, port, cidr)
+			log.Printf("Rule already exists: %s:%s -> %s", rule.Protocol, policy.PortRange(rule.Port, rule.EndPort), rule.CIDR)
+			return nil
+		}
+		return err
+	}
+
+	log.Printf("Authorized egress: %s:%s -> %s in %s", rule.Protocol, policy.PortRange(rule.Port, rule.EndPort), rule.CIDR, sgID)
+	return nil
+}
+
+// authorizeIngress adds an ingress rule to the Security Group. Responses to
+// allowed inbound traffic need no egress rule.
+func (c *AWSClient) authorizeIngress(sgID string, rule SecurityGroupRule, description string) error {
+	input := &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(sgID),
+		IpPermissions: []types.IpPermission{ipPermission(rule, description)},
+	}
+
+	_, err := c.ec2API.AuthorizeSecurityGroupIngress(context.TODO(), input)
+	if err != nil {
+		// Ignore "duplicate rule" errors
+		if strings.Contains(err.Error(), "already exists") {
+			log.Printf("Rule already exists: %s:%s <- %s", rule.Protocol, policy.PortRange(rule.Port, rule.EndPort), rule.CIDR)
 			return nil
 		}
 		return err
 	}
 
-	log.Printf("Authorized egress: %s:%d -> %s in %s", protocol, port, cidr, sgID)
+	log.Printf("Authorized ingress: %s:%s <- %s in %s", rule.Protocol, policy.PortRange(rule.Port, rule.EndPort), rule.CIDR, sgID)
 	return nil
 }
 
@@ -195,6 +327,65 @@ func (c *AWSClient) RevokeAllEgress(sgID string) error {
 	return nil
 }
 
+// AppliedRule is a rule as it exists on a Security Group in AWS, whether or
+// not ZTAP created it
+type AppliedRule struct {
+	ID            string `json:"id"`
+	Account       string `json:"account,omitempty"` // Set by MultiClient
+	Region        string `json:"region"`
+	SecurityGroup string `json:"securityGroup"`
+	Egress        bool   `json:"egress"`
+	Protocol      string `json:"protocol"` // "-1" for all protocols
+	FromPort      int    `json:"fromPort"`
+	ToPort        int    `json:"toPort"`
+	// Exactly one of CIDR, PrefixList and Group is the peer
+	CIDR        string `json:"cidr,omitempty"`
+	PrefixList  string `json:"prefixList,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// ListRules returns every ingress and egress rule of a Security Group, as
+// AWS reports them
+func (c *AWSClient) ListRules(ctx context.Context, sgID string) ([]AppliedRule, error) {
+	input := &ec2.DescribeSecurityGroupRulesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("group-id"), Values: []string{sgID}},
+		},
+	}
+
+	var rules []AppliedRule
+	paginator := ec2.NewDescribeSecurityGroupRulesPaginator(c.ec2API, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe security group rules: %w", err)
+		}
+		for _, r := range page.SecurityGroupRules {
+			rule := AppliedRule{
+				ID:            aws.ToString(r.SecurityGroupRuleId),
+				Region:        c.region,
+				SecurityGroup: aws.ToString(r.GroupId),
+				Egress:        aws.ToBool(r.IsEgress),
+				Protocol:      aws.ToString(r.IpProtocol),
+				FromPort:      int(aws.ToInt32(r.FromPort)),
+				ToPort:        int(aws.ToInt32(r.ToPort)),
+				CIDR:          aws.ToString(r.CidrIpv4),
+				PrefixList:    aws.ToString(r.PrefixListId),
+				Description:   aws.ToString(r.Description),
+			}
+			if rule.CIDR == "" {
+				rule.CIDR = aws.ToString(r.CidrIpv6)
+			}
+			if r.ReferencedGroupInfo != nil {
+				rule.Group = aws.ToString(r.ReferencedGroupInfo.GroupId)
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
 // MatchResourcesByLabels finds resources matching the given labels
 func MatchResourcesByLabels(resources []Resource, labels map[string]string) []Resource {
 	var matched []Resource
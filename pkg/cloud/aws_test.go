@@ -3,8 +3,12 @@ package cloud
 import (
 	"context"
 	"errors"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
+	"ztap/pkg/cluster"
 	"ztap/pkg/policy"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -14,6 +18,8 @@ import (
 
 // mockEC2Client implements the ec2API interface for testing.
 type mockEC2Client struct {
+	mu sync.Mutex
+
 	describeInstancesOutput *ec2.DescribeInstancesOutput
 	describeInstancesErr    error
 
@@ -23,8 +29,22 @@ type mockEC2Client struct {
 	describeSGOutput *ec2.DescribeSecurityGroupsOutput
 	describeSGErr    error
 
+	describeRulesInputs []*ec2.DescribeSecurityGroupRulesInput
+	describeRulesPages  []*ec2.DescribeSecurityGroupRulesOutput
+
 	revokeInput *ec2.RevokeSecurityGroupEgressInput
 	revokeErr   error
+
+	updateDescriptionInputs []*ec2.UpdateSecurityGroupRuleDescriptionsEgressInput
+
+	authorizeIngressInputs         []*ec2.AuthorizeSecurityGroupIngressInput
+	revokeIngressInput             *ec2.RevokeSecurityGroupIngressInput
+	updateIngressDescriptionInputs []*ec2.UpdateSecurityGroupRuleDescriptionsIngressInput
+
+	createTagsInputs []*ec2.CreateTagsInput
+
+	describeENIPages []*ec2.DescribeNetworkInterfacesOutput
+	describeENIErr   error
 }
 
 func (m *mockEC2Client) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
@@ -32,6 +52,8 @@ func (m *mockEC2Client) DescribeInstances(ctx context.Context, params *ec2.Descr
 }
 
 func (m *mockEC2Client) AuthorizeSecurityGroupEgress(ctx context.Context, params *ec2.AuthorizeSecurityGroupEgressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupEgressOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.authorizeInputs = append(m.authorizeInputs, params)
 	if m.authorizeErr != nil {
 		return nil, m.authorizeErr
@@ -43,6 +65,26 @@ func (m *mockEC2Client) DescribeSecurityGroups(ctx context.Context, params *ec2.
 	return m.describeSGOutput, m.describeSGErr
 }
 
+func (m *mockEC2Client) DescribeSecurityGroupRules(ctx context.Context, params *ec2.DescribeSecurityGroupRulesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupRulesOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.describeRulesInputs = append(m.describeRulesInputs, params)
+	if len(m.describeRulesPages) == 0 {
+		return &ec2.DescribeSecurityGroupRulesOutput{}, nil
+	}
+
+	// Pages are addressed by their index as the NextToken
+	page := 0
+	if params.NextToken != nil {
+		page, _ = strconv.Atoi(aws.ToString(params.NextToken))
+	}
+	out := *m.describeRulesPages[page]
+	if page+1 < len(m.describeRulesPages) {
+		out.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return &out, nil
+}
+
 func (m *mockEC2Client) RevokeSecurityGroupEgress(ctx context.Context, params *ec2.RevokeSecurityGroupEgressInput, optFns ...func(*ec2.Options)) (*ec2.RevokeSecurityGroupEgressOutput, error) {
 	m.revokeInput = params
 	if m.revokeErr != nil {
@@ -51,6 +93,65 @@ func (m *mockEC2Client) RevokeSecurityGroupEgress(ctx context.Context, params *e
 	return &ec2.RevokeSecurityGroupEgressOutput{}, nil
 }
 
+func (m *mockEC2Client) UpdateSecurityGroupRuleDescriptionsEgress(ctx context.Context, params *ec2.UpdateSecurityGroupRuleDescriptionsEgressInput, optFns ...func(*ec2.Options)) (*ec2.UpdateSecurityGroupRuleDescriptionsEgressOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updateDescriptionInputs = append(m.updateDescriptionInputs, params)
+	return &ec2.UpdateSecurityGroupRuleDescriptionsEgressOutput{}, nil
+}
+
+func (m *mockEC2Client) AuthorizeSecurityGroupIngress(ctx context.Context, params *ec2.AuthorizeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.authorizeIngressInputs = append(m.authorizeIngressInputs, params)
+	if m.authorizeErr != nil {
+		return nil, m.authorizeErr
+	}
+	return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+}
+
+func (m *mockEC2Client) RevokeSecurityGroupIngress(ctx context.Context, params *ec2.RevokeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	m.revokeIngressInput = params
+	if m.revokeErr != nil {
+		return nil, m.revokeErr
+	}
+	return &ec2.RevokeSecurityGroupIngressOutput{}, nil
+}
+
+func (m *mockEC2Client) UpdateSecurityGroupRuleDescriptionsIngress(ctx context.Context, params *ec2.UpdateSecurityGroupRuleDescriptionsIngressInput, optFns ...func(*ec2.Options)) (*ec2.UpdateSecurityGroupRuleDescriptionsIngressOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updateIngressDescriptionInputs = append(m.updateIngressDescriptionInputs, params)
+	return &ec2.UpdateSecurityGroupRuleDescriptionsIngressOutput{}, nil
+}
+
+func (m *mockEC2Client) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.createTagsInputs = append(m.createTagsInputs, params)
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (m *mockEC2Client) DescribeNetworkInterfaces(ctx context.Context, params *ec2.DescribeNetworkInterfacesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	if m.describeENIErr != nil {
+		return nil, m.describeENIErr
+	}
+	if len(m.describeENIPages) == 0 {
+		return &ec2.DescribeNetworkInterfacesOutput{}, nil
+	}
+
+	// Pages are addressed by their index as the NextToken
+	page := 0
+	if params.NextToken != nil {
+		page, _ = strconv.Atoi(aws.ToString(params.NextToken))
+	}
+	out := *m.describeENIPages[page]
+	if page+1 < len(m.describeENIPages) {
+		out.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return &out, nil
+}
+
 func TestMatchResourcesByLabels(t *testing.T) {
 	resources := []Resource{
 		{ID: "i-1", Labels: map[string]string{"env": "prod", "app": "web"}},
@@ -140,22 +241,15 @@ func TestSyncPolicyWithIPBlock(t *testing.T) {
 			IPBlock struct {
 				CIDR string `yaml:"cidr"`
 			} `yaml:"ipBlock,omitempty"`
+			Group string `yaml:"group,omitempty"`
 		} `yaml:"to"`
-		Ports []struct {
-			Protocol string `yaml:"protocol"`
-			Port     int    `yaml:"port"`
-		} `yaml:"ports"`
+		Ports []policy.PolicyPort `yaml:"ports"`
+		When  string              `yaml:"when,omitempty"`
 	}{}
 
 	egress.To.IPBlock.CIDR = "10.0.0.0/24"
-	egress.Ports = append(egress.Ports, struct {
-		Protocol string `yaml:"protocol"`
-		Port     int    `yaml:"port"`
-	}{Protocol: "TCP", Port: 5432})
-	egress.Ports = append(egress.Ports, struct {
-		Protocol string `yaml:"protocol"`
-		Port     int    `yaml:"port"`
-	}{Protocol: "UDP", Port: 53})
+	egress.Ports = append(egress.Ports, policy.PolicyPort{Protocol: "TCP", Port: 5432})
+	egress.Ports = append(egress.Ports, policy.PolicyPort{Protocol: "UDP", Port: 53})
 
 	np.Spec.Egress = append(np.Spec.Egress, egress)
 
@@ -198,17 +292,13 @@ func TestSyncPolicyAuthorizeError(t *testing.T) {
 			IPBlock struct {
 				CIDR string `yaml:"cidr"`
 			} `yaml:"ipBlock,omitempty"`
+			Group string `yaml:"group,omitempty"`
 		} `yaml:"to"`
-		Ports []struct {
-			Protocol string `yaml:"protocol"`
-			Port     int    `yaml:"port"`
-		} `yaml:"ports"`
+		Ports []policy.PolicyPort `yaml:"ports"`
+		When  string              `yaml:"when,omitempty"`
 	}{}
 	egress.To.IPBlock.CIDR = "10.0.0.0/24"
-	egress.Ports = append(egress.Ports, struct {
-		Protocol string `yaml:"protocol"`
-		Port     int    `yaml:"port"`
-	}{Protocol: "TCP", Port: 443})
+	egress.Ports = append(egress.Ports, policy.PolicyPort{Protocol: "TCP", Port: 443})
 	np.Spec.Egress = append(np.Spec.Egress, egress)
 
 	err := client.SyncPolicy(np, "sg-456")
@@ -217,11 +307,73 @@ func TestSyncPolicyAuthorizeError(t *testing.T) {
 	}
 }
 
+func TestSyncPolicies(t *testing.T) {
+	mock := &mockEC2Client{}
+	client := &AWSClient{ec2API: mock, region: "us-east-1"}
+
+	policies, err := policy.Parse([]byte(`
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: allow-https
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/8
+      ports:
+        - protocol: TCP
+          port: 443
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: allow-dns
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 8.8.8.8/32
+      ports:
+        - protocol: UDP
+          port: 53
+`))
+	if err != nil {
+		t.Fatalf("failed to parse policies: %v", err)
+	}
+
+	if err := client.SyncPolicies(context.Background(), policies, "sg-123", 4); err != nil {
+		t.Fatalf("SyncPolicies returned error: %v", err)
+	}
+	if len(mock.authorizeInputs) != 2 {
+		t.Fatalf("expected 2 authorize calls, got %d", len(mock.authorizeInputs))
+	}
+
+	mock.authorizeErr = errors.New("api failure")
+	err = client.SyncPolicies(context.Background(), policies, "sg-123", 4)
+	var applyErr *policy.ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("expected *policy.ApplyError, got %v", err)
+	}
+	if len(applyErr.Failures) != 2 {
+		t.Fatalf("expected 2 failures, got %d", len(applyErr.Failures))
+	}
+	if !strings.Contains(err.Error(), "allow-dns") || !strings.Contains(err.Error(), "allow-https") {
+		t.Fatalf("expected error to name failing policies, got %v", err)
+	}
+}
+
 func TestAuthorizeEgressDuplicate(t *testing.T) {
 	mock := &mockEC2Client{authorizeErr: errors.New("rule already exists")}
 	client := &AWSClient{ec2API: mock, region: "us-east-1"}
 
-	if err := client.authorizeEgress("sg-789", "10.0.0.0/24", "TCP", 80); err != nil {
+	if err := client.authorizeEgress("sg-789", SecurityGroupRule{Protocol: "tcp", Port: 80, CIDR: "10.0.0.0/24"}, "Managed by ZTAP"); err != nil {
 		t.Fatalf("expected duplicate error to be ignored, got %v", err)
 	}
 }
@@ -285,3 +437,127 @@ func TestRevokeAllEgressNotFound(t *testing.T) {
 		t.Fatal("expected error for missing security group, got nil")
 	}
 }
+
+func TestListRules(t *testing.T) {
+	mock := &mockEC2Client{describeRulesPages: []*ec2.DescribeSecurityGroupRulesOutput{
+		{SecurityGroupRules: []types.SecurityGroupRule{{
+			SecurityGroupRuleId: aws.String("sgr-1"),
+			GroupId:             aws.String("sg-123"),
+			IsEgress:            aws.Bool(true),
+			IpProtocol:          aws.String("tcp"),
+			FromPort:            aws.Int32(443),
+			ToPort:              aws.Int32(443),
+			CidrIpv4:            aws.String("10.0.0.0/8"),
+			Description:         aws.String("Managed by ZTAP: allow-https"),
+		}}},
+		{SecurityGroupRules: []types.SecurityGroupRule{{
+			SecurityGroupRuleId: aws.String("sgr-2"),
+			GroupId:             aws.String("sg-123"),
+			IsEgress:            aws.Bool(false),
+			IpProtocol:          aws.String("-1"),
+			FromPort:            aws.Int32(-1),
+			ToPort:              aws.Int32(-1),
+			ReferencedGroupInfo: &types.ReferencedSecurityGroup{GroupId: aws.String("sg-123")},
+		}}},
+	}}
+	client := &AWSClient{ec2API: mock, region: "us-east-1"}
+
+	rules, err := client.ListRules(context.Background(), "sg-123")
+	if err != nil {
+		t.Fatalf("ListRules failed: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected rules from both pages, got %+v", rules)
+	}
+	want := AppliedRule{
+		ID: "sgr-1", Region: "us-east-1", SecurityGroup: "sg-123", Egress: true,
+		Protocol: "tcp", FromPort: 443, ToPort: 443, CIDR: "10.0.0.0/8",
+		Description: "Managed by ZTAP: allow-https",
+	}
+	if rules[0] != want {
+		t.Errorf("expected %+v, got %+v", want, rules[0])
+	}
+	if rules[1].Egress || rules[1].Group != "sg-123" || rules[1].CIDR != "" {
+		t.Errorf("expected ingress rule from the group itself, got %+v", rules[1])
+	}
+	filter := mock.describeRulesInputs[0].Filters[0]
+	if aws.ToString(filter.Name) != "group-id" || filter.Values[0] != "sg-123" {
+		t.Errorf("expected rules filtered by group, got %+v", filter)
+	}
+}
+
+func TestSyncPoliciesFenced(t *testing.T) {
+	policies, err := policy.Parse([]byte(`
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: allow-https
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/8
+      ports:
+        - protocol: TCP
+          port: 443
+`))
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+
+	taggedGroup := func(epoch, leader string) *ec2.DescribeSecurityGroupsOutput {
+		return &ec2.DescribeSecurityGroupsOutput{
+			SecurityGroups: []types.SecurityGroup{{
+				GroupId: aws.String("sg-123"),
+				Tags: []types.Tag{
+					{Key: aws.String(fencingEpochTag), Value: aws.String(epoch)},
+					{Key: aws.String(fencingLeaderTag), Value: aws.String(leader)},
+				},
+			}},
+		}
+	}
+
+	t.Run("current leader", func(t *testing.T) {
+		mock := &mockEC2Client{describeSGOutput: taggedGroup("2", "node-a")}
+		client := &AWSClient{ec2API: mock, region: "us-east-1"}
+
+		token := cluster.FencingToken{Epoch: 3, LeaderID: "node-b"}
+		if err := client.SyncPoliciesFenced(context.Background(), token, policies, "sg-123", 2); err != nil {
+			t.Fatalf("expected fenced sync to succeed: %v", err)
+		}
+		if len(mock.createTagsInputs) != 1 || aws.ToString(mock.createTagsInputs[0].Tags[0].Value) != "3" {
+			t.Errorf("expected security group tagged with epoch 3, got %+v", mock.createTagsInputs)
+		}
+		if len(mock.authorizeInputs) != 1 {
+			t.Errorf("expected 1 authorize call, got %d", len(mock.authorizeInputs))
+		}
+	})
+
+	t.Run("stale leader", func(t *testing.T) {
+		mock := &mockEC2Client{describeSGOutput: taggedGroup("5", "node-a")}
+		client := &AWSClient{ec2API: mock, region: "us-east-1"}
+
+		token := cluster.FencingToken{Epoch: 4, LeaderID: "node-b"}
+		err := client.SyncPoliciesFenced(context.Background(), token, policies, "sg-123", 2)
+		if !errors.Is(err, cluster.ErrStaleEpoch) {
+			t.Fatalf("expected ErrStaleEpoch, got %v", err)
+		}
+		if len(mock.authorizeInputs) != 0 || len(mock.createTagsInputs) != 0 {
+			t.Error("expected no writes from a stale leader")
+		}
+	})
+
+	t.Run("split brain", func(t *testing.T) {
+		mock := &mockEC2Client{describeSGOutput: taggedGroup("5", "node-a")}
+		client := &AWSClient{ec2API: mock, region: "us-east-1"}
+
+		token := cluster.FencingToken{Epoch: 5, LeaderID: "node-b"}
+		err := client.SyncPoliciesFenced(context.Background(), token, policies, "sg-123", 2)
+		if !errors.Is(err, cluster.ErrStaleEpoch) {
+			t.Fatalf("expected ErrStaleEpoch for conflicting leader, got %v", err)
+		}
+	})
+}
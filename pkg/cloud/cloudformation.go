@@ -0,0 +1,105 @@
+package cloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"ztap/pkg/policy"
+)
+
+// CloudFormationOptions controls CloudFormation export
+type CloudFormationOptions struct {
+	// SecurityGroupID is the default for the SecurityGroupId parameter
+	SecurityGroupID string
+}
+
+// LogicalID returns a stable CloudFormation logical ID for the rule.
+// Logical IDs must be alphanumeric, so the readable part is followed by a
+// hash of the raw protocol, ports and CIDR to keep IDs unique once
+// separators are dropped. Ingress rules start with ZtapIn.
+func (r SecurityGroupRule) LogicalID() string {
+	var b strings.Builder
+	b.WriteString("Ztap")
+	if r.Ingress {
+		b.WriteString("In")
+	}
+	words := strings.FieldsFunc(fmt.Sprintf("%s_%s_%s", strings.ToLower(r.Protocol), policy.PortRange(r.Port, r.EndPort), strings.ToLower(r.CIDR)), func(c rune) bool {
+		return !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9')
+	})
+	for _, word := range words {
+		b.WriteString(strings.ToUpper(word[:1]))
+		b.WriteString(word[1:])
+	}
+	return b.String() + strings.ToUpper(r.hash())
+}
+
+// WriteCloudFormation renders rules as a CloudFormation template of
+// AWS::EC2::SecurityGroupEgress and AWS::EC2::SecurityGroupIngress resources
+// attached to the SecurityGroupId parameter. Keys are emitted in sorted order
+// and logical IDs derive only from rule content, so repeated exports produce
+// minimal diffs. Skipped peers are recorded under Metadata.
+func WriteCloudFormation(w io.Writer, rules []SecurityGroupRule, skipped []SkippedRule, opts CloudFormationOptions) error {
+	// CloudFormation rejects templates without resources
+	if len(rules) == 0 {
+		return fmt.Errorf("no Security Group rules to export")
+	}
+
+	parameter := map[string]interface{}{
+		"Type":        "AWS::EC2::SecurityGroup::Id",
+		"Description": "Security Group the ZTAP rules are attached to",
+	}
+	if opts.SecurityGroupID != "" {
+		parameter["Default"] = opts.SecurityGroupID
+	}
+
+	resources := make(map[string]interface{}, len(rules))
+	for _, r := range rules {
+		properties := map[string]interface{}{
+			"GroupId":     map[string]string{"Ref": "SecurityGroupId"},
+			"IpProtocol":  r.Protocol,
+			"FromPort":    r.Port,
+			"ToPort":      r.LastPort(),
+			"Description": r.Description(),
+		}
+		if r.IPv6() {
+			properties["CidrIpv6"] = r.CIDR
+		} else {
+			properties["CidrIp"] = r.CIDR
+		}
+
+		id := r.LogicalID()
+		if _, exists := resources[id]; exists {
+			return fmt.Errorf("duplicate logical ID %s", id)
+		}
+		resourceType := "AWS::EC2::SecurityGroupEgress"
+		if r.Ingress {
+			resourceType = "AWS::EC2::SecurityGroupIngress"
+		}
+		resources[id] = map[string]interface{}{
+			"Type":       resourceType,
+			"Properties": properties,
+		}
+	}
+
+	template := map[string]interface{}{
+		"AWSTemplateFormatVersion": "2010-09-09",
+		"Description":              "ZTAP Security Group rules. Generated by ztap cloud export; re-export after policy changes instead of editing.",
+		"Parameters":               map[string]interface{}{"SecurityGroupId": parameter},
+		"Resources":                resources,
+	}
+	if len(skipped) > 0 {
+		notes := make([]string, 0, len(skipped))
+		for _, s := range skipped {
+			notes = append(notes, fmt.Sprintf("%s: %s", s.Policy, s.Reason))
+		}
+		template["Metadata"] = map[string]interface{}{
+			"ZTAP": map[string]interface{}{"Skipped": notes},
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(template)
+}
@@ -0,0 +1,121 @@
+package cloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"testing"
+)
+
+func TestSecurityGroupRuleLogicalID(t *testing.T) {
+	rule := SecurityGroupRule{Policies: []string{"web-egress"}, Protocol: "tcp", Port: 5432, CIDR: "10.0.0.0/16"}
+
+	id := rule.LogicalID()
+	if !regexp.MustCompile(`^ZtapTcp54321000016[0-9A-F]{8}$`).MatchString(id) {
+		t.Errorf("Unexpected logical ID %s", id)
+	}
+	if rule.LogicalID() != id {
+		t.Error("Logical ID is not stable")
+	}
+
+	// Dropping separators would make these collide without the hash suffix
+	a := SecurityGroupRule{Policies: []string{"p"}, Protocol: "tcp", Port: 443, CIDR: "1.10.0.0/16"}
+	b := SecurityGroupRule{Policies: []string{"p"}, Protocol: "tcp", Port: 443, CIDR: "11.0.0.0/16"}
+	if a.LogicalID() == b.LogicalID() {
+		t.Errorf("Expected distinct logical IDs, both %s", a.LogicalID())
+	}
+}
+
+func TestWriteCloudFormation(t *testing.T) {
+	rules, skipped := DeriveRules(loadExportPolicies(t), nil)
+
+	var out bytes.Buffer
+	if err := WriteCloudFormation(&out, rules, skipped, CloudFormationOptions{SecurityGroupID: "sg-123"}); err != nil {
+		t.Fatalf("WriteCloudFormation failed: %v", err)
+	}
+
+	var template struct {
+		Parameters map[string]struct {
+			Type    string
+			Default string
+		}
+		Resources map[string]struct {
+			Type       string
+			Properties map[string]interface{}
+		}
+		Metadata struct {
+			ZTAP struct{ Skipped []string }
+		}
+	}
+	if err := json.Unmarshal(out.Bytes(), &template); err != nil {
+		t.Fatalf("Invalid template JSON: %v", err)
+	}
+
+	if p := template.Parameters["SecurityGroupId"]; p.Type != "AWS::EC2::SecurityGroup::Id" || p.Default != "sg-123" {
+		t.Errorf("Unexpected parameter: %+v", p)
+	}
+	if len(template.Resources) != len(rules) {
+		t.Fatalf("Expected %d resources, got %d", len(rules), len(template.Resources))
+	}
+	if len(template.Metadata.ZTAP.Skipped) != 1 {
+		t.Errorf("Expected skipped destination in metadata, got %v", template.Metadata.ZTAP.Skipped)
+	}
+
+	for _, r := range rules {
+		res, ok := template.Resources[r.LogicalID()]
+		if !ok {
+			t.Fatalf("Missing resource %s", r.LogicalID())
+		}
+		if res.Type != "AWS::EC2::SecurityGroupEgress" {
+			t.Errorf("Unexpected resource type %s", res.Type)
+		}
+		cidrKey := "CidrIp"
+		if r.IPv6() {
+			cidrKey = "CidrIpv6"
+		}
+		if res.Properties[cidrKey] != r.CIDR {
+			t.Errorf("%s: expected %s=%s, got %v", r.LogicalID(), cidrKey, r.CIDR, res.Properties)
+		}
+	}
+
+	// Export is deterministic
+	var again bytes.Buffer
+	WriteCloudFormation(&again, rules, skipped, CloudFormationOptions{SecurityGroupID: "sg-123"})
+	if again.String() != out.String() {
+		t.Error("Repeated export produced different output")
+	}
+}
+
+func TestWriteCloudFormationSharedRule(t *testing.T) {
+	policies := loadExportPolicies(t)
+	other := policies[0]
+	other.Metadata.Name = "web_egress"
+
+	rules, skipped := DeriveRules(append(policies, other), nil)
+	var out bytes.Buffer
+	if err := WriteCloudFormation(&out, rules, skipped, CloudFormationOptions{}); err != nil {
+		t.Fatalf("Expected policies sharing rules to export, got %v", err)
+	}
+
+	var template struct {
+		Resources map[string]struct{ Properties map[string]interface{} }
+	}
+	if err := json.Unmarshal(out.Bytes(), &template); err != nil {
+		t.Fatalf("Invalid template JSON: %v", err)
+	}
+	if len(template.Resources) != 3 {
+		t.Fatalf("Expected one resource per destination, got %d", len(template.Resources))
+	}
+	for id, res := range template.Resources {
+		if res.Properties["Description"] != "Managed by ZTAP: web-egress, web_egress" {
+			t.Errorf("%s: unexpected description %v", id, res.Properties["Description"])
+		}
+	}
+}
+
+func TestWriteCloudFormationEmpty(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteCloudFormation(&out, nil, nil, CloudFormationOptions{}); err == nil {
+		t.Error("Expected error for template without rules")
+	}
+}
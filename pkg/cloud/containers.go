@@ -0,0 +1,111 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Resource types reported for containerized workloads
+const (
+	ResourceTypeECS = "ECS"
+	ResourceTypeEKS = "EKS"
+)
+
+// Tags AWS sets on container network interfaces
+const (
+	ecsClusterTag = "aws:ecs:clusterName"
+	ecsServiceTag = "aws:ecs:serviceName"
+	eksBranchENI  = "aws-k8s-branch-eni" // Description of Security Groups for Pods ENIs
+)
+
+// ErrContainerDiscovery is returned alongside EC2 instances when containerized
+// workloads could not be discovered
+var ErrContainerDiscovery = errors.New("container discovery failed")
+
+// DiscoverContainers finds containerized workloads that own a network
+// interface: ECS tasks in awsvpc mode and EKS pods using Security Groups for
+// Pods (branch ENIs). Each is reported with its ENI IPs and labelled with the
+// ENI's tags, so policies can select them by label like EC2 instances.
+//
+// ENI tags are not task tags, and tags set only on an ECS task do not appear
+// on its ENI. Reading task tags needs ecs:ListTasks and ecs:DescribeTasks with
+// TAGS, which this client does not call yet. Pods
+// sharing a node's ENIs under the default VPC CNI mode have no ENI of their
+// own and are not discovered.
+func (c *AWSClient) DiscoverContainers() ([]Resource, error) {
+	input := &ec2.DescribeNetworkInterfacesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("status"), Values: []string{string(types.NetworkInterfaceStatusInUse)}},
+		},
+	}
+
+	var resources []Resource
+	paginator := ec2.NewDescribeNetworkInterfacesPaginator(c.ec2API, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe network interfaces: %w", err)
+		}
+
+		for _, eni := range page.NetworkInterfaces {
+			resourceType := containerType(eni)
+			if resourceType == "" {
+				continue
+			}
+			resources = append(resources, containerResource(eni, resourceType))
+		}
+	}
+
+	return resources, nil
+}
+
+// containerType returns the workload type owning eni, or "" if it does not
+// belong to a container
+func containerType(eni types.NetworkInterface) string {
+	description := aws.ToString(eni.Description)
+	switch {
+	case eni.InterfaceType == types.NetworkInterfaceTypeBranch, description == eksBranchENI:
+		return ResourceTypeEKS
+	case strings.HasPrefix(description, "arn:aws:ecs:"):
+		return ResourceTypeECS
+	}
+	for _, tag := range eni.TagSet {
+		if aws.ToString(tag.Key) == ecsClusterTag {
+			return ResourceTypeECS
+		}
+	}
+	return ""
+}
+
+func containerResource(eni types.NetworkInterface, resourceType string) Resource {
+	labels := make(map[string]string, len(eni.TagSet))
+	var name string
+	for _, tag := range eni.TagSet {
+		key := aws.ToString(tag.Key)
+		value := aws.ToString(tag.Value)
+		labels[key] = value
+		if key == "Name" || (key == ecsServiceTag && name == "") {
+			name = value
+		}
+	}
+
+	var publicIP string
+	if eni.Association != nil {
+		publicIP = aws.ToString(eni.Association.PublicIp)
+	}
+
+	return Resource{
+		ID:        aws.ToString(eni.NetworkInterfaceId),
+		Name:      name,
+		Type:      resourceType,
+		PrivateIP: aws.ToString(eni.PrivateIpAddress),
+		PublicIP:  publicIP,
+		Labels:    labels,
+	}
+}
@@ -0,0 +1,106 @@
+package cloud
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func tag(key, value string) types.Tag {
+	return types.Tag{Key: aws.String(key), Value: aws.String(value)}
+}
+
+func TestDiscoverContainers(t *testing.T) {
+	mock := &mockEC2Client{
+		describeENIPages: []*ec2.DescribeNetworkInterfacesOutput{
+			{NetworkInterfaces: []types.NetworkInterface{
+				{
+					NetworkInterfaceId: aws.String("eni-ecs"),
+					Description:        aws.String("arn:aws:ecs:us-east-1:111111111111:attachment/abc"),
+					InterfaceType:      types.NetworkInterfaceTypeInterface,
+					PrivateIpAddress:   aws.String("10.0.1.10"),
+					Association:        &types.NetworkInterfaceAssociation{PublicIp: aws.String("203.0.113.10")},
+					TagSet: []types.Tag{
+						tag(ecsClusterTag, "prod"),
+						tag(ecsServiceTag, "payments"),
+						tag("app", "payments"),
+					},
+				},
+				{
+					// Instance primary ENI: not a container
+					NetworkInterfaceId: aws.String("eni-instance"),
+					InterfaceType:      types.NetworkInterfaceTypeInterface,
+					PrivateIpAddress:   aws.String("10.0.1.5"),
+				},
+			}},
+			{NetworkInterfaces: []types.NetworkInterface{
+				{
+					NetworkInterfaceId: aws.String("eni-pod"),
+					Description:        aws.String(eksBranchENI),
+					InterfaceType:      types.NetworkInterfaceTypeBranch,
+					PrivateIpAddress:   aws.String("10.0.2.20"),
+					TagSet:             []types.Tag{tag("Name", "checkout-pod"), tag("app", "checkout")},
+				},
+			}},
+		},
+	}
+
+	client := &AWSClient{ec2API: mock, region: "us-east-1"}
+	resources, err := client.DiscoverContainers()
+	if err != nil {
+		t.Fatalf("DiscoverContainers returned error: %v", err)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("Expected 2 containers across pages, got %d: %+v", len(resources), resources)
+	}
+
+	ecs := resources[0]
+	if ecs.Type != ResourceTypeECS || ecs.Name != "payments" || ecs.PrivateIP != "10.0.1.10" || ecs.PublicIP != "203.0.113.10" {
+		t.Errorf("Unexpected ECS task: %+v", ecs)
+	}
+
+	pod := resources[1]
+	if pod.Type != ResourceTypeEKS || pod.Name != "checkout-pod" || pod.PrivateIP != "10.0.2.20" {
+		t.Errorf("Unexpected EKS pod: %+v", pod)
+	}
+
+	// Containers are selectable by label like instances
+	matched := MatchResourcesByLabels(resources, map[string]string{"app": "checkout"})
+	if len(matched) != 1 || matched[0].ID != "eni-pod" {
+		t.Errorf("Expected eni-pod to match app=checkout, got %+v", matched)
+	}
+}
+
+func TestDiscoverResourcesIncludesContainers(t *testing.T) {
+	mock := &mockEC2Client{
+		describeInstancesOutput: instanceOutput("i-1"),
+		describeENIPages: []*ec2.DescribeNetworkInterfacesOutput{
+			{NetworkInterfaces: []types.NetworkInterface{{
+				NetworkInterfaceId: aws.String("eni-ecs"),
+				TagSet:             []types.Tag{tag(ecsClusterTag, "prod")},
+			}}},
+		},
+	}
+
+	client := &AWSClient{ec2API: mock, region: "us-east-1"}
+	resources, err := client.DiscoverResources()
+	if err != nil {
+		t.Fatalf("DiscoverResources returned error: %v", err)
+	}
+	if len(resources) != 2 || resources[0].Type != "EC2" || resources[1].Type != ResourceTypeECS {
+		t.Errorf("Expected instance then ECS task, got %+v", resources)
+	}
+
+	// Instances are still returned when containers cannot be discovered
+	mock.describeENIErr = errors.New("UnauthorizedOperation")
+	resources, err = client.DiscoverResources()
+	if !errors.Is(err, ErrContainerDiscovery) {
+		t.Errorf("Expected container discovery error, got %v", err)
+	}
+	if len(resources) != 1 || resources[0].Type != "EC2" {
+		t.Errorf("Expected EC2 instance despite container error, got %+v", resources)
+	}
+}
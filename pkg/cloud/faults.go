@@ -0,0 +1,93 @@
+package cloud
+
+import (
+	"context"
+
+	"ztap/pkg/faults"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+// faultyEC2 fails calls to an ec2API when faults are injected at
+// faults.AWSCall, so chaos tests can exercise partial cloud sync failures.
+// It passes every call through while no fault is configured.
+type faultyEC2 struct {
+	api ec2API
+}
+
+func (f *faultyEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	if err := faults.Inject(faults.AWSCall); err != nil {
+		return nil, err
+	}
+	return f.api.DescribeInstances(ctx, params, optFns...)
+}
+
+func (f *faultyEC2) AuthorizeSecurityGroupEgress(ctx context.Context, params *ec2.AuthorizeSecurityGroupEgressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupEgressOutput, error) {
+	if err := faults.Inject(faults.AWSCall); err != nil {
+		return nil, err
+	}
+	return f.api.AuthorizeSecurityGroupEgress(ctx, params, optFns...)
+}
+
+func (f *faultyEC2) DescribeSecurityGroups(ctx context.Context, params *ec2.DescribeSecurityGroupsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupsOutput, error) {
+	if err := faults.Inject(faults.AWSCall); err != nil {
+		return nil, err
+	}
+	return f.api.DescribeSecurityGroups(ctx, params, optFns...)
+}
+
+func (f *faultyEC2) DescribeSecurityGroupRules(ctx context.Context, params *ec2.DescribeSecurityGroupRulesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeSecurityGroupRulesOutput, error) {
+	if err := faults.Inject(faults.AWSCall); err != nil {
+		return nil, err
+	}
+	return f.api.DescribeSecurityGroupRules(ctx, params, optFns...)
+}
+
+func (f *faultyEC2) RevokeSecurityGroupEgress(ctx context.Context, params *ec2.RevokeSecurityGroupEgressInput, optFns ...func(*ec2.Options)) (*ec2.RevokeSecurityGroupEgressOutput, error) {
+	if err := faults.Inject(faults.AWSCall); err != nil {
+		return nil, err
+	}
+	return f.api.RevokeSecurityGroupEgress(ctx, params, optFns...)
+}
+
+func (f *faultyEC2) UpdateSecurityGroupRuleDescriptionsEgress(ctx context.Context, params *ec2.UpdateSecurityGroupRuleDescriptionsEgressInput, optFns ...func(*ec2.Options)) (*ec2.UpdateSecurityGroupRuleDescriptionsEgressOutput, error) {
+	if err := faults.Inject(faults.AWSCall); err != nil {
+		return nil, err
+	}
+	return f.api.UpdateSecurityGroupRuleDescriptionsEgress(ctx, params, optFns...)
+}
+
+func (f *faultyEC2) AuthorizeSecurityGroupIngress(ctx context.Context, params *ec2.AuthorizeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	if err := faults.Inject(faults.AWSCall); err != nil {
+		return nil, err
+	}
+	return f.api.AuthorizeSecurityGroupIngress(ctx, params, optFns...)
+}
+
+func (f *faultyEC2) RevokeSecurityGroupIngress(ctx context.Context, params *ec2.RevokeSecurityGroupIngressInput, optFns ...func(*ec2.Options)) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	if err := faults.Inject(faults.AWSCall); err != nil {
+		return nil, err
+	}
+	return f.api.RevokeSecurityGroupIngress(ctx, params, optFns...)
+}
+
+func (f *faultyEC2) UpdateSecurityGroupRuleDescriptionsIngress(ctx context.Context, params *ec2.UpdateSecurityGroupRuleDescriptionsIngressInput, optFns ...func(*ec2.Options)) (*ec2.UpdateSecurityGroupRuleDescriptionsIngressOutput, error) {
+	if err := faults.Inject(faults.AWSCall); err != nil {
+		return nil, err
+	}
+	return f.api.UpdateSecurityGroupRuleDescriptionsIngress(ctx, params, optFns...)
+}
+
+func (f *faultyEC2) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	if err := faults.Inject(faults.AWSCall); err != nil {
+		return nil, err
+	}
+	return f.api.CreateTags(ctx, params, optFns...)
+}
+
+func (f *faultyEC2) DescribeNetworkInterfaces(ctx context.Context, params *ec2.DescribeNetworkInterfacesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	if err := faults.Inject(faults.AWSCall); err != nil {
+		return nil, err
+	}
+	return f.api.DescribeNetworkInterfaces(ctx, params, optFns...)
+}
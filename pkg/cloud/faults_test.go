@@ -0,0 +1,29 @@
+package cloud
+
+import (
+	"errors"
+	"testing"
+
+	"ztap/pkg/faults"
+)
+
+func TestFaultyEC2(t *testing.T) {
+	defer faults.Reset()
+
+	mock := &mockEC2Client{describeInstancesOutput: instanceOutput("i-1")}
+	client := &AWSClient{ec2API: &faultyEC2{api: mock}}
+
+	if resources, err := client.DiscoverResources(); err != nil || len(resources) != 1 {
+		t.Fatalf("Expected calls to pass through without faults, got %v, %v", resources, err)
+	}
+
+	if err := faults.Set("aws.call:fail=100%"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.DiscoverResources(); !errors.Is(err, faults.ErrInjected) {
+		t.Errorf("Expected injected failure, got %v", err)
+	}
+	if faults.Injected(faults.AWSCall) == 0 {
+		t.Error("Expected injected AWS failures to be counted")
+	}
+}
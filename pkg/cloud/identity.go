@@ -0,0 +1,315 @@
+package cloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Cloud identity labels. Identities are attached to discovery registrations
+// and cluster node metadata under these keys so policies can select
+// workloads by account, role or VPC; LabelAccount and LabelRegion are shared
+// with resources discovered through a MultiClient.
+const (
+	LabelProvider = "ztap:provider"
+	LabelZone     = "ztap:zone"
+	LabelInstance = "ztap:instance"
+	LabelRole     = "ztap:role"
+	LabelVPC      = "ztap:vpc"
+)
+
+// Default instance metadata endpoints
+const (
+	awsMetadataURL   = "http://169.254.169.254"
+	azureMetadataURL = "http://169.254.169.254"
+	gcpMetadataURL   = "http://metadata.google.internal"
+)
+
+// identityTimeout bounds each metadata probe so detection off-cloud is fast
+const identityTimeout = 2 * time.Second
+
+// ErrNoIdentity is returned when no metadata service identifies the node
+var ErrNoIdentity = errors.New("no cloud instance metadata service found")
+
+// Identity is a node's workload identity derived from instance metadata
+type Identity struct {
+	Provider string // aws, azure or gcp
+	Account  string // AWS account, Azure subscription or GCP project
+	Region   string
+	Zone     string
+	Instance string
+	Role     string // IAM role, Azure managed identity client ID or GCP service account
+	VPC      string // VPC, VNet or network name, if exposed by the metadata service
+}
+
+// Labels returns the identity as labels, omitting unknown attributes
+func (id *Identity) Labels() map[string]string {
+	labels := make(map[string]string)
+	for key, value := range map[string]string{
+		LabelProvider: id.Provider,
+		LabelAccount:  id.Account,
+		LabelRegion:   id.Region,
+		LabelZone:     id.Zone,
+		LabelInstance: id.Instance,
+		LabelRole:     id.Role,
+		LabelVPC:      id.VPC,
+	} {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// IdentityProvider derives a node identity from one cloud's metadata service
+type IdentityProvider interface {
+	Name() string
+	Identity(ctx context.Context) (*Identity, error)
+}
+
+// DefaultIdentityProviders returns providers for AWS, Azure and GCP using
+// their standard metadata endpoints
+func DefaultIdentityProviders() []IdentityProvider {
+	return []IdentityProvider{
+		NewAWSIdentityProvider(awsMetadataURL),
+		NewAzureIdentityProvider(azureMetadataURL),
+		NewGCPIdentityProvider(gcpMetadataURL),
+	}
+}
+
+// DetectIdentity returns the identity from the first provider whose metadata
+// service answers, or ErrNoIdentity
+func DetectIdentity(ctx context.Context, providers ...IdentityProvider) (*Identity, error) {
+	var errs []error
+	for _, p := range providers {
+		probeCtx, cancel := context.WithTimeout(ctx, identityTimeout)
+		id, err := p.Identity(probeCtx)
+		cancel()
+		if err == nil {
+			return id, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+	}
+	return nil, fmt.Errorf("%w: %w", ErrNoIdentity, errors.Join(errs...))
+}
+
+// metadataClient performs metadata requests against a base URL
+type metadataClient struct {
+	baseURL string
+	http    *http.Client
+}
+
+func newMetadataClient(baseURL string) metadataClient {
+	return metadataClient{baseURL: strings.TrimSuffix(baseURL, "/"), http: &http.Client{}}
+}
+
+// errNotFound is returned for metadata paths the service does not expose
+var errNotFound = errors.New("metadata not found")
+
+func (c metadataClient) do(ctx context.Context, method, urlPath string, headers map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+urlPath, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: status %d", method, urlPath, resp.StatusCode)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// optional returns value, treating a missing path as empty
+func optional(value string, err error) (string, error) {
+	if errors.Is(err, errNotFound) {
+		return "", nil
+	}
+	return value, err
+}
+
+// awsIdentityProvider reads the EC2 instance metadata service (IMDSv2)
+type awsIdentityProvider struct {
+	client metadataClient
+}
+
+// NewAWSIdentityProvider creates an IMDSv2 provider for baseURL
+func NewAWSIdentityProvider(baseURL string) IdentityProvider {
+	return &awsIdentityProvider{client: newMetadataClient(baseURL)}
+}
+
+func (p *awsIdentityProvider) Name() string { return "aws" }
+
+func (p *awsIdentityProvider) Identity(ctx context.Context) (*Identity, error) {
+	token, err := p.client.do(ctx, http.MethodPut, "/latest/api/token",
+		map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "300"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get IMDSv2 token: %w", err)
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": token}
+
+	document, err := p.client.do(ctx, http.MethodGet, "/latest/dynamic/instance-identity/document", headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance identity document: %w", err)
+	}
+	var doc struct {
+		AccountID        string `json:"accountId"`
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+		InstanceID       string `json:"instanceId"`
+	}
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse instance identity document: %w", err)
+	}
+
+	id := &Identity{
+		Provider: "aws",
+		Account:  doc.AccountID,
+		Region:   doc.Region,
+		Zone:     doc.AvailabilityZone,
+		Instance: doc.InstanceID,
+	}
+
+	// The role name is listed under security-credentials when an instance profile is attached
+	if id.Role, err = optional(p.client.do(ctx, http.MethodGet, "/latest/meta-data/iam/security-credentials/", headers)); err != nil {
+		return nil, err
+	}
+
+	mac, err := optional(p.client.do(ctx, http.MethodGet, "/latest/meta-data/mac", headers))
+	if err != nil {
+		return nil, err
+	}
+	if mac != "" {
+		if id.VPC, err = optional(p.client.do(ctx, http.MethodGet, "/latest/meta-data/network/interfaces/macs/"+mac+"/vpc-id", headers)); err != nil {
+			return nil, err
+		}
+	}
+	return id, nil
+}
+
+// azureIdentityProvider reads the Azure instance metadata service
+type azureIdentityProvider struct {
+	client metadataClient
+}
+
+// NewAzureIdentityProvider creates an Azure IMDS provider for baseURL
+func NewAzureIdentityProvider(baseURL string) IdentityProvider {
+	return &azureIdentityProvider{client: newMetadataClient(baseURL)}
+}
+
+func (p *azureIdentityProvider) Name() string { return "azure" }
+
+func (p *azureIdentityProvider) Identity(ctx context.Context) (*Identity, error) {
+	headers := map[string]string{"Metadata": "true"}
+
+	compute, err := p.client.do(ctx, http.MethodGet, "/metadata/instance/compute?api-version=2021-02-01", headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance metadata: %w", err)
+	}
+	var doc struct {
+		SubscriptionID string `json:"subscriptionId"`
+		Location       string `json:"location"`
+		Zone           string `json:"zone"`
+		VMID           string `json:"vmId"`
+	}
+	if err := json.Unmarshal([]byte(compute), &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse instance metadata: %w", err)
+	}
+
+	id := &Identity{
+		Provider: "azure",
+		Account:  doc.SubscriptionID,
+		Region:   doc.Location,
+		Zone:     doc.Zone,
+		Instance: doc.VMID,
+	}
+
+	// A token request reveals the managed identity (Azure AD workload
+	// identity) client ID; VMs without one answer 400 and keep an empty role
+	token, err := p.client.do(ctx, http.MethodGet,
+		"/metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.azure.com%2F", headers)
+	if err == nil {
+		var resp struct {
+			ClientID string `json:"client_id"`
+		}
+		if json.Unmarshal([]byte(token), &resp) == nil {
+			id.Role = resp.ClientID
+		}
+	}
+	return id, nil
+}
+
+// gcpIdentityProvider reads the GCE metadata server
+type gcpIdentityProvider struct {
+	client metadataClient
+}
+
+// NewGCPIdentityProvider creates a GCE metadata provider for baseURL
+func NewGCPIdentityProvider(baseURL string) IdentityProvider {
+	return &gcpIdentityProvider{client: newMetadataClient(baseURL)}
+}
+
+func (p *gcpIdentityProvider) Name() string { return "gcp" }
+
+func (p *gcpIdentityProvider) Identity(ctx context.Context) (*Identity, error) {
+	headers := map[string]string{"Metadata-Flavor": "Google"}
+	get := func(key string) (string, error) {
+		return p.client.do(ctx, http.MethodGet, "/computeMetadata/v1/"+key, headers)
+	}
+
+	project, err := get("project/project-id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read project: %w", err)
+	}
+	// Zone and network are resource paths such as projects/123/zones/us-central1-a
+	zone, err := get("instance/zone")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zone: %w", err)
+	}
+	instance, err := get("instance/id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance ID: %w", err)
+	}
+
+	id := &Identity{
+		Provider: "gcp",
+		Account:  project,
+		Zone:     path.Base(zone),
+		Instance: instance,
+	}
+	if i := strings.LastIndex(id.Zone, "-"); i > 0 {
+		id.Region = id.Zone[:i]
+	}
+
+	if id.Role, err = optional(get("instance/service-accounts/default/email")); err != nil {
+		return nil, err
+	}
+	network, err := optional(get("instance/network-interfaces/0/network"))
+	if err != nil {
+		return nil, err
+	}
+	if network != "" {
+		id.VPC = path.Base(network)
+	}
+	return id, nil
+}
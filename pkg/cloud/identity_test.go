@@ -0,0 +1,193 @@
+package cloud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// metadataServer serves fixed responses, requiring header to be set on
+// every request
+func metadataServer(t *testing.T, header, value string, responses map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(header) != value {
+			http.Error(w, "missing header", http.StatusUnauthorized)
+			return
+		}
+		body, ok := responses[r.Method+" "+r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAWSIdentity(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Path == "/latest/api/token" {
+			if r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				http.Error(w, "missing ttl", http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte("token-1"))
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != "token-1" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/latest/dynamic/instance-identity/document":
+			_, _ = w.Write([]byte(`{"accountId":"111111111111","region":"us-east-1","availabilityZone":"us-east-1a","instanceId":"i-123"}`))
+		case "/latest/meta-data/iam/security-credentials/":
+			_, _ = w.Write([]byte("web-role\n"))
+		case "/latest/meta-data/mac":
+			_, _ = w.Write([]byte("0a:1b:2c:3d:4e:5f"))
+		case "/latest/meta-data/network/interfaces/macs/0a:1b:2c:3d:4e:5f/vpc-id":
+			_, _ = w.Write([]byte("vpc-abc"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	id, err := NewAWSIdentityProvider(srv.URL).Identity(context.Background())
+	if err != nil {
+		t.Fatalf("Identity failed: %v", err)
+	}
+
+	expected := &Identity{
+		Provider: "aws",
+		Account:  "111111111111",
+		Region:   "us-east-1",
+		Zone:     "us-east-1a",
+		Instance: "i-123",
+		Role:     "web-role",
+		VPC:      "vpc-abc",
+	}
+	if !reflect.DeepEqual(id, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, id)
+	}
+}
+
+func TestAWSIdentityWithoutRole(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			_, _ = w.Write([]byte("token"))
+		case "/latest/dynamic/instance-identity/document":
+			_, _ = w.Write([]byte(`{"accountId":"111111111111","region":"eu-west-1","instanceId":"i-456"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	id, err := NewAWSIdentityProvider(srv.URL).Identity(context.Background())
+	if err != nil {
+		t.Fatalf("Identity failed: %v", err)
+	}
+	if id.Role != "" || id.VPC != "" {
+		t.Errorf("Expected empty role and VPC, got %+v", id)
+	}
+	if id.Account != "111111111111" || id.Instance != "i-456" {
+		t.Errorf("Unexpected identity %+v", id)
+	}
+}
+
+func TestAzureIdentity(t *testing.T) {
+	srv := metadataServer(t, "Metadata", "true", map[string]string{
+		"GET /metadata/instance/compute?api-version=2021-02-01":                                                     `{"subscriptionId":"sub-1","location":"westeurope","zone":"2","vmId":"vm-1"}`,
+		"GET /metadata/identity/oauth2/token?api-version=2018-02-01&resource=https%3A%2F%2Fmanagement.azure.com%2F": `{"access_token":"secret","client_id":"client-1"}`,
+	})
+
+	id, err := NewAzureIdentityProvider(srv.URL).Identity(context.Background())
+	if err != nil {
+		t.Fatalf("Identity failed: %v", err)
+	}
+
+	expected := &Identity{
+		Provider: "azure",
+		Account:  "sub-1",
+		Region:   "westeurope",
+		Zone:     "2",
+		Instance: "vm-1",
+		Role:     "client-1",
+	}
+	if !reflect.DeepEqual(id, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, id)
+	}
+}
+
+func TestGCPIdentity(t *testing.T) {
+	srv := metadataServer(t, "Metadata-Flavor", "Google", map[string]string{
+		"GET /computeMetadata/v1/project/project-id":                      "my-project",
+		"GET /computeMetadata/v1/instance/zone":                           "projects/123/zones/us-central1-a",
+		"GET /computeMetadata/v1/instance/id":                             "987",
+		"GET /computeMetadata/v1/instance/service-accounts/default/email": "web@my-project.iam.gserviceaccount.com",
+		"GET /computeMetadata/v1/instance/network-interfaces/0/network":   "projects/123/networks/prod-vpc",
+	})
+
+	id, err := NewGCPIdentityProvider(srv.URL).Identity(context.Background())
+	if err != nil {
+		t.Fatalf("Identity failed: %v", err)
+	}
+
+	expected := &Identity{
+		Provider: "gcp",
+		Account:  "my-project",
+		Region:   "us-central1",
+		Zone:     "us-central1-a",
+		Instance: "987",
+		Role:     "web@my-project.iam.gserviceaccount.com",
+		VPC:      "prod-vpc",
+	}
+	if !reflect.DeepEqual(id, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, id)
+	}
+}
+
+func TestDetectIdentity(t *testing.T) {
+	// An AWS endpoint that rejects everything, as on a non-AWS host
+	aws := httptest.NewServer(http.NotFoundHandler())
+	defer aws.Close()
+	gcp := metadataServer(t, "Metadata-Flavor", "Google", map[string]string{
+		"GET /computeMetadata/v1/project/project-id": "my-project",
+		"GET /computeMetadata/v1/instance/zone":      "projects/123/zones/europe-west1-b",
+		"GET /computeMetadata/v1/instance/id":        "42",
+	})
+
+	id, err := DetectIdentity(context.Background(), NewAWSIdentityProvider(aws.URL), NewGCPIdentityProvider(gcp.URL))
+	if err != nil {
+		t.Fatalf("DetectIdentity failed: %v", err)
+	}
+	if id.Provider != "gcp" || id.Account != "my-project" {
+		t.Errorf("Expected GCP identity, got %+v", id)
+	}
+
+	_, err = DetectIdentity(context.Background(), NewAWSIdentityProvider(aws.URL))
+	if !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("Expected ErrNoIdentity, got %v", err)
+	}
+}
+
+func TestIdentityLabels(t *testing.T) {
+	id := &Identity{Provider: "aws", Account: "111111111111", Region: "us-east-1", Role: "web-role"}
+
+	expected := map[string]string{
+		LabelProvider: "aws",
+		LabelAccount:  "111111111111",
+		LabelRegion:   "us-east-1",
+		LabelRole:     "web-role",
+	}
+	if labels := id.Labels(); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected %v, got %v", expected, labels)
+	}
+}
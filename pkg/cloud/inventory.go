@@ -0,0 +1,105 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Inventory resolves label selectors against discovered cloud resources, so
+// cloud workloads can back policy selectors alongside service discovery (see
+// policy.NewPolicyResolver). It serves the snapshot taken by the last
+// Refresh rather than calling the provider on every lookup.
+type Inventory struct {
+	discover  func() ([]Resource, error)
+	mu        sync.RWMutex
+	resources []Resource
+}
+
+// NewInventory creates an inventory filled by discover, e.g. the
+// DiscoverResources method of an AWSClient or MultiClient
+func NewInventory(discover func() ([]Resource, error)) *Inventory {
+	return &Inventory{discover: discover}
+}
+
+// Refresh replaces the snapshot with newly discovered resources. Resources
+// returned alongside an error (unreachable accounts, failed container
+// discovery) are kept; if nothing was found the previous snapshot stays.
+func (i *Inventory) Refresh() error {
+	resources, err := i.discover()
+	if len(resources) > 0 || err == nil {
+		i.mu.Lock()
+		i.resources = resources
+		i.mu.Unlock()
+	}
+	return err
+}
+
+// Run refreshes the inventory every interval until ctx is cancelled
+func (i *Inventory) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := i.Refresh(); err != nil {
+				log.Printf("Warning: Failed to refresh cloud inventory: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ResolveLabels returns the private IPs of resources whose tags match every
+// label in the selector
+func (i *Inventory) ResolveLabels(labels map[string]string) ([]string, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	var ips []string
+	for _, r := range i.resources {
+		if r.PrivateIP == "" || !matchTags(r.Labels, labels) {
+			continue
+		}
+		ips = append(ips, r.PrivateIP)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no cloud resources found matching labels: %v", labels)
+	}
+	return ips, nil
+}
+
+// LabelsOf returns the tags of the resource with the private IP ip, which
+// rule conditions are evaluated against (see policy.LabelSource)
+func (i *Inventory) LabelsOf(ip string) (map[string]string, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	for _, r := range i.resources {
+		if r.PrivateIP == ip {
+			return r.Labels, true
+		}
+	}
+	return nil, false
+}
+
+// Resources returns the resources of the last Refresh
+func (i *Inventory) Resources() []Resource {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return append([]Resource(nil), i.resources...)
+}
+
+// matchTags checks if resource tags match the selector
+func matchTags(tags, selector map[string]string) bool {
+	for key, value := range selector {
+		if tags[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package cloud
+
+import (
+	"errors"
+	"testing"
+
+	"ztap/pkg/policy"
+)
+
+func TestInventoryResolveLabels(t *testing.T) {
+	resources := []Resource{
+		{ID: "i-1", PrivateIP: "10.0.1.1", Labels: map[string]string{"app": "web", "env": "prod"}},
+		{ID: "i-2", PrivateIP: "10.0.1.2", Labels: map[string]string{"app": "web", "env": "dev"}},
+		{ID: "i-3", Labels: map[string]string{"app": "web", "env": "prod"}}, // No private IP
+	}
+	inventory := NewInventory(func() ([]Resource, error) { return resources, nil })
+
+	if _, err := inventory.ResolveLabels(map[string]string{"app": "web"}); err == nil {
+		t.Error("Expected error before the first refresh")
+	}
+	if err := inventory.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	ips, err := inventory.ResolveLabels(map[string]string{"app": "web", "env": "prod"})
+	if err != nil || len(ips) != 1 || ips[0] != "10.0.1.1" {
+		t.Errorf("Expected 10.0.1.1, got %v, %v", ips, err)
+	}
+	if _, err := inventory.ResolveLabels(map[string]string{"app": "db"}); err == nil {
+		t.Error("Expected error for unmatched selector")
+	}
+}
+
+func TestInventoryRefreshKeepsSnapshot(t *testing.T) {
+	var found []Resource
+	var discoverErr error
+	inventory := NewInventory(func() ([]Resource, error) { return found, discoverErr })
+
+	found = []Resource{{PrivateIP: "10.0.1.1", Labels: map[string]string{"app": "web"}}}
+	inventory.Refresh()
+
+	// A failed refresh with nothing found keeps the last snapshot
+	found, discoverErr = nil, errors.New("throttled")
+	if err := inventory.Refresh(); err == nil {
+		t.Error("Expected refresh error")
+	}
+	if ips, _ := inventory.ResolveLabels(map[string]string{"app": "web"}); len(ips) != 1 {
+		t.Errorf("Expected previous snapshot, got %v", ips)
+	}
+
+	// Partial results replace it
+	found = []Resource{{PrivateIP: "10.0.1.2", Labels: map[string]string{"app": "web"}}}
+	discoverErr = ErrContainerDiscovery
+	inventory.Refresh()
+	if ips, _ := inventory.ResolveLabels(map[string]string{"app": "web"}); len(ips) != 1 || ips[0] != "10.0.1.2" {
+		t.Errorf("Expected partial results, got %v", ips)
+	}
+	if resources := inventory.Resources(); len(resources) != 1 || resources[0].PrivateIP != "10.0.1.2" {
+		t.Errorf("Expected snapshot resources, got %v", resources)
+	}
+}
+
+func TestInventoryWithServiceDiscovery(t *testing.T) {
+	inventory := NewInventory(func() ([]Resource, error) {
+		return []Resource{{PrivateIP: "10.0.1.1", Labels: map[string]string{"app": "web"}}}, nil
+	})
+	inventory.Refresh()
+
+	// One selector covers the AWS VM and the locally registered host
+	resolver := policy.NewPolicyResolver(stubResolver{"web": {"192.168.1.10"}}, inventory)
+	ips, err := resolver.ResolveLabels(map[string]string{"app": "web"})
+	if err != nil || len(ips) != 2 {
+		t.Errorf("Expected local and cloud endpoints, got %v, %v", ips, err)
+	}
+}
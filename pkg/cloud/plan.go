@@ -0,0 +1,304 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"ztap/pkg/policy"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// ProviderAWS names AWS Security Groups in a RuleChange
+const ProviderAWS = "aws"
+
+// managedPrefix starts the description of every rule ZTAP creates (see
+// SecurityGroupRule.Description); rules without it are left alone
+const managedPrefix = "Managed by ZTAP"
+
+// Actions of a RuleChange. Keep and unmanaged are not changes: they place the
+// changes among the live rules in a full diff.
+const (
+	ActionCreate    = "create"    // Desired rule missing from the Security Group
+	ActionUpdate    = "update"    // Managed rule whose description is out of date
+	ActionRevoke    = "revoke"    // Managed rule no policy implies any more
+	ActionKeep      = "keep"      // Managed rule already as desired
+	ActionUnmanaged = "unmanaged" // Rule ZTAP did not create, never changed
+)
+
+// RuleChange is what a sync would do to one rule of a Security Group
+type RuleChange struct {
+	Action        string   `json:"action"`
+	Provider      string   `json:"provider"`
+	Account       string   `json:"account,omitempty"` // Set by MultiClient
+	Region        string   `json:"region"`
+	SecurityGroup string   `json:"securityGroup"`
+	RuleID        string   `json:"ruleId,omitempty"` // Empty for creates
+	Protocol      string   `json:"protocol"`         // "-1" for all protocols
+	FromPort      int      `json:"fromPort"`
+	ToPort        int      `json:"toPort"`
+	Peer          string   `json:"peer"` // CIDR, prefix list or Security Group
+	Ingress       bool     `json:"ingress,omitempty"`
+	Policies      []string `json:"policies,omitempty"`
+	// Description is the desired description, LiveDescription the one
+	// recorded in AWS
+	Description     string `json:"description,omitempty"`
+	LiveDescription string `json:"liveDescription,omitempty"`
+}
+
+// Changed reports whether applying the change modifies the Security Group
+func (c RuleChange) Changed() bool {
+	return c.Action == ActionCreate || c.Action == ActionUpdate || c.Action == ActionRevoke
+}
+
+// Rule returns the rule in the form "tcp/443 -> 10.0.0.0/8", or
+// "tcp/22 <- 10.0.0.0/8" for an ingress rule
+func (c RuleChange) Rule() string {
+	protocol := c.Protocol
+	if protocol == "-1" {
+		protocol = "all"
+	}
+	arrow := "->"
+	if c.Ingress {
+		arrow = "<-"
+	}
+	switch {
+	case c.FromPort == c.ToPort && c.FromPort > 0:
+		return fmt.Sprintf("%s/%d %s %s", protocol, c.FromPort, arrow, c.Peer)
+	case c.FromPort > 0 || c.ToPort > 0:
+		return fmt.Sprintf("%s/%d-%d %s %s", protocol, c.FromPort, c.ToPort, arrow, c.Peer)
+	}
+	return fmt.Sprintf("%s %s %s", protocol, arrow, c.Peer)
+}
+
+// PlanRules compares the desired rules of a Security Group with its live
+// rules. Desired rules missing from it are created; rules ZTAP manages
+// (described "Managed by ZTAP...") are updated when their description no
+// longer names the policies allowing them, and revoked when no policy
+// implies them. Other egress rules are reported as unmanaged; other ingress
+// rules are left out. Changes are sorted by protocol, port and peer.
+func PlanRules(desired []SecurityGroupRule, live []AppliedRule) []RuleChange {
+	wanted := make(map[string]SecurityGroupRule, len(desired))
+	for _, rule := range desired {
+		wanted[rule.Name()] = rule
+	}
+
+	var changes []RuleChange
+	found := make(map[string]bool, len(live))
+	for _, r := range live {
+		managed := strings.HasPrefix(r.Description, managedPrefix)
+		if !r.Egress && !managed {
+			continue
+		}
+		change := RuleChange{
+			RuleID:          r.ID,
+			Protocol:        r.Protocol,
+			FromPort:        r.FromPort,
+			ToPort:          r.ToPort,
+			Peer:            r.CIDR + r.PrefixList + r.Group,
+			Ingress:         !r.Egress,
+			LiveDescription: r.Description,
+		}
+		if !managed {
+			change.Action = ActionUnmanaged
+			changes = append(changes, change)
+			continue
+		}
+
+		key := SecurityGroupRule{Protocol: r.Protocol, Port: r.FromPort, EndPort: r.ToPort, CIDR: r.CIDR, Ingress: !r.Egress}
+		rule, ok := wanted[key.Name()]
+		switch {
+		case !ok || r.CIDR == "" || r.ToPort != key.LastPort() || found[key.Name()]:
+			change.Action = ActionRevoke
+		case r.Description != rule.Description():
+			change.Action = ActionUpdate
+		default:
+			change.Action = ActionKeep
+		}
+		if ok && change.Action != ActionRevoke {
+			found[key.Name()] = true
+			change.Policies = rule.Policies
+			change.Description = rule.Description()
+		}
+		changes = append(changes, change)
+	}
+
+	for _, rule := range desired {
+		if found[rule.Name()] {
+			continue
+		}
+		changes = append(changes, RuleChange{
+			Action:      ActionCreate,
+			Protocol:    rule.Protocol,
+			FromPort:    rule.Port,
+			ToPort:      rule.LastPort(),
+			Peer:        rule.CIDR,
+			Ingress:     rule.Ingress,
+			Policies:    rule.Policies,
+			Description: rule.Description(),
+		})
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.FromPort != b.FromPort {
+			return a.FromPort < b.FromPort
+		}
+		if a.Peer != b.Peer {
+			return a.Peer < b.Peer
+		}
+		return !a.Ingress && b.Ingress
+	})
+	return changes
+}
+
+// PlanSync returns what syncing policies to a Security Group would change,
+// against its live rules (see PlanRules). podSelector destinations are not
+// synced, so they are not planned either.
+func (c *AWSClient) PlanSync(ctx context.Context, policies []policy.NetworkPolicy, sgID string) ([]RuleChange, error) {
+	live, err := c.ListRules(ctx, sgID)
+	if err != nil {
+		return nil, err
+	}
+	desired, _ := DeriveRules(policies, nil)
+
+	changes := PlanRules(desired, live)
+	for i := range changes {
+		changes[i].Provider = ProviderAWS
+		changes[i].Region = c.region
+		changes[i].SecurityGroup = sgID
+	}
+	return changes, nil
+}
+
+// ApplyChanges makes the creates, updates and revokes among changes to the
+// Security Group sgID, e.g. to also correct and remove the stale rules a
+// sync leaves in place
+func (c *AWSClient) ApplyChanges(ctx context.Context, sgID string, changes []RuleChange) error {
+	var descriptions, ingressDescriptions []types.SecurityGroupRuleDescription
+	var revoked, revokedIngress []string
+	for _, change := range changes {
+		switch change.Action {
+		case ActionCreate:
+			rule := SecurityGroupRule{Policies: change.Policies, Protocol: change.Protocol, Port: change.FromPort, EndPort: change.ToPort, CIDR: change.Peer, Ingress: change.Ingress}
+			if err := c.authorize(sgID, rule, change.Description); err != nil {
+				return err
+			}
+		case ActionUpdate:
+			description := types.SecurityGroupRuleDescription{
+				SecurityGroupRuleId: aws.String(change.RuleID),
+				Description:         aws.String(change.Description),
+			}
+			if change.Ingress {
+				ingressDescriptions = append(ingressDescriptions, description)
+			} else {
+				descriptions = append(descriptions, description)
+			}
+		case ActionRevoke:
+			if change.Ingress {
+				revokedIngress = append(revokedIngress, change.RuleID)
+			} else {
+				revoked = append(revoked, change.RuleID)
+			}
+		}
+	}
+
+	if len(descriptions) > 0 {
+		_, err := c.ec2API.UpdateSecurityGroupRuleDescriptionsEgress(ctx, &ec2.UpdateSecurityGroupRuleDescriptionsEgressInput{
+			GroupId:                       aws.String(sgID),
+			SecurityGroupRuleDescriptions: descriptions,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update rule descriptions: %w", err)
+		}
+		log.Printf("Updated %d rule descriptions in %s", len(descriptions), sgID)
+	}
+	if len(revoked) > 0 {
+		_, err := c.ec2API.RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{
+			GroupId:              aws.String(sgID),
+			SecurityGroupRuleIds: revoked,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to revoke egress rules: %w", err)
+		}
+		log.Printf("Revoked %d egress rules from %s", len(revoked), sgID)
+	}
+	if len(ingressDescriptions) > 0 {
+		_, err := c.ec2API.UpdateSecurityGroupRuleDescriptionsIngress(ctx, &ec2.UpdateSecurityGroupRuleDescriptionsIngressInput{
+			GroupId:                       aws.String(sgID),
+			SecurityGroupRuleDescriptions: ingressDescriptions,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update rule descriptions: %w", err)
+		}
+		log.Printf("Updated %d ingress rule descriptions in %s", len(ingressDescriptions), sgID)
+	}
+	if len(revokedIngress) > 0 {
+		_, err := c.ec2API.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:              aws.String(sgID),
+			SecurityGroupRuleIds: revokedIngress,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to revoke ingress rules: %w", err)
+		}
+		log.Printf("Revoked %d ingress rules from %s", len(revokedIngress), sgID)
+	}
+	return nil
+}
+
+// PlanSync plans the sync of policies to the Security Group of every target
+// that has one configured. Plans of reachable targets are returned
+// alongside an error naming the failed ones.
+func (m *MultiClient) PlanSync(ctx context.Context, policies []policy.NetworkPolicy) ([]RuleChange, error) {
+	var mu sync.Mutex
+	var changes []RuleChange
+
+	err := m.fanOut(ctx, m.syncTargets(), func(ctx context.Context, t *Target) error {
+		planned, err := t.client.PlanSync(ctx, policies, t.SecurityGroup)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, change := range planned {
+			change.Account = t.Account
+			changes = append(changes, change)
+		}
+		return err
+	})
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Account != changes[j].Account {
+			return changes[i].Account < changes[j].Account
+		}
+		return changes[i].Region < changes[j].Region
+	})
+	return changes, err
+}
+
+// ApplyChanges applies changes planned by PlanSync to the Security Group of
+// their account and region
+func (m *MultiClient) ApplyChanges(ctx context.Context, changes []RuleChange) error {
+	byTarget := make(map[*Target][]RuleChange)
+	var targets []*Target
+	for _, t := range m.syncTargets() {
+		for _, change := range changes {
+			if change.Account == t.Account && change.Region == t.Region && change.Changed() {
+				byTarget[t] = append(byTarget[t], change)
+			}
+		}
+		if len(byTarget[t]) > 0 {
+			targets = append(targets, t)
+		}
+	}
+
+	return m.fanOut(ctx, targets, func(ctx context.Context, t *Target) error {
+		return t.client.ApplyChanges(ctx, t.SecurityGroup, byTarget[t])
+	})
+}
@@ -0,0 +1,139 @@
+package cloud
+
+import (
+	"context"
+	"testing"
+
+	"ztap/pkg/policy"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestPlanRules(t *testing.T) {
+	https := SecurityGroupRule{Policies: []string{"allow-https"}, Protocol: "tcp", Port: 443, CIDR: "10.0.0.0/8"}
+	dns := SecurityGroupRule{Policies: []string{"allow-dns", "allow-resolvers"}, Protocol: "udp", Port: 53, CIDR: "8.8.8.8/32"}
+	db := SecurityGroupRule{Policies: []string{"allow-db"}, Protocol: "tcp", Port: 5432, CIDR: "10.1.0.0/16"}
+	ssh := SecurityGroupRule{Policies: []string{"bastion"}, Protocol: "tcp", Port: 22, CIDR: "10.9.0.0/16", Ingress: true}
+	web := SecurityGroupRule{Policies: []string{"web"}, Protocol: "tcp", Port: 8000, EndPort: 8080, CIDR: "10.2.0.0/16"}
+
+	live := []AppliedRule{
+		{ID: "sgr-https", Egress: true, Protocol: "tcp", FromPort: 443, ToPort: 443, CIDR: "10.0.0.0/8", Description: https.Description()},
+		{ID: "sgr-dns", Egress: true, Protocol: "udp", FromPort: 53, ToPort: 53, CIDR: "8.8.8.8/32", Description: "Managed by ZTAP"},
+		{ID: "sgr-ssh", Egress: true, Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: "0.0.0.0/0", Description: "Managed by ZTAP: allow-ssh"},
+		{ID: "sgr-all", Egress: true, Protocol: "-1", CIDR: "0.0.0.0/0"},
+		{ID: "sgr-in", Egress: false, Protocol: "tcp", FromPort: 443, ToPort: 443, CIDR: "0.0.0.0/0", Description: "Managed by ZTAP"},
+		{ID: "sgr-in-ssh", Egress: false, Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: "10.9.0.0/16", Description: ssh.Description()},
+		{ID: "sgr-in-lb", Egress: false, Protocol: "tcp", FromPort: 80, ToPort: 80, CIDR: "0.0.0.0/0"},
+		{ID: "sgr-web", Egress: true, Protocol: "tcp", FromPort: 8000, ToPort: 8080, CIDR: "10.2.0.0/16", Description: web.Description()},
+		{ID: "sgr-web-old", Egress: true, Protocol: "tcp", FromPort: 8000, ToPort: 8000, CIDR: "10.2.0.0/16", Description: web.Description()},
+	}
+
+	changes := PlanRules([]SecurityGroupRule{https, dns, db, ssh, web}, live)
+	actions := make(map[string]string)
+	for _, c := range changes {
+		actions[c.Rule()] = c.Action
+	}
+	expected := map[string]string{
+		"tcp/443 -> 10.0.0.0/8":   ActionKeep,
+		"udp/53 -> 8.8.8.8/32":    ActionUpdate,
+		"tcp/22 -> 0.0.0.0/0":     ActionRevoke,
+		"all -> 0.0.0.0/0":        ActionUnmanaged,
+		"tcp/5432 -> 10.1.0.0/16": ActionCreate,
+		// Managed ingress rules are planned too; unmanaged ones left out
+		"tcp/443 <- 0.0.0.0/0":  ActionRevoke,
+		"tcp/22 <- 10.9.0.0/16": ActionKeep,
+		// Port ranges are matched by their FromPort and ToPort
+		"tcp/8000-8080 -> 10.2.0.0/16": ActionKeep,
+		"tcp/8000 -> 10.2.0.0/16":      ActionRevoke,
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), changes)
+	}
+	for rule, action := range expected {
+		if actions[rule] != action {
+			t.Errorf("expected %s to %s, got %q", rule, action, actions[rule])
+		}
+	}
+
+	for _, c := range changes {
+		if c.Action == ActionUpdate && (c.RuleID != "sgr-dns" || c.Description != dns.Description()) {
+			t.Errorf("unexpected update: %+v", c)
+		}
+	}
+	if changes[0].Protocol != "-1" || changes[len(changes)-1].Protocol != "udp" {
+		t.Errorf("expected changes sorted by protocol, got %+v", changes)
+	}
+}
+
+func TestPlanSyncAndApply(t *testing.T) {
+	mock := &mockEC2Client{
+		describeRulesPages: []*ec2.DescribeSecurityGroupRulesOutput{{
+			SecurityGroupRules: []types.SecurityGroupRule{
+				{
+					SecurityGroupRuleId: aws.String("sgr-old"),
+					GroupId:             aws.String("sg-123"),
+					IsEgress:            aws.Bool(true),
+					IpProtocol:          aws.String("tcp"),
+					FromPort:            aws.Int32(22),
+					ToPort:              aws.Int32(22),
+					CidrIpv4:            aws.String("0.0.0.0/0"),
+					Description:         aws.String("Managed by ZTAP: allow-ssh"),
+				},
+			},
+		}},
+	}
+	client := &AWSClient{ec2API: mock, region: "us-east-1"}
+
+	policies, err := policy.Parse([]byte(`
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: allow-https
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/8
+      ports:
+        - protocol: TCP
+          port: 443
+`))
+	if err != nil {
+		t.Fatalf("failed to parse policies: %v", err)
+	}
+
+	changes, err := client.PlanSync(context.Background(), policies, "sg-123")
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %+v", changes)
+	}
+	for _, c := range changes {
+		if c.Provider != ProviderAWS || c.Region != "us-east-1" || c.SecurityGroup != "sg-123" {
+			t.Errorf("expected change located in aws us-east-1 sg-123, got %+v", c)
+		}
+	}
+	if len(mock.authorizeInputs) != 0 {
+		t.Fatal("expected planning to authorize nothing")
+	}
+
+	if err := client.ApplyChanges(context.Background(), "sg-123", changes); err != nil {
+		t.Fatalf("ApplyChanges failed: %v", err)
+	}
+	if len(mock.authorizeInputs) != 1 {
+		t.Fatalf("expected 1 authorize call, got %d", len(mock.authorizeInputs))
+	}
+	description := aws.ToString(mock.authorizeInputs[0].IpPermissions[0].IpRanges[0].Description)
+	if description != "Managed by ZTAP: allow-https" {
+		t.Errorf("unexpected description %q", description)
+	}
+	if mock.revokeInput == nil || len(mock.revokeInput.SecurityGroupRuleIds) != 1 || mock.revokeInput.SecurityGroupRuleIds[0] != "sgr-old" {
+		t.Errorf("expected sgr-old revoked, got %+v", mock.revokeInput)
+	}
+}
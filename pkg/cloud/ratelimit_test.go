@@ -0,0 +1,49 @@
+package cloud
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurstThenThrottle(t *testing.T) {
+	limiter := newRateLimiter(100, 2)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		limiter.Wait(ctx)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Millisecond {
+		t.Errorf("Burst calls should not wait, took %v", elapsed)
+	}
+
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		limiter.Wait(ctx)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected calls beyond the burst to be throttled, took %v", elapsed)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	limiter := newRateLimiter(1, 1)
+	limiter.Wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); err == nil {
+		t.Error("Expected context error while throttled")
+	}
+}
+
+func TestRateLimitedEC2(t *testing.T) {
+	mock := &mockEC2Client{describeInstancesOutput: instanceOutput("i-1")}
+	client := &AWSClient{ec2API: &rateLimitedEC2{api: mock, limiter: newRateLimiter(1000, 10)}}
+
+	resources, err := client.DiscoverResources()
+	if err != nil || len(resources) != 1 {
+		t.Fatalf("Expected calls to pass through the limiter, got %v, %v", resources, err)
+	}
+}
@@ -0,0 +1,170 @@
+package cloud
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+
+	"ztap/pkg/policy"
+)
+
+// SecurityGroupRule is a Security Group egress or ingress rule implied by
+// one or more policies. Rules are identified by direction, protocol, ports
+// and CIDR; policies that allow the same peer share a rule.
+type SecurityGroupRule struct {
+	Policies []string // Sorted names of the policies allowing this peer
+	Protocol string   // Lowercase, as AWS expects
+	Port     int
+	EndPort  int // Last port of a range; 0 for a single port
+	CIDR     string
+	Ingress  bool // Inbound from CIDR to Port, rather than outbound
+}
+
+// SkippedRule records a policy destination that could not become a rule
+type SkippedRule struct {
+	Policy string
+	Reason string
+}
+
+// maxDescriptionLength is the AWS limit for rule descriptions
+const maxDescriptionLength = 255
+
+// IPv6 reports whether the rule's peer is an IPv6 CIDR
+func (r SecurityGroupRule) IPv6() bool {
+	ip, _, err := net.ParseCIDR(r.CIDR)
+	return err == nil && ip.To4() == nil
+}
+
+// LastPort returns the last port of the rule, its ToPort in AWS: EndPort
+// for a range, Port otherwise
+func (r SecurityGroupRule) LastPort() int {
+	return max(r.Port, r.EndPort)
+}
+
+// Description returns the rule description recorded in AWS, listing every
+// policy that allows the peer. Lists too long for AWS are cut short
+// with a count of the remaining policies.
+func (r SecurityGroupRule) Description() string {
+	const prefix = "Managed by ZTAP: "
+	description := prefix + strings.Join(r.Policies, ", ")
+	for n := len(r.Policies) - 1; len(description) > maxDescriptionLength && n > 0; n-- {
+		description = fmt.Sprintf("%s%s (+%d more)", prefix, strings.Join(r.Policies[:n], ", "), len(r.Policies)-n)
+	}
+	return description
+}
+
+// Name returns a stable identifier for the rule made of lowercase letters,
+// digits and underscores, so repeated exports of the same policy set produce
+// the same resource names. The readable part loses punctuation, so a hash of
+// the raw protocol, ports and CIDR keeps distinct rules from colliding.
+// Ingress rules are named ztap_in_... Port ranges are written as 8000_8080,
+// so rules on a single port keep their names.
+func (r SecurityGroupRule) Name() string {
+	ports := policy.PortRange(r.Port, r.EndPort)
+	raw := fmt.Sprintf("ztap_%s_%s_%s", r.Protocol, ports, r.CIDR)
+	if r.Ingress {
+		raw = fmt.Sprintf("ztap_in_%s_%s_%s", r.Protocol, ports, r.CIDR)
+	}
+
+	var b strings.Builder
+	for _, c := range strings.ToLower(raw) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String() + "_" + r.hash()
+}
+
+// hash returns a short hex digest of the fields identifying the rule
+func (r SecurityGroupRule) hash() string {
+	key := fmt.Sprintf("%s\x00%s\x00%s", r.Protocol, policy.PortRange(r.Port, r.EndPort), r.CIDR)
+	if r.Ingress {
+		key += "\x00ingress"
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// DeriveRules converts policies into the Security Group egress and ingress
+// rules they imply, sorted by name. Policies are expanded with
+// policy.Compile: ipBlock peers map directly and podSelector peers are
+// resolved to host CIDRs through resolver. A peer that cannot be resolved (or
+// any podSelector when resolver is nil) is skipped without dropping the
+// policy's other peers. Rules for the same direction, protocol, ports and
+// CIDR are merged.
+func DeriveRules(policies []policy.NetworkPolicy, resolver policy.ServiceDiscovery) ([]SecurityGroupRule, []SkippedRule) {
+	compiler := policy.NewPolicyResolver(resolver)
+	byKey := make(map[string]*SecurityGroupRule)
+	var skipped []SkippedRule
+
+	for _, p := range policies {
+		add := func(compiled *policy.CompiledPolicy) {
+			for _, rule := range compiled.Rules {
+				key := SecurityGroupRule{Protocol: strings.ToLower(rule.Protocol), Port: rule.Port, EndPort: rule.EndPort, CIDR: rule.CIDR, Ingress: rule.Ingress()}
+				existing, ok := byKey[key.Name()]
+				if !ok {
+					existing = &key
+					byKey[key.Name()] = existing
+				}
+				if !slices.Contains(existing.Policies, p.Metadata.Name) {
+					existing.Policies = append(existing.Policies, p.Metadata.Name)
+				}
+			}
+		}
+		skip := func(field string, err error) {
+			if cause := errors.Unwrap(err); cause != nil {
+				err = cause
+			}
+			skipped = append(skipped, SkippedRule{
+				Policy: p.Metadata.Name,
+				Reason: fmt.Sprintf("%s: %v", field, err),
+			})
+		}
+
+		// Compile one rule at a time so a failed selector only skips its
+		// own peer
+		for i := range p.Spec.Egress {
+			single := p
+			single.Spec.Egress = p.Spec.Egress[i : i+1]
+			single.Spec.Ingress = nil
+
+			compiled, err := compiler.Compile(single)
+			if err != nil {
+				peer := "podSelector"
+				if p.Spec.Egress[i].To.Group != "" {
+					peer = "group"
+				}
+				skip(fmt.Sprintf("spec.egress[%d].to.%s", i, peer), err)
+				continue
+			}
+			add(compiled)
+		}
+		for i := range p.Spec.Ingress {
+			single := p
+			single.Spec.Egress = nil
+			single.Spec.Ingress = p.Spec.Ingress[i : i+1]
+
+			compiled, err := compiler.Compile(single)
+			if err != nil {
+				skip(fmt.Sprintf("spec.ingress[%d].from.podSelector", i), err)
+				continue
+			}
+			add(compiled)
+		}
+	}
+
+	rules := make([]SecurityGroupRule, 0, len(byKey))
+	for _, rule := range byKey {
+		sort.Strings(rule.Policies)
+		rules = append(rules, *rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name() < rules[j].Name() })
+	return rules, skipped
+}
@@ -0,0 +1,242 @@
+package cloud
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"ztap/pkg/policy"
+)
+
+const exportPolicies = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-egress
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/16
+      ports:
+        - protocol: TCP
+          port: 5432
+        - protocol: TCP
+          port: 443
+    - to:
+        ipBlock:
+          cidr: 2001:db8::/32
+      ports:
+        - protocol: UDP
+          port: 53
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+`
+
+type stubResolver map[string][]string
+
+func (s stubResolver) ResolveLabels(labels map[string]string) ([]string, error) {
+	ips, ok := s[labels["app"]]
+	if !ok {
+		return nil, fmt.Errorf("no services")
+	}
+	return ips, nil
+}
+
+func loadExportPolicies(t *testing.T) []policy.NetworkPolicy {
+	t.Helper()
+	policies, err := policy.Parse([]byte(exportPolicies))
+	if err != nil {
+		t.Fatalf("Failed to parse policies: %v", err)
+	}
+	return policies
+}
+
+func TestDeriveRules(t *testing.T) {
+	policies := loadExportPolicies(t)
+
+	rules, skipped := DeriveRules(policies, nil)
+	if len(rules) != 3 {
+		t.Fatalf("Expected 3 ipBlock rules, got %d: %+v", len(rules), rules)
+	}
+	if len(skipped) != 1 || skipped[0].Policy != "web-egress" {
+		t.Errorf("Expected podSelector destination to be skipped, got %+v", skipped)
+	}
+
+	// Rules are sorted by name so exports are stable
+	for i := 1; i < len(rules); i++ {
+		if rules[i-1].Name() >= rules[i].Name() {
+			t.Errorf("Rules not sorted: %s before %s", rules[i-1].Name(), rules[i].Name())
+		}
+	}
+
+	rules, skipped = DeriveRules(policies, stubResolver{"db": {"10.1.0.5", "10.1.0.6"}})
+	if len(rules) != 5 || len(skipped) != 0 {
+		t.Fatalf("Expected 5 rules with resolved endpoints, got %d rules, %d skipped", len(rules), len(skipped))
+	}
+
+	// Duplicate policies do not produce duplicate rules
+	rules, _ = DeriveRules(append(policies, policies...), nil)
+	if len(rules) != 3 {
+		t.Errorf("Expected duplicates to be removed, got %d rules", len(rules))
+	}
+}
+
+func TestDeriveRulesMergesPolicies(t *testing.T) {
+	policies := loadExportPolicies(t)
+	other := policies[0]
+	other.Metadata.Name = "api-egress"
+	other.Spec.Egress = other.Spec.Egress[:1]
+
+	rules, _ := DeriveRules(append(policies, other), nil)
+	if len(rules) != 3 {
+		t.Fatalf("Expected shared destinations to be merged, got %d rules", len(rules))
+	}
+	for _, rule := range rules {
+		if rule.IPv6() {
+			continue
+		}
+		if len(rule.Policies) != 2 || rule.Policies[0] != "api-egress" || rule.Policies[1] != "web-egress" {
+			t.Errorf("Expected both policies on %s, got %v", rule.Name(), rule.Policies)
+		}
+		if got, want := rule.Description(), "Managed by ZTAP: api-egress, web-egress"; got != want {
+			t.Errorf("Description() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSecurityGroupRuleName(t *testing.T) {
+	rule := SecurityGroupRule{Policies: []string{"web-egress"}, Protocol: "tcp", Port: 5432, CIDR: "10.0.0.0/16"}
+	if got := rule.Name(); !regexp.MustCompile(`^ztap_tcp_5432_10_0_0_0_16_[0-9a-f]{8}$`).MatchString(got) {
+		t.Errorf("Unexpected name %s", got)
+	}
+	if rule.IPv6() {
+		t.Error("Expected IPv4 rule")
+	}
+
+	// The name does not depend on the owning policies, only the destination
+	renamed := rule
+	renamed.Policies = []string{"web_egress"}
+	if rule.Name() != renamed.Name() {
+		t.Errorf("Expected name to ignore policies, got %s and %s", rule.Name(), renamed.Name())
+	}
+
+	// Destinations that sanitize to the same text still get distinct names
+	a := SecurityGroupRule{Protocol: "tcp", Port: 443, CIDR: "2001:db8::/32"}
+	b := SecurityGroupRule{Protocol: "tcp", Port: 443, CIDR: "2001:db8:0::/32"}
+	if a.Name() == b.Name() {
+		t.Errorf("Expected distinct names, both got %s", a.Name())
+	}
+
+	// Port ranges get their own names; single ports keep theirs
+	ranged := rule
+	ranged.EndPort = 5439
+	if got := ranged.Name(); !regexp.MustCompile(`^ztap_tcp_5432_5439_10_0_0_0_16_[0-9a-f]{8}$`).MatchString(got) || ranged.LastPort() != 5439 {
+		t.Errorf("Unexpected range name %s", got)
+	}
+	ranged.EndPort = 5432
+	if ranged.Name() != rule.Name() {
+		t.Errorf("Expected a one-port range named as its port, got %s", ranged.Name())
+	}
+
+	v6 := SecurityGroupRule{Policies: []string{"web-egress"}, Protocol: "udp", Port: 53, CIDR: "2001:db8::/32"}
+	if !v6.IPv6() {
+		t.Error("Expected IPv6 rule")
+	}
+}
+
+func TestSecurityGroupRuleDescriptionLimit(t *testing.T) {
+	var rule SecurityGroupRule
+	for i := 0; i < 40; i++ {
+		rule.Policies = append(rule.Policies, fmt.Sprintf("policy-number-%02d", i))
+	}
+	description := rule.Description()
+	if len(description) > maxDescriptionLength {
+		t.Errorf("Description is %d characters, limit is %d", len(description), maxDescriptionLength)
+	}
+	if !strings.HasSuffix(description, " more)") {
+		t.Errorf("Expected truncated description to count remaining policies, got %q", description)
+	}
+}
+
+func TestDeriveRulesIngress(t *testing.T) {
+	policies, err := policy.Parse([]byte(`
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: db-ingress
+spec:
+  podSelector:
+    matchLabels:
+      app: db
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.9.0.0/16
+      ports:
+        - protocol: TCP
+          port: 22
+  ingress:
+    - from:
+        ipBlock:
+          cidr: 10.9.0.0/16
+      ports:
+        - protocol: TCP
+          port: 22
+    - from:
+        podSelector:
+          matchLabels:
+            app: web
+      ports:
+        - protocol: TCP
+          port: 5432
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse policies: %v", err)
+	}
+
+	// The same peer and port in both directions are distinct rules, ingress
+	// first by name
+	rules, skipped := DeriveRules(policies, nil)
+	if len(rules) != 2 || !rules[0].Ingress || rules[1].Ingress {
+		t.Fatalf("Expected an egress and an ingress rule, got %+v", rules)
+	}
+	if len(skipped) != 1 || !strings.Contains(skipped[0].Reason, "spec.ingress[1].from.podSelector") {
+		t.Errorf("Expected the podSelector source skipped, got %+v", skipped)
+	}
+	if name := rules[0].Name(); !strings.HasPrefix(name, "ztap_in_tcp_22_") {
+		t.Errorf("Unexpected ingress rule name %s", name)
+	}
+
+	mock := &mockEC2Client{}
+	client := &AWSClient{ec2API: mock, region: "us-east-1"}
+	if err := client.SyncPolicy(policies[0], "sg-123"); err != nil {
+		t.Fatalf("SyncPolicy returned error: %v", err)
+	}
+	if len(mock.authorizeInputs) != 1 || len(mock.authorizeIngressInputs) != 1 {
+		t.Fatalf("Expected one egress and one ingress authorization, got %d and %d", len(mock.authorizeInputs), len(mock.authorizeIngressInputs))
+	}
+	permission := mock.authorizeIngressInputs[0].IpPermissions[0]
+	if *permission.FromPort != 22 || *permission.IpRanges[0].CidrIp != "10.9.0.0/16" {
+		t.Errorf("Unexpected ingress permission %+v", permission)
+	}
+
+	var out strings.Builder
+	if err := WriteTerraform(&out, rules, skipped, TerraformOptions{SecurityGroupID: "sg-123", Import: true}); err != nil {
+		t.Fatalf("WriteTerraform failed: %v", err)
+	}
+	for _, want := range []string{`  type              = "ingress"`, `  id = "sg-123_ingress_tcp_22_22_10.9.0.0/16"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Output missing %q:\n%s", want, out.String())
+		}
+	}
+}
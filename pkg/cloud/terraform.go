@@ -0,0 +1,86 @@
+package cloud
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// TerraformOptions controls Terraform export
+type TerraformOptions struct {
+	// SecurityGroupID is the default for var.security_group_id; required for imports
+	SecurityGroupID string
+
+	// Import emits import blocks (Terraform 1.5+) so rules ZTAP already
+	// synced are adopted into state instead of recreated
+	Import bool
+}
+
+// WriteTerraform renders rules as HCL aws_security_group_rule resources
+// attached to var.security_group_id. Skipped destinations are listed as
+// comments so reviewers can see what the export does not cover.
+func WriteTerraform(w io.Writer, rules []SecurityGroupRule, skipped []SkippedRule, opts TerraformOptions) error {
+	if opts.Import && opts.SecurityGroupID == "" {
+		return fmt.Errorf("import blocks require a security group ID")
+	}
+
+	fmt.Fprintln(w, "# Generated by ztap cloud export. Re-export after policy changes instead of editing.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, `variable "security_group_id" {`)
+	fmt.Fprintln(w, `  description = "Security Group the ZTAP rules are attached to"`)
+	fmt.Fprintln(w, `  type        = string`)
+	if opts.SecurityGroupID != "" {
+		fmt.Fprintf(w, "  default     = %s\n", strconv.Quote(opts.SecurityGroupID))
+	}
+	fmt.Fprintln(w, "}")
+
+	if len(skipped) > 0 {
+		fmt.Fprintln(w)
+		for _, s := range skipped {
+			fmt.Fprintf(w, "# Skipped %s: %s\n", s.Policy, s.Reason)
+		}
+	}
+
+	for _, r := range rules {
+		cidrAttr := "cidr_blocks"
+		if r.IPv6() {
+			cidrAttr = "ipv6_cidr_blocks"
+		}
+
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "resource \"aws_security_group_rule\" %s {\n", strconv.Quote(r.Name()))
+		fmt.Fprintf(w, "  type              = %s\n", strconv.Quote(terraformType(r)))
+		fmt.Fprintln(w, `  security_group_id = var.security_group_id`)
+		fmt.Fprintf(w, "  protocol          = %s\n", strconv.Quote(r.Protocol))
+		fmt.Fprintf(w, "  from_port         = %d\n", r.Port)
+		fmt.Fprintf(w, "  to_port           = %d\n", r.LastPort())
+		fmt.Fprintf(w, "  %-17s = [%s]\n", cidrAttr, strconv.Quote(r.CIDR))
+		fmt.Fprintf(w, "  description       = %s\n", strconv.Quote(r.Description()))
+		fmt.Fprintln(w, "}")
+	}
+
+	if opts.Import {
+		for _, r := range rules {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "import {")
+			fmt.Fprintf(w, "  to = aws_security_group_rule.%s\n", r.Name())
+			fmt.Fprintf(w, "  id = %s\n", strconv.Quote(terraformImportID(opts.SecurityGroupID, r)))
+			fmt.Fprintln(w, "}")
+		}
+	}
+	return nil
+}
+
+// terraformType returns the aws_security_group_rule type of r
+func terraformType(r SecurityGroupRule) string {
+	if r.Ingress {
+		return "ingress"
+	}
+	return "egress"
+}
+
+// terraformImportID returns the aws_security_group_rule import ID:
+// SGID_TYPE_PROTOCOL_FROMPORT_TOPORT_SOURCE
+func terraformImportID(sgID string, r SecurityGroupRule) string {
+	return fmt.Sprintf("%s_%s_%s_%d_%d_%s", sgID, terraformType(r), r.Protocol, r.Port, r.LastPort(), r.CIDR)
+}
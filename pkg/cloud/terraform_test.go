@@ -0,0 +1,73 @@
+package cloud
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTerraform(t *testing.T) {
+	rules, skipped := DeriveRules(loadExportPolicies(t), nil)
+
+	var out bytes.Buffer
+	err := WriteTerraform(&out, rules, skipped, TerraformOptions{SecurityGroupID: "sg-123", Import: true})
+	if err != nil {
+		t.Fatalf("WriteTerraform failed: %v", err)
+	}
+	hcl := out.String()
+
+	for _, want := range []string{
+		`default     = "sg-123"`,
+		`# Skipped web-egress: spec.egress[2].to.podSelector: no service discovery backend configured`,
+		`resource "aws_security_group_rule" "ztap_tcp_5432_10_0_0_0_16_2009c37a" {`,
+		`  cidr_blocks       = ["10.0.0.0/16"]`,
+		`  ipv6_cidr_blocks  = ["2001:db8::/32"]`,
+		`  description       = "Managed by ZTAP: web-egress"`,
+		`  to = aws_security_group_rule.ztap_tcp_5432_10_0_0_0_16_2009c37a`,
+		`  id = "sg-123_egress_tcp_5432_5432_10.0.0.0/16"`,
+	} {
+		if !strings.Contains(hcl, want) {
+			t.Errorf("Output missing %q:\n%s", want, hcl)
+		}
+	}
+
+	if got := strings.Count(hcl, `resource "aws_security_group_rule"`); got != len(rules) {
+		t.Errorf("Expected %d resources, got %d", len(rules), got)
+	}
+
+	// Export is deterministic
+	var again bytes.Buffer
+	WriteTerraform(&again, rules, skipped, TerraformOptions{SecurityGroupID: "sg-123", Import: true})
+	if again.String() != hcl {
+		t.Error("Repeated export produced different output")
+	}
+
+	// Port ranges become from_port and to_port
+	var ranged bytes.Buffer
+	rule := SecurityGroupRule{Policies: []string{"web"}, Protocol: "tcp", Port: 8000, EndPort: 8080, CIDR: "10.2.0.0/16"}
+	if err := WriteTerraform(&ranged, []SecurityGroupRule{rule}, nil, TerraformOptions{SecurityGroupID: "sg-123", Import: true}); err != nil {
+		t.Fatalf("WriteTerraform failed: %v", err)
+	}
+	for _, want := range []string{"  from_port         = 8000\n", "  to_port           = 8080\n", `id = "sg-123_egress_tcp_8000_8080_10.2.0.0/16"`} {
+		if !strings.Contains(ranged.String(), want) {
+			t.Errorf("Output missing %q:\n%s", want, ranged.String())
+		}
+	}
+}
+
+func TestWriteTerraformImportRequiresGroup(t *testing.T) {
+	rules, _ := DeriveRules(loadExportPolicies(t), nil)
+
+	var out bytes.Buffer
+	if err := WriteTerraform(&out, rules, nil, TerraformOptions{Import: true}); err == nil {
+		t.Error("Expected error for import without security group ID")
+	}
+
+	out.Reset()
+	if err := WriteTerraform(&out, rules, nil, TerraformOptions{}); err != nil {
+		t.Fatalf("WriteTerraform failed: %v", err)
+	}
+	if strings.Contains(out.String(), "default") || strings.Contains(out.String(), "import {") {
+		t.Errorf("Expected no default or import blocks:\n%s", out.String())
+	}
+}
@@ -0,0 +1,323 @@
+package cluster
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// NodeCertTTL is how long node certificates issued at enrollment are valid
+const NodeCertTTL = 365 * 24 * time.Hour
+
+const (
+	caValidity    = 10 * 365 * 24 * time.Hour
+	certClockSkew = 5 * time.Minute // NotBefore is backdated by this much
+)
+
+// CA is the cluster certificate authority, which issues the client
+// certificates enrolled nodes authenticate to the API server with
+type CA struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+	retired []*x509.Certificate // Replaced by RotateCA, still trusted during their overlap
+}
+
+// retiredUntilHeader is the PEM header of ca-retired.crt holding when a
+// retired CA certificate stops being trusted
+const retiredUntilHeader = "Retired-Until"
+
+// LoadOrCreateCA loads the CA from ca.crt and ca.key in dir, creating a
+// self-signed ECDSA P-256 CA there if neither exists. Certificates retired
+// by RotateCA less than their overlap ago, kept in ca-retired.crt, are
+// trusted too.
+func LoadOrCreateCA(dir string) (*CA, error) {
+	ca, err := loadOrCreateCA(dir)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := readRetiredCAs(dir, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retired cluster CA certificate: %w", err)
+		}
+		ca.retired = append(ca.retired, cert)
+	}
+	return ca, nil
+}
+
+func loadOrCreateCA(dir string) (*CA, error) {
+	certPath, keyPath := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	certPEM, certErr := os.ReadFile(certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		return createCA(certPath, keyPath)
+	}
+	if err := errors.Join(certErr, keyErr); err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster CA certificate %s: %w", certPath, err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("invalid cluster CA key %s: no PEM block", keyPath)
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cluster CA key %s: %w", keyPath, err)
+	}
+	return &CA{cert: cert, key: key, certPEM: certPEM}, nil
+}
+
+// createCA generates a CA and writes it to certPath and keyPath
+func createCA(certPath, keyPath string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate cluster CA key: %w", err)
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "ZTAP Cluster CA"},
+		NotBefore:             now.Add(-certClockSkew),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cluster CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cluster CA directory: %w", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write cluster CA key: %w", err)
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write cluster CA certificate: %w", err)
+	}
+	return &CA{cert: cert, key: key, certPEM: certPEM}, nil
+}
+
+// RotateCA replaces the CA in dir with a new one, which issues all further
+// node certificates. The previous certificate moves to ca-retired.crt and
+// still verifies the certificates it issued for overlap, giving nodes time
+// to enroll again; certificates retired longer ago are dropped. It returns
+// the new CA and the fingerprints of the previous and dropped certificates.
+func RotateCA(dir string, now time.Time, overlap time.Duration) (*CA, string, []string, error) {
+	certPath := filepath.Join(dir, "ca.crt")
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	previous, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("invalid cluster CA certificate %s: %w", certPath, err)
+	}
+
+	all, err := readRetiredCAs(dir, time.Time{})
+	if err != nil {
+		return nil, "", nil, err
+	}
+	var bundle []byte
+	var dropped []string
+	for _, block := range all {
+		until, _ := time.Parse(time.RFC3339, block.Headers[retiredUntilHeader])
+		if !until.After(now) {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				dropped = append(dropped, fingerprint(cert))
+			}
+			continue
+		}
+		bundle = append(bundle, pem.EncodeToMemory(block)...)
+	}
+	bundle = append(bundle, pem.EncodeToMemory(&pem.Block{
+		Type:    "CERTIFICATE",
+		Headers: map[string]string{retiredUntilHeader: now.Add(overlap).UTC().Format(time.RFC3339)},
+		Bytes:   previous.Raw,
+	})...)
+	if err := os.WriteFile(filepath.Join(dir, "ca-retired.crt"), bundle, 0644); err != nil {
+		return nil, "", nil, fmt.Errorf("failed to write retired cluster CA certificates: %w", err)
+	}
+
+	if _, err := createCA(certPath, filepath.Join(dir, "ca.key")); err != nil {
+		return nil, "", nil, err
+	}
+	ca, err := LoadOrCreateCA(dir)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return ca, fingerprint(previous), dropped, nil
+}
+
+// readRetiredCAs returns the PEM blocks of ca-retired.crt in dir that are
+// still trusted at now, or all of them if now is zero
+func readRetiredCAs(dir string, now time.Time) ([]*pem.Block, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "ca-retired.crt"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read retired cluster CA certificates: %w", err)
+	}
+	var blocks []*pem.Block
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return blocks, nil
+		}
+		until, err := time.Parse(time.RFC3339, block.Headers[retiredUntilHeader])
+		if block.Type != "CERTIFICATE" || err != nil {
+			return nil, fmt.Errorf("invalid retired cluster CA certificate: missing %s", retiredUntilHeader)
+		}
+		if now.IsZero() || until.After(now) {
+			blocks = append(blocks, block)
+		}
+	}
+}
+
+// CertPEM returns the CA certificate, PEM encoded
+func (ca *CA) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// Fingerprint identifies the CA certificate: the first 8 bytes of its
+// SHA-256 hash, hex encoded
+func (ca *CA) Fingerprint() string {
+	return fingerprint(ca.cert)
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Pool returns a pool holding the CA certificate and the retired ones still
+// trusted, to verify node certificates with
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	for _, cert := range ca.retired {
+		pool.AddCert(cert)
+	}
+	return pool
+}
+
+// ParseNodeCSR parses a PEM encoded certificate request of node and checks
+// its signature and that its common name is the node ID
+func ParseNodeCSR(csrPEM []byte, node string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("invalid certificate request: no CERTIFICATE REQUEST PEM block")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request signature: %w", err)
+	}
+	if csr.Subject.CommonName != node {
+		return nil, fmt.Errorf("certificate request is for %q, not node %s", csr.Subject.CommonName, node)
+	}
+	return csr, nil
+}
+
+// IssueNodeCertificate signs a client certificate for the key of csr, with
+// the node ID (the request's common name) as its subject. Only the key is
+// taken from the request.
+func (ca *CA) IssueNodeCertificate(csr *x509.CertificateRequest, validity time.Duration) ([]byte, time.Time, error) {
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		NotBefore:    now.Add(-certClockSkew),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to issue node certificate: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), template.NotAfter, nil
+}
+
+// NewNodeKey generates the private key of node and a certificate request
+// for it, both PEM encoded
+func NewNodeKey(node string) (keyPEM, csrPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate node key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: node},
+	}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), nil
+}
+
+// parseCertificatePEM parses the first certificate of a PEM file
+func parseCertificatePEM(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no CERTIFICATE PEM block")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// randomSerial returns a random 128-bit certificate serial number
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate serial: %w", err)
+	}
+	return serial, nil
+}
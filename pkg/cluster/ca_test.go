@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestCAIssuesNodeCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA failed: %v", err)
+	}
+	reloaded, err := LoadOrCreateCA(dir)
+	if err != nil || !bytes.Equal(reloaded.CertPEM(), ca.CertPEM()) {
+		t.Fatalf("Expected the stored CA to be loaded again (%v)", err)
+	}
+
+	_, csrPEM, err := NewNodeKey("web-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseNodeCSR(csrPEM, "db-1"); err == nil {
+		t.Error("Expected error for a request naming another node")
+	}
+	if _, err := ParseNodeCSR([]byte("garbage"), "web-1"); err == nil {
+		t.Error("Expected error for a malformed request")
+	}
+	csr, err := ParseNodeCSR(csrPEM, "web-1")
+	if err != nil {
+		t.Fatalf("ParseNodeCSR failed: %v", err)
+	}
+
+	certPEM, expiresAt, err := reloaded.IssueNodeCertificate(csr, time.Hour)
+	if err != nil {
+		t.Fatalf("IssueNodeCertificate failed: %v", err)
+	}
+	cert, err := parseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "web-1" || !cert.NotAfter.Equal(expiresAt.Truncate(time.Second)) {
+		t.Errorf("Unexpected certificate for %s until %s", cert.Subject.CommonName, cert.NotAfter)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{Roots: ca.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Errorf("Expected a client certificate chaining to the CA: %v", err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: ca.Pool()}); err == nil {
+		t.Error("Expected the certificate not to be valid for servers")
+	}
+}
+
+func TestRotateCA(t *testing.T) {
+	dir := t.TempDir()
+	old, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA failed: %v", err)
+	}
+	_, csrPEM, _ := NewNodeKey("web-1")
+	csr, _ := ParseNodeCSR(csrPEM, "web-1")
+	certPEM, _, err := old.IssueNodeCertificate(csr, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := parseCertificatePEM(certPEM)
+	verifies := func(ca *CA) bool {
+		_, err := cert.Verify(x509.VerifyOptions{Roots: ca.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+		return err == nil
+	}
+
+	// The previous CA's certificates are trusted during the overlap
+	now := time.Now()
+	ca, previous, dropped, err := RotateCA(dir, now, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateCA failed: %v", err)
+	}
+	if previous != old.Fingerprint() || ca.Fingerprint() == previous || len(dropped) != 0 {
+		t.Errorf("Expected a new CA replacing %s, got %s replacing %s (dropped %v)", old.Fingerprint(), ca.Fingerprint(), previous, dropped)
+	}
+	if !verifies(ca) {
+		t.Error("Expected the previous CA's node certificate to verify during the overlap")
+	}
+
+	// After it, the next rotation drops it
+	_, _, dropped, err = RotateCA(dir, now.Add(2*time.Hour), 0)
+	if err != nil {
+		t.Fatalf("RotateCA failed: %v", err)
+	}
+	if len(dropped) != 1 || dropped[0] != previous {
+		t.Errorf("Expected %s to be dropped, got %v", previous, dropped)
+	}
+	reloaded, err := LoadOrCreateCA(dir)
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA failed: %v", err)
+	}
+	if verifies(reloaded) {
+		t.Error("Expected the dropped CA's node certificate not to verify")
+	}
+}
@@ -0,0 +1,426 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ztap/pkg/metrics"
+)
+
+// Well-known cluster configuration keys
+const (
+	ConfigDefaultDeny       = "default-deny"   // Deny traffic not matched by any policy (true/false)
+	ConfigLogLevel          = "log-level"      // debug, info, warn, or error
+	ConfigFeaturePrefix     = "feature."       // Feature flags, e.g. feature.ebpf-batch=true
+	ConfigConfirmShrink     = "confirm-shrink" // Comma-separated policies whose held endpoint shrink is accepted
+	ConfigBreakGlass        = "break-glass"    // RFC 3339 time until which enforcement is suspended
+	ConfigMaintenancePrefix = "maintenance."   // Maintenance windows, e.g. maintenance.db-upgrade=<start>/<end> app=db
+	ConfigCordonPrefix      = "cordon."        // Cordoned nodes, e.g. cordon.web-1=true
+	ConfigDrainPrefix       = "drain."         // Drained nodes and their DrainMode, e.g. drain.web-1=monitor
+)
+
+// ConfigEntry is a versioned cluster configuration value
+type ConfigEntry struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	Version   int64     `json:"version"`    // Store revision at which the entry last changed
+	UpdatedBy string    `json:"updated_by"` // Node or user that made the change
+	UpdatedAt time.Time `json:"updated_at"`
+	Deleted   bool      `json:"deleted,omitempty"` // Set on watch notifications for removed keys
+}
+
+// ConfigStore is a replicated key-value store for cluster-level settings.
+type ConfigStore interface {
+	// Get returns the entry for key, if set.
+	Get(key string) (ConfigEntry, bool)
+
+	// Set validates and stores a value, returning the new entry.
+	Set(key, value, updatedBy string) (ConfigEntry, error)
+
+	// Delete removes a key.
+	Delete(key, updatedBy string) error
+
+	// List returns all entries sorted by key.
+	List() []ConfigEntry
+
+	// Watch returns a channel that receives every change. A slow consumer loses
+	// the oldest pending changes, visible as a gap in Version.
+	// The channel is closed when the context is cancelled.
+	Watch(ctx context.Context) <-chan ConfigEntry
+}
+
+// ValidateConfig checks that value is acceptable for key
+func ValidateConfig(key, value string) error {
+	switch {
+	case key == ConfigDefaultDeny:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be true or false", key)
+		}
+	case key == ConfigLogLevel:
+		switch value {
+		case "debug", "info", "warn", "error":
+		default:
+			return fmt.Errorf("%s must be debug, info, warn, or error", key)
+		}
+	case key == ConfigConfirmShrink:
+		for _, name := range strings.Split(value, ",") {
+			if strings.TrimSpace(name) == "" {
+				return fmt.Errorf("%s must be a comma-separated list of policy names", key)
+			}
+		}
+	case key == ConfigBreakGlass:
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("%s must be an RFC 3339 time", key)
+		}
+	case strings.HasPrefix(key, ConfigMaintenancePrefix):
+		if key == ConfigMaintenancePrefix {
+			return fmt.Errorf("maintenance window name cannot be empty")
+		}
+		if _, err := ParseMaintenanceWindow(strings.TrimPrefix(key, ConfigMaintenancePrefix), value); err != nil {
+			return err
+		}
+	case strings.HasPrefix(key, ConfigCordonPrefix):
+		if key == ConfigCordonPrefix {
+			return fmt.Errorf("cordoned node ID cannot be empty")
+		}
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s must be true or false", key)
+		}
+	case strings.HasPrefix(key, ConfigDrainPrefix):
+		if key == ConfigDrainPrefix {
+			return fmt.Errorf("drained node ID cannot be empty")
+		}
+		if _, err := ParseDrainMode(value); err != nil {
+			return err
+		}
+	case strings.HasPrefix(key, ConfigFeaturePrefix):
+		if key == ConfigFeaturePrefix {
+			return fmt.Errorf("feature flag name cannot be empty")
+		}
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("feature flag %s must be true or false", key)
+		}
+	default:
+		return fmt.Errorf("unknown config key %q (expected %s, %s, %s, %s, %s<name>, %s<name>, %s<node-id>, or %s<node-id>)",
+			key, ConfigDefaultDeny, ConfigLogLevel, ConfigConfirmShrink, ConfigBreakGlass, ConfigFeaturePrefix, ConfigMaintenancePrefix,
+			ConfigCordonPrefix, ConfigDrainPrefix)
+	}
+	return nil
+}
+
+// LocalConfigStore is a single-node ConfigStore, optionally persisted to a
+// JSON file. It is NOT replicated; use an etcd or Raft backed store for
+// production multi-node deployments.
+type LocalConfigStore struct {
+	path     string
+	mu       sync.RWMutex
+	entries  map[string]ConfigEntry
+	revision int64
+	watchers []chan ConfigEntry
+	dropped  uint64
+}
+
+// localConfigFile is the on-disk format of LocalConfigStore
+type localConfigFile struct {
+	Revision int64                  `json:"revision"`
+	Entries  map[string]ConfigEntry `json:"entries"`
+}
+
+// NewLocalConfigStore creates a config store persisted at path. An empty path
+// keeps the store in memory only.
+func NewLocalConfigStore(path string) (*LocalConfigStore, error) {
+	s := &LocalConfigStore{
+		path:    path,
+		entries: make(map[string]ConfigEntry),
+	}
+
+	if path != "" {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Get returns the entry for key, if set.
+func (s *LocalConfigStore) Get(key string) (ConfigEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[key]
+	return entry, ok
+}
+
+// Set validates and stores a value, returning the new entry.
+func (s *LocalConfigStore) Set(key, value, updatedBy string) (ConfigEntry, error) {
+	if err := ValidateConfig(key, value); err != nil {
+		return ConfigEntry{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.entries[key]; ok && current.Value == value {
+		return current, nil
+	}
+
+	previous, existed := s.entries[key]
+	s.revision++
+	entry := ConfigEntry{
+		Key:       key,
+		Value:     value,
+		Version:   s.revision,
+		UpdatedBy: updatedBy,
+		UpdatedAt: time.Now(),
+	}
+	s.entries[key] = entry
+
+	if err := s.save(); err != nil {
+		// Roll back so memory never runs ahead of what was persisted
+		s.revision--
+		if existed {
+			s.entries[key] = previous
+		} else {
+			delete(s.entries, key)
+		}
+		return ConfigEntry{}, err
+	}
+	s.broadcast(entry)
+
+	return entry, nil
+}
+
+// Delete removes a key.
+func (s *LocalConfigStore) Delete(key, updatedBy string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return fmt.Errorf("config key %q not set", key)
+	}
+
+	s.revision++
+	delete(s.entries, key)
+
+	if err := s.save(); err != nil {
+		s.revision--
+		s.entries[key] = entry
+		return err
+	}
+
+	entry.Value = ""
+	entry.Version = s.revision
+	entry.UpdatedBy = updatedBy
+	entry.UpdatedAt = time.Now()
+	entry.Deleted = true
+	s.broadcast(entry)
+
+	return nil
+}
+
+// List returns all entries sorted by key.
+func (s *LocalConfigStore) List() []ConfigEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]ConfigEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+// Watch returns a channel that receives every change.
+func (s *LocalConfigStore) Watch(ctx context.Context) <-chan ConfigEntry {
+	ch := make(chan ConfigEntry, watchBufferSize)
+
+	s.mu.Lock()
+	s.watchers = append(s.watchers, ch)
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if removeWatcher(&s.watchers, ch) {
+			close(ch)
+		}
+	}()
+
+	return ch
+}
+
+// broadcast sends a change to all watchers (requires holding mu lock).
+func (s *LocalConfigStore) broadcast(entry ConfigEntry) {
+	for _, ch := range s.watchers {
+		if offerLatest(ch, entry) {
+			s.dropped++
+			metrics.GetCollector().IncWatchDropped("cluster_config")
+		}
+	}
+}
+
+// Reload re-reads the store from disk and notifies watchers of changes made
+// by other processes, such as 'ztap cluster config set'. Several changes made
+// between reloads are delivered as their end state, visible as a gap in
+// Version.
+func (s *LocalConfigStore) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+
+	file, err := s.readFile()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if file.Revision == s.revision {
+		return nil
+	}
+
+	var changes []ConfigEntry
+	for key, entry := range file.Entries {
+		if current, ok := s.entries[key]; !ok || current.Version != entry.Version {
+			changes = append(changes, entry)
+		}
+	}
+	for key, entry := range s.entries {
+		if _, ok := file.Entries[key]; !ok {
+			entry.Value = ""
+			entry.Version = file.Revision
+			entry.UpdatedAt = time.Now()
+			entry.Deleted = true
+			changes = append(changes, entry)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Version < changes[j].Version })
+
+	s.entries = file.Entries
+	s.revision = file.Revision
+	for _, entry := range changes {
+		s.broadcast(entry)
+	}
+	return nil
+}
+
+// load reads the store from disk; a missing file is an empty store
+func (s *LocalConfigStore) load() error {
+	file, err := s.readFile()
+	if err != nil {
+		return err
+	}
+	s.entries = file.Entries
+	s.revision = file.Revision
+	return nil
+}
+
+// readFile parses the on-disk store; a missing file is an empty store
+func (s *LocalConfigStore) readFile() (localConfigFile, error) {
+	file := localConfigFile{Entries: make(map[string]ConfigEntry)}
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return file, nil
+	}
+	if err != nil {
+		return file, fmt.Errorf("failed to read config store: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("failed to parse config store %s: %w", s.path, err)
+	}
+	if file.Entries == nil {
+		file.Entries = make(map[string]ConfigEntry)
+	}
+	return file, nil
+}
+
+// save writes the store to disk (requires holding mu lock)
+func (s *LocalConfigStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(localConfigFile{Revision: s.revision, Entries: s.entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+// ApplyConfig calls apply for every current entry and then for each change
+// until ctx is cancelled, so a node converges on the cluster configuration
+// without restarting. Changes made while the snapshot is applied are not lost.
+// A gap in Version means the watch dropped changes, so the store is re-listed
+// and only the differences are applied; keys removed in the gap are applied as
+// deleted entries.
+func ApplyConfig(ctx context.Context, store ConfigStore, apply func(entry ConfigEntry)) {
+	changes := store.Watch(ctx)
+
+	var applied int64
+	known := make(map[string]int64) // Applied version per live key
+
+	resync := func() {
+		current := store.List()
+		live := make(map[string]bool, len(current))
+		for _, entry := range current {
+			live[entry.Key] = true
+			if known[entry.Key] != entry.Version {
+				apply(entry)
+				known[entry.Key] = entry.Version
+			}
+			applied = max(applied, entry.Version)
+		}
+
+		var removed []string
+		for key := range known {
+			if !live[key] {
+				removed = append(removed, key)
+			}
+		}
+		sort.Strings(removed)
+		for _, key := range removed {
+			delete(known, key)
+			apply(ConfigEntry{Key: key, Version: applied, Deleted: true})
+		}
+	}
+
+	resync()
+	for entry := range changes {
+		// Skip changes already reflected in the snapshot
+		if entry.Version <= applied {
+			continue
+		}
+		if entry.Version > applied+1 {
+			// The listing is taken after entry was committed, so it covers it
+			resync()
+			applied = max(applied, entry.Version)
+			continue
+		}
+
+		apply(entry)
+		applied = entry.Version
+		if entry.Deleted {
+			delete(known, entry.Key)
+		} else {
+			known[entry.Key] = entry.Version
+		}
+	}
+}
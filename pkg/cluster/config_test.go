@@ -0,0 +1,308 @@
+package cluster
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		key, value string
+		valid      bool
+	}{
+		{ConfigDefaultDeny, "true", true},
+		{ConfigDefaultDeny, "yes", false},
+		{ConfigLogLevel, "warn", true},
+		{ConfigLogLevel, "verbose", false},
+		{"feature.ebpf-batch", "false", true},
+		{"feature.ebpf-batch", "on", false},
+		{"feature.", "true", false},
+		{ConfigConfirmShrink, "web-to-db,api", true},
+		{ConfigConfirmShrink, "web-to-db,", false},
+		{ConfigBreakGlass, "2025-10-31T18:00:00Z", true},
+		{ConfigBreakGlass, "30m", false},
+		{"maintenance.db-upgrade", "2025-10-31T22:00:00Z/2025-11-01T02:00:00Z", true},
+		{"maintenance.db-upgrade", "2025-10-31T22:00:00Z/2025-11-01T02:00:00Z app=db,env=prod", true},
+		{"maintenance.db-upgrade", "2025-10-31T22:00:00Z/2025-10-31T20:00:00Z", false},
+		{"maintenance.db-upgrade", "2025-10-31T22:00:00Z/2025-11-01T02:00:00Z app", false},
+		{"maintenance.", "2025-10-31T22:00:00Z/2025-11-01T02:00:00Z", false},
+		{"unknown", "x", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateConfig(tt.key, tt.value)
+		if tt.valid && err != nil {
+			t.Errorf("%s=%s: unexpected error: %v", tt.key, tt.value, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s=%s: expected validation error", tt.key, tt.value)
+		}
+	}
+}
+
+func TestLocalConfigStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cluster-config.json")
+	store, err := NewLocalConfigStore(path)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	entry, err := store.Set(ConfigLogLevel, "debug", "node-1")
+	if err != nil {
+		t.Fatalf("failed to set: %v", err)
+	}
+	if entry.Version != 1 || entry.UpdatedBy != "node-1" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+
+	// Setting the same value does not bump the version
+	if same, _ := store.Set(ConfigLogLevel, "debug", "node-2"); same.Version != 1 {
+		t.Errorf("expected unchanged version 1, got %d", same.Version)
+	}
+
+	if _, err := store.Set(ConfigDefaultDeny, "true", "node-1"); err != nil {
+		t.Fatalf("failed to set: %v", err)
+	}
+	if _, err := store.Set(ConfigLogLevel, "loud", "node-1"); err == nil {
+		t.Error("expected validation error")
+	}
+
+	// A new store at the same path sees persisted state
+	reloaded, err := NewLocalConfigStore(path)
+	if err != nil {
+		t.Fatalf("failed to reload store: %v", err)
+	}
+	if got, ok := reloaded.Get(ConfigLogLevel); !ok || got.Value != "debug" {
+		t.Errorf("expected persisted log-level=debug, got %+v", got)
+	}
+
+	if err := reloaded.Delete(ConfigLogLevel, "node-1"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if err := reloaded.Delete(ConfigLogLevel, "node-1"); err == nil {
+		t.Error("expected error deleting unset key")
+	}
+
+	entries := reloaded.List()
+	if len(entries) != 1 || entries[0].Key != ConfigDefaultDeny {
+		t.Errorf("expected only default-deny, got %+v", entries)
+	}
+
+	// Revisions keep increasing after reload
+	entry, _ = reloaded.Set(ConfigLogLevel, "info", "node-1")
+	if entry.Version != 4 {
+		t.Errorf("expected version 4, got %d", entry.Version)
+	}
+}
+
+func TestLocalConfigStoreSaveFailure(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := NewLocalConfigStore(filepath.Join(dir, "cluster-config.json"))
+	if _, err := store.Set(ConfigLogLevel, "debug", "node-1"); err != nil {
+		t.Fatalf("failed to set: %v", err)
+	}
+
+	// A path whose parent is a file cannot be written
+	path := store.path
+	store.path = filepath.Join(path, "unwritable")
+
+	if _, err := store.Set(ConfigLogLevel, "warn", "node-1"); err == nil {
+		t.Fatal("expected save error")
+	}
+	if _, err := store.Set(ConfigDefaultDeny, "true", "node-1"); err == nil {
+		t.Fatal("expected save error")
+	}
+	if err := store.Delete(ConfigLogLevel, "node-1"); err == nil {
+		t.Fatal("expected save error")
+	}
+
+	entries := store.List()
+	if len(entries) != 1 || entries[0].Value != "debug" || entries[0].Version != 1 {
+		t.Errorf("expected failed writes to be rolled back, got %+v", entries)
+	}
+
+	store.path = path
+	if entry, _ := store.Set(ConfigLogLevel, "warn", "node-1"); entry.Version != 2 {
+		t.Errorf("expected revision to resume at 2, got %d", entry.Version)
+	}
+}
+
+func TestLocalConfigStoreReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cluster-config.json")
+	writer, _ := NewLocalConfigStore(path)
+	writer.Set(ConfigDefaultDeny, "true", "node-1")
+
+	reader, _ := NewLocalConfigStore(path)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := reader.Watch(ctx)
+
+	writer.Set(ConfigLogLevel, "debug", "node-1")
+	writer.Set(ConfigLogLevel, "warn", "node-1")
+	writer.Delete(ConfigDefaultDeny, "node-1")
+
+	if err := reader.Reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+
+	// The two log-level writes collapse into their end state
+	if change := <-changes; change.Key != ConfigLogLevel || change.Value != "warn" || change.Version != 3 {
+		t.Errorf("unexpected change: %+v", change)
+	}
+	if change := <-changes; change.Key != ConfigDefaultDeny || !change.Deleted || change.Version != 4 {
+		t.Errorf("expected default-deny deleted at version 4, got %+v", change)
+	}
+	if _, ok := reader.Get(ConfigDefaultDeny); ok {
+		t.Error("expected default-deny removed after reload")
+	}
+
+	// Nothing changed since the last reload
+	if err := reader.Reload(); err != nil {
+		t.Fatalf("failed to reload: %v", err)
+	}
+	select {
+	case change := <-changes:
+		t.Errorf("unexpected change: %+v", change)
+	default:
+	}
+}
+
+func TestLocalConfigStoreWatch(t *testing.T) {
+	store, _ := NewLocalConfigStore("")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := store.Watch(ctx)
+
+	store.Set(ConfigLogLevel, "debug", "node-1")
+	store.Delete(ConfigLogLevel, "node-1")
+
+	if change := <-changes; change.Key != ConfigLogLevel || change.Value != "debug" {
+		t.Errorf("unexpected change: %+v", change)
+	}
+	if change := <-changes; !change.Deleted || change.Version != 2 {
+		t.Errorf("expected delete at version 2, got %+v", change)
+	}
+
+	cancel()
+	if _, ok := <-changes; ok {
+		t.Error("expected channel to be closed after cancel")
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	store, _ := NewLocalConfigStore("")
+	store.Set(ConfigDefaultDeny, "true", "node-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	live := make(map[string]string)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ApplyConfig(ctx, store, func(entry ConfigEntry) {
+			mu.Lock()
+			defer mu.Unlock()
+			if entry.Deleted {
+				delete(live, entry.Key)
+			} else {
+				live[entry.Key] = entry.Value
+			}
+		})
+	}()
+
+	store.Set(ConfigLogLevel, "warn", "node-2")
+	store.Set("feature.fast-path", "true", "node-2")
+	store.Delete(ConfigDefaultDeny, "node-2")
+
+	deadline := time.After(time.Second)
+	for {
+		mu.Lock()
+		converged := len(live) == 2 && live[ConfigLogLevel] == "warn" && live["feature.fast-path"] == "true"
+		mu.Unlock()
+		if converged {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("config not applied live: %v", live)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	<-done
+}
+
+// gapStore is a ConfigStore whose watch channel and listing are driven by
+// the test
+type gapStore struct {
+	LocalConfigStore
+	mu      sync.Mutex
+	listing []ConfigEntry
+	changes chan ConfigEntry
+}
+
+func (s *gapStore) List() []ConfigEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listing
+}
+
+func (s *gapStore) Watch(ctx context.Context) <-chan ConfigEntry { return s.changes }
+
+func (s *gapStore) setListing(entries ...ConfigEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listing = entries
+}
+
+func TestApplyConfigResyncsOnGap(t *testing.T) {
+	store := &gapStore{changes: make(chan ConfigEntry)}
+	store.setListing(
+		ConfigEntry{Key: ConfigDefaultDeny, Value: "true", Version: 1},
+		ConfigEntry{Key: ConfigLogLevel, Value: "info", Version: 2},
+	)
+
+	var applied []ConfigEntry
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ApplyConfig(context.Background(), store, func(entry ConfigEntry) {
+			applied = append(applied, entry)
+		})
+	}()
+
+	store.changes <- ConfigEntry{Key: ConfigLogLevel, Value: "debug", Version: 3}
+
+	// Versions 4-5 were evicted: default-deny was deleted and a flag added.
+	// Only version 6 arrives, and the listing reflects everything.
+	store.setListing(
+		ConfigEntry{Key: "feature.fast-path", Value: "true", Version: 5},
+		ConfigEntry{Key: ConfigLogLevel, Value: "warn", Version: 6},
+	)
+	store.changes <- ConfigEntry{Key: ConfigLogLevel, Value: "warn", Version: 6}
+	close(store.changes)
+	<-done
+
+	live := make(map[string]string)
+	for _, entry := range applied {
+		if entry.Deleted {
+			delete(live, entry.Key)
+		} else {
+			live[entry.Key] = entry.Value
+		}
+	}
+	if len(live) != 2 || live[ConfigLogLevel] != "warn" || live["feature.fast-path"] != "true" {
+		t.Errorf("expected resync to converge, got %v from %+v", live, applied)
+	}
+	// Snapshot (2) + change (1) + resync: flag, log-level, default-deny deleted (3)
+	if len(applied) != 6 {
+		t.Errorf("expected 6 applied entries, got %+v", applied)
+	}
+}
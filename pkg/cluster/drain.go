@@ -0,0 +1,138 @@
+package cluster
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// DrainMode is the enforcement state a drained node switches to
+type DrainMode string
+
+const (
+	// DrainMonitor removes every rule, so traffic flows and is only logged
+	// (fail-open)
+	DrainMonitor DrainMode = "monitor"
+	// DrainKeep keeps the last enforced rules and ignores policy changes
+	DrainKeep DrainMode = "keep"
+)
+
+// ParseDrainMode parses the value of a drain.<node-id> config key
+func ParseDrainMode(value string) (DrainMode, error) {
+	switch mode := DrainMode(value); mode {
+	case DrainMonitor, DrainKeep:
+		return mode, nil
+	}
+	return "", fmt.Errorf("drain mode must be %s or %s", DrainMonitor, DrainKeep)
+}
+
+// NodeSchedule is the maintenance state of a node, stored under
+// ConfigCordonPrefix+<node-id> ("true") and ConfigDrainPrefix+<node-id>
+// (a DrainMode). A cordoned node receives no new policy assignments; a
+// drained node is also cordoned, switches to its drain mode and cannot
+// become leader.
+type NodeSchedule struct {
+	Cordoned bool      `json:"cordoned"`
+	Drain    DrainMode `json:"drain,omitempty"` // Empty unless drained
+	By       string    `json:"by,omitempty"`    // Who cordoned or drained the node
+}
+
+// Drained reports whether the node is drained
+func (s NodeSchedule) Drained() bool {
+	return s.Drain != ""
+}
+
+// String describes the state, e.g. "drained (monitor)", or "-" for a
+// schedulable node
+func (s NodeSchedule) String() string {
+	switch {
+	case s.Drained():
+		return fmt.Sprintf("drained (%s)", s.Drain)
+	case s.Cordoned:
+		return "cordoned"
+	}
+	return "-"
+}
+
+// NodeSchedules returns the cordoned and drained nodes in store, by node ID
+func NodeSchedules(store ConfigStore) map[string]NodeSchedule {
+	schedules := make(map[string]NodeSchedule)
+	for _, entry := range store.List() {
+		ApplyNodeSchedule(entry, schedules)
+	}
+	return schedules
+}
+
+// ApplyNodeSchedule updates schedules, keyed by node ID, with a change to a
+// cordon or drain config key, and returns the node it concerns. It reports
+// false for other keys and invalid values.
+func ApplyNodeSchedule(entry ConfigEntry, schedules map[string]NodeSchedule) (string, bool) {
+	node, schedule, ok := applyNodeSchedule(entry, schedules)
+	if !ok {
+		return "", false
+	}
+	if !schedule.Cordoned && !schedule.Drained() {
+		delete(schedules, node)
+	} else {
+		schedules[node] = schedule
+	}
+	return node, true
+}
+
+func applyNodeSchedule(entry ConfigEntry, schedules map[string]NodeSchedule) (string, NodeSchedule, bool) {
+	if node, ok := strings.CutPrefix(entry.Key, ConfigCordonPrefix); ok && node != "" {
+		schedule := schedules[node]
+		cordoned, err := strconv.ParseBool(entry.Value)
+		if !entry.Deleted && err != nil {
+			return "", schedule, false
+		}
+		schedule.Cordoned = !entry.Deleted && cordoned
+		if !entry.Deleted {
+			schedule.By = entry.UpdatedBy
+		}
+		return node, schedule, true
+	}
+	if node, ok := strings.CutPrefix(entry.Key, ConfigDrainPrefix); ok && node != "" {
+		schedule := schedules[node]
+		schedule.Drain = ""
+		if !entry.Deleted {
+			mode, err := ParseDrainMode(entry.Value)
+			if err != nil {
+				return "", schedule, false
+			}
+			schedule.Drain, schedule.By = mode, entry.UpdatedBy
+		}
+		return node, schedule, true
+	}
+	return "", NodeSchedule{}, false
+}
+
+// FollowDrains returns a function that keeps election in step with drain
+// config changes; pass it to ApplyConfig. Drained nodes are set to
+// StateDrained, which excludes them from leadership, and are healthy again
+// once undrained.
+func FollowDrains(election LeaderElection) func(ConfigEntry) {
+	schedules := make(map[string]NodeSchedule)
+	return func(entry ConfigEntry) {
+		node, ok := ApplyNodeSchedule(entry, schedules)
+		if !ok {
+			return
+		}
+		current := election.GetNode(node)
+		if current == nil {
+			return
+		}
+
+		state := current.State
+		switch {
+		case schedules[node].Drained():
+			state = StateDrained
+		case current.State == StateDrained:
+			state = StateHealthy
+		}
+		if err := election.SetNodeState(node, state); err != nil {
+			log.Printf("Warning: Failed to update state of node %s: %v", node, err)
+		}
+	}
+}
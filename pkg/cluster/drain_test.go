@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ztap/pkg/clock"
+)
+
+func TestNodeSchedules(t *testing.T) {
+	store, err := NewLocalConfigStore("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if _, err := store.Set(ConfigDrainPrefix+"web-1", "evict", "alice"); err == nil {
+		t.Error("Expected error for an unknown drain mode")
+	}
+	store.Set(ConfigCordonPrefix+"web-1", "true", "alice")
+	store.Set(ConfigCordonPrefix+"web-2", "false", "alice")
+	store.Set(ConfigDrainPrefix+"db-1", "keep", "bob")
+
+	schedules := NodeSchedules(store)
+	if len(schedules) != 2 {
+		t.Fatalf("Expected web-1 and db-1, got %+v", schedules)
+	}
+	if s := schedules["web-1"]; !s.Cordoned || s.Drained() || s.String() != "cordoned" {
+		t.Errorf("Expected web-1 cordoned, got %+v", s)
+	}
+	if s := schedules["db-1"]; s.Drain != DrainKeep || s.By != "bob" || s.String() != "drained (keep)" {
+		t.Errorf("Expected db-1 drained in keep mode, got %+v", s)
+	}
+
+	if node, ok := ApplyNodeSchedule(ConfigEntry{Key: ConfigDrainPrefix + "db-1", Deleted: true}, schedules); !ok || node != "db-1" {
+		t.Errorf("Expected the change to concern db-1, got %q, %v", node, ok)
+	}
+	if _, ok := schedules["db-1"]; ok {
+		t.Error("Expected db-1 forgotten once undrained")
+	}
+}
+
+func TestFollowDrainsExcludesFromLeadership(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	e := NewInMemoryElection(LeaderElectionConfig{NodeID: "a", Clock: clk})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := e.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer e.Stop()
+	e.RegisterNode(&Node{ID: "b", State: StateHealthy})
+	e.checkAndElect()
+	if leader := e.GetLeader(); leader == nil || leader.ID != "a" {
+		t.Fatalf("Expected a to lead, got %+v", leader)
+	}
+
+	follow := FollowDrains(e)
+	follow(ConfigEntry{Key: ConfigDrainPrefix + "a", Value: "monitor"})
+	if leader := e.GetLeader(); leader == nil || leader.ID != "b" || e.GetNode("a").State != StateDrained {
+		t.Fatalf("Expected the drained leader replaced by b, got %+v", leader)
+	}
+	e.checkAndElect()
+	if e.GetNode("a").State != StateDrained {
+		t.Error("Expected a to stay drained across heartbeats")
+	}
+
+	follow(ConfigEntry{Key: ConfigDrainPrefix + "a", Deleted: true})
+	if e.GetNode("a").State != StateHealthy {
+		t.Errorf("Expected a healthy once undrained, got %s", e.GetNode("a").State)
+	}
+}
@@ -6,8 +6,16 @@ import (
 	"log"
 	"sync"
 	"time"
+
+	"ztap/pkg/clock"
+	"ztap/pkg/events"
+	"ztap/pkg/faults"
+	"ztap/pkg/metrics"
 )
 
+// watchBufferSize is the number of pending state changes kept per watcher
+const watchBufferSize = 10
+
 // InMemoryElection implements a simple in-memory leader election for development and testing.
 // It is NOT suitable for production distributed deployments; use etcd or Raft for production.
 type InMemoryElection struct {
@@ -20,8 +28,11 @@ type InMemoryElection struct {
 	stopCh       chan struct{}
 	nodeUpdates  []chan ClusterStateChange
 	leaderChs    []chan *Node
-	ticker       *time.Ticker
+	ticker       clock.Ticker
+	clock        clock.Clock
 	lastElection time.Time
+	dropped      uint64
+	expired      map[string]bool // Nodes marked unhealthy for missing heartbeats
 }
 
 // NewInMemoryElection creates a new in-memory leader election backend.
@@ -38,6 +49,7 @@ func NewInMemoryElection(config LeaderElectionConfig) *InMemoryElection {
 	if config.MaxRetries == 0 {
 		config.MaxRetries = 3
 	}
+	clk := clock.OrReal(config.Clock)
 
 	return &InMemoryElection{
 		config:       config,
@@ -45,44 +57,52 @@ func NewInMemoryElection(config LeaderElectionConfig) *InMemoryElection {
 		stopCh:       make(chan struct{}),
 		nodeUpdates:  make([]chan ClusterStateChange, 0),
 		leaderChs:    make([]chan *Node, 0),
-		lastElection: time.Now(),
+		lastElection: clk.Now(),
+		expired:      make(map[string]bool),
+		clock:        clk,
 	}
 }
 
 // Start begins the leader election process.
 func (e *InMemoryElection) Start(ctx context.Context) error {
 	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	if e.running {
-		e.mu.Unlock()
 		return fmt.Errorf("leader election already running")
 	}
 	e.running = true
+	e.stopCh = make(chan struct{})
 
 	// Register this node
 	thisNode := &Node{
 		ID:       e.config.NodeID,
 		Address:  e.config.NodeAddress,
 		State:    StateHealthy,
-		JoinedAt: time.Now(),
-		LastSeen: time.Now(),
-		Metadata: make(map[string]string),
+		JoinedAt: e.clock.Now(),
+		LastSeen: e.clock.Now(),
+		Metadata: make(map[string]string, len(e.config.NodeMetadata)),
+	}
+	for k, v := range e.config.NodeMetadata {
+		thisNode.Metadata[k] = v
 	}
 	e.state.Nodes[thisNode.ID] = thisNode
-	e.mu.Unlock()
 
-	e.ticker = time.NewTicker(e.config.HeartbeatInterval)
+	e.ticker = e.clock.NewTicker(e.config.HeartbeatInterval)
 
-	go e.runElectionLoop(ctx)
+	go e.runElectionLoop(ctx, e.ticker, e.stopCh)
 	log.Printf("In-memory leader election started for node %s", e.config.NodeID)
 
 	return nil
 }
 
-// Stop gracefully shuts down the leader election.
+// Stop gracefully shuts down the leader election. Watcher channels are closed
+// under the lock, so Stop never races with broadcasts or watch cancellation.
 func (e *InMemoryElection) Stop() error {
 	e.mu.Lock()
+	defer e.mu.Unlock()
+
 	if !e.running {
-		e.mu.Unlock()
 		return fmt.Errorf("leader election not running")
 	}
 	e.running = false
@@ -103,7 +123,6 @@ func (e *InMemoryElection) Stop() error {
 	e.nodeUpdates = make([]chan ClusterStateChange, 0)
 	e.leaderChs = make([]chan *Node, 0)
 
-	e.mu.Unlock()
 	return nil
 }
 
@@ -114,14 +133,26 @@ func (e *InMemoryElection) IsLeader() bool {
 	return e.isLeader
 }
 
-// GetLeader returns the current leader node, or nil if no leader is elected.
+// GetLeader returns a copy of the current leader node, or nil if no leader is elected.
 func (e *InMemoryElection) GetLeader() *Node {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
-	return e.leader
+	return e.leader.Clone()
+}
+
+// FencingToken returns the token for the current leadership term.
+func (e *InMemoryElection) FencingToken() (FencingToken, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if !e.isLeader || e.leader == nil {
+		return FencingToken{}, ErrNotLeader
+	}
+	return FencingToken{Epoch: e.state.Epoch, LeaderID: e.leader.ID}, nil
 }
 
-// RegisterNode adds or updates a node in the cluster.
+// RegisterNode adds or updates a node in the cluster. The election keeps its
+// own copy, so later changes to node by the caller have no effect.
 func (e *InMemoryElection) RegisterNode(node *Node) error {
 	if node == nil {
 		return fmt.Errorf("node cannot be nil")
@@ -134,20 +165,104 @@ func (e *InMemoryElection) RegisterNode(node *Node) error {
 		return fmt.Errorf("node ID cannot be empty")
 	}
 
-	node.LastSeen = time.Now()
-	e.state.Nodes[node.ID] = node
+	stored := node.Clone()
+	stored.LastSeen = e.clock.Now()
+	e.state.Nodes[stored.ID] = stored
+	delete(e.expired, stored.ID)
 	e.state.Version++
 
+	// Keep the leader pointer on the stored node when the leader re-registers
+	if e.leader != nil && e.leader.ID == stored.ID {
+		stored.Role = "leader"
+		e.leader = stored
+		e.state.Leader = stored
+	}
+
 	change := ClusterStateChange{
 		Type:      ChangeNodeJoined,
-		Node:      node,
-		Timestamp: time.Now(),
+		Node:      stored.Clone(),
+		Timestamp: e.clock.Now(),
+		Seq:       e.state.Version,
 	}
 	e.broadcastChange(change)
 
 	return nil
 }
 
+// SetNodeState updates the operational state of a node. A leader that is no
+// longer healthy is replaced immediately.
+func (e *InMemoryElection) SetNodeState(nodeID string, state NodeState) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	node, exists := e.state.Nodes[nodeID]
+	if !exists {
+		return fmt.Errorf("node %s not found", nodeID)
+	}
+	if node.State == state {
+		return nil
+	}
+
+	node.State = state
+	node.LastSeen = e.clock.Now()
+	delete(e.expired, nodeID)
+	e.state.Version++
+
+	changeType := ChangeNodeUnwell
+	if state == StateHealthy {
+		changeType = ChangeNodeHealthy
+	}
+	e.broadcastChange(ClusterStateChange{
+		Type:      changeType,
+		Node:      node.Clone(),
+		Timestamp: e.clock.Now(),
+		Seq:       e.state.Version,
+	})
+
+	if e.leader != nil && e.leader.ID == nodeID && state != StateHealthy {
+		e.triggerElection()
+	}
+
+	return nil
+}
+
+// Heartbeat records that a node is alive. A node that was marked unhealthy
+// for missing heartbeats becomes healthy again; it does not take leadership
+// back from the node that replaced it.
+func (e *InMemoryElection) Heartbeat(nodeID string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, exists := e.state.Nodes[nodeID]; !exists {
+		return fmt.Errorf("node %s not found", nodeID)
+	}
+	e.heartbeat(nodeID)
+	return nil
+}
+
+// heartbeat refreshes a node's LastSeen (requires holding mu lock).
+func (e *InMemoryElection) heartbeat(nodeID string) {
+	node, exists := e.state.Nodes[nodeID]
+	if !exists {
+		return
+	}
+	node.LastSeen = e.clock.Now()
+	if !e.expired[nodeID] {
+		return
+	}
+
+	delete(e.expired, nodeID)
+	node.State = StateHealthy
+	e.state.Version++
+	e.broadcastChange(ClusterStateChange{
+		Type:      ChangeNodeHealthy,
+		Node:      node.Clone(),
+		Timestamp: e.clock.Now(),
+		Seq:       e.state.Version,
+	})
+	log.Printf("Node %s is sending heartbeats again", nodeID)
+}
+
 // DeregisterNode removes a node from the cluster.
 func (e *InMemoryElection) DeregisterNode(nodeID string) error {
 	e.mu.Lock()
@@ -159,12 +274,14 @@ func (e *InMemoryElection) DeregisterNode(nodeID string) error {
 	}
 
 	delete(e.state.Nodes, nodeID)
+	delete(e.expired, nodeID)
 	e.state.Version++
 
 	change := ClusterStateChange{
 		Type:      ChangeNodeLeft,
-		Node:      node,
-		Timestamp: time.Now(),
+		Node:      node.Clone(),
+		Timestamp: e.clock.Now(),
+		Seq:       e.state.Version,
 	}
 	e.broadcastChange(change)
 
@@ -176,97 +293,89 @@ func (e *InMemoryElection) DeregisterNode(nodeID string) error {
 	return nil
 }
 
-// GetNodes returns all known nodes in the cluster.
+// GetNodes returns copies of all known nodes in the cluster.
 func (e *InMemoryElection) GetNodes() []*Node {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
 	nodes := make([]*Node, 0, len(e.state.Nodes))
 	for _, node := range e.state.Nodes {
-		nodes = append(nodes, node)
+		nodes = append(nodes, node.Clone())
 	}
 	return nodes
 }
 
-// GetNode returns a specific node by ID, or nil if not found.
+// GetNode returns a copy of a specific node by ID, or nil if not found.
 func (e *InMemoryElection) GetNode(nodeID string) *Node {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
-	return e.state.Nodes[nodeID]
+	return e.state.Nodes[nodeID].Clone()
 }
 
 // Watch returns a channel that receives notifications on cluster state changes.
 func (e *InMemoryElection) Watch(ctx context.Context) <-chan ClusterStateChange {
-	ch := make(chan ClusterStateChange, 10)
+	ch := make(chan ClusterStateChange, watchBufferSize)
+
+	e.mu.Lock()
+	e.nodeUpdates = append(e.nodeUpdates, ch)
+	e.mu.Unlock()
 
 	go func() {
 		<-ctx.Done()
 		e.mu.Lock()
-		// Remove this channel from watchers
-		for i, watcher := range e.nodeUpdates {
-			if watcher == ch {
-				e.nodeUpdates = append(e.nodeUpdates[:i], e.nodeUpdates[i+1:]...)
-				break
-			}
-		}
-		e.mu.Unlock()
-		// Close channel after removal to avoid double-close
-		select {
-		case <-ch:
-			// Channel already closed
-		default:
+		defer e.mu.Unlock()
+		// Stop may already have closed and removed the channel
+		if removeWatcher(&e.nodeUpdates, ch) {
 			close(ch)
 		}
 	}()
 
-	e.mu.Lock()
-	e.nodeUpdates = append(e.nodeUpdates, ch)
-	e.mu.Unlock()
-
 	return ch
 }
 
 // LeaderChanges returns a channel that receives notifications when leadership changes.
 func (e *InMemoryElection) LeaderChanges(ctx context.Context) <-chan *Node {
-	ch := make(chan *Node, 10)
+	ch := make(chan *Node, 1)
+
+	e.mu.Lock()
+	e.leaderChs = append(e.leaderChs, ch)
+	e.mu.Unlock()
 
 	go func() {
 		<-ctx.Done()
 		e.mu.Lock()
-		// Remove this channel from watchers
-		for i, watcher := range e.leaderChs {
-			if watcher == ch {
-				e.leaderChs = append(e.leaderChs[:i], e.leaderChs[i+1:]...)
-				break
-			}
-		}
-		e.mu.Unlock()
-		// Close channel after removal to avoid double-close
-		select {
-		case <-ch:
-			// Channel already closed
-		default:
+		defer e.mu.Unlock()
+		// Stop may already have closed and removed the channel
+		if removeWatcher(&e.leaderChs, ch) {
 			close(ch)
 		}
 	}()
 
-	e.mu.Lock()
-	e.leaderChs = append(e.leaderChs, ch)
-	e.mu.Unlock()
-
 	return ch
 }
 
+// removeWatcher removes ch from watchers and reports whether it was present
+// (requires holding mu lock).
+func removeWatcher[T any](watchers *[]chan T, ch chan T) bool {
+	for i, watcher := range *watchers {
+		if watcher == ch {
+			*watchers = append((*watchers)[:i], (*watchers)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
 // runElectionLoop manages periodic leader election.
-func (e *InMemoryElection) runElectionLoop(ctx context.Context) {
+func (e *InMemoryElection) runElectionLoop(ctx context.Context, ticker clock.Ticker, stopCh <-chan struct{}) {
 	for {
 		select {
-		case <-e.stopCh:
+		case <-stopCh:
 			return
 		case <-ctx.Done():
 			return
-		case <-e.ticker.C:
+		case <-ticker.C():
 			e.checkAndElect()
 		}
 	}
@@ -277,15 +386,30 @@ func (e *InMemoryElection) checkAndElect() {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	// Every tick is this node's own heartbeat
+	if err := faults.Inject(faults.Heartbeat); err == nil {
+		e.heartbeat(e.config.NodeID)
+	}
+
 	// If no leader or leader is unhealthy, trigger election
 	if e.leader == nil || e.leader.State != StateHealthy {
 		e.triggerElection()
 		return
 	}
 
-	// Check for leader timeout
-	if time.Since(e.leader.LastSeen) > e.config.ElectionTimeout {
+	// A leader that stopped sending heartbeats is unhealthy until it
+	// heartbeats again, so the election moves to another node
+	if e.clock.Since(e.leader.LastSeen) > e.config.ElectionTimeout {
 		log.Printf("Leader %s timed out; triggering election", e.leader.ID)
+		e.expired[e.leader.ID] = true
+		e.leader.State = StateUnhealthy
+		e.state.Version++
+		e.broadcastChange(ClusterStateChange{
+			Type:      ChangeNodeUnwell,
+			Node:      e.leader.Clone(),
+			Timestamp: e.clock.Now(),
+			Seq:       e.state.Version,
+		})
 		e.triggerElection()
 	}
 }
@@ -304,17 +428,42 @@ func (e *InMemoryElection) triggerElection() {
 
 	oldLeader := e.leader
 	e.leader = newLeader
-	if e.leader != nil {
+
+	// Every state version is broadcast so watchers can rely on Seq gaps
+	// meaning dropped changes; re-electing the same leader changes nothing
+	if leaderID(oldLeader) != leaderID(newLeader) {
+		defer func() {
+			e.state.Version++
+			e.broadcastChange(ClusterStateChange{
+				Type:      ChangeLeaderElected,
+				Node:      e.leader.Clone(),
+				Timestamp: e.clock.Now(),
+				Seq:       e.state.Version,
+			})
+		}()
+	}
+
+	if e.leader == nil {
+		e.isLeader = false
+		e.state.Leader = nil
+	} else {
 		e.leader.Role = "leader"
 		e.isLeader = (e.leader.ID == e.config.NodeID)
 		e.state.Leader = e.leader
-		e.state.Version++
-		e.lastElection = time.Now()
+		if oldLeader == nil || oldLeader.ID != e.leader.ID {
+			e.state.Epoch++
+			events.Default().Publish(events.TopicLeaderChanged, events.LeaderChanged{
+				LeaderID: e.leader.ID,
+				Epoch:    e.state.Epoch,
+			})
+		}
+		e.lastElection = e.clock.Now()
 
-		log.Printf("New leader elected: %s (this node leader=%v)", e.leader.ID, e.isLeader)
+		log.Printf("New leader elected: %s (epoch %d, this node leader=%v)",
+			e.leader.ID, e.state.Epoch, e.isLeader)
 
 		// Notify leader change watchers
-		e.broadcastLeaderChange(e.leader)
+		e.broadcastLeaderChange(e.leader.Clone())
 
 		// Mark old leader as follower if it still exists
 		if oldLeader != nil && oldLeader.ID != e.leader.ID {
@@ -323,13 +472,29 @@ func (e *InMemoryElection) triggerElection() {
 	}
 }
 
+func leaderID(n *Node) string {
+	if n == nil {
+		return ""
+	}
+	return n.ID
+}
+
+// DroppedNotifications returns how many watch notifications were discarded
+// because a consumer fell behind
+func (e *InMemoryElection) DroppedNotifications() uint64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dropped
+}
+
 // broadcastChange sends a change notification to all watchers (requires holding mu lock).
+// When a watcher's buffer is full the oldest pending change is discarded so the
+// newest state always gets through.
 func (e *InMemoryElection) broadcastChange(change ClusterStateChange) {
 	for _, ch := range e.nodeUpdates {
-		select {
-		case ch <- change:
-		default:
-			log.Printf("Warning: node change channel full, dropping event")
+		if offerLatest(ch, change) {
+			e.dropped++
+			metrics.GetCollector().IncWatchDropped("cluster")
 		}
 	}
 }
@@ -337,10 +502,29 @@ func (e *InMemoryElection) broadcastChange(change ClusterStateChange) {
 // broadcastLeaderChange sends a leader change notification to all watchers (requires holding mu lock).
 func (e *InMemoryElection) broadcastLeaderChange(leader *Node) {
 	for _, ch := range e.leaderChs {
-		select {
-		case ch <- leader:
-		default:
-			log.Printf("Warning: leader change channel full, dropping event")
+		if offerLatest(ch, leader) {
+			e.dropped++
+			metrics.GetCollector().IncWatchDropped("cluster_leader")
 		}
 	}
 }
+
+// offerLatest sends v without blocking, evicting the oldest buffered value if
+// ch is full. It reports whether a value was evicted. Callers must be the only
+// sender on ch.
+func offerLatest[T any](ch chan T, v T) bool {
+	select {
+	case ch <- v:
+		return false
+	default:
+	}
+
+	evicted := false
+	select {
+	case <-ch:
+		evicted = true
+	default:
+	}
+	ch <- v
+	return evicted
+}
@@ -2,10 +2,36 @@ package cluster
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
+
+	"ztap/pkg/clock"
 )
 
+// tick advances the fake clock by one heartbeat interval once the election
+// loop is waiting on it, and waits until the loop has handled the tick
+func tick(t *testing.T, e *InMemoryElection, clk *clock.Fake) {
+	t.Helper()
+	clk.BlockUntil(1)
+	before := e.clock.Now()
+	clk.Advance(e.config.HeartbeatInterval)
+	deadline := time.Now().Add(time.Second)
+	for {
+		e.mu.RLock()
+		self := e.state.Nodes[e.config.NodeID]
+		handled := self != nil && self.LastSeen.After(before)
+		e.mu.RUnlock()
+		if handled {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("election loop did not handle the tick")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func TestInMemoryElectionStart(t *testing.T) {
 	config := LeaderElectionConfig{
 		NodeID:      "node-1",
@@ -91,10 +117,12 @@ func TestInMemoryElectionRegisterNode(t *testing.T) {
 }
 
 func TestInMemoryElectionLeaderElection(t *testing.T) {
+	clk := clock.NewFake(time.Now())
 	config := LeaderElectionConfig{
 		NodeID:            "node-1",
 		NodeAddress:       "127.0.0.1:9090",
 		HeartbeatInterval: 100 * time.Millisecond,
+		Clock:             clk,
 	}
 	election := NewInMemoryElection(config)
 
@@ -107,7 +135,7 @@ func TestInMemoryElectionLeaderElection(t *testing.T) {
 	defer election.Stop()
 
 	// Wait for leader election
-	time.Sleep(200 * time.Millisecond)
+	tick(t, election, clk)
 
 	leader := election.GetLeader()
 	if leader == nil {
@@ -124,10 +152,12 @@ func TestInMemoryElectionLeaderElection(t *testing.T) {
 }
 
 func TestInMemoryElectionMultipleNodes(t *testing.T) {
+	clk := clock.NewFake(time.Now())
 	config := LeaderElectionConfig{
 		NodeID:            "node-1",
 		NodeAddress:       "127.0.0.1:9090",
 		HeartbeatInterval: 100 * time.Millisecond,
+		Clock:             clk,
 	}
 	election := NewInMemoryElection(config)
 
@@ -151,7 +181,7 @@ func TestInMemoryElectionMultipleNodes(t *testing.T) {
 	}
 
 	// Wait for leader election
-	time.Sleep(200 * time.Millisecond)
+	tick(t, election, clk)
 
 	leader := election.GetLeader()
 	if leader == nil {
@@ -244,11 +274,115 @@ func TestInMemoryElectionWatch(t *testing.T) {
 	}
 }
 
+func TestInMemoryElectionWatchSlowConsumer(t *testing.T) {
+	election := NewInMemoryElection(LeaderElectionConfig{
+		NodeID:      "node-1",
+		NodeAddress: "127.0.0.1:9090",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := election.Watch(ctx)
+
+	total := watchBufferSize + 5
+	for i := 0; i < total; i++ {
+		node := &Node{ID: fmt.Sprintf("node-%d", i+2), State: StateHealthy}
+		if err := election.RegisterNode(node); err != nil {
+			t.Fatalf("failed to register: %v", err)
+		}
+	}
+
+	// The oldest changes are dropped; the newest are kept in order
+	first := <-changes
+	if first.Seq != 6 {
+		t.Errorf("expected first retained seq 6, got %d", first.Seq)
+	}
+	last := first
+	for i := 1; i < watchBufferSize; i++ {
+		change := <-changes
+		if change.Seq != last.Seq+1 {
+			t.Errorf("expected seq %d, got %d", last.Seq+1, change.Seq)
+		}
+		last = change
+	}
+	if last.Seq != int64(total) {
+		t.Errorf("expected latest seq %d, got %d", total, last.Seq)
+	}
+
+	if dropped := election.DroppedNotifications(); dropped != 5 {
+		t.Errorf("expected 5 dropped notifications, got %d", dropped)
+	}
+}
+
+func TestInMemoryElectionWatchLeaderElected(t *testing.T) {
+	election := NewInMemoryElection(LeaderElectionConfig{
+		NodeID:      "node-1",
+		NodeAddress: "127.0.0.1:9090",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := election.Watch(ctx)
+
+	for _, id := range []string{"node-2", "node-3"} {
+		if err := election.RegisterNode(&Node{ID: id, State: StateHealthy}); err != nil {
+			t.Fatalf("failed to register: %v", err)
+		}
+	}
+	election.checkAndElect()
+	election.checkAndElect() // Same leader: no state change
+	if err := election.DeregisterNode("node-2"); err != nil {
+		t.Fatalf("failed to deregister: %v", err)
+	}
+
+	expected := []struct {
+		changeType ChangeType
+		nodeID     string
+	}{
+		{ChangeNodeJoined, "node-2"},
+		{ChangeNodeJoined, "node-3"},
+		{ChangeLeaderElected, "node-2"},
+		{ChangeNodeLeft, "node-2"},
+		{ChangeLeaderElected, "node-3"},
+	}
+	for i, want := range expected {
+		change := <-changes
+		if change.Seq != int64(i+1) {
+			t.Errorf("change %d: expected seq %d, got %d", i, i+1, change.Seq)
+		}
+		if change.Type != want.changeType || change.Node == nil || change.Node.ID != want.nodeID {
+			t.Errorf("change %d: expected %s %s, got %+v", i, want.changeType, want.nodeID, change)
+		}
+	}
+	select {
+	case change := <-changes:
+		t.Errorf("unexpected extra change %+v", change)
+	default:
+	}
+}
+
+func TestOfferLatest(t *testing.T) {
+	ch := make(chan int, 1)
+
+	if offerLatest(ch, 1) {
+		t.Error("expected no eviction with free buffer")
+	}
+	if !offerLatest(ch, 2) {
+		t.Error("expected eviction with full buffer")
+	}
+	if v := <-ch; v != 2 {
+		t.Errorf("expected latest value 2, got %d", v)
+	}
+}
+
 func TestInMemoryElectionLeaderChanges(t *testing.T) {
+	clk := clock.NewFake(time.Now())
 	config := LeaderElectionConfig{
 		NodeID:            "node-1",
 		NodeAddress:       "127.0.0.1:9090",
 		HeartbeatInterval: 100 * time.Millisecond,
+		Clock:             clk,
 	}
 	election := NewInMemoryElection(config)
 
@@ -266,25 +400,146 @@ func TestInMemoryElectionLeaderChanges(t *testing.T) {
 	changes := election.LeaderChanges(leaderCtx)
 
 	// Wait for initial leader election
-	time.Sleep(200 * time.Millisecond)
+	tick(t, election, clk)
+
+	if err := election.RegisterNode(&Node{ID: "node-2", State: StateHealthy}); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
 
 	// Mark current leader as unhealthy to trigger election
-	leader := election.GetLeader()
-	if leader != nil {
-		leader.State = StateUnhealthy
+	if err := election.SetNodeState("node-1", StateUnhealthy); err != nil {
+		t.Fatalf("failed to set node state: %v", err)
+	}
+
+	// Should receive a leader change notification for the new leader
+	timeout := time.After(1 * time.Second)
+	for {
+		select {
+		case newLeader := <-changes:
+			if newLeader == nil {
+				t.Fatal("leader should not be nil")
+			}
+			if newLeader.ID == "node-2" {
+				if election.IsLeader() {
+					t.Error("node-1 should no longer be leader")
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for node-2 to be elected")
+		}
+	}
+}
+
+func TestInMemoryElectionReturnsCopies(t *testing.T) {
+	election := NewInMemoryElection(LeaderElectionConfig{
+		NodeID:      "node-1",
+		NodeAddress: "127.0.0.1:9090",
+	})
+
+	node := &Node{ID: "node-2", State: StateHealthy, Metadata: map[string]string{"zone": "a"}}
+	if err := election.RegisterNode(node); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+
+	// Mutating the registered or returned node must not affect stored state
+	node.State = StateStopped
+	retrieved := election.GetNode("node-2")
+	retrieved.State = StateUnhealthy
+	retrieved.Metadata["zone"] = "b"
+	for _, n := range election.GetNodes() {
+		n.State = StateUnhealthy
+	}
+
+	stored := election.GetNode("node-2")
+	if stored.State != StateHealthy {
+		t.Errorf("expected stored state %s, got %s", StateHealthy, stored.State)
+	}
+	if stored.Metadata["zone"] != "a" {
+		t.Errorf("expected stored metadata zone=a, got %s", stored.Metadata["zone"])
+	}
+}
+
+func TestInMemoryElectionSetNodeState(t *testing.T) {
+	election := NewInMemoryElection(LeaderElectionConfig{
+		NodeID:      "node-1",
+		NodeAddress: "127.0.0.1:9090",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := election.Watch(ctx)
+
+	if err := election.SetNodeState("missing", StateUnhealthy); err == nil {
+		t.Error("expected error for unknown node")
 	}
 
-	// Wait for new election
-	time.Sleep(200 * time.Millisecond)
+	if err := election.RegisterNode(&Node{ID: "node-2", State: StateHealthy}); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	<-changes
+
+	if err := election.SetNodeState("node-2", StateUnhealthy); err != nil {
+		t.Fatalf("failed to set node state: %v", err)
+	}
+	change := <-changes
+	if change.Type != ChangeNodeUnwell || change.Node.State != StateUnhealthy {
+		t.Errorf("expected node_unwell change, got %s (%s)", change.Type, change.Node.State)
+	}
+
+	if err := election.SetNodeState("node-2", StateHealthy); err != nil {
+		t.Fatalf("failed to set node state: %v", err)
+	}
+	if change := <-changes; change.Type != ChangeNodeHealthy {
+		t.Errorf("expected node_healthy change, got %s", change.Type)
+	}
 
-	// Should receive a leader change notification
+	// Setting the current state is a no-op
+	if err := election.SetNodeState("node-2", StateHealthy); err != nil {
+		t.Fatalf("failed to set node state: %v", err)
+	}
 	select {
-	case newLeader := <-changes:
-		if newLeader == nil {
-			t.Error("leader should not be nil")
+	case change := <-changes:
+		t.Errorf("expected no change notification, got %s", change.Type)
+	default:
+	}
+}
+
+func TestInMemoryElectionStopWatchRace(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		election := NewInMemoryElection(LeaderElectionConfig{
+			NodeID:            "node-1",
+			NodeAddress:       "127.0.0.1:9090",
+			HeartbeatInterval: time.Millisecond,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := election.Start(ctx); err != nil {
+			t.Fatalf("failed to start: %v", err)
+		}
+
+		changes := election.Watch(ctx)
+		leaders := election.LeaderChanges(ctx)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for j := 0; j < 20; j++ {
+				_ = election.RegisterNode(&Node{ID: fmt.Sprintf("node-%d", j+2), State: StateHealthy})
+			}
+		}()
+
+		// Cancel and Stop concurrently; neither may double-close a channel
+		go cancel()
+		if err := election.Stop(); err != nil {
+			t.Fatalf("failed to stop: %v", err)
+		}
+		<-done
+
+		for range changes {
+		}
+		for range leaders {
 		}
-	case <-time.After(1 * time.Second):
-		// Timeout is acceptable; leader change may not always fire in test
 	}
 }
 
@@ -316,6 +571,62 @@ func TestInMemoryElectionStop(t *testing.T) {
 	}
 }
 
+func TestInMemoryElectionLeaderTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	election := NewInMemoryElection(LeaderElectionConfig{
+		NodeID:            "node-1",
+		HeartbeatInterval: time.Second,
+		ElectionTimeout:   5 * time.Second,
+		Clock:             clk,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := election.Start(ctx); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer election.Stop()
+
+	// node-0 sorts first and leads while it sends heartbeats
+	if err := election.RegisterNode(&Node{ID: "node-0", State: StateHealthy}); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		tick(t, election, clk)
+		if err := election.Heartbeat("node-0"); err != nil {
+			t.Fatalf("heartbeat failed: %v", err)
+		}
+	}
+	if leader := election.GetLeader(); leader == nil || leader.ID != "node-0" {
+		t.Fatalf("expected node-0 to lead, got %v", leader)
+	}
+
+	// node-0 goes silent past the election timeout
+	for i := 0; i < 6; i++ {
+		tick(t, election, clk)
+	}
+	if !election.IsLeader() {
+		t.Fatalf("expected node-1 to take over, leader is %v", election.GetLeader())
+	}
+	if node := election.GetNode("node-0"); node.State != StateUnhealthy {
+		t.Errorf("expected timed out node-0 to be unhealthy, got %s", node.State)
+	}
+
+	// node-0 comes back as a healthy follower
+	if err := election.Heartbeat("node-0"); err != nil {
+		t.Fatalf("heartbeat failed: %v", err)
+	}
+	tick(t, election, clk)
+	if node := election.GetNode("node-0"); node.State != StateHealthy {
+		t.Errorf("expected node-0 to recover, got %s", node.State)
+	}
+	if !election.IsLeader() {
+		t.Error("expected node-1 to keep leadership")
+	}
+	if err := election.Heartbeat("node-9"); err == nil {
+		t.Error("expected error for unknown node")
+	}
+}
+
 func TestInMemoryElectionDefaultConfig(t *testing.T) {
 	config := LeaderElectionConfig{
 		NodeID:      "node-1",
@@ -0,0 +1,104 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EnrollRequest is the body of POST /enroll
+type EnrollRequest struct {
+	Token string `json:"token"`
+	Node  string `json:"node"`
+	CSR   string `json:"csr"` // PEM certificate request for the node's key
+}
+
+// EnrollResponse is the identity the API server returns to an enrolled node
+type EnrollResponse struct {
+	Node           string            `json:"node"`
+	Tenant         string            `json:"tenant,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	ServiceAccount string            `json:"service_account"`
+	Secret         string            `json:"secret"`      // Service account secret
+	Certificate    string            `json:"certificate"` // PEM client certificate
+	CA             string            `json:"ca"`          // PEM cluster CA certificate
+	ExpiresAt      time.Time         `json:"expires_at"`  // Of the certificate
+}
+
+// Enrollment is an enrolled node's identity with its private key
+type Enrollment struct {
+	EnrollResponse
+	Key []byte // PEM private key
+}
+
+// Enroll generates a key for node and enrolls it with the API server at
+// server (e.g. https://controller:8443), presenting a join token. The key
+// never leaves the node: only a certificate request is sent.
+func Enroll(ctx context.Context, client *http.Client, server, token, node string) (*Enrollment, error) {
+	keyPEM, csrPEM, err := NewNodeKey(node)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(EnrollRequest{Token: token, Node: node, CSR: string(csrPEM)})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(server, "/")+"/enroll", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", server, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return nil, fmt.Errorf("enrollment rejected (%s): %s", resp.Status, failure.Error)
+	}
+	var enrollment Enrollment
+	if err := json.NewDecoder(resp.Body).Decode(&enrollment.EnrollResponse); err != nil {
+		return nil, fmt.Errorf("invalid enrollment response: %w", err)
+	}
+	if enrollment.Node != node || enrollment.Certificate == "" {
+		return nil, fmt.Errorf("invalid enrollment response for node %q", enrollment.Node)
+	}
+	enrollment.Key = keyPEM
+	return &enrollment, nil
+}
+
+// Save writes the enrollment to dir as node.key, node.crt, ca.crt and
+// node.secret, the key and secret readable only by their owner
+func (e *Enrollment) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{"node.key", e.Key, 0600},
+		{"node.secret", []byte(e.Secret + "\n"), 0600},
+		{"node.crt", []byte(e.Certificate), 0644},
+		{"ca.crt", []byte(e.CA), 0644},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dir, f.name), f.data, f.mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+	return nil
+}
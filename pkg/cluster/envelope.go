@@ -0,0 +1,303 @@
+package cluster
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Errors returned when opening a sealed policy update
+var (
+	ErrUnsealedUpdate = errors.New("policy update is not sealed")
+	ErrUnknownSyncKey = errors.New("policy update sealed with an unknown or retired sync key")
+	ErrBadSignature   = errors.New("policy update signature is invalid")
+)
+
+// DefaultSyncKeyOverlap is how long a rotated sync key still opens updates,
+// so nodes can pick up the new keyring at different times
+const DefaultSyncKeyOverlap = 24 * time.Hour
+
+// PayloadSealer protects the payloads of policy updates between nodes, on
+// top of the transport's TLS. The sending transport seals each update; an
+// agent given a sealer (see agent.Agent.SetSealer) opens every update
+// before applying it and rejects those that are unsealed or fail to open.
+type PayloadSealer interface {
+	// Seal returns update with its YAML moved into an Envelope
+	Seal(update PolicyUpdate) (PolicyUpdate, error)
+	// Open verifies and decrypts a sealed update, returning it with its
+	// YAML restored and no Envelope
+	Open(update PolicyUpdate) (PolicyUpdate, error)
+}
+
+// Envelope is the sealed payload of a PolicyUpdate. The YAML is encrypted
+// with a fresh data key, which is encrypted (wrapped) with the cluster's
+// sync key. The signature also covers the update's name, version, fencing
+// token and origin, so a payload cannot be replayed under other ones.
+type Envelope struct {
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"` // Nonce and AES-GCM sealed data key
+	Ciphertext []byte `json:"ciphertext"`  // Nonce and AES-GCM sealed YAML
+	Signature  []byte `json:"signature"`   // Ed25519
+}
+
+// SyncKey is one version of the cluster's policy sync key
+type SyncKey struct {
+	ID         string             `json:"id"`
+	Secret     []byte             `json:"secret"`                // AES-256 key wrapping data keys
+	SigningKey ed25519.PrivateKey `json:"signing_key,omitempty"` // Absent from verify-only keyrings
+	VerifyKey  ed25519.PublicKey  `json:"verify_key"`
+	CreatedAt  time.Time          `json:"created_at"`
+	RetiredAt  time.Time          `json:"retired_at,omitempty"` // When a newer key replaced it
+}
+
+// SyncKeyring holds the versions of the sync key. The newest seals updates;
+// older ones still open them until Rotate drops them after their overlap.
+// It implements PayloadSealer.
+type SyncKeyring struct {
+	Keys []SyncKey `json:"keys"` // Oldest first
+}
+
+// NewSyncKeyring creates a keyring with a single key
+func NewSyncKeyring(now time.Time) (*SyncKeyring, error) {
+	key, err := newSyncKey(now)
+	if err != nil {
+		return nil, err
+	}
+	return &SyncKeyring{Keys: []SyncKey{key}}, nil
+}
+
+func newSyncKey(now time.Time) (SyncKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return SyncKey{}, fmt.Errorf("failed to generate sync key: %w", err)
+	}
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return SyncKey{}, fmt.Errorf("failed to generate sync signing key: %w", err)
+	}
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return SyncKey{}, fmt.Errorf("failed to generate sync key: %w", err)
+	}
+	return SyncKey{ID: hex.EncodeToString(id), Secret: secret, SigningKey: private, VerifyKey: public, CreatedAt: now}, nil
+}
+
+// LoadSyncKeyring reads the keyring at path
+func LoadSyncKeyring(path string) (*SyncKeyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync keyring: %w", err)
+	}
+	var keyring SyncKeyring
+	if err := json.Unmarshal(data, &keyring); err != nil {
+		return nil, fmt.Errorf("failed to parse sync keyring %s: %w", path, err)
+	}
+	if len(keyring.Keys) == 0 {
+		return nil, fmt.Errorf("sync keyring %s has no keys", path)
+	}
+	for _, key := range keyring.Keys {
+		if len(key.Secret) != 32 || len(key.VerifyKey) != ed25519.PublicKeySize ||
+			(key.SigningKey != nil && len(key.SigningKey) != ed25519.PrivateKeySize) {
+			return nil, fmt.Errorf("sync keyring %s: key %s is malformed", path, key.ID)
+		}
+	}
+	return &keyring, nil
+}
+
+// Save writes the keyring, readable only by its owner
+func (k *SyncKeyring) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create sync keyring directory: %w", err)
+	}
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write sync keyring: %w", err)
+	}
+	return nil
+}
+
+// Primary returns the key that seals updates
+func (k *SyncKeyring) Primary() SyncKey {
+	return k.Keys[len(k.Keys)-1]
+}
+
+// Rotate adds a new primary key, retiring the current one, and drops the
+// keys retired more than overlap ago. It returns the new key and the IDs
+// of the dropped ones.
+func (k *SyncKeyring) Rotate(now time.Time, overlap time.Duration) (SyncKey, []string, error) {
+	key, err := newSyncKey(now)
+	if err != nil {
+		return SyncKey{}, nil, err
+	}
+	var kept []SyncKey
+	var dropped []string
+	for i, old := range k.Keys {
+		if i == len(k.Keys)-1 {
+			old.RetiredAt = now
+		}
+		if !old.RetiredAt.IsZero() && now.Sub(old.RetiredAt) > overlap {
+			dropped = append(dropped, old.ID)
+			continue
+		}
+		kept = append(kept, old)
+	}
+	k.Keys = append(kept, key)
+	return key, dropped, nil
+}
+
+// VerifyOnly returns a copy of the keyring without signing keys, for
+// agents that open updates but must not be able to forge them
+func (k *SyncKeyring) VerifyOnly() *SyncKeyring {
+	keys := make([]SyncKey, len(k.Keys))
+	for i, key := range k.Keys {
+		key.SigningKey = nil
+		keys[i] = key
+	}
+	return &SyncKeyring{Keys: keys}
+}
+
+// key returns the key with id
+func (k *SyncKeyring) key(id string) (SyncKey, bool) {
+	for _, key := range k.Keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return SyncKey{}, false
+}
+
+// envelopeHeader is what an Envelope's signature and encryption bind the
+// payload to
+type envelopeHeader struct {
+	PolicyName string       `json:"policy_name"`
+	Version    int64        `json:"version"`
+	Token      FencingToken `json:"token"`
+	Source     string       `json:"source"`
+	Principal  string       `json:"principal"`
+	Timestamp  time.Time    `json:"timestamp"`
+	Schema     int          `json:"schema"`
+	Features   []string     `json:"features"`
+	KeyID      string       `json:"key_id"`
+}
+
+// sealedHeader returns the additional data of an update's encryption
+func sealedHeader(update PolicyUpdate, keyID string) []byte {
+	data, _ := json.Marshal(envelopeHeader{
+		PolicyName: update.PolicyName,
+		Version:    update.Version,
+		Token:      update.Token,
+		Source:     update.Source,
+		Principal:  update.Principal,
+		Timestamp:  update.Timestamp.UTC(),
+		Schema:     update.Schema,
+		Features:   update.Features,
+		KeyID:      keyID,
+	})
+	return data
+}
+
+// signed returns the message an Envelope's signature covers
+func signed(aad []byte, e *Envelope) []byte {
+	message, _ := json.Marshal([][]byte{aad, e.WrappedKey, e.Ciphertext})
+	return message
+}
+
+// Seal encrypts and signs the update's YAML with the primary key
+func (k *SyncKeyring) Seal(update PolicyUpdate) (PolicyUpdate, error) {
+	key := k.Primary()
+	if key.SigningKey == nil {
+		return PolicyUpdate{}, fmt.Errorf("sync key %s has no signing key; this keyring can only open updates", key.ID)
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return PolicyUpdate{}, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	aad := sealedHeader(update, key.ID)
+	envelope := &Envelope{KeyID: key.ID}
+	var err error
+	if envelope.WrappedKey, err = seal(key.Secret, dataKey, aad); err != nil {
+		return PolicyUpdate{}, err
+	}
+	if envelope.Ciphertext, err = seal(dataKey, update.YAML, aad); err != nil {
+		return PolicyUpdate{}, err
+	}
+	envelope.Signature = ed25519.Sign(key.SigningKey, signed(aad, envelope))
+
+	update.YAML = nil
+	update.Envelope = envelope
+	return update, nil
+}
+
+// Open verifies the signature of a sealed update, then decrypts its YAML
+func (k *SyncKeyring) Open(update PolicyUpdate) (PolicyUpdate, error) {
+	envelope := update.Envelope
+	if envelope == nil {
+		return PolicyUpdate{}, ErrUnsealedUpdate
+	}
+	key, ok := k.key(envelope.KeyID)
+	if !ok {
+		return PolicyUpdate{}, fmt.Errorf("%w: %s", ErrUnknownSyncKey, envelope.KeyID)
+	}
+	aad := sealedHeader(update, key.ID)
+	if !ed25519.Verify(key.VerifyKey, signed(aad, envelope), envelope.Signature) {
+		return PolicyUpdate{}, ErrBadSignature
+	}
+	dataKey, err := open(key.Secret, envelope.WrappedKey, aad)
+	if err != nil {
+		return PolicyUpdate{}, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	yaml, err := open(dataKey, envelope.Ciphertext, aad)
+	if err != nil {
+		return PolicyUpdate{}, fmt.Errorf("failed to decrypt policy update: %w", err)
+	}
+
+	update.YAML = yaml
+	update.Envelope = nil
+	return update, nil
+}
+
+// seal encrypts plaintext with AES-256-GCM, returning the nonce followed by
+// the ciphertext
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open decrypts what seal returned
+func open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
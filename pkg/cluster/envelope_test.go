@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testUpdate() PolicyUpdate {
+	return PolicyUpdate{
+		PolicyName: "web-to-db",
+		YAML:       []byte("kind: NetworkPolicy\n"),
+		Version:    3,
+		Token:      FencingToken{Epoch: 2, LeaderID: "node-a"},
+		Source:     "node-a",
+		Principal:  "alice",
+		Timestamp:  time.Now(),
+		Schema:     1,
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	keyring, err := NewSyncKeyring(time.Now())
+	if err != nil {
+		t.Fatalf("NewSyncKeyring failed: %v", err)
+	}
+	update := testUpdate()
+	sealed, err := keyring.Seal(update)
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if sealed.YAML != nil || sealed.Envelope == nil || bytes.Contains(sealed.Envelope.Ciphertext, update.YAML) {
+		t.Fatalf("Expected the YAML sealed, got %+v", sealed)
+	}
+
+	// Updates cross the wire as JSON
+	data, _ := json.Marshal(sealed)
+	var received PolicyUpdate
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	opened, err := keyring.VerifyOnly().Open(received)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if string(opened.YAML) != string(update.YAML) || opened.Envelope != nil {
+		t.Errorf("Unexpected opened update %+v", opened)
+	}
+
+	// The envelope is bound to the update's metadata
+	for name, tamper := range map[string]func(*PolicyUpdate){
+		"version":    func(u *PolicyUpdate) { u.Version++ },
+		"name":       func(u *PolicyUpdate) { u.PolicyName = "other" },
+		"epoch":      func(u *PolicyUpdate) { u.Token.Epoch++ },
+		"principal":  func(u *PolicyUpdate) { u.Principal = "mallory" },
+		"ciphertext": func(u *PolicyUpdate) { u.Envelope.Ciphertext[len(u.Envelope.Ciphertext)-1] ^= 1 },
+	} {
+		forged := received
+		envelope := *received.Envelope
+		envelope.Ciphertext = bytes.Clone(envelope.Ciphertext)
+		forged.Envelope = &envelope
+		tamper(&forged)
+		if _, err := keyring.Open(forged); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: expected ErrBadSignature, got %v", name, err)
+		}
+	}
+
+	if _, err := keyring.Open(update); !errors.Is(err, ErrUnsealedUpdate) {
+		t.Errorf("Expected ErrUnsealedUpdate, got %v", err)
+	}
+	if _, err := keyring.VerifyOnly().Seal(update); err == nil {
+		t.Error("Expected a verify-only keyring unable to seal")
+	}
+	other, _ := NewSyncKeyring(time.Now())
+	if _, err := other.Open(sealed); !errors.Is(err, ErrUnknownSyncKey) {
+		t.Errorf("Expected ErrUnknownSyncKey from another cluster's keyring, got %v", err)
+	}
+}
+
+func TestSyncKeyringRotate(t *testing.T) {
+	start := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	keyring, _ := NewSyncKeyring(start)
+	first := keyring.Primary()
+	sealed, _ := keyring.Seal(testUpdate())
+
+	second, dropped, err := keyring.Rotate(start.Add(time.Hour), DefaultSyncKeyOverlap)
+	if err != nil || len(dropped) != 0 {
+		t.Fatalf("Rotate failed: %v, dropped %v", err, dropped)
+	}
+	if keyring.Primary().ID != second.ID || len(keyring.Keys) != 2 || keyring.Keys[0].RetiredAt.IsZero() {
+		t.Fatalf("Expected the new key primary and the first retired, got %+v", keyring.Keys)
+	}
+	// Updates sealed before the rotation still open during the overlap
+	if _, err := keyring.Open(sealed); err != nil {
+		t.Errorf("Expected the retired key to open updates, got %v", err)
+	}
+	resealed, _ := keyring.Seal(testUpdate())
+	if resealed.Envelope.KeyID != second.ID {
+		t.Errorf("Expected new updates sealed with %s, got %s", second.ID, resealed.Envelope.KeyID)
+	}
+
+	_, dropped, _ = keyring.Rotate(start.Add(26*time.Hour), DefaultSyncKeyOverlap)
+	if len(dropped) != 1 || dropped[0] != first.ID || len(keyring.Keys) != 2 {
+		t.Fatalf("Expected %s dropped after the overlap, got %v (%d keys)", first.ID, dropped, len(keyring.Keys))
+	}
+	if _, err := keyring.Open(sealed); !errors.Is(err, ErrUnknownSyncKey) {
+		t.Errorf("Expected ErrUnknownSyncKey after the overlap, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "sync-keys.json")
+	if err := keyring.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the keyring readable by its owner only, got %v", info.Mode())
+	}
+	loaded, err := LoadSyncKeyring(path)
+	if err != nil || loaded.Primary().ID != keyring.Primary().ID {
+		t.Fatalf("LoadSyncKeyring failed: %v", err)
+	}
+	if _, err := loaded.Open(resealed); err != nil {
+		t.Errorf("Expected the loaded keyring to open updates, got %v", err)
+	}
+}
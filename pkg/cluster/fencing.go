@@ -0,0 +1,59 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrNotLeader is returned when a fencing token is requested by a follower
+	ErrNotLeader = errors.New("this node is not the cluster leader")
+
+	// ErrStaleEpoch is returned when an operation carries an outdated fencing token
+	ErrStaleEpoch = errors.New("stale leadership epoch")
+)
+
+// FencingToken identifies a leadership term. Epochs increase monotonically
+// each time a different leader is elected, so an operation issued by a
+// deposed leader carries a lower epoch than one from the current leader.
+type FencingToken struct {
+	Epoch    int64  `json:"epoch"`
+	LeaderID string `json:"leader_id"`
+}
+
+func (t FencingToken) String() string {
+	return fmt.Sprintf("epoch %d (%s)", t.Epoch, t.LeaderID)
+}
+
+// Fence guards a resource against updates from stale leaders. Agents check
+// the token of every policy or cloud sync update before applying it.
+type Fence struct {
+	mu      sync.Mutex
+	highest FencingToken
+}
+
+// Admit accepts token if its epoch is at least the highest seen so far and
+// records it. Tokens from an older epoch, or a different leader claiming the
+// same epoch (split brain), are rejected with ErrStaleEpoch.
+func (f *Fence) Admit(token FencingToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if token.Epoch < f.highest.Epoch {
+		return fmt.Errorf("%w: got %s, current %s", ErrStaleEpoch, token, f.highest)
+	}
+	if token.Epoch == f.highest.Epoch && f.highest.LeaderID != "" && token.LeaderID != f.highest.LeaderID {
+		return fmt.Errorf("%w: conflicting leader for %s, current %s", ErrStaleEpoch, token, f.highest)
+	}
+
+	f.highest = token
+	return nil
+}
+
+// Current returns the highest admitted token
+func (f *Fence) Current() FencingToken {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.highest
+}
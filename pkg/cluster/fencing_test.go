@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFenceAdmit(t *testing.T) {
+	var fence Fence
+
+	if err := fence.Admit(FencingToken{Epoch: 1, LeaderID: "node-1"}); err != nil {
+		t.Fatalf("expected first token to be admitted: %v", err)
+	}
+	if err := fence.Admit(FencingToken{Epoch: 1, LeaderID: "node-1"}); err != nil {
+		t.Fatalf("expected same token to be admitted again: %v", err)
+	}
+	if err := fence.Admit(FencingToken{Epoch: 1, LeaderID: "node-2"}); !errors.Is(err, ErrStaleEpoch) {
+		t.Errorf("expected conflicting leader in same epoch to be rejected, got %v", err)
+	}
+	if err := fence.Admit(FencingToken{Epoch: 2, LeaderID: "node-2"}); err != nil {
+		t.Fatalf("expected newer epoch to be admitted: %v", err)
+	}
+	if err := fence.Admit(FencingToken{Epoch: 1, LeaderID: "node-1"}); !errors.Is(err, ErrStaleEpoch) {
+		t.Errorf("expected deposed leader to be rejected, got %v", err)
+	}
+
+	if current := fence.Current(); current.Epoch != 2 || current.LeaderID != "node-2" {
+		t.Errorf("unexpected current token: %+v", current)
+	}
+}
+
+func TestInMemoryElectionFencingToken(t *testing.T) {
+	election := NewInMemoryElection(LeaderElectionConfig{
+		NodeID:            "node-1",
+		NodeAddress:       "127.0.0.1:9090",
+		HeartbeatInterval: 10 * time.Millisecond,
+	})
+
+	if _, err := election.FencingToken(); !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("expected ErrNotLeader before election, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := election.Start(ctx); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+	defer election.Stop()
+
+	var first FencingToken
+	deadline := time.After(time.Second)
+	for {
+		token, err := election.FencingToken()
+		if err == nil {
+			first = token
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timeout waiting for leadership")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if first.Epoch != 1 || first.LeaderID != "node-1" {
+		t.Errorf("unexpected first token: %+v", first)
+	}
+
+	// Losing and regaining leadership starts a new epoch
+	election.RegisterNode(&Node{ID: "node-0", State: StateHealthy})
+	election.SetNodeState("node-1", StateUnhealthy)
+	if _, err := election.FencingToken(); !errors.Is(err, ErrNotLeader) {
+		t.Errorf("expected ErrNotLeader after losing leadership, got %v", err)
+	}
+
+	election.DeregisterNode("node-0")
+	election.SetNodeState("node-1", StateHealthy)
+	election.mu.Lock()
+	election.triggerElection()
+	election.mu.Unlock()
+
+	second, err := election.FencingToken()
+	if err != nil {
+		t.Fatalf("expected leadership to be regained: %v", err)
+	}
+	if second.Epoch <= first.Epoch {
+		t.Errorf("expected epoch to increase past %d, got %d", first.Epoch, second.Epoch)
+	}
+
+	// The old token is now rejected by a fence that has seen the new one
+	var fence Fence
+	fence.Admit(second)
+	if err := fence.Admit(first); !errors.Is(err, ErrStaleEpoch) {
+		t.Errorf("expected stale token to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ztap/pkg/clock"
+)
+
+// Errors returned when redeeming a join token
+var (
+	ErrTokenInvalid = errors.New("invalid join token")
+	ErrTokenExpired = errors.New("join token expired")
+	ErrTokenUsed    = errors.New("join token already used")
+)
+
+// tokenRetention is how long expired and used tokens are kept for 'ztap
+// cluster token list'
+const tokenRetention = 7 * 24 * time.Hour
+
+// JoinToken is a short-lived, single-use credential a node enrolls with.
+// Only a hash of its secret is stored.
+type JoinToken struct {
+	ID        string            `json:"id"`
+	Hash      string            `json:"hash"`           // SHA-256 of the secret, hex encoded
+	Node      string            `json:"node,omitempty"` // Node the token is restricted to, if any
+	Tenant    string            `json:"tenant,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"` // Labels of the node's service account
+	CreatedBy string            `json:"created_by,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	UsedAt    time.Time         `json:"used_at,omitempty"`
+	UsedBy    string            `json:"used_by,omitempty"` // Node that enrolled with the token
+}
+
+// Used reports whether a node has enrolled with the token
+func (t JoinToken) Used() bool {
+	return t.UsedBy != ""
+}
+
+// TokenOptions describe a join token to create
+type TokenOptions struct {
+	TTL       time.Duration
+	Node      string // Restricts the token to this node ID (optional)
+	Tenant    string
+	Labels    map[string]string
+	CreatedBy string
+}
+
+// TokenStore keeps join tokens in a JSON file. The file is read again on
+// every operation, as tokens are created by 'ztap cluster token create' and
+// redeemed by 'ztap serve'.
+type TokenStore struct {
+	path  string
+	mu    sync.Mutex
+	clock clock.Clock
+}
+
+// NewTokenStore creates a token store persisted at path
+func NewTokenStore(path string) *TokenStore {
+	return &TokenStore{path: path, clock: clock.Real}
+}
+
+// SetClock sets the clock expiry is checked against (used in tests)
+func (s *TokenStore) SetClock(clk clock.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = clock.OrReal(clk)
+}
+
+// Create stores a new token and returns it as <id>.<secret>, the form
+// nodes present. The secret is not stored and cannot be shown again.
+func (s *TokenStore) Create(opts TokenOptions) (string, JoinToken, error) {
+	if opts.TTL <= 0 {
+		return "", JoinToken{}, fmt.Errorf("join token TTL must be positive")
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return "", JoinToken{}, err
+	}
+	secret, err := randomHex(16)
+	if err != nil {
+		return "", JoinToken{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.load()
+	if err != nil {
+		return "", JoinToken{}, err
+	}
+	now := s.clock.Now()
+	token := JoinToken{
+		ID:        id,
+		Hash:      hashSecret(secret),
+		Node:      opts.Node,
+		Tenant:    opts.Tenant,
+		Labels:    opts.Labels,
+		CreatedBy: opts.CreatedBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(opts.TTL),
+	}
+	tokens[id] = token
+	if err := s.save(tokens); err != nil {
+		return "", JoinToken{}, err
+	}
+	return id + "." + secret, token, nil
+}
+
+// Redeem consumes a token presented by node, returning what it was created
+// with. A token can be redeemed once, before it expires, and only by the
+// node it is restricted to.
+func (s *TokenStore) Redeem(presented, node string) (JoinToken, error) {
+	id, secret, ok := strings.Cut(presented, ".")
+	if !ok {
+		return JoinToken{}, ErrTokenInvalid
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.load()
+	if err != nil {
+		return JoinToken{}, err
+	}
+	token, exists := tokens[id]
+	if !exists || subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashSecret(secret))) != 1 {
+		return JoinToken{}, ErrTokenInvalid
+	}
+	if token.Used() {
+		return JoinToken{}, ErrTokenUsed
+	}
+	now := s.clock.Now()
+	if !now.Before(token.ExpiresAt) {
+		return JoinToken{}, ErrTokenExpired
+	}
+	if token.Node != "" && token.Node != node {
+		return JoinToken{}, fmt.Errorf("%w: restricted to node %s", ErrTokenInvalid, token.Node)
+	}
+
+	token.UsedAt, token.UsedBy = now, node
+	tokens[id] = token
+	if err := s.save(tokens); err != nil {
+		return JoinToken{}, err
+	}
+	return token, nil
+}
+
+// Release returns a token redeemed by node to unused, for an enrollment that
+// failed after Redeem. Tokens redeemed by another node are left as they are.
+func (s *TokenStore) Release(id, node string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	token, exists := tokens[id]
+	if !exists || token.UsedBy != node {
+		return nil
+	}
+	token.UsedAt, token.UsedBy = time.Time{}, ""
+	tokens[id] = token
+	return s.save(tokens)
+}
+
+// List returns the stored tokens, newest first
+func (s *TokenStore) List() ([]JoinToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]JoinToken, 0, len(tokens))
+	for _, token := range tokens {
+		list = append(list, token)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	return list, nil
+}
+
+// RotatedToken is a join token reissued by Rotate
+type RotatedToken struct {
+	Previous  string // ID of the token it replaces
+	Presented string // The new token, as <id>.<secret>
+	Token     JoinToken
+}
+
+// Rotate reissues every unused, unexpired token with a new ID and secret
+// and the same options and expiry, oldest first. The previous tokens stay
+// valid for overlap, or until they expire if that is sooner, so nodes
+// already handed one can still enroll.
+func (s *TokenStore) Rotate(overlap time.Duration) ([]RotatedToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	now := s.clock.Now()
+	var previous []JoinToken
+	for _, token := range tokens {
+		if !token.Used() && now.Before(token.ExpiresAt) {
+			previous = append(previous, token)
+		}
+	}
+	sort.Slice(previous, func(i, j int) bool { return previous[i].CreatedAt.Before(previous[j].CreatedAt) })
+
+	rotated := make([]RotatedToken, 0, len(previous))
+	for _, old := range previous {
+		id, err := randomHex(4)
+		if err != nil {
+			return nil, err
+		}
+		secret, err := randomHex(16)
+		if err != nil {
+			return nil, err
+		}
+		token := old
+		token.ID, token.Hash, token.CreatedAt = id, hashSecret(secret), now
+		tokens[id] = token
+		if until := now.Add(overlap); until.Before(old.ExpiresAt) {
+			old.ExpiresAt = until
+			tokens[old.ID] = old
+		}
+		rotated = append(rotated, RotatedToken{Previous: old.ID, Presented: id + "." + secret, Token: token})
+	}
+	if len(rotated) == 0 {
+		return nil, nil
+	}
+	if err := s.save(tokens); err != nil {
+		return nil, err
+	}
+	return rotated, nil
+}
+
+// Revoke deletes an unused token so it can no longer be redeemed
+func (s *TokenStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, exists := tokens[id]; !exists {
+		return fmt.Errorf("join token %s not found", id)
+	}
+	delete(tokens, id)
+	return s.save(tokens)
+}
+
+// load reads the tokens, dropping those expired or used longer than
+// tokenRetention ago (requires mu)
+func (s *TokenStore) load() (map[string]JoinToken, error) {
+	tokens := make(map[string]JoinToken)
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read join tokens: %w", err)
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse join tokens %s: %w", s.path, err)
+	}
+
+	cutoff := s.clock.Now().Add(-tokenRetention)
+	for id, token := range tokens {
+		if token.ExpiresAt.Before(cutoff) && (!token.Used() || token.UsedAt.Before(cutoff)) {
+			delete(tokens, id)
+		}
+	}
+	return tokens, nil
+}
+
+// save writes the tokens, readable only by their owner (requires mu)
+func (s *TokenStore) save(tokens map[string]JoinToken) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create join token directory: %w", err)
+	}
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write join tokens: %w", err)
+	}
+	return nil
+}
+
+// hashSecret returns the stored form of a token secret
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate join token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
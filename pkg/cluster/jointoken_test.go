@@ -0,0 +1,118 @@
+package cluster
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/clock"
+)
+
+func TestTokenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "join-tokens.json")
+	clk := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	store := NewTokenStore(path)
+	store.SetClock(clk)
+
+	presented, token, err := store.Create(TokenOptions{TTL: time.Hour, Tenant: "acme", Labels: map[string]string{"app": "web"}})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !strings.HasPrefix(presented, token.ID+".") || strings.Contains(token.Hash, strings.TrimPrefix(presented, token.ID+".")) {
+		t.Errorf("Expected <id>.<secret> with only a hash stored, got %s (%+v)", presented, token)
+	}
+
+	// Another process redeems it, once
+	other := NewTokenStore(path)
+	other.SetClock(clk)
+	if _, err := other.Redeem(token.ID+".wrong", "web-1"); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected ErrTokenInvalid for a wrong secret, got %v", err)
+	}
+	redeemed, err := other.Redeem(presented, "web-1")
+	if err != nil {
+		t.Fatalf("Redeem failed: %v", err)
+	}
+	if redeemed.Tenant != "acme" || redeemed.Labels["app"] != "web" || redeemed.UsedBy != "web-1" {
+		t.Errorf("Unexpected redeemed token %+v", redeemed)
+	}
+	if _, err := store.Redeem(presented, "web-2"); !errors.Is(err, ErrTokenUsed) {
+		t.Errorf("Expected ErrTokenUsed, got %v", err)
+	}
+
+	// Expired and node-restricted tokens
+	expiring, _, err := store.Create(TokenOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restricted, _, err := store.Create(TokenOptions{TTL: time.Hour, Node: "db-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Minute)
+	if _, err := store.Redeem(expiring, "web-2"); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+	if _, err := store.Redeem(restricted, "web-2"); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected ErrTokenInvalid for another node, got %v", err)
+	}
+	if _, err := store.Redeem(restricted, "db-1"); err != nil {
+		t.Errorf("Expected db-1 to redeem its token, got %v", err)
+	}
+	if _, err := store.Redeem("not-a-token", "db-1"); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected ErrTokenInvalid for a malformed token, got %v", err)
+	}
+
+	// Revoked tokens cannot be redeemed; old ones are pruned
+	unused, revoked, err := store.Create(TokenOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Revoke(revoked.ID); err != nil {
+		t.Fatalf("Revoke failed: %v", err)
+	}
+	if _, err := store.Redeem(unused, "web-3"); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("Expected ErrTokenInvalid for a revoked token, got %v", err)
+	}
+	if tokens, err := store.List(); err != nil || len(tokens) != 3 {
+		t.Errorf("Expected 3 tokens, got %+v (%v)", tokens, err)
+	}
+	clk.Advance(tokenRetention + time.Hour)
+	if tokens, err := store.List(); err != nil || len(tokens) != 0 {
+		t.Errorf("Expected old tokens to be pruned, got %+v (%v)", tokens, err)
+	}
+
+	if _, _, err := store.Create(TokenOptions{}); err == nil {
+		t.Error("Expected error for a token without TTL")
+	}
+}
+
+func TestTokenStoreRotate(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	store := NewTokenStore(filepath.Join(t.TempDir(), "join-tokens.json"))
+	store.SetClock(clk)
+
+	oldPresented, old, _ := store.Create(TokenOptions{TTL: 4 * time.Hour, Node: "web-1", Labels: map[string]string{"app": "web"}})
+	usedPresented, _, _ := store.Create(TokenOptions{TTL: time.Hour})
+	if _, err := store.Redeem(usedPresented, "db-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := store.Rotate(time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if len(rotated) != 1 || rotated[0].Previous != old.ID || rotated[0].Token.Node != "web-1" || !rotated[0].Token.ExpiresAt.Equal(old.ExpiresAt) {
+		t.Fatalf("Expected only the unused token reissued with its options, got %+v", rotated)
+	}
+
+	// The previous token works during the overlap, the new one until it expires
+	clk.Advance(2 * time.Hour)
+	if _, err := store.Redeem(oldPresented, "web-1"); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected the previous token to expire after the overlap, got %v", err)
+	}
+	if redeemed, err := store.Redeem(rotated[0].Presented, "web-1"); err != nil || redeemed.Labels["app"] != "web" {
+		t.Errorf("Expected the reissued token to be redeemable, got %+v (%v)", redeemed, err)
+	}
+}
@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow is a planned change during which agents stop remediating
+// drift and alerting for the workloads in scope. It is stored under
+// ConfigMaintenancePrefix+Name as "<start>/<end>" in RFC 3339, optionally
+// followed by a space and a label selector such as "app=db,env=prod". A
+// window without a selector covers every workload.
+type MaintenanceWindow struct {
+	Name     string            `json:"name"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Selector map[string]string `json:"selector,omitempty"`
+	By       string            `json:"by,omitempty"` // Who set the window
+}
+
+// ParseMaintenanceWindow parses the config value of the named window
+func ParseMaintenanceWindow(name, value string) (MaintenanceWindow, error) {
+	w := MaintenanceWindow{Name: name}
+	interval, selector, _ := strings.Cut(strings.TrimSpace(value), " ")
+	start, end, ok := strings.Cut(interval, "/")
+	if !ok {
+		return w, fmt.Errorf("maintenance window must be <start>/<end> [label=value,...]")
+	}
+
+	var err error
+	if w.Start, err = time.Parse(time.RFC3339, start); err != nil {
+		return w, fmt.Errorf("maintenance window start must be an RFC 3339 time")
+	}
+	if w.End, err = time.Parse(time.RFC3339, end); err != nil {
+		return w, fmt.Errorf("maintenance window end must be an RFC 3339 time")
+	}
+	if !w.End.After(w.Start) {
+		return w, fmt.Errorf("maintenance window must end after it starts")
+	}
+
+	if selector = strings.TrimSpace(selector); selector != "" {
+		w.Selector = make(map[string]string)
+		for _, pair := range strings.Split(selector, ",") {
+			key, val, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(key) == "" {
+				return w, fmt.Errorf("maintenance window selector must be label=value pairs, got %q", pair)
+			}
+			w.Selector[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+	}
+	return w, nil
+}
+
+// Value returns the config value that stores the window
+func (w MaintenanceWindow) Value() string {
+	value := w.Start.UTC().Format(time.RFC3339) + "/" + w.End.UTC().Format(time.RFC3339)
+	if scope := w.Scope(); scope != "" {
+		value += " " + scope
+	}
+	return value
+}
+
+// Scope returns the selector as sorted label=value pairs, empty for a
+// global window
+func (w MaintenanceWindow) Scope() string {
+	pairs := make([]string, 0, len(w.Selector))
+	for k, v := range w.Selector {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Active reports whether the window is open at now
+func (w MaintenanceWindow) Active(now time.Time) bool {
+	return !now.Before(w.Start) && now.Before(w.End)
+}
+
+// Covers reports whether the window applies to a workload with labels: a
+// global window covers every workload, a scoped one those with all of its
+// selector labels
+func (w MaintenanceWindow) Covers(labels map[string]string) bool {
+	for k, v := range w.Selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// MaintenanceWindows returns the valid maintenance windows in store, ordered
+// by start
+func MaintenanceWindows(store ConfigStore) []MaintenanceWindow {
+	var windows []MaintenanceWindow
+	for _, entry := range store.List() {
+		name, ok := strings.CutPrefix(entry.Key, ConfigMaintenancePrefix)
+		if !ok {
+			continue
+		}
+		w, err := ParseMaintenanceWindow(name, entry.Value)
+		if err != nil {
+			continue
+		}
+		w.By = entry.UpdatedBy
+		windows = append(windows, w)
+	}
+	sort.SliceStable(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	return windows
+}
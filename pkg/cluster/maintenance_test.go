@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	w, err := ParseMaintenanceWindow("db-upgrade", "2025-10-31T22:00:00Z/2025-11-01T02:00:00Z env=prod, app=db")
+	if err != nil {
+		t.Fatalf("ParseMaintenanceWindow failed: %v", err)
+	}
+	if w.Scope() != "app=db,env=prod" {
+		t.Errorf("Unexpected scope %q", w.Scope())
+	}
+	if got := w.Value(); got != "2025-10-31T22:00:00Z/2025-11-01T02:00:00Z app=db,env=prod" {
+		t.Errorf("Unexpected value %q", got)
+	}
+
+	start := time.Date(2025, 10, 31, 22, 0, 0, 0, time.UTC)
+	if w.Active(start.Add(-time.Second)) || !w.Active(start) || w.Active(start.Add(4*time.Hour)) {
+		t.Error("Expected the window to be open from start until end")
+	}
+
+	if !w.Covers(map[string]string{"app": "db", "env": "prod", "tier": "data"}) {
+		t.Error("Expected window to cover a workload with all selector labels")
+	}
+	if w.Covers(map[string]string{"app": "db"}) {
+		t.Error("Expected window not to cover a workload missing a selector label")
+	}
+	if global := (MaintenanceWindow{}); !global.Covers(nil) {
+		t.Error("Expected a global window to cover every workload")
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	store, err := NewLocalConfigStore("")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	store.Set(ConfigMaintenancePrefix+"late", "2025-11-02T00:00:00Z/2025-11-02T01:00:00Z", "alice")
+	store.Set(ConfigMaintenancePrefix+"early", "2025-11-01T00:00:00Z/2025-11-01T01:00:00Z app=web", "bob")
+	store.Set(ConfigLogLevel, "debug", "alice")
+
+	windows := MaintenanceWindows(store)
+	if len(windows) != 2 || windows[0].Name != "early" || windows[1].Name != "late" {
+		t.Fatalf("Expected windows ordered by start, got %+v", windows)
+	}
+	if windows[0].By != "bob" || windows[0].Selector["app"] != "web" {
+		t.Errorf("Unexpected window %+v", windows[0])
+	}
+}
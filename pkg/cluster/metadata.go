@@ -0,0 +1,113 @@
+package cluster
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"ztap/pkg/policy"
+)
+
+// Well-known Node.Metadata keys
+const (
+	MetadataVersion        = "version"         // ZTAP build version
+	MetadataOS             = "os"              // Operating system (runtime.GOOS)
+	MetadataBackend        = "backend"         // Enforcement backend (ebpf or pf)
+	MetadataCapabilities   = "capabilities"    // Comma-separated enforcement capabilities
+	MetadataPolicyCapacity = "policy_capacity" // Max policy rules; 0 or absent means unbounded
+	MetadataPolicySchema   = "policy_schema"   // Newest policy apiVersion understood (ztap/v<N>)
+	MetadataPolicyFeatures = "policy_features" // Comma-separated policy schema features understood
+
+	// MetadataLabelPrefix starts the keys of node labels, e.g. label.role=edge
+	MetadataLabelPrefix = "label."
+)
+
+// NodeInfo describes what a node runs and can enforce
+type NodeInfo struct {
+	Version        string
+	OS             string
+	Backend        string
+	Capabilities   []string
+	PolicyCapacity int
+	PolicySchema   int               // Newest policy apiVersion understood; 0 does not advertise
+	PolicyFeatures []string          // Policy schema features understood
+	Labels         map[string]string // Matched by policy nodeSelectors
+}
+
+// Metadata encodes the node info as Node.Metadata entries
+func (i NodeInfo) Metadata() map[string]string {
+	capabilities := append([]string(nil), i.Capabilities...)
+	sort.Strings(capabilities)
+
+	metadata := map[string]string{
+		MetadataVersion:        i.Version,
+		MetadataOS:             i.OS,
+		MetadataBackend:        i.Backend,
+		MetadataCapabilities:   strings.Join(capabilities, ","),
+		MetadataPolicyCapacity: strconv.Itoa(i.PolicyCapacity),
+	}
+	if i.PolicySchema > 0 {
+		features := append([]string(nil), i.PolicyFeatures...)
+		sort.Strings(features)
+		metadata[MetadataPolicySchema] = strconv.Itoa(i.PolicySchema)
+		metadata[MetadataPolicyFeatures] = strings.Join(features, ",")
+	}
+	for key, value := range i.Labels {
+		metadata[MetadataLabelPrefix+key] = value
+	}
+	return metadata
+}
+
+// Capabilities returns the enforcement capabilities advertised by the node.
+// ok is false if the node does not advertise capabilities at all.
+func (n *Node) Capabilities() (capabilities []string, ok bool) {
+	value, ok := n.Metadata[MetadataCapabilities]
+	if !ok {
+		return nil, false
+	}
+	for _, capability := range strings.Split(value, ",") {
+		if capability = strings.TrimSpace(capability); capability != "" {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities, true
+}
+
+// PolicyCapacity returns the maximum number of policy rules the node can hold,
+// or 0 if unbounded or unknown
+func (n *Node) PolicyCapacity() int {
+	capacity, err := strconv.Atoi(n.Metadata[MetadataPolicyCapacity])
+	if err != nil || capacity < 0 {
+		return 0
+	}
+	return capacity
+}
+
+// Labels returns the node's labels, matched by policy nodeSelectors
+func (n *Node) Labels() map[string]string {
+	labels := make(map[string]string)
+	for key, value := range n.Metadata {
+		if name, ok := strings.CutPrefix(key, MetadataLabelPrefix); ok {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+// PolicyPeer returns the policy schema version and features the node
+// understands, matched against its labels. ok is false if the node does not
+// advertise them, e.g. it was joined by a build predating policy feature
+// negotiation.
+func (n *Node) PolicyPeer() (peer policy.Peer, ok bool) {
+	schema, err := strconv.Atoi(n.Metadata[MetadataPolicySchema])
+	if err != nil || schema <= 0 {
+		return policy.Peer{}, false
+	}
+	peer = policy.Peer{Schema: schema, Labels: n.Labels()}
+	for _, feature := range strings.Split(n.Metadata[MetadataPolicyFeatures], ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			peer.Features = append(peer.Features, feature)
+		}
+	}
+	return peer, true
+}
@@ -0,0 +1,47 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNodeInfoMetadata(t *testing.T) {
+	info := NodeInfo{
+		Version:        "v1.2.3",
+		OS:             "linux",
+		Backend:        "ebpf",
+		Capabilities:   []string{"label-selectors", "ipv4"},
+		PolicyCapacity: 10000,
+		Labels:         map[string]string{"role": "edge"},
+	}
+	node := &Node{ID: "node-1", Metadata: info.Metadata()}
+
+	if node.Metadata[MetadataBackend] != "ebpf" || node.Metadata[MetadataVersion] != "v1.2.3" {
+		t.Errorf("unexpected metadata: %v", node.Metadata)
+	}
+
+	capabilities, ok := node.Capabilities()
+	if !ok {
+		t.Fatal("expected capabilities to be advertised")
+	}
+	if expected := []string{"ipv4", "label-selectors"}; !reflect.DeepEqual(capabilities, expected) {
+		t.Errorf("expected capabilities %v, got %v", expected, capabilities)
+	}
+	if capacity := node.PolicyCapacity(); capacity != 10000 {
+		t.Errorf("expected capacity 10000, got %d", capacity)
+	}
+	if labels := node.Labels(); !reflect.DeepEqual(labels, map[string]string{"role": "edge"}) {
+		t.Errorf("expected labels role=edge, got %v", labels)
+	}
+}
+
+func TestNodeWithoutMetadata(t *testing.T) {
+	node := &Node{ID: "legacy"}
+
+	if _, ok := node.Capabilities(); ok {
+		t.Error("expected no advertised capabilities")
+	}
+	if capacity := node.PolicyCapacity(); capacity != 0 {
+		t.Errorf("expected unbounded capacity, got %d", capacity)
+	}
+}
@@ -0,0 +1,147 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"ztap/pkg/policy"
+)
+
+// Assignment is the set of policies a node should enforce
+type Assignment struct {
+	NodeID     string
+	Policies   []policy.NetworkPolicy
+	Skipped    map[string]string   // policy name -> reason it was not assigned
+	Downgraded map[string][]string // policy name -> schema features rewritten for the node
+}
+
+// SchedulePolicies decides which policies each node receives. A policy is
+// skipped for a node its nodeSelector does not match, a node that lacks a
+// capability it requires, or once the node's policy capacity would be
+// exceeded. A node advertising an older policy schema receives policies
+// downgraded to what it understands (see policy.Downgrade); those that cannot
+// be downgraded are skipped. Requirements and capacity use the
+// policy's compiled rules, since a selector occupies one backend entry per
+// resolved endpoint and port, and a port range one per port (see
+// policy.CompiledPolicy.Entries); policies missing from compiled are skipped.
+// Deny-all policies are placed first so capacity never crowds them out.
+// Nodes that do not advertise capabilities are assumed to support everything.
+func SchedulePolicies(nodes []*Node, policies []policy.NetworkPolicy, compiled map[string]*policy.CompiledPolicy) map[string]*Assignment {
+	ordered := make([]policy.NetworkPolicy, 0, len(policies))
+	for _, p := range policies {
+		if p.IsDenyAll() {
+			ordered = append(ordered, p)
+		}
+	}
+	for _, p := range policies {
+		if !p.IsDenyAll() {
+			ordered = append(ordered, p)
+		}
+	}
+
+	assignments := make(map[string]*Assignment, len(nodes))
+	for _, node := range nodes {
+		assignment := &Assignment{
+			NodeID:     node.ID,
+			Skipped:    make(map[string]string),
+			Downgraded: make(map[string][]string),
+		}
+		peer, negotiated := node.PolicyPeer()
+
+		capabilities, advertised := node.Capabilities()
+		supported := make(map[string]bool, len(capabilities))
+		for _, capability := range capabilities {
+			supported[capability] = true
+		}
+		capacity := node.PolicyCapacity()
+		labels := node.Labels()
+
+		used := 0
+		for _, p := range ordered {
+			if !p.SelectsNode(labels) {
+				assignment.Skipped[p.Metadata.Name] = fmt.Sprintf("nodeSelector %s does not match the node's labels",
+					labelString(p.Spec.NodeSelector))
+				continue
+			}
+
+			var downgraded []string
+			if negotiated {
+				var err error
+				if p, downgraded, err = policy.Downgrade(p, peer); err != nil {
+					var incompatible *policy.IncompatibleError
+					errors.As(err, &incompatible)
+					assignment.Skipped[p.Metadata.Name] = fmt.Sprintf("%s (node runs %s)",
+						incompatible.Reason(), nodeVersion(node))
+					continue
+				}
+			}
+
+			c, ok := compiled[p.Metadata.Name]
+			if !ok {
+				assignment.Skipped[p.Metadata.Name] = "policy could not be compiled"
+				continue
+			}
+
+			if advertised {
+				required := append(p.RequiredCapabilities(), c.RequiredCapabilities()...)
+				if missing := missingCapabilities(required, supported); len(missing) > 0 {
+					assignment.Skipped[p.Metadata.Name] = fmt.Sprintf("missing capabilities: %s",
+						strings.Join(missing, ", "))
+					continue
+				}
+			}
+
+			rules := c.Entries()
+			if capacity > 0 && used+rules > capacity {
+				assignment.Skipped[p.Metadata.Name] = fmt.Sprintf("policy capacity exceeded (%d/%d rules used)",
+					used, capacity)
+				continue
+			}
+
+			used += rules
+			assignment.Policies = append(assignment.Policies, p)
+			if len(downgraded) > 0 {
+				assignment.Downgraded[p.Metadata.Name] = downgraded
+			}
+		}
+
+		assignments[node.ID] = assignment
+	}
+
+	return assignments
+}
+
+// missingCapabilities returns the sorted, deduplicated required capabilities
+// not in supported
+func missingCapabilities(required []string, supported map[string]bool) []string {
+	var missing []string
+	for _, capability := range required {
+		if !supported[capability] && !slices.Contains(missing, capability) {
+			missing = append(missing, capability)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// labelString formats labels as k=v pairs in key order
+func labelString(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// nodeVersion returns the ZTAP version the node advertises, or "an unknown
+// version"
+func nodeVersion(node *Node) string {
+	if version := node.Metadata[MetadataVersion]; version != "" {
+		return version
+	}
+	return "an unknown version"
+}
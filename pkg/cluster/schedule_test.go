@@ -0,0 +1,241 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"ztap/pkg/policy"
+)
+
+const scheduleTestPolicies = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: v6-egress
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 2001:db8::/32
+      ports:
+        - protocol: TCP
+          port: 443
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: lockdown
+spec:
+  podSelector:
+    matchLabels:
+      app: legacy
+`
+
+// appDiscovery resolves app=<name> selectors from a map
+type appDiscovery map[string][]string
+
+func (d appDiscovery) ResolveLabels(labels map[string]string) ([]string, error) {
+	ips, ok := d[labels["app"]]
+	if !ok {
+		return nil, fmt.Errorf("no services match %v", labels)
+	}
+	return ips, nil
+}
+
+// compileAll compiles the test policies, resolving app=db to dbIPs
+func compileAll(t *testing.T, dbIPs ...string) ([]policy.NetworkPolicy, map[string]*policy.CompiledPolicy) {
+	t.Helper()
+	policies, err := policy.Parse([]byte(scheduleTestPolicies))
+	if err != nil {
+		t.Fatalf("failed to parse policies: %v", err)
+	}
+
+	resolver := policy.NewPolicyResolver(appDiscovery{"db": dbIPs})
+	compiled := make(map[string]*policy.CompiledPolicy, len(policies))
+	for _, p := range policies {
+		c, err := resolver.Compile(p)
+		if err != nil {
+			t.Fatalf("failed to compile %s: %v", p.Metadata.Name, err)
+		}
+		compiled[p.Metadata.Name] = c
+	}
+	return policies, compiled
+}
+
+func TestSchedulePolicies(t *testing.T) {
+	policies, compiled := compileAll(t, "10.0.2.1")
+
+	nodes := []*Node{
+		{ID: "linux-1", Metadata: NodeInfo{Backend: "ebpf", Capabilities: []string{"ipv4", "label-selectors"}}.Metadata()},
+		{ID: "mac-1", Metadata: NodeInfo{Backend: "pf", Capabilities: []string{"ipv4", "ipv6"}}.Metadata()},
+		{ID: "legacy-1"},
+	}
+
+	assignments := SchedulePolicies(nodes, policies, compiled)
+
+	assertAssigned := func(nodeID string, expected ...string) {
+		t.Helper()
+		var names []string
+		for _, p := range assignments[nodeID].Policies {
+			names = append(names, p.Metadata.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Errorf("%s: expected %v, got %v", nodeID, expected, names)
+		}
+	}
+
+	// Deny-all policies come first
+	assertAssigned("linux-1", "lockdown", "web-to-db")
+	assertAssigned("mac-1", "lockdown", "v6-egress")
+	assertAssigned("legacy-1", "lockdown", "web-to-db", "v6-egress")
+
+	if reason := assignments["linux-1"].Skipped["v6-egress"]; !strings.Contains(reason, "ipv6") {
+		t.Errorf("expected v6-egress skipped on linux-1 for ipv6, got %q", reason)
+	}
+	if reason := assignments["mac-1"].Skipped["web-to-db"]; !strings.Contains(reason, "label-selectors") {
+		t.Errorf("expected web-to-db skipped on mac-1 for label-selectors, got %q", reason)
+	}
+}
+
+func TestSchedulePoliciesCapacity(t *testing.T) {
+	// The selector resolves to two endpoints, i.e. two backend entries
+	policies, compiled := compileAll(t, "10.0.2.1", "10.0.2.2")
+
+	node := &Node{ID: "small", Metadata: NodeInfo{PolicyCapacity: 1}.Metadata()}
+	delete(node.Metadata, MetadataCapabilities)
+
+	assignment := SchedulePolicies([]*Node{node}, policies, compiled)["small"]
+	if len(assignment.Policies) != 2 {
+		t.Fatalf("expected 2 policies within capacity, got %d", len(assignment.Policies))
+	}
+	if reason := assignment.Skipped["web-to-db"]; !strings.Contains(reason, "capacity") {
+		t.Errorf("expected web-to-db skipped for capacity, got %q", reason)
+	}
+}
+
+func TestSchedulePoliciesPortRangeCapacity(t *testing.T) {
+	// One endpoint, but a range of 10 ports takes 10 backend entries
+	policies, compiled := compileAll(t, "10.0.2.1")
+	compiled["web-to-db"].Rules[0].EndPort = 5441
+
+	node := &Node{ID: "small", Metadata: NodeInfo{PolicyCapacity: 5}.Metadata()}
+	delete(node.Metadata, MetadataCapabilities)
+
+	assignment := SchedulePolicies([]*Node{node}, policies, compiled)["small"]
+	if reason := assignment.Skipped["web-to-db"]; !strings.Contains(reason, "capacity") {
+		t.Errorf("expected the port range skipped for capacity, got %q", reason)
+	}
+}
+
+func TestSchedulePoliciesResolvedIPv6(t *testing.T) {
+	policies, compiled := compileAll(t, "2001:db8::5")
+
+	node := &Node{ID: "linux-1", Metadata: NodeInfo{Backend: "ebpf", Capabilities: []string{"ipv4", "label-selectors"}}.Metadata()}
+	assignment := SchedulePolicies([]*Node{node}, policies, compiled)["linux-1"]
+
+	if reason := assignment.Skipped["web-to-db"]; !strings.Contains(reason, "ipv6") {
+		t.Errorf("expected web-to-db skipped for resolved ipv6 endpoint, got %q", reason)
+	}
+}
+
+func TestSchedulePoliciesUncompiled(t *testing.T) {
+	policies, compiled := compileAll(t, "10.0.2.1")
+	delete(compiled, "web-to-db")
+
+	assignment := SchedulePolicies([]*Node{{ID: "legacy-1"}}, policies, compiled)["legacy-1"]
+	if reason := assignment.Skipped["web-to-db"]; !strings.Contains(reason, "compiled") {
+		t.Errorf("expected web-to-db skipped as uncompiled, got %q", reason)
+	}
+}
+
+func TestSchedulePoliciesNodeSelector(t *testing.T) {
+	policies, compiled := compileAll(t, "10.0.2.1")
+	for i := range policies {
+		if policies[i].Metadata.Name == "web-to-db" {
+			policies[i].Spec.NodeSelector = map[string]string{"role": "app", "zone": "a"}
+		}
+	}
+
+	nodes := []*Node{
+		{ID: "app-1", Metadata: NodeInfo{Labels: map[string]string{"role": "app", "zone": "a"}}.Metadata()},
+		{ID: "edge-1", Metadata: NodeInfo{Labels: map[string]string{"role": "edge"}}.Metadata()},
+		{ID: "legacy-1"},
+	}
+	for _, node := range nodes {
+		delete(node.Metadata, MetadataCapabilities)
+	}
+	assignments := SchedulePolicies(nodes, policies, compiled)
+
+	if len(assignments["app-1"].Policies) != 3 {
+		t.Errorf("expected app-1 to receive every policy, got %+v", assignments["app-1"].Skipped)
+	}
+	for _, nodeID := range []string{"edge-1", "legacy-1"} {
+		assignment := assignments[nodeID]
+		if len(assignment.Policies) != 2 {
+			t.Errorf("%s: expected 2 policies, got %d", nodeID, len(assignment.Policies))
+		}
+		if reason := assignment.Skipped["web-to-db"]; reason != "nodeSelector role=app,zone=a does not match the node's labels" {
+			t.Errorf("%s: unexpected reason %q", nodeID, reason)
+		}
+	}
+}
+
+func TestSchedulePoliciesVersionSkew(t *testing.T) {
+	policies, compiled := compileAll(t, "10.0.2.1")
+	for i := range policies {
+		switch policies[i].Metadata.Name {
+		case "web-to-db":
+			policies[i].Spec.NodeSelector = map[string]string{"role": "app"}
+		case "v6-egress":
+			policies[i].Metadata.TTL = "4h"
+		}
+	}
+
+	current := NodeInfo{Version: "1.4.0", PolicySchema: policy.SchemaVersion, PolicyFeatures: policy.SupportedFeatures(), Labels: map[string]string{"role": "app"}}
+	legacy := NodeInfo{Version: "1.1.0", PolicySchema: 1, Labels: map[string]string{"role": "app"}}
+	nodes := []*Node{{ID: "new-1", Metadata: current.Metadata()}, {ID: "old-1", Metadata: legacy.Metadata()}}
+	for _, node := range nodes {
+		delete(node.Metadata, MetadataCapabilities)
+	}
+	assignments := SchedulePolicies(nodes, policies, compiled)
+
+	if got := assignments["new-1"]; len(got.Policies) != 3 || len(got.Downgraded) != 0 {
+		t.Errorf("expected new-1 to receive every policy unchanged, got %+v", got)
+	}
+	old := assignments["old-1"]
+	if len(old.Policies) != 2 {
+		t.Fatalf("expected 2 policies for old-1, got %+v", old.Skipped)
+	}
+	if reason := old.Skipped["v6-egress"]; reason != "requires unsupported features: expiry (node runs 1.1.0)" {
+		t.Errorf("unexpected reason %q", reason)
+	}
+	if features := old.Downgraded["web-to-db"]; len(features) != 1 || features[0] != policy.FeatureNodeSelector {
+		t.Errorf("expected web-to-db downgraded, got %v", old.Downgraded)
+	}
+	for _, p := range old.Policies {
+		if len(p.Spec.NodeSelector) > 0 {
+			t.Errorf("expected %s without nodeSelector for old-1", p.Metadata.Name)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package cluster
 import (
 	"context"
 	"time"
+
+	"ztap/pkg/clock"
 )
 
 // NodeState represents the operational state of a node in the cluster.
@@ -12,6 +14,7 @@ const (
 	StateHealthy   NodeState = "healthy"
 	StateUnhealthy NodeState = "unhealthy"
 	StateStopped   NodeState = "stopped"
+	StateDrained   NodeState = "drained" // Under maintenance; never elected leader
 )
 
 // Node represents a cluster member.
@@ -25,22 +28,40 @@ type Node struct {
 	Metadata map[string]string `json:"metadata"`  // Custom metadata (e.g., version, capabilities)
 }
 
+// Clone returns a deep copy of the node, or nil for a nil node.
+func (n *Node) Clone() *Node {
+	if n == nil {
+		return nil
+	}
+	clone := *n
+	if n.Metadata != nil {
+		clone.Metadata = make(map[string]string, len(n.Metadata))
+		for k, v := range n.Metadata {
+			clone.Metadata[k] = v
+		}
+	}
+	return &clone
+}
+
 // ClusterState represents the current state of the cluster.
 type ClusterState struct {
 	ID      string           `json:"id"`      // Cluster identifier
 	Leader  *Node            `json:"leader"`  // Current leader node
 	Nodes   map[string]*Node `json:"nodes"`   // All nodes keyed by ID
 	Version int64            `json:"version"` // State version for ordering updates
+	Epoch   int64            `json:"epoch"`   // Leadership epoch, incremented on each new leader
 }
 
 // LeaderElectionConfig holds configuration for leader election.
 type LeaderElectionConfig struct {
-	NodeID            string        // Identifier for this node
-	NodeAddress       string        // Network address of this node
-	HeartbeatInterval time.Duration // Interval for heartbeats (default: 1s)
-	ElectionTimeout   time.Duration // Timeout before triggering new election (default: 5s)
-	InitialLeadership time.Duration // Time before initial node can become leader (default: 3s)
-	MaxRetries        int           // Max retries for operations (default: 3)
+	NodeID            string            // Identifier for this node
+	NodeAddress       string            // Network address of this node
+	HeartbeatInterval time.Duration     // Interval for heartbeats (default: 1s)
+	ElectionTimeout   time.Duration     // Timeout before triggering new election (default: 5s)
+	InitialLeadership time.Duration     // Time before initial node can become leader (default: 3s)
+	MaxRetries        int               // Max retries for operations (default: 3)
+	NodeMetadata      map[string]string // Metadata advertised for this node (see NodeInfo)
+	Clock             clock.Clock       // Time source for heartbeats and timeouts (default: system clock)
 }
 
 // LeaderElection defines the interface for leader election backends.
@@ -54,26 +75,36 @@ type LeaderElection interface {
 	// IsLeader returns true if this node is the current leader.
 	IsLeader() bool
 
-	// GetLeader returns the current leader node, or nil if no leader is elected.
+	// GetLeader returns a copy of the current leader node, or nil if no leader is elected.
 	GetLeader() *Node
 
+	// FencingToken returns the token for the current leadership term, or
+	// ErrNotLeader if this node is not the leader. Attach it to enforcement
+	// actions so receivers can reject stale leaders.
+	FencingToken() (FencingToken, error)
+
 	// RegisterNode adds or updates a node in the cluster.
 	RegisterNode(node *Node) error
 
+	// SetNodeState updates the operational state of a node.
+	SetNodeState(nodeID string, state NodeState) error
+
 	// DeregisterNode removes a node from the cluster.
 	DeregisterNode(nodeID string) error
 
-	// GetNodes returns all known nodes in the cluster.
+	// GetNodes returns copies of all known nodes in the cluster.
 	GetNodes() []*Node
 
-	// GetNode returns a specific node by ID, or nil if not found.
+	// GetNode returns a copy of a specific node by ID, or nil if not found.
 	GetNode(nodeID string) *Node
 
 	// Watch returns a channel that receives notifications on cluster state changes.
+	// A slow consumer loses the oldest pending changes, visible as a gap in Seq.
 	// The channel is closed when the context is cancelled.
 	Watch(ctx context.Context) <-chan ClusterStateChange
 
 	// LeaderChanges returns a channel that receives notifications when leadership changes.
+	// A slow consumer receives only the latest leader.
 	// The channel is closed when the context is cancelled.
 	LeaderChanges(ctx context.Context) <-chan *Node
 }
@@ -84,6 +115,7 @@ type ClusterStateChange struct {
 	Node      *Node      // Node involved (may be nil for leader changes)
 	Timestamp time.Time  // When the change occurred
 	Error     error      // Error if change failed (may be nil)
+	Seq       int64      // Cluster state version; a gap means older changes were dropped
 }
 
 // ChangeType defines the type of cluster state change.
@@ -111,9 +143,19 @@ type PolicySync interface {
 
 // PolicyUpdate represents a distributed policy change.
 type PolicyUpdate struct {
-	PolicyName string    // Name of the policy
-	YAML       []byte    // Policy YAML content
-	Version    int64     // Version number for ordering
-	Source     string    // Node ID that initiated the update
-	Timestamp  time.Time // When the update occurred
+	PolicyName string       // Name of the policy
+	YAML       []byte       // Policy YAML content
+	Version    int64        // Version number for ordering; keeps increasing across removals
+	Token      FencingToken // Leadership term of the sender; stale epochs are rejected
+	Source     string       // Node ID that initiated the update
+	Principal  string       // User who initiated the update on the source node, if known
+	Timestamp  time.Time    // When the update occurred
+	// Schema and Features are the policy apiVersion and schema features the
+	// YAML uses (see policy.Requirements), so an agent that does not
+	// understand them withholds the policy instead of misreading it
+	Schema   int
+	Features []string
+	// Envelope holds the YAML of an update sealed by a PayloadSealer, which
+	// is then empty
+	Envelope *Envelope
 }
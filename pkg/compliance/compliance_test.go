@@ -0,0 +1,161 @@
+package compliance
+
+import (
+	"testing"
+	"time"
+
+	"ztap/pkg/cluster"
+	"ztap/pkg/policy"
+)
+
+const testPolicies = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: isolate-db
+spec:
+  podSelector:
+    matchLabels:
+      app: db
+`
+
+func loadTestPolicies(t *testing.T) []policy.NetworkPolicy {
+	t.Helper()
+	policies, err := policy.Parse([]byte(testPolicies))
+	if err != nil {
+		t.Fatalf("Failed to parse policies: %v", err)
+	}
+	return policies
+}
+
+func controlByID(t *testing.T, report *Report, id string) Control {
+	t.Helper()
+	for _, c := range report.Controls {
+		if c.ID == id {
+			return c
+		}
+	}
+	t.Fatalf("Control %s not found", id)
+	return Control{}
+}
+
+func TestGenerateCompliant(t *testing.T) {
+	now := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	evidence := Evidence{
+		Policies: loadTestPolicies(t),
+		Config: []cluster.ConfigEntry{
+			{Key: cluster.ConfigDefaultDeny, Value: "true", Version: 1, UpdatedBy: "node-1"},
+		},
+		AuditRecords: 42,
+		OldestAudit:  now.Add(-100 * 24 * time.Hour),
+		Retention:    90 * 24 * time.Hour,
+	}
+
+	report := Generate(evidence, "v1.0.0", now)
+
+	// Cluster default deny is configuration only, so SEG-2 cannot pass
+	if report.Summary.Passed != len(report.Controls)-1 || report.Summary.Warned != 1 {
+		for _, c := range report.Controls {
+			t.Logf("%s: %s (%s)", c.ID, c.Status, c.Statement)
+		}
+		t.Fatalf("Expected all but SEG-2 of %d controls to pass, got %+v", len(report.Controls), report.Summary)
+	}
+	if seg2 := controlByID(t, report, "ZTAP-SEG-2"); seg2.Status != StatusWarn {
+		t.Errorf("Expected SEG-2 warn from the unenforced config key, got %s", seg2.Status)
+	}
+
+	seg := controlByID(t, report, "ZTAP-SEG-1")
+	if len(seg.Evidence) != 2 || seg.Evidence[0] != "policy web-to-db segments app=web (1 egress rule(s))" {
+		t.Errorf("Unexpected segmentation evidence: %v", seg.Evidence)
+	}
+}
+
+func TestGenerateFindings(t *testing.T) {
+	now := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		evidence Evidence
+		control  string
+		want     Status
+	}{
+		{
+			name:     "no policies",
+			evidence: Evidence{},
+			control:  "ZTAP-SEG-1",
+			want:     StatusFail,
+		},
+		{
+			name:     "deny-all policy without cluster default deny",
+			evidence: Evidence{Policies: loadTestPolicies(t)},
+			control:  "ZTAP-SEG-2",
+			want:     StatusWarn,
+		},
+		{
+			name: "cluster default deny without enforcement",
+			evidence: Evidence{Config: []cluster.ConfigEntry{
+				{Key: cluster.ConfigDefaultDeny, Value: "true", UpdatedBy: "node-1"},
+			}},
+			control: "ZTAP-SEG-2",
+			want:    StatusWarn,
+		},
+		{
+			name: "default deny disabled",
+			evidence: Evidence{Config: []cluster.ConfigEntry{
+				{Key: cluster.ConfigDefaultDeny, Value: "false", UpdatedBy: "node-1"},
+			}},
+			control: "ZTAP-SEG-2",
+			want:    StatusFail,
+		},
+		{
+			name:     "no audit records",
+			evidence: Evidence{Retention: 90 * 24 * time.Hour},
+			control:  "ZTAP-AUD-1",
+			want:     StatusFail,
+		},
+		{
+			name: "short audit history",
+			evidence: Evidence{
+				AuditRecords: 3,
+				OldestAudit:  now.Add(-10 * 24 * time.Hour),
+				Retention:    90 * 24 * time.Hour,
+			},
+			control: "ZTAP-AUD-1",
+			want:    StatusWarn,
+		},
+		{
+			name: "unattributed config change",
+			evidence: Evidence{Config: []cluster.ConfigEntry{
+				{Key: cluster.ConfigLogLevel, Value: "debug", Version: 2},
+			}},
+			control: "ZTAP-AUD-2",
+			want:    StatusFail,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := Generate(tt.evidence, "dev", now)
+			if got := controlByID(t, report, tt.control); got.Status != tt.want {
+				t.Errorf("Expected %s to be %s, got %s (%s)", tt.control, tt.want, got.Status, got.Statement)
+			}
+		})
+	}
+}
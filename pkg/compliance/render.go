@@ -0,0 +1,105 @@
+package compliance
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteTable writes the report as a human-readable table
+func (r *Report) WriteTable(w io.Writer) error {
+	fmt.Fprintf(w, "Compliance report generated %s (ztap %s)\n", r.GeneratedAt.Format("2006-01-02 15:04 MST"), r.Version)
+	fmt.Fprintf(w, "Passed: %d  Warnings: %d  Failed: %d\n\n", r.Summary.Passed, r.Summary.Warned, r.Summary.Failed)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONTROL\tSTATUS\tFRAMEWORKS\tSTATEMENT")
+	for _, c := range r.Controls {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.ID, strings.ToUpper(string(c.Status)),
+			strings.Join(c.Frameworks, ", "), c.Statement)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, c := range r.Controls {
+		if len(c.Evidence) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s %s:\n", c.ID, c.Title)
+		for _, e := range c.Evidence {
+			fmt.Fprintf(w, "  - %s\n", e)
+		}
+	}
+
+	if r.Signature != "" {
+		fmt.Fprintf(w, "\nSigned with Ed25519 key %s\n", r.PublicKey)
+	}
+	return nil
+}
+
+// WriteJSON writes the report as indented JSON, the format Verify accepts
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+var htmlReport = template.Must(template.New("compliance").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ZTAP Compliance Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 12px; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; }
+.pass { color: #1a7f37; } .warn { color: #9a6700; } .fail { color: #cf222e; }
+code { word-break: break-all; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>ZTAP Compliance Report</h1>
+<p>Generated {{.GeneratedAt.Format "2006-01-02 15:04 MST"}} by ztap {{.Version}}</p>
+<p>Passed: {{.Summary.Passed}} &middot; Warnings: {{.Summary.Warned}} &middot; Failed: {{.Summary.Failed}}</p>
+<table>
+<tr><th>Control</th><th>Status</th><th>Frameworks</th><th>Statement</th><th>Evidence</th></tr>
+{{range .Controls}}<tr>
+<td>{{.ID}}<br>{{.Title}}</td>
+<td class="{{.Status}}">{{.Status}}</td>
+<td>{{range .Frameworks}}{{.}}<br>{{end}}</td>
+<td>{{.Statement}}</td>
+<td>{{range .Evidence}}{{.}}<br>{{end}}</td>
+</tr>
+{{end}}</table>
+{{if .Signature}}<h2>Signature</h2>
+<p>Ed25519 public key: <code>{{.PublicKey}}</code></p>
+<p>Signature: <code>{{.Signature}}</code></p>
+<p>Verify the JSON form of this report with <code>ztap report compliance verify</code>.</p>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes the report as a standalone, print-ready HTML page
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlReport.Execute(w, r)
+}
+
+// LoadReport reads a JSON report written by WriteJSON
+func LoadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse report %s: %w", path, err)
+	}
+	return &report, nil
+}
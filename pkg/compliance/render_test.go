@@ -0,0 +1,45 @@
+package compliance
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportFormats(t *testing.T) {
+	key, err := LoadOrCreateSigningKey(filepath.Join(t.TempDir(), "report-signing.key"))
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+
+	report := Generate(Evidence{Policies: loadTestPolicies(t)}, "dev", time.Now())
+	report.Sign(key)
+
+	var table bytes.Buffer
+	if err := report.WriteTable(&table); err != nil {
+		t.Fatalf("WriteTable failed: %v", err)
+	}
+	for _, want := range []string{"ZTAP-SEG-1", "PASS", "policy web-to-db segments app=web", "Signed with Ed25519 key"} {
+		if !strings.Contains(table.String(), want) {
+			t.Errorf("Table output missing %q:\n%s", want, table.String())
+		}
+	}
+
+	var html bytes.Buffer
+	if err := report.WriteHTML(&html); err != nil {
+		t.Fatalf("WriteHTML failed: %v", err)
+	}
+	for _, want := range []string{`<td class="fail">fail</td>`, "Ed25519 public key"} {
+		if !strings.Contains(html.String(), want) {
+			t.Errorf("HTML output missing %q", want)
+		}
+	}
+}
+
+func TestLoadReportMissing(t *testing.T) {
+	if _, err := LoadReport(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for missing report")
+	}
+}
@@ -0,0 +1,121 @@
+package compliance
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ErrInvalidSignature is returned when a report does not match its signature
+	ErrInvalidSignature = errors.New("compliance report signature is invalid")
+
+	// ErrUntrustedKey is returned when a report is signed by a key other than
+	// the trusted one
+	ErrUntrustedKey = errors.New("compliance report is not signed by the trusted key")
+
+	// ErrNoTrustedKey is returned when Verify has no key to trust
+	ErrNoTrustedKey = errors.New("no trusted public key to verify against")
+)
+
+const signingKeyType = "ZTAP REPORT SIGNING KEY"
+
+// LoadSigningKey reads the Ed25519 signing key at path
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != signingKeyType || len(block.Bytes) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key %s", path)
+	}
+	return ed25519.NewKeyFromSeed(block.Bytes), nil
+}
+
+// LoadOrCreateSigningKey reads the Ed25519 signing key at path, generating
+// and saving a new one if it does not exist
+func LoadOrCreateSigningKey(path string) (ed25519.PrivateKey, error) {
+	key, err := LoadSigningKey(path)
+	if err == nil {
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	_, key, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	pemData := pem.EncodeToMemory(&pem.Block{Type: signingKeyType, Bytes: key.Seed()})
+	if err := os.WriteFile(path, pemData, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save signing key: %w", err)
+	}
+	return key, nil
+}
+
+// ParsePublicKey decodes a base64 Ed25519 public key, the form embedded in
+// reports
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 public key %q", encoded)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// payload returns the signed bytes: the report as JSON without its signature
+func (r *Report) payload() ([]byte, error) {
+	unsigned := *r
+	unsigned.Signature = ""
+	unsigned.PublicKey = ""
+	return json.Marshal(unsigned)
+}
+
+// Sign signs the report with key, embedding the signature and public key
+func (r *Report) Sign(key ed25519.PrivateKey) error {
+	payload, err := r.payload()
+	if err != nil {
+		return err
+	}
+	r.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	r.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	return nil
+}
+
+// Verify checks the report's signature against trusted, the issuer's public
+// key obtained out of band. The embedded PublicKey is not trusted: anyone can
+// re-sign an edited report with their own key, so a report signed by any
+// other key is rejected with ErrUntrustedKey.
+func (r *Report) Verify(trusted ed25519.PublicKey) error {
+	if len(trusted) != ed25519.PublicKeySize {
+		return ErrNoTrustedKey
+	}
+	if r.PublicKey != base64.StdEncoding.EncodeToString(trusted) {
+		return fmt.Errorf("%w: report signed with %q", ErrUntrustedKey, r.PublicKey)
+	}
+	signature, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", ErrInvalidSignature)
+	}
+
+	payload, err := r.payload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(trusted, payload, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package compliance
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadOrCreateSigningKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "report-signing.key")
+
+	key, err := LoadOrCreateSigningKey(path)
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Key was not saved: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected key permissions 0600, got %v", info.Mode().Perm())
+	}
+
+	loaded, err := LoadOrCreateSigningKey(path)
+	if err != nil {
+		t.Fatalf("Failed to load key: %v", err)
+	}
+	if !loaded.Equal(key) {
+		t.Error("Loaded key differs from the generated key")
+	}
+
+	os.WriteFile(path, []byte("not a key"), 0600)
+	if _, err := LoadOrCreateSigningKey(path); err == nil {
+		t.Error("Expected error for invalid key file")
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	key, err := LoadOrCreateSigningKey(filepath.Join(t.TempDir(), "report-signing.key"))
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+
+	report := Generate(Evidence{}, "dev", time.Now())
+	if err := report.Sign(key); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	trusted := key.Public().(ed25519.PublicKey)
+	if err := report.Verify(trusted); err != nil {
+		t.Fatalf("Verify failed on untouched report: %v", err)
+	}
+
+	// Round trip through the JSON artifact
+	path := filepath.Join(t.TempDir(), "report.json")
+	file, _ := os.Create(path)
+	report.WriteJSON(file)
+	file.Close()
+
+	loaded, err := LoadReport(path)
+	if err != nil {
+		t.Fatalf("LoadReport failed: %v", err)
+	}
+	if err := loaded.Verify(trusted); err != nil {
+		t.Fatalf("Verify failed after JSON round trip: %v", err)
+	}
+
+	loaded.Controls[0].Status = StatusPass
+	if err := loaded.Verify(trusted); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for tampered report, got %v", err)
+	}
+
+	// A tampered report re-signed with another key is self-consistent, but
+	// not signed by the trusted key
+	_, forger, _ := ed25519.GenerateKey(nil)
+	if err := loaded.Sign(forger); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+	if err := loaded.Verify(trusted); !errors.Is(err, ErrUntrustedKey) {
+		t.Errorf("Expected ErrUntrustedKey for re-signed report, got %v", err)
+	}
+	if err := loaded.Verify(nil); !errors.Is(err, ErrNoTrustedKey) {
+		t.Errorf("Expected ErrNoTrustedKey without a trust anchor, got %v", err)
+	}
+
+	unsigned := Generate(Evidence{}, "dev", time.Now())
+	if err := unsigned.Verify(trusted); !errors.Is(err, ErrUntrustedKey) {
+		t.Errorf("Expected ErrUntrustedKey for unsigned report, got %v", err)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key, _ := LoadOrCreateSigningKey(filepath.Join(t.TempDir(), "report-signing.key"))
+	report := Generate(Evidence{}, "dev", time.Now())
+	report.Sign(key)
+
+	parsed, err := ParsePublicKey(report.PublicKey)
+	if err != nil {
+		t.Fatalf("ParsePublicKey failed: %v", err)
+	}
+	if err := report.Verify(parsed); err != nil {
+		t.Errorf("Verify with parsed key failed: %v", err)
+	}
+
+	if _, err := ParsePublicKey("not-a-key"); err == nil {
+		t.Error("Expected error for malformed key")
+	}
+}
@@ -0,0 +1,69 @@
+// Package configfile reads config.yaml, whose sections configure ZTAP's
+// subsystems. The file is read and parsed once; each subsystem then takes
+// its own section with Section and checks it, in its package's LoadConfig.
+package configfile
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Validator is a section's configuration, checked once decoded
+type Validator interface {
+	Validate() error
+}
+
+// File is a parsed config.yaml
+type File struct {
+	path     string
+	sections map[string]interface{}
+}
+
+// Load reads the config.yaml at path. A missing file has no sections.
+func Load(path string) (*File, error) {
+	file := &File{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &file.sections); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Read reads the config.yaml at path and returns the section load, a
+// package's LoadConfig, takes from it. Callers needing several sections Load
+// the file once instead.
+func Read[T any](path string, load func(*File) (T, error)) (T, error) {
+	file, err := Load(path)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return load(file)
+}
+
+// Section decodes the section name into out, then validates it. Settings
+// the section leaves unset, or all of them if there is no such section, keep
+// their value in out, so out may hold defaults.
+func (f *File) Section(name string, out Validator) error {
+	if raw, ok := f.sections[name]; ok {
+		data, err := yaml.Marshal(raw)
+		if err == nil {
+			err = yaml.Unmarshal(data, out)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse config file %s: %s: %w", f.path, name, err)
+		}
+	}
+	if err := out.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package configfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testSection struct {
+	Size    int           `yaml:"size"`
+	Timeout time.Duration `yaml:"timeout"`
+	Tags    []string      `yaml:"tags"`
+}
+
+func (s testSection) Validate() error {
+	if s.Size < 0 {
+		return errors.New("test.size must not be negative")
+	}
+	return nil
+}
+
+func TestSection(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "test:\n  timeout: 5s\n  tags: [a, b]\nother:\n  size: -1\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// Unset settings keep their defaults
+	section := testSection{Size: 10}
+	if err := file.Section("test", &section); err != nil {
+		t.Fatalf("Section failed: %v", err)
+	}
+	if section.Size != 10 || section.Timeout != 5*time.Second || len(section.Tags) != 2 {
+		t.Errorf("Unexpected section %+v", section)
+	}
+
+	var other testSection
+	if err := file.Section("other", &other); err == nil || !strings.HasPrefix(err.Error(), path+": ") {
+		t.Errorf("Expected the validation error with the path, got %v", err)
+	}
+	missing := testSection{Size: 3}
+	if err := file.Section("missing", &missing); err != nil || missing.Size != 3 {
+		t.Errorf("Expected a missing section to keep the defaults, got %+v, %v", missing, err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	file, err := Load(filepath.Join(dir, "missing.yaml"))
+	if err != nil {
+		t.Fatalf("Expected a missing file to load, got %v", err)
+	}
+	var section testSection
+	if err := file.Section("test", &section); err != nil || section.Size != 0 {
+		t.Errorf("Expected no sections in a missing file, got %+v, %v", section, err)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("test: [unclosed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Expected invalid YAML to be rejected")
+	}
+}
@@ -0,0 +1,230 @@
+// Package coverage cross-references discovered workloads with the selectors
+// of policies to show which workloads no policy protects yet, the main
+// measure of progress in a zero-trust rollout.
+package coverage
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"ztap/pkg/policy"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Status is how a workload is covered
+type Status string
+
+const (
+	StatusEnforced    Status = "enforced"     // Selected by at least one enforced policy
+	StatusMonitorOnly Status = "monitor-only" // Selected only while enforcement is suspended
+	StatusUncovered   Status = "uncovered"    // Selected by no policy
+)
+
+// Workload is a discovered service or cloud resource
+type Workload struct {
+	Name   string            `yaml:"name" json:"name"`
+	IP     string            `yaml:"ip" json:"ip"`
+	Labels map[string]string `yaml:"labels" json:"labels,omitempty"`
+	Source string            `yaml:"-" json:"source"` // e.g. discovery, aws, file
+}
+
+// LoadWorkloads reads a YAML list of workloads (name, ip, labels), for
+// inventories that are not registered with service discovery
+func LoadWorkloads(filename string) ([]Workload, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var workloads []Workload
+	if err := yaml.Unmarshal(data, &workloads); err != nil {
+		return nil, fmt.Errorf("invalid workloads file %s: %w", filename, err)
+	}
+	for i := range workloads {
+		if workloads[i].Name == "" && workloads[i].IP == "" {
+			return nil, fmt.Errorf("workload %d in %s has neither name nor ip", i+1, filename)
+		}
+		workloads[i].Source = "file"
+	}
+	return workloads, nil
+}
+
+// Options control an analysis
+type Options struct {
+	// Monitor reports that enforcement is suspended (break-glass), so every
+	// policy only monitors
+	Monitor bool
+	// GroupBy are the label keys workloads are grouped by, e.g. app
+	GroupBy []string
+	// Now decides which policies have expired (default: time.Now)
+	Now time.Time
+}
+
+// WorkloadCoverage is the coverage of one workload
+type WorkloadCoverage struct {
+	Workload
+	Status   Status   `json:"status"`
+	Policies []string `json:"policies,omitempty"` // Policies whose podSelector matches
+}
+
+// Group summarizes the workloads sharing the values of the GroupBy labels
+type Group struct {
+	Key         string `json:"key"` // e.g. "app=web,env=prod"
+	Total       int    `json:"total"`
+	Enforced    int    `json:"enforced"`
+	MonitorOnly int    `json:"monitor_only"`
+	Uncovered   int    `json:"uncovered"`
+}
+
+// Percent returns the share of the group's workloads that are enforced
+func (g Group) Percent() float64 {
+	return percent(g.Enforced, g.Total)
+}
+
+// Report is the result of an analysis
+type Report struct {
+	GeneratedAt time.Time          `json:"generated_at"`
+	Monitor     bool               `json:"monitor"`
+	Expired     []string           `json:"expired_policies,omitempty"` // Ignored because they have expired
+	Total       int                `json:"total"`
+	Enforced    int                `json:"enforced"`
+	MonitorOnly int                `json:"monitor_only"`
+	Uncovered   int                `json:"uncovered"`
+	Workloads   []WorkloadCoverage `json:"workloads"`
+	Groups      []Group            `json:"groups,omitempty"`
+}
+
+// Percent returns the share of all workloads that are enforced
+func (r *Report) Percent() float64 {
+	return percent(r.Enforced, r.Total)
+}
+
+// Analyze matches every workload against the podSelector of every
+// unexpired policy. An empty podSelector selects every workload.
+func Analyze(policies []policy.NetworkPolicy, workloads []Workload, opts Options) *Report {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	report := &Report{GeneratedAt: now, Monitor: opts.Monitor}
+
+	var active []policy.NetworkPolicy
+	for _, p := range policies {
+		// A ttl counts from first enforcement, so only expiresAt can have passed
+		if expiresAt, ok := p.Expiry(now); ok && !expiresAt.After(now) {
+			report.Expired = append(report.Expired, p.Metadata.Name)
+			continue
+		}
+		active = append(active, p)
+	}
+
+	groups := make(map[string]*Group)
+	for _, w := range workloads {
+		wc := WorkloadCoverage{Workload: w, Status: StatusUncovered}
+		for _, p := range active {
+			if selects(p.Spec.PodSelector.MatchLabels, w.Labels) {
+				wc.Policies = append(wc.Policies, p.Metadata.Name)
+			}
+		}
+		if len(wc.Policies) > 0 {
+			wc.Status = StatusEnforced
+			if opts.Monitor {
+				wc.Status = StatusMonitorOnly
+			}
+		}
+		report.Workloads = append(report.Workloads, wc)
+
+		var g *Group
+		if len(opts.GroupBy) > 0 {
+			key := groupKey(w.Labels, opts.GroupBy)
+			if g = groups[key]; g == nil {
+				g = &Group{Key: key}
+				groups[key] = g
+			}
+			g.Total++
+		}
+		report.Total++
+		switch wc.Status {
+		case StatusEnforced:
+			report.Enforced++
+			if g != nil {
+				g.Enforced++
+			}
+		case StatusMonitorOnly:
+			report.MonitorOnly++
+			if g != nil {
+				g.MonitorOnly++
+			}
+		default:
+			report.Uncovered++
+			if g != nil {
+				g.Uncovered++
+			}
+		}
+	}
+
+	sort.Slice(report.Workloads, func(i, j int) bool {
+		a, b := report.Workloads[i], report.Workloads[j]
+		if a.Status != b.Status {
+			return statusOrder(a.Status) < statusOrder(b.Status)
+		}
+		return a.Name < b.Name
+	})
+	for _, g := range groups {
+		report.Groups = append(report.Groups, *g)
+	}
+	// Least covered groups first
+	sort.Slice(report.Groups, func(i, j int) bool {
+		a, b := report.Groups[i], report.Groups[j]
+		if a.Percent() != b.Percent() {
+			return a.Percent() < b.Percent()
+		}
+		return a.Key < b.Key
+	})
+	return report
+}
+
+// selects reports whether a podSelector matches labels
+func selects(selector, labels map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// groupKey joins the values of keys in labels, with <none> for a missing one
+func groupKey(labels map[string]string, keys []string) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		value, ok := labels[key]
+		if !ok {
+			value = "<none>"
+		}
+		parts[i] = key + "=" + value
+	}
+	return strings.Join(parts, ",")
+}
+
+// statusOrder sorts uncovered workloads first
+func statusOrder(s Status) int {
+	switch s {
+	case StatusUncovered:
+		return 0
+	case StatusMonitorOnly:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
@@ -0,0 +1,187 @@
+package coverage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ztap/pkg/policy"
+)
+
+const policies = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-egress
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/8
+      ports:
+        - protocol: TCP
+          port: 443
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: prod-web
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+      env: prod
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/8
+      ports:
+        - protocol: TCP
+          port: 8443
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: old-db-exception
+  expiresAt: "2025-01-01T00:00:00Z"
+spec:
+  podSelector:
+    matchLabels:
+      app: db
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/8
+      ports:
+        - protocol: TCP
+          port: 5432
+`
+
+var now = time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func workloads() []Workload {
+	return []Workload{
+		{Name: "web-1", IP: "10.0.1.1", Labels: map[string]string{"app": "web", "env": "prod"}},
+		{Name: "web-2", IP: "10.0.1.2", Labels: map[string]string{"app": "web", "env": "dev"}},
+		{Name: "db-1", IP: "10.0.2.1", Labels: map[string]string{"app": "db", "env": "prod"}},
+		{Name: "batch", IP: "10.0.3.1"},
+	}
+}
+
+func parse(t *testing.T) []policy.NetworkPolicy {
+	t.Helper()
+	p, err := policy.Parse([]byte(policies))
+	if err != nil {
+		t.Fatalf("Failed to parse policies: %v", err)
+	}
+	return p
+}
+
+func TestAnalyze(t *testing.T) {
+	report := Analyze(parse(t), workloads(), Options{GroupBy: []string{"app"}, Now: now})
+
+	if report.Total != 4 || report.Enforced != 2 || report.Uncovered != 2 || report.MonitorOnly != 0 {
+		t.Fatalf("Unexpected totals: %+v", report)
+	}
+	if report.Percent() != 50 {
+		t.Errorf("Expected 50%% coverage, got %v", report.Percent())
+	}
+	if len(report.Expired) != 1 || report.Expired[0] != "old-db-exception" {
+		t.Errorf("Expected the expired exception to be ignored, got %v", report.Expired)
+	}
+
+	// Uncovered workloads sort first
+	if report.Workloads[0].Name != "batch" || report.Workloads[1].Name != "db-1" {
+		t.Errorf("Expected uncovered workloads first, got %v, %v", report.Workloads[0].Name, report.Workloads[1].Name)
+	}
+	for _, w := range report.Workloads {
+		if w.Name == "web-1" && len(w.Policies) != 2 {
+			t.Errorf("Expected web-1 to be selected by both web policies, got %v", w.Policies)
+		}
+	}
+
+	want := map[string][2]int{ // total, uncovered
+		"app=<none>": {1, 1},
+		"app=db":     {1, 1},
+		"app=web":    {2, 0},
+	}
+	if len(report.Groups) != len(want) {
+		t.Fatalf("Expected %d groups, got %+v", len(want), report.Groups)
+	}
+	for _, g := range report.Groups {
+		if w := want[g.Key]; g.Total != w[0] || g.Uncovered != w[1] {
+			t.Errorf("Group %s: expected total %d uncovered %d, got %+v", g.Key, w[0], w[1], g)
+		}
+	}
+	if report.Groups[len(report.Groups)-1].Key != "app=web" {
+		t.Errorf("Expected the fully covered group last, got %+v", report.Groups)
+	}
+}
+
+func TestAnalyzeMonitor(t *testing.T) {
+	report := Analyze(parse(t), workloads(), Options{Monitor: true, Now: now})
+	if report.Enforced != 0 || report.MonitorOnly != 2 || report.Uncovered != 2 {
+		t.Errorf("Expected selected workloads to be monitor-only, got %+v", report)
+	}
+	if len(report.Groups) != 0 {
+		t.Errorf("Expected no groups without GroupBy, got %+v", report.Groups)
+	}
+}
+
+func TestAnalyzeEmptySelector(t *testing.T) {
+	doc := `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: baseline
+spec:
+  podSelector: {}
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/8
+      ports:
+        - protocol: UDP
+          port: 53
+`
+	p, err := policy.Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	if report := Analyze(p, workloads(), Options{Now: now}); report.Uncovered != 0 {
+		t.Errorf("Expected an empty podSelector to cover every workload, got %+v", report)
+	}
+}
+
+func TestLoadWorkloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workloads.yaml")
+	data := `
+- name: web-1
+  ip: 10.0.1.1
+  labels:
+    app: web
+- name: legacy
+  ip: 10.0.9.9
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	workloads, err := LoadWorkloads(path)
+	if err != nil {
+		t.Fatalf("LoadWorkloads failed: %v", err)
+	}
+	if len(workloads) != 2 || workloads[0].Labels["app"] != "web" || workloads[1].Source != "file" {
+		t.Errorf("Unexpected workloads: %+v", workloads)
+	}
+
+	if err := os.WriteFile(path, []byte("- labels: {app: web}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadWorkloads(path); err == nil {
+		t.Error("Expected error for a workload without name or ip")
+	}
+}
@@ -0,0 +1,170 @@
+package discovery
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"ztap/pkg/clock"
+	"ztap/pkg/events"
+)
+
+// Attribution names the workload that held an IP
+type Attribution struct {
+	Service string            `json:"service"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// String formats the attribution as the service name
+func (a Attribution) String() string {
+	return a.Service
+}
+
+// assignment is an IP held by a service from From until To, zero while it
+// still holds it
+type assignment struct {
+	Attribution
+	From, To time.Time
+}
+
+func (a assignment) covers(at time.Time) bool {
+	return !at.Before(a.From) && (a.To.IsZero() || at.Before(a.To))
+}
+
+// Resolver attributes an IP that no service registration covers, e.g. from
+// cloud inventory
+type Resolver func(ip string) (Attribution, bool)
+
+type resolved struct {
+	attribution Attribution
+	ok          bool
+	expiresAt   time.Time
+}
+
+// Attributor maps IPs in flows back to the services that held them. It
+// follows service registrations (ServiceChanged events) and keeps every
+// assignment, so a flow is attributed to the service that held its IP at
+// the time, even after the IP was recycled to another service.
+type Attributor struct {
+	mu       sync.RWMutex
+	history  map[string][]assignment // By IP, oldest first
+	resolver Resolver
+	ttl      time.Duration
+	cache    map[string]resolved
+	clock    clock.Clock
+}
+
+// NewAttributor creates an attributor that falls back to resolver, if not
+// nil, for IPs no registration covers, caching its answers for ttl
+func NewAttributor(resolver Resolver, ttl time.Duration) *Attributor {
+	return NewAttributorWithClock(resolver, ttl, clock.Real)
+}
+
+// NewAttributorWithClock creates an attributor expiring resolver answers by
+// clk, e.g. a clock.Fake in tests
+func NewAttributorWithClock(resolver Resolver, ttl time.Duration, clk clock.Clock) *Attributor {
+	return &Attributor{
+		history:  make(map[string][]assignment),
+		resolver: resolver,
+		ttl:      ttl,
+		cache:    make(map[string]resolved),
+		clock:    clock.OrReal(clk),
+	}
+}
+
+// Observe applies a service registration or removal made at a time
+func (a *Attributor) Observe(change events.ServiceChanged, at time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// A service holds one IP: re-registering moves it, removing releases it
+	for ip, assignments := range a.history {
+		last := &assignments[len(assignments)-1]
+		if last.Service == change.Name && last.To.IsZero() && (change.Removed || ip != change.IP) {
+			last.To = at
+		}
+	}
+	if change.Removed || change.IP == "" {
+		return
+	}
+
+	assignments := a.history[change.IP]
+	if n := len(assignments); n > 0 && assignments[n-1].To.IsZero() {
+		if assignments[n-1].Service == change.Name {
+			assignments[n-1].Labels = change.Labels
+			return
+		}
+		// The IP was recycled to another service
+		assignments[n-1].To = at
+	}
+	a.history[change.IP] = append(assignments, assignment{
+		Attribution: Attribution{Service: change.Name, Labels: change.Labels},
+		From:        at,
+	})
+}
+
+// Load applies journaled events in order, e.g. from events.Journal.History
+func (a *Attributor) Load(history []events.Event) {
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Timestamp.Before(history[j].Timestamp) })
+	for _, event := range history {
+		if change, ok := event.Data.(events.ServiceChanged); ok {
+			a.Observe(change, event.Timestamp)
+		}
+	}
+}
+
+// Record observes the registrations published on bus until stop is called
+func (a *Attributor) Record(bus *events.Bus) (stop func()) {
+	return bus.SubscribeFunc(func(event events.Event) {
+		if change, ok := event.Data.(events.ServiceChanged); ok {
+			a.Observe(change, event.Timestamp)
+		}
+	}, events.TopicServiceChanged)
+}
+
+// Attribute returns the workload that held ip at a time, or now if at is
+// zero
+func (a *Attributor) Attribute(ip string, at time.Time) (Attribution, bool) {
+	if at.IsZero() {
+		at = a.clock.Now()
+	}
+
+	a.mu.RLock()
+	assignments := a.history[ip]
+	for i := len(assignments) - 1; i >= 0; i-- {
+		if assignments[i].covers(at) {
+			a.mu.RUnlock()
+			return assignments[i].Attribution, true
+		}
+	}
+	cached, hit := a.cache[ip]
+	a.mu.RUnlock()
+
+	if a.resolver == nil {
+		return Attribution{}, false
+	}
+	now := a.clock.Now()
+	if hit && now.Before(cached.expiresAt) {
+		return cached.attribution, cached.ok
+	}
+	attribution, ok := a.resolver(ip)
+	a.mu.Lock()
+	a.cache[ip] = resolved{attribution: attribution, ok: ok, expiresAt: now.Add(a.ttl)}
+	a.mu.Unlock()
+	return attribution, ok
+}
+
+// Name returns the service that held ip at a time, or "" if unknown
+func (a *Attributor) Name(ip string, at time.Time) string {
+	attribution, _ := a.Attribute(ip, at)
+	return attribution.Service
+}
+
+// FormatIP returns ip with the service that held it at a time, e.g.
+// "10.0.1.1 (web-1)", or ip alone if unknown
+func (a *Attributor) FormatIP(ip string, at time.Time) string {
+	if name := a.Name(ip, at); name != "" {
+		return ip + " (" + name + ")"
+	}
+	return ip
+}
@@ -0,0 +1,97 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"ztap/pkg/clock"
+	"ztap/pkg/events"
+)
+
+func TestAttributorRecycledIP(t *testing.T) {
+	start := time.Date(2025, 10, 9, 10, 0, 0, 0, time.UTC)
+	a := NewAttributor(nil, 0)
+
+	a.Load([]events.Event{
+		{Topic: events.TopicServiceChanged, Timestamp: start, Data: events.ServiceChanged{Name: "web-1", IP: "10.0.1.1", Labels: map[string]string{"app": "web"}}},
+		{Topic: events.TopicServiceChanged, Timestamp: start.Add(2 * time.Hour), Data: events.ServiceChanged{Name: "web-1", IP: "10.0.1.1", Removed: true}},
+		// The IP is recycled to a database an hour after web-1 left
+		{Topic: events.TopicServiceChanged, Timestamp: start.Add(3 * time.Hour), Data: events.ServiceChanged{Name: "db-1", IP: "10.0.1.1", Labels: map[string]string{"app": "database"}}},
+	})
+
+	for _, tc := range []struct {
+		at   time.Time
+		want string
+	}{
+		{start.Add(-time.Minute), ""},
+		{start.Add(time.Hour), "web-1"},
+		{start.Add(150 * time.Minute), ""},
+		{start.Add(4 * time.Hour), "db-1"},
+	} {
+		if got := a.Name("10.0.1.1", tc.at); got != tc.want {
+			t.Errorf("Name at %v = %q, expected %q", tc.at, got, tc.want)
+		}
+	}
+	if got := a.FormatIP("10.0.1.1", start.Add(time.Hour)); got != "10.0.1.1 (web-1)" {
+		t.Errorf("FormatIP = %q", got)
+	}
+	if attribution, _ := a.Attribute("10.0.1.1", start.Add(4*time.Hour)); attribution.Labels["app"] != "database" {
+		t.Errorf("Expected database labels, got %v", attribution.Labels)
+	}
+}
+
+func TestAttributorMovedService(t *testing.T) {
+	start := time.Date(2025, 10, 9, 10, 0, 0, 0, time.UTC)
+	a := NewAttributor(nil, 0)
+	a.Observe(events.ServiceChanged{Name: "api", IP: "10.0.2.1"}, start)
+	a.Observe(events.ServiceChanged{Name: "api", IP: "10.0.2.2"}, start.Add(time.Hour))
+	// Taking over an IP ends the previous holder's assignment
+	a.Observe(events.ServiceChanged{Name: "cache", IP: "10.0.2.2"}, start.Add(2*time.Hour))
+
+	if got := a.Name("10.0.2.1", start.Add(90*time.Minute)); got != "" {
+		t.Errorf("Expected the old IP released after the move, got %q", got)
+	}
+	if got := a.Name("10.0.2.2", start.Add(90*time.Minute)); got != "api" {
+		t.Errorf("Expected api on its new IP, got %q", got)
+	}
+	if got := a.Name("10.0.2.2", start.Add(3*time.Hour)); got != "cache" {
+		t.Errorf("Expected cache after the takeover, got %q", got)
+	}
+}
+
+func TestAttributorResolverCache(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 10, 9, 10, 0, 0, 0, time.UTC))
+	calls := 0
+	a := NewAttributorWithClock(func(ip string) (Attribution, bool) {
+		calls++
+		return Attribution{Service: "i-0abc"}, ip == "172.31.0.5"
+	}, time.Minute, clk)
+
+	for range 3 {
+		if got := a.Name("172.31.0.5", time.Time{}); got != "i-0abc" {
+			t.Fatalf("Expected the resolver's answer, got %q", got)
+		}
+	}
+	a.Name("172.31.0.9", time.Time{})
+	a.Name("172.31.0.9", time.Time{})
+	if calls != 2 {
+		t.Errorf("Expected answers and misses cached, got %d resolver calls", calls)
+	}
+
+	clk.Advance(2 * time.Minute)
+	a.Name("172.31.0.5", time.Time{})
+	if calls != 3 {
+		t.Errorf("Expected an expired answer resolved again, got %d calls", calls)
+	}
+}
+
+func TestAttributorRecord(t *testing.T) {
+	bus := events.NewBus()
+	a := NewAttributor(nil, 0)
+	defer a.Record(bus)()
+
+	bus.Publish(events.TopicServiceChanged, events.ServiceChanged{Name: "web-1", IP: "10.0.1.1"})
+	if got := a.Name("10.0.1.1", time.Time{}); got != "web-1" {
+		t.Errorf("Expected the published registration observed, got %q", got)
+	}
+}
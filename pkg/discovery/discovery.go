@@ -8,6 +8,11 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"ztap/pkg/clock"
+	"ztap/pkg/events"
+	"ztap/pkg/faults"
+	"ztap/pkg/metrics"
 )
 
 // ServiceDiscovery interface for different backends
@@ -26,38 +31,63 @@ type Service struct {
 	UpdatedAt time.Time         `json:"updated_at"`
 }
 
+// WatchUpdate is a watch notification carrying the full set of matching IPs.
+// Seq increases by one per registry change; a jump means intermediate states
+// were coalesced because the consumer fell behind.
+type WatchUpdate struct {
+	Seq uint64
+	IPs []string
+}
+
+// watcher is a registered watch with a single-slot, latest-state-wins buffer
+type watcher struct {
+	labels  map[string]string
+	updates chan WatchUpdate
+}
+
 // InMemoryDiscovery is a simple in-memory service discovery for testing
 type InMemoryDiscovery struct {
 	services map[string]*Service
 	mu       sync.RWMutex
-	watchers []chan []string
+	watchers []*watcher
+	seq      uint64
+	dropped  uint64
 }
 
 // NewInMemoryDiscovery creates a new in-memory discovery service
 func NewInMemoryDiscovery() *InMemoryDiscovery {
 	return &InMemoryDiscovery{
 		services: make(map[string]*Service),
-		watchers: make([]chan []string, 0),
+		watchers: make([]*watcher, 0),
 	}
 }
 
 // ResolveLabels finds all IPs matching the given labels
 func (d *InMemoryDiscovery) ResolveLabels(labels map[string]string) ([]string, error) {
+	if err := faults.Inject(faults.DiscoveryResolve); err != nil {
+		return nil, err
+	}
+
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 
+	ips := d.matchingIPs(labels)
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no services found matching labels: %v", labels)
+	}
+
+	return ips, nil
+}
+
+// matchingIPs returns the IPs of services matching labels (requires holding mu lock)
+func (d *InMemoryDiscovery) matchingIPs(labels map[string]string) []string {
 	ips := make([]string, 0)
 	for _, service := range d.services {
 		if matchLabels(service.Labels, labels) {
 			ips = append(ips, service.IP)
 		}
 	}
-
-	if len(ips) == 0 {
-		return nil, fmt.Errorf("no services found matching labels: %v", labels)
-	}
-
-	return ips, nil
+	return ips
 }
 
 // RegisterService adds a service to the discovery
@@ -79,6 +109,11 @@ func (d *InMemoryDiscovery) RegisterService(name string, ip string, labels map[s
 
 	// Notify watchers
 	d.notifyWatchers()
+	events.Default().Publish(events.TopicServiceChanged, events.ServiceChanged{
+		Name:   name,
+		IP:     ip,
+		Labels: labels,
+	})
 
 	return nil
 }
@@ -88,23 +123,68 @@ func (d *InMemoryDiscovery) DeregisterService(name string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	service, exists := d.services[name]
+	if !exists {
+		return nil
+	}
+
 	delete(d.services, name)
 	d.notifyWatchers()
+	events.Default().Publish(events.TopicServiceChanged, events.ServiceChanged{
+		Name:    name,
+		IP:      service.IP,
+		Labels:  service.Labels,
+		Removed: true,
+	})
 	return nil
 }
 
-// Watch returns a channel that receives IP updates when services change
+// Watch returns a channel that receives IP updates when services change.
+// A consumer that falls behind receives only the latest state.
 func (d *InMemoryDiscovery) Watch(ctx context.Context, labels map[string]string) (<-chan []string, error) {
-	ch := make(chan []string, 10)
+	updates, err := d.WatchUpdates(ctx, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep draining updates while the consumer is busy so the value handed
+	// over is always the latest state, never one received before it blocked
+	ch := make(chan []string)
+	go func() {
+		defer close(ch)
+		var pending []string
+		var out chan []string // nil while nothing is pending
+		for {
+			select {
+			case update, ok := <-updates:
+				if !ok {
+					return
+				}
+				pending, out = update.IPs, ch
+			case out <- pending:
+				out = nil
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// WatchUpdates is like Watch but exposes sequence numbers so consumers can
+// detect coalesced updates. The initial state is delivered immediately.
+func (d *InMemoryDiscovery) WatchUpdates(ctx context.Context, labels map[string]string) (<-chan WatchUpdate, error) {
+	w := &watcher{
+		labels:  labels,
+		updates: make(chan WatchUpdate, 1),
+	}
 
 	d.mu.Lock()
-	d.watchers = append(d.watchers, ch)
+	w.updates <- WatchUpdate{Seq: d.seq, IPs: d.matchingIPs(labels)}
+	d.watchers = append(d.watchers, w)
 	d.mu.Unlock()
 
-	// Send initial state
-	ips, _ := d.ResolveLabels(labels)
-	ch <- ips
-
 	// Handle context cancellation
 	go func() {
 		<-ctx.Done()
@@ -112,32 +192,41 @@ func (d *InMemoryDiscovery) Watch(ctx context.Context, labels map[string]string)
 		defer d.mu.Unlock()
 
 		// Remove watcher
-		for i, w := range d.watchers {
-			if w == ch {
+		for i, existing := range d.watchers {
+			if existing == w {
 				d.watchers = append(d.watchers[:i], d.watchers[i+1:]...)
 				break
 			}
 		}
-		close(ch)
+		close(w.updates)
 	}()
 
-	return ch, nil
+	return w.updates, nil
 }
 
-// notifyWatchers sends updates to all watchers
+// DroppedNotifications returns how many watch updates were superseded before
+// their consumer received them
+func (d *InMemoryDiscovery) DroppedNotifications() uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.dropped
+}
+
+// notifyWatchers sends the new state to all watchers (requires holding mu lock).
+// An unread update is replaced rather than blocking the registry.
 func (d *InMemoryDiscovery) notifyWatchers() {
-	for _, ch := range d.watchers {
-		// Get all IPs
-		ips := make([]string, 0, len(d.services))
-		for _, service := range d.services {
-			ips = append(ips, service.IP)
-		}
+	d.seq++
+	for _, w := range d.watchers {
+		update := WatchUpdate{Seq: d.seq, IPs: d.matchingIPs(w.labels)}
 
 		select {
-		case ch <- ips:
+		case <-w.updates:
+			d.dropped++
+			metrics.GetCollector().IncWatchDropped("discovery")
 		default:
-			// Skip if channel is full
 		}
+		// Only notifyWatchers sends and it holds mu, so the slot is now free
+		w.updates <- update
 	}
 }
 
@@ -153,6 +242,20 @@ func (d *InMemoryDiscovery) ListServices() []*Service {
 	return services
 }
 
+// LabelsOf returns the labels of the service registered with ip, which rule
+// conditions are evaluated against (see policy.LabelSource)
+func (d *InMemoryDiscovery) LabelsOf(ip string) (map[string]string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, service := range d.services {
+		if service.IP == ip {
+			return service.Labels, true
+		}
+	}
+	return nil, false
+}
+
 // matchLabels checks if service labels match the selector
 func matchLabels(serviceLabels, selector map[string]string) bool {
 	for key, value := range selector {
@@ -175,6 +278,10 @@ func NewDNSDiscovery(domain string) *DNSDiscovery {
 
 // ResolveLabels converts labels to DNS query and resolves
 func (d *DNSDiscovery) ResolveLabels(labels map[string]string) ([]string, error) {
+	if err := faults.Inject(faults.DiscoveryResolve); err != nil {
+		return nil, err
+	}
+
 	// Build DNS query from labels
 	// Format: app-value.tier-value.domain
 	parts := make([]string, 0, len(labels))
@@ -278,24 +385,35 @@ func (k *K8sDiscovery) Watch(ctx context.Context, labels map[string]string) (<-c
 	return nil, fmt.Errorf("Kubernetes discovery not yet implemented")
 }
 
+// Cache stores resolved IPs for CacheDiscovery. Implementations treat their
+// own failures as misses, since the backend is always the source of truth.
+type Cache interface {
+	// Get returns the unexpired IPs stored under key
+	Get(key string) ([]string, bool)
+	// Set stores ips under key for ttl
+	Set(key string, ips []string, ttl time.Duration)
+	// Clear removes all entries
+	Clear()
+}
+
 // CacheDiscovery wraps another discovery with caching
 type CacheDiscovery struct {
 	backend ServiceDiscovery
-	cache   map[string]cacheEntry
-	mu      sync.RWMutex
+	cache   Cache
 	ttl     time.Duration
 }
 
-type cacheEntry struct {
-	ips       []string
-	expiresAt time.Time
+// NewCacheDiscovery creates a caching wrapper with an in-process cache
+func NewCacheDiscovery(backend ServiceDiscovery, ttl time.Duration) *CacheDiscovery {
+	return NewCacheDiscoveryWithCache(backend, ttl, NewMemoryCache())
 }
 
-// NewCacheDiscovery creates a caching wrapper
-func NewCacheDiscovery(backend ServiceDiscovery, ttl time.Duration) *CacheDiscovery {
+// NewCacheDiscoveryWithCache creates a caching wrapper storing entries in
+// cache, e.g. one shared by several API servers
+func NewCacheDiscoveryWithCache(backend ServiceDiscovery, ttl time.Duration, cache Cache) *CacheDiscovery {
 	return &CacheDiscovery{
 		backend: backend,
-		cache:   make(map[string]cacheEntry),
+		cache:   cache,
 		ttl:     ttl,
 	}
 }
@@ -306,14 +424,9 @@ func (c *CacheDiscovery) ResolveLabels(labels map[string]string) ([]string, erro
 	keyBytes, _ := json.Marshal(labels)
 	key := string(keyBytes)
 
-	c.mu.RLock()
-	if entry, exists := c.cache[key]; exists {
-		if time.Now().Before(entry.expiresAt) {
-			c.mu.RUnlock()
-			return entry.ips, nil
-		}
+	if ips, ok := c.cache.Get(key); ok {
+		return ips, nil
 	}
-	c.mu.RUnlock()
 
 	// Cache miss or expired, fetch from backend
 	ips, err := c.backend.ResolveLabels(labels)
@@ -321,14 +434,7 @@ func (c *CacheDiscovery) ResolveLabels(labels map[string]string) ([]string, erro
 		return nil, err
 	}
 
-	// Update cache
-	c.mu.Lock()
-	c.cache[key] = cacheEntry{
-		ips:       ips,
-		expiresAt: time.Now().Add(c.ttl),
-	}
-	c.mu.Unlock()
-
+	c.cache.Set(key, ips, c.ttl)
 	return ips, nil
 }
 
@@ -347,9 +453,65 @@ func (c *CacheDiscovery) Watch(ctx context.Context, labels map[string]string) (<
 	return c.backend.Watch(ctx, labels)
 }
 
+// LabelsOf delegates to backend, if it knows the labels of IPs
+func (c *CacheDiscovery) LabelsOf(ip string) (map[string]string, bool) {
+	if labeled, ok := c.backend.(interface {
+		LabelsOf(ip string) (map[string]string, bool)
+	}); ok {
+		return labeled.LabelsOf(ip)
+	}
+	return nil, false
+}
+
 // ClearCache removes all cached entries
 func (c *CacheDiscovery) ClearCache() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.cache = make(map[string]cacheEntry)
+	c.cache.Clear()
+}
+
+// MemoryCache is an in-process Cache
+type MemoryCache struct {
+	entries map[string]cacheEntry
+	mu      sync.RWMutex
+	clock   clock.Clock
+}
+
+type cacheEntry struct {
+	ips       []string
+	expiresAt time.Time
+}
+
+// NewMemoryCache creates an empty in-process cache
+func NewMemoryCache() *MemoryCache {
+	return NewMemoryCacheWithClock(clock.Real)
+}
+
+// NewMemoryCacheWithClock creates an empty in-process cache expiring entries
+// by clk, e.g. a clock.Fake in tests
+func NewMemoryCacheWithClock(clk clock.Clock) *MemoryCache {
+	return &MemoryCache{entries: make(map[string]cacheEntry), clock: clock.OrReal(clk)}
+}
+
+// Get returns the unexpired IPs stored under key
+func (m *MemoryCache) Get(key string) ([]string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, exists := m.entries[key]
+	if !exists || !m.clock.Now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	return entry.ips, true
+}
+
+// Set stores ips under key for ttl
+func (m *MemoryCache) Set(key string, ips []string, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = cacheEntry{ips: ips, expiresAt: m.clock.Now().Add(ttl)}
+}
+
+// Clear removes all entries
+func (m *MemoryCache) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = make(map[string]cacheEntry)
 }
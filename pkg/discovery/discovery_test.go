@@ -2,8 +2,11 @@ package discovery
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
+
+	"ztap/pkg/clock"
 )
 
 func TestInMemoryDiscovery_RegisterAndResolve(t *testing.T) {
@@ -208,6 +211,85 @@ func TestInMemoryDiscovery_Watch(t *testing.T) {
 	}
 }
 
+func TestInMemoryDiscovery_WatchSlowConsumer(t *testing.T) {
+	disc := NewInMemoryDiscovery()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := disc.Watch(ctx, map[string]string{"app": "web"})
+	if err != nil {
+		t.Fatalf("Failed to start watch: %v", err)
+	}
+
+	// The consumer does not read while the registry changes; the first value
+	// it reads must not be the initial state held from before
+	time.Sleep(20 * time.Millisecond) // Let the adapter pick up the initial state
+	for i := 1; i <= 3; i++ {
+		disc.RegisterService(fmt.Sprintf("web-%d", i), fmt.Sprintf("10.0.1.%d", i), map[string]string{"app": "web"})
+	}
+
+	deadline := time.After(1 * time.Second)
+	for {
+		select {
+		case ips := <-ch:
+			if len(ips) == 3 {
+				return
+			}
+			if len(ips) == 0 {
+				t.Fatal("Received stale initial state after later changes")
+			}
+		case <-deadline:
+			t.Fatal("Timeout waiting for latest state")
+		}
+	}
+}
+
+func TestInMemoryDiscovery_WatchUpdatesCoalesce(t *testing.T) {
+	disc := NewInMemoryDiscovery()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := disc.WatchUpdates(ctx, map[string]string{"app": "web"})
+	if err != nil {
+		t.Fatalf("Failed to start watch: %v", err)
+	}
+
+	initial := <-updates
+	if initial.Seq != 0 || len(initial.IPs) != 0 {
+		t.Fatalf("Expected empty initial state at seq 0, got %+v", initial)
+	}
+
+	// A slow consumer must not block registrations or miss the final state
+	for i := 1; i <= 5; i++ {
+		disc.RegisterService(fmt.Sprintf("web-%d", i), fmt.Sprintf("10.0.1.%d", i), map[string]string{"app": "web"})
+	}
+	disc.RegisterService("db-1", "10.0.2.1", map[string]string{"app": "db"})
+
+	select {
+	case update := <-updates:
+		if update.Seq != 6 {
+			t.Errorf("Expected latest seq 6, got %d", update.Seq)
+		}
+		if len(update.IPs) != 5 {
+			t.Errorf("Expected only the 5 matching IPs, got %v", update.IPs)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for coalesced update")
+	}
+
+	select {
+	case update := <-updates:
+		t.Fatalf("Expected no further updates, got %+v", update)
+	default:
+	}
+
+	if dropped := disc.DroppedNotifications(); dropped != 5 {
+		t.Errorf("Expected 5 dropped notifications, got %d", dropped)
+	}
+}
+
 func TestDNSDiscovery(t *testing.T) {
 	disc := NewDNSDiscovery("example.com")
 
@@ -227,7 +309,8 @@ func TestCacheDiscovery(t *testing.T) {
 	backend := NewInMemoryDiscovery()
 	backend.RegisterService("web-1", "10.0.1.1", map[string]string{"app": "web"})
 
-	cache := NewCacheDiscovery(backend, 1*time.Second)
+	clk := clock.NewFake(time.Now())
+	cache := NewCacheDiscoveryWithCache(backend, 1*time.Second, NewMemoryCacheWithClock(clk))
 
 	// First resolution (cache miss)
 	ips1, err := cache.ResolveLabels(map[string]string{"app": "web"})
@@ -258,8 +341,8 @@ func TestCacheDiscovery(t *testing.T) {
 		t.Errorf("Expected cached result with 1 IP, got %d", len(ips3))
 	}
 
-	// Wait for cache to expire
-	time.Sleep(1100 * time.Millisecond)
+	// Cache expires after its TTL
+	clk.Advance(time.Second)
 
 	// Now gets fresh result (2 IPs)
 	ips4, err := cache.ResolveLabels(map[string]string{"app": "web"})
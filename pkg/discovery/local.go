@@ -0,0 +1,323 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels of local listeners registered into discovery, so policies can
+// select what a host serves, e.g. ztap:process=postgres
+const (
+	LabelHost     = "ztap:host"
+	LabelProcess  = "ztap:process"
+	LabelBinary   = "ztap:binary"
+	LabelProtocol = "ztap:protocol"
+	LabelPort     = "ztap:port"
+)
+
+// Listener is a socket on the local host accepting TCP connections or UDP
+// datagrams, with the process owning it
+type Listener struct {
+	Protocol string `json:"protocol"` // TCP or UDP
+	Address  string `json:"address"`  // Local address, e.g. 0.0.0.0, :: or 127.0.0.1
+	Port     int    `json:"port"`
+	PID      int    `json:"pid,omitempty"`     // 0 if the owner is not visible (e.g. without root)
+	Process  string `json:"process,omitempty"` // Process name, e.g. postgres
+	Binary   string `json:"binary,omitempty"`  // Executable path, if visible
+}
+
+// String describes the listener, e.g. "tcp 0.0.0.0:5432 postgres (pid 812)"
+func (l Listener) String() string {
+	s := fmt.Sprintf("%s %s", strings.ToLower(l.Protocol), net.JoinHostPort(l.Address, strconv.Itoa(l.Port)))
+	if l.Process != "" {
+		s += " " + l.Process
+	}
+	if l.PID > 0 {
+		s += fmt.Sprintf(" (pid %d)", l.PID)
+	}
+	return s
+}
+
+// Loopback reports whether the listener only accepts local connections
+func (l Listener) Loopback() bool {
+	ip := net.ParseIP(l.Address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// CollectListeners returns the listening sockets of the local host with the
+// processes owning them, sorted by protocol, port and address. Linux reads
+// /proc, macOS runs lsof and ps, and Windows runs PowerShell's
+// Get-NetTCPConnection, Get-NetUDPEndpoint and Get-Process. Owners of other
+// users' sockets are only visible with root (Administrator) privileges;
+// their listeners are returned without a process.
+func CollectListeners(ctx context.Context) ([]Listener, error) {
+	listeners, err := collectListeners(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect listening sockets: %w", err)
+	}
+	sortListeners(listeners)
+	return listeners, nil
+}
+
+// LocalServices returns the services to register into discovery for
+// listeners on host, one per process, protocol and port. Listeners bound to a
+// wildcard address are registered with hostIP; loopback-only listeners serve
+// no other host and are left out. Each service is labeled with the host,
+// process, binary, protocol and port.
+func LocalServices(host, hostIP string, listeners []Listener) []Service {
+	seen := make(map[string]bool)
+	var services []Service
+	for _, l := range listeners {
+		if l.Loopback() {
+			continue
+		}
+		ip := l.Address
+		if parsed := net.ParseIP(ip); parsed == nil || parsed.IsUnspecified() {
+			ip = hostIP
+		}
+		process := l.Process
+		if process == "" {
+			process = "unknown"
+		}
+		name := fmt.Sprintf("%s/%s/%s-%d", host, process, strings.ToLower(l.Protocol), l.Port)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		labels := map[string]string{
+			LabelHost:     host,
+			LabelProcess:  process,
+			LabelProtocol: strings.ToLower(l.Protocol),
+			LabelPort:     strconv.Itoa(l.Port),
+		}
+		if l.Binary != "" {
+			labels[LabelBinary] = l.Binary
+		}
+		services = append(services, Service{Name: name, IP: ip, Labels: labels})
+	}
+	return services
+}
+
+// HostIP returns the first global unicast address of the host's interfaces,
+// preferring IPv4, for services listening on a wildcard address
+func HostIP() (string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
+	}
+	var v6 string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+		if v6 == "" {
+			v6 = ipNet.IP.String()
+		}
+	}
+	if v6 == "" {
+		return "", fmt.Errorf("no global unicast address found")
+	}
+	return v6, nil
+}
+
+// sortListeners orders listeners by protocol, port and address
+func sortListeners(listeners []Listener) {
+	sort.Slice(listeners, func(i, j int) bool {
+		a, b := listeners[i], listeners[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Address < b.Address
+	})
+}
+
+// procProcess is the name and executable of a process
+type procProcess struct {
+	name   string
+	binary string
+}
+
+// withProcesses fills in the process of each listener from processes, by PID
+func withProcesses(listeners []Listener, processes map[int]procProcess) []Listener {
+	for i := range listeners {
+		if p, ok := processes[listeners[i].PID]; ok {
+			listeners[i].Process, listeners[i].Binary = p.name, p.binary
+		}
+	}
+	return listeners
+}
+
+// procSocket is a listening socket read from /proc/net, owned by the process
+// holding its inode
+type procSocket struct {
+	listener Listener
+	inode    string
+}
+
+// parseProcNet parses /proc/net/{tcp,tcp6,udp,udp6}, returning the listening
+// TCP sockets (state 0A) or the unconnected UDP sockets (state 07 with no
+// remote address)
+func parseProcNet(data []byte, protocol string) ([]procSocket, error) {
+	var sockets []procSocket
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 0; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if line == 0 || len(fields) < 10 {
+			continue // Header
+		}
+		local, remote, state, inode := fields[1], fields[2], fields[3], fields[9]
+		switch {
+		case protocol == "TCP" && state != "0A":
+			continue
+		case protocol == "UDP" && (state != "07" || strings.Trim(remote, "0:") != ""):
+			continue
+		}
+		address, port, err := parseProcAddress(local)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line+1, err)
+		}
+		sockets = append(sockets, procSocket{Listener{Protocol: protocol, Address: address, Port: port}, inode})
+	}
+	return sockets, scanner.Err()
+}
+
+// parseProcAddress decodes a /proc/net address, e.g. 0100007F:1538 for
+// 127.0.0.1:5432. The IP is stored as 32-bit words in host (little-endian)
+// byte order, the port in big-endian hex.
+func parseProcAddress(s string) (string, int, error) {
+	hexIP, hexPort, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("invalid address %q", s)
+	}
+	raw, err := hex.DecodeString(hexIP)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, fmt.Errorf("invalid address %q", s)
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %q", s)
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(raw[i:]))
+	}
+	return ip.String(), int(port), nil
+}
+
+// parseLsof parses the output of lsof -nP -FpcPn for listening sockets:
+// p (PID) and c (command) lines start a process, P (protocol) and n (name,
+// e.g. *:80 or [::1]:631) lines describe its sockets. Connected UDP sockets
+// (names with ->) are skipped.
+func parseLsof(data []byte) ([]Listener, error) {
+	var listeners []Listener
+	var pid int
+	var command, protocol string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		value := line[1:]
+		switch line[0] {
+		case 'p':
+			var err error
+			if pid, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("invalid PID %q", value)
+			}
+			command, protocol = "", ""
+		case 'c':
+			command = value
+		case 'P':
+			protocol = value
+		case 'n':
+			if strings.Contains(value, "->") || (protocol != "TCP" && protocol != "UDP") {
+				continue
+			}
+			host, portString, err := net.SplitHostPort(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid socket name %q", value)
+			}
+			port, err := strconv.Atoi(portString)
+			if err != nil {
+				continue // e.g. *:* for an unbound UDP socket
+			}
+			if host == "*" {
+				host = "0.0.0.0"
+			}
+			listeners = append(listeners, Listener{Protocol: protocol, Address: host, Port: port, PID: pid, Process: command})
+		}
+	}
+	return listeners, scanner.Err()
+}
+
+// parsePS parses the output of ps -axo pid=,comm=, which on macOS lists the
+// executable path of each process
+func parsePS(data []byte) map[int]procProcess {
+	processes := make(map[int]procProcess)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		pidString, binary, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		pid, err := strconv.Atoi(pidString)
+		if !ok || err != nil {
+			continue
+		}
+		binary = strings.TrimSpace(binary)
+		name := binary
+		if i := strings.LastIndex(binary, "/"); i >= 0 {
+			name = binary[i+1:]
+		}
+		processes[pid] = procProcess{name, binary}
+	}
+	return processes
+}
+
+// parseWindowsInventory parses the tab-separated output of windowsInventory:
+// "TCP|UDP <address> <port> <pid>" lines for sockets and
+// "PROC <pid> <name> <path>" lines for processes
+func parseWindowsInventory(data []byte) ([]Listener, error) {
+	var listeners []Listener
+	processes := make(map[int]procProcess)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimRight(scanner.Text(), "\r"), "\t")
+		switch {
+		case len(fields) == 4 && fields[0] == "PROC":
+			pid, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid PID %q", fields[1])
+			}
+			processes[pid] = procProcess{fields[2], fields[3]}
+		case len(fields) == 4 && (fields[0] == "TCP" || fields[0] == "UDP"):
+			port, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid port %q", fields[2])
+			}
+			pid, err := strconv.Atoi(fields[3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid PID %q", fields[3])
+			}
+			listeners = append(listeners, Listener{Protocol: fields[0], Address: fields[1], Port: port, PID: pid})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return withProcesses(listeners, processes), nil
+}
@@ -0,0 +1,31 @@
+//go:build darwin
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// collectListeners lists listening TCP and bound UDP sockets with lsof, and
+// the executables of their owners with ps
+func collectListeners(ctx context.Context) ([]Listener, error) {
+	// lsof exits 1 when it finds nothing
+	out, err := exec.CommandContext(ctx, "lsof", "-nP", "-iTCP", "-sTCP:LISTEN", "-iUDP", "-FpcPn").Output()
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		return nil, fmt.Errorf("lsof: %w", err)
+	}
+	listeners, err := parseLsof(out)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err = exec.CommandContext(ctx, "ps", "-axo", "pid=,comm=").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ps: %w", err)
+	}
+	return withProcesses(listeners, parsePS(out)), nil
+}
@@ -0,0 +1,96 @@
+//go:build linux
+
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// collectListeners reads the listening sockets from /proc/net and finds
+// their owners through the socket inodes in /proc/<pid>/fd
+func collectListeners(ctx context.Context) ([]Listener, error) {
+	var sockets []procSocket
+	for _, table := range []struct{ file, protocol string }{
+		{"tcp", "TCP"}, {"tcp6", "TCP"}, {"udp", "UDP"}, {"udp6", "UDP"},
+	} {
+		data, err := os.ReadFile(filepath.Join("/proc/net", table.file))
+		if os.IsNotExist(err) {
+			continue // IPv6 disabled
+		}
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parseProcNet(data, table.protocol)
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, parsed...)
+	}
+
+	owners, err := socketOwners(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listeners := make([]Listener, len(sockets))
+	for i, socket := range sockets {
+		listeners[i] = socket.listener
+		listeners[i].PID = owners[socket.inode]
+	}
+	return withProcesses(listeners, procProcesses(owners)), nil
+}
+
+// socketOwners maps socket inodes to the PID holding them. File descriptors
+// of processes that cannot be read (other users' without root) are skipped.
+func socketOwners(ctx context.Context) (map[string]int, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[string]int)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			if inode, ok := strings.CutPrefix(target, "socket:["); ok {
+				owners[strings.TrimSuffix(inode, "]")] = pid
+			}
+		}
+	}
+	return owners, nil
+}
+
+// procProcesses reads the name and executable of each owner from /proc
+func procProcesses(owners map[string]int) map[int]procProcess {
+	processes := make(map[int]procProcess)
+	for _, pid := range owners {
+		if _, done := processes[pid]; done {
+			continue
+		}
+		dir := filepath.Join("/proc", strconv.Itoa(pid))
+		comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+		if err != nil {
+			continue
+		}
+		binary, _ := os.Readlink(filepath.Join(dir, "exe"))
+		processes[pid] = procProcess{strings.TrimSpace(string(comm)), binary}
+	}
+	return processes
+}
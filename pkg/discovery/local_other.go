@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// collectListeners is not supported on this platform
+func collectListeners(ctx context.Context) ([]Listener, error) {
+	return nil, fmt.Errorf("not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,93 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProcNet(t *testing.T) {
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1538 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 23442 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 23443 1 0000000000000000 100 0 0 10 0
+   2: 0502000A:1538 0702000A:D431 01 00000000:00000000 00:00000000 00000000   999        0 23444 1 0000000000000000 20 4 30 10 -1
+`
+	sockets, err := parseProcNet([]byte(tcp), "TCP")
+	if err != nil {
+		t.Fatalf("parseProcNet failed: %v", err)
+	}
+	want := []procSocket{
+		{Listener{Protocol: "TCP", Address: "0.0.0.0", Port: 5432}, "23442"},
+		{Listener{Protocol: "TCP", Address: "127.0.0.1", Port: 3306}, "23443"},
+	}
+	if !reflect.DeepEqual(sockets, want) {
+		t.Errorf("Expected %+v, got %+v", want, sockets)
+	}
+
+	udp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  0: 00000000000000000000000001000000:0035 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 31337 2 0000000000000000 0
+  1: 00000000000000000000000000000000:D431 B80D0120000000000000000001000000:0035 01 00000000:00000000 00:00000000 00000000     0        0 31338 2 0000000000000000 0
+`
+	sockets, err = parseProcNet([]byte(udp6), "UDP")
+	if err != nil {
+		t.Fatalf("parseProcNet failed: %v", err)
+	}
+	if len(sockets) != 1 || sockets[0].listener.Address != "::1" || sockets[0].listener.Port != 53 {
+		t.Errorf("Expected unconnected UDP socket on [::1]:53 only, got %+v", sockets)
+	}
+}
+
+func TestParseLsof(t *testing.T) {
+	out := "p812\ncpostgres\nPTCP\nn*:5432\nPTCP\nn[::1]:5432\np90\ncmDNSRespond\nPUDP\nn*:5353\nPUDP\nn10.0.2.5:60123->10.0.2.1:53\n"
+	listeners, err := parseLsof([]byte(out))
+	if err != nil {
+		t.Fatalf("parseLsof failed: %v", err)
+	}
+	listeners = withProcesses(listeners, parsePS([]byte("  812 /opt/homebrew/bin/postgres\n   90 /usr/sbin/mDNSResponder\n")))
+	sortListeners(listeners)
+
+	want := []Listener{
+		{Protocol: "TCP", Address: "0.0.0.0", Port: 5432, PID: 812, Process: "postgres", Binary: "/opt/homebrew/bin/postgres"},
+		{Protocol: "TCP", Address: "::1", Port: 5432, PID: 812, Process: "postgres", Binary: "/opt/homebrew/bin/postgres"},
+		{Protocol: "UDP", Address: "0.0.0.0", Port: 5353, PID: 90, Process: "mDNSResponder", Binary: "/usr/sbin/mDNSResponder"},
+	}
+	if !reflect.DeepEqual(listeners, want) {
+		t.Errorf("Expected %+v, got %+v", want, listeners)
+	}
+}
+
+func TestParseWindowsInventory(t *testing.T) {
+	out := "TCP\t0.0.0.0\t3389\t1040\r\nUDP\t::\t500\t4\r\nPROC\t1040\tsvchost\tC:\\Windows\\System32\\svchost.exe\r\nPROC\t4\tSystem\t\r\n"
+	listeners, err := parseWindowsInventory([]byte(out))
+	if err != nil {
+		t.Fatalf("parseWindowsInventory failed: %v", err)
+	}
+	want := []Listener{
+		{Protocol: "TCP", Address: "0.0.0.0", Port: 3389, PID: 1040, Process: "svchost", Binary: `C:\Windows\System32\svchost.exe`},
+		{Protocol: "UDP", Address: "::", Port: 500, PID: 4, Process: "System"},
+	}
+	if !reflect.DeepEqual(listeners, want) {
+		t.Errorf("Expected %+v, got %+v", want, listeners)
+	}
+}
+
+func TestLocalServices(t *testing.T) {
+	listeners := []Listener{
+		{Protocol: "TCP", Address: "0.0.0.0", Port: 5432, PID: 812, Process: "postgres", Binary: "/usr/bin/postgres"},
+		{Protocol: "TCP", Address: "::", Port: 5432, PID: 812, Process: "postgres", Binary: "/usr/bin/postgres"},
+		{Protocol: "TCP", Address: "127.0.0.1", Port: 6379, PID: 90, Process: "redis-server"},
+		{Protocol: "UDP", Address: "10.0.2.5", Port: 53},
+	}
+	services := LocalServices("db-1", "10.0.2.5", listeners)
+
+	want := []Service{
+		{Name: "db-1/postgres/tcp-5432", IP: "10.0.2.5", Labels: map[string]string{
+			LabelHost: "db-1", LabelProcess: "postgres", LabelBinary: "/usr/bin/postgres", LabelProtocol: "tcp", LabelPort: "5432",
+		}},
+		{Name: "db-1/unknown/udp-53", IP: "10.0.2.5", Labels: map[string]string{
+			LabelHost: "db-1", LabelProcess: "unknown", LabelProtocol: "udp", LabelPort: "53",
+		}},
+	}
+	if !reflect.DeepEqual(services, want) {
+		t.Errorf("Expected %+v, got %+v", want, services)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// windowsInventory prints listening sockets and processes as tab-separated
+// lines (see parseWindowsInventory). The cmdlets report states and addresses
+// the same way in every locale, unlike netstat.
+const windowsInventory = `
+Get-NetTCPConnection -State Listen | ForEach-Object { @('TCP', $_.LocalAddress, $_.LocalPort, $_.OwningProcess) -join [char]9 }
+Get-NetUDPEndpoint | ForEach-Object { @('UDP', $_.LocalAddress, $_.LocalPort, $_.OwningProcess) -join [char]9 }
+Get-Process | ForEach-Object { @('PROC', $_.Id, $_.ProcessName, $_.Path) -join [char]9 }
+`
+
+// collectListeners lists listening sockets and their owners with PowerShell
+func collectListeners(ctx context.Context) ([]Listener, error) {
+	out, err := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", windowsInventory).Output()
+	if err != nil {
+		return nil, fmt.Errorf("powershell: %w", err)
+	}
+	return parseWindowsInventory(out)
+}
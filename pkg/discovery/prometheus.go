@@ -0,0 +1,112 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Meta labels of Prometheus targets exported from discovery. Prometheus
+// drops __meta_ labels after relabeling, so keep the ones wanted with
+// relabel_configs, e.g. a labelmap of __meta_ztap_label_(.+).
+const (
+	MetaService     = "__meta_ztap_service"
+	MetaLabelPrefix = "__meta_ztap_label_"
+)
+
+// TargetGroup is a group of Prometheus scrape targets sharing labels, the
+// format of both file_sd files and HTTP SD responses
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// PrometheusTargets returns a target group per service, sorted by name, so
+// scrape targets follow the same inventory policies are resolved against.
+// Targets are scraped on port, or if 0 on the service's ztap:port label.
+// Services with neither are left out, since Prometheus would scrape them on
+// a default port, and their names returned as skipped. Each group is
+// labeled with the service name and its labels, their names changed to
+// valid Prometheus label names.
+func PrometheusTargets(services []*Service, port int) (groups []TargetGroup, skipped []string) {
+	sorted := append([]*Service(nil), services...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	groups = make([]TargetGroup, 0, len(sorted))
+	for _, service := range sorted {
+		address, ok := target(service, port)
+		if !ok {
+			skipped = append(skipped, service.Name)
+			continue
+		}
+		labels := map[string]string{MetaService: service.Name}
+		keys := make([]string, 0, len(service.Labels))
+		for key := range service.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			labels[MetaLabelPrefix+labelName(key)] = service.Labels[key]
+		}
+		groups = append(groups, TargetGroup{
+			Targets: []string{address},
+			Labels:  labels,
+		})
+	}
+	return groups, skipped
+}
+
+// target returns the address to scrape service at, or false if it has no
+// valid port
+func target(service *Service, port int) (string, bool) {
+	if port == 0 {
+		port, _ = strconv.Atoi(service.Labels[LabelPort])
+	}
+	if port < 1 || port > 65535 {
+		return "", false
+	}
+	return net.JoinHostPort(service.IP, strconv.Itoa(port)), true
+}
+
+// labelName replaces the characters of key not allowed in a Prometheus
+// label name with underscores, e.g. ztap:account becomes ztap_account
+func labelName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// WriteTargetFile writes groups to a file_sd file at path. The file is
+// replaced atomically, so Prometheus never reads a partial one.
+func WriteTargetFile(path string, groups []TargetGroup) error {
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write targets: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write targets: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write targets: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write targets: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
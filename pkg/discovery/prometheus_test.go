@@ -0,0 +1,73 @@
+package discovery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPrometheusTargets(t *testing.T) {
+	services := []*Service{
+		{Name: "web-1", IP: "10.0.1.1", Labels: map[string]string{"app": "web", "ztap:account": "123456789012"}},
+		{Name: "db-1", IP: "10.0.2.1", Labels: map[string]string{"app": "db", LabelPort: "5432"}},
+		{Name: "cache", IP: "fd00::1", Labels: map[string]string{LabelPort: "http"}},
+	}
+
+	// Services without a valid port are skipped rather than scraped on a
+	// default one
+	groups, skipped := PrometheusTargets(services, 0)
+	expected := []TargetGroup{
+		{Targets: []string{"10.0.2.1:5432"}, Labels: map[string]string{
+			MetaService: "db-1", "__meta_ztap_label_app": "db", "__meta_ztap_label_ztap_port": "5432",
+		}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, groups)
+	}
+	if !reflect.DeepEqual(skipped, []string{"cache", "web-1"}) {
+		t.Errorf("Expected cache and web-1 skipped, got %v", skipped)
+	}
+
+	// An explicit port wins over the ztap:port label
+	groups, skipped = PrometheusTargets(services, 9100)
+	if len(groups) != 3 || len(skipped) != 0 {
+		t.Fatalf("Expected every service exported, got %+v, skipped %v", groups, skipped)
+	}
+	if !reflect.DeepEqual(groups[2].Labels, map[string]string{
+		MetaService: "web-1", "__meta_ztap_label_app": "web", "__meta_ztap_label_ztap_account": "123456789012",
+	}) {
+		t.Errorf("Unexpected labels %v", groups[2].Labels)
+	}
+	for i, want := range []string{"[fd00::1]:9100", "10.0.2.1:9100", "10.0.1.1:9100"} {
+		if groups[i].Targets[0] != want {
+			t.Errorf("Expected target %s, got %s", want, groups[i].Targets[0])
+		}
+	}
+}
+
+func TestWriteTargetFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ztap.json")
+	groups, _ := PrometheusTargets([]*Service{{Name: "web-1", IP: "10.0.1.1"}}, 9100)
+	if err := WriteTargetFile(path, groups); err != nil {
+		t.Fatalf("WriteTargetFile failed: %v", err)
+	}
+	// An empty inventory is written as an empty list, not null
+	empty, _ := PrometheusTargets(nil, 9100)
+	if err := WriteTargetFile(path, empty); err != nil {
+		t.Fatalf("WriteTargetFile failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	var read []TargetGroup
+	if err := json.Unmarshal(data, &read); err != nil || read == nil || len(read) != 0 {
+		t.Errorf("Expected an empty list, got %s (%v)", data, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("Expected no temporary files left, got %d entries", len(entries))
+	}
+}
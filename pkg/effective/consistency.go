@@ -0,0 +1,327 @@
+package effective
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"ztap/pkg/cloud"
+	"ztap/pkg/enforcer"
+	"ztap/pkg/policy"
+)
+
+// Divergence kinds
+const (
+	// DivergenceCloudOnly: a Security Group allows egress the host does not
+	DivergenceCloudOnly = "cloud-only"
+	// DivergenceHostOnly: the host allows egress a Security Group does not
+	DivergenceHostOnly = "host-only"
+)
+
+// Divergence is egress one side of a hybrid workload allows and the other
+// does not: the host (eBPF map or pf anchor) against one Security Group
+type Divergence struct {
+	Kind          string `json:"kind"`
+	Account       string `json:"account,omitempty"`
+	Region        string `json:"region"`
+	SecurityGroup string `json:"securityGroup"`
+	Traffic       string `json:"traffic"`          // e.g. "tcp/443 -> 10.0.0.0/8"
+	RuleID        string `json:"ruleId,omitempty"` // Security Group rule, for cloud-only
+	// HostRule is the host rule allowing the traffic (host-only), or one
+	// explicitly blocking it (cloud-only)
+	HostRule string `json:"hostRule,omitempty"`
+	// Partial is set when the other side allows part of the traffic
+	Partial bool `json:"partial,omitempty"`
+}
+
+// String describes the divergence for CLI output
+func (d Divergence) String() string {
+	extent := "none"
+	if d.Partial {
+		extent = "only part"
+	}
+	if d.Kind == DivergenceHostOnly {
+		return fmt.Sprintf("host allows %s (%s) but %s allows %s of it", d.Traffic, d.HostRule, d.SecurityGroup, extent)
+	}
+	msg := fmt.Sprintf("%s allows %s (%s) but the host allows %s of it", d.SecurityGroup, d.Traffic, d.RuleID, extent)
+	if d.HostRule != "" {
+		msg += "; blocked by " + d.HostRule
+	}
+	return msg
+}
+
+// span is the egress traffic a rule matches. Empty protocol, nil network
+// and zero ports match anything.
+type span struct {
+	protocol string
+	network  *net.IPNet
+	low      int
+	high     int
+	source   string
+}
+
+// CheckConsistency compares the egress the host allows with the egress each
+// Security Group in doc allows, so a workload running both on-prem and in
+// the cloud is held to the same policy in both places. A rule counts as
+// consistent when a single rule on the other side allows all of its
+// traffic. Security Group rules whose peer is a prefix list or another
+// group cannot be compared and are skipped. Divergences are sorted by
+// Security Group and traffic.
+func CheckConsistency(doc Document) []Divergence {
+	allowed, blocked := hostSpans(doc)
+
+	type group struct{ account, region, id string }
+	var groups []group
+	rules := make(map[group][]cloud.AppliedRule)
+	if doc.SecurityGroups != nil {
+		for _, r := range doc.SecurityGroups.Rules {
+			g := group{r.Account, r.Region, r.SecurityGroup}
+			if _, ok := rules[g]; !ok {
+				groups = append(groups, g)
+			}
+			rules[g] = append(rules[g], r)
+		}
+	}
+
+	var divergences []Divergence
+	for _, g := range groups {
+		var cloudSpans []span
+		for _, r := range rules[g] {
+			s, ok := ruleSpan(r)
+			if !ok {
+				continue
+			}
+			cloudSpans = append(cloudSpans, s)
+			if coveredBy(s, allowed) {
+				continue
+			}
+			d := Divergence{
+				Kind:          DivergenceCloudOnly,
+				Account:       g.account,
+				Region:        g.region,
+				SecurityGroup: g.id,
+				Traffic:       s.String(),
+				RuleID:        r.ID,
+				Partial:       overlapsAny(s, allowed),
+			}
+			for _, b := range blocked {
+				if overlaps(b, s) {
+					d.HostRule = b.source
+					break
+				}
+			}
+			divergences = append(divergences, d)
+		}
+
+		for _, s := range allowed {
+			if coveredBy(s, cloudSpans) {
+				continue
+			}
+			divergences = append(divergences, Divergence{
+				Kind:          DivergenceHostOnly,
+				Account:       g.account,
+				Region:        g.region,
+				SecurityGroup: g.id,
+				Traffic:       s.String(),
+				HostRule:      s.source,
+				Partial:       overlapsAny(s, cloudSpans),
+			})
+		}
+	}
+
+	slices.SortStableFunc(divergences, func(a, b Divergence) int {
+		return cmp.Or(
+			cmp.Compare(a.Account, b.Account),
+			cmp.Compare(a.Region, b.Region),
+			cmp.Compare(a.SecurityGroup, b.SecurityGroup),
+			cmp.Compare(a.Traffic, b.Traffic),
+		)
+	})
+	return divergences
+}
+
+// hostSpans returns the egress the host's eBPF map or pf anchor allows and
+// explicitly blocks. pf's closing block-all rule is the default deny, not
+// an explicit block. The eBPF map holds a port range as one entry per port,
+// so entries for consecutive ports of a destination make one span.
+func hostSpans(doc Document) (allowed, blocked []span) {
+	if doc.EBPF != nil {
+		var allowedEntries, blockedEntries []span
+		for _, e := range doc.EBPF.Entries {
+			if e.Direction == policy.DirectionIngress {
+				continue
+			}
+			cidr := e.CIDR
+			if cidr == "" {
+				cidr = e.IP + "/32"
+			}
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				continue
+			}
+			s := span{protocol: strings.ToLower(e.Protocol), network: network, low: e.Port, high: e.Port}
+			if e.Action == "allow" {
+				allowedEntries = append(allowedEntries, s)
+			} else {
+				blockedEntries = append(blockedEntries, s)
+			}
+		}
+		for _, s := range mergePorts(allowedEntries) {
+			s.source = fmt.Sprintf("ebpf allow %s", s)
+			allowed = append(allowed, s)
+		}
+		for _, s := range mergePorts(blockedEntries) {
+			s.source = fmt.Sprintf("ebpf block %s", s)
+			blocked = append(blocked, s)
+		}
+	}
+	if doc.PF != nil {
+		for _, r := range doc.PF.Rules {
+			if r.Conditional || (r.Action != enforcer.HostAccept && r.Action != enforcer.HostDrop) {
+				continue
+			}
+			s := span{protocol: r.Protocol, low: r.PortLow, high: r.PortHigh, source: r.String()}
+			if s.high == 0 {
+				s.high = s.low
+			}
+			if r.CIDR != "" {
+				_, network, err := net.ParseCIDR(r.CIDR)
+				if err != nil {
+					continue
+				}
+				s.network = network
+			}
+			switch {
+			case r.Action == enforcer.HostAccept:
+				allowed = append(allowed, s)
+			case r.CIDR != "" || r.Protocol != "" || r.PortLow != 0:
+				blocked = append(blocked, s)
+			}
+		}
+	}
+	return allowed, blocked
+}
+
+// mergePorts joins spans for consecutive ports of the same protocol and
+// network into one, in order of network, protocol and port
+func mergePorts(spans []span) []span {
+	slices.SortFunc(spans, func(a, b span) int {
+		return cmp.Or(cmp.Compare(a.network.String(), b.network.String()), cmp.Compare(a.protocol, b.protocol), cmp.Compare(a.low, b.low))
+	})
+	var merged []span
+	for _, s := range spans {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.protocol == s.protocol && last.network.String() == s.network.String() && last.low != 0 && s.low == last.high+1 {
+				last.high = s.high
+				continue
+			}
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// ruleSpan returns the traffic of a Security Group egress rule, false for
+// ingress rules and peers other than a CIDR
+func ruleSpan(r cloud.AppliedRule) (span, bool) {
+	if !r.Egress || r.CIDR == "" {
+		return span{}, false
+	}
+	_, network, err := net.ParseCIDR(r.CIDR)
+	if err != nil {
+		return span{}, false
+	}
+	s := span{network: network, low: r.FromPort, high: r.ToPort}
+	switch r.Protocol {
+	case "-1", "all":
+		s.protocol = ""
+	case "6":
+		s.protocol = "tcp"
+	case "17":
+		s.protocol = "udp"
+	case "1":
+		s.protocol = "icmp"
+	default:
+		s.protocol = strings.ToLower(r.Protocol)
+	}
+	// Ports of all-protocol and ICMP rules are -1 or ICMP types and codes
+	if s.protocol == "" || s.protocol == "icmp" || s.low < 0 || (s.low == 0 && s.high == 65535) {
+		s.low, s.high = 0, 0
+	}
+	return s, true
+}
+
+// String returns the traffic in the form "tcp/443 -> 10.0.0.0/8"
+func (s span) String() string {
+	protocol := s.protocol
+	if protocol == "" {
+		protocol = "all"
+	}
+	switch {
+	case s.low != 0 && s.low == s.high:
+		protocol = fmt.Sprintf("%s/%d", protocol, s.low)
+	case s.low != 0:
+		protocol = fmt.Sprintf("%s/%d-%d", protocol, s.low, s.high)
+	}
+	destination := "any"
+	if s.network != nil {
+		destination = s.network.String()
+	}
+	return protocol + " -> " + destination
+}
+
+// covers reports whether a matches all the traffic of b
+func covers(a, b span) bool {
+	if a.protocol != "" && a.protocol != b.protocol {
+		return false
+	}
+	if a.network != nil {
+		if b.network == nil || !a.network.Contains(b.network.IP) {
+			return false
+		}
+		aOnes, aBits := a.network.Mask.Size()
+		bOnes, bBits := b.network.Mask.Size()
+		if aBits != bBits || bOnes < aOnes {
+			return false
+		}
+	}
+	if a.low != 0 {
+		return b.low != 0 && a.low <= b.low && b.high <= a.high
+	}
+	return true
+}
+
+// overlaps reports whether a and b match some of the same traffic
+func overlaps(a, b span) bool {
+	if a.protocol != "" && b.protocol != "" && a.protocol != b.protocol {
+		return false
+	}
+	if a.network != nil && b.network != nil && !a.network.Contains(b.network.IP) && !b.network.Contains(a.network.IP) {
+		return false
+	}
+	if a.low != 0 && b.low != 0 && (a.high < b.low || b.high < a.low) {
+		return false
+	}
+	return true
+}
+
+func coveredBy(s span, spans []span) bool {
+	for _, o := range spans {
+		if covers(o, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func overlapsAny(s span, spans []span) bool {
+	for _, o := range spans {
+		if overlaps(o, s) {
+			return true
+		}
+	}
+	return false
+}
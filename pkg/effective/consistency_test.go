@@ -0,0 +1,92 @@
+package effective
+
+import (
+	"strings"
+	"testing"
+
+	"ztap/pkg/cloud"
+	"ztap/pkg/enforcer"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	doc := Document{
+		EBPF: &EBPF{Entries: []enforcer.PolicyEntry{
+			{IP: "10.0.2.10", CIDR: "10.0.2.10/32", Port: 443, Protocol: "TCP", Action: "allow"},
+			{IP: "10.0.2.9", CIDR: "10.0.2.9/32", Port: 5432, Protocol: "TCP", Action: "allow"},
+			{IP: "10.0.3.1", CIDR: "10.0.3.1/32", Port: 22, Protocol: "TCP", Action: "block"},
+		}},
+		SecurityGroups: &SecurityGroups{Rules: []cloud.AppliedRule{
+			// Matches the host exactly
+			{ID: "sgr-1", Account: "prod", Region: "us-east-1", SecurityGroup: "sg-1", Egress: true, Protocol: "tcp", FromPort: 443, ToPort: 443, CIDR: "10.0.2.10/32"},
+			// A port the host blocks
+			{ID: "sgr-2", Account: "prod", Region: "us-east-1", SecurityGroup: "sg-1", Egress: true, Protocol: "tcp", FromPort: 22, ToPort: 22, CIDR: "10.0.3.0/24"},
+			// Not comparable
+			{ID: "sgr-3", Account: "prod", Region: "us-east-1", SecurityGroup: "sg-1", Egress: true, Protocol: "tcp", FromPort: 443, ToPort: 443, PrefixList: "pl-1"},
+			{ID: "sgr-4", Account: "prod", Region: "us-east-1", SecurityGroup: "sg-1", Egress: false, Protocol: "tcp", FromPort: 80, ToPort: 80, CIDR: "0.0.0.0/0"},
+		}},
+	}
+
+	divergences := CheckConsistency(doc)
+	if len(divergences) != 2 {
+		t.Fatalf("Expected 2 divergences, got %+v", divergences)
+	}
+
+	cloudOnly, hostOnly := divergences[0], divergences[1]
+	if cloudOnly.Kind != DivergenceCloudOnly || cloudOnly.RuleID != "sgr-2" || cloudOnly.Partial {
+		t.Errorf("Expected sgr-2 to be cloud-only, got %+v", cloudOnly)
+	}
+	if !strings.Contains(cloudOnly.HostRule, "ebpf block tcp/22 -> 10.0.3.1/32") {
+		t.Errorf("Expected the blocking eBPF entry, got %q", cloudOnly.HostRule)
+	}
+	if hostOnly.Kind != DivergenceHostOnly || hostOnly.Traffic != "tcp/5432 -> 10.0.2.9/32" {
+		t.Errorf("Expected tcp/5432 to be host-only, got %+v", hostOnly)
+	}
+	if !strings.Contains(cloudOnly.String(), "blocked by") {
+		t.Errorf("Expected the description to name the block, got %q", cloudOnly.String())
+	}
+}
+
+func TestCheckConsistencyBroaderRule(t *testing.T) {
+	doc := Document{
+		PF: &PF{Rules: []enforcer.HostRule{
+			{Firewall: enforcer.FirewallPF, Action: enforcer.HostAccept, Quick: true, Protocol: "udp", CIDR: "8.8.8.8/32", PortLow: 53, Raw: "pass out quick proto udp from any to 8.8.8.8 port = 53"},
+			{Firewall: enforcer.FirewallPF, Action: enforcer.HostDrop, Quick: true, Raw: "block drop out quick all"},
+		}},
+		SecurityGroups: &SecurityGroups{Rules: []cloud.AppliedRule{
+			// The default egress rule of a Security Group
+			{ID: "sgr-all", Region: "us-east-1", SecurityGroup: "sg-1", Egress: true, Protocol: "-1", FromPort: -1, ToPort: -1, CIDR: "0.0.0.0/0"},
+		}},
+	}
+
+	divergences := CheckConsistency(doc)
+	if len(divergences) != 1 {
+		t.Fatalf("Expected 1 divergence, got %+v", divergences)
+	}
+	d := divergences[0]
+	if d.Kind != DivergenceCloudOnly || !d.Partial || d.Traffic != "all -> 0.0.0.0/0" || d.HostRule != "" {
+		t.Errorf("Expected the allow-all rule to be partly cloud-only, got %+v", d)
+	}
+}
+
+func TestCheckConsistencyPortRange(t *testing.T) {
+	// The eBPF map holds the range 8000-8002 as one entry per port
+	doc := Document{
+		EBPF: &EBPF{Entries: []enforcer.PolicyEntry{
+			{IP: "10.0.4.1", CIDR: "10.0.4.1/32", Port: 8002, Protocol: "TCP", Action: "allow"},
+			{IP: "10.0.4.1", CIDR: "10.0.4.1/32", Port: 8000, Protocol: "TCP", Action: "allow"},
+			{IP: "10.0.4.1", CIDR: "10.0.4.1/32", Port: 8001, Protocol: "TCP", Action: "allow"},
+			{IP: "10.0.4.1", CIDR: "10.0.4.1/32", Port: 8080, Protocol: "TCP", Action: "allow"},
+		}},
+		SecurityGroups: &SecurityGroups{Rules: []cloud.AppliedRule{
+			{ID: "sgr-range", Region: "us-east-1", SecurityGroup: "sg-1", Egress: true, Protocol: "tcp", FromPort: 8000, ToPort: 8002, CIDR: "10.0.4.1/32"},
+		}},
+	}
+
+	divergences := CheckConsistency(doc)
+	if len(divergences) != 1 {
+		t.Fatalf("Expected 1 divergence, got %+v", divergences)
+	}
+	if d := divergences[0]; d.Kind != DivergenceHostOnly || d.Traffic != "tcp/8080 -> 10.0.4.1/32" {
+		t.Errorf("Expected only tcp/8080 host-only, got %+v", d)
+	}
+}
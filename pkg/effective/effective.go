@@ -0,0 +1,125 @@
+// Package effective assembles the rules currently enforced by every backend
+// into one document, so audits and external tools can diff what is enforced
+// against what policies intend. Rules are read back from the backends (the
+// pinned eBPF map, the loaded pf anchor, the Security Groups in AWS), not
+// recompiled from policy files.
+package effective
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"net"
+	"slices"
+	"time"
+
+	"ztap/pkg/cloud"
+	"ztap/pkg/enforcer"
+)
+
+// Document is the effective rule set of a host and the Security Groups it
+// syncs. A backend that was not read has no section; one that could not be
+// read has a section with Error set.
+type Document struct {
+	GeneratedAt    time.Time       `json:"generatedAt"`
+	Host           string          `json:"host"`
+	EBPF           *EBPF           `json:"ebpf,omitempty"`
+	PF             *PF             `json:"pf,omitempty"`
+	SecurityGroups *SecurityGroups `json:"securityGroups,omitempty"`
+}
+
+// EBPF is the content of the pinned eBPF policy map
+type EBPF struct {
+	Error   string                 `json:"error,omitempty"`
+	Entries []enforcer.PolicyEntry `json:"entries"`
+}
+
+// PF is the ruleset loaded in the ztap pf anchor
+type PF struct {
+	Anchor string              `json:"anchor"`
+	Error  string              `json:"error,omitempty"`
+	Rules  []enforcer.HostRule `json:"rules"`
+}
+
+// SecurityGroups are the rules of the Security Groups policies are synced to
+type SecurityGroups struct {
+	Error string              `json:"error,omitempty"`
+	Rules []cloud.AppliedRule `json:"rules"`
+}
+
+// Sources read each backend's rules. A nil source leaves its section out.
+type Sources struct {
+	EBPF           func() ([]enforcer.PolicyEntry, error)
+	PF             func() ([]enforcer.HostRule, error)
+	SecurityGroups func(ctx context.Context) ([]cloud.AppliedRule, error)
+}
+
+// Collect reads every backend in sources. Entries are sorted so that two
+// documents of the same state differ only in GeneratedAt; pf rules keep
+// their evaluation order. Rules read before a backend failed are kept
+// alongside its error.
+func Collect(ctx context.Context, host string, now time.Time, sources Sources) Document {
+	doc := Document{GeneratedAt: now.UTC(), Host: host}
+
+	if sources.EBPF != nil {
+		entries, err := sources.EBPF()
+		doc.EBPF = &EBPF{Error: errorString(err), Entries: nonNil(entries)}
+		slices.SortFunc(doc.EBPF.Entries, compareEntries)
+	}
+	if sources.PF != nil {
+		rules, err := sources.PF()
+		doc.PF = &PF{Anchor: "ztap", Error: errorString(err), Rules: nonNil(rules)}
+	}
+	if sources.SecurityGroups != nil {
+		rules, err := sources.SecurityGroups(ctx)
+		doc.SecurityGroups = &SecurityGroups{Error: errorString(err), Rules: nonNil(rules)}
+		slices.SortFunc(doc.SecurityGroups.Rules, compareAppliedRules)
+	}
+	return doc
+}
+
+// Errors returns the error of every section that could not be read
+func (d Document) Errors() []string {
+	var errs []string
+	if d.EBPF != nil && d.EBPF.Error != "" {
+		errs = append(errs, "ebpf: "+d.EBPF.Error)
+	}
+	if d.PF != nil && d.PF.Error != "" {
+		errs = append(errs, "pf: "+d.PF.Error)
+	}
+	if d.SecurityGroups != nil && d.SecurityGroups.Error != "" {
+		errs = append(errs, "security groups: "+d.SecurityGroups.Error)
+	}
+	return errs
+}
+
+func compareEntries(a, b enforcer.PolicyEntry) int {
+	if c := bytes.Compare(net.ParseIP(a.IP).To16(), net.ParseIP(b.IP).To16()); c != 0 {
+		return c
+	}
+	return cmp.Or(cmp.Compare(a.Port, b.Port), cmp.Compare(a.Protocol, b.Protocol), cmp.Compare(a.Direction, b.Direction))
+}
+
+func compareAppliedRules(a, b cloud.AppliedRule) int {
+	return cmp.Or(
+		cmp.Compare(a.Account, b.Account),
+		cmp.Compare(a.Region, b.Region),
+		cmp.Compare(a.SecurityGroup, b.SecurityGroup),
+		cmp.Compare(a.ID, b.ID),
+	)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// nonNil returns s, or an empty slice so the document has [] and not null
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
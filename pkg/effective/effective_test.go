@@ -0,0 +1,65 @@
+package effective
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/cloud"
+	"ztap/pkg/enforcer"
+)
+
+func TestCollect(t *testing.T) {
+	now := time.Date(2025, 10, 31, 12, 0, 0, 0, time.UTC)
+	doc := Collect(context.Background(), "node-1", now, Sources{
+		EBPF: func() ([]enforcer.PolicyEntry, error) {
+			return []enforcer.PolicyEntry{
+				{IP: "10.0.2.10", CIDR: "10.0.2.10/32", Port: 443, Protocol: "TCP", Action: "allow"},
+				{IP: "10.0.2.9", CIDR: "10.0.2.9/32", Port: 5432, Protocol: "TCP", Action: "allow"},
+				{IP: "10.0.2.9", CIDR: "10.0.2.9/32", Port: 53, Protocol: "UDP", Action: "allow"},
+			}, nil
+		},
+		SecurityGroups: func(ctx context.Context) ([]cloud.AppliedRule, error) {
+			return []cloud.AppliedRule{
+				{ID: "sgr-2", Account: "prod", Region: "us-east-1", SecurityGroup: "sg-1"},
+				{ID: "sgr-1", Account: "prod", Region: "us-east-1", SecurityGroup: "sg-1"},
+			}, errors.New("dev/eu-west-1: access denied")
+		},
+	})
+
+	var order []string
+	for _, e := range doc.EBPF.Entries {
+		order = append(order, e.IP+":"+e.Protocol)
+	}
+	if got := strings.Join(order, " "); got != "10.0.2.9:UDP 10.0.2.9:TCP 10.0.2.10:TCP" {
+		t.Errorf("Expected entries sorted by address and port, got %s", got)
+	}
+	if doc.SecurityGroups.Rules[0].ID != "sgr-1" {
+		t.Errorf("Expected rules sorted by ID, got %+v", doc.SecurityGroups.Rules)
+	}
+	if doc.PF != nil {
+		t.Error("Expected no pf section without a pf source")
+	}
+	if errs := doc.Errors(); len(errs) != 1 || !strings.HasPrefix(errs[0], "security groups: ") {
+		t.Errorf("Expected the Security Group error, got %v", errs)
+	}
+}
+
+func TestCollectEmptyBackend(t *testing.T) {
+	doc := Collect(context.Background(), "node-1", time.Now(), Sources{
+		PF: func() ([]enforcer.HostRule, error) { return nil, nil },
+	})
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"pf":{"anchor":"ztap","rules":[]}`) {
+		t.Errorf("Expected an empty rule list, got %s", data)
+	}
+	if strings.Contains(string(data), "ebpf") {
+		t.Errorf("Expected no eBPF section, got %s", data)
+	}
+}
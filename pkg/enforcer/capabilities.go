@@ -0,0 +1,49 @@
+package enforcer
+
+import "ztap/pkg/policy"
+
+// Enforcement backends
+const (
+	BackendEBPF = "ebpf"
+	BackendPF   = "pf"
+)
+
+// ebpfPolicyMapCapacity matches policy_map max_entries in bpf/filter.c
+const ebpfPolicyMapCapacity = 10000
+
+// MaxEBPFPortRange is the widest port range the eBPF backend enforces. Its
+// policy map matches exact ports, so a range takes one entry per port.
+const MaxEBPFPortRange = 1024
+
+// Backend returns the enforcement backend used on this platform
+func Backend() string {
+	if IsLinux() {
+		return BackendEBPF
+	}
+	return BackendPF
+}
+
+// BackendCapabilities returns the policy features a backend can enforce.
+// Both backends enforce compiled policies, in which label selectors are
+// already resolved to host rules; the eBPF policy map is keyed by IPv4
+// address.
+func BackendCapabilities(backend string) []string {
+	switch backend {
+	case BackendEBPF:
+		return []string{policy.CapabilityIngress, policy.CapabilityIPv4, policy.CapabilityLabelSelectors}
+	case BackendPF:
+		return []string{policy.CapabilityIngress, policy.CapabilityIPv4, policy.CapabilityIPv6, policy.CapabilityLabelSelectors}
+	default:
+		return nil
+	}
+}
+
+// BackendPolicyCapacity returns the maximum number of compiled policy rules
+// (one per resolved destination and port) a backend can hold, or 0 if it is
+// unbounded
+func BackendPolicyCapacity(backend string) int {
+	if backend == BackendEBPF {
+		return ebpfPolicyMapCapacity
+	}
+	return 0
+}
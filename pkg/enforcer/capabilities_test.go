@@ -0,0 +1,41 @@
+package enforcer
+
+import (
+	"testing"
+
+	"ztap/pkg/policy"
+)
+
+func TestBackendCapabilities(t *testing.T) {
+	has := func(backend, capability string) bool {
+		for _, c := range BackendCapabilities(backend) {
+			if c == capability {
+				return true
+			}
+		}
+		return false
+	}
+
+	if has(BackendEBPF, policy.CapabilityIPv6) {
+		t.Error("eBPF backend should not advertise IPv6")
+	}
+	if !has(BackendEBPF, policy.CapabilityLabelSelectors) {
+		t.Error("eBPF backend should advertise label selectors")
+	}
+	if !has(BackendPF, policy.CapabilityIPv6) {
+		t.Error("pf backend should advertise IPv6")
+	}
+	if !has(BackendPF, policy.CapabilityLabelSelectors) {
+		t.Error("pf backend should advertise label selectors")
+	}
+	if BackendCapabilities("unknown") != nil {
+		t.Error("unknown backend should have no capabilities")
+	}
+
+	if BackendPolicyCapacity(BackendEBPF) != ebpfPolicyMapCapacity {
+		t.Errorf("expected eBPF capacity %d", ebpfPolicyMapCapacity)
+	}
+	if BackendPolicyCapacity(BackendPF) != 0 {
+		t.Error("expected pf capacity to be unbounded")
+	}
+}
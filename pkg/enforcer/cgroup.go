@@ -0,0 +1,149 @@
+package enforcer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Cgroup hierarchy layouts
+const (
+	// CgroupUnified: only cgroup v2 is mounted (the default on recent distros)
+	CgroupUnified = "unified"
+	// CgroupHybrid: controllers on cgroup v1, with a v2 hierarchy alongside
+	// (systemd mounts it at /sys/fs/cgroup/unified)
+	CgroupHybrid = "hybrid"
+	// CgroupLegacy: only cgroup v1 is mounted
+	CgroupLegacy = "legacy"
+	// CgroupNone: no cgroup filesystem is mounted
+	CgroupNone = "none"
+)
+
+// CompatCgroupPath is where the eBPF backend mounts a cgroup v2 hierarchy of
+// its own on legacy hosts. Every process starts in its root, so the filter
+// attached there covers the whole host.
+const CompatCgroupPath = "/run/ztap/cgroup2"
+
+// CgroupLayout describes the cgroup hierarchies mounted on the host
+type CgroupLayout struct {
+	Mode     string
+	V2Mount  string   // Mount point of the cgroup v2 hierarchy, empty if none
+	V1Mounts []string // Mount points of cgroup v1 hierarchies, sorted
+}
+
+// String describes the layout for diagnostics, e.g.
+// "hybrid (cgroup v1 controllers, v2 at /sys/fs/cgroup/unified)"
+func (l CgroupLayout) String() string {
+	switch l.Mode {
+	case CgroupUnified:
+		return fmt.Sprintf("unified (cgroup v2 at %s)", l.V2Mount)
+	case CgroupHybrid:
+		return fmt.Sprintf("hybrid (cgroup v1 controllers, v2 at %s)", l.V2Mount)
+	case CgroupLegacy:
+		return "legacy (cgroup v1 only)"
+	default:
+		return "none (no cgroup filesystem mounted)"
+	}
+}
+
+// ParseCgroupLayout finds the cgroup mounts in the contents of
+// /proc/self/mountinfo
+func ParseCgroupLayout(mountinfo []byte) CgroupLayout {
+	var layout CgroupLayout
+	scanner := bufio.NewScanner(bytes.NewReader(mountinfo))
+	for scanner.Scan() {
+		// 30 23 0:26 / /sys/fs/cgroup rw,nosuid shared:4 - cgroup2 cgroup2 rw
+		before, after, ok := strings.Cut(scanner.Text(), " - ")
+		fields, fsFields := strings.Fields(before), strings.Fields(after)
+		if !ok || len(fields) < 5 || len(fsFields) < 1 {
+			continue
+		}
+		switch fsFields[0] {
+		case "cgroup2":
+			if layout.V2Mount == "" {
+				layout.V2Mount = fields[4]
+			}
+		case "cgroup":
+			layout.V1Mounts = append(layout.V1Mounts, fields[4])
+		}
+	}
+	sort.Strings(layout.V1Mounts)
+
+	switch {
+	case layout.V2Mount != "" && len(layout.V1Mounts) == 0:
+		layout.Mode = CgroupUnified
+	case layout.V2Mount != "":
+		layout.Mode = CgroupHybrid
+	case len(layout.V1Mounts) > 0:
+		layout.Mode = CgroupLegacy
+	default:
+		layout.Mode = CgroupNone
+	}
+	return layout
+}
+
+// CgroupError explains why the filter cannot be attached for a cgroup path
+type CgroupError struct {
+	Path   string
+	Layout CgroupLayout
+	Reason string
+}
+
+func (e *CgroupError) Error() string {
+	return fmt.Sprintf("cannot attach the eBPF filter for %s on a %s host: %s", e.Path, e.Layout, e.Reason)
+}
+
+// ResolveCgroupPath returns the cgroup v2 directory to attach the filter to
+// for path, as eBPF cgroup programs only attach to cgroup v2. On unified
+// hosts path is used as is. On hybrid hosts, a path in a v1 hierarchy (e.g.
+// /sys/fs/cgroup/net_cls/system.slice) or under the v1 root maps to the same
+// cgroup in the v2 hierarchy (/sys/fs/cgroup/unified/system.slice), where
+// systemd keeps the same tree. Legacy hosts have no v2 hierarchy to map to.
+func ResolveCgroupPath(layout CgroupLayout, path string) (string, error) {
+	path = filepath.Clean(path)
+	switch layout.Mode {
+	case CgroupUnified:
+		return path, nil
+	case CgroupHybrid:
+		if within(layout.V2Mount, path) {
+			return path, nil
+		}
+		relative, ok := v1Relative(layout, path)
+		if !ok {
+			return "", &CgroupError{path, layout, fmt.Sprintf("the path is in no cgroup hierarchy; use a cgroup under %s", layout.V2Mount)}
+		}
+		return filepath.Join(layout.V2Mount, relative), nil
+	case CgroupLegacy:
+		return "", &CgroupError{path, layout, fmt.Sprintf("eBPF cgroup programs need cgroup v2; mount it at %s (done automatically with CAP_SYS_ADMIN) or boot with systemd.unified_cgroup_hierarchy=1", CompatCgroupPath)}
+	default:
+		return "", &CgroupError{path, layout, "mount cgroup2 (e.g. mount -t cgroup2 none /sys/fs/cgroup)"}
+	}
+}
+
+// v1Relative returns path relative to the v1 hierarchy it is in. The
+// directory holding the v1 hierarchies (usually /sys/fs/cgroup) stands for
+// the root cgroup.
+func v1Relative(layout CgroupLayout, path string) (string, bool) {
+	best := ""
+	for _, mount := range layout.V1Mounts {
+		if within(mount, path) && len(mount) > len(best) {
+			best = mount
+		}
+	}
+	if best != "" {
+		relative, err := filepath.Rel(best, path)
+		return relative, err == nil
+	}
+	if len(layout.V1Mounts) > 0 && path == filepath.Dir(layout.V1Mounts[0]) {
+		return ".", true
+	}
+	return "", false
+}
+
+// within reports whether path is dir or below it
+func within(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
@@ -0,0 +1,68 @@
+//go:build linux
+
+package enforcer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// DetectCgroupLayout reads the cgroup mounts of the host
+func DetectCgroupLayout() (CgroupLayout, error) {
+	mountinfo, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return CgroupLayout{}, fmt.Errorf("failed to read mounts: %w", err)
+	}
+	return ParseCgroupLayout(mountinfo), nil
+}
+
+// attachPath returns the cgroup v2 directory to attach the filter to for
+// cgroupPath (see ResolveCgroupPath). On legacy hosts a cgroup v2 hierarchy
+// is mounted at CompatCgroupPath first; only its root holds processes, as a
+// cgroup v1 init places them in v1 cgroups only.
+func attachPath(cgroupPath string) (string, error) {
+	layout, err := DetectCgroupLayout()
+	if err != nil {
+		return "", err
+	}
+	if layout.Mode == CgroupLegacy {
+		if err := mountCompatCgroup(); err != nil {
+			_, resolveErr := ResolveCgroupPath(layout, cgroupPath)
+			return "", fmt.Errorf("%w: %v", resolveErr, err)
+		}
+		log.Printf("cgroup v1 host: mounted a cgroup v2 hierarchy at %s for the eBPF filter", CompatCgroupPath)
+		layout.Mode, layout.V2Mount = CgroupHybrid, CompatCgroupPath
+	}
+
+	path, err := ResolveCgroupPath(layout, cgroupPath)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", &CgroupError{cgroupPath, layout, fmt.Sprintf("%s does not exist in the cgroup v2 hierarchy; attach to %s to cover the host", path, layout.V2Mount)}
+	}
+	if path != filepath.Clean(cgroupPath) {
+		log.Printf("%s cgroups: attaching for %s through %s", layout.Mode, cgroupPath, path)
+	}
+	return path, nil
+}
+
+// mountCompatCgroup mounts a cgroup v2 hierarchy at CompatCgroupPath. A v2
+// hierarchy without controllers can be mounted next to v1 ones; once it is,
+// the host is detected as hybrid.
+func mountCompatCgroup() error {
+	if err := CheckPrivileges(OpMountCgroup); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(CompatCgroupPath, 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", CompatCgroupPath, err)
+	}
+	if err := unix.Mount("cgroup2", CompatCgroupPath, "cgroup2", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("failed to mount cgroup2 at %s: %w", CompatCgroupPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package enforcer
+
+import "fmt"
+
+// DetectCgroupLayout is only supported on Linux; the pf backend does not use
+// cgroups
+func DetectCgroupLayout() (CgroupLayout, error) {
+	return CgroupLayout{}, fmt.Errorf("cgroups require Linux")
+}
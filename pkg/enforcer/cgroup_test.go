@@ -0,0 +1,91 @@
+package enforcer
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	unifiedMountinfo = `22 1 259:1 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw
+30 23 0:26 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:4 - cgroup2 cgroup2 rw,nsdelegate
+`
+	hybridMountinfo = `22 1 259:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+25 21 0:22 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:4 - tmpfs tmpfs ro,mode=755
+26 25 0:23 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime shared:5 - cgroup2 cgroup2 rw,nsdelegate
+27 25 0:24 / /sys/fs/cgroup/systemd rw,nosuid,nodev,noexec,relatime shared:6 - cgroup cgroup rw,xattr,name=systemd
+31 25 0:28 / /sys/fs/cgroup/net_cls,net_prio rw,nosuid,nodev,noexec,relatime shared:10 - cgroup cgroup rw,net_cls,net_prio
+`
+)
+
+func TestParseCgroupLayout(t *testing.T) {
+	tests := []struct {
+		name      string
+		mountinfo string
+		want      CgroupLayout
+	}{
+		{"unified", unifiedMountinfo, CgroupLayout{Mode: CgroupUnified, V2Mount: "/sys/fs/cgroup"}},
+		{"hybrid", hybridMountinfo, CgroupLayout{
+			Mode:     CgroupHybrid,
+			V2Mount:  "/sys/fs/cgroup/unified",
+			V1Mounts: []string{"/sys/fs/cgroup/net_cls,net_prio", "/sys/fs/cgroup/systemd"},
+		}},
+		{"legacy", strings.ReplaceAll(hybridMountinfo, "cgroup2 cgroup2", "tmpfs tmpfs"), CgroupLayout{
+			Mode:     CgroupLegacy,
+			V1Mounts: []string{"/sys/fs/cgroup/net_cls,net_prio", "/sys/fs/cgroup/systemd"},
+		}},
+		{"none", "22 1 259:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw\n", CgroupLayout{Mode: CgroupNone}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseCgroupLayout([]byte(tt.mountinfo)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveCgroupPath(t *testing.T) {
+	unified := ParseCgroupLayout([]byte(unifiedMountinfo))
+	hybrid := ParseCgroupLayout([]byte(hybridMountinfo))
+
+	tests := []struct {
+		name   string
+		layout CgroupLayout
+		path   string
+		want   string
+	}{
+		{"unified", unified, "/sys/fs/cgroup/system.slice/", "/sys/fs/cgroup/system.slice"},
+		{"hybrid root", hybrid, "/sys/fs/cgroup", "/sys/fs/cgroup/unified"},
+		{"hybrid v2 path", hybrid, "/sys/fs/cgroup/unified/system.slice", "/sys/fs/cgroup/unified/system.slice"},
+		{"hybrid controller path", hybrid, "/sys/fs/cgroup/net_cls,net_prio/system.slice/web.service", "/sys/fs/cgroup/unified/system.slice/web.service"},
+		{"hybrid systemd path", hybrid, "/sys/fs/cgroup/systemd/user.slice", "/sys/fs/cgroup/unified/user.slice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveCgroupPath(tt.layout, tt.path)
+			if err != nil || got != tt.want {
+				t.Errorf("Expected %s, got %q (%v)", tt.want, got, err)
+			}
+		})
+	}
+
+	// Paths outside every hierarchy and legacy hosts are explained
+	legacy := CgroupLayout{Mode: CgroupLegacy, V1Mounts: hybrid.V1Mounts}
+	for _, tt := range []struct {
+		layout CgroupLayout
+		path   string
+		want   string
+	}{
+		{hybrid, "/tmp/cgroup", "use a cgroup under /sys/fs/cgroup/unified"},
+		{legacy, "/sys/fs/cgroup", "systemd.unified_cgroup_hierarchy=1"},
+		{CgroupLayout{Mode: CgroupNone}, "/sys/fs/cgroup", "mount -t cgroup2"},
+	} {
+		_, err := ResolveCgroupPath(tt.layout, tt.path)
+		var cgroupErr *CgroupError
+		if !errors.As(err, &cgroupErr) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s on %s: expected *CgroupError containing %q, got %v", tt.path, tt.layout.Mode, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+//go:build linux
+// +build linux
+
+package enforcer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cilium/ebpf"
+)
+
+// mapBatchSize bounds the number of entries sent to the kernel per batch syscall
+const mapBatchSize = 1024
+
+// policyMap captures the eBPF map operations used to sync policy entries so the
+// batch and fallback paths can be exercised without a kernel
+type policyMap interface {
+	Put(key, value interface{}) error
+	Delete(key interface{}) error
+	BatchUpdate(keys, values interface{}, opts *ebpf.BatchOptions) (int, error)
+	BatchDelete(keys interface{}, opts *ebpf.BatchOptions) (int, error)
+}
+
+// writeEntries writes entries to the map in chunks of mapBatchSize using
+// BatchUpdate, falling back to one Put per entry if the kernel lacks batch
+// support (BPF_MAP_UPDATE_BATCH requires Linux 5.6+)
+func writeEntries(m policyMap, entries map[policyKey]policyValue) error {
+	keys := make([]policyKey, 0, len(entries))
+	values := make([]policyValue, 0, len(entries))
+	for k, v := range entries {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+
+	for start := 0; start < len(keys); start += mapBatchSize {
+		end := min(start+mapBatchSize, len(keys))
+
+		_, err := m.BatchUpdate(keys[start:end], values[start:end], nil)
+		if errors.Is(err, ebpf.ErrNotSupported) {
+			return putEntries(m, keys[start:], values[start:])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to batch update policy map: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// putEntries writes entries one at a time
+func putEntries(m policyMap, keys []policyKey, values []policyValue) error {
+	for i := range keys {
+		if err := m.Put(&keys[i], &values[i]); err != nil {
+			return fmt.Errorf("failed to update policy map: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteEntries removes keys from the map in chunks using BatchDelete, falling
+// back to one Delete per key if the kernel lacks batch support
+func deleteEntries(m policyMap, keys []policyKey) error {
+	for start := 0; start < len(keys); start += mapBatchSize {
+		end := min(start+mapBatchSize, len(keys))
+		chunk := keys[start:end]
+
+		n, err := m.BatchDelete(chunk, nil)
+		switch {
+		case errors.Is(err, ebpf.ErrNotSupported):
+			return removeEntries(m, keys[start:])
+		case errors.Is(err, ebpf.ErrKeyNotExist):
+			// The kernel stops at the first missing key; finish the chunk one by one
+			if err := removeEntries(m, chunk[n:]); err != nil {
+				return err
+			}
+		case err != nil:
+			return fmt.Errorf("failed to batch delete from policy map: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// removeEntries deletes keys one at a time, ignoring keys already absent
+func removeEntries(m policyMap, keys []policyKey) error {
+	for i := range keys {
+		if err := m.Delete(&keys[i]); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("failed to delete from policy map: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+//go:build linux
+// +build linux
+
+package enforcer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+// fakePolicyMap is an in-memory policyMap that can simulate kernels without
+// batch support
+type fakePolicyMap struct {
+	entries     map[policyKey]policyValue
+	noBatch     bool
+	batchCalls  int
+	singleCalls int
+}
+
+func newFakePolicyMap(noBatch bool) *fakePolicyMap {
+	return &fakePolicyMap{entries: make(map[policyKey]policyValue), noBatch: noBatch}
+}
+
+func (m *fakePolicyMap) Put(key, value interface{}) error {
+	m.singleCalls++
+	m.entries[*key.(*policyKey)] = *value.(*policyValue)
+	return nil
+}
+
+func (m *fakePolicyMap) Delete(key interface{}) error {
+	m.singleCalls++
+	k := *key.(*policyKey)
+	if _, exists := m.entries[k]; !exists {
+		return ebpf.ErrKeyNotExist
+	}
+	delete(m.entries, k)
+	return nil
+}
+
+func (m *fakePolicyMap) BatchUpdate(keys, values interface{}, _ *ebpf.BatchOptions) (int, error) {
+	if m.noBatch {
+		return 0, ebpf.ErrNotSupported
+	}
+	m.batchCalls++
+	ks, vs := keys.([]policyKey), values.([]policyValue)
+	if len(ks) > mapBatchSize {
+		return 0, errors.New("batch too large")
+	}
+	for i := range ks {
+		m.entries[ks[i]] = vs[i]
+	}
+	return len(ks), nil
+}
+
+func (m *fakePolicyMap) BatchDelete(keys interface{}, _ *ebpf.BatchOptions) (int, error) {
+	if m.noBatch {
+		return 0, ebpf.ErrNotSupported
+	}
+	m.batchCalls++
+	// Like the kernel, stop at the first missing key
+	for i, k := range keys.([]policyKey) {
+		if _, exists := m.entries[k]; !exists {
+			return i, ebpf.ErrKeyNotExist
+		}
+		delete(m.entries, k)
+	}
+	return len(keys.([]policyKey)), nil
+}
+
+func testEntries(n int) map[policyKey]policyValue {
+	entries := make(map[policyKey]policyValue, n)
+	for i := 0; i < n; i++ {
+		entries[policyKey{DestIP: uint32(i), DestPort: 443, Protocol: 6}] = policyValue{Action: 1}
+	}
+	return entries
+}
+
+func TestWriteEntriesBatched(t *testing.T) {
+	m := newFakePolicyMap(false)
+
+	if err := writeEntries(m, testEntries(2500)); err != nil {
+		t.Fatalf("writeEntries failed: %v", err)
+	}
+	if len(m.entries) != 2500 {
+		t.Errorf("Expected 2500 entries, got %d", len(m.entries))
+	}
+	if m.batchCalls != 3 || m.singleCalls != 0 {
+		t.Errorf("Expected 3 batch calls and no single ops, got %d/%d", m.batchCalls, m.singleCalls)
+	}
+}
+
+func TestWriteEntriesFallback(t *testing.T) {
+	m := newFakePolicyMap(true)
+
+	if err := writeEntries(m, testEntries(10)); err != nil {
+		t.Fatalf("writeEntries failed: %v", err)
+	}
+	if len(m.entries) != 10 || m.singleCalls != 10 {
+		t.Errorf("Expected 10 entries via single ops, got %d entries/%d calls", len(m.entries), m.singleCalls)
+	}
+}
+
+func TestDeleteEntries(t *testing.T) {
+	for _, noBatch := range []bool{false, true} {
+		m := newFakePolicyMap(noBatch)
+		entries := testEntries(1500)
+		if err := writeEntries(m, entries); err != nil {
+			t.Fatalf("writeEntries failed: %v", err)
+		}
+
+		var keys []policyKey
+		for k := range entries {
+			keys = append(keys, k)
+		}
+		// A key already absent must not stop the rest of the chunk
+		delete(m.entries, keys[10])
+
+		if err := deleteEntries(m, keys); err != nil {
+			t.Fatalf("deleteEntries (noBatch=%v) failed: %v", noBatch, err)
+		}
+		if len(m.entries) != 0 {
+			t.Errorf("Expected empty map (noBatch=%v), got %d entries", noBatch, len(m.entries))
+		}
+	}
+}
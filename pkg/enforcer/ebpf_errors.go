@@ -0,0 +1,123 @@
+package enforcer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of data-plane errors the eBPF filter program counts
+const (
+	// FilterErrorNotIPv4: packets that are not IPv4, passed unfiltered
+	FilterErrorNotIPv4 = "not_ipv4"
+	// FilterErrorMalformed: packets whose IPv4 header could not be read
+	FilterErrorMalformed = "malformed"
+	// FilterErrorTruncated: TCP or UDP packets whose header could not be read
+	FilterErrorTruncated = "truncated"
+	// FilterErrorPolicyMiss: packets with no policy map entry, decided by the
+	// program's default (blocked by filter_egress)
+	FilterErrorPolicyMiss = "policy_miss"
+	// FilterErrorEventDropped: error events lost because the perf buffer was
+	// full or the reader fell behind
+	FilterErrorEventDropped = "event_dropped"
+)
+
+// filterErrorKinds are the kinds in the order of their index in the
+// filter_errors map (ERR_* in bpf/filter.c)
+var filterErrorKinds = []string{
+	FilterErrorNotIPv4,
+	FilterErrorMalformed,
+	FilterErrorTruncated,
+	FilterErrorPolicyMiss,
+	FilterErrorEventDropped,
+}
+
+// FilterErrors are the cumulative error counts of the filter program, by kind
+type FilterErrors map[string]uint64
+
+// Since returns the counts added after previous. A count below its previous
+// value (the program's maps were recreated) is taken as counted from zero.
+func (f FilterErrors) Since(previous FilterErrors) FilterErrors {
+	delta := make(FilterErrors)
+	for kind, count := range f {
+		if before := previous[kind]; count >= before {
+			count -= before
+		}
+		if count > 0 {
+			delta[kind] = count
+		}
+	}
+	return delta
+}
+
+// String lists the non-zero counts by kind, e.g. "malformed=3 truncated=1"
+func (f FilterErrors) String() string {
+	var parts []string
+	for kind, count := range f {
+		if count > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", kind, count))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// FilterEvent is a packet the filter program could not parse
+type FilterEvent struct {
+	Kind     string // FilterErrorMalformed or FilterErrorTruncated
+	DestIP   string // Empty if the IPv4 header could not be read
+	Protocol string
+}
+
+// String describes the event, e.g. "truncated TCP packet to 10.0.0.5"
+func (e FilterEvent) String() string {
+	if e.DestIP == "" {
+		return fmt.Sprintf("%s packet", e.Kind)
+	}
+	return fmt.Sprintf("%s %s packet to %s", e.Kind, e.Protocol, e.DestIP)
+}
+
+// filterEventSize is the size of struct filter_event in bpf/filter.c
+const filterEventSize = 12
+
+// parseFilterEvent decodes a struct filter_event sample. Perf samples are
+// padded, so trailing bytes are ignored.
+func parseFilterEvent(raw []byte) (FilterEvent, error) {
+	if len(raw) < filterEventSize {
+		return FilterEvent{}, fmt.Errorf("eBPF filter event too short: %d bytes", len(raw))
+	}
+	index := binary.NativeEndian.Uint32(raw[0:4])
+	if int(index) >= len(filterErrorKinds) {
+		return FilterEvent{}, fmt.Errorf("unknown eBPF filter error kind %d", index)
+	}
+
+	event := FilterEvent{Kind: filterErrorKinds[index]}
+	if ip := binary.NativeEndian.Uint32(raw[4:8]); ip != 0 {
+		event.DestIP = uint32ToIP(ip).String()
+		event.Protocol = protocolName(raw[10])
+	}
+	return event, nil
+}
+
+// uint32ToIP converts an address in host byte order, as the filter program
+// stores them, to an IP
+func uint32ToIP(n uint32) net.IP {
+	return net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+// protocolName is the inverse of protocolToNum
+func protocolName(num uint8) string {
+	switch num {
+	case 6:
+		return "TCP"
+	case 17:
+		return "UDP"
+	case 1:
+		return "ICMP"
+	default:
+		return strconv.Itoa(int(num))
+	}
+}
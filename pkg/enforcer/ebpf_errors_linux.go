@@ -0,0 +1,99 @@
+//go:build linux
+// +build linux
+
+package enforcer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"ztap/pkg/metrics"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/perf"
+)
+
+// FilterErrorReader reads the error counters and events of the filter
+// program from the maps pinned under PinPath by a running agent
+type FilterErrorReader struct {
+	errors *ebpf.Map
+	events *ebpf.Map
+}
+
+// OpenFilterErrors opens the pinned filter_errors and filter_events maps
+func OpenFilterErrors() (*FilterErrorReader, error) {
+	if err := CheckPrivileges(OpLoadEBPF); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(PinPath, "filter_errors")
+	errorMap, err := ebpf.LoadPinnedMap(path, &ebpf.LoadPinOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pinned error map %s (is 'ztap agent' enforcing with eBPF?): %w", path, err)
+	}
+	path = filepath.Join(PinPath, "filter_events")
+	eventMap, err := ebpf.LoadPinnedMap(path, nil)
+	if err != nil {
+		errorMap.Close()
+		return nil, fmt.Errorf("failed to open pinned event map %s: %w", path, err)
+	}
+	return &FilterErrorReader{errors: errorMap, events: eventMap}, nil
+}
+
+// Counts returns the error counts summed over all CPUs and exports them as
+// ztap_ebpf_filter_errors
+func (r *FilterErrorReader) Counts() (FilterErrors, error) {
+	counts := make(FilterErrors, len(filterErrorKinds))
+	for i, kind := range filterErrorKinds {
+		var perCPU []uint64
+		if err := r.errors.Lookup(uint32(i), &perCPU); err != nil {
+			return nil, fmt.Errorf("failed to read eBPF error counter %s: %w", kind, err)
+		}
+		for _, count := range perCPU {
+			counts[kind] += count
+		}
+		metrics.GetCollector().SetEBPFFilterErrors(kind, counts[kind])
+	}
+	return counts, nil
+}
+
+// ReadEvents calls handle with each malformed or truncated packet the filter
+// program reports, until ctx is cancelled. Events the program could not
+// write because the buffers were full are counted as
+// FilterErrorEventDropped.
+func (r *FilterErrorReader) ReadEvents(ctx context.Context, handle func(FilterEvent)) error {
+	reader, err := perf.NewReader(r.events, os.Getpagesize())
+	if err != nil {
+		return fmt.Errorf("failed to read eBPF filter events: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		reader.Close()
+	}()
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, perf.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read eBPF filter events: %w", err)
+		}
+		if record.LostSamples > 0 {
+			continue // Already counted by the program
+		}
+		event, err := parseFilterEvent(record.RawSample)
+		if err != nil {
+			return err
+		}
+		handle(event)
+	}
+}
+
+// Close releases the maps
+func (r *FilterErrorReader) Close() error {
+	r.events.Close()
+	return r.errors.Close()
+}
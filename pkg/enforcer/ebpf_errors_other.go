@@ -0,0 +1,32 @@
+//go:build !linux
+// +build !linux
+
+package enforcer
+
+import (
+	"context"
+	"fmt"
+)
+
+// FilterErrorReader is only supported with the eBPF backend
+type FilterErrorReader struct{}
+
+// OpenFilterErrors is only supported with the eBPF backend
+func OpenFilterErrors() (*FilterErrorReader, error) {
+	return nil, fmt.Errorf("eBPF filter errors require Linux")
+}
+
+// Counts is only supported with the eBPF backend
+func (r *FilterErrorReader) Counts() (FilterErrors, error) {
+	return nil, fmt.Errorf("eBPF filter errors require Linux")
+}
+
+// ReadEvents is only supported with the eBPF backend
+func (r *FilterErrorReader) ReadEvents(ctx context.Context, handle func(FilterEvent)) error {
+	return fmt.Errorf("eBPF filter errors require Linux")
+}
+
+// Close is only supported with the eBPF backend
+func (r *FilterErrorReader) Close() error {
+	return nil
+}
@@ -0,0 +1,55 @@
+package enforcer
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestFilterErrorsSince(t *testing.T) {
+	previous := FilterErrors{FilterErrorMalformed: 3, FilterErrorPolicyMiss: 100, FilterErrorTruncated: 7}
+	current := FilterErrors{FilterErrorMalformed: 5, FilterErrorPolicyMiss: 100, FilterErrorTruncated: 2}
+
+	// The truncated counter went down: the maps were recreated
+	want := FilterErrors{FilterErrorMalformed: 2, FilterErrorTruncated: 2}
+	if got := current.Since(previous); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := want.String(); got != "malformed=2 truncated=2" {
+		t.Errorf("Expected malformed=2 truncated=2, got %q", got)
+	}
+}
+
+func TestParseFilterEvent(t *testing.T) {
+	raw := make([]byte, 16) // Perf samples are padded to 8 bytes
+	binary.NativeEndian.PutUint32(raw[0:], 2)
+	binary.NativeEndian.PutUint32(raw[4:], 0x0a000005)
+	raw[10] = 6
+
+	event, err := parseFilterEvent(raw)
+	if err != nil {
+		t.Fatalf("parseFilterEvent failed: %v", err)
+	}
+	want := FilterEvent{Kind: FilterErrorTruncated, DestIP: "10.0.0.5", Protocol: "TCP"}
+	if event != want {
+		t.Errorf("Expected %+v, got %+v", want, event)
+	}
+	if got := event.String(); got != "truncated TCP packet to 10.0.0.5" {
+		t.Errorf("Unexpected description %q", got)
+	}
+
+	// An unreadable IPv4 header has no destination
+	binary.NativeEndian.PutUint32(raw[0:], 1)
+	binary.NativeEndian.PutUint32(raw[4:], 0)
+	if event, err := parseFilterEvent(raw); err != nil || event.String() != "malformed packet" {
+		t.Errorf("Expected malformed packet, got %v (%v)", event, err)
+	}
+
+	binary.NativeEndian.PutUint32(raw[0:], 9)
+	if _, err := parseFilterEvent(raw); err == nil {
+		t.Error("Expected error for an unknown kind")
+	}
+	if _, err := parseFilterEvent(raw[:8]); err == nil {
+		t.Error("Expected error for a short sample")
+	}
+}
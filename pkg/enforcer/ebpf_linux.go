@@ -4,14 +4,15 @@
 package enforcer
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"net"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 
+	"ztap/pkg/metrics"
 	"ztap/pkg/policy"
 
 	"github.com/cilium/ebpf"
@@ -22,46 +23,108 @@ import (
 // eBPFEnforcer manages eBPF programs for network policy enforcement
 type eBPFEnforcer struct {
 	objs     *bpfObjects
-	links    []link.Link
-	policies []policy.NetworkPolicy
+	links    []cgroupLink
+	policies []*policy.CompiledPolicy
+	entries  map[policyKey]policyValue // current policy map contents
+	pinPath  string                    // bpffs directory for pinned state, empty if not pinned
 }
 
+// PinPath is where the policy map and cgroup links are pinned so enforcement
+// survives restarts of the process that loaded them (e.g. an upgrade)
+const PinPath = "/sys/fs/bpf/ztap"
+
 // bpfObjects contains loaded eBPF programs and maps
 type bpfObjects struct {
-	PolicyMap  *ebpf.Map     `ebpf:"policy_map"`
-	FilterProg *ebpf.Program `ebpf:"filter_egress"`
+	PolicyMap   *ebpf.Map     `ebpf:"policy_map"`
+	ErrorMap    *ebpf.Map     `ebpf:"filter_errors"`
+	EventMap    *ebpf.Map     `ebpf:"filter_events"`
+	FilterProg  *ebpf.Program `ebpf:"filter_egress"`
+	IngressProg *ebpf.Program `ebpf:"filter_ingress"`
 }
 
-// policyKey represents the key for eBPF policy map
-type policyKey struct {
-	DestIP   uint32
-	DestPort uint16
-	Protocol uint8
-	_        uint8 // padding
+// program returns the filter program for one direction
+func (o *bpfObjects) program(ingress bool) *ebpf.Program {
+	if ingress {
+		return o.IngressProg
+	}
+	return o.FilterProg
 }
 
-// policyValue represents the value for eBPF policy map
-type policyValue struct {
-	Action uint8    // 0 = block, 1 = allow
-	_      [3]uint8 // padding
+// cgroupLink attaches the egress or the ingress filter program to a cgroup
+type cgroupLink struct {
+	link.Link
+	ingress bool
 }
 
-// NewEBPFEnforcer creates a new eBPF enforcer
+// pinnedMaps are the maps pinned by name under the pin path, so they outlive
+// the program using them: the policy map keeps its entries and the error
+// counters keep counting across program replacements and restarts
+var pinnedMaps = []string{"policy_map", "filter_errors", "filter_events"}
+
+// NewEBPFEnforcer creates a new eBPF enforcer. It fails with a
+// *PrivilegeError naming the missing capabilities when the process cannot
+// load eBPF programs.
 func NewEBPFEnforcer() (*eBPFEnforcer, error) {
-	// Remove resource limits for loading eBPF programs
+	if err := CheckPrivileges(OpLoadEBPF); err != nil {
+		return nil, err
+	}
+
+	// Remove resource limits for loading eBPF programs. Kernels with memcg
+	// accounting (5.11+) need no limit, so this only fails on older ones.
 	if err := rlimit.RemoveMemlock(); err != nil {
+		if privErr := CheckPrivileges(OpMemlock); privErr != nil {
+			return nil, fmt.Errorf("failed to remove memlock: %w", privErr)
+		}
 		return nil, fmt.Errorf("failed to remove memlock: %w", err)
 	}
 
 	return &eBPFEnforcer{
-		links: make([]link.Link, 0),
+		links:   make([]cgroupLink, 0),
+		entries: make(map[policyKey]policyValue),
 	}, nil
 }
 
-// LoadPolicies loads policies into eBPF maps
-func (e *eBPFEnforcer) LoadPolicies(policies []policy.NetworkPolicy) error {
-	e.policies = policies
+// LoadPolicies loads compiled policies into eBPF maps
+func (e *eBPFEnforcer) LoadPolicies(policies []*policy.CompiledPolicy) error {
+	spec, err := loadCollectionSpec()
+	if err != nil {
+		return err
+	}
+
+	var opts *ebpf.CollectionOptions
+	if e.pinPath != "" {
+		if err := os.MkdirAll(e.pinPath, 0700); err != nil {
+			return fmt.Errorf("failed to create eBPF pin directory: %w", err)
+		}
+		// Reuse the maps a previous process pinned, entries included
+		for _, name := range pinnedMaps {
+			if m, ok := spec.Maps[name]; ok {
+				m.Pinning = ebpf.PinByName
+			}
+		}
+		opts = &ebpf.CollectionOptions{Maps: ebpf.MapOptions{PinPath: e.pinPath}}
+	}
+
+	objs := &bpfObjects{}
+	if err := spec.LoadAndAssign(objs, opts); err != nil {
+		return fmt.Errorf("failed to load eBPF objects: %w", err)
+	}
+	e.objs = objs
 
+	if e.pinPath != "" {
+		if err := e.readEntries(); err != nil {
+			return err
+		}
+	}
+
+	// Populate policy map
+	return e.UpdatePolicies(policies)
+}
+
+// loadCollectionSpec loads the spec of the first compiled eBPF object found,
+// checking ZTAP_BPF_OBJECT, the repository's bpf directory and the
+// system-wide locations
+func loadCollectionSpec() (*ebpf.CollectionSpec, error) {
 	// Try to load eBPF object file
 	// First check if compiled BPF program exists
 	// Determine repo root based on this source file location to handle tests run from package dirs
@@ -89,8 +152,6 @@ func (e *eBPFEnforcer) LoadPolicies(policies []policy.NetworkPolicy) error {
 		filepath.Join(os.Getenv("HOME"), ".ztap", "bpf", "filter.o"),
 	}
 
-	var spec *ebpf.CollectionSpec
-	var err error
 	var attempts []string
 
 	for _, path := range bpfPaths {
@@ -105,103 +166,254 @@ func (e *eBPFEnforcer) LoadPolicies(policies []policy.NetworkPolicy) error {
 			attempts = append(attempts, fmt.Sprintf("%s: %v", path, statErr))
 			continue
 		}
-		spec, err = ebpf.LoadCollectionSpec(path)
+		spec, err := ebpf.LoadCollectionSpec(path)
 		if err == nil {
 			log.Printf("Loaded eBPF spec from: %s", path)
-			break
+			return spec, nil
 		}
 		attempts = append(attempts, fmt.Sprintf("%s: %v", path, err))
 	}
 
-	if spec == nil {
-		// Provide detailed diagnostic information
-		return fmt.Errorf("failed to load eBPF object. Please compile with: 'cd bpf && make'. Attempts: [%s]",
-			strings.Join(attempts, "; "))
-	}
+	// Provide detailed diagnostic information
+	return nil, fmt.Errorf("failed to load eBPF object. Please compile with: 'cd bpf && make'. Attempts: [%s]",
+		strings.Join(attempts, "; "))
+}
 
-	objs := &bpfObjects{}
-	if err := spec.LoadAndAssign(objs, nil); err != nil {
-		return fmt.Errorf("failed to load eBPF objects: %w", err)
+// UpdatePolicies syncs the policy map to the entries derived from compiled
+// policies, writing changed entries and deleting stale ones in batches
+func (e *eBPFEnforcer) UpdatePolicies(policies []*policy.CompiledPolicy) error {
+	if e.objs == nil {
+		return fmt.Errorf("eBPF objects not loaded")
 	}
-	e.objs = objs
 
-	// Populate policy map
+	desired := make(map[policyKey]policyValue)
 	for _, p := range policies {
-		if err := e.addPolicyToMap(p); err != nil {
-			log.Printf("Warning: Failed to add policy '%s': %v", p.Metadata.Name, err)
+		for _, rule := range p.Rules {
+			if err := addRuleEntry(rule, desired); err != nil {
+				log.Printf("Warning: Failed to add rule for policy '%s': %v", p.Name, err)
+			}
 		}
 	}
 
+	changed := make(map[policyKey]policyValue)
+	for key, value := range desired {
+		if current, exists := e.entries[key]; !exists || current != value {
+			changed[key] = value
+		}
+	}
+	var stale []policyKey
+	for key := range e.entries {
+		if _, exists := desired[key]; !exists {
+			stale = append(stale, key)
+		}
+	}
+
+	if err := writeEntries(e.objs.PolicyMap, changed); err != nil {
+		return err
+	}
+	if err := deleteEntries(e.objs.PolicyMap, stale); err != nil {
+		return err
+	}
+
+	e.entries = desired
+	e.policies = policies
+	log.Printf("eBPF policy map synced: %d entries (%d written, %d removed)",
+		len(desired), len(changed), len(stale))
+
+	metrics.GetCollector().SetEBPFMapUsage(len(desired), int(e.objs.PolicyMap.MaxEntries()))
 	return nil
 }
 
-// addPolicyToMap adds a policy to the eBPF map
-func (e *eBPFEnforcer) addPolicyToMap(p policy.NetworkPolicy) error {
-	for _, egress := range p.Spec.Egress {
-		// Handle IP-based rules
-		if egress.To.IPBlock.CIDR != "" {
-			ip, ipnet, err := net.ParseCIDR(egress.To.IPBlock.CIDR)
-			if err != nil {
-				return fmt.Errorf("invalid CIDR %s: %w", egress.To.IPBlock.CIDR, err)
-			}
+// addRuleEntry adds the map entries for a compiled rule to entries (see
+// ruleEntries) and logs them
+func addRuleEntry(rule policy.Rule, entries map[policyKey]policyValue) error {
+	if err := ruleEntries(rule, entries); err != nil {
+		return err
+	}
+	if rule.Ingress() {
+		log.Printf("Added eBPF rule: %s <- %s:%s (ALLOW)", rule.Policy, rule.CIDR, rule.PortRange())
+	} else {
+		log.Printf("Added eBPF rule: %s -> %s:%s (ALLOW)", rule.Policy, rule.CIDR, rule.PortRange())
+	}
+	return nil
+}
 
-			// For simplicity, use network address (full CIDR support requires range)
-			destIP := ipToUint32(ip.To4())
+// SetPinPath pins the maps and cgroup links under dir (see PinPath).
+// A later enforcer with the same pin path picks up the pinned state instead
+// of starting empty, and enforcement continues in between. Must be called
+// before LoadPolicies.
+func (e *eBPFEnforcer) SetPinPath(dir string) {
+	e.pinPath = dir
+}
 
-			for _, port := range egress.Ports {
-				key := policyKey{
-					DestIP:   destIP,
-					DestPort: uint16(port.Port),
-					Protocol: protocolToNum(port.Protocol),
-				}
+// readEntries loads the current contents of a pinned policy map, so the next
+// sync only writes what changed
+func (e *eBPFEnforcer) readEntries() error {
+	entries := make(map[policyKey]policyValue)
+	var key policyKey
+	var value policyValue
+	iter := e.objs.PolicyMap.Iterate()
+	for iter.Next(&key, &value) {
+		entries[key] = value
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("failed to read pinned policy map: %w", err)
+	}
+	e.entries = entries
+	return nil
+}
 
-				value := policyValue{
-					Action: 1, // allow
-				}
+// linkPinPath returns where the link attaching the egress or ingress filter
+// to cgroupPath is pinned
+func (e *eBPFEnforcer) linkPinPath(cgroupPath string, ingress bool) string {
+	name := strings.ReplaceAll(strings.Trim(filepath.Clean(cgroupPath), "/"), "/", "_")
+	if name == "" {
+		name = "root"
+	}
+	if ingress {
+		return filepath.Join(e.pinPath, "ingress_"+name)
+	}
+	return filepath.Join(e.pinPath, "egress_"+name)
+}
 
-				if err := e.objs.PolicyMap.Put(&key, &value); err != nil {
-					return fmt.Errorf("failed to update policy map: %w", err)
-				}
+// Attach attaches the egress and ingress filter programs to cgroup. The path
+// is resolved to the cgroup v2 hierarchy on hybrid and cgroup v1 hosts (see
+// attachPath). With a pin path, a link pinned by a previous process is
+// switched to this process's program in place, so the cgroup is never left
+// unfiltered.
+func (e *eBPFEnforcer) Attach(cgroupPath string) error {
+	if e.objs == nil {
+		return fmt.Errorf("eBPF objects not loaded")
+	}
+	if err := CheckPrivileges(OpAttachCgroup); err != nil {
+		return err
+	}
+	cgroupPath, err := attachPath(cgroupPath)
+	if err != nil {
+		return err
+	}
 
-				log.Printf("Added eBPF rule: %s -> %s:%d (ALLOW)",
-					p.Metadata.Name, ipnet.String(), port.Port)
+	for _, ingress := range []bool{false, true} {
+		if err := e.attach(cgroupPath, ingress); err != nil {
+			return err
+		}
+	}
+	log.Printf("eBPF program attached to cgroup: %s", cgroupPath)
+	return nil
+}
+
+// attach attaches the filter program for one direction to cgroupPath
+func (e *eBPFEnforcer) attach(cgroupPath string, ingress bool) error {
+	prog := e.objs.program(ingress)
+	if e.pinPath != "" {
+		if l, err := link.LoadPinnedLink(e.linkPinPath(cgroupPath, ingress), nil); err == nil {
+			if err := l.Update(prog); err != nil {
+				l.Close()
+				return fmt.Errorf("failed to update pinned cgroup link: %w", err)
 			}
+			e.links = append(e.links, cgroupLink{Link: l, ingress: ingress})
+			log.Printf("eBPF program replaced on pinned cgroup link: %s", cgroupPath)
+			return nil
 		}
+	}
 
-		// Handle label-based rules (requires resolution)
-		if len(egress.To.PodSelector.MatchLabels) > 0 {
-			log.Printf("Warning: Label-based rules require IP resolution for policy '%s'",
-				p.Metadata.Name)
-			// In production: resolve labels to IPs via service discovery, then add to map
+	attachType := ebpf.AttachCGroupInetEgress
+	if ingress {
+		attachType = ebpf.AttachCGroupInetIngress
+	}
+	l, err := link.AttachCgroup(link.CgroupOptions{
+		Path:    cgroupPath,
+		Attach:  attachType,
+		Program: prog,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to cgroup: %w", err)
+	}
+
+	if e.pinPath != "" {
+		if err := l.Pin(e.linkPinPath(cgroupPath, ingress)); err != nil {
+			l.Close()
+			return fmt.Errorf("failed to pin cgroup link: %w", err)
 		}
 	}
 
+	e.links = append(e.links, cgroupLink{Link: l, ingress: ingress})
 	return nil
 }
 
-// Attach attaches the eBPF program to cgroup
-func (e *eBPFEnforcer) Attach(cgroupPath string) error {
+// Reload replaces the filter program with the one in the eBPF object found
+// now (e.g. a filter.o installed by an upgrade) without detaching it from any
+// cgroup. The new program is loaded against the live maps, so the enforced
+// entries and error counters carry over, and each link is switched to it
+// atomically with link.Update: every packet is filtered by either the old or
+// the new program. If a link cannot be switched, the links already switched
+// are moved back and the old program stays in place. Cgroups attached by a
+// build without the ingress filter get it on the next Attach.
+func (e *eBPFEnforcer) Reload() error {
 	if e.objs == nil {
 		return fmt.Errorf("eBPF objects not loaded")
 	}
-
-	// Attach to cgroup egress
-	l, err := link.AttachCgroup(link.CgroupOptions{
-		Path:    cgroupPath,
-		Attach:  ebpf.AttachCGroupInetEgress,
-		Program: e.objs.FilterProg,
-	})
+	spec, err := loadCollectionSpec()
 	if err != nil {
-		return fmt.Errorf("failed to attach to cgroup: %w", err)
+		return err
 	}
 
-	e.links = append(e.links, l)
-	log.Printf("eBPF program attached to cgroup: %s", cgroupPath)
+	objs := &bpfObjects{}
+	opts := &ebpf.CollectionOptions{
+		MapReplacements: map[string]*ebpf.Map{
+			"policy_map":    e.objs.PolicyMap,
+			"filter_errors": e.objs.ErrorMap,
+			"filter_events": e.objs.EventMap,
+		},
+	}
+	if err := spec.LoadAndAssign(objs, opts); err != nil {
+		if errors.Is(err, ebpf.ErrMapIncompatible) {
+			return fmt.Errorf("new eBPF program cannot reuse the loaded maps (unpin and re-enforce to change their layout): %w", err)
+		}
+		return fmt.Errorf("failed to load eBPF objects: %w", err)
+	}
 
+	for i, l := range e.links {
+		if err := l.Update(objs.program(l.ingress)); err != nil {
+			for _, done := range e.links[:i] {
+				if err := done.Update(e.objs.program(done.ingress)); err != nil {
+					log.Printf("Warning: Failed to restore previous eBPF program: %v", err)
+				}
+			}
+			objs.close()
+			return fmt.Errorf("failed to swap eBPF program, previous program kept: %w", err)
+		}
+	}
+
+	e.objs.close()
+	e.objs = objs
+	log.Printf("eBPF program replaced on %d cgroup link(s), %d policy entries kept", len(e.links), len(e.entries))
 	return nil
 }
 
-// Close cleans up eBPF resources
+// Unpin removes the pinned maps and links, so enforcement stops once this
+// enforcer is closed
+func (e *eBPFEnforcer) Unpin() error {
+	for _, l := range e.links {
+		if err := l.Unpin(); err != nil {
+			return fmt.Errorf("failed to unpin cgroup link: %w", err)
+		}
+	}
+	if e.objs != nil {
+		for _, m := range []*ebpf.Map{e.objs.PolicyMap, e.objs.ErrorMap, e.objs.EventMap} {
+			if m == nil {
+				continue
+			}
+			if err := m.Unpin(); err != nil {
+				return fmt.Errorf("failed to unpin eBPF map: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Close cleans up eBPF resources. Pinned links and maps stay in place and
+// keep enforcing (see Unpin).
 func (e *eBPFEnforcer) Close() error {
 	// Detach programs
 	for _, l := range e.links {
@@ -212,45 +424,37 @@ func (e *eBPFEnforcer) Close() error {
 
 	// Close maps and programs
 	if e.objs != nil {
-		if e.objs.PolicyMap != nil {
-			e.objs.PolicyMap.Close()
-		}
-		if e.objs.FilterProg != nil {
-			e.objs.FilterProg.Close()
-		}
+		e.objs.close()
 	}
 
 	return nil
 }
 
-// Helper functions
-
-func ipToUint32(ip net.IP) uint32 {
-	if ip == nil {
-		return 0
+// close releases this process's handles on the map and program. Links and
+// pins hold their own references.
+func (o *bpfObjects) close() {
+	for _, m := range []*ebpf.Map{o.PolicyMap, o.ErrorMap, o.EventMap} {
+		if m != nil {
+			m.Close()
+		}
 	}
-	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
-}
-
-func protocolToNum(protocol string) uint8 {
-	switch strings.ToUpper(protocol) {
-	case "TCP":
-		return 6
-	case "UDP":
-		return 17
-	case "ICMP":
-		return 1
-	default:
-		return 0
+	for _, p := range []*ebpf.Program{o.FilterProg, o.IngressProg} {
+		if p != nil {
+			p.Close()
+		}
 	}
 }
 
-// EnforceWithEBPFReal uses actual eBPF enforcement (requires root)
-func EnforceWithEBPFReal(policies []policy.NetworkPolicy, cgroupPath string) error {
+// EnforceWithEBPFReal uses actual eBPF enforcement (requires root or the
+// capabilities of OpLoadEBPF and OpAttachCgroup). State is pinned under
+// PinPath, so enforcement outlives the process and is updated in place by
+// the next call.
+func EnforceWithEBPFReal(policies []*policy.CompiledPolicy, cgroupPath string) error {
 	enforcer, err := NewEBPFEnforcer()
 	if err != nil {
 		return fmt.Errorf("failed to create eBPF enforcer: %w", err)
 	}
+	enforcer.SetPinPath(PinPath)
 
 	if err := enforcer.LoadPolicies(policies); err != nil {
 		return fmt.Errorf("failed to load policies: %w", err)
@@ -39,8 +39,11 @@ func TestEBPFIntegrationLoadAndAttach(t *testing.T) {
 		}
 	})
 
-	policies := []policy.NetworkPolicy{allowTCPPolicy("allow-web", "10.1.2.0/24", 443)}
-	if err := enf.LoadPolicies(policies); err != nil {
+	compiled, err := policy.NewPolicyResolver(nil).Compile(allowTCPPolicy("allow-web", "10.1.2.0/24", 443))
+	if err != nil {
+		t.Fatalf("failed to compile policy: %v", err)
+	}
+	if err := enf.LoadPolicies([]*policy.CompiledPolicy{compiled}); err != nil {
 		t.Fatalf("failed to load policies: %v", err)
 	}
 
@@ -69,6 +72,64 @@ func TestEBPFIntegrationLoadAndAttach(t *testing.T) {
 	}
 }
 
+// TestEBPFIntegrationReload verifies that Reload swaps the program on an
+// attached cgroup link while keeping the policy map entries. Requires root.
+func TestEBPFIntegrationReload(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root privileges; re-run with sudo or CAP_BPF + CAP_NET_ADMIN")
+	}
+
+	compileTestBPF(t)
+
+	enf, err := NewEBPFEnforcer()
+	if err != nil {
+		t.Fatalf("failed to create enforcer: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := enf.Close(); err != nil {
+			t.Errorf("failed to close enforcer: %v", err)
+		}
+	})
+
+	compiled, err := policy.NewPolicyResolver(nil).Compile(allowTCPPolicy("allow-web", "10.1.2.0/24", 443))
+	if err != nil {
+		t.Fatalf("failed to compile policy: %v", err)
+	}
+	if err := enf.LoadPolicies([]*policy.CompiledPolicy{compiled}); err != nil {
+		t.Fatalf("failed to load policies: %v", err)
+	}
+	if err := enf.Attach(createTestCgroup(t)); err != nil {
+		t.Fatalf("failed to attach program: %v", err)
+	}
+
+	oldInfo, err := enf.objs.FilterProg.Info()
+	if err != nil {
+		t.Fatalf("failed to get program info: %v", err)
+	}
+	if err := enf.Reload(); err != nil {
+		t.Fatalf("failed to reload program: %v", err)
+	}
+	newInfo, err := enf.objs.FilterProg.Info()
+	if err != nil {
+		t.Fatalf("failed to get program info: %v", err)
+	}
+	oldID, _ := oldInfo.ID()
+	newID, _ := newInfo.ID()
+	if oldID == newID {
+		t.Fatalf("expected a new program, still running %d", oldID)
+	}
+
+	key := policyKey{
+		DestIP:   ipToUint32(net.ParseIP("10.1.2.0").To4()),
+		DestPort: 443,
+		Protocol: protocolToNum("TCP"),
+	}
+	var value policyValue
+	if err := enf.objs.PolicyMap.Lookup(&key, &value); err != nil || value.Action != 1 {
+		t.Fatalf("expected the allow entry to survive the reload, got %+v (%v)", value, err)
+	}
+}
+
 func compileTestBPF(t *testing.T) {
 	t.Helper()
 
@@ -123,18 +184,13 @@ func allowTCPPolicy(name, cidr string, port int) policy.NetworkPolicy {
 			IPBlock struct {
 				CIDR string "yaml:\"cidr\""
 			} "yaml:\"ipBlock,omitempty\""
+			Group string "yaml:\"group,omitempty\""
 		} "yaml:\"to\""
-		Ports []struct {
-			Protocol string "yaml:\"protocol\""
-			Port     int    "yaml:\"port\""
-		} "yaml:\"ports\""
+		Ports []policy.PolicyPort "yaml:\"ports\""
 	}{}
 
 	egressRule.To.IPBlock.CIDR = cidr
-	egressRule.Ports = append(egressRule.Ports, struct {
-		Protocol string "yaml:\"protocol\""
-		Port     int    "yaml:\"port\""
-	}{
+	egressRule.Ports = append(egressRule.Ports, policy.PolicyPort{
 		Protocol: "TCP",
 		Port:     port,
 	})
@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package enforcer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+
+	"ztap/pkg/policy"
+
+	"github.com/cilium/ebpf"
+)
+
+// PolicyMapReader looks up flows in the policy map pinned under PinPath by a
+// running agent, the same lookup the filter program makes per packet
+type PolicyMapReader struct {
+	m *ebpf.Map
+}
+
+// OpenPinnedPolicyMap opens the pinned policy map read-only
+func OpenPinnedPolicyMap() (*PolicyMapReader, error) {
+	if err := CheckPrivileges(OpLoadEBPF); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(PinPath, "policy_map")
+	m, err := ebpf.LoadPinnedMap(path, &ebpf.LoadPinOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pinned policy map %s (is 'ztap agent' enforcing with eBPF?): %w", path, err)
+	}
+	return &PolicyMapReader{m: m}, nil
+}
+
+// Allowed reports whether the map allows traffic to destIP:port over protocol
+func (r *PolicyMapReader) Allowed(destIP string, port int, protocol string) (bool, error) {
+	ip := net.ParseIP(destIP).To4()
+	if ip == nil {
+		return false, fmt.Errorf("invalid IPv4 destination %q", destIP)
+	}
+	key := policyKey{
+		DestIP:   ipToUint32(ip),
+		DestPort: uint16(port),
+		Protocol: protocolToNum(protocol),
+	}
+
+	var value policyValue
+	if err := r.m.Lookup(&key, &value); err != nil {
+		if errors.Is(err, ebpf.ErrKeyNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return value.Action == 1, nil
+}
+
+// Entries returns every rule entry of the map
+func (r *PolicyMapReader) Entries() ([]PolicyEntry, error) {
+	var entries []PolicyEntry
+	var key policyKey
+	var value policyValue
+	iter := r.m.Iterate()
+	for iter.Next(&key, &value) {
+		if key == ingressRestrictedKey {
+			continue
+		}
+		direction := ""
+		if key.Direction == keyIngress {
+			direction = policy.DirectionIngress
+		}
+		ip := uint32ToIP(key.DestIP).String()
+		action := "block"
+		if value.Action == 1 {
+			action = "allow"
+		}
+		entries = append(entries, PolicyEntry{
+			IP:        ip,
+			CIDR:      ip + "/32",
+			Port:      int(key.DestPort),
+			Protocol:  protocolName(key.Protocol),
+			Action:    action,
+			Direction: direction,
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pinned policy map: %w", err)
+	}
+	return entries, nil
+}
+
+// Close releases the map
+func (r *PolicyMapReader) Close() error {
+	return r.m.Close()
+}
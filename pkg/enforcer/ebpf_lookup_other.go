@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package enforcer
+
+import "fmt"
+
+// PolicyMapReader is only supported with the eBPF backend
+type PolicyMapReader struct{}
+
+// OpenPinnedPolicyMap is only supported with the eBPF backend
+func OpenPinnedPolicyMap() (*PolicyMapReader, error) {
+	return nil, fmt.Errorf("the eBPF policy map requires Linux")
+}
+
+// Allowed is only supported with the eBPF backend
+func (r *PolicyMapReader) Allowed(destIP string, port int, protocol string) (bool, error) {
+	return false, fmt.Errorf("the eBPF policy map requires Linux")
+}
+
+// Entries is only supported with the eBPF backend
+func (r *PolicyMapReader) Entries() ([]PolicyEntry, error) {
+	return nil, fmt.Errorf("the eBPF policy map requires Linux")
+}
+
+// Close is only supported with the eBPF backend
+func (r *PolicyMapReader) Close() error {
+	return nil
+}
@@ -0,0 +1,109 @@
+package enforcer
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"ztap/pkg/policy"
+)
+
+// policyKey represents the key for eBPF policy map. Ingress entries hold the
+// source address in DestIP and the local port in DestPort.
+type policyKey struct {
+	DestIP    uint32
+	DestPort  uint16
+	Protocol  uint8
+	Direction uint8
+}
+
+// Policy key directions (must match bpf/filter.c)
+const (
+	keyEgress  uint8 = 0
+	keyIngress uint8 = 1
+	// keyIngressRestricted marks that some policy has ingress rules, so the
+	// ingress filter denies inbound traffic none of them allows
+	keyIngressRestricted uint8 = 2
+)
+
+// ingressRestrictedKey is present in the policy map while inbound traffic
+// is filtered
+var ingressRestrictedKey = policyKey{Direction: keyIngressRestricted}
+
+// policyValue represents the value for eBPF policy map
+type policyValue struct {
+	Action uint8    // 0 = block, 1 = allow
+	_      [3]uint8 // padding
+}
+
+// ruleEntries adds the map entries for a compiled rule to entries, one per
+// port of its range. Label selectors are already resolved to host CIDRs by
+// compilation. An ingress rule also adds ingressRestrictedKey, even if its
+// own entries cannot be added, so inbound traffic is filtered from then on.
+func ruleEntries(rule policy.Rule, entries map[policyKey]policyValue) error {
+	direction := keyEgress
+	if rule.Ingress() {
+		direction = keyIngress
+		entries[ingressRestrictedKey] = policyValue{Action: 1}
+	}
+
+	ip, _, err := net.ParseCIDR(rule.CIDR)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %s: %w", rule.CIDR, err)
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("IPv6 peer %s is not supported by the eBPF policy map", rule.CIDR)
+	}
+	// The map matches exact ports, so a range takes one entry per port
+	if ports := rule.Ports(); ports > MaxEBPFPortRange {
+		return fmt.Errorf("port range %s spans %d ports, more than the %d the eBPF policy map expands", rule.PortRange(), ports, MaxEBPFPortRange)
+	}
+
+	// For simplicity, use network address (full CIDR support requires range)
+	for port := rule.Port; port <= rule.LastPort(); port++ {
+		key := policyKey{
+			DestIP:    ipToUint32(ip.To4()),
+			DestPort:  uint16(port),
+			Protocol:  protocolToNum(rule.Protocol),
+			Direction: direction,
+		}
+		entries[key] = policyValue{
+			Action: 1, // allow
+		}
+	}
+	return nil
+}
+
+// EBPFMapEntries returns the number of policy map entries the eBPF backend
+// writes for policies: one per IPv4 peer, protocol and port of a range,
+// without duplicates, and a marker while any policy has ingress rules. Rules
+// the backend skips, such as IPv6 peers, take none.
+func EBPFMapEntries(policies []*policy.CompiledPolicy) int {
+	entries := make(map[policyKey]policyValue)
+	for _, p := range policies {
+		for _, rule := range p.Rules {
+			ruleEntries(rule, entries)
+		}
+	}
+	return len(entries)
+}
+
+func ipToUint32(ip net.IP) uint32 {
+	if ip == nil {
+		return 0
+	}
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func protocolToNum(protocol string) uint8 {
+	switch strings.ToUpper(protocol) {
+	case "TCP":
+		return 6
+	case "UDP":
+		return 17
+	case "ICMP":
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,28 @@
+package enforcer
+
+import (
+	"testing"
+
+	"ztap/pkg/policy"
+)
+
+func TestEBPFMapEntries(t *testing.T) {
+	policies := []*policy.CompiledPolicy{
+		{Name: "web", Rules: []policy.Rule{
+			{CIDR: "10.0.2.1/32", Protocol: "TCP", Port: 8000, EndPort: 8009},
+			{CIDR: "2001:db8::1/128", Protocol: "TCP", Port: 443},
+		}},
+		{Name: "db", Rules: []policy.Rule{
+			{CIDR: "10.0.2.1/32", Protocol: "TCP", Port: 8000},
+			{CIDR: "10.9.0.0/16", Protocol: "TCP", Port: 5432, Direction: policy.DirectionIngress},
+		}},
+	}
+	// Ten range entries, the ingress rule and its marker; the IPv6 peer and
+	// the duplicate port take none
+	if entries := EBPFMapEntries(policies); entries != 12 {
+		t.Errorf("Expected 12 entries, got %d", entries)
+	}
+	if entries := EBPFMapEntries(nil); entries != 0 {
+		t.Errorf("Expected no entries, got %d", entries)
+	}
+}
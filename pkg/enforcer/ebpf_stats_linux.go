@@ -0,0 +1,77 @@
+//go:build linux
+// +build linux
+
+package enforcer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"ztap/pkg/metrics"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+// ebpfProgramName is the name of the filter program in bpf/filter.c
+const ebpfProgramName = "filter_egress"
+
+// EnableProgramStats makes the kernel account eBPF program run time until the
+// returned closer is closed. Accounting adds a small cost to every program
+// run, so it is opt-in. Requires CAP_SYS_ADMIN and at least Linux 5.8.
+func EnableProgramStats() (io.Closer, error) {
+	if err := CheckPrivileges(OpProgramStats); err != nil {
+		return nil, err
+	}
+	closer, err := ebpf.EnableStats(uint32(unix.BPF_STATS_RUN_TIME))
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable eBPF program statistics: %w", err)
+	}
+	return closer, nil
+}
+
+// SampleProgramStats exports the summed run time and run count of every
+// loaded ZTAP filter program, whichever process loaded it
+func SampleProgramStats() error {
+	var runTime time.Duration
+	var runs uint64
+	found := false
+
+	var id ebpf.ProgramID
+	for {
+		next, err := ebpf.ProgramGetNextID(id)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to list eBPF programs: %w", err)
+		}
+		id = next
+
+		prog, err := ebpf.NewProgramFromID(id)
+		if err != nil {
+			continue // Unloaded since it was listed
+		}
+		info, err := prog.Info()
+		if err == nil && info.Name == ebpfProgramName {
+			stats, err := prog.Stats()
+			if err != nil {
+				prog.Close()
+				return fmt.Errorf("failed to read eBPF program statistics: %w", err)
+			}
+			runTime += stats.Runtime
+			runs += stats.RunCount
+			found = true
+		}
+		prog.Close()
+	}
+
+	if !found {
+		return fmt.Errorf("no %s eBPF program is loaded", ebpfProgramName)
+	}
+	metrics.GetCollector().SetEBPFProgramStats(runTime, runs)
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package enforcer
+
+import (
+	"fmt"
+	"io"
+)
+
+// EnableProgramStats is only supported with the eBPF backend
+func EnableProgramStats() (io.Closer, error) {
+	return nil, fmt.Errorf("eBPF program statistics require Linux")
+}
+
+// SampleProgramStats is only supported with the eBPF backend
+func SampleProgramStats() error {
+	return fmt.Errorf("eBPF program statistics require Linux")
+}
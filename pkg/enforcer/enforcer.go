@@ -2,9 +2,6 @@ package enforcer
 
 import (
 	"fmt"
-	"log"
-	"os"
-	"os/exec"
 	"runtime"
 	"ztap/pkg/policy"
 )
@@ -15,65 +12,27 @@ func IsLinux() bool {
 }
 
 // EnforceWithEBPF (Linux) - placeholder for real eBPF logic
-func EnforceWithEBPF(policies []policy.NetworkPolicy) {
+func EnforceWithEBPF(policies []*policy.CompiledPolicy) error {
 	fmt.Printf("Applying %d eBPF-based policies on Linux\n", len(policies))
 	// In production: load eBPF programs, attach to cgroup/socket hooks
 	// For demonstration: simulate with logs
 	for _, p := range policies {
-		fmt.Printf("  • Policy '%s': %s → %v\n",
-			p.Metadata.Name,
-			p.Spec.PodSelector.MatchLabels,
-			p.Spec.Egress)
-	}
-}
-
-// EnforceWithPF (macOS) - uses pfctl to manage rules
-func EnforceWithPF(policies []policy.NetworkPolicy) {
-	fmt.Printf("Applying %d pf-based policies on macOS\n", len(policies))
-
-	if os.Getenv("ZTAP_SKIP_PF") == "1" {
-		log.Println("Skipping pf enforcement due to ZTAP_SKIP_PF environment override")
-		return
-	}
-
-	if os.Geteuid() != 0 {
-		log.Println("pf enforcement requires root privileges; skipping rule application")
-		return
-	}
-
-	// Create anchor file content
-	anchorContent := "# ZTAP Managed Rules\n"
-
-	for _, p := range policies {
-		anchorContent += fmt.Sprintf("# Policy: %s\n", p.Metadata.Name)
-		for _, egress := range p.Spec.Egress {
-			if len(egress.To.PodSelector.MatchLabels) > 0 {
-				// In real world: resolve labels to IPs (via DNS or inventory)
-				anchorContent += "# Note: Label-based rules require inventory resolution\n"
-				anchorContent += "block out quick from any to 192.168.0.0/16\n"
-			}
-			if egress.To.IPBlock.CIDR != "" {
-				for _, port := range egress.Ports {
-					anchorContent += fmt.Sprintf("block out quick proto %s from any to %s port = %d\n",
-						port.Protocol, egress.To.IPBlock.CIDR, port.Port)
-				}
-			}
+		fmt.Printf("  • Policy '%s': %d rule(s)\n", p.Name, len(p.Rules))
+		for _, r := range p.Rules {
+			fmt.Printf("      %s %s:%s\n", r.Protocol, r.CIDR, r.PortRange())
 		}
 	}
+	return nil
+}
 
-	// Write to anchor file (requires sudo in real use)
-	anchorFile := "/etc/pf.anchors/ztap"
-	cmd := exec.Command("sudo", "sh", "-c", fmt.Sprintf("mkdir -p /etc/pf.anchors && echo '%s' > %s", anchorContent, anchorFile))
-	err := cmd.Run()
-	if err != nil {
-		log.Printf("Warning: pf rules require sudo. Demo mode only.")
-	}
-
-	// Ensure anchor is loaded in pf.conf
-	pfConf := "/etc/pf.conf"
-	pfContent := "anchor \"ztap\"\nload anchor \"ztap\" from \"/etc/pf.anchors/ztap\"\n"
-	cmd2 := exec.Command("sudo", "sh", "-c", fmt.Sprintf("grep -q 'anchor \"ztap\"' %s || echo '%s' >> %s", pfConf, pfContent, pfConf))
-	cmd2.Run() // Ignore errors (file may be read-only)
-
-	fmt.Println("Note: Full enforcement requires sudo. See docs for production setup.")
+// PolicyEntry is an entry of the eBPF policy map. The filter program matches
+// the exact peer address, so CIDR is always a host route. For ingress
+// entries IP is the source and Port the local port.
+type PolicyEntry struct {
+	IP        string `json:"ip"`
+	CIDR      string `json:"cidr"`
+	Port      int    `json:"port"`
+	Protocol  string `json:"protocol"`
+	Action    string `json:"action"`              // allow or block
+	Direction string `json:"direction,omitempty"` // policy.DirectionIngress, or egress if empty
 }
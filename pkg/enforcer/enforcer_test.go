@@ -5,6 +5,7 @@ package enforcer
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"ztap/pkg/policy"
 )
@@ -30,6 +31,9 @@ func TestProtocolToNum(t *testing.T) {
 			if result != tt.expected {
 				t.Errorf("protocolToNum(%s) = %d, expected %d", tt.protocol, result, tt.expected)
 			}
+			if result != 0 && protocolName(result) != strings.ToUpper(tt.protocol) {
+				t.Errorf("protocolName(%d) = %s, expected %s", result, protocolName(result), strings.ToUpper(tt.protocol))
+			}
 		})
 	}
 }
@@ -54,6 +58,9 @@ func TestIPToUint32(t *testing.T) {
 			if result != tt.expected {
 				t.Errorf("ipToUint32(%s) = 0x%X, expected 0x%X", tt.ip, result, tt.expected)
 			}
+			if back := uint32ToIP(result).String(); back != tt.ip {
+				t.Errorf("uint32ToIP(0x%X) = %s, expected %s", result, back, tt.ip)
+			}
 		})
 	}
 }
@@ -133,18 +140,14 @@ func TestCreatePolicyFromYAML(t *testing.T) {
 			IPBlock struct {
 				CIDR string `yaml:"cidr"`
 			} `yaml:"ipBlock,omitempty"`
+			Group string `yaml:"group,omitempty"`
 		} `yaml:"to"`
-		Ports []struct {
-			Protocol string `yaml:"protocol"`
-			Port     int    `yaml:"port"`
-		} `yaml:"ports"`
+		Ports []policy.PolicyPort `yaml:"ports"`
+		When  string              `yaml:"when,omitempty"`
 	}{}
 
 	egress.To.IPBlock.CIDR = "10.0.0.0/8"
-	egress.Ports = []struct {
-		Protocol string `yaml:"protocol"`
-		Port     int    `yaml:"port"`
-	}{
+	egress.Ports = []policy.PolicyPort{
 		{Protocol: "TCP", Port: 443},
 	}
 
@@ -167,3 +170,53 @@ func TestCreatePolicyFromYAML(t *testing.T) {
 		t.Errorf("Port mismatch")
 	}
 }
+
+func TestAddRuleEntry(t *testing.T) {
+	entries := make(map[policyKey]policyValue)
+
+	rule := policy.Rule{Policy: "web", CIDR: "10.0.2.1/32", Protocol: "TCP", Port: 5432}
+	if err := addRuleEntry(rule, entries); err != nil {
+		t.Fatalf("addRuleEntry failed: %v", err)
+	}
+	key := policyKey{DestIP: 0x0A000201, DestPort: 5432, Protocol: 6}
+	if value, exists := entries[key]; !exists || value.Action != 1 {
+		t.Errorf("Expected allow entry for %+v, got %v", key, entries)
+	}
+
+	if err := addRuleEntry(policy.Rule{Policy: "v6", CIDR: "2001:db8::1/128", Protocol: "TCP", Port: 443}, entries); err == nil {
+		t.Error("Expected IPv6 rule to be rejected")
+	}
+	if err := addRuleEntry(policy.Rule{Policy: "bad", CIDR: "not-a-cidr", Protocol: "TCP", Port: 443}, entries); err == nil {
+		t.Error("Expected invalid CIDR to be rejected")
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected 1 entry, got %d", len(entries))
+	}
+
+	// Ingress rules are keyed by source, and turn on inbound filtering
+	rule = policy.Rule{Policy: "db", CIDR: "10.0.1.7/32", Protocol: "TCP", Port: 5432, Direction: policy.DirectionIngress}
+	if err := addRuleEntry(rule, entries); err != nil {
+		t.Fatalf("addRuleEntry failed: %v", err)
+	}
+	key = policyKey{DestIP: 0x0A000107, DestPort: 5432, Protocol: 6, Direction: keyIngress}
+	if _, exists := entries[key]; !exists {
+		t.Errorf("Expected ingress entry for %+v, got %v", key, entries)
+	}
+	if _, exists := entries[ingressRestrictedKey]; !exists || len(entries) != 3 {
+		t.Errorf("Expected the ingress marker, got %v", entries)
+	}
+
+	// Ranges take one entry per port, up to MaxEBPFPortRange
+	entries = make(map[policyKey]policyValue)
+	rule = policy.Rule{Policy: "web", CIDR: "10.0.4.1/32", Protocol: "UDP", Port: 8000, EndPort: 8009}
+	if err := addRuleEntry(rule, entries); err != nil || len(entries) != 10 {
+		t.Errorf("Expected 10 entries for the range, got %d (%v)", len(entries), err)
+	}
+	if _, exists := entries[policyKey{DestIP: 0x0A000401, DestPort: 8009, Protocol: 17}]; !exists {
+		t.Errorf("Expected an entry for the end of the range, got %v", entries)
+	}
+	rule.EndPort = rule.Port + MaxEBPFPortRange
+	if err := addRuleEntry(rule, entries); err == nil || !strings.Contains(err.Error(), "spans 1025 ports") {
+		t.Errorf("Expected a range wider than the map expands rejected, got %v", err)
+	}
+}
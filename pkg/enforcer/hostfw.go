@@ -0,0 +1,556 @@
+package enforcer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"ztap/pkg/policy"
+)
+
+// Host firewalls ZTAP can inventory
+const (
+	FirewallIptables = "iptables"
+	FirewallNftables = "nftables"
+	FirewallPF       = "pf"
+)
+
+// Host firewall rule actions
+const (
+	HostAccept = "accept"
+	HostDrop   = "drop"
+	HostAnchor = "anchor" // pf: where the ztap anchor is evaluated
+)
+
+// Conflict kinds
+const (
+	// ConflictBlocked: the host firewall drops traffic a ZTAP rule allows
+	ConflictBlocked = "blocked"
+	// ConflictBypass: a broader host accept decides before ZTAP's rules, so
+	// traffic ZTAP would block is let through
+	ConflictBypass = "bypass"
+)
+
+// HostRule is an egress rule found in a host firewall ruleset
+type HostRule struct {
+	Firewall string `json:"firewall"`
+	Chain    string `json:"chain,omitempty"` // Empty for pf's main ruleset
+	Position int    `json:"position"`        // 1-based, within the chain
+	Action   string `json:"action"`
+	Protocol string `json:"protocol,omitempty"` // Empty matches any protocol
+	CIDR     string `json:"cidr,omitempty"`     // Empty matches any destination
+	PortLow  int    `json:"portLow,omitempty"`  // 0 matches any port
+	PortHigh int    `json:"portHigh,omitempty"`
+	Quick    bool   `json:"quick,omitempty"`   // pf: first match wins
+	Default  bool   `json:"default,omitempty"` // The chain's default policy
+	// Conditional rules also match on something ZTAP rules do not carry
+	// (source, interface, connection state), so they may match only some of
+	// a ZTAP rule's traffic
+	Conditional bool   `json:"conditional,omitempty"`
+	Raw         string `json:"raw"`
+}
+
+// String describes where the rule lives, e.g. "iptables OUTPUT rule 3"
+func (r HostRule) String() string {
+	where := r.Firewall
+	if r.Chain != "" {
+		where += " " + r.Chain
+	}
+	if r.Default {
+		return fmt.Sprintf("%s default policy %s", where, r.Action)
+	}
+	return fmt.Sprintf("%s rule %d: %s", where, r.Position, r.Raw)
+}
+
+// Conflict is a compiled ZTAP rule whose effect is changed by a host rule
+type Conflict struct {
+	Kind    string      `json:"kind"`
+	Rule    policy.Rule `json:"rule"`
+	Host    HostRule    `json:"host"`
+	Partial bool        `json:"partial,omitempty"` // The host rule matches only part of the ZTAP rule's traffic
+}
+
+// String describes the conflict for CLI output and logs
+func (c Conflict) String() string {
+	target := fmt.Sprintf("%s %s port %s", strings.ToLower(c.Rule.Protocol), c.Rule.CIDR, c.Rule.PortRange())
+	switch c.Kind {
+	case ConflictBlocked:
+		extent := "is"
+		if c.Partial {
+			extent = "is partly"
+		}
+		return fmt.Sprintf("policy %s allows %s but it %s dropped by %s", c.Rule.Policy, target, extent, c.Host)
+	case ConflictBypass:
+		return fmt.Sprintf("policy %s allows %s but %s accepts broader traffic before ZTAP's rules", c.Rule.Policy, target, c.Host)
+	default:
+		return fmt.Sprintf("policy %s: %s conflicts with %s", c.Rule.Policy, target, c.Host)
+	}
+}
+
+// runFirewallTool runs a firewall listing command (replaced in tests)
+var runFirewallTool = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+// lookFirewallTool reports whether a firewall tool is installed (replaced in tests)
+var lookFirewallTool = func(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// ScanHostFirewall inventories the egress rules of the host firewalls this
+// platform has: iptables and nftables on Linux, pf elsewhere. Firewalls whose
+// tools are not installed are skipped. Listing rules usually requires root;
+// rules from the firewalls that could be read are returned with the error.
+func ScanHostFirewall() ([]HostRule, error) {
+	var rules []HostRule
+	var errs []error
+
+	if runtime.GOOS != "linux" {
+		if !lookFirewallTool("pfctl") {
+			return nil, nil
+		}
+		out, err := runFirewallTool("pfctl", "-sr")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pf rules: %w", err)
+		}
+		return ParsePFRules(string(out)), nil
+	}
+
+	if lookFirewallTool("iptables-save") {
+		out, err := runFirewallTool("iptables-save", "-t", "filter")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list iptables rules: %w", err))
+		} else {
+			rules = append(rules, ParseIptablesSave(string(out))...)
+		}
+	}
+	if lookFirewallTool("nft") {
+		out, err := runFirewallTool("nft", "list", "ruleset")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list nftables rules: %w", err))
+		} else {
+			// iptables-nft stores its rules in nftables too; skip its tables
+			// rather than reporting every conflict twice
+			rules = append(rules, parseNftRuleset(string(out), len(rules) > 0)...)
+		}
+	}
+	return rules, errors.Join(errs...)
+}
+
+// ParseIptablesSave returns the OUTPUT chain rules of the filter table from
+// iptables-save output. Rules with negations or non-terminal targets (LOG,
+// jumps to user chains) are skipped since their effect cannot be decided
+// from the rule alone.
+func ParseIptablesSave(output string) []HostRule {
+	var rules []HostRule
+	var table string
+	var defaultPolicy *HostRule
+	position := 0
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "*"):
+			table = strings.TrimPrefix(line, "*")
+		case table != "filter":
+			continue
+		case strings.HasPrefix(line, ":OUTPUT "):
+			if fields := strings.Fields(line); len(fields) > 1 && fields[1] == "DROP" {
+				defaultPolicy = &HostRule{Firewall: FirewallIptables, Chain: "OUTPUT", Action: HostDrop, Default: true, Raw: line}
+			}
+		case strings.HasPrefix(line, "-A OUTPUT "):
+			position++
+			if rule, ok := parseIptablesRule(line); ok {
+				rule.Position = position
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	if defaultPolicy != nil {
+		defaultPolicy.Position = position + 1
+		rules = append(rules, *defaultPolicy)
+	}
+	return rules
+}
+
+func parseIptablesRule(line string) (HostRule, bool) {
+	rule := HostRule{Firewall: FirewallIptables, Chain: "OUTPUT", Raw: line}
+	fields := strings.Fields(line)
+	for i := 2; i < len(fields); i++ {
+		next := ""
+		if i+1 < len(fields) {
+			next = fields[i+1]
+		}
+		switch fields[i] {
+		case "!":
+			return rule, false
+		case "-d", "--destination":
+			rule.CIDR = normalizeCIDR(next)
+			i++
+		case "-p", "--protocol":
+			rule.Protocol = strings.ToLower(next)
+			i++
+		case "--dport", "--destination-port":
+			rule.PortLow, rule.PortHigh = parsePortRange(next, ":")
+			i++
+		case "-j", "--jump":
+			switch next {
+			case "ACCEPT":
+				rule.Action = HostAccept
+			case "DROP", "REJECT":
+				rule.Action = HostDrop
+			default:
+				return rule, false
+			}
+			i++
+		case "-m", "--match":
+			if next != "tcp" && next != "udp" && next != "comment" {
+				rule.Conditional = true
+			}
+			i++
+		case "--comment":
+			i++
+		default:
+			if strings.HasPrefix(fields[i], "-") {
+				rule.Conditional = true
+			}
+		}
+	}
+	return rule, rule.Action != ""
+}
+
+// ParseNftRuleset returns the rules of chains hooked to output from
+// 'nft list ruleset' output
+func ParseNftRuleset(output string) []HostRule {
+	return parseNftRuleset(output, false)
+}
+
+func parseNftRuleset(output string, skipIptablesTables bool) []HostRule {
+	var rules []HostRule
+	var table, chain string
+	var hooked, skip bool
+	var defaultPolicy *HostRule
+	position := 0
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 3 && fields[0] == "table":
+			table = fields[1] + " " + fields[2]
+			skip = skipIptablesTables && (table == "ip filter" || table == "ip6 filter")
+		case len(fields) >= 2 && fields[0] == "chain":
+			chain, hooked, position, defaultPolicy = fields[1], false, 0, nil
+		case strings.HasPrefix(line, "type filter hook output"):
+			hooked = true
+			if strings.Contains(line, "policy drop") {
+				defaultPolicy = &HostRule{Firewall: FirewallNftables, Chain: table + " " + chain, Action: HostDrop, Default: true, Raw: line}
+			}
+		case line == "}":
+			if hooked && !skip && defaultPolicy != nil {
+				defaultPolicy.Position = position + 1
+				rules = append(rules, *defaultPolicy)
+			}
+			hooked, defaultPolicy = false, nil
+		case hooked && !skip && line != "":
+			position++
+			if rule, ok := parseNftRule(fields); ok {
+				rule.Chain = table + " " + chain
+				rule.Position = position
+				rule.Raw = line
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+func parseNftRule(fields []string) (HostRule, bool) {
+	rule := HostRule{Firewall: FirewallNftables}
+	for i := 0; i < len(fields); i++ {
+		next := ""
+		if i+1 < len(fields) {
+			next = fields[i+1]
+		}
+		switch fields[i] {
+		case "!=":
+			return rule, false
+		case "daddr":
+			rule.CIDR = normalizeCIDR(next)
+			i++
+		case "tcp", "udp":
+			rule.Protocol = fields[i]
+			if next == "dport" && i+2 < len(fields) {
+				if strings.HasPrefix(fields[i+2], "{") {
+					// Port sets are not decoded
+					return rule, false
+				}
+				rule.PortLow, rule.PortHigh = parsePortRange(fields[i+2], "-")
+				i += 2
+			}
+		case "saddr", "sport", "oif", "oifname", "ct", "skuid", "skgid", "mark":
+			rule.Conditional = true
+		case "accept":
+			rule.Action = HostAccept
+		case "drop", "reject":
+			rule.Action = HostDrop
+		case "jump", "goto", "return":
+			return rule, false
+		}
+	}
+	return rule, rule.Action != ""
+}
+
+// ParsePFRules returns the outbound rules of the main ruleset from
+// 'pfctl -sr' output, including the position of the ztap anchor
+func ParsePFRules(output string) []HostRule {
+	var rules []HostRule
+	position := 0
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		position++
+
+		if fields[0] == "anchor" && len(fields) > 1 && strings.Trim(fields[1], `"`) == "ztap" {
+			rules = append(rules, HostRule{Firewall: FirewallPF, Position: position, Action: HostAnchor, Raw: line})
+			continue
+		}
+		if rule, ok := parsePFRule(fields); ok {
+			rule.Position = position
+			rule.Raw = line
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func parsePFRule(fields []string) (HostRule, bool) {
+	rule := HostRule{Firewall: FirewallPF}
+	switch fields[0] {
+	case "pass":
+		rule.Action = HostAccept
+	case "block":
+		rule.Action = HostDrop
+	default:
+		return rule, false
+	}
+
+	to := false
+	for i := 1; i < len(fields); i++ {
+		next := ""
+		if i+1 < len(fields) {
+			next = fields[i+1]
+		}
+		switch fields[i] {
+		case "in", "!":
+			return rule, false
+		case "on", "user", "group":
+			rule.Conditional = true
+			i++
+		case "from":
+			if next != "any" {
+				rule.Conditional = true
+			}
+			i++
+		case "quick":
+			rule.Quick = true
+		case "proto":
+			rule.Protocol = strings.ToLower(next)
+			i++
+		case "to":
+			to = true
+			if next == "any" {
+				i++
+				continue
+			}
+			if strings.HasPrefix(next, "<") || strings.HasPrefix(next, "(") {
+				// Tables and interface addresses change at runtime
+				return rule, false
+			}
+			rule.CIDR = normalizeCIDR(next)
+			i++
+		case "port":
+			if !to {
+				continue
+			}
+			if next == "=" && i+2 < len(fields) {
+				next = fields[i+2]
+				i++
+			}
+			rule.PortLow, rule.PortHigh = parsePortRange(next, ":")
+			i++
+		}
+	}
+	return rule, true
+}
+
+// DetectConflicts reports the compiled ZTAP rules whose effect a host rule
+// changes. iptables and nftables chains are evaluated first match wins: a
+// drop (or a drop default policy) reached before any accept covering the
+// ZTAP rule blocks it. The eBPF hook runs independently of netfilter, so a
+// netfilter accept cannot bypass ZTAP. In pf, quick rules before the ztap
+// anchor decide before ZTAP's rules do. Host rules are read from the
+// outbound chains only, so ingress rules are not checked.
+func DetectConflicts(host []HostRule, policies []*policy.CompiledPolicy) []Conflict {
+	var chains [][]HostRule
+	index := make(map[string]int)
+	for _, r := range host {
+		key := r.Firewall + "/" + r.Chain
+		i, ok := index[key]
+		if !ok {
+			i = len(chains)
+			index[key] = i
+			chains = append(chains, nil)
+		}
+		chains[i] = append(chains[i], r)
+	}
+
+	var conflicts []Conflict
+	for _, p := range policies {
+		for _, rule := range p.Rules {
+			if rule.Ingress() {
+				continue
+			}
+			for _, chain := range chains {
+				if chain[0].Firewall == FirewallPF {
+					conflicts = append(conflicts, pfConflicts(chain, rule)...)
+				} else {
+					conflicts = append(conflicts, netfilterConflicts(chain, rule)...)
+				}
+			}
+		}
+	}
+	return conflicts
+}
+
+func netfilterConflicts(chain []HostRule, rule policy.Rule) []Conflict {
+	var conflicts []Conflict
+	for _, hr := range chain {
+		covers, overlaps := hostMatch(hr, rule)
+		switch {
+		case (covers || overlaps) && hr.Conditional:
+			if hr.Action == HostDrop {
+				conflicts = append(conflicts, Conflict{Kind: ConflictBlocked, Rule: rule, Host: hr, Partial: true})
+			}
+		case hr.Default || covers:
+			if hr.Action == HostDrop {
+				conflicts = append(conflicts, Conflict{Kind: ConflictBlocked, Rule: rule, Host: hr})
+			}
+			return conflicts
+		case overlaps && hr.Action == HostDrop:
+			conflicts = append(conflicts, Conflict{Kind: ConflictBlocked, Rule: rule, Host: hr, Partial: true})
+		}
+	}
+	return conflicts
+}
+
+func pfConflicts(chain []HostRule, rule policy.Rule) []Conflict {
+	var conflicts []Conflict
+	for _, hr := range chain {
+		if hr.Action == HostAnchor {
+			break
+		}
+		if !hr.Quick {
+			// Later rules, including the ztap anchor's, override it
+			continue
+		}
+		covers, overlaps := hostMatch(hr, rule)
+		switch {
+		case (covers || overlaps) && hr.Conditional:
+			if hr.Action == HostDrop {
+				conflicts = append(conflicts, Conflict{Kind: ConflictBlocked, Rule: rule, Host: hr, Partial: true})
+			}
+		case covers && hr.Action == HostDrop:
+			return append(conflicts, Conflict{Kind: ConflictBlocked, Rule: rule, Host: hr})
+		case covers:
+			if !sameTraffic(hr, rule) {
+				conflicts = append(conflicts, Conflict{Kind: ConflictBypass, Rule: rule, Host: hr})
+			}
+			return conflicts
+		case overlaps && hr.Action == HostDrop:
+			conflicts = append(conflicts, Conflict{Kind: ConflictBlocked, Rule: rule, Host: hr, Partial: true})
+		}
+	}
+	return conflicts
+}
+
+// hostMatch reports whether a host rule matches all of a ZTAP rule's
+// traffic (covers) or only part of it (overlaps)
+func hostMatch(hr HostRule, rule policy.Rule) (covers, overlaps bool) {
+	if hr.Protocol != "" && !strings.EqualFold(hr.Protocol, rule.Protocol) {
+		return false, false
+	}
+	if hr.PortLow != 0 && (rule.LastPort() < hr.PortLow || rule.Port > hr.PortHigh) {
+		return false, false
+	}
+	// A host rule on part of a ZTAP rule's port range matches part of its
+	// traffic at most
+	allPorts := hr.PortLow == 0 || (rule.Port >= hr.PortLow && rule.LastPort() <= hr.PortHigh)
+	if hr.CIDR == "" {
+		return allPorts, !allPorts
+	}
+
+	_, hostNet, err := net.ParseCIDR(hr.CIDR)
+	if err != nil {
+		return false, false
+	}
+	_, ruleNet, err := net.ParseCIDR(rule.CIDR)
+	if err != nil {
+		return false, false
+	}
+	hostOnes, _ := hostNet.Mask.Size()
+	ruleOnes, _ := ruleNet.Mask.Size()
+	if hostNet.Contains(ruleNet.IP) && hostOnes <= ruleOnes {
+		return allPorts, !allPorts
+	}
+	return false, ruleNet.Contains(hostNet.IP)
+}
+
+// sameTraffic reports whether a host rule matches exactly a ZTAP rule's traffic
+func sameTraffic(hr HostRule, rule policy.Rule) bool {
+	return strings.EqualFold(hr.Protocol, rule.Protocol) &&
+		hr.PortLow == rule.Port && hr.PortHigh == rule.LastPort() &&
+		hr.CIDR == normalizeCIDR(rule.CIDR)
+}
+
+// normalizeCIDR turns a bare address into a host CIDR
+func normalizeCIDR(addr string) string {
+	if _, ipnet, err := net.ParseCIDR(addr); err == nil {
+		return ipnet.String()
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+	return ip.String() + "/128"
+}
+
+// parsePortRange parses "443" or a range like "8000:8080"
+func parsePortRange(s, sep string) (low, high int) {
+	lowStr, highStr, isRange := strings.Cut(s, sep)
+	low, err := strconv.Atoi(lowStr)
+	if err != nil {
+		return 0, 0
+	}
+	if !isRange {
+		return low, low
+	}
+	high, err = strconv.Atoi(highStr)
+	if err != nil {
+		return 0, 0
+	}
+	return low, high
+}
@@ -0,0 +1,197 @@
+package enforcer
+
+import (
+	"strings"
+	"testing"
+
+	"ztap/pkg/policy"
+)
+
+func TestParseIptablesSave(t *testing.T) {
+	output := `# Generated by iptables-save v1.8.7
+*nat
+:OUTPUT ACCEPT [0:0]
+-A OUTPUT -d 10.9.9.9/32 -j DNAT --to-destination 10.0.0.1
+COMMIT
+*filter
+:INPUT ACCEPT [0:0]
+:OUTPUT DROP [0:0]
+-A INPUT -p tcp --dport 22 -j ACCEPT
+-A OUTPUT -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT
+-A OUTPUT -d 10.0.0.0/8 -p tcp -m tcp --dport 5432 -j DROP
+-A OUTPUT ! -d 10.0.0.0/8 -j DROP
+-A OUTPUT -p udp -m udp --dport 8000:8080 -j ACCEPT
+-A OUTPUT -j LOG
+COMMIT
+`
+	rules := ParseIptablesSave(output)
+	if len(rules) != 4 {
+		t.Fatalf("Expected 4 rules, got %d: %+v", len(rules), rules)
+	}
+
+	if !rules[0].Conditional || rules[0].Action != HostAccept {
+		t.Errorf("Expected conditional accept, got %+v", rules[0])
+	}
+	drop := rules[1]
+	if drop.Position != 2 || drop.Action != HostDrop || drop.CIDR != "10.0.0.0/8" ||
+		drop.Protocol != "tcp" || drop.PortLow != 5432 || drop.PortHigh != 5432 || drop.Conditional {
+		t.Errorf("Unexpected drop rule: %+v", drop)
+	}
+	if rules[2].Position != 4 || rules[2].PortLow != 8000 || rules[2].PortHigh != 8080 {
+		t.Errorf("Unexpected port range rule: %+v", rules[2])
+	}
+	if !rules[3].Default || rules[3].Action != HostDrop || rules[3].Position != 6 {
+		t.Errorf("Expected drop default policy last, got %+v", rules[3])
+	}
+}
+
+func TestParseNftRuleset(t *testing.T) {
+	output := `table inet filter {
+	chain input {
+		type filter hook input priority filter; policy accept;
+		tcp dport 22 drop
+	}
+	chain output {
+		type filter hook output priority filter; policy accept;
+		ct state established accept
+		ip daddr 10.0.2.1 tcp dport 443 reject
+		tcp dport { 80, 443 } accept
+	}
+}
+table ip filter {
+	chain OUTPUT {
+		type filter hook output priority filter; policy drop;
+		udp dport 53 accept
+	}
+}
+`
+	rules := ParseNftRuleset(output)
+	if len(rules) != 4 {
+		t.Fatalf("Expected 4 rules, got %d: %+v", len(rules), rules)
+	}
+	if !rules[0].Conditional {
+		t.Errorf("Expected ct state rule to be conditional: %+v", rules[0])
+	}
+	if r := rules[1]; r.Chain != "inet filter output" || r.Position != 2 || r.Action != HostDrop ||
+		r.CIDR != "10.0.2.1/32" || r.Protocol != "tcp" || r.PortLow != 443 {
+		t.Errorf("Unexpected reject rule: %+v", r)
+	}
+	if r := rules[3]; !r.Default || r.Chain != "ip filter OUTPUT" || r.Position != 2 {
+		t.Errorf("Expected drop default policy, got %+v", r)
+	}
+
+	// iptables-nft tables are skipped when iptables-save already listed them
+	if rules := parseNftRuleset(output, true); len(rules) != 2 {
+		t.Errorf("Expected iptables-nft tables to be skipped, got %+v", rules)
+	}
+}
+
+func TestParsePFRules(t *testing.T) {
+	output := `scrub-anchor "com.apple/*" all fragment reassemble
+pass out quick proto tcp from any to 10.0.0.0/8 port = 443 flags S/SA keep state
+block drop in quick proto tcp from any to any port = 22
+block drop out quick on en0 proto udp from any to any port = 53
+anchor "ztap" all
+pass out all flags S/SA keep state
+`
+	rules := ParsePFRules(output)
+	if len(rules) != 4 {
+		t.Fatalf("Expected 4 rules, got %d: %+v", len(rules), rules)
+	}
+	if r := rules[0]; r.Position != 2 || r.Action != HostAccept || !r.Quick ||
+		r.CIDR != "10.0.0.0/8" || r.Protocol != "tcp" || r.PortLow != 443 {
+		t.Errorf("Unexpected pass rule: %+v", r)
+	}
+	if r := rules[1]; !r.Conditional || r.Action != HostDrop {
+		t.Errorf("Expected interface-bound block to be conditional: %+v", r)
+	}
+	if rules[2].Action != HostAnchor || rules[2].Position != 5 {
+		t.Errorf("Expected ztap anchor, got %+v", rules[2])
+	}
+	if r := rules[3]; r.Quick || r.CIDR != "" {
+		t.Errorf("Unexpected catch-all pass: %+v", r)
+	}
+}
+
+func TestDetectConflicts(t *testing.T) {
+	compiled := []*policy.CompiledPolicy{{
+		Name: "web-egress",
+		Rules: []policy.Rule{
+			{Policy: "web-egress", CIDR: "10.0.2.1/32", Protocol: "TCP", Port: 5432},
+			{Policy: "web-egress", CIDR: "10.0.3.0/24", Protocol: "TCP", Port: 443},
+			{Policy: "web-egress", CIDR: "192.168.1.5/32", Protocol: "UDP", Port: 53},
+		},
+	}}
+
+	t.Run("netfilter", func(t *testing.T) {
+		host := ParseIptablesSave(`*filter
+:OUTPUT DROP [0:0]
+-A OUTPUT -d 10.0.2.0/24 -p tcp -m tcp --dport 5432 -j DROP
+-A OUTPUT -d 10.0.3.7/32 -p tcp -j REJECT
+-A OUTPUT -p tcp -j ACCEPT
+COMMIT
+`)
+		conflicts := DetectConflicts(host, compiled)
+		if len(conflicts) != 3 {
+			t.Fatalf("Expected 3 conflicts, got %d: %v", len(conflicts), conflicts)
+		}
+		if c := conflicts[0]; c.Kind != ConflictBlocked || c.Rule.Port != 5432 || c.Partial || c.Host.Position != 1 {
+			t.Errorf("Expected 5432 to be blocked by rule 1: %v", c)
+		}
+		// 10.0.3.7 is dropped but the rest of 10.0.3.0/24 reaches the accept
+		if c := conflicts[1]; c.Rule.Port != 443 || !c.Partial || c.Host.Position != 2 {
+			t.Errorf("Expected 443 to be partly blocked by rule 2: %v", c)
+		}
+		// UDP never reaches an accept, so the default policy drops it
+		if c := conflicts[2]; c.Rule.Port != 53 || !c.Host.Default {
+			t.Errorf("Expected 53 to be blocked by the default policy: %v", c)
+		}
+	})
+
+	t.Run("pf", func(t *testing.T) {
+		host := ParsePFRules(`pass out quick proto tcp from any to 10.0.0.0/8 keep state
+pass out quick proto udp from any to 192.168.1.5 port = 53
+anchor "ztap" all
+block drop out quick proto tcp from any to any
+`)
+		conflicts := DetectConflicts(host, compiled)
+		if len(conflicts) != 2 {
+			t.Fatalf("Expected 2 conflicts, got %d: %v", len(conflicts), conflicts)
+		}
+		for _, c := range conflicts {
+			if c.Kind != ConflictBypass || c.Host.Position != 1 {
+				t.Errorf("Expected bypass by the 10.0.0.0/8 pass: %v", c)
+			}
+		}
+	})
+
+	t.Run("port ranges", func(t *testing.T) {
+		ranged := []*policy.CompiledPolicy{{Name: "web", Rules: []policy.Rule{
+			{Policy: "web", CIDR: "10.0.4.0/24", Protocol: "TCP", Port: 8000, EndPort: 8080},
+		}}}
+		host := ParseIptablesSave(`*filter
+:OUTPUT ACCEPT [0:0]
+-A OUTPUT -d 10.0.4.0/24 -p tcp -m tcp --dport 8000:8009 -j DROP
+COMMIT
+`)
+		conflicts := DetectConflicts(host, ranged)
+		if len(conflicts) != 1 || !conflicts[0].Partial || !strings.Contains(conflicts[0].String(), "port 8000-8080") {
+			t.Errorf("Expected the range partly blocked, got %v", conflicts)
+		}
+	})
+
+	if conflicts := DetectConflicts(nil, compiled); len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts without host rules, got %v", conflicts)
+	}
+}
+
+func TestScanHostFirewallSkipsMissingTools(t *testing.T) {
+	origLook := lookFirewallTool
+	defer func() { lookFirewallTool = origLook }()
+	lookFirewallTool = func(string) bool { return false }
+
+	rules, err := ScanHostFirewall()
+	if err != nil || len(rules) != 0 {
+		t.Errorf("Expected no rules and no error, got %v, %v", rules, err)
+	}
+}
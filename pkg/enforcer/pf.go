@@ -0,0 +1,386 @@
+package enforcer
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"ztap/pkg/metrics"
+	"ztap/pkg/policy"
+
+	"gopkg.in/yaml.v2"
+)
+
+// pfAnchorPath is where the ztap anchor is written
+const pfAnchorPath = "/etc/pf.anchors/ztap"
+
+// pfConfPath is the main ruleset, which must reference the anchor
+const pfConfPath = "/etc/pf.conf"
+
+// pfTableNameMax is the longest pf table name (PF_TABLE_NAME_SIZE - 1)
+const pfTableNameMax = 31
+
+// PFConfig is the pf section of config.yaml
+type PFConfig struct {
+	// Interfaces the anchor's rules apply on, e.g. [en0]. Empty applies them
+	// on every interface but loopback.
+	Interfaces []string `yaml:"interfaces"`
+}
+
+// pfInterfaceName matches interface and interface group names, e.g. en0
+var pfInterfaceName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.]*$`)
+
+// Validate checks the configuration
+func (c PFConfig) Validate() error {
+	for _, name := range c.Interfaces {
+		if !pfInterfaceName.MatchString(name) {
+			return fmt.Errorf("pf.interfaces: invalid interface name %q", name)
+		}
+	}
+	return nil
+}
+
+// pfConfigFile is the part of config.yaml read by LoadPFConfig
+type pfConfigFile struct {
+	PF PFConfig `yaml:"pf"`
+}
+
+// LoadPFConfig reads the pf section of the config.yaml at path. A missing
+// file or section applies the rules on every interface.
+func LoadPFConfig(path string) (PFConfig, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return PFConfig{}, nil
+	}
+	if err != nil {
+		return PFConfig{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file pfConfigFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return PFConfig{}, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if err := file.PF.Validate(); err != nil {
+		return PFConfig{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return file.PF, nil
+}
+
+// EnforceWithPF (macOS) - uses pfctl to manage rules. Destinations live in
+// pf tables, so when only endpoints change the anchor is left loaded and the
+// tables' contents are replaced. pf.conf is made to reference the anchor and
+// pf is enabled if needed.
+func EnforceWithPF(policies []*policy.CompiledPolicy, config PFConfig) error {
+	fmt.Printf("Applying %d pf-based policies on macOS\n", len(policies))
+
+	if os.Getenv("ZTAP_SKIP_PF") == "1" {
+		log.Println("Skipping pf enforcement due to ZTAP_SKIP_PF environment override")
+		return nil
+	}
+
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("pf enforcement requires root privileges")
+	}
+
+	layout := pfAnchor(policies, config)
+	if err := applyPF(layout, pfAnchorPath); err != nil {
+		return err
+	}
+	reloaded, err := ensurePFAnchor(pfConfPath)
+	if err != nil {
+		return err
+	}
+	if reloaded {
+		// Loading pf.conf loaded the anchor again, emptying its tables
+		return applyPF(layout, pfAnchorPath)
+	}
+	return nil
+}
+
+// pfAnchorRef matches the anchor rule referencing ztap in pf.conf
+var pfAnchorRef = regexp.MustCompile(`(?m)^\s*anchor\s+"ztap"`)
+
+// ensurePFAnchor makes the main ruleset at confPath evaluate the ztap
+// anchor and pf enforce it. Rules loaded into an anchor have no effect
+// until pf.conf references it, so a missing reference is appended and
+// pf.conf loaded again; reloaded reports whether it was. pf is enabled if
+// it is not.
+func ensurePFAnchor(confPath string) (reloaded bool, err error) {
+	conf, err := os.ReadFile(confPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", confPath, err)
+	}
+	if !pfAnchorRef.Match(conf) {
+		ref := fmt.Sprintf("anchor \"ztap\"\nload anchor \"ztap\" from \"%s\"\n", pfAnchorPath)
+		if len(conf) > 0 && conf[len(conf)-1] != '\n' {
+			ref = "\n" + ref
+		}
+		f, err := os.OpenFile(confPath, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return false, fmt.Errorf("failed to add the ztap anchor to %s: %w", confPath, err)
+		}
+		_, err = f.WriteString(ref)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to add the ztap anchor to %s: %w", confPath, err)
+		}
+		if out, err := runPFCtl("", "-f", confPath); err != nil {
+			return false, fmt.Errorf("failed to load %s: %w: %s", confPath, err, strings.TrimSpace(string(out)))
+		}
+		log.Printf("Added the ztap anchor to %s", confPath)
+		reloaded = true
+	}
+
+	out, err := runPFCtl("", "-s", "info")
+	if err != nil {
+		return reloaded, fmt.Errorf("failed to read pf status: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	if !strings.Contains(string(out), "Status: Enabled") {
+		if out, err := runPFCtl("", "-e"); err != nil {
+			return reloaded, fmt.Errorf("failed to enable pf: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		log.Println("pf was disabled; enabled it")
+	}
+	return reloaded, nil
+}
+
+// pfLayout is the ztap anchor and the contents of the tables its rules
+// reference
+type pfLayout struct {
+	Anchor string
+	Tables map[string][]string // Table name to CIDRs
+}
+
+// pfAnchor renders the ztap anchor for compiled policies, with the same
+// model as the eBPF backend: compiled rules are allowed and everything else
+// is blocked. Egress rules pass traffic out to their destinations, ingress
+// rules pass traffic in from their sources to the local port; both keep
+// state, so replies pass too. Outbound traffic is blocked by default, and
+// inbound traffic once a policy has ingress rules. With config.Interfaces
+// every rule is scoped to those interfaces; without, loopback is exempt.
+//
+// Label selectors are already resolved to host CIDRs by compilation. The
+// CIDRs of a policy's rules with the same direction, protocol and ports are
+// collected in one table (see pfTableName), so endpoint churn changes
+// tables, not the anchor.
+func pfAnchor(policies []*policy.CompiledPolicy, config PFConfig) pfLayout {
+	layout := pfLayout{Tables: make(map[string][]string)}
+	on := pfInterfaces(config.Interfaces)
+	ingress := false
+	seen := make(map[string]bool) // Table name and CIDR
+
+	var rules strings.Builder
+	var tables []string
+	for _, p := range policies {
+		fmt.Fprintf(&rules, "# Policy: %s\n", p.Name)
+		for _, r := range p.Rules {
+			protocol := strings.ToLower(r.Protocol)
+			name := pfTableName(p.Name, r.Direction, protocol, r.PortRange())
+			if _, ok := layout.Tables[name]; !ok {
+				tables = append(tables, name)
+				ingress = ingress || r.Ingress()
+				rules.WriteString(pfPassRules(r, on, name))
+			}
+			if !seen[name+" "+r.CIDR] {
+				seen[name+" "+r.CIDR] = true
+				layout.Tables[name] = append(layout.Tables[name], r.CIDR)
+			}
+		}
+	}
+
+	// Tables are declared before the rules using them, and persist keeps
+	// them when empty. Loading the anchor empties them; applyPF fills them.
+	var b strings.Builder
+	b.WriteString("# ZTAP Managed Rules\n")
+	for _, name := range tables {
+		fmt.Fprintf(&b, "table <%s> persist\n", name)
+	}
+	if on == "" {
+		b.WriteString("pass quick on lo0 all\n")
+	}
+	b.WriteString(rules.String())
+	b.WriteString("# Default deny\n")
+	fmt.Fprintf(&b, "block drop out quick%s all\n", on)
+	if ingress {
+		fmt.Fprintf(&b, "block drop in quick%s all\n", on)
+	}
+	layout.Anchor = b.String()
+	return layout
+}
+
+// pfPassRules renders the pass rules of r for its table. pf cannot match
+// ICMP by port, so ICMP rules pass every ICMP type to or from the table,
+// for IPv4 and IPv6.
+func pfPassRules(r policy.Rule, on, table string) string {
+	protocol := strings.ToLower(r.Protocol)
+	if r.Ingress() {
+		if protocol == "icmp" {
+			return fmt.Sprintf("pass in quick%[1]s inet proto icmp from <%[2]s> to any keep state\n"+
+				"pass in quick%[1]s inet6 proto icmp6 from <%[2]s> to any keep state\n", on, table)
+		}
+		return fmt.Sprintf("pass in quick%s proto %s from <%s> to any port %s keep state\n", on, protocol, table, pfPort(r))
+	}
+	if protocol == "icmp" {
+		return fmt.Sprintf("pass out quick%[1]s inet proto icmp from any to <%[2]s> keep state\n"+
+			"pass out quick%[1]s inet6 proto icmp6 from any to <%[2]s> keep state\n", on, table)
+	}
+	return fmt.Sprintf("pass out quick%s proto %s from any to <%s> port %s keep state\n", on, protocol, table, pfPort(r))
+}
+
+// pfPort renders the port clause of r, e.g. "= 443", or "8000:8080" for a
+// range, which pf matches inclusively
+func pfPort(r policy.Rule) string {
+	if r.EndPort > r.Port {
+		return fmt.Sprintf("%d:%d", r.Port, r.EndPort)
+	}
+	return fmt.Sprintf("= %d", r.Port)
+}
+
+// pfInterfaces renders the interface clause of a rule, e.g. " on en0" or
+// " on { en0 en1 }", empty for every interface
+func pfInterfaces(interfaces []string) string {
+	switch len(interfaces) {
+	case 0:
+		return ""
+	case 1:
+		return " on " + interfaces[0]
+	default:
+		return " on { " + strings.Join(interfaces, " ") + " }"
+	}
+}
+
+// pfTableName names the table of a policy's CIDRs for direction, protocol
+// and ports, e.g. ztap_web_tcp443, ztap_web_tcp8000-8080 for a range, or
+// ztap_web_in_tcp22 for ingress. ICMP
+// rules share one table whatever their port, e.g. ztap_web_icmp. Names
+// that would be too long for pf or hold characters other than letters,
+// digits, - and _ use a hash of the policy name instead.
+func pfTableName(policyName, direction, protocol, ports string) string {
+	suffix := protocol + ports
+	if protocol == "icmp" {
+		suffix = protocol
+	}
+	if direction == policy.DirectionIngress {
+		suffix = "in_" + suffix
+	}
+	name := fmt.Sprintf("ztap_%s_%s", policyName, suffix)
+	if len(name) <= pfTableNameMax && strings.Trim(policyName, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_") == "" {
+		return name
+	}
+	sum := sha256.Sum256([]byte(policyName))
+	return fmt.Sprintf("ztap_%x_%s", sum[:4], suffix)
+}
+
+// applyPF loads layout into the ztap anchor. The anchor file at path is
+// rewritten and reloaded only when its rules changed; the tables are then
+// replaced in place with pfctl -T replace and tables of removed rules are
+// dropped.
+func applyPF(layout pfLayout, path string) error {
+	current, err := os.ReadFile(path)
+	if err != nil || string(current) != layout.Anchor {
+		start := time.Now()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create pf anchor directory: %w", err)
+		}
+		if err := os.WriteFile(path, []byte(layout.Anchor), 0644); err != nil {
+			return fmt.Errorf("failed to write pf anchor %s: %w", path, err)
+		}
+		if out, err := runPFCtl("", "-a", "ztap", "-f", path); err != nil {
+			return fmt.Errorf("failed to load pf anchor %s: %w: %s", path, err, strings.TrimSpace(string(out)))
+		}
+		metrics.GetCollector().ObservePFReload(time.Since(start))
+		log.Printf("pf anchor reloaded: %d tables", len(layout.Tables))
+	}
+
+	names := make([]string, 0, len(layout.Tables))
+	for name := range layout.Tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		addresses := strings.Join(layout.Tables[name], "\n") + "\n"
+		if out, err := runPFCtl(addresses, "-a", "ztap", "-t", name, "-T", "replace", "-f", "-"); err != nil {
+			return fmt.Errorf("failed to replace pf table %s: %w: %s", name, err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	out, err := runPFCtl("", "-a", "ztap", "-s", "Tables")
+	if err != nil {
+		return fmt.Errorf("failed to list pf tables: %w", err)
+	}
+	for _, name := range strings.Fields(string(out)) {
+		if _, ok := layout.Tables[name]; ok || !strings.HasPrefix(name, "ztap_") {
+			continue
+		}
+		if out, err := runPFCtl("", "-a", "ztap", "-t", name, "-T", "kill"); err != nil {
+			return fmt.Errorf("failed to remove pf table %s: %w: %s", name, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// runPFCtl runs pfctl with stdin as its input (replaced in tests)
+var runPFCtl = func(stdin string, args ...string) ([]byte, error) {
+	cmd := exec.Command("pfctl", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	return cmd.CombinedOutput()
+}
+
+// ListPFAnchorRules returns the rules loaded in the ztap anchor, as pf
+// reports them, with one rule per address of the tables they reference.
+// Listing rules usually requires root.
+func ListPFAnchorRules() ([]HostRule, error) {
+	out, err := runFirewallTool("pfctl", "-a", "ztap", "-sr")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pf anchor rules: %w", err)
+	}
+	expanded, err := expandPFTables(string(out), func(name string) ([]string, error) {
+		out, err := runFirewallTool("pfctl", "-a", "ztap", "-t", name, "-T", "show")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pf table %s: %w", name, err)
+		}
+		return strings.Fields(string(out)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	rules := ParsePFRules(expanded)
+	for i := range rules {
+		rules[i].Chain = "ztap"
+	}
+	return rules, nil
+}
+
+// pfTableRef matches a table reference in a pf rule, e.g. <ztap_web_tcp443>
+var pfTableRef = regexp.MustCompile(`<([^>]+)>`)
+
+// expandPFTables replaces each rule referencing a table with one rule per
+// address in the table, as listed by show. Rules on empty tables match
+// nothing and are dropped.
+func expandPFTables(rules string, show func(name string) ([]string, error)) (string, error) {
+	var b strings.Builder
+	for _, line := range strings.Split(rules, "\n") {
+		match := pfTableRef.FindStringSubmatchIndex(line)
+		if match == nil {
+			b.WriteString(line + "\n")
+			continue
+		}
+		addresses, err := show(line[match[2]:match[3]])
+		if err != nil {
+			return "", err
+		}
+		for _, address := range addresses {
+			b.WriteString(line[:match[0]] + address + line[match[1]:] + "\n")
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,285 @@
+package enforcer
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"ztap/pkg/policy"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// pfGoldenDiscovery resolves app labels to fixed addresses
+type pfGoldenDiscovery map[string][]string
+
+func (d pfGoldenDiscovery) ResolveLabels(labels map[string]string) ([]string, error) {
+	if ips, ok := d[labels["app"]]; ok {
+		return ips, nil
+	}
+	return nil, fmt.Errorf("no services found for %v", labels)
+}
+
+const pfGoldenLabelPolicy = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+`
+
+// TestPFAnchorGolden compares generated rulesets, with the contents of
+// their tables, to testdata/pf/*.golden. Run with -update to rewrite them.
+func TestPFAnchorGolden(t *testing.T) {
+	parsed, err := policy.Parse([]byte(pfGoldenLabelPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := policy.NewPolicyResolver(pfGoldenDiscovery{"db": {"10.0.2.2", "10.0.2.1"}})
+	labelled, err := resolver.Compile(parsed[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		policies []*policy.CompiledPolicy
+		config   PFConfig
+	}{
+		{"empty", nil, PFConfig{}},
+		{"egress", []*policy.CompiledPolicy{
+			{Name: "web-egress", Rules: []policy.Rule{
+				{Policy: "web-egress", CIDR: "10.0.2.1/32", Protocol: "TCP", Port: 5432},
+				{Policy: "web-egress", CIDR: "10.0.2.2/32", Protocol: "TCP", Port: 5432},
+				{Policy: "web-egress", CIDR: "2001:db8::/64", Protocol: "UDP", Port: 53},
+			}},
+			{Name: "deny-all"},
+			{Name: "payments-api-egress-to-databases", Rules: []policy.Rule{
+				{Policy: "payments-api-egress-to-databases", CIDR: "10.0.5.0/24", Protocol: "TCP", Port: 5432},
+			}},
+		}, PFConfig{}},
+		{"ingress-interfaces", []*policy.CompiledPolicy{{Name: "db", Rules: []policy.Rule{
+			{Policy: "db", CIDR: "10.0.1.0/24", Protocol: "TCP", Port: 5432, Direction: policy.DirectionIngress},
+			{Policy: "db", CIDR: "10.0.3.9/32", Protocol: "TCP", Port: 5432, Direction: policy.DirectionEgress},
+		}}}, PFConfig{Interfaces: []string{"en0", "en1"}}},
+		{"icmp", []*policy.CompiledPolicy{{Name: "ping", Rules: []policy.Rule{
+			{Policy: "ping", CIDR: "10.0.0.0/8", Protocol: "ICMP", Port: 8},
+			{Policy: "ping", CIDR: "10.0.0.0/8", Protocol: "ICMP", Port: 0},
+			{Policy: "ping", CIDR: "2001:db8::/32", Protocol: "ICMP", Port: 8},
+			{Policy: "ping", CIDR: "192.0.2.0/24", Protocol: "ICMP", Port: 8, Direction: policy.DirectionIngress},
+		}}}, PFConfig{Interfaces: []string{"en0"}}},
+		{"ranges", []*policy.CompiledPolicy{{Name: "web", Rules: []policy.Rule{
+			{Policy: "web", CIDR: "10.0.4.0/24", Protocol: "TCP", Port: 8000, EndPort: 8080},
+			{Policy: "web", CIDR: "10.0.4.0/24", Protocol: "TCP", Port: 8443},
+			{Policy: "web", CIDR: "10.0.1.0/24", Protocol: "UDP", Port: 60000, EndPort: 61000, Direction: policy.DirectionIngress},
+		}}}, PFConfig{}},
+		// Labels are resolved to the selected hosts only
+		{"labels", []*policy.CompiledPolicy{labelled}, PFConfig{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout := pfAnchor(tt.policies, tt.config)
+			got := layout.Anchor
+			names := make([]string, 0, len(layout.Tables))
+			for name := range layout.Tables {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				got += fmt.Sprintf("# <%s>: %s\n", name, strings.Join(layout.Tables[name], " "))
+			}
+
+			path := filepath.Join("testdata", "pf", tt.name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read golden file (run with -update to create it): %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("Ruleset differs from %s:\n%s", path, got)
+			}
+		})
+	}
+}
+
+func TestLoadPFConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if config, err := LoadPFConfig(path); err != nil || len(config.Interfaces) != 0 {
+		t.Fatalf("Expected empty config for a missing file, got %+v (%v)", config, err)
+	}
+
+	if err := os.WriteFile(path, []byte("pf:\n  interfaces: [en0, utun3]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadPFConfig(path)
+	if err != nil || !reflect.DeepEqual(config.Interfaces, []string{"en0", "utun3"}) {
+		t.Errorf("Expected interfaces [en0 utun3], got %+v (%v)", config, err)
+	}
+
+	if err := os.WriteFile(path, []byte("pf:\n  interfaces: [\"en0 }\"]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPFConfig(path); err == nil {
+		t.Error("Expected error for an invalid interface name")
+	}
+}
+
+func TestPFTableName(t *testing.T) {
+	if got := pfTableName("web", "", "tcp", "443"); got != "ztap_web_tcp443" {
+		t.Errorf("Expected ztap_web_tcp443, got %s", got)
+	}
+	if got := pfTableName("web", policy.DirectionIngress, "tcp", "22"); got != "ztap_web_in_tcp22" {
+		t.Errorf("Expected ztap_web_in_tcp22, got %s", got)
+	}
+	long := pfTableName("payments-api-egress-to-databases", "", "tcp", "5432")
+	if len(long) > pfTableNameMax || !strings.HasPrefix(long, "ztap_") || !strings.HasSuffix(long, "_tcp5432") {
+		t.Errorf("Expected a hashed name of at most %d characters, got %s", pfTableNameMax, long)
+	}
+	if pfTableName("a.b", "", "udp", "53") == pfTableName("a_b", "", "udp", "53") {
+		t.Error("Expected names with other characters to be hashed")
+	}
+}
+
+func TestApplyPF(t *testing.T) {
+	origRun := runPFCtl
+	defer func() { runPFCtl = origRun }()
+	var calls []string
+	loaded := "ztap_web_tcp443\nztap_old_tcp80\n"
+	runPFCtl = func(stdin string, args ...string) ([]byte, error) {
+		call := strings.Join(args, " ")
+		if stdin != "" {
+			call += " < " + strings.ReplaceAll(strings.TrimSpace(stdin), "\n", ",")
+		}
+		calls = append(calls, call)
+		if strings.HasSuffix(call, "-s Tables") {
+			return []byte(loaded), nil
+		}
+		return nil, nil
+	}
+
+	path := filepath.Join(t.TempDir(), "ztap")
+	policies := []*policy.CompiledPolicy{{Name: "web", Rules: []policy.Rule{
+		{Policy: "web", CIDR: "10.0.2.1/32", Protocol: "TCP", Port: 443},
+	}}}
+	if err := applyPF(pfAnchor(policies, PFConfig{}), path); err != nil {
+		t.Fatalf("applyPF failed: %v", err)
+	}
+	expected := []string{
+		"-a ztap -f " + path,
+		"-a ztap -t ztap_web_tcp443 -T replace -f - < 10.0.2.1/32",
+		"-a ztap -s Tables",
+		"-a ztap -t ztap_old_tcp80 -T kill",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected %q, got %q", expected, calls)
+	}
+
+	// New endpoints only replace the table, the anchor stays loaded
+	calls, loaded = nil, "ztap_web_tcp443\n"
+	policies[0].Rules = append(policies[0].Rules, policy.Rule{Policy: "web", CIDR: "10.0.2.7/32", Protocol: "TCP", Port: 443})
+	if err := applyPF(pfAnchor(policies, PFConfig{}), path); err != nil {
+		t.Fatalf("applyPF failed: %v", err)
+	}
+	expected = []string{
+		"-a ztap -t ztap_web_tcp443 -T replace -f - < 10.0.2.1/32,10.0.2.7/32",
+		"-a ztap -s Tables",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected %q, got %q", expected, calls)
+	}
+}
+
+func TestEnsurePFAnchor(t *testing.T) {
+	origRun := runPFCtl
+	defer func() { runPFCtl = origRun }()
+	var calls []string
+	status := "Status: Disabled for 0 days 00:00:00"
+	runPFCtl = func(stdin string, args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(args, " "))
+		if args[0] == "-s" {
+			return []byte(status), nil
+		}
+		return nil, nil
+	}
+
+	path := filepath.Join(t.TempDir(), "pf.conf")
+	if err := os.WriteFile(path, []byte(`anchor "com.apple/*"`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := ensurePFAnchor(path)
+	if err != nil || !reloaded {
+		t.Fatalf("Expected pf.conf to be reloaded, got %v (%v)", reloaded, err)
+	}
+	conf, _ := os.ReadFile(path)
+	expected := "anchor \"com.apple/*\"\nanchor \"ztap\"\nload anchor \"ztap\" from \"/etc/pf.anchors/ztap\"\n"
+	if string(conf) != expected {
+		t.Errorf("Unexpected pf.conf:\n%s", conf)
+	}
+	want := []string{"-f " + path, "-s info", "-e"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Expected calls %v, got %v", want, calls)
+	}
+
+	// An enabled pf with the anchor referenced is left alone
+	calls = nil
+	status = "Status: Enabled for 0 days 00:01:02"
+	if reloaded, err := ensurePFAnchor(path); err != nil || reloaded {
+		t.Fatalf("Expected no reload, got %v (%v)", reloaded, err)
+	}
+	if !reflect.DeepEqual(calls, []string{"-s info"}) {
+		t.Errorf("Expected only a status check, got %v", calls)
+	}
+	if conf, _ := os.ReadFile(path); string(conf) != expected {
+		t.Errorf("Expected pf.conf unchanged, got:\n%s", conf)
+	}
+}
+
+func TestListPFAnchorRules(t *testing.T) {
+	origRun := runFirewallTool
+	defer func() { runFirewallTool = origRun }()
+	var commands []string
+	runFirewallTool = func(name string, a ...string) ([]byte, error) {
+		command := strings.Join(append([]string{name}, a...), " ")
+		commands = append(commands, command)
+		if command == "pfctl -a ztap -t ztap_web_tcp5432 -T show" {
+			return []byte("   10.0.2.1\n   10.0.2.2\n"), nil
+		}
+		return []byte("block drop out quick proto tcp from any to <ztap_web_tcp5432> port = 5432\n" +
+			"block drop out quick proto udp from any to 10.0.0.53 port = 53\n"), nil
+	}
+
+	rules, err := ListPFAnchorRules()
+	if err != nil {
+		t.Fatalf("ListPFAnchorRules failed: %v", err)
+	}
+	if commands[0] != "pfctl -a ztap -sr" || len(commands) != 2 {
+		t.Errorf("Unexpected commands %v", commands)
+	}
+	if len(rules) != 3 || rules[0].Chain != "ztap" || rules[0].CIDR != "10.0.2.1/32" || rules[1].CIDR != "10.0.2.2/32" || rules[0].PortLow != 5432 {
+		t.Errorf("Unexpected rules %+v", rules)
+	}
+	if rules[2].CIDR != "10.0.0.53/32" || rules[2].PortLow != 53 {
+		t.Errorf("Expected rules without tables to be kept, got %+v", rules[2])
+	}
+}
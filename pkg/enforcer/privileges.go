@@ -0,0 +1,133 @@
+package enforcer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Capability is a Linux capability, identified by its bit number
+type Capability struct {
+	Name string
+	Bit  uint
+}
+
+// Capabilities the eBPF backend needs
+var (
+	CapNetAdmin    = Capability{Name: "CAP_NET_ADMIN", Bit: 12}
+	CapSysAdmin    = Capability{Name: "CAP_SYS_ADMIN", Bit: 21}
+	CapSysResource = Capability{Name: "CAP_SYS_RESOURCE", Bit: 24}
+	CapPerfmon     = Capability{Name: "CAP_PERFMON", Bit: 38}
+	CapBPF         = Capability{Name: "CAP_BPF", Bit: 39}
+)
+
+// Privileged eBPF backend operations
+const (
+	OpLoadEBPF     = "load the eBPF filter program and policy map"
+	OpAttachCgroup = "attach the filter to a cgroup"
+	OpMemlock      = "lift the memlock limit for eBPF maps (kernels before 5.11)"
+	OpProgramStats = "export eBPF program statistics (--ebpf-stats)"
+	OpMountCgroup  = "mount a cgroup v2 hierarchy for the filter (cgroup v1 hosts)"
+)
+
+// Operations lists the privileged operations in the order the agent performs them
+var Operations = []string{OpMemlock, OpLoadEBPF, OpAttachCgroup, OpProgramStats}
+
+// requiredCapabilities maps each operation to the capabilities it needs.
+// CAP_PERFMON lets the verifier accept the filter's pointer comparisons.
+var requiredCapabilities = map[string][]Capability{
+	OpLoadEBPF:     {CapBPF, CapPerfmon},
+	OpAttachCgroup: {CapNetAdmin},
+	OpMemlock:      {CapSysResource},
+	OpProgramStats: {CapSysAdmin},
+	OpMountCgroup:  {CapSysAdmin},
+}
+
+// RequiredCapabilities returns the capabilities an operation needs
+func RequiredCapabilities(op string) []Capability {
+	return requiredCapabilities[op]
+}
+
+// CapabilitySet is a set of capabilities, as in /proc/<pid>/status
+type CapabilitySet uint64
+
+// Has reports whether the set holds c. CAP_SYS_ADMIN stands in for CAP_BPF
+// and CAP_PERFMON, which kernels before 5.8 do not have.
+func (s CapabilitySet) Has(c Capability) bool {
+	if s&(1<<c.Bit) != 0 {
+		return true
+	}
+	return (c == CapBPF || c == CapPerfmon) && s&(1<<CapSysAdmin.Bit) != 0
+}
+
+// Missing returns the capabilities op needs that are not in the set
+func (s CapabilitySet) Missing(op string) []Capability {
+	var missing []Capability
+	for _, c := range requiredCapabilities[op] {
+		if !s.Has(c) {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// PrivilegeError lists the capabilities missing for each operation
+type PrivilegeError struct {
+	Missing map[string][]Capability // Keyed by operation
+	ops     []string                // Operations in the order they were checked
+}
+
+func (e *PrivilegeError) Error() string {
+	var parts []string
+	for _, op := range e.ops {
+		names := make([]string, len(e.Missing[op]))
+		for i, c := range e.Missing[op] {
+			names[i] = c.Name
+		}
+		parts = append(parts, fmt.Sprintf("%s needs %s", op, strings.Join(names, ", ")))
+	}
+	return fmt.Sprintf("missing capabilities: %s (run as root, or grant them with setcap or systemd AmbientCapabilities)",
+		strings.Join(parts, "; "))
+}
+
+// CheckPrivileges returns a *PrivilegeError if the process lacks a
+// capability needed for any of ops. Errors reading the process's
+// capabilities are returned as is.
+func CheckPrivileges(ops ...string) error {
+	caps, err := EffectiveCapabilities()
+	if err != nil {
+		return err
+	}
+	return checkPrivileges(caps, ops...)
+}
+
+func checkPrivileges(caps CapabilitySet, ops ...string) error {
+	missing := &PrivilegeError{Missing: make(map[string][]Capability)}
+	for _, op := range ops {
+		if m := caps.Missing(op); len(m) > 0 {
+			missing.Missing[op] = m
+			missing.ops = append(missing.ops, op)
+		}
+	}
+	if len(missing.ops) == 0 {
+		return nil
+	}
+	return missing
+}
+
+// parseCapEff extracts the effective capability set from the contents of
+// /proc/<pid>/status
+func parseCapEff(status string) (CapabilitySet, error) {
+	for _, line := range strings.Split(status, "\n") {
+		value, ok := strings.CutPrefix(line, "CapEff:")
+		if !ok {
+			continue
+		}
+		bits, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid CapEff %q: %w", strings.TrimSpace(value), err)
+		}
+		return CapabilitySet(bits), nil
+	}
+	return 0, fmt.Errorf("no CapEff line in process status")
+}
@@ -0,0 +1,18 @@
+//go:build linux
+// +build linux
+
+package enforcer
+
+import (
+	"fmt"
+	"os"
+)
+
+// EffectiveCapabilities returns the capabilities the process can use now
+func EffectiveCapabilities() (CapabilitySet, error) {
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read process capabilities: %w", err)
+	}
+	return parseCapEff(string(status))
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package enforcer
+
+import "fmt"
+
+// EffectiveCapabilities is only supported on Linux; the pf backend needs root
+func EffectiveCapabilities() (CapabilitySet, error) {
+	return 0, fmt.Errorf("capabilities require Linux")
+}
@@ -0,0 +1,66 @@
+package enforcer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseCapEff(t *testing.T) {
+	status := "Name:\tztap\nCapInh:\t0000000000000000\nCapPrm:\t000000c000001000\nCapEff:\t000000c000001000\n"
+	caps, err := parseCapEff(status)
+	if err != nil {
+		t.Fatalf("parseCapEff failed: %v", err)
+	}
+	for _, c := range []Capability{CapNetAdmin, CapPerfmon, CapBPF} {
+		if !caps.Has(c) {
+			t.Errorf("Expected %s in %x", c.Name, caps)
+		}
+	}
+	if caps.Has(CapSysAdmin) || caps.Has(CapSysResource) {
+		t.Errorf("Unexpected capabilities in %x", caps)
+	}
+
+	if _, err := parseCapEff("Name:\tztap\n"); err == nil {
+		t.Error("Expected error without CapEff")
+	}
+	if _, err := parseCapEff("CapEff:\tzz\n"); err == nil {
+		t.Error("Expected error for invalid CapEff")
+	}
+}
+
+func TestCapabilitySetSysAdminFallback(t *testing.T) {
+	// Kernels before 5.8 only have CAP_SYS_ADMIN
+	caps := CapabilitySet(1 << CapSysAdmin.Bit)
+	if len(caps.Missing(OpLoadEBPF)) != 0 {
+		t.Errorf("Expected CAP_SYS_ADMIN to cover loading, missing %v", caps.Missing(OpLoadEBPF))
+	}
+	if missing := caps.Missing(OpAttachCgroup); len(missing) != 1 || missing[0] != CapNetAdmin {
+		t.Errorf("Expected CAP_NET_ADMIN missing for attach, got %v", missing)
+	}
+}
+
+func TestCheckPrivileges(t *testing.T) {
+	full := CapabilitySet(^uint64(0))
+	if err := checkPrivileges(full, Operations...); err != nil {
+		t.Errorf("Expected root to pass, got %v", err)
+	}
+
+	err := checkPrivileges(CapabilitySet(1<<CapBPF.Bit), OpLoadEBPF, OpAttachCgroup)
+	var privErr *PrivilegeError
+	if !errors.As(err, &privErr) {
+		t.Fatalf("Expected *PrivilegeError, got %v", err)
+	}
+	if len(privErr.Missing) != 2 {
+		t.Errorf("Expected two operations missing capabilities, got %v", privErr.Missing)
+	}
+	msg := err.Error()
+	for _, want := range []string{OpLoadEBPF + " needs CAP_PERFMON", OpAttachCgroup + " needs CAP_NET_ADMIN"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Expected %q in %q", want, msg)
+		}
+	}
+	if strings.Index(msg, OpLoadEBPF) > strings.Index(msg, OpAttachCgroup) {
+		t.Errorf("Expected operations in checked order: %q", msg)
+	}
+}
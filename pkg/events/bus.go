@@ -0,0 +1,192 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// subscriberBufferSize is the number of pending events kept per subscriber
+const subscriberBufferSize = 64
+
+// subscriber receives events for a set of topics, either through a buffered
+// channel or a synchronous handler
+type subscriber struct {
+	topics  map[Topic]bool // empty means all topics
+	ch      chan Event
+	handler func(Event)
+	seq     uint64 // Events delivered to this subscriber
+}
+
+func (s *subscriber) wants(topic Topic) bool {
+	return len(s.topics) == 0 || s.topics[topic]
+}
+
+// Bus is an in-process publish/subscribe event bus. Producers publish without
+// knowing who consumes; a slow channel subscriber loses its oldest pending
+// events rather than blocking the producer.
+type Bus struct {
+	mu          sync.Mutex
+	subscribers []*subscriber
+	onDrop      func(topic Topic)
+	tenant      string
+}
+
+// NewBus creates an empty event bus
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+var (
+	defaultBus  *Bus
+	defaultOnce sync.Once
+)
+
+// Default returns the process-wide event bus
+func Default() *Bus {
+	defaultOnce.Do(func() {
+		defaultBus = NewBus()
+	})
+	return defaultBus
+}
+
+// SetTenant sets the tenant events published on the bus belong to, so the
+// API server streams them to that tenant's users only
+func (b *Bus) SetTenant(tenant string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tenant = tenant
+}
+
+// Publish sends an event to every subscriber of topic
+func (b *Bus) Publish(topic Topic, data interface{}) {
+	b.mu.Lock()
+	tenant := b.tenant
+	b.mu.Unlock()
+	b.PublishFor(tenant, topic, data)
+}
+
+// PublishFor publishes an event of tenant, for processes such as the API
+// server that act for several tenants
+func (b *Bus) PublishFor(tenant string, topic Topic, data interface{}) {
+	b.publish(Event{
+		Topic:     topic,
+		Timestamp: time.Now(),
+		Data:      data,
+		Tenant:    tenant,
+	})
+}
+
+// Replay publishes an event that another process already published, keeping
+// its timestamp and marking it Replayed
+func (b *Bus) Replay(event Event) {
+	event.Replayed = true
+	b.publish(event)
+}
+
+func (b *Bus) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, s := range b.subscribers {
+		if !s.wants(event.Topic) {
+			continue
+		}
+		s.seq++
+		event.Seq = s.seq
+		if s.handler != nil {
+			s.handler(event)
+			continue
+		}
+		if b.offer(s.ch, event) && b.onDrop != nil {
+			b.onDrop(event.Topic)
+		}
+	}
+}
+
+// Subscribe returns a channel of events for the given topics (all topics if
+// none are given). The channel is closed when ctx is cancelled.
+func (b *Bus) Subscribe(ctx context.Context, topics ...Topic) <-chan Event {
+	s := &subscriber{
+		topics: topicSet(topics),
+		ch:     make(chan Event, subscriberBufferSize),
+	}
+	b.add(s)
+
+	go func() {
+		<-ctx.Done()
+		if b.remove(s) {
+			close(s.ch)
+		}
+	}()
+
+	return s.ch
+}
+
+// SubscribeFunc calls handler synchronously for each event on the given topics
+// (all topics if none are given) until the returned unsubscribe function is
+// called. Handlers run while the bus is locked: they must be fast and must not
+// publish. Use it for consumers that cannot miss events, such as metrics.
+func (b *Bus) SubscribeFunc(handler func(Event), topics ...Topic) (unsubscribe func()) {
+	s := &subscriber{
+		topics:  topicSet(topics),
+		handler: handler,
+	}
+	b.add(s)
+
+	return func() { b.remove(s) }
+}
+
+// OnDrop registers a callback invoked with the topic of every event dropped
+// for a slow subscriber (e.g. to export metrics)
+func (b *Bus) OnDrop(fn func(topic Topic)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.onDrop = fn
+}
+
+func (b *Bus) add(s *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, s)
+}
+
+// remove unsubscribes s and reports whether it was still subscribed
+func (b *Bus) remove(s *subscriber) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, existing := range b.subscribers {
+		if existing == s {
+			b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// offer sends event without blocking, evicting the oldest buffered event if ch
+// is full, and reports whether an event was dropped (requires holding mu lock)
+func (b *Bus) offer(ch chan Event, event Event) bool {
+	select {
+	case ch <- event:
+		return false
+	default:
+	}
+
+	dropped := false
+	select {
+	case <-ch:
+		dropped = true
+	default:
+	}
+	ch <- event
+	return dropped
+}
+
+func topicSet(topics []Topic) map[Topic]bool {
+	set := make(map[Topic]bool, len(topics))
+	for _, topic := range topics {
+		set[topic] = true
+	}
+	return set
+}
@@ -0,0 +1,128 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBusSubscribeTopics(t *testing.T) {
+	bus := NewBus()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leaders := bus.Subscribe(ctx, TopicLeaderChanged)
+	all := bus.Subscribe(ctx)
+
+	bus.Publish(TopicServiceChanged, ServiceChanged{Name: "web-1"})
+	bus.Publish(TopicLeaderChanged, LeaderChanged{LeaderID: "node-1", Epoch: 1})
+
+	select {
+	case event := <-leaders:
+		data, ok := event.Data.(LeaderChanged)
+		if !ok || data.LeaderID != "node-1" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+		// Sequence numbers count this subscriber's events only, so a
+		// topic filter does not look like dropped events
+		if event.Seq != 1 {
+			t.Errorf("expected seq 1, got %d", event.Seq)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for leader event")
+	}
+
+	select {
+	case event := <-leaders:
+		t.Errorf("expected only leader events, got %+v", event)
+	default:
+	}
+
+	for _, expected := range []Topic{TopicServiceChanged, TopicLeaderChanged} {
+		if event := <-all; event.Topic != expected {
+			t.Errorf("expected %s, got %s", expected, event.Topic)
+		}
+	}
+
+	cancel()
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-all:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("expected channel to be closed after cancel")
+		}
+	}
+}
+
+func TestBusSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+
+	var dropped []Topic
+	bus.OnDrop(func(topic Topic) { dropped = append(dropped, topic) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := bus.Subscribe(ctx)
+
+	total := subscriberBufferSize + 3
+	for i := 0; i < total; i++ {
+		bus.Publish(TopicFlowBlocked, FlowBlocked{Port: i})
+	}
+
+	if len(dropped) != 3 {
+		t.Fatalf("expected 3 dropped events, got %d", len(dropped))
+	}
+
+	// The oldest events are dropped, visible as a gap in Seq
+	first := <-ch
+	if first.Seq != 4 {
+		t.Errorf("expected first retained seq 4, got %d", first.Seq)
+	}
+}
+
+func TestBusSubscribeFunc(t *testing.T) {
+	bus := NewBus()
+
+	var received []Event
+	unsubscribe := bus.SubscribeFunc(func(event Event) {
+		received = append(received, event)
+	}, TopicPolicyApplied)
+
+	bus.Publish(TopicPolicyApplied, PolicyApplied{Policy: "web-to-db"})
+	bus.Publish(TopicFlowBlocked, FlowBlocked{})
+	unsubscribe()
+	bus.Publish(TopicPolicyApplied, PolicyApplied{Policy: "ignored"})
+
+	if len(received) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(received))
+	}
+	if data := received[0].Data.(PolicyApplied); data.Policy != "web-to-db" {
+		t.Errorf("unexpected payload: %+v", data)
+	}
+}
+
+func TestDefaultBus(t *testing.T) {
+	if Default() != Default() {
+		t.Error("expected Default to return a singleton bus")
+	}
+}
+
+func TestBusTenant(t *testing.T) {
+	bus := NewBus()
+	var got []Event
+	defer bus.SubscribeFunc(func(event Event) { got = append(got, event) })()
+
+	bus.Publish(TopicFlowBlocked, FlowBlocked{Port: 22})
+	bus.SetTenant("acme")
+	bus.Publish(TopicFlowBlocked, FlowBlocked{Port: 22})
+	bus.PublishFor("globex", TopicFlowBlocked, FlowBlocked{Port: 22})
+
+	if len(got) != 3 || got[0].Tenant != "" || got[1].Tenant != "acme" || got[2].Tenant != "globex" {
+		t.Errorf("Expected events of no tenant, acme and globex, got %+v", got)
+	}
+}
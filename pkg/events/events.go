@@ -0,0 +1,202 @@
+package events
+
+import "time"
+
+// Topic identifies a category of event
+type Topic string
+
+const (
+	TopicServiceChanged  Topic = "service_changed"
+	TopicPolicyApplied   Topic = "policy_applied"
+	TopicLeaderChanged   Topic = "leader_changed"
+	TopicFlowBlocked     Topic = "flow_blocked"
+	TopicAnomalyDetected Topic = "anomaly_detected"
+	TopicShrinkHeld      Topic = "endpoint_shrink_held"
+	TopicPolicyExpired   Topic = "policy_expired"
+	TopicPolicyApproval  Topic = "policy_approval"
+	TopicBreakGlass      Topic = "break_glass"
+	TopicMaintenance     Topic = "maintenance"
+	TopicPolicyStale     Topic = "policy_stale"
+	TopicSecretRotated   Topic = "secret_rotated"
+)
+
+// Topics lists every known topic
+var Topics = []Topic{
+	TopicServiceChanged,
+	TopicPolicyApplied,
+	TopicLeaderChanged,
+	TopicFlowBlocked,
+	TopicAnomalyDetected,
+	TopicShrinkHeld,
+	TopicPolicyExpired,
+	TopicPolicyApproval,
+	TopicBreakGlass,
+	TopicMaintenance,
+	TopicPolicyStale,
+	TopicSecretRotated,
+}
+
+// Event is a published message. Data holds the topic's payload type.
+type Event struct {
+	Seq       uint64      `json:"seq"` // Per-subscriber sequence number; gaps mean this subscriber dropped events
+	Topic     Topic       `json:"topic"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+	Replayed  bool        `json:"replayed,omitempty"` // Published by another process and bridged through a Journal
+	Tenant    string      `json:"tenant,omitempty"`   // Tenant of the publishing process, see Bus.SetTenant
+}
+
+// ServiceChanged is published when a service is registered or deregistered
+type ServiceChanged struct {
+	Name    string            `json:"name"`
+	IP      string            `json:"ip,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Removed bool              `json:"removed,omitempty"`
+}
+
+// PolicyApplied is published after a policy is enforced by a backend
+type PolicyApplied struct {
+	Policy    string `json:"policy"`
+	Backend   string `json:"backend"`
+	Principal string `json:"principal,omitempty"` // Who initiated the enforcement
+	Error     string `json:"error,omitempty"`     // Empty on success
+}
+
+// LeaderChanged is published when a new cluster leader is elected
+type LeaderChanged struct {
+	LeaderID string `json:"leader_id"`
+	Epoch    int64  `json:"epoch"`
+}
+
+// FlowBlocked is published when a flow is denied by policy
+type FlowBlocked struct {
+	Policy        string `json:"policy,omitempty"`
+	Principal     string `json:"principal,omitempty"` // Who last changed the policy
+	SourceIP      string `json:"source_ip"`
+	SourceService string `json:"source_service,omitempty"` // Service that held the IP, if known
+	DestIP        string `json:"dest_ip"`
+	DestService   string `json:"dest_service,omitempty"`
+	Port          int    `json:"port"`
+	Protocol      string `json:"protocol"`
+}
+
+// AnomalyDetected is published when a flow scores as anomalous
+type AnomalyDetected struct {
+	ID            string  `json:"id,omitempty"` // Same for every alert on the flow, see 'ztap anomaly ack'
+	SourceIP      string  `json:"source_ip"`
+	SourceService string  `json:"source_service,omitempty"`
+	DestIP        string  `json:"dest_ip"`
+	DestService   string  `json:"dest_service,omitempty"`
+	Port          int     `json:"port"`
+	Protocol      string  `json:"protocol,omitempty"`
+	Score         float64 `json:"score"`
+	Reason        string  `json:"reason"`
+	Scope         string  `json:"scope,omitempty"` // Anomaly scope of the flow's workloads, if any
+}
+
+// ShrinkHeld is published when the agent keeps a policy's previous rules
+// because its resolved endpoints shrank more than the safety threshold
+type ShrinkHeld struct {
+	Policy    string    `json:"policy"`
+	Previous  int       `json:"previous"`   // Endpoints currently enforced
+	Resolved  int       `json:"resolved"`   // Endpoints discovery now returns
+	HeldUntil time.Time `json:"held_until"` // Zero if only confirmation releases it
+}
+
+// PolicyExpired is published when the agent removes a temporary policy whose
+// expiresAt or ttl has passed, and again with Hit set for each blocked flow
+// the expired policy would still have allowed
+type PolicyExpired struct {
+	Policy    string    `json:"policy"`
+	ExpiredAt time.Time `json:"expired_at"`
+	Principal string    `json:"principal,omitempty"` // Who last changed the policy
+	Hit       bool      `json:"hit,omitempty"`
+	SourceIP  string    `json:"source_ip,omitempty"` // Set with Hit
+	DestIP    string    `json:"dest_ip,omitempty"`
+	Port      int       `json:"port,omitempty"`
+	Protocol  string    `json:"protocol,omitempty"`
+}
+
+// Policy approval actions
+const (
+	ApprovalRequested = "requested"
+	ApprovalApproved  = "approved"
+	ApprovalRejected  = "rejected"
+)
+
+// PolicyApproval is published when a high-risk policy change is held for
+// approval, and when a second admin approves or rejects it
+type PolicyApproval struct {
+	ID          string   `json:"id"`
+	Policy      string   `json:"policy"`
+	Action      string   `json:"action"` // ApprovalRequested, ApprovalApproved or ApprovalRejected
+	Principal   string   `json:"principal"`
+	RequestedBy string   `json:"requested_by"`
+	Reasons     []string `json:"reasons,omitempty"`
+}
+
+// Break-glass actions
+const (
+	BreakGlassEnabled  = "enabled"
+	BreakGlassDisabled = "disabled"
+	BreakGlassExpired  = "expired" // Enforcement restored when the window ended
+)
+
+// BreakGlass is published when an admin suspends enforcement for incident
+// response, and when enforcement is restored
+type BreakGlass struct {
+	Action    string    `json:"action"` // BreakGlassEnabled, BreakGlassDisabled or BreakGlassExpired
+	Principal string    `json:"principal"`
+	Until     time.Time `json:"until,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// Maintenance window actions
+const (
+	MaintenanceScheduled  = "scheduled"
+	MaintenanceStarted    = "started"
+	MaintenanceEnded      = "ended"
+	MaintenanceOverridden = "overridden" // Ended early or replaced while open
+)
+
+// Maintenance is published when a maintenance window is scheduled, when an
+// agent sees it start and end, and when an operator overrides it
+type Maintenance struct {
+	Name      string            `json:"name"`
+	Action    string            `json:"action"` // MaintenanceScheduled, MaintenanceStarted, MaintenanceEnded or MaintenanceOverridden
+	Principal string            `json:"principal"`
+	Start     time.Time         `json:"start"`
+	End       time.Time         `json:"end"`
+	Selector  map[string]string `json:"selector,omitempty"` // Empty for a global window
+	Reason    string            `json:"reason,omitempty"`
+}
+
+// PolicyStale is published when an agent has enforced the same rule set for
+// longer than its staleness threshold because policies could not be loaded,
+// compiled or enforced (e.g. after restarting on its last-known-good rule
+// set), and again with Recovered set once it reconciles
+type PolicyStale struct {
+	Node      string    `json:"node,omitempty"`
+	LastGood  time.Time `json:"last_good"` // When the enforced rule set was last compiled and enforced in full
+	Error     string    `json:"error,omitempty"`
+	Recovered bool      `json:"recovered,omitempty"`
+}
+
+// Rotated secrets
+const (
+	SecretClusterCA  = "cluster_ca"
+	SecretJoinTokens = "join_tokens"
+	SecretSyncKey    = "sync_key"
+)
+
+// SecretRotated is published when an operator rotates a secret with 'ztap
+// security rotate'. The previous key keeps working until OverlapUntil.
+type SecretRotated struct {
+	Kind         string    `json:"kind"` // SecretClusterCA, SecretJoinTokens or SecretSyncKey
+	Principal    string    `json:"principal"`
+	KeyID        string    `json:"key_id,omitempty"`      // New key, or CA certificate fingerprint
+	PreviousID   string    `json:"previous_id,omitempty"` // Key or CA certificate it replaces
+	Reissued     int       `json:"reissued,omitempty"`    // Join tokens reissued
+	OverlapUntil time.Time `json:"overlap_until"`
+	Dropped      []string  `json:"dropped,omitempty"` // Keys retired earlier, no longer accepted
+}
@@ -0,0 +1,253 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"ztap/pkg/logging"
+)
+
+// journalMaxSize is the size at which the journal is rotated to path.1
+const journalMaxSize = 10 << 20
+
+// journalRecord is one line of the journal
+type journalRecord struct {
+	Origin    int             `json:"origin"` // PID of the publishing process
+	Topic     Topic           `json:"topic"`
+	Timestamp time.Time       `json:"timestamp"`
+	Tenant    string          `json:"tenant,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Journal bridges buses across processes through an append-only JSON lines
+// file. Every CLI process records the events it publishes, and a long-running
+// process such as the API server follows the file and replays other
+// processes' events onto its own bus.
+type Journal struct {
+	path   string
+	origin int
+}
+
+// NewJournal creates a journal at path
+func NewJournal(path string) *Journal {
+	return &Journal{path: path, origin: os.Getpid()}
+}
+
+// Record appends every event published on bus to the journal until stop is
+// called. Replayed events are skipped so they are not bridged twice.
+func (j *Journal) Record(bus *Bus) (stop func()) {
+	return bus.SubscribeFunc(func(event Event) {
+		if event.Replayed {
+			return
+		}
+		if err := j.append(event); err != nil {
+			log.Printf("Warning: Failed to journal event: %v", err)
+		}
+	})
+}
+
+// append writes one event as a single line, rotating a full journal
+func (j *Journal) append(event Event) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(journalRecord{
+		Origin:    j.origin,
+		Topic:     event.Topic,
+		Timestamp: event.Timestamp,
+		Tenant:    event.Tenant,
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// One write per record so concurrent appenders do not interleave lines
+	// Secrets in payloads, such as errors, are redacted before they are
+	// written and exported
+	line = []byte(logging.Scrub(string(line)))
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	if info, err := file.Stat(); err == nil && info.Size() > journalMaxSize {
+		return os.Rename(j.path, j.path+".1")
+	}
+	return nil
+}
+
+// Follow replays events that other processes append to the journal onto bus,
+// polling every interval until ctx is cancelled. Only events recorded after
+// Follow starts are replayed. A rotated journal, or one rewritten by
+// retention, is read from the start, past the records already read.
+func (j *Journal) Follow(ctx context.Context, bus *Bus, interval time.Duration) {
+	c := &cursor{}
+	if info, err := os.Stat(j.path); err == nil {
+		c.offset, c.file = info.Size(), info
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.replay(bus, c)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cursor is a follower's position in the journal
+type cursor struct {
+	offset int64
+	file   os.FileInfo // The file offset is in
+	last   time.Time   // Timestamp of the last record read
+}
+
+// replay replays the complete records after the cursor and advances it
+func (j *Journal) replay(bus *Bus, c *cursor) {
+	file, err := os.Open(j.path)
+	if err != nil {
+		c.offset, c.file = 0, nil
+		return
+	}
+	defer file.Close()
+
+	var skip time.Time
+	if info, err := file.Stat(); err == nil {
+		if c.file != nil && !os.SameFile(c.file, info) {
+			c.offset, skip = 0, c.last
+		} else if info.Size() < c.offset {
+			c.offset = 0
+		}
+		c.file = info
+	}
+	if _, err := file.Seek(c.offset, io.SeekStart); err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// A partial line is still being written; retry it next poll
+			return
+		}
+		c.offset += int64(len(line))
+
+		var record journalRecord
+		if json.Unmarshal(line, &record) != nil {
+			continue
+		}
+		if !skip.IsZero() && !record.Timestamp.After(skip) {
+			continue
+		}
+		c.last = record.Timestamp
+		if record.Origin == j.origin {
+			continue
+		}
+		data, err := decodeData(record.Topic, record.Data)
+		if err != nil {
+			continue
+		}
+		bus.Replay(Event{Topic: record.Topic, Timestamp: record.Timestamp, Data: data, Tenant: record.Tenant})
+	}
+}
+
+// History returns the journaled events on topics recorded since since,
+// oldest first, including those of this process and the rotated file
+func (j *Journal) History(since time.Time, topics ...Topic) ([]Event, error) {
+	var history []Event
+	for _, path := range []string{j.path + ".1", j.path} {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var record journalRecord
+			if json.Unmarshal(scanner.Bytes(), &record) != nil || record.Timestamp.Before(since) {
+				continue
+			}
+			if len(topics) > 0 && !slices.Contains(topics, record.Topic) {
+				continue
+			}
+			data, err := decodeData(record.Topic, record.Data)
+			if err != nil {
+				continue
+			}
+			history = append(history, Event{Topic: record.Topic, Timestamp: record.Timestamp, Data: data, Replayed: true, Tenant: record.Tenant})
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read journal: %w", err)
+		}
+	}
+	return history, nil
+}
+
+// decodeData decodes a payload into its topic's type, so replayed events
+// carry the same Data types as locally published ones
+func decodeData(topic Topic, raw json.RawMessage) (interface{}, error) {
+	switch topic {
+	case TopicServiceChanged:
+		return decode[ServiceChanged](raw)
+	case TopicPolicyApplied:
+		return decode[PolicyApplied](raw)
+	case TopicLeaderChanged:
+		return decode[LeaderChanged](raw)
+	case TopicFlowBlocked:
+		return decode[FlowBlocked](raw)
+	case TopicAnomalyDetected:
+		return decode[AnomalyDetected](raw)
+	case TopicShrinkHeld:
+		return decode[ShrinkHeld](raw)
+	case TopicPolicyExpired:
+		return decode[PolicyExpired](raw)
+	case TopicPolicyApproval:
+		return decode[PolicyApproval](raw)
+	case TopicBreakGlass:
+		return decode[BreakGlass](raw)
+	case TopicMaintenance:
+		return decode[Maintenance](raw)
+	case TopicPolicyStale:
+		return decode[PolicyStale](raw)
+	case TopicSecretRotated:
+		return decode[SecretRotated](raw)
+	}
+	return nil, fmt.Errorf("unknown topic %q", topic)
+}
+
+func decode[T any](raw json.RawMessage) (interface{}, error) {
+	var data T
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
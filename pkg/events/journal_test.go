@@ -0,0 +1,168 @@
+package events
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalBridgesProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+
+	// Two processes sharing a journal, distinguished by origin
+	agentBus, serverBus := NewBus(), NewBus()
+	agent := &Journal{path: path, origin: 100}
+	server := &Journal{path: path, origin: 200}
+
+	stop := agent.Record(agentBus)
+	defer stop()
+	defer server.Record(serverBus)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := serverBus.Subscribe(ctx)
+	go server.Follow(ctx, serverBus, 5*time.Millisecond)
+
+	// Let Follow record its starting offset first
+	time.Sleep(20 * time.Millisecond)
+	agentBus.Publish(TopicPolicyApplied, PolicyApplied{Policy: "web-to-db", Backend: "ebpf", Error: "denied"})
+	serverBus.Publish(TopicLeaderChanged, LeaderChanged{LeaderID: "node-1", Epoch: 2})
+
+	// The server's own event is delivered locally, not replayed
+	if event := <-stream; event.Replayed || event.Topic != TopicLeaderChanged {
+		t.Errorf("Expected local leader event first, got %+v", event)
+	}
+
+	select {
+	case event := <-stream:
+		data, ok := event.Data.(PolicyApplied)
+		if !ok || data.Policy != "web-to-db" || data.Error != "denied" {
+			t.Fatalf("Expected typed PolicyApplied payload, got %#v", event.Data)
+		}
+		if !event.Replayed {
+			t.Error("Expected event to be marked replayed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for replayed event")
+	}
+
+	// Replayed events are not journaled again
+	time.Sleep(20 * time.Millisecond)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	if lines := countLines(data); lines != 2 {
+		t.Errorf("Expected 2 journal records, got %d", lines)
+	}
+}
+
+func TestJournalSkipsHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	writer := &Journal{path: path, origin: 100}
+	if err := writer.append(Event{Topic: TopicFlowBlocked, Data: FlowBlocked{Port: 22}}); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+
+	bus := NewBus()
+	var replayed []Event
+	defer bus.SubscribeFunc(func(event Event) { replayed = append(replayed, event) })()
+
+	reader := &Journal{path: path, origin: 200}
+	c := &cursor{}
+	reader.replay(bus, c)
+	offset := c.offset
+	if len(replayed) != 1 {
+		t.Fatalf("Expected 1 replayed event from offset 0, got %d", len(replayed))
+	}
+
+	// A partial line is left for the next poll
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	file.WriteString(`{"origin":100,"topic":"flow_blocked","data":{"port":`)
+	file.Close()
+	if reader.replay(bus, c); c.offset != offset || len(replayed) != 1 {
+		t.Errorf("Expected partial record to be deferred, got offset %d, %d events", c.offset, len(replayed))
+	}
+
+	// Truncation shrinks the file below the offset: read from the start
+	os.WriteFile(path, []byte(`{"origin":100,"topic":"anomaly_detected","data":{"score":80}}`+"\n"), 0600)
+	reader.replay(bus, c)
+	if len(replayed) != 2 || replayed[1].Data.(AnomalyDetected).Score != 80 {
+		t.Errorf("Expected rotated journal to be replayed, got %+v", replayed)
+	}
+}
+
+func TestJournalFollowsRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	writer := &Journal{path: path, origin: 100}
+	start := time.Date(2025, 10, 9, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		writer.append(Event{Topic: TopicFlowBlocked, Timestamp: start.Add(time.Duration(i) * time.Minute), Data: FlowBlocked{Port: i}})
+	}
+
+	bus := NewBus()
+	var replayed []Event
+	defer bus.SubscribeFunc(func(event Event) { replayed = append(replayed, event) })()
+	reader := &Journal{path: path, origin: 200}
+	c := &cursor{}
+	reader.replay(bus, c)
+
+	// Retention replaces the journal with its newest record, and a record is
+	// appended after: only the new record is replayed
+	data, _ := os.ReadFile(path)
+	lines := strings.SplitAfter(string(data), "\n")
+	os.WriteFile(path+".tmp", []byte(lines[2]), 0600)
+	os.Rename(path+".tmp", path)
+	writer.append(Event{Topic: TopicFlowBlocked, Timestamp: start.Add(time.Hour), Data: FlowBlocked{Port: 3}})
+
+	reader.replay(bus, c)
+	if len(replayed) != 4 || replayed[3].Data.(FlowBlocked).Port != 3 {
+		t.Errorf("Expected only the appended record replayed after the rewrite, got %+v", replayed)
+	}
+}
+
+func TestJournalHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	j := &Journal{path: path, origin: 100}
+	start := time.Date(2025, 10, 9, 10, 0, 0, 0, time.UTC)
+
+	// The oldest event was rotated to path.1
+	j.append(Event{Topic: TopicAnomalyDetected, Timestamp: start, Data: AnomalyDetected{ID: "a1"}})
+	os.Rename(path, path+".1")
+	j.append(Event{Topic: TopicAnomalyDetected, Timestamp: start.Add(time.Hour), Data: AnomalyDetected{ID: "a2"}})
+	j.append(Event{Topic: TopicFlowBlocked, Timestamp: start.Add(time.Hour), Data: FlowBlocked{Port: 22}})
+	j.append(Event{Topic: TopicAnomalyDetected, Timestamp: start.Add(2 * time.Hour), Data: AnomalyDetected{ID: "a3"}, Tenant: "acme"})
+
+	history, err := j.History(start.Add(time.Minute), TopicAnomalyDetected)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	var ids []string
+	for _, event := range history {
+		ids = append(ids, event.Data.(AnomalyDetected).ID)
+	}
+	if len(ids) != 2 || ids[0] != "a2" || ids[1] != "a3" {
+		t.Errorf("Expected anomalies a2 and a3, got %v", ids)
+	}
+	if history[1].Tenant != "acme" {
+		t.Errorf("Expected the tenant journaled, got %q", history[1].Tenant)
+	}
+
+	all, _ := j.History(time.Time{})
+	if len(all) != 4 {
+		t.Errorf("Expected 4 events on all topics, got %d", len(all))
+	}
+}
+
+func countLines(data []byte) int {
+	n := 0
+	for _, b := range data {
+		if b == '\n' {
+			n++
+		}
+	}
+	return n
+}
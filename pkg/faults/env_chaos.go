@@ -0,0 +1,22 @@
+//go:build chaos
+// +build chaos
+
+package faults
+
+import (
+	"log"
+	"os"
+)
+
+// Chaos builds read faults from ZTAP_FAULTS at startup
+func init() {
+	spec := os.Getenv("ZTAP_FAULTS")
+	if spec == "" {
+		return
+	}
+	if err := Set(spec); err != nil {
+		log.Printf("Warning: ignoring ZTAP_FAULTS: %v", err)
+		return
+	}
+	log.Printf("Warning: fault injection enabled: %s", spec)
+}
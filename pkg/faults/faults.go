@@ -0,0 +1,216 @@
+// Package faults injects failures into ZTAP's cluster, discovery, cloud and
+// enforcement paths so chaos tests can exercise the reconciliation logic.
+//
+// Fault points are always compiled in but do nothing until faults are
+// configured, either in-process with Set or, in binaries built with
+// -tags chaos, through the ZTAP_FAULTS environment variable. A spec is a
+// comma-separated list of point:action=value entries:
+//
+//	ZTAP_FAULTS="cluster.heartbeat:drop=100%,discovery.resolve:delay=2s,aws.call:fail=10%"
+//
+// Actions are fail (alias drop) with a probability, and delay with a
+// duration. A delay is applied before the point fails or proceeds.
+package faults
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Point names a place where faults can be injected
+type Point string
+
+const (
+	// Heartbeat is a node refreshing its liveness with the leader election
+	Heartbeat Point = "cluster.heartbeat"
+	// DiscoveryResolve is a discovery backend resolving a label selector
+	DiscoveryResolve Point = "discovery.resolve"
+	// AWSCall is any EC2 API call
+	AWSCall Point = "aws.call"
+	// Enforce is the agent applying policies to the enforcement backend
+	Enforce Point = "enforce.apply"
+	// Controller is the agent checking the API server managing its node
+	Controller Point = "controller.check"
+	// Detect is a remote anomaly detection service scoring flows
+	Detect Point = "anomaly.detect"
+)
+
+// Points lists every fault point
+var Points = []Point{Heartbeat, DiscoveryResolve, AWSCall, Enforce, Controller, Detect}
+
+// ErrInjected is returned by a point that was made to fail
+var ErrInjected = errors.New("injected fault")
+
+// Fault is what happens at one point
+type Fault struct {
+	Rate  float64       // Probability in [0, 1] that the point fails
+	Delay time.Duration // Added before the point fails or proceeds
+}
+
+var (
+	config atomic.Pointer[map[Point]Fault]
+
+	randMu sync.Mutex
+	random = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	injected sync.Map // Point -> *atomic.Uint64
+)
+
+// Parse parses a ZTAP_FAULTS spec
+func Parse(spec string) (map[Point]Fault, error) {
+	faults := make(map[Point]Fault)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, value, ok := strings.Cut(entry, "=")
+		point, action, ok2 := strings.Cut(target, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid fault %q: want point:action=value", entry)
+		}
+		if !known(Point(point)) {
+			return nil, fmt.Errorf("invalid fault %q: unknown point %s", entry, point)
+		}
+
+		fault := faults[Point(point)]
+		switch action {
+		case "fail", "drop":
+			rate, err := parseRate(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fault %q: %w", entry, err)
+			}
+			fault.Rate = rate
+		case "delay":
+			delay, err := time.ParseDuration(value)
+			if err != nil || delay < 0 {
+				return nil, fmt.Errorf("invalid fault %q: invalid delay %s", entry, value)
+			}
+			fault.Delay = delay
+		default:
+			return nil, fmt.Errorf("invalid fault %q: unknown action %s", entry, action)
+		}
+		faults[Point(point)] = fault
+	}
+	return faults, nil
+}
+
+func known(p Point) bool {
+	for _, point := range Points {
+		if point == p {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRate accepts "25%" or a fraction such as "0.25"
+func parseRate(value string) (float64, error) {
+	scale := 1.0
+	if strings.HasSuffix(value, "%") {
+		value = strings.TrimSuffix(value, "%")
+		scale = 100
+	}
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %s", value)
+	}
+	rate /= scale
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate %s out of range", value)
+	}
+	return rate, nil
+}
+
+// Set replaces the configured faults with spec; an empty spec clears them
+func Set(spec string) error {
+	faults, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	Configure(faults)
+	return nil
+}
+
+// Configure replaces the configured faults
+func Configure(faults map[Point]Fault) {
+	if len(faults) == 0 {
+		config.Store(nil)
+		return
+	}
+	copied := make(map[Point]Fault, len(faults))
+	for p, f := range faults {
+		copied[p] = f
+	}
+	config.Store(&copied)
+}
+
+// Reset clears all faults and injection counts
+func Reset() {
+	config.Store(nil)
+	injected.Range(func(key, _ any) bool {
+		injected.Delete(key)
+		return true
+	})
+}
+
+// Configured reports whether a fault is configured at p
+func Configured(p Point) bool {
+	faults := config.Load()
+	if faults == nil {
+		return false
+	}
+	_, ok := (*faults)[p]
+	return ok
+}
+
+// Active reports whether any fault is configured
+func Active() bool {
+	return config.Load() != nil
+}
+
+// Inject applies the fault configured for p: it sleeps for the configured
+// delay and then returns ErrInjected with the configured probability. It
+// returns nil immediately when no fault is configured.
+func Inject(p Point) error {
+	faults := config.Load()
+	if faults == nil {
+		return nil
+	}
+	fault, ok := (*faults)[p]
+	if !ok {
+		return nil
+	}
+
+	if fault.Delay > 0 {
+		time.Sleep(fault.Delay)
+	}
+	if fault.Rate <= 0 {
+		return nil
+	}
+	randMu.Lock()
+	fail := random.Float64() < fault.Rate
+	randMu.Unlock()
+	if !fail {
+		return nil
+	}
+
+	counter, _ := injected.LoadOrStore(p, new(atomic.Uint64))
+	counter.(*atomic.Uint64).Add(1)
+	return fmt.Errorf("%w at %s", ErrInjected, p)
+}
+
+// Injected returns how many failures were injected at p since the last Reset
+func Injected(p Point) uint64 {
+	counter, ok := injected.Load(p)
+	if !ok {
+		return 0
+	}
+	return counter.(*atomic.Uint64).Load()
+}
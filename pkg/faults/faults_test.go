@@ -0,0 +1,85 @@
+package faults
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	faults, err := Parse("cluster.heartbeat:drop=100%, discovery.resolve:delay=20ms,discovery.resolve:fail=0.5,aws.call:fail=10%")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if faults[Heartbeat].Rate != 1 {
+		t.Errorf("Expected heartbeat drop rate 1, got %v", faults[Heartbeat].Rate)
+	}
+	if f := faults[DiscoveryResolve]; f.Delay != 20*time.Millisecond || f.Rate != 0.5 {
+		t.Errorf("Expected discovery delay and rate to combine, got %+v", f)
+	}
+	if faults[AWSCall].Rate != 0.1 {
+		t.Errorf("Expected aws rate 0.1, got %v", faults[AWSCall].Rate)
+	}
+
+	for _, bad := range []string{
+		"cluster.heartbeat",
+		"nowhere:fail=1",
+		"aws.call:explode=1",
+		"aws.call:fail=150%",
+		"aws.call:delay=soon",
+	} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestInject(t *testing.T) {
+	defer Reset()
+
+	if err := Inject(AWSCall); err != nil {
+		t.Fatalf("Expected no fault when unconfigured, got %v", err)
+	}
+
+	if err := Set("aws.call:fail=100%,enforce.apply:fail=0"); err != nil {
+		t.Fatal(err)
+	}
+	if !Active() {
+		t.Error("Expected faults to be active")
+	}
+	if !Configured(Enforce) || Configured(Controller) {
+		t.Error("Expected only the configured points to be reported")
+	}
+	for i := 0; i < 3; i++ {
+		if err := Inject(AWSCall); !errors.Is(err, ErrInjected) {
+			t.Errorf("Expected ErrInjected, got %v", err)
+		}
+	}
+	if err := Inject(Enforce); err != nil {
+		t.Errorf("Expected zero rate to never fail, got %v", err)
+	}
+	if err := Inject(Heartbeat); err != nil {
+		t.Errorf("Expected unconfigured point to pass, got %v", err)
+	}
+	if Injected(AWSCall) != 3 || Injected(Enforce) != 0 {
+		t.Errorf("Unexpected counts: aws=%d enforce=%d", Injected(AWSCall), Injected(Enforce))
+	}
+
+	Reset()
+	if Active() || Injected(AWSCall) != 0 {
+		t.Error("Expected Reset to clear faults and counts")
+	}
+}
+
+func TestInjectDelay(t *testing.T) {
+	defer Reset()
+	Configure(map[Point]Fault{DiscoveryResolve: {Delay: 30 * time.Millisecond}})
+
+	start := time.Now()
+	if err := Inject(DiscoveryResolve); err != nil {
+		t.Fatalf("Expected delay only, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Expected at least 30ms delay, got %v", elapsed)
+	}
+}
@@ -0,0 +1,162 @@
+// Package flowlog aggregates flow verdicts before they are written to the
+// enforcement log: identical flows (same source, destination, port,
+// protocol, verdict and policy) seen within a window are written as one
+// record with their count, bytes and first-seen and last-seen times, keeping
+// log volume proportional to distinct flows rather than packets.
+package flowlog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"ztap/pkg/configfile"
+)
+
+// Flow is one flow verdict
+type Flow struct {
+	Timestamp time.Time
+	Policy    string
+	Action    string // ALLOWED or BLOCKED
+	SourceIP  string
+	DestIP    string
+	Port      int
+	Protocol  string
+	Bytes     int64
+	Labels    map[string]string
+}
+
+// Record is the aggregate of identical flows
+type Record struct {
+	Flow      // The first flow, with the total Bytes
+	Count     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Config is the flow_log section of config.yaml
+type Config struct {
+	Window   time.Duration            `yaml:"window"`   // Aggregation window (0 logs every flow)
+	Backends map[string]time.Duration `yaml:"backends"` // Window per enforcement backend, overriding Window
+}
+
+// WindowFor returns the aggregation window of an enforcement backend
+func (c Config) WindowFor(backend string) time.Duration {
+	if window, ok := c.Backends[backend]; ok {
+		return window
+	}
+	return c.Window
+}
+
+// Validate checks that no window is negative
+func (c Config) Validate() error {
+	if c.Window < 0 {
+		return fmt.Errorf("flow_log.window must not be negative")
+	}
+	for backend, window := range c.Backends {
+		if window < 0 {
+			return fmt.Errorf("flow_log.backends.%s must not be negative", backend)
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads the flow_log section of file. A missing file or section
+// logs every flow.
+func LoadConfig(file *configfile.File) (Config, error) {
+	var c Config
+	if err := file.Section("flow_log", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// key identifies identical flows
+type key struct {
+	policy, action   string
+	sourceIP, destIP string
+	port             int
+	protocol         string
+}
+
+// Aggregator groups flows over a window and writes a record per group when
+// the window closes
+type Aggregator struct {
+	window time.Duration
+	write  func(Record) error
+
+	mu     sync.Mutex
+	groups map[key]*Record
+	order  []key // Groups in first-seen order
+	timer  *time.Timer
+	err    error // Last write error of a timed flush
+}
+
+// NewAggregator creates an aggregator writing records with write. The
+// first flow of a window starts it; with a zero window every flow is
+// written at once as a record of one.
+func NewAggregator(window time.Duration, write func(Record) error) *Aggregator {
+	return &Aggregator{
+		window: window,
+		write:  write,
+		groups: make(map[key]*Record),
+	}
+}
+
+// Add records a flow. It returns the error of writing it when not
+// aggregating, and otherwise the error of the last timed flush, if any.
+func (a *Aggregator) Add(f Flow) error {
+	if a.window <= 0 {
+		return a.write(Record{Flow: f, Count: 1, FirstSeen: f.Timestamp, LastSeen: f.Timestamp})
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	k := key{f.Policy, f.Action, f.SourceIP, f.DestIP, f.Port, f.Protocol}
+	r, ok := a.groups[k]
+	if !ok {
+		a.groups[k] = &Record{Flow: f, Count: 1, FirstSeen: f.Timestamp, LastSeen: f.Timestamp}
+		a.order = append(a.order, k)
+	} else {
+		r.Count++
+		r.Bytes += f.Bytes
+		if f.Timestamp.Before(r.FirstSeen) {
+			r.FirstSeen = f.Timestamp
+		}
+		if f.Timestamp.After(r.LastSeen) {
+			r.LastSeen = f.Timestamp
+		}
+	}
+	if a.timer == nil {
+		a.timer = time.AfterFunc(a.window, func() {
+			if err := a.Flush(); err != nil {
+				a.mu.Lock()
+				a.err = err
+				a.mu.Unlock()
+			}
+		})
+	}
+	err := a.err
+	a.err = nil
+	return err
+}
+
+// Flush writes the pending records, in first-seen order, and starts a new
+// window. Call it before exiting so no flow is lost.
+func (a *Aggregator) Flush() error {
+	a.mu.Lock()
+	groups, order := a.groups, a.order
+	a.groups, a.order = make(map[key]*Record), nil
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	a.mu.Unlock()
+
+	for _, k := range order {
+		if err := a.write(*groups[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package flowlog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ztap/pkg/configfile"
+)
+
+func TestAggregator(t *testing.T) {
+	var records []Record
+	a := NewAggregator(time.Hour, func(r Record) error {
+		records = append(records, r)
+		return nil
+	})
+
+	start := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	scan := Flow{Action: "BLOCKED", SourceIP: "203.0.113.9", DestIP: "10.0.1.1", Port: 22, Protocol: "TCP", Bytes: 60}
+	for i := 0; i < 3; i++ {
+		scan.Timestamp = start.Add(time.Duration(i) * time.Second)
+		if err := a.Add(scan); err != nil {
+			t.Fatal(err)
+		}
+	}
+	web := Flow{Timestamp: start.Add(time.Second), Policy: "web", Action: "ALLOWED", SourceIP: "10.0.1.1", DestIP: "10.0.2.1", Port: 443, Protocol: "TCP"}
+	if err := a.Add(web); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("Expected nothing written before the window closes, got %v", records)
+	}
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected a record per distinct flow, got %v", records)
+	}
+	r := records[0]
+	if r.Count != 3 || r.Bytes != 180 || !r.FirstSeen.Equal(start) || !r.LastSeen.Equal(start.Add(2*time.Second)) {
+		t.Errorf("Expected 3 flows of 180 bytes over 2s, got %+v", r)
+	}
+	if records[1].Policy != "web" || records[1].Count != 1 {
+		t.Errorf("Expected the web flow on its own, got %+v", records[1])
+	}
+
+	// Flushing starts a new window
+	if err := a.Flush(); err != nil || len(records) != 2 {
+		t.Errorf("Expected nothing left to flush, got %v, %v", records, err)
+	}
+}
+
+func TestAggregatorWithoutWindow(t *testing.T) {
+	written := 0
+	a := NewAggregator(0, func(r Record) error {
+		if r.Count != 1 {
+			t.Errorf("Expected single flows, got %+v", r)
+		}
+		written++
+		return nil
+	})
+	for i := 0; i < 2; i++ {
+		if err := a.Add(Flow{Action: "ALLOWED", Timestamp: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if written != 2 {
+		t.Errorf("Expected every flow written at once, got %d", written)
+	}
+}
+
+func TestAggregatorTimedFlush(t *testing.T) {
+	written := make(chan Record, 1)
+	a := NewAggregator(10*time.Millisecond, func(r Record) error {
+		written <- r
+		return nil
+	})
+	if err := a.Add(Flow{Action: "BLOCKED", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-written:
+		if r.Count != 1 {
+			t.Errorf("Expected one flow, got %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the record written when the window closed")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("flow_log:\n  window: 10s\n  backends:\n    pf: 1m\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := configfile.Read(path, LoadConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.WindowFor("pf") != time.Minute || config.WindowFor("ebpf") != 10*time.Second {
+		t.Errorf("Expected per-backend windows over the default, got %+v", config)
+	}
+
+	if err := os.WriteFile(path, []byte("flow_log:\n  window: -1s\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := configfile.Read(path, LoadConfig); err == nil {
+		t.Error("Expected a negative window rejected")
+	}
+}
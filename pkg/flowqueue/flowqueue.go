@@ -0,0 +1,175 @@
+// Package flowqueue bounds the agent's flow pipeline, so that a flood of flow
+// events (a DDoS or a port scan) cannot exhaust memory or fall ever further
+// behind: flows wait for the handler in a fixed-size queue, flows arriving
+// while it is full are dropped, and while it is overloaded only 1 in N
+// identical verdicts is queued. Dropped and sampled flows are counted in
+// metrics.
+package flowqueue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"ztap/pkg/configfile"
+	"ztap/pkg/metrics"
+)
+
+// Config is the flow_queue section of config.yaml
+type Config struct {
+	Size        int     `yaml:"size"`         // Flows waiting for the handler; more are dropped
+	SampleAbove float64 `yaml:"sample_above"` // Fraction of Size filled above which the queue is overloaded
+	SampleRate  int     `yaml:"sample_rate"`  // While overloaded, 1 in SampleRate identical verdicts is queued (1 disables sampling)
+}
+
+// DefaultConfig buffers a few seconds of a busy node's flows
+var DefaultConfig = Config{
+	Size:        10000,
+	SampleAbove: 0.5,
+	SampleRate:  100,
+}
+
+// Validate checks the configuration
+func (c Config) Validate() error {
+	if c.Size <= 0 {
+		return fmt.Errorf("flow_queue.size must be positive")
+	}
+	if c.SampleAbove <= 0 || c.SampleAbove > 1 {
+		return fmt.Errorf("flow_queue.sample_above must be in (0, 1], got %g", c.SampleAbove)
+	}
+	if c.SampleRate < 1 {
+		return fmt.Errorf("flow_queue.sample_rate must be at least 1")
+	}
+	return nil
+}
+
+// LoadConfig reads the flow_queue section of file. Unset fields keep their
+// DefaultConfig value.
+func LoadConfig(file *configfile.File) (Config, error) {
+	c := DefaultConfig
+	if err := file.Section("flow_queue", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Queue hands items to a handler running in its own goroutine (see Run)
+type Queue[T any] struct {
+	config    Config
+	threshold int
+	ch        chan T
+	verdict   func(T) string
+	handle    func(T)
+
+	mu         sync.Mutex
+	overloaded bool
+	seen       map[string]int // Items offered per verdict while overloaded
+	dropped    int            // Since the overload began
+	sampled    int
+}
+
+// New creates a queue handing items to handle. Items for which verdict
+// returns the same string are identical for sampling; a nil verdict never
+// samples.
+func New[T any](config Config, verdict func(T) string, handle func(T)) *Queue[T] {
+	return &Queue[T]{
+		config:    config,
+		threshold: max(1, int(float64(config.Size)*config.SampleAbove)),
+		ch:        make(chan T, config.Size),
+		verdict:   verdict,
+		handle:    handle,
+		seen:      make(map[string]int),
+	}
+}
+
+// Offer queues item without blocking and reports whether it was queued: it
+// is dropped if the queue is full, and skipped if the queue is overloaded
+// and it is not the 1 in SampleRate item with its verdict
+func (q *Queue[T]) Offer(item T) bool {
+	if depth := len(q.ch); depth >= q.threshold && q.sample(item, depth) {
+		metrics.GetCollector().IncFlowsSampled()
+		return false
+	}
+
+	select {
+	case q.ch <- item:
+		metrics.GetCollector().SetFlowQueueDepth(len(q.ch))
+		return true
+	default:
+	}
+	q.mu.Lock()
+	q.overload(len(q.ch))
+	q.dropped++
+	q.mu.Unlock()
+	metrics.GetCollector().IncFlowsDropped()
+	return false
+}
+
+// sample records an item offered to the overloaded queue and reports
+// whether to skip it
+func (q *Queue[T]) sample(item T, depth int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.overload(depth)
+	if q.verdict == nil || q.config.SampleRate <= 1 {
+		return false
+	}
+
+	// Bound the verdicts remembered, as a scan makes each one unique
+	if len(q.seen) >= q.config.Size {
+		clear(q.seen)
+	}
+	key := q.verdict(item)
+	n := q.seen[key]
+	q.seen[key] = n + 1
+	if n%q.config.SampleRate == 0 {
+		return false
+	}
+	q.sampled++
+	return true
+}
+
+// overload logs the start of an overload (requires holding mu)
+func (q *Queue[T]) overload(depth int) {
+	if q.overloaded {
+		return
+	}
+	q.overloaded = true
+	log.Printf("Warning: Flow queue overloaded (%d/%d flows waiting): dropping flows once full, handling 1 in %d identical verdicts", depth, q.config.Size, q.config.SampleRate)
+}
+
+// recovered logs the end of an overload once the queue is empty
+func (q *Queue[T]) recovered() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.overloaded {
+		return
+	}
+	log.Printf("Flow queue caught up: %d flows dropped and %d sampled out while overloaded", q.dropped, q.sampled)
+	q.overloaded = false
+	q.dropped, q.sampled = 0, 0
+	clear(q.seen)
+}
+
+// Len returns the number of items waiting
+func (q *Queue[T]) Len() int {
+	return len(q.ch)
+}
+
+// Run hands queued items to the handler until ctx is cancelled
+func (q *Queue[T]) Run(ctx context.Context) {
+	for {
+		select {
+		case item := <-q.ch:
+			q.handle(item)
+			depth := len(q.ch)
+			metrics.GetCollector().SetFlowQueueDepth(depth)
+			if depth == 0 {
+				q.recovered()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
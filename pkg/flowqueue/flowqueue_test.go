@@ -0,0 +1,85 @@
+package flowqueue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ztap/pkg/configfile"
+)
+
+func TestQueueDropsAndSamples(t *testing.T) {
+	config := Config{Size: 10, SampleAbove: 0.5, SampleRate: 4}
+	var handled []string
+	q := New(config, func(v string) string { return v }, func(v string) {
+		handled = append(handled, v)
+	})
+
+	// Below the threshold every flow is queued
+	for i := 0; i < 5; i++ {
+		if !q.Offer("scan") {
+			t.Fatalf("Expected flow %d queued", i)
+		}
+	}
+	// Overloaded: 1 in 4 identical verdicts is queued
+	queued := 0
+	for i := 0; i < 8; i++ {
+		if q.Offer("scan") {
+			queued++
+		}
+	}
+	if queued != 2 {
+		t.Errorf("Expected 2 of 8 identical flows queued while overloaded, got %d", queued)
+	}
+	// Distinct verdicts are queued until the queue is full, then dropped
+	for _, v := range []string{"a", "b", "c", "d"} {
+		q.Offer(v)
+	}
+	if q.Len() != config.Size {
+		t.Fatalf("Expected a full queue, got %d", q.Len())
+	}
+	if q.Offer("e") {
+		t.Error("Expected a flow offered to a full queue dropped")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.handle = func(v string) {
+		handled = append(handled, v)
+		if q.Len() == 0 {
+			cancel()
+		}
+	}
+	q.Run(ctx)
+	if len(handled) != config.Size {
+		t.Errorf("Expected %d flows handled, got %d", config.Size, len(handled))
+	}
+	if q.overloaded {
+		t.Error("Expected the overload to end once the queue drained")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config, err := configfile.Read(path, LoadConfig)
+	if err != nil || config != DefaultConfig {
+		t.Fatalf("Expected defaults without a config file, got %+v, %v", config, err)
+	}
+
+	if err := os.WriteFile(path, []byte("flow_queue:\n  size: 500\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = configfile.Read(path, LoadConfig); err != nil {
+		t.Fatal(err)
+	}
+	if config.Size != 500 || config.SampleRate != DefaultConfig.SampleRate {
+		t.Errorf("Expected set fields over defaults, got %+v", config)
+	}
+
+	if err := os.WriteFile(path, []byte("flow_queue:\n  sample_above: 2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := configfile.Read(path, LoadConfig); err == nil {
+		t.Error("Expected sample_above over 1 rejected")
+	}
+}
@@ -0,0 +1,168 @@
+package graph
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats lists the export formats
+var Formats = []string{"dot", "graphml", "json"}
+
+// Write exports the graph in format (see Formats)
+func (g *Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case "dot":
+		return g.WriteDOT(w)
+	case "graphml":
+		return g.WriteGraphML(w)
+	case "json":
+		return g.WriteJSON(w)
+	default:
+		return fmt.Errorf("unknown graph format %q (use %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+// WriteJSON writes the graph as JSON
+func (g *Graph) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(g)
+}
+
+// edgeColors colors DOT edges by coverage
+var edgeColors = map[Coverage]string{
+	Covered:   "darkgreen",
+	Partial:   "orange",
+	Uncovered: "red",
+}
+
+// WriteDOT writes the graph in Graphviz DOT format. Unknown IPs are drawn as
+// dashed boxes, annotated edges are green, orange or red by coverage, and
+// edges without an allowed flow are dashed.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph ztap {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=ellipse];\n")
+	for _, n := range g.Nodes {
+		attrs := []string{"label=" + strconv.Quote(n.ID)}
+		if !n.Known {
+			attrs = append(attrs, "shape=box", "style=dashed")
+		}
+		fmt.Fprintf(&b, "  %s [%s];\n", strconv.Quote(n.ID), strings.Join(attrs, ", "))
+	}
+	for _, e := range g.Edges {
+		label := fmt.Sprintf("%s/%d (%d)", e.Protocol, e.Port, e.Flows())
+		attrs := []string{"label=" + strconv.Quote(label)}
+		if color, ok := edgeColors[e.Coverage]; ok {
+			attrs = append(attrs, "color="+color)
+		}
+		if e.Allowed == 0 {
+			attrs = append(attrs, "style=dashed")
+		}
+		fmt.Fprintf(&b, "  %s -> %s [%s];\n", strconv.Quote(e.From), strconv.Quote(e.To), strings.Join(attrs, ", "))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// GraphML document types
+type (
+	graphML struct {
+		XMLName xml.Name     `xml:"graphml"`
+		XMLNS   string       `xml:"xmlns,attr"`
+		Keys    []graphMLKey `xml:"key"`
+		Graph   graphMLGraph `xml:"graph"`
+	}
+	graphMLKey struct {
+		ID   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+		Type string `xml:"attr.type,attr"`
+	}
+	graphMLGraph struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	}
+	graphMLNode struct {
+		ID   string        `xml:"id,attr"`
+		Data []graphMLData `xml:"data"`
+	}
+	graphMLEdge struct {
+		Source string        `xml:"source,attr"`
+		Target string        `xml:"target,attr"`
+		Data   []graphMLData `xml:"data"`
+	}
+	graphMLData struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+)
+
+var graphMLKeys = []graphMLKey{
+	{ID: "known", For: "node", Name: "known", Type: "boolean"},
+	{ID: "labels", For: "node", Name: "labels", Type: "string"},
+	{ID: "ips", For: "node", Name: "ips", Type: "string"},
+	{ID: "port", For: "edge", Name: "port", Type: "int"},
+	{ID: "protocol", For: "edge", Name: "protocol", Type: "string"},
+	{ID: "allowed", For: "edge", Name: "allowed", Type: "int"},
+	{ID: "blocked", For: "edge", Name: "blocked", Type: "int"},
+	{ID: "policies", For: "edge", Name: "policies", Type: "string"},
+	{ID: "coverage", For: "edge", Name: "coverage", Type: "string"},
+}
+
+// WriteGraphML writes the graph as GraphML, e.g. for Gephi or yEd
+func (g *Graph) WriteGraphML(w io.Writer) error {
+	doc := graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  graphMLKeys,
+		Graph: graphMLGraph{ID: "ztap", EdgeDefault: "directed"},
+	}
+	for _, n := range g.Nodes {
+		labels := make([]string, 0, len(n.Labels))
+		for k, v := range n.Labels {
+			labels = append(labels, k+"="+v)
+		}
+		sort.Strings(labels)
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: n.ID,
+			Data: []graphMLData{
+				{Key: "known", Value: strconv.FormatBool(n.Known)},
+				{Key: "labels", Value: strings.Join(labels, ",")},
+				{Key: "ips", Value: strings.Join(n.IPs, ",")},
+			},
+		})
+	}
+	for _, e := range g.Edges {
+		data := []graphMLData{
+			{Key: "port", Value: strconv.Itoa(e.Port)},
+			{Key: "protocol", Value: e.Protocol},
+			{Key: "allowed", Value: strconv.Itoa(e.Allowed)},
+			{Key: "blocked", Value: strconv.Itoa(e.Blocked)},
+			{Key: "policies", Value: strings.Join(e.Policies, ",")},
+		}
+		if e.Coverage != "" {
+			data = append(data, graphMLData{Key: "coverage", Value: string(e.Coverage)})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: e.From, Target: e.To, Data: data})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,303 @@
+// Package graph builds a service-to-service communication graph from flow
+// logs, with workloads identified by their discovery labels, and exports it
+// as DOT, GraphML or JSON. Edges can be annotated with whether the policy
+// set allows them, as a starting point for authoring policies.
+package graph
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"ztap/pkg/policy"
+)
+
+// Flow is one logged flow
+type Flow struct {
+	Timestamp time.Time
+	SourceIP  string
+	DestIP    string
+	Port      int
+	Protocol  string
+	Action    string // ALLOWED or BLOCKED
+	Policy    string
+	Count     int       // Identical flows aggregated into this one (0 counts as 1)
+	LastSeen  time.Time // Last of the aggregated flows (zero means Timestamp)
+}
+
+// Endpoint is the workload behind an IP
+type Endpoint struct {
+	Name   string
+	Labels map[string]string
+}
+
+// Coverage tells whether an edge's flows are allowed by the policy set
+type Coverage string
+
+const (
+	Covered   Coverage = "covered"
+	Partial   Coverage = "partial" // Only some of the edge's flows are allowed
+	Uncovered Coverage = "uncovered"
+)
+
+// Node is a service, or a bare IP that no workload is known for
+type Node struct {
+	ID     string            `json:"id"`
+	Known  bool              `json:"known"`
+	Labels map[string]string `json:"labels,omitempty"` // Labels shared by all of the node's workloads
+	IPs    []string          `json:"ips"`
+}
+
+// Edge aggregates the flows from one node to another on a port
+type Edge struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Port      int       `json:"port"`
+	Protocol  string    `json:"protocol"`
+	Allowed   int       `json:"allowed"`
+	Blocked   int       `json:"blocked"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Policies  []string  `json:"policies,omitempty"` // Policies the flows were logged under
+	Coverage  Coverage  `json:"coverage,omitempty"` // Empty unless rules were set
+	covered   int
+}
+
+// Flows returns the number of flows on the edge
+func (e *Edge) Flows() int {
+	return e.Allowed + e.Blocked
+}
+
+// Graph is a communication graph
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// Builder accumulates flows into a graph
+type Builder struct {
+	lookup  func(ip string) (Endpoint, bool)
+	groupBy string
+	rules   *Rules
+
+	nodes    map[string]*Node
+	nodeIPs  map[string]map[string]bool
+	edges    map[edgeKey]*Edge
+	policies map[edgeKey]map[string]bool
+}
+
+type edgeKey struct {
+	from, to string
+	port     int
+	protocol string
+}
+
+// NewBuilder creates a builder that identifies IPs with lookup. Workloads
+// with the same value of the groupBy label (e.g. app) form one node;
+// workloads without it are a node of their own.
+func NewBuilder(lookup func(ip string) (Endpoint, bool), groupBy string) *Builder {
+	return &Builder{
+		lookup:   lookup,
+		groupBy:  groupBy,
+		nodes:    make(map[string]*Node),
+		nodeIPs:  make(map[string]map[string]bool),
+		edges:    make(map[edgeKey]*Edge),
+		policies: make(map[edgeKey]map[string]bool),
+	}
+}
+
+// SetRules annotates edges with whether rules allow their flows. Must be
+// called before Add.
+func (b *Builder) SetRules(rules *Rules) {
+	b.rules = rules
+}
+
+// Add adds a flow to the graph
+func (b *Builder) Add(f Flow) {
+	src, srcKnown := b.lookup(f.SourceIP)
+	from := b.node(f.SourceIP, src, srcKnown)
+	dst, dstKnown := b.lookup(f.DestIP)
+	to := b.node(f.DestIP, dst, dstKnown)
+
+	key := edgeKey{from: from, to: to, port: f.Port, protocol: f.Protocol}
+	e, ok := b.edges[key]
+	if !ok {
+		e = &Edge{From: from, To: to, Port: f.Port, Protocol: f.Protocol, FirstSeen: f.Timestamp}
+		b.edges[key] = e
+		b.policies[key] = make(map[string]bool)
+	}
+	count := max(1, f.Count)
+	if f.Action == "BLOCKED" {
+		e.Blocked += count
+	} else {
+		e.Allowed += count
+	}
+	lastSeen := f.Timestamp
+	if f.LastSeen.After(lastSeen) {
+		lastSeen = f.LastSeen
+	}
+	if f.Timestamp.Before(e.FirstSeen) {
+		e.FirstSeen = f.Timestamp
+	}
+	if lastSeen.After(e.LastSeen) {
+		e.LastSeen = lastSeen
+	}
+	if f.Policy != "" {
+		b.policies[key][f.Policy] = true
+	}
+	if b.rules != nil && b.rules.Allows(src.Labels, srcKnown, f.DestIP, f.Port, f.Protocol) {
+		e.covered += count
+	}
+}
+
+// node returns the ID of the node for ip, creating it on first sight
+func (b *Builder) node(ip string, ep Endpoint, known bool) string {
+	id := ip
+	if known {
+		id = ep.Name
+		if value, ok := ep.Labels[b.groupBy]; ok && b.groupBy != "" {
+			id = b.groupBy + "=" + value
+		}
+		if id == "" {
+			id = ip
+		}
+	}
+
+	n, ok := b.nodes[id]
+	if !ok {
+		n = &Node{ID: id, Known: known}
+		if known {
+			n.Labels = maps.Clone(ep.Labels)
+		}
+		b.nodes[id] = n
+		b.nodeIPs[id] = make(map[string]bool)
+	} else if known {
+		// Keep only the labels every workload of the node shares
+		for k, v := range n.Labels {
+			if ep.Labels[k] != v {
+				delete(n.Labels, k)
+			}
+		}
+	}
+	b.nodeIPs[id][ip] = true
+	return id
+}
+
+// Graph returns the graph built so far, sorted by ID and by flow count
+func (b *Builder) Graph() *Graph {
+	g := &Graph{}
+	for id, n := range b.nodes {
+		node := *n
+		for ip := range b.nodeIPs[id] {
+			node.IPs = append(node.IPs, ip)
+		}
+		sort.Strings(node.IPs)
+		g.Nodes = append(g.Nodes, node)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+
+	for key, e := range b.edges {
+		edge := *e
+		for name := range b.policies[key] {
+			edge.Policies = append(edge.Policies, name)
+		}
+		sort.Strings(edge.Policies)
+		if b.rules != nil {
+			switch edge.covered {
+			case edge.Flows():
+				edge.Coverage = Covered
+			case 0:
+				edge.Coverage = Uncovered
+			default:
+				edge.Coverage = Partial
+			}
+		}
+		g.Edges = append(g.Edges, edge)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.Flows() != b.Flows() {
+			return a.Flows() > b.Flows()
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Port < b.Port
+	})
+	return g
+}
+
+// Rules decides whether a flow is allowed by a policy set. A rule applies to
+// sources selected by its policy's podSelector; a source with unknown labels
+// may be selected by any policy.
+type Rules struct {
+	rules []rule
+}
+
+type rule struct {
+	selector map[string]string
+	network  *net.IPNet
+	port     int
+	lastPort int // Last port of the rule's range
+	protocol string
+}
+
+// NewRules pairs policies with their compiled rules by name
+func NewRules(policies []policy.NetworkPolicy, compiled []*policy.CompiledPolicy) (*Rules, error) {
+	selectors := make(map[string]map[string]string, len(policies))
+	for _, p := range policies {
+		selectors[p.Metadata.Name] = p.Spec.PodSelector.MatchLabels
+	}
+
+	r := &Rules{}
+	for _, c := range compiled {
+		for _, compiledRule := range c.Rules {
+			_, network, err := net.ParseCIDR(compiledRule.CIDR)
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: invalid CIDR %s: %w", c.Name, compiledRule.CIDR, err)
+			}
+			r.rules = append(r.rules, rule{
+				selector: selectors[c.Name],
+				network:  network,
+				port:     compiledRule.Port,
+				lastPort: compiledRule.LastPort(),
+				protocol: compiledRule.Protocol,
+			})
+		}
+	}
+	return r, nil
+}
+
+// Allows reports whether a rule allows a flow from a source with labels
+// (known is false if the source's labels are unknown) to destIP
+func (r *Rules) Allows(labels map[string]string, known bool, destIP string, port int, protocol string) bool {
+	ip := net.ParseIP(destIP)
+	if ip == nil {
+		return false
+	}
+	for _, rule := range r.rules {
+		if port < rule.port || port > rule.lastPort || !strings.EqualFold(rule.protocol, protocol) || !rule.network.Contains(ip) {
+			continue
+		}
+		if !known || selects(rule.selector, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+// selects reports whether a podSelector matches labels
+func selects(selector, labels map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/policy"
+)
+
+var epoch = time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+
+var endpoints = map[string]Endpoint{
+	"10.0.1.1": {Name: "web-1", Labels: map[string]string{"app": "web", "env": "prod"}},
+	"10.0.1.2": {Name: "web-2", Labels: map[string]string{"app": "web", "env": "dev"}},
+	"10.0.2.1": {Name: "db-1", Labels: map[string]string{"app": "db"}},
+	"10.0.3.1": {Name: "batch"},
+}
+
+func lookup(ip string) (Endpoint, bool) {
+	ep, ok := endpoints[ip]
+	return ep, ok
+}
+
+func flows() []Flow {
+	return []Flow{
+		{Timestamp: epoch, SourceIP: "10.0.1.1", DestIP: "10.0.2.1", Port: 5432, Protocol: "TCP", Action: "ALLOWED", Policy: "web-to-db"},
+		{Timestamp: epoch.Add(time.Minute), SourceIP: "10.0.1.2", DestIP: "10.0.2.1", Port: 5432, Protocol: "TCP", Action: "ALLOWED", Policy: "web-to-db"},
+		{Timestamp: epoch.Add(2 * time.Minute), SourceIP: "10.0.3.1", DestIP: "10.0.2.1", Port: 5432, Protocol: "TCP", Action: "BLOCKED", Policy: "default-deny"},
+		{Timestamp: epoch.Add(3 * time.Minute), SourceIP: "10.0.1.1", DestIP: "8.8.8.8", Port: 53, Protocol: "UDP", Action: "ALLOWED"},
+	}
+}
+
+func build(t *testing.T, rules *Rules) *Graph {
+	t.Helper()
+	b := NewBuilder(lookup, "app")
+	b.SetRules(rules)
+	for _, f := range flows() {
+		b.Add(f)
+	}
+	return b.Graph()
+}
+
+func TestBuilderGroupsByLabel(t *testing.T) {
+	g := build(t, nil)
+
+	ids := make([]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		ids[i] = n.ID
+	}
+	if got := strings.Join(ids, " "); got != "8.8.8.8 app=db app=web batch" {
+		t.Fatalf("Unexpected nodes: %s", got)
+	}
+	web := g.Nodes[2]
+	if len(web.IPs) != 2 || web.Labels["app"] != "web" || web.Labels["env"] != "" {
+		t.Errorf("Expected web node with both IPs and only shared labels, got %+v", web)
+	}
+	if g.Nodes[0].Known {
+		t.Error("Expected unknown IP node")
+	}
+
+	if len(g.Edges) != 3 {
+		t.Fatalf("Expected 3 edges, got %+v", g.Edges)
+	}
+	first := g.Edges[0]
+	if first.From != "app=web" || first.To != "app=db" || first.Allowed != 2 || first.Coverage != "" {
+		t.Errorf("Expected busiest edge web -> db without coverage, got %+v", first)
+	}
+	if !first.FirstSeen.Equal(epoch) || !first.LastSeen.Equal(epoch.Add(time.Minute)) {
+		t.Errorf("Unexpected first/last seen: %v, %v", first.FirstSeen, first.LastSeen)
+	}
+	if len(first.Policies) != 1 || first.Policies[0] != "web-to-db" {
+		t.Errorf("Expected web-to-db policy on edge, got %v", first.Policies)
+	}
+}
+
+func TestBuilderCoverage(t *testing.T) {
+	policies, err := policy.Parse([]byte(`
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: prod-web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+      env: prod
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.2.0/24
+      ports:
+        - protocol: TCP
+          port: 5432
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	compiled, err := policy.NewPolicyResolver(nil).Compile(policies[0])
+	if err != nil {
+		t.Fatalf("Failed to compile policy: %v", err)
+	}
+	rules, err := NewRules(policies, []*policy.CompiledPolicy{compiled})
+	if err != nil {
+		t.Fatalf("NewRules failed: %v", err)
+	}
+
+	coverage := map[string]Coverage{}
+	for _, e := range build(t, rules).Edges {
+		coverage[e.From+" -> "+e.To] = e.Coverage
+	}
+	want := map[string]Coverage{
+		"app=web -> app=db":  Partial, // Only the prod web workload is selected
+		"batch -> app=db":    Uncovered,
+		"app=web -> 8.8.8.8": Uncovered,
+	}
+	for edge, c := range want {
+		if coverage[edge] != c {
+			t.Errorf("%s: expected %s, got %s", edge, c, coverage[edge])
+		}
+	}
+
+	if !rules.Allows(nil, false, "10.0.2.9", 5432, "tcp") {
+		t.Error("Expected an unknown source to match any policy")
+	}
+}
+
+func TestWriteFormats(t *testing.T) {
+	g := build(t, nil)
+
+	var dot bytes.Buffer
+	if err := g.Write(&dot, "dot"); err != nil {
+		t.Fatalf("WriteDOT failed: %v", err)
+	}
+	if !strings.Contains(dot.String(), `"app=web" -> "app=db" [label="TCP/5432 (2)"];`) {
+		t.Errorf("Missing web -> db edge in DOT:\n%s", dot.String())
+	}
+	if !strings.Contains(dot.String(), `"batch" -> "app=db" [label="TCP/5432 (1)", style=dashed];`) {
+		t.Errorf("Expected blocked-only edge to be dashed:\n%s", dot.String())
+	}
+
+	var graphml bytes.Buffer
+	if err := g.Write(&graphml, "graphml"); err != nil {
+		t.Fatalf("WriteGraphML failed: %v", err)
+	}
+	var doc graphML
+	if err := xml.Unmarshal(graphml.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid GraphML: %v", err)
+	}
+	if len(doc.Graph.Nodes) != 4 || len(doc.Graph.Edges) != 3 {
+		t.Errorf("Expected 4 nodes and 3 edges in GraphML, got %d and %d", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+
+	var out bytes.Buffer
+	if err := g.Write(&out, "json"); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var decoded Graph
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || len(decoded.Edges) != 3 {
+		t.Errorf("Expected JSON round trip, got %+v, %v", decoded, err)
+	}
+
+	if err := g.Write(&out, "svg"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
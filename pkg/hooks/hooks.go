@@ -0,0 +1,275 @@
+// Package hooks runs site-specific integrations at points of the policy apply
+// lifecycle: pre-compile hooks mutate the policy set, an enforcer hook
+// replaces the built-in backend, and post-apply hooks are notified of each
+// enforcement. A hook is either a command, which reads its input on stdin
+// and writes its output to stdout, or a URL, to which the input is POSTed
+// and which responds with the output.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/configfile"
+	"ztap/pkg/policy"
+)
+
+// Backend is the backend name reported for enforcement by an enforcer hook
+const Backend = "hook"
+
+// DefaultTimeout bounds a hook run when the config sets no timeout
+const DefaultTimeout = 10 * time.Second
+
+// Lifecycle points, passed to commands as $ZTAP_HOOK and to URLs as the
+// X-ZTAP-Hook header
+const (
+	PreCompile = "pre-compile"
+	PostApply  = "post-apply"
+	Enforcer   = "enforcer"
+)
+
+// Hook is a command or URL to run
+type Hook struct {
+	Name    string   `yaml:"name"`
+	Command []string `yaml:"command"` // Program and arguments, run without a shell
+	URL     string   `yaml:"url"`
+}
+
+func (h Hook) String() string {
+	if h.Name != "" {
+		return h.Name
+	}
+	if len(h.Command) > 0 {
+		return h.Command[0]
+	}
+	return h.URL
+}
+
+// Config is the hooks section of config.yaml
+type Config struct {
+	Timeout    time.Duration `yaml:"timeout"`
+	PreCompile []Hook        `yaml:"preCompile"` // Policies YAML in, policies YAML out, run in order
+	PostApply  []Hook        `yaml:"postApply"`  // Result JSON in, output ignored
+	Enforcer   *Hook         `yaml:"enforcer"`   // Compiled policies JSON in; replaces the built-in backend
+}
+
+// Validate checks that every hook has exactly one of command and url
+func (c Config) Validate() error {
+	check := func(field string, h Hook) error {
+		if (len(h.Command) == 0) == (h.URL == "") {
+			return fmt.Errorf("%s: exactly one of command and url is required", field)
+		}
+		if h.URL != "" && !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+			return fmt.Errorf("%s: url must be http or https", field)
+		}
+		return nil
+	}
+	for i, h := range c.PreCompile {
+		if err := check(fmt.Sprintf("hooks.preCompile[%d]", i), h); err != nil {
+			return err
+		}
+	}
+	for i, h := range c.PostApply {
+		if err := check(fmt.Sprintf("hooks.postApply[%d]", i), h); err != nil {
+			return err
+		}
+	}
+	if c.Enforcer != nil {
+		if err := check("hooks.enforcer", *c.Enforcer); err != nil {
+			return err
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("hooks.timeout must not be negative")
+	}
+	return nil
+}
+
+// LoadConfig reads the hooks section of file. A missing file or section
+// configures no hooks.
+func LoadConfig(file *configfile.File) (Config, error) {
+	var c Config
+	if err := file.Section("hooks", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Runner runs the configured hooks
+type Runner struct {
+	config Config
+	client *http.Client
+}
+
+// New creates a runner for config
+func New(config Config) *Runner {
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	return &Runner{config: config, client: &http.Client{}}
+}
+
+// Mutates reports whether pre-compile hooks are configured
+func (r *Runner) Mutates() bool {
+	return len(r.config.PreCompile) > 0
+}
+
+// Mutate passes policies through the pre-compile hooks in order. Each hook
+// receives the previous one's output as multi-document YAML and must write
+// the policy set to compile, which is validated before it is used.
+func (r *Runner) Mutate(ctx context.Context, policies []policy.NetworkPolicy) ([]policy.NetworkPolicy, error) {
+	for _, h := range r.config.PreCompile {
+		input, err := policy.Marshal(policies)
+		if err != nil {
+			return nil, err
+		}
+		output, err := r.run(ctx, h, PreCompile, "application/yaml", input)
+		if err != nil {
+			return nil, err
+		}
+		if policies, err = policy.Parse(output); err != nil {
+			return nil, fmt.Errorf("%s hook %s: invalid policy YAML: %w", PreCompile, h, err)
+		}
+		for i := range policies {
+			if err := policies[i].Validate(); err != nil {
+				return nil, fmt.Errorf("%s hook %s: %w", PreCompile, h, err)
+			}
+		}
+	}
+	return policies, nil
+}
+
+// HasEnforcer reports whether an enforcer hook replaces the built-in backend
+func (r *Runner) HasEnforcer() bool {
+	return r.config.Enforcer != nil
+}
+
+// Enforcement is the input of the enforcer hook
+type Enforcement struct {
+	Principal string                   `json:"principal,omitempty"`
+	Policies  []*policy.CompiledPolicy `json:"policies"`
+}
+
+// Enforce applies compiled policies with the enforcer hook. Like the
+// built-in backends, it replaces whatever was applied before; an empty set
+// removes every rule.
+func (r *Runner) Enforce(ctx context.Context, compiled []*policy.CompiledPolicy) error {
+	if r.config.Enforcer == nil {
+		return fmt.Errorf("no enforcer hook configured")
+	}
+	input, err := json.Marshal(Enforcement{
+		Principal: auth.PrincipalFromContext(ctx),
+		Policies:  nonNil(compiled),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.run(ctx, *r.config.Enforcer, Enforcer, "application/json", input)
+	return err
+}
+
+// Result is the input of post-apply hooks
+type Result struct {
+	Backend   string          `json:"backend"`
+	Principal string          `json:"principal,omitempty"`
+	Error     string          `json:"error,omitempty"` // Empty on success
+	Policies  []AppliedPolicy `json:"policies"`
+}
+
+// AppliedPolicy summarizes a policy in a Result
+type AppliedPolicy struct {
+	Name  string `json:"name"`
+	Hash  string `json:"hash"`
+	Rules int    `json:"rules"`
+}
+
+// Notify runs the post-apply hooks with the result of an enforcement.
+// Failures are logged and never fail the enforcement.
+func (r *Runner) Notify(ctx context.Context, backend string, compiled []*policy.CompiledPolicy, applyErr error) {
+	if len(r.config.PostApply) == 0 {
+		return
+	}
+	result := Result{Backend: backend, Principal: auth.PrincipalFromContext(ctx), Policies: []AppliedPolicy{}}
+	if applyErr != nil {
+		result.Error = applyErr.Error()
+	}
+	for _, c := range compiled {
+		result.Policies = append(result.Policies, AppliedPolicy{Name: c.Name, Hash: c.Hash, Rules: len(c.Rules)})
+	}
+	input, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	for _, h := range r.config.PostApply {
+		if _, err := r.run(ctx, h, PostApply, "application/json", input); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+}
+
+// run runs one hook with input and returns its output
+func (r *Runner) run(ctx context.Context, h Hook, point, contentType string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	if h.URL != "" {
+		return r.post(ctx, h, point, contentType, input)
+	}
+
+	cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+	cmd.Env = append(os.Environ(), "ZTAP_HOOK="+point)
+	cmd.WaitDelay = time.Second // Do not wait on children holding stdout after a timeout
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s hook %s failed: %w: %s", point, h, err, msg)
+		}
+		return nil, fmt.Errorf("%s hook %s failed: %w", point, h, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (r *Runner) post(ctx context.Context, h Hook, point, contentType string, input []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(input))
+	if err != nil {
+		return nil, fmt.Errorf("%s hook %s: %w", point, h, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-ZTAP-Hook", point)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s hook %s failed: %w", point, h, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s hook %s failed: %w", point, h, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s hook %s failed: %s: %s", point, h, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// nonNil returns compiled, or an empty slice so the hook sees [] and not null
+func nonNil(compiled []*policy.CompiledPolicy) []*policy.CompiledPolicy {
+	if compiled == nil {
+		return []*policy.CompiledPolicy{}
+	}
+	return compiled
+}
@@ -0,0 +1,163 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/configfile"
+	"ztap/pkg/policy"
+)
+
+const testPolicy = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.2.0/24
+      ports:
+        - protocol: TCP
+          port: 5432
+`
+
+func parse(t *testing.T) []policy.NetworkPolicy {
+	t.Helper()
+	policies, err := policy.Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	return policies
+}
+
+func sh(script string) Hook {
+	return Hook{Command: []string{"sh", "-c", script}}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+hooks:
+  timeout: 5s
+  preCompile:
+    - name: team-labels
+      command: ["/usr/local/bin/team-labels"]
+  postApply:
+    - url: https://hooks.example.com/ztap
+`), 0600)
+
+	config, err := configfile.Read(path, LoadConfig)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Timeout != 5*time.Second || len(config.PreCompile) != 1 || config.PreCompile[0].String() != "team-labels" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	if config, err := configfile.Read(filepath.Join(t.TempDir(), "missing.yaml"), LoadConfig); err != nil || New(config).Mutates() {
+		t.Errorf("Expected no hooks without a config file, got %+v, %v", config, err)
+	}
+
+	invalid := []Config{
+		{PreCompile: []Hook{{}}},
+		{PostApply: []Hook{{Command: []string{"true"}, URL: "https://example.com"}}},
+		{Enforcer: &Hook{URL: "ftp://example.com"}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", c)
+		}
+	}
+}
+
+func TestMutate(t *testing.T) {
+	runner := New(Config{PreCompile: []Hook{
+		sh(`test "$ZTAP_HOOK" = pre-compile && sed 's/port: 5432/port: 6432/'`),
+		sh(`in=$(cat); printf '%s\n---\n' "$in"; printf '%s\n' "$in" | sed 's/name: web-to-db/name: web-to-db-copy/'`),
+	}})
+
+	policies, err := runner.Mutate(context.Background(), parse(t))
+	if err != nil {
+		t.Fatalf("Mutate failed: %v", err)
+	}
+	if len(policies) != 2 || policies[1].Metadata.Name != "web-to-db-copy" {
+		t.Fatalf("Expected hooks to run in order, got %+v", policies)
+	}
+	if port := policies[0].Spec.Egress[0].Ports[0].Port; port != 6432 {
+		t.Errorf("Expected mutated port 6432, got %d", port)
+	}
+}
+
+func TestMutateRejectsInvalidOutput(t *testing.T) {
+	tests := map[string]Hook{
+		"failing": sh(`echo denied >&2; exit 3`),
+		"invalid": sh(`sed 's/kind: NetworkPolicy/kind: Other/'`),
+		"slow":    sh(`sleep 5`),
+	}
+	for name, h := range tests {
+		runner := New(Config{Timeout: 200 * time.Millisecond, PreCompile: []Hook{h}})
+		_, err := runner.Mutate(context.Background(), parse(t))
+		if err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+		if name == "failing" && !strings.Contains(err.Error(), "denied") {
+			t.Errorf("Expected stderr in error, got %v", err)
+		}
+	}
+}
+
+func TestEnforceAndNotify(t *testing.T) {
+	dir := t.TempDir()
+	var received Result
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-ZTAP-Hook") != PostApply {
+			http.Error(w, "wrong hook", http.StatusBadRequest)
+			return
+		}
+		json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+
+	runner := New(Config{
+		Enforcer:  &Hook{Command: []string{"sh", "-c", `cat > "$0"`, filepath.Join(dir, "enforced.json")}},
+		PostApply: []Hook{{URL: server.URL}, sh(`exit 1`)},
+	})
+	if !runner.HasEnforcer() {
+		t.Fatal("Expected enforcer hook")
+	}
+
+	compiled, err := policy.NewPolicyResolver(nil).Compile(parse(t)[0])
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	ctx := auth.WithPrincipal(context.Background(), "alice")
+	if err := runner.Enforce(ctx, []*policy.CompiledPolicy{compiled}); err != nil {
+		t.Fatalf("Enforce failed: %v", err)
+	}
+	var enforced Enforcement
+	data, _ := os.ReadFile(filepath.Join(dir, "enforced.json"))
+	if err := json.Unmarshal(data, &enforced); err != nil || enforced.Principal != "alice" || len(enforced.Policies) != 1 {
+		t.Errorf("Unexpected enforcer input %s: %v", data, err)
+	}
+
+	// A failing post-apply hook is only logged
+	runner.Notify(ctx, Backend, []*policy.CompiledPolicy{compiled}, nil)
+	if received.Backend != Backend || received.Principal != "alice" || len(received.Policies) != 1 || received.Policies[0].Rules != 1 {
+		t.Errorf("Unexpected post-apply input %+v", received)
+	}
+}
@@ -0,0 +1,211 @@
+// Package loadtest drives synthetic flows through a flow-processing pipeline
+// at a target rate and reports throughput and latency percentiles, so the
+// agent's flow path can be sized without production traffic.
+package loadtest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"ztap/pkg/anomaly"
+)
+
+// Flow is one synthetic flow and the verdict enforcement gave it
+type Flow struct {
+	anomaly.FlowRecord
+	Policy  string
+	Allowed bool
+}
+
+// Config controls a load test run
+type Config struct {
+	Flows        int     // Total flows to generate
+	Rate         float64 // Flows per second; 0 sends as fast as the workers allow
+	Workers      int     // Concurrent pipeline workers (default: GOMAXPROCS)
+	BlockedRatio float64 // Fraction of flows that were blocked
+	Policies     int     // Distinct policy names flows are attributed to (default: 10)
+	Seed         int64   // Generator seed, so runs are reproducible
+}
+
+// Validate checks the configuration
+func (c Config) Validate() error {
+	if c.Flows <= 0 {
+		return fmt.Errorf("flows must be positive, got %d", c.Flows)
+	}
+	if c.Rate < 0 {
+		return fmt.Errorf("rate must not be negative, got %v", c.Rate)
+	}
+	if c.Workers < 0 {
+		return fmt.Errorf("workers must not be negative, got %d", c.Workers)
+	}
+	if c.BlockedRatio < 0 || c.BlockedRatio > 1 {
+		return fmt.Errorf("blocked ratio must be between 0 and 1, got %v", c.BlockedRatio)
+	}
+	return nil
+}
+
+// Generator produces synthetic flows with a realistic mix of ports, a few
+// suspicious ones, and an occasional large transfer
+type Generator struct {
+	rand     *rand.Rand
+	blocked  float64
+	policies int
+	now      func() time.Time
+}
+
+// commonPorts weights generated destination ports towards typical services
+var commonPorts = []int{443, 443, 443, 80, 80, 53, 5432, 6379, 8080, 9090, 22, 3389}
+
+// NewGenerator creates a generator for c
+func NewGenerator(c Config) *Generator {
+	policies := c.Policies
+	if policies <= 0 {
+		policies = 10
+	}
+	return &Generator{
+		rand:     rand.New(rand.NewSource(c.Seed)),
+		blocked:  c.BlockedRatio,
+		policies: policies,
+		now:      time.Now,
+	}
+}
+
+// Next returns the next synthetic flow
+func (g *Generator) Next() Flow {
+	port := commonPorts[g.rand.Intn(len(commonPorts))]
+	protocol := "TCP"
+	if port == 53 {
+		protocol = "UDP"
+	}
+	bytes := g.rand.Int63n(64 << 10)
+	if g.rand.Intn(1000) == 0 {
+		bytes = 200 << 20 // Large transfer
+	}
+
+	return Flow{
+		FlowRecord: anomaly.FlowRecord{
+			SourceIP:  fmt.Sprintf("10.0.%d.%d", g.rand.Intn(16), 1+g.rand.Intn(254)),
+			DestIP:    fmt.Sprintf("10.1.%d.%d", g.rand.Intn(64), 1+g.rand.Intn(254)),
+			Port:      port,
+			Protocol:  protocol,
+			Bytes:     bytes,
+			Timestamp: g.now(),
+		},
+		Policy:  fmt.Sprintf("loadtest-%d", g.rand.Intn(g.policies)),
+		Allowed: g.rand.Float64() >= g.blocked,
+	}
+}
+
+// Report summarizes a run
+type Report struct {
+	Flows    int           // Flows processed
+	Errors   int           // Flows the pipeline returned an error for
+	Duration time.Duration // Wall time of the run
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+// Throughput returns the achieved flows per second
+func (r *Report) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Flows) / r.Duration.Seconds()
+}
+
+// Run sends c.Flows synthetic flows through handle at c.Rate and measures
+// how long handle takes per flow. It stops early when ctx is cancelled and
+// reports what was processed until then.
+func Run(ctx context.Context, c Config, handle func(Flow) error) (*Report, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	workers := c.Workers
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	gen := NewGenerator(c)
+	flows := make(chan Flow, workers*4)
+	latencies := make([][]time.Duration, workers)
+	errs := make([]int, workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for flow := range flows {
+				start := time.Now()
+				if err := handle(flow); err != nil {
+					errs[w]++
+				}
+				latencies[w] = append(latencies[w], time.Since(start))
+			}
+		}(w)
+	}
+
+	start := time.Now()
+	send(ctx, c, gen, flows, start)
+	close(flows)
+	wg.Wait()
+
+	report := &Report{Duration: time.Since(start)}
+	var all []time.Duration
+	for w := range latencies {
+		all = append(all, latencies[w]...)
+		report.Errors += errs[w]
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	report.Flows = len(all)
+	report.P50, report.P90, report.P99 = percentile(all, 0.50), percentile(all, 0.90), percentile(all, 0.99)
+	if len(all) > 0 {
+		report.Max = all[len(all)-1]
+	}
+	return report, ctx.Err()
+}
+
+// send generates flows onto out, paced to c.Rate from start
+func send(ctx context.Context, c Config, gen *Generator, out chan<- Flow, start time.Time) {
+	for i := 0; i < c.Flows; i++ {
+		if c.Rate > 0 {
+			due := start.Add(time.Duration(float64(i) / c.Rate * float64(time.Second)))
+			if wait := time.Until(due); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+			}
+		}
+		select {
+		case out <- gen.Next():
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// percentile returns the p-th percentile of sorted latencies
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(latencies))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(latencies) {
+		i = len(latencies) - 1
+	}
+	return latencies[i]
+}
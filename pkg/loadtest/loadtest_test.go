@@ -0,0 +1,121 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	if err := (Config{Flows: 10}).Validate(); err != nil {
+		t.Errorf("Expected valid config: %v", err)
+	}
+	for _, c := range []Config{
+		{},
+		{Flows: 10, Rate: -1},
+		{Flows: 10, Workers: -1},
+		{Flows: 10, BlockedRatio: 1.5},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Expected error for %+v", c)
+		}
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	a := NewGenerator(Config{Seed: 42, BlockedRatio: 0.25, Policies: 3})
+	b := NewGenerator(Config{Seed: 42, BlockedRatio: 0.25, Policies: 3})
+
+	blocked := 0
+	policies := make(map[string]bool)
+	for i := 0; i < 2000; i++ {
+		fa, fb := a.Next(), b.Next()
+		if fa.DestIP != fb.DestIP || fa.Port != fb.Port || fa.Allowed != fb.Allowed {
+			t.Fatalf("Expected the same seed to generate the same flows, got %+v and %+v", fa, fb)
+		}
+		if fa.SourceIP == "" || fa.Protocol == "" {
+			t.Fatalf("Incomplete flow %+v", fa)
+		}
+		if !fa.Allowed {
+			blocked++
+		}
+		policies[fa.Policy] = true
+	}
+	if blocked < 400 || blocked > 600 {
+		t.Errorf("Expected about 25%% blocked flows, got %d of 2000", blocked)
+	}
+	if len(policies) != 3 {
+		t.Errorf("Expected 3 policies, got %v", policies)
+	}
+}
+
+func TestRun(t *testing.T) {
+	var handled atomic.Int64
+	report, err := Run(context.Background(), Config{Flows: 1000, Workers: 4, BlockedRatio: 0.1}, func(f Flow) error {
+		if handled.Add(1)%100 == 0 {
+			return errors.New("pipeline failure")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Flows != 1000 || handled.Load() != 1000 {
+		t.Errorf("Expected 1000 flows, got %d (handled %d)", report.Flows, handled.Load())
+	}
+	if report.Errors != 10 {
+		t.Errorf("Expected 10 errors, got %d", report.Errors)
+	}
+	if report.P50 > report.P90 || report.P90 > report.P99 || report.P99 > report.Max {
+		t.Errorf("Percentiles out of order: %+v", report)
+	}
+	if report.Throughput() <= 0 {
+		t.Errorf("Expected positive throughput, got %v", report.Throughput())
+	}
+}
+
+func TestRunRate(t *testing.T) {
+	report, err := Run(context.Background(), Config{Flows: 50, Rate: 500}, func(Flow) error { return nil })
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	// 50 flows at 500/s take at least 98ms
+	if report.Duration < 90*time.Millisecond {
+		t.Errorf("Expected the rate to be enforced, run took %v", report.Duration)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var handled atomic.Int64
+	report, err := Run(ctx, Config{Flows: 1000000, Rate: 1000}, func(Flow) error {
+		if handled.Add(1) == 10 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if report == nil || report.Flows < 10 || report.Flows > 1000 {
+		t.Errorf("Expected a partial report, got %+v", report)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	if p := percentile(latencies, 0.5); p != 50*time.Millisecond {
+		t.Errorf("Expected p50 50ms, got %v", p)
+	}
+	if p := percentile(latencies, 0.99); p != 99*time.Millisecond {
+		t.Errorf("Expected p99 99ms, got %v", p)
+	}
+	if p := percentile(nil, 0.5); p != 0 {
+		t.Errorf("Expected 0 for no samples, got %v", p)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -16,6 +17,30 @@ type Collector struct {
 	flowsBlocked     prometheus.Counter
 	anomalyScore     prometheus.Gauge
 	policyLoadTime   prometheus.Histogram
+	policyCacheHits  prometheus.Counter
+	policyCacheMiss  prometheus.Counter
+	ebpfMapEntries   prometheus.Gauge
+	ebpfMapCapacity  prometheus.Gauge
+	watchDropped     *prometheus.CounterVec
+	shrinkHeld       *prometheus.CounterVec
+	policyExpired    *prometheus.CounterVec
+	expiredHits      *prometheus.CounterVec
+	approvals        *prometheus.CounterVec
+	breakGlass       prometheus.Gauge
+	policyLastGood   prometheus.Gauge
+	policyStale      prometheus.Gauge
+	flowsDropped     prometheus.Counter
+	flowsSampled     prometheus.Counter
+	flowQueueDepth   prometheus.Gauge
+	enforcementsBy   *prometheus.CounterVec
+	apiRequests      *prometheus.CounterVec
+	applyDuration    *prometheus.HistogramVec
+	pfReloadDuration prometheus.Histogram
+	ebpfProgRunTime  prometheus.Gauge
+	ebpfProgRuns     prometheus.Gauge
+	ebpfFilterErrors *prometheus.GaugeVec
+	retentionPruned  *prometheus.CounterVec
+	retentionBytes   *prometheus.CounterVec
 	mu               sync.Mutex
 }
 
@@ -49,6 +74,104 @@ func GetCollector() *Collector {
 				Help:    "Time taken to load policies",
 				Buckets: prometheus.DefBuckets,
 			}),
+			policyCacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+				Name: "ztap_policy_cache_hits_total",
+				Help: "Total number of policy compilations served from cache",
+			}),
+			policyCacheMiss: prometheus.NewCounter(prometheus.CounterOpts{
+				Name: "ztap_policy_cache_misses_total",
+				Help: "Total number of policy compilations that missed the cache",
+			}),
+			ebpfMapEntries: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "ztap_ebpf_map_entries",
+				Help: "Number of entries in the eBPF policy map",
+			}),
+			ebpfMapCapacity: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "ztap_ebpf_map_capacity",
+				Help: "Maximum number of entries the eBPF policy map can hold",
+			}),
+			watchDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "ztap_watch_notifications_dropped_total",
+				Help: "Watch notifications superseded before a slow consumer received them",
+			}, []string{"source"}),
+			shrinkHeld: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "ztap_endpoint_shrink_held_total",
+				Help: "Times a policy's rules were held back because its resolved endpoints shrank past the safety threshold",
+			}, []string{"policy"}),
+			policyExpired: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "ztap_policy_expirations_total",
+				Help: "Temporary policies removed because their expiresAt or ttl passed",
+			}, []string{"policy"}),
+			expiredHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "ztap_expired_policy_hits_total",
+				Help: "Blocked flows an expired temporary policy would still have allowed",
+			}, []string{"policy"}),
+			approvals: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "ztap_policy_approvals_total",
+				Help: "High-risk policy changes held for approval, approved and rejected",
+			}, []string{"action"}),
+			breakGlass: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "ztap_break_glass_active",
+				Help: "1 while break-glass suspends enforcement on this node",
+			}),
+			policyLastGood: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "ztap_policy_last_good_timestamp_seconds",
+				Help: "Unix time the enforced rule set was last compiled and enforced in full",
+			}),
+			policyStale: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "ztap_policy_stale",
+				Help: "1 while the agent enforces a rule set older than its staleness threshold",
+			}),
+			flowsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+				Name: "ztap_flow_events_dropped_total",
+				Help: "Flow events dropped because the agent's flow queue was full",
+			}),
+			flowsSampled: prometheus.NewCounter(prometheus.CounterOpts{
+				Name: "ztap_flow_events_sampled_total",
+				Help: "Flow events skipped by sampling identical verdicts while the agent's flow queue was overloaded",
+			}),
+			flowQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "ztap_flow_queue_depth",
+				Help: "Flow events waiting in the agent's flow queue",
+			}),
+			enforcementsBy: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "ztap_enforcements_by_principal_total",
+				Help: "Policies enforced, by the principal that initiated the enforcement and its result",
+			}, []string{"principal", "result"}),
+			apiRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "ztap_api_requests_total",
+				Help: "Authenticated API requests, by user",
+			}, []string{"user"}),
+			applyDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "ztap_policy_apply_duration_seconds",
+				Help:    "Time the enforcement backend took to apply a compiled policy set",
+				Buckets: prometheus.DefBuckets,
+			}, []string{"backend"}),
+			pfReloadDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+				Name:    "ztap_pf_reload_duration_seconds",
+				Help:    "Time taken to write and load the pf anchor",
+				Buckets: prometheus.DefBuckets,
+			}),
+			ebpfProgRunTime: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "ztap_ebpf_program_run_time_seconds",
+				Help: "Cumulative time the kernel spent running the eBPF filter program (requires --ebpf-stats)",
+			}),
+			ebpfProgRuns: prometheus.NewGauge(prometheus.GaugeOpts{
+				Name: "ztap_ebpf_program_run_count",
+				Help: "Cumulative number of eBPF filter program runs, i.e. packets filtered (requires --ebpf-stats)",
+			}),
+			ebpfFilterErrors: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "ztap_ebpf_filter_errors",
+				Help: "Cumulative data-plane errors counted by the eBPF filter program, by kind (not_ipv4, malformed, truncated, policy_miss, event_dropped)",
+			}, []string{"kind"}),
+			retentionPruned: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "ztap_retention_pruned_records_total",
+				Help: "Log entries, journal records and stats buckets removed by retention, by store",
+			}, []string{"store"}),
+			retentionBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "ztap_retention_reclaimed_bytes_total",
+				Help: "Disk space reclaimed by retention, by store",
+			}, []string{"store"}),
 		}
 
 		// Register metrics with Prometheus
@@ -57,6 +180,30 @@ func GetCollector() *Collector {
 		prometheus.MustRegister(globalCollector.flowsBlocked)
 		prometheus.MustRegister(globalCollector.anomalyScore)
 		prometheus.MustRegister(globalCollector.policyLoadTime)
+		prometheus.MustRegister(globalCollector.policyCacheHits)
+		prometheus.MustRegister(globalCollector.policyCacheMiss)
+		prometheus.MustRegister(globalCollector.ebpfMapEntries)
+		prometheus.MustRegister(globalCollector.ebpfMapCapacity)
+		prometheus.MustRegister(globalCollector.watchDropped)
+		prometheus.MustRegister(globalCollector.shrinkHeld)
+		prometheus.MustRegister(globalCollector.policyExpired)
+		prometheus.MustRegister(globalCollector.expiredHits)
+		prometheus.MustRegister(globalCollector.approvals)
+		prometheus.MustRegister(globalCollector.breakGlass)
+		prometheus.MustRegister(globalCollector.policyLastGood)
+		prometheus.MustRegister(globalCollector.policyStale)
+		prometheus.MustRegister(globalCollector.flowsDropped)
+		prometheus.MustRegister(globalCollector.flowsSampled)
+		prometheus.MustRegister(globalCollector.flowQueueDepth)
+		prometheus.MustRegister(globalCollector.enforcementsBy)
+		prometheus.MustRegister(globalCollector.apiRequests)
+		prometheus.MustRegister(globalCollector.applyDuration)
+		prometheus.MustRegister(globalCollector.pfReloadDuration)
+		prometheus.MustRegister(globalCollector.ebpfProgRunTime)
+		prometheus.MustRegister(globalCollector.ebpfProgRuns)
+		prometheus.MustRegister(globalCollector.ebpfFilterErrors)
+		prometheus.MustRegister(globalCollector.retentionPruned)
+		prometheus.MustRegister(globalCollector.retentionBytes)
 	})
 
 	return globalCollector
@@ -97,6 +244,174 @@ func (c *Collector) ObservePolicyLoadTime(seconds float64) {
 	c.policyLoadTime.Observe(seconds)
 }
 
+// ObservePolicyCache records a policy compile cache hit or miss
+func (c *Collector) ObservePolicyCache(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.policyCacheHits.Inc()
+	} else {
+		c.policyCacheMiss.Inc()
+	}
+}
+
+// SetEBPFMapUsage records the eBPF policy map fill level
+func (c *Collector) SetEBPFMapUsage(entries, capacity int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ebpfMapEntries.Set(float64(entries))
+	c.ebpfMapCapacity.Set(float64(capacity))
+}
+
+// ObservePolicyApply records how long backend took to apply a policy set
+func (c *Collector) ObservePolicyApply(backend string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.applyDuration.WithLabelValues(backend).Observe(d.Seconds())
+}
+
+// ObservePFReload records how long writing and loading the pf anchor took
+func (c *Collector) ObservePFReload(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pfReloadDuration.Observe(d.Seconds())
+}
+
+// SetEBPFProgramStats records the kernel's cumulative run time and run count
+// of the eBPF filter program. Divide their rates for the per-packet overhead.
+func (c *Collector) SetEBPFProgramStats(runTime time.Duration, runs uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ebpfProgRunTime.Set(runTime.Seconds())
+	c.ebpfProgRuns.Set(float64(runs))
+}
+
+// SetEBPFFilterErrors records the eBPF filter program's cumulative count of
+// errors of kind
+func (c *Collector) SetEBPFFilterErrors(kind string, count uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ebpfFilterErrors.WithLabelValues(kind).Set(float64(count))
+}
+
+// IncWatchDropped counts a watch notification dropped for a slow consumer
+func (c *Collector) IncWatchDropped(source string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.watchDropped.WithLabelValues(source).Inc()
+}
+
+// IncShrinkHeld counts a policy held back by the endpoint shrink check
+func (c *Collector) IncShrinkHeld(policy string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shrinkHeld.WithLabelValues(policy).Inc()
+}
+
+// IncPolicyExpired counts a temporary policy removed at expiry
+func (c *Collector) IncPolicyExpired(policy string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policyExpired.WithLabelValues(policy).Inc()
+}
+
+// IncExpiredPolicyHit counts a blocked flow an expired policy would have
+// allowed
+func (c *Collector) IncExpiredPolicyHit(policy string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expiredHits.WithLabelValues(policy).Inc()
+}
+
+// IncPolicyApproval counts a step of the policy approval workflow
+func (c *Collector) IncPolicyApproval(action string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.approvals.WithLabelValues(action).Inc()
+}
+
+// SetBreakGlass records whether break-glass suspends enforcement
+func (c *Collector) SetBreakGlass(active bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if active {
+		c.breakGlass.Set(1)
+	} else {
+		c.breakGlass.Set(0)
+	}
+}
+
+// SetPolicyLastGood records when the enforced rule set was last compiled and
+// enforced in full
+func (c *Collector) SetPolicyLastGood(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policyLastGood.Set(float64(t.Unix()))
+}
+
+// SetPolicyStale records whether the enforced rule set is stale
+func (c *Collector) SetPolicyStale(stale bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stale {
+		c.policyStale.Set(1)
+	} else {
+		c.policyStale.Set(0)
+	}
+}
+
+// IncFlowsDropped counts a flow event dropped because the flow queue was full
+func (c *Collector) IncFlowsDropped() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flowsDropped.Inc()
+}
+
+// IncFlowsSampled counts a flow event skipped by overload sampling
+func (c *Collector) IncFlowsSampled() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flowsSampled.Inc()
+}
+
+// SetFlowQueueDepth records the number of flow events waiting in the flow
+// queue
+func (c *Collector) SetFlowQueueDepth(depth int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flowQueueDepth.Set(float64(depth))
+}
+
+// IncEnforcementBy counts a policy enforced on behalf of principal. Enforcement
+// without a known principal is counted as "unknown".
+func (c *Collector) IncEnforcementBy(principal string, success bool) {
+	if principal == "" {
+		principal = "unknown"
+	}
+	result := "success"
+	if !success {
+		result = "error"
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.enforcementsBy.WithLabelValues(principal, result).Inc()
+}
+
+// IncAPIRequest counts an authenticated API request by user
+func (c *Collector) IncAPIRequest(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apiRequests.WithLabelValues(user).Inc()
+}
+
+// ObserveRetention counts the records and bytes retention removed from store
+func (c *Collector) ObserveRetention(store string, records int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retentionPruned.WithLabelValues(store).Add(float64(records))
+	c.retentionBytes.WithLabelValues(store).Add(float64(bytes))
+}
+
 // StartServer starts the Prometheus metrics HTTP server
 func StartServer(port int) error {
 	http.Handle("/metrics", promhttp.Handler())
@@ -3,6 +3,7 @@ package metrics
 import (
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
@@ -18,6 +19,30 @@ func resetCollector(t *testing.T) {
 		prometheus.Unregister(globalCollector.flowsBlocked)
 		prometheus.Unregister(globalCollector.anomalyScore)
 		prometheus.Unregister(globalCollector.policyLoadTime)
+		prometheus.Unregister(globalCollector.policyCacheHits)
+		prometheus.Unregister(globalCollector.policyCacheMiss)
+		prometheus.Unregister(globalCollector.ebpfMapEntries)
+		prometheus.Unregister(globalCollector.ebpfMapCapacity)
+		prometheus.Unregister(globalCollector.watchDropped)
+		prometheus.Unregister(globalCollector.shrinkHeld)
+		prometheus.Unregister(globalCollector.policyExpired)
+		prometheus.Unregister(globalCollector.expiredHits)
+		prometheus.Unregister(globalCollector.approvals)
+		prometheus.Unregister(globalCollector.breakGlass)
+		prometheus.Unregister(globalCollector.policyLastGood)
+		prometheus.Unregister(globalCollector.policyStale)
+		prometheus.Unregister(globalCollector.flowsDropped)
+		prometheus.Unregister(globalCollector.flowsSampled)
+		prometheus.Unregister(globalCollector.flowQueueDepth)
+		prometheus.Unregister(globalCollector.enforcementsBy)
+		prometheus.Unregister(globalCollector.apiRequests)
+		prometheus.Unregister(globalCollector.applyDuration)
+		prometheus.Unregister(globalCollector.pfReloadDuration)
+		prometheus.Unregister(globalCollector.ebpfProgRunTime)
+		prometheus.Unregister(globalCollector.ebpfProgRuns)
+		prometheus.Unregister(globalCollector.ebpfFilterErrors)
+		prometheus.Unregister(globalCollector.retentionPruned)
+		prometheus.Unregister(globalCollector.retentionBytes)
 	}
 	globalCollector = nil
 	once = sync.Once{}
@@ -87,3 +112,144 @@ func TestCollectorGaugeAndHistogram(t *testing.T) {
 		t.Fatalf("expected histogram to collect once, got %d", count)
 	}
 }
+
+func TestCollectorPolicyCache(t *testing.T) {
+	resetCollector(t)
+	collector := GetCollector()
+
+	collector.ObservePolicyCache(true)
+	collector.ObservePolicyCache(true)
+	collector.ObservePolicyCache(false)
+
+	if got := testutil.ToFloat64(collector.policyCacheHits); got != 2 {
+		t.Fatalf("expected policyCacheHits=2, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.policyCacheMiss); got != 1 {
+		t.Fatalf("expected policyCacheMiss=1, got %v", got)
+	}
+}
+
+func TestCollectorEBPFMapUsage(t *testing.T) {
+	resetCollector(t)
+	collector := GetCollector()
+
+	collector.SetEBPFMapUsage(250, 10000)
+
+	if got := testutil.ToFloat64(collector.ebpfMapEntries); got != 250 {
+		t.Fatalf("expected ebpfMapEntries=250, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.ebpfMapCapacity); got != 10000 {
+		t.Fatalf("expected ebpfMapCapacity=10000, got %v", got)
+	}
+}
+
+func TestCollectorBreakGlass(t *testing.T) {
+	resetCollector(t)
+	collector := GetCollector()
+
+	collector.SetBreakGlass(true)
+	if got := testutil.ToFloat64(collector.breakGlass); got != 1 {
+		t.Fatalf("expected breakGlass=1, got %v", got)
+	}
+	collector.SetBreakGlass(false)
+	if got := testutil.ToFloat64(collector.breakGlass); got != 0 {
+		t.Fatalf("expected breakGlass=0, got %v", got)
+	}
+}
+
+func TestCollectorWatchDropped(t *testing.T) {
+	resetCollector(t)
+	collector := GetCollector()
+
+	collector.IncWatchDropped("discovery")
+	collector.IncWatchDropped("discovery")
+	collector.IncWatchDropped("cluster")
+
+	if got := testutil.ToFloat64(collector.watchDropped.WithLabelValues("discovery")); got != 2 {
+		t.Fatalf("expected 2 discovery drops, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.watchDropped.WithLabelValues("cluster")); got != 1 {
+		t.Fatalf("expected 1 cluster drop, got %v", got)
+	}
+}
+
+func TestCollectorPrincipals(t *testing.T) {
+	resetCollector(t)
+	collector := GetCollector()
+
+	collector.IncEnforcementBy("alice", true)
+	collector.IncEnforcementBy("alice", false)
+	collector.IncEnforcementBy("", true)
+	collector.IncAPIRequest("bob")
+
+	if got := testutil.ToFloat64(collector.enforcementsBy.WithLabelValues("alice", "success")); got != 1 {
+		t.Fatalf("expected 1 successful enforcement by alice, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.enforcementsBy.WithLabelValues("alice", "error")); got != 1 {
+		t.Fatalf("expected 1 failed enforcement by alice, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.enforcementsBy.WithLabelValues("unknown", "success")); got != 1 {
+		t.Fatalf("expected unattributed enforcement counted as unknown, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.apiRequests.WithLabelValues("bob")); got != 1 {
+		t.Fatalf("expected 1 API request by bob, got %v", got)
+	}
+}
+
+func TestCollectorDataPlaneOverhead(t *testing.T) {
+	resetCollector(t)
+	collector := GetCollector()
+
+	collector.ObservePolicyApply("ebpf", 20*time.Millisecond)
+	collector.ObservePolicyApply("ebpf", 30*time.Millisecond)
+	collector.ObservePFReload(200 * time.Millisecond)
+	collector.SetEBPFProgramStats(1500*time.Millisecond, 3000)
+
+	metric := &dto.Metric{}
+	if err := collector.applyDuration.WithLabelValues("ebpf").(prometheus.Histogram).Write(metric); err != nil {
+		t.Fatalf("failed to read histogram metric: %v", err)
+	}
+	if hist := metric.GetHistogram(); hist.GetSampleCount() != 2 || hist.GetSampleSum() < 0.049 || hist.GetSampleSum() > 0.051 {
+		t.Fatalf("expected 2 apply samples summing to 0.05s, got %v", hist)
+	}
+	if count := testutil.CollectAndCount(collector.pfReloadDuration); count != 1 {
+		t.Fatalf("expected pf reload histogram to collect once, got %d", count)
+	}
+	if got := testutil.ToFloat64(collector.ebpfProgRunTime); got != 1.5 {
+		t.Fatalf("expected program run time 1.5s, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.ebpfProgRuns); got != 3000 {
+		t.Fatalf("expected 3000 program runs, got %v", got)
+	}
+}
+
+func TestCollectorEBPFFilterErrors(t *testing.T) {
+	resetCollector(t)
+	collector := GetCollector()
+
+	collector.SetEBPFFilterErrors("malformed", 3)
+	collector.SetEBPFFilterErrors("malformed", 5)
+	collector.SetEBPFFilterErrors("policy_miss", 120)
+
+	if got := testutil.ToFloat64(collector.ebpfFilterErrors.WithLabelValues("malformed")); got != 5 {
+		t.Fatalf("expected 5 malformed packets, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.ebpfFilterErrors.WithLabelValues("policy_miss")); got != 120 {
+		t.Fatalf("expected 120 policy misses, got %v", got)
+	}
+}
+
+func TestCollectorRetention(t *testing.T) {
+	resetCollector(t)
+	collector := GetCollector()
+
+	collector.ObserveRetention("enforcement_log", 3, 512)
+	collector.ObserveRetention("enforcement_log", 1, 128)
+
+	if got := testutil.ToFloat64(collector.retentionPruned.WithLabelValues("enforcement_log")); got != 4 {
+		t.Fatalf("expected 4 pruned records, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.retentionBytes.WithLabelValues("enforcement_log")); got != 640 {
+		t.Fatalf("expected 640 reclaimed bytes, got %v", got)
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import "ztap/pkg/events"
+
+// RecordEvents updates metrics from events published on bus and counts events
+// dropped for slow subscribers. It returns a function that stops recording.
+func RecordEvents(bus *events.Bus) (stop func()) {
+	c := GetCollector()
+
+	bus.OnDrop(func(topic events.Topic) {
+		c.IncWatchDropped("events")
+	})
+
+	return bus.SubscribeFunc(func(event events.Event) {
+		// Counted by the process that published them
+		if event.Replayed {
+			return
+		}
+		switch data := event.Data.(type) {
+		case events.PolicyApplied:
+			if data.Error == "" {
+				c.IncPoliciesEnforced()
+			}
+			c.IncEnforcementBy(data.Principal, data.Error == "")
+		case events.FlowBlocked:
+			c.IncFlowsBlocked()
+		case events.AnomalyDetected:
+			c.SetAnomalyScore(data.Score)
+		case events.ShrinkHeld:
+			c.IncShrinkHeld(data.Policy)
+		case events.PolicyExpired:
+			if data.Hit {
+				c.IncExpiredPolicyHit(data.Policy)
+			} else {
+				c.IncPolicyExpired(data.Policy)
+			}
+		case events.PolicyApproval:
+			c.IncPolicyApproval(data.Action)
+		}
+	}, events.TopicPolicyApplied, events.TopicFlowBlocked, events.TopicAnomalyDetected, events.TopicShrinkHeld, events.TopicPolicyExpired,
+		events.TopicPolicyApproval)
+}
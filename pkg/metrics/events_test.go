@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"testing"
+
+	"ztap/pkg/events"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRecordEvents(t *testing.T) {
+	resetCollector(t)
+	collector := GetCollector()
+
+	bus := events.NewBus()
+	stop := RecordEvents(bus)
+
+	bus.Publish(events.TopicPolicyApplied, events.PolicyApplied{Policy: "web-to-db", Backend: "ebpf", Principal: "alice"})
+	bus.Publish(events.TopicPolicyApplied, events.PolicyApplied{Policy: "broken", Error: "map full"})
+	bus.Publish(events.TopicFlowBlocked, events.FlowBlocked{DestIP: "10.0.0.1", Port: 22})
+	bus.Publish(events.TopicAnomalyDetected, events.AnomalyDetected{Score: 80})
+	bus.Publish(events.TopicShrinkHeld, events.ShrinkHeld{Policy: "web-to-db", Previous: 10, Resolved: 1})
+	bus.Publish(events.TopicPolicyExpired, events.PolicyExpired{Policy: "break-glass"})
+	bus.Publish(events.TopicPolicyExpired, events.PolicyExpired{Policy: "break-glass", Hit: true, DestIP: "10.0.0.5", Port: 22})
+	bus.Publish(events.TopicPolicyExpired, events.PolicyExpired{Policy: "break-glass", Hit: true, DestIP: "10.0.0.5", Port: 22})
+	bus.Publish(events.TopicPolicyApproval, events.PolicyApproval{ID: "1a", Policy: "ssh", Action: events.ApprovalRequested})
+	bus.Publish(events.TopicPolicyApproval, events.PolicyApproval{ID: "1a", Policy: "ssh", Action: events.ApprovalApproved})
+	// Already counted by the publishing process
+	bus.Replay(events.Event{Topic: events.TopicFlowBlocked, Data: events.FlowBlocked{DestIP: "10.0.0.1", Port: 22}})
+
+	if got := testutil.ToFloat64(collector.policiesEnforced); got != 1 {
+		t.Errorf("expected 1 policy enforced, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.enforcementsBy.WithLabelValues("alice", "success")); got != 1 {
+		t.Errorf("expected 1 enforcement by alice, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.enforcementsBy.WithLabelValues("unknown", "error")); got != 1 {
+		t.Errorf("expected 1 failed unattributed enforcement, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.flowsBlocked); got != 1 {
+		t.Errorf("expected 1 flow blocked, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.anomalyScore); got != 80 {
+		t.Errorf("expected anomaly score 80, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.shrinkHeld.WithLabelValues("web-to-db")); got != 1 {
+		t.Errorf("expected 1 held shrink, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.policyExpired.WithLabelValues("break-glass")); got != 1 {
+		t.Errorf("expected 1 expiration, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.expiredHits.WithLabelValues("break-glass")); got != 2 {
+		t.Errorf("expected 2 expired policy hits, got %v", got)
+	}
+	if got := testutil.ToFloat64(collector.approvals.WithLabelValues(events.ApprovalApproved)); got != 1 {
+		t.Errorf("expected 1 approval, got %v", got)
+	}
+
+	stop()
+	bus.Publish(events.TopicFlowBlocked, events.FlowBlocked{})
+	if got := testutil.ToFloat64(collector.flowsBlocked); got != 1 {
+		t.Errorf("expected no updates after stop, got %v", got)
+	}
+}
@@ -0,0 +1,231 @@
+package metrics
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// OTLP/HTTP JSON encoding of an ExportMetricsServiceRequest. 64-bit integers
+// are strings, as the protobuf JSON mapping requires.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+	Summary     *otlpSummary   `json:"summary,omitempty"`
+}
+
+type otlpNumberPoint struct {
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	TimeUnixNano string          `json:"timeUnixNano"`
+	AsDouble     float64         `json:"asDouble"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes     []otlpAttribute `json:"attributes,omitempty"`
+	TimeUnixNano   string          `json:"timeUnixNano"`
+	Count          string          `json:"count"`
+	Sum            float64         `json:"sum"`
+	BucketCounts   []string        `json:"bucketCounts"`
+	ExplicitBounds []float64       `json:"explicitBounds"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type otlpSummaryPoint struct {
+	Attributes     []otlpAttribute `json:"attributes,omitempty"`
+	TimeUnixNano   string          `json:"timeUnixNano"`
+	Count          string          `json:"count"`
+	Sum            float64         `json:"sum"`
+	QuantileValues []otlpQuantile  `json:"quantileValues"`
+}
+
+type otlpSummary struct {
+	DataPoints []otlpSummaryPoint `json:"dataPoints"`
+}
+
+// otlpCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE; Prometheus counters
+// and histograms never reset between pushes
+const otlpCumulative = 2
+
+// encodeOTLP renders families as an OTLP/HTTP JSON metrics request. resource
+// labels become resource attributes, alongside service.name=ztap. Values JSON
+// cannot represent (NaN, e.g. an empty summary's quantiles) are skipped.
+func encodeOTLP(families []*dto.MetricFamily, resource map[string]string, now time.Time) ([]byte, error) {
+	attrs := map[string]string{"service.name": "ztap"}
+	for k, v := range resource {
+		attrs[k] = v
+	}
+
+	var metrics []otlpMetric
+	for _, family := range families {
+		metric := otlpMetric{Name: family.GetName(), Description: family.GetHelp()}
+		for _, m := range family.GetMetric() {
+			ts := strconv.FormatInt(now.UnixNano(), 10)
+			if m.TimestampMs != nil {
+				ts = strconv.FormatInt(m.GetTimestampMs()*int64(time.Millisecond), 10)
+			}
+			labels := otlpLabels(m.GetLabel())
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				if metric.Sum == nil {
+					metric.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+				}
+				if v := m.GetCounter().GetValue(); finite(v) {
+					metric.Sum.DataPoints = append(metric.Sum.DataPoints, otlpNumberPoint{labels, ts, v})
+				}
+			case dto.MetricType_HISTOGRAM:
+				if metric.Histogram == nil {
+					metric.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+				}
+				metric.Histogram.DataPoints = append(metric.Histogram.DataPoints, otlpHistogramPointOf(m.GetHistogram(), labels, ts))
+			case dto.MetricType_SUMMARY:
+				if metric.Summary == nil {
+					metric.Summary = &otlpSummary{}
+				}
+				s := m.GetSummary()
+				point := otlpSummaryPoint{
+					Attributes:     labels,
+					TimeUnixNano:   ts,
+					Count:          strconv.FormatUint(s.GetSampleCount(), 10),
+					Sum:            s.GetSampleSum(),
+					QuantileValues: []otlpQuantile{},
+				}
+				for _, q := range s.GetQuantile() {
+					if finite(q.GetValue()) {
+						point.QuantileValues = append(point.QuantileValues, otlpQuantile{q.GetQuantile(), q.GetValue()})
+					}
+				}
+				metric.Summary.DataPoints = append(metric.Summary.DataPoints, point)
+			default:
+				// Gauges, and untyped metrics which OTLP has no type for
+				if metric.Gauge == nil {
+					metric.Gauge = &otlpGauge{}
+				}
+				v := m.GetGauge().GetValue()
+				if family.GetType() != dto.MetricType_GAUGE {
+					v = m.GetUntyped().GetValue()
+				}
+				if finite(v) {
+					metric.Gauge.DataPoints = append(metric.Gauge.DataPoints, otlpNumberPoint{labels, ts, v})
+				}
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return json.Marshal(otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlpResource{Attributes: otlpAttributes(attrs)},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "ztap"}, Metrics: metrics}},
+	}}})
+}
+
+// otlpHistogramPointOf converts cumulative Prometheus buckets into OTLP's
+// per-bucket counts, the last of which counts observations above every bound
+func otlpHistogramPointOf(h *dto.Histogram, labels []otlpAttribute, ts string) otlpHistogramPoint {
+	point := otlpHistogramPoint{
+		Attributes:     labels,
+		TimeUnixNano:   ts,
+		Count:          strconv.FormatUint(h.GetSampleCount(), 10),
+		Sum:            h.GetSampleSum(),
+		BucketCounts:   []string{},
+		ExplicitBounds: []float64{},
+	}
+	var previous uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), +1) {
+			continue
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, b.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-previous, 10))
+		previous = b.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(h.GetSampleCount()-previous, 10))
+	return point
+}
+
+func otlpLabels(pairs []*dto.LabelPair) []otlpAttribute {
+	labels := make(map[string]string, len(pairs))
+	for _, l := range pairs {
+		labels[l.GetName()] = l.GetValue()
+	}
+	return otlpAttributes(labels)
+}
+
+// otlpAttributes converts labels to attributes sorted by key
+func otlpAttributes(labels map[string]string) []otlpAttribute {
+	if len(labels) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]otlpAttribute, len(keys))
+	for i, k := range keys {
+		attrs[i] = otlpAttribute{Key: k, Value: otlpValue{StringValue: labels[k]}}
+	}
+	return attrs
+}
+
+func finite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEncodeOTLP(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body, err := encodeOTLP(testFamilies(t), map[string]string{"host.name": "node-1"}, now)
+	if err != nil {
+		t.Fatalf("encodeOTLP failed: %v", err)
+	}
+
+	var request otlpRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(request.ResourceMetrics) != 1 {
+		t.Fatalf("Expected one resource, got %d", len(request.ResourceMetrics))
+	}
+	rm := request.ResourceMetrics[0]
+	attrs := make(map[string]string)
+	for _, a := range rm.Resource.Attributes {
+		attrs[a.Key] = a.Value.StringValue
+	}
+	if attrs["service.name"] != "ztap" || attrs["host.name"] != "node-1" {
+		t.Errorf("Unexpected resource attributes %v", attrs)
+	}
+
+	metrics := make(map[string]otlpMetric)
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+
+	counter := metrics["test_total"]
+	if counter.Sum == nil || !counter.Sum.IsMonotonic || counter.Sum.AggregationTemporality != otlpCumulative {
+		t.Fatalf("Expected cumulative monotonic sum, got %+v", counter)
+	}
+	point := counter.Sum.DataPoints[0]
+	if point.AsDouble != 3 || point.TimeUnixNano != "1700000000000000000" || point.Attributes[0].Key != "policy" {
+		t.Errorf("Unexpected counter point %+v", point)
+	}
+
+	histogram := metrics["test_seconds"].Histogram
+	if histogram == nil {
+		t.Fatal("Expected histogram")
+	}
+	h := histogram.DataPoints[0]
+	// Per-bucket counts: <=0.1, (0.1,1], >1
+	if h.Count != "3" || len(h.ExplicitBounds) != 2 || len(h.BucketCounts) != 3 ||
+		h.BucketCounts[0] != "1" || h.BucketCounts[1] != "1" || h.BucketCounts[2] != "1" {
+		t.Errorf("Unexpected histogram point %+v", h)
+	}
+}
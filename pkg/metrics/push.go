@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Push formats
+const (
+	PushRemoteWrite = "remote_write" // Prometheus remote_write 1.0 (protobuf, snappy)
+	PushOTLP        = "otlp"         // OTLP/HTTP metrics (JSON), e.g. http://collector:4318/v1/metrics
+)
+
+// PushConfig configures pushing metrics to an endpoint, for environments where
+// node-local /metrics endpoints cannot be scraped
+type PushConfig struct {
+	URL       string
+	Format    string // PushRemoteWrite or PushOTLP
+	Interval  time.Duration
+	Headers   map[string]string // Sent with every request, e.g. Authorization
+	Labels    map[string]string // Added to every series (remote_write) or to the resource (OTLP)
+	QueueSize int               // Batches kept while the endpoint is failing (default 60)
+	Timeout   time.Duration     // Per request (default 10s)
+}
+
+// Pusher periodically gathers metrics and pushes them to an endpoint. Each
+// gather is queued as one batch; batches that fail with a retryable error
+// (network errors, 429, 5xx) stay queued and are retried oldest first on the
+// next push, and the oldest batch is dropped once the queue is full. A
+// Pusher is not safe for concurrent use.
+type Pusher struct {
+	config   PushConfig
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	queue    [][]byte
+	dropped  int
+	now      func() time.Time
+}
+
+// NewPusher creates a pusher for metrics gathered from gatherer, typically
+// prometheus.DefaultGatherer
+func NewPusher(config PushConfig, gatherer prometheus.Gatherer) (*Pusher, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid push URL %q", config.URL)
+	}
+	if config.Format != PushRemoteWrite && config.Format != PushOTLP {
+		return nil, fmt.Errorf("unknown push format %q (use %s or %s)", config.Format, PushRemoteWrite, PushOTLP)
+	}
+	if config.Interval <= 0 {
+		return nil, fmt.Errorf("push interval must be positive")
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 60
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	return &Pusher{
+		config:   config,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: config.Timeout},
+		now:      time.Now,
+	}, nil
+}
+
+// Run pushes every interval until ctx is cancelled. Failures are logged and
+// the batches retried on the next push.
+func (p *Pusher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Push(ctx); err != nil {
+				log.Printf("Warning: Failed to push metrics: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Push gathers the current metrics, queues them and sends every queued batch
+func (p *Pusher) Push(ctx context.Context) error {
+	families, err := p.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	var batch []byte
+	switch p.config.Format {
+	case PushRemoteWrite:
+		batch = encodeRemoteWrite(families, p.config.Labels, p.now())
+	case PushOTLP:
+		if batch, err = encodeOTLP(families, p.config.Labels, p.now()); err != nil {
+			return fmt.Errorf("failed to encode metrics: %w", err)
+		}
+	}
+
+	if len(p.queue) >= p.config.QueueSize {
+		p.queue = p.queue[1:]
+		p.dropped++
+	}
+	p.queue = append(p.queue, batch)
+	return p.flush(ctx)
+}
+
+// Pending returns the number of queued batches and how many were dropped
+// because the queue was full or the endpoint rejected them
+func (p *Pusher) Pending() (queued, dropped int) {
+	return len(p.queue), p.dropped
+}
+
+// flush sends queued batches oldest first, stopping at the first retryable
+// failure. Batches the endpoint rejects outright are dropped.
+func (p *Pusher) flush(ctx context.Context) error {
+	var rejected error
+	for len(p.queue) > 0 {
+		retry, err := p.send(ctx, p.queue[0])
+		if err != nil && retry {
+			return fmt.Errorf("%w (%d batch(es) queued)", err, len(p.queue))
+		}
+		if err != nil {
+			rejected = err
+			p.dropped++
+		}
+		p.queue = p.queue[1:]
+	}
+	return rejected
+}
+
+// send posts one batch and reports whether a failure is worth retrying
+func (p *Pusher) send(ctx context.Context, batch []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.URL, bytes.NewReader(batch))
+	if err != nil {
+		return false, err
+	}
+	switch p.config.Format {
+	case PushRemoteWrite:
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	case PushOTLP:
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", "ztap")
+	for k, v := range p.config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode/100 == 2:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5:
+		return true, fmt.Errorf("push endpoint returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("push endpoint rejected metrics: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pushServer records pushed requests and answers with the next status
+type pushServer struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (s *pushServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestPusher(t *testing.T, format string, statuses ...int) (*Pusher, *pushServer) {
+	t.Helper()
+	srv := &pushServer{statuses: statuses}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "A counter"}))
+
+	p, err := NewPusher(PushConfig{
+		URL:       ts.URL,
+		Format:    format,
+		Interval:  1,
+		Headers:   map[string]string{"Authorization": "Bearer secret"},
+		QueueSize: 2,
+	}, registry)
+	if err != nil {
+		t.Fatalf("NewPusher failed: %v", err)
+	}
+	return p, srv
+}
+
+func TestNewPusherValidation(t *testing.T) {
+	for _, config := range []PushConfig{
+		{URL: "localhost:9090", Format: PushOTLP, Interval: 1},
+		{URL: "http://localhost:9090", Format: "graphite", Interval: 1},
+		{URL: "http://localhost:9090", Format: PushOTLP},
+	} {
+		if _, err := NewPusher(config, prometheus.NewRegistry()); err == nil {
+			t.Errorf("Expected error for %+v", config)
+		}
+	}
+}
+
+func TestPusherHeaders(t *testing.T) {
+	for format, contentType := range map[string]string{PushRemoteWrite: "application/x-protobuf", PushOTLP: "application/json"} {
+		p, srv := newTestPusher(t, format)
+		if err := p.Push(context.Background()); err != nil {
+			t.Fatalf("%s: Push failed: %v", format, err)
+		}
+		r := srv.requests[0]
+		if r.Header.Get("Content-Type") != contentType || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("%s: unexpected headers %v", format, r.Header)
+		}
+		if format == PushRemoteWrite && r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("Expected snappy encoding, got %v", r.Header)
+		}
+	}
+}
+
+func TestPusherRetriesQueuedBatches(t *testing.T) {
+	p, srv := newTestPusher(t, PushOTLP, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	ctx := context.Background()
+
+	// Retryable failures keep the batch queued
+	if err := p.Push(ctx); err == nil {
+		t.Fatal("Expected push error")
+	}
+	if err := p.Push(ctx); err == nil {
+		t.Fatal("Expected push error")
+	}
+	if queued, dropped := p.Pending(); queued != 2 || dropped != 0 {
+		t.Fatalf("Expected 2 queued batches, got %d queued, %d dropped", queued, dropped)
+	}
+
+	// The queue is bounded: the oldest batch is dropped, the rest delivered
+	if err := p.Push(ctx); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	if queued, dropped := p.Pending(); queued != 0 || dropped != 1 {
+		t.Errorf("Expected empty queue with 1 dropped, got %d queued, %d dropped", queued, dropped)
+	}
+	if len(srv.requests) != 4 {
+		t.Errorf("Expected 2 failed and 2 delivered requests, got %d", len(srv.requests))
+	}
+}
+
+func TestPusherDropsRejectedBatches(t *testing.T) {
+	p, srv := newTestPusher(t, PushRemoteWrite, http.StatusBadRequest)
+	ctx := context.Background()
+
+	if err := p.Push(ctx); err == nil {
+		t.Fatal("Expected rejection error")
+	}
+	if queued, dropped := p.Pending(); queued != 0 || dropped != 1 {
+		t.Errorf("Expected rejected batch to be dropped, got %d queued, %d dropped", queued, dropped)
+	}
+	if err := p.Push(ctx); err != nil || len(srv.requests) != 2 {
+		t.Errorf("Expected next push to succeed, got %v after %d requests", err, len(srv.requests))
+	}
+}
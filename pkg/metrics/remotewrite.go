@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// series is one flattened time series value
+type series struct {
+	labels    map[string]string // Including __name__
+	value     float64
+	timestamp int64 // Milliseconds since the epoch
+}
+
+// flatten expands metric families into time series the way the Prometheus
+// text format does: histograms become _bucket, _sum and _count series and
+// summaries quantile, _sum and _count series. extra labels are added to every
+// series unless the metric sets them itself.
+func flatten(families []*dto.MetricFamily, extra map[string]string, now time.Time) []series {
+	var out []series
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			ts := now.UnixMilli()
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(suffix string, value float64, labelName, labelValue string) {
+				labels := make(map[string]string, len(extra)+len(m.GetLabel())+2)
+				for k, v := range extra {
+					labels[k] = v
+				}
+				for _, l := range m.GetLabel() {
+					labels[l.GetName()] = l.GetValue()
+				}
+				if labelName != "" {
+					labels[labelName] = labelValue
+				}
+				labels["__name__"] = name + suffix
+				out = append(out, series{labels: labels, value: value, timestamp: ts})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue(), "", "")
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue(), "", "")
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add("_sum", s.GetSampleSum(), "", "")
+				add("_count", float64(s.GetSampleCount()), "", "")
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						continue // Emitted below from the sample count
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				add("_bucket", float64(h.GetSampleCount()), "le", "+Inf")
+				add("_sum", h.GetSampleSum(), "", "")
+				add("_count", float64(h.GetSampleCount()), "", "")
+			default:
+				add("", m.GetUntyped().GetValue(), "", "")
+			}
+		}
+	}
+	return out
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeRemoteWrite renders families as a snappy-compressed Prometheus
+// remote_write 1.0 WriteRequest
+func encodeRemoteWrite(families []*dto.MetricFamily, extra map[string]string, now time.Time) []byte {
+	var request []byte
+	for _, s := range flatten(families, extra, now) {
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, encodeTimeSeries(s))
+	}
+	return snappyEncode(request)
+}
+
+// encodeTimeSeries encodes a prometheus.TimeSeries message: labels sorted by
+// name (field 1) and a single sample (field 2)
+func encodeTimeSeries(s series) []byte {
+	names := make([]string, 0, len(s.labels))
+	for name := range s.labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ts []byte
+	for _, name := range names {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, s.labels[name])
+
+		ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, label)
+	}
+
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+
+	ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+	return protowire.AppendBytes(ts, sample)
+}
+
+// snappyEncode frames src as a snappy block made only of literals. This is
+// valid input for any snappy decoder; remote_write requires the framing, and
+// metrics payloads are small enough that skipping compression costs little.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+	for len(src) > 0 {
+		n := min(len(src), 1<<16)
+		switch {
+		case n <= 60:
+			dst = append(dst, byte(n-1)<<2)
+		case n <= 1<<8:
+			dst = append(dst, 60<<2, byte(n-1))
+		default:
+			dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// testFamilies gathers a counter with a label and a histogram from a private
+// registry
+func testFamilies(t *testing.T) []*dto.MetricFamily {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total", Help: "A counter"}, []string{"policy"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "A histogram", Buckets: []float64{0.1, 1}})
+	registry.MustRegister(counter, histogram)
+
+	counter.WithLabelValues("web").Add(3)
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(5)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	return families
+}
+
+// snappyDecodeLiterals decodes a snappy block made only of literals
+func snappyDecodeLiterals(t *testing.T, src []byte) []byte {
+	t.Helper()
+	length, n := binary.Uvarint(src)
+	src = src[n:]
+	var dst []byte
+	for len(src) > 0 {
+		tag := src[0]
+		if tag&3 != 0 {
+			t.Fatalf("Unexpected non-literal tag %x", tag)
+		}
+		size := int(tag>>2) + 1
+		src = src[1:]
+		switch tag >> 2 {
+		case 60:
+			size = int(src[0]) + 1
+			src = src[1:]
+		case 61:
+			size = int(binary.LittleEndian.Uint16(src)) + 1
+			src = src[2:]
+		}
+		dst = append(dst, src[:size]...)
+		src = src[size:]
+	}
+	if len(dst) != int(length) {
+		t.Fatalf("Decoded %d bytes, header says %d", len(dst), length)
+	}
+	return dst
+}
+
+// decodeWriteRequest parses a WriteRequest into label sets and samples
+func decodeWriteRequest(t *testing.T, b []byte) []series {
+	t.Helper()
+	var out []series
+	for len(b) > 0 {
+		_, _, n := protowire.ConsumeTag(b)
+		ts, m := protowire.ConsumeBytes(b[n:])
+		b = b[n+m:]
+
+		s := series{labels: make(map[string]string)}
+		var lastName string
+		for len(ts) > 0 {
+			field, _, n := protowire.ConsumeTag(ts)
+			msg, m := protowire.ConsumeBytes(ts[n:])
+			ts = ts[n+m:]
+			switch field {
+			case 1:
+				_, _, n := protowire.ConsumeTag(msg)
+				name, m := protowire.ConsumeString(msg[n:])
+				msg = msg[n+m:]
+				_, _, n = protowire.ConsumeTag(msg)
+				value, _ := protowire.ConsumeString(msg[n:])
+				if name < lastName {
+					t.Errorf("Labels not sorted: %s after %s", name, lastName)
+				}
+				lastName = name
+				s.labels[name] = value
+			case 2:
+				_, _, n := protowire.ConsumeTag(msg)
+				bits, m := protowire.ConsumeFixed64(msg[n:])
+				msg = msg[n+m:]
+				_, _, n = protowire.ConsumeTag(msg)
+				ts, _ := protowire.ConsumeVarint(msg[n:])
+				s.value, s.timestamp = math.Float64frombits(bits), int64(ts)
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestEncodeRemoteWrite(t *testing.T) {
+	now := time.UnixMilli(1700000000000)
+	body := encodeRemoteWrite(testFamilies(t), map[string]string{"instance": "node-1", "policy": "ignored"}, now)
+	got := decodeWriteRequest(t, snappyDecodeLiterals(t, body))
+
+	values := make(map[string]float64)
+	for _, s := range got {
+		if s.timestamp != now.UnixMilli() || s.labels["instance"] != "node-1" {
+			t.Errorf("Unexpected series %+v", s)
+		}
+		key := s.labels["__name__"]
+		if le, ok := s.labels["le"]; ok {
+			key += "{le=" + le + "}"
+		}
+		values[key] = s.value
+	}
+
+	want := map[string]float64{
+		"test_total":                   3,
+		"test_seconds_bucket{le=0.1}":  1,
+		"test_seconds_bucket{le=1}":    2,
+		"test_seconds_bucket{le=+Inf}": 3,
+		"test_seconds_sum":             5.55,
+		"test_seconds_count":           3,
+	}
+	for key, value := range want {
+		if v, ok := values[key]; !ok || math.Abs(v-value) > 1e-9 {
+			t.Errorf("%s = %v, want %v", key, v, value)
+		}
+	}
+
+	// Metric labels win over extra labels
+	for _, s := range got {
+		if s.labels["__name__"] == "test_total" && s.labels["policy"] != "web" {
+			t.Errorf("Expected metric label to win, got %v", s.labels)
+		}
+	}
+}
+
+func TestSnappyEncodeLongLiterals(t *testing.T) {
+	for _, size := range []int{0, 1, 60, 61, 256, 257, 70000} {
+		src := make([]byte, size)
+		for i := range src {
+			src[i] = byte(i)
+		}
+		if got := snappyDecodeLiterals(t, snappyEncode(src)); string(got) != string(src) {
+			t.Errorf("Round trip of %d bytes failed", size)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Package migrate keeps the state ZTAP stores under ~/.ztap readable across
+// upgrades. The format version of each file is recorded in a manifest
+// (state-version.json). At startup, files in an older format are backed up
+// and migrated, and ztap refuses to run when a file is in a format newer
+// than the binary understands, rather than misreading it or overwriting it
+// in the old format.
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestFile is the name of the manifest in the state directory
+const ManifestFile = "state-version.json"
+
+// BackupDir is the directory, in the state directory, files are copied to
+// before they are migrated
+const BackupDir = "backups"
+
+// ErrTooNew is returned when a file is in a format newer than the binary
+// understands, after a downgrade or when an older ztap shares the state
+// directory with a newer one
+var ErrTooNew = errors.New("state was written by a newer version of ztap")
+
+// Migration rewrites a file from one format version to the next
+type Migration struct {
+	Description string
+	// Apply returns the file's content in the next version. The manifest is
+	// updated after the migrated file is in place, so a crash in between
+	// applies the migration again: Apply must leave content already in the
+	// next version unchanged.
+	Apply func(data []byte) ([]byte, error)
+}
+
+// Format is the on-disk format of a file in the state directory. Its
+// version is 1 plus the number of migrations: Migrations[0] migrates
+// version 1 to 2, and so on. Files written before the manifest existed are
+// version 1.
+type Format struct {
+	File       string // Relative to the state directory
+	Migrations []Migration
+}
+
+// Version returns the format version this build writes
+func (f Format) Version() int {
+	return len(f.Migrations) + 1
+}
+
+// Manifest records the format version of each file
+type Manifest struct {
+	Versions  map[string]int `json:"versions"`
+	WrittenBy string         `json:"written_by,omitempty"` // ztap version that last updated the manifest
+}
+
+// Migrated is a file Run migrated
+type Migrated struct {
+	File   string
+	From   int
+	To     int
+	Backup string // Path of the copy of the file before migration
+	Steps  []string
+}
+
+// LoadManifest reads the manifest of the state directory dir; a missing
+// manifest has no versions
+func LoadManifest(dir string) (Manifest, error) {
+	manifest := Manifest{Versions: make(map[string]int)}
+	path := filepath.Join(dir, ManifestFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read state manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("invalid state manifest %s: %w", path, err)
+	}
+	if manifest.Versions == nil {
+		manifest.Versions = make(map[string]int)
+	}
+	return manifest, nil
+}
+
+// Check returns ErrTooNew, naming the files, if any file of dir is recorded
+// in a format newer than formats
+func Check(dir string, formats []Format) error {
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return err
+	}
+	return checkVersions(dir, manifest, formats)
+}
+
+func checkVersions(dir string, manifest Manifest, formats []Format) error {
+	known := make(map[string]Format, len(formats))
+	for _, f := range formats {
+		known[f.File] = f
+	}
+	var newer []string
+	for file, version := range manifest.Versions {
+		// Files this build does not know of are left to the version that
+		// wrote them
+		if f, ok := known[file]; ok && version > f.Version() {
+			newer = append(newer, fmt.Sprintf("%s (version %d, this ztap reads up to %d)", file, version, f.Version()))
+		}
+	}
+	if len(newer) == 0 {
+		return nil
+	}
+	sort.Strings(newer)
+	by := ""
+	if manifest.WrittenBy != "" {
+		by = " (" + manifest.WrittenBy + ")"
+	}
+	return fmt.Errorf("%w%s: %s in %s; upgrade ztap, or restore the files from %s",
+		ErrTooNew, by, strings.Join(newer, ", "), dir, filepath.Join(dir, BackupDir))
+}
+
+// Run migrates the files of the state directory dir to formats, and
+// records their versions in the manifest as written by version. Nothing is
+// migrated if any file is newer than formats (ErrTooNew). Each file is
+// copied to the backup directory, named after its version and now, before
+// its migrated content is renamed over it; so is the manifest before the
+// first migration. Missing files are recorded in
+// the current version, as they will be written in it.
+func Run(dir string, formats []Format, version string, now time.Time) ([]Migrated, error) {
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersions(dir, manifest, formats); err != nil {
+		return nil, err
+	}
+
+	var migrated []Migrated
+	changed := false
+	for _, f := range formats {
+		from, recorded := manifest.Versions[f.File]
+		if !recorded {
+			from = 1
+		}
+		path := filepath.Join(dir, f.File)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			if !recorded || from != f.Version() {
+				manifest.Versions[f.File] = f.Version()
+				changed = true
+			}
+			continue
+		}
+		if err != nil {
+			return migrated, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if from == f.Version() {
+			if !recorded {
+				manifest.Versions[f.File] = from
+				changed = true
+			}
+			continue
+		}
+
+		if len(migrated) == 0 {
+			if err := backupManifest(dir, now); err != nil {
+				return migrated, err
+			}
+		}
+		m, err := migrateFile(dir, f, from, data, now)
+		if err != nil {
+			return migrated, err
+		}
+		migrated = append(migrated, m)
+		manifest.Versions[f.File] = f.Version()
+		manifest.WrittenBy = version
+		if err := saveManifest(dir, manifest); err != nil {
+			return migrated, err
+		}
+		changed = false
+	}
+	if changed {
+		manifest.WrittenBy = version
+		if err := saveManifest(dir, manifest); err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// migrateFile backs up the file of f holding data in version from, and
+// replaces it with data migrated to f's version
+func migrateFile(dir string, f Format, from int, data []byte, now time.Time) (Migrated, error) {
+	path := filepath.Join(dir, f.File)
+	m := Migrated{File: f.File, From: from, To: f.Version()}
+	info, err := os.Stat(path)
+	if err != nil {
+		return m, err
+	}
+
+	out := data
+	for v := from; v < f.Version(); v++ {
+		step := f.Migrations[v-1]
+		if out, err = step.Apply(out); err != nil {
+			return m, fmt.Errorf("failed to migrate %s from version %d to %d (%s): %w", path, v, v+1, step.Description, err)
+		}
+		m.Steps = append(m.Steps, step.Description)
+	}
+
+	m.Backup = filepath.Join(dir, BackupDir, fmt.Sprintf("%s.v%d.%s", f.File, from, timestamp(now)))
+	if err := writeFile(m.Backup, data, info.Mode().Perm()); err != nil {
+		return m, fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	if err := writeFile(path, out, info.Mode().Perm()); err != nil {
+		return m, fmt.Errorf("failed to write migrated %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// backupManifest copies the manifest of dir, if any, to the backup
+// directory, so restoring the backups of a migration restores the versions
+// they were in
+func backupManifest(dir string, now time.Time) error {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state manifest: %w", err)
+	}
+	if err := writeFile(filepath.Join(dir, BackupDir, ManifestFile+"."+timestamp(now)), data, 0600); err != nil {
+		return fmt.Errorf("failed to back up state manifest: %w", err)
+	}
+	return nil
+}
+
+// timestamp formats now for the names of backups
+func timestamp(now time.Time) string {
+	return now.UTC().Format("20060102T150405Z")
+}
+
+// saveManifest writes the manifest of dir
+func saveManifest(dir string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, ManifestFile), data, 0600); err != nil {
+		return fmt.Errorf("failed to save state manifest: %w", err)
+	}
+	return nil
+}
+
+// writeFile writes data to a temporary file and renames it over path, so
+// readers never see a partial file
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,99 @@
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// upper is a migration to version 2 of a format whose content is upper case
+var upper = Migration{
+	Description: "upper-case the content",
+	Apply: func(data []byte) ([]byte, error) {
+		return bytes.ToUpper(data), nil
+	},
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 10, 1, 9, 0, 0, 0, time.UTC)
+	os.WriteFile(filepath.Join(dir, "users.json"), []byte("alice"), 0600)
+	v1 := []Format{{File: "users.json"}, {File: "stats.json"}}
+
+	// Files written before the manifest are recorded in version 1, as are
+	// missing ones
+	migrated, err := Run(dir, v1, "v1.0.0", now)
+	if err != nil || len(migrated) != 0 {
+		t.Fatalf("Expected nothing migrated, got %+v (%v)", migrated, err)
+	}
+	manifest, _ := LoadManifest(dir)
+	if manifest.Versions["users.json"] != 1 || manifest.Versions["stats.json"] != 1 || manifest.WrittenBy != "v1.0.0" {
+		t.Fatalf("Unexpected manifest %+v", manifest)
+	}
+
+	// An upgrade migrates users.json and keeps a copy of the old file
+	v2 := []Format{{File: "users.json", Migrations: []Migration{upper}}, {File: "stats.json"}}
+	migrated, err = Run(dir, v2, "v1.1.0", now)
+	if err != nil || len(migrated) != 1 {
+		t.Fatalf("Expected users.json migrated, got %+v (%v)", migrated, err)
+	}
+	if m := migrated[0]; m.File != "users.json" || m.From != 1 || m.To != 2 || len(m.Steps) != 1 {
+		t.Errorf("Unexpected migration %+v", m)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "users.json")); string(data) != "ALICE" {
+		t.Errorf("Expected migrated content, got %q", data)
+	}
+	if data, err := os.ReadFile(migrated[0].Backup); err != nil || string(data) != "alice" {
+		t.Errorf("Expected the old content backed up, got %q (%v)", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, BackupDir, ManifestFile+".20251001T090000Z")); err != nil || !strings.Contains(string(data), `"users.json": 1`) {
+		t.Errorf("Expected the old manifest backed up, got %q (%v)", data, err)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "users.json")); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the file mode kept, got %v", info.Mode().Perm())
+	}
+
+	// Running again changes nothing
+	if migrated, err := Run(dir, v2, "v1.1.0", now); err != nil || len(migrated) != 0 {
+		t.Errorf("Expected nothing migrated again, got %+v (%v)", migrated, err)
+	}
+
+	// The older build refuses the newer format and leaves it alone
+	err = Check(dir, v1)
+	if !errors.Is(err, ErrTooNew) || !strings.Contains(err.Error(), "users.json") || !strings.Contains(err.Error(), "v1.1.0") {
+		t.Errorf("Expected ErrTooNew naming users.json and v1.1.0, got %v", err)
+	}
+	if _, err := Run(dir, v1, "v1.0.0", now); !errors.Is(err, ErrTooNew) {
+		t.Errorf("Expected Run to refuse a newer format, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "users.json")); string(data) != "ALICE" {
+		t.Errorf("Expected the newer file untouched, got %q", data)
+	}
+}
+
+func TestRunFailedMigration(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policies.json")
+	os.WriteFile(path, []byte("{}"), 0600)
+	broken := Migration{
+		Description: "split rules",
+		Apply: func([]byte) ([]byte, error) {
+			return nil, errors.New("unexpected rule")
+		},
+	}
+
+	_, err := Run(dir, []Format{{File: "policies.json", Migrations: []Migration{upper, broken}}}, "v2.0.0", time.Now())
+	if err == nil || !strings.Contains(err.Error(), "version 2 to 3") {
+		t.Fatalf("Expected the failed step reported, got %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "{}" {
+		t.Errorf("Expected the file untouched after a failed migration, got %q", data)
+	}
+	if manifest, _ := LoadManifest(dir); len(manifest.Versions) != 0 {
+		t.Errorf("Expected no versions recorded, got %+v", manifest.Versions)
+	}
+}
@@ -0,0 +1,589 @@
+// Package notify delivers events to notification channels, commands or URLs
+// like hooks, without letting alert storms flood them. Repeats of an alert,
+// keyed by its topic, policy and destination, are suppressed for a window
+// and reported as one notification with their count, and channels may take
+// hourly or daily digests instead of a notification per event.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ztap/pkg/clock"
+	"ztap/pkg/configfile"
+	"ztap/pkg/events"
+)
+
+// DefaultTimeout bounds a delivery when the config sets no timeout
+const DefaultTimeout = 10 * time.Second
+
+// flushInterval is how often Run reports ended dedup windows and digests
+const flushInterval = time.Minute
+
+// queueSize is the number of deliveries kept per channel while it is slow;
+// beyond it the oldest are dropped
+const queueSize = 64
+
+// Digest periods
+const (
+	Hourly = "hourly"
+	Daily  = "daily" // UTC days
+)
+
+// Notification kinds, passed to commands as $ZTAP_NOTIFICATION and to URLs
+// as the X-ZTAP-Notification header
+const (
+	KindEvent  = "event"
+	KindDigest = "digest"
+)
+
+// Channel is a command or URL receiving notifications
+type Channel struct {
+	Name    string         `yaml:"name"`
+	Command []string       `yaml:"command"` // Program and arguments, run without a shell
+	URL     string         `yaml:"url"`
+	Topics  []events.Topic `yaml:"topics"` // All topics if empty
+	Digest  string         `yaml:"digest"` // Hourly or Daily instead of a notification per event
+}
+
+func (c Channel) String() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	if len(c.Command) > 0 {
+		return c.Command[0]
+	}
+	return c.URL
+}
+
+// wants reports whether the channel receives events of topic
+func (c Channel) wants(topic events.Topic) bool {
+	if len(c.Topics) == 0 {
+		return true
+	}
+	for _, t := range c.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// DedupConfig sets how long repeats of an alert are suppressed
+type DedupConfig struct {
+	Window time.Duration                  `yaml:"window"` // 0 sends every event
+	Topics map[events.Topic]time.Duration `yaml:"topics"` // Overrides Window per topic
+}
+
+// window returns the dedup window of topic
+func (d DedupConfig) window(topic events.Topic) time.Duration {
+	if w, ok := d.Topics[topic]; ok {
+		return w
+	}
+	return d.Window
+}
+
+// Config is the notifications section of config.yaml:
+//
+//	notifications:
+//	  timeout: 10s
+//	  dedup:
+//	    window: 10m          # one notification per alert per window
+//	    topics:
+//	      flow_blocked: 1h
+//	  channels:
+//	    - name: oncall
+//	      url: https://hooks.example.com/ztap
+//	      topics: [flow_blocked, anomaly_detected, break_glass]
+//	    - name: security-team
+//	      command: [/usr/local/bin/mail-digest]
+//	      digest: daily
+type Config struct {
+	Timeout  time.Duration `yaml:"timeout"`
+	Dedup    DedupConfig   `yaml:"dedup"`
+	Channels []Channel     `yaml:"channels"`
+}
+
+// Enabled reports whether any channel is configured
+func (c Config) Enabled() bool {
+	return len(c.Channels) > 0
+}
+
+// Validate checks that every channel has exactly one of command and url,
+// known topics and digest period, and that durations are not negative
+func (c Config) Validate() error {
+	known := make(map[events.Topic]bool, len(events.Topics))
+	for _, t := range events.Topics {
+		known[t] = true
+	}
+	for i, ch := range c.Channels {
+		field := fmt.Sprintf("notifications.channels[%d]", i)
+		if (len(ch.Command) == 0) == (ch.URL == "") {
+			return fmt.Errorf("%s: exactly one of command and url is required", field)
+		}
+		if ch.URL != "" && !strings.HasPrefix(ch.URL, "http://") && !strings.HasPrefix(ch.URL, "https://") {
+			return fmt.Errorf("%s: url must be http or https", field)
+		}
+		for _, t := range ch.Topics {
+			if !known[t] {
+				return fmt.Errorf("%s: unknown topic %q", field, t)
+			}
+		}
+		switch ch.Digest {
+		case "", Hourly, Daily:
+		default:
+			return fmt.Errorf("%s: digest must be %s or %s", field, Hourly, Daily)
+		}
+	}
+	if c.Dedup.Window < 0 {
+		return fmt.Errorf("notifications.dedup.window must not be negative")
+	}
+	for t, w := range c.Dedup.Topics {
+		if !known[t] {
+			return fmt.Errorf("notifications.dedup.topics: unknown topic %q", t)
+		}
+		if w < 0 {
+			return fmt.Errorf("notifications.dedup.topics.%s must not be negative", t)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("notifications.timeout must not be negative")
+	}
+	return nil
+}
+
+// LoadConfig reads the notifications section of file. A missing file or
+// section configures no channels.
+func LoadConfig(file *configfile.File) (Config, error) {
+	var c Config
+	if err := file.Section("notifications", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Key identifies repeats of an alert
+type Key struct {
+	Topic       events.Topic `json:"topic"`
+	Tenant      string       `json:"tenant,omitempty"`
+	Policy      string       `json:"policy,omitempty"`
+	Destination string       `json:"destination,omitempty"` // IP, with the port if known
+}
+
+// KeyOf returns the dedup key of event
+func KeyOf(event events.Event) Key {
+	key := Key{Topic: event.Topic, Tenant: event.Tenant}
+	switch d := event.Data.(type) {
+	case events.FlowBlocked:
+		key.Policy, key.Destination = d.Policy, destination(d.DestIP, d.Port)
+	case events.AnomalyDetected:
+		key.Destination = destination(d.DestIP, d.Port)
+	case events.PolicyExpired:
+		key.Policy, key.Destination = d.Policy, destination(d.DestIP, d.Port)
+	case events.PolicyApplied:
+		key.Policy = d.Policy
+	case events.ShrinkHeld:
+		key.Policy = d.Policy
+	case events.PolicyApproval:
+		key.Policy = d.Policy
+	case events.ServiceChanged:
+		key.Destination = d.IP
+	}
+	return key
+}
+
+func destination(ip string, port int) string {
+	if ip == "" || port == 0 {
+		return ip
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port))
+}
+
+// Notification is sent to per-event channels. Count is 1 for the first
+// event of a dedup window; when repeats were suppressed, a notification
+// with their count and the last of them follows once the window ends.
+type Notification struct {
+	Key
+	Count     int          `json:"count"`
+	FirstSeen time.Time    `json:"first_seen"`
+	LastSeen  time.Time    `json:"last_seen"`
+	Event     events.Event `json:"event"` // The latest event
+}
+
+// Digest summarizes the events a digest channel received in a period
+type Digest struct {
+	Period  string        `json:"period"` // Hourly or Daily
+	Start   time.Time     `json:"start"`
+	End     time.Time     `json:"end"`
+	Total   int           `json:"total"`
+	Entries []DigestEntry `json:"entries"` // Most frequent first
+}
+
+// DigestEntry counts the events of one key in a Digest
+type DigestEntry struct {
+	Key
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// window tracks the repeats of a key within its dedup window
+type window struct {
+	until      time.Time
+	suppressed int
+	firstSeen  time.Time // Of the suppressed repeats
+	lastSeen   time.Time
+	last       events.Event
+}
+
+// repeats returns the notification of the suppressed repeats, if any
+func (w *window) repeats(key Key) (Notification, bool) {
+	return Notification{
+		Key:       key,
+		Count:     w.suppressed,
+		FirstSeen: w.firstSeen,
+		LastSeen:  w.lastSeen,
+		Event:     w.last,
+	}, w.suppressed > 0
+}
+
+// digest collects the events of a digest channel in the current period
+type digest struct {
+	start   time.Time
+	total   int
+	entries map[Key]*DigestEntry
+}
+
+// delivery is a payload to send once mu is released
+type delivery struct {
+	channel int // Index in Config.Channels
+	kind    string
+	payload any
+}
+
+// queue holds the deliveries of a channel waiting to be sent. A goroutine
+// sends them in order while any are pending, so a slow channel delays
+// neither the others nor the events read from the bus.
+type queue struct {
+	mu      sync.Mutex
+	pending []delivery
+	sending bool
+}
+
+// Notifier delivers events to the configured channels
+type Notifier struct {
+	config  Config
+	clock   clock.Clock
+	client  *http.Client
+	mu      sync.Mutex
+	windows map[Key]*window
+	digests []*digest // By channel; nil for per-event channels and empty periods
+	queues  []*queue  // By channel
+	queued  sync.WaitGroup
+}
+
+// New creates a notifier for config
+func New(config Config) *Notifier {
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	queues := make([]*queue, len(config.Channels))
+	for i := range queues {
+		queues[i] = &queue{}
+	}
+	return &Notifier{
+		config:  config,
+		clock:   clock.Real,
+		client:  &http.Client{},
+		windows: make(map[Key]*window),
+		digests: make([]*digest, len(config.Channels)),
+		queues:  queues,
+	}
+}
+
+// SetClock replaces the clock used for windows and digest periods, e.g.
+// with a clock.Fake in tests
+func (n *Notifier) SetClock(clk clock.Clock) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.clock = clock.OrReal(clk)
+}
+
+// Run delivers the events published on bus until ctx is cancelled, then
+// sends what the current dedup windows and digests hold
+func (n *Notifier) Run(ctx context.Context, bus *events.Bus) {
+	ch := bus.Subscribe(ctx)
+	ticker := n.clock.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				n.Close()
+				return
+			}
+			n.Handle(event)
+		case <-ticker.C():
+			n.Flush()
+		}
+	}
+}
+
+// Handle queues event for the per-event channels wanting its topic, unless
+// it repeats an alert within its dedup window, and adds it to the digests.
+// It does not wait for deliveries (see deliver).
+func (n *Notifier) Handle(event events.Event) {
+	n.mu.Lock()
+	now := n.clock.Now()
+	key := KeyOf(event)
+	var out []delivery
+	for i, ch := range n.config.Channels {
+		if ch.Digest == "" || !ch.wants(event.Topic) {
+			continue
+		}
+		d := n.digests[i]
+		if d != nil && !now.Before(periodEnd(d.start, ch.Digest)) {
+			out = append(out, delivery{i, KindDigest, d.summary(ch.Digest, periodEnd(d.start, ch.Digest))})
+			d = nil
+		}
+		if d == nil {
+			d = &digest{start: periodStart(now, ch.Digest), entries: make(map[Key]*DigestEntry)}
+			n.digests[i] = d
+		}
+		d.add(key, now)
+	}
+
+	if w := n.windows[key]; w != nil && now.Before(w.until) {
+		if w.suppressed == 0 {
+			w.firstSeen = now
+		}
+		w.suppressed++
+		w.lastSeen, w.last = now, event
+	} else {
+		if w != nil {
+			// The window ended between flushes
+			if r, ok := w.repeats(key); ok {
+				out = n.eventDeliveries(out, r)
+			}
+			delete(n.windows, key)
+		}
+		if d := n.config.Dedup.window(event.Topic); d > 0 {
+			n.windows[key] = &window{until: now.Add(d)}
+		}
+		out = n.eventDeliveries(out, Notification{Key: key, Count: 1, FirstSeen: now, LastSeen: now, Event: event})
+	}
+	n.mu.Unlock()
+
+	n.deliver(out)
+}
+
+// Flush sends a notification for each ended dedup window with suppressed
+// repeats, and the digests of ended periods
+func (n *Notifier) Flush() {
+	n.flush(false)
+}
+
+// Close sends the repeats of every open dedup window and every digest,
+// ended or not, and waits for the queued deliveries to be sent
+func (n *Notifier) Close() {
+	n.flush(true)
+	n.queued.Wait()
+}
+
+func (n *Notifier) flush(all bool) {
+	n.mu.Lock()
+	now := n.clock.Now()
+	var repeats []Notification
+	for key, w := range n.windows {
+		if !all && now.Before(w.until) {
+			continue
+		}
+		if r, ok := w.repeats(key); ok {
+			repeats = append(repeats, r)
+		}
+		delete(n.windows, key)
+	}
+	sort.Slice(repeats, func(i, j int) bool { return repeats[i].FirstSeen.Before(repeats[j].FirstSeen) })
+	var out []delivery
+	for _, r := range repeats {
+		out = n.eventDeliveries(out, r)
+	}
+	for i, d := range n.digests {
+		if d == nil {
+			continue
+		}
+		ch := n.config.Channels[i]
+		end := periodEnd(d.start, ch.Digest)
+		if now.Before(end) {
+			if !all {
+				continue
+			}
+			end = now
+		}
+		out = append(out, delivery{i, KindDigest, d.summary(ch.Digest, end)})
+		n.digests[i] = nil
+	}
+	n.mu.Unlock()
+
+	n.deliver(out)
+}
+
+// eventDeliveries appends the deliveries of notification to the per-event
+// channels wanting it
+func (n *Notifier) eventDeliveries(out []delivery, notification Notification) []delivery {
+	for i, ch := range n.config.Channels {
+		if ch.Digest == "" && ch.wants(notification.Topic) {
+			out = append(out, delivery{i, KindEvent, notification})
+		}
+	}
+	return out
+}
+
+// deliver queues each delivery on its channel. A channel with queueSize
+// deliveries pending drops the oldest.
+func (n *Notifier) deliver(out []delivery) {
+	for _, d := range out {
+		q := n.queues[d.channel]
+		n.queued.Add(1)
+		q.mu.Lock()
+		if len(q.pending) == queueSize {
+			log.Printf("Warning: notification channel %s is falling behind; dropped its oldest %s notification", n.config.Channels[d.channel], q.pending[0].kind)
+			q.pending = q.pending[1:]
+			n.queued.Done()
+		}
+		q.pending = append(q.pending, d)
+		start := !q.sending
+		q.sending = true
+		q.mu.Unlock()
+		if start {
+			go n.drain(q)
+		}
+	}
+}
+
+// drain sends the deliveries of q until none are pending
+func (n *Notifier) drain(q *queue) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.sending = false
+			q.mu.Unlock()
+			return
+		}
+		d := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		n.send(n.config.Channels[d.channel], d.kind, d.payload)
+		n.queued.Done()
+	}
+}
+
+func (d *digest) add(key Key, now time.Time) {
+	d.total++
+	e := d.entries[key]
+	if e == nil {
+		e = &DigestEntry{Key: key, FirstSeen: now}
+		d.entries[key] = e
+	}
+	e.Count++
+	e.LastSeen = now
+}
+
+func (d *digest) summary(period string, end time.Time) Digest {
+	s := Digest{Period: period, Start: d.start, End: end, Total: d.total}
+	for _, e := range d.entries {
+		s.Entries = append(s.Entries, *e)
+	}
+	sort.Slice(s.Entries, func(i, j int) bool {
+		a, b := s.Entries[i], s.Entries[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.FirstSeen.Before(b.FirstSeen)
+	})
+	return s
+}
+
+func periodStart(t time.Time, period string) time.Time {
+	if period == Daily {
+		return t.UTC().Truncate(24 * time.Hour)
+	}
+	return t.UTC().Truncate(time.Hour)
+}
+
+func periodEnd(start time.Time, period string) time.Time {
+	if period == Daily {
+		return start.Add(24 * time.Hour)
+	}
+	return start.Add(time.Hour)
+}
+
+// send delivers payload to ch. Failures are logged; a notification is never
+// retried.
+func (n *Notifier) send(ch Channel, kind string, payload any) {
+	input, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.Timeout)
+	defer cancel()
+	if ch.URL != "" {
+		err = n.post(ctx, ch, kind, input)
+	} else {
+		err = run(ctx, ch, kind, input)
+	}
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+func run(ctx context.Context, ch Channel, kind string, input []byte) error {
+	cmd := exec.CommandContext(ctx, ch.Command[0], ch.Command[1:]...)
+	cmd.Env = append(os.Environ(), "ZTAP_NOTIFICATION="+kind)
+	cmd.WaitDelay = time.Second // Do not wait on children holding stdout after a timeout
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("notification channel %s failed: %w: %s", ch, err, msg)
+		}
+		return fmt.Errorf("notification channel %s failed: %w", ch, err)
+	}
+	return nil
+}
+
+func (n *Notifier) post(ctx context.Context, ch Channel, kind string, input []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ch.URL, bytes.NewReader(input))
+	if err != nil {
+		return fmt.Errorf("notification channel %s: %w", ch, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-ZTAP-Notification", kind)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notification channel %s failed: %w", ch, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("notification channel %s failed: %s: %s", ch, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ztap/pkg/clock"
+	"ztap/pkg/configfile"
+	"ztap/pkg/events"
+)
+
+var epoch = time.Date(2025, 10, 1, 12, 30, 0, 0, time.UTC)
+
+// receiver records the notifications POSTed to it
+type receiver struct {
+	mu       sync.Mutex
+	received []received
+}
+
+type received struct {
+	Kind string
+	Body []byte
+}
+
+func newReceiver(t *testing.T) (*receiver, string) {
+	r := &receiver{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.received = append(r.received, received{req.Header.Get("X-ZTAP-Notification"), body})
+		r.mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return r, server.URL
+}
+
+// take waits for the deliveries n queued, then returns what r received
+func (r *receiver) take(n *Notifier) []received {
+	n.queued.Wait()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	got := r.received
+	r.received = nil
+	return got
+}
+
+func blocked(dest string, port int) events.Event {
+	return events.Event{
+		Topic: events.TopicFlowBlocked,
+		Data:  events.FlowBlocked{Policy: "web-to-db", SourceIP: "10.0.1.5", DestIP: dest, Port: port, Protocol: "TCP"},
+	}
+}
+
+func notifications(t *testing.T, got []received) []Notification {
+	t.Helper()
+	var out []Notification
+	for _, r := range got {
+		if r.Kind != KindEvent {
+			t.Fatalf("Expected an event notification, got %s", r.Kind)
+		}
+		var n Notification
+		if err := json.Unmarshal(r.Body, &n); err != nil {
+			t.Fatalf("Invalid notification: %v", err)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+notifications:
+  dedup:
+    window: 10m
+    topics:
+      flow_blocked: 1h
+  channels:
+    - name: oncall
+      url: https://hooks.example.com/ztap
+      topics: [flow_blocked]
+    - command: [/usr/local/bin/mail-digest]
+      digest: daily
+`), 0600)
+	config, err := configfile.Read(path, LoadConfig)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if !config.Enabled() || len(config.Channels) != 2 || config.Channels[1].Digest != Daily {
+		t.Errorf("Unexpected channels: %+v", config.Channels)
+	}
+	if w := config.Dedup.window(events.TopicFlowBlocked); w != time.Hour {
+		t.Errorf("Expected a 1h flow_blocked window, got %v", w)
+	}
+	if w := config.Dedup.window(events.TopicAnomalyDetected); w != 10*time.Minute {
+		t.Errorf("Expected the default 10m window, got %v", w)
+	}
+
+	if config, err := configfile.Read(filepath.Join(t.TempDir(), "missing.yaml"), LoadConfig); err != nil || config.Enabled() {
+		t.Errorf("Expected no channels without a config file, got %+v, %v", config, err)
+	}
+
+	for name, c := range map[string]Config{
+		"no target":     {Channels: []Channel{{Name: "x"}}},
+		"both targets":  {Channels: []Channel{{URL: "https://x", Command: []string{"true"}}}},
+		"bad url":       {Channels: []Channel{{URL: "ftp://x"}}},
+		"unknown topic": {Channels: []Channel{{URL: "https://x", Topics: []events.Topic{"nope"}}}},
+		"bad digest":    {Channels: []Channel{{URL: "https://x", Digest: "weekly"}}},
+		"negative":      {Dedup: DedupConfig{Window: -time.Second}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestKeyOf(t *testing.T) {
+	if key := KeyOf(blocked("10.0.2.10", 5432)); key != (Key{Topic: events.TopicFlowBlocked, Policy: "web-to-db", Destination: "10.0.2.10:5432"}) {
+		t.Errorf("Unexpected key %+v", key)
+	}
+	if key := KeyOf(blocked("fd00::1", 443)); key.Destination != "[fd00::1]:443" {
+		t.Errorf("Expected a bracketed IPv6 destination, got %q", key.Destination)
+	}
+	event := events.Event{Topic: events.TopicPolicyApplied, Tenant: "acme", Data: events.PolicyApplied{Policy: "web"}}
+	if key := KeyOf(event); key != (Key{Topic: events.TopicPolicyApplied, Tenant: "acme", Policy: "web"}) {
+		t.Errorf("Unexpected key %+v", key)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	recv, url := newReceiver(t)
+	clk := clock.NewFake(epoch)
+	n := New(Config{
+		Dedup:    DedupConfig{Window: 10 * time.Minute},
+		Channels: []Channel{{URL: url}},
+	})
+	n.SetClock(clk)
+
+	for i := 0; i < 1000; i++ {
+		n.Handle(blocked("10.0.2.10", 5432))
+		clk.Advance(100 * time.Millisecond)
+	}
+	n.Handle(blocked("10.0.2.11", 5432)) // Another destination is another alert
+
+	got := notifications(t, recv.take(n))
+	if len(got) != 2 || got[0].Count != 1 || got[0].Destination != "10.0.2.10:5432" || got[1].Destination != "10.0.2.11:5432" {
+		t.Fatalf("Expected the first event of each destination, got %+v", got)
+	}
+
+	n.Flush()
+	if got := recv.take(n); len(got) != 0 {
+		t.Fatalf("Expected nothing before the window ends, got %d notifications", len(got))
+	}
+
+	clk.Advance(10 * time.Minute)
+	n.Flush()
+	got = notifications(t, recv.take(n))
+	if len(got) != 1 || got[0].Count != 999 || got[0].Destination != "10.0.2.10:5432" {
+		t.Fatalf("Expected one notification of 999 repeats, got %+v", got)
+	}
+	if !got[0].FirstSeen.Equal(epoch.Add(100*time.Millisecond)) || !got[0].LastSeen.Equal(epoch.Add(99900*time.Millisecond)) {
+		t.Errorf("Unexpected repeat span %v - %v", got[0].FirstSeen, got[0].LastSeen)
+	}
+
+	// The window has ended: the next event is sent again
+	n.Handle(blocked("10.0.2.10", 5432))
+	if got := notifications(t, recv.take(n)); len(got) != 1 || got[0].Count != 1 {
+		t.Fatalf("Expected a new notification after the window, got %+v", got)
+	}
+}
+
+func TestDedupWindowEndsBetweenFlushes(t *testing.T) {
+	recv, url := newReceiver(t)
+	clk := clock.NewFake(epoch)
+	n := New(Config{
+		Dedup:    DedupConfig{Topics: map[events.Topic]time.Duration{events.TopicFlowBlocked: time.Minute}},
+		Channels: []Channel{{URL: url}},
+	})
+	n.SetClock(clk)
+
+	n.Handle(blocked("10.0.2.10", 5432))
+	n.Handle(blocked("10.0.2.10", 5432))
+	clk.Advance(time.Minute)
+	n.Handle(blocked("10.0.2.10", 5432))
+
+	got := notifications(t, recv.take(n))
+	if len(got) != 3 || got[0].Count != 1 || got[1].Count != 1 || got[2].Count != 1 {
+		t.Fatalf("Expected the first event, one repeat, then the new event, got %+v", got)
+	}
+	if !got[1].LastSeen.Equal(epoch) || !got[2].FirstSeen.Equal(epoch.Add(time.Minute)) {
+		t.Errorf("Expected the repeat before the new event, got %+v", got)
+	}
+
+	// Topics without a window are never suppressed
+	for i := 0; i < 3; i++ {
+		n.Handle(events.Event{Topic: events.TopicBreakGlass, Data: events.BreakGlass{Action: events.BreakGlassEnabled}})
+	}
+	if got := recv.take(n); len(got) != 3 {
+		t.Errorf("Expected every break_glass event, got %d", len(got))
+	}
+}
+
+func TestDigest(t *testing.T) {
+	recv, url := newReceiver(t)
+	clk := clock.NewFake(epoch)
+	n := New(Config{
+		Dedup: DedupConfig{Window: time.Hour},
+		Channels: []Channel{
+			{Name: "digest", URL: url + "/digest", Digest: Hourly, Topics: []events.Topic{events.TopicFlowBlocked}},
+		},
+	})
+	n.SetClock(clk)
+
+	for i := 0; i < 5; i++ {
+		n.Handle(blocked("10.0.2.10", 5432))
+	}
+	n.Handle(blocked("10.0.2.11", 5432))
+	n.Handle(events.Event{Topic: events.TopicBreakGlass, Data: events.BreakGlass{}}) // Not a topic of the channel
+
+	n.Flush()
+	if got := recv.take(n); len(got) != 0 {
+		t.Fatalf("Expected no notification before the hour ends, got %d", len(got))
+	}
+
+	clk.Set(time.Date(2025, 10, 1, 13, 0, 0, 0, time.UTC))
+	n.Flush()
+	got := recv.take(n)
+	if len(got) != 1 || got[0].Kind != KindDigest {
+		t.Fatalf("Expected one digest, got %+v", got)
+	}
+	var digest Digest
+	if err := json.Unmarshal(got[0].Body, &digest); err != nil {
+		t.Fatalf("Invalid digest: %v", err)
+	}
+	if digest.Period != Hourly || !digest.Start.Equal(time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)) || !digest.End.Equal(time.Date(2025, 10, 1, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected digest period %s %v - %v", digest.Period, digest.Start, digest.End)
+	}
+	if digest.Total != 6 || len(digest.Entries) != 2 || digest.Entries[0].Count != 5 || digest.Entries[0].Destination != "10.0.2.10:5432" {
+		t.Errorf("Unexpected digest %+v", digest)
+	}
+
+	// Empty periods send nothing; an event after an unflushed period sends
+	// that period's digest first
+	clk.Set(time.Date(2025, 10, 1, 14, 10, 0, 0, time.UTC))
+	n.Flush()
+	n.Handle(blocked("10.0.2.10", 5432))
+	clk.Set(time.Date(2025, 10, 1, 15, 5, 0, 0, time.UTC))
+	n.Handle(blocked("10.0.2.10", 5432))
+	if got := recv.take(n); len(got) != 1 || !strings.Contains(string(got[0].Body), `"total":1`) {
+		t.Fatalf("Expected the 14:00 digest, got %+v", got)
+	}
+
+	// Close sends the current period
+	n.Close()
+	if got := recv.take(n); len(got) != 1 || !strings.Contains(string(got[0].Body), `"end":"2025-10-01T15:05:00Z"`) {
+		t.Fatalf("Expected the partial 15:00 digest on close, got %+v", got)
+	}
+}
+
+func TestCommandChannel(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	n := New(Config{Channels: []Channel{{Command: []string{"sh", "-c", `{ echo "$ZTAP_NOTIFICATION"; cat; } > ` + out}}}})
+	n.Handle(blocked("10.0.2.10", 5432))
+	n.queued.Wait()
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Command did not run: %v", err)
+	}
+	kind, body, _ := strings.Cut(string(data), "\n")
+	if kind != KindEvent || !strings.Contains(body, `"destination":"10.0.2.10:5432"`) {
+		t.Errorf("Unexpected command input %q", data)
+	}
+}
+
+func TestSlowChannel(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	recv, url := newReceiver(t)
+	n := New(Config{Channels: []Channel{{URL: slow.URL}, {URL: url}}})
+
+	// A channel that does not answer delays neither the caller nor the
+	// other channels, and keeps at most queueSize deliveries pending
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < queueSize+10; i++ {
+			n.Handle(blocked("10.0.2.10", 5432))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handle blocked on a slow channel")
+	}
+	q := n.queues[0]
+	q.mu.Lock()
+	pending := len(q.pending)
+	q.mu.Unlock()
+	if pending > queueSize {
+		t.Errorf("Expected at most %d deliveries pending, got %d", queueSize, pending)
+	}
+
+	close(release)
+	if got := recv.take(n); len(got) < queueSize {
+		t.Errorf("Expected the other channel to keep receiving, got %d notifications", len(got))
+	}
+}
@@ -0,0 +1,128 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"ztap/pkg/bulk"
+)
+
+// ApplyFunc applies a single policy to a backend
+type ApplyFunc func(p NetworkPolicy) error
+
+// ApplyError aggregates per-policy failures from ApplyAll
+type ApplyError struct {
+	Failures map[string]error // keyed by policy name
+}
+
+func (e *ApplyError) Error() string {
+	names := make([]string, 0, len(e.Failures))
+	for name := range e.Failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s: %v", name, e.Failures[name]))
+	}
+	return fmt.Sprintf("%d policy(ies) failed: %s", len(names), strings.Join(parts, "; "))
+}
+
+// IsDenyAll returns true if the policy has no egress rules (default deny)
+func (p *NetworkPolicy) IsDenyAll() bool {
+	return len(p.Spec.Egress) == 0
+}
+
+// ApplyAll applies policies using a pool of up to concurrency workers, level
+// by level (see Levels) so that each policy is applied after its
+// dependencies; a policy whose dependency failed is not applied. Within a
+// level, deny-all policies are applied in a first phase that completes before
+// any allow policy starts, so a partially applied set never loosens before it
+// tightens. Failures are collected per policy and returned as *ApplyError. A
+// dependency cycle is returned as *DependencyCycleError before anything is
+// applied.
+func ApplyAll(ctx context.Context, policies []NetworkPolicy, concurrency int, apply ApplyFunc) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	levels, err := Levels(policies)
+	if err != nil {
+		return err
+	}
+
+	failures := make(map[string]error)
+	for _, level := range levels {
+		var deny, allow []NetworkPolicy
+		for _, p := range level {
+			if dep := failedDependency(p, failures); dep != "" {
+				failures[p.Metadata.Name] = fmt.Errorf("not applied: dependency %s failed", dep)
+				continue
+			}
+			if p.IsDenyAll() {
+				deny = append(deny, p)
+			} else {
+				allow = append(allow, p)
+			}
+		}
+
+		for _, phase := range [][]NetworkPolicy{deny, allow} {
+			for name, err := range applyPhase(ctx, phase, concurrency, apply) {
+				failures[name] = err
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return &ApplyError{Failures: failures}
+	}
+	return nil
+}
+
+// failedDependency returns the first dependency of p among failures, or ""
+func failedDependency(p NetworkPolicy, failures map[string]error) string {
+	for _, dep := range p.Metadata.DependsOn {
+		if _, failed := failures[dep]; failed {
+			return dep
+		}
+	}
+	return ""
+}
+
+// applyPhase runs apply over a set of policies with bounded concurrency
+func applyPhase(ctx context.Context, policies []NetworkPolicy, concurrency int, apply ApplyFunc) map[string]error {
+	return bulk.Failures(bulk.Run(ctx, policyNames(policies), concurrency, func(i int) error {
+		return apply(policies[i])
+	}))
+}
+
+// policyNames returns the names of policies, in order
+func policyNames(policies []NetworkPolicy) []string {
+	names := make([]string, len(policies))
+	for i, p := range policies {
+		names[i] = p.Metadata.Name
+	}
+	return names
+}
+
+// CompileAll compiles policies concurrently, returning results in input order.
+// Policies that fail to compile have a nil entry and are reported in the error.
+func (c *CompileCache) CompileAll(ctx context.Context, policies []NetworkPolicy, concurrency int) ([]*CompiledPolicy, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]*CompiledPolicy, len(policies))
+	failures := bulk.Failures(bulk.Run(ctx, policyNames(policies), concurrency, func(i int) error {
+		compiled, _, err := c.Compile(policies[i])
+		results[i] = compiled
+		return err
+	}))
+
+	if len(failures) == 0 {
+		return results, nil
+	}
+	return results, &ApplyError{Failures: failures}
+}
@@ -0,0 +1,157 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testPolicies(t *testing.T, allow, deny int) []NetworkPolicy {
+	t.Helper()
+	var policies []NetworkPolicy
+	for i := 0; i < allow; i++ {
+		p := mustParse(t, compileTestPolicy)
+		p.Metadata.Name = fmt.Sprintf("allow-%d", i)
+		policies = append(policies, p)
+	}
+	for i := 0; i < deny; i++ {
+		p := mustParse(t, compileTestPolicy)
+		p.Metadata.Name = fmt.Sprintf("deny-%d", i)
+		p.Spec.Egress = nil
+		policies = append(policies, p)
+	}
+	return policies
+}
+
+func TestApplyAllConcurrency(t *testing.T) {
+	policies := testPolicies(t, 20, 0)
+
+	var inFlight, maxInFlight, applied int32
+	err := ApplyAll(context.Background(), policies, 4, func(p NetworkPolicy) error {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			peak := atomic.LoadInt32(&maxInFlight)
+			if n <= peak || atomic.CompareAndSwapInt32(&maxInFlight, peak, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+		atomic.AddInt32(&applied, 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ApplyAll failed: %v", err)
+	}
+
+	if applied != 20 {
+		t.Errorf("Expected 20 policies applied, got %d", applied)
+	}
+	if maxInFlight > 4 {
+		t.Errorf("Expected at most 4 concurrent applies, got %d", maxInFlight)
+	}
+}
+
+func TestApplyAllDenyBeforeAllow(t *testing.T) {
+	policies := testPolicies(t, 5, 3)
+
+	var mu sync.Mutex
+	var order []string
+	err := ApplyAll(context.Background(), policies, 8, func(p NetworkPolicy) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, p.Metadata.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ApplyAll failed: %v", err)
+	}
+
+	for i, name := range order {
+		isDeny := name[:4] == "deny"
+		if i < 3 && !isDeny {
+			t.Fatalf("Expected deny policies first, got order %v", order)
+		}
+		if i >= 3 && isDeny {
+			t.Fatalf("Expected allow policies after deny, got order %v", order)
+		}
+	}
+}
+
+func TestApplyAllErrorAggregation(t *testing.T) {
+	policies := testPolicies(t, 4, 0)
+
+	err := ApplyAll(context.Background(), policies, 2, func(p NetworkPolicy) error {
+		if p.Metadata.Name == "allow-1" || p.Metadata.Name == "allow-3" {
+			return errors.New("backend failure")
+		}
+		return nil
+	})
+
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("Expected *ApplyError, got %v", err)
+	}
+	if len(applyErr.Failures) != 2 {
+		t.Fatalf("Expected 2 failures, got %d", len(applyErr.Failures))
+	}
+	if _, ok := applyErr.Failures["allow-1"]; !ok {
+		t.Error("Expected allow-1 in failures")
+	}
+	if _, ok := applyErr.Failures["allow-3"]; !ok {
+		t.Error("Expected allow-3 in failures")
+	}
+}
+
+func TestApplyAllCancelled(t *testing.T) {
+	policies := testPolicies(t, 3, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var applied int32
+	err := ApplyAll(ctx, policies, 2, func(p NetworkPolicy) error {
+		atomic.AddInt32(&applied, 1)
+		return nil
+	})
+
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) || len(applyErr.Failures) != 3 {
+		t.Fatalf("Expected all 3 policies to fail with context error, got %v", err)
+	}
+	if applied != 0 {
+		t.Errorf("Expected no policies applied after cancel, got %d", applied)
+	}
+}
+
+func TestCompileAll(t *testing.T) {
+	policies := testPolicies(t, 3, 1)
+	cache := NewCompileCache(NewPolicyResolver(&mockDiscovery{
+		services: map[string][]string{"app=db": {"10.0.2.1"}},
+	}))
+
+	results, err := cache.CompileAll(context.Background(), policies, 4)
+	if err != nil {
+		t.Fatalf("CompileAll failed: %v", err)
+	}
+	for i, compiled := range results {
+		if compiled == nil || compiled.Name != policies[i].Metadata.Name {
+			t.Fatalf("Result %d out of order or missing: %+v", i, compiled)
+		}
+	}
+
+	// Unresolvable selectors are reported per policy
+	failing := NewCompileCache(NewPolicyResolver(&mockDiscovery{services: map[string][]string{}}))
+	results, err = failing.CompileAll(context.Background(), policies, 4)
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) || len(applyErr.Failures) != 3 {
+		t.Fatalf("Expected 3 compile failures, got %v", err)
+	}
+	if results[3] == nil {
+		t.Error("Expected deny-all policy to compile without resolution")
+	}
+}
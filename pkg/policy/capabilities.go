@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"net"
+	"sort"
+)
+
+// Enforcement capabilities a policy may depend on
+const (
+	CapabilityIPv4           = "ipv4"            // IPv4 ipBlock destinations and sources
+	CapabilityIPv6           = "ipv6"            // IPv6 ipBlock destinations and sources
+	CapabilityLabelSelectors = "label-selectors" // podSelector destinations and sources, enforced as compiled host rules
+	CapabilityIngress        = "ingress"         // Ingress rules
+)
+
+// RequiredCapabilities returns the sorted enforcement capabilities a backend
+// needs to enforce the policy
+func (p *NetworkPolicy) RequiredCapabilities() []string {
+	required := make(map[string]bool)
+	peer := func(matchLabels map[string]string, cidr string) {
+		if len(matchLabels) > 0 {
+			required[CapabilityLabelSelectors] = true
+		}
+		if cidr != "" {
+			ip, _, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return
+			}
+			if ip.To4() != nil {
+				required[CapabilityIPv4] = true
+			} else {
+				required[CapabilityIPv6] = true
+			}
+		}
+	}
+	for _, egress := range p.Spec.Egress {
+		peer(egress.To.PodSelector.MatchLabels, egress.To.IPBlock.CIDR)
+	}
+	for _, ingress := range p.Spec.Ingress {
+		required[CapabilityIngress] = true
+		peer(ingress.From.PodSelector.MatchLabels, ingress.From.IPBlock.CIDR)
+	}
+
+	capabilities := make([]string, 0, len(required))
+	for capability := range required {
+		capabilities = append(capabilities, capability)
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
+
+// RequiredCapabilities returns the sorted address-family capabilities the
+// compiled rules need. Selectors resolved to IPv6 endpoints require IPv6 even
+// though the source policy declares no IPv6 ipBlock.
+func (c *CompiledPolicy) RequiredCapabilities() []string {
+	required := make(map[string]bool)
+	for _, rule := range c.Rules {
+		ip, _, err := net.ParseCIDR(rule.CIDR)
+		if err != nil {
+			continue
+		}
+		if ip.To4() != nil {
+			required[CapabilityIPv4] = true
+		} else {
+			required[CapabilityIPv6] = true
+		}
+	}
+
+	capabilities := make([]string, 0, len(required))
+	for capability := range required {
+		capabilities = append(capabilities, capability)
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
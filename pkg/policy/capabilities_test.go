@@ -0,0 +1,42 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRequiredCapabilities(t *testing.T) {
+	p := mustParse(t, compileTestPolicy)
+
+	expected := []string{CapabilityIPv4, CapabilityLabelSelectors}
+	if got := p.RequiredCapabilities(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	p.Spec.Egress = p.Spec.Egress[1:]
+	p.Spec.Egress[0].To.IPBlock.CIDR = "2001:db8::/32"
+	expected = []string{CapabilityIPv6}
+	if got := p.RequiredCapabilities(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	p.Spec.Egress = nil
+	if got := p.RequiredCapabilities(); len(got) != 0 {
+		t.Errorf("Expected no capabilities for deny-all policy, got %v", got)
+	}
+}
+
+func TestCompiledRequiredCapabilities(t *testing.T) {
+	compiled := &CompiledPolicy{Rules: []Rule{
+		{CIDR: "10.0.0.0/8", Protocol: "TCP", Port: 443},
+		{CIDR: "2001:db8::1/128", Protocol: "TCP", Port: 5432}, // Resolved selector endpoint
+	}}
+
+	expected := []string{CapabilityIPv4, CapabilityIPv6}
+	if got := compiled.RequiredCapabilities(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if got := (&CompiledPolicy{}).RequiredCapabilities(); len(got) != 0 {
+		t.Errorf("Expected no capabilities without rules, got %v", got)
+	}
+}
@@ -0,0 +1,85 @@
+package policy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// OverrideAnnotation, set to "true", marks a policy that intentionally
+// replaces a different policy of the same name from an earlier source
+const OverrideAnnotation = "ztap.io/override"
+
+// Source is a set of policies and where they were loaded from, e.g. a file
+// or a stored policy document
+type Source struct {
+	Name     string
+	Policies []NetworkPolicy
+}
+
+// CollisionError reports two policies sharing a name with different content
+type CollisionError struct {
+	Policy string
+	First  string // Source of the earlier policy
+	Second string // Source of the later policy
+}
+
+func (e *CollisionError) Error() string {
+	return fmt.Sprintf("policy '%s' is defined by both %s and %s with different content; annotate the one from %s with %s: \"true\" if it should replace the other",
+		e.Policy, e.First, e.Second, e.Second, OverrideAnnotation)
+}
+
+// Merge combines the policies of sources, in order, by name. A policy
+// identical to an earlier one of the same name is dropped. One that differs
+// replaces it if annotated with OverrideAnnotation, and is reported with a
+// *CollisionError otherwise, so the last source does not silently win.
+// Every collision is reported.
+func Merge(sources ...Source) ([]NetworkPolicy, error) {
+	var (
+		merged []NetworkPolicy
+		errs   []error
+	)
+	index := make(map[string]int)      // Position in merged, by name
+	origins := make(map[string]string) // Source of the merged policy, by name
+	for _, source := range sources {
+		for _, p := range source.Policies {
+			name := p.Metadata.Name
+			i, seen := index[name]
+			switch {
+			case !seen:
+				index[name] = len(merged)
+				origins[name] = source.Name
+				merged = append(merged, p)
+			case sameContent(merged[i], p):
+			case p.Metadata.Annotations[OverrideAnnotation] == "true":
+				merged[i] = p
+				origins[name] = source.Name
+			default:
+				errs = append(errs, &CollisionError{Policy: name, First: origins[name], Second: source.Name})
+			}
+		}
+	}
+	return merged, errors.Join(errs...)
+}
+
+// sameContent reports whether two policies render identically, endpoint
+// groups included
+func sameContent(a, b NetworkPolicy) bool {
+	dataA, errA := Marshal([]NetworkPolicy{a})
+	dataB, errB := Marshal([]NetworkPolicy{b})
+	return errA == nil && errB == nil && bytes.Equal(dataA, dataB)
+}
+
+// LoadFromFiles reads the policies of several YAML files, merged in order
+// (see Merge). Collisions name the files.
+func LoadFromFiles(filenames ...string) ([]NetworkPolicy, error) {
+	sources := make([]Source, len(filenames))
+	for i, filename := range filenames {
+		policies, err := LoadFromFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		sources[i] = Source{Name: filename, Policies: policies}
+	}
+	return Merge(sources...)
+}
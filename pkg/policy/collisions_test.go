@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const webToDB = `apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/24
+      ports:
+        - protocol: TCP
+          port: 5432
+`
+
+func TestMerge(t *testing.T) {
+	first, err := Parse([]byte(webToDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := Parse([]byte(strings.Replace(webToDB, "5432", "5433", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := Merge(Source{Name: "a.yaml", Policies: first}, Source{Name: "b.yaml", Policies: first})
+	if err != nil || len(merged) != 1 {
+		t.Fatalf("Expected identical policies to merge, got %d, %v", len(merged), err)
+	}
+
+	_, err = Merge(Source{Name: "a.yaml", Policies: first}, Source{Name: "b.yaml", Policies: changed})
+	var collision *CollisionError
+	if !errors.As(err, &collision) || collision.Policy != "web-to-db" || collision.First != "a.yaml" || collision.Second != "b.yaml" {
+		t.Fatalf("Expected a collision between a.yaml and b.yaml, got %v", err)
+	}
+
+	changed[0].Metadata.Annotations = map[string]string{OverrideAnnotation: "true"}
+	merged, err = Merge(Source{Name: "a.yaml", Policies: first}, Source{Name: "b.yaml", Policies: changed})
+	if err != nil || len(merged) != 1 || merged[0].Spec.Egress[0].Ports[0].Port != 5433 {
+		t.Errorf("Expected the override to replace the policy, got %+v, %v", merged, err)
+	}
+}
+
+func TestParseDuplicateDocuments(t *testing.T) {
+	_, err := Parse([]byte(webToDB + "---\n" + strings.Replace(webToDB, "5432", "5433", 1)))
+	if err == nil || !strings.Contains(err.Error(), "policy document 1 and policy document 2") {
+		t.Errorf("Expected duplicate documents to collide, got %v", err)
+	}
+}
+
+func TestLoadFromFiles(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")
+	os.WriteFile(a, []byte(webToDB), 0644)
+	os.WriteFile(b, []byte(strings.Replace(webToDB, "5432", "5433", 1)), 0644)
+
+	if _, err := LoadFromFiles(a, b); err == nil || !strings.Contains(err.Error(), a) || !strings.Contains(err.Error(), b) {
+		t.Errorf("Expected the collision to name both files, got %v", err)
+	}
+}
@@ -0,0 +1,442 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rule directions
+const (
+	DirectionEgress  = "egress"  // CIDR is the destination, Port its port
+	DirectionIngress = "ingress" // CIDR is the source, Port the local port
+)
+
+// Rule is a single concrete allow rule produced by compiling a policy
+type Rule struct {
+	Policy    string `json:"policy"`
+	CIDR      string `json:"cidr"`
+	Protocol  string `json:"protocol"`
+	Port      int    `json:"port"`
+	EndPort   int    `json:"end_port,omitempty"`  // Last port of a range; 0 for a single port
+	Direction string `json:"direction,omitempty"` // DirectionEgress if empty
+}
+
+// Ingress reports whether the rule allows inbound traffic
+func (r Rule) Ingress() bool {
+	return r.Direction == DirectionIngress
+}
+
+// LastPort returns the last port the rule covers: EndPort for a range, Port
+// otherwise
+func (r Rule) LastPort() int {
+	return max(r.Port, r.EndPort)
+}
+
+// CoversPort reports whether port is in the rule's range
+func (r Rule) CoversPort(port int) bool {
+	return port >= r.Port && port <= r.LastPort()
+}
+
+// PortRange returns the rule's ports, e.g. "443" or "8000-8080"
+func (r Rule) PortRange() string {
+	return PortRange(r.Port, r.EndPort)
+}
+
+// Ports returns the number of ports the rule covers, the entries it takes
+// in a backend matching exact ports such as the eBPF policy map
+func (r Rule) Ports() int {
+	return r.LastPort() - r.Port + 1
+}
+
+// CompiledPolicy is a policy with every selector resolved to concrete rules
+type CompiledPolicy struct {
+	Name      string `json:"name"`
+	Hash      string `json:"hash"`
+	Rules     []Rule `json:"rules"`
+	Endpoints int    `json:"endpoints"` // IPs resolved from podSelectors, and endpoint group members
+}
+
+// Entries returns the number of exact-port entries the policy's rules
+// expand to, one per port of each rule's range. Capacity checks count these
+// rather than the rules.
+func (c *CompiledPolicy) Entries() int {
+	entries := 0
+	for _, rule := range c.Rules {
+		entries += rule.Ports()
+	}
+	return entries
+}
+
+// Compile resolves label selectors and expands a policy into concrete rules
+func (r *PolicyResolver) Compile(p NetworkPolicy) (*CompiledPolicy, error) {
+	endpoints, err := r.resolveEndpoints(p)
+	if err != nil {
+		return nil, err
+	}
+	kept, dropped, err := r.applyConditions(p, endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := compileHash(p, kept, dropped)
+	if err != nil {
+		return nil, err
+	}
+
+	return compile(p, kept, dropped, hash), nil
+}
+
+// resolveEndpoints resolves the podSelector or endpoint group of every egress
+// rule, indexed by rule position, followed by the podSelector of every
+// ingress rule. IP block rules have a nil entry.
+func (r *PolicyResolver) resolveEndpoints(p NetworkPolicy) ([][]string, error) {
+	endpoints := make([][]string, len(p.Spec.Egress)+len(p.Spec.Ingress))
+	for i := range endpoints {
+		ips, err := r.resolvePeer(p, i)
+		if err != nil {
+			return nil, err
+		}
+		endpoints[i] = ips
+	}
+	return endpoints, nil
+}
+
+// resolvePeer resolves the peer of the i-th rule of p, counting egress rules
+// then ingress rules, to sorted IPs, or nil for an IP block
+func (r *PolicyResolver) resolvePeer(p NetworkPolicy, i int) ([]string, error) {
+	var selector map[string]string
+	var field string
+	if i < len(p.Spec.Egress) {
+		egress := p.Spec.Egress[i]
+		if egress.To.Group != "" {
+			g, ok := p.Groups[egress.To.Group]
+			if !ok {
+				return nil, fmt.Errorf("policy '%s': spec.egress[%d].to.group: unknown endpoint group %q",
+					p.Metadata.Name, i, egress.To.Group)
+			}
+			members, err := r.ResolveGroup(g)
+			if err != nil {
+				return nil, fmt.Errorf("policy '%s': failed to resolve endpoint group %s: %w",
+					p.Metadata.Name, g.Name, err)
+			}
+			return members, nil
+		}
+		selector, field = egress.To.PodSelector.MatchLabels, fmt.Sprintf("spec.egress[%d].to.podSelector", i)
+	} else {
+		j := i - len(p.Spec.Egress)
+		selector, field = p.Spec.Ingress[j].From.PodSelector.MatchLabels, fmt.Sprintf("spec.ingress[%d].from.podSelector", j)
+	}
+	if len(selector) == 0 {
+		return nil, nil
+	}
+
+	ips, err := r.ResolveLabels(selector)
+	if err != nil {
+		return nil, fmt.Errorf("policy '%s': failed to resolve %s: %w", p.Metadata.Name, field, err)
+	}
+	sorted := append([]string(nil), ips...)
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// applyConditions evaluates the condition of every egress rule against its
+// destinations, at the resolver's current time. It returns the endpoints the
+// conditions keep, and which rules are dropped whole: ipBlock rules whose
+// condition rejects their CIDR.
+func (r *PolicyResolver) applyConditions(p NetworkPolicy, endpoints [][]string) (kept [][]string, dropped []bool, err error) {
+	kept = make([][]string, len(endpoints))
+	copy(kept[len(p.Spec.Egress):], endpoints[len(p.Spec.Egress):])
+	dropped = make([]bool, len(endpoints))
+	now := r.clock.Now()
+	for i, egress := range p.Spec.Egress {
+		if egress.When == "" {
+			kept[i] = endpoints[i]
+			continue
+		}
+		cond, err := ParseCondition(egress.When)
+		if err != nil {
+			return nil, nil, fmt.Errorf("policy '%s': spec.egress[%d].when: %w", p.Metadata.Name, i, err)
+		}
+		meets := func(ip string) (bool, error) {
+			labels, _ := r.LabelsOf(ip)
+			ok, err := cond.Eval(ConditionEnv{IP: ip, Labels: labels, Time: now})
+			if err != nil {
+				return false, fmt.Errorf("policy '%s': spec.egress[%d].when: %s: %w", p.Metadata.Name, i, ip, err)
+			}
+			return ok, nil
+		}
+
+		if cidr := egress.To.IPBlock.CIDR; cidr != "" {
+			ok, err := meets(cidr)
+			if err != nil {
+				return nil, nil, err
+			}
+			dropped[i] = !ok
+		}
+		for _, ip := range endpoints[i] {
+			ok, err := meets(ip)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				kept[i] = append(kept[i], ip)
+			}
+		}
+	}
+	return kept, dropped, nil
+}
+
+// compileHash fingerprints the policy content together with its resolved
+// endpoints and the rules conditions dropped
+func compileHash(p NetworkPolicy, endpoints [][]string, dropped []bool) (string, error) {
+	content, err := yaml.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal policy '%s': %w", p.Metadata.Name, err)
+	}
+
+	h := sha256.New()
+	h.Write(content)
+	for i, ips := range endpoints {
+		fmt.Fprintf(h, "\n%d:%s", i, strings.Join(ips, ","))
+		if dropped[i] {
+			h.Write([]byte("!"))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// compile expands egress rules into one Rule per destination and port,
+// skipping dropped rules, then ingress rules into one Rule per source and
+// port. Endpoints are IPs or, for endpoint group CIDRs, networks.
+func compile(p NetworkPolicy, endpoints [][]string, dropped []bool, hash string) *CompiledPolicy {
+	compiled := &CompiledPolicy{
+		Name: p.Metadata.Name,
+		Hash: hash,
+	}
+
+	for i, egress := range p.Spec.Egress {
+		var cidrs []string
+		if egress.To.IPBlock.CIDR != "" && !dropped[i] {
+			cidrs = append(cidrs, egress.To.IPBlock.CIDR)
+		}
+		for _, ip := range endpoints[i] {
+			cidrs = append(cidrs, hostCIDR(ip))
+		}
+		compiled.Endpoints += len(endpoints[i])
+
+		for _, cidr := range cidrs {
+			for _, port := range egress.Ports {
+				compiled.Rules = append(compiled.Rules, Rule{
+					Policy:   p.Metadata.Name,
+					CIDR:     cidr,
+					Protocol: port.Protocol,
+					Port:     port.Port,
+					EndPort:  port.rangeEnd(),
+				})
+			}
+		}
+	}
+
+	for i, ingress := range p.Spec.Ingress {
+		sources := endpoints[len(p.Spec.Egress)+i]
+		var cidrs []string
+		if ingress.From.IPBlock.CIDR != "" {
+			cidrs = append(cidrs, ingress.From.IPBlock.CIDR)
+		}
+		for _, ip := range sources {
+			cidrs = append(cidrs, hostCIDR(ip))
+		}
+		compiled.Endpoints += len(sources)
+
+		for _, cidr := range cidrs {
+			for _, port := range ingress.Ports {
+				compiled.Rules = append(compiled.Rules, Rule{
+					Policy:    p.Metadata.Name,
+					CIDR:      cidr,
+					Protocol:  port.Protocol,
+					Port:      port.Port,
+					EndPort:   port.rangeEnd(),
+					Direction: DirectionIngress,
+				})
+			}
+		}
+	}
+
+	return compiled
+}
+
+// DiffRules returns the rules in next that are not in previous and the rules
+// in previous that are not in next
+func DiffRules(previous, next []Rule) (added, removed []Rule) {
+	before := make(map[Rule]bool, len(previous))
+	for _, r := range previous {
+		before[r] = true
+	}
+	after := make(map[Rule]bool, len(next))
+	for _, r := range next {
+		after[r] = true
+		if !before[r] {
+			added = append(added, r)
+		}
+	}
+	for _, r := range previous {
+		if !after[r] {
+			removed = append(removed, r)
+		}
+	}
+	return added, removed
+}
+
+// hostCIDR converts a single IP address to a host CIDR (/32 or /128). Anything
+// else, e.g. a CIDR, is returned unchanged.
+func hostCIDR(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if parsed.To4() != nil {
+		return parsed.String() + "/32"
+	}
+	return parsed.String() + "/128"
+}
+
+// CacheStats reports compile cache effectiveness
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+// CompileCache skips recompilation of policies whose content and resolved
+// endpoints have not changed since the last compile. It also keeps the
+// current members of the endpoint groups compiled policies reference.
+type CompileCache struct {
+	resolver      *PolicyResolver
+	entries       map[string]*CompiledPolicy
+	groups        map[string][]string // Members of each endpoint group, as last resolved
+	hits          uint64
+	misses        uint64
+	onLookup      func(hit bool)
+	onGroupChange func(group string, added, removed []string)
+	mu            sync.Mutex
+}
+
+// NewCompileCache creates a compile cache backed by the given resolver
+func NewCompileCache(resolver *PolicyResolver) *CompileCache {
+	return &CompileCache{
+		resolver: resolver,
+		entries:  make(map[string]*CompiledPolicy),
+		groups:   make(map[string][]string),
+	}
+}
+
+// Compile returns the compiled policy and whether it was served from cache.
+// Selectors are always re-resolved and conditions re-evaluated, so endpoint
+// churn and conditions changing with the time invalidate the entry.
+func (c *CompileCache) Compile(p NetworkPolicy) (*CompiledPolicy, bool, error) {
+	endpoints, err := c.resolver.resolveEndpoints(p)
+	if err != nil {
+		return nil, false, err
+	}
+	kept, dropped, err := c.resolver.applyConditions(p, endpoints)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hash, err := compileHash(p, kept, dropped)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trackGroups(p, endpoints)
+
+	if entry, exists := c.entries[p.Metadata.Name]; exists && entry.Hash == hash {
+		c.hits++
+		c.observe(true)
+		return entry, true, nil
+	}
+
+	compiled := compile(p, kept, dropped, hash)
+	c.entries[p.Metadata.Name] = compiled
+	c.misses++
+	c.observe(false)
+	return compiled, false, nil
+}
+
+// OnLookup registers a callback invoked with the outcome of every cache lookup
+// (e.g. to export hit/miss metrics)
+func (c *CompileCache) OnLookup(fn func(hit bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onLookup = fn
+}
+
+// OnGroupChange registers a callback invoked when the members of an endpoint
+// group differ from when it was last resolved. Every policy referencing the
+// group is recompiled on its next Compile, since its hash changes with them.
+func (c *CompileCache) OnGroupChange(fn func(group string, added, removed []string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onGroupChange = fn
+}
+
+// GroupMembers returns the members of an endpoint group as last resolved,
+// or nil if no compiled policy referenced it yet
+func (c *CompileCache) GroupMembers(group string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.groups[group]...)
+}
+
+// trackGroups records the members of the groups p references, reporting
+// changes (requires holding mu lock)
+func (c *CompileCache) trackGroups(p NetworkPolicy, endpoints [][]string) {
+	for i, egress := range p.Spec.Egress {
+		if egress.To.Group == "" {
+			continue
+		}
+		previous, known := c.groups[egress.To.Group]
+		c.groups[egress.To.Group] = endpoints[i]
+		if !known || c.onGroupChange == nil {
+			continue
+		}
+		added, removed := diffStrings(previous, endpoints[i])
+		if len(added) > 0 || len(removed) > 0 {
+			c.onGroupChange(egress.To.Group, added, removed)
+		}
+	}
+}
+
+// observe reports a lookup outcome (requires holding mu lock)
+func (c *CompileCache) observe(hit bool) {
+	if c.onLookup != nil {
+		c.onLookup(hit)
+	}
+}
+
+// Invalidate drops the cached entry for a policy
+func (c *CompileCache) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, name)
+}
+
+// Stats returns a snapshot of cache hit/miss counters
+func (c *CompileCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:    c.hits,
+		Misses:  c.misses,
+		Entries: len(c.entries),
+	}
+}
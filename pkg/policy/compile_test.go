@@ -0,0 +1,186 @@
+package policy
+
+import (
+	"testing"
+)
+
+const compileTestPolicy = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-egress
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/8
+      ports:
+        - protocol: TCP
+          port: 443
+        - protocol: UDP
+          port: 53
+`
+
+func mustParse(t *testing.T, content string) NetworkPolicy {
+	t.Helper()
+	policies, err := Parse([]byte(content))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	if len(policies) != 1 {
+		t.Fatalf("Expected 1 policy, got %d", len(policies))
+	}
+	return policies[0]
+}
+
+func TestCompile(t *testing.T) {
+	resolver := NewPolicyResolver(&mockDiscovery{
+		services: map[string][]string{
+			"app=db": {"10.0.2.2", "10.0.2.1"},
+		},
+	})
+
+	compiled, err := resolver.Compile(mustParse(t, compileTestPolicy))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if compiled.Name != "web-egress" {
+		t.Errorf("Expected name 'web-egress', got '%s'", compiled.Name)
+	}
+	if compiled.Hash == "" {
+		t.Error("Expected non-empty hash")
+	}
+	if compiled.Endpoints != 2 {
+		t.Errorf("Expected 2 resolved endpoints, got %d", compiled.Endpoints)
+	}
+
+	expected := []Rule{
+		{Policy: "web-egress", CIDR: "10.0.2.1/32", Protocol: "TCP", Port: 5432},
+		{Policy: "web-egress", CIDR: "10.0.2.2/32", Protocol: "TCP", Port: 5432},
+		{Policy: "web-egress", CIDR: "10.0.0.0/8", Protocol: "TCP", Port: 443},
+		{Policy: "web-egress", CIDR: "10.0.0.0/8", Protocol: "UDP", Port: 53},
+	}
+	if len(compiled.Rules) != len(expected) {
+		t.Fatalf("Expected %d rules, got %d: %v", len(expected), len(compiled.Rules), compiled.Rules)
+	}
+	for i, rule := range expected {
+		if compiled.Rules[i] != rule {
+			t.Errorf("Rule %d: expected %+v, got %+v", i, rule, compiled.Rules[i])
+		}
+	}
+}
+
+func TestCompileResolutionError(t *testing.T) {
+	resolver := NewPolicyResolver(&mockDiscovery{services: map[string][]string{}})
+
+	if _, err := resolver.Compile(mustParse(t, compileTestPolicy)); err == nil {
+		t.Error("Expected error when selector cannot be resolved")
+	}
+}
+
+func TestCompileCache(t *testing.T) {
+	disc := &mockDiscovery{
+		services: map[string][]string{
+			"app=db": {"10.0.2.1"},
+		},
+	}
+	cache := NewCompileCache(NewPolicyResolver(disc))
+	p := mustParse(t, compileTestPolicy)
+
+	var observed []bool
+	cache.OnLookup(func(hit bool) { observed = append(observed, hit) })
+
+	first, cached, err := cache.Compile(p)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if cached {
+		t.Error("First compile should not be served from cache")
+	}
+
+	second, cached, err := cache.Compile(p)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if !cached {
+		t.Error("Second compile of unchanged policy should be served from cache")
+	}
+	if second != first {
+		t.Error("Expected cached compile to return the same result")
+	}
+
+	// Endpoint churn must invalidate the entry
+	disc.services["app=db"] = []string{"10.0.2.1", "10.0.2.3"}
+	third, cached, err := cache.Compile(p)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if cached {
+		t.Error("Compile after endpoint change should miss the cache")
+	}
+	if third.Hash == first.Hash {
+		t.Error("Expected hash to change with resolved endpoints")
+	}
+
+	// Policy content change must invalidate the entry
+	p.Spec.Egress[1].Ports[0].Port = 8443
+	if _, cached, _ = cache.Compile(p); cached {
+		t.Error("Compile after content change should miss the cache")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Entries != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	if len(observed) != 4 || !observed[1] || observed[0] || observed[2] || observed[3] {
+		t.Errorf("Unexpected lookup observations: %v", observed)
+	}
+
+	cache.Invalidate("web-egress")
+	if _, cached, _ = cache.Compile(p); cached {
+		t.Error("Compile after Invalidate should miss the cache")
+	}
+}
+
+func TestDiffRules(t *testing.T) {
+	a := Rule{Policy: "p", CIDR: "10.0.0.1/32", Protocol: "TCP", Port: 80}
+	b := Rule{Policy: "p", CIDR: "10.0.0.2/32", Protocol: "TCP", Port: 80}
+	c := Rule{Policy: "p", CIDR: "10.0.0.3/32", Protocol: "TCP", Port: 80}
+
+	added, removed := DiffRules([]Rule{a, b}, []Rule{b, c})
+	if len(added) != 1 || added[0] != c {
+		t.Errorf("Expected %v added, got %v", c, added)
+	}
+	if len(removed) != 1 || removed[0] != a {
+		t.Errorf("Expected %v removed, got %v", a, removed)
+	}
+
+	if added, removed := DiffRules([]Rule{a}, []Rule{a}); len(added) != 0 || len(removed) != 0 {
+		t.Errorf("Expected no diff, got +%v -%v", added, removed)
+	}
+}
+
+func TestHostCIDR(t *testing.T) {
+	tests := map[string]string{
+		"10.0.0.1":    "10.0.0.1/32",
+		"2001:db8::1": "2001:db8::1/128",
+		"not-an-ip":   "not-an-ip",
+	}
+	for ip, expected := range tests {
+		if got := hostCIDR(ip); got != expected {
+			t.Errorf("hostCIDR(%s) = %s, expected %s", ip, got, expected)
+		}
+	}
+}
@@ -0,0 +1,848 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule conditions (spec.egress[].when) are expressions in a small subset of
+// CEL, for conditions label selectors cannot express:
+//
+//	when: 'size(dest.labels) > 0 && dest.labels["pci"] == "true" && time.hour() < 6'
+//
+// They are evaluated when the policy is compiled, once per destination the
+// rule resolves to, and the destinations they reject are left out. The
+// variables are:
+//
+//	dest.ip      string, the destination IP, or CIDR for ipBlock rules and
+//	             endpoint group CIDRs
+//	dest.labels  map of the destination's labels as known to service
+//	             discovery; empty for CIDRs and unregistered IPs. A missing
+//	             label reads as "".
+//	time         the compile time in UTC: time.hour(), time.minute() and
+//	             time.weekday() (0 is Sunday)
+//
+// Operators are ! && || == != < <= > >= and in (a key of a map or an
+// element of a list); functions are size(map, string or list) and the
+// string methods startsWith, endsWith and contains. Literals are integers,
+// quoted strings, true, false and lists. Expressions are type-checked when
+// the policy is validated. They cannot loop, call out or change anything,
+// and are limited in length and nesting, so evaluating one is cheap and
+// bounded.
+const (
+	maxConditionLength = 1024
+	maxConditionDepth  = 32
+)
+
+// Condition is a parsed rule condition
+type Condition struct {
+	source string
+	root   condNode
+}
+
+// ConditionEnv is what a condition is evaluated against
+type ConditionEnv struct {
+	IP     string
+	Labels map[string]string
+	Time   time.Time
+}
+
+// ParseCondition parses and type-checks a rule condition, which must be a
+// boolean expression
+func ParseCondition(source string) (*Condition, error) {
+	if len(source) > maxConditionLength {
+		return nil, fmt.Errorf("condition is longer than %d characters", maxConditionLength)
+	}
+	tokens, err := lexCondition(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &condParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+	}
+	t, err := root.check()
+	if err != nil {
+		return nil, err
+	}
+	if t != typeBool {
+		return nil, fmt.Errorf("condition must be a boolean expression, not %s", t)
+	}
+	return &Condition{source: source, root: root}, nil
+}
+
+// String returns the condition's source
+func (c *Condition) String() string {
+	return c.source
+}
+
+// Eval evaluates the condition against env
+func (c *Condition) Eval(env ConditionEnv) (bool, error) {
+	v, err := c.root.eval(&env)
+	if err != nil {
+		return false, err
+	}
+	return v.(bool), nil
+}
+
+// condType is the static type of an expression
+type condType int
+
+const (
+	typeBool condType = iota
+	typeInt
+	typeString
+	typeMap        // map(string, string), i.e. labels
+	typeStringList // list(string)
+	typeIntList    // list(int)
+	typeDest
+	typeTime
+)
+
+func (t condType) String() string {
+	return [...]string{"bool", "int", "string", "map", "list(string)", "list(int)", "dest", "time"}[t]
+}
+
+// elem returns the element type of a list type
+func (t condType) elem() condType {
+	if t == typeIntList {
+		return typeInt
+	}
+	return typeString
+}
+
+// Tokens
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokString
+	tokOp // Operators and punctuation
+)
+
+type condToken struct {
+	kind tokenKind
+	text string // Identifier, operator, or unquoted string
+	num  int64
+	pos  int
+}
+
+func (t condToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of condition"
+	case tokString:
+		return strconv.Quote(t.text)
+	case tokInt:
+		return strconv.FormatInt(t.num, 10)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// condOperators are matched longest first
+var condOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ".", ","}
+
+func lexCondition(s string) ([]condToken, error) {
+	var tokens []condToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, condToken{kind: tokString, text: b.String(), pos: i})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			n, err := strconv.ParseInt(s[i:j], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number at offset %d", i)
+			}
+			tokens = append(tokens, condToken{kind: tokInt, num: n, pos: i})
+			i = j
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] >= 'a' && s[j] <= 'z' || s[j] >= 'A' && s[j] <= 'Z' || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, condToken{kind: tokIdent, text: s[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range condOperators {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			tokens = append(tokens, condToken{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, condToken{kind: tokEOF, pos: len(s)}), nil
+}
+
+// Parser, by precedence: || then && then comparisons and in, then unary !,
+// then member access, calls and indexing
+
+type condParser struct {
+	tokens []condToken
+	pos    int
+	depth  int
+}
+
+func (p *condParser) peek() condToken {
+	return p.tokens[p.pos]
+}
+
+func (p *condParser) next() condToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the operator or keyword op
+func (p *condParser) accept(op string) bool {
+	if tok := p.peek(); (tok.kind == tokOp || tok.kind == tokIdent) && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *condParser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q, found %s at offset %d", op, tok, tok.pos)
+	}
+	return nil
+}
+
+// enter bounds the nesting of the expression
+func (p *condParser) enter() error {
+	p.depth++
+	if p.depth > maxConditionDepth {
+		return fmt.Errorf("condition is nested more than %d levels deep", maxConditionDepth)
+	}
+	return nil
+}
+
+func (p *condParser) parseOr() (condNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *condParser) parseAnd() (condNode, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *condParser) parseComparison() (condNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">", "in"} {
+		if p.accept(op) {
+			right, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &compareNode{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *condParser) parseUnary() (condNode, error) {
+	if p.accept("!") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *condParser) parsePostfix() (condNode, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			tok := p.next()
+			if tok.kind != tokIdent {
+				return nil, fmt.Errorf("expected a field or method name, found %s at offset %d", tok, tok.pos)
+			}
+			if p.accept("(") {
+				args, err := p.parseArgs()
+				if err != nil {
+					return nil, err
+				}
+				n = &callNode{name: tok.text, target: n, args: args}
+			} else {
+				n = &fieldNode{target: n, name: tok.text}
+			}
+		case p.accept("["):
+			index, err := p.parseNested()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{target: n, index: index}
+		default:
+			return n, nil
+		}
+	}
+}
+
+// parseNested parses a parenthesized, indexing or argument expression
+func (p *condParser) parseNested() (condNode, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	return p.parseOr()
+}
+
+// parseArgs parses call arguments after "("
+func (p *condParser) parseArgs() ([]condNode, error) {
+	var args []condNode
+	if p.accept(")") {
+		return args, nil
+	}
+	for {
+		arg, err := p.parseNested()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.accept(")") {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *condParser) parsePrimary() (condNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokInt:
+		return &literalNode{value: tok.num, typ: typeInt}, nil
+	case tokString:
+		return &literalNode{value: tok.text, typ: typeString}, nil
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			return &literalNode{value: tok.text == "true", typ: typeBool}, nil
+		case "dest":
+			return &varNode{typ: typeDest}, nil
+		case "time":
+			return &varNode{typ: typeTime}, nil
+		}
+		if p.accept("(") {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return &callNode{name: tok.text, args: args}, nil
+		}
+		return nil, fmt.Errorf("unknown variable %q at offset %d (use dest or time)", tok.text, tok.pos)
+	case tokOp:
+		switch tok.text {
+		case "(":
+			n, err := p.parseNested()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			list := &listNode{}
+			if p.accept("]") {
+				return list, nil
+			}
+			for {
+				elem, err := p.parseNested()
+				if err != nil {
+					return nil, err
+				}
+				list.elems = append(list.elems, elem)
+				if p.accept("]") {
+					return list, nil
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", tok, tok.pos)
+}
+
+// Nodes
+
+type condNode interface {
+	check() (condType, error)
+	eval(env *ConditionEnv) (any, error)
+}
+
+type literalNode struct {
+	value any
+	typ   condType
+}
+
+func (n *literalNode) check() (condType, error)        { return n.typ, nil }
+func (n *literalNode) eval(*ConditionEnv) (any, error) { return n.value, nil }
+
+// varNode is dest or time; both evaluate to the environment
+type varNode struct {
+	typ condType
+}
+
+func (n *varNode) check() (condType, error)            { return n.typ, nil }
+func (n *varNode) eval(env *ConditionEnv) (any, error) { return env, nil }
+
+type listNode struct {
+	elems []condNode
+	typ   condType
+}
+
+func (n *listNode) check() (condType, error) {
+	n.typ = typeStringList
+	for i, elem := range n.elems {
+		t, err := elem.check()
+		if err != nil {
+			return 0, err
+		}
+		if t != typeString && t != typeInt {
+			return 0, fmt.Errorf("list elements must be strings or integers, not %s", t)
+		}
+		if i == 0 && t == typeInt {
+			n.typ = typeIntList
+		}
+		if t != n.typ.elem() {
+			return 0, fmt.Errorf("list mixes %s and %s elements", n.typ.elem(), t)
+		}
+	}
+	return n.typ, nil
+}
+
+func (n *listNode) eval(env *ConditionEnv) (any, error) {
+	return evalList(n.elems, env)
+}
+
+// evalList evaluates elems in order
+func evalList(elems []condNode, env *ConditionEnv) ([]any, error) {
+	values := make([]any, len(elems))
+	for i, elem := range elems {
+		v, err := elem.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+type notNode struct {
+	operand condNode
+}
+
+func (n *notNode) check() (condType, error) {
+	t, err := n.operand.check()
+	if err != nil {
+		return 0, err
+	}
+	if t != typeBool {
+		return 0, fmt.Errorf("! needs a bool, not %s", t)
+	}
+	return typeBool, nil
+}
+
+func (n *notNode) eval(env *ConditionEnv) (any, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !v.(bool), nil
+}
+
+type logicalNode struct {
+	op          string
+	left, right condNode
+}
+
+func (n *logicalNode) check() (condType, error) {
+	for _, operand := range []condNode{n.left, n.right} {
+		t, err := operand.check()
+		if err != nil {
+			return 0, err
+		}
+		if t != typeBool {
+			return 0, fmt.Errorf("%s needs bools, not %s", n.op, t)
+		}
+	}
+	return typeBool, nil
+}
+
+// eval short-circuits: the right operand is not evaluated when the left
+// decides the result
+func (n *logicalNode) eval(env *ConditionEnv) (any, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if left.(bool) == (n.op == "||") {
+		return left, nil
+	}
+	return n.right.eval(env)
+}
+
+type compareNode struct {
+	op          string
+	left, right condNode
+}
+
+func (n *compareNode) check() (condType, error) {
+	left, err := n.left.check()
+	if err != nil {
+		return 0, err
+	}
+	right, err := n.right.check()
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case "in":
+		switch {
+		case right == typeMap && left == typeString,
+			(right == typeStringList || right == typeIntList) && left == right.elem():
+			return typeBool, nil
+		}
+		return 0, fmt.Errorf("cannot test whether %s is in %s", left, right)
+	case "==", "!=":
+		if left != right || left == typeDest || left == typeTime {
+			return 0, fmt.Errorf("cannot compare %s %s %s", left, n.op, right)
+		}
+	default:
+		if left != right || left != typeInt && left != typeString {
+			return 0, fmt.Errorf("cannot order %s %s %s", left, n.op, right)
+		}
+	}
+	return typeBool, nil
+}
+
+func (n *compareNode) eval(env *ConditionEnv) (any, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "in":
+		if m, ok := right.(map[string]string); ok {
+			_, found := m[left.(string)]
+			return found, nil
+		}
+		for _, elem := range right.([]any) {
+			if equalValues(left, elem) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "==":
+		return equalValues(left, right), nil
+	case "!=":
+		return !equalValues(left, right), nil
+	}
+
+	var cmp int
+	switch l := left.(type) {
+	case int64:
+		cmp = compareInts(l, right.(int64))
+	case string:
+		cmp = strings.Compare(l, right.(string))
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// equalValues compares two values of the same type
+func equalValues(a, b any) bool {
+	switch a := a.(type) {
+	case map[string]string:
+		b := b.(map[string]string)
+		if len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := b[k]; !ok || w != v {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b := b.([]any)
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equalValues(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// fieldNode is dest.ip or dest.labels
+type fieldNode struct {
+	target condNode
+	name   string
+}
+
+func (n *fieldNode) check() (condType, error) {
+	t, err := n.target.check()
+	if err != nil {
+		return 0, err
+	}
+	if t != typeDest {
+		return 0, fmt.Errorf("%s has no field %s", t, n.name)
+	}
+	switch n.name {
+	case "ip":
+		return typeString, nil
+	case "labels":
+		return typeMap, nil
+	}
+	return 0, fmt.Errorf("dest has no field %s (use ip or labels)", n.name)
+}
+
+func (n *fieldNode) eval(env *ConditionEnv) (any, error) {
+	if n.name == "ip" {
+		return env.IP, nil
+	}
+	if env.Labels == nil {
+		return map[string]string{}, nil
+	}
+	return env.Labels, nil
+}
+
+type indexNode struct {
+	target, index condNode
+}
+
+func (n *indexNode) check() (condType, error) {
+	target, err := n.target.check()
+	if err != nil {
+		return 0, err
+	}
+	index, err := n.index.check()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case target == typeMap && index == typeString:
+		return typeString, nil
+	case (target == typeStringList || target == typeIntList) && index == typeInt:
+		return target.elem(), nil
+	}
+	return 0, fmt.Errorf("cannot index %s with %s", target, index)
+}
+
+func (n *indexNode) eval(env *ConditionEnv) (any, error) {
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := target.(map[string]string); ok {
+		return m[index.(string)], nil
+	}
+	list, i := target.([]any), index.(int64)
+	if i < 0 || i >= int64(len(list)) {
+		return nil, fmt.Errorf("index %d out of range of a list of %d", i, len(list))
+	}
+	return list[i], nil
+}
+
+// callNode is a function call, or a method call if target is set
+type callNode struct {
+	name   string
+	target condNode
+	args   []condNode
+}
+
+func (n *callNode) check() (condType, error) {
+	var target condType
+	if n.target != nil {
+		var err error
+		if target, err = n.target.check(); err != nil {
+			return 0, err
+		}
+	}
+	args := make([]condType, len(n.args))
+	for i, arg := range n.args {
+		t, err := arg.check()
+		if err != nil {
+			return 0, err
+		}
+		args[i] = t
+	}
+
+	switch {
+	case n.target == nil && n.name == "size":
+		if len(args) == 1 && (args[0] == typeMap || args[0] == typeString || args[0] == typeStringList || args[0] == typeIntList) {
+			return typeInt, nil
+		}
+		return 0, fmt.Errorf("size takes one map, string or list")
+	case n.target == nil:
+		return 0, fmt.Errorf("unknown function %s (use size)", n.name)
+	case target == typeTime:
+		switch n.name {
+		case "hour", "minute", "weekday":
+			if len(args) != 0 {
+				return 0, fmt.Errorf("time.%s takes no arguments", n.name)
+			}
+			return typeInt, nil
+		}
+		return 0, fmt.Errorf("time has no method %s (use hour, minute or weekday)", n.name)
+	case target == typeString:
+		switch n.name {
+		case "startsWith", "endsWith", "contains":
+			if len(args) != 1 || args[0] != typeString {
+				return 0, fmt.Errorf("%s takes one string", n.name)
+			}
+			return typeBool, nil
+		}
+		return 0, fmt.Errorf("string has no method %s (use startsWith, endsWith or contains)", n.name)
+	}
+	return 0, fmt.Errorf("%s has no method %s", target, n.name)
+}
+
+func (n *callNode) eval(env *ConditionEnv) (any, error) {
+	args, err := evalList(n.args, env)
+	if err != nil {
+		return nil, err
+	}
+	if n.target == nil { // size
+		switch v := args[0].(type) {
+		case map[string]string:
+			return int64(len(v)), nil
+		case string:
+			return int64(len(v)), nil
+		case []any:
+			return int64(len(v)), nil
+		}
+		return nil, fmt.Errorf("size of unexpected %T", args[0])
+	}
+
+	target, err := n.target.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if env, ok := target.(*ConditionEnv); ok {
+		t := env.Time.UTC()
+		switch n.name {
+		case "hour":
+			return int64(t.Hour()), nil
+		case "minute":
+			return int64(t.Minute()), nil
+		default:
+			return int64(t.Weekday()), nil
+		}
+	}
+	s, arg := target.(string), args[0].(string)
+	switch n.name {
+	case "startsWith":
+		return strings.HasPrefix(s, arg), nil
+	case "endsWith":
+		return strings.HasSuffix(s, arg), nil
+	default:
+		return strings.Contains(s, arg), nil
+	}
+}
@@ -0,0 +1,174 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/clock"
+)
+
+func TestCondition(t *testing.T) {
+	env := ConditionEnv{
+		IP:     "10.0.2.1",
+		Labels: map[string]string{"pci": "true", "zone": "eu-west-1a"},
+		Time:   time.Date(2025, 10, 5, 4, 30, 0, 0, time.UTC), // A Sunday
+	}
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`size(dest.labels) > 0 && dest.labels["pci"] == "true" && time.hour() < 6`, true},
+		{`dest.labels["owner"] == ""`, true},
+		{`"pci" in dest.labels && !("owner" in dest.labels)`, true},
+		{`dest.labels["zone"].startsWith("eu-") && dest.ip.endsWith(".1")`, true},
+		{`time.weekday() in [0, 6]`, true},
+		{`time.minute() >= 45 || dest.ip == '10.0.2.2'`, false},
+		{`dest.ip in ["10.0.2.1", "10.0.2.2"] && size(dest.ip) == 8`, true},
+	}
+	for _, tt := range tests {
+		cond, err := ParseCondition(tt.expr)
+		if err != nil {
+			t.Errorf("ParseCondition(%s) failed: %v", tt.expr, err)
+			continue
+		}
+		got, err := cond.Eval(env)
+		if err != nil || got != tt.expected {
+			t.Errorf("%s = %v (%v), want %v", tt.expr, got, err, tt.expected)
+		}
+	}
+
+	// Labels of unknown destinations are empty
+	cond, _ := ParseCondition(`size(dest.labels) == 0`)
+	if ok, err := cond.Eval(ConditionEnv{IP: "192.0.2.1"}); !ok || err != nil {
+		t.Errorf("Expected no labels for an unknown destination, got %v (%v)", ok, err)
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	tests := map[string]string{
+		`dest.labels["pci"]`:                     "boolean expression",
+		`dest.port == 443`:                       "no field port",
+		`dest.labels.pci == "true"`:              "map has no field pci",
+		`time.hour() == "4"`:                     "cannot compare",
+		`os.exec("rm")`:                          "unknown variable",
+		`size(dest.labels) > 0 &&`:               "unexpected end of condition",
+		`dest.labels["pci"] == "true`:            "unterminated string",
+		`dest.ip.matches(".*")`:                  "no method matches",
+		`[1, "a"] == [1]`:                        "mixes",
+		`dest.labels == 1`:                       "cannot compare",
+		strings.Repeat("!", 40) + "true":         "nested",
+		strings.Repeat("true && ", 200) + "true": "longer than",
+	}
+	for expr, expected := range tests {
+		if _, err := ParseCondition(expr); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("ParseCondition(%.40s) = %v, want an error containing %q", expr, err, expected)
+		}
+	}
+}
+
+func TestCompileConditions(t *testing.T) {
+	p := mustParse(t, `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: batch-to-pci
+spec:
+  podSelector:
+    matchLabels:
+      app: batch
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            tier: db
+      when: 'dest.labels["pci"] == "true" && time.hour() < 6'
+      ports:
+        - protocol: TCP
+          port: 5432
+    - to:
+        ipBlock:
+          cidr: 10.9.0.0/16
+      when: 'time.hour() < 6'
+      ports:
+        - protocol: TCP
+          port: 443
+`)
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if features := p.Features(); len(features) != 1 || features[0] != FeatureConditions {
+		t.Errorf("Expected the conditions feature, got %v", features)
+	}
+
+	resolver := NewPolicyResolver(&labeledDiscovery{
+		mockDiscovery: mockDiscovery{services: map[string][]string{"tier=db": {"10.0.2.1", "10.0.2.2"}}},
+		labels:        map[string]map[string]string{"10.0.2.1": {"tier": "db", "pci": "true"}, "10.0.2.2": {"tier": "db"}},
+	})
+	clk := clock.NewFake(time.Date(2025, 10, 1, 3, 0, 0, 0, time.UTC))
+	resolver.SetClock(clk)
+	cache := NewCompileCache(resolver)
+
+	compiled, _, err := cache.Compile(p)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if len(compiled.Rules) != 2 || compiled.Rules[0].CIDR != "10.0.2.1/32" || compiled.Rules[1].CIDR != "10.9.0.0/16" {
+		t.Errorf("Expected rules for the PCI database and the CIDR at night, got %+v", compiled.Rules)
+	}
+
+	// Conditions are re-evaluated: after 06:00 the same policy compiles to
+	// no rules, and the cache does not serve the night's
+	clk.Advance(4 * time.Hour)
+	compiled, hit, err := cache.Compile(p)
+	if err != nil || hit || len(compiled.Rules) != 0 {
+		t.Errorf("Expected no rules in the day, got %+v (hit %v, %v)", compiled, hit, err)
+	}
+
+	p.Spec.Egress[1].When = "time.hour()"
+	if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "spec.egress[1].when") {
+		t.Errorf("Expected a validation error for a non-boolean condition, got %v", err)
+	}
+}
+
+func TestSimulateConditions(t *testing.T) {
+	p := mustParse(t, `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-pci
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            tier: db
+      when: 'dest.labels["pci"] == "true"'
+      ports:
+        - protocol: TCP
+          port: 5432
+`)
+	flow := Flow{From: Endpoint{Labels: map[string]string{"app": "web"}}, Port: 5432}
+	flow.To.Labels = map[string]string{"tier": "db", "pci": "true"}
+	if v := Simulate([]NetworkPolicy{p}, flow); !v.Allowed {
+		t.Errorf("Expected the PCI database allowed, got %s", v.Reason)
+	}
+	flow.To.Labels = map[string]string{"tier": "db"}
+	if v := Simulate([]NetworkPolicy{p}, flow); v.Allowed {
+		t.Error("Expected a database without the pci label denied")
+	}
+}
+
+// labeledDiscovery is a mockDiscovery knowing the labels of its IPs
+type labeledDiscovery struct {
+	mockDiscovery
+	labels map[string]map[string]string
+}
+
+func (d *labeledDiscovery) LabelsOf(ip string) (map[string]string, bool) {
+	labels, ok := d.labels[ip]
+	return labels, ok
+}
@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DependencyCycleError reports policies whose dependsOn lists form a cycle,
+// so no apply order satisfies them
+type DependencyCycleError struct {
+	Cycle []string // Policy names, the first repeated at the end
+}
+
+func (e *DependencyCycleError) Error() string {
+	return fmt.Sprintf("policy dependency cycle: %s", strings.Join(e.Cycle, " -> "))
+}
+
+// MissingDependencyError reports a dependsOn entry naming no known policy
+type MissingDependencyError struct {
+	Policy    string
+	DependsOn string
+}
+
+func (e *MissingDependencyError) Error() string {
+	return fmt.Sprintf("policy %s depends on %s, which does not exist", e.Policy, e.DependsOn)
+}
+
+// CheckDependencies reports every dependsOn entry naming a policy missing
+// from policies, and a dependency cycle if there is one
+func CheckDependencies(policies []NetworkPolicy) error {
+	known := make(map[string]bool, len(policies))
+	for _, p := range policies {
+		known[p.Metadata.Name] = true
+	}
+	var errs []error
+	for _, p := range policies {
+		for _, dep := range p.Metadata.DependsOn {
+			if !known[dep] {
+				errs = append(errs, &MissingDependencyError{Policy: p.Metadata.Name, DependsOn: dep})
+			}
+		}
+	}
+	if _, err := Levels(policies); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Levels groups policies into apply levels: the dependencies of a policy are
+// all in earlier levels, so applying level by level honors every dependsOn.
+// Within a level policies keep their order. Dependencies missing from
+// policies (e.g. not distributed to this node, or expired) impose no order.
+func Levels(policies []NetworkPolicy) ([][]NetworkPolicy, error) {
+	index := make(map[string]int, len(policies))
+	for i, p := range policies {
+		index[p.Metadata.Name] = i
+	}
+
+	level := make([]int, len(policies))
+	for i := range level {
+		level[i] = -1
+	}
+	var visit func(i int, path []int) error
+	visit = func(i int, path []int) error {
+		if level[i] >= 0 {
+			return nil
+		}
+		for n, j := range path {
+			if j == i {
+				return cycleError(policies, append(path[n:], i))
+			}
+		}
+		path = append(path, i)
+		depth := 0
+		for _, dep := range policies[i].Metadata.DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				continue
+			}
+			if err := visit(j, path); err != nil {
+				return err
+			}
+			depth = max(depth, level[j]+1)
+		}
+		level[i] = depth
+		return nil
+	}
+
+	var levels [][]NetworkPolicy
+	for i := range policies {
+		if err := visit(i, nil); err != nil {
+			return nil, err
+		}
+		for len(levels) <= level[i] {
+			levels = append(levels, nil)
+		}
+	}
+	for i, p := range policies {
+		levels[level[i]] = append(levels[level[i]], p)
+	}
+	return levels, nil
+}
+
+// Order returns policies in apply order, each after its dependencies (see
+// Levels)
+func Order(policies []NetworkPolicy) ([]NetworkPolicy, error) {
+	levels, err := Levels(policies)
+	if err != nil {
+		return nil, err
+	}
+	ordered := make([]NetworkPolicy, 0, len(policies))
+	for _, level := range levels {
+		ordered = append(ordered, level...)
+	}
+	return ordered, nil
+}
+
+func cycleError(policies []NetworkPolicy, cycle []int) error {
+	names := make([]string, len(cycle))
+	for i, j := range cycle {
+		names[i] = policies[j].Metadata.Name
+	}
+	return &DependencyCycleError{Cycle: names}
+}
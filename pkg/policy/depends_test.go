@@ -0,0 +1,98 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// dependentPolicies returns policies named by the keys of deps, in order,
+// depending on the listed policies
+func dependentPolicies(t *testing.T, names []string, deps map[string][]string) []NetworkPolicy {
+	t.Helper()
+	policies := make([]NetworkPolicy, len(names))
+	for i, name := range names {
+		policies[i] = mustParse(t, compileTestPolicy)
+		policies[i].Metadata.Name = name
+		policies[i].Metadata.DependsOn = deps[name]
+	}
+	return policies
+}
+
+func TestLevels(t *testing.T) {
+	policies := dependentPolicies(t, []string{"app-deny", "dns", "infra-allow", "db"}, map[string][]string{
+		"app-deny":    {"infra-allow", "db"},
+		"infra-allow": {"dns"},
+		"db":          {"not-on-this-node"},
+	})
+	levels, err := Levels(policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"dns", "db"}, {"infra-allow"}, {"app-deny"}}
+	if len(levels) != len(want) {
+		t.Fatalf("Expected %d levels, got %d", len(want), len(levels))
+	}
+	for i := range want {
+		for j, p := range levels[i] {
+			if j >= len(want[i]) || p.Metadata.Name != want[i][j] {
+				t.Fatalf("Expected level %d to be %v, got %v", i, want[i], policyNames(levels[i]))
+			}
+		}
+	}
+
+	if err := CheckDependencies(policies); !errors.As(err, new(*MissingDependencyError)) {
+		t.Errorf("Expected the missing dependency reported, got %v", err)
+	}
+}
+
+func TestLevelsCycle(t *testing.T) {
+	policies := dependentPolicies(t, []string{"a", "b", "c"}, map[string][]string{
+		"a": {"b"},
+		"b": {"c"},
+		"c": {"b"},
+	})
+	_, err := Levels(policies)
+	var cycle *DependencyCycleError
+	if !errors.As(err, &cycle) {
+		t.Fatalf("Expected a cycle error, got %v", err)
+	}
+	if err.Error() != "policy dependency cycle: b -> c -> b" {
+		t.Errorf("Unexpected error %q", err)
+	}
+	if err := ApplyAll(context.Background(), policies, 1, func(NetworkPolicy) error {
+		t.Error("Expected nothing applied")
+		return nil
+	}); !errors.As(err, &cycle) {
+		t.Errorf("Expected ApplyAll to return the cycle, got %v", err)
+	}
+}
+
+func TestApplyAllDependencies(t *testing.T) {
+	policies := dependentPolicies(t, []string{"app-deny", "infra-allow", "broken", "after-broken"}, map[string][]string{
+		"app-deny":     {"infra-allow"},
+		"after-broken": {"broken"},
+	})
+	policies[0].Spec.Egress = nil // Deny-all, still applied after its dependency
+
+	var mu sync.Mutex
+	var order []string
+	err := ApplyAll(context.Background(), policies, 4, func(p NetworkPolicy) error {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, p.Metadata.Name)
+		if p.Metadata.Name == "broken" {
+			return errors.New("backend failure")
+		}
+		return nil
+	})
+
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) || len(applyErr.Failures) != 2 || applyErr.Failures["after-broken"] == nil {
+		t.Fatalf("Expected broken and its dependent to fail, got %v", err)
+	}
+	if len(order) != 3 || order[2] != "app-deny" {
+		t.Errorf("Expected app-deny applied last and after-broken skipped, got %v", order)
+	}
+}
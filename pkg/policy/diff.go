@@ -0,0 +1,185 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Change kinds of a PolicyChange
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// PolicyChange is how a policy differs between two policy sets
+type PolicyChange struct {
+	Policy string
+	Kind   string // ChangeAdded, ChangeRemoved or ChangeChanged
+	// Fields describes changed fields other than rules, e.g.
+	// "podSelector: app=web -> app=api"
+	Fields []string
+	// Added and Removed are egress and ingress rules, one per peer and port
+	Added   []string
+	Removed []string
+}
+
+// DiffPolicies compares two policy sets by policy name, in name order.
+// Policies whose selector, expiry, rules and referenced endpoint groups are
+// all equal are left out; the order of rules does not matter.
+func DiffPolicies(before, after []NetworkPolicy) []PolicyChange {
+	old := make(map[string]*NetworkPolicy, len(before))
+	for i := range before {
+		old[before[i].Metadata.Name] = &before[i]
+	}
+	current := make(map[string]*NetworkPolicy, len(after))
+	for i := range after {
+		current[after[i].Metadata.Name] = &after[i]
+	}
+
+	var changes []PolicyChange
+	for name, p := range current {
+		previous, ok := old[name]
+		if !ok {
+			changes = append(changes, PolicyChange{Policy: name, Kind: ChangeAdded, Added: allRules(p)})
+			continue
+		}
+		change := PolicyChange{Policy: name, Kind: ChangeChanged}
+		if from, to := labelString(previous.Spec.PodSelector.MatchLabels), labelString(p.Spec.PodSelector.MatchLabels); from != to {
+			change.Fields = append(change.Fields, fmt.Sprintf("podSelector: %s -> %s", orAll(from), orAll(to)))
+		}
+		if from, to := labelString(previous.Spec.NodeSelector), labelString(p.Spec.NodeSelector); from != to {
+			change.Fields = append(change.Fields, fmt.Sprintf("nodeSelector: %s -> %s", orAll(from), orAll(to)))
+		}
+		if previous.Metadata.ExpiresAt != p.Metadata.ExpiresAt {
+			change.Fields = append(change.Fields, fmt.Sprintf("expiresAt: %q -> %q", previous.Metadata.ExpiresAt, p.Metadata.ExpiresAt))
+		}
+		if previous.Metadata.TTL != p.Metadata.TTL {
+			change.Fields = append(change.Fields, fmt.Sprintf("ttl: %q -> %q", previous.Metadata.TTL, p.Metadata.TTL))
+		}
+		change.Fields = append(change.Fields, diffGroups(previous, p)...)
+		change.Added, change.Removed = diffStrings(allRules(previous), allRules(p))
+		if len(change.Fields) > 0 || len(change.Added) > 0 || len(change.Removed) > 0 {
+			changes = append(changes, change)
+		}
+	}
+	for name, p := range old {
+		if _, ok := current[name]; !ok {
+			changes = append(changes, PolicyChange{Policy: name, Kind: ChangeRemoved, Removed: allRules(p)})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Policy < changes[j].Policy })
+	return changes
+}
+
+// diffGroups describes the endpoint groups referenced by both policies whose
+// definition changed, e.g. "group corp-saas: 203.0.113.0/24 -> 198.51.100.0/24"
+func diffGroups(previous, p *NetworkPolicy) []string {
+	names := make([]string, 0, len(p.Groups))
+	for name := range p.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fields []string
+	for _, name := range names {
+		old, ok := previous.Groups[name]
+		if from, to := old.String(), p.Groups[name].String(); ok && from != to {
+			fields = append(fields, fmt.Sprintf("group %s: %s -> %s", name, from, to))
+		}
+	}
+	return fields
+}
+
+// EgressRules describes the egress rules of p, one per peer and port, e.g.
+// "to app=db TCP/5432", "to 10.0.0.0/8 UDP/53" or "to group corp-saas
+// TCP/443", followed by the rule's condition if any, sorted
+func EgressRules(p *NetworkPolicy) []string {
+	var rules []string
+	for _, egress := range p.Spec.Egress {
+		var peers []string
+		if len(egress.To.PodSelector.MatchLabels) > 0 {
+			peers = append(peers, labelString(egress.To.PodSelector.MatchLabels))
+		}
+		if egress.To.IPBlock.CIDR != "" {
+			peers = append(peers, egress.To.IPBlock.CIDR)
+		}
+		if egress.To.Group != "" {
+			peers = append(peers, "group "+egress.To.Group)
+		}
+		peer := orAll(strings.Join(peers, " "))
+		when := ""
+		if egress.When != "" {
+			when = " when " + egress.When
+		}
+		if len(egress.Ports) == 0 {
+			rules = append(rules, fmt.Sprintf("to %s (no ports)%s", peer, when))
+		}
+		for _, port := range egress.Ports {
+			rules = append(rules, fmt.Sprintf("to %s %s%s", peer, port, when))
+		}
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+// IngressRules describes the ingress rules of p, one per peer and port, e.g.
+// "from app=web TCP/8080" or "from 10.0.0.0/8 TCP/22", sorted
+func IngressRules(p *NetworkPolicy) []string {
+	var rules []string
+	for _, ingress := range p.Spec.Ingress {
+		var peers []string
+		if len(ingress.From.PodSelector.MatchLabels) > 0 {
+			peers = append(peers, labelString(ingress.From.PodSelector.MatchLabels))
+		}
+		if ingress.From.IPBlock.CIDR != "" {
+			peers = append(peers, ingress.From.IPBlock.CIDR)
+		}
+		peer := orAll(strings.Join(peers, " "))
+		if len(ingress.Ports) == 0 {
+			rules = append(rules, fmt.Sprintf("from %s (no ports)", peer))
+		}
+		for _, port := range ingress.Ports {
+			rules = append(rules, fmt.Sprintf("from %s %s", peer, port))
+		}
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+// allRules describes the egress rules of p followed by its ingress rules
+func allRules(p *NetworkPolicy) []string {
+	return append(EgressRules(p), IngressRules(p)...)
+}
+
+// diffStrings returns the elements of next not in previous and those of
+// previous not in next
+func diffStrings(previous, next []string) (added, removed []string) {
+	before := make(map[string]bool, len(previous))
+	for _, s := range previous {
+		before[s] = true
+	}
+	after := make(map[string]bool, len(next))
+	for _, s := range next {
+		after[s] = true
+		if !before[s] {
+			added = append(added, s)
+		}
+	}
+	for _, s := range previous {
+		if !after[s] {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+// orAll names an empty selector
+func orAll(selector string) string {
+	if selector == "" {
+		return "<all>"
+	}
+	return selector
+}
@@ -0,0 +1,135 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffPolicies(t *testing.T) {
+	before, err := Parse([]byte(`apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+        - protocol: TCP
+          port: 3306
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: legacy
+spec:
+  podSelector:
+    matchLabels:
+      app: legacy
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: dns
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.53/32
+      ports:
+        - protocol: UDP
+          port: 53
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := Parse([]byte(`apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: dns
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.53/32
+      ports:
+        - protocol: udp
+          port: 53
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+  ttl: 4h
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+      tier: frontend
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+    - to:
+        podSelector:
+          matchLabels:
+            app: cache
+      ports:
+        - protocol: TCP
+          port: 6379
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: api
+spec:
+  podSelector:
+    matchLabels:
+      app: api
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/8
+      ports:
+        - protocol: TCP
+          port: 443
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []PolicyChange{
+		{Policy: "api", Kind: ChangeAdded, Added: []string{"to 10.0.0.0/8 TCP/443"}},
+		{Policy: "legacy", Kind: ChangeRemoved},
+		{
+			Policy:  "web-to-db",
+			Kind:    ChangeChanged,
+			Fields:  []string{"podSelector: app=web -> app=web,tier=frontend", `ttl: "" -> "4h"`},
+			Added:   []string{"to app=cache TCP/6379"},
+			Removed: []string{"to app=db TCP/3306"},
+		},
+	}
+	if got := DiffPolicies(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected diff:\n got %+v\nwant %+v", got, want)
+	}
+	if got := DiffPolicies(after, after); len(got) != 0 {
+		t.Errorf("Expected no changes, got %+v", got)
+	}
+}
@@ -0,0 +1,297 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SchemaVersion is the newest policy apiVersion (ztap/v<N>) this build
+// understands
+const SchemaVersion = 1
+
+// Schema features added to ztap/v1 after its first release. Agents advertise
+// the ones they understand in policy sync; a policy using a feature an agent
+// lacks is downgraded for it where that keeps its meaning, and withheld
+// otherwise.
+const (
+	FeatureExpiry         = "expiry"          // metadata.expiresAt and metadata.ttl
+	FeatureEndpointGroups = "endpoint-groups" // to.group referencing EndpointGroup documents
+	FeatureNodeSelector   = "node-selector"   // spec.nodeSelector
+	FeatureConditions     = "conditions"      // spec.egress[].when
+	FeatureIngress        = "ingress"         // spec.ingress
+	FeaturePortRanges     = "port-ranges"     // ports[].endPort, port ranges and named ports
+)
+
+// SupportedFeatures returns the sorted schema features this build
+// understands
+func SupportedFeatures() []string {
+	return []string{FeatureConditions, FeatureEndpointGroups, FeatureExpiry, FeatureIngress, FeatureNodeSelector, FeaturePortRanges}
+}
+
+var apiVersionPattern = regexp.MustCompile(`^ztap/v(\d+)$`)
+
+// Schema returns the schema version of the policy's apiVersion, or 0 if it
+// is malformed
+func (p *NetworkPolicy) Schema() int {
+	match := apiVersionPattern.FindStringSubmatch(p.APIVersion)
+	if match == nil {
+		return 0
+	}
+	version, _ := strconv.Atoi(match[1])
+	return version
+}
+
+// Features returns the sorted schema features the policy uses
+func (p *NetworkPolicy) Features() []string {
+	var features []string
+	for _, egress := range p.Spec.Egress {
+		if egress.To.Group != "" {
+			features = append(features, FeatureEndpointGroups)
+			break
+		}
+	}
+	for _, egress := range p.Spec.Egress {
+		if egress.When != "" {
+			features = append(features, FeatureConditions)
+			break
+		}
+	}
+	if p.Metadata.ExpiresAt != "" || p.Metadata.TTL != "" {
+		features = append(features, FeatureExpiry)
+	}
+	if len(p.Spec.Ingress) > 0 {
+		features = append(features, FeatureIngress)
+	}
+	if len(p.Spec.NodeSelector) > 0 {
+		features = append(features, FeatureNodeSelector)
+	}
+	if p.usesPortRanges() {
+		features = append(features, FeaturePortRanges)
+	}
+	sort.Strings(features)
+	return features
+}
+
+// usesPortRanges reports whether a ports entry of p has an endPort or a name
+func (p *NetworkPolicy) usesPortRanges() bool {
+	var ports []PolicyPort
+	for _, egress := range p.Spec.Egress {
+		ports = append(ports, egress.Ports...)
+	}
+	for _, ingress := range p.Spec.Ingress {
+		ports = append(ports, ingress.Ports...)
+	}
+	for _, port := range ports {
+		if port.EndPort != 0 || port.Name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Requirements returns the highest schema version and the sorted schema
+// features used by the policies in data
+func Requirements(data []byte) (schema int, features []string, err error) {
+	policies, err := Parse(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, p := range policies {
+		schema = max(schema, p.Schema())
+		for _, feature := range p.Features() {
+			if !slices.Contains(features, feature) {
+				features = append(features, feature)
+			}
+		}
+	}
+	sort.Strings(features)
+	return schema, features, nil
+}
+
+// Peer is what a consumer of policies, such as an agent, understands
+type Peer struct {
+	Schema   int      // Newest apiVersion understood
+	Features []string // Schema features understood
+	// Labels the policy was matched against for the peer, if any. A
+	// nodeSelector matching them can be dropped for a peer that does not
+	// understand nodeSelectors.
+	Labels map[string]string
+}
+
+// LocalPeer returns what this build understands
+func LocalPeer() Peer {
+	return Peer{Schema: SchemaVersion, Features: SupportedFeatures()}
+}
+
+// Supports reports whether the peer understands feature
+func (peer Peer) Supports(feature string) bool {
+	return slices.Contains(peer.Features, feature)
+}
+
+// Accepts checks that the peer understands a policy needing schema and
+// features as they are, e.g. those declared by a cluster policy update
+func (peer Peer) Accepts(name string, schema int, features []string) error {
+	incompatible := &IncompatibleError{Policy: name}
+	if schema > peer.Schema {
+		incompatible.Schema = schema
+	}
+	for _, feature := range features {
+		if !peer.Supports(feature) {
+			incompatible.Missing = append(incompatible.Missing, feature)
+		}
+	}
+	if incompatible.Schema > 0 || len(incompatible.Missing) > 0 {
+		return incompatible
+	}
+	return nil
+}
+
+// IncompatibleError reports why a policy is withheld from a peer
+type IncompatibleError struct {
+	Policy  string
+	Schema  int      // Schema version the policy needs, if newer than the peer's
+	Missing []string // Features the peer lacks that the policy cannot do without
+}
+
+func (e *IncompatibleError) Error() string {
+	return fmt.Sprintf("policy '%s' %s", e.Policy, e.Reason())
+}
+
+// Reason describes what the peer lacks, e.g. "requires unsupported
+// features: expiry"
+func (e *IncompatibleError) Reason() string {
+	var reasons []string
+	if e.Schema > 0 {
+		reasons = append(reasons, fmt.Sprintf("requires apiVersion ztap/v%d", e.Schema))
+	}
+	if len(e.Missing) > 0 {
+		reasons = append(reasons, "requires unsupported features: "+strings.Join(e.Missing, ", "))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Downgrade returns p as peer understands it, along with the features that
+// were rewritten for it:
+//
+//   - endpoint-groups: to.group is replaced by one rule per CIDR and
+//     selector of the group; groups with FQDNs cannot be rewritten
+//   - node-selector: dropped if it matches peer.Labels
+//   - port-ranges: named ports are replaced by their number, and ranges by
+//     one entry per port if they span at most maxInlinedPorts
+//
+// A policy needing a newer schema, or a feature that cannot be rewritten, is
+// rejected with an *IncompatibleError. Expiry is never rewritten: a peer that
+// ignored it would enforce a temporary exception forever. Nor are
+// conditions: a peer that ignored them would allow every destination. Ingress
+// rules are not rewritten either: a peer that ignored them would leave
+// inbound traffic open.
+func Downgrade(p NetworkPolicy, peer Peer) (NetworkPolicy, []string, error) {
+	incompatible := &IncompatibleError{Policy: p.Metadata.Name}
+	if schema := p.Schema(); schema > peer.Schema {
+		incompatible.Schema = schema
+	}
+
+	var downgraded []string
+	for _, feature := range p.Features() {
+		if peer.Supports(feature) {
+			continue
+		}
+		var ok bool
+		switch feature {
+		case FeatureEndpointGroups:
+			p, ok = inlineGroups(p)
+		case FeatureNodeSelector:
+			if ok = peer.Labels != nil && p.SelectsNode(peer.Labels); ok {
+				p.Spec.NodeSelector = nil
+			}
+		case FeaturePortRanges:
+			p, ok = inlinePorts(p)
+		}
+		if ok {
+			downgraded = append(downgraded, feature)
+		} else {
+			incompatible.Missing = append(incompatible.Missing, feature)
+		}
+	}
+
+	if incompatible.Schema > 0 || len(incompatible.Missing) > 0 {
+		return p, nil, incompatible
+	}
+	return p, downgraded, nil
+}
+
+// inlineGroups replaces every to.group of p with one rule per CIDR and
+// selector of the group. ok is false if a group has FQDNs, which have no
+// equivalent without groups.
+func inlineGroups(p NetworkPolicy) (NetworkPolicy, bool) {
+	egress := p.Spec.Egress[:0:0]
+	for _, rule := range p.Spec.Egress {
+		if rule.To.Group == "" {
+			egress = append(egress, rule)
+			continue
+		}
+		g := p.Groups[rule.To.Group]
+		if len(g.FQDNs) > 0 {
+			return p, false
+		}
+		rule.To.Group = ""
+		for _, cidr := range g.CIDRs {
+			inlined := rule
+			inlined.To.IPBlock.CIDR = cidr
+			egress = append(egress, inlined)
+		}
+		for _, labels := range g.Selectors {
+			inlined := rule
+			inlined.To.PodSelector.MatchLabels = labels
+			egress = append(egress, inlined)
+		}
+	}
+	p.Spec.Egress = egress
+	p.Groups = nil
+	return p, true
+}
+
+// maxInlinedPorts is the widest port range Downgrade rewrites into single
+// ports
+const maxInlinedPorts = 64
+
+// inlinePorts replaces the named ports of p by their number and its port
+// ranges by one entry per port. ok is false if a range spans more than
+// maxInlinedPorts.
+func inlinePorts(p NetworkPolicy) (NetworkPolicy, bool) {
+	inline := func(ports []PolicyPort) ([]PolicyPort, bool) {
+		var out []PolicyPort
+		for _, port := range ports {
+			if port.LastPort()-port.Port >= maxInlinedPorts {
+				return nil, false
+			}
+			for n := port.Port; n <= port.LastPort(); n++ {
+				out = append(out, PolicyPort{Protocol: port.Protocol, Port: n})
+			}
+		}
+		return out, true
+	}
+
+	// Copy the rules so the caller's policy keeps its ports
+	p.Spec.Egress = slices.Clone(p.Spec.Egress)
+	for i := range p.Spec.Egress {
+		ports, ok := inline(p.Spec.Egress[i].Ports)
+		if !ok {
+			return p, false
+		}
+		p.Spec.Egress[i].Ports = ports
+	}
+	p.Spec.Ingress = slices.Clone(p.Spec.Ingress)
+	for i := range p.Spec.Ingress {
+		ports, ok := inline(p.Spec.Ingress[i].Ports)
+		if !ok {
+			return p, false
+		}
+		p.Spec.Ingress[i].Ports = ports
+	}
+	return p, true
+}
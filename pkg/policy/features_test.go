@@ -0,0 +1,99 @@
+package policy
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const featuresTestPolicies = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-saas
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  nodeSelector:
+    role: edge
+  egress:
+    - to:
+        group: saas
+      ports:
+        - protocol: TCP
+          port: 443
+---
+apiVersion: ztap/v1
+kind: EndpointGroup
+metadata:
+  name: saas
+spec:
+  cidrs: [203.0.113.0/24]
+  selectors:
+    - matchLabels:
+        app: proxy
+`
+
+func TestDowngrade(t *testing.T) {
+	policies, err := Parse([]byte(featuresTestPolicies))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := policies[0]
+	if got, expected := p.Features(), []string{FeatureEndpointGroups, FeatureNodeSelector}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected features %v, got %v", expected, got)
+	}
+	schema, features, err := Requirements([]byte(featuresTestPolicies))
+	if err != nil || schema != 1 || len(features) != 2 {
+		t.Errorf("Unexpected requirements %d %v (%v)", schema, features, err)
+	}
+
+	// A current peer gets the policy unchanged
+	current := Peer{Schema: SchemaVersion, Features: SupportedFeatures()}
+	same, downgraded, err := Downgrade(p, current)
+	if err != nil || len(downgraded) != 0 || !reflect.DeepEqual(same, p) {
+		t.Errorf("Expected the policy unchanged, got %+v, %v (%v)", same, downgraded, err)
+	}
+
+	// A legacy peer matched by the nodeSelector gets groups inlined
+	legacy := Peer{Schema: 1, Labels: map[string]string{"role": "edge"}}
+	old, downgraded, err := Downgrade(p, legacy)
+	if err != nil {
+		t.Fatalf("Downgrade failed: %v", err)
+	}
+	if !reflect.DeepEqual(downgraded, []string{FeatureEndpointGroups, FeatureNodeSelector}) || len(old.Features()) != 0 {
+		t.Errorf("Expected groups and nodeSelector rewritten, got %v (features %v)", downgraded, old.Features())
+	}
+	if len(old.Spec.Egress) != 2 || old.Spec.Egress[0].To.IPBlock.CIDR != "203.0.113.0/24" || old.Spec.Egress[1].To.PodSelector.MatchLabels["app"] != "proxy" {
+		t.Errorf("Unexpected inlined egress %+v", old.Spec.Egress)
+	}
+	if err := old.Validate(); err != nil {
+		t.Errorf("Expected a valid downgraded policy: %v", err)
+	}
+	if len(p.Spec.Egress) != 1 || p.Spec.NodeSelector == nil {
+		t.Error("Expected the original policy left unchanged")
+	}
+
+	// Unmatched nodeSelectors, FQDN groups, expiry and newer schemas are withheld
+	var incompatible *IncompatibleError
+	if _, _, err := Downgrade(p, Peer{Schema: 1}); !errors.As(err, &incompatible) || !reflect.DeepEqual(incompatible.Missing, []string{FeatureNodeSelector}) {
+		t.Errorf("Expected the nodeSelector to be missing, got %v", err)
+	}
+	fqdn := p
+	fqdn.Groups = map[string]EndpointGroup{"saas": {Name: "saas", FQDNs: []string{"login.example.com"}}}
+	if _, _, err := Downgrade(fqdn, legacy); err == nil {
+		t.Error("Expected a group with FQDNs to be withheld")
+	}
+	temporary := p
+	temporary.Metadata.TTL = "4h"
+	if _, _, err := Downgrade(temporary, legacy); err == nil || !strings.Contains(err.Error(), FeatureExpiry) {
+		t.Errorf("Expected an expiring policy to be withheld, got %v", err)
+	}
+	next := p
+	next.APIVersion = "ztap/v2"
+	if _, _, err := Downgrade(next, current); err == nil || !strings.Contains(err.Error(), "ztap/v2") {
+		t.Errorf("Expected a ztap/v2 policy to be withheld, got %v", err)
+	}
+}
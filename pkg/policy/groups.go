@@ -0,0 +1,207 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// KindEndpointGroup is the kind of documents defining an endpoint group
+const KindEndpointGroup = "EndpointGroup"
+
+// EndpointGroup is a named set of destinations defined once in a policy file
+// and referenced from egress rules with to.group:
+//
+//	apiVersion: ztap/v1
+//	kind: EndpointGroup
+//	metadata:
+//	  name: corp-saas
+//	spec:
+//	  cidrs: [203.0.113.0/24]
+//	  fqdns: [login.example.com]
+//	  selectors:
+//	    - matchLabels:
+//	        app: sso-proxy
+//
+// Static CIDRs are used as they are. FQDNs and selectors are resolved each
+// time a referencing policy is compiled, so a change in membership changes
+// the compiled rules (and compile hash) of every policy using the group.
+type EndpointGroup struct {
+	Name      string
+	CIDRs     []string
+	FQDNs     []string
+	Selectors []map[string]string
+}
+
+// groupDocument is the YAML form of an EndpointGroup
+type groupDocument struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Spec struct {
+		CIDRs     []string `yaml:"cidrs,omitempty"`
+		FQDNs     []string `yaml:"fqdns,omitempty"`
+		Selectors []struct {
+			MatchLabels map[string]string `yaml:"matchLabels"`
+		} `yaml:"selectors,omitempty"`
+	} `yaml:"spec"`
+}
+
+// group returns the EndpointGroup the document defines
+func (d groupDocument) group() EndpointGroup {
+	g := EndpointGroup{Name: d.Metadata.Name, CIDRs: d.Spec.CIDRs, FQDNs: d.Spec.FQDNs}
+	for _, selector := range d.Spec.Selectors {
+		g.Selectors = append(g.Selectors, selector.MatchLabels)
+	}
+	return g
+}
+
+// document returns the YAML form of g
+func (g EndpointGroup) document() groupDocument {
+	var d groupDocument
+	d.APIVersion = "ztap/v1"
+	d.Kind = KindEndpointGroup
+	d.Metadata.Name = g.Name
+	d.Spec.CIDRs = g.CIDRs
+	d.Spec.FQDNs = g.FQDNs
+	for _, labels := range g.Selectors {
+		d.Spec.Selectors = append(d.Spec.Selectors, struct {
+			MatchLabels map[string]string `yaml:"matchLabels"`
+		}{labels})
+	}
+	return d
+}
+
+// Validate checks that the group has members and that they are well formed
+func (g EndpointGroup) Validate() error {
+	if len(g.CIDRs) == 0 && len(g.FQDNs) == 0 && len(g.Selectors) == 0 {
+		return fmt.Errorf("endpoint group %s: must specify cidrs, fqdns or selectors", g.Name)
+	}
+	for _, cidr := range g.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("endpoint group %s: invalid CIDR: %v", g.Name, err)
+		}
+	}
+	for _, fqdn := range g.FQDNs {
+		if fqdn == "" || strings.ContainsAny(fqdn, " /*") {
+			return fmt.Errorf("endpoint group %s: invalid FQDN %q", g.Name, fqdn)
+		}
+	}
+	for i, labels := range g.Selectors {
+		if len(labels) == 0 {
+			return fmt.Errorf("endpoint group %s: selectors[%d] must have at least one label", g.Name, i)
+		}
+	}
+	return nil
+}
+
+// String describes the members of g, e.g.
+// "203.0.113.0/24 login.example.com app=sso-proxy"
+func (g EndpointGroup) String() string {
+	members := append(append([]string(nil), g.CIDRs...), g.FQDNs...)
+	for _, labels := range g.Selectors {
+		members = append(members, labelString(labels))
+	}
+	return strings.Join(members, " ")
+}
+
+// endpointGroups returns the groups defined by docs, by name
+func endpointGroups(docs []document) (map[string]EndpointGroup, error) {
+	groups := make(map[string]EndpointGroup)
+	for _, d := range docs {
+		if d.group.Kind != KindEndpointGroup {
+			continue
+		}
+		g := d.group.group()
+		if g.Name == "" {
+			return nil, fmt.Errorf("endpoint group: metadata.name missing")
+		}
+		if _, exists := groups[g.Name]; exists {
+			return nil, fmt.Errorf("endpoint group %s defined twice", g.Name)
+		}
+		if err := g.Validate(); err != nil {
+			return nil, err
+		}
+		groups[g.Name] = g
+	}
+	return groups, nil
+}
+
+// attachGroups sets the Groups of each policy to the groups its egress rules
+// reference
+func attachGroups(policies []NetworkPolicy, groups map[string]EndpointGroup) error {
+	for i := range policies {
+		p := &policies[i]
+		for j, egress := range p.Spec.Egress {
+			if egress.To.Group == "" {
+				continue
+			}
+			g, ok := groups[egress.To.Group]
+			if !ok {
+				return ValidationError{p.Metadata.Name, fmt.Sprintf("spec.egress[%d].to.group", j), fmt.Sprintf("unknown endpoint group %q", egress.To.Group)}
+			}
+			if p.Groups == nil {
+				p.Groups = make(map[string]EndpointGroup)
+			}
+			p.Groups[g.Name] = g
+		}
+	}
+	return nil
+}
+
+// ResolveGroup returns the members of an endpoint group, sorted: its CIDRs,
+// the addresses its FQDNs resolve to and the IPs its selectors match. Any
+// member failing to resolve fails the group, so a DNS outage keeps the rules
+// enforced before rather than dropping the destination.
+func (r *PolicyResolver) ResolveGroup(g EndpointGroup) ([]string, error) {
+	seen := make(map[string]bool)
+	var members []string
+	add := func(member string) {
+		if !seen[member] {
+			seen[member] = true
+			members = append(members, member)
+		}
+	}
+
+	for _, cidr := range g.CIDRs {
+		add(cidr)
+	}
+	var errs []error
+	for _, fqdn := range g.FQDNs {
+		ips, err := r.lookup(fqdn)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("fqdn %s: %w", fqdn, err))
+			continue
+		}
+		for _, ip := range ips {
+			add(ip)
+		}
+	}
+	for _, labels := range g.Selectors {
+		ips, err := r.ResolveLabels(labels)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("selector %s: %w", labelString(labels), err))
+			continue
+		}
+		for _, ip := range ips {
+			add(ip)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// lookup resolves a host name to its addresses
+func (r *PolicyResolver) lookup(host string) ([]string, error) {
+	if r != nil && r.lookupHost != nil {
+		return r.lookupHost(host)
+	}
+	return net.LookupHost(host)
+}
@@ -0,0 +1,250 @@
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const endpointGroupPolicies = `apiVersion: ztap/v1
+kind: EndpointGroup
+metadata:
+  name: corp-saas
+spec:
+  cidrs: [203.0.113.0/24]
+  fqdns: [login.example.com]
+  selectors:
+    - matchLabels:
+        app: sso-proxy
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-saas
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        group: corp-saas
+      ports:
+        - protocol: TCP
+          port: 443
+`
+
+// groupResolver resolves app=sso-proxy and login.example.com through the
+// members it is given
+func groupResolver(proxies []string, hosts map[string][]string) *PolicyResolver {
+	r := NewPolicyResolver(&mockDiscovery{services: map[string][]string{"app=sso-proxy": proxies}})
+	r.lookupHost = func(host string) ([]string, error) {
+		if ips, ok := hosts[host]; ok {
+			return ips, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+	return r
+}
+
+func TestParseEndpointGroup(t *testing.T) {
+	p := mustParse(t, endpointGroupPolicies)
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	want := EndpointGroup{
+		Name:      "corp-saas",
+		CIDRs:     []string{"203.0.113.0/24"},
+		FQDNs:     []string{"login.example.com"},
+		Selectors: []map[string]string{{"app": "sso-proxy"}},
+	}
+	if got := p.Groups["corp-saas"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected group %+v, got %+v", want, got)
+	}
+
+	// Marshal writes the group back, so the output parses the same
+	data, err := Marshal([]NetworkPolicy{p})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	again := mustParse(t, string(data))
+	if !reflect.DeepEqual(again.Groups, p.Groups) || again.Spec.Egress[0].To.Group != "corp-saas" {
+		t.Errorf("Round trip changed the policy:\n%s", data)
+	}
+}
+
+func TestParseEndpointGroupErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "unknown group",
+			content: strings.Replace(endpointGroupPolicies, "group: corp-saas", "group: missing", 1),
+			want:    `unknown endpoint group "missing"`,
+		},
+		{
+			name:    "defined twice",
+			content: endpointGroupPolicies + "---\n" + strings.SplitN(endpointGroupPolicies, "---", 2)[0],
+			want:    "endpoint group corp-saas defined twice",
+		},
+		{
+			name:    "invalid cidr",
+			content: strings.Replace(endpointGroupPolicies, "203.0.113.0/24", "203.0.113.0/33", 1),
+			want:    "invalid CIDR",
+		},
+		{
+			name: "no members",
+			content: `apiVersion: ztap/v1
+kind: EndpointGroup
+metadata:
+  name: empty
+spec: {}
+`,
+			want: "must specify cidrs, fqdns or selectors",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidateGroupPeer(t *testing.T) {
+	p := mustParse(t, endpointGroupPolicies)
+	p.Spec.Egress[0].To.IPBlock.CIDR = "10.0.0.0/8"
+	if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "cannot specify group with podSelector or ipBlock") {
+		t.Errorf("Expected error for group with ipBlock, got %v", err)
+	}
+
+	p = mustParse(t, endpointGroupPolicies)
+	p.Groups = nil
+	if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "unknown endpoint group") {
+		t.Errorf("Expected error for a group not attached, got %v", err)
+	}
+}
+
+func TestCompileEndpointGroup(t *testing.T) {
+	resolver := groupResolver([]string{"10.0.5.1"}, map[string][]string{"login.example.com": {"198.51.100.7"}})
+	compiled, err := resolver.Compile(mustParse(t, endpointGroupPolicies))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	var cidrs []string
+	for _, rule := range compiled.Rules {
+		cidrs = append(cidrs, rule.CIDR)
+	}
+	want := []string{"10.0.5.1/32", "198.51.100.7/32", "203.0.113.0/24"}
+	if !reflect.DeepEqual(cidrs, want) {
+		t.Errorf("Expected rules to %v, got %v", want, cidrs)
+	}
+	if compiled.Endpoints != 3 {
+		t.Errorf("Expected 3 endpoints, got %d", compiled.Endpoints)
+	}
+
+	// A member that fails to resolve fails the policy rather than dropping
+	// the destination
+	resolver = groupResolver([]string{"10.0.5.1"}, nil)
+	if _, err := resolver.Compile(mustParse(t, endpointGroupPolicies)); err == nil || !strings.Contains(err.Error(), "fqdn login.example.com") {
+		t.Errorf("Expected FQDN resolution error, got %v", err)
+	}
+}
+
+func TestCompileCacheGroupMembership(t *testing.T) {
+	hosts := map[string][]string{"login.example.com": {"198.51.100.7"}}
+	resolver := groupResolver([]string{"10.0.5.1"}, hosts)
+	cache := NewCompileCache(resolver)
+
+	var changes []string
+	cache.OnGroupChange(func(group string, added, removed []string) {
+		changes = append(changes, fmt.Sprintf("%s +%v -%v", group, added, removed))
+	})
+
+	p := mustParse(t, endpointGroupPolicies)
+	first, _, err := cache.Compile(p)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if _, hit, _ := cache.Compile(p); !hit {
+		t.Error("Expected a cache hit while membership is unchanged")
+	}
+	if len(changes) != 0 {
+		t.Errorf("Expected no membership change on first resolution, got %v", changes)
+	}
+
+	// The FQDN moves: the policy is recompiled and the change reported
+	hosts["login.example.com"] = []string{"198.51.100.8"}
+	second, hit, err := cache.Compile(p)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if hit || second.Hash == first.Hash {
+		t.Error("Expected membership change to recompile the policy")
+	}
+	want := []string{"corp-saas +[198.51.100.8] -[198.51.100.7]"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Expected changes %v, got %v", want, changes)
+	}
+	if members := cache.GroupMembers("corp-saas"); !reflect.DeepEqual(members, []string{"10.0.5.1", "198.51.100.8", "203.0.113.0/24"}) {
+		t.Errorf("Unexpected members %v", members)
+	}
+}
+
+func TestSimulateEndpointGroup(t *testing.T) {
+	policies := []NetworkPolicy{mustParse(t, endpointGroupPolicies)}
+	web := Endpoint{Labels: map[string]string{"app": "web"}}
+
+	tests := []struct {
+		to   Endpoint
+		want bool
+	}{
+		{Endpoint{IP: "203.0.113.9"}, true},
+		{Endpoint{Labels: map[string]string{"app": "sso-proxy"}}, true},
+		{Endpoint{IP: "198.51.100.7"}, false}, // FQDNs are not resolved
+	}
+	for _, tt := range tests {
+		verdict := Simulate(policies, Flow{From: web, To: tt.to, Protocol: "TCP", Port: 443})
+		if verdict.Allowed != tt.want {
+			t.Errorf("Flow to %v: expected allowed=%v, got %+v", tt.to, tt.want, verdict)
+		}
+	}
+}
+
+func TestDiffEndpointGroup(t *testing.T) {
+	before := []NetworkPolicy{mustParse(t, endpointGroupPolicies)}
+	after := []NetworkPolicy{mustParse(t, strings.Replace(endpointGroupPolicies, "203.0.113.0/24", "192.0.2.0/24", 1))}
+
+	changes := DiffPolicies(before, after)
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 change, got %+v", changes)
+	}
+	want := []string{"group corp-saas: 203.0.113.0/24 login.example.com app=sso-proxy -> 192.0.2.0/24 login.example.com app=sso-proxy"}
+	if !reflect.DeepEqual(changes[0].Fields, want) {
+		t.Errorf("Expected fields %v, got %v", want, changes[0].Fields)
+	}
+	if rules := EgressRules(&after[0]); !reflect.DeepEqual(rules, []string{"to group corp-saas TCP/443"}) {
+		t.Errorf("Unexpected egress rules %v", rules)
+	}
+}
+
+func TestEndpointGroupsExample(t *testing.T) {
+	policies, err := LoadFromFile("../../examples/endpoint-groups.yaml")
+	if err != nil {
+		t.Fatalf("LoadFromFile failed: %v", err)
+	}
+	if len(policies) != 2 {
+		t.Fatalf("Expected 2 policies, got %d", len(policies))
+	}
+	for i := range policies {
+		if err := policies[i].Validate(); err != nil {
+			t.Errorf("Expected %s to validate, got %v", policies[i].Metadata.Name, err)
+		}
+	}
+}
@@ -2,11 +2,16 @@ package policy
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"regexp"
+	"sort"
+	"time"
+
+	"ztap/pkg/clock"
 
 	"gopkg.in/yaml.v2"
 )
@@ -16,12 +21,29 @@ type ServiceDiscovery interface {
 	ResolveLabels(labels map[string]string) ([]string, error)
 }
 
+// LabelSource is implemented by discovery sources that know the labels of
+// the IPs they resolve, which rule conditions are evaluated against
+type LabelSource interface {
+	LabelsOf(ip string) (map[string]string, bool)
+}
+
 // NetworkPolicy defines a zero-trust rule
 type NetworkPolicy struct {
 	APIVersion string `yaml:"apiVersion"`
 	Kind       string `yaml:"kind"`
 	Metadata   struct {
 		Name string `yaml:"name"`
+		// ExpiresAt (RFC 3339) or TTL (e.g. "4h", counted from when the agent
+		// first enforces the policy) makes the policy a temporary exception
+		ExpiresAt string `yaml:"expiresAt,omitempty"`
+		TTL       string `yaml:"ttl,omitempty"`
+		// Annotations are free-form notes about the policy, such as its
+		// owner, that do not affect enforcement
+		Annotations map[string]string `yaml:"annotations,omitempty"`
+		// DependsOn names policies that must be applied before this one,
+		// e.g. infrastructure allow rules before an application's default
+		// deny (see Levels)
+		DependsOn []string `yaml:"dependsOn,omitempty"`
 	} `yaml:"metadata"`
 	Spec struct {
 		PodSelector struct {
@@ -35,15 +57,39 @@ type NetworkPolicy struct {
 				IPBlock struct {
 					CIDR string `yaml:"cidr"`
 				} `yaml:"ipBlock,omitempty"`
+				Group string `yaml:"group,omitempty"`
 			} `yaml:"to"`
-			Ports []struct {
-				Protocol string `yaml:"protocol"`
-				Port     int    `yaml:"port"`
-			} `yaml:"ports"`
+			Ports []PolicyPort `yaml:"ports"`
+			// When is a condition the rule's destinations must meet (see
+			// ParseCondition)
+			When string `yaml:"when,omitempty"`
 		} `yaml:"egress"`
+		// Ingress rules allow inbound traffic to the selected workloads
+		// from sources; once a policy has one, other inbound traffic is
+		// denied
+		Ingress []struct {
+			From struct {
+				PodSelector struct {
+					MatchLabels map[string]string `yaml:"matchLabels"`
+				} `yaml:"podSelector,omitempty"`
+				IPBlock struct {
+					CIDR string `yaml:"cidr"`
+				} `yaml:"ipBlock,omitempty"`
+			} `yaml:"from"`
+			Ports []PolicyPort `yaml:"ports"`
+		} `yaml:"ingress,omitempty"`
+		NodeSelector map[string]string `yaml:"nodeSelector,omitempty"`
 	} `yaml:"spec"`
+
+	// Groups are the endpoint groups egress rules reference with to.group,
+	// by name. Parse attaches them; Marshal writes them back as documents.
+	Groups map[string]EndpointGroup `yaml:"-"`
 }
 
+// SelfProtectionPolicy names the built-in policy keeping ZTAP's own control
+// channels open (see agent.SelfProtection); user policies cannot take it
+const SelfProtectionPolicy = "ztap-self-protection"
+
 // LoadFromFile reads policies from a YAML file
 func LoadFromFile(filename string) ([]NetworkPolicy, error) {
 	data, err := os.ReadFile(filename)
@@ -51,19 +97,80 @@ func LoadFromFile(filename string) ([]NetworkPolicy, error) {
 		return nil, err
 	}
 
-	var policies []NetworkPolicy
+	return Parse(data)
+}
+
+// Parse reads policies from multi-document YAML content. SelectorSet
+// documents are expanded into the podSelectors referencing them and
+// EndpointGroup documents attached to the policies referencing them; neither
+// is returned as a policy. Policies sharing a name are merged (see Merge).
+func Parse(data []byte) ([]NetworkPolicy, error) {
+	var docs []document
 	decoder := yaml.NewDecoder(bytes.NewReader(data))
 	for {
-		var policy NetworkPolicy
-		if err := decoder.Decode(&policy); err != nil {
+		var doc document
+		if err := decoder.Decode(&doc); err != nil {
 			if err == io.EOF {
 				break
 			}
 			return nil, err
 		}
-		policies = append(policies, policy)
+		docs = append(docs, doc)
 	}
-	return policies, nil
+	groups, err := endpointGroups(docs)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := expandSelectors(docs)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachGroups(policies, groups); err != nil {
+		return nil, err
+	}
+	// Two documents of one file may only share a name if identical, or
+	// one overrides the other
+	sources := make([]Source, len(policies))
+	for i := range policies {
+		sources[i] = Source{Name: fmt.Sprintf("policy document %d", i+1), Policies: policies[i : i+1]}
+	}
+	return Merge(sources...)
+}
+
+// Marshal renders policies as multi-document YAML, the inverse of Parse.
+// Selector sets are written expanded; the endpoint groups the policies
+// reference follow them, once each.
+func Marshal(policies []NetworkPolicy) ([]byte, error) {
+	var buf bytes.Buffer
+	groups := make(map[string]EndpointGroup)
+	for i, p := range policies {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		data, err := yaml.Marshal(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal policy %s: %w", p.Metadata.Name, err)
+		}
+		buf.Write(data)
+		for name, g := range p.Groups {
+			groups[name] = g
+		}
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, err := yaml.Marshal(groups[name].document())
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal endpoint group %s: %w", name, err)
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
 }
 
 // ValidationError represents a policy validation error
@@ -104,23 +211,64 @@ func (p *NetworkPolicy) Validate() error {
 	if !validName.MatchString(p.Metadata.Name) {
 		return ValidationError{p.Metadata.Name, "metadata.name", "must be lowercase alphanumeric with hyphens"}
 	}
+	if p.Metadata.Name == SelfProtectionPolicy {
+		return ValidationError{p.Metadata.Name, "metadata.name", "is reserved for the built-in self-protection policy"}
+	}
+
+	// Validate expiry
+	if p.Metadata.ExpiresAt != "" && p.Metadata.TTL != "" {
+		return ValidationError{p.Metadata.Name, "metadata", "cannot specify both expiresAt and ttl"}
+	}
+	if p.Metadata.ExpiresAt != "" {
+		if _, err := time.Parse(time.RFC3339, p.Metadata.ExpiresAt); err != nil {
+			return ValidationError{p.Metadata.Name, "metadata.expiresAt", "must be an RFC 3339 time, e.g. 2025-10-31T18:00:00Z"}
+		}
+	}
+	if p.Metadata.TTL != "" {
+		if ttl, err := time.ParseDuration(p.Metadata.TTL); err != nil || ttl <= 0 {
+			return ValidationError{p.Metadata.Name, "metadata.ttl", "must be a positive duration, e.g. 4h"}
+		}
+	}
+
+	// Validate dependencies; references across policies are checked by
+	// CheckDependencies
+	seen := make(map[string]bool, len(p.Metadata.DependsOn))
+	for _, dep := range p.Metadata.DependsOn {
+		switch {
+		case !validName.MatchString(dep):
+			return ValidationError{p.Metadata.Name, "metadata.dependsOn", fmt.Sprintf("%q is not a policy name", dep)}
+		case dep == p.Metadata.Name:
+			return ValidationError{p.Metadata.Name, "metadata.dependsOn", "a policy cannot depend on itself"}
+		case seen[dep]:
+			return ValidationError{p.Metadata.Name, "metadata.dependsOn", fmt.Sprintf("%s is listed twice", dep)}
+		}
+		seen[dep] = true
+	}
 
 	// Check podSelector
 	if len(p.Spec.PodSelector.MatchLabels) == 0 {
 		return ValidationError{p.Metadata.Name, "spec.podSelector", "must have at least one label"}
 	}
 
+	// Check nodeSelector
+	for key, value := range p.Spec.NodeSelector {
+		if key == "" || value == "" {
+			return ValidationError{p.Metadata.Name, "spec.nodeSelector", "labels must have a key and a value"}
+		}
+	}
+
 	// Validate egress rules
 	for i, egress := range p.Spec.Egress {
-		// Must have either podSelector or ipBlock
+		// Must have one of podSelector, ipBlock or group
 		hasPodSelector := len(egress.To.PodSelector.MatchLabels) > 0
 		hasIPBlock := egress.To.IPBlock.CIDR != ""
+		hasGroup := egress.To.Group != ""
 
-		if !hasPodSelector && !hasIPBlock {
+		if !hasPodSelector && !hasIPBlock && !hasGroup {
 			return ValidationError{
 				p.Metadata.Name,
 				fmt.Sprintf("spec.egress[%d].to", i),
-				"must specify either podSelector or ipBlock",
+				"must specify either podSelector, ipBlock or group",
 			}
 		}
 
@@ -132,6 +280,24 @@ func (p *NetworkPolicy) Validate() error {
 			}
 		}
 
+		if hasGroup && (hasPodSelector || hasIPBlock) {
+			return ValidationError{
+				p.Metadata.Name,
+				fmt.Sprintf("spec.egress[%d].to", i),
+				"cannot specify group with podSelector or ipBlock",
+			}
+		}
+
+		if hasGroup {
+			if _, ok := p.Groups[egress.To.Group]; !ok {
+				return ValidationError{
+					p.Metadata.Name,
+					fmt.Sprintf("spec.egress[%d].to.group", i),
+					fmt.Sprintf("unknown endpoint group %q", egress.To.Group),
+				}
+			}
+		}
+
 		// Validate CIDR if present
 		if hasIPBlock {
 			_, _, err := net.ParseCIDR(egress.To.IPBlock.CIDR)
@@ -144,6 +310,16 @@ func (p *NetworkPolicy) Validate() error {
 			}
 		}
 
+		if egress.When != "" {
+			if _, err := ParseCondition(egress.When); err != nil {
+				return ValidationError{
+					p.Metadata.Name,
+					fmt.Sprintf("spec.egress[%d].when", i),
+					err.Error(),
+				}
+			}
+		}
+
 		// Validate ports
 		if len(egress.Ports) == 0 {
 			return ValidationError{
@@ -154,46 +330,166 @@ func (p *NetworkPolicy) Validate() error {
 		}
 
 		for j, port := range egress.Ports {
-			// Validate protocol
-			validProtocols := map[string]bool{"TCP": true, "UDP": true, "ICMP": true}
-			if !validProtocols[port.Protocol] {
-				return ValidationError{
-					p.Metadata.Name,
-					fmt.Sprintf("spec.egress[%d].ports[%d].protocol", i, j),
-					"must be TCP, UDP, or ICMP",
-				}
+			if err := port.validate(p.Metadata.Name, fmt.Sprintf("spec.egress[%d].ports[%d]", i, j)); err != nil {
+				return err
 			}
+		}
+	}
 
-			// Validate port number
-			if port.Port < 1 || port.Port > 65535 {
+	// Validate ingress rules
+	for i, ingress := range p.Spec.Ingress {
+		// Must have exactly one of podSelector or ipBlock
+		hasPodSelector := len(ingress.From.PodSelector.MatchLabels) > 0
+		hasIPBlock := ingress.From.IPBlock.CIDR != ""
+
+		if hasPodSelector == hasIPBlock {
+			return ValidationError{
+				p.Metadata.Name,
+				fmt.Sprintf("spec.ingress[%d].from", i),
+				"must specify either podSelector or ipBlock",
+			}
+		}
+
+		if hasIPBlock {
+			if _, _, err := net.ParseCIDR(ingress.From.IPBlock.CIDR); err != nil {
 				return ValidationError{
 					p.Metadata.Name,
-					fmt.Sprintf("spec.egress[%d].ports[%d].port", i, j),
-					"must be between 1 and 65535",
+					fmt.Sprintf("spec.ingress[%d].from.ipBlock.cidr", i),
+					fmt.Sprintf("invalid CIDR: %v", err),
 				}
 			}
 		}
+
+		if len(ingress.Ports) == 0 {
+			return ValidationError{
+				p.Metadata.Name,
+				fmt.Sprintf("spec.ingress[%d].ports", i),
+				"must specify at least one port",
+			}
+		}
+
+		for j, port := range ingress.Ports {
+			if err := port.validate(p.Metadata.Name, fmt.Sprintf("spec.ingress[%d].ports[%d]", i, j)); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
-// PolicyResolver handles label resolution with service discovery
+// Expiry returns when a temporary policy expires, given when it was first
+// enforced (used for ttl). ok is false for a permanent policy. An expiry that
+// does not parse is treated as already passed, so a malformed exception
+// fails closed.
+func (p *NetworkPolicy) Expiry(firstEnforced time.Time) (expiresAt time.Time, ok bool) {
+	switch {
+	case p.Metadata.ExpiresAt != "":
+		expiresAt, err := time.Parse(time.RFC3339, p.Metadata.ExpiresAt)
+		if err != nil {
+			return time.Time{}, true
+		}
+		return expiresAt, true
+	case p.Metadata.TTL != "":
+		ttl, err := time.ParseDuration(p.Metadata.TTL)
+		if err != nil {
+			return time.Time{}, true
+		}
+		return firstEnforced.Add(ttl), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// Selects reports whether the policy's podSelector matches a workload or
+// node with labels
+func (p *NetworkPolicy) Selects(labels map[string]string) bool {
+	return selects(p.Spec.PodSelector.MatchLabels, labels)
+}
+
+// SelectsNode reports whether the policy is distributed to a node with
+// labels: one with a nodeSelector goes to the nodes it matches, one without
+// to every node
+func (p *NetworkPolicy) SelectsNode(labels map[string]string) bool {
+	return selects(p.Spec.NodeSelector, labels)
+}
+
+// PolicyResolver handles label resolution with one or more discovery sources,
+// e.g. local service discovery and cloud inventory
 type PolicyResolver struct {
-	discovery ServiceDiscovery
+	sources    []ServiceDiscovery
+	lookupHost func(host string) ([]string, error) // Resolves group FQDNs, net.LookupHost if nil
+	clock      clock.Clock                         // Time rule conditions are evaluated at
+}
+
+// NewPolicyResolver creates a new resolver over the given discovery backends,
+// highest precedence first. Nil backends are ignored.
+func NewPolicyResolver(sources ...ServiceDiscovery) *PolicyResolver {
+	r := &PolicyResolver{clock: clock.Real}
+	for _, source := range sources {
+		if source != nil {
+			r.sources = append(r.sources, source)
+		}
+	}
+	return r
 }
 
-// NewPolicyResolver creates a new resolver with the given discovery backend
-func NewPolicyResolver(discovery ServiceDiscovery) *PolicyResolver {
-	return &PolicyResolver{discovery: discovery}
+// SetClock replaces the clock rule conditions read the time from, e.g.
+// with a fake clock in tests
+func (r *PolicyResolver) SetClock(clk clock.Clock) {
+	r.clock = clock.OrReal(clk)
 }
 
-// ResolveLabels converts label selectors to IP addresses using service discovery
+// LabelsOf returns the labels of ip reported by the highest-precedence
+// source that knows it
+func (r *PolicyResolver) LabelsOf(ip string) (map[string]string, bool) {
+	for _, source := range r.sources {
+		if labeled, ok := source.(LabelSource); ok {
+			if labels, ok := labeled.LabelsOf(ip); ok {
+				return labels, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// ResolveLabels converts label selectors to IP addresses by merging every
+// source's matches. IPs are deduplicated, keeping the order and form of the
+// highest-precedence source reporting them. A source that fails or finds
+// nothing is skipped; an error is returned only if no source found an IP.
 func (r *PolicyResolver) ResolveLabels(labels map[string]string) ([]string, error) {
-	if r.discovery == nil {
+	if r == nil || len(r.sources) == 0 {
 		return nil, fmt.Errorf("no service discovery backend configured")
 	}
-	return r.discovery.ResolveLabels(labels)
+
+	var ips []string
+	var errs []error
+	seen := make(map[string]bool)
+	for _, source := range r.sources {
+		found, err := source.ResolveLabels(labels)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, ip := range found {
+			key := ip
+			if parsed := net.ParseIP(ip); parsed != nil {
+				key = parsed.String()
+			}
+			if !seen[key] {
+				seen[key] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	if len(ips) == 0 {
+		if len(errs) > 0 {
+			return nil, errors.Join(errs...)
+		}
+		return nil, fmt.Errorf("no services found matching labels: %v", labels)
+	}
+	return ips, nil
 }
 
 // ResolveLabels (standalone) is deprecated, use PolicyResolver instead
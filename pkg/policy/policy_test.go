@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadFromFile(t *testing.T) {
@@ -95,7 +98,11 @@ func TestValidate(t *testing.T) {
 				APIVersion: "ztap/v1",
 				Kind:       "NetworkPolicy",
 				Metadata: struct {
-					Name string `yaml:"name"`
+					Name        string            `yaml:"name"`
+					ExpiresAt   string            `yaml:"expiresAt,omitempty"`
+					TTL         string            `yaml:"ttl,omitempty"`
+					Annotations map[string]string `yaml:"annotations,omitempty"`
+					DependsOn   []string          `yaml:"dependsOn,omitempty"`
 				}{Name: "valid-policy"},
 				Spec: struct {
 					PodSelector struct {
@@ -109,12 +116,23 @@ func TestValidate(t *testing.T) {
 							IPBlock struct {
 								CIDR string `yaml:"cidr"`
 							} `yaml:"ipBlock,omitempty"`
+							Group string `yaml:"group,omitempty"`
 						} `yaml:"to"`
-						Ports []struct {
-							Protocol string `yaml:"protocol"`
-							Port     int    `yaml:"port"`
-						} `yaml:"ports"`
+						Ports []PolicyPort `yaml:"ports"`
+						When  string       `yaml:"when,omitempty"`
 					} `yaml:"egress"`
+					Ingress []struct {
+						From struct {
+							PodSelector struct {
+								MatchLabels map[string]string `yaml:"matchLabels"`
+							} `yaml:"podSelector,omitempty"`
+							IPBlock struct {
+								CIDR string `yaml:"cidr"`
+							} `yaml:"ipBlock,omitempty"`
+						} `yaml:"from"`
+						Ports []PolicyPort `yaml:"ports"`
+					} `yaml:"ingress,omitempty"`
+					NodeSelector map[string]string `yaml:"nodeSelector,omitempty"`
 				}{
 					PodSelector: struct {
 						MatchLabels map[string]string `yaml:"matchLabels"`
@@ -129,11 +147,10 @@ func TestValidate(t *testing.T) {
 							IPBlock struct {
 								CIDR string `yaml:"cidr"`
 							} `yaml:"ipBlock,omitempty"`
+							Group string `yaml:"group,omitempty"`
 						} `yaml:"to"`
-						Ports []struct {
-							Protocol string `yaml:"protocol"`
-							Port     int    `yaml:"port"`
-						} `yaml:"ports"`
+						Ports []PolicyPort `yaml:"ports"`
+						When  string       `yaml:"when,omitempty"`
 					}{
 						{
 							To: struct {
@@ -143,15 +160,13 @@ func TestValidate(t *testing.T) {
 								IPBlock struct {
 									CIDR string `yaml:"cidr"`
 								} `yaml:"ipBlock,omitempty"`
+								Group string `yaml:"group,omitempty"`
 							}{
 								IPBlock: struct {
 									CIDR string `yaml:"cidr"`
 								}{CIDR: "10.0.0.0/8"},
 							},
-							Ports: []struct {
-								Protocol string `yaml:"protocol"`
-								Port     int    `yaml:"port"`
-							}{
+							Ports: []PolicyPort{
 								{Protocol: "TCP", Port: 443},
 							},
 						},
@@ -165,7 +180,11 @@ func TestValidate(t *testing.T) {
 			policy: NetworkPolicy{
 				Kind: "NetworkPolicy",
 				Metadata: struct {
-					Name string `yaml:"name"`
+					Name        string            `yaml:"name"`
+					ExpiresAt   string            `yaml:"expiresAt,omitempty"`
+					TTL         string            `yaml:"ttl,omitempty"`
+					Annotations map[string]string `yaml:"annotations,omitempty"`
+					DependsOn   []string          `yaml:"dependsOn,omitempty"`
 				}{Name: "test"},
 			},
 			expectError: true,
@@ -176,7 +195,11 @@ func TestValidate(t *testing.T) {
 				APIVersion: "ztap/v1",
 				Kind:       "NetworkPolicy",
 				Metadata: struct {
-					Name string `yaml:"name"`
+					Name        string            `yaml:"name"`
+					ExpiresAt   string            `yaml:"expiresAt,omitempty"`
+					TTL         string            `yaml:"ttl,omitempty"`
+					Annotations map[string]string `yaml:"annotations,omitempty"`
+					DependsOn   []string          `yaml:"dependsOn,omitempty"`
 				}{Name: "test"},
 				Spec: struct {
 					PodSelector struct {
@@ -190,12 +213,23 @@ func TestValidate(t *testing.T) {
 							IPBlock struct {
 								CIDR string `yaml:"cidr"`
 							} `yaml:"ipBlock,omitempty"`
+							Group string `yaml:"group,omitempty"`
 						} `yaml:"to"`
-						Ports []struct {
-							Protocol string `yaml:"protocol"`
-							Port     int    `yaml:"port"`
-						} `yaml:"ports"`
+						Ports []PolicyPort `yaml:"ports"`
+						When  string       `yaml:"when,omitempty"`
 					} `yaml:"egress"`
+					Ingress []struct {
+						From struct {
+							PodSelector struct {
+								MatchLabels map[string]string `yaml:"matchLabels"`
+							} `yaml:"podSelector,omitempty"`
+							IPBlock struct {
+								CIDR string `yaml:"cidr"`
+							} `yaml:"ipBlock,omitempty"`
+						} `yaml:"from"`
+						Ports []PolicyPort `yaml:"ports"`
+					} `yaml:"ingress,omitempty"`
+					NodeSelector map[string]string `yaml:"nodeSelector,omitempty"`
 				}{
 					PodSelector: struct {
 						MatchLabels map[string]string `yaml:"matchLabels"`
@@ -210,11 +244,10 @@ func TestValidate(t *testing.T) {
 							IPBlock struct {
 								CIDR string `yaml:"cidr"`
 							} `yaml:"ipBlock,omitempty"`
+							Group string `yaml:"group,omitempty"`
 						} `yaml:"to"`
-						Ports []struct {
-							Protocol string `yaml:"protocol"`
-							Port     int    `yaml:"port"`
-						} `yaml:"ports"`
+						Ports []PolicyPort `yaml:"ports"`
+						When  string       `yaml:"when,omitempty"`
 					}{
 						{
 							To: struct {
@@ -224,15 +257,13 @@ func TestValidate(t *testing.T) {
 								IPBlock struct {
 									CIDR string `yaml:"cidr"`
 								} `yaml:"ipBlock,omitempty"`
+								Group string `yaml:"group,omitempty"`
 							}{
 								IPBlock: struct {
 									CIDR string `yaml:"cidr"`
 								}{CIDR: "invalid-cidr"},
 							},
-							Ports: []struct {
-								Protocol string `yaml:"protocol"`
-								Port     int    `yaml:"port"`
-							}{
+							Ports: []PolicyPort{
 								{Protocol: "TCP", Port: 443},
 							},
 						},
@@ -247,7 +278,11 @@ func TestValidate(t *testing.T) {
 				APIVersion: "ztap/v1",
 				Kind:       "NetworkPolicy",
 				Metadata: struct {
-					Name string `yaml:"name"`
+					Name        string            `yaml:"name"`
+					ExpiresAt   string            `yaml:"expiresAt,omitempty"`
+					TTL         string            `yaml:"ttl,omitempty"`
+					Annotations map[string]string `yaml:"annotations,omitempty"`
+					DependsOn   []string          `yaml:"dependsOn,omitempty"`
 				}{Name: "test"},
 				Spec: struct {
 					PodSelector struct {
@@ -261,12 +296,23 @@ func TestValidate(t *testing.T) {
 							IPBlock struct {
 								CIDR string `yaml:"cidr"`
 							} `yaml:"ipBlock,omitempty"`
+							Group string `yaml:"group,omitempty"`
 						} `yaml:"to"`
-						Ports []struct {
-							Protocol string `yaml:"protocol"`
-							Port     int    `yaml:"port"`
-						} `yaml:"ports"`
+						Ports []PolicyPort `yaml:"ports"`
+						When  string       `yaml:"when,omitempty"`
 					} `yaml:"egress"`
+					Ingress []struct {
+						From struct {
+							PodSelector struct {
+								MatchLabels map[string]string `yaml:"matchLabels"`
+							} `yaml:"podSelector,omitempty"`
+							IPBlock struct {
+								CIDR string `yaml:"cidr"`
+							} `yaml:"ipBlock,omitempty"`
+						} `yaml:"from"`
+						Ports []PolicyPort `yaml:"ports"`
+					} `yaml:"ingress,omitempty"`
+					NodeSelector map[string]string `yaml:"nodeSelector,omitempty"`
 				}{
 					PodSelector: struct {
 						MatchLabels map[string]string `yaml:"matchLabels"`
@@ -281,11 +327,10 @@ func TestValidate(t *testing.T) {
 							IPBlock struct {
 								CIDR string `yaml:"cidr"`
 							} `yaml:"ipBlock,omitempty"`
+							Group string `yaml:"group,omitempty"`
 						} `yaml:"to"`
-						Ports []struct {
-							Protocol string `yaml:"protocol"`
-							Port     int    `yaml:"port"`
-						} `yaml:"ports"`
+						Ports []PolicyPort `yaml:"ports"`
+						When  string       `yaml:"when,omitempty"`
 					}{
 						{
 							To: struct {
@@ -295,15 +340,13 @@ func TestValidate(t *testing.T) {
 								IPBlock struct {
 									CIDR string `yaml:"cidr"`
 								} `yaml:"ipBlock,omitempty"`
+								Group string `yaml:"group,omitempty"`
 							}{
 								IPBlock: struct {
 									CIDR string `yaml:"cidr"`
 								}{CIDR: "10.0.0.0/8"},
 							},
-							Ports: []struct {
-								Protocol string `yaml:"protocol"`
-								Port     int    `yaml:"port"`
-							}{
+							Ports: []PolicyPort{
 								{Protocol: "TCP", Port: 99999},
 							},
 						},
@@ -355,6 +398,106 @@ func TestPolicyResolver(t *testing.T) {
 	}
 }
 
+func TestPolicyResolverMultipleSources(t *testing.T) {
+	local := &mockDiscovery{services: map[string][]string{
+		"app=web": {"192.168.1.10", "10.0.1.1"},
+		"app=db":  {"192.168.1.20"},
+	}}
+	cloud := &mockDiscovery{services: map[string][]string{
+		"app=web":   {"10.0.1.1", "10.0.1.2"},
+		"app=cache": {"10.0.3.1"},
+	}}
+	resolver := NewPolicyResolver(local, nil, cloud)
+
+	// Matches from every source are merged, duplicates kept once in
+	// precedence order
+	ips, err := resolver.ResolveLabels(map[string]string{"app": "web"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if want := []string{"192.168.1.10", "10.0.1.1", "10.0.1.2"}; !reflect.DeepEqual(ips, want) {
+		t.Errorf("Expected %v, got %v", want, ips)
+	}
+
+	// A selector only one source knows still resolves
+	for _, app := range []string{"db", "cache"} {
+		if ips, err := resolver.ResolveLabels(map[string]string{"app": app}); err != nil || len(ips) != 1 {
+			t.Errorf("Expected app=%s to resolve from one source, got %v, %v", app, ips, err)
+		}
+	}
+
+	if _, err := resolver.ResolveLabels(map[string]string{"app": "none"}); err == nil {
+		t.Error("Expected error when no source matches")
+	}
+	if _, err := NewPolicyResolver(nil).ResolveLabels(map[string]string{"app": "web"}); err == nil {
+		t.Error("Expected error without sources")
+	}
+}
+
+func TestPolicyResolverDeduplicatesIPForms(t *testing.T) {
+	a := &mockDiscovery{services: map[string][]string{"app=web": {"2001:db8::1"}}}
+	b := &mockDiscovery{services: map[string][]string{"app=web": {"2001:0db8:0:0::1"}}}
+
+	ips, err := NewPolicyResolver(a, b).ResolveLabels(map[string]string{"app": "web"})
+	if err != nil || len(ips) != 1 || ips[0] != "2001:db8::1" {
+		t.Errorf("Expected one IP in the first source's form, got %v, %v", ips, err)
+	}
+}
+
+func TestPolicyExpiry(t *testing.T) {
+	const base = `apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: break-glass
+%s
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/24
+      ports:
+        - protocol: TCP
+          port: 22
+`
+	start := time.Date(2025, 10, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		metadata  string
+		valid     bool
+		temporary bool
+		expiresAt time.Time
+	}{
+		{"permanent", "", true, false, time.Time{}},
+		{"expiresAt", "  expiresAt: 2025-10-31T18:00:00Z", true, true, time.Date(2025, 10, 31, 18, 0, 0, 0, time.UTC)},
+		{"ttl", "  ttl: 4h", true, true, start.Add(4 * time.Hour)},
+		{"both", "  expiresAt: 2025-10-31T18:00:00Z\n  ttl: 4h", false, true, time.Date(2025, 10, 31, 18, 0, 0, 0, time.UTC)},
+		{"bad expiresAt", "  expiresAt: tomorrow", false, true, time.Time{}},
+		{"negative ttl", "  ttl: -1h", false, true, start.Add(-time.Hour)},
+		{"bad ttl", "  ttl: four hours", false, true, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policies, err := Parse([]byte(fmt.Sprintf(base, tt.metadata)))
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			p := policies[0]
+			if err := p.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid=%v", err, tt.valid)
+			}
+			// Malformed expiries are treated as already passed
+			expiresAt, temporary := p.Expiry(start)
+			if temporary != tt.temporary || !expiresAt.Equal(tt.expiresAt) {
+				t.Errorf("Expiry() = %v, %v, want %v, %v", expiresAt, temporary, tt.expiresAt, tt.temporary)
+			}
+		})
+	}
+}
+
 // Mock discovery for testing
 type mockDiscovery struct {
 	services map[string][]string
@@ -387,3 +530,149 @@ func (m *mockDiscovery) DeregisterService(name string) error {
 func (m *mockDiscovery) Watch(ctx context.Context, labels map[string]string) (<-chan []string, error) {
 	return nil, nil
 }
+
+func TestNodeSelector(t *testing.T) {
+	const withNodeSelector = `apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: edge-egress
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  nodeSelector:
+    role: edge
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/24
+      ports:
+        - protocol: TCP
+          port: 443
+`
+	p := mustParse(t, withNodeSelector)
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if !p.SelectsNode(map[string]string{"role": "edge", "zone": "a"}) || p.SelectsNode(map[string]string{"role": "app"}) || p.SelectsNode(nil) {
+		t.Error("Expected nodeSelector to match edge nodes only")
+	}
+
+	// Without a nodeSelector a policy goes to every node
+	p.Spec.NodeSelector = nil
+	if !p.SelectsNode(nil) {
+		t.Error("Expected policy without nodeSelector to select every node")
+	}
+	data, err := Marshal([]NetworkPolicy{p})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if strings.Contains(string(data), "nodeSelector") {
+		t.Errorf("Expected empty nodeSelector to be omitted:\n%s", data)
+	}
+
+	p.Spec.NodeSelector = map[string]string{"role": ""}
+	if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "spec.nodeSelector") {
+		t.Errorf("Expected error for a label without value, got %v", err)
+	}
+
+	before := []NetworkPolicy{mustParse(t, withNodeSelector)}
+	after := []NetworkPolicy{mustParse(t, strings.Replace(withNodeSelector, "role: edge", "role: app", 1))}
+	changes := DiffPolicies(before, after)
+	if len(changes) != 1 || !reflect.DeepEqual(changes[0].Fields, []string{"nodeSelector: role=edge -> role=app"}) {
+		t.Errorf("Unexpected changes %+v", changes)
+	}
+}
+
+func TestValidateReservesSelfProtection(t *testing.T) {
+	p := NetworkPolicy{APIVersion: "ztap/v1", Kind: "NetworkPolicy"}
+	p.Metadata.Name = SelfProtectionPolicy
+	p.Spec.PodSelector.MatchLabels = map[string]string{"app": "web"}
+	if err := p.Validate(); err == nil {
+		t.Error("Expected the self-protection name to be reserved")
+	}
+}
+
+func TestIngress(t *testing.T) {
+	const withIngress = `apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: db-ingress
+spec:
+  podSelector:
+    matchLabels:
+      app: db
+  egress: []
+  ingress:
+    - from:
+        podSelector:
+          matchLabels:
+            app: web
+      ports:
+        - protocol: TCP
+          port: 5432
+    - from:
+        ipBlock:
+          cidr: 10.9.0.0/16
+      ports:
+        - protocol: TCP
+          port: 22
+`
+	p := mustParse(t, withIngress)
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if features := p.Features(); !reflect.DeepEqual(features, []string{FeatureIngress}) {
+		t.Errorf("Expected the ingress feature, got %v", features)
+	}
+	expected := []string{CapabilityIngress, CapabilityIPv4, CapabilityLabelSelectors}
+	if got := p.RequiredCapabilities(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+
+	resolver := NewPolicyResolver(&mockDiscovery{services: map[string][]string{"app=web": {"10.0.1.2", "10.0.1.1"}}})
+	compiled, err := resolver.Compile(p)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	want := []Rule{
+		{Policy: "db-ingress", CIDR: "10.0.1.1/32", Protocol: "TCP", Port: 5432, Direction: DirectionIngress},
+		{Policy: "db-ingress", CIDR: "10.0.1.2/32", Protocol: "TCP", Port: 5432, Direction: DirectionIngress},
+		{Policy: "db-ingress", CIDR: "10.9.0.0/16", Protocol: "TCP", Port: 22, Direction: DirectionIngress},
+	}
+	if !reflect.DeepEqual(compiled.Rules, want) || compiled.Endpoints != 2 {
+		t.Errorf("Expected ingress rules %+v, got %+v (%d endpoints)", want, compiled.Rules, compiled.Endpoints)
+	}
+
+	// Inbound flows to the database need an ingress rule; the web tier's
+	// own egress is not enforced
+	flow := Flow{From: Endpoint{Labels: map[string]string{"app": "web"}}, To: Endpoint{Labels: map[string]string{"app": "db"}}, Port: 5432}
+	if v := Simulate([]NetworkPolicy{p}, flow); !v.Allowed {
+		t.Errorf("Expected web to reach the database, got %s", v.Reason)
+	}
+	flow.From = Endpoint{IP: "192.0.2.1"}
+	if v := Simulate([]NetworkPolicy{p}, flow); v.Allowed || !strings.Contains(v.Reason, "no ingress rule of db-ingress") {
+		t.Errorf("Expected an unknown source denied, got %+v", v)
+	}
+	flow.From, flow.Port = Endpoint{IP: "10.9.3.4"}, 22
+	if v := Simulate([]NetworkPolicy{p}, flow); !v.Allowed {
+		t.Errorf("Expected SSH from the bastion network allowed, got %s", v.Reason)
+	}
+
+	after := mustParse(t, strings.Replace(withIngress, "port: 22", "port: 2222", 1))
+	changes := DiffPolicies([]NetworkPolicy{p}, []NetworkPolicy{after})
+	if len(changes) != 1 || !reflect.DeepEqual(changes[0].Added, []string{"from 10.9.0.0/16 TCP/2222"}) ||
+		!reflect.DeepEqual(changes[0].Removed, []string{"from 10.9.0.0/16 TCP/22"}) {
+		t.Errorf("Unexpected changes %+v", changes)
+	}
+
+	p.Spec.Ingress[1].From.PodSelector.MatchLabels = map[string]string{"app": "bastion"}
+	if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "spec.ingress[1].from") {
+		t.Errorf("Expected an error for both podSelector and ipBlock, got %v", err)
+	}
+	p.Spec.Ingress[1].From.PodSelector.MatchLabels = nil
+	p.Spec.Ingress[1].Ports[0].Port = 0
+	if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "spec.ingress[1].ports[0].port") {
+		t.Errorf("Expected an error for port 0, got %v", err)
+	}
+}
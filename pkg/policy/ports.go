@@ -0,0 +1,167 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PolicyPort is an entry of a rule's ports: one port, or the range from Port
+// to EndPort. In YAML the port may be a number (443), a range ("8000-8080"),
+// which is the same as port: 8000 with endPort: 8080, or a service name
+// ("https", see PortNames).
+type PolicyPort struct {
+	Protocol string
+	Port     int
+	EndPort  int    // Last port of a range; 0 for a single port
+	Name     string // Service name the port was written as, if any
+}
+
+// PortNames are the service names a port may be written as, and their
+// well-known port
+var PortNames = map[string]int{
+	"dns":        53,
+	"domain":     53,
+	"ftp":        21,
+	"grpc":       50051,
+	"http":       80,
+	"http-alt":   8080,
+	"https":      443,
+	"imap":       143,
+	"imaps":      993,
+	"kafka":      9092,
+	"kerberos":   88,
+	"ldap":       389,
+	"ldaps":      636,
+	"memcached":  11211,
+	"mongodb":    27017,
+	"mysql":      3306,
+	"nfs":        2049,
+	"ntp":        123,
+	"postgres":   5432,
+	"postgresql": 5432,
+	"rdp":        3389,
+	"redis":      6379,
+	"smtp":       25,
+	"smtps":      465,
+	"snmp":       161,
+	"ssh":        22,
+	"submission": 587,
+	"syslog":     514,
+}
+
+// portYAML is a PolicyPort as written in a policy file
+type portYAML struct {
+	Protocol string `yaml:"protocol"`
+	Port     any    `yaml:"port"`
+	EndPort  int    `yaml:"endPort,omitempty"`
+}
+
+// UnmarshalYAML reads the port as a number, a range or a service name. A
+// name that is not in PortNames leaves Port 0, and is reported by Validate.
+func (p *PolicyPort) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw struct {
+		Protocol string `yaml:"protocol"`
+		Port     string `yaml:"port"`
+		EndPort  int    `yaml:"endPort"`
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	*p = PolicyPort{Protocol: raw.Protocol, EndPort: raw.EndPort}
+
+	value := strings.TrimSpace(raw.Port)
+	if value == "" {
+		return nil
+	}
+	if port, err := strconv.Atoi(value); err == nil {
+		p.Port = port
+		return nil
+	}
+	if first, last, ok := strings.Cut(value, "-"); ok {
+		low, lowErr := strconv.Atoi(first)
+		high, highErr := strconv.Atoi(last)
+		if lowErr == nil && highErr == nil {
+			if raw.EndPort != 0 {
+				return fmt.Errorf("port range %q cannot be combined with endPort", value)
+			}
+			p.Port, p.EndPort = low, high
+			return nil
+		}
+	}
+	p.Name = strings.ToLower(value)
+	p.Port = PortNames[p.Name]
+	return nil
+}
+
+// MarshalYAML writes a named port by its name and a range as port and
+// endPort
+func (p PolicyPort) MarshalYAML() (interface{}, error) {
+	out := portYAML{Protocol: p.Protocol, Port: p.Port, EndPort: p.EndPort}
+	if p.Name != "" {
+		out.Port = p.Name
+	}
+	return out, nil
+}
+
+// LastPort returns the last port covered: EndPort for a range, Port
+// otherwise
+func (p PolicyPort) LastPort() int {
+	return max(p.Port, p.EndPort)
+}
+
+// CoversPort reports whether port is in the entry's range
+func (p PolicyPort) CoversPort(port int) bool {
+	return port >= p.Port && port <= p.LastPort()
+}
+
+// Ports returns the number of ports the entry covers
+func (p PolicyPort) Ports() int {
+	return p.LastPort() - p.Port + 1
+}
+
+// String returns the protocol and port, e.g. "TCP/443" or "TCP/8000-8080"
+func (p PolicyPort) String() string {
+	return strings.ToUpper(p.Protocol) + "/" + PortRange(p.Port, p.EndPort)
+}
+
+// rangeEnd returns EndPort for a range of more than one port, 0 otherwise,
+// as compiled rules hold it
+func (p PolicyPort) rangeEnd() int {
+	if p.EndPort > p.Port {
+		return p.EndPort
+	}
+	return 0
+}
+
+// PortRange formats the ports from port to endPort, e.g. "443" or
+// "8000-8080"; endPort is ignored unless past port
+func PortRange(port, endPort int) string {
+	if endPort > port {
+		return fmt.Sprintf("%d-%d", port, endPort)
+	}
+	return strconv.Itoa(port)
+}
+
+// validate checks the protocol and ports of the entry at field
+func (p PolicyPort) validate(policyName, field string) error {
+	validProtocols := map[string]bool{"TCP": true, "UDP": true, "ICMP": true}
+	if !validProtocols[p.Protocol] {
+		return ValidationError{policyName, field + ".protocol", "must be TCP, UDP, or ICMP"}
+	}
+	if p.Name != "" && p.Port == 0 {
+		return ValidationError{policyName, field + ".port", fmt.Sprintf("unknown port name %q", p.Name)}
+	}
+	if p.Port < 1 || p.Port > 65535 {
+		return ValidationError{policyName, field + ".port", "must be between 1 and 65535"}
+	}
+	if p.EndPort != 0 {
+		if p.Name != "" {
+			return ValidationError{policyName, field + ".endPort", "cannot be used with a named port"}
+		}
+		if p.EndPort < p.Port || p.EndPort > 65535 {
+			return ValidationError{policyName, field + ".endPort", "must be between port and 65535"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package policy
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const portsTestPolicy = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-backends
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/16
+      ports:
+        - protocol: TCP
+          port: https
+        - protocol: TCP
+          port: 8000
+          endPort: 8003
+        - protocol: UDP
+          port: "5000-5001"
+  ingress:
+    - from:
+        ipBlock:
+          cidr: 10.9.0.0/16
+      ports:
+        - protocol: TCP
+          port: SSH
+`
+
+func TestPolicyPorts(t *testing.T) {
+	p := mustParse(t, portsTestPolicy)
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	expected := []PolicyPort{
+		{Protocol: "TCP", Port: 443, Name: "https"},
+		{Protocol: "TCP", Port: 8000, EndPort: 8003},
+		{Protocol: "UDP", Port: 5000, EndPort: 5001},
+	}
+	if !reflect.DeepEqual(p.Spec.Egress[0].Ports, expected) {
+		t.Errorf("Expected ports %+v, got %+v", expected, p.Spec.Egress[0].Ports)
+	}
+	if port := p.Spec.Ingress[0].Ports[0]; port.Port != 22 || port.Name != "ssh" {
+		t.Errorf("Expected names matched case-insensitively, got %+v", port)
+	}
+
+	// Ranges compile to one rule, and cover every port in them
+	compiled, err := NewPolicyResolver(&mockDiscovery{}).Compile(p)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if len(compiled.Rules) != 4 {
+		t.Fatalf("Expected 4 rules, got %+v", compiled.Rules)
+	}
+	if r := compiled.Rules[0]; r.Port != 443 || r.EndPort != 0 || r.PortRange() != "443" {
+		t.Errorf("Expected the named port compiled to its number, got %+v", r)
+	}
+	if r := compiled.Rules[1]; r.EndPort != 8003 || !r.CoversPort(8002) || r.CoversPort(8004) || r.PortRange() != "8000-8003" {
+		t.Errorf("Expected the range 8000-8003, got %+v", r)
+	}
+	flow := Flow{From: Endpoint{Labels: map[string]string{"app": "web"}}, To: Endpoint{IP: "10.0.1.1"}, Protocol: "UDP", Port: 5001}
+	if v := Simulate([]NetworkPolicy{p}, flow); !v.Allowed {
+		t.Errorf("Expected the end of the range allowed, got %s", v.Reason)
+	}
+	flow.Port = 5002
+	if v := Simulate([]NetworkPolicy{p}, flow); v.Allowed {
+		t.Error("Expected a port past the range denied")
+	}
+	if rules := EgressRules(&p); !reflect.DeepEqual(rules, []string{"to 10.0.0.0/16 TCP/443", "to 10.0.0.0/16 TCP/8000-8003", "to 10.0.0.0/16 UDP/5000-5001"}) {
+		t.Errorf("Unexpected egress rules %v", rules)
+	}
+
+	// Names are written back as names, and ranges as port and endPort
+	data, err := Marshal([]NetworkPolicy{p})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), "port: https") || !strings.Contains(string(data), "endPort: 5001") {
+		t.Errorf("Unexpected marshaled ports:\n%s", data)
+	}
+	again, err := Parse(data)
+	if err != nil || !reflect.DeepEqual(again[0].Spec.Egress[0].Ports, expected) {
+		t.Errorf("Expected the ports to round-trip, got %+v (%v)", again, err)
+	}
+}
+
+func TestPolicyPortsValidation(t *testing.T) {
+	tests := []struct {
+		ports    string
+		expected string
+	}{
+		{"port: http2", `spec.egress[0].ports[0].port: unknown port name "http2"`},
+		{"port: 8080\n          endPort: 8000", "spec.egress[0].ports[0].endPort: must be between port and 65535"},
+		{"port: 8080\n          endPort: 70000", "spec.egress[0].ports[0].endPort: must be between port and 65535"},
+		{"port: https\n          endPort: 8443", "spec.egress[0].ports[0].endPort: cannot be used with a named port"},
+		{`port: "0-10"`, "spec.egress[0].ports[0].port: must be between 1 and 65535"},
+	}
+	for _, tt := range tests {
+		policies, err := Parse([]byte(strings.Replace(testPortPolicy, "port: 443", tt.ports, 1)))
+		if err != nil {
+			t.Errorf("%s: Parse failed: %v", tt.ports, err)
+			continue
+		}
+		if err := policies[0].Validate(); err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected %q, got %v", tt.ports, tt.expected, err)
+		}
+	}
+
+	if _, err := Parse([]byte(strings.Replace(testPortPolicy, "port: 443", "port: 8000-8080\n          endPort: 9000", 1))); err == nil {
+		t.Error("Expected a range combined with endPort rejected")
+	}
+}
+
+// testPortPolicy has a single port entry, port: 443
+const testPortPolicy = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/16
+      ports:
+        - protocol: TCP
+          port: 443
+`
+
+func TestDowngradePorts(t *testing.T) {
+	p := mustParse(t, portsTestPolicy)
+	if features := p.Features(); !reflect.DeepEqual(features, []string{FeatureIngress, FeaturePortRanges}) {
+		t.Errorf("Expected the port-ranges feature, got %v", features)
+	}
+
+	// Names become numbers and short ranges one entry per port
+	old, downgraded, err := Downgrade(p, Peer{Schema: 1, Features: []string{FeatureIngress}})
+	if err != nil || !reflect.DeepEqual(downgraded, []string{FeaturePortRanges}) {
+		t.Fatalf("Expected port ranges rewritten, got %v (%v)", downgraded, err)
+	}
+	if ports := old.Spec.Egress[0].Ports; len(ports) != 7 || ports[0] != (PolicyPort{Protocol: "TCP", Port: 443}) || ports[4].Port != 8003 {
+		t.Errorf("Unexpected inlined ports %+v", ports)
+	}
+	if len(old.Features()) != 1 || p.Spec.Egress[0].Ports[0].Name != "https" {
+		t.Errorf("Expected only ingress left, and the original policy unchanged, got %v", old.Features())
+	}
+
+	// Wide ranges are withheld
+	p.Spec.Egress[0].Ports[1].EndPort = 9000
+	var incompatible *IncompatibleError
+	if _, _, err := Downgrade(p, Peer{Schema: 1, Features: []string{FeatureIngress}}); !errors.As(err, &incompatible) || incompatible.Missing[0] != FeaturePortRanges {
+		t.Errorf("Expected a wide range to be withheld, got %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// SearchQuery asks which compiled rules cover traffic to a destination
+type SearchQuery struct {
+	Dest     string `json:"dest"`               // IP address
+	Port     int    `json:"port,omitempty"`     // Any port if 0
+	Protocol string `json:"protocol,omitempty"` // Any protocol if empty
+}
+
+// Validate checks that the query names an IP and, if any, a valid port
+func (q SearchQuery) Validate() error {
+	if net.ParseIP(q.Dest) == nil {
+		return fmt.Errorf("dest %q is not an IP address", q.Dest)
+	}
+	if q.Port < 0 || q.Port > 65535 {
+		return fmt.Errorf("port %d out of range", q.Port)
+	}
+	return nil
+}
+
+// SearchMatch is a compiled rule covering a SearchQuery, with the part of
+// its policy it was compiled from
+type SearchMatch struct {
+	Rule
+	// Source is the field the destination matched through, e.g.
+	// "spec.egress[0].to.podSelector tier=db", or for ingress rules
+	// "spec.podSelector app=db", the workloads the policy protects
+	Source string `json:"source"`
+}
+
+// SearchResult is the outcome of RuleIndex.Search
+type SearchResult struct {
+	Matches []SearchMatch `json:"matches"`
+	// Warnings are the selectors that failed to resolve, whose rules cover
+	// nothing, and the policies that failed to compile and were left out
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// indexedRule is a SearchMatch for the destinations in network
+type indexedRule struct {
+	match   SearchMatch
+	network *net.IPNet
+}
+
+// RuleIndex indexes the compiled rules of a policy set by the destination
+// they allow traffic to: egress rules by their CIDR, ingress rules by the
+// IPs the podSelector of their policy resolves to
+type RuleIndex struct {
+	hosts    map[string][]SearchMatch // Rules for a single address, by IP
+	networks []indexedRule            // Rules for wider networks
+	warnings []string                 // See SearchResult
+}
+
+// NewRuleIndex compiles policies with r, resolving label selectors and
+// endpoint groups and evaluating rule conditions as Compile does, and
+// indexes the resulting rules. Unlike Compile, a selector that fails to
+// resolve only leaves its own rule out; it is reported by Search.
+func NewRuleIndex(r *PolicyResolver, policies []NetworkPolicy) *RuleIndex {
+	index := &RuleIndex{hosts: make(map[string][]SearchMatch)}
+	for _, p := range policies {
+		if err := index.addPolicy(r, p); err != nil {
+			index.warnings = append(index.warnings, err.Error())
+		}
+	}
+	return index
+}
+
+// addPolicy compiles p and indexes its rules, or none if it fails to compile
+func (x *RuleIndex) addPolicy(r *PolicyResolver, p NetworkPolicy) error {
+	endpoints := make([][]string, len(p.Spec.Egress)+len(p.Spec.Ingress))
+	for i := range endpoints {
+		ips, err := r.resolvePeer(p, i)
+		if err != nil {
+			x.warnings = append(x.warnings, err.Error())
+		}
+		endpoints[i] = ips
+	}
+	kept, dropped, err := r.applyConditions(p, endpoints)
+	if err != nil {
+		return err
+	}
+	var selected []string
+	if len(p.Spec.Ingress) > 0 && len(p.Spec.PodSelector.MatchLabels) > 0 {
+		if selected, err = r.ResolveLabels(p.Spec.PodSelector.MatchLabels); err != nil {
+			x.warnings = append(x.warnings, fmt.Sprintf("policy '%s': failed to resolve spec.podSelector: %v", p.Metadata.Name, err))
+		}
+	}
+
+	for i, egress := range p.Spec.Egress {
+		var cidrs, sources []string
+		if cidr := egress.To.IPBlock.CIDR; cidr != "" && !dropped[i] {
+			cidrs = append(cidrs, cidr)
+			sources = append(sources, fmt.Sprintf("spec.egress[%d].to.ipBlock", i))
+		}
+		source := fmt.Sprintf("spec.egress[%d].to.podSelector %s", i, labelString(egress.To.PodSelector.MatchLabels))
+		if egress.To.Group != "" {
+			source = fmt.Sprintf("spec.egress[%d].to.group %s", i, egress.To.Group)
+		}
+		for _, ip := range kept[i] {
+			cidrs = append(cidrs, hostCIDR(ip))
+			sources = append(sources, source)
+		}
+		for j, cidr := range cidrs {
+			for _, port := range egress.Ports {
+				x.add(cidr, SearchMatch{
+					Rule:   Rule{Policy: p.Metadata.Name, CIDR: cidr, Protocol: port.Protocol, Port: port.Port, EndPort: port.rangeEnd()},
+					Source: sources[j],
+				})
+			}
+		}
+	}
+
+	// Ingress rules cover the workloads the policy selects
+	source := "spec.podSelector " + labelString(p.Spec.PodSelector.MatchLabels)
+	for _, rule := range compile(p, kept, dropped, "").Rules {
+		if !rule.Ingress() {
+			continue
+		}
+		for _, ip := range selected {
+			x.add(hostCIDR(ip), SearchMatch{Rule: rule, Source: source})
+		}
+	}
+	return nil
+}
+
+// add indexes match under the destinations in cidr
+func (x *RuleIndex) add(cidr string, match SearchMatch) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return
+	}
+	if ones, bits := network.Mask.Size(); ones == bits {
+		x.hosts[network.IP.String()] = append(x.hosts[network.IP.String()], match)
+		return
+	}
+	x.networks = append(x.networks, indexedRule{match: match, network: network})
+}
+
+// Search returns the rules covering q, by policy name: egress rules whose
+// CIDR contains the destination and ingress rules of policies selecting it,
+// on q's port and protocol if set
+func (x *RuleIndex) Search(q SearchQuery) SearchResult {
+	result := SearchResult{Matches: []SearchMatch{}, Warnings: x.warnings}
+	ip := net.ParseIP(q.Dest)
+	if ip == nil {
+		return result
+	}
+	covers := func(rule Rule) bool {
+		return (q.Port == 0 || rule.CoversPort(q.Port)) && (q.Protocol == "" || strings.EqualFold(rule.Protocol, q.Protocol))
+	}
+
+	matches := result.Matches
+	for _, match := range x.hosts[ip.String()] {
+		if covers(match.Rule) {
+			matches = append(matches, match)
+		}
+	}
+	for _, indexed := range x.networks {
+		if indexed.network.Contains(ip) && covers(indexed.match.Rule) {
+			matches = append(matches, indexed.match)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Policy < matches[j].Policy })
+	result.Matches = matches
+	return result
+}
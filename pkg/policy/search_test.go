@@ -0,0 +1,103 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuleIndexSearch(t *testing.T) {
+	policies, err := Parse([]byte(`
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            tier: db
+      ports:
+        - protocol: TCP
+          port: 5432
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/16
+      ports:
+        - protocol: UDP
+          port: 53
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: db-ingress
+spec:
+  podSelector:
+    matchLabels:
+      tier: db
+  ingress:
+    - from:
+        ipBlock:
+          cidr: 10.9.0.0/16
+      ports:
+        - protocol: TCP
+          port: 5432
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: unresolved
+spec:
+  podSelector:
+    matchLabels:
+      app: batch
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: missing
+      ports:
+        - protocol: TCP
+          port: 443
+`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	resolver := NewPolicyResolver(&mockDiscovery{services: map[string][]string{"tier=db": {"10.0.2.5", "10.0.2.6"}}})
+	index := NewRuleIndex(resolver, policies)
+
+	// The database is reached through label resolution, and protected by
+	// the ingress rules of the policy selecting it
+	result := index.Search(SearchQuery{Dest: "10.0.2.5", Port: 5432})
+	if len(result.Matches) != 2 {
+		t.Fatalf("Expected 2 matches, got %+v", result.Matches)
+	}
+	if m := result.Matches[0]; m.Policy != "db-ingress" || !m.Ingress() || m.CIDR != "10.9.0.0/16" || m.Source != "spec.podSelector tier=db" {
+		t.Errorf("Unexpected ingress match %+v", m)
+	}
+	if m := result.Matches[1]; m.Policy != "web-to-db" || m.CIDR != "10.0.2.5/32" || m.Source != "spec.egress[0].to.podSelector tier=db" {
+		t.Errorf("Unexpected egress match %+v", m)
+	}
+	if len(result.Warnings) != 1 || !strings.Contains(result.Warnings[0], "policy 'unresolved': failed to resolve spec.egress[0].to.podSelector") {
+		t.Errorf("Expected the unresolved selector reported, got %v", result.Warnings)
+	}
+
+	// Networks match every address they contain, filtered by protocol
+	result = index.Search(SearchQuery{Dest: "10.0.2.5", Protocol: "udp"})
+	if len(result.Matches) != 1 || result.Matches[0].Source != "spec.egress[1].to.ipBlock" || result.Matches[0].Port != 53 {
+		t.Errorf("Expected the DNS rule of the network, got %+v", result.Matches)
+	}
+	if result := index.Search(SearchQuery{Dest: "192.0.2.1"}); len(result.Matches) != 0 {
+		t.Errorf("Expected no matches outside every rule, got %+v", result.Matches)
+	}
+
+	if err := (SearchQuery{Dest: "db.internal"}).Validate(); err == nil {
+		t.Error("Expected a hostname rejected")
+	}
+	if err := (SearchQuery{Dest: "10.0.2.5", Port: 70000}).Validate(); err == nil {
+		t.Error("Expected an out of range port rejected")
+	}
+}
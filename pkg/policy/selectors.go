@@ -0,0 +1,202 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// KindSelectorSet is the kind of documents defining a named selector set
+const KindSelectorSet = "SelectorSet"
+
+// SelectorSet is a named set of labels defined once in a policy file and
+// referenced from podSelectors with selectorRefs:
+//
+//	apiVersion: ztap/v1
+//	kind: SelectorSet
+//	metadata:
+//	  name: prod-db
+//	spec:
+//	  selectorRefs: [prod] # sets compose other sets
+//	  matchLabels:
+//	    app: db
+//
+// A podSelector with selectorRefs: [prod-db] matches the labels of every set
+// it references and its own matchLabels. References are expanded when the
+// file is parsed, so compiled policies only hold concrete labels.
+type SelectorSet struct {
+	Name         string
+	MatchLabels  map[string]string
+	SelectorRefs []string
+}
+
+// selectorRefs are the parts of a policy document naming selector sets,
+// decoded alongside the NetworkPolicy so its type stays unchanged
+type selectorRefs struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Spec struct {
+		// MatchLabels and SelectorRefs define a SelectorSet
+		MatchLabels  map[string]string `yaml:"matchLabels"`
+		SelectorRefs []string          `yaml:"selectorRefs"`
+		PodSelector  struct {
+			SelectorRefs []string `yaml:"selectorRefs"`
+		} `yaml:"podSelector"`
+		Egress []struct {
+			To struct {
+				PodSelector struct {
+					SelectorRefs []string `yaml:"selectorRefs"`
+				} `yaml:"podSelector"`
+			} `yaml:"to"`
+		} `yaml:"egress"`
+	} `yaml:"spec"`
+}
+
+// document is one document of a policy file: a NetworkPolicy, a SelectorSet
+// or an EndpointGroup
+type document struct {
+	policy NetworkPolicy
+	refs   selectorRefs
+	group  groupDocument
+}
+
+func (d *document) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&d.policy); err != nil {
+		return err
+	}
+	if err := unmarshal(&d.refs); err != nil {
+		return err
+	}
+	if d.refs.Kind != KindEndpointGroup {
+		return nil
+	}
+	return unmarshal(&d.group)
+}
+
+// expandSelectors returns the policies of docs with the selector sets they
+// reference merged into their podSelectors. SelectorSet and EndpointGroup
+// documents are not returned.
+func expandSelectors(docs []document) ([]NetworkPolicy, error) {
+	sets := make(map[string]SelectorSet)
+	for _, d := range docs {
+		if d.refs.Kind != KindSelectorSet {
+			continue
+		}
+		name := d.refs.Metadata.Name
+		if name == "" {
+			return nil, fmt.Errorf("selector set: metadata.name missing")
+		}
+		if _, exists := sets[name]; exists {
+			return nil, fmt.Errorf("selector set %s defined twice", name)
+		}
+		sets[name] = SelectorSet{Name: name, MatchLabels: d.refs.Spec.MatchLabels, SelectorRefs: d.refs.Spec.SelectorRefs}
+	}
+	resolved := make(map[string]map[string]string, len(sets))
+
+	var policies []NetworkPolicy
+	for _, d := range docs {
+		if d.refs.Kind == KindSelectorSet || d.refs.Kind == KindEndpointGroup {
+			continue
+		}
+		p := d.policy
+		labels, err := mergeSelector(p.Spec.PodSelector.MatchLabels, d.refs.Spec.PodSelector.SelectorRefs, sets, resolved)
+		if err != nil {
+			return nil, ValidationError{p.Metadata.Name, "spec.podSelector", err.Error()}
+		}
+		p.Spec.PodSelector.MatchLabels = labels
+
+		// Egress rules share their backing array with d.policy; copy before
+		// rewriting so documents are not modified
+		p.Spec.Egress = append(p.Spec.Egress[:0:0], p.Spec.Egress...)
+		for i, egress := range d.refs.Spec.Egress {
+			if i >= len(p.Spec.Egress) {
+				break
+			}
+			labels, err := mergeSelector(p.Spec.Egress[i].To.PodSelector.MatchLabels, egress.To.PodSelector.SelectorRefs, sets, resolved)
+			if err != nil {
+				return nil, ValidationError{p.Metadata.Name, fmt.Sprintf("spec.egress[%d].to.podSelector", i), err.Error()}
+			}
+			p.Spec.Egress[i].To.PodSelector.MatchLabels = labels
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// mergeSelector returns labels with the labels of the referenced sets added.
+// Without refs, labels is returned as is.
+func mergeSelector(labels map[string]string, refs []string, sets map[string]SelectorSet, resolved map[string]map[string]string) (map[string]string, error) {
+	if len(refs) == 0 {
+		return labels, nil
+	}
+	merged := make(map[string]string, len(labels))
+	for key, value := range labels {
+		merged[key] = value
+	}
+	for _, ref := range refs {
+		setLabels, err := resolveSet(ref, sets, resolved, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := addLabels(merged, setLabels, "selector set "+ref); err != nil {
+			return nil, err
+		}
+	}
+	return merged, nil
+}
+
+// resolveSet returns the labels of the named set, including those of the
+// sets it references. visiting holds the sets being resolved, to report
+// cycles.
+func resolveSet(name string, sets map[string]SelectorSet, resolved map[string]map[string]string, visiting []string) (map[string]string, error) {
+	if labels, ok := resolved[name]; ok {
+		return labels, nil
+	}
+	set, ok := sets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown selector set %q", name)
+	}
+	for _, v := range visiting {
+		if v == name {
+			return nil, fmt.Errorf("selector sets reference each other: %s -> %s", strings.Join(visiting, " -> "), name)
+		}
+	}
+	visiting = append(visiting, name)
+
+	labels := make(map[string]string, len(set.MatchLabels))
+	refs := append([]string(nil), set.SelectorRefs...)
+	sort.Strings(refs)
+	for _, ref := range refs {
+		refLabels, err := resolveSet(ref, sets, resolved, visiting)
+		if err != nil {
+			return nil, err
+		}
+		if err := addLabels(labels, refLabels, "selector set "+ref); err != nil {
+			return nil, fmt.Errorf("selector set %s: %w", name, err)
+		}
+	}
+	if err := addLabels(labels, set.MatchLabels, "its matchLabels"); err != nil {
+		return nil, fmt.Errorf("selector set %s: %w", name, err)
+	}
+	resolved[name] = labels
+	return labels, nil
+}
+
+// addLabels adds labels from source to labels, failing when source requires
+// another value for a key
+func addLabels(labels, from map[string]string, source string) error {
+	keys := make([]string, 0, len(from))
+	for key := range from {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if existing, ok := labels[key]; ok && existing != from[key] {
+			return fmt.Errorf("%s requires %s=%s, conflicting with %s=%s", source, key, from[key], key, existing)
+		}
+		labels[key] = from[key]
+	}
+	return nil
+}
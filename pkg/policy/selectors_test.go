@@ -0,0 +1,119 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const selectorSetPolicies = `apiVersion: ztap/v1
+kind: SelectorSet
+metadata:
+  name: prod
+spec:
+  matchLabels:
+    env: prod
+---
+apiVersion: ztap/v1
+kind: SelectorSet
+metadata:
+  name: prod-db
+spec:
+  selectorRefs: [prod]
+  matchLabels:
+    app: db
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    selectorRefs: [prod]
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          selectorRefs: [prod-db]
+      ports:
+        - protocol: TCP
+          port: 5432
+    - to:
+        ipBlock:
+          cidr: 10.0.0.53/32
+      ports:
+        - protocol: UDP
+          port: 53
+`
+
+func TestSelectorSets(t *testing.T) {
+	policies, err := Parse([]byte(selectorSetPolicies))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(policies) != 1 {
+		t.Fatalf("Expected selector sets left out, got %d documents", len(policies))
+	}
+	p := policies[0]
+	if err := p.Validate(); err != nil {
+		t.Errorf("Expected the expanded policy to validate, got %v", err)
+	}
+	if want := map[string]string{"env": "prod", "app": "web"}; !reflect.DeepEqual(p.Spec.PodSelector.MatchLabels, want) {
+		t.Errorf("Unexpected podSelector %v", p.Spec.PodSelector.MatchLabels)
+	}
+	if want := map[string]string{"env": "prod", "app": "db"}; !reflect.DeepEqual(p.Spec.Egress[0].To.PodSelector.MatchLabels, want) {
+		t.Errorf("Unexpected egress podSelector %v", p.Spec.Egress[0].To.PodSelector.MatchLabels)
+	}
+	if p.Spec.Egress[1].To.PodSelector.MatchLabels != nil || p.Spec.Egress[1].To.IPBlock.CIDR != "10.0.0.53/32" {
+		t.Errorf("Expected the ipBlock rule unchanged, got %+v", p.Spec.Egress[1].To)
+	}
+
+	// Marshal writes the expanded labels
+	data, err := Marshal(policies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "selectorRefs") {
+		t.Errorf("Expected expanded selectors, got %s", data)
+	}
+}
+
+func TestSelectorSetErrors(t *testing.T) {
+	policyWith := func(selector string) string {
+		return "apiVersion: ztap/v1\nkind: NetworkPolicy\nmetadata:\n  name: web\nspec:\n  podSelector:\n" + selector
+	}
+	set := func(name, spec string) string {
+		return "apiVersion: ztap/v1\nkind: SelectorSet\nmetadata:\n  name: " + name + "\nspec:\n" + spec + "---\n"
+	}
+
+	tests := map[string]string{
+		"unknown selector set": policyWith("    selectorRefs: [missing]\n"),
+		"conflicting with env=dev": set("prod", "  matchLabels: {env: prod}\n") +
+			policyWith("    selectorRefs: [prod]\n    matchLabels: {env: dev}\n"),
+		"reference each other": set("a", "  selectorRefs: [b]\n") + set("b", "  selectorRefs: [a]\n") +
+			policyWith("    selectorRefs: [a]\n"),
+		"defined twice": set("a", "  matchLabels: {env: prod}\n") + set("a", "  matchLabels: {env: dev}\n") +
+			policyWith("    matchLabels: {app: web}\n"),
+	}
+	for want, input := range tests {
+		if _, err := Parse([]byte(input)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected an error containing %q, got %v", want, err)
+		}
+	}
+}
+
+func TestSelectorSetsExample(t *testing.T) {
+	policies, err := LoadFromFile("../../examples/selector-sets.yaml")
+	if err != nil {
+		t.Fatalf("LoadFromFile failed: %v", err)
+	}
+	for i := range policies {
+		if err := policies[i].Validate(); err != nil {
+			t.Errorf("Expected %s to validate, got %v", policies[i].Metadata.Name, err)
+		}
+		if policies[i].Spec.PodSelector.MatchLabels["env"] != "prod" {
+			t.Errorf("Expected %s to select prod, got %v", policies[i].Metadata.Name, policies[i].Spec.PodSelector.MatchLabels)
+		}
+	}
+}
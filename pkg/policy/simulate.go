@@ -0,0 +1,210 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Endpoint is one end of a simulated flow. Either end is matched by labels
+// against podSelectors and by IP against ipBlocks; policies are selected by
+// labels.
+type Endpoint struct {
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	IP     string            `yaml:"ip,omitempty" json:"ip,omitempty"`
+}
+
+// String describes the endpoint by its IP and labels
+func (e Endpoint) String() string {
+	var parts []string
+	if e.IP != "" {
+		parts = append(parts, e.IP)
+	}
+	if len(e.Labels) > 0 {
+		parts = append(parts, labelString(e.Labels))
+	}
+	if len(parts) == 0 {
+		return "<any>"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Flow is a connection to evaluate against a policy set
+type Flow struct {
+	From     Endpoint
+	To       Endpoint
+	Protocol string // Defaults to TCP
+	Port     int
+	At       time.Time // When rule conditions are evaluated; now if zero
+}
+
+// Verdict is the outcome of a simulated flow
+type Verdict struct {
+	Allowed bool
+	// Policy and Egress identify the rule allowing the flow, if any
+	Policy string
+	Egress int
+	Reason string
+}
+
+// Simulate evaluates flow as enforcement would. A source no policy selects
+// is not enforced, so its flows are allowed. A selected source may only
+// reach destinations an egress rule of a selecting policy allows on the
+// flow's port and protocol; a selecting policy without egress rules allows
+// nothing. Destinations given by labels are matched against podSelectors
+// directly, without resolving them through service discovery, and rule
+// conditions are evaluated against the destination's IP and labels. A flow
+// egress allows must also be allowed in: once a policy selecting the
+// destination has ingress rules, one of them must match the source.
+func Simulate(policies []NetworkPolicy, flow Flow) Verdict {
+	protocol := flow.Protocol
+	if protocol == "" {
+		protocol = "TCP"
+	}
+
+	verdict := simulateEgress(policies, flow, protocol)
+	if !verdict.Allowed {
+		return verdict
+	}
+	var restricting []string
+	for _, p := range policies {
+		if len(p.Spec.Ingress) == 0 || len(flow.To.Labels) == 0 || !selects(p.Spec.PodSelector.MatchLabels, flow.To.Labels) {
+			continue
+		}
+		for _, ingress := range p.Spec.Ingress {
+			if allowsPort(ingress.Ports, flow.Port, protocol) && allowsPeer(ingress.From.PodSelector.MatchLabels, ingress.From.IPBlock.CIDR, flow.From) {
+				return verdict
+			}
+		}
+		restricting = append(restricting, p.Metadata.Name)
+	}
+	if len(restricting) > 0 {
+		return Verdict{
+			Egress: -1,
+			Reason: fmt.Sprintf("no ingress rule of %s allows %s/%d from %s", strings.Join(restricting, ", "), strings.ToUpper(protocol), flow.Port, flow.From),
+		}
+	}
+	return verdict
+}
+
+// simulateEgress evaluates flow against the egress rules of the policies
+// selecting its source
+func simulateEgress(policies []NetworkPolicy, flow Flow, protocol string) Verdict {
+	var selecting []string
+	for _, p := range policies {
+		if !selects(p.Spec.PodSelector.MatchLabels, flow.From.Labels) {
+			continue
+		}
+		selecting = append(selecting, p.Metadata.Name)
+		for i, egress := range p.Spec.Egress {
+			if !allowsPort(egress.Ports, flow.Port, protocol) || !allowsDestination(p, i, flow.To) || !meetsCondition(egress.When, flow) {
+				continue
+			}
+			return Verdict{
+				Allowed: true,
+				Policy:  p.Metadata.Name,
+				Egress:  i,
+				Reason:  fmt.Sprintf("allowed by policy %s spec.egress[%d]", p.Metadata.Name, i),
+			}
+		}
+	}
+
+	if len(selecting) == 0 {
+		return Verdict{Allowed: true, Egress: -1, Reason: "no policy selects the source, so it is not enforced"}
+	}
+	return Verdict{
+		Egress: -1,
+		Reason: fmt.Sprintf("no egress rule of %s allows %s/%d to %s", strings.Join(selecting, ", "), strings.ToUpper(protocol), flow.Port, flow.To),
+	}
+}
+
+// selects reports whether a podSelector matches labels. An empty selector
+// selects every workload.
+func selects(selector, labels map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// allowsPort reports whether one of ports covers port/protocol
+func allowsPort(ports []PolicyPort, port int, protocol string) bool {
+	for _, p := range ports {
+		if p.CoversPort(port) && strings.EqualFold(p.Protocol, protocol) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsDestination reports whether the peer of p's egress rule i matches the
+// destination. Endpoint group FQDNs are not resolved, so only the group's
+// CIDRs and selectors match.
+func allowsDestination(p NetworkPolicy, i int, to Endpoint) bool {
+	egress := p.Spec.Egress[i]
+	if egress.To.Group == "" {
+		return allowsPeer(egress.To.PodSelector.MatchLabels, egress.To.IPBlock.CIDR, to)
+	}
+	g := p.Groups[egress.To.Group]
+	for _, cidr := range g.CIDRs {
+		if allowsPeer(nil, cidr, to) {
+			return true
+		}
+	}
+	for _, selector := range g.Selectors {
+		if allowsPeer(selector, "", to) {
+			return true
+		}
+	}
+	return false
+}
+
+// meetsCondition reports whether the flow's destination meets a rule
+// condition. A condition that does not parse or evaluate allows nothing.
+func meetsCondition(when string, flow Flow) bool {
+	if when == "" {
+		return true
+	}
+	cond, err := ParseCondition(when)
+	if err != nil {
+		return false
+	}
+	at := flow.At
+	if at.IsZero() {
+		at = time.Now()
+	}
+	ok, err := cond.Eval(ConditionEnv{IP: flow.To.IP, Labels: flow.To.Labels, Time: at})
+	return err == nil && ok
+}
+
+// allowsPeer reports whether a rule's peer (a podSelector or an ipBlock)
+// matches the endpoint at the other end
+func allowsPeer(selector map[string]string, cidr string, to Endpoint) bool {
+	if len(selector) > 0 && len(to.Labels) > 0 && selects(selector, to.Labels) {
+		return true
+	}
+	if cidr == "" || to.IP == "" {
+		return false
+	}
+	_, block, err := net.ParseCIDR(cidr)
+	ip := net.ParseIP(to.IP)
+	return err == nil && ip != nil && block.Contains(ip)
+}
+
+// labelString formats labels as k=v pairs in key order
+func labelString(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + labels[key]
+	}
+	return strings.Join(pairs, ",")
+}
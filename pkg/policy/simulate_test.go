@@ -0,0 +1,66 @@
+package policy
+
+import "testing"
+
+func TestSimulate(t *testing.T) {
+	policies, err := Parse([]byte(`apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+    - to:
+        ipBlock:
+          cidr: 10.0.0.0/8
+      ports:
+        - protocol: UDP
+          port: 53
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: lockdown
+spec:
+  podSelector:
+    matchLabels:
+      app: vault
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := Endpoint{Labels: map[string]string{"app": "web", "tier": "frontend"}}
+
+	tests := []struct {
+		name   string
+		flow   Flow
+		allow  bool
+		policy string
+	}{
+		{"selector peer", Flow{From: web, To: Endpoint{Labels: map[string]string{"app": "db"}}, Port: 5432}, true, "web-to-db"},
+		{"wrong port", Flow{From: web, To: Endpoint{Labels: map[string]string{"app": "db"}}, Port: 3306}, false, ""},
+		{"wrong protocol", Flow{From: web, To: Endpoint{Labels: map[string]string{"app": "db"}}, Protocol: "udp", Port: 5432}, false, ""},
+		{"ipBlock peer", Flow{From: web, To: Endpoint{IP: "10.1.2.3"}, Protocol: "udp", Port: 53}, true, "web-to-db"},
+		{"outside ipBlock", Flow{From: web, To: Endpoint{IP: "192.168.1.1"}, Protocol: "udp", Port: 53}, false, ""},
+		{"deny-all policy", Flow{From: Endpoint{Labels: map[string]string{"app": "vault"}}, To: Endpoint{IP: "10.1.2.3"}, Port: 443}, false, ""},
+		{"unselected source", Flow{From: Endpoint{Labels: map[string]string{"app": "batch"}}, To: Endpoint{IP: "10.1.2.3"}, Port: 443}, true, ""},
+	}
+	for _, tt := range tests {
+		verdict := Simulate(policies, tt.flow)
+		if verdict.Allowed != tt.allow || verdict.Policy != tt.policy {
+			t.Errorf("%s: expected allowed=%v by %q, got %+v", tt.name, tt.allow, tt.policy, verdict)
+		}
+		if verdict.Reason == "" {
+			t.Errorf("%s: expected a reason", tt.name)
+		}
+	}
+}
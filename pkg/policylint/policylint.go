@@ -0,0 +1,332 @@
+// Package policylint checks valid policies against best practices, such as
+// naming an owner and not allowing the whole internet, which Validate does
+// not reject. Rules have configurable severities and can be suppressed per
+// policy.
+package policylint
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"ztap/pkg/policy"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Severity of a finding
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+	// SeverityOff disables a rule
+	SeverityOff Severity = "off"
+)
+
+// Rules
+const (
+	// RuleOpenDestination: an egress rule allows every address (0.0.0.0/0 or
+	// ::/0), directly or through an endpoint group
+	RuleOpenDestination = "open-destination"
+	// RuleBroadSelector: a podSelector has a single label that most
+	// workloads share, such as env=prod
+	RuleBroadSelector = "broad-selector"
+	// RuleMissingOwner: the policy has no owner annotation
+	RuleMissingOwner = "missing-owner"
+	// RulePortRange: an egress rule allows more ports of a protocol than
+	// Config.MaxPorts
+	RulePortRange = "port-range"
+	// RuleUnusedSelector: a podSelector matches none of the known workloads
+	RuleUnusedSelector = "unused-selector"
+)
+
+// defaultSeverities are the rules and their default severities
+var defaultSeverities = map[string]Severity{
+	RuleOpenDestination: SeverityWarning,
+	RuleBroadSelector:   SeverityWarning,
+	RuleMissingOwner:    SeverityWarning,
+	RulePortRange:       SeverityWarning,
+	RuleUnusedSelector:  SeverityWarning,
+}
+
+// Rules returns the sorted names of the lint rules
+func Rules() []string {
+	rules := make([]string, 0, len(defaultSeverities))
+	for rule := range defaultSeverities {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+// IgnoreAnnotation suppresses the comma-separated rules it lists for the
+// policy it annotates, e.g. "lint.ztap.io/ignore: open-destination"
+const IgnoreAnnotation = "lint.ztap.io/ignore"
+
+// Config configures the rules. Its YAML form is:
+//
+//	rules:
+//	  open-destination: error
+//	  unused-selector: off
+//	maxPorts: 100
+//	ownerAnnotation: owner
+//	broadLabels: [env, tier]
+//	suppress:
+//	  - rule: open-destination
+//	    policy: allow-egress-proxy
+type Config struct {
+	// Rules overrides the severity of rules, by name
+	Rules map[string]Severity `yaml:"rules"`
+	// MaxPorts is the number of ports of one protocol an egress rule may
+	// allow (port-range)
+	MaxPorts int `yaml:"maxPorts"`
+	// OwnerAnnotation is the annotation naming a policy's owner
+	// (missing-owner)
+	OwnerAnnotation string `yaml:"ownerAnnotation"`
+	// BroadLabels are label keys too common to select workloads on their
+	// own (broad-selector)
+	BroadLabels []string `yaml:"broadLabels"`
+	// BroadShare is the share of known workloads a single-label selector
+	// may match (broad-selector)
+	BroadShare float64 `yaml:"broadShare"`
+	// Suppress lists the findings to ignore
+	Suppress []Suppression `yaml:"suppress"`
+}
+
+// Suppression ignores the findings of a rule for a policy, or for every
+// policy if Policy is empty
+type Suppression struct {
+	Rule   string `yaml:"rule"`
+	Policy string `yaml:"policy,omitempty"`
+}
+
+// DefaultConfig returns the default configuration
+func DefaultConfig() Config {
+	return Config{
+		MaxPorts:        100,
+		OwnerAnnotation: "owner",
+		BroadLabels:     []string{"env", "environment", "region", "team", "tier", "zone"},
+		BroadShare:      0.5,
+	}
+}
+
+// LoadConfig reads a configuration file; unset settings keep their defaults
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, fmt.Errorf("invalid lint config %s: %w", path, err)
+	}
+	return config, config.Validate()
+}
+
+// Validate checks the rule names and severities
+func (c Config) Validate() error {
+	for rule, severity := range c.Rules {
+		if _, ok := defaultSeverities[rule]; !ok {
+			return fmt.Errorf("unknown lint rule %q", rule)
+		}
+		switch severity {
+		case SeverityError, SeverityWarning, SeverityInfo, SeverityOff:
+		default:
+			return fmt.Errorf("rule %s: severity must be error, warning, info or off, got %q", rule, severity)
+		}
+	}
+	for _, s := range c.Suppress {
+		if _, ok := defaultSeverities[s.Rule]; !ok {
+			return fmt.Errorf("suppress: unknown lint rule %q", s.Rule)
+		}
+	}
+	return nil
+}
+
+// severity returns the severity of rule
+func (c Config) severity(rule string) Severity {
+	if severity, ok := c.Rules[rule]; ok {
+		return severity
+	}
+	return defaultSeverities[rule]
+}
+
+// suppressed reports whether the config or the policy's annotation
+// suppresses rule for the policy
+func (c Config) suppressed(rule string, p *policy.NetworkPolicy) bool {
+	for _, s := range c.Suppress {
+		if s.Rule == rule && (s.Policy == "" || s.Policy == p.Metadata.Name) {
+			return true
+		}
+	}
+	for _, ignored := range strings.Split(p.Metadata.Annotations[IgnoreAnnotation], ",") {
+		if strings.TrimSpace(ignored) == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// Finding is a rule a policy breaks
+type Finding struct {
+	Policy   string   `json:"policy"`
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Field    string   `json:"field"` // e.g. spec.egress[0].to.ipBlock.cidr
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: policy '%s': %s: %s (%s)", f.Severity, f.Policy, f.Field, f.Message, f.Rule)
+}
+
+// Lint checks policies, which should be valid, against the rules of config
+// and returns the findings sorted by policy. workloads are the labels of the
+// known workloads; broad-selector and unused-selector only consult them if
+// there are any.
+func Lint(policies []policy.NetworkPolicy, workloads []map[string]string, config Config) []Finding {
+	var findings []Finding
+	for i := range policies {
+		p := &policies[i]
+		report := func(rule, field, format string, args ...any) {
+			severity := config.severity(rule)
+			if severity == SeverityOff || config.suppressed(rule, p) {
+				return
+			}
+			findings = append(findings, Finding{
+				Policy:   p.Metadata.Name,
+				Rule:     rule,
+				Severity: severity,
+				Field:    field,
+				Message:  fmt.Sprintf(format, args...),
+			})
+		}
+
+		if config.OwnerAnnotation != "" && p.Metadata.Annotations[config.OwnerAnnotation] == "" {
+			report(RuleMissingOwner, "metadata.annotations", "no %s annotation", config.OwnerAnnotation)
+		}
+		checkSelector(report, "spec.podSelector", p.Spec.PodSelector.MatchLabels, workloads, config)
+		checkPorts := func(field string, protocols []string) {
+			if config.MaxPorts <= 0 {
+				return
+			}
+			ports := make(map[string]int)
+			for _, protocol := range protocols {
+				ports[protocol]++
+			}
+			for _, protocol := range sortedKeys(ports) {
+				if ports[protocol] > config.MaxPorts {
+					report(RulePortRange, field, "allows %d %s ports (more than %d)", ports[protocol], protocol, config.MaxPorts)
+				}
+			}
+		}
+
+		for j, egress := range p.Spec.Egress {
+			field := fmt.Sprintf("spec.egress[%d]", j)
+			if isOpen(egress.To.IPBlock.CIDR) {
+				report(RuleOpenDestination, field+".to.ipBlock.cidr", "%s allows every address", egress.To.IPBlock.CIDR)
+			}
+			if group, ok := p.Groups[egress.To.Group]; ok && egress.To.Group != "" {
+				for _, cidr := range group.CIDRs {
+					if isOpen(cidr) {
+						report(RuleOpenDestination, field+".to.group", "endpoint group %s contains %s, which allows every address", group.Name, cidr)
+					}
+				}
+			}
+			checkSelector(report, field+".to.podSelector", egress.To.PodSelector.MatchLabels, workloads, config)
+
+			protocols := make([]string, 0, len(egress.Ports))
+			for _, port := range egress.Ports {
+				protocols = append(protocols, port.Protocol)
+			}
+			checkPorts(field+".ports", protocols)
+		}
+		// Ingress from every address is how a public service is opened, so
+		// open sources are not reported
+		for j, ingress := range p.Spec.Ingress {
+			field := fmt.Sprintf("spec.ingress[%d]", j)
+			checkSelector(report, field+".from.podSelector", ingress.From.PodSelector.MatchLabels, workloads, config)
+
+			protocols := make([]string, 0, len(ingress.Ports))
+			for _, port := range ingress.Ports {
+				protocols = append(protocols, port.Protocol)
+			}
+			checkPorts(field+".ports", protocols)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Policy < findings[j].Policy })
+	return findings
+}
+
+// checkSelector reports a selector that is too broad or matches nothing
+func checkSelector(report func(rule, field, format string, args ...any), field string, selector map[string]string, workloads []map[string]string, config Config) {
+	if len(selector) == 0 {
+		return
+	}
+
+	matched := 0
+	for _, labels := range workloads {
+		if matches(selector, labels) {
+			matched++
+		}
+	}
+	if len(workloads) > 0 && matched == 0 {
+		report(RuleUnusedSelector, field, "%s matches no known workload", labelString(selector))
+	}
+
+	if len(selector) != 1 {
+		return
+	}
+	for key, value := range selector {
+		switch {
+		case slices.Contains(config.BroadLabels, key):
+			report(RuleBroadSelector, field, "%s=%s alone selects too broadly; add a label such as app", key, value)
+		case len(workloads) > 0 && config.BroadShare > 0 && float64(matched) > config.BroadShare*float64(len(workloads)):
+			report(RuleBroadSelector, field, "%s=%s alone matches %d of %d known workloads", key, value, matched, len(workloads))
+		}
+	}
+}
+
+// isOpen reports whether cidr covers every address
+func isOpen(cidr string) bool {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, _ := network.Mask.Size()
+	return ones == 0
+}
+
+// matches reports whether labels has every label of selector
+func matches(selector, labels map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// labelString renders labels as sorted key=value pairs
+func labelString(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for _, key := range sortedKeys(labels) {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,142 @@
+package policylint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ztap/pkg/policy"
+)
+
+const policies = `apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-internet
+spec:
+  podSelector:
+    matchLabels:
+      env: prod
+  egress:
+    - to:
+        ipBlock:
+          cidr: 0.0.0.0/0
+      ports:
+        - protocol: TCP
+          port: 443
+        - protocol: TCP
+          port: 8443
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+  annotations:
+    owner: payments
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: cache
+      ports:
+        - protocol: TCP
+          port: 6379
+`
+
+func load(t *testing.T) []policy.NetworkPolicy {
+	t.Helper()
+	parsed, err := policy.Parse([]byte(policies))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range parsed {
+		if err := parsed[i].Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return parsed
+}
+
+func rules(findings []Finding) map[string]Finding {
+	byRule := make(map[string]Finding)
+	for _, f := range findings {
+		byRule[f.Policy+"/"+f.Rule] = f
+	}
+	return byRule
+}
+
+func TestLint(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxPorts = 1
+	findings := rules(Lint(load(t), nil, config))
+
+	for _, want := range []string{
+		"web-to-internet/" + RuleOpenDestination,
+		"web-to-internet/" + RuleMissingOwner,
+		"web-to-internet/" + RuleBroadSelector,
+		"web-to-internet/" + RulePortRange,
+	} {
+		if _, ok := findings[want]; !ok {
+			t.Errorf("Expected finding %s, got %v", want, findings)
+		}
+	}
+	if f := findings["web-to-internet/"+RuleOpenDestination]; f.Field != "spec.egress[0].to.ipBlock.cidr" || f.Severity != SeverityWarning {
+		t.Errorf("Unexpected finding %+v", f)
+	}
+	if len(findings) != 4 {
+		t.Errorf("Expected web-to-db to pass without workloads, got %v", findings)
+	}
+}
+
+func TestLintWorkloads(t *testing.T) {
+	workloads := []map[string]string{
+		{"app": "web", "env": "prod"},
+		{"app": "web", "env": "prod"},
+		{"app": "db", "env": "prod"},
+	}
+	config := DefaultConfig()
+	config.BroadLabels = nil
+	findings := rules(Lint(load(t), workloads, config))
+
+	if f, ok := findings["web-to-db/"+RuleUnusedSelector]; !ok || f.Field != "spec.egress[0].to.podSelector" {
+		t.Errorf("Expected app=cache to be unused, got %v", findings)
+	}
+	if _, ok := findings["web-to-internet/"+RuleBroadSelector]; !ok {
+		t.Errorf("Expected env=prod to be broad by share, got %v", findings)
+	}
+}
+
+func TestSuppressions(t *testing.T) {
+	parsed := load(t)
+	parsed[0].Metadata.Annotations = map[string]string{IgnoreAnnotation: "open-destination, broad-selector"}
+
+	config := DefaultConfig()
+	config.Rules = map[string]Severity{RulePortRange: SeverityOff, RuleMissingOwner: SeverityError}
+	config.MaxPorts = 1
+	config.Suppress = []Suppression{{Rule: RuleUnusedSelector}}
+	findings := Lint(parsed, []map[string]string{{"app": "other"}}, config)
+
+	if len(findings) != 1 || findings[0].Rule != RuleMissingOwner || findings[0].Severity != SeverityError {
+		t.Errorf("Expected only the missing owner as an error, got %v", findings)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lint.yaml")
+	os.WriteFile(path, []byte("rules:\n  unused-selector: off\nmaxPorts: 10\n"), 0644)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.severity(RuleUnusedSelector) != SeverityOff || config.MaxPorts != 10 || config.OwnerAnnotation != "owner" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	os.WriteFile(path, []byte("rules:\n  no-such-rule: error\n"), 0644)
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("Expected an error for an unknown rule")
+	}
+}
@@ -0,0 +1,114 @@
+// Package policytest runs policy test files: YAML assertions of the verdict
+// a policy set should give flows between workloads, evaluated with
+// policy.Simulate, so segmentation rules can be unit tested like code.
+package policytest
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ztap/pkg/policy"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Expected verdicts
+const (
+	ExpectAllow = "allow"
+	ExpectDeny  = "deny"
+)
+
+// Case is one assertion of a test file
+type Case struct {
+	Name     string          `yaml:"name"`
+	From     policy.Endpoint `yaml:"from"`
+	To       policy.Endpoint `yaml:"to"`
+	Port     int             `yaml:"port"`
+	Protocol string          `yaml:"protocol"` // Defaults to TCP
+	Expect   string          `yaml:"expect"`   // ExpectAllow or ExpectDeny
+}
+
+// file is the layout of a test file
+type file struct {
+	Tests []Case `yaml:"tests"`
+}
+
+// Load reads the cases of a test file
+func Load(path string) ([]Case, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid test file %s: %w", path, err)
+	}
+	for i := range f.Tests {
+		if err := f.Tests[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: tests[%d]: %w", path, i, err)
+		}
+		if f.Tests[i].Name == "" {
+			f.Tests[i].Name = f.Tests[i].String()
+		}
+	}
+	return f.Tests, nil
+}
+
+// validate checks that the case can be evaluated
+func (c Case) validate() error {
+	if c.Expect != ExpectAllow && c.Expect != ExpectDeny {
+		return fmt.Errorf("expect must be %s or %s, got %q", ExpectAllow, ExpectDeny, c.Expect)
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("port %d out of range", c.Port)
+	}
+	if len(c.To.Labels) == 0 && c.To.IP == "" {
+		return fmt.Errorf("to needs labels or an ip")
+	}
+	if c.To.IP != "" && net.ParseIP(c.To.IP) == nil {
+		return fmt.Errorf("invalid to.ip %q", c.To.IP)
+	}
+	return nil
+}
+
+// String describes the flow of the case, naming cases without a name
+func (c Case) String() string {
+	protocol := c.Protocol
+	if protocol == "" {
+		protocol = "TCP"
+	}
+	return fmt.Sprintf("%s -> %s %s/%d", c.From, c.To, strings.ToUpper(protocol), c.Port)
+}
+
+// Result is the outcome of a case
+type Result struct {
+	File    string
+	Case    Case
+	Verdict policy.Verdict
+	Passed  bool
+}
+
+// Run evaluates cases against policies
+func Run(policies []policy.NetworkPolicy, path string, cases []Case) []Result {
+	results := make([]Result, 0, len(cases))
+	for _, c := range cases {
+		verdict := policy.Simulate(policies, policy.Flow{From: c.From, To: c.To, Protocol: c.Protocol, Port: c.Port})
+		results = append(results, Result{
+			File:    path,
+			Case:    c,
+			Verdict: verdict,
+			Passed:  verdict.Allowed == (c.Expect == ExpectAllow),
+		})
+	}
+	return results
+}
+
+// DefaultPath returns the test file conventionally kept alongside a policy
+// file: web-to-db_test.yaml for web-to-db.yaml
+func DefaultPath(policyFile string) string {
+	ext := filepath.Ext(policyFile)
+	return strings.TrimSuffix(policyFile, ext) + "_test" + ext
+}
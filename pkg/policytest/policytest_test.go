@@ -0,0 +1,69 @@
+package policytest
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ztap/pkg/policy"
+)
+
+func TestExampleTests(t *testing.T) {
+	policies, err := policy.LoadFromFile("../../examples/web-to-db.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := DefaultPath("../../examples/web-to-db.yaml")
+	cases, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("Expected test cases")
+	}
+	for _, result := range Run(policies, path, cases) {
+		if !result.Passed {
+			t.Errorf("%s failed: %s", result.Case.Name, result.Verdict.Reason)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy_test.yaml")
+	os.WriteFile(path, []byte(`tests:
+  - from: {labels: {app: web}}
+    to: {ip: 10.0.0.5}
+    port: 443
+    expect: deny
+`), 0600)
+	cases, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(cases) != 1 || cases[0].Name != "app=web -> 10.0.0.5 TCP/443" {
+		t.Errorf("Expected the case named after its flow, got %+v", cases)
+	}
+
+	for _, invalid := range []string{
+		"tests:\n  - {from: {labels: {app: web}}, to: {ip: 10.0.0.5}, port: 443, expect: maybe}\n",
+		"tests:\n  - {from: {labels: {app: web}}, to: {ip: 10.0.0.5}, port: 0, expect: deny}\n",
+		"tests:\n  - {from: {labels: {app: web}}, port: 443, expect: deny}\n",
+		"tests:\n  - {from: {labels: {app: web}}, to: {ip: db}, port: 443, expect: deny}\n",
+		"tests:\n  - {from: {labels: {app: web}}, to: {ip: 10.0.0.5}, port: 443, expected: deny}\n",
+	} {
+		os.WriteFile(path, []byte(invalid), 0600)
+		if _, err := Load(path); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestDefaultPath(t *testing.T) {
+	if got := DefaultPath("policies/web-to-db.yaml"); got != "policies/web-to-db_test.yaml" {
+		t.Errorf("Unexpected test file %s", got)
+	}
+	if got := DefaultPath("policy.yml"); !strings.HasSuffix(got, "policy_test.yml") {
+		t.Errorf("Unexpected test file %s", got)
+	}
+}
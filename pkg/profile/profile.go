@@ -0,0 +1,105 @@
+// Package profile reads the contexts section of config.yaml: named profiles
+// of the environments a CLI user manages, chosen with 'ztap config
+// use-context' or --context
+package profile
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Discovery backends a context can select
+const (
+	DiscoveryMemory     = "memory"     // Services registered with 'ztap discovery register'
+	DiscoveryDNS        = "dns"        // Labels resolved as <key>-<value>...<domain>
+	DiscoveryConsul     = "consul"     // Consul agent at address
+	DiscoveryKubernetes = "kubernetes" // Pods of the context's namespace
+)
+
+// DiscoveryConfig selects the discovery backend labels resolve against
+type DiscoveryConfig struct {
+	Backend string `yaml:"backend"` // One of the Discovery* backends; memory if empty
+	Domain  string `yaml:"domain"`  // Required for dns
+	Address string `yaml:"address"` // Required for consul
+}
+
+// Context is a named profile of an environment. Fields left empty keep the
+// CLI's defaults, and flags given on the command line override them.
+type Context struct {
+	// Server is the URL of the API server ('ztap serve') the CLI manages,
+	// e.g. https://ztap.example.com; empty for this host's stores
+	Server string `yaml:"server"`
+	// Tenant is the default of --tenant
+	Tenant string `yaml:"tenant"`
+	// Region is the default AWS region of --region and --aws-region
+	Region string `yaml:"region"`
+	// Namespace is the default of --namespace: the namespace label of
+	// services registered, listed and logged, and the namespace Kubernetes
+	// discovery resolves in
+	Namespace string          `yaml:"namespace"`
+	Discovery DiscoveryConfig `yaml:"discovery"`
+}
+
+// Validate checks the context
+func (c Context) Validate() error {
+	if c.Server != "" {
+		if u, err := url.Parse(c.Server); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("server must be an http or https URL, got %q", c.Server)
+		}
+	}
+	switch c.Discovery.Backend {
+	case "", DiscoveryMemory, DiscoveryKubernetes:
+	case DiscoveryDNS:
+		if c.Discovery.Domain == "" {
+			return fmt.Errorf("discovery.domain is required for the dns backend")
+		}
+	case DiscoveryConsul:
+		if c.Discovery.Address == "" {
+			return fmt.Errorf("discovery.address is required for the consul backend")
+		}
+	default:
+		return fmt.Errorf("discovery.backend must be memory, dns, consul or kubernetes, got %q", c.Discovery.Backend)
+	}
+	return nil
+}
+
+// profileFile is the part of config.yaml read by LoadContexts
+type profileFile struct {
+	Contexts map[string]Context `yaml:"contexts"`
+}
+
+// LoadContexts reads the contexts section of the config.yaml at path, none
+// if the file does not exist
+func LoadContexts(path string) (map[string]Context, error) {
+	var file profileFile
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	for name, context := range file.Contexts {
+		if err := context.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: contexts.%s: %w", path, name, err)
+		}
+	}
+	return file.Contexts, nil
+}
+
+// Names returns the names of contexts in order
+func Names(contexts map[string]Context) []string {
+	names := make([]string, 0, len(contexts))
+	for name := range contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,55 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadContexts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if contexts, err := LoadContexts(path); err != nil || contexts != nil {
+		t.Fatalf("Expected no contexts without a config file, got %v, %v", contexts, err)
+	}
+
+	config := `contexts:
+  prod:
+    server: https://ztap.example.com
+    tenant: acme
+    region: eu-west-1
+  lab:
+    namespace: payments
+    discovery:
+      backend: dns
+      domain: lab.internal
+`
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	contexts, err := LoadContexts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := Names(contexts); len(names) != 2 || names[0] != "lab" {
+		t.Errorf("Expected lab and prod, got %v", names)
+	}
+	if prod := contexts["prod"]; prod.Server != "https://ztap.example.com" || prod.Tenant != "acme" || prod.Region != "eu-west-1" {
+		t.Errorf("Unexpected prod context %+v", prod)
+	}
+	if lab := contexts["lab"]; lab.Server != "" || lab.Discovery.Domain != "lab.internal" {
+		t.Errorf("Expected a local context with DNS discovery, got %+v", lab)
+	}
+
+	for _, invalid := range []string{
+		"contexts:\n  prod:\n    server: ztap.example.com\n",
+		"contexts:\n  lab:\n    discovery:\n      backend: dns\n",
+		"contexts:\n  lab:\n    discovery:\n      backend: etcd\n",
+	} {
+		if err := os.WriteFile(path, []byte(invalid), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadContexts(path); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Package prune finds egress rules that have not matched any traffic and
+// removes them, so policies can be tightened to what workloads actually use.
+package prune
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"ztap/pkg/policy"
+	"ztap/pkg/stats"
+)
+
+// Rule is one port of an egress rule and the traffic it matched
+type Rule struct {
+	Policy   string    `json:"policy"`
+	Egress   int       `json:"egress"` // Index into spec.egress
+	Target   string    `json:"target"` // CIDR or label selector
+	Protocol string    `json:"protocol"`
+	Port     int       `json:"port"`
+	EndPort  int       `json:"end_port,omitempty"` // Last port of a range; 0 for a single port
+	Hits     int64     `json:"hits"`
+	LastHour time.Time `json:"last_hour,omitempty"` // Zero if never hit
+}
+
+// Unused reports whether the rule matched no traffic
+func (r Rule) Unused() bool {
+	return r.Hits == 0
+}
+
+// Resolver resolves label selectors to endpoint IPs
+type Resolver interface {
+	ResolveLabels(labels map[string]string) ([]string, error)
+}
+
+// target is the destination of an egress rule
+type target struct {
+	network  *net.IPNet
+	ips      map[string]bool
+	selector bool
+}
+
+func (t target) contains(ip string) bool {
+	if t.network != nil {
+		parsed := net.ParseIP(ip)
+		return parsed != nil && t.network.Contains(parsed)
+	}
+	return t.ips[ip]
+}
+
+// Analyze attributes hits to the egress rule ports of policies. A hit counts
+// for a rule port of its policy with the same protocol and port whose
+// destination contains the hit's IP. Label selectors are resolved with
+// resolver as they are now, so a hit to an IP a selector no longer resolves
+// to counts for every selector rule of its policy with that protocol and
+// port: a rule is only reported unused when no hit could have matched it.
+func Analyze(policies []policy.NetworkPolicy, hits []stats.RuleHit, resolver Resolver) []Rule {
+	var rules []Rule
+	var targets []target
+	for _, p := range policies {
+		for i, egress := range p.Spec.Egress {
+			t, desc := resolveTarget(egress.To.IPBlock.CIDR, egress.To.PodSelector.MatchLabels, resolver)
+			if egress.To.Group != "" {
+				// Group members change over time like selector IPs; any hit
+				// on the rule's port may have been to one of them
+				t, desc = target{ips: make(map[string]bool), selector: true}, "group "+egress.To.Group
+			}
+			for _, port := range egress.Ports {
+				rules = append(rules, Rule{
+					Policy:   p.Metadata.Name,
+					Egress:   i,
+					Target:   desc,
+					Protocol: strings.ToUpper(port.Protocol),
+					Port:     port.Port,
+					EndPort:  port.EndPort,
+				})
+				targets = append(targets, t)
+			}
+		}
+	}
+
+	for _, hit := range hits {
+		matched := false
+		for i := range rules {
+			if rules[i].matches(hit) && targets[i].contains(hit.IP) {
+				rules[i].record(hit)
+				matched = true
+			}
+		}
+		if matched {
+			continue
+		}
+		for i := range rules {
+			if rules[i].matches(hit) && targets[i].selector {
+				rules[i].record(hit)
+			}
+		}
+	}
+	return rules
+}
+
+func (r *Rule) matches(hit stats.RuleHit) bool {
+	return r.Policy == hit.Policy && hit.Port >= r.Port && hit.Port <= max(r.Port, r.EndPort) && r.Protocol == strings.ToUpper(hit.Protocol)
+}
+
+func (r *Rule) record(hit stats.RuleHit) {
+	r.Hits += hit.Count
+	if hit.LastHour.After(r.LastHour) {
+		r.LastHour = hit.LastHour
+	}
+}
+
+// resolveTarget returns the destination of an egress rule and a description
+func resolveTarget(cidr string, selector map[string]string, resolver Resolver) (target, string) {
+	if cidr != "" {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return target{}, cidr
+		}
+		return target{network: network}, cidr
+	}
+
+	t := target{ips: make(map[string]bool), selector: true}
+	if resolver != nil {
+		ips, _ := resolver.ResolveLabels(selector)
+		for _, ip := range ips {
+			t.ips[ip] = true
+		}
+	}
+	pairs := make([]string, 0, len(selector))
+	for k, v := range selector {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return t, strings.Join(pairs, ",")
+}
+
+// Prune returns copies of policies without the unused rule ports. Egress
+// rules left without ports are dropped; the names of policies left without
+// egress rules, which then allow nothing, are returned as emptied.
+func Prune(policies []policy.NetworkPolicy, rules []Rule) (pruned []policy.NetworkPolicy, emptied []string) {
+	unused := make(map[string]bool)
+	for _, r := range rules {
+		if r.Unused() {
+			unused[portKey(r.Policy, r.Egress, r.Protocol, policy.PortRange(r.Port, r.EndPort))] = true
+		}
+	}
+
+	for _, p := range policies {
+		out := p
+		out.Spec.Egress = nil
+		for i, egress := range p.Spec.Egress {
+			kept := egress
+			kept.Ports = nil
+			for _, port := range egress.Ports {
+				if !unused[portKey(p.Metadata.Name, i, strings.ToUpper(port.Protocol), policy.PortRange(port.Port, port.EndPort))] {
+					kept.Ports = append(kept.Ports, port)
+				}
+			}
+			if len(kept.Ports) > 0 {
+				out.Spec.Egress = append(out.Spec.Egress, kept)
+			}
+		}
+		if len(p.Spec.Egress) > 0 && len(out.Spec.Egress) == 0 {
+			emptied = append(emptied, p.Metadata.Name)
+		}
+		pruned = append(pruned, out)
+	}
+	return pruned, emptied
+}
+
+func portKey(policyName string, egress int, protocol, ports string) string {
+	return fmt.Sprintf("%s/%d/%s/%s", policyName, egress, protocol, ports)
+}
@@ -0,0 +1,186 @@
+package prune
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/policy"
+	"ztap/pkg/stats"
+)
+
+var hour = time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+
+type staticResolver map[string][]string
+
+func (r staticResolver) ResolveLabels(labels map[string]string) ([]string, error) {
+	return r[labels["app"]], nil
+}
+
+func loadPolicies(t *testing.T) []policy.NetworkPolicy {
+	t.Helper()
+	policies, err := policy.Parse([]byte(`
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-egress
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+        - protocol: TCP
+          port: 6432
+    - to:
+        ipBlock:
+          cidr: 10.1.0.0/16
+      ports:
+        - protocol: UDP
+          port: 53
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: batch-egress
+spec:
+  podSelector:
+    matchLabels:
+      app: batch
+  egress:
+    - to:
+        ipBlock:
+          cidr: 0.0.0.0/0
+      ports:
+        - protocol: TCP
+          port: 443
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse policies: %v", err)
+	}
+	return policies
+}
+
+func TestAnalyze(t *testing.T) {
+	hits := []stats.RuleHit{
+		{Policy: "web-egress", Protocol: "TCP", IP: "10.0.2.1", Port: 5432, Count: 7, LastHour: hour},
+		{Policy: "web-egress", Protocol: "TCP", IP: "10.0.2.1", Port: 6432, Count: 1, LastHour: hour.Add(-time.Hour)},
+		{Policy: "web-egress", Protocol: "udp", IP: "192.168.0.1", Port: 53, Count: 3, LastHour: hour},
+	}
+	rules := Analyze(loadPolicies(t), hits, staticResolver{"db": {"10.0.2.1"}})
+
+	if len(rules) != 4 {
+		t.Fatalf("Expected 4 rules, got %+v", rules)
+	}
+	want := []struct {
+		target string
+		hits   int64
+	}{
+		{"app=db", 7},
+		{"app=db", 1},
+		{"10.1.0.0/16", 0}, // Hit IP is outside the CIDR
+		{"0.0.0.0/0", 0},
+	}
+	for i, w := range want {
+		if rules[i].Target != w.target || rules[i].Hits != w.hits {
+			t.Errorf("Rule %d: expected %s with %d hits, got %+v", i, w.target, w.hits, rules[i])
+		}
+	}
+	if !rules[0].LastHour.Equal(hour) {
+		t.Errorf("Expected last hit at %v, got %v", hour, rules[0].LastHour)
+	}
+}
+
+func TestAnalyzeAttributesStaleSelectorHits(t *testing.T) {
+	hits := []stats.RuleHit{
+		{Policy: "web-egress", Protocol: "TCP", IP: "10.0.2.99", Port: 5432, Count: 2, LastHour: hour},
+	}
+	rules := Analyze(loadPolicies(t), hits, staticResolver{"db": {"10.0.2.1"}})
+	if rules[0].Hits != 2 {
+		t.Errorf("Expected hit to an IP the selector no longer resolves to to count, got %+v", rules[0])
+	}
+}
+
+func TestAnalyzePortRange(t *testing.T) {
+	policies, err := policy.Parse([]byte(`
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-egress
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.1.0.0/16
+      ports:
+        - protocol: TCP
+          port: 8000-8080
+        - protocol: TCP
+          port: 9000-9010
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse policies: %v", err)
+	}
+	hits := []stats.RuleHit{{Policy: "web-egress", Protocol: "TCP", IP: "10.1.0.5", Port: 8042, Count: 4, LastHour: hour}}
+	rules := Analyze(policies, hits, nil)
+	if len(rules) != 2 || rules[0].Hits != 4 || !rules[1].Unused() {
+		t.Fatalf("Expected a hit within the first range only, got %+v", rules)
+	}
+
+	pruned, _ := Prune(policies, rules)
+	if ports := pruned[0].Spec.Egress[0].Ports; len(ports) != 1 || ports[0].EndPort != 8080 {
+		t.Errorf("Expected the unused range pruned, got %+v", ports)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	policies := loadPolicies(t)
+	hits := []stats.RuleHit{
+		{Policy: "web-egress", Protocol: "TCP", IP: "10.0.2.1", Port: 5432, Count: 7, LastHour: hour},
+	}
+	rules := Analyze(policies, hits, staticResolver{"db": {"10.0.2.1"}})
+
+	pruned, emptied := Prune(policies, rules)
+	if len(pruned) != 2 {
+		t.Fatalf("Expected 2 policies, got %d", len(pruned))
+	}
+	web := pruned[0]
+	if len(web.Spec.Egress) != 1 || len(web.Spec.Egress[0].Ports) != 1 || web.Spec.Egress[0].Ports[0].Port != 5432 {
+		t.Errorf("Expected only the 5432 rule to remain, got %+v", web.Spec.Egress)
+	}
+	if len(policies[0].Spec.Egress) != 2 {
+		t.Error("Expected Prune to leave the input policies unchanged")
+	}
+	if len(emptied) != 1 || emptied[0] != "batch-egress" {
+		t.Errorf("Expected batch-egress to be emptied, got %v", emptied)
+	}
+
+	data, err := policy.Marshal(pruned)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if strings.Contains(string(data), "6432") || strings.Contains(string(data), "10.1.0.0/16") {
+		t.Errorf("Unused rules left in YAML:\n%s", data)
+	}
+	reparsed, err := policy.Parse(data)
+	if err != nil {
+		t.Fatalf("Failed to parse pruned YAML: %v", err)
+	}
+	if len(reparsed) != 2 || reparsed[0].Spec.Egress[0].To.PodSelector.MatchLabels["app"] != "db" {
+		t.Errorf("Unexpected round trip: %+v", reparsed)
+	}
+	for _, p := range reparsed {
+		if err := p.Validate(); err != nil {
+			t.Errorf("Pruned policy is invalid: %v", err)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+// Package quota limits what each tenant may store: policies, rules per
+// policy and registered services. Limits are checked when a policy is
+// stored or a service registered, so a single team cannot exhaust the
+// enforcement backend's map capacity for everyone else.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/configfile"
+	"ztap/pkg/events"
+	"ztap/pkg/policy"
+	"ztap/pkg/storage"
+)
+
+// ErrExceeded is returned when storing a policy or registering a service
+// would go over the tenant's quota
+var ErrExceeded = errors.New("quota exceeded")
+
+// Resources limited by Limits, as named in errors and 'ztap quota'
+const (
+	ResourcePolicies = "policies"
+	ResourceRules    = "rules per policy"
+	ResourceServices = "services"
+)
+
+// Limits are the quotas of one tenant. Zero means unlimited in the default
+// limits, and inherited from them in a tenant's.
+type Limits struct {
+	MaxPolicies       int `yaml:"max_policies"`
+	MaxRulesPerPolicy int `yaml:"max_rules_per_policy"`
+	MaxServices       int `yaml:"max_services"`
+}
+
+// validate checks that no limit is negative
+func (l Limits) validate(section string) error {
+	for field, value := range map[string]int{
+		"max_policies":         l.MaxPolicies,
+		"max_rules_per_policy": l.MaxRulesPerPolicy,
+		"max_services":         l.MaxServices,
+	} {
+		if value < 0 {
+			return fmt.Errorf("%s.%s must not be negative", section, field)
+		}
+	}
+	return nil
+}
+
+// Config is the quotas section of config.yaml. Default applies to every
+// tenant, including users without one; Tenants overrides it per tenant.
+type Config struct {
+	Default Limits            `yaml:"default"`
+	Tenants map[string]Limits `yaml:"tenants"`
+}
+
+// Enabled reports whether any limit is set
+func (c Config) Enabled() bool {
+	if c.Default != (Limits{}) {
+		return true
+	}
+	for _, limits := range c.Tenants {
+		if limits != (Limits{}) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the limits and tenant names
+func (c Config) Validate() error {
+	if err := c.Default.validate("quotas.default"); err != nil {
+		return err
+	}
+	for tenant, limits := range c.Tenants {
+		if tenant == "" || auth.ValidateTenant(tenant) != nil {
+			return fmt.Errorf("quotas.tenants: invalid tenant %q", tenant)
+		}
+		if err := limits.validate("quotas.tenants." + tenant); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// For returns the limits of tenant: its own where set, the default ones
+// otherwise
+func (c Config) For(tenant string) Limits {
+	limits := c.Default
+	override, ok := c.Tenants[tenant]
+	if !ok {
+		return limits
+	}
+	if override.MaxPolicies != 0 {
+		limits.MaxPolicies = override.MaxPolicies
+	}
+	if override.MaxRulesPerPolicy != 0 {
+		limits.MaxRulesPerPolicy = override.MaxRulesPerPolicy
+	}
+	if override.MaxServices != 0 {
+		limits.MaxServices = override.MaxServices
+	}
+	return limits
+}
+
+// LoadConfig reads the quotas section of file. A missing file or section sets
+// no limits.
+func LoadConfig(file *configfile.File) (Config, error) {
+	var c Config
+	if err := file.Section("quotas", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Rules counts the rules of p: one per egress or ingress peer and port, one
+// per port of a range, and one for a peer allowed on every port. This is the
+// number of entries p takes in the backend per resolved peer.
+func Rules(p *policy.NetworkPolicy) int {
+	rules := 0
+	for _, egress := range p.Spec.Egress {
+		rules += portEntries(egress.Ports)
+	}
+	for _, ingress := range p.Spec.Ingress {
+		rules += portEntries(ingress.Ports)
+	}
+	return rules
+}
+
+// portEntries returns the entries ports take per peer, 1 if there are none
+func portEntries(ports []policy.PolicyPort) int {
+	if len(ports) == 0 {
+		return 1
+	}
+	entries := 0
+	for _, port := range ports {
+		entries += port.Ports()
+	}
+	return entries
+}
+
+// exceeded returns the error for a resource over its limit
+func exceeded(tenant, resource string, limit int, detail string) error {
+	who := "tenant " + tenant
+	if tenant == "" {
+		who = "the default tenant"
+	}
+	return fmt.Errorf("%w: %s is limited to %d %s (%s)", ErrExceeded, who, limit, resource, detail)
+}
+
+// Usage is what a tenant uses of its quotas
+type Usage struct {
+	Policies int
+	// Rules is the largest rule count of a stored policy, LargestPolicy its
+	// name
+	Rules         int
+	LargestPolicy string
+	Services      int
+}
+
+// Store is a policy store that refuses to store a policy over the quotas of
+// the context's tenant (auth.TenantFromContext). Other calls go to the
+// wrapped store.
+type Store struct {
+	storage.PolicyStore
+	config Config
+}
+
+// NewStore enforces config on writes to policies
+func NewStore(policies storage.PolicyStore, config Config) *Store {
+	return &Store{PolicyStore: policies, config: config}
+}
+
+// Admit checks that the policy YAML document can be stored as name without
+// going over the tenant's quotas. Replacing a stored policy does not count
+// against max_policies.
+func (s *Store) Admit(ctx context.Context, name, document string) error {
+	tenant := auth.TenantFromContext(ctx)
+	limits := s.config.For(tenant)
+
+	if limits.MaxRulesPerPolicy > 0 {
+		policies, err := policy.Parse([]byte(document))
+		if err != nil {
+			return fmt.Errorf("invalid policy YAML: %v", err)
+		}
+		for i := range policies {
+			if rules := Rules(&policies[i]); rules > limits.MaxRulesPerPolicy {
+				return exceeded(tenant, ResourceRules, limits.MaxRulesPerPolicy,
+					fmt.Sprintf("policy %s has %d", policies[i].Metadata.Name, rules))
+			}
+		}
+	}
+
+	if limits.MaxPolicies > 0 {
+		records, err := s.ListPolicies(ctx)
+		if err != nil {
+			return err
+		}
+		stored := 0
+		for _, record := range records {
+			if record.Name == name {
+				return nil
+			}
+			stored++
+		}
+		if stored >= limits.MaxPolicies {
+			return exceeded(tenant, ResourcePolicies, limits.MaxPolicies, fmt.Sprintf("%d stored", stored))
+		}
+	}
+	return nil
+}
+
+// PutPolicy stores the policy if Admit allows it
+func (s *Store) PutPolicy(ctx context.Context, name, document, principal string) (storage.PolicyRecord, error) {
+	if err := s.Admit(ctx, name, document); err != nil {
+		return storage.PolicyRecord{}, err
+	}
+	return s.PolicyStore.PutPolicy(ctx, name, document, principal)
+}
+
+// Usage returns what the context's tenant uses, with the services it has
+// registered according to history (see Services)
+func (s *Store) Usage(ctx context.Context, history []events.Event) (Usage, error) {
+	records, err := s.ListPolicies(ctx)
+	if err != nil {
+		return Usage{}, err
+	}
+	tenant := auth.TenantFromContext(ctx)
+	usage := Usage{Policies: len(records), Services: len(Services(history, tenant))}
+	for _, record := range records {
+		policies, err := policy.Parse([]byte(record.YAML))
+		if err != nil {
+			continue
+		}
+		for i := range policies {
+			if rules := Rules(&policies[i]); rules > usage.Rules {
+				usage.Rules = rules
+				usage.LargestPolicy = record.Name
+			}
+		}
+	}
+	return usage, nil
+}
+
+// Services returns the names of the services registered by tenant and not
+// since deregistered, replaying service_changed events in order
+func Services(history []events.Event, tenant string) []string {
+	registered := make(map[string]bool)
+	for _, event := range history {
+		change, ok := event.Data.(events.ServiceChanged)
+		if !ok || event.Tenant != tenant {
+			continue
+		}
+		if change.Removed {
+			delete(registered, change.Name)
+		} else {
+			registered[change.Name] = true
+		}
+	}
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AdmitService checks that tenant may register the service name, given the
+// services it has registered according to history. Re-registering a
+// service does not count against max_services.
+func (c Config) AdmitService(history []events.Event, tenant, name string) error {
+	limit := c.For(tenant).MaxServices
+	if limit <= 0 {
+		return nil
+	}
+	services := Services(history, tenant)
+	for _, registered := range services {
+		if registered == name {
+			return nil
+		}
+	}
+	if len(services) >= limit {
+		return exceeded(tenant, ResourceServices, limit, fmt.Sprintf("%d registered", len(services)))
+	}
+	return nil
+}
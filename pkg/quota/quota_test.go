@@ -0,0 +1,151 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/configfile"
+	"ztap/pkg/events"
+	"ztap/pkg/policy"
+	"ztap/pkg/storage"
+)
+
+// policyYAML returns a policy with one egress rule per port
+func policyYAML(name string, ports ...string) string {
+	var b strings.Builder
+	b.WriteString("apiVersion: ztap/v1\nkind: NetworkPolicy\nmetadata:\n  name: " + name + "\n")
+	b.WriteString("spec:\n  podSelector:\n    matchLabels:\n      app: web\n  egress:\n")
+	b.WriteString("    - to:\n        ipBlock:\n          cidr: 10.0.0.0/24\n      ports:\n")
+	for _, port := range ports {
+		b.WriteString("        - protocol: TCP\n          port: " + port + "\n")
+	}
+	return b.String()
+}
+
+func TestStoreAdmit(t *testing.T) {
+	store := NewStore(storage.NewFilePolicyStore(filepath.Join(t.TempDir(), "policies.json")), Config{
+		Default: Limits{MaxPolicies: 1, MaxRulesPerPolicy: 2},
+		Tenants: map[string]Limits{"acme": {MaxPolicies: 2}},
+	})
+	ctx := context.Background()
+	acme := auth.WithTenant(ctx, "acme")
+
+	if _, err := store.PutPolicy(ctx, "web", policyYAML("web", "80", "443"), "alice"); err != nil {
+		t.Fatalf("PutPolicy failed: %v", err)
+	}
+	// Replacing a stored policy does not count against max_policies
+	if _, err := store.PutPolicy(ctx, "web", policyYAML("web", "443"), "alice"); err != nil {
+		t.Fatalf("Expected the policy to be replaced, got %v", err)
+	}
+	_, err := store.PutPolicy(ctx, "db", policyYAML("db", "5432"), "alice")
+	if !errors.Is(err, ErrExceeded) || !strings.Contains(err.Error(), "limited to 1 policies") {
+		t.Errorf("Expected the policy quota exceeded, got %v", err)
+	}
+	_, err = store.PutPolicy(acme, "web", policyYAML("web", "80", "443", "8080"), "ann")
+	if !errors.Is(err, ErrExceeded) || !strings.Contains(err.Error(), "policy web has 3") {
+		t.Errorf("Expected acme to inherit the default rule limit, got %v", err)
+	}
+
+	// acme's own max_policies applies, and the default tenant's policies are
+	// not counted
+	for _, name := range []string{"web", "db"} {
+		if _, err := store.PutPolicy(acme, name, policyYAML(name, "443"), "ann"); err != nil {
+			t.Fatalf("PutPolicy %s failed: %v", name, err)
+		}
+	}
+	if _, err := store.PutPolicy(acme, "cache", policyYAML("cache", "6379"), "ann"); !errors.Is(err, ErrExceeded) {
+		t.Errorf("Expected acme's policy quota exceeded, got %v", err)
+	}
+
+	usage, err := store.Usage(acme, nil)
+	if err != nil || usage.Policies != 2 || usage.Rules != 1 {
+		t.Errorf("Unexpected usage %+v, %v", usage, err)
+	}
+}
+
+func TestRulesPortRanges(t *testing.T) {
+	policies, err := policy.Parse([]byte(policyYAML("web", "443", `"8000-8009"`)))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	// A range takes one backend entry per port
+	if rules := Rules(&policies[0]); rules != 11 {
+		t.Errorf("Expected 11 rules, got %d", rules)
+	}
+
+	store := NewStore(storage.NewFilePolicyStore(filepath.Join(t.TempDir(), "policies.json")), Config{
+		Default: Limits{MaxRulesPerPolicy: 10},
+	})
+	_, err = store.PutPolicy(context.Background(), "web", policyYAML("web", `"8000-8009"`, "443"), "alice")
+	if !errors.Is(err, ErrExceeded) || !strings.Contains(err.Error(), "policy web has 11") {
+		t.Errorf("Expected the range counted per port, got %v", err)
+	}
+}
+
+func TestAdmitService(t *testing.T) {
+	history := []events.Event{
+		{Tenant: "acme", Data: events.ServiceChanged{Name: "web", IP: "10.0.0.1"}},
+		{Tenant: "acme", Data: events.ServiceChanged{Name: "db", IP: "10.0.0.2"}},
+		{Tenant: "acme", Data: events.ServiceChanged{Name: "db", Removed: true}},
+		{Tenant: "globex", Data: events.ServiceChanged{Name: "api", IP: "10.0.0.3"}},
+	}
+	if got := Services(history, "acme"); len(got) != 1 || got[0] != "web" {
+		t.Errorf("Expected acme to have web registered, got %v", got)
+	}
+
+	config := Config{Default: Limits{MaxServices: 1}}
+	if err := config.AdmitService(history, "acme", "web"); err != nil {
+		t.Errorf("Expected re-registration allowed, got %v", err)
+	}
+	if err := config.AdmitService(history, "acme", "db"); !errors.Is(err, ErrExceeded) {
+		t.Errorf("Expected the service quota exceeded, got %v", err)
+	}
+	if err := config.AdmitService(history, "", "db"); err != nil {
+		t.Errorf("Expected the default tenant under its quota, got %v", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+quotas:
+  default:
+    max_policies: 50
+    max_services: 100
+  tenants:
+    acme:
+      max_policies: 10
+`), 0600)
+	config, err := configfile.Read(path, LoadConfig)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if !config.Enabled() {
+		t.Error("Expected quotas enabled")
+	}
+	if got := config.For("acme"); got != (Limits{MaxPolicies: 10, MaxServices: 100}) {
+		t.Errorf("Unexpected acme limits %+v", got)
+	}
+	if got := config.For("globex"); got != (Limits{MaxPolicies: 50, MaxServices: 100}) {
+		t.Errorf("Unexpected default limits %+v", got)
+	}
+
+	for _, invalid := range []string{
+		"quotas:\n  default:\n    max_policies: -1\n",
+		"quotas:\n  tenants:\n    ACME:\n      max_services: 1\n",
+	} {
+		os.WriteFile(path, []byte(invalid), 0600)
+		if _, err := configfile.Read(path, LoadConfig); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+
+	if config, err := configfile.Read(filepath.Join(t.TempDir(), "missing.yaml"), LoadConfig); err != nil || config.Enabled() {
+		t.Errorf("Expected no quotas without a config file, got %+v, %v", config, err)
+	}
+}
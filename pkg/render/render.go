@@ -0,0 +1,255 @@
+// Package render prints command output as a table, JSON or YAML, so commands
+// describe what to show rather than how. Messages and table headers go
+// through a Catalog, so output can be localized without touching the
+// commands, and timestamps are formatted the same way everywhere.
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Format is an output format
+type Format string
+
+const (
+	FormatTable Format = "table"
+	FormatJSON  Format = "json"
+	FormatYAML  Format = "yaml"
+)
+
+// ParseFormat parses an --output value; "text" is an alias of table
+func ParseFormat(s string) (Format, error) {
+	switch format := Format(s); format {
+	case "", "text":
+		return FormatTable, nil
+	case FormatTable, FormatJSON, FormatYAML:
+		return format, nil
+	}
+	return "", fmt.Errorf("unknown output format %q (use table, json or yaml)", s)
+}
+
+// TimeFormat is how tables show timestamps, in local time. JSON and YAML
+// carry them as RFC 3339 in UTC.
+const TimeFormat = time.RFC3339
+
+// Catalog translates messages and table headers, keyed by their English
+// text (a format string for messages). Missing entries are shown as is.
+type Catalog map[string]string
+
+func (c Catalog) translate(s string) string {
+	if translated, ok := c[s]; ok {
+		return translated
+	}
+	return s
+}
+
+// Printer writes command output
+type Printer struct {
+	out     io.Writer
+	status  io.Writer // Messages in JSON and YAML output, which keep out parseable
+	format  Format
+	quiet   bool
+	catalog Catalog
+}
+
+// New returns a printer writing format to out. With quiet, tables are
+// reduced to their first column (e.g. the IDs, for piping into other
+// commands) and messages are dropped; errors are unaffected.
+func New(out io.Writer, format Format, quiet bool) *Printer {
+	return &Printer{out: out, status: os.Stderr, format: format, quiet: quiet}
+}
+
+// SetCatalog sets the catalog messages and headers are translated with
+func (p *Printer) SetCatalog(catalog Catalog) {
+	p.catalog = catalog
+}
+
+// Format returns the output format
+func (p *Printer) Format() Format {
+	return p.format
+}
+
+// Structured reports whether the output is JSON or YAML
+func (p *Printer) Structured() bool {
+	return p.format == FormatJSON || p.format == FormatYAML
+}
+
+// Printf writes a message, e.g. "Node %s cordoned". It is dropped with
+// quiet, and written to stderr with JSON or YAML output.
+func (p *Printer) Printf(format string, args ...any) {
+	if p.quiet {
+		return
+	}
+	w := p.out
+	if p.Structured() {
+		w = p.status
+	}
+	fmt.Fprintf(w, p.catalog.translate(format), args...)
+}
+
+// Column is a table column; Key names it in JSON and YAML output
+type Column struct {
+	Header string
+	Key    string
+}
+
+// Table is a list of records
+type Table struct {
+	Columns []Column
+	Rows    [][]any
+	// Empty is the message shown in table output when there are no rows,
+	// e.g. "No users found"
+	Empty string
+}
+
+// AddRow appends a row with one cell per column
+func (t *Table) AddRow(cells ...any) {
+	t.Rows = append(t.Rows, cells)
+}
+
+// Table writes t. Cells are formatted by type: timestamps with TimeFormat,
+// booleans as yes/no, lists comma-separated, and empty values as "-".
+func (p *Printer) Table(t Table) error {
+	switch {
+	case p.Structured():
+		records := make([]record, len(t.Rows))
+		for i, row := range t.Rows {
+			for j, column := range t.Columns {
+				records[i] = append(records[i], yaml.MapItem{Key: column.Key, Value: structuredCell(row[j])})
+			}
+		}
+		return p.Value(records)
+	case p.quiet:
+		for _, row := range t.Rows {
+			fmt.Fprintln(p.out, Cell(row[0]))
+		}
+		return nil
+	case len(t.Rows) == 0:
+		if t.Empty != "" {
+			fmt.Fprintln(p.out, p.catalog.translate(t.Empty))
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 0, 2, ' ', 0)
+	headers := make([]string, len(t.Columns))
+	for i, column := range t.Columns {
+		headers[i] = strings.ToUpper(p.catalog.translate(column.Header))
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, row := range t.Rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = Cell(cell)
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
+
+// Value writes v as JSON or YAML. In table output, v is written as a single
+// cell (see Cell), unless quiet.
+func (p *Printer) Value(v any) error {
+	switch p.format {
+	case FormatJSON:
+		encoder := json.NewEncoder(p.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	case FormatYAML:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = p.out.Write(data)
+		return err
+	}
+	if !p.quiet {
+		fmt.Fprintln(p.out, Cell(v))
+	}
+	return nil
+}
+
+// Cell formats a value for a table cell
+func Cell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case string:
+		if v == "" {
+			return "-"
+		}
+		return v
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case time.Time:
+		if v.IsZero() {
+			return "-"
+		}
+		return v.Local().Format(TimeFormat)
+	case []string:
+		if len(v) == 0 {
+			return "-"
+		}
+		return strings.Join(v, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// structuredCell converts a cell for JSON and YAML output
+func structuredCell(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return nil
+		}
+		return v.UTC().Format(time.RFC3339)
+	case time.Duration:
+		return v.String()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+// record is a table row as an object, keeping the column order in JSON and
+// YAML output
+type record yaml.MapSlice
+
+func (r record) MarshalYAML() (any, error) {
+	return yaml.MapSlice(r), nil
+}
+
+func (r record) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, item := range r {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(item.Key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,119 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func usersTable() Table {
+	table := Table{
+		Columns: []Column{{Header: "Username", Key: "username"}, {Header: "Enabled", Key: "enabled"}, {Header: "Last login", Key: "last_login"}},
+		Empty:   "No users found",
+	}
+	table.AddRow("alice", true, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	table.AddRow("bob", false, time.Time{})
+	return table
+}
+
+func TestTable(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New(&buf, FormatTable, false).Table(usersTable()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "USERNAME  ENABLED  LAST LOGIN") {
+		t.Fatalf("Unexpected table:\n%s", buf.String())
+	}
+	if !strings.Contains(lines[1], "yes") || !strings.Contains(lines[1], time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC).Local().Format(TimeFormat)) {
+		t.Errorf("Expected formatted cells, got %q", lines[1])
+	}
+	if !strings.HasSuffix(lines[2], "no       -") {
+		t.Errorf("Expected a dash for a zero time, got %q", lines[2])
+	}
+}
+
+func TestTableEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, FormatTable, false).Table(Table{Columns: usersTable().Columns, Empty: "No users found"})
+	if buf.String() != "No users found\n" {
+		t.Errorf("Expected the empty message, got %q", buf.String())
+	}
+
+	buf.Reset()
+	New(&buf, FormatJSON, false).Table(Table{Columns: usersTable().Columns, Empty: "No users found"})
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("Expected an empty JSON list, got %q", buf.String())
+	}
+}
+
+func TestTableQuiet(t *testing.T) {
+	var buf bytes.Buffer
+	p := New(&buf, FormatTable, true)
+	p.Printf("Listing users\n")
+	if err := p.Table(usersTable()); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "alice\nbob\n" {
+		t.Errorf("Expected only the first column, got %q", buf.String())
+	}
+}
+
+func TestTableJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New(&buf, FormatJSON, false).Table(usersTable()); err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+	if !(strings.Index(output, `"username"`) < strings.Index(output, `"enabled"`) &&
+		strings.Index(output, `"enabled"`) < strings.Index(output, `"last_login": "2026-01-02T03:04:05Z"`)) {
+		t.Errorf("Expected records in column order, got:\n%s", buf.String())
+	}
+	var records []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(records) != 2 || records[1]["last_login"] != nil {
+		t.Errorf("Expected a null zero time, got %v", records)
+	}
+}
+
+func TestTableYAML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New(&buf, FormatYAML, false).Table(usersTable()); err != nil {
+		t.Fatal(err)
+	}
+	var records []map[string]any
+	if err := yaml.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatalf("Invalid YAML: %v", err)
+	}
+	if len(records) != 2 || records[0]["username"] != "alice" || records[0]["last_login"] != "2026-01-02T03:04:05Z" {
+		t.Errorf("Unexpected records: %v", records)
+	}
+}
+
+func TestCatalog(t *testing.T) {
+	var buf bytes.Buffer
+	p := New(&buf, FormatTable, false)
+	p.SetCatalog(Catalog{"Username": "Benutzer", "Node %s cordoned\n": "Knoten %s gesperrt\n"})
+	p.Printf("Node %s cordoned\n", "web-1")
+	p.Table(usersTable())
+	if !strings.HasPrefix(buf.String(), "Knoten web-1 gesperrt\nBENUTZER") {
+		t.Errorf("Expected translated output, got:\n%s", buf.String())
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for input, want := range map[string]Format{"text": FormatTable, "": FormatTable, "json": FormatJSON, "yaml": FormatYAML} {
+		if got, err := ParseFormat(input); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,347 @@
+// Package replay evaluates the traffic of packet captures against a policy
+// set with policy.Simulate, so policies can be validated offline against
+// representative traffic before they are enforced.
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Packet is the part of a captured packet policies are evaluated on
+type Packet struct {
+	Src      string // IP address
+	Dst      string
+	Protocol string // TCP, UDP or ICMP (ICMPv6 included)
+	SrcPort  int    // 0 for ICMP
+	DstPort  int
+	SYN, ACK bool // TCP flags
+}
+
+// Link types (LINKTYPE_* in the pcap specification)
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkLinuxSLL = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkLinuxSL2 = 276
+)
+
+// Block types of pcapng
+const (
+	pcapngSectionHeader  = 0x0a0d0d0a
+	pcapngInterface      = 1
+	pcapngSimplePacket   = 3
+	pcapngEnhancedPacket = 6
+)
+
+// pcapngByteOrderMagic is the byte-order magic of a section header block,
+// as read in the section's byte order
+const pcapngByteOrderMagic = 0x1a2b3c4d
+
+// maxRecordLength bounds the records and blocks read, so a corrupt length
+// does not allocate gigabytes
+const maxRecordLength = 16 << 20
+
+// Reader reads the TCP, UDP and ICMP packets of a pcap or pcapng capture.
+// Other packets (ARP, non-first IP fragments, truncated headers) are
+// counted in Skipped.
+type Reader struct {
+	r       io.Reader
+	ng      bool
+	order   binary.ByteOrder
+	link    int   // Link type of a pcap file
+	links   []int // Link types of the pcapng section's interfaces
+	snaplen []int
+	Skipped int
+}
+
+// NewReader reads the file header of a pcap or pcapng capture
+func NewReader(r io.Reader) (*Reader, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, fmt.Errorf("failed to read capture header: %w", err)
+	}
+	reader := &Reader{r: r}
+
+	switch binary.LittleEndian.Uint32(magic[:]) {
+	case 0xa1b2c3d4, 0xa1b23c4d: // Microsecond and nanosecond timestamps
+		reader.order = binary.LittleEndian
+	case 0xd4c3b2a1, 0x4d3cb2a1:
+		reader.order = binary.BigEndian
+	case pcapngSectionHeader:
+		// readBlock reads the section header block again, for its byte order
+		reader.r = io.MultiReader(bytes.NewReader(magic[:]), r)
+		reader.ng = true
+		if _, _, err := reader.readBlock(); err != nil {
+			return nil, err
+		}
+		return reader, nil
+	default:
+		return nil, fmt.Errorf("not a pcap or pcapng capture")
+	}
+
+	// Version, time zone, timestamp accuracy, snapshot length, link type
+	var header [20]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+	reader.link = int(reader.order.Uint32(header[16:]) & 0xffff)
+	if !supportedLink(reader.link) {
+		return nil, fmt.Errorf("unsupported link type %d", reader.link)
+	}
+	return reader, nil
+}
+
+// Next returns the next TCP, UDP or ICMP packet, or io.EOF at the end of
+// the capture
+func (r *Reader) Next() (Packet, error) {
+	for {
+		data, link, err := r.nextFrame()
+		if err != nil {
+			return Packet{}, err
+		}
+		if packet, ok := decodeFrame(link, data); ok {
+			return packet, nil
+		}
+		r.Skipped++
+	}
+}
+
+// nextFrame returns the captured bytes of the next frame and its link type
+func (r *Reader) nextFrame() ([]byte, int, error) {
+	if !r.ng {
+		var header [16]byte
+		if _, err := io.ReadFull(r.r, header[:]); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, 0, fmt.Errorf("truncated pcap record header")
+			}
+			return nil, 0, err
+		}
+		length := r.order.Uint32(header[8:])
+		if length > maxRecordLength {
+			return nil, 0, fmt.Errorf("pcap record of %d bytes is too large", length)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r.r, data); err != nil {
+			return nil, 0, fmt.Errorf("truncated pcap record: %w", err)
+		}
+		return data, r.link, nil
+	}
+
+	for {
+		kind, body, err := r.readBlock()
+		if err != nil {
+			return nil, 0, err
+		}
+		switch kind {
+		case pcapngInterface:
+			if len(body) < 8 {
+				return nil, 0, fmt.Errorf("pcapng interface block too short")
+			}
+			link := int(r.order.Uint16(body[0:]))
+			if !supportedLink(link) {
+				return nil, 0, fmt.Errorf("unsupported link type %d", link)
+			}
+			r.links = append(r.links, link)
+			r.snaplen = append(r.snaplen, int(r.order.Uint32(body[4:])))
+		case pcapngEnhancedPacket:
+			if len(body) < 20 {
+				return nil, 0, fmt.Errorf("pcapng packet block too short")
+			}
+			id := int(r.order.Uint32(body[0:]))
+			length := int(r.order.Uint32(body[12:]))
+			if id >= len(r.links) || length > len(body)-20 {
+				return nil, 0, fmt.Errorf("invalid pcapng packet block")
+			}
+			return body[20 : 20+length], r.links[id], nil
+		case pcapngSimplePacket:
+			if len(body) < 4 || len(r.links) == 0 {
+				return nil, 0, fmt.Errorf("invalid pcapng simple packet block")
+			}
+			length := min(int(r.order.Uint32(body[0:])), len(body)-4)
+			if r.snaplen[0] > 0 {
+				length = min(length, r.snaplen[0])
+			}
+			return body[4 : 4+length], r.links[0], nil
+		}
+	}
+}
+
+// readBlock reads a pcapng block, returning its type and body. A section
+// header block sets the byte order of the blocks following it and resets
+// the interfaces.
+func (r *Reader) readBlock() (uint32, []byte, error) {
+	var header [8]byte // Block type, block length
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, nil, fmt.Errorf("truncated pcapng block header")
+		}
+		return 0, nil, err
+	}
+	kind := binary.LittleEndian.Uint32(header[0:])
+	if kind == pcapngSectionHeader {
+		var magic [4]byte
+		if _, err := io.ReadFull(r.r, magic[:]); err != nil {
+			return 0, nil, fmt.Errorf("truncated pcapng section header: %w", err)
+		}
+		switch binary.LittleEndian.Uint32(magic[:]) {
+		case pcapngByteOrderMagic:
+			r.order = binary.LittleEndian
+		case 0x4d3c2b1a:
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("invalid pcapng byte-order magic")
+		}
+		r.links, r.snaplen = nil, nil
+	} else {
+		kind = r.order.Uint32(header[0:])
+	}
+
+	length := r.order.Uint32(header[4:])
+	if length < 12 || length > maxRecordLength || length%4 != 0 {
+		return 0, nil, fmt.Errorf("invalid pcapng block length %d", length)
+	}
+	rest := int(length) - 8
+	if kind == pcapngSectionHeader {
+		rest -= 4
+	}
+	if rest < 4 {
+		return 0, nil, fmt.Errorf("invalid pcapng block length %d", length)
+	}
+	block := make([]byte, rest)
+	if _, err := io.ReadFull(r.r, block); err != nil {
+		return 0, nil, fmt.Errorf("truncated pcapng block: %w", err)
+	}
+	return kind, block[:len(block)-4], nil // Without the trailing length
+}
+
+// supportedLink reports whether frames of a link type can be decoded
+func supportedLink(link int) bool {
+	switch link {
+	case linkNull, linkEthernet, linkRaw, linkLoop, linkLinuxSLL, linkIPv4, linkIPv6, linkLinuxSL2:
+		return true
+	}
+	return false
+}
+
+// decodeFrame decodes the IP packet in a frame of a link type
+func decodeFrame(link int, data []byte) (Packet, bool) {
+	switch link {
+	case linkEthernet:
+		if len(data) < 14 {
+			return Packet{}, false
+		}
+		etherType, offset := binary.BigEndian.Uint16(data[12:]), 14
+		for etherType == 0x8100 || etherType == 0x88a8 { // VLAN tags
+			if len(data) < offset+4 {
+				return Packet{}, false
+			}
+			etherType = binary.BigEndian.Uint16(data[offset+2:])
+			offset += 4
+		}
+		if etherType != 0x0800 && etherType != 0x86dd {
+			return Packet{}, false
+		}
+		return decodeIP(data[offset:])
+	case linkNull, linkLoop:
+		if len(data) < 4 {
+			return Packet{}, false
+		}
+		return decodeIP(data[4:]) // The address family is checked by the IP version
+	case linkLinuxSLL:
+		if len(data) < 16 {
+			return Packet{}, false
+		}
+		return decodeIP(data[16:])
+	case linkLinuxSL2:
+		if len(data) < 20 {
+			return Packet{}, false
+		}
+		return decodeIP(data[20:])
+	default: // Raw IP
+		return decodeIP(data)
+	}
+}
+
+// decodeIP decodes an IPv4 or IPv6 packet and its TCP, UDP or ICMP header
+func decodeIP(data []byte) (Packet, bool) {
+	if len(data) < 1 {
+		return Packet{}, false
+	}
+	var packet Packet
+	var protocol byte
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return Packet{}, false
+		}
+		headerLength := int(data[0]&0x0f) * 4
+		if headerLength < 20 || len(data) < headerLength {
+			return Packet{}, false
+		}
+		if binary.BigEndian.Uint16(data[6:])&0x1fff != 0 {
+			return Packet{}, false // Non-first fragments have no transport header
+		}
+		protocol = data[9]
+		packet.Src = net.IP(data[12:16]).String()
+		packet.Dst = net.IP(data[16:20]).String()
+		data = data[headerLength:]
+	case 6:
+		if len(data) < 40 {
+			return Packet{}, false
+		}
+		protocol = data[6]
+		packet.Src = net.IP(data[8:24]).String()
+		packet.Dst = net.IP(data[24:40]).String()
+		data = data[40:]
+		for protocol == 0 || protocol == 43 || protocol == 44 || protocol == 60 {
+			if len(data) < 8 {
+				return Packet{}, false
+			}
+			length := (int(data[1]) + 1) * 8 // Hop-by-hop, routing and destination options
+			if protocol == 44 {
+				if binary.BigEndian.Uint16(data[2:])&0xfff8 != 0 {
+					return Packet{}, false // Non-first fragment
+				}
+				length = 8
+			}
+			if len(data) < length {
+				return Packet{}, false
+			}
+			protocol, data = data[0], data[length:]
+		}
+	default:
+		return Packet{}, false
+	}
+
+	switch protocol {
+	case 6:
+		if len(data) < 14 {
+			return Packet{}, false
+		}
+		packet.Protocol = "TCP"
+		packet.SYN = data[13]&0x02 != 0
+		packet.ACK = data[13]&0x10 != 0
+	case 17:
+		if len(data) < 4 {
+			return Packet{}, false
+		}
+		packet.Protocol = "UDP"
+	case 1, 58: // ICMP, ICMPv6
+		packet.Protocol = "ICMP"
+		return packet, true
+	default:
+		return Packet{}, false
+	}
+	packet.SrcPort = int(binary.BigEndian.Uint16(data[0:]))
+	packet.DstPort = int(binary.BigEndian.Uint16(data[2:]))
+	return packet, true
+}
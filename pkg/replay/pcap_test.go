@@ -0,0 +1,195 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// TCP flags
+const (
+	flagSYN = 0x02
+	flagACK = 0x10
+)
+
+func ipv4(src, dst string, protocol byte, payload []byte) []byte {
+	header := make([]byte, 20)
+	header[0] = 0x45
+	binary.BigEndian.PutUint16(header[2:], uint16(20+len(payload)))
+	header[8] = 64
+	header[9] = protocol
+	copy(header[12:], net.ParseIP(src).To4())
+	copy(header[16:], net.ParseIP(dst).To4())
+	return append(header, payload...)
+}
+
+func ipv6(src, dst string, protocol byte, payload []byte) []byte {
+	header := make([]byte, 40)
+	header[0] = 0x60
+	binary.BigEndian.PutUint16(header[4:], uint16(len(payload)))
+	header[6] = protocol
+	header[7] = 64
+	copy(header[8:], net.ParseIP(src))
+	copy(header[24:], net.ParseIP(dst))
+	return append(header, payload...)
+}
+
+func tcp(srcPort, dstPort int, flags byte) []byte {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(header[2:], uint16(dstPort))
+	header[12] = 5 << 4
+	header[13] = flags
+	return header
+}
+
+func udp(srcPort, dstPort int) []byte {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint16(header[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(header[2:], uint16(dstPort))
+	return header
+}
+
+func ethernet(etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14)
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+	return append(frame, payload...)
+}
+
+// pcapFile writes frames as a little-endian pcap capture
+func pcapFile(link uint32, frames ...[]byte) []byte {
+	var b bytes.Buffer
+	for _, v := range []any{uint32(0xa1b2c3d4), uint16(2), uint16(4), int32(0), uint32(0), uint32(65535), link} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	for i, frame := range frames {
+		for _, v := range []uint32{uint32(i), 0, uint32(len(frame)), uint32(len(frame))} {
+			binary.Write(&b, binary.LittleEndian, v)
+		}
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+// pcapngBlock writes a pcapng block, padding its body to 4 bytes
+func pcapngBlock(b *bytes.Buffer, order binary.ByteOrder, kind uint32, body []byte) {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	binary.Write(b, order, kind)
+	binary.Write(b, order, uint32(12+len(body)))
+	b.Write(body)
+	binary.Write(b, order, uint32(12+len(body)))
+}
+
+// pcapngFile writes frames as a pcapng capture with one interface
+func pcapngFile(order binary.ByteOrder, link uint16, frames ...[]byte) []byte {
+	var b bytes.Buffer
+	section := make([]byte, 16)
+	order.PutUint32(section[0:], pcapngByteOrderMagic)
+	order.PutUint16(section[4:], 1)
+	binary.LittleEndian.PutUint64(section[8:], ^uint64(0)) // Unknown section length
+	pcapngBlock(&b, order, pcapngSectionHeader, section)
+
+	iface := make([]byte, 8)
+	order.PutUint16(iface[0:], link)
+	pcapngBlock(&b, order, pcapngInterface, iface)
+	pcapngBlock(&b, order, 0x80000001, []byte("custom block")) // Skipped
+
+	for _, frame := range frames {
+		packet := make([]byte, 20)
+		order.PutUint32(packet[12:], uint32(len(frame)))
+		order.PutUint32(packet[16:], uint32(len(frame)))
+		pcapngBlock(&b, order, pcapngEnhancedPacket, append(packet, frame...))
+	}
+	return b.Bytes()
+}
+
+func readAll(t *testing.T, capture []byte) ([]Packet, int) {
+	t.Helper()
+	reader, err := NewReader(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	var packets []Packet
+	for {
+		packet, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return packets, reader.Skipped
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		packets = append(packets, packet)
+	}
+}
+
+func TestReader(t *testing.T) {
+	vlan := ethernet(0x8100, append([]byte{0, 10, 0x08, 0x00}, ipv4("10.0.1.5", "10.0.3.1", 17, udp(40000, 53))...))
+	frames := [][]byte{
+		ethernet(0x0800, ipv4("10.0.1.5", "10.0.2.1", 6, tcp(50000, 5432, flagSYN))),
+		ethernet(0x0806, make([]byte, 28)), // ARP
+		vlan,
+		ethernet(0x86dd, ipv6("2001:db8::1", "2001:db8::2", 58, []byte{128, 0, 0, 0})),
+		ethernet(0x0800, ipv4("10.0.1.5", "10.0.2.1", 6, tcp(50000, 5432, flagSYN)[:10])), // Truncated
+	}
+	want := []Packet{
+		{Src: "10.0.1.5", Dst: "10.0.2.1", Protocol: "TCP", SrcPort: 50000, DstPort: 5432, SYN: true},
+		{Src: "10.0.1.5", Dst: "10.0.3.1", Protocol: "UDP", SrcPort: 40000, DstPort: 53},
+		{Src: "2001:db8::1", Dst: "2001:db8::2", Protocol: "ICMP"},
+	}
+
+	for name, capture := range map[string][]byte{
+		"pcap":              pcapFile(linkEthernet, frames...),
+		"pcapng":            pcapngFile(binary.LittleEndian, linkEthernet, frames...),
+		"pcapng big-endian": pcapngFile(binary.BigEndian, linkEthernet, frames...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			packets, skipped := readAll(t, capture)
+			if !reflect.DeepEqual(packets, want) {
+				t.Errorf("Expected %+v, got %+v", want, packets)
+			}
+			if skipped != 2 {
+				t.Errorf("Expected 2 skipped packets, got %d", skipped)
+			}
+		})
+	}
+}
+
+func TestReaderLinkTypes(t *testing.T) {
+	packet := ipv4("10.0.1.5", "10.0.2.1", 6, tcp(50000, 443, flagSYN|flagACK))
+	want := Packet{Src: "10.0.1.5", Dst: "10.0.2.1", Protocol: "TCP", SrcPort: 50000, DstPort: 443, SYN: true, ACK: true}
+
+	for name, capture := range map[string][]byte{
+		"raw":        pcapFile(linkRaw, packet),
+		"null":       pcapFile(linkNull, append([]byte{2, 0, 0, 0}, packet...)),
+		"linux sll":  pcapFile(linkLinuxSLL, append(make([]byte, 16), packet...)),
+		"linux sll2": pcapFile(linkLinuxSL2, append(make([]byte, 20), packet...)),
+	} {
+		packets, _ := readAll(t, capture)
+		if len(packets) != 1 || packets[0] != want {
+			t.Errorf("%s: expected %+v, got %+v", name, want, packets)
+		}
+	}
+
+	if _, err := NewReader(bytes.NewReader(pcapFile(147, packet))); err == nil {
+		t.Error("Expected error for an unsupported link type")
+	}
+	if _, err := NewReader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n"))); err == nil {
+		t.Error("Expected error for a file that is not a capture")
+	}
+
+	// A capture cut short is an error, not the end of the capture
+	capture := pcapFile(linkRaw, packet)
+	reader, err := NewReader(bytes.NewReader(capture[:len(capture)-5]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); err == nil || errors.Is(err, io.EOF) {
+		t.Errorf("Expected error for a truncated record, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package replay
+
+import (
+	"errors"
+	"io"
+	"sort"
+
+	"ztap/pkg/policy"
+)
+
+// Flow is the traffic of a capture from one source to one destination port,
+// in the direction its connections were opened. Replies are counted with
+// the connection they belong to, as enforcement keeps state.
+type Flow struct {
+	Src         policy.Endpoint `json:"src"`
+	Dst         policy.Endpoint `json:"dst"`
+	Protocol    string          `json:"protocol"`
+	Port        int             `json:"port"` // 0 for ICMP
+	Connections int             `json:"connections"`
+	Packets     int             `json:"packets"` // Both directions
+	Allowed     bool            `json:"allowed"`
+	Policy      string          `json:"policy,omitempty"` // Policy allowing the flow
+	Reason      string          `json:"reason"`
+}
+
+// Report is the outcome of a replayed capture
+type Report struct {
+	Flows          []Flow `json:"flows"` // Blocked flows first, then by packets
+	AllowedPackets int    `json:"allowed_packets"`
+	BlockedPackets int    `json:"blocked_packets"`
+	Skipped        int    `json:"skipped_packets"` // Not TCP, UDP or ICMP
+}
+
+// connection identifies the packets of a connection in one direction
+type connection struct {
+	src, dst         string
+	protocol         string
+	srcPort, dstPort int
+}
+
+// reverse is the connection of the replies
+func (c connection) reverse() connection {
+	return connection{c.dst, c.src, c.protocol, c.dstPort, c.srcPort}
+}
+
+// flowKey identifies a Flow
+type flowKey struct {
+	src, dst string
+	protocol string
+	port     int
+}
+
+// Replay reads every packet of capture and evaluates the flows they form
+// against policies with policy.Simulate. labels maps workload IPs to their
+// labels, so sources are matched against podSelectors and destinations
+// against both podSelectors and ipBlocks.
+//
+// A connection is opened by its first packet, except that a TCP SYN-ACK
+// comes from the side that accepted it, so captures starting mid-handshake
+// are still read the right way round. ICMP flows have port 0, as the eBPF
+// backend sees them.
+func Replay(policies []policy.NetworkPolicy, capture *Reader, labels map[string]map[string]string) (*Report, error) {
+	connections := make(map[connection]flowKey)
+	flows := make(map[flowKey]*Flow)
+	var order []flowKey
+
+	for {
+		packet, err := capture.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		conn := connection{packet.Src, packet.Dst, packet.Protocol, packet.SrcPort, packet.DstPort}
+		key, ok := connections[conn]
+		if !ok {
+			key, ok = connections[conn.reverse()]
+		}
+		if !ok {
+			if packet.SYN && packet.ACK {
+				conn = conn.reverse()
+			}
+			key = flowKey{conn.src, conn.dst, conn.protocol, conn.dstPort}
+			connections[conn] = key
+			if _, ok := flows[key]; !ok {
+				flows[key] = &Flow{
+					Src:      policy.Endpoint{IP: key.src, Labels: labels[key.src]},
+					Dst:      policy.Endpoint{IP: key.dst, Labels: labels[key.dst]},
+					Protocol: key.protocol,
+					Port:     key.port,
+				}
+				order = append(order, key)
+			}
+			flows[key].Connections++
+		}
+		flows[key].Packets++
+	}
+
+	report := &Report{Skipped: capture.Skipped}
+	for _, key := range order {
+		flow := flows[key]
+		verdict := policy.Simulate(policies, policy.Flow{From: flow.Src, To: flow.Dst, Protocol: flow.Protocol, Port: flow.Port})
+		flow.Allowed, flow.Policy, flow.Reason = verdict.Allowed, verdict.Policy, verdict.Reason
+		if flow.Allowed {
+			report.AllowedPackets += flow.Packets
+		} else {
+			report.BlockedPackets += flow.Packets
+		}
+		report.Flows = append(report.Flows, *flow)
+	}
+	sort.SliceStable(report.Flows, func(i, j int) bool {
+		a, b := report.Flows[i], report.Flows[j]
+		if a.Allowed != b.Allowed {
+			return !a.Allowed
+		}
+		return a.Packets > b.Packets
+	})
+	return report, nil
+}
+
+// Blocked returns the number of flows that would be blocked
+func (r *Report) Blocked() int {
+	blocked := 0
+	for _, flow := range r.Flows {
+		if !flow.Allowed {
+			blocked++
+		}
+	}
+	return blocked
+}
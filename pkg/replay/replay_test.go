@@ -0,0 +1,96 @@
+package replay
+
+import (
+	"bytes"
+	"testing"
+
+	"ztap/pkg/policy"
+)
+
+const replayTestPolicy = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-egress
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+    - to:
+        ipBlock:
+          cidr: 10.0.3.0/24
+      ports:
+        - protocol: UDP
+          port: 53
+`
+
+func TestReplay(t *testing.T) {
+	policies, err := policy.Parse([]byte(replayTestPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]map[string]string{
+		"10.0.1.5": {"app": "web"},
+		"10.0.2.1": {"app": "db"},
+	}
+
+	capture := pcapFile(linkRaw,
+		// Two connections to the database, one captured from the SYN-ACK
+		ipv4("10.0.1.5", "10.0.2.1", 6, tcp(50000, 5432, flagSYN)),
+		ipv4("10.0.2.1", "10.0.1.5", 6, tcp(5432, 50000, flagSYN|flagACK)),
+		ipv4("10.0.1.5", "10.0.2.1", 6, tcp(50000, 5432, flagACK)),
+		ipv4("10.0.2.1", "10.0.1.5", 6, tcp(5432, 50001, flagSYN|flagACK)),
+		ipv4("10.0.1.5", "10.0.2.1", 6, tcp(50001, 5432, flagACK)),
+		// DNS is allowed, the internet is not
+		ipv4("10.0.1.5", "10.0.3.1", 17, udp(40000, 53)),
+		ipv4("10.0.3.1", "10.0.1.5", 17, udp(53, 40000)),
+		ipv4("10.0.1.5", "203.0.113.10", 6, tcp(50002, 443, flagSYN)),
+		// The database is selected by no policy
+		ipv4("10.0.2.1", "203.0.113.10", 1, []byte{8, 0, 0, 0}),
+		[]byte{0x45}, // Skipped
+	)
+	reader, err := NewReader(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := Replay(policies, reader, labels)
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+
+	type result struct {
+		src, dst    string
+		protocol    string
+		port        int
+		connections int
+		packets     int
+		allowed     bool
+		policy      string
+	}
+	want := []result{
+		{"10.0.1.5", "203.0.113.10", "TCP", 443, 1, 1, false, ""},
+		{"10.0.1.5", "10.0.2.1", "TCP", 5432, 2, 5, true, "web-egress"},
+		{"10.0.1.5", "10.0.3.1", "UDP", 53, 1, 2, true, "web-egress"},
+		{"10.0.2.1", "203.0.113.10", "ICMP", 0, 1, 1, true, ""},
+	}
+	if len(report.Flows) != len(want) {
+		t.Fatalf("Expected %d flows, got %+v", len(want), report.Flows)
+	}
+	for i, flow := range report.Flows {
+		got := result{flow.Src.IP, flow.Dst.IP, flow.Protocol, flow.Port, flow.Connections, flow.Packets, flow.Allowed, flow.Policy}
+		if got != want[i] {
+			t.Errorf("Flow %d: expected %+v, got %+v (%s)", i, want[i], got, flow.Reason)
+		}
+	}
+	if report.AllowedPackets != 8 || report.BlockedPackets != 1 || report.Skipped != 1 || report.Blocked() != 1 {
+		t.Errorf("Unexpected totals: %+v, %d blocked flows", report, report.Blocked())
+	}
+}
@@ -0,0 +1,361 @@
+// Package retention prunes the state ZTAP accumulates on a host: the
+// enforcement log (flow history and audit records), the event journal and
+// the hourly stats aggregates. A Janitor applies the age and size limits of
+// the retention section of config.yaml on an interval.
+package retention
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ztap/pkg/configfile"
+	"ztap/pkg/metrics"
+	"ztap/pkg/stats"
+)
+
+// DefaultInterval is how often the janitor runs when no interval is set
+const DefaultInterval = time.Hour
+
+// Limit bounds how much of a store is kept. Zero fields keep everything.
+type Limit struct {
+	MaxAge  time.Duration
+	MaxSize int64 // Bytes
+}
+
+// limitYAML is a Limit as written in config.yaml, e.g. max_age: 90d and
+// max_size: 500MB
+type limitYAML struct {
+	MaxAge  string `yaml:"max_age"`
+	MaxSize string `yaml:"max_size"`
+}
+
+// UnmarshalYAML parses max_age as a period (24h, 90d, 2w) and max_size as
+// bytes with an optional KB, MB or GB suffix
+func (l *Limit) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw limitYAML
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	if raw.MaxAge != "" {
+		age, err := stats.ParseSince(raw.MaxAge)
+		if err != nil {
+			return fmt.Errorf("max_age: %w", err)
+		}
+		l.MaxAge = age
+	}
+	if raw.MaxSize != "" {
+		size, err := ParseSize(raw.MaxSize)
+		if err != nil {
+			return fmt.Errorf("max_size: %w", err)
+		}
+		l.MaxSize = size
+	}
+	return nil
+}
+
+// enabled reports whether the limit prunes anything
+func (l Limit) enabled() bool {
+	return l.MaxAge > 0 || l.MaxSize > 0
+}
+
+// ParseSize parses a size in bytes with an optional KB, MB or GB suffix
+// (powers of 1024), e.g. 500MB
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"B", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// Config is the retention section of config.yaml
+type Config struct {
+	Interval       time.Duration `yaml:"interval"`
+	EnforcementLog Limit         `yaml:"enforcement_log"`
+	EventJournal   Limit         `yaml:"event_journal"`
+	Stats          Limit         `yaml:"stats"`
+}
+
+// Enabled reports whether any store is pruned
+func (c Config) Enabled() bool {
+	return c.EnforcementLog.enabled() || c.EventJournal.enabled() || c.Stats.enabled()
+}
+
+// Validate checks the configuration
+func (c Config) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("retention.interval must not be negative")
+	}
+	return nil
+}
+
+// LoadConfig reads the retention section of file. A missing file or section
+// keeps everything.
+func LoadConfig(file *configfile.File) (Config, error) {
+	var c Config
+	if err := file.Section("retention", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Paths locates the stores on the host
+type Paths struct {
+	EnforcementLog string
+	EventJournal   string // Rotated to EventJournal + ".1"
+	Stats          string
+}
+
+// Stores, as named in results and the ztap_retention_* metrics
+const (
+	StoreEnforcementLog = "enforcement_log"
+	StoreEventJournal   = "event_journal"
+	StoreStats          = "stats"
+)
+
+// Result reports what one sweep removed from a store
+type Result struct {
+	Store     string
+	Removed   int   // Log entries, journal records or stats buckets
+	Reclaimed int64 // Bytes
+	Err       error
+}
+
+// Janitor prunes the stores by their limits
+type Janitor struct {
+	config Config
+	paths  Paths
+	now    func() time.Time
+}
+
+// NewJanitor creates a janitor for the stores at paths
+func NewJanitor(config Config, paths Paths) *Janitor {
+	return &Janitor{config: config, paths: paths, now: time.Now}
+}
+
+// Run sweeps right away and then every interval (DefaultInterval if zero)
+// until ctx is cancelled, logging failures and exporting what was reclaimed
+// as ztap_retention_* metrics
+func (j *Janitor) Run(ctx context.Context) {
+	interval := j.config.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, result := range j.Sweep() {
+			if result.Err != nil {
+				log.Printf("Warning: Failed to prune %s: %v", result.Store, result.Err)
+				continue
+			}
+			metrics.GetCollector().ObserveRetention(result.Store, result.Removed, result.Reclaimed)
+			if result.Removed > 0 {
+				log.Printf("Pruned %d record(s) from %s, reclaiming %d bytes", result.Removed, result.Store, result.Reclaimed)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Sweep prunes every store with a limit once
+func (j *Janitor) Sweep() []Result {
+	now := j.now()
+	var results []Result
+	if j.config.EnforcementLog.enabled() {
+		result := Result{Store: StoreEnforcementLog}
+		result.Removed, result.Reclaimed, result.Err = pruneLines([]string{j.paths.EnforcementLog}, cutoff(now, j.config.EnforcementLog), j.config.EnforcementLog.MaxSize)
+		results = append(results, result)
+	}
+	if j.config.EventJournal.enabled() {
+		result := Result{Store: StoreEventJournal}
+		result.Removed, result.Reclaimed, result.Err = pruneLines([]string{j.paths.EventJournal + ".1", j.paths.EventJournal}, cutoff(now, j.config.EventJournal), j.config.EventJournal.MaxSize)
+		results = append(results, result)
+	}
+	if j.config.Stats.enabled() {
+		result := Result{Store: StoreStats}
+		result.Removed, result.Reclaimed, result.Err = stats.Prune(j.paths.Stats, cutoff(now, j.config.Stats), j.config.Stats.MaxSize)
+		results = append(results, result)
+	}
+	return results
+}
+
+// cutoff returns the time before which records are too old, zero if age
+// is unlimited
+func cutoff(now time.Time, limit Limit) time.Time {
+	if limit.MaxAge <= 0 {
+		return time.Time{}
+	}
+	return now.Add(-limit.MaxAge)
+}
+
+// timestamped is the field every enforcement log entry and journal record has
+type timestamped struct {
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// file is a JSON lines file read for pruning
+type file struct {
+	path  string
+	lines [][]byte
+	size  int64 // Bytes read, so appends made meanwhile can be kept
+	drop  int   // Leading lines to remove
+}
+
+// pruneLines removes the records older than cutoff from JSON lines files,
+// given oldest first, and then the oldest records while the files together
+// are larger than maxSize. Records are appended in time order, so pruning
+// removes a prefix of the files. Lines that cannot be parsed count as old.
+func pruneLines(paths []string, cutoff time.Time, maxSize int64) (removed int, reclaimed int64, err error) {
+	var files []*file
+	var total int64
+	for _, path := range paths {
+		f, err := readLines(path)
+		if err != nil {
+			return 0, 0, err
+		}
+		if f == nil {
+			continue
+		}
+		files = append(files, f)
+		total += f.size
+	}
+
+	// Age, then size: both drop from the oldest end
+	aged := true
+	for _, f := range files {
+		for aged && f.drop < len(f.lines) {
+			var record timestamped
+			if json.Unmarshal(f.lines[f.drop], &record) == nil && !record.Timestamp.Before(cutoff) {
+				aged = false
+				break
+			}
+			total -= int64(len(f.lines[f.drop]))
+			f.drop++
+		}
+	}
+	for _, f := range files {
+		for maxSize > 0 && total > maxSize && f.drop < len(f.lines) {
+			total -= int64(len(f.lines[f.drop]))
+			f.drop++
+		}
+	}
+
+	for _, f := range files {
+		if f.drop == 0 {
+			continue
+		}
+		n, err := f.rewrite()
+		if err != nil {
+			return removed, reclaimed, err
+		}
+		removed += f.drop
+		reclaimed += n
+	}
+	return removed, reclaimed, nil
+}
+
+// readLines reads the complete lines of path, or returns nil if it does not
+// exist
+func readLines(path string) (*file, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// A partial last line is still being written; keep it for the next sweep
+	if i := bytes.LastIndexByte(data, '\n'); i+1 < len(data) {
+		data = data[:i+1]
+	}
+
+	f := &file{path: path, size: int64(len(data))}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(scanLines)
+	for scanner.Scan() {
+		f.lines = append(f.lines, scanner.Bytes())
+	}
+	return f, scanner.Err()
+}
+
+// scanLines splits lines keeping their newline, so sizes add up
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// rewrite replaces the file with its kept lines and anything appended since
+// it was read, and returns the bytes reclaimed. The file is replaced by
+// rename, which followers detect (see events.Journal.Follow).
+func (f *file) rewrite() (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	var kept int64
+	for _, line := range f.lines[f.drop:] {
+		n, err := tmp.Write(line)
+		kept += int64(n)
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+
+	current, err := os.Open(f.path)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	defer current.Close()
+	if _, err := current.Seek(f.size, io.SeekStart); err == nil {
+		io.Copy(tmp, current)
+	}
+	if info, err := current.Stat(); err == nil {
+		os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return 0, err
+	}
+	return f.size - kept, nil
+}
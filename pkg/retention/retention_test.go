@@ -0,0 +1,161 @@
+package retention
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ztap/pkg/configfile"
+)
+
+// writeEntries writes one JSON line per timestamp
+func writeEntries(t *testing.T, path string, times ...time.Time) {
+	t.Helper()
+	var b strings.Builder
+	for i, ts := range times {
+		fmt.Fprintf(&b, "{\"timestamp\":%q,\"n\":%d}\n", ts.Format(time.RFC3339), i)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func lines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestSweepByAge(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	paths := Paths{
+		EnforcementLog: filepath.Join(dir, "ztap.log"),
+		EventJournal:   filepath.Join(dir, "events.jsonl"),
+		Stats:          filepath.Join(dir, "stats.json"),
+	}
+	writeEntries(t, paths.EnforcementLog, now.Add(-72*time.Hour), now.Add(-48*time.Hour), now.Add(-time.Hour))
+	// The rotated journal holds only old records, the live one a mix
+	writeEntries(t, paths.EventJournal+".1", now.Add(-96*time.Hour), now.Add(-80*time.Hour))
+	writeEntries(t, paths.EventJournal, now.Add(-50*time.Hour), now.Add(-2*time.Hour))
+
+	j := NewJanitor(Config{
+		EnforcementLog: Limit{MaxAge: 24 * time.Hour},
+		EventJournal:   Limit{MaxAge: 24 * time.Hour},
+	}, paths)
+	j.now = func() time.Time { return now }
+
+	results := j.Sweep()
+	if len(results) != 2 {
+		t.Fatalf("Expected results for the log and journal, got %+v", results)
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("Sweep of %s failed: %v", result.Store, result.Err)
+		}
+	}
+	if results[0].Removed != 2 || results[0].Reclaimed <= 0 {
+		t.Errorf("Expected 2 log entries pruned, got %+v", results[0])
+	}
+	if results[1].Removed != 3 {
+		t.Errorf("Expected 3 journal records pruned, got %+v", results[1])
+	}
+
+	if got := lines(t, paths.EnforcementLog); len(got) != 1 || !strings.Contains(got[0], `"n":2`) {
+		t.Errorf("Expected the newest log entry kept, got %v", got)
+	}
+	if got := lines(t, paths.EventJournal+".1"); len(got) != 1 || got[0] != "" {
+		t.Errorf("Expected the rotated journal emptied, got %v", got)
+	}
+	if got := lines(t, paths.EventJournal); len(got) != 1 || !strings.Contains(got[0], `"n":1`) {
+		t.Errorf("Expected the newest journal record kept, got %v", got)
+	}
+}
+
+func TestSweepBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ztap.log")
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	writeEntries(t, path, now, now, now, now)
+	info, _ := os.Stat(path)
+
+	j := NewJanitor(Config{EnforcementLog: Limit{MaxSize: info.Size() / 2}}, Paths{EnforcementLog: path})
+	results := j.Sweep()
+	if len(results) != 1 || results[0].Err != nil || results[0].Removed != 2 || results[0].Reclaimed != info.Size()/2 {
+		t.Fatalf("Expected the 2 oldest entries pruned, got %+v", results)
+	}
+	if got := lines(t, path); len(got) != 2 || !strings.Contains(got[0], `"n":2`) {
+		t.Errorf("Expected the 2 newest entries kept, got %v", got)
+	}
+}
+
+func TestPruneKeepsPartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ztap.log")
+	now := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	writeEntries(t, path, now.Add(-48*time.Hour), now)
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(`{"timestamp":"2025-`)
+	f.Close()
+
+	if removed, _, err := pruneLines([]string{path}, now.Add(-24*time.Hour), 0); err != nil || removed != 1 {
+		t.Fatalf("Expected 1 entry pruned, got %d, %v", removed, err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.HasSuffix(string(data), "\n"+`{"timestamp":"2025-`) {
+		t.Errorf("Expected the entry being written kept, got %q", data)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(`
+retention:
+  interval: 30m
+  enforcement_log:
+    max_age: 90d
+    max_size: 500MB
+  stats:
+    max_age: 2w
+`), 0600)
+	config, err := configfile.Read(path, LoadConfig)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Interval != 30*time.Minute || config.EnforcementLog.MaxAge != 90*24*time.Hour ||
+		config.EnforcementLog.MaxSize != 500<<20 || config.Stats.MaxAge != 14*24*time.Hour || config.EventJournal.enabled() {
+		t.Errorf("Unexpected config %+v", config)
+	}
+
+	for _, invalid := range []string{
+		"retention:\n  enforcement_log:\n    max_size: lots\n",
+		"retention:\n  stats:\n    max_age: forever\n",
+		"retention:\n  interval: -1h\n",
+	} {
+		os.WriteFile(path, []byte(invalid), 0600)
+		if _, err := configfile.Read(path, LoadConfig); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+
+	if config, err := configfile.Read(filepath.Join(t.TempDir(), "missing.yaml"), LoadConfig); err != nil || config.Enabled() {
+		t.Errorf("Expected no retention without a config file, got %+v, %v", config, err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for input, want := range map[string]int64{"1024": 1024, "10KB": 10 << 10, "1 GB": 1 << 30, "5mb": 5 << 20, "20B": 20} {
+		if got, err := ParseSize(input); err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "MB", "-5MB", "1TB"} {
+		if _, err := ParseSize(input); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package scim holds the configuration and resources of the SCIM 2.0
+// provisioning endpoints of the API server (RFC 7643 and 7644), through
+// which an identity provider creates, updates and deactivates users and
+// maps its groups to roles.
+package scim
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/configfile"
+)
+
+// Schema URNs
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// ContentType is the media type of SCIM requests and responses
+const ContentType = "application/scim+json"
+
+// Config is the scim section of config.yaml:
+//
+//	scim:
+//	  tokenFile: /etc/ztap/scim-token # bearer token of the identity provider
+//	  tenant: acme                    # tenant of provisioned users
+//	  defaultRole: viewer             # role of users in no mapped group
+//	  groups:                         # identity provider group: role
+//	    ztap-admins: tenant-admin
+//	    ztap-operators: operator
+type Config struct {
+	TokenFile   string               `yaml:"tokenFile"`
+	Tenant      string               `yaml:"tenant"`
+	DefaultRole auth.Role            `yaml:"defaultRole"`
+	Groups      map[string]auth.Role `yaml:"groups"`
+}
+
+// Enabled reports whether the SCIM endpoints are served
+func (c Config) Enabled() bool {
+	return c.TokenFile != ""
+}
+
+// Validate checks the tenant and roles, which must be ones users of the
+// tenant can hold
+func (c Config) Validate() error {
+	if err := auth.ValidateTenant(c.Tenant); err != nil {
+		return fmt.Errorf("scim.tenant: %w", err)
+	}
+	check := func(field string, role auth.Role) error {
+		if err := c.Provisionable(role); err != nil {
+			return fmt.Errorf("%s: %w", field, err)
+		}
+		return nil
+	}
+	if c.DefaultRole != "" {
+		if err := check("scim.defaultRole", c.DefaultRole); err != nil {
+			return err
+		}
+	}
+	for group, role := range c.Groups {
+		if err := check("scim.groups."+group, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadConfig reads the scim section of file. A missing file or section
+// disables SCIM.
+func LoadConfig(file *configfile.File) (Config, error) {
+	var c Config
+	if err := file.Section("scim", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// LoadToken reads the bearer token from TokenFile, ignoring surrounding
+// whitespace
+func (c Config) LoadToken() (string, error) {
+	data, err := os.ReadFile(c.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read scim.tokenFile: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if len(token) < 16 {
+		return "", errors.New("scim.tokenFile must hold a token of at least 16 characters")
+	}
+	return token, nil
+}
+
+// TokenMatches compares a presented bearer token with the configured one in
+// constant time
+func TokenMatches(presented, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
+
+// Provisionable checks that users of the tenant can be provisioned with
+// role, whether it is mapped from a group or set by the provider
+func (c Config) Provisionable(role auth.Role) error {
+	switch role {
+	case auth.RoleOperator, auth.RoleViewer:
+		return nil
+	case auth.RoleAdmin:
+		if c.Tenant == "" {
+			return nil
+		}
+	case auth.RoleTenantAdmin:
+		if c.Tenant != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("role %q cannot be provisioned (tenant %q)", role, c.Tenant)
+}
+
+// rolePrivilege orders roles; a user in several groups gets the most
+// privileged of their roles
+var rolePrivilege = map[auth.Role]int{
+	auth.RoleViewer:      1,
+	auth.RoleOperator:    2,
+	auth.RoleTenantAdmin: 3,
+	auth.RoleAdmin:       4,
+}
+
+// Role returns the role of a user in groups: the most privileged role the
+// groups map to, else fallback (a role the provider set on the user), else
+// DefaultRole, else viewer
+func (c Config) Role(groups []string, fallback auth.Role) auth.Role {
+	var role auth.Role
+	for _, group := range groups {
+		if mapped, ok := c.Groups[group]; ok && rolePrivilege[mapped] > rolePrivilege[role] {
+			role = mapped
+		}
+	}
+	switch {
+	case role != "":
+		return role
+	case fallback != "":
+		return fallback
+	case c.DefaultRole != "":
+		return c.DefaultRole
+	}
+	return auth.RoleViewer
+}
+
+// GroupNames returns the sorted names of the mapped groups
+func (c Config) GroupNames() []string {
+	names := make([]string, 0, len(c.Groups))
+	for name := range c.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Meta describes a resource
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	Location     string     `json:"location,omitempty"`
+}
+
+// Value is an element of a multi-valued attribute, such as a group of a
+// user or a member of a group
+type Value struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// User is the SCIM User resource. Its id is the ZTAP username.
+type User struct {
+	Schemas    []string `json:"schemas"`
+	ID         string   `json:"id,omitempty"`
+	ExternalID string   `json:"externalId,omitempty"`
+	UserName   string   `json:"userName"`
+	Active     *bool    `json:"active,omitempty"`
+	Password   string   `json:"password,omitempty"` // Write-only
+	Groups     []Value  `json:"groups,omitempty"`   // Read-only; set through Groups
+	Roles      []Value  `json:"roles,omitempty"`
+	Meta       *Meta    `json:"meta,omitempty"`
+}
+
+// Group is the SCIM Group resource of a mapped identity provider group. Its
+// id is the group's name.
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Value  `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// ListResponse is a page of resources
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []any    `json:"Resources"`
+}
+
+// PatchOp is the body of a PATCH request
+type PatchOp struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is one operation of a PatchOp; Op is add, remove or
+// replace, in any case
+type PatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+// Error is a SCIM error response
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// Filter is a parsed filter of the form 'attribute eq "value"', the only
+// form identity providers need to look up resources
+type Filter struct {
+	Attribute string
+	Value     string
+}
+
+// ParseFilter parses a filter; an empty filter matches everything
+func ParseFilter(filter string) (*Filter, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		return nil, nil
+	}
+	attribute, rest, ok := strings.Cut(filter, " ")
+	if !ok {
+		return nil, fmt.Errorf("unsupported filter %q", filter)
+	}
+	op, value, ok := strings.Cut(strings.TrimSpace(rest), " ")
+	value = strings.TrimSpace(value)
+	if !ok || !strings.EqualFold(op, "eq") || len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return nil, fmt.Errorf("unsupported filter %q; only 'attribute eq \"value\"' is supported", filter)
+	}
+	return &Filter{Attribute: attribute, Value: value[1 : len(value)-1]}, nil
+}
+
+// Matches reports whether the attribute named by the filter, looked up in
+// attributes (case-insensitively, as SCIM attribute names are), equals its
+// value
+func (f *Filter) Matches(attributes map[string]string) bool {
+	if f == nil {
+		return true
+	}
+	for name, value := range attributes {
+		if strings.EqualFold(name, f.Attribute) {
+			return value == f.Value
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package scim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/configfile"
+)
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter(`userName eq "alice@example.com"`)
+	if err != nil || f.Attribute != "userName" || f.Value != "alice@example.com" {
+		t.Fatalf("Unexpected filter %+v, %v", f, err)
+	}
+	if !f.Matches(map[string]string{"username": "alice@example.com"}) || f.Matches(map[string]string{"userName": "bob"}) {
+		t.Error("Expected a case-insensitive attribute and exact value match")
+	}
+	if f, err := ParseFilter(""); err != nil || !f.Matches(nil) {
+		t.Errorf("Expected an empty filter to match everything, got %v", err)
+	}
+	for _, bad := range []string{`userName sw "a"`, `userName eq alice`, `userName`} {
+		if _, err := ParseFilter(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestRole(t *testing.T) {
+	config := Config{
+		Tenant:      "acme",
+		DefaultRole: auth.RoleViewer,
+		Groups:      map[string]auth.Role{"admins": auth.RoleTenantAdmin, "ops": auth.RoleOperator},
+	}
+	tests := []struct {
+		groups   []string
+		fallback auth.Role
+		want     auth.Role
+	}{
+		{nil, "", auth.RoleViewer},
+		{nil, auth.RoleOperator, auth.RoleOperator},
+		{[]string{"ops"}, auth.RoleViewer, auth.RoleOperator},
+		{[]string{"ops", "admins", "unmapped"}, "", auth.RoleTenantAdmin},
+	}
+	for _, tt := range tests {
+		if got := config.Role(tt.groups, tt.fallback); got != tt.want {
+			t.Errorf("Role(%v, %q) = %s, want %s", tt.groups, tt.fallback, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if config, err := configfile.Read(path, LoadConfig); err != nil || config.Enabled() {
+		t.Fatalf("Expected SCIM disabled without a config file, got %+v, %v", config, err)
+	}
+
+	os.WriteFile(path, []byte("scim:\n  tokenFile: token\n  tenant: acme\n  groups:\n    admins: admin\n"), 0600)
+	if _, err := configfile.Read(path, LoadConfig); err == nil {
+		t.Error("Expected the admin role refused for a tenant")
+	}
+
+	os.WriteFile(path, []byte("scim:\n  tokenFile: token\n  tenant: acme\n  groups:\n    admins: tenant-admin\n"), 0600)
+	config, err := configfile.Read(path, LoadConfig)
+	if err != nil || !config.Enabled() || config.Groups["admins"] != auth.RoleTenantAdmin {
+		t.Fatalf("Unexpected config %+v, %v", config, err)
+	}
+
+	config.TokenFile = filepath.Join(t.TempDir(), "token")
+	os.WriteFile(config.TokenFile, []byte("short\n"), 0600)
+	if _, err := config.LoadToken(); err == nil {
+		t.Error("Expected a short token refused")
+	}
+	os.WriteFile(config.TokenFile, []byte("0123456789abcdef0123\n"), 0600)
+	if token, err := config.LoadToken(); err != nil || !TokenMatches("0123456789abcdef0123", token) {
+		t.Errorf("Unexpected token %q, %v", token, err)
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// selinuxModule is the SELinux policy module name (no dashes allowed)
+const selinuxModule = "ztap"
+
+// AppArmorProfile renders an AppArmor profile limiting the agent to its
+// binary, policy file, state directory, eBPF object and the kernel
+// interfaces enforcement uses
+func AppArmorProfile(c Config) string {
+	var b strings.Builder
+	b.WriteString("# Generated by 'ztap install-service --hardened'\n")
+	b.WriteString("abi <abi/3.0>,\n\n")
+	b.WriteString("#include <tunables/global>\n\n")
+	fmt.Fprintf(&b, "profile %s %s flags=(enforce) {\n", Name, c.Binary)
+	b.WriteString("  #include <abstractions/base>\n")
+	b.WriteString("  #include <abstractions/nameservice>\n")
+	b.WriteString("  #include <abstractions/ssl_certs>\n\n")
+
+	for _, capability := range c.Capabilities {
+		fmt.Fprintf(&b, "  capability %s,\n", strings.ToLower(strings.TrimPrefix(capability, "CAP_")))
+	}
+	if c.User == "" {
+		// Root still needs its capabilities granted by the profile
+		for _, capability := range []string{"bpf", "perfmon", "net_admin", "sys_admin", "sys_resource"} {
+			fmt.Fprintf(&b, "  capability %s,\n", capability)
+		}
+	}
+	b.WriteString("\n")
+
+	b.WriteString("  network inet stream,\n")
+	b.WriteString("  network inet6 stream,\n")
+	b.WriteString("  network inet dgram,\n")
+	b.WriteString("  network inet6 dgram,\n")
+	b.WriteString("  network netlink raw,\n\n")
+
+	fmt.Fprintf(&b, "  %s mr,\n", c.Binary)
+	fmt.Fprintf(&b, "  %s r,\n", c.PolicyFile)
+	b.WriteString("  /etc/ztap/** r,\n")
+	fmt.Fprintf(&b, "  %s/ r,\n", c.StateDir)
+	fmt.Fprintf(&b, "  %s/** rwk,\n", c.StateDir)
+	b.WriteString("  /usr/local/share/ztap/bpf/*.o r,\n")
+	b.WriteString("  /sys/fs/cgroup/ r,\n")
+	b.WriteString("  /sys/fs/cgroup/** r,\n")
+	b.WriteString("  /sys/fs/bpf/ r,\n")
+	b.WriteString("  /sys/fs/bpf/** rw,\n")
+	b.WriteString("  /sys/kernel/mm/transparent_hugepage/hpage_pmd_size r,\n")
+	b.WriteString("  @{PROC}/@{pid}/status r,\n")
+	b.WriteString("  @{PROC}/sys/net/core/somaxconn r,\n")
+	b.WriteString("  @{PROC}/sys/kernel/unprivileged_bpf_disabled r,\n\n")
+
+	b.WriteString("  # Nothing else may be executed or written\n")
+	b.WriteString("  deny /bin/** x,\n")
+	b.WriteString("  deny /usr/bin/** x,\n")
+	b.WriteString("  deny @{PROC}/sys/** w,\n")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// SELinuxModule renders the type enforcement (.te) source of a policy module
+// confining the agent in the ztap_t domain. Build and load it with
+// 'make -f /usr/share/selinux/devel/Makefile ztap.pp && semodule -i ztap.pp'.
+func SELinuxModule(c Config) string {
+	var b strings.Builder
+	b.WriteString("# Generated by 'ztap install-service --hardened'\n")
+	fmt.Fprintf(&b, "policy_module(%s, 1.0.0)\n\n", selinuxModule)
+
+	b.WriteString("type ztap_t;\n")
+	b.WriteString("type ztap_exec_t;\n")
+	b.WriteString("init_daemon_domain(ztap_t, ztap_exec_t)\n\n")
+	b.WriteString("type ztap_etc_t;\n")
+	b.WriteString("files_config_file(ztap_etc_t)\n")
+	b.WriteString("type ztap_var_lib_t;\n")
+	b.WriteString("files_type(ztap_var_lib_t)\n\n")
+
+	b.WriteString("allow ztap_t self:capability { net_admin sys_admin sys_resource };\n")
+	b.WriteString("allow ztap_t self:capability2 { bpf perfmon };\n")
+	b.WriteString("allow ztap_t self:bpf { map_create map_read map_write prog_load prog_run };\n")
+	b.WriteString("allow ztap_t self:perf_event { open read write kernel cpu };\n")
+	b.WriteString("allow ztap_t self:netlink_route_socket create_netlink_socket_perms;\n")
+	b.WriteString("allow ztap_t self:tcp_socket create_stream_socket_perms;\n")
+	b.WriteString("allow ztap_t self:udp_socket create_socket_perms;\n\n")
+
+	b.WriteString("read_files_pattern(ztap_t, ztap_etc_t, ztap_etc_t)\n")
+	b.WriteString("manage_dirs_pattern(ztap_t, ztap_var_lib_t, ztap_var_lib_t)\n")
+	b.WriteString("manage_files_pattern(ztap_t, ztap_var_lib_t, ztap_var_lib_t)\n")
+	b.WriteString("files_var_lib_filetrans(ztap_t, ztap_var_lib_t, dir)\n\n")
+
+	b.WriteString("corenet_tcp_bind_generic_node(ztap_t)\n")
+	b.WriteString("corenet_tcp_bind_all_unreserved_ports(ztap_t)\n")
+	b.WriteString("corenet_tcp_connect_http_port(ztap_t)\n")
+	b.WriteString("corenet_tcp_connect_all_unreserved_ports(ztap_t)\n")
+	b.WriteString("sysnet_dns_name_resolve(ztap_t)\n")
+	b.WriteString("miscfiles_read_generic_certs(ztap_t)\n\n")
+
+	b.WriteString("fs_read_cgroup_files(ztap_t)\n")
+	b.WriteString("fs_list_cgroup_dirs(ztap_t)\n")
+	b.WriteString("fs_manage_bpf_files(ztap_t)\n")
+	b.WriteString("kernel_read_system_state(ztap_t)\n")
+	b.WriteString("kernel_read_net_sysctls(ztap_t)\n")
+	return b.String()
+}
+
+// SELinuxFileContexts renders the file contexts (.fc) labelling the
+// binary, configuration and state directory for SELinuxModule
+func SELinuxFileContexts(c Config) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\t--\tgen_context(system_u:object_r:ztap_exec_t,s0)\n", selinuxPath(c.Binary))
+	b.WriteString("/etc/ztap(/.*)?\t\tgen_context(system_u:object_r:ztap_etc_t,s0)\n")
+	fmt.Fprintf(&b, "%s(/.*)?\t\tgen_context(system_u:object_r:ztap_var_lib_t,s0)\n", selinuxPath(c.StateDir))
+	return b.String()
+}
+
+// selinuxPath escapes the regular expression characters file contexts
+// interpret in a path
+func selinuxPath(p string) string {
+	return strings.NewReplacer(".", `\.`, "+", `\+`).Replace(p)
+}
@@ -0,0 +1,79 @@
+package service
+
+import "encoding/json"
+
+// runtimeSyscalls are made by the Go runtime itself: scheduling, memory,
+// signals and the network poller
+var runtimeSyscalls = []string{
+	"arch_prctl", "brk", "clock_getres", "clock_gettime", "clock_nanosleep",
+	"clone", "clone3", "close", "dup3", "epoll_create1", "epoll_ctl",
+	"epoll_pwait", "epoll_wait", "eventfd2", "exit", "exit_group", "fcntl",
+	"futex", "getpid", "getrandom", "gettid", "madvise", "membarrier",
+	"mincore", "mmap", "mprotect", "munmap", "nanosleep", "pipe2", "prlimit64",
+	"rseq", "rt_sigaction", "rt_sigprocmask", "rt_sigreturn",
+	"sched_getaffinity", "sched_yield", "set_robust_list", "set_tid_address",
+	"sigaltstack", "tgkill", "uname",
+}
+
+// fileSyscalls cover policy, state and log files, including the locks and
+// atomic renames used by the stats and event journal files
+var fileSyscalls = []string{
+	"faccessat", "faccessat2", "fchmod", "fchmodat", "fchown", "flock",
+	"fstat", "fstatfs", "fsync", "ftruncate", "getdents64", "lseek",
+	"mkdirat", "newfstatat", "openat", "pread64", "pwrite64", "read",
+	"readlinkat", "renameat", "renameat2", "statx", "unlinkat", "write",
+	"writev",
+}
+
+// networkSyscalls cover the metrics server, cloud APIs, the anomaly
+// detector and netlink
+var networkSyscalls = []string{
+	"accept4", "bind", "connect", "getpeername", "getsockname", "getsockopt",
+	"listen", "recvfrom", "recvmsg", "sendmsg", "sendto", "setsockopt",
+	"shutdown", "socket",
+}
+
+// enforcementSyscalls cover eBPF loading and attachment, and the identity
+// and capability checks
+var enforcementSyscalls = []string{
+	"bpf", "capget", "getegid", "geteuid", "getgid", "getuid", "ioctl",
+	"perf_event_open", "setrlimit",
+}
+
+// Syscalls returns the sorted syscalls the agent makes. Anything else is
+// denied by the hardened unit and the seccomp profile.
+func Syscalls() []string {
+	var all []string
+	for _, group := range [][]string{runtimeSyscalls, fileSyscalls, networkSyscalls, enforcementSyscalls} {
+		all = append(all, group...)
+	}
+	return sortedUnique(all)
+}
+
+// seccompProfile is the OCI seccomp profile format used by Docker,
+// containerd and Kubernetes
+type seccompProfile struct {
+	DefaultAction string           `json:"defaultAction"`
+	Architectures []string         `json:"architectures"`
+	Syscalls      []seccompSyscall `json:"syscalls"`
+}
+
+type seccompSyscall struct {
+	Names  []string `json:"names"`
+	Action string   `json:"action"`
+}
+
+// SeccompProfile renders an OCI seccomp profile allowing only Syscalls, for
+// running the agent in a container
+func SeccompProfile() ([]byte, error) {
+	profile := seccompProfile{
+		DefaultAction: "SCMP_ACT_ERRNO",
+		Architectures: []string{"SCMP_ARCH_X86_64", "SCMP_ARCH_AARCH64"},
+		Syscalls:      []seccompSyscall{{Names: Syscalls(), Action: "SCMP_ACT_ALLOW"}},
+	}
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
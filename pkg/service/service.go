@@ -0,0 +1,153 @@
+// Package service generates the files that install ZTAP as a system
+// service: a systemd unit for the agent and, for hardened installs, the
+// seccomp, AppArmor and SELinux policies that confine it.
+package service
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Name is the service, AppArmor profile and SELinux module name
+const Name = "ztap-agent"
+
+// Config describes the service to install
+type Config struct {
+	Binary     string   // Absolute path of the ztap binary
+	PolicyFile string   // Policy file the agent enforces
+	StateDir   string   // Home of the service user; ztap keeps its state in StateDir/.ztap
+	User       string   // Service user; empty runs as root
+	Args       []string // Extra 'ztap agent' arguments
+	// Capabilities granted to a non-root user, e.g. CAP_BPF
+	Capabilities []string
+	Hardened     bool
+}
+
+// DefaultConfig is a root service enforcing /etc/ztap/policy.yaml
+var DefaultConfig = Config{
+	Binary:     "/usr/local/bin/ztap",
+	PolicyFile: "/etc/ztap/policy.yaml",
+	StateDir:   "/var/lib/ztap",
+}
+
+// Validate checks that paths are absolute, since systemd and AppArmor
+// require them
+func (c Config) Validate() error {
+	for _, p := range []struct{ flag, value string }{
+		{"binary", c.Binary},
+		{"policy file", c.PolicyFile},
+		{"state directory", c.StateDir},
+	} {
+		if !path.IsAbs(p.value) {
+			return fmt.Errorf("%s must be an absolute path, got %q", p.flag, p.value)
+		}
+	}
+	if c.User == "root" {
+		return fmt.Errorf("leave the user empty to run as root")
+	}
+	return nil
+}
+
+// File is a generated file and where it is installed
+type File struct {
+	Path string
+	Mode uint32
+	Data []byte
+}
+
+// Files returns every file of the installation: the unit, plus the seccomp
+// profile, AppArmor profile and SELinux module for hardened installs
+func Files(c Config) ([]File, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	files := []File{{Path: "/etc/systemd/system/" + Name + ".service", Mode: 0o644, Data: []byte(Unit(c))}}
+	if !c.Hardened {
+		return files, nil
+	}
+
+	seccomp, err := SeccompProfile()
+	if err != nil {
+		return nil, err
+	}
+	return append(files,
+		File{Path: "/etc/ztap/seccomp.json", Mode: 0o644, Data: seccomp},
+		File{Path: "/etc/apparmor.d/" + Name, Mode: 0o644, Data: []byte(AppArmorProfile(c))},
+		File{Path: "/etc/ztap/selinux/" + selinuxModule + ".te", Mode: 0o644, Data: []byte(SELinuxModule(c))},
+		File{Path: "/etc/ztap/selinux/" + selinuxModule + ".fc", Mode: 0o644, Data: []byte(SELinuxFileContexts(c))},
+	), nil
+}
+
+// Unit renders the systemd unit running 'ztap agent'. Hardened units are
+// sandboxed by systemd, confined by the AppArmor profile and restricted to
+// the syscalls in Syscalls.
+func Unit(c Config) string {
+	var b strings.Builder
+	b.WriteString("# Generated by 'ztap install-service'\n")
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=ZTAP zero-trust enforcement agent\n")
+	b.WriteString("After=network-online.target\n")
+	b.WriteString("Wants=network-online.target\n\n")
+
+	b.WriteString("[Service]\n")
+	args := append([]string{c.Binary, "agent", "-f", c.PolicyFile}, c.Args...)
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(args, " "))
+	fmt.Fprintf(&b, "Environment=HOME=%s\n", c.StateDir)
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("RestartSec=5s\n")
+	if c.User != "" {
+		fmt.Fprintf(&b, "User=%s\n", c.User)
+		fmt.Fprintf(&b, "Group=%s\n", c.User)
+		caps := strings.Join(c.Capabilities, " ")
+		fmt.Fprintf(&b, "AmbientCapabilities=%s\n", caps)
+		fmt.Fprintf(&b, "CapabilityBoundingSet=%s\n", caps)
+	}
+	if strings.HasPrefix(c.StateDir, "/var/lib/") {
+		fmt.Fprintf(&b, "StateDirectory=%s\n", strings.TrimPrefix(c.StateDir, "/var/lib/"))
+	}
+
+	if c.Hardened {
+		b.WriteString("\n# Sandboxing\n")
+		b.WriteString("NoNewPrivileges=yes\n")
+		b.WriteString("ProtectSystem=strict\n")
+		fmt.Fprintf(&b, "ReadWritePaths=%s /sys/fs/bpf\n", c.StateDir)
+		b.WriteString("ProtectHome=yes\n")
+		b.WriteString("PrivateTmp=yes\n")
+		b.WriteString("PrivateDevices=yes\n")
+		b.WriteString("ProtectKernelModules=yes\n")
+		b.WriteString("ProtectKernelLogs=yes\n")
+		b.WriteString("ProtectClock=yes\n")
+		b.WriteString("ProtectHostname=yes\n")
+		b.WriteString("RestrictNamespaces=yes\n")
+		b.WriteString("RestrictRealtime=yes\n")
+		b.WriteString("RestrictSUIDSGID=yes\n")
+		b.WriteString("LockPersonality=yes\n")
+		b.WriteString("MemoryDenyWriteExecute=yes\n")
+		b.WriteString("RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6 AF_NETLINK\n")
+		b.WriteString("SystemCallArchitectures=native\n")
+		fmt.Fprintf(&b, "SystemCallFilter=%s\n", strings.Join(Syscalls(), " "))
+		b.WriteString("SystemCallErrorNumber=EPERM\n")
+		fmt.Fprintf(&b, "AppArmorProfile=-%s\n", Name)
+	}
+
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// sortedUnique returns values sorted without duplicates
+func sortedUnique(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var out []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
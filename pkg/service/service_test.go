@@ -0,0 +1,167 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if err := DefaultConfig.Validate(); err != nil {
+		t.Errorf("Expected default config to be valid: %v", err)
+	}
+
+	c := DefaultConfig
+	c.Binary = "ztap"
+	if err := c.Validate(); err == nil {
+		t.Error("Expected error for relative binary path")
+	}
+
+	c = DefaultConfig
+	c.User = "root"
+	if err := c.Validate(); err == nil {
+		t.Error("Expected error for explicit root user")
+	}
+}
+
+func TestUnit(t *testing.T) {
+	unit := Unit(DefaultConfig)
+	if !strings.Contains(unit, "ExecStart=/usr/local/bin/ztap agent -f /etc/ztap/policy.yaml\n") {
+		t.Errorf("Missing ExecStart:\n%s", unit)
+	}
+	if !strings.Contains(unit, "StateDirectory=ztap\n") {
+		t.Errorf("Missing StateDirectory:\n%s", unit)
+	}
+	for _, directive := range []string{"User=", "AmbientCapabilities=", "SystemCallFilter=", "AppArmorProfile="} {
+		if strings.Contains(unit, directive) {
+			t.Errorf("Unexpected %s in plain root unit:\n%s", directive, unit)
+		}
+	}
+
+	c := DefaultConfig
+	c.User = "ztap"
+	c.Capabilities = []string{"CAP_BPF", "CAP_NET_ADMIN"}
+	c.Args = []string{"--metrics-port", "9090"}
+	c.Hardened = true
+	unit = Unit(c)
+	for _, want := range []string{
+		"ExecStart=/usr/local/bin/ztap agent -f /etc/ztap/policy.yaml --metrics-port 9090\n",
+		"User=ztap\n",
+		"AmbientCapabilities=CAP_BPF CAP_NET_ADMIN\n",
+		"CapabilityBoundingSet=CAP_BPF CAP_NET_ADMIN\n",
+		"ReadWritePaths=/var/lib/ztap /sys/fs/bpf\n",
+		"SystemCallFilter=accept4 arch_prctl bind bpf ",
+		"AppArmorProfile=-ztap-agent\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("Missing %q in hardened unit:\n%s", want, unit)
+		}
+	}
+}
+
+func TestSyscalls(t *testing.T) {
+	syscalls := Syscalls()
+	for i := 1; i < len(syscalls); i++ {
+		if syscalls[i-1] >= syscalls[i] {
+			t.Fatalf("Syscalls not sorted and unique at %q, %q", syscalls[i-1], syscalls[i])
+		}
+	}
+	for _, want := range []string{"bpf", "perf_event_open", "futex", "openat", "connect"} {
+		found := false
+		for _, s := range syscalls {
+			found = found || s == want
+		}
+		if !found {
+			t.Errorf("Expected %s to be allowed", want)
+		}
+	}
+	for _, denied := range []string{"execve", "ptrace", "mount", "init_module"} {
+		for _, s := range syscalls {
+			if s == denied {
+				t.Errorf("Expected %s to be denied", denied)
+			}
+		}
+	}
+}
+
+func TestSeccompProfile(t *testing.T) {
+	data, err := SeccompProfile()
+	if err != nil {
+		t.Fatalf("SeccompProfile failed: %v", err)
+	}
+
+	var profile seccompProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		t.Fatalf("Invalid profile JSON: %v", err)
+	}
+	if profile.DefaultAction != "SCMP_ACT_ERRNO" {
+		t.Errorf("Expected deny by default, got %s", profile.DefaultAction)
+	}
+	if len(profile.Syscalls) != 1 || len(profile.Syscalls[0].Names) != len(Syscalls()) {
+		t.Errorf("Expected one allow rule with every syscall, got %+v", profile.Syscalls)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	files, err := Files(DefaultConfig)
+	if err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "/etc/systemd/system/ztap-agent.service" {
+		t.Errorf("Expected only the unit, got %v", files)
+	}
+
+	c := DefaultConfig
+	c.Hardened = true
+	files, err = Files(c)
+	if err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	expected := "/etc/systemd/system/ztap-agent.service /etc/ztap/seccomp.json /etc/apparmor.d/ztap-agent /etc/ztap/selinux/ztap.te /etc/ztap/selinux/ztap.fc"
+	if got := strings.Join(paths, " "); got != expected {
+		t.Errorf("Unexpected files %s", got)
+	}
+
+	c.StateDir = "var/lib/ztap"
+	if _, err := Files(c); err == nil {
+		t.Error("Expected error for relative state directory")
+	}
+}
+
+func TestAppArmorProfile(t *testing.T) {
+	c := DefaultConfig
+	c.User = "ztap"
+	c.Capabilities = []string{"CAP_BPF", "CAP_NET_ADMIN"}
+	profile := AppArmorProfile(c)
+	for _, want := range []string{
+		"profile ztap-agent /usr/local/bin/ztap flags=(enforce) {\n",
+		"  capability bpf,\n",
+		"  capability net_admin,\n",
+		"  /etc/ztap/policy.yaml r,\n",
+		"  /var/lib/ztap/** rwk,\n",
+		"  /sys/fs/bpf/** rw,\n",
+	} {
+		if !strings.Contains(profile, want) {
+			t.Errorf("Missing %q in profile:\n%s", want, profile)
+		}
+	}
+	if strings.Contains(profile, "capability sys_admin") {
+		t.Errorf("Non-root profile should only grant the configured capabilities:\n%s", profile)
+	}
+}
+
+func TestSELinuxFileContexts(t *testing.T) {
+	c := DefaultConfig
+	c.Binary = "/opt/ztap-1.2/ztap"
+	fc := SELinuxFileContexts(c)
+	if !strings.Contains(fc, `/opt/ztap-1\.2/ztap	--	gen_context(system_u:object_r:ztap_exec_t,s0)`) {
+		t.Errorf("Expected escaped binary path:\n%s", fc)
+	}
+	if !strings.Contains(SELinuxModule(c), "policy_module(ztap, 1.0.0)") {
+		t.Error("Missing policy_module declaration")
+	}
+}
@@ -0,0 +1,166 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Heatmap metrics
+const (
+	MetricBlocks    = "blocks"    // Blocked flows per policy
+	MetricAnomalies = "anomalies" // Anomaly scores per source service
+)
+
+// heatmapSteps are the column widths of a heatmap, multiples of
+// SlotDuration; the finest one fitting the requested columns is used
+var heatmapSteps = []time.Duration{
+	5 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+// HeatmapOptions select what BuildHeatmap charts
+type HeatmapOptions struct {
+	Since, Until time.Time
+	// Step is the finest column width wanted, rounded up to a step of at
+	// least SlotDuration; long ranges use wider columns to stay within
+	// MaxColumns
+	Step       time.Duration
+	MaxColumns int
+	Top        int                    // Keep the rows with the highest totals; 0 keeps all
+	Include    func(name string) bool // Rows to keep; nil keeps all
+}
+
+// Heatmap is a matrix of time-bucketed values, one row per policy or
+// service and one column per step, for dashboard charts
+type Heatmap struct {
+	Metric  string       `json:"metric"`
+	Since   time.Time    `json:"since"` // Start of the first column
+	Until   time.Time    `json:"until"`
+	Step    int64        `json:"step_seconds"`
+	Columns []time.Time  `json:"columns"` // Start of each column
+	Rows    []HeatmapRow `json:"rows"`    // Highest total first
+}
+
+// HeatmapRow holds the values of one policy or service. Values are blocked
+// flows for MetricBlocks, and the highest anomaly score for
+// MetricAnomalies, whose Counts hold the number of anomalies.
+type HeatmapRow struct {
+	Name   string    `json:"name"`
+	Values []float64 `json:"values"`
+	Counts []int64   `json:"counts,omitempty"`
+	Total  float64   `json:"total"` // Blocked flows, or the highest score
+}
+
+// heatmapStep returns the column width for the options
+func heatmapStep(opts HeatmapOptions) time.Duration {
+	span := opts.Until.Sub(opts.Since)
+	for _, step := range heatmapSteps {
+		if step < opts.Step {
+			continue
+		}
+		if opts.MaxColumns <= 0 || int((span+step-1)/step) <= opts.MaxColumns {
+			return step
+		}
+	}
+	return heatmapSteps[len(heatmapSteps)-1]
+}
+
+// BuildHeatmap charts metric over the buckets. Columns are aligned to their
+// width in UTC; older buckets without slots count their blocked flows at
+// the start of their hour.
+func BuildHeatmap(buckets []*Bucket, metric string, opts HeatmapOptions) (*Heatmap, error) {
+	if metric != MetricBlocks && metric != MetricAnomalies {
+		return nil, fmt.Errorf("unknown heatmap metric %q (want %s or %s)", metric, MetricBlocks, MetricAnomalies)
+	}
+	if !opts.Since.Before(opts.Until) {
+		return nil, fmt.Errorf("heatmap range is empty")
+	}
+	step := heatmapStep(opts)
+	start := opts.Since.UTC().Truncate(step)
+	heatmap := &Heatmap{Metric: metric, Since: start, Until: opts.Until, Step: int64(step / time.Second)}
+	for t := start; t.Before(opts.Until); t = t.Add(step) {
+		heatmap.Columns = append(heatmap.Columns, t)
+	}
+
+	rows := make(map[string]*HeatmapRow)
+	row := func(name string) *HeatmapRow {
+		r, ok := rows[name]
+		if !ok {
+			r = &HeatmapRow{Name: name, Values: make([]float64, len(heatmap.Columns))}
+			if metric == MetricAnomalies {
+				r.Counts = make([]int64, len(heatmap.Columns))
+			}
+			rows[name] = r
+		}
+		return r
+	}
+	// column returns the column of slot i of b, or -1 outside the range
+	column := func(b *Bucket, i int) int {
+		at := b.Hour.Add(time.Duration(i) * SlotDuration)
+		if at.Before(start) || !at.Before(opts.Until) {
+			return -1
+		}
+		return int(at.Sub(start) / step)
+	}
+
+	for _, b := range buckets {
+		if !b.Hour.Add(time.Hour).After(start) || !b.Hour.Before(opts.Until) {
+			continue
+		}
+		switch metric {
+		case MetricBlocks:
+			if b.PolicyBlocks == nil {
+				for name, c := range b.Policies {
+					if col := column(b, 0); col >= 0 && c.Blocked > 0 {
+						row(name).Values[col] += float64(c.Blocked)
+					}
+				}
+				continue
+			}
+			for name, slots := range b.PolicyBlocks {
+				for i, n := range slots {
+					if col := column(b, i); col >= 0 && n > 0 {
+						row(name).Values[col] += float64(n)
+					}
+				}
+			}
+		case MetricAnomalies:
+			for name, slots := range b.AnomalyScores {
+				for i, score := range slots {
+					if col := column(b, i); col >= 0 && score.Count > 0 {
+						r := row(name)
+						r.Counts[col] += score.Count
+						r.Values[col] = max(r.Values[col], score.Max)
+					}
+				}
+			}
+		}
+	}
+
+	heatmap.Rows = []HeatmapRow{}
+	for name, r := range rows {
+		if opts.Include != nil && !opts.Include(name) {
+			continue
+		}
+		for _, v := range r.Values {
+			if metric == MetricBlocks {
+				r.Total += v
+			} else {
+				r.Total = max(r.Total, v)
+			}
+		}
+		heatmap.Rows = append(heatmap.Rows, *r)
+	}
+	sort.Slice(heatmap.Rows, func(i, j int) bool {
+		a, b := heatmap.Rows[i], heatmap.Rows[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Name < b.Name
+	})
+	if opts.Top > 0 && len(heatmap.Rows) > opts.Top {
+		heatmap.Rows = heatmap.Rows[:opts.Top]
+	}
+	return heatmap, nil
+}
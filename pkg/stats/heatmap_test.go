@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecorderSlots(t *testing.T) {
+	now := time.Date(2025, 10, 1, 9, 7, 0, 0, time.UTC)
+	r, path := newTestRecorder(t, &now)
+
+	r.RecordFlows("web-to-db", false, "10.0.0.3", 5432, 3)
+	r.RecordAnomalyScore("web", 0.7)
+	now = now.Add(50 * time.Minute) // 09:57, the last slot
+	r.RecordFlow("web-to-db", false, "10.0.0.3", 5432)
+	r.RecordAnomalyScore("web", 0.9)
+	r.RecordAnomalyScore("web", 0.8)
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	buckets, _ := Load(path)
+	if len(buckets) != 1 {
+		t.Fatalf("Expected 1 bucket, got %d", len(buckets))
+	}
+	blocks := buckets[0].PolicyBlocks["web-to-db"]
+	if len(blocks) != 12 || blocks[1] != 3 || blocks[11] != 1 {
+		t.Errorf("Unexpected block slots %v", blocks)
+	}
+	scores := buckets[0].AnomalyScores["web"]
+	if scores[1] != (Score{Count: 1, Sum: 0.7, Max: 0.7}) || scores[11].Count != 2 || scores[11].Max != 0.9 {
+		t.Errorf("Unexpected score slots %v", scores)
+	}
+	if buckets[0].Anomalies != 3 {
+		t.Errorf("Expected 3 anomalies, got %d", buckets[0].Anomalies)
+	}
+}
+
+func TestBuildHeatmap(t *testing.T) {
+	nine := time.Date(2025, 10, 1, 9, 0, 0, 0, time.UTC)
+	buckets := []*Bucket{
+		// Recorded before slots: counted at the start of the hour
+		{Hour: nine.Add(-time.Hour), Policies: map[string]*Counts{"web-to-db": {Blocked: 7}}},
+		{
+			Hour:         nine,
+			Policies:     map[string]*Counts{"web-to-db": {Blocked: 4}, "deny-ssh": {Blocked: 10}},
+			PolicyBlocks: map[string][]int64{"web-to-db": {1, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0}, "deny-ssh": {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 10}},
+			AnomalyScores: map[string][]Score{"web": {
+				{Count: 1, Sum: 0.5, Max: 0.5}, {Count: 2, Sum: 1.6, Max: 0.9}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {},
+			}},
+		},
+	}
+
+	heatmap, err := BuildHeatmap(buckets, MetricBlocks, HeatmapOptions{Since: nine, Until: nine.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("BuildHeatmap failed: %v", err)
+	}
+	if heatmap.Step != 300 || len(heatmap.Columns) != 12 || !heatmap.Columns[2].Equal(nine.Add(10*time.Minute)) {
+		t.Fatalf("Expected 12 5-minute columns, got %d of %ds", len(heatmap.Columns), heatmap.Step)
+	}
+	if len(heatmap.Rows) != 2 || heatmap.Rows[0].Name != "deny-ssh" || heatmap.Rows[0].Values[11] != 10 {
+		t.Fatalf("Expected deny-ssh first, got %+v", heatmap.Rows)
+	}
+	if web := heatmap.Rows[1]; web.Values[0] != 1 || web.Values[2] != 3 || web.Total != 4 {
+		t.Errorf("Unexpected web-to-db row %+v", web)
+	}
+
+	// Long ranges are downsampled to fit the columns
+	heatmap, _ = BuildHeatmap(buckets, MetricBlocks, HeatmapOptions{Since: nine.Add(-2 * time.Hour), Until: nine.Add(time.Hour), MaxColumns: 6})
+	if heatmap.Step != 1800 || len(heatmap.Columns) != 6 {
+		t.Fatalf("Expected 6 30-minute columns, got %d of %ds", len(heatmap.Columns), heatmap.Step)
+	}
+	for _, row := range heatmap.Rows {
+		if row.Name == "web-to-db" && (row.Values[2] != 7 || row.Values[4] != 4 || row.Total != 11) {
+			t.Errorf("Unexpected downsampled row %+v", row)
+		}
+	}
+
+	// Steps round up to the next supported width; Top and Include keep rows
+	heatmap, _ = BuildHeatmap(buckets, MetricBlocks, HeatmapOptions{
+		Since: nine, Until: nine.Add(time.Hour), Step: 20 * time.Minute, Top: 1,
+		Include: func(name string) bool { return name != "deny-ssh" },
+	})
+	if heatmap.Step != 1800 || len(heatmap.Rows) != 1 || heatmap.Rows[0].Name != "web-to-db" || heatmap.Rows[0].Values[0] != 4 {
+		t.Errorf("Unexpected filtered heatmap %+v", heatmap)
+	}
+
+	heatmap, _ = BuildHeatmap(buckets, MetricAnomalies, HeatmapOptions{Since: nine, Until: nine.Add(15 * time.Minute), Step: 15 * time.Minute})
+	if len(heatmap.Rows) != 1 || heatmap.Rows[0].Values[0] != 0.9 || heatmap.Rows[0].Counts[0] != 3 || heatmap.Rows[0].Total != 0.9 {
+		t.Errorf("Unexpected anomaly heatmap %+v", heatmap.Rows)
+	}
+
+	if _, err := BuildHeatmap(buckets, "latency", HeatmapOptions{Since: nine, Until: nine.Add(time.Hour)}); err == nil {
+		t.Error("Expected an error for an unknown metric")
+	}
+	if _, err := BuildHeatmap(buckets, MetricBlocks, HeatmapOptions{Since: nine, Until: nine}); err == nil {
+		t.Error("Expected an error for an empty range")
+	}
+}
@@ -0,0 +1,253 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// PolicySummary totals the flows matched by one policy
+type PolicySummary struct {
+	Name    string `json:"name"`
+	Allowed int64  `json:"allowed"`
+	Blocked int64  `json:"blocked"`
+}
+
+// Destination is a blocked destination and how often it was blocked
+type Destination struct {
+	Address string `json:"address"`
+	Service string `json:"service,omitempty"` // Service that held the IP, see Report.Attribute
+	Blocked int64  `json:"blocked"`
+
+	lastHour time.Time // Latest hour the destination was blocked in
+}
+
+// Day summarizes one day of the report period, including per-policy hits
+// so trends can be charted
+type Day struct {
+	Date      time.Time          `json:"date"`
+	Allowed   int64              `json:"allowed"`
+	Blocked   int64              `json:"blocked"`
+	Anomalies int64              `json:"anomalies"`
+	Policies  map[string]*Counts `json:"policies,omitempty"`
+}
+
+// Report summarizes enforcement statistics over a period
+type Report struct {
+	Since      time.Time       `json:"since"`
+	Until      time.Time       `json:"until"`
+	Allowed    int64           `json:"allowed"`
+	Blocked    int64           `json:"blocked"`
+	Anomalies  int64           `json:"anomalies"`
+	Policies   []PolicySummary `json:"policies"`
+	TopBlocked []Destination   `json:"top_blocked"`
+	Days       []Day           `json:"days"`
+}
+
+// BuildReport summarizes the buckets whose hour falls in [since, until),
+// keeping the top most-blocked destinations
+func BuildReport(buckets []*Bucket, since, until time.Time, top int) *Report {
+	report := &Report{Since: since, Until: until}
+
+	policies := make(map[string]*PolicySummary)
+	destinations := make(map[string]int64)
+	lastHours := make(map[string]time.Time)
+	days := make(map[string]*Day)
+
+	for _, b := range buckets {
+		if b.Hour.Before(since.Truncate(time.Hour)) || !b.Hour.Before(until) {
+			continue
+		}
+
+		date := b.Hour.UTC().Truncate(24 * time.Hour)
+		day, ok := days[date.Format("2006-01-02")]
+		if !ok {
+			day = &Day{Date: date, Policies: make(map[string]*Counts)}
+			days[date.Format("2006-01-02")] = day
+		}
+
+		for name, c := range b.Policies {
+			summary, ok := policies[name]
+			if !ok {
+				summary = &PolicySummary{Name: name}
+				policies[name] = summary
+			}
+			summary.Allowed += c.Allowed
+			summary.Blocked += c.Blocked
+
+			counts, ok := day.Policies[name]
+			if !ok {
+				counts = &Counts{}
+				day.Policies[name] = counts
+			}
+			counts.Allowed += c.Allowed
+			counts.Blocked += c.Blocked
+
+			day.Allowed += c.Allowed
+			day.Blocked += c.Blocked
+		}
+		for dest, n := range b.BlockedDestinations {
+			destinations[dest] += n
+			if b.Hour.After(lastHours[dest]) {
+				lastHours[dest] = b.Hour
+			}
+		}
+		day.Anomalies += b.Anomalies
+	}
+
+	for _, summary := range policies {
+		report.Policies = append(report.Policies, *summary)
+		report.Allowed += summary.Allowed
+		report.Blocked += summary.Blocked
+	}
+	sort.Slice(report.Policies, func(i, j int) bool {
+		a, b := report.Policies[i], report.Policies[j]
+		if a.Blocked != b.Blocked {
+			return a.Blocked > b.Blocked
+		}
+		return a.Name < b.Name
+	})
+
+	for dest, n := range destinations {
+		report.TopBlocked = append(report.TopBlocked, Destination{Address: dest, Blocked: n, lastHour: lastHours[dest]})
+	}
+	sort.Slice(report.TopBlocked, func(i, j int) bool {
+		a, b := report.TopBlocked[i], report.TopBlocked[j]
+		if a.Blocked != b.Blocked {
+			return a.Blocked > b.Blocked
+		}
+		return a.Address < b.Address
+	})
+	if top > 0 && len(report.TopBlocked) > top {
+		report.TopBlocked = report.TopBlocked[:top]
+	}
+
+	for _, day := range days {
+		report.Days = append(report.Days, *day)
+		report.Anomalies += day.Anomalies
+	}
+	sort.Slice(report.Days, func(i, j int) bool {
+		return report.Days[i].Date.Before(report.Days[j].Date)
+	})
+
+	return report
+}
+
+// ParseSince parses a look-back period such as "24h", "7d" or "2w". Days and
+// weeks are accepted in addition to time.ParseDuration units.
+func ParseSince(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count <= 0 {
+				return 0, fmt.Errorf("invalid period %q", s)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid period %q", s)
+	}
+	return d, nil
+}
+
+// Attribute names the service of each top blocked destination. name returns
+// the service that held an IP at a time, or "" if unknown; it is asked about
+// the last hour the destination was blocked in, so recycled IPs are named
+// after the service that held them then.
+func (r *Report) Attribute(name func(ip string, at time.Time) string) {
+	for i, d := range r.TopBlocked {
+		ip := d.Address
+		if host, _, err := net.SplitHostPort(d.Address); err == nil {
+			ip = host
+		}
+		// The hour bucket may start before the service registered
+		r.TopBlocked[i].Service = name(ip, d.lastHour.Add(time.Hour-time.Nanosecond))
+	}
+}
+
+// WriteTable writes the report as human-readable tables
+func (r *Report) WriteTable(w io.Writer) error {
+	fmt.Fprintf(w, "Enforcement report %s to %s\n\n",
+		r.Since.Format("2006-01-02 15:04"), r.Until.Format("2006-01-02 15:04"))
+	fmt.Fprintf(w, "Flows allowed: %d\nFlows blocked: %d\nAnomalies:     %d\n", r.Allowed, r.Blocked, r.Anomalies)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "\nPOLICY\tALLOWED\tBLOCKED")
+	for _, p := range r.Policies {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", p.Name, p.Allowed, p.Blocked)
+	}
+
+	fmt.Fprintln(tw, "\nTOP BLOCKED DESTINATION\tSERVICE\tBLOCKED")
+	for _, d := range r.TopBlocked {
+		service := d.Service
+		if service == "" {
+			service = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", d.Address, service, d.Blocked)
+	}
+
+	fmt.Fprintln(tw, "\nDAY\tALLOWED\tBLOCKED\tANOMALIES")
+	for _, d := range r.Days {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", d.Date.Format("2006-01-02"), d.Allowed, d.Blocked, d.Anomalies)
+	}
+
+	return tw.Flush()
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+var htmlReport = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>ZTAP Enforcement Report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 12px; text-align: left; }
+th { background: #f0f0f0; }
+</style>
+</head>
+<body>
+<h1>ZTAP Enforcement Report</h1>
+<p>{{.Since.Format "2006-01-02 15:04"}} to {{.Until.Format "2006-01-02 15:04"}}</p>
+<p>Flows allowed: {{.Allowed}} &middot; Flows blocked: {{.Blocked}} &middot; Anomalies: {{.Anomalies}}</p>
+<h2>Policies</h2>
+<table>
+<tr><th>Policy</th><th>Allowed</th><th>Blocked</th></tr>
+{{range .Policies}}<tr><td>{{.Name}}</td><td>{{.Allowed}}</td><td>{{.Blocked}}</td></tr>
+{{end}}</table>
+<h2>Top Blocked Destinations</h2>
+<table>
+<tr><th>Destination</th><th>Service</th><th>Blocked</th></tr>
+{{range .TopBlocked}}<tr><td>{{.Address}}</td><td>{{.Service}}</td><td>{{.Blocked}}</td></tr>
+{{end}}</table>
+<h2>Daily Trend</h2>
+<table>
+<tr><th>Day</th><th>Allowed</th><th>Blocked</th><th>Anomalies</th></tr>
+{{range .Days}}<tr><td>{{.Date.Format "2006-01-02"}}</td><td>{{.Allowed}}</td><td>{{.Blocked}}</td><td>{{.Anomalies}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as a standalone HTML page
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlReport.Execute(w, r)
+}
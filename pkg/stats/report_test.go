@@ -0,0 +1,165 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testBuckets() []*Bucket {
+	day1 := time.Date(2025, 10, 1, 9, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	return []*Bucket{
+		{
+			Hour: day1.Add(-30 * 24 * time.Hour), // Outside the report period
+			Policies: map[string]*Counts{
+				"old": {Blocked: 100},
+			},
+		},
+		{
+			Hour: day1,
+			Policies: map[string]*Counts{
+				"web-to-db": {Allowed: 10, Blocked: 2},
+				"deny-ssh":  {Blocked: 5},
+			},
+			BlockedDestinations: map[string]int64{"10.0.0.4:22": 5, "10.0.0.3:5432": 2},
+			Anomalies:           1,
+		},
+		{
+			Hour: day2,
+			Policies: map[string]*Counts{
+				"deny-ssh": {Blocked: 3},
+			},
+			BlockedDestinations: map[string]int64{"10.0.0.4:22": 2, "10.0.0.5:22": 1},
+			Anomalies:           2,
+		},
+	}
+}
+
+func TestBuildReport(t *testing.T) {
+	since := time.Date(2025, 9, 25, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 10, 3, 0, 0, 0, 0, time.UTC)
+
+	report := BuildReport(testBuckets(), since, until, 2)
+
+	if report.Allowed != 10 || report.Blocked != 10 || report.Anomalies != 3 {
+		t.Errorf("Unexpected totals: allowed=%d blocked=%d anomalies=%d",
+			report.Allowed, report.Blocked, report.Anomalies)
+	}
+
+	if len(report.Policies) != 2 || report.Policies[0].Name != "deny-ssh" || report.Policies[0].Blocked != 8 {
+		t.Errorf("Expected deny-ssh first with 8 blocked, got %+v", report.Policies)
+	}
+
+	if len(report.TopBlocked) != 2 {
+		t.Fatalf("Expected top 2 destinations, got %d", len(report.TopBlocked))
+	}
+	if top := report.TopBlocked[0]; top.Address != "10.0.0.4:22" || top.Blocked != 7 {
+		t.Errorf("Unexpected top destination: %+v", top)
+	}
+
+	if len(report.Days) != 2 {
+		t.Fatalf("Expected 2 days, got %d", len(report.Days))
+	}
+	if report.Days[0].Blocked != 7 || report.Days[1].Policies["deny-ssh"].Blocked != 3 {
+		t.Errorf("Unexpected daily trend: %+v", report.Days)
+	}
+}
+
+func TestReportAttribute(t *testing.T) {
+	since := time.Date(2025, 9, 25, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 10, 3, 0, 0, 0, 0, time.UTC)
+	report := BuildReport(testBuckets(), since, until, 10)
+
+	// 10.0.0.4 was recycled from bastion to db-2 on the second day
+	recycled := time.Date(2025, 10, 2, 0, 0, 0, 0, time.UTC)
+	report.Attribute(func(ip string, at time.Time) string {
+		switch {
+		case ip == "10.0.0.4" && at.Before(recycled):
+			return "bastion"
+		case ip == "10.0.0.4":
+			return "db-2"
+		case ip == "10.0.0.3":
+			return "db-1"
+		}
+		return ""
+	})
+
+	services := make(map[string]string)
+	for _, d := range report.TopBlocked {
+		services[d.Address] = d.Service
+	}
+	if services["10.0.0.4:22"] != "db-2" || services["10.0.0.3:5432"] != "db-1" || services["10.0.0.5:22"] != "" {
+		t.Errorf("Unexpected services %v", services)
+	}
+
+	var table bytes.Buffer
+	report.WriteTable(&table)
+	if !strings.Contains(table.String(), "db-1") {
+		t.Errorf("Table output missing service:\n%s", table.String())
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"7d", 7 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"36h", 36 * time.Hour, false},
+		{"0d", 0, true},
+		{"-1h", 0, true},
+		{"xd", 0, true},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseSince(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSince(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSince(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportFormats(t *testing.T) {
+	since := time.Date(2025, 9, 25, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2025, 10, 3, 0, 0, 0, 0, time.UTC)
+	report := BuildReport(testBuckets(), since, until, 10)
+
+	var table bytes.Buffer
+	if err := report.WriteTable(&table); err != nil {
+		t.Fatalf("WriteTable failed: %v", err)
+	}
+	if !strings.Contains(table.String(), "deny-ssh") || !strings.Contains(table.String(), "10.0.0.4:22") {
+		t.Errorf("Table output missing data:\n%s", table.String())
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteJSON(&out); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if decoded.Blocked != report.Blocked {
+		t.Errorf("JSON round trip lost totals: %+v", decoded)
+	}
+
+	var html bytes.Buffer
+	if err := report.WriteHTML(&html); err != nil {
+		t.Fatalf("WriteHTML failed: %v", err)
+	}
+	if !strings.Contains(html.String(), "<td>10.0.0.4:22</td>") {
+		t.Errorf("HTML output missing destination:\n%s", html.String())
+	}
+}
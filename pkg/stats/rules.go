@@ -0,0 +1,86 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RuleHit totals the allowed flows from one policy to one destination
+type RuleHit struct {
+	Policy   string    `json:"policy"`
+	Protocol string    `json:"protocol"`
+	IP       string    `json:"ip"`
+	Port     int       `json:"port"`
+	Count    int64     `json:"count"`
+	LastHour time.Time `json:"last_hour"` // Start of the last hour with a hit
+}
+
+// ruleHitKey identifies a destination of allowed flows in Bucket.RuleHits
+func ruleHitKey(policy, protocol, destIP string, port int) string {
+	return fmt.Sprintf("%s|%s|%s:%d", policy, strings.ToUpper(protocol), destIP, port)
+}
+
+// parseRuleHitKey splits a key made by ruleHitKey
+func parseRuleHitKey(key string) (RuleHit, bool) {
+	parts := strings.SplitN(key, "|", 3)
+	if len(parts) != 3 {
+		return RuleHit{}, false
+	}
+	i := strings.LastIndex(parts[2], ":")
+	if i < 0 {
+		return RuleHit{}, false
+	}
+	port, err := strconv.Atoi(parts[2][i+1:])
+	if err != nil {
+		return RuleHit{}, false
+	}
+	return RuleHit{Policy: parts[0], Protocol: parts[1], IP: parts[2][:i], Port: port}, true
+}
+
+// RuleHits totals the rule hits of the buckets whose hour is not before
+// since, ordered by policy, protocol, port and IP
+func RuleHits(buckets []*Bucket, since time.Time) []RuleHit {
+	totals := make(map[string]*RuleHit)
+	for _, b := range buckets {
+		if b.Hour.Before(since.Truncate(time.Hour)) {
+			continue
+		}
+		for key, n := range b.RuleHits {
+			hit, ok := totals[key]
+			if !ok {
+				parsed, valid := parseRuleHitKey(key)
+				if !valid {
+					continue
+				}
+				hit = &parsed
+				totals[key] = hit
+			}
+			hit.Count += n
+			if b.Hour.After(hit.LastHour) {
+				hit.LastHour = b.Hour
+			}
+		}
+	}
+
+	hits := make([]RuleHit, 0, len(totals))
+	for _, hit := range totals {
+		hits = append(hits, *hit)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.Policy != b.Policy {
+			return a.Policy < b.Policy
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.IP < b.IP
+	})
+	return hits
+}
@@ -0,0 +1,450 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"ztap/pkg/events"
+)
+
+// Counts holds flow counters for one policy
+type Counts struct {
+	Allowed int64 `json:"allowed"`
+	Blocked int64 `json:"blocked"`
+}
+
+// SlotDuration is the resolution of the per-policy blocks and per-service
+// anomaly scores of a Bucket, charted by BuildHeatmap
+const SlotDuration = 5 * time.Minute
+
+// slotsPerHour is the number of slots in a Bucket
+const slotsPerHour = int(time.Hour / SlotDuration)
+
+// Score aggregates the anomaly scores of one slot
+type Score struct {
+	Count int64   `json:"count,omitempty"`
+	Sum   float64 `json:"sum,omitempty"`
+	Max   float64 `json:"max,omitempty"`
+}
+
+// Bucket aggregates enforcement statistics for one hour
+type Bucket struct {
+	Hour                time.Time          `json:"hour"`
+	Policies            map[string]*Counts `json:"policies,omitempty"`
+	BlockedDestinations map[string]int64   `json:"blocked_destinations,omitempty"` // Keyed by "ip:port"
+	RuleHits            map[string]int64   `json:"rule_hits,omitempty"`            // Allowed flows keyed by ruleHitKey
+	Anomalies           int64              `json:"anomalies,omitempty"`
+	// PolicyBlocks and AnomalyScores hold a value per SlotDuration of the
+	// hour: blocked flows by policy, and anomaly scores by source service
+	// (or IP). Buckets recorded by earlier versions have neither.
+	PolicyBlocks  map[string][]int64 `json:"policy_blocks,omitempty"`
+	AnomalyScores map[string][]Score `json:"anomaly_scores,omitempty"`
+}
+
+func newBucket(hour time.Time) *Bucket {
+	return &Bucket{
+		Hour:                hour,
+		Policies:            make(map[string]*Counts),
+		BlockedDestinations: make(map[string]int64),
+		RuleHits:            make(map[string]int64),
+	}
+}
+
+// merge adds the counters of other into b
+func (b *Bucket) merge(other *Bucket) {
+	if b.Policies == nil {
+		b.Policies = make(map[string]*Counts)
+	}
+	if b.BlockedDestinations == nil {
+		b.BlockedDestinations = make(map[string]int64)
+	}
+	if b.RuleHits == nil {
+		b.RuleHits = make(map[string]int64)
+	}
+
+	for name, c := range other.Policies {
+		counts, ok := b.Policies[name]
+		if !ok {
+			counts = &Counts{}
+			b.Policies[name] = counts
+		}
+		counts.Allowed += c.Allowed
+		counts.Blocked += c.Blocked
+	}
+	for dest, n := range other.BlockedDestinations {
+		b.BlockedDestinations[dest] += n
+	}
+	for key, n := range other.RuleHits {
+		b.RuleHits[key] += n
+	}
+	b.Anomalies += other.Anomalies
+	for name, slots := range other.PolicyBlocks {
+		mine := b.policyBlocks(name)
+		for i, n := range slots {
+			if i < len(mine) {
+				mine[i] += n
+			}
+		}
+	}
+	for name, slots := range other.AnomalyScores {
+		mine := b.anomalyScores(name)
+		for i, score := range slots {
+			if i < len(mine) {
+				mine[i].add(score)
+			}
+		}
+	}
+}
+
+// policyBlocks returns the slots of policy, creating them if needed
+func (b *Bucket) policyBlocks(policy string) []int64 {
+	if b.PolicyBlocks == nil {
+		b.PolicyBlocks = make(map[string][]int64)
+	}
+	slots, ok := b.PolicyBlocks[policy]
+	if !ok {
+		slots = make([]int64, slotsPerHour)
+		b.PolicyBlocks[policy] = slots
+	}
+	return slots
+}
+
+// anomalyScores returns the slots of service, creating them if needed
+func (b *Bucket) anomalyScores(service string) []Score {
+	if b.AnomalyScores == nil {
+		b.AnomalyScores = make(map[string][]Score)
+	}
+	slots, ok := b.AnomalyScores[service]
+	if !ok {
+		slots = make([]Score, slotsPerHour)
+		b.AnomalyScores[service] = slots
+	}
+	return slots
+}
+
+// add merges other into s
+func (s *Score) add(other Score) {
+	s.Count += other.Count
+	s.Sum += other.Sum
+	if other.Max > s.Max {
+		s.Max = other.Max
+	}
+}
+
+// Recorder aggregates statistics in memory and persists them to a JSON file
+// on Flush, merging with what earlier processes recorded. Only hourly
+// aggregates are stored, so the file stays small regardless of flow volume.
+type Recorder struct {
+	mu      sync.Mutex
+	path    string
+	pending map[int64]*Bucket // Keyed by hour Unix timestamp
+	now     func() time.Time
+}
+
+// NewRecorder creates a recorder persisting to path
+func NewRecorder(path string) *Recorder {
+	return &Recorder{
+		path:    path,
+		pending: make(map[int64]*Bucket),
+		now:     time.Now,
+	}
+}
+
+// bucket returns the pending bucket for the current hour (requires holding mu lock)
+func (r *Recorder) bucket() *Bucket {
+	hour := r.now().UTC().Truncate(time.Hour)
+	b, ok := r.pending[hour.Unix()]
+	if !ok {
+		b = newBucket(hour)
+		r.pending[hour.Unix()] = b
+	}
+	return b
+}
+
+// slot returns the index of the current SlotDuration in b, the bucket of
+// the current hour
+func (r *Recorder) slot(b *Bucket) int {
+	return min(int(r.now().Sub(b.Hour)/SlotDuration), slotsPerHour-1)
+}
+
+// RecordFlow counts an allowed or blocked flow for policy
+func (r *Recorder) RecordFlow(policy string, allowed bool, destIP string, port int) {
+	r.RecordFlows(policy, allowed, destIP, port, 1)
+}
+
+// RecordFlows counts n identical flows for policy, e.g. an aggregated
+// enforcement log entry
+func (r *Recorder) RecordFlows(policy string, allowed bool, destIP string, port int, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b := r.bucket()
+	counts, ok := b.Policies[policy]
+	if !ok {
+		counts = &Counts{}
+		b.Policies[policy] = counts
+	}
+	if allowed {
+		counts.Allowed += int64(n)
+		return
+	}
+	counts.Blocked += int64(n)
+	b.BlockedDestinations[fmt.Sprintf("%s:%d", destIP, port)] += int64(n)
+	b.policyBlocks(policy)[r.slot(b)] += int64(n)
+}
+
+// RecordRuleHit counts an allowed flow to destIP:port under policy, so rules
+// that stop matching traffic can be found (see RuleHits)
+func (r *Recorder) RecordRuleHit(policy, protocol, destIP string, port int) {
+	r.RecordRuleHits(policy, protocol, destIP, port, 1)
+}
+
+// RecordRuleHits counts n identical allowed flows as rule hits
+func (r *Recorder) RecordRuleHits(policy, protocol, destIP string, port int, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bucket().RuleHits[ruleHitKey(policy, protocol, destIP, port)] += int64(n)
+}
+
+// RecordAnomaly counts a detected anomaly
+func (r *Recorder) RecordAnomaly() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bucket().Anomalies++
+}
+
+// RecordAnomalyScore counts a detected anomaly of service and its score
+func (r *Recorder) RecordAnomalyScore(service string, score float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.bucket()
+	b.Anomalies++
+	b.anomalyScores(service)[r.slot(b)].add(Score{Count: 1, Sum: score, Max: score})
+}
+
+// RecordEvents counts anomalies published on bus, with their scores by
+// source service, until stop is called. Replayed events were already
+// counted by the process that published them.
+func (r *Recorder) RecordEvents(bus *events.Bus) (stop func()) {
+	return bus.SubscribeFunc(func(event events.Event) {
+		if event.Replayed {
+			return
+		}
+		data, ok := event.Data.(events.AnomalyDetected)
+		if !ok {
+			r.RecordAnomaly()
+			return
+		}
+		service := data.SourceService
+		if service == "" {
+			service = data.SourceIP
+		}
+		r.RecordAnomalyScore(service, data.Score)
+	}, events.TopicAnomalyDetected)
+}
+
+// Flush merges pending statistics into the file and clears them. The file
+// is locked for the read-merge-write so concurrent processes do not lose each
+// other's counts, and replaced atomically so readers never see a partial
+// write.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return fmt.Errorf("failed to create stats directory: %w", err)
+	}
+	unlock, err := lockFile(r.path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	buckets, err := Load(r.path)
+	if err != nil {
+		return err
+	}
+
+	byHour := make(map[int64]*Bucket, len(buckets))
+	for _, b := range buckets {
+		byHour[b.Hour.Unix()] = b
+	}
+	for hour, b := range r.pending {
+		existing, ok := byHour[hour]
+		if !ok {
+			existing = newBucket(b.Hour)
+			byHour[hour] = existing
+			buckets = append(buckets, existing)
+		}
+		existing.merge(b)
+	}
+	sortBuckets(buckets)
+
+	if err := save(r.path, buckets); err != nil {
+		return err
+	}
+	r.pending = make(map[int64]*Bucket)
+	return nil
+}
+
+// Run flushes every interval until ctx is cancelled, then flushes once more
+// and returns that flush's error. Failed flushes keep their statistics
+// pending, are logged and retried on the next tick.
+func (r *Recorder) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.Flush(); err != nil {
+				log.Printf("Warning: Failed to save statistics: %v", err)
+			}
+		case <-ctx.Done():
+			return r.Flush()
+		}
+	}
+}
+
+// Load reads persisted hourly buckets from path, oldest first. A missing
+// file yields no buckets.
+func Load(path string) ([]*Bucket, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats: %w", err)
+	}
+
+	var buckets []*Bucket
+	if err := json.Unmarshal(data, &buckets); err != nil {
+		return nil, fmt.Errorf("failed to parse stats %s: %w", path, err)
+	}
+	sortBuckets(buckets)
+	return buckets, nil
+}
+
+// Prune removes the buckets of hours before cutoff and then, while the file
+// is larger than maxSize bytes (if positive), the oldest remaining buckets.
+// It returns how many buckets were removed and the bytes reclaimed.
+func Prune(path string, cutoff time.Time, maxSize int64) (removed int, reclaimed int64, err error) {
+	unlock, err := lockFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read stats: %w", err)
+	}
+	buckets, err := Load(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	start := 0
+	for start < len(buckets) && buckets[start].Hour.Before(cutoff) {
+		start++
+	}
+	if maxSize > 0 {
+		// Approximates the saved size from each bucket's own encoding
+		var size int64
+		for _, b := range buckets[start:] {
+			data, _ := json.MarshalIndent(b, "  ", "  ")
+			size += int64(len(data)) + 4
+		}
+		for start < len(buckets) && size > maxSize {
+			data, _ := json.MarshalIndent(buckets[start], "  ", "  ")
+			size -= int64(len(data)) + 4
+			start++
+		}
+	}
+	if start == 0 {
+		return 0, 0, nil
+	}
+
+	if err := save(path, buckets[start:]); err != nil {
+		return 0, 0, err
+	}
+	if after, err := os.Stat(path); err == nil {
+		reclaimed = info.Size() - after.Size()
+	}
+	return start, reclaimed, nil
+}
+
+// Lock file timing: Flush waits up to lockTimeout for another process, and
+// removes a lock older than staleLockAge, left by a process that crashed
+const (
+	lockTimeout  = 10 * time.Second
+	staleLockAge = time.Minute
+)
+
+// lockFile creates path.lock exclusively and returns a function removing it
+func lockFile(path string) (unlock func(), err error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to lock stats: %w", err)
+		}
+
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for stats lock %s", lockPath)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// save writes buckets to a temporary file and renames it over path
+func save(path string, buckets []*Bucket) error {
+	data, err := json.MarshalIndent(buckets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save stats: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save stats: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save stats: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func sortBuckets(buckets []*Bucket) {
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Hour.Before(buckets[j].Hour)
+	})
+}
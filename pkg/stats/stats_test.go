@@ -0,0 +1,270 @@
+package stats
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"ztap/pkg/events"
+)
+
+func newTestRecorder(t *testing.T, now *time.Time) (*Recorder, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stats.json")
+	r := NewRecorder(path)
+	r.now = func() time.Time { return *now }
+	return r, path
+}
+
+func TestRecorderAggregatesHourly(t *testing.T) {
+	now := time.Date(2025, 10, 1, 9, 15, 0, 0, time.UTC)
+	r, path := newTestRecorder(t, &now)
+
+	r.RecordFlow("web-to-db", true, "10.0.0.2", 5432)
+	r.RecordFlow("web-to-db", false, "10.0.0.3", 5432)
+	r.RecordFlow("web-to-db", false, "10.0.0.3", 5432)
+	now = now.Add(time.Hour)
+	r.RecordFlow("deny-ssh", false, "10.0.0.4", 22)
+	r.RecordAnomaly()
+
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	buckets, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(buckets) != 2 {
+		t.Fatalf("Expected 2 hourly buckets, got %d", len(buckets))
+	}
+
+	first := buckets[0]
+	if !first.Hour.Equal(time.Date(2025, 10, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected first bucket at 09:00, got %v", first.Hour)
+	}
+	if c := first.Policies["web-to-db"]; c.Allowed != 1 || c.Blocked != 2 {
+		t.Errorf("Unexpected counts: %+v", c)
+	}
+	if first.BlockedDestinations["10.0.0.3:5432"] != 2 {
+		t.Errorf("Expected 2 blocks to 10.0.0.3:5432, got %v", first.BlockedDestinations)
+	}
+	if buckets[1].Anomalies != 1 {
+		t.Errorf("Expected 1 anomaly in second bucket, got %d", buckets[1].Anomalies)
+	}
+}
+
+func TestRecorderFlushMerges(t *testing.T) {
+	now := time.Date(2025, 10, 1, 9, 0, 0, 0, time.UTC)
+	r, path := newTestRecorder(t, &now)
+
+	r.RecordFlow("web-to-db", false, "10.0.0.3", 5432)
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// A second process recording into the same hour adds to the stored counts
+	other := NewRecorder(path)
+	other.now = r.now
+	other.RecordFlow("web-to-db", false, "10.0.0.3", 5432)
+	if err := other.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	buckets, _ := Load(path)
+	if len(buckets) != 1 {
+		t.Fatalf("Expected 1 bucket, got %d", len(buckets))
+	}
+	if got := buckets[0].Policies["web-to-db"].Blocked; got != 2 {
+		t.Errorf("Expected 2 blocked after merge, got %d", got)
+	}
+
+	// Flushing with nothing pending leaves the file unchanged
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	buckets, _ = Load(path)
+	if got := buckets[0].Policies["web-to-db"].Blocked; got != 2 {
+		t.Errorf("Expected empty flush to keep 2 blocked, got %d", got)
+	}
+}
+
+func TestRecorderFlushConcurrent(t *testing.T) {
+	now := time.Date(2025, 10, 1, 9, 0, 0, 0, time.UTC)
+	_, path := newTestRecorder(t, &now)
+
+	// Recorders with separate memory, as in separate processes
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := NewRecorder(path)
+			r.now = func() time.Time { return now }
+			r.RecordFlow("web-to-db", false, "10.0.0.3", 5432)
+			errs <- r.Flush()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+	}
+
+	buckets, _ := Load(path)
+	if len(buckets) != 1 || buckets[0].Policies["web-to-db"].Blocked != writers {
+		t.Errorf("Expected %d blocked across writers, got %+v", writers, buckets)
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("Expected lock to be released, got %v", err)
+	}
+}
+
+func TestRecorderFlushStaleLock(t *testing.T) {
+	now := time.Date(2025, 10, 1, 9, 0, 0, 0, time.UTC)
+	r, path := newTestRecorder(t, &now)
+
+	// Left behind by a crashed process
+	if err := os.WriteFile(path+".lock", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * staleLockAge)
+	os.Chtimes(path+".lock", old, old)
+
+	r.RecordFlow("web-to-db", true, "10.0.0.2", 5432)
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Expected stale lock to be removed, got %v", err)
+	}
+}
+
+func TestRecorderRunContinuesAfterFlushError(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "file")
+	os.WriteFile(blocker, nil, 0600)
+
+	// The parent of the stats file is a regular file, so every flush fails
+	r := NewRecorder(filepath.Join(blocker, "stats.json"))
+	r.RecordFlow("web-to-db", true, "10.0.0.2", 5432)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Run(ctx, time.Millisecond) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned on a failed flush: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-done; err == nil {
+		t.Error("Expected the final flush error to be returned")
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	buckets, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("Expected no error for missing file, got %v", err)
+	}
+	if len(buckets) != 0 {
+		t.Errorf("Expected no buckets, got %d", len(buckets))
+	}
+}
+
+func TestRecorderRecordEvents(t *testing.T) {
+	now := time.Date(2025, 10, 1, 9, 0, 0, 0, time.UTC)
+	r, path := newTestRecorder(t, &now)
+	bus := events.NewBus()
+
+	stop := r.RecordEvents(bus)
+	bus.Publish(events.TopicAnomalyDetected, events.AnomalyDetected{Score: 90})
+	bus.Publish(events.TopicFlowBlocked, events.FlowBlocked{})
+	bus.Replay(events.Event{Topic: events.TopicAnomalyDetected, Data: events.AnomalyDetected{Score: 90}})
+	stop()
+	bus.Publish(events.TopicAnomalyDetected, events.AnomalyDetected{Score: 90})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.Run(ctx, time.Hour); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	buckets, _ := Load(path)
+	if len(buckets) != 1 || buckets[0].Anomalies != 1 {
+		t.Errorf("Expected 1 anomaly recorded before stop, got %+v", buckets)
+	}
+}
+
+func TestRuleHits(t *testing.T) {
+	now := time.Date(2025, 10, 1, 9, 15, 0, 0, time.UTC)
+	r, path := newTestRecorder(t, &now)
+
+	r.RecordRuleHit("web-to-db", "tcp", "10.0.0.2", 5432)
+	now = now.Add(2 * time.Hour)
+	r.RecordRuleHit("web-to-db", "TCP", "10.0.0.2", 5432)
+	r.RecordRuleHit("dns", "UDP", "fd00::53", 53)
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	buckets, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	hits := RuleHits(buckets, time.Time{})
+	if len(hits) != 2 {
+		t.Fatalf("Expected 2 rule hits, got %+v", hits)
+	}
+	if hits[0].Policy != "dns" || hits[0].IP != "fd00::53" || hits[0].Port != 53 {
+		t.Errorf("Expected IPv6 destination to round-trip, got %+v", hits[0])
+	}
+	web := hits[1]
+	if web.Count != 2 || web.Protocol != "TCP" || !web.LastHour.Equal(time.Date(2025, 10, 1, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected web-to-db hit: %+v", web)
+	}
+
+	if hits := RuleHits(buckets, now); len(hits) != 2 || hits[1].Count != 1 {
+		t.Errorf("Expected only hits since the last hour, got %+v", hits)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2025, 10, 1, 9, 15, 0, 0, time.UTC)
+	r, path := newTestRecorder(t, &now)
+	for i := 0; i < 4; i++ {
+		r.RecordFlow("web-to-db", false, "10.0.0.3", 5432)
+		now = now.Add(time.Hour)
+	}
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	// Hours before 10:00 are too old
+	removed, reclaimed, err := Prune(path, time.Date(2025, 10, 1, 10, 0, 0, 0, time.UTC), 0)
+	if err != nil || removed != 1 || reclaimed <= 0 {
+		t.Fatalf("Expected 1 bucket pruned by age, got %d (%d bytes), %v", removed, reclaimed, err)
+	}
+
+	// A size for about one bucket keeps the newest
+	info, _ := os.Stat(path)
+	removed, _, err = Prune(path, time.Time{}, info.Size()/3)
+	if err != nil || removed != 2 {
+		t.Fatalf("Expected 2 buckets pruned by size, got %d, %v", removed, err)
+	}
+	buckets, _ := Load(path)
+	if len(buckets) != 1 || !buckets[0].Hour.Equal(time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected only the 12:00 bucket kept, got %+v", buckets)
+	}
+
+	if removed, _, err := Prune(filepath.Join(t.TempDir(), "missing.json"), now, 0); err != nil || removed != 0 {
+		t.Errorf("Expected nothing pruned without a stats file, got %d, %v", removed, err)
+	}
+}
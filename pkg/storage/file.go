@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"ztap/pkg/auth"
+)
+
+// FilePolicyStore keeps policy documents in a JSON file. Every write re-reads
+// the file first, so changes made by other ztap processes on the host are
+// kept. Each tenant's policies are kept in a file of their own (see
+// tenantPath).
+type FilePolicyStore struct {
+	mu   sync.Mutex
+	path string
+	now  func() time.Time
+}
+
+// NewFilePolicyStore creates a policy store backed by path
+func NewFilePolicyStore(path string) *FilePolicyStore {
+	return &FilePolicyStore{path: path, now: time.Now}
+}
+
+// ListPolicies returns every stored policy in name order
+func (s *FilePolicyStore) ListPolicies(ctx context.Context) ([]PolicyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	policies, err := s.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]PolicyRecord, 0, len(policies))
+	for _, record := range policies {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records, nil
+}
+
+// GetPolicy returns the named policy
+func (s *FilePolicyStore) GetPolicy(ctx context.Context, name string) (PolicyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	policies, err := s.read(ctx)
+	if err != nil {
+		return PolicyRecord{}, err
+	}
+	record, exists := policies[name]
+	if !exists {
+		return PolicyRecord{}, ErrPolicyNotFound
+	}
+	return record, nil
+}
+
+// PutPolicy creates or replaces the named policy
+func (s *FilePolicyStore) PutPolicy(ctx context.Context, name, yaml, updatedBy string) (PolicyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	policies, err := s.read(ctx)
+	if err != nil {
+		return PolicyRecord{}, err
+	}
+	record := PolicyRecord{
+		Name:      name,
+		YAML:      yaml,
+		Version:   policies[name].Version + 1,
+		UpdatedBy: updatedBy,
+		UpdatedAt: s.now().UTC(),
+	}
+	policies[name] = record
+	return record, s.write(ctx, policies)
+}
+
+// DeletePolicy removes the named policy
+func (s *FilePolicyStore) DeletePolicy(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	policies, err := s.read(ctx)
+	if err != nil {
+		return err
+	}
+	if _, exists := policies[name]; !exists {
+		return ErrPolicyNotFound
+	}
+	delete(policies, name)
+	return s.write(ctx, policies)
+}
+
+// read loads the file of the context's tenant; a missing file has no
+// policies (requires mu)
+func (s *FilePolicyStore) read(ctx context.Context) (map[string]PolicyRecord, error) {
+	path, err := tenantPath(s.path, ctx)
+	if err != nil {
+		return nil, err
+	}
+	policies := make(map[string]PolicyRecord)
+	return policies, readFile(path, &policies)
+}
+
+// write saves the file of the context's tenant (requires mu)
+func (s *FilePolicyStore) write(ctx context.Context, policies map[string]PolicyRecord) error {
+	path, err := tenantPath(s.path, ctx)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, policies)
+}
+
+// FilePendingStore keeps pending policy changes in a JSON file, re-read on
+// every access like FilePolicyStore
+type FilePendingStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFilePendingStore creates a pending change store backed by path
+func NewFilePendingStore(path string) *FilePendingStore {
+	return &FilePendingStore{path: path}
+}
+
+// ListPending returns every pending change, oldest first
+func (s *FilePendingStore) ListPending(ctx context.Context) ([]PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, err := s.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]PendingChange, 0, len(pending))
+	for _, change := range pending {
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].RequestedAt.Before(changes[j].RequestedAt) })
+	return changes, nil
+}
+
+// GetPending returns the pending change with id
+func (s *FilePendingStore) GetPending(ctx context.Context, id string) (PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, err := s.read(ctx)
+	if err != nil {
+		return PendingChange{}, err
+	}
+	change, exists := pending[id]
+	if !exists {
+		return PendingChange{}, ErrChangeNotFound
+	}
+	return change, nil
+}
+
+// AddPending stores a new pending change
+func (s *FilePendingStore) AddPending(ctx context.Context, change PendingChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, err := s.read(ctx)
+	if err != nil {
+		return err
+	}
+	pending[change.ID] = change
+	return s.write(ctx, pending)
+}
+
+// TakePending removes and returns the pending change with id
+func (s *FilePendingStore) TakePending(ctx context.Context, id string) (PendingChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, err := s.read(ctx)
+	if err != nil {
+		return PendingChange{}, err
+	}
+	change, exists := pending[id]
+	if !exists {
+		return PendingChange{}, ErrChangeNotFound
+	}
+	delete(pending, id)
+	return change, s.write(ctx, pending)
+}
+
+// read loads the file of the context's tenant; a missing file has no
+// changes (requires mu)
+func (s *FilePendingStore) read(ctx context.Context) (map[string]PendingChange, error) {
+	path, err := tenantPath(s.path, ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := make(map[string]PendingChange)
+	return pending, readFile(path, &pending)
+}
+
+// write saves the file of the context's tenant (requires mu)
+func (s *FilePendingStore) write(ctx context.Context, pending map[string]PendingChange) error {
+	path, err := tenantPath(s.path, ctx)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, pending)
+}
+
+// tenantPath returns where the tenant of ctx (auth.WithTenant) keeps the file
+// at path: path itself without a tenant, else tenants/<tenant>/ next to it
+func tenantPath(path string, ctx context.Context) (string, error) {
+	tenant := auth.TenantFromContext(ctx)
+	if err := auth.ValidateTenant(tenant); err != nil {
+		return "", err
+	}
+	if tenant == "" {
+		return path, nil
+	}
+	return filepath.Join(filepath.Dir(path), "tenants", tenant, filepath.Base(path)), nil
+}
+
+// readFile decodes the JSON file at path into v, leaving v unchanged if the
+// file does not exist
+func readFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeFile saves v as JSON to path, readable only by the owner
+func writeFile(path string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ztap/pkg/auth"
+)
+
+func TestFilePolicyStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "policies.json")
+	store := NewFilePolicyStore(path)
+
+	if _, err := store.GetPolicy(ctx, "web"); err != ErrPolicyNotFound {
+		t.Errorf("Expected ErrPolicyNotFound, got %v", err)
+	}
+
+	if _, err := store.PutPolicy(ctx, "web", "v1", "alice"); err != nil {
+		t.Fatalf("PutPolicy failed: %v", err)
+	}
+	record, err := store.PutPolicy(ctx, "web", "v2", "bob")
+	if err != nil {
+		t.Fatalf("PutPolicy failed: %v", err)
+	}
+	if record.Version != 2 || record.UpdatedBy != "bob" {
+		t.Errorf("Expected version 2 by bob, got %+v", record)
+	}
+
+	// Another process sees the same policies
+	if _, err := NewFilePolicyStore(path).PutPolicy(ctx, "db", "v1", "alice"); err != nil {
+		t.Fatalf("PutPolicy failed: %v", err)
+	}
+	records, err := store.ListPolicies(ctx)
+	if err != nil {
+		t.Fatalf("ListPolicies failed: %v", err)
+	}
+	if len(records) != 2 || records[0].Name != "db" || records[1].YAML != "v2" {
+		t.Errorf("Unexpected policies %+v", records)
+	}
+
+	if err := store.DeletePolicy(ctx, "web"); err != nil {
+		t.Fatalf("DeletePolicy failed: %v", err)
+	}
+	if err := store.DeletePolicy(ctx, "web"); err != ErrPolicyNotFound {
+		t.Errorf("Expected ErrPolicyNotFound, got %v", err)
+	}
+}
+
+func TestFilePendingStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "pending.json")
+	store := NewFilePendingStore(path)
+
+	start := time.Date(2025, 10, 31, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"b2", "a1"} {
+		change := PendingChange{ID: id, Policy: "ssh", Reasons: []string{"port 22"}, RequestedBy: "alice", RequestedAt: start.Add(time.Duration(i) * time.Minute)}
+		if err := store.AddPending(ctx, change); err != nil {
+			t.Fatalf("AddPending failed: %v", err)
+		}
+	}
+
+	// Another process sees the same changes, oldest first
+	changes, err := NewFilePendingStore(path).ListPending(ctx)
+	if err != nil {
+		t.Fatalf("ListPending failed: %v", err)
+	}
+	if len(changes) != 2 || changes[0].ID != "b2" || changes[1].Reasons[0] != "port 22" {
+		t.Errorf("Unexpected pending changes %+v", changes)
+	}
+
+	change, err := store.TakePending(ctx, "a1")
+	if err != nil || change.ID != "a1" {
+		t.Fatalf("TakePending = %+v, %v", change, err)
+	}
+	if _, err := store.TakePending(ctx, "a1"); err != ErrChangeNotFound {
+		t.Errorf("Expected ErrChangeNotFound, got %v", err)
+	}
+	if _, err := store.GetPending(ctx, "a1"); err != ErrChangeNotFound {
+		t.Errorf("Expected ErrChangeNotFound, got %v", err)
+	}
+	if _, err := store.GetPending(ctx, "b2"); err != nil {
+		t.Errorf("GetPending failed: %v", err)
+	}
+}
+
+func TestFileStoresByTenant(t *testing.T) {
+	dir := t.TempDir()
+	policies := NewFilePolicyStore(filepath.Join(dir, "policies.json"))
+	pending := NewFilePendingStore(filepath.Join(dir, "pending.json"))
+	acme := auth.WithTenant(context.Background(), "acme")
+
+	if _, err := policies.PutPolicy(acme, "web", "v1", "ann"); err != nil {
+		t.Fatalf("PutPolicy failed: %v", err)
+	}
+	if err := pending.AddPending(acme, PendingChange{ID: "abc", Policy: "web"}); err != nil {
+		t.Fatalf("AddPending failed: %v", err)
+	}
+
+	// Other tenants and records without a tenant are kept apart
+	for _, ctx := range []context.Context{context.Background(), auth.WithTenant(context.Background(), "globex")} {
+		if _, err := policies.GetPolicy(ctx, "web"); err != ErrPolicyNotFound {
+			t.Errorf("Expected ErrPolicyNotFound outside acme, got %v", err)
+		}
+		if _, err := pending.TakePending(ctx, "abc"); err != ErrChangeNotFound {
+			t.Errorf("Expected ErrChangeNotFound outside acme, got %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tenants", "acme", "policies.json")); err != nil {
+		t.Errorf("Expected acme's policies in their own file: %v", err)
+	}
+
+	if _, err := policies.ListPolicies(auth.WithTenant(context.Background(), "../acme")); err != auth.ErrInvalidTenant {
+		t.Errorf("Expected ErrInvalidTenant, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+-- Users, sessions and policies shared by ZTAP API server replicas
+
+CREATE TABLE ztap_users (
+    username      TEXT PRIMARY KEY,
+    password_hash TEXT NOT NULL,
+    role          TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL,
+    last_login    TIMESTAMPTZ,
+    enabled       BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+-- Keyed by a SHA-256 hash of the session token; tokens are never stored
+CREATE TABLE ztap_sessions (
+    key        TEXT PRIMARY KEY,
+    username   TEXT NOT NULL REFERENCES ztap_users (username) ON DELETE CASCADE,
+    role       TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX ztap_sessions_expires_at ON ztap_sessions (expires_at);
+
+CREATE TABLE ztap_policies (
+    name       TEXT PRIMARY KEY,
+    yaml       TEXT NOT NULL,
+    version    BIGINT NOT NULL,
+    updated_by TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
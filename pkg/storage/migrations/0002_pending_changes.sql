@@ -0,0 +1,10 @@
+-- Policy changes held until a second admin approves them
+
+CREATE TABLE ztap_pending_changes (
+    id           TEXT PRIMARY KEY,
+    policy       TEXT NOT NULL,
+    yaml         TEXT NOT NULL,
+    reasons      TEXT NOT NULL, -- JSON array
+    requested_by TEXT NOT NULL,
+    requested_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,13 @@
+-- Tenants (organizations) sharing the API servers; '' is no tenant, used by
+-- the users operating the deployment and by single-tenant installs
+
+ALTER TABLE ztap_users ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+ALTER TABLE ztap_sessions ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+
+-- Policy names are unique per tenant
+ALTER TABLE ztap_policies ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+ALTER TABLE ztap_policies DROP CONSTRAINT ztap_policies_pkey;
+ALTER TABLE ztap_policies ADD PRIMARY KEY (tenant, name);
+
+ALTER TABLE ztap_pending_changes ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+CREATE INDEX ztap_pending_changes_tenant ON ztap_pending_changes (tenant, requested_at);
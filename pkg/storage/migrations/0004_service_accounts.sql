@@ -0,0 +1,7 @@
+-- Node service accounts: the node a user or session acts for and the labels
+-- selecting its policies, as a JSON object; '' and '{}' for everyone else
+
+ALTER TABLE ztap_users ADD COLUMN node TEXT NOT NULL DEFAULT '';
+ALTER TABLE ztap_users ADD COLUMN labels TEXT NOT NULL DEFAULT '{}';
+ALTER TABLE ztap_sessions ADD COLUMN node TEXT NOT NULL DEFAULT '';
+ALTER TABLE ztap_sessions ADD COLUMN labels TEXT NOT NULL DEFAULT '{}';
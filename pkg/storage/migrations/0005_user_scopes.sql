@@ -0,0 +1,5 @@
+-- Label scopes limiting what a user sees, as a JSON array of label objects;
+-- '[]' for users seeing everything
+
+ALTER TABLE ztap_users ADD COLUMN scopes TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE ztap_sessions ADD COLUMN scopes TEXT NOT NULL DEFAULT '[]';
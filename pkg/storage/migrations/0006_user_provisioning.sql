@@ -0,0 +1,5 @@
+-- Identity provider state of users provisioned through SCIM: the provider's
+-- ID for the user and its groups, as a JSON array; '' and '[]' for others
+
+ALTER TABLE ztap_users ADD COLUMN external_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE ztap_users ADD COLUMN groups TEXT NOT NULL DEFAULT '[]';
@@ -0,0 +1,3 @@
+-- Whether a user was created through SCIM, which manages no other users
+
+ALTER TABLE ztap_users ADD COLUMN provisioned BOOLEAN NOT NULL DEFAULT false;
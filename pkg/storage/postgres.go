@@ -0,0 +1,461 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ztap/pkg/auth"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the pg_advisory_lock key serializing migrations across
+// replicas starting together ("ztap")
+const migrationLockID = 0x7a746170
+
+// PostgresStore keeps users, sessions, policies and pending policy changes in
+// PostgreSQL. It implements auth.Store, PolicyStore and PendingStore.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// OpenPostgres connects through the database/sql driver registered as
+// config.Driver (default "postgres") and applies pending migrations. ztap
+// does not link a PostgreSQL driver itself; builds using this backend
+// register one, e.g. with a blank import of github.com/lib/pq.
+func OpenPostgres(ctx context.Context, config PostgresConfig) (*PostgresStore, error) {
+	driver := config.Driver
+	if driver == "" {
+		driver = "postgres"
+	}
+	if !slices.Contains(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("no database/sql driver registered as %q; this ztap build has no PostgreSQL driver", driver)
+	}
+
+	db, err := sql.Open(driver, config.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to postgres: %w", err)
+	}
+
+	store := NewPostgresStore(db)
+	if err := store.Migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// NewPostgresStore wraps an open database; call Migrate before use
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Close closes the database
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// migration is one embedded schema change
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+// migrations returns the embedded migrations in version order. Files are
+// named NNNN_description.sql.
+func migrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var out []migration
+	for _, entry := range entries {
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: name must start with a version number", entry.Name())
+		}
+		data, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, migration{version: version, name: entry.Name(), sql: string(data)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].version < out[j].version })
+	return out, nil
+}
+
+// Migrate applies the embedded migrations not yet recorded in
+// ztap_schema_migrations, each in its own transaction. An advisory lock keeps
+// replicas starting together from applying the same migration twice.
+func (s *PostgresStore) Migrate(ctx context.Context) error {
+	pending, err := migrations()
+	if err != nil {
+		return err
+	}
+
+	// Advisory locks belong to a database session, so pin one connection
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("locking migrations: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS ztap_schema_migrations (
+	version    INTEGER PRIMARY KEY,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`); err != nil {
+		return fmt.Errorf("creating migrations table: %w", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM ztap_schema_migrations")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range pending {
+		if applied[m.version] {
+			continue
+		}
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO ztap_schema_migrations (version) VALUES ($1)", m.version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// Users returns every user
+func (s *PostgresStore) Users() (map[string]*auth.User, error) {
+	rows, err := s.db.Query(`SELECT username, password_hash, role, created_at, last_login, enabled, tenant, node, labels, scopes, external_id, groups, provisioned FROM ztap_users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make(map[string]*auth.User)
+	for rows.Next() {
+		var user auth.User
+		var role string
+		var lastLogin sql.NullTime
+		var labels, scopes, groups string
+		if err := rows.Scan(&user.Username, &user.PasswordHash, &role, &user.CreatedAt, &lastLogin, &user.Enabled, &user.Tenant, &user.Node, &labels, &scopes, &user.ExternalID, &groups, &user.Provisioned); err != nil {
+			return nil, err
+		}
+		user.Role = auth.Role(role)
+		user.LastLogin = lastLogin.Time
+		if user.Labels, err = decodeLabels(labels); err != nil {
+			return nil, fmt.Errorf("user %s: %w", user.Username, err)
+		}
+		if user.Scopes, err = decodeScopes(scopes); err != nil {
+			return nil, fmt.Errorf("user %s: %w", user.Username, err)
+		}
+		if err := json.Unmarshal([]byte(groups), &user.Groups); err != nil {
+			return nil, fmt.Errorf("user %s: invalid groups: %w", user.Username, err)
+		}
+		users[user.Username] = &user
+	}
+	return users, rows.Err()
+}
+
+// PutUser creates or replaces a user. A service account provisioned again
+// also replaces its creation time, tenant, node and labels.
+func (s *PostgresStore) PutUser(user *auth.User) error {
+	lastLogin := sql.NullTime{Time: user.LastLogin, Valid: !user.LastLogin.IsZero()}
+	labels, err := encodeLabels(user.Labels)
+	if err != nil {
+		return err
+	}
+	scopes, err := encodeScopes(user.Scopes)
+	if err != nil {
+		return err
+	}
+	groups := []byte("[]")
+	if len(user.Groups) > 0 {
+		if groups, err = json.Marshal(user.Groups); err != nil {
+			return err
+		}
+	}
+	_, err = s.db.Exec(`INSERT INTO ztap_users (username, password_hash, role, created_at, last_login, enabled, tenant, node, labels, scopes, external_id, groups, provisioned)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+ON CONFLICT (username) DO UPDATE SET
+	password_hash = EXCLUDED.password_hash,
+	role = EXCLUDED.role,
+	created_at = EXCLUDED.created_at,
+	last_login = EXCLUDED.last_login,
+	enabled = EXCLUDED.enabled,
+	tenant = EXCLUDED.tenant,
+	node = EXCLUDED.node,
+	labels = EXCLUDED.labels,
+	scopes = EXCLUDED.scopes,
+	external_id = EXCLUDED.external_id,
+	groups = EXCLUDED.groups,
+	provisioned = EXCLUDED.provisioned`,
+		user.Username, user.PasswordHash, string(user.Role), user.CreatedAt, lastLogin, user.Enabled, user.Tenant, user.Node, labels, scopes, user.ExternalID, string(groups), user.Provisioned)
+	return err
+}
+
+// Session returns the unexpired session stored under key
+func (s *PostgresStore) Session(key string) (*auth.Session, error) {
+	var session auth.Session
+	var role, labels, scopes string
+	err := s.db.QueryRow(`SELECT username, role, tenant, node, labels, scopes, created_at, expires_at FROM ztap_sessions
+WHERE key = $1 AND expires_at > now()`, key).
+		Scan(&session.Username, &role, &session.Tenant, &session.Node, &labels, &scopes, &session.CreatedAt, &session.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, auth.ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.Role = auth.Role(role)
+	if session.Labels, err = decodeLabels(labels); err != nil {
+		return nil, err
+	}
+	if session.Scopes, err = decodeScopes(scopes); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// PutSession stores a session under key. Expired sessions are dropped.
+func (s *PostgresStore) PutSession(key string, session *auth.Session) error {
+	if _, err := s.db.Exec(`DELETE FROM ztap_sessions WHERE expires_at <= now()`); err != nil {
+		return err
+	}
+	labels, err := encodeLabels(session.Labels)
+	if err != nil {
+		return err
+	}
+	scopes, err := encodeScopes(session.Scopes)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO ztap_sessions (key, username, role, tenant, node, labels, scopes, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (key) DO UPDATE SET expires_at = EXCLUDED.expires_at`,
+		key, session.Username, string(session.Role), session.Tenant, session.Node, labels, scopes, session.CreatedAt, session.ExpiresAt)
+	return err
+}
+
+// encodeLabels stores the labels of a service account as a JSON object
+func encodeLabels(labels map[string]string) (string, error) {
+	if len(labels) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(labels)
+	return string(data), err
+}
+
+// decodeLabels reads labels stored by encodeLabels, nil if there are none
+func decodeLabels(data string) (map[string]string, error) {
+	var labels map[string]string
+	if err := json.Unmarshal([]byte(data), &labels); err != nil {
+		return nil, fmt.Errorf("invalid labels: %w", err)
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels, nil
+}
+
+// encodeScopes stores the scopes of a user as a JSON array
+func encodeScopes(scopes []auth.Scope) (string, error) {
+	if len(scopes) == 0 {
+		return "[]", nil
+	}
+	data, err := json.Marshal(scopes)
+	return string(data), err
+}
+
+// decodeScopes reads scopes stored by encodeScopes, nil if there are none
+func decodeScopes(data string) ([]auth.Scope, error) {
+	var scopes []auth.Scope
+	if err := json.Unmarshal([]byte(data), &scopes); err != nil {
+		return nil, fmt.Errorf("invalid scopes: %w", err)
+	}
+	if len(scopes) == 0 {
+		return nil, nil
+	}
+	return scopes, nil
+}
+
+// DeleteSession removes the session stored under key
+func (s *PostgresStore) DeleteSession(key string) error {
+	_, err := s.db.Exec(`DELETE FROM ztap_sessions WHERE key = $1`, key)
+	return err
+}
+
+// ListPolicies returns every policy stored for the context's tenant
+// (auth.WithTenant) in name order. Every policy and pending change query is
+// scoped to the tenant.
+func (s *PostgresStore) ListPolicies(ctx context.Context) ([]PolicyRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, yaml, version, updated_by, updated_at FROM ztap_policies
+WHERE tenant = $1 ORDER BY name`, auth.TenantFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []PolicyRecord
+	for rows.Next() {
+		var record PolicyRecord
+		if err := rows.Scan(&record.Name, &record.YAML, &record.Version, &record.UpdatedBy, &record.UpdatedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// GetPolicy returns the named policy
+func (s *PostgresStore) GetPolicy(ctx context.Context, name string) (PolicyRecord, error) {
+	var record PolicyRecord
+	err := s.db.QueryRowContext(ctx, `SELECT name, yaml, version, updated_by, updated_at FROM ztap_policies
+WHERE tenant = $1 AND name = $2`, auth.TenantFromContext(ctx), name).
+		Scan(&record.Name, &record.YAML, &record.Version, &record.UpdatedBy, &record.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PolicyRecord{}, ErrPolicyNotFound
+	}
+	return record, err
+}
+
+// PutPolicy creates or replaces the named policy. The version is incremented
+// in the same statement, so concurrent writers from several replicas never
+// reuse a version.
+func (s *PostgresStore) PutPolicy(ctx context.Context, name, yaml, updatedBy string) (PolicyRecord, error) {
+	record := PolicyRecord{Name: name, YAML: yaml, UpdatedBy: updatedBy, UpdatedAt: time.Now().UTC()}
+	err := s.db.QueryRowContext(ctx, `INSERT INTO ztap_policies (tenant, name, yaml, version, updated_by, updated_at)
+VALUES ($1, $2, $3, 1, $4, $5)
+ON CONFLICT (tenant, name) DO UPDATE SET
+	yaml = EXCLUDED.yaml,
+	version = ztap_policies.version + 1,
+	updated_by = EXCLUDED.updated_by,
+	updated_at = EXCLUDED.updated_at
+RETURNING version`, auth.TenantFromContext(ctx), name, yaml, updatedBy, record.UpdatedAt).Scan(&record.Version)
+	return record, err
+}
+
+// DeletePolicy removes the named policy
+func (s *PostgresStore) DeletePolicy(ctx context.Context, name string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM ztap_policies WHERE tenant = $1 AND name = $2`, auth.TenantFromContext(ctx), name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrPolicyNotFound
+	}
+	return err
+}
+
+// ListPending returns every pending change, oldest first
+func (s *PostgresStore) ListPending(ctx context.Context) ([]PendingChange, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, policy, yaml, reasons, requested_by, requested_at FROM ztap_pending_changes
+WHERE tenant = $1 ORDER BY requested_at`, auth.TenantFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []PendingChange
+	for rows.Next() {
+		change, err := scanPending(rows)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
+
+// GetPending returns the pending change with id
+func (s *PostgresStore) GetPending(ctx context.Context, id string) (PendingChange, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT id, policy, yaml, reasons, requested_by, requested_at FROM ztap_pending_changes
+WHERE tenant = $1 AND id = $2`, auth.TenantFromContext(ctx), id)
+	change, err := scanPending(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PendingChange{}, ErrChangeNotFound
+	}
+	return change, err
+}
+
+// AddPending stores a new pending change
+func (s *PostgresStore) AddPending(ctx context.Context, change PendingChange) error {
+	reasons, err := json.Marshal(change.Reasons)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT INTO ztap_pending_changes (tenant, id, policy, yaml, reasons, requested_by, requested_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		auth.TenantFromContext(ctx), change.ID, change.Policy, change.YAML, string(reasons), change.RequestedBy, change.RequestedAt)
+	return err
+}
+
+// TakePending removes and returns the pending change with id. The row is
+// deleted and returned in one statement, so two replicas approving the same
+// change cannot both apply it.
+func (s *PostgresStore) TakePending(ctx context.Context, id string) (PendingChange, error) {
+	row := s.db.QueryRowContext(ctx, `DELETE FROM ztap_pending_changes WHERE tenant = $1 AND id = $2
+RETURNING id, policy, yaml, reasons, requested_by, requested_at`, auth.TenantFromContext(ctx), id)
+	change, err := scanPending(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PendingChange{}, ErrChangeNotFound
+	}
+	return change, err
+}
+
+// scanPending reads a ztap_pending_changes row
+func scanPending(row interface{ Scan(...any) error }) (PendingChange, error) {
+	var change PendingChange
+	var reasons string
+	if err := row.Scan(&change.ID, &change.Policy, &change.YAML, &reasons, &change.RequestedBy, &change.RequestedAt); err != nil {
+		return PendingChange{}, err
+	}
+	return change, json.Unmarshal([]byte(reasons), &change.Reasons)
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB records the statements run through the fake driver and keeps the
+// ztap_schema_migrations rows
+type fakeDB struct {
+	mu         sync.Mutex
+	statements []string
+	applied    []int64
+}
+
+func (db *fakeDB) ran(substr string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	n := 0
+	for _, statement := range db.statements {
+		if strings.Contains(statement, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = make(map[string]*fakeDB)
+)
+
+func init() {
+	sql.Register("ztap-fake", fakeDriver{})
+}
+
+// openFake opens a fresh fake database
+func openFake(t *testing.T) (*sql.DB, *fakeDB) {
+	t.Helper()
+	fake := &fakeDB{}
+	fakeDBsMu.Lock()
+	fakeDBs[t.Name()] = fake
+	fakeDBsMu.Unlock()
+
+	db, err := sql.Open("ztap-fake", t.Name())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	return &fakeConn{db: fakeDBs[name]}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.record("BEGIN")
+	return fakeTx{c}, nil
+}
+
+func (c *fakeConn) record(statement string) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.statements = append(c.db.statements, strings.TrimSpace(statement))
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.record(query)
+	if strings.HasPrefix(query, "INSERT INTO ztap_schema_migrations") {
+		c.db.mu.Lock()
+		c.db.applied = append(c.db.applied, args[0].Value.(int64))
+		c.db.mu.Unlock()
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.record(query)
+	if query != "SELECT version FROM ztap_schema_migrations" {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	return &fakeRows{versions: append([]int64(nil), c.db.applied...)}, nil
+}
+
+type fakeTx struct {
+	c *fakeConn
+}
+
+func (tx fakeTx) Commit() error   { tx.c.record("COMMIT"); return nil }
+func (tx fakeTx) Rollback() error { tx.c.record("ROLLBACK"); return nil }
+
+type fakeRows struct {
+	versions []int64
+}
+
+func (r *fakeRows) Columns() []string { return []string{"version"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.versions) == 0 {
+		return io.EOF
+	}
+	dest[0], r.versions = r.versions[0], r.versions[1:]
+	return nil
+}
+
+func TestMigrationsOrdered(t *testing.T) {
+	all, err := migrations()
+	if err != nil {
+		t.Fatalf("migrations failed: %v", err)
+	}
+	if len(all) == 0 || all[0].version != 1 {
+		t.Fatalf("Expected migrations starting at version 1, got %+v", all)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].version <= all[i-1].version {
+			t.Errorf("Migrations out of order: %s after %s", all[i].name, all[i-1].name)
+		}
+	}
+}
+
+func TestMigrateAppliesPendingOnce(t *testing.T) {
+	db, fake := openFake(t)
+	store := NewPostgresStore(db)
+	ctx := context.Background()
+
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	all, _ := migrations()
+	if len(fake.applied) != len(all) {
+		t.Fatalf("Expected %d applied migrations, got %v", len(all), fake.applied)
+	}
+	if fake.ran("CREATE TABLE ztap_users") != 1 || fake.ran("COMMIT") != len(all) {
+		t.Errorf("Expected each migration committed once, got %v", fake.statements)
+	}
+	if fake.ran("SELECT pg_advisory_lock") != 1 || fake.ran("SELECT pg_advisory_unlock") != 1 {
+		t.Errorf("Expected migrations under an advisory lock, got %v", fake.statements)
+	}
+
+	// Already applied migrations are skipped
+	if err := store.Migrate(ctx); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if fake.ran("CREATE TABLE ztap_users") != 1 || len(fake.applied) != len(all) {
+		t.Errorf("Expected no migrations on second run, got %v", fake.statements)
+	}
+}
+
+func TestOpenPostgresRequiresDriver(t *testing.T) {
+	_, err := OpenPostgres(context.Background(), PostgresConfig{DSN: "postgres://db/ztap", Driver: "missing"})
+	if err == nil || !strings.Contains(err.Error(), "no database/sql driver") {
+		t.Errorf("Expected missing driver error, got %v", err)
+	}
+}
@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"ztap/pkg/auth"
+)
+
+// redisTimeout bounds dialing and each command
+const redisTimeout = 5 * time.Second
+
+// RedisConfig configures the Redis store for sessions and discovery cache
+// entries, from the storage.redis section of config.yaml
+type RedisConfig struct {
+	Addr     string         `yaml:"addr"`     // host:port
+	Username string         `yaml:"username"` // ACL user (Redis 6+); empty for password-only AUTH
+	Password string         `yaml:"password"`
+	DB       int            `yaml:"db"`
+	Prefix   string         `yaml:"prefix"` // Key prefix (default "ztap:")
+	TLS      RedisTLSConfig `yaml:"tls"`
+	// DiscoveryCacheTTL enables the shared discovery cache when set
+	DiscoveryCacheTTL time.Duration `yaml:"discovery_cache_ttl"`
+}
+
+// RedisTLSConfig configures TLS to Redis
+type RedisTLSConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`   // PEM bundle; system roots if empty
+	CertFile           string `yaml:"cert_file"` // Client certificate, for mutual TLS
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"` // Defaults to the host of Addr
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// RedisStore keeps sessions and discovery cache entries in Redis, expiring
+// them with Redis TTLs. It implements auth.SessionStore and discovery.Cache
+// over a single connection, redialed after network errors.
+type RedisStore struct {
+	config    RedisConfig
+	tlsConfig *tls.Config
+
+	mu   sync.Mutex
+	conn net.Conn
+	rd   *bufio.Reader
+	now  func() time.Time
+}
+
+// OpenRedis connects to Redis and checks the connection with PING
+func OpenRedis(config RedisConfig) (*RedisStore, error) {
+	if config.Addr == "" {
+		return nil, fmt.Errorf("storage.redis.addr is required")
+	}
+	if config.Prefix == "" {
+		config.Prefix = "ztap:"
+	}
+
+	r := &RedisStore{config: config, now: time.Now}
+	if config.TLS.Enabled {
+		tlsConfig, err := redisTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		r.tlsConfig = tlsConfig
+	}
+
+	if _, err := r.do("PING"); err != nil {
+		return nil, fmt.Errorf("connecting to redis %s: %w", config.Addr, err)
+	}
+	return r, nil
+}
+
+// redisTLSConfig builds the client TLS configuration
+func redisTLSConfig(config RedisConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         config.TLS.ServerName,
+		InsecureSkipVerify: config.TLS.InsecureSkipVerify,
+	}
+	if tlsConfig.ServerName == "" {
+		host, _, err := net.SplitHostPort(config.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage.redis.addr %q: %w", config.Addr, err)
+		}
+		tlsConfig.ServerName = host
+	}
+
+	if config.TLS.CAFile != "" {
+		pem, err := os.ReadFile(config.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in redis CA file %s", config.TLS.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.TLS.CertFile != "" || config.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLS.CertFile, config.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Close closes the connection
+func (r *RedisStore) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn, r.rd = nil, nil
+	return err
+}
+
+// do runs a command, dialing first if needed. A command failing on a stale
+// connection is retried once on a new one.
+func (r *RedisStore) do(args ...string) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		fresh := r.conn == nil
+		if fresh {
+			if err := r.dial(); err != nil {
+				return nil, err
+			}
+		}
+		reply, err := r.roundTrip(args)
+		var replyErr redisError
+		if err == nil || errors.As(err, &replyErr) {
+			return reply, err
+		}
+
+		// Network or protocol error: the connection is unusable
+		r.conn.Close()
+		r.conn, r.rd = nil, nil
+		if fresh || attempt > 0 {
+			return nil, err
+		}
+	}
+}
+
+// dial connects, authenticates and selects the database (requires mu)
+func (r *RedisStore) dial() error {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if r.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", r.config.Addr, r.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", r.config.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	r.conn, r.rd = conn, bufio.NewReader(conn)
+
+	var setup [][]string
+	if r.config.Password != "" {
+		if r.config.Username != "" {
+			setup = append(setup, []string{"AUTH", r.config.Username, r.config.Password})
+		} else {
+			setup = append(setup, []string{"AUTH", r.config.Password})
+		}
+	}
+	if r.config.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.config.DB)})
+	}
+	for _, args := range setup {
+		if _, err := r.roundTrip(args); err != nil {
+			conn.Close()
+			r.conn, r.rd = nil, nil
+			return fmt.Errorf("%s failed: %w", args[0], err)
+		}
+	}
+	return nil
+}
+
+// roundTrip writes a command and reads its reply (requires mu)
+func (r *RedisStore) roundTrip(args []string) (interface{}, error) {
+	r.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := r.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(r.rd)
+}
+
+// readReply parses one RESP2 reply: a string for simple and bulk strings, nil
+// for a null bulk string or array, int64 for integers, []interface{} for
+// arrays and redisError for errors
+func readReply(rd *bufio.Reader) (interface{}, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(rd, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(rd); err != nil {
+				var replyErr redisError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				items[i] = err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+	}
+}
+
+// setWithTTL stores value under key, expiring after ttl (at least 1ms)
+func (r *RedisStore) setWithTTL(key string, value []byte, ttl time.Duration) error {
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	_, err := r.do("SET", key, string(value), "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+// get returns the value stored under key, or false if there is none
+func (r *RedisStore) get(key string) ([]byte, bool, error) {
+	reply, err := r.do("GET", key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	value, ok := reply.(string)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected GET reply %T", reply)
+	}
+	return []byte(value), true, nil
+}
+
+func (r *RedisStore) sessionKey(key string) string {
+	return r.config.Prefix + "session:" + key
+}
+
+// Session returns the session stored under key
+func (r *RedisStore) Session(key string) (*auth.Session, error) {
+	data, found, err := r.get(r.sessionKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, auth.ErrSessionNotFound
+	}
+	var session auth.Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// PutSession stores a session under key, expiring with the session
+func (r *RedisStore) PutSession(key string, session *auth.Session) error {
+	ttl := session.ExpiresAt.Sub(r.now())
+	if ttl <= 0 {
+		return r.DeleteSession(key)
+	}
+	stored := *session
+	stored.Token = ""
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	return r.setWithTTL(r.sessionKey(key), data, ttl)
+}
+
+// DeleteSession removes the session stored under key
+func (r *RedisStore) DeleteSession(key string) error {
+	_, err := r.do("DEL", r.sessionKey(key))
+	return err
+}
+
+func (r *RedisStore) discoveryKey(key string) string {
+	return r.config.Prefix + "discovery:" + key
+}
+
+// Get returns the discovery cache entry stored under key. Redis errors are
+// misses, so resolution falls through to the discovery backend.
+func (r *RedisStore) Get(key string) ([]string, bool) {
+	data, found, err := r.get(r.discoveryKey(key))
+	if err != nil || !found {
+		return nil, false
+	}
+	var ips []string
+	if err := json.Unmarshal(data, &ips); err != nil {
+		return nil, false
+	}
+	return ips, true
+}
+
+// Set stores a discovery cache entry for ttl. Errors are ignored; the entry
+// is resolved again on the next miss.
+func (r *RedisStore) Set(key string, ips []string, ttl time.Duration) {
+	data, err := json.Marshal(ips)
+	if err != nil {
+		return
+	}
+	r.setWithTTL(r.discoveryKey(key), data, ttl)
+}
+
+// Clear removes every discovery cache entry under the prefix
+func (r *RedisStore) Clear() {
+	cursor := "0"
+	for {
+		reply, err := r.do("SCAN", cursor, "MATCH", r.discoveryKey("*"), "COUNT", "100")
+		if err != nil {
+			return
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return
+		}
+		cursor, _ = page[0].(string)
+		keys, _ := page[1].([]interface{})
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, key := range keys {
+				if s, ok := key.(string); ok {
+					args = append(args, s)
+				}
+			}
+			r.do(args...)
+		}
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/discovery"
+)
+
+// fakeRedis is a RESP server with the commands RedisStore uses
+type fakeRedis struct {
+	username, password string
+	// closeAfterReply drops each connection after one reply
+	closeAfterReply bool
+
+	mu    sync.Mutex
+	data  map[string]string
+	ttls  map[string]time.Duration
+	dials int
+}
+
+func startFakeRedis(t *testing.T, fr *fakeRedis, tlsConfig *tls.Config) string {
+	t.Helper()
+	fr.data = make(map[string]string)
+	fr.ttls = make(map[string]time.Duration)
+
+	var ln net.Listener
+	var err error
+	if tlsConfig != nil {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fr.mu.Lock()
+			fr.dials++
+			fr.mu.Unlock()
+			go fr.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (fr *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	authed := fr.password == ""
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+		reply := fr.handle(args, &authed)
+		if _, err := io.WriteString(conn, reply); err != nil || fr.closeAfterReply {
+			return
+		}
+	}
+}
+
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	reply, err := readReply(rd)
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]interface{})
+	args := make([]string, len(items))
+	for i, item := range items {
+		args[i], _ = item.(string)
+	}
+	return args, nil
+}
+
+// stored returns the value and TTL stored under key
+func (fr *fakeRedis) stored(key string) (string, time.Duration) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	return fr.data[key], fr.ttls[key]
+}
+
+func bulk(s string) string { return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n" }
+
+func (fr *fakeRedis) handle(args []string, authed *bool) string {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+
+	cmd := strings.ToUpper(args[0])
+	if cmd == "AUTH" {
+		user, pass := "default", args[len(args)-1]
+		if len(args) == 3 {
+			user = args[1]
+		}
+		if pass != fr.password || (fr.username != "" && user != fr.username) {
+			return "-WRONGPASS invalid username-password pair\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	}
+	if !*authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		value, ok := fr.data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return bulk(value)
+	case "SET":
+		fr.data[args[1]] = args[2]
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			fr.ttls[args[1]] = time.Duration(ms) * time.Millisecond
+		}
+		return "+OK\r\n"
+	case "DEL":
+		n := 0
+		for _, key := range args[1:] {
+			if _, ok := fr.data[key]; ok {
+				delete(fr.data, key)
+				n++
+			}
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	case "SCAN":
+		var keys []string
+		for key := range fr.data {
+			if ok, _ := path.Match(args[3], key); ok {
+				keys = append(keys, key)
+			}
+		}
+		reply := "*2\r\n" + bulk("0") + "*" + strconv.Itoa(len(keys)) + "\r\n"
+		for _, key := range keys {
+			reply += bulk(key)
+		}
+		return reply
+	default:
+		return "-ERR unknown command '" + args[0] + "'\r\n"
+	}
+}
+
+func TestRedisSessions(t *testing.T) {
+	fr := &fakeRedis{username: "ztap", password: "secret"}
+	addr := startFakeRedis(t, fr, nil)
+
+	r, err := OpenRedis(RedisConfig{Addr: addr, Username: "ztap", Password: "secret", DB: 2})
+	if err != nil {
+		t.Fatalf("OpenRedis failed: %v", err)
+	}
+	defer r.Close()
+
+	if _, err := r.Session("missing"); err != auth.ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+
+	session := &auth.Session{Token: "secret-token", Username: "alice", Role: auth.RoleViewer, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := r.PutSession("k1", session); err != nil {
+		t.Fatalf("PutSession failed: %v", err)
+	}
+	value, ttl := fr.stored("ztap:session:k1")
+	if strings.Contains(value, "secret-token") {
+		t.Error("Session token was stored")
+	}
+	if ttl < 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected TTL of about an hour, got %v", ttl)
+	}
+
+	got, err := r.Session("k1")
+	if err != nil || got.Username != "alice" || got.Role != auth.RoleViewer {
+		t.Errorf("Unexpected session %+v, %v", got, err)
+	}
+
+	// Storing an already expired session removes it
+	session.ExpiresAt = time.Now().Add(-time.Second)
+	if err := r.PutSession("k1", session); err != nil {
+		t.Fatalf("PutSession failed: %v", err)
+	}
+	if _, err := r.Session("k1"); err != auth.ErrSessionNotFound {
+		t.Errorf("Expected expired session to be removed, got %v", err)
+	}
+}
+
+func TestRedisAuthFailure(t *testing.T) {
+	addr := startFakeRedis(t, &fakeRedis{password: "secret"}, nil)
+	if _, err := OpenRedis(RedisConfig{Addr: addr, Password: "wrong"}); err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Expected auth error, got %v", err)
+	}
+	if _, err := OpenRedis(RedisConfig{Addr: addr}); err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Errorf("Expected NOAUTH error, got %v", err)
+	}
+}
+
+func TestRedisReconnects(t *testing.T) {
+	fr := &fakeRedis{closeAfterReply: true}
+	addr := startFakeRedis(t, fr, nil)
+
+	r, err := OpenRedis(RedisConfig{Addr: addr})
+	if err != nil {
+		t.Fatalf("OpenRedis failed: %v", err)
+	}
+	defer r.Close()
+
+	// Every command after PING finds its connection closed and redials
+	for i := 0; i < 3; i++ {
+		if _, err := r.Session("missing"); err != auth.ErrSessionNotFound {
+			t.Fatalf("Expected ErrSessionNotFound after reconnect, got %v", err)
+		}
+	}
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	if fr.dials != 4 {
+		t.Errorf("Expected 4 dials, got %d", fr.dials)
+	}
+}
+
+func TestRedisDiscoveryCache(t *testing.T) {
+	fr := &fakeRedis{}
+	addr := startFakeRedis(t, fr, nil)
+
+	r, err := OpenRedis(RedisConfig{Addr: addr, Prefix: "prod:"})
+	if err != nil {
+		t.Fatalf("OpenRedis failed: %v", err)
+	}
+	defer r.Close()
+
+	backend := discovery.NewInMemoryDiscovery()
+	backend.RegisterService("web-1", "10.0.1.1", map[string]string{"app": "web"})
+	cache := discovery.NewCacheDiscoveryWithCache(backend, time.Minute, r)
+
+	if ips, err := cache.ResolveLabels(map[string]string{"app": "web"}); err != nil || len(ips) != 1 {
+		t.Fatalf("Unexpected resolution %v, %v", ips, err)
+	}
+	if _, ttl := fr.stored(`prod:discovery:{"app":"web"}`); ttl != time.Minute {
+		t.Errorf("Expected entry cached for a minute, got %v", ttl)
+	}
+
+	// Cached entries are shared: a second server sees the first one's entry
+	backend.RegisterService("web-2", "10.0.1.2", map[string]string{"app": "web"})
+	other := discovery.NewCacheDiscoveryWithCache(discovery.NewInMemoryDiscovery(), time.Minute, r)
+	if ips, _ := other.ResolveLabels(map[string]string{"app": "web"}); len(ips) != 1 {
+		t.Errorf("Expected shared cached entry, got %v", ips)
+	}
+
+	// Clearing leaves sessions alone
+	r.PutSession("k1", &auth.Session{Username: "alice", ExpiresAt: time.Now().Add(time.Hour)})
+	cache.ClearCache()
+	if ips, _ := cache.ResolveLabels(map[string]string{"app": "web"}); len(ips) != 2 {
+		t.Errorf("Expected fresh result after clear, got %v", ips)
+	}
+	if _, err := r.Session("k1"); err != nil {
+		t.Errorf("Expected session to survive cache clear, got %v", err)
+	}
+}
+
+func TestRedisTLS(t *testing.T) {
+	certPEM, keyPEM := selfSignedCert(t)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startFakeRedis(t, &fakeRedis{}, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenRedis(RedisConfig{Addr: addr, TLS: RedisTLSConfig{Enabled: true, CAFile: caFile, ServerName: "redis.test"}})
+	if err != nil {
+		t.Fatalf("OpenRedis over TLS failed: %v", err)
+	}
+	r.Close()
+
+	// The certificate is not valid for the address host
+	if _, err := OpenRedis(RedisConfig{Addr: addr, TLS: RedisTLSConfig{Enabled: true, CAFile: caFile}}); err == nil {
+		t.Error("Expected certificate verification error")
+	}
+}
+
+// selfSignedCert returns a PEM certificate and key for redis.test
+func selfSignedCert(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis.test"},
+		DNSNames:              []string{"redis.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestReadReply(t *testing.T) {
+	rd := bufio.NewReader(strings.NewReader("*3\r\n:5\r\n$-1\r\n-ERR bad\r\n"))
+	reply, err := readReply(rd)
+	if err != nil {
+		t.Fatalf("readReply failed: %v", err)
+	}
+	items := reply.([]interface{})
+	if items[0] != int64(5) || items[1] != nil || fmt.Sprint(items[2]) != "redis: ERR bad" {
+		t.Errorf("Unexpected reply %#v", items)
+	}
+
+	if _, err := readReply(bufio.NewReader(strings.NewReader("?x\r\n"))); err == nil {
+		t.Error("Expected error for unknown reply type")
+	}
+}
@@ -0,0 +1,133 @@
+// Package storage provides the stores for state shared by ZTAP API servers:
+// users and sessions (auth.Store), policy documents (PolicyStore) and policy
+// changes awaiting approval (PendingStore). The
+// file backend keeps them under ~/.ztap for a single host; the postgres
+// backend lets several API server replicas share them.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ztap/pkg/auth"
+	"ztap/pkg/configfile"
+	"ztap/pkg/discovery"
+)
+
+// Storage backends
+const (
+	BackendFile     = "file"
+	BackendPostgres = "postgres"
+)
+
+// Config selects the storage backend, from the storage section of
+// config.yaml
+type Config struct {
+	Backend  string         `yaml:"backend"` // BackendFile (default) or BackendPostgres
+	Postgres PostgresConfig `yaml:"postgres"`
+	// Redis, when set, keeps sessions and discovery cache entries in Redis
+	Redis *RedisConfig `yaml:"redis"`
+}
+
+// PostgresConfig configures the postgres backend
+type PostgresConfig struct {
+	// DSN is passed to the driver, e.g.
+	// postgres://ztap:secret@db:5432/ztap?sslmode=verify-full
+	DSN string `yaml:"dsn"`
+	// Driver is the database/sql driver name (default "postgres")
+	Driver string `yaml:"driver"`
+}
+
+// Validate checks the configuration before anything is opened
+func (c Config) Validate() error {
+	if c.Redis != nil && c.Redis.Addr == "" {
+		return fmt.Errorf("storage.redis.addr is required when storage.redis is set")
+	}
+
+	switch c.Backend {
+	case "", BackendFile:
+		return nil
+	case BackendPostgres:
+		if c.Postgres.DSN == "" {
+			return fmt.Errorf("storage.postgres.dsn is required for the postgres backend")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown storage backend %q (use %s or %s)", c.Backend, BackendFile, BackendPostgres)
+	}
+}
+
+// ErrPolicyNotFound is returned for a policy that is not stored
+var ErrPolicyNotFound = errors.New("policy not found")
+
+// PolicyRecord is a stored policy document
+type PolicyRecord struct {
+	Name      string    `json:"name"`
+	YAML      string    `json:"yaml"`
+	Version   int64     `json:"version"` // Incremented by every PutPolicy
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PolicyStore persists policy documents by name
+type PolicyStore interface {
+	// ListPolicies returns every stored policy in name order
+	ListPolicies(ctx context.Context) ([]PolicyRecord, error)
+	// GetPolicy returns the named policy, or ErrPolicyNotFound
+	GetPolicy(ctx context.Context, name string) (PolicyRecord, error)
+	// PutPolicy creates or replaces the named policy and returns it with its
+	// new version
+	PutPolicy(ctx context.Context, name, yaml, updatedBy string) (PolicyRecord, error)
+	// DeletePolicy removes the named policy, or returns ErrPolicyNotFound
+	DeletePolicy(ctx context.Context, name string) error
+}
+
+// ErrChangeNotFound is returned for a pending change that is not stored
+var ErrChangeNotFound = errors.New("pending change not found")
+
+// PendingChange is a policy change held until a second admin approves it
+type PendingChange struct {
+	ID          string    `json:"id"`
+	Policy      string    `json:"policy"`
+	YAML        string    `json:"yaml"`
+	Reasons     []string  `json:"reasons"` // Why the change needs approval
+	RequestedBy string    `json:"requested_by"`
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// PendingStore persists policy changes awaiting approval
+type PendingStore interface {
+	// ListPending returns every pending change, oldest first
+	ListPending(ctx context.Context) ([]PendingChange, error)
+	// GetPending returns the pending change with id, or ErrChangeNotFound
+	GetPending(ctx context.Context, id string) (PendingChange, error)
+	// AddPending stores a new pending change
+	AddPending(ctx context.Context, change PendingChange) error
+	// TakePending removes and returns the pending change with id, or
+	// returns ErrChangeNotFound. Of concurrent callers only one gets it.
+	TakePending(ctx context.Context, id string) (PendingChange, error)
+}
+
+// LoadConfig reads the storage section of file. A missing file selects the
+// file backend.
+func LoadConfig(file *configfile.File) (Config, error) {
+	var c Config
+	if err := file.Section("storage", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+var (
+	_ auth.Store   = (*PostgresStore)(nil)
+	_ PolicyStore  = (*PostgresStore)(nil)
+	_ PendingStore = (*PostgresStore)(nil)
+	_ auth.Store   = (*auth.FileStore)(nil)
+	_ PolicyStore  = (*FilePolicyStore)(nil)
+	_ PendingStore = (*FilePendingStore)(nil)
+
+	_ auth.SessionStore = (*RedisStore)(nil)
+	_ discovery.Cache   = (*RedisStore)(nil)
+)
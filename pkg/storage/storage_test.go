@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ztap/pkg/configfile"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	config, err := configfile.Read(filepath.Join(dir, "missing.yaml"), LoadConfig)
+	if err != nil || config.Backend != "" {
+		t.Errorf("Expected file backend for missing config, got %+v, %v", config, err)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	data := `
+logging:
+  level: info
+storage:
+  backend: postgres
+  postgres:
+    dsn: postgres://ztap@db/ztap
+    driver: pgx
+`
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err = configfile.Read(path, LoadConfig)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if config.Backend != BackendPostgres || config.Postgres.DSN != "postgres://ztap@db/ztap" || config.Postgres.Driver != "pgx" {
+		t.Errorf("Unexpected config %+v", config)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, config := range []Config{
+		{Backend: "mysql"},
+		{Backend: BackendPostgres},
+	} {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected error for %+v", config)
+		}
+	}
+}
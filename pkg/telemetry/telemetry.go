@@ -0,0 +1,323 @@
+// Package telemetry reports anonymized usage statistics to help maintainers
+// prioritize, and only when an operator opts in. A report carries the
+// version, platform, backend types, policy and service counts, and counts of
+// failed commands by class; never names, labels, addresses or policy content.
+// 'ztap telemetry show' prints exactly what would be sent.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"ztap/pkg/clock"
+	"ztap/pkg/configfile"
+)
+
+// DefaultInterval is how often reports are sent when the config sets no
+// interval
+const DefaultInterval = 24 * time.Hour
+
+// checkInterval is how often Run checks whether a report is due
+const checkInterval = time.Hour
+
+// OffEnv names the hard off switch: with ZTAP_TELEMETRY=off (or
+// DO_NOT_TRACK set), nothing is recorded or sent, whatever config.yaml says
+const OffEnv = "ZTAP_TELEMETRY"
+
+// Config is the telemetry section of config.yaml:
+//
+//	telemetry:
+//	  enabled: true
+//	  endpoint: https://telemetry.example.com/ztap
+//	  interval: 24h
+type Config struct {
+	Enabled  bool          `yaml:"enabled"`
+	Endpoint string        `yaml:"endpoint"` // Reports are POSTed here as JSON
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Off reports whether the hard off switch is set in the environment
+func Off() bool {
+	switch strings.ToLower(os.Getenv(OffEnv)) {
+	case "off", "0", "false", "no":
+		return true
+	}
+	dnt := os.Getenv("DO_NOT_TRACK")
+	return dnt != "" && dnt != "0"
+}
+
+// Active reports whether usage is recorded and reported: the operator opted
+// in and the off switch is not set
+func (c Config) Active() bool {
+	return c.Enabled && !Off()
+}
+
+// interval returns the reporting interval
+func (c Config) interval() time.Duration {
+	if c.Interval > 0 {
+		return c.Interval
+	}
+	return DefaultInterval
+}
+
+// Validate checks that an enabled config has an http or https endpoint and
+// that the interval is not negative
+func (c Config) Validate() error {
+	if c.Enabled && !strings.HasPrefix(c.Endpoint, "http://") && !strings.HasPrefix(c.Endpoint, "https://") {
+		return fmt.Errorf("telemetry.endpoint must be an http or https URL when telemetry is enabled")
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("telemetry.interval must not be negative")
+	}
+	return nil
+}
+
+// LoadConfig reads the telemetry section of file. A missing file or section
+// leaves telemetry disabled.
+func LoadConfig(file *configfile.File) (Config, error) {
+	var c Config
+	if err := file.Section("telemetry", &c); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Report is what is sent
+type Report struct {
+	InstallID string            `json:"install_id"` // Random, generated when the first report is sent
+	Version   string            `json:"version"`
+	OS        string            `json:"os"`
+	Arch      string            `json:"arch"`
+	Backends  map[string]string `json:"backends"` // Backend type by role, e.g. enforcement: ebpf
+	Policies  int               `json:"policies"` // Enforced on this host
+	Rules     int               `json:"rules"`
+	Services  int               `json:"services"` // Registered with service discovery
+	Errors    map[string]int64  `json:"errors"`   // Failed commands by class since the last report
+}
+
+// state is what is kept between reports
+type state struct {
+	InstallID string           `json:"install_id,omitempty"`
+	Errors    map[string]int64 `json:"errors,omitempty"`
+	LastSent  time.Time        `json:"last_sent,omitempty"`
+}
+
+// Store keeps the install ID, the error counts not reported yet and when the
+// last report was sent in a JSON file, read again on every operation as
+// every ztap process on the host records errors
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore creates a store persisted at path
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// RecordError counts a failed command of class
+func (s *Store) RecordError(class string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.load()
+	if err != nil {
+		return err
+	}
+	if st.Errors == nil {
+		st.Errors = make(map[string]int64)
+	}
+	st.Errors[class]++
+	return s.save(st)
+}
+
+// load reads the state; a missing file is empty (requires mu)
+func (s *Store) load() (state, error) {
+	var st state
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return st, fmt.Errorf("failed to read telemetry state: %w", err)
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("failed to parse telemetry state %s: %w", s.path, err)
+	}
+	return st, nil
+}
+
+// save writes the state (requires mu)
+func (s *Store) save(st state) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create telemetry directory: %w", err)
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write telemetry state: %w", err)
+	}
+	return nil
+}
+
+// Reporter builds and sends reports
+type Reporter struct {
+	config  Config
+	store   *Store
+	version string
+	collect func(*Report) // Fills in backends and counts
+	client  *http.Client
+	clock   clock.Clock
+}
+
+// NewReporter creates a reporter for the ztap version. collect fills in the
+// backends and counts of each report.
+func NewReporter(config Config, store *Store, version string, collect func(*Report)) *Reporter {
+	return &Reporter{
+		config:  config,
+		store:   store,
+		version: version,
+		collect: collect,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		clock:   clock.Real,
+	}
+}
+
+// SetClock sets the clock reports are scheduled with (used in tests)
+func (r *Reporter) SetClock(clk clock.Clock) {
+	r.clock = clock.OrReal(clk)
+}
+
+// Preview returns the report that would be sent now, without sending it
+// or generating an install ID
+func (r *Reporter) Preview() (Report, error) {
+	r.store.mu.Lock()
+	st, err := r.store.load()
+	r.store.mu.Unlock()
+	if err != nil {
+		return Report{}, err
+	}
+	return r.build(st), nil
+}
+
+func (r *Reporter) build(st state) Report {
+	report := Report{
+		InstallID: st.InstallID,
+		Version:   r.version,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Backends:  make(map[string]string),
+		Errors:    make(map[string]int64),
+	}
+	for class, n := range st.Errors {
+		report.Errors[class] = n
+	}
+	if r.collect != nil {
+		r.collect(&report)
+	}
+	return report
+}
+
+// Send sends a report if telemetry is active and one is due (force sends
+// regardless of the interval). Reported errors are then cleared.
+func (r *Reporter) Send(ctx context.Context, force bool) (bool, error) {
+	if !r.config.Active() {
+		return false, nil
+	}
+	r.store.mu.Lock()
+	st, err := r.store.load()
+	if err == nil && st.InstallID == "" {
+		st.InstallID, err = newInstallID()
+		if err == nil {
+			err = r.store.save(st)
+		}
+	}
+	r.store.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	now := r.clock.Now()
+	if !force && !st.LastSent.IsZero() && now.Sub(st.LastSent) < r.config.interval() {
+		return false, nil
+	}
+
+	report := r.build(st)
+	body, err := json.Marshal(report)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to send telemetry: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return false, fmt.Errorf("failed to send telemetry: %s returned %s", r.config.Endpoint, resp.Status)
+	}
+
+	// Errors recorded while the report was sent stay for the next one
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	st, err = r.store.load()
+	if err != nil {
+		return true, err
+	}
+	for class, n := range report.Errors {
+		if st.Errors[class] <= n {
+			delete(st.Errors, class)
+		} else {
+			st.Errors[class] -= n
+		}
+	}
+	st.LastSent = now
+	return true, r.store.save(st)
+}
+
+// Run sends a report whenever one is due until ctx is cancelled. It does
+// nothing unless telemetry is active. Failures are logged and retried at the
+// next check.
+func (r *Reporter) Run(ctx context.Context) {
+	if !r.config.Active() {
+		return
+	}
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Send(ctx, false); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// newInstallID returns a random install ID, unrelated to the host
+func newInstallID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate install ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,98 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ztap/pkg/clock"
+	"ztap/pkg/configfile"
+)
+
+func TestReporter(t *testing.T) {
+	t.Setenv(OffEnv, "")
+	t.Setenv("DO_NOT_TRACK", "")
+	var received []Report
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report Report
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Errorf("Failed to decode report: %v", err)
+		}
+		received = append(received, report)
+	}))
+	defer server.Close()
+
+	store := NewStore(filepath.Join(t.TempDir(), "telemetry.json"))
+	store.RecordError("validation")
+	store.RecordError("validation")
+	collect := func(r *Report) {
+		r.Backends["enforcement"] = "ebpf"
+		r.Policies = 3
+	}
+
+	// Without opting in, nothing is sent; the preview shows what would be
+	disabled := NewReporter(Config{Endpoint: server.URL}, store, "1.2.0", collect)
+	if sent, err := disabled.Send(t.Context(), true); sent || err != nil {
+		t.Fatalf("Expected nothing sent without opting in, got %v (%v)", sent, err)
+	}
+	preview, err := disabled.Preview()
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	if preview.InstallID != "" || preview.Version != "1.2.0" || preview.Policies != 3 || preview.Errors["validation"] != 2 {
+		t.Errorf("Unexpected preview %+v", preview)
+	}
+
+	clk := clock.NewFake(time.Date(2025, 10, 1, 9, 0, 0, 0, time.UTC))
+	r := NewReporter(Config{Enabled: true, Endpoint: server.URL}, store, "1.2.0", collect)
+	r.SetClock(clk)
+	if sent, err := r.Send(t.Context(), false); !sent || err != nil {
+		t.Fatalf("Expected a report sent, got %v (%v)", sent, err)
+	}
+	if len(received) != 1 || received[0].InstallID == "" || received[0].Backends["enforcement"] != "ebpf" || received[0].Errors["validation"] != 2 {
+		t.Fatalf("Unexpected report %+v", received)
+	}
+
+	// Reported errors are cleared, and the next report waits for the interval
+	store.RecordError("auth")
+	clk.Advance(time.Hour)
+	if sent, _ := r.Send(t.Context(), false); sent {
+		t.Error("Expected no report before the interval")
+	}
+	clk.Advance(DefaultInterval)
+	if sent, err := r.Send(t.Context(), false); !sent || err != nil {
+		t.Fatalf("Expected a report after the interval, got %v (%v)", sent, err)
+	}
+	if last := received[len(received)-1]; last.InstallID != received[0].InstallID || len(last.Errors) != 1 || last.Errors["auth"] != 1 {
+		t.Errorf("Expected the same install ID and only the new error, got %+v", last)
+	}
+
+	// The off switch overrides config.yaml
+	t.Setenv(OffEnv, "off")
+	if sent, _ := r.Send(t.Context(), true); sent {
+		t.Error("Expected nothing sent with ZTAP_TELEMETRY=off")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	config, err := configfile.Read(filepath.Join(dir, "missing.yaml"), LoadConfig)
+	if err != nil || config.Enabled {
+		t.Fatalf("Expected telemetry disabled without a config, got %+v (%v)", config, err)
+	}
+
+	path := filepath.Join(dir, "config.yaml")
+	os.WriteFile(path, []byte("telemetry:\n  enabled: true\n"), 0644)
+	if _, err := configfile.Read(path, LoadConfig); err == nil {
+		t.Error("Expected an error for telemetry enabled without an endpoint")
+	}
+	os.WriteFile(path, []byte("telemetry:\n  enabled: true\n  endpoint: https://telemetry.example.com\n  interval: 12h\n"), 0644)
+	config, err = configfile.Read(path, LoadConfig)
+	if err != nil || config.interval() != 12*time.Hour {
+		t.Errorf("Unexpected config %+v (%v)", config, err)
+	}
+}
@@ -0,0 +1,279 @@
+// Package upgrade replaces the running ztap binary with a signed release.
+//
+// A release endpoint serves a JSON manifest listing one binary per platform.
+// Every binary has a detached Ed25519 signature over the release version and
+// the binary's SHA-256, and a binary is only installed if its signature
+// verifies against the release key the CLI was built with (or configured to
+// trust). The manifest itself is not signed, so signing the version stops an
+// endpoint from passing an old signed binary off as a newer release.
+package upgrade
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidSignature is returned when a downloaded binary does not match
+	// its detached signature
+	ErrInvalidSignature = errors.New("release signature is invalid")
+
+	// ErrNoTrustedKey is returned when there is no release key to verify with
+	ErrNoTrustedKey = errors.New("no trusted release key to verify against")
+
+	// ErrNoAsset is returned when a release has no binary for the platform
+	ErrNoAsset = errors.New("release has no binary for this platform")
+)
+
+// maxBinarySize bounds downloads so a bad endpoint cannot fill the disk
+const maxBinarySize = 256 << 20
+
+// Release is the manifest served by the release endpoint
+type Release struct {
+	Version   string    `json:"version"`
+	Published time.Time `json:"published,omitempty"`
+	Notes     string    `json:"notes,omitempty"`
+	Assets    []Asset   `json:"assets"`
+}
+
+// Asset is one platform's binary. URLs may be relative to the manifest.
+type Asset struct {
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+	URL       string `json:"url"`
+	Signature string `json:"signature"` // URL of the base64 detached signature
+	SHA256    string `json:"sha256,omitempty"`
+}
+
+// Asset returns the binary for a platform
+func (r *Release) Asset(goos, goarch string) (Asset, error) {
+	for _, a := range r.Assets {
+		if a.OS == goos && a.Arch == goarch {
+			return a, nil
+		}
+	}
+	return Asset{}, fmt.Errorf("%w: %s/%s in %s", ErrNoAsset, goos, goarch, r.Version)
+}
+
+// Client checks for and downloads releases
+type Client struct {
+	Endpoint string            // URL of the release manifest
+	Key      ed25519.PublicKey // Trusted release key
+	HTTP     *http.Client
+}
+
+// NewClient creates a client for the manifest at endpoint trusting key
+func NewClient(endpoint string, key ed25519.PublicKey) *Client {
+	return &Client{
+		Endpoint: endpoint,
+		Key:      key,
+		HTTP:     &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// Latest fetches the release manifest
+func (c *Client) Latest(ctx context.Context) (*Release, error) {
+	data, err := c.get(ctx, c.Endpoint, 1<<20)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch release manifest: %w", err)
+	}
+
+	var release Release
+	if err := json.Unmarshal(data, &release); err != nil {
+		return nil, fmt.Errorf("invalid release manifest: %w", err)
+	}
+	if release.Version == "" {
+		return nil, fmt.Errorf("invalid release manifest: no version")
+	}
+	return &release, nil
+}
+
+// Download fetches an asset's binary of release version and returns it once
+// its signature verifies against the trusted key
+func (c *Client) Download(ctx context.Context, version string, asset Asset) ([]byte, error) {
+	if len(c.Key) != ed25519.PublicKeySize {
+		return nil, ErrNoTrustedKey
+	}
+
+	binary, err := c.get(ctx, c.resolve(asset.URL), maxBinarySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", asset.URL, err)
+	}
+	encoded, err := c.get(ctx, c.resolve(asset.Signature), 4096)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download signature %s: %w", asset.Signature, err)
+	}
+
+	if err := Verify(c.Key, version, binary, strings.TrimSpace(string(encoded))); err != nil {
+		return nil, err
+	}
+	if asset.SHA256 != "" {
+		sum := sha256.Sum256(binary)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), asset.SHA256) {
+			return nil, fmt.Errorf("%w: sha256 does not match the manifest", ErrInvalidSignature)
+		}
+	}
+	return binary, nil
+}
+
+// Verify checks a base64 detached Ed25519 signature of binary as release
+// version
+func Verify(key ed25519.PublicKey, version string, binary []byte, signature string) error {
+	if len(key) != ed25519.PublicKeySize {
+		return ErrNoTrustedKey
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", ErrInvalidSignature)
+	}
+	if !ed25519.Verify(key, signedPayload(version, binary), sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Sign returns the base64 detached signature of binary as release version,
+// for release tooling
+func Sign(key ed25519.PrivateKey, version string, binary []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, signedPayload(version, binary)))
+}
+
+// signedPayload is what a release signature covers: the version and the
+// SHA-256 of the binary
+func signedPayload(version string, binary []byte) []byte {
+	sum := sha256.Sum256(binary)
+	return []byte("ztap-release\n" + version + "\n" + hex.EncodeToString(sum[:]) + "\n")
+}
+
+func (c *Client) resolve(ref string) string {
+	base, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return ref
+	}
+	target, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return target.String()
+}
+
+func (c *Client) get(ctx context.Context, target string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("response larger than %d bytes", limit)
+	}
+	return data, nil
+}
+
+// Install atomically replaces the binary at path, keeping its file mode. The
+// replaced binary is kept as path.previous for rollback. A running process
+// keeps executing the old binary until it restarts.
+func Install(path string, binary []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".upgrade-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary binary: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write binary: %w", err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set binary mode: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write binary: %w", err)
+	}
+
+	previous := path + ".previous"
+	os.Remove(previous)
+	if err := os.Link(path, previous); err != nil {
+		return fmt.Errorf("failed to keep previous binary: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace binary: %w", err)
+	}
+	return nil
+}
+
+// Newer reports whether version a is newer than b. Versions are compared as
+// vMAJOR.MINOR.PATCH; pre-release suffixes are ignored. An unparsable
+// version (e.g. a "dev" build) is never newer and every release is newer
+// than it.
+func Newer(a, b string) bool {
+	va, okA := parseVersion(a)
+	vb, okB := parseVersion(b)
+	switch {
+	case !okA:
+		return false
+	case !okB:
+		return true
+	}
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] > vb[i]
+		}
+	}
+	return false
+}
+
+func parseVersion(v string) ([3]int, bool) {
+	var parsed [3]int
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return parsed, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return parsed, false
+		}
+		parsed[i] = n
+	}
+	return parsed, true
+}
@@ -0,0 +1,162 @@
+package upgrade
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// releaseServer serves a manifest of version for linux/amd64, with a
+// signature of signed as v1.3.0 made with key
+func releaseServer(t *testing.T, key ed25519.PrivateKey, version string, binary []byte, signed []byte) *httptest.Server {
+	t.Helper()
+	sum := sha256.Sum256(binary)
+	manifest := `{"version":"` + version + `","assets":[{"os":"linux","arch":"amd64","url":"ztap-linux-amd64","signature":"ztap-linux-amd64.sig","sha256":"` + hex.EncodeToString(sum[:]) + `"}]}`
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/releases/latest.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(manifest))
+	})
+	mux.HandleFunc("/releases/ztap-linux-amd64", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(binary)
+	})
+	mux.HandleFunc("/releases/ztap-linux-amd64.sig", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(Sign(key, "v1.3.0", signed) + "\n"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLatestAndDownload(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	binary := []byte("#!/bin/sh\necho ztap v1.3.0\n")
+	server := releaseServer(t, key, "v1.3.0", binary, binary)
+
+	client := NewClient(server.URL+"/releases/latest.json", pub)
+	release, err := client.Latest(context.Background())
+	if err != nil {
+		t.Fatalf("Latest failed: %v", err)
+	}
+	if release.Version != "v1.3.0" {
+		t.Errorf("Expected v1.3.0, got %s", release.Version)
+	}
+	if _, err := release.Asset("darwin", "arm64"); !errors.Is(err, ErrNoAsset) {
+		t.Errorf("Expected ErrNoAsset, got %v", err)
+	}
+
+	asset, err := release.Asset("linux", "amd64")
+	if err != nil {
+		t.Fatalf("Asset failed: %v", err)
+	}
+	data, err := client.Download(context.Background(), release.Version, asset)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if string(data) != string(binary) {
+		t.Errorf("Unexpected binary %q", data)
+	}
+
+	// A different trusted key rejects the release
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := NewClient(client.Endpoint, other).Download(context.Background(), release.Version, asset); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for untrusted key, got %v", err)
+	}
+	if _, err := NewClient(client.Endpoint, nil).Download(context.Background(), release.Version, asset); !errors.Is(err, ErrNoTrustedKey) {
+		t.Errorf("Expected ErrNoTrustedKey, got %v", err)
+	}
+}
+
+func TestDownloadRejectsTamperedBinary(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	server := releaseServer(t, key, "v1.3.0", []byte("tampered"), []byte("original"))
+
+	client := NewClient(server.URL+"/releases/latest.json", pub)
+	release, err := client.Latest(context.Background())
+	if err != nil {
+		t.Fatalf("Latest failed: %v", err)
+	}
+	asset, _ := release.Asset("linux", "amd64")
+	if _, err := client.Download(context.Background(), release.Version, asset); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestDownloadRejectsRelabelledVersion(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	binary := []byte("#!/bin/sh\necho ztap v1.3.0\n")
+	// The signature is valid for v1.3.0, but the manifest claims v9.0.0
+	server := releaseServer(t, key, "v9.0.0", binary, binary)
+
+	client := NewClient(server.URL+"/releases/latest.json", pub)
+	release, err := client.Latest(context.Background())
+	if err != nil {
+		t.Fatalf("Latest failed: %v", err)
+	}
+	asset, _ := release.Asset("linux", "amd64")
+	if _, err := client.Download(context.Background(), release.Version, asset); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for a relabelled release, got %v", err)
+	}
+}
+
+func TestInstall(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ztap")
+	if err := os.WriteFile(path, []byte("old"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Install(path, []byte("new")); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "new" {
+		t.Errorf("Expected new binary, got %q", data)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0o750 {
+		t.Errorf("Expected mode 0750 to be kept, got %v", info.Mode().Perm())
+	}
+	previous, _ := os.ReadFile(path + ".previous")
+	if string(previous) != "old" {
+		t.Errorf("Expected previous binary to be kept, got %q", previous)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 2 {
+		t.Errorf("Expected no temporary files left, got %d entries", len(entries))
+	}
+
+	if err := Install(filepath.Join(t.TempDir(), "missing"), []byte("new")); err == nil {
+		t.Error("Expected error for missing binary")
+	}
+}
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		newer bool
+	}{
+		{"v1.3.0", "v1.2.9", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v1.2.0", "v1.2.0", false},
+		{"v1.2.0", "v1.3.0", false},
+		{"1.2.1", "v1.2", true},
+		{"v2.0.0-rc.1", "v1.9.9", true},
+		{"v1.0.0", "dev", true},
+		{"dev", "v1.0.0", false},
+	}
+	for _, tt := range tests {
+		if got := Newer(tt.a, tt.b); got != tt.newer {
+			t.Errorf("Newer(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.newer)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+// Package whatif simulates changes to service discovery, such as a service
+// scaling out or being removed, and reports what they would do to the
+// compiled policies: rule counts per policy, eBPF policy map usage and
+// Security Group rule counts against their limits. It helps plan for
+// autoscaling before a hard limit is hit in production.
+package whatif
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ztap/pkg/cloud"
+	"ztap/pkg/enforcer"
+	"ztap/pkg/policy"
+)
+
+// DefaultSecurityGroupRuleLimit is the default AWS quota of inbound or
+// outbound rules per Security Group, counted separately for IPv4 and IPv6
+const DefaultSecurityGroupRuleLimit = 60
+
+// syntheticNetwork holds the IPs of hypothetical workloads: the benchmarking
+// range of RFC 2544, which no real workload uses
+var syntheticNetwork = net.IPNet{IP: net.IPv4(198, 18, 0, 0).To4(), Mask: net.CIDRMask(15, 32)}
+
+// Scale sets how many IPs a label selector resolves to
+type Scale struct {
+	Selector map[string]string
+	IPs      int
+}
+
+// ParseScale parses a scale written as selector:count, e.g. app=db:50 or
+// app=db,tier=primary:3
+func ParseScale(s string) (Scale, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return Scale{}, fmt.Errorf("invalid scale %q: want selector:count, e.g. app=db:50", s)
+	}
+	count, err := strconv.Atoi(s[i+1:])
+	if err != nil || count < 0 {
+		return Scale{}, fmt.Errorf("invalid scale %q: count must be a non-negative number", s)
+	}
+	selector := make(map[string]string)
+	for _, pair := range strings.Split(s[:i], ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return Scale{}, fmt.Errorf("invalid scale %q: selector must be key=value pairs", s)
+		}
+		selector[key] = value
+	}
+	return Scale{Selector: selector, IPs: count}, nil
+}
+
+// Scenario is hypothetical discovery state layered over the real one
+type Scenario struct {
+	// Scale makes selectors resolve to a number of IPs: hypothetical
+	// workloads with the selector's labels are added, or the last matching
+	// IPs removed
+	Scale []Scale
+	// Remove are the IPs of services that are gone
+	Remove []string
+}
+
+// Discovery resolves labels as base does, changed by a Scenario
+type Discovery struct {
+	base    policy.ServiceDiscovery
+	removed map[string]bool
+	scales  []scaled
+}
+
+// scaled is a Scale with the IPs it adds and removes
+type scaled struct {
+	selector map[string]string
+	added    []string
+	removed  map[string]bool
+}
+
+// NewDiscovery layers scenario over base. Hypothetical workloads get IPs
+// from 198.18.0.0/15.
+func NewDiscovery(base policy.ServiceDiscovery, scenario Scenario) (*Discovery, error) {
+	d := &Discovery{base: base, removed: make(map[string]bool)}
+	for _, ip := range scenario.Remove {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, fmt.Errorf("invalid IP %q", ip)
+		}
+		d.removed[parsed.String()] = true
+	}
+
+	next := 0
+	for _, scale := range scenario.Scale {
+		if len(scale.Selector) == 0 {
+			return nil, fmt.Errorf("scale must have a selector")
+		}
+		s := scaled{selector: scale.Selector, removed: make(map[string]bool)}
+		existing := d.resolveBase(scale.Selector)
+		sortIPs(existing)
+		if len(existing) > scale.IPs {
+			for _, ip := range existing[scale.IPs:] {
+				s.removed[ip] = true
+			}
+		}
+		for n := len(existing); n < scale.IPs; n++ {
+			ip, err := syntheticIP(next)
+			if err != nil {
+				return nil, err
+			}
+			s.added = append(s.added, ip)
+			next++
+		}
+		d.scales = append(d.scales, s)
+	}
+	return d, nil
+}
+
+// sortIPs sorts ips by address, so 10.0.0.9 comes before 10.0.0.10, with
+// anything unparsable last
+func sortIPs(ips []string) {
+	sort.Slice(ips, func(i, j int) bool {
+		a, errA := netip.ParseAddr(ips[i])
+		b, errB := netip.ParseAddr(ips[j])
+		if errA != nil || errB != nil {
+			if (errA == nil) != (errB == nil) {
+				return errA == nil
+			}
+			return ips[i] < ips[j]
+		}
+		return a.Less(b)
+	})
+}
+
+// syntheticIP returns the n-th host IP of the synthetic network, from 0
+func syntheticIP(n int) (string, error) {
+	ones, bits := syntheticNetwork.Mask.Size()
+	if hosts := 1<<(bits-ones) - 2; n >= hosts {
+		return "", fmt.Errorf("more than %d hypothetical IPs", hosts)
+	}
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(syntheticNetwork.IP)+uint32(n)+1)
+	return ip.String(), nil
+}
+
+// resolveBase resolves labels through base without the removed services;
+// selectors base cannot resolve match nothing
+func (d *Discovery) resolveBase(labels map[string]string) []string {
+	ips, err := d.base.ResolveLabels(labels)
+	if err != nil {
+		return nil
+	}
+	var kept []string
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			ip = parsed.String()
+		}
+		if !d.removed[ip] {
+			kept = append(kept, ip)
+		}
+	}
+	return kept
+}
+
+// ResolveLabels returns the IPs base resolves labels to, without removed
+// services, and with the hypothetical workloads whose labels match
+func (d *Discovery) ResolveLabels(labels map[string]string) ([]string, error) {
+	var ips []string
+	for _, ip := range d.resolveBase(labels) {
+		if !d.scaledAway(ip) {
+			ips = append(ips, ip)
+		}
+	}
+	for _, s := range d.scales {
+		if matches(s.selector, labels) {
+			ips = append(ips, s.added...)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no services found matching labels: %v", labels)
+	}
+	return ips, nil
+}
+
+// LabelsOf returns the labels of a hypothetical workload, or those base
+// knows for ip
+func (d *Discovery) LabelsOf(ip string) (map[string]string, bool) {
+	for _, s := range d.scales {
+		for _, added := range s.added {
+			if added == ip {
+				return s.selector, true
+			}
+		}
+	}
+	if source, ok := d.base.(policy.LabelSource); ok {
+		return source.LabelsOf(ip)
+	}
+	return nil, false
+}
+
+// scaledAway reports whether a Scale removed ip
+func (d *Discovery) scaledAway(ip string) bool {
+	for _, s := range d.scales {
+		if s.removed[ip] {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether a workload with labels is selected by selector
+func matches(labels, selector map[string]string) bool {
+	for key, value := range selector {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Change is a count before and after the scenario
+type Change struct {
+	Before int `json:"before"`
+	After  int `json:"after"`
+}
+
+// Delta returns After - Before
+func (c Change) Delta() int {
+	return c.After - c.Before
+}
+
+// PolicyChange is how the compiled rules of a policy change
+type PolicyChange struct {
+	Policy string `json:"policy"`
+	Rules  Change `json:"rules"`
+	// Error is why the policy fails to compile after the scenario, e.g. a
+	// selector left with no workload
+	Error string `json:"error,omitempty"`
+}
+
+// Limits are the capacities the Report checks counts against
+type Limits struct {
+	MapCapacity            int // Entries the eBPF policy map holds; 0 if unbounded
+	SecurityGroupRuleLimit int // Rules per direction and address family
+}
+
+// Report is what a Scenario changes
+type Report struct {
+	Policies []PolicyChange `json:"policies"` // Policies whose rules change, by name
+	// Rules are the compiled rules of every policy, one per resolved peer
+	// and port and per port of a range, as enforcement backends and the
+	// cluster scheduler count them
+	Rules Change `json:"rules"`
+	// MapEntries are the eBPF policy map entries of every policy, counted as
+	// the enforcer writes them (see enforcer.EBPFMapEntries)
+	MapEntries  Change `json:"map_entries"`
+	MapCapacity int    `json:"map_capacity,omitempty"`
+	// SecurityGroupEgress and SecurityGroupIngress count the rules 'ztap
+	// cloud sync' would keep in one Security Group. AWS limits IPv4 and IPv6
+	// rules separately, so each is the larger of the two.
+	SecurityGroupEgress    Change `json:"security_group_egress"`
+	SecurityGroupIngress   Change `json:"security_group_ingress"`
+	SecurityGroupRuleLimit int    `json:"security_group_rule_limit"`
+}
+
+// Analyze compiles policies against base and against scenario over base,
+// and reports how the rule counts change
+func Analyze(policies []policy.NetworkPolicy, base policy.ServiceDiscovery, scenario Scenario, limits Limits) (*Report, error) {
+	hypothetical, err := NewDiscovery(base, scenario)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{MapCapacity: limits.MapCapacity, SecurityGroupRuleLimit: limits.SecurityGroupRuleLimit}
+	if report.SecurityGroupRuleLimit == 0 {
+		report.SecurityGroupRuleLimit = DefaultSecurityGroupRuleLimit
+	}
+
+	before, after := policy.NewPolicyResolver(base), policy.NewPolicyResolver(hypothetical)
+	var compiledBefore, compiledAfter []*policy.CompiledPolicy
+	for _, p := range policies {
+		change := PolicyChange{Policy: p.Metadata.Name}
+		if compiled, err := before.Compile(p); err == nil {
+			change.Rules.Before = compiled.Entries()
+			compiledBefore = append(compiledBefore, compiled)
+		}
+		if compiled, err := after.Compile(p); err == nil {
+			change.Rules.After = compiled.Entries()
+			compiledAfter = append(compiledAfter, compiled)
+		} else {
+			change.Error = err.Error()
+		}
+		report.Rules.Before += change.Rules.Before
+		report.Rules.After += change.Rules.After
+		if change.Rules.Delta() != 0 {
+			report.Policies = append(report.Policies, change)
+		}
+	}
+	sort.Slice(report.Policies, func(i, j int) bool { return report.Policies[i].Policy < report.Policies[j].Policy })
+	report.MapEntries = Change{enforcer.EBPFMapEntries(compiledBefore), enforcer.EBPFMapEntries(compiledAfter)}
+
+	report.SecurityGroupEgress.Before, report.SecurityGroupIngress.Before = securityGroupRules(policies, base)
+	report.SecurityGroupEgress.After, report.SecurityGroupIngress.After = securityGroupRules(policies, hypothetical)
+	return report, nil
+}
+
+// securityGroupRules counts the Security Group rules policies derive to per
+// direction, the larger of the IPv4 and IPv6 counts
+func securityGroupRules(policies []policy.NetworkPolicy, discovery policy.ServiceDiscovery) (egress, ingress int) {
+	rules, _ := cloud.DeriveRules(policies, discovery)
+	counts := make(map[[2]bool]int)
+	for _, rule := range rules {
+		counts[[2]bool{rule.Ingress, rule.IPv6()}]++
+	}
+	return max(counts[[2]bool{false, false}], counts[[2]bool{false, true}]),
+		max(counts[[2]bool{true, false}], counts[[2]bool{true, true}])
+}
+
+// OverMapCapacity reports whether the eBPF policy map entries after the
+// scenario exceed its capacity
+func (r *Report) OverMapCapacity() bool {
+	return r.MapCapacity > 0 && r.MapEntries.After > r.MapCapacity
+}
+
+// OverSecurityGroupLimit reports whether the Security Group rules after the
+// scenario exceed the limit in either direction
+func (r *Report) OverSecurityGroupLimit() bool {
+	return r.SecurityGroupEgress.After > r.SecurityGroupRuleLimit || r.SecurityGroupIngress.After > r.SecurityGroupRuleLimit
+}
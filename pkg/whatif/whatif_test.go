@@ -0,0 +1,211 @@
+package whatif
+
+import (
+	"strings"
+	"testing"
+
+	"ztap/pkg/discovery"
+	"ztap/pkg/policy"
+)
+
+const testPolicies = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+        - protocol: TCP
+          port: 6432
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-cache
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: cache
+      ports:
+        - protocol: TCP
+          port: 6379
+---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-dns
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        ipBlock:
+          cidr: 10.0.0.2/32
+      ports:
+        - protocol: UDP
+          port: 53
+`
+
+func testDiscovery() *discovery.InMemoryDiscovery {
+	d := discovery.NewInMemoryDiscovery()
+	d.RegisterService("db-1", "10.0.2.1", map[string]string{"app": "db", "zone": "a"})
+	d.RegisterService("db-2", "10.0.2.2", map[string]string{"app": "db", "zone": "b"})
+	d.RegisterService("cache", "10.0.3.1", map[string]string{"app": "cache"})
+	return d
+}
+
+func TestAnalyze(t *testing.T) {
+	policies, err := policy.Parse([]byte(testPolicies))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	scale, err := ParseScale("app=db:40")
+	if err != nil {
+		t.Fatalf("ParseScale failed: %v", err)
+	}
+
+	report, err := Analyze(policies, testDiscovery(), Scenario{Scale: []Scale{scale}, Remove: []string{"10.0.3.1"}},
+		Limits{MapCapacity: 50})
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+
+	// The database grows from 2 to 40 IPs on two ports, and the cache is gone
+	if len(report.Policies) != 2 {
+		t.Fatalf("Expected 2 changed policies, got %+v", report.Policies)
+	}
+	if c := report.Policies[0]; c.Policy != "web-to-cache" || c.Rules != (Change{1, 0}) || !strings.Contains(c.Error, "app:cache") {
+		t.Errorf("Unexpected cache change %+v", c)
+	}
+	if c := report.Policies[1]; c.Policy != "web-to-db" || c.Rules != (Change{4, 80}) || c.Error != "" {
+		t.Errorf("Unexpected database change %+v", c)
+	}
+	if report.Rules != (Change{6, 81}) || !report.OverMapCapacity() {
+		t.Errorf("Expected 81 rules over a capacity of 50, got %+v", report.Rules)
+	}
+	if report.SecurityGroupEgress != (Change{6, 81}) || report.SecurityGroupIngress != (Change{}) ||
+		report.SecurityGroupRuleLimit != DefaultSecurityGroupRuleLimit || !report.OverSecurityGroupLimit() {
+		t.Errorf("Expected 81 egress rules over the default limit, got %+v", report)
+	}
+}
+
+func TestAnalyzePortRange(t *testing.T) {
+	policies, err := policy.Parse([]byte(strings.Replace(testPolicies, "port: 6432", "port: 6432\n          endPort: 6441", 1)))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	scale, _ := ParseScale("app=db:3")
+	report, err := Analyze(policies, testDiscovery(), Scenario{Scale: []Scale{scale}}, Limits{MapCapacity: 30})
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	// Each database IP takes one entry for 5432 and ten for the range
+	if c := report.Policies[0]; c.Policy != "web-to-db" || c.Rules != (Change{22, 33}) {
+		t.Errorf("Expected the range counted per port, got %+v", c)
+	}
+	if !report.OverMapCapacity() {
+		t.Errorf("Expected %d entries over a capacity of 30", report.MapEntries.After)
+	}
+}
+
+func TestAnalyzeMapEntries(t *testing.T) {
+	policies, err := policy.Parse([]byte(testPolicies + `---
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: db-ingress
+spec:
+  podSelector:
+    matchLabels:
+      app: db
+  ingress:
+    - from:
+        ipBlock:
+          cidr: 10.9.0.0/16
+      ports:
+        - protocol: TCP
+          port: 5432
+    - from:
+        ipBlock:
+          cidr: 2001:db8::/32
+      ports:
+        - protocol: TCP
+          port: 5432
+`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	report, err := Analyze(policies, testDiscovery(), Scenario{Remove: []string{"10.0.3.1"}}, Limits{MapCapacity: 7})
+	if err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	// The IPv6 rule takes no entry, but the ingress marker does
+	if report.Rules.Before != 8 || report.MapEntries != (Change{8, 7}) || report.OverMapCapacity() {
+		t.Errorf("Expected 7 of 7 map entries used, got rules %+v, entries %+v", report.Rules, report.MapEntries)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	scale, _ := ParseScale("app=db:1")
+	d, err := NewDiscovery(testDiscovery(), Scenario{Scale: []Scale{scale}})
+	if err != nil {
+		t.Fatalf("NewDiscovery failed: %v", err)
+	}
+	// Scaling in keeps the first IPs
+	if ips, err := d.ResolveLabels(map[string]string{"app": "db"}); err != nil || len(ips) != 1 || ips[0] != "10.0.2.1" {
+		t.Errorf("Expected only 10.0.2.1 left, got %v (%v)", ips, err)
+	}
+	if _, err := d.ResolveLabels(map[string]string{"zone": "b"}); err == nil {
+		t.Error("Expected the scaled-in workload gone from every selector")
+	}
+
+	// Hypothetical workloads carry the selector's labels, and only match
+	// selectors within them
+	scale, _ = ParseScale("app=api,tier=edge:2")
+	d, _ = NewDiscovery(testDiscovery(), Scenario{Scale: []Scale{scale}})
+	ips, err := d.ResolveLabels(map[string]string{"tier": "edge"})
+	if err != nil || len(ips) != 2 || ips[0] != "198.18.0.1" || ips[1] != "198.18.0.2" {
+		t.Errorf("Expected two synthetic IPs, got %v (%v)", ips, err)
+	}
+	if labels, ok := d.LabelsOf("198.18.0.2"); !ok || labels["app"] != "api" {
+		t.Errorf("Expected the labels of the hypothetical workload, got %v", labels)
+	}
+	if _, err := d.ResolveLabels(map[string]string{"tier": "edge", "zone": "a"}); err == nil {
+		t.Error("Expected no hypothetical workload for a wider selector")
+	}
+
+	// Scaling in keeps the lowest addresses, not the first strings
+	base := discovery.NewInMemoryDiscovery()
+	base.RegisterService("db-9", "10.0.2.9", map[string]string{"app": "db"})
+	base.RegisterService("db-10", "10.0.2.10", map[string]string{"app": "db"})
+	scale, _ = ParseScale("app=db:1")
+	d, _ = NewDiscovery(base, Scenario{Scale: []Scale{scale}})
+	if ips, err := d.ResolveLabels(map[string]string{"app": "db"}); err != nil || len(ips) != 1 || ips[0] != "10.0.2.9" {
+		t.Errorf("Expected only 10.0.2.9 left, got %v (%v)", ips, err)
+	}
+
+	for _, s := range []string{"app=db", "app=db:-1", "db:3", ":3"} {
+		if _, err := ParseScale(s); err == nil {
+			t.Errorf("ParseScale(%q): expected an error", s)
+		}
+	}
+	if _, err := NewDiscovery(testDiscovery(), Scenario{Remove: []string{"db-1"}}); err == nil {
+		t.Error("Expected a service name rejected as an IP")
+	}
+}
@@ -0,0 +1,254 @@
+// Package chaos exercises cluster and enforcement reconciliation while
+// faults are injected with pkg/faults.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"ztap/pkg/agent"
+	"ztap/pkg/cluster"
+	"ztap/pkg/discovery"
+	"ztap/pkg/faults"
+	"ztap/pkg/policy"
+)
+
+const chaosPolicy = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: web-to-db
+spec:
+  podSelector:
+    matchLabels:
+      app: web
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: db
+      ports:
+        - protocol: TCP
+          port: 5432
+`
+
+// backend is an EnforceFunc that keeps the last enforced rule count
+type backend struct {
+	mu    sync.Mutex
+	calls int
+	rules []int // Rules enforced per call
+}
+
+func (b *backend) enforce(ctx context.Context, policies []*policy.CompiledPolicy) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls++
+	n := 0
+	for _, p := range policies {
+		n += len(p.Rules)
+	}
+	b.rules = append(b.rules, n)
+	return nil
+}
+
+func (b *backend) last() (calls, rules int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.rules) == 0 {
+		return b.calls, 0
+	}
+	return b.calls, b.rules[len(b.rules)-1]
+}
+
+func newAgent(t *testing.T) (*agent.Agent, *backend, *discovery.InMemoryDiscovery, []policy.NetworkPolicy) {
+	t.Helper()
+	t.Cleanup(faults.Reset)
+
+	policies, err := policy.Parse([]byte(chaosPolicy))
+	if err != nil {
+		t.Fatalf("Failed to parse policy: %v", err)
+	}
+	disc := discovery.NewInMemoryDiscovery()
+	disc.RegisterService("db-1", "10.0.2.1", map[string]string{"app": "db"})
+	disc.RegisterService("db-2", "10.0.2.2", map[string]string{"app": "db"})
+
+	b := &backend{}
+	a := agent.New(policy.NewCompileCache(policy.NewPolicyResolver(disc)), b.enforce, 2)
+	return a, b, disc, policies
+}
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestLeaderFailover drops the leader's heartbeats and expects another node
+// to take over, and the old leader to rejoin as a healthy follower
+func TestLeaderFailover(t *testing.T) {
+	t.Cleanup(faults.Reset)
+
+	election := cluster.NewInMemoryElection(cluster.LeaderElectionConfig{
+		NodeID:            "node-a",
+		HeartbeatInterval: 10 * time.Millisecond,
+		ElectionTimeout:   60 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := election.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer election.Stop()
+
+	if err := election.RegisterNode(&cluster.Node{ID: "node-b", State: cluster.StateHealthy}); err != nil {
+		t.Fatal(err)
+	}
+	nodeB, killNodeB := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-nodeB.Done():
+				return
+			case <-ticker.C:
+				election.Heartbeat("node-b")
+			}
+		}
+	}()
+
+	waitFor(t, time.Second, "node-a to lead", election.IsLeader)
+	first, _ := election.FencingToken()
+
+	if err := faults.Set("cluster.heartbeat:drop=100%"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, time.Second, "node-b to take over", func() bool {
+		leader := election.GetLeader()
+		return leader != nil && leader.ID == "node-b"
+	})
+	if election.IsLeader() {
+		t.Error("Expected node-a to step down")
+	}
+	if node := election.GetNode("node-a"); node.State != cluster.StateUnhealthy {
+		t.Errorf("Expected silent node-a to be unhealthy, got %s", node.State)
+	}
+	if _, err := election.FencingToken(); !errors.Is(err, cluster.ErrNotLeader) {
+		t.Errorf("Expected ErrNotLeader after failover, got %v", err)
+	}
+
+	// Heartbeats resume: node-a rejoins as a follower without flapping back
+	faults.Reset()
+	waitFor(t, time.Second, "node-a to recover", func() bool {
+		return election.GetNode("node-a").State == cluster.StateHealthy
+	})
+	time.Sleep(100 * time.Millisecond)
+	if leader := election.GetLeader(); leader == nil || leader.ID != "node-b" {
+		t.Errorf("Expected node-b to keep leadership, got %v", leader)
+	}
+
+	// node-b dies for good: leadership returns to node-a in a newer epoch
+	killNodeB()
+	waitFor(t, time.Second, "node-a to lead again", election.IsLeader)
+	if token, err := election.FencingToken(); err != nil || token.Epoch <= first.Epoch+1 {
+		t.Errorf("Expected a newer leadership epoch than %d, got %+v, %v", first.Epoch+1, token, err)
+	}
+}
+
+// TestDiscoveryFlap makes discovery fail and lag intermittently; enforcement
+// must never lose the rules it already has
+func TestDiscoveryFlap(t *testing.T) {
+	a, b, disc, policies := newAgent(t)
+	ctx := context.Background()
+
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if calls, rules := b.last(); calls != 1 || rules != 2 {
+		t.Fatalf("Expected 2 rules enforced once, got %d calls, %d rules", calls, rules)
+	}
+
+	if err := faults.Set("discovery.resolve:fail=50%,discovery.resolve:delay=2ms"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		a.Reconcile(ctx, policies)
+	}
+	b.mu.Lock()
+	for i, n := range b.rules {
+		if n != 2 {
+			t.Errorf("Enforcement %d dropped rules during discovery flap: %d", i, n)
+		}
+	}
+	b.mu.Unlock()
+
+	// A real change made during the flap is picked up once discovery recovers
+	disc.RegisterService("db-3", "10.0.2.3", map[string]string{"app": "db"})
+	if err := faults.Set("discovery.resolve:fail=100%"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Reconcile(ctx, policies); err == nil {
+		t.Error("Expected injected discovery failure")
+	}
+	faults.Reset()
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed after recovery: %v", err)
+	}
+	if _, rules := b.last(); rules != 3 {
+		t.Errorf("Expected 3 rules after recovery, got %d", rules)
+	}
+}
+
+// TestPartialEnforcementFailure fails enforcement on some cycles while
+// endpoints churn; the agent must converge on the latest desired state
+func TestPartialEnforcementFailure(t *testing.T) {
+	a, b, disc, policies := newAgent(t)
+	ctx := context.Background()
+
+	if err := faults.Set("enforce.apply:fail=50%"); err != nil {
+		t.Fatal(err)
+	}
+	failures := 0
+	for i := 0; i < 30; i++ {
+		if i%5 == 0 {
+			name := "db-extra"
+			if i%10 == 0 {
+				disc.RegisterService(name, "10.0.2.9", map[string]string{"app": "db"})
+			} else {
+				disc.DeregisterService(name)
+			}
+		}
+		if _, err := a.Reconcile(ctx, policies); errors.Is(err, faults.ErrInjected) {
+			failures++
+		}
+	}
+	if failures == 0 || uint64(failures) != faults.Injected(faults.Enforce) {
+		t.Errorf("Expected injected failures to surface, got %d (injected %d)", failures, faults.Injected(faults.Enforce))
+	}
+
+	// Without faults a single cycle converges
+	faults.Reset()
+	disc.RegisterService("db-extra", "10.0.2.9", map[string]string{"app": "db"})
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if _, rules := b.last(); rules != 3 {
+		t.Errorf("Expected converged state with 3 rules, got %d", rules)
+	}
+	calls, _ := b.last()
+	if _, err := a.Reconcile(ctx, policies); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if again, _ := b.last(); again != calls {
+		t.Errorf("Expected converged state not to be re-enforced, got %d calls after %d", again, calls)
+	}
+}
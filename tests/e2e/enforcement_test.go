@@ -0,0 +1,183 @@
+//go:build linux && e2e
+// +build linux,e2e
+
+package e2e
+
+import (
+	"testing"
+
+	"ztap/pkg/discovery"
+	"ztap/pkg/enforcer"
+	"ztap/pkg/policy"
+)
+
+const echoPolicy = `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: client-to-echo
+spec:
+  podSelector:
+    matchLabels:
+      app: client
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: echo
+      ports:
+        - protocol: TCP
+          port: 8080
+        - protocol: UDP
+          port: 5353
+`
+
+// compileEcho compiles doc with app=echo resolving to the namespace
+func compileEcho(t *testing.T, doc string) []*policy.CompiledPolicy {
+	t.Helper()
+	policies, err := policy.Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("failed to parse policy: %v", err)
+	}
+	disc := discovery.NewInMemoryDiscovery()
+	disc.RegisterService("echo", peerAddr, map[string]string{"app": "echo"})
+
+	resolver := policy.NewPolicyResolver(disc)
+	var compiled []*policy.CompiledPolicy
+	for _, p := range policies {
+		c, err := resolver.Compile(p)
+		if err != nil {
+			t.Fatalf("failed to compile policy %s: %v", p.Metadata.Name, err)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled
+}
+
+type probe struct {
+	protocol string
+	port     int
+	allowed  bool
+}
+
+func expectTraffic(t *testing.T, cgroupPath string, probes []probe) {
+	t.Helper()
+	for _, p := range probes {
+		if got := reachable(t, cgroupPath, p.protocol, p.port); got != p.allowed {
+			t.Errorf("%s/%d: expected allowed=%v, got %v", p.protocol, p.port, p.allowed, got)
+		}
+	}
+}
+
+// TestEnforcementAllowsAndBlocksTraffic attaches the enforcer to a cgroup and
+// checks that only traffic matching the policy leaves it
+func TestEnforcementAllowsAndBlocksTraffic(t *testing.T) {
+	requireRoot(t)
+	compileBPF(t)
+
+	ns := newNetns(t)
+	ns.serve(t, "tcp/8080", "tcp/9090", "udp/5353", "udp/5354")
+	cgroupPath := newCgroup(t)
+
+	// Without enforcement everything is reachable
+	expectTraffic(t, cgroupPath, []probe{
+		{"tcp", 8080, true},
+		{"tcp", 9090, true},
+		{"udp", 5353, true},
+		{"udp", 5354, true},
+	})
+
+	enf, err := enforcer.NewEBPFEnforcer()
+	if err != nil {
+		t.Fatalf("failed to create enforcer: %v", err)
+	}
+	defer enf.Close()
+	if err := enf.LoadPolicies(compileEcho(t, echoPolicy)); err != nil {
+		t.Fatalf("failed to load policies: %v", err)
+	}
+	if err := enf.Attach(cgroupPath); err != nil {
+		t.Fatalf("failed to attach: %v", err)
+	}
+
+	expectTraffic(t, cgroupPath, []probe{
+		{"tcp", 8080, true},
+		{"tcp", 9090, false},
+		{"udp", 5353, true},
+		{"udp", 5354, false},
+	})
+}
+
+// TestEnforcementUpdateTakesEffect checks that UpdatePolicies changes what
+// live traffic is allowed without reattaching
+func TestEnforcementUpdateTakesEffect(t *testing.T) {
+	requireRoot(t)
+	compileBPF(t)
+
+	ns := newNetns(t)
+	ns.serve(t, "tcp/8080", "tcp/9090")
+	cgroupPath := newCgroup(t)
+
+	enf, err := enforcer.NewEBPFEnforcer()
+	if err != nil {
+		t.Fatalf("failed to create enforcer: %v", err)
+	}
+	defer enf.Close()
+	if err := enf.LoadPolicies(compileEcho(t, echoPolicy)); err != nil {
+		t.Fatalf("failed to load policies: %v", err)
+	}
+	if err := enf.Attach(cgroupPath); err != nil {
+		t.Fatalf("failed to attach: %v", err)
+	}
+	expectTraffic(t, cgroupPath, []probe{{"tcp", 8080, true}, {"tcp", 9090, false}})
+
+	moved := `
+apiVersion: ztap/v1
+kind: NetworkPolicy
+metadata:
+  name: client-to-echo
+spec:
+  podSelector:
+    matchLabels:
+      app: client
+  egress:
+    - to:
+        podSelector:
+          matchLabels:
+            app: echo
+      ports:
+        - protocol: TCP
+          port: 9090
+`
+	if err := enf.UpdatePolicies(compileEcho(t, moved)); err != nil {
+		t.Fatalf("failed to update policies: %v", err)
+	}
+	expectTraffic(t, cgroupPath, []probe{{"tcp", 8080, false}, {"tcp", 9090, true}})
+}
+
+// TestEnforcementDetachRestoresTraffic checks that closing the enforcer
+// stops filtering the cgroup
+func TestEnforcementDetachRestoresTraffic(t *testing.T) {
+	requireRoot(t)
+	compileBPF(t)
+
+	ns := newNetns(t)
+	ns.serve(t, "tcp/9090")
+	cgroupPath := newCgroup(t)
+
+	enf, err := enforcer.NewEBPFEnforcer()
+	if err != nil {
+		t.Fatalf("failed to create enforcer: %v", err)
+	}
+	if err := enf.LoadPolicies(compileEcho(t, echoPolicy)); err != nil {
+		t.Fatalf("failed to load policies: %v", err)
+	}
+	if err := enf.Attach(cgroupPath); err != nil {
+		t.Fatalf("failed to attach: %v", err)
+	}
+	expectTraffic(t, cgroupPath, []probe{{"tcp", 9090, false}})
+
+	if err := enf.Close(); err != nil {
+		t.Fatalf("failed to close enforcer: %v", err)
+	}
+	expectTraffic(t, cgroupPath, []probe{{"tcp", 9090, true}})
+}
@@ -0,0 +1,270 @@
+//go:build linux && e2e
+// +build linux,e2e
+
+// Package e2e runs real traffic through the eBPF enforcer. Each test gets a
+// network namespace behind a veth pair, echo servers inside the namespace,
+// and a cgroup the enforcer is attached to; clients dial from inside that
+// cgroup so their egress is filtered. Requires root, iproute2, cgroup v2 and
+// a compiled bpf/filter.o.
+package e2e
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	hostAddr = "10.203.0.1"
+	peerAddr = "10.203.0.2"
+
+	helperEnv   = "ZTAP_E2E_HELPER"
+	dialTimeout = 2 * time.Second
+)
+
+// TestMain turns the test binary into a helper process when helperEnv is
+// set, so servers and clients run as separate processes that can be placed
+// in a namespace or cgroup without affecting the test itself
+func TestMain(m *testing.M) {
+	switch os.Getenv(helperEnv) {
+	case "serve":
+		flag.Parse()
+		serve(flag.Args())
+	case "dial":
+		flag.Parse()
+		os.Exit(dial(flag.Args()))
+	}
+	os.Exit(m.Run())
+}
+
+// netns is a network namespace reachable at peerAddr over a veth pair
+type netns struct {
+	name string
+	veth string
+}
+
+// requireRoot skips the test unless it can create namespaces and cgroups
+func requireRoot(t *testing.T) {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("requires root to create network namespaces and cgroups")
+	}
+	if _, err := exec.LookPath("ip"); err != nil {
+		t.Skip("requires iproute2 (ip)")
+	}
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		t.Skip("requires cgroup v2 mounted at /sys/fs/cgroup")
+	}
+}
+
+// newNetns creates a namespace with hostAddr on the host end of a veth pair
+// and peerAddr on the namespace end
+func newNetns(t *testing.T) *netns {
+	t.Helper()
+	suffix := strconv.Itoa(os.Getpid() % 100000)
+	n := &netns{name: "ztap-e2e-" + suffix, veth: "ztape2e" + suffix}
+	peer := n.veth + "p"
+
+	run(t, "ip", "netns", "add", n.name)
+	t.Cleanup(func() { exec.Command("ip", "netns", "delete", n.name).Run() })
+	run(t, "ip", "link", "add", n.veth, "type", "veth", "peer", "name", peer)
+	t.Cleanup(func() { exec.Command("ip", "link", "delete", n.veth).Run() })
+
+	run(t, "ip", "link", "set", peer, "netns", n.name)
+	run(t, "ip", "addr", "add", hostAddr+"/24", "dev", n.veth)
+	run(t, "ip", "link", "set", n.veth, "up")
+	run(t, "ip", "netns", "exec", n.name, "ip", "addr", "add", peerAddr+"/24", "dev", peer)
+	run(t, "ip", "netns", "exec", n.name, "ip", "link", "set", peer, "up")
+	run(t, "ip", "netns", "exec", n.name, "ip", "link", "set", "lo", "up")
+	return n
+}
+
+// serve starts echo servers inside the namespace, one per "tcp/port" or
+// "udp/port" listener, and waits until they are listening
+func (n *netns) serve(t *testing.T, listeners ...string) {
+	t.Helper()
+	args := append([]string{"netns", "exec", n.name, os.Args[0]}, listeners...)
+	cmd := exec.Command("ip", args...)
+	cmd.Env = append(os.Environ(), helperEnv+"=serve")
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to pipe server output: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start echo servers: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	ready := make(chan error, 1)
+	go func() {
+		line, err := bufio.NewReader(stdout).ReadString('\n')
+		if err == nil && strings.TrimSpace(line) != "ready" {
+			err = fmt.Errorf("unexpected server output %q", line)
+		}
+		ready <- err
+	}()
+	select {
+	case err := <-ready:
+		if err != nil {
+			t.Fatalf("echo servers failed to start: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for echo servers")
+	}
+}
+
+// newCgroup creates a cgroup v2 directory for the test and returns its path
+func newCgroup(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join("/sys/fs/cgroup", fmt.Sprintf("ztap-e2e-%d", time.Now().UnixNano()))
+	if err := os.Mkdir(path, 0o755); err != nil {
+		t.Fatalf("failed to create cgroup %s: %v", path, err)
+	}
+	t.Cleanup(func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			t.Errorf("failed to remove cgroup %s: %v", path, err)
+		}
+	})
+	return path
+}
+
+// reachable reports whether a client started inside cgroupPath gets an echo
+// back from peerAddr over protocol ("tcp" or "udp") and port
+func reachable(t *testing.T, cgroupPath, protocol string, port int) bool {
+	t.Helper()
+	dir, err := os.Open(cgroupPath)
+	if err != nil {
+		t.Fatalf("failed to open cgroup: %v", err)
+	}
+	defer dir.Close()
+
+	cmd := exec.Command(os.Args[0], protocol, net.JoinHostPort(peerAddr, strconv.Itoa(port)))
+	cmd.Env = append(os.Environ(), helperEnv+"=dial")
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(dir.Fd())}
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return true
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		t.Logf("%s/%d unreachable: %s", protocol, port, strings.TrimSpace(string(output)))
+		return false
+	}
+	t.Fatalf("dial helper failed: %v\n%s", err, output)
+	return false
+}
+
+func run(t *testing.T, name string, args ...string) {
+	t.Helper()
+	if output, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		t.Fatalf("%s %s: %v\n%s", name, strings.Join(args, " "), err, output)
+	}
+}
+
+// compileBPF builds bpf/filter.o unless ZTAP_BPF_OBJECT points at one
+func compileBPF(t *testing.T) {
+	t.Helper()
+	if os.Getenv("ZTAP_BPF_OBJECT") != "" {
+		return
+	}
+	_, thisFile, _, ok := runtime.Caller(0)
+	if !ok {
+		t.Fatal("unable to determine caller path")
+	}
+	cmd := exec.Command("make")
+	cmd.Dir = filepath.Join(filepath.Dir(thisFile), "..", "..", "bpf")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build eBPF program: %v\n%s", err, output)
+	}
+}
+
+// serve runs echo servers for listeners until killed
+func serve(listeners []string) {
+	for _, l := range listeners {
+		protocol, port, _ := strings.Cut(l, "/")
+		addr := net.JoinHostPort(peerAddr, port)
+		switch protocol {
+		case "tcp":
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			go func() {
+				for {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						defer conn.Close()
+						io.Copy(conn, conn)
+					}()
+				}
+			}()
+		case "udp":
+			conn, err := net.ListenPacket("udp", addr)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			go func() {
+				buf := make([]byte, 1500)
+				for {
+					n, from, err := conn.ReadFrom(buf)
+					if err != nil {
+						return
+					}
+					conn.WriteTo(buf[:n], from)
+				}
+			}()
+		default:
+			fmt.Fprintf(os.Stderr, "unknown listener %q\n", l)
+			os.Exit(2)
+		}
+	}
+	fmt.Println("ready")
+	select {}
+}
+
+// dial sends a probe to args[1] over args[0] and waits for the echo. It
+// exits 0 when the echo arrives and 1 when traffic is blocked.
+func dial(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: dial tcp|udp host:port")
+		return 2
+	}
+	conn, err := net.DialTimeout(args[0], args[1], dialTimeout)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	probe := []byte("ztap-e2e")
+	if _, err := conn.Write(probe); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	echo := make([]byte, len(probe))
+	if _, err := io.ReadFull(conn, echo); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"ztap/pkg/clock"
 	"ztap/pkg/discovery"
 	"ztap/pkg/policy"
 )
@@ -124,8 +125,9 @@ func TestDiscoveryWithCache(t *testing.T) {
 	backend := discovery.NewInMemoryDiscovery()
 	backend.RegisterService("web-1", "10.0.1.1", map[string]string{"app": "web"})
 
-	// Create cached discovery with short TTL
-	cached := discovery.NewCacheDiscovery(backend, 500*time.Millisecond)
+	// Create cached discovery with short TTL on a fake clock
+	clk := clock.NewFake(time.Now())
+	cached := discovery.NewCacheDiscoveryWithCache(backend, 500*time.Millisecond, discovery.NewMemoryCacheWithClock(clk))
 
 	// First resolution (cache miss)
 	start := time.Now()
@@ -172,8 +174,8 @@ func TestDiscoveryWithCache(t *testing.T) {
 		t.Errorf("Expected cached result with 1 IP, got %d", len(ips3))
 	}
 
-	// Wait for cache expiration
-	time.Sleep(600 * time.Millisecond)
+	// Let the cache expire
+	clk.Advance(600 * time.Millisecond)
 
 	// Now should get updated result
 	ips4, err := cached.ResolveLabels(map[string]string{"app": "web"})